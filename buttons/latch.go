@@ -0,0 +1,59 @@
+// Package buttons turns raw readings of buttons and switches into presses, for
+// the components which read them (e.g. the controller, and the kill switch).
+package buttons
+
+import (
+	"time"
+)
+
+// Latch detects presses: Run returns true only on the tick when the input goes
+// from released to pressed, so holding a button doesn't repeat it. The zero
+// value is ready to use, and starts released.
+//
+// If Debounce is set, the input has to stay the same for that long before it
+// counts as changed, so a contact which bounces (or a dropped reading) doesn't
+// count as more than one press. That needs the time of each reading, so it's
+// only applied by RunAt.
+type Latch struct {
+	Debounce time.Duration
+
+	// Whether the (debounced) input is pressed.
+	val bool
+
+	// The most recent input (before debouncing), and when it changed to that.
+	raw   bool
+	rawAt time.Time
+}
+
+// Run is called every tick with whether the input is pressed, and returns true
+// if it was just pressed. It ignores Debounce.
+func (l *Latch) Run(v bool) bool {
+	r := v && !l.val
+	l.val = v
+	l.raw = v
+	return r
+}
+
+// RunAt is Run, with the time of the reading, and debouncing.
+func (l *Latch) RunAt(now time.Time, v bool) bool {
+	if l.Debounce <= 0 {
+		return l.Run(v)
+	}
+
+	if l.rawAt.IsZero() || v != l.raw {
+		l.raw = v
+		l.rawAt = now
+	}
+
+	if l.raw == l.val || now.Sub(l.rawAt) < l.Debounce {
+		return false
+	}
+
+	l.val = l.raw
+	return l.val
+}
+
+// Pressed returns whether the (debounced) input is pressed, as of the last run.
+func (l *Latch) Pressed() bool {
+	return l.val
+}
@@ -0,0 +1,109 @@
+package buttons
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// trace is a series of readings, one per millisecond, as a string of 0s and 1s
+// (so they line up), and the presses which should be reported, as a string of
+// . (nothing) and ^ (a press) in the same columns.
+type trace struct {
+	name string
+	in   string
+	want string
+}
+
+func TestRun(t *testing.T) {
+	for _, tc := range []trace{
+		{"released", "00000", "....."},
+		{"one press", "00111000", "..^....."},
+		{"held from the start", "1111", "^..."},
+		{"two presses", "0110110", ".^..^.."},
+		{"every reading", "0101", ".^.^"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			l := &Latch{}
+			assert.Equal(t, tc.want, run(tc.in, func(_ time.Time, v bool) bool { return l.Run(v) }))
+		})
+	}
+}
+
+func TestRunAtWithoutDebounce(t *testing.T) {
+
+	// Acts just like Run.
+	for _, in := range []string{"00111000", "1111", "0101", "0110110"} {
+		a, b := &Latch{}, &Latch{}
+		assert.Equal(t,
+			run(in, func(_ time.Time, v bool) bool { return a.Run(v) }),
+			run(in, b.RunAt))
+	}
+}
+
+func TestRunAtWithDebounce(t *testing.T) {
+	for _, tc := range []trace{
+		{"released", "00000", "....."},
+
+		// Presses are late by the debounce, but otherwise unchanged.
+		{"clean press", "0011111000", ".....^...."},
+		{"held from the start", "11111", "...^."},
+		{"two clean presses", "0111100001111", "....^.......^"},
+
+		// Bounces shorter than the debounce are ignored.
+		{"bouncy press", "0010110111111", "..........^.."},
+		{"bouncy release", "0111101001111", "....^........"},
+		{"glitch while released", "0001000000", ".........."},
+		{"glitch while held", "011110111110000", "....^.........."},
+
+		// The input has to still be the same when the debounce is up.
+		{"just long enough", "0011110011", ".....^...."},
+		{"too short", "0011000110", ".........."},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			l := &Latch{Debounce: 3 * time.Millisecond}
+			assert.Equal(t, tc.want, run(tc.in, l.RunAt))
+		})
+	}
+}
+
+func TestPressed(t *testing.T) {
+	l := &Latch{Debounce: 3 * time.Millisecond}
+	now := time.Unix(0, 0)
+	ms := func(n int) time.Time { return now.Add(time.Duration(n) * time.Millisecond) }
+
+	assert.False(t, l.Pressed())
+	l.RunAt(ms(0), true)
+	l.RunAt(ms(2), true)
+	assert.False(t, l.Pressed())
+	l.RunAt(ms(3), true)
+	assert.True(t, l.Pressed())
+
+	// Releasing is debounced too.
+	l.RunAt(ms(4), false)
+	l.RunAt(ms(6), false)
+	assert.True(t, l.Pressed())
+	l.RunAt(ms(7), false)
+	assert.False(t, l.Pressed())
+
+	// Readings don't have to be regular.
+	assert.False(t, l.RunAt(ms(100), true))
+	assert.True(t, l.RunAt(ms(200), true))
+}
+
+// run feeds the readings in a trace to f, a millisecond apart, and returns what
+// it reported in the same format.
+func run(in string, f func(time.Time, bool) bool) string {
+	out := make([]byte, len(in))
+	now := time.Unix(0, 0)
+	for i, c := range in {
+		out[i] = '.'
+		if f(now, c == '1') {
+			out[i] = '^'
+		}
+		now = now.Add(time.Millisecond)
+	}
+
+	return string(out)
+}
@@ -0,0 +1,263 @@
+package hexapod
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// Spec describes a component which can be enabled or disabled by name when the
+// hex starts up, e.g. via flags or the config. See Catalog.
+type Spec struct {
+
+	// The name which the component is enabled or disabled by. This should be
+	// short and lower case, like the package name.
+	Name string
+
+	// A short description, for --list-components.
+	Doc string
+
+	// Whether the component is enabled unless it's overridden.
+	Enabled bool
+
+	// The names of the components (or the things which are provided by the
+	// environment, see Catalog.Provide) which must be enabled too for this one
+	// to work.
+	Requires []string
+
+	// Creates the component, or components. This is only called if it's
+	// enabled, and every spec's requirements are met.
+	New func() ([]Component, error)
+}
+
+// Catalog is an ordered set of components which may be registered with the
+// hex, so they can be chosen at startup rather than compiled in. The order is
+// the order in which they're registered, which matters (see Register).
+type Catalog struct {
+	specs    []Spec
+	provided map[string]provision
+}
+
+// provision is something which the environment may provide. See Provide.
+type provision struct {
+	ok  bool
+	doc string
+}
+
+// NewCatalog returns an empty catalog.
+func NewCatalog() *Catalog {
+	return &Catalog{
+		provided: map[string]provision{},
+	}
+}
+
+// Add appends the given specs to the catalog. It's an error for a name to be
+// used twice.
+func (c *Catalog) Add(specs ...Spec) error {
+	for _, s := range specs {
+		if _, ok := c.spec(s.Name); ok {
+			return fmt.Errorf("component %q is already in the catalog", s.Name)
+		}
+		if _, ok := c.provided[s.Name]; ok {
+			return fmt.Errorf("component %q has the same name as something provided", s.Name)
+		}
+
+		c.specs = append(c.specs, s)
+	}
+
+	return nil
+}
+
+// Provide declares whether the environment provides the given thing (e.g.
+// "bus", the servo network), so that components can require it as if it were
+// another component. The doc describes it, to explain what's missing when it
+// isn't provided.
+func (c *Catalog) Provide(name string, ok bool, doc string) {
+	c.provided[name] = provision{ok, doc}
+}
+
+// Names returns the names of the components in the catalog, sorted.
+func (c *Catalog) Names() []string {
+	names := make([]string, len(c.specs))
+	for i, s := range c.specs {
+		names[i] = s.Name
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+func (c *Catalog) spec(name string) (Spec, bool) {
+	for _, s := range c.specs {
+		if s.Name == name {
+			return s, true
+		}
+	}
+
+	return Spec{}, false
+}
+
+// enabled returns whether each component is enabled, given the overrides. The
+// later overrides win. It's an error to override a name which isn't in the
+// catalog.
+func (c *Catalog) enabled(overrides []map[string]bool) (map[string]bool, error) {
+	en := map[string]bool{}
+	for _, s := range c.specs {
+		en[s.Name] = s.Enabled
+	}
+
+	var unknown []string
+	for _, o := range overrides {
+		for name, v := range o {
+			if _, ok := en[name]; !ok {
+				unknown = append(unknown, name)
+				continue
+			}
+			en[name] = v
+		}
+	}
+
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return nil, fmt.Errorf("unknown components: %s (valid components are: %s)",
+			strings.Join(unknown, ", "), strings.Join(c.Names(), ", "))
+	}
+
+	return en, nil
+}
+
+// Select returns the specs which are enabled, given the overrides (e.g. from
+// the config, then the flags), in catalog order. It's an error to override a
+// name which isn't in the catalog, or for an enabled component to require one
+// which isn't enabled (or provided).
+func (c *Catalog) Select(overrides ...map[string]bool) ([]Spec, error) {
+	en, err := c.enabled(overrides)
+	if err != nil {
+		return nil, err
+	}
+
+	var out []Spec
+	var problems []string
+	for _, s := range c.specs {
+		if !en[s.Name] {
+			continue
+		}
+
+		for _, r := range s.Requires {
+			if p := c.missing(r, en); p != "" {
+				problems = append(problems, fmt.Sprintf("%s requires %s", s.Name, p))
+			}
+		}
+
+		out = append(out, s)
+	}
+
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("invalid components: %s", strings.Join(problems, "; "))
+	}
+
+	return out, nil
+}
+
+// missing describes the given requirement if it isn't met, or returns empty if
+// it is.
+func (c *Catalog) missing(name string, enabled map[string]bool) string {
+	if p, ok := c.provided[name]; ok {
+		if p.ok {
+			return ""
+		}
+		return fmt.Sprintf("%s (%s), which isn't available", name, p.doc)
+	}
+
+	v, ok := enabled[name]
+	if !ok {
+		return fmt.Sprintf("%s, which isn't in the catalog", name)
+	}
+	if !v {
+		return fmt.Sprintf("%s, which is disabled", name)
+	}
+
+	return ""
+}
+
+// Build creates the components which are enabled, given the overrides (see
+// Select), in catalog order, ready to be registered.
+func (c *Catalog) Build(overrides ...map[string]bool) ([]Component, error) {
+	specs, err := c.Select(overrides...)
+	if err != nil {
+		return nil, err
+	}
+
+	var out []Component
+	for _, s := range specs {
+		cs, err := s.New()
+		if err != nil {
+			return nil, fmt.Errorf("error creating %s: %s", s.Name, err)
+		}
+
+		out = append(out, cs...)
+	}
+
+	return out, nil
+}
+
+// List writes a table of the components in the catalog to w, in catalog order,
+// with whether each one is enabled given the overrides (see Select).
+func (c *Catalog) List(w io.Writer, overrides ...map[string]bool) error {
+	en, err := c.enabled(overrides)
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tENABLED\tREQUIRES\tDESCRIPTION")
+	for _, s := range c.specs {
+		enabled := "no"
+		if en[s.Name] {
+			enabled = "yes"
+		}
+
+		req := "-"
+		if len(s.Requires) > 0 {
+			req = strings.Join(s.Requires, ",")
+		}
+
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", s.Name, enabled, req, s.Doc)
+	}
+
+	return tw.Flush()
+}
+
+// ParseOverrides returns the overrides for Catalog.Select given comma-separated
+// lists of the names to enable and disable, e.g. from the --enable and
+// --disable flags. It's an error to both enable and disable the same name.
+func ParseOverrides(enable, disable string) (map[string]bool, error) {
+	out := map[string]bool{}
+
+	for _, name := range splitNames(enable) {
+		out[name] = true
+	}
+
+	for _, name := range splitNames(disable) {
+		if out[name] {
+			return nil, fmt.Errorf("component %s is both enabled and disabled", name)
+		}
+		out[name] = false
+	}
+
+	return out, nil
+}
+
+func splitNames(s string) []string {
+	var out []string
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		if name != "" {
+			out = append(out, name)
+		}
+	}
+
+	return out
+}
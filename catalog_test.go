@@ -0,0 +1,199 @@
+package hexapod
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// namedComponent does nothing, but remembers which spec created it.
+type namedComponent struct {
+	name string
+}
+
+func (c *namedComponent) Boot() error                  { return nil }
+func (c *namedComponent) Tick(time.Time, *State) error { return nil }
+
+func testCatalog(t *testing.T, bus bool) *Catalog {
+	spec := func(name string, enabled bool, requires ...string) Spec {
+		return Spec{
+			Name:     name,
+			Doc:      "the " + name,
+			Enabled:  enabled,
+			Requires: requires,
+			New: func() ([]Component, error) {
+				return []Component{&namedComponent{name}}, nil
+			},
+		}
+	}
+
+	c := NewCatalog()
+	c.Provide("bus", bus, "the servo network")
+	assert.NoError(t, c.Add(
+		spec("legs", true, "bus"),
+		spec("tracker", false, "head"),
+		spec("head", true, "bus", "legs"),
+		spec("telemetry", false),
+		spec("recorder", true),
+	))
+
+	return c
+}
+
+func names(cs []Component) []string {
+	var out []string
+	for _, c := range cs {
+		out = append(out, c.(*namedComponent).name)
+	}
+
+	return out
+}
+
+func TestCatalogBuild(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		overrides []map[string]bool
+		want      []string
+	}{
+		{
+			name: "defaults",
+			want: []string{"legs", "head", "recorder"},
+		},
+		{
+			name:      "enabled by the config",
+			overrides: []map[string]bool{{"telemetry": true}},
+			want:      []string{"legs", "head", "telemetry", "recorder"},
+		},
+		{
+			name:      "disabled by the flags",
+			overrides: []map[string]bool{{}, {"recorder": false}},
+			want:      []string{"legs", "head"},
+		},
+		{
+			name:      "flags win over the config",
+			overrides: []map[string]bool{{"telemetry": true, "recorder": false}, {"telemetry": false}},
+			want:      []string{"legs", "head"},
+		},
+		{
+			// It's registered in catalog order, not the order it was enabled.
+			name:      "order",
+			overrides: []map[string]bool{{"tracker": true}},
+			want:      []string{"legs", "tracker", "head", "recorder"},
+		},
+		{
+			name:      "everything disabled",
+			overrides: []map[string]bool{{"legs": false, "head": false, "recorder": false}},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cs, err := testCatalog(t, true).Build(tc.overrides...)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.want, names(cs))
+		})
+	}
+}
+
+func TestCatalogDependencies(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		bus       bool
+		overrides map[string]bool
+		err       string
+	}{
+		{
+			name:      "requires a disabled component",
+			bus:       true,
+			overrides: map[string]bool{"legs": false},
+			err:       "invalid components: head requires legs, which is disabled",
+		},
+		{
+			name:      "requires a component which is disabled by default",
+			bus:       true,
+			overrides: map[string]bool{"tracker": true, "head": false},
+			err:       "invalid components: tracker requires head, which is disabled",
+		},
+		{
+			name: "requires something which isn't provided",
+			bus:  false,
+			err:  "invalid components: legs requires bus (the servo network), which isn't available; head requires bus (the servo network), which isn't available",
+		},
+		{
+			name:      "unmet requirements of disabled components don't matter",
+			bus:       false,
+			overrides: map[string]bool{"legs": false, "head": false},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := testCatalog(t, tc.bus).Build(tc.overrides)
+			if tc.err == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, tc.err)
+			}
+		})
+	}
+}
+
+func TestCatalogUnknown(t *testing.T) {
+	c := testCatalog(t, true)
+
+	_, err := c.Build(map[string]bool{"hed": false}, map[string]bool{"leds": true})
+	assert.EqualError(t, err, "unknown components: hed, leds (valid components are: head, legs, recorder, telemetry, tracker)")
+
+	// Listing is an error too, so typos aren't missed.
+	assert.Error(t, c.List(&bytes.Buffer{}, map[string]bool{"hed": false}))
+}
+
+func TestCatalogRequirementNotInCatalog(t *testing.T) {
+	c := NewCatalog()
+	assert.NoError(t, c.Add(Spec{Name: "head", Enabled: true, Requires: []string{"neck"}}))
+
+	_, err := c.Select()
+	assert.EqualError(t, err, "invalid components: head requires neck, which isn't in the catalog")
+}
+
+func TestCatalogDuplicates(t *testing.T) {
+	c := testCatalog(t, true)
+	assert.Error(t, c.Add(Spec{Name: "legs"}))
+	assert.Error(t, c.Add(Spec{Name: "bus"}))
+}
+
+func TestCatalogNewError(t *testing.T) {
+	c := testCatalog(t, true)
+	assert.NoError(t, c.Add(Spec{
+		Name:    "buzzer",
+		Enabled: true,
+		New:     func() ([]Component, error) { return nil, errors.New("no such device") },
+	}))
+
+	_, err := c.Build()
+	assert.EqualError(t, err, "error creating buzzer: no such device")
+}
+
+func TestCatalogList(t *testing.T) {
+	b := &bytes.Buffer{}
+	assert.NoError(t, testCatalog(t, true).List(b, map[string]bool{"telemetry": true, "recorder": false}))
+	assert.Equal(t, ""+
+		"NAME       ENABLED  REQUIRES  DESCRIPTION\n"+
+		"legs       yes      bus       the legs\n"+
+		"tracker    no       head      the tracker\n"+
+		"head       yes      bus,legs  the head\n"+
+		"telemetry  yes      -         the telemetry\n"+
+		"recorder   no       -         the recorder\n", b.String())
+}
+
+func TestParseOverrides(t *testing.T) {
+	o, err := ParseOverrides("telemetry, tracker", "recorder,")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]bool{"telemetry": true, "tracker": true, "recorder": false}, o)
+
+	o, err = ParseOverrides("", "")
+	assert.NoError(t, err)
+	assert.Empty(t, o)
+
+	_, err = ParseOverrides("head", "legs,head")
+	assert.EqualError(t, err, "component head is both enabled and disabled")
+}
@@ -0,0 +1,126 @@
+// Command hexapod-diff replays the controller input from a session recorded on
+// the hardware through the simulator, and reports how far the simulation
+// diverged from what the hex actually did: the error in its pose, and in the
+// angle of each joint. It's a regression test for the gaits and IK, and for the
+// simulator itself. Record the session with --record-servos (and ideally
+// legs.feedback set, so the joints' present positions are compared, rather
+// than only their goals), dump the flight recorder, and then:
+//
+//	go run ./cmd/hexapod-diff /tmp/flight-20170601-120000.000.rec
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/adammck/hexapod"
+	"github.com/adammck/hexapod/components/recorder"
+	"github.com/adammck/hexapod/components/replay"
+	"github.com/adammck/hexapod/config"
+	"github.com/adammck/hexapod/servos"
+)
+
+var (
+	fps        = flag.Int("fps", hexapod.DefaultFPS, "number of frames per second to simulate at (the same as the session was recorded at)")
+	configPath = flag.String("config", "", "path to the config file which the session was recorded with (empty to use the defaults)")
+	servosPath = flag.String("servos", "", "path to the servo capture (defaults to the dump's path, with .servos rather than .rec)")
+	jsonFlag   = flag.Bool("json", false, "write the report as JSON, rather than text")
+	logLevels  = flag.String("log-levels", "warn", "comma-separated log levels, e.g. warn,legs=debug")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] dump.rec\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	err := hexapod.SetLogLevels(*logLevels)
+	if err != nil {
+		log.Fatalf("error setting log levels: %s", err)
+	}
+
+	cfg := config.Default()
+	if *configPath != "" {
+		cfg, err = config.Load(*configPath)
+		if err != nil {
+			log.Fatalf("error loading config: %s", err)
+		}
+	}
+
+	path := flag.Arg(0)
+	if *servosPath == "" {
+		*servosPath = strings.TrimSuffix(path, ".rec") + ".servos"
+	}
+
+	want, err := load(path, *servosPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	got, err := replay.Simulate(cfg, want.Records, *fps)
+	if err != nil {
+		log.Fatalf("error simulating: %s", err)
+	}
+
+	r, err := replay.Compare(want, got)
+	if err != nil {
+		log.Fatalf("error comparing: %s", err)
+	}
+
+	if *jsonFlag {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(r)
+	} else {
+		err = r.WriteText(os.Stdout)
+	}
+
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// load reads the flight recorder dump and the servo capture at the given paths.
+// The capture is optional, since the pose can be compared without it.
+func load(recPath, servosPath string) (replay.Session, error) {
+	var s replay.Session
+
+	f, err := os.Open(recPath)
+	if err != nil {
+		return s, err
+	}
+	defer f.Close()
+
+	s.Records, err = recorder.Decode(bufio.NewReader(f))
+	if err != nil {
+		return s, fmt.Errorf("%s (while decoding %s)", err, recPath)
+	}
+
+	g, err := os.Open(servosPath)
+	if os.IsNotExist(err) {
+		log.Warnf("no servo capture at %s; only comparing the pose", servosPath)
+		return s, nil
+	}
+	if err != nil {
+		return s, err
+	}
+	defer g.Close()
+
+	s.Servos, err = servos.DecodeCapture(bufio.NewReader(g))
+	if err != nil {
+		return s, fmt.Errorf("%s (while decoding %s)", err, servosPath)
+	}
+
+	return s, nil
+}
@@ -0,0 +1,174 @@
+// Command hexapod-sim runs the hexapod without any hardware: the servos are
+// simulated (see sim.Bus), and the controller is driven from the keyboard via
+// the dashboard, or by replaying a flight recorder dump. Everything else (the
+// loop, gaits, IK, etc) is the real thing. Run it, and open the dashboard in a
+// browser:
+//
+//	go run ./cmd/hexapod-sim --demo
+//	open http://localhost:8080
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	_ "embed"
+	"flag"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/adammck/dynamixel/network"
+	"github.com/adammck/hexapod"
+	"github.com/adammck/hexapod/components/api"
+	"github.com/adammck/hexapod/components/controller"
+	"github.com/adammck/hexapod/components/dashboard"
+	"github.com/adammck/hexapod/components/head"
+	"github.com/adammck/hexapod/components/legs"
+	"github.com/adammck/hexapod/components/navigator"
+	"github.com/adammck/hexapod/components/power"
+	"github.com/adammck/hexapod/components/recorder"
+	"github.com/adammck/hexapod/components/replay"
+	"github.com/adammck/hexapod/components/sim"
+	"github.com/adammck/hexapod/components/telemetry"
+	"github.com/adammck/hexapod/components/voltage"
+	"github.com/adammck/hexapod/config"
+	"github.com/adammck/hexapod/math3d"
+	"github.com/adammck/hexapod/servos"
+	"github.com/adammck/sixaxis"
+)
+
+// demo is a flight recorder dump of a short drive around, recorded from this
+// simulator: walking forwards, turning, strafing, changing gait and speed, and
+// looking around. Only the records where the input changed are kept, since
+// that's all a replay needs.
+//
+//go:embed demo.rec
+var demo []byte
+
+var (
+	fps           = flag.Int("fps", hexapod.DefaultFPS, "set the number of frames per second")
+	logLevels     = flag.String("log-levels", os.Getenv("HEXAPOD_LOG"), "comma-separated log levels, e.g. warn,legs=debug (defaults to $HEXAPOD_LOG)")
+	configPath    = flag.String("config", "", "path to the config file (empty to use the defaults)")
+	httpPort      = flag.Int("http-port", 8000, "port to start the HTTP API on (zero to disable)")
+	telemetryPort = flag.Int("telemetry-port", 8001, "port to stream telemetry to the dashboard on")
+	telemetryRate = flag.Int("telemetry-rate", 30, "number of telemetry snapshots to send per second")
+	dashboardPort = flag.Int("dashboard-port", 8080, "port to serve the dashboard on")
+	recorderDir   = flag.String("recorder-dir", os.TempDir(), "directory to write flight recorder dumps to")
+	demoFlag      = flag.Bool("demo", false, "play the built-in demo (over and over) until a key is pressed on the dashboard")
+	replayPath    = flag.String("replay", "", "replay the controller input from the given flight recorder dump")
+)
+
+func main() {
+	flag.Parse()
+
+	err := hexapod.SetLogLevels(*logLevels)
+	if err != nil {
+		log.Fatalf("error setting log levels: %s", err)
+	}
+
+	cfg := config.Default()
+	if *configPath != "" {
+		cfg, err = config.Load(*configPath)
+		if err != nil {
+			log.Fatalf("error loading config: %s", err)
+		}
+	}
+
+	var records []recorder.Record
+	switch {
+	case *demoFlag && *replayPath != "":
+		log.Fatal("--demo and --replay can't be used together")
+
+	case *demoFlag:
+		records, err = recorder.Decode(bytes.NewReader(demo))
+		if err != nil {
+			log.Fatalf("error decoding demo: %s", err)
+		}
+
+	case *replayPath != "":
+		records, err = decodeDump(*replayPath)
+		if err != nil {
+			log.Fatalf("error decoding flight recorder dump: %s", err)
+		}
+	}
+
+	bus := sim.NewBus()
+	network := network.New(bus)
+
+	h := hexapod.New(network, cfg)
+	h.TargetFPS = *fps
+
+	l := legs.New(network, cfg.Legs, cfg.Gait)
+	s := sim.New(bus, l)
+	s.Faults = cfg.Sim
+	h.Register(l, s)
+
+	// The replay and the keyboard both drive the same sixaxis, which the
+	// controller reads, so they come before it. The keyboard comes second, so
+	// it takes over whenever a key is held.
+	sa := sixaxis.New(nil)
+	if records != nil {
+		rp := replay.New(sa, records)
+		rp.Loop = *demoFlag
+		h.Register(rp)
+	}
+
+	dash := dashboard.New(*dashboardPort, *telemetryPort)
+	dash.Keyboard = sa
+	h.Register(dash)
+
+	nav := navigator.New(cfg.Navigator)
+	h.Register(controller.NewScripted(sa, cfg.Controller, cfg.Head), nav)
+
+	// The simulated servos report their voltage and load, like the real ones.
+	var ps []power.Servo
+	for _, s := range l.Servos() {
+		ps = append(ps, s)
+	}
+	h.Register(voltage.New(l.Legs[0].Coxa, cfg.Voltage, cfg.Safety), power.New(ps, cfg.Power))
+
+	headH, err := servos.New(network, 71)
+	if err != nil {
+		log.Fatalf("error while initializing servo #71: %s", err)
+	}
+	headV, err := servos.New(network, 72)
+	if err != nil {
+		log.Fatalf("error while initializing servo #72: %s", err)
+	}
+	mount := math3d.Pose{Position: math3d.Vector3{X: 0, Y: 43.0, Z: 70}}
+	h.Register(head.New(mount, headH, headV, cfg.Head))
+
+	if *httpPort > 0 {
+		a := api.New(*httpPort, h, cfg.Telemetry.Units())
+		a.Navigator = nav
+		h.Register(a)
+	}
+
+	h.Register(telemetry.New(*telemetryPort, *telemetryRate, cfg.Telemetry.Units(), nil))
+
+	// Like main, the flight recorder goes last. Its dumps (from select +
+	// square, or shutting down) can be replayed with --replay.
+	h.Register(recorder.New(*recorderDir, 30*time.Second, *fps))
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	err = h.Run(ctx)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// decodeDump reads the flight recorder dump at the given path.
+func decodeDump(path string) ([]recorder.Record, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return recorder.Decode(bufio.NewReader(f))
+}
@@ -0,0 +1,547 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/adammck/hexapod"
+	"github.com/adammck/hexapod/components/head"
+	"github.com/adammck/hexapod/components/navigator"
+	"github.com/adammck/hexapod/components/odometer"
+	"github.com/adammck/hexapod/components/power"
+	"github.com/adammck/hexapod/components/session"
+	"github.com/adammck/hexapod/config"
+	"github.com/adammck/hexapod/params"
+	"github.com/adammck/hexapod/units"
+)
+
+var log = logrus.WithFields(logrus.Fields{
+	"pkg": "api",
+})
+
+// API is a component which serves a little HTTP API for inspecting and tweaking
+// the hexapod while it's running:
+//
+//	GET  /state       the current state, as a hexapod.Snapshot
+//	GET  /components  the health of each component
+//	GET  /events      the most recent events, oldest first
+//	GET  /params      the current value of every tunable param
+//	POST /params      set params, from a JSON object of name => value
+//	POST /estop       halt (but don't shut down)
+//	DELETE /estop     resume after a halt
+//	GET  /stiffness   the stiffness preset of the legs
+//	POST /stiffness   switch presets, from a JSON object like {"preset": "soft"}
+//	GET  /gait        where the legs are in the step cycle, with gait.debug set
+//	POST /gait        pause or step the gait, from a JSON object like
+//	                  {"action": "step-phase"}
+//	GET  /waypoints   the navigator's queue, and its progress
+//	POST /waypoints   queue waypoints, from a JSON array of navigator.Waypoint
+//	DELETE /waypoints clear the queue, and stop
+//	GET  /session     the totals for the session so far, as a session.Summary
+//	POST /session     write the session summary to the log and a file
+//	GET  /power       the power calibration readings, and the fitted params
+//	POST /power       calibrate, from a JSON object like {"current": 1.2}
+//	DELETE /power     forget the calibration readings
+//	GET  /odometer    the lifetime counters, as an odometer.Counters
+//	POST /look        sweep the head through a path, from a JSON array of
+//	                  head.LookPoint like {"at": [0,100,600], "duration": "4s"}
+//	DELETE /look      stop sweeping
+//
+// Handlers run in their own goroutines, so never touch the state directly.
+// Instead, Tick copies what they need into the cache (under the lock), and
+// applies any requested changes.
+type API struct {
+	sync.Mutex
+	port   int
+	hex    *hexapod.Hexapod
+	params *params.Registry
+	mux    *http.ServeMux
+
+	// The navigator to queue waypoints with, or nil if there isn't one, in
+	// which case /waypoints isn't found.
+	Navigator *navigator.Navigator
+
+	// The session to summarize, or nil if there isn't one, in which case
+	// /session isn't found.
+	Session *session.Session
+
+	// The power estimator to calibrate, or nil if there isn't one, in which
+	// case /power isn't found.
+	Power *power.Power
+
+	// The odometer to read the counters of, or nil if there isn't one, in
+	// which case /odometer isn't found.
+	Odometer *odometer.Odometer
+
+	// The head to play look paths on, or nil if there isn't one, in which
+	// case /look isn't found.
+	Head *head.Head
+
+	// The units which the snapshots are converted to.
+	units units.System
+
+	// Copied from the main loop every tick.
+	snapshot  hexapod.Snapshot
+	health    []hexapod.ComponentHealth
+	gaitDebug hexapod.GaitDebug
+
+	// Set by the handlers, applied during the next Tick. Nil means no change.
+	halt *bool
+
+	// Set by the handlers, applied during the next Tick. Empty means no change.
+	stiffness string
+
+	// Set by the handlers, applied during the next Tick. None means no change.
+	gaitStep hexapod.GaitStepRequest
+}
+
+// New creates an API component which will serve on the given port, reporting
+// the health of the components of the given hexapod, and adjusting its params.
+// The state is served in the given units.
+func New(port int, h *hexapod.Hexapod, u units.System) *API {
+	a := &API{
+		port:   port,
+		hex:    h,
+		params: h.Params,
+		units:  u,
+		mux:    http.NewServeMux(),
+	}
+
+	a.mux.HandleFunc("/state", a.handleState)
+	a.mux.HandleFunc("/components", a.handleComponents)
+	a.mux.HandleFunc("/events", a.handleEvents)
+	a.mux.HandleFunc("/params", a.handleParams)
+	a.mux.HandleFunc("/estop", a.handleEstop)
+	a.mux.HandleFunc("/stiffness", a.handleStiffness)
+	a.mux.HandleFunc("/gait", a.handleGait)
+	a.mux.HandleFunc("/waypoints", a.handleWaypoints)
+	a.mux.HandleFunc("/session", a.handleSession)
+	a.mux.HandleFunc("/power", a.handlePower)
+	a.mux.HandleFunc("/odometer", a.handleOdometer)
+	a.mux.HandleFunc("/look", a.handleLook)
+
+	return a
+}
+
+// Writes returns hexapod.Commander, since the API can halt the hex.
+func (a *API) Writes() hexapod.Role {
+	return hexapod.Commander
+}
+
+// Source implements hexapod.Source.
+func (a *API) Source() string {
+	return "api"
+}
+
+// Boot starts the HTTP server in the background.
+func (a *API) Boot() error {
+	addr := fmt.Sprintf(":%d", a.port)
+	log.Infof("listening on %s", addr)
+
+	go func() {
+		err := http.ListenAndServe(addr, a)
+		log.Errorf("server stopped: %s", err)
+	}()
+
+	return nil
+}
+
+// Tick refreshes the cached state, and applies any pending halt, stiffness, or
+// gait step request. Param writes are applied by the core loop itself, not here.
+func (a *API) Tick(now time.Time, state *hexapod.State) error {
+	a.Lock()
+	defer a.Unlock()
+
+	if a.halt != nil {
+		if *a.halt != state.Halt {
+			log.Warnf("halt=%v (via API)", *a.halt)
+		}
+		state.Halt = *a.halt
+		a.halt = nil
+	}
+
+	if a.stiffness != "" {
+		log.Infof("stiffness=%s (via API)", a.stiffness)
+		state.SetStiffness = a.stiffness
+		a.stiffness = ""
+	}
+
+	if a.gaitStep != hexapod.GaitStepNone {
+		log.Infof("gait %s (via API)", a.gaitStep)
+		state.GaitStep = a.gaitStep
+		a.gaitStep = hexapod.GaitStepNone
+	}
+
+	a.snapshot = state.Snapshot(now).In(a.units)
+	a.health = a.hex.Health()
+	a.gaitDebug = state.GaitDebug
+	return nil
+}
+
+func (a *API) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a.mux.ServeHTTP(w, r)
+}
+
+func (a *API) handleState(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		httpError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	a.Lock()
+	s := a.snapshot
+	a.Unlock()
+
+	writeJSON(w, http.StatusOK, s)
+}
+
+func (a *API) handleComponents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		httpError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	a.Lock()
+	h := a.health
+	a.Unlock()
+
+	writeJSON(w, http.StatusOK, h)
+}
+
+// handleEvents doesn't need the cache, since the core keeps the history under
+// its own lock.
+func (a *API) handleEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		httpError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, a.hex.RecentEvents())
+}
+
+func (a *API) handleParams(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		writeJSON(w, http.StatusOK, a.params.Values())
+
+	case "POST":
+		vals := map[string]float64{}
+		err := json.NewDecoder(r.Body).Decode(&vals)
+		if err != nil {
+			httpError(w, http.StatusBadRequest, fmt.Sprintf("invalid JSON: %s", err))
+			return
+		}
+
+		err = a.params.Set(vals)
+		if err != nil {
+			httpError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		for n, v := range vals {
+			log.Infof("setting %s=%v (via API)", n, v)
+		}
+
+		// The writes are applied at the start of the next tick.
+		writeJSON(w, http.StatusAccepted, vals)
+
+	default:
+		httpError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func (a *API) handleEstop(w http.ResponseWriter, r *http.Request) {
+	var halt bool
+
+	switch r.Method {
+	case "POST":
+		halt = true
+	case "DELETE":
+		halt = false
+	default:
+		httpError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	a.Lock()
+	a.halt = &halt
+	a.Unlock()
+
+	writeJSON(w, http.StatusAccepted, map[string]bool{"halt": halt})
+}
+
+// stiffness is the request to POST /stiffness, and the response to both.
+type stiffness struct {
+	Preset string `json:"preset"`
+}
+
+// handleStiffness only queues the preset. The legs defer switching to it until
+// every foot is down, so the response to GET might lag behind for a step.
+func (a *API) handleStiffness(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		a.Lock()
+		s := stiffness{Preset: a.snapshot.Stiffness}
+		a.Unlock()
+		writeJSON(w, http.StatusOK, s)
+
+	case "POST":
+		var s stiffness
+		err := json.NewDecoder(r.Body).Decode(&s)
+		if err != nil {
+			httpError(w, http.StatusBadRequest, fmt.Sprintf("invalid JSON: %s", err))
+			return
+		}
+
+		if !config.IsStiffnessPreset(s.Preset) {
+			httpError(w, http.StatusBadRequest, fmt.Sprintf("no such stiffness preset: %s", s.Preset))
+			return
+		}
+
+		a.Lock()
+		a.stiffness = s.Preset
+		a.Unlock()
+		writeJSON(w, http.StatusAccepted, s)
+
+	default:
+		httpError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// gaitStep is the request to POST /gait. The action is the name of a
+// hexapod.GaitStepRequest, as typed into the console.
+type gaitStep struct {
+	Action string `json:"action"`
+}
+
+// gaitStatus is the response to GET /gait. See hexapod.GaitDebug.
+type gaitStatus struct {
+	Paused bool `json:"paused"`
+	Tick   int  `json:"tick"`
+	Length int  `json:"length"`
+	Phase  int  `json:"phase"`
+	Cycles int  `json:"cycles"`
+}
+
+// handleGait isn't found unless the legs say that stepping is enabled. Like the
+// stiffness, it only queues the request, so GET lags behind by a tick.
+func (a *API) handleGait(w http.ResponseWriter, r *http.Request) {
+	a.Lock()
+	d := a.gaitDebug
+	a.Unlock()
+
+	if !d.Enabled {
+		httpError(w, http.StatusNotFound, "gait debugging is disabled")
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		writeJSON(w, http.StatusOK, gaitStatus{d.Paused, d.Tick, d.Length, d.Phase, d.Cycles})
+
+	case "POST":
+		var s gaitStep
+		err := json.NewDecoder(r.Body).Decode(&s)
+		if err != nil {
+			httpError(w, http.StatusBadRequest, fmt.Sprintf("invalid JSON: %s", err))
+			return
+		}
+
+		req, ok := hexapod.ParseGaitStep(s.Action)
+		if !ok {
+			httpError(w, http.StatusBadRequest, fmt.Sprintf("no such gait action: %s", s.Action))
+			return
+		}
+
+		a.Lock()
+		a.gaitStep = req
+		a.Unlock()
+		writeJSON(w, http.StatusAccepted, s)
+
+	default:
+		httpError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// waypoints is the response to GET /waypoints.
+type waypoints struct {
+	Navigation hexapod.Navigation   `json:"navigation"`
+	Queue      []navigator.Waypoint `json:"queue"`
+}
+
+// handleWaypoints doesn't need the cache either, since the navigator has its
+// own lock, and fetches new waypoints itself.
+func (a *API) handleWaypoints(w http.ResponseWriter, r *http.Request) {
+	if a.Navigator == nil {
+		httpError(w, http.StatusNotFound, "no navigator")
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		nav, q := a.Navigator.Status()
+		writeJSON(w, http.StatusOK, waypoints{nav, q})
+
+	case "POST":
+		var ws []navigator.Waypoint
+		err := json.NewDecoder(r.Body).Decode(&ws)
+		if err != nil {
+			httpError(w, http.StatusBadRequest, fmt.Sprintf("invalid JSON: %s", err))
+			return
+		}
+
+		log.Infof("queueing %d waypoints (via API)", len(ws))
+		a.Navigator.Add(ws...)
+		writeJSON(w, http.StatusAccepted, ws)
+
+	case "DELETE":
+		log.Info("clearing waypoints (via API)")
+		a.Navigator.Clear()
+		w.WriteHeader(http.StatusAccepted)
+
+	default:
+		httpError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// handleSession doesn't need the cache either, since the session has its own
+// lock.
+func (a *API) handleSession(w http.ResponseWriter, r *http.Request) {
+	if a.Session == nil {
+		httpError(w, http.StatusNotFound, "no session")
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		writeJSON(w, http.StatusOK, a.Session.Summary())
+
+	case "POST":
+		path, err := a.Session.Emit("requested via API")
+		if err != nil {
+			httpError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		writeJSON(w, http.StatusOK, map[string]string{"path": path})
+
+	default:
+		httpError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// calibration is the request to POST /power. The current is in amps, as
+// measured between the battery and the hex at the time of the request.
+type calibration struct {
+	Current float64 `json:"current"`
+}
+
+// handlePower doesn't need the cache either, since the power estimator has its
+// own lock, and the fitted params are applied by the core loop.
+func (a *API) handlePower(w http.ResponseWriter, r *http.Request) {
+	if a.Power == nil {
+		httpError(w, http.StatusNotFound, "no power estimator")
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		writeJSON(w, http.StatusOK, a.Power.Calibration())
+
+	case "POST":
+		var req calibration
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			httpError(w, http.StatusBadRequest, fmt.Sprintf("invalid JSON: %s", err))
+			return
+		}
+
+		c, err := a.Power.Calibrate(req.Current)
+		if err != nil {
+			httpError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		log.Infof("calibrating power with %.3fA (via API)", req.Current)
+		writeJSON(w, http.StatusAccepted, c)
+
+	case "DELETE":
+		log.Info("resetting power calibration (via API)")
+		a.Power.ResetCalibration()
+		w.WriteHeader(http.StatusAccepted)
+
+	default:
+		httpError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// handleOdometer doesn't need the cache either, since the odometer has its own
+// lock. The counters are reset via the console, not here, since that should be
+// done by hand, after replacing something.
+func (a *API) handleOdometer(w http.ResponseWriter, r *http.Request) {
+	if a.Odometer == nil {
+		httpError(w, http.StatusNotFound, "no odometer")
+		return
+	}
+
+	if r.Method != "GET" {
+		httpError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, a.Odometer.Counters())
+}
+
+// handleLook doesn't need the cache either, since the head has its own lock. The
+// path is rejected if any of its points are out of range (see head.Head.Look),
+// with the reason, rather than being played as far as it can be.
+func (a *API) handleLook(w http.ResponseWriter, r *http.Request) {
+	if a.Head == nil {
+		httpError(w, http.StatusNotFound, "no head")
+		return
+	}
+
+	switch r.Method {
+	case "POST":
+		var p head.LookPath
+		err := json.NewDecoder(r.Body).Decode(&p)
+		if err != nil {
+			httpError(w, http.StatusBadRequest, fmt.Sprintf("invalid JSON: %s", err))
+			return
+		}
+
+		err = a.Head.Look(p)
+		if err != nil {
+			httpError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		log.Infof("looking through %d points (via API)", len(p))
+		writeJSON(w, http.StatusAccepted, p)
+
+	case "DELETE":
+		log.Info("stopping look path (via API)")
+		a.Head.StopLook()
+		w.WriteHeader(http.StatusAccepted)
+
+	default:
+		httpError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		log.Warnf("%s (while writing response)", err)
+	}
+}
+
+func httpError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
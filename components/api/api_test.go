@@ -0,0 +1,417 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/adammck/dynamixel/network"
+	"github.com/adammck/hexapod"
+	"github.com/adammck/hexapod/components/head"
+	"github.com/adammck/hexapod/components/navigator"
+	"github.com/adammck/hexapod/components/odometer"
+	"github.com/adammck/hexapod/components/power"
+	"github.com/adammck/hexapod/components/session"
+	"github.com/adammck/hexapod/config"
+	fake_serial "github.com/adammck/hexapod/fake/serial"
+	"github.com/adammck/hexapod/math3d"
+	"github.com/adammck/hexapod/params"
+	"github.com/adammck/hexapod/units"
+	"github.com/stretchr/testify/assert"
+)
+
+type nopComponent struct{}
+
+func (c *nopComponent) Boot() error                                    { return nil }
+func (c *nopComponent) Tick(now time.Time, state *hexapod.State) error { return nil }
+
+type publishingComponent struct{}
+
+func (c *publishingComponent) Boot() error { return nil }
+func (c *publishingComponent) Tick(now time.Time, state *hexapod.State) error {
+	state.Publish("test", hexapod.Warning, nil)
+	return nil
+}
+
+// setup returns a hexapod with a single nop component, an API attached to it,
+// and a float param named "test.speed" (between 0 and 10) to poke at.
+func setup(t *testing.T) (*hexapod.Hexapod, *API, *float64) {
+	h := hexapod.NewHexapod(network.New(&fake_serial.FakeSerial{}), 60)
+	h.Params = params.New()
+
+	speed := 5.0
+	assert.NoError(t, h.Params.Register(params.Param{
+		Name: "test.speed",
+		Type: params.Float,
+		Min:  0,
+		Max:  10,
+		Get:  func() float64 { return speed },
+		Set:  func(v float64) { speed = v },
+	}))
+
+	a := New(0, h, units.Internal)
+	h.Add(&nopComponent{})
+	h.Add(a)
+	return h, a, &speed
+}
+
+func do(a *API, method, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+	rec := httptest.NewRecorder()
+	a.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestGetState(t *testing.T) {
+	h, a, _ := setup(t)
+	h.State.Speed = 3
+	h.State.Target.Position.Y = 40
+	assert.NoError(t, h.Tick(time.Now()))
+
+	rec := do(a, "GET", "/state", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var s hexapod.Snapshot
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &s))
+	assert.Equal(t, hexapod.SnapshotVersion, s.Version)
+	assert.Equal(t, 3, s.Speed)
+	assert.Equal(t, 40.0, s.Clearance)
+
+	rec = do(a, "POST", "/state", "")
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+}
+
+func TestGetComponents(t *testing.T) {
+	h, a, _ := setup(t)
+	assert.NoError(t, h.Tick(time.Now()))
+
+	rec := do(a, "GET", "/components", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var health []hexapod.ComponentHealth
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &health))
+	assert.Equal(t, []hexapod.ComponentHealth{
+		{Name: "*api.nopComponent"},
+		{Name: "*api.API"},
+	}, health)
+}
+
+func TestParams(t *testing.T) {
+	h, a, speed := setup(t)
+
+	rec := do(a, "GET", "/params", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var vals []params.Value
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &vals))
+	assert.Equal(t, []params.Value{{Name: "test.speed", Type: params.Float, Min: 0, Max: 10, Step: 0.1, Value: 5}}, vals)
+
+	rec = do(a, "POST", "/params", `{"test.speed": 7.5}`)
+	assert.Equal(t, http.StatusAccepted, rec.Code)
+
+	// Not applied until the next tick.
+	assert.Equal(t, 5.0, *speed)
+	assert.NoError(t, h.Tick(time.Now()))
+	assert.Equal(t, 7.5, *speed)
+
+	rec = do(a, "GET", "/params", "")
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &vals))
+	assert.Equal(t, 7.5, vals[0].Value)
+}
+
+func TestParamsRejectsInvalid(t *testing.T) {
+	h, a, speed := setup(t)
+
+	for _, body := range []string{
+		`{"test.speed": 11}`,
+		`{"test.speed": -1}`,
+		`{"test.nope": 1}`,
+		`{"test.speed": "fast"}`,
+		`not json`,
+	} {
+		rec := do(a, "POST", "/params", body)
+		assert.Equal(t, http.StatusBadRequest, rec.Code, body)
+
+		var e map[string]string
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &e))
+		assert.NotEmpty(t, e["error"])
+	}
+
+	assert.NoError(t, h.Tick(time.Now()))
+	assert.Equal(t, 5.0, *speed)
+}
+
+func TestEstop(t *testing.T) {
+	h, a, _ := setup(t)
+
+	rec := do(a, "POST", "/estop", "")
+	assert.Equal(t, http.StatusAccepted, rec.Code)
+	assert.False(t, h.State.Halt)
+
+	assert.NoError(t, h.Tick(time.Now()))
+	assert.True(t, h.State.Halt)
+	assert.False(t, h.State.Shutdown)
+
+	rec = do(a, "DELETE", "/estop", "")
+	assert.Equal(t, http.StatusAccepted, rec.Code)
+	assert.NoError(t, h.Tick(time.Now()))
+	assert.False(t, h.State.Halt)
+
+	rec = do(a, "GET", "/estop", "")
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+}
+
+func TestStiffness(t *testing.T) {
+	h, a, _ := setup(t)
+	h.State.Stiffness = "normal"
+	assert.NoError(t, h.Tick(time.Now()))
+
+	rec := do(a, "GET", "/stiffness", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"preset": "normal"}`, rec.Body.String())
+
+	rec = do(a, "POST", "/stiffness", `{"preset": "soft"}`)
+	assert.Equal(t, http.StatusAccepted, rec.Code)
+	assert.Empty(t, h.State.SetStiffness)
+
+	// The API only asks; it's up to the legs (which aren't here) when to switch.
+	assert.NoError(t, h.Tick(time.Now()))
+	assert.Equal(t, "soft", h.State.SetStiffness)
+
+	rec = do(a, "POST", "/stiffness", `{"preset": "squishy"}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), "no such stiffness preset: squishy")
+
+	rec = do(a, "POST", "/stiffness", `nope`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = do(a, "DELETE", "/stiffness", "")
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+}
+
+func TestGait(t *testing.T) {
+	h, a, _ := setup(t)
+
+	// Not found until the legs (which aren't here) say that it's enabled.
+	assert.NoError(t, h.Tick(time.Now()))
+	rec := do(a, "GET", "/gait", "")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	rec = do(a, "POST", "/gait", `{"action": "pause"}`)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	h.State.GaitDebug = hexapod.GaitDebug{Enabled: true, Paused: true, Tick: 30, Length: 180, Phase: 1, Cycles: 2}
+	assert.NoError(t, h.Tick(time.Now()))
+	rec = do(a, "GET", "/gait", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"paused": true, "tick": 30, "length": 180, "phase": 1, "cycles": 2}`, rec.Body.String())
+
+	rec = do(a, "POST", "/gait", `{"action": "step-cycle"}`)
+	assert.Equal(t, http.StatusAccepted, rec.Code)
+	assert.Equal(t, hexapod.GaitStepNone, h.State.GaitStep)
+	assert.NoError(t, h.Tick(time.Now()))
+	assert.Equal(t, hexapod.GaitStepCycle, h.State.GaitStep)
+
+	rec = do(a, "POST", "/gait", `{"action": "jump"}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), "no such gait action: jump")
+
+	rec = do(a, "POST", "/gait", `{"action": "none"}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = do(a, "DELETE", "/gait", "")
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+}
+
+func TestGetEvents(t *testing.T) {
+	h, a, _ := setup(t)
+	h.Add(&publishingComponent{})
+	assert.NoError(t, h.Tick(time.Now()))
+
+	rec := do(a, "GET", "/events", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var events []map[string]interface{}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &events))
+	if assert.Len(t, events, 1) {
+		assert.Equal(t, "test", events[0]["name"])
+		assert.Equal(t, "warning", events[0]["severity"])
+		assert.Equal(t, "*api.publishingComponent", events[0]["source"])
+	}
+
+	rec = do(a, "POST", "/events", "")
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+}
+
+func TestWaypoints(t *testing.T) {
+	h, a, _ := setup(t)
+
+	// Not found until there's a navigator.
+	rec := do(a, "GET", "/waypoints", "")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	a.Navigator = navigator.New(config.Default().Navigator)
+	h.Add(a.Navigator)
+
+	rec = do(a, "POST", "/waypoints", `[{"forward": 500}, {"turn": -90}]`)
+	assert.Equal(t, http.StatusAccepted, rec.Code)
+	assert.NoError(t, h.Tick(time.Now()))
+
+	rec = do(a, "GET", "/waypoints", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var got waypoints
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &got))
+	assert.Equal(t, []navigator.Waypoint{{Forward: 500}, {Turn: -90}}, got.Queue)
+	assert.True(t, got.Navigation.Active)
+	assert.Equal(t, 2, got.Navigation.Remaining)
+	assert.InDelta(t, 500, got.Navigation.Distance, 0.001)
+
+	rec = do(a, "DELETE", "/waypoints", "")
+	assert.Equal(t, http.StatusAccepted, rec.Code)
+	assert.NoError(t, h.Tick(time.Now()))
+	assert.False(t, h.State.Navigation.Active)
+
+	rec = do(a, "POST", "/waypoints", `{"forward": 500}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestSession(t *testing.T) {
+	h, a, _ := setup(t)
+
+	// Not found until there's a session.
+	rec := do(a, "GET", "/session", "")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	dir := t.TempDir()
+	a.Session = session.New(dir, h.LoopStats, config.Default().Safety)
+	h.Add(a.Session)
+
+	h.State.Halt = true
+	assert.NoError(t, h.Tick(time.Now()))
+
+	rec = do(a, "GET", "/session", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var got session.Summary
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &got))
+	assert.Equal(t, 1, got.Estops)
+
+	rec = do(a, "POST", "/session", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var resp map[string]string
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	assert.FileExists(t, resp["path"])
+	assert.Equal(t, dir, filepath.Dir(resp["path"]))
+
+	rec = do(a, "DELETE", "/session", "")
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+}
+
+type idleServo struct{}
+
+func (s *idleServo) ModelNumber() (int, error) { return 12, nil }
+func (s *idleServo) PresentLoad() (int, error) { return 0, nil }
+
+func TestPower(t *testing.T) {
+	h, a, _ := setup(t)
+
+	// Not found until there's a power estimator.
+	rec := do(a, "GET", "/power", "")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	a.Power = power.New([]power.Servo{&idleServo{}}, config.Default().Power)
+	a.Power.Params = h.Params
+	h.Add(a.Power)
+	assert.NoError(t, h.Boot())
+	assert.NoError(t, h.Tick(time.Now()))
+
+	// The default model is 0.05A at idle, plus 0.4A for everything else. So
+	// this is a gain of two.
+	rec = do(a, "POST", "/power", `{"current": 0.5}`)
+	assert.Equal(t, http.StatusAccepted, rec.Code)
+
+	var got power.Calibration
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &got))
+	assert.Len(t, got.Points, 1)
+	assert.InDelta(t, 2, got.Gain, 0.0001)
+
+	assert.NoError(t, h.Tick(time.Now()))
+	assert.InDelta(t, 0.5, h.State.Power.Current, 0.0001)
+
+	rec = do(a, "POST", "/power", `{"current": -1}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = do(a, "DELETE", "/power", "")
+	assert.Equal(t, http.StatusAccepted, rec.Code)
+
+	rec = do(a, "GET", "/power", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &got))
+	assert.Len(t, got.Points, 0)
+	assert.InDelta(t, 2, got.Gain, 0.0001)
+}
+
+func TestOdometer(t *testing.T) {
+	h, a, _ := setup(t)
+
+	// Not found until there's an odometer.
+	rec := do(a, "GET", "/odometer", "")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	a.Odometer = odometer.New(filepath.Join(t.TempDir(), "odometer.json"), h.LoopStats)
+	h.Add(a.Odometer)
+	assert.NoError(t, h.Boot())
+
+	h.State.Pose.Position.Z = 100
+	assert.NoError(t, h.Tick(time.Now()))
+	h.State.Pose.Position.Z = 350
+	assert.NoError(t, h.Tick(time.Now()))
+
+	rec = do(a, "GET", "/odometer", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var got odometer.Counters
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &got))
+	assert.Equal(t, 250.0, got.Distance)
+
+	rec = do(a, "DELETE", "/odometer", "")
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+}
+
+func TestLook(t *testing.T) {
+	_, a, _ := setup(t)
+
+	// Not found until there's a head. It isn't added to the hex, since it has
+	// no servos to tick.
+	rec := do(a, "POST", "/look", `[{"at": [0, 100, 600], "duration": "1s"}]`)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	a.Head = head.New(math3d.Pose{Position: math3d.Vector3{Y: 43, Z: 70}}, nil, nil, config.Default().Head)
+
+	rec = do(a, "POST", "/look", `[{"at": [-300, 100, 600], "duration": "1s"}, {"at": [300, 100, 600], "duration": "4s", "easing": "linear"}]`)
+	assert.Equal(t, http.StatusAccepted, rec.Code)
+
+	var got head.LookPath
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &got))
+	assert.Len(t, got, 2)
+	assert.Equal(t, 4*time.Second, got[1].Duration.Duration)
+
+	// The whole path is rejected if any point is out of range.
+	rec = do(a, "POST", "/look", `[{"at": [0, 100, 600], "duration": "1s"}, {"at": [0, 0, -500], "duration": "1s"}]`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), "point 1")
+
+	rec = do(a, "POST", "/look", `{"at": [0, 100, 600]}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	rec = do(a, "DELETE", "/look", "")
+	assert.Equal(t, http.StatusAccepted, rec.Code)
+
+	rec = do(a, "GET", "/look", "")
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+}
@@ -0,0 +1,862 @@
+// Package builtin is the catalog of the built-in components, which the main
+// binary (or anything else embedding the hex) chooses from at startup, by name.
+// See hexapod.Catalog.
+package builtin
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/adammck/dynamixel/network"
+	"github.com/adammck/hexapod"
+	"github.com/adammck/hexapod/components/api"
+	"github.com/adammck/hexapod/components/buzzer"
+	"github.com/adammck/hexapod/components/calibration"
+	"github.com/adammck/hexapod/components/console"
+	"github.com/adammck/hexapod/components/controller"
+	"github.com/adammck/hexapod/components/derate"
+	"github.com/adammck/hexapod/components/discovery"
+	"github.com/adammck/hexapod/components/endurance"
+	"github.com/adammck/hexapod/components/follow"
+	"github.com/adammck/hexapod/components/head"
+	"github.com/adammck/hexapod/components/joints"
+	"github.com/adammck/hexapod/components/killswitch"
+	"github.com/adammck/hexapod/components/leds"
+	"github.com/adammck/hexapod/components/legs"
+	"github.com/adammck/hexapod/components/mqtt"
+	"github.com/adammck/hexapod/components/navigator"
+	"github.com/adammck/hexapod/components/odometer"
+	"github.com/adammck/hexapod/components/power"
+	"github.com/adammck/hexapod/components/profiles"
+	"github.com/adammck/hexapod/components/rangefinder"
+	"github.com/adammck/hexapod/components/recorder"
+	"github.com/adammck/hexapod/components/reload"
+	"github.com/adammck/hexapod/components/righting"
+	"github.com/adammck/hexapod/components/rosbridge"
+	"github.com/adammck/hexapod/components/safemode"
+	"github.com/adammck/hexapod/components/selftest"
+	"github.com/adammck/hexapod/components/session"
+	"github.com/adammck/hexapod/components/settings"
+	"github.com/adammck/hexapod/components/sim"
+	"github.com/adammck/hexapod/components/startup"
+	"github.com/adammck/hexapod/components/statelog"
+	"github.com/adammck/hexapod/components/sysmon"
+	"github.com/adammck/hexapod/components/telemetry"
+	"github.com/adammck/hexapod/components/tracker"
+	"github.com/adammck/hexapod/components/transport"
+	"github.com/adammck/hexapod/components/voltage"
+	"github.com/adammck/hexapod/components/watchdog"
+	"github.com/adammck/hexapod/config"
+	fake_voltage "github.com/adammck/hexapod/fake/voltage"
+	"github.com/adammck/hexapod/math3d"
+	"github.com/adammck/hexapod/servos"
+)
+
+var log = hexapod.NewLog("builtin")
+
+// mount returns the pose of the head (and the camera on it) relative to the hex.
+func mount(cfg config.Head) math3d.Pose {
+	return math3d.Pose{Position: math3d.Vector3{X: cfg.Mount[0], Y: cfg.Mount[1], Z: cfg.Mount[2]}}
+}
+
+// Options are what the built-in components need besides the config, which the
+// main binary sets from its flags. Whether each component is enabled by default
+// depends on these, e.g. the telemetry is only enabled if TelemetryPort is set,
+// so the defaults are the same as they were before components could be chosen.
+type Options struct {
+
+	// The servo network, and the port which it writes to (for the watchdog).
+	// If the network is nil, nothing which needs the bus can be enabled.
+	Network *network.Network
+	Port    *servos.Port
+
+	// The simulated servos, if the network is on them rather than a serial
+	// port. The simulator is only enabled if this is set.
+	Bus *sim.Bus
+
+	// Whether to use fake devices, rather than the controller and the battery.
+	Offline bool
+
+	// The IMU, for the self-test, the righting and the startup check. Only the
+	// simulated one (see sim.Bus.IMU) is provided by main, so otherwise it's
+	// only set by programs which embed the hex, and the righting and startup
+	// check can't be enabled without.
+	IMU righting.IMU
+
+	FPS             int
+	ControllerPort  string
+	CalibrationPath string
+	SelfTestAtBoot  bool
+	RangefinderIIO  string
+	BuzzerPWM       string
+	RecorderDir     string
+	HTTPPort        int
+	TelemetryPort   int
+	TelemetryRate   int
+	TrackerPort     int
+
+	// The unix socket which the console listens on, or empty to read from
+	// stdin instead.
+	ConsoleSocket string
+
+	MQTTBroker   string
+	MQTTPrefix   string
+	MQTTInterval time.Duration
+
+	RosbridgeURL    string
+	RosbridgePrefix string
+	RosbridgeCmdVel string
+	RosbridgeRate   int
+
+	DiscoveryPort     int
+	DiscoveryInterval time.Duration
+
+	SettingsPath string
+	OdometerPath string
+	ConfigPath   string
+	ConfigWatch  time.Duration
+
+	StateLogDir    string
+	StateLogFormat string
+	StateLogFields string
+	StateLogSize   int64
+}
+
+// Builtin creates the built-in components. Some of them are wired to each
+// other (e.g. the API to the navigator) as they're created, so only to the
+// ones which are enabled.
+type Builtin struct {
+	h    *hexapod.Hexapod
+	cfg  config.Config
+	opts Options
+
+	// The components which others are wired to, or nil until they're created
+	// (or if they're disabled).
+	legs     *legs.Legs
+	selfTest *selftest.SelfTest
+	nav      *navigator.Navigator
+	head     *head.Head
+	power    *power.Power
+	session  *session.Session
+	odometer *odometer.Odometer
+	reloader *reload.Reloader
+
+	// The decimator which the telemetry and MQTT share, so the state is only
+	// sampled once per tick for both. Created by the first of them.
+	decimator *telemetry.Decimator
+}
+
+// New returns the built-in components for the given hex.
+func New(h *hexapod.Hexapod, cfg config.Config, opts Options) *Builtin {
+	return &Builtin{
+		h:    h,
+		cfg:  cfg,
+		opts: opts,
+	}
+}
+
+// Reloader returns the config reloader, or nil if it isn't enabled (or hasn't
+// been created yet).
+func (b *Builtin) Reloader() *reload.Reloader {
+	return b.reloader
+}
+
+// decimate returns the shared decimator, creating it if necessary.
+func (b *Builtin) decimate() *telemetry.Decimator {
+	if b.decimator == nil {
+		b.decimator = telemetry.NewDecimator()
+	}
+
+	return b.decimator
+}
+
+// Catalog returns the catalog of the built-in components, in the order in
+// which they must be registered.
+func (b *Builtin) Catalog() *hexapod.Catalog {
+	o := b.opts
+	c := hexapod.NewCatalog()
+	c.Provide("bus", o.Network != nil, "the servo network")
+	c.Provide("simbus", o.Bus != nil, "the simulated servos, see --sim")
+	c.Provide("imu", o.IMU != nil, "the IMU, see Options.IMU")
+
+	// The voltage is read from the servos, unless it's faked, or read from an
+	// ADC.
+	var voltageRequires []string
+	if !o.Offline && b.cfg.Voltage.Source != "adc" {
+		voltageRequires = []string{"legs"}
+	}
+
+	err := c.Add(
+
+		// This must come before the legs, so the offsets are applied before they
+		// set their initial goals.
+		hexapod.Spec{
+			Name:     "calibration",
+			Doc:      "the servo calibration wizard (select + circle)",
+			Enabled:  true,
+			Requires: []string{"legs"},
+			New:      b.newCalibration,
+		},
+
+		// This must come before the legs too, which leave the servos alone while
+		// it's moving them. What it checks besides the legs is set as they're
+		// created, below.
+		hexapod.Spec{
+			Name:     "selftest",
+			Doc:      "the self-test (select + R1, or --selftest)",
+			Enabled:  true,
+			Requires: []string{"legs"},
+			New:      b.newSelfTest,
+		},
+
+		// This must come before the legs too, which leave the servos alone while
+		// the hex is on its back.
+		hexapod.Spec{
+			Name:     "righting",
+			Doc:      "rolls the hex back over once it's fallen onto its back (select + PS)",
+			Enabled:  b.cfg.Righting.Enabled,
+			Requires: []string{"legs", "imu"},
+			New:      b.newRighting,
+		},
+
+		// This must come before the legs too, which don't stand up until it
+		// says that the hex is on its feet.
+		hexapod.Spec{
+			Name:     "startup",
+			Doc:      "keeps the hex from standing up unless it's on its feet (double tap select + PS to overrule)",
+			Enabled:  b.cfg.Startup.Enabled,
+			Requires: []string{"legs", "imu"},
+			New:      b.newStartup,
+		},
+
+		// This must come before the legs too, which leave the servos alone while
+		// the legs are folded, and after the startup check, which it waits for
+		// before unfolding them.
+		hexapod.Spec{
+			Name:     "transport",
+			Doc:      "folds the legs up for carrying (hold select + down), until woken (hold circle + square)",
+			Enabled:  true,
+			Requires: []string{"legs"},
+			New:      b.newTransport,
+		},
+		hexapod.Spec{
+			Name:     "legs",
+			Doc:      "the legs, which walk",
+			Enabled:  true,
+			Requires: []string{"bus"},
+			New:      b.newLegs,
+		},
+		hexapod.Spec{
+			Name:     "sim",
+			Doc:      "the simulator, which moves the simulated servos",
+			Enabled:  o.Bus != nil,
+			Requires: []string{"simbus", "legs"},
+			New:      b.newSim,
+		},
+
+		// This must come before the controller, so the target is held on the
+		// same tick as the switch is opened.
+		hexapod.Spec{
+			Name:    "killswitch",
+			Doc:     "the kill switch (killswitch.pin)",
+			Enabled: b.cfg.KillSwitch.Pin >= 0,
+			New:     b.newKillSwitch,
+		},
+
+		// This must come before the controller, which ducks under whatever it
+		// sees.
+		hexapod.Spec{
+			Name:    "rangefinder",
+			Doc:     "the forward rangefinder (--rangefinder-iio)",
+			Enabled: o.RangefinderIIO != "",
+			New:     b.newRangefinder,
+		},
+
+		// This must come before the controller and the navigator, which scale
+		// their moves by it.
+		hexapod.Spec{
+			Name:    "derate",
+			Doc:     "limits the speed as the battery runs down and the servos heat up",
+			Enabled: true,
+			New:     b.newDerate,
+		},
+		hexapod.Spec{
+			Name:    "controller",
+			Doc:     "the sixaxis controller (--controller-port)",
+			Enabled: true,
+			New:     b.newController,
+		},
+
+		// This must come after the controller, which takes over from it whenever
+		// the sticks are used. The ROS bridge comes after both, for the same
+		// reason.
+		hexapod.Spec{
+			Name:    "navigator",
+			Doc:     "the navigator, which walks to waypoints",
+			Enabled: true,
+			New:     b.newNavigator,
+		},
+
+		// This must come after the navigator, which it gives way to.
+		hexapod.Spec{
+			Name:    "follow",
+			Doc:     "follows another hex, or broadcasts the pose to be followed (follow.leader, follow.broadcast)",
+			Enabled: b.cfg.Follow.Broadcast || b.cfg.Follow.Leader != "",
+			New:     b.newFollow,
+		},
+		hexapod.Spec{
+			Name:     "voltage",
+			Doc:      "the battery voltage check",
+			Enabled:  true,
+			Requires: voltageRequires,
+			New:      b.newVoltage,
+		},
+		hexapod.Spec{
+			Name:     "power",
+			Doc:      "the estimate of the current drawn by the servos",
+			Enabled:  true,
+			Requires: []string{"legs"},
+			New:      b.newPower,
+		},
+
+		// This must come after the controller, which takes over from it whenever
+		// the right stick is used, and before the head.
+		hexapod.Spec{
+			Name:     "tracker",
+			Doc:      "points the head at detections from a vision process (--tracker-port)",
+			Enabled:  o.TrackerPort > 0,
+			Requires: []string{"head"},
+			New:      b.newTracker,
+		},
+
+		// The head is aimed from the pose which the legs update.
+		hexapod.Spec{
+			Name:     "head",
+			Doc:      "the head, which aims the camera",
+			Enabled:  true,
+			Requires: []string{"bus", "legs"},
+			New:      b.newHead,
+		},
+		hexapod.Spec{
+			Name:    "leds",
+			Doc:     "the LED strip (leds.count)",
+			Enabled: b.cfg.LEDs.Count > 0,
+			New:     b.newLEDs,
+		},
+		hexapod.Spec{
+			Name:    "buzzer",
+			Doc:     "the buzzer (--buzzer-pwm)",
+			Enabled: o.BuzzerPWM != "",
+			New:     b.newBuzzer,
+		},
+
+		// This must come before the session, which includes the counters in its
+		// summary.
+		hexapod.Spec{
+			Name:    "odometer",
+			Doc:     "the lifetime counters, for maintenance (--odometer-path)",
+			Enabled: o.OdometerPath != "",
+			New:     b.newOdometer,
+		},
+
+		// This must come before the flight recorder, so it sees dump requests
+		// before they're cleared.
+		hexapod.Spec{
+			Name:    "session",
+			Doc:     "the session summary, written at shutdown",
+			Enabled: true,
+			New:     b.newSession,
+		},
+		hexapod.Spec{
+			Name:    "api",
+			Doc:     "the HTTP API (--http-port)",
+			Enabled: o.HTTPPort > 0,
+			New:     b.newAPI,
+		},
+		hexapod.Spec{
+			Name:    "console",
+			Doc:     "a text console on stdin, or --console-socket",
+			Enabled: false,
+			New:     b.newConsole,
+		},
+		hexapod.Spec{
+			Name:    "telemetry",
+			Doc:     "the telemetry stream (--telemetry-port)",
+			Enabled: o.TelemetryPort > 0,
+			New:     b.newTelemetry,
+		},
+		hexapod.Spec{
+			Name:    "mqtt",
+			Doc:     "publishes the state to MQTT (--mqtt-broker)",
+			Enabled: o.MQTTBroker != "",
+			New:     b.newMQTT,
+		},
+
+		// This must come after the controller (and the navigator), since it only
+		// sets the target if the controller isn't being used.
+		hexapod.Spec{
+			Name:    "rosbridge",
+			Doc:     "the bridge to ROS (--rosbridge-url)",
+			Enabled: o.RosbridgeURL != "",
+			New:     b.newRosbridge,
+		},
+
+		// This must come after everything which sets the target, so it can hold
+		// it while resting.
+		hexapod.Spec{
+			Name:    "endurance",
+			Doc:     "rests the hex while its servos heat up (endurance.enabled)",
+			Enabled: true,
+			New:     b.newEndurance,
+		},
+
+		// This must come after everything which sets the target too, so that
+		// nothing can get around the restrictions of safe mode.
+		hexapod.Spec{
+			Name:    "safemode",
+			Doc:     "restricts the hex while in safe mode (--safe-mode)",
+			Enabled: b.cfg.SafeMode,
+			New:     b.newSafeMode,
+		},
+		hexapod.Spec{
+			Name:    "discovery",
+			Doc:     "broadcasts discovery beacons (--discovery-interval)",
+			Enabled: o.DiscoveryInterval > 0,
+			New:     b.newDiscovery,
+		},
+
+		// This and the settings must come after every component whose params
+		// they write, since they register them during Boot.
+		hexapod.Spec{
+			Name:    "profiles",
+			Doc:     "the param profiles (select + down)",
+			Enabled: true,
+			New:     b.newProfiles,
+		},
+		hexapod.Spec{
+			Name:    "settings",
+			Doc:     "persists runtime settings (--settings-path)",
+			Enabled: o.SettingsPath != "",
+			New:     b.newSettings,
+		},
+		hexapod.Spec{
+			Name:    "reload",
+			Doc:     "reloads the config file on SIGHUP (--config, --config-watch)",
+			Enabled: o.ConfigPath != "",
+			New:     b.newReload,
+		},
+		hexapod.Spec{
+			Name:    "statelog",
+			Doc:     "logs the state every tick (--state-log-dir)",
+			Enabled: o.StateLogDir != "",
+			New:     b.newStateLog,
+		},
+		hexapod.Spec{
+			Name:    "sysmon",
+			Doc:     "monitors the health of the computer, and sheds load",
+			Enabled: true,
+			New:     b.newSysmon,
+		},
+
+		// This is armed at boot, so comes after anything which takes a while to
+		// boot. It's disarmed at shutdown before the flight recorder dumps.
+		hexapod.Spec{
+			Name:     "watchdog",
+			Doc:      "powers the servos off if the loop stalls (watchdog.ticks)",
+			Enabled:  b.cfg.Watchdog.Ticks > 0,
+			Requires: []string{"bus"},
+			New:      b.newWatchdog,
+		},
+
+		// The flight recorder goes last, so it sees the state after every other
+		// component has had a chance to update it.
+		hexapod.Spec{
+			Name:    "recorder",
+			Doc:     "the flight recorder (select + square)",
+			Enabled: true,
+			New:     b.newRecorder,
+		},
+	)
+
+	// The names are all different, so this can't happen.
+	if err != nil {
+		panic(err)
+	}
+
+	return c
+}
+
+// one wraps a single component as the result of a Spec's New.
+func one(c hexapod.Component) ([]hexapod.Component, error) {
+	return []hexapod.Component{c}, nil
+}
+
+// getLegs returns the legs, creating them if they haven't been yet, since the
+// components before them need them too.
+func (b *Builtin) getLegs() *legs.Legs {
+	if b.legs == nil {
+		b.legs = legs.New(b.opts.Network, b.cfg.Legs, b.cfg.Gait)
+	}
+
+	return b.legs
+}
+
+func (b *Builtin) newCalibration() ([]hexapod.Component, error) {
+	return one(calibration.New(b.opts.CalibrationPath, calibration.FromLegs(b.getLegs().Legs)))
+}
+
+func (b *Builtin) newSelfTest() ([]hexapod.Component, error) {
+	b.selfTest = selftest.New(joints.FromLegs(b.getLegs().Legs), b.cfg.SelfTest, b.cfg.Safety)
+	b.selfTest.AtBoot = b.opts.SelfTestAtBoot
+	if b.opts.IMU != nil {
+		b.selfTest.IMU = b.opts.IMU
+	}
+
+	return one(b.selfTest)
+}
+
+func (b *Builtin) newRighting() ([]hexapod.Component, error) {
+	return one(righting.New(joints.FromLegs(b.getLegs().Legs), b.opts.IMU, b.cfg.Righting))
+}
+
+func (b *Builtin) newStartup() ([]hexapod.Component, error) {
+	return one(startup.New(startup.FromLegs(b.getLegs().Legs), b.opts.IMU, b.cfg.Startup))
+}
+
+func (b *Builtin) newTransport() ([]hexapod.Component, error) {
+	return one(transport.New(joints.FromLegs(b.getLegs().Legs), b.cfg.Transport))
+}
+
+func (b *Builtin) newLegs() ([]hexapod.Component, error) {
+	return one(b.getLegs())
+}
+
+func (b *Builtin) newSim() ([]hexapod.Component, error) {
+	s := sim.New(b.opts.Bus, b.getLegs())
+	s.Faults = b.cfg.Sim
+	s.Params = b.h.Params
+	return one(s)
+}
+
+func (b *Builtin) newKillSwitch() ([]hexapod.Component, error) {
+	if b.cfg.KillSwitch.Pin < 0 {
+		return nil, errors.New("no pin is configured (see killswitch.pin)")
+	}
+
+	pin, err := killswitch.NewSysfs("/sys/class/gpio", b.cfg.KillSwitch.Pin)
+	if err != nil {
+		return nil, err
+	}
+
+	return one(killswitch.New(pin, b.cfg.KillSwitch))
+}
+
+func (b *Builtin) newRangefinder() ([]hexapod.Component, error) {
+	if b.opts.RangefinderIIO == "" {
+		return nil, errors.New("no device is configured (see --rangefinder-iio)")
+	}
+
+	sensor, err := rangefinder.NewIIO(b.opts.RangefinderIIO)
+	if err != nil {
+		return nil, err
+	}
+
+	return one(rangefinder.New(sensor, b.cfg.Rangefinder))
+}
+
+func (b *Builtin) newController() ([]hexapod.Component, error) {
+	var f io.Reader
+	if b.opts.Offline {
+		log.Warn("using fake controller")
+		f, _ = os.Open("/dev/null")
+
+	} else {
+		log.Info("opening controller")
+		var err error
+		f, err = os.Open(b.opts.ControllerPort)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	ctrl := controller.New(f, b.cfg.Controller, b.cfg.Head)
+	if b.selfTest != nil && !b.opts.Offline {
+		b.selfTest.Link = ctrl.LastInput
+	}
+
+	return one(ctrl)
+}
+
+func (b *Builtin) newNavigator() ([]hexapod.Component, error) {
+	b.nav = navigator.New(b.cfg.Navigator)
+	return one(b.nav)
+}
+
+func (b *Builtin) newFollow() ([]hexapod.Component, error) {
+	f := b.cfg.Follow
+	if !f.Broadcast && f.Leader == "" {
+		return nil, errors.New("nothing to follow or broadcast (see follow.leader and follow.broadcast)")
+	}
+
+	if f.Leader != "" && f.Leader == b.h.State.Identity.ID {
+		return nil, fmt.Errorf("can't follow %s, since that's this hex", f.Leader)
+	}
+
+	var cs []hexapod.Component
+	if f.Broadcast {
+		cs = append(cs, follow.NewBroadcaster(f.Port, f.Interval.Duration))
+	}
+	if f.Leader != "" {
+		cs = append(cs, follow.NewFollower(f))
+	}
+
+	return cs, nil
+}
+
+func (b *Builtin) newVoltage() ([]hexapod.Component, error) {
+	var v voltage.HasVoltage
+	switch src := b.cfg.Voltage.Source; {
+	case b.opts.Offline:
+		log.Warn("using fake voltage check")
+		v = fake_voltage.New(9.6)
+
+	case src == "adc":
+		adc, err := voltage.NewADC(b.cfg.Voltage.ADC, b.cfg.Voltage.Divider)
+		if err != nil {
+			return nil, err
+		}
+		v = adc
+
+	case src == "min" || src == "max":
+		var servos []voltage.HasVoltage
+		for _, s := range b.getLegs().Servos() {
+			servos = append(servos, s)
+		}
+		v = voltage.NewAcross(servos, src == "max")
+
+	default:
+		v = b.getLegs().Legs[0].Coxa
+	}
+
+	if b.selfTest != nil {
+		b.selfTest.Voltage = v
+	}
+
+	return one(voltage.New(v, b.cfg.Voltage, b.cfg.Safety))
+}
+
+func (b *Builtin) newPower() ([]hexapod.Component, error) {
+	var ps []power.Servo
+	for _, s := range b.getLegs().Servos() {
+		ps = append(ps, s)
+	}
+
+	b.power = power.New(ps, b.cfg.Power)
+	return one(b.power)
+}
+
+func (b *Builtin) newTracker() ([]hexapod.Component, error) {
+	log.Infof("accepting detections on port %d", b.opts.TrackerPort)
+	return one(tracker.New(b.opts.TrackerPort, mount(b.cfg.Head), b.cfg.Tracker))
+}
+
+func (b *Builtin) newHead() ([]hexapod.Component, error) {
+	h, err := servos.New(b.opts.Network, 71)
+	if err != nil {
+		return nil, err
+	}
+
+	v, err := servos.New(b.opts.Network, 72)
+	if err != nil {
+		return nil, err
+	}
+
+	b.head = head.New(mount(b.cfg.Head), h, v, b.cfg.Head)
+	return one(b.head)
+}
+
+func (b *Builtin) newLEDs() ([]hexapod.Component, error) {
+	if b.cfg.LEDs.Count <= 0 {
+		return nil, errors.New("there are no LEDs (see leds.count)")
+	}
+
+	log.Warn("there's no LED strip driver yet, using a mock")
+	strip, err := leds.New(leds.NewMock(b.cfg.LEDs.Count), b.cfg.LEDs)
+	if err != nil {
+		return nil, err
+	}
+
+	return one(strip)
+}
+
+func (b *Builtin) newBuzzer() ([]hexapod.Component, error) {
+	if b.opts.BuzzerPWM == "" {
+		return nil, errors.New("no PWM channel is configured (see --buzzer-pwm)")
+	}
+
+	pwm, err := buzzer.NewPWM(b.opts.BuzzerPWM)
+	if err != nil {
+		return nil, err
+	}
+
+	return one(buzzer.New(pwm))
+}
+
+func (b *Builtin) newOdometer() ([]hexapod.Component, error) {
+	if b.opts.OdometerPath == "" {
+		return nil, errors.New("no path is configured (see --odometer-path)")
+	}
+
+	b.odometer = odometer.New(b.opts.OdometerPath, b.h.LoopStats)
+	return one(b.odometer)
+}
+
+func (b *Builtin) newSession() ([]hexapod.Component, error) {
+	b.session = session.New(b.opts.RecorderDir, b.h.LoopStats, b.cfg.Safety)
+	b.session.Odometer = b.odometer
+	return one(b.session)
+}
+
+func (b *Builtin) newAPI() ([]hexapod.Component, error) {
+	if b.opts.HTTPPort <= 0 {
+		return nil, errors.New("no port is configured (see --http-port)")
+	}
+
+	log.Info("starting HTTP API")
+	a := api.New(b.opts.HTTPPort, b.h, b.cfg.Telemetry.Units())
+	a.Navigator = b.nav
+	a.Session = b.session
+	a.Odometer = b.odometer
+	a.Head = b.head
+	a.Power = b.power
+	return one(a)
+}
+
+func (b *Builtin) newConsole() ([]hexapod.Component, error) {
+	if b.opts.ConsoleSocket == "" {
+		return one(console.New(os.Stdin, os.Stdout, b.cfg.Controller, b.h.Params))
+	}
+
+	return one(console.Listen(b.opts.ConsoleSocket, b.cfg.Controller, b.h.Params))
+}
+
+func (b *Builtin) newTelemetry() ([]hexapod.Component, error) {
+	if b.opts.TelemetryPort <= 0 {
+		return nil, errors.New("no port is configured (see --telemetry-port)")
+	}
+
+	log.Infof("streaming telemetry at %dHz", b.opts.TelemetryRate)
+	return one(telemetry.New(b.opts.TelemetryPort, b.opts.TelemetryRate, b.cfg.Telemetry.Units(), b.decimate()))
+}
+
+func (b *Builtin) newMQTT() ([]hexapod.Component, error) {
+	if b.opts.MQTTBroker == "" {
+		return nil, errors.New("no broker is configured (see --mqtt-broker)")
+	}
+
+	// The topics are per-robot by default, so more than one can share a broker.
+	prefix := b.opts.MQTTPrefix
+	if prefix == "" {
+		prefix = "hexapod/" + b.h.State.Identity.ID
+	}
+
+	log.Infof("publishing to MQTT broker at %s under %s", b.opts.MQTTBroker, prefix)
+	return one(mqtt.New(b.opts.MQTTBroker, prefix, b.opts.MQTTInterval, b.h.Params, b.decimate()))
+}
+
+func (b *Builtin) newRosbridge() ([]hexapod.Component, error) {
+	if b.opts.RosbridgeURL == "" {
+		return nil, errors.New("no server is configured (see --rosbridge-url)")
+	}
+
+	log.Infof("bridging to ROS at %s", b.opts.RosbridgeURL)
+	return one(rosbridge.New(b.opts.RosbridgeURL, b.opts.RosbridgePrefix, b.opts.RosbridgeCmdVel, b.opts.RosbridgeRate))
+}
+
+func (b *Builtin) newDerate() ([]hexapod.Component, error) {
+	return one(derate.New(b.cfg.Derate, b.cfg.Safety))
+}
+
+func (b *Builtin) newEndurance() ([]hexapod.Component, error) {
+	return one(endurance.New(b.cfg.Endurance))
+}
+
+func (b *Builtin) newSafeMode() ([]hexapod.Component, error) {
+	return one(safemode.New(b.cfg.Controller))
+}
+
+func (b *Builtin) newDiscovery() ([]hexapod.Component, error) {
+	if b.opts.DiscoveryInterval <= 0 {
+		return nil, errors.New("no interval is configured (see --discovery-interval)")
+	}
+
+	return one(discovery.New(discovery.Beacon{
+		Firmware:      hexapod.Version,
+		APIPort:       b.opts.HTTPPort,
+		TelemetryPort: b.opts.TelemetryPort,
+	}, b.opts.DiscoveryPort, b.opts.DiscoveryInterval, b.cfg.Safety))
+}
+
+func (b *Builtin) newProfiles() ([]hexapod.Component, error) {
+	return one(profiles.New(b.cfg.Profiles, b.cfg.Profile, b.h.Params))
+}
+
+func (b *Builtin) newSettings() ([]hexapod.Component, error) {
+	if b.opts.SettingsPath == "" {
+		return nil, errors.New("no path is configured (see --settings-path)")
+	}
+
+	return one(settings.New(b.opts.SettingsPath, settings.Keys, b.h.Params))
+}
+
+func (b *Builtin) newReload() ([]hexapod.Component, error) {
+	if b.opts.ConfigPath == "" {
+		return nil, errors.New("no config file is configured (see --config)")
+	}
+
+	b.reloader = reload.New(b.opts.ConfigPath, b.cfg, b.h.Params, b.opts.ConfigWatch)
+	return one(b.reloader)
+}
+
+func (b *Builtin) newStateLog() ([]hexapod.Component, error) {
+	if b.opts.StateLogDir == "" {
+		return nil, errors.New("no directory is configured (see --state-log-dir)")
+	}
+
+	sl, err := statelog.New(b.opts.StateLogDir, statelog.Format(b.opts.StateLogFormat), strings.Split(b.opts.StateLogFields, ","), b.opts.StateLogSize)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Infof("logging state to %s", b.opts.StateLogDir)
+	return one(sl)
+}
+
+func (b *Builtin) newSysmon() ([]hexapod.Component, error) {
+	return one(sysmon.New(b.h.LoopStats, b.h.Params, b.cfg.Sysmon))
+}
+
+func (b *Builtin) newWatchdog() ([]hexapod.Component, error) {
+	if b.cfg.Watchdog.Ticks <= 0 {
+		return nil, errors.New("no timeout is configured (see watchdog.ticks)")
+	}
+	if b.opts.Port == nil {
+		return nil, errors.New("there's no servo port to write to")
+	}
+
+	return one(watchdog.New(b.opts.Port, time.Duration(1000000000/b.opts.FPS), b.cfg.Watchdog))
+}
+
+func (b *Builtin) newRecorder() ([]hexapod.Component, error) {
+	r := recorder.New(b.opts.RecorderDir, 30*time.Second, b.opts.FPS)
+	if b.opts.Port != nil {
+		r.Servos = b.opts.Port.Capture
+	}
+	r.Journal = b.h.Journal
+
+	return one(r)
+}
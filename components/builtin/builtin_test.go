@@ -0,0 +1,313 @@
+package builtin
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/adammck/dynamixel/network"
+	"github.com/adammck/hexapod"
+	"github.com/adammck/hexapod/components/sim"
+	"github.com/adammck/hexapod/config"
+	"github.com/adammck/hexapod/math3d"
+	"github.com/adammck/hexapod/params"
+	"github.com/adammck/hexapod/servos"
+	"github.com/stretchr/testify/assert"
+)
+
+// setup returns the built-in components of a hex on the simulated servos,
+// which is offline, and otherwise configured as main is with no flags.
+func setup(t *testing.T, cfg config.Config, opts func(o *Options)) *Builtin {
+	bus := sim.NewBus()
+	port := servos.NewPort(bus)
+	n := network.New(port)
+
+	h := hexapod.New(n, cfg)
+	h.Params = params.New()
+
+	o := Options{
+		Network:           n,
+		Port:              port,
+		Bus:               bus,
+		Offline:           true,
+		FPS:               60,
+		RecorderDir:       t.TempDir(),
+		HTTPPort:          8000,
+		DiscoveryInterval: 2,
+		ConfigPath:        "/etc/hexapod.toml",
+	}
+	if opts != nil {
+		opts(&o)
+	}
+
+	return New(h, cfg, o)
+}
+
+func selected(t *testing.T, c *hexapod.Catalog, overrides ...map[string]bool) []string {
+	specs, err := c.Select(overrides...)
+	assert.NoError(t, err)
+
+	var out []string
+	for _, s := range specs {
+		out = append(out, s.Name)
+	}
+
+	return out
+}
+
+func TestDefaults(t *testing.T) {
+	cfg := config.Default()
+	c := setup(t, cfg, nil).Catalog()
+
+	// The same as main registered before components could be chosen.
+	assert.Equal(t, []string{
+		"calibration", "selftest", "transport", "legs", "sim", "derate",
+		"controller", "navigator", "voltage", "power", "head", "session", "api",
+		"endurance", "discovery", "profiles", "reload", "sysmon", "watchdog",
+		"recorder",
+	}, selected(t, c))
+
+	// Those which depend on the flags or the config follow them.
+	cfg.KillSwitch.Pin = 17
+	cfg.LEDs.Count = 0
+	c = setup(t, cfg, func(o *Options) {
+		o.Bus = nil
+		o.HTTPPort = 0
+		o.TelemetryPort = 8001
+		o.TrackerPort = 8002
+		o.DiscoveryInterval = 0
+		o.SettingsPath = "/tmp/settings.json"
+		o.ConfigPath = ""
+	}).Catalog()
+
+	assert.Equal(t, []string{
+		"calibration", "selftest", "transport", "legs", "killswitch",
+		"derate", "controller", "navigator", "voltage", "power", "tracker",
+		"head", "session", "telemetry", "endurance", "profiles", "settings",
+		"sysmon", "watchdog", "recorder",
+	}, selected(t, c))
+}
+
+func TestOverrides(t *testing.T) {
+	cfg := config.Default()
+	cfg.Components = map[string]bool{"head": false, "endurance": false}
+	c := setup(t, cfg, nil).Catalog()
+
+	flags, err := hexapod.ParseOverrides("telemetry,head", "api,discovery")
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{
+		"calibration", "selftest", "transport", "legs", "sim", "derate",
+		"controller", "navigator", "voltage", "power", "session", "api",
+		"discovery", "profiles", "reload", "sysmon", "watchdog", "recorder",
+	}, selected(t, c, cfg.Components))
+
+	// The flags win.
+	assert.Equal(t, []string{
+		"calibration", "selftest", "transport", "legs", "sim", "derate",
+		"controller", "navigator", "voltage", "power", "head", "session",
+		"telemetry", "profiles", "reload", "sysmon", "watchdog", "recorder",
+	}, selected(t, c, cfg.Components, flags))
+}
+
+func TestSafeMode(t *testing.T) {
+	cfg := config.Default()
+	c := setup(t, cfg, func(o *Options) { o.SettingsPath = "/tmp/settings.json" }).Catalog()
+	assert.Nil(t, cfg.SafeOverrides())
+	assert.NotContains(t, selected(t, c, cfg.SafeOverrides()), "safemode")
+
+	// Nothing can enable what it disables, or disable it.
+	cfg = cfg.Safe()
+	cfg.Components = map[string]bool{"navigator": true, "safemode": false}
+	c = setup(t, cfg, func(o *Options) { o.SettingsPath = "/tmp/settings.json" }).Catalog()
+
+	flags, err := hexapod.ParseOverrides("profiles,settings,reload", "safemode")
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{
+		"calibration", "selftest", "transport", "legs", "sim", "derate",
+		"controller", "voltage", "power", "head", "session", "api",
+		"endurance", "safemode", "discovery", "sysmon", "watchdog", "recorder",
+	}, selected(t, c, cfg.Components, flags, cfg.SafeOverrides()))
+}
+
+func TestDependencies(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		opts      func(o *Options)
+		overrides map[string]bool
+		err       string
+	}{
+		{
+			name:      "no legs",
+			overrides: map[string]bool{"legs": false},
+			err: "invalid components: calibration requires legs, which is disabled; " +
+				"selftest requires legs, which is disabled; " +
+				"transport requires legs, which is disabled; " +
+				"sim requires legs, which is disabled; " +
+				"power requires legs, which is disabled; " +
+				"head requires legs, which is disabled",
+		},
+		{
+			name: "no bus",
+			opts: func(o *Options) {
+				o.Network = nil
+				o.Bus = nil
+			},
+			overrides: map[string]bool{"calibration": false, "selftest": false, "power": false, "head": false},
+			err: "invalid components: legs requires bus (the servo network), which isn't available; " +
+				"watchdog requires bus (the servo network), which isn't available",
+		},
+		{
+			name:      "sim without simulated servos",
+			opts:      func(o *Options) { o.Bus = nil },
+			overrides: map[string]bool{"sim": true},
+			err:       "invalid components: sim requires simbus (the simulated servos, see --sim), which isn't available",
+		},
+		{
+			name:      "righting without an IMU",
+			overrides: map[string]bool{"righting": true},
+			err:       "invalid components: righting requires imu (the IMU, see Options.IMU), which isn't available",
+		},
+		{
+			name:      "startup without an IMU",
+			overrides: map[string]bool{"startup": true},
+			err:       "invalid components: startup requires imu (the IMU, see Options.IMU), which isn't available",
+		},
+		{
+			name:      "tracker without the head",
+			overrides: map[string]bool{"tracker": true, "head": false},
+			err:       "invalid components: tracker requires head, which is disabled",
+		},
+		{
+			// Offline, the battery voltage is faked, so doesn't need the legs.
+			name: "voltage without legs",
+			opts: func(o *Options) { o.Offline = false },
+			overrides: map[string]bool{
+				"legs": false, "calibration": false, "selftest": false,
+				"transport": false, "sim": false, "power": false, "head": false,
+			},
+			err: "invalid components: voltage requires legs, which is disabled",
+		},
+		{
+			name:      "unknown",
+			overrides: map[string]bool{"legz": false},
+			err:       "unknown components: legz (valid components are: api, buzzer, calibration, console, controller, derate, discovery, endurance, follow, head, killswitch, leds, legs, mqtt, navigator, odometer, power, profiles, rangefinder, recorder, reload, righting, rosbridge, safemode, selftest, session, settings, sim, startup, statelog, sysmon, telemetry, tracker, transport, voltage, watchdog)",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := setup(t, config.Default(), tc.opts).Catalog().Select(tc.overrides)
+			assert.EqualError(t, err, tc.err)
+		})
+	}
+}
+
+func TestBuild(t *testing.T) {
+	b := setup(t, config.Default(), nil)
+	cs, err := b.Catalog().Build(map[string]bool{"api": false, "discovery": false})
+	assert.NoError(t, err)
+
+	var types []string
+	for _, c := range cs {
+		types = append(types, fmt.Sprintf("%T", c))
+	}
+
+	assert.Equal(t, []string{
+		"*calibration.Calibration", "*selftest.SelfTest", "*transport.Transport",
+		"*legs.Legs", "*sim.Sim", "*derate.Derate", "*controller.Controller",
+		"*navigator.Navigator", "*voltage.VoltageCheck", "*power.Power",
+		"*head.Head", "*session.Session", "*endurance.Endurance",
+		"*profiles.Profiles", "*reload.Reloader", "*sysmon.Sysmon",
+		"*watchdog.Watchdog", "*recorder.Recorder",
+	}, types)
+
+	// The shared components are wired up.
+	assert.NotNil(t, b.Reloader())
+	assert.NotNil(t, b.selfTest.Voltage)
+	assert.Nil(t, b.selfTest.IMU)
+}
+
+func TestBuildWithoutOptions(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		err  string
+	}{
+		{"api", "error creating api: no port is configured (see --http-port)"},
+		{"follow", "error creating follow: nothing to follow or broadcast (see follow.leader and follow.broadcast)"},
+		{"buzzer", "error creating buzzer: no PWM channel is configured (see --buzzer-pwm)"},
+		{"killswitch", "error creating killswitch: no pin is configured (see killswitch.pin)"},
+		{"watchdog", "error creating watchdog: no timeout is configured (see watchdog.ticks)"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := config.Default()
+			cfg.KillSwitch.Pin = -1
+			cfg.Watchdog.Ticks = 0
+
+			b := setup(t, cfg, func(o *Options) { o.HTTPPort = 0 })
+			_, err := b.Catalog().Build(map[string]bool{tc.name: true, "head": false})
+			assert.EqualError(t, err, tc.err)
+		})
+	}
+}
+
+// imu is an IMU which reads the right way up.
+type imu struct{}
+
+func (imu) Acceleration() (math3d.Vector3, error) {
+	return math3d.Vector3{Y: 1}, nil
+}
+
+func TestBuildRighting(t *testing.T) {
+	cfg := config.Default()
+	cfg.Righting.Enabled = true
+
+	b := setup(t, cfg, func(o *Options) { o.IMU = imu{} })
+	cs, err := b.Catalog().Build(map[string]bool{"api": false, "discovery": false})
+	assert.NoError(t, err)
+
+	var types []string
+	for _, c := range cs {
+		types = append(types, fmt.Sprintf("%T", c))
+	}
+	assert.Equal(t, []string{"*calibration.Calibration", "*selftest.SelfTest", "*righting.Righting", "*transport.Transport", "*legs.Legs"}, types[:5])
+
+	// The self-test checks the same IMU.
+	assert.Equal(t, imu{}, b.selfTest.IMU)
+}
+
+func TestBuildStartup(t *testing.T) {
+	cfg := config.Default()
+	cfg.Startup.Enabled = true
+
+	b := setup(t, cfg, func(o *Options) { o.IMU = imu{} })
+	cs, err := b.Catalog().Build(map[string]bool{"api": false, "discovery": false})
+	assert.NoError(t, err)
+
+	var types []string
+	for _, c := range cs {
+		types = append(types, fmt.Sprintf("%T", c))
+	}
+	assert.Equal(t, []string{"*calibration.Calibration", "*selftest.SelfTest", "*startup.Startup", "*transport.Transport", "*legs.Legs"}, types[:5])
+}
+
+func TestBuildFollow(t *testing.T) {
+	cfg := config.Default()
+	cfg.Identity.ID = "beta"
+	cfg.Follow.Broadcast = true
+	cfg.Follow.Leader = "alpha"
+
+	// Only the components, not their sockets, which are opened at boot.
+	cs, err := setup(t, cfg, nil).Catalog().Build(map[string]bool{"api": false, "discovery": false})
+	assert.NoError(t, err)
+
+	var types []string
+	for _, c := range cs {
+		types = append(types, fmt.Sprintf("%T", c))
+	}
+	assert.Contains(t, types, "*follow.Broadcaster")
+	assert.Contains(t, types, "*follow.Follower")
+
+	// A hex can't follow itself.
+	cfg.Follow.Leader = "beta"
+	_, err = setup(t, cfg, nil).Catalog().Build(map[string]bool{"api": false, "discovery": false})
+	assert.EqualError(t, err, "error creating follow: can't follow beta, since that's this hex")
+}
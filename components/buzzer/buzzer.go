@@ -0,0 +1,338 @@
+// Package buzzer beeps a piezo buzzer when something happens which the operator
+// should know about, since they can't see the logs in the field.
+package buzzer
+
+import (
+	"time"
+
+	"github.com/adammck/hexapod"
+)
+
+var log = hexapod.NewLog("buzzer")
+
+// The silence between one sequence and the next, so they don't run together.
+const gap = 50 * time.Millisecond
+
+// Driver makes the buzzer sound.
+type Driver interface {
+
+	// Tone starts a square wave at the given frequency (in Hz), or stops it if
+	// the frequency is zero. It must return quickly, since it's called from the
+	// main loop.
+	Tone(freq float64) error
+
+	Close() error
+}
+
+// Note is a single tone, or a rest if Freq is zero.
+type Note struct {
+	Freq float64
+	Dur  time.Duration
+}
+
+// Sequence is a named series of notes, which are played one after the other.
+type Sequence struct {
+	Name  string
+	Notes []Note
+}
+
+// The sequences which are played for each trigger.
+var (
+	Boot = Sequence{"boot", []Note{
+		{1000, 80 * time.Millisecond},
+		{1500, 80 * time.Millisecond},
+		{2000, 120 * time.Millisecond},
+	}}
+
+	BatteryLow = Sequence{"battery_low", []Note{
+		{2000, 100 * time.Millisecond},
+		{0, 100 * time.Millisecond},
+		{2000, 100 * time.Millisecond},
+	}}
+
+	// This is played over and over while the battery is critical.
+	BatteryCritical = Sequence{"battery_critical", []Note{
+		{2500, 60 * time.Millisecond},
+		{0, 60 * time.Millisecond},
+	}}
+
+	Degraded = Sequence{"degraded", []Note{
+		{1500, 150 * time.Millisecond},
+		{800, 150 * time.Millisecond},
+		{1500, 150 * time.Millisecond},
+		{800, 150 * time.Millisecond},
+	}}
+
+	SelfTestPassed = Sequence{"selftest_passed", []Note{
+		{1500, 80 * time.Millisecond},
+		{2000, 200 * time.Millisecond},
+	}}
+
+	SelfTestFailed = Sequence{"selftest_failed", []Note{
+		{800, 300 * time.Millisecond},
+		{0, 100 * time.Millisecond},
+		{800, 300 * time.Millisecond},
+	}}
+
+	// These are played when the hex sits down for a rest on a long run, and
+	// gets back up, so the audience knows that it's on purpose.
+	CoolingStarted = Sequence{"cooling_started", []Note{
+		{2000, 150 * time.Millisecond},
+		{1500, 150 * time.Millisecond},
+		{1000, 300 * time.Millisecond},
+	}}
+
+	CoolingEnded = Sequence{"cooling_ended", []Note{
+		{1000, 100 * time.Millisecond},
+		{1500, 100 * time.Millisecond},
+		{2000, 100 * time.Millisecond},
+		{2500, 150 * time.Millisecond},
+	}}
+
+	// A chirp for each second of the countdown before an autonomous source
+	// starts moving the hex (see hexapod.Autonomous), a longer and higher one
+	// when it starts, and a low one if it's cancelled.
+	Countdown = Sequence{"countdown", []Note{
+		{1800, 80 * time.Millisecond},
+	}}
+
+	CountdownOver = Sequence{"countdown_over", []Note{
+		{2500, 300 * time.Millisecond},
+	}}
+
+	CountdownCancelled = Sequence{"countdown_cancelled", []Note{
+		{600, 250 * time.Millisecond},
+	}}
+
+	// A rising pair when the tuning mode is engaged, a falling pair when it's
+	// disengaged, and a short chirp to confirm each param which is nudged.
+	TuningOn = Sequence{"tuning_on", []Note{
+		{1200, 80 * time.Millisecond},
+		{1800, 80 * time.Millisecond},
+	}}
+
+	TuningOff = Sequence{"tuning_off", []Note{
+		{1800, 80 * time.Millisecond},
+		{1200, 80 * time.Millisecond},
+	}}
+
+	Tuned = Sequence{"tuned", []Note{
+		{2200, 40 * time.Millisecond},
+	}}
+
+	Shutdown = Sequence{"shutdown", []Note{
+		{2000, 120 * time.Millisecond},
+		{1500, 120 * time.Millisecond},
+		{1000, 250 * time.Millisecond},
+	}}
+)
+
+// change is a point in the timeline at which the buzzer should start playing a
+// frequency.
+type change struct {
+	at   time.Time
+	freq float64
+	seq  string
+}
+
+// Buzzer is a component which plays sequences on the buzzer when events are
+// published, or the state changes. Sequences are scheduled rather than played
+// by sleeping, so a tick never waits for one; each tick just sets the tone to
+// whatever should be playing by then.
+//
+// Conditions which persist (like a low battery) only beep when they start, and
+// a sequence which is already scheduled isn't scheduled again.
+type Buzzer struct {
+	drv Driver
+
+	// The changes to make, in order, and the frequency being played now.
+	timeline []change
+	freq     float64
+
+	// Which conditions have already been beeped about.
+	booted   bool
+	low      bool
+	critical bool
+	shutdown bool
+
+	// Sequences triggered by events since the last tick.
+	queued []Sequence
+}
+
+// New creates a buzzer component which plays to the given driver.
+func New(drv Driver) *Buzzer {
+	return &Buzzer{
+		drv: drv,
+	}
+}
+
+func (b *Buzzer) Boot() error {
+	return b.drv.Tone(0)
+}
+
+// Close silences the buzzer, and closes the driver.
+func (b *Buzzer) Close() error {
+	err := b.drv.Tone(0)
+	if err != nil {
+		return err
+	}
+
+	return b.drv.Close()
+}
+
+// Shutdown closes the buzzer, once the loop has stopped.
+func (b *Buzzer) Shutdown() error {
+	return b.Close()
+}
+
+// Wants implements hexapod.Subscriber.
+func (b *Buzzer) Wants(e *hexapod.Event) bool {
+	switch e.Name {
+	case hexapod.EventBatteryLow, hexapod.EventComponentUnhealthy, hexapod.EventSelfTestPassed, hexapod.EventSelfTestFailed, hexapod.EventCoolingStarted, hexapod.EventCoolingEnded, hexapod.EventCountdown, hexapod.EventCountdownCancelled, hexapod.EventTuningChanged, hexapod.EventParamTuned:
+		return true
+	}
+
+	return false
+}
+
+// Notify implements hexapod.Subscriber.
+func (b *Buzzer) Notify(events []hexapod.Event) {
+	for _, e := range events {
+		switch {
+		case e.Name == hexapod.EventComponentUnhealthy:
+			b.queued = append(b.queued, Degraded)
+
+		case e.Name == hexapod.EventSelfTestPassed:
+			b.queued = append(b.queued, SelfTestPassed)
+
+		case e.Name == hexapod.EventSelfTestFailed:
+			b.queued = append(b.queued, SelfTestFailed)
+
+		case e.Name == hexapod.EventCoolingStarted:
+			b.queued = append(b.queued, CoolingStarted)
+
+		case e.Name == hexapod.EventCoolingEnded:
+			b.queued = append(b.queued, CoolingEnded)
+
+		case e.Name == hexapod.EventCountdown && e.Payload == 0:
+			b.queued = append(b.queued, CountdownOver)
+
+		case e.Name == hexapod.EventCountdown:
+			b.queued = append(b.queued, Countdown)
+
+		case e.Name == hexapod.EventCountdownCancelled:
+			b.queued = append(b.queued, CountdownCancelled)
+
+		case e.Name == hexapod.EventTuningChanged && e.Payload == true:
+			b.queued = append(b.queued, TuningOn)
+
+		case e.Name == hexapod.EventTuningChanged:
+			b.queued = append(b.queued, TuningOff)
+
+		case e.Name == hexapod.EventParamTuned:
+			b.queued = append(b.queued, Tuned)
+
+		case e.Severity >= hexapod.Critical:
+			b.critical = true
+
+		case !b.low:
+			b.low = true
+			b.queued = append(b.queued, BatteryLow)
+		}
+	}
+}
+
+// Tick schedules any sequences which have been triggered since the last tick,
+// and then plays whatever is due. Errors are logged rather than returned,
+// since the hexapod is fine without the buzzer.
+func (b *Buzzer) Tick(now time.Time, state *hexapod.State) error {
+
+	// Whatever has finished is dropped before scheduling anything new, so it
+	// can start straight away.
+	freq := b.advance(now, b.freq)
+	b.schedule(now, state)
+	freq = b.advance(now, freq)
+
+	if freq != b.freq {
+		b.freq = freq
+		err := b.drv.Tone(freq)
+		if err != nil {
+			log.RateLimited("tone", 5*time.Second).Warnf("error playing tone: %s", err)
+		}
+	}
+
+	return nil
+}
+
+// advance drops the changes which are due by now from the timeline, and
+// returns the frequency which should be playing, given that it was freq.
+func (b *Buzzer) advance(now time.Time, freq float64) float64 {
+	for len(b.timeline) > 0 && !b.timeline[0].at.After(now) {
+		freq = b.timeline[0].freq
+		b.timeline = b.timeline[1:]
+	}
+
+	return freq
+}
+
+// schedule adds the sequences for whatever has happened to the timeline.
+func (b *Buzzer) schedule(now time.Time, state *hexapod.State) {
+	if !state.BatteryLow {
+		b.low = false
+	}
+	if !state.BatteryCritical {
+		b.critical = false
+	}
+
+	// Shutting down drowns out everything else, including whatever was
+	// already scheduled, since it's the last thing that will be heard.
+	if state.Shutdown {
+		if !b.shutdown {
+			b.shutdown = true
+			b.timeline = b.timeline[:0]
+			b.play(now, Shutdown)
+		}
+
+		b.queued = b.queued[:0]
+		return
+	}
+
+	if !b.booted {
+		b.booted = true
+		b.play(now, Boot)
+	}
+
+	for _, s := range b.queued {
+		b.play(now, s)
+	}
+	b.queued = b.queued[:0]
+
+	// Keep beeping while the battery is critical, but only once there's
+	// nothing else to play, so it doesn't drown out anything new.
+	if b.critical && len(b.timeline) == 0 {
+		b.play(now, BatteryCritical)
+	}
+}
+
+// play schedules the sequence to start once everything before it has finished
+// (or now, if nothing is playing), unless it's already scheduled.
+func (b *Buzzer) play(now time.Time, s Sequence) {
+	for _, c := range b.timeline {
+		if c.seq == s.Name {
+			return
+		}
+	}
+
+	at := now
+	if n := len(b.timeline); n > 0 {
+		at = b.timeline[n-1].at.Add(gap)
+	}
+
+	log.Debugf("playing %s at %s", s.Name, at.Format("15:04:05.000"))
+	for _, n := range s.Notes {
+		b.timeline = append(b.timeline, change{at, n.Freq, s.Name})
+		at = at.Add(n.Dur)
+	}
+
+	b.timeline = append(b.timeline, change{at, 0, s.Name})
+}
@@ -0,0 +1,244 @@
+package buzzer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/adammck/hexapod"
+	"github.com/adammck/hexapod/params"
+	"github.com/stretchr/testify/assert"
+)
+
+// player ticks the buzzer, and records when each tone was heard.
+type player struct {
+	t     *testing.T
+	b     *Buzzer
+	m     *Mock
+	start time.Time
+	now   time.Time
+	heard []string
+}
+
+func newPlayer(t *testing.T) *player {
+	m := &Mock{}
+	b := New(m)
+	assert.NoError(t, b.Boot())
+
+	start := time.Unix(0, 0)
+	return &player{t: t, b: b, m: m, start: start, now: start}
+}
+
+// play ticks every step for the given duration, and returns the tones heard
+// during it, as "offset frequency", where the offset is from the player's
+// creation.
+func (p *player) play(state *hexapod.State, d, step time.Duration) []string {
+	p.heard = nil
+	end := p.now.Add(d)
+	for ; p.now.Before(end); p.now = p.now.Add(step) {
+		n := len(p.m.Tones())
+		assert.NoError(p.t, p.b.Tick(p.now, state))
+
+		for _, f := range p.m.Tones()[n:] {
+			p.heard = append(p.heard, fmt.Sprintf("%s %v", p.now.Sub(p.start), f))
+		}
+	}
+
+	return p.heard
+}
+
+// charged returns the state of a hex with a full-ish battery.
+func charged() *hexapod.State {
+	return &hexapod.State{Measurements: hexapod.Measurements{Voltage: 12}}
+}
+
+func battery(sev hexapod.Severity, v float64) []hexapod.Event {
+	return []hexapod.Event{{Name: hexapod.EventBatteryLow, Severity: sev, Payload: v}}
+}
+
+const tick = 10 * time.Millisecond
+
+func TestBootChirps(t *testing.T) {
+	p := newPlayer(t)
+	assert.Equal(t, []string{"0s 1000", "80ms 1500", "160ms 2000", "280ms 0"}, p.play(charged(), time.Second, tick))
+
+	// Only once.
+	assert.Empty(t, p.play(charged(), time.Second, tick))
+}
+
+func TestSlowTicksKeepTime(t *testing.T) {
+	p := newPlayer(t)
+
+	// Each tick plays whatever should be playing by then, so notes are cut
+	// short (or skipped) rather than the whole sequence being stretched.
+	assert.Equal(t, []string{"0s 1000", "100ms 1500", "200ms 2000", "300ms 0"}, p.play(charged(), time.Second, 100*time.Millisecond))
+}
+
+func TestBatteryLowDoubleBeeps(t *testing.T) {
+	p := newPlayer(t)
+	p.play(charged(), time.Second, tick)
+
+	s := charged()
+	s.BatteryLow = true
+	p.b.Notify(battery(hexapod.Warning, 9.4))
+	assert.Equal(t, []string{"1s 2000", "1.1s 0", "1.2s 2000", "1.3s 0"}, p.play(s, time.Second, tick))
+
+	// Even if the warning is published again while it's low, it doesn't keep
+	// beeping.
+	p.b.Notify(battery(hexapod.Warning, 9.3))
+	assert.Empty(t, p.play(s, time.Second, tick))
+
+	// Once it's charged, it can beep again.
+	p.play(charged(), time.Second, tick)
+	p.b.Notify(battery(hexapod.Warning, 9.4))
+	assert.Equal(t, []string{"4s 2000", "4.1s 0", "4.2s 2000", "4.3s 0"}, p.play(s, time.Second, tick))
+}
+
+func TestBatteryCriticalBeepsContinuously(t *testing.T) {
+	p := newPlayer(t)
+	p.play(charged(), time.Second, tick)
+
+	s := charged()
+	s.BatteryLow, s.BatteryCritical = true, true
+	p.b.Notify(battery(hexapod.Critical, 8.8))
+	assert.Equal(t, []string{
+		"1s 2500", "1.06s 0",
+		"1.12s 2500", "1.18s 0",
+		"1.24s 2500", "1.3s 0",
+		"1.36s 2500", "1.42s 0",
+	}, p.play(s, 480*time.Millisecond, tick))
+
+	// Until the battery is replaced.
+	assert.Empty(t, p.play(charged(), time.Second, tick))
+}
+
+func TestDegradedWarbles(t *testing.T) {
+	p := newPlayer(t)
+	p.play(charged(), time.Second, tick)
+
+	unhealthy := hexapod.Event{Name: hexapod.EventComponentUnhealthy, Severity: hexapod.Critical, Payload: "*legs.Hexapod"}
+	p.b.Notify([]hexapod.Event{unhealthy, unhealthy})
+	want := []string{"1s 1500", "1.15s 800", "1.3s 1500", "1.45s 800", "1.6s 0"}
+	assert.Equal(t, want, p.play(charged(), time.Second, tick))
+}
+
+func TestSelfTest(t *testing.T) {
+	p := newPlayer(t)
+	p.play(charged(), time.Second, tick)
+
+	p.b.Notify([]hexapod.Event{{Name: hexapod.EventSelfTestPassed}})
+	assert.Equal(t, []string{"1s 1500", "1.08s 2000", "1.28s 0"}, p.play(charged(), time.Second, tick))
+
+	p.b.Notify([]hexapod.Event{{Name: hexapod.EventSelfTestFailed, Severity: hexapod.Warning, Payload: []string{"joints"}}})
+	assert.Equal(t, []string{"2s 800", "2.3s 0", "2.4s 800", "2.7s 0"}, p.play(charged(), time.Second, tick))
+}
+
+func TestCooling(t *testing.T) {
+	p := newPlayer(t)
+	p.play(charged(), time.Second, tick)
+
+	p.b.Notify([]hexapod.Event{{Name: hexapod.EventCoolingStarted, Payload: 15.0}})
+	assert.Equal(t, []string{"1s 2000", "1.15s 1500", "1.3s 1000", "1.6s 0"}, p.play(charged(), time.Second, tick))
+
+	p.b.Notify([]hexapod.Event{{Name: hexapod.EventCoolingEnded, Payload: "rested"}})
+	assert.Equal(t, []string{"2s 1000", "2.1s 1500", "2.2s 2000", "2.3s 2500", "2.45s 0"}, p.play(charged(), time.Second, tick))
+}
+
+func TestCountdown(t *testing.T) {
+	p := newPlayer(t)
+	p.play(charged(), time.Second, tick)
+
+	// A chirp each second, and then a longer one as it starts moving.
+	var heard []string
+	for _, secs := range []int{3, 2, 1, 0} {
+		p.b.Notify([]hexapod.Event{{Name: hexapod.EventCountdown, Payload: secs}})
+		heard = append(heard, p.play(charged(), time.Second, tick)...)
+	}
+	assert.Equal(t, []string{"1s 1800", "1.08s 0", "2s 1800", "2.08s 0", "3s 1800", "3.08s 0", "4s 2500", "4.3s 0"}, heard)
+
+	p.b.Notify([]hexapod.Event{{Name: hexapod.EventCountdownCancelled, Severity: hexapod.Warning, Payload: "navigator"}})
+	assert.Equal(t, []string{"5s 600", "5.25s 0"}, p.play(charged(), time.Second, tick))
+}
+
+func TestTuning(t *testing.T) {
+	p := newPlayer(t)
+	p.play(charged(), time.Second, tick)
+
+	p.b.Notify([]hexapod.Event{{Name: hexapod.EventTuningChanged, Payload: true}})
+	assert.Equal(t, []string{"1s 1200", "1.08s 1800", "1.16s 0"}, p.play(charged(), time.Second, tick))
+
+	p.b.Notify([]hexapod.Event{{Name: hexapod.EventParamTuned, Payload: params.Value{Name: "legs.step_height", Value: 45}}})
+	assert.Equal(t, []string{"2s 2200", "2.04s 0"}, p.play(charged(), time.Second, tick))
+
+	p.b.Notify([]hexapod.Event{{Name: hexapod.EventTuningChanged, Payload: false}})
+	assert.Equal(t, []string{"3s 1800", "3.08s 1200", "3.16s 0"}, p.play(charged(), time.Second, tick))
+}
+
+func TestSequencesQueue(t *testing.T) {
+	p := newPlayer(t)
+
+	// The battery warning waits for the boot chirp to finish, and then a gap.
+	s := charged()
+	s.BatteryLow = true
+	p.b.Notify(battery(hexapod.Warning, 9.4))
+	assert.Equal(t, []string{
+		"0s 1000", "80ms 1500", "160ms 2000",
+		"280ms 0", "330ms 2000", "430ms 0", "530ms 2000", "630ms 0",
+	}, p.play(s, time.Second, tick))
+}
+
+func TestShutdownDescends(t *testing.T) {
+	p := newPlayer(t)
+
+	// Shutting down cuts off the chirp, and anything queued after it.
+	p.b.Notify(battery(hexapod.Critical, 8.8))
+	s := charged()
+	s.BatteryLow, s.BatteryCritical = true, true
+	p.play(s, 100*time.Millisecond, tick)
+
+	s.Shutdown = true
+	assert.Equal(t, []string{"100ms 2000", "220ms 1500", "340ms 1000", "590ms 0"}, p.play(s, time.Second, tick))
+
+	// And nothing else is played after it.
+	p.b.Notify(battery(hexapod.Critical, 8.7))
+	assert.Empty(t, p.play(s, time.Second, tick))
+
+	assert.NoError(t, p.b.Close())
+	assert.True(t, p.m.Closed())
+}
+
+func TestPWM(t *testing.T) {
+	chip := t.TempDir()
+	path := filepath.Join(chip, "pwm1")
+
+	// The channel is exported if it doesn't exist yet. The kernel would
+	// create it then.
+	p, err := NewPWM(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "1", read(t, filepath.Join(chip, "export")))
+	assert.NoError(t, os.Mkdir(path, 0755))
+
+	assert.NoError(t, p.Tone(2000))
+	assert.Equal(t, "500000", read(t, filepath.Join(path, "period")))
+	assert.Equal(t, "250000", read(t, filepath.Join(path, "duty_cycle")))
+	assert.Equal(t, "1", read(t, filepath.Join(path, "enable")))
+
+	assert.NoError(t, p.Tone(0))
+	assert.Equal(t, "0", read(t, filepath.Join(path, "enable")))
+	assert.Equal(t, "500000", read(t, filepath.Join(path, "period")))
+
+	assert.NoError(t, p.Tone(1000))
+	assert.Equal(t, "1000000", read(t, filepath.Join(path, "period")))
+	assert.Equal(t, "500000", read(t, filepath.Join(path, "duty_cycle")))
+
+	assert.NoError(t, p.Close())
+	assert.Equal(t, "0", read(t, filepath.Join(path, "enable")))
+}
+
+func read(t *testing.T, path string) string {
+	b, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	return string(b)
+}
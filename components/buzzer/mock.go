@@ -0,0 +1,45 @@
+package buzzer
+
+import (
+	"sync"
+)
+
+// Mock is a Driver which doesn't make a sound, but keeps every frequency which
+// it was asked to play, in order.
+type Mock struct {
+	mu     sync.Mutex
+	tones  []float64
+	closed bool
+}
+
+func (m *Mock) Tone(freq float64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.tones = append(m.tones, freq)
+	return nil
+}
+
+func (m *Mock) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.closed = true
+	return nil
+}
+
+// Tones returns a copy of every frequency played so far.
+func (m *Mock) Tones() []float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]float64{}, m.tones...)
+}
+
+// Closed returns whether Close has been called.
+func (m *Mock) Closed() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.closed
+}
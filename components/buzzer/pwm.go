@@ -0,0 +1,83 @@
+package buzzer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PWM is a Driver for a buzzer on a hardware PWM channel, via the sysfs
+// interface, e.g. /sys/class/pwm/pwmchip0/pwm0.
+type PWM struct {
+	path string
+
+	// The period (in ns) which the channel is currently set to, so it's only
+	// written when the frequency changes.
+	period int64
+}
+
+// NewPWM returns a driver for the PWM channel at the given path, exporting it
+// first if it hasn't been already.
+func NewPWM(path string) (*PWM, error) {
+	_, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		n := strings.TrimPrefix(filepath.Base(path), "pwm")
+		err = write(filepath.Join(filepath.Dir(path), "export"), n)
+		if err != nil {
+			return nil, fmt.Errorf("error exporting PWM channel: %s", err)
+		}
+	} else if err != nil {
+		return nil, err
+	}
+
+	return &PWM{path: path}, nil
+}
+
+// Tone sets the period of the channel to match the frequency, with a 50% duty
+// cycle, or disables it.
+func (p *PWM) Tone(freq float64) error {
+	if freq <= 0 {
+		return p.set("enable", 0)
+	}
+
+	period := int64(float64(time.Second) / freq)
+	if period != p.period {
+
+		// The duty cycle can't be longer than the period, so has to be
+		// cleared before the period is shortened.
+		err := p.set("duty_cycle", 0)
+		if err != nil {
+			return err
+		}
+
+		err = p.set("period", period)
+		if err != nil {
+			return err
+		}
+
+		err = p.set("duty_cycle", period/2)
+		if err != nil {
+			return err
+		}
+
+		p.period = period
+	}
+
+	return p.set("enable", 1)
+}
+
+// Close disables the channel.
+func (p *PWM) Close() error {
+	return p.set("enable", 0)
+}
+
+func (p *PWM) set(name string, v int64) error {
+	return write(filepath.Join(p.path, name), strconv.FormatInt(v, 10))
+}
+
+func write(path, s string) error {
+	return os.WriteFile(path, []byte(s), 0644)
+}
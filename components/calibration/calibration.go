@@ -0,0 +1,291 @@
+package calibration
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/adammck/hexapod"
+	"github.com/adammck/hexapod/components/legs"
+	"github.com/adammck/hexapod/units"
+)
+
+var log = hexapod.NewLog("calibration")
+
+const (
+
+	// The zero angle which the servos start with, i.e. the middle of their
+	// range. Offsets are relative to this.
+	defaultZero = 150.0
+
+	// Offsets larger than this (in degrees) are almost certainly a mistake,
+	// e.g. capturing before the leg was posed, rather than slop in the horns.
+	maxOffset = 30.0
+
+	// The maximum clearance (in mm) at which the hex counts as parked, and so
+	// the wizard can be started.
+	maxParkedClearance = 1.0
+)
+
+// The names of the joints of each leg, from the body outwards. These are used
+// in the keys of the offsets.
+var joints = [4]string{"coxa", "femur", "tibia", "tarsus"}
+
+// Servo is the part of servo.Servo which calibration needs.
+type Servo interface {
+	Angle() (float64, error)
+	SetZero(offset float64)
+	SetTorqueEnable(state bool) error
+	SetLED(state bool) error
+}
+
+// Leg is a named set of servos to calibrate together, in the same order as
+// joints.
+type Leg struct {
+	Name   string
+	Servos [4]Servo
+}
+
+// FromLegs returns the legs to calibrate, from the legs component.
+func FromLegs(ls [6]*legs.Leg) []Leg {
+	out := make([]Leg, len(ls))
+	for i, l := range ls {
+		out[i] = Leg{
+			Name:   l.Name,
+			Servos: [4]Servo{l.Coxa, l.Femur, l.Tibia, l.Tarsus},
+		}
+	}
+
+	return out
+}
+
+// Calibration is a component which applies the servo offsets from the
+// calibration file at boot, and runs the calibration wizard to capture new
+// ones when State.Calibration asks it to.
+//
+// The wizard only starts while parked. It relaxes one leg at a time (lighting
+// up its LEDs), and waits for it to be posed flat and straight out by hand.
+// Capturing reads the angles of its servos as the new offsets; skipping leaves
+// the leg as it was. After the last leg, the new offsets are saved and applied.
+// Shutting down mid-way aborts, without changing anything.
+//
+// This must be added before the legs, so the offsets are applied before the
+// legs set their initial goals.
+type Calibration struct {
+	path string
+	legs []Leg
+
+	// The offsets which are currently applied, and those captured so far by
+	// the wizard.
+	offsets Offsets
+	pending Offsets
+
+	// The index of the leg being calibrated, or -1 if the wizard isn't running.
+	active int
+}
+
+// New creates a calibration component for the given legs, which reads and
+// writes the calibration file at the given path.
+func New(path string, ls []Leg) *Calibration {
+	return &Calibration{
+		path:    path,
+		legs:    ls,
+		offsets: Offsets{},
+		active:  -1,
+	}
+}
+
+// Boot loads the calibration file, and applies the offsets.
+func (c *Calibration) Boot() error {
+	o, err := Load(c.path)
+	if err != nil {
+		return err
+	}
+
+	c.offsets = o
+	c.apply()
+
+	log.Infof("applied %d offsets from %s", len(o), c.path)
+	return nil
+}
+
+// apply sets the zero of every servo, according to the current offsets.
+func (c *Calibration) apply() {
+	for _, l := range c.legs {
+		for i, s := range l.Servos {
+			s.SetZero(defaultZero + c.offsets[key(l.Name, joints[i])])
+		}
+	}
+}
+
+func (c *Calibration) Tick(now time.Time, state *hexapod.State) error {
+	req := state.Calibration
+	state.Calibration = hexapod.CalibrationNone
+
+	if c.active < 0 {
+		if req == hexapod.CalibrationStart {
+			c.start(state)
+		}
+
+		return nil
+	}
+
+	if state.Shutdown {
+		log.Warn("shutting down, aborting calibration")
+		c.stop(state)
+		return nil
+	}
+
+	switch req {
+	case hexapod.CalibrationCapture:
+		err := c.capture()
+		if err != nil {
+			log.Warnf("%s (while capturing %s)", err, c.legs[c.active].Name)
+			return nil
+		}
+
+		c.next(state)
+
+	case hexapod.CalibrationSkip:
+		log.Infof("skipped %s", c.legs[c.active].Name)
+		c.next(state)
+	}
+
+	return nil
+}
+
+func (c *Calibration) start(state *hexapod.State) {
+	if state.Shutdown {
+		return
+	}
+
+	if state.SelfTesting {
+		log.Warn("can't calibrate while self-testing")
+		return
+	}
+
+	if state.Pose.Position.Y > maxParkedClearance {
+		log.Warnf("can't calibrate unless parked (clearance=%s)", units.MM(state.Pose.Position.Y))
+		return
+	}
+
+	log.Info("starting calibration")
+	state.Calibrating = true
+	c.pending = Offsets{}
+	c.active = 0
+	c.relax(state)
+}
+
+// relax turns off the torque of the active leg, so it can be posed by hand, and
+// turns on its LEDs to show which one it is.
+func (c *Calibration) relax(state *hexapod.State) {
+	l := c.legs[c.active]
+
+	err := each(l, func(s Servo) error { return s.SetTorqueEnable(false) })
+	if err == nil {
+		err = each(l, func(s Servo) error { return s.SetLED(true) })
+	}
+	if err != nil {
+		log.Warnf("%s (while relaxing %s), aborting calibration", err, l.Name)
+		c.stop(state)
+		return
+	}
+
+	log.Infof("calibrating %s (%d/%d): pose it flat and straight out, then press X to capture or triangle to skip", l.Name, c.active+1, len(c.legs))
+}
+
+// hold turns the torque of the active leg back on, and its LEDs off.
+func (c *Calibration) hold() {
+	l := c.legs[c.active]
+
+	err := each(l, func(s Servo) error { return s.SetTorqueEnable(true) })
+	if err != nil {
+		log.Warnf("%s (while holding %s)", err, l.Name)
+	}
+
+	err = each(l, func(s Servo) error { return s.SetLED(false) })
+	if err != nil {
+		log.Warnf("%s (while holding %s)", err, l.Name)
+	}
+}
+
+// capture reads the angle of each servo of the active leg, which has been
+// posed at zero, and so is the error of its current offset. Nothing is kept if
+// any of them fail.
+func (c *Calibration) capture() error {
+	l := c.legs[c.active]
+	o := Offsets{}
+
+	for i, s := range l.Servos {
+		a, err := s.Angle()
+		if err != nil {
+			return fmt.Errorf("%s (while reading %s angle)", err, joints[i])
+		}
+
+		k := key(l.Name, joints[i])
+		v := c.offsets[k] + a
+		if v < -maxOffset || v > maxOffset {
+			return fmt.Errorf("%s offset would be %0.1f, which is too far; is the leg posed?", joints[i], v)
+		}
+
+		o[k] = v
+	}
+
+	for k, v := range o {
+		c.pending[k] = v
+	}
+
+	log.Infof("captured %s: %0.1f, %0.1f, %0.1f, %0.1f", l.Name, o[key(l.Name, joints[0])], o[key(l.Name, joints[1])], o[key(l.Name, joints[2])], o[key(l.Name, joints[3])])
+	return nil
+}
+
+// next moves on to the next leg, or finishes if that was the last one.
+func (c *Calibration) next(state *hexapod.State) {
+	c.hold()
+	c.active += 1
+
+	if c.active < len(c.legs) {
+		c.relax(state)
+		return
+	}
+
+	c.active = -1
+	state.Calibrating = false
+
+	if len(c.pending) == 0 {
+		log.Info("finished calibration, nothing captured")
+		return
+	}
+
+	for k, v := range c.pending {
+		c.offsets[k] = v
+	}
+	c.apply()
+
+	err := c.offsets.Save(c.path)
+	if err != nil {
+		log.Warnf("%s (while saving calibration); the new offsets are applied, but will be lost at restart", err)
+		return
+	}
+
+	log.Infof("finished calibration, saved %d offsets to %s", len(c.pending), c.path)
+}
+
+// stop aborts the wizard, discarding anything captured so far.
+func (c *Calibration) stop(state *hexapod.State) {
+	c.hold()
+	c.active = -1
+	c.pending = nil
+	state.Calibrating = false
+}
+
+// each calls f for each servo in the leg, and returns the first error.
+func each(l Leg, f func(Servo) error) error {
+	for _, s := range l.Servos {
+		err := f(s)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
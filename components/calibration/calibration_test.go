@@ -0,0 +1,231 @@
+package calibration
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/adammck/hexapod"
+	"github.com/adammck/hexapod/hexapodtest"
+	"github.com/adammck/hexapod/math3d"
+	"github.com/stretchr/testify/assert"
+)
+
+type mockServo struct {
+	angle  float64
+	zero   float64
+	torque bool
+	led    bool
+	err    error
+}
+
+func (s *mockServo) Angle() (float64, error) {
+	return s.angle, s.err
+}
+
+func (s *mockServo) SetZero(offset float64) {
+	s.zero = offset
+}
+
+func (s *mockServo) SetTorqueEnable(state bool) error {
+	s.torque = state
+	return nil
+}
+
+func (s *mockServo) SetLED(state bool) error {
+	s.led = state
+	return nil
+}
+
+type fixture struct {
+	*hexapodtest.Clock
+	path   string
+	c      *Calibration
+	servos [][4]*mockServo
+}
+
+func setup(t *testing.T, file string) *fixture {
+	f := &fixture{
+		Clock: hexapodtest.NewClock(t, 20*time.Millisecond),
+		path:  filepath.Join(t.TempDir(), "calibration.json"),
+	}
+
+	if file != "" {
+		assert.NoError(t, ioutil.WriteFile(f.path, []byte(file), 0644))
+	}
+
+	var ls []Leg
+	for _, name := range []string{"FL", "FR", "MR"} {
+		var ms [4]*mockServo
+		var ss [4]Servo
+		for i := range ms {
+			ms[i] = &mockServo{torque: true}
+			ss[i] = ms[i]
+		}
+
+		f.servos = append(f.servos, ms)
+		ls = append(ls, Leg{Name: name, Servos: ss})
+	}
+
+	f.c = New(f.path, ls)
+	assert.NoError(t, f.c.Boot())
+	return f
+}
+
+func (f *fixture) press(req hexapod.CalibrationRequest) {
+	f.State.Calibration = req
+	f.Tick(f.c)
+	assert.Equal(f.T, hexapod.CalibrationNone, f.State.Calibration)
+}
+
+// pose sets the angles which the servos of the given leg will read as.
+func (f *fixture) pose(leg int, angles ...float64) {
+	for i, a := range angles {
+		f.servos[leg][i].angle = a
+	}
+}
+
+func (f *fixture) read(t *testing.T) Offsets {
+	o, err := Load(f.path)
+	assert.NoError(t, err)
+	return o
+}
+
+func TestWizard(t *testing.T) {
+	f := setup(t, `{"FL.coxa": 2, "MR.tarsus": -1}`)
+
+	// Offsets from the file are applied at boot.
+	assert.Equal(t, 152.0, f.servos[0][0].zero)
+	assert.Equal(t, 150.0, f.servos[0][1].zero)
+	assert.Equal(t, 149.0, f.servos[2][3].zero)
+
+	f.press(hexapod.CalibrationStart)
+	assert.True(t, f.State.Calibrating)
+
+	// Only the first leg is relaxed.
+	for i := 0; i < 4; i++ {
+		assert.False(t, f.servos[0][i].torque)
+		assert.True(t, f.servos[0][i].led)
+		assert.True(t, f.servos[1][i].torque)
+	}
+
+	// Ticks without a request don't do anything.
+	f.press(hexapod.CalibrationNone)
+	assert.False(t, f.servos[0][0].torque)
+
+	// Capture the first leg. The angles are relative to the current zero, so
+	// add to the existing offsets.
+	f.pose(0, 1.5, -3, 0, 4)
+	f.press(hexapod.CalibrationCapture)
+	assert.True(t, f.servos[0][0].torque)
+	assert.False(t, f.servos[0][0].led)
+	assert.False(t, f.servos[1][0].torque)
+
+	// Nothing is applied until the end.
+	assert.Equal(t, 152.0, f.servos[0][0].zero)
+
+	// Skip the second.
+	f.pose(1, 10, 10, 10, 10)
+	f.press(hexapod.CalibrationSkip)
+	assert.True(t, f.servos[1][0].torque)
+	assert.False(t, f.servos[2][0].torque)
+
+	// A wild reading (e.g. the leg wasn't posed yet) isn't captured, and the
+	// leg stays relaxed to try again.
+	f.pose(2, 0, 45, 0, 0)
+	f.press(hexapod.CalibrationCapture)
+	assert.True(t, f.State.Calibrating)
+	assert.False(t, f.servos[2][0].torque)
+
+	f.pose(2, -0.5, 1, 2, 3)
+	f.press(hexapod.CalibrationCapture)
+	assert.False(t, f.State.Calibrating)
+
+	// The file has the new offsets for the calibrated legs, and keeps what was
+	// there for the rest.
+	assert.Equal(t, Offsets{
+		"FL.coxa":   3.5,
+		"FL.femur":  -3,
+		"FL.tibia":  0,
+		"FL.tarsus": 4,
+		"MR.coxa":   -0.5,
+		"MR.femur":  1,
+		"MR.tibia":  2,
+		"MR.tarsus": 2,
+	}, f.read(t))
+
+	// And they're applied live.
+	assert.Equal(t, 153.5, f.servos[0][0].zero)
+	assert.Equal(t, 147.0, f.servos[0][1].zero)
+	assert.Equal(t, 150.0, f.servos[1][0].zero)
+	assert.Equal(t, 152.0, f.servos[2][3].zero)
+	for _, ms := range f.servos {
+		for _, s := range ms {
+			assert.True(t, s.torque)
+			assert.False(t, s.led)
+		}
+	}
+}
+
+func TestWizardAbort(t *testing.T) {
+	f := setup(t, "")
+
+	// Can't start unless parked.
+	f.State.Pose = math3d.Pose{Position: math3d.Vector3{Y: 40}}
+	f.press(hexapod.CalibrationStart)
+	assert.False(t, f.State.Calibrating)
+
+	// Capture and skip don't do anything unless the wizard is running.
+	f.State.Pose = math3d.Pose{}
+	f.press(hexapod.CalibrationCapture)
+	assert.False(t, f.State.Calibrating)
+
+	f.press(hexapod.CalibrationStart)
+	assert.True(t, f.State.Calibrating)
+	f.pose(0, 1, 2, 3, 4)
+	f.press(hexapod.CalibrationCapture)
+
+	// Read errors are retried.
+	f.servos[1][2].err = errors.New("timeout")
+	f.press(hexapod.CalibrationCapture)
+	assert.True(t, f.State.Calibrating)
+	assert.False(t, f.servos[1][0].torque)
+
+	// Shutting down puts the torque back, and throws away what was captured.
+	f.State.Shutdown = true
+	f.press(hexapod.CalibrationCapture)
+	assert.False(t, f.State.Calibrating)
+	assert.True(t, f.servos[1][0].torque)
+	assert.False(t, f.servos[1][0].led)
+	assert.Equal(t, 150.0, f.servos[0][0].zero)
+
+	_, err := os.Stat(f.path)
+	assert.True(t, os.IsNotExist(err))
+
+	// And it doesn't start again.
+	f.press(hexapod.CalibrationStart)
+	assert.False(t, f.State.Calibrating)
+}
+
+func TestLoad(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "calibration.json")
+
+	o, err := Load(p)
+	assert.NoError(t, err)
+	assert.Equal(t, Offsets{}, o)
+
+	assert.NoError(t, ioutil.WriteFile(p, []byte(`{"FL.coxa": 50}`), 0644))
+	_, err = Load(p)
+	assert.EqualError(t, err, "offset of FL.coxa must be within +/- 30, but is 50 (in "+p+")")
+
+	assert.NoError(t, ioutil.WriteFile(p, []byte(`{`), 0644))
+	_, err = Load(p)
+	assert.Error(t, err)
+
+	// A bad file stops the hex from booting.
+	c := New(p, nil)
+	assert.Error(t, c.Boot())
+}
@@ -0,0 +1,84 @@
+package calibration
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+)
+
+// Offsets are the angles (in degrees) which each servo's zero is moved by, so
+// that zero really is straight out from the chassis. They're keyed by leg and
+// joint, e.g. "FL.coxa", rather than servo ID, so the file makes sense to read.
+type Offsets map[string]float64
+
+func key(leg, joint string) string {
+	return leg + "." + joint
+}
+
+// Load reads the offsets from the calibration file at the given path. If the
+// file doesn't exist, there are no offsets. Unlike most other files, an invalid
+// calibration is an error, since walking with the wrong offsets could break
+// something.
+func Load(path string) (Offsets, error) {
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return Offsets{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var o Offsets
+	err = json.Unmarshal(b, &o)
+	if err != nil {
+		return nil, fmt.Errorf("%s (while parsing %s)", err, path)
+	}
+
+	for k, v := range o {
+		if math.IsNaN(v) || math.Abs(v) > maxOffset {
+			return nil, fmt.Errorf("offset of %s must be within +/- %v, but is %v (in %s)", k, maxOffset, v, path)
+		}
+	}
+
+	if o == nil {
+		o = Offsets{}
+	}
+
+	return o, nil
+}
+
+// Save writes the offsets to the calibration file at the given path. It writes
+// to a temporary file first, so a crash mid-write can't leave it truncated.
+func (o Offsets) Save(path string) error {
+	b, err := json.MarshalIndent(o, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+
+	_, err = tmp.Write(append(b, '\n'))
+	if err == nil {
+		err = tmp.Close()
+	} else {
+		tmp.Close()
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	err = os.Rename(tmp.Name(), path)
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,181 @@
+package console
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/adammck/hexapod"
+)
+
+// The params which the shortcuts write to.
+const (
+	clearanceParam = "controller.clearance"
+	speedParam     = "hexapod.speed"
+
+	// The prefix of the params of the simulated faults. See config.Sim.
+	faultPrefix = "sim."
+)
+
+// usage is printed for help, and after any line which can't be parsed.
+const usage = `commands:
+  clearance <mm>           set the clearance
+  speed <n>                set the speed
+  gait <name>              select a gait
+  gait pause               freeze the feet, for debugging (with gait.debug)
+  gait step-phase          play the paused gait to the end of the step
+  gait step-cycle          play the paused gait to the end of the cycle
+  gait resume              carry on walking
+  legs stiffness <preset>  select a stiffness preset (soft, normal, or stiff)
+  odometer reset <counter> reset a lifetime counter, e.g. steps.FL
+  fault <name> <val>       inject a simulated fault, e.g. fault sag 1.5
+  estop [off]              halt, or resume
+  sit                      lower the chassis to the ground
+  stand                    raise the chassis to the default clearance
+  transport                fold the legs up and turn the torque off
+  param set <name> <val>   set a tunable param
+  param get <name>         print a tunable param
+  status                   print a summary of the state
+  help                     print this`
+
+// command is a parsed line. Which of the other fields are relevant depends on
+// the name.
+type command struct {
+	name string
+
+	// The param to set or get, and the value to set it to. The clearance,
+	// speed and fault shortcuts (and sit and stand) are parsed as param sets.
+	param string
+	value float64
+
+	gait      string
+	gaitStep  hexapod.GaitStepRequest
+	stiffness string
+	counter   string
+	halt      bool
+}
+
+// parse parses a line of input. Blank lines are a command with no name. The
+// values of params aren't validated here, since the registry does that.
+func parse(line string) (command, error) {
+	f := strings.Fields(line)
+	if len(f) == 0 {
+		return command{}, nil
+	}
+
+	name, args := f[0], f[1:]
+	cmd := command{name: name}
+
+	switch name {
+	case "clearance", "speed":
+		if len(args) != 1 {
+			return command{}, fmt.Errorf("%s takes one value", name)
+		}
+
+		v, err := strconv.ParseFloat(args[0], 64)
+		if err != nil {
+			return command{}, fmt.Errorf("invalid %s: %q", name, args[0])
+		}
+
+		cmd.name = "set"
+		cmd.param = clearanceParam
+		if name == "speed" {
+			cmd.param = speedParam
+		}
+		cmd.value = v
+
+	case "gait":
+		if len(args) != 1 {
+			return command{}, fmt.Errorf("gait takes one name")
+		}
+		cmd.gait = args[0]
+
+		// The step requests aren't gaits, so there can't be one by that name.
+		if r, ok := hexapod.ParseGaitStep(args[0]); ok {
+			cmd.name = "gaitstep"
+			cmd.gait = ""
+			cmd.gaitStep = r
+		}
+
+	case "legs":
+		if len(args) != 2 || args[0] != "stiffness" {
+			return command{}, fmt.Errorf("legs takes stiffness and a preset")
+		}
+		cmd.name = "stiffness"
+		cmd.stiffness = args[1]
+
+	case "odometer":
+		if len(args) != 2 || args[0] != "reset" {
+			return command{}, fmt.Errorf("odometer takes reset and a counter")
+		}
+		cmd.name = "reset"
+		cmd.counter = args[1]
+
+	case "fault":
+		if len(args) != 2 {
+			return command{}, fmt.Errorf("fault takes a name and a value")
+		}
+
+		v, err := strconv.ParseFloat(args[1], 64)
+		if err != nil {
+			return command{}, fmt.Errorf("invalid value: %q", args[1])
+		}
+
+		cmd.name = "set"
+		cmd.param = faultPrefix + args[0]
+		cmd.value = v
+
+	case "estop":
+		switch {
+		case len(args) == 0:
+			cmd.halt = true
+		case len(args) == 1 && args[0] == "off":
+			cmd.halt = false
+		default:
+			return command{}, fmt.Errorf("estop takes nothing, or off")
+		}
+
+	case "sit", "stand", "transport", "status", "help":
+		if len(args) != 0 {
+			return command{}, fmt.Errorf("%s takes nothing", name)
+		}
+
+	case "param":
+		return parseParam(args)
+
+	default:
+		return command{}, fmt.Errorf("unknown command: %s", name)
+	}
+
+	return cmd, nil
+}
+
+func parseParam(args []string) (command, error) {
+	if len(args) == 0 {
+		return command{}, fmt.Errorf("param takes set or get")
+	}
+
+	switch args[0] {
+	case "set":
+		if len(args) != 3 {
+			return command{}, fmt.Errorf("param set takes a name and a value")
+		}
+
+		v, err := strconv.ParseFloat(args[2], 64)
+		if err != nil {
+			return command{}, fmt.Errorf("invalid value: %q", args[2])
+		}
+
+		return command{name: "set", param: args[1], value: v}, nil
+
+	case "get":
+		if len(args) != 2 {
+			return command{}, fmt.Errorf("param get takes a name")
+		}
+
+		return command{name: "get", param: args[1]}, nil
+
+	default:
+		return command{}, fmt.Errorf("param takes set or get, not %s", args[0])
+	}
+}
@@ -0,0 +1,472 @@
+// Package console accepts line-based commands from a terminal (e.g. over SSH)
+// or a unix socket, for tweaking the hex without a controller or a browser.
+package console
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adammck/hexapod"
+	"github.com/adammck/hexapod/components/odometer"
+	"github.com/adammck/hexapod/config"
+	"github.com/adammck/hexapod/params"
+)
+
+var log = hexapod.NewLog("console")
+
+// The number of replies which can be waiting to be written to a session before
+// any more are dropped, so a slow terminal can't hold up the loop.
+const replyBuffer = 32
+
+// Console is a component which reads commands, one per line, from stdin or the
+// connections to a unix socket. See usage for the commands.
+//
+// Lines are read in their own goroutines, so are parsed there, and those which
+// can't be are answered with the usage straight away, without going anywhere
+// near the hex. The rest are queued, and applied during the next Tick, in the
+// order they arrived. Params (including the clearance and speed shortcuts) are
+// written via the registry, so they're validated like those from the API, and
+// a gait is only selected if the controller would select it.
+type Console struct {
+	sync.Mutex
+	cfg    config.Controller
+	params *params.Registry
+
+	// Where to read and write, if this is the stdin console, or the path of
+	// the socket to listen on, if not.
+	in   io.Reader
+	out  io.Writer
+	path string
+
+	listener net.Listener
+
+	// Set by the sessions, applied during the next Tick.
+	pending []request
+
+	// The params written during the current tick, which the registry won't
+	// return until the next, so the commands after see the new values.
+	written map[string]float64
+}
+
+// request is a command from a session, to be applied during the next Tick.
+type request struct {
+	cmd     command
+	session *session
+}
+
+// New creates a console which reads commands from in and writes the replies to
+// out, e.g. stdin and stdout. The params (including the clearance and the
+// speed) are written to r, and stand raises the chassis to the clearance in
+// the given controller config.
+func New(in io.Reader, out io.Writer, cfg config.Controller, r *params.Registry) *Console {
+	return &Console{
+		cfg:    cfg,
+		params: r,
+		in:     in,
+		out:    out,
+	}
+}
+
+// Listen creates a console which accepts any number of connections on a unix
+// socket at the given path, each of which is read like New's in and out.
+func Listen(path string, cfg config.Controller, r *params.Registry) *Console {
+	return &Console{
+		cfg:    cfg,
+		params: r,
+		path:   path,
+	}
+}
+
+// Writes returns hexapod.Commander, since it can halt the hex, and select the
+// gait.
+func (c *Console) Writes() hexapod.Role {
+	return hexapod.Commander
+}
+
+// Source implements hexapod.Source.
+func (c *Console) Source() string {
+	return "console"
+}
+
+// Boot starts reading in the background, or listening on the socket. A stale
+// socket from a previous run is removed first.
+func (c *Console) Boot() error {
+	if c.path == "" {
+		go c.read(newSession(c.out), c.in)
+		return nil
+	}
+
+	os.Remove(c.path)
+	l, err := net.Listen("unix", c.path)
+	if err != nil {
+		return err
+	}
+
+	log.Infof("listening on %s", c.path)
+	c.listener = l
+	go c.accept()
+	return nil
+}
+
+// Shutdown stops listening, and removes the socket.
+func (c *Console) Shutdown() error {
+	if c.listener == nil {
+		return nil
+	}
+
+	return c.listener.Close()
+}
+
+func (c *Console) accept() {
+	for {
+		conn, err := c.listener.Accept()
+		if err != nil {
+			log.Infof("stopped listening: %s", err)
+			return
+		}
+
+		go func() {
+			defer conn.Close()
+			s := newSession(conn)
+			c.read(s, conn)
+			s.close()
+		}()
+	}
+}
+
+// read handles each line from r, until it ends.
+func (c *Console) read(s *session, r io.Reader) {
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		c.handle(s, sc.Text())
+	}
+
+	if err := sc.Err(); err != nil {
+		log.Warnf("error reading: %s", err)
+	}
+}
+
+// handle parses the line, and queues the command (or answers it, if it's help
+// or invalid).
+func (c *Console) handle(s *session, line string) {
+	cmd, err := parse(line)
+	if err != nil {
+		s.reply(fmt.Sprintf("error: %s\n%s", err, usage))
+		return
+	}
+
+	switch cmd.name {
+	case "":
+		return
+	case "help":
+		s.reply(usage)
+		return
+	}
+
+	c.Lock()
+	c.pending = append(c.pending, request{cmd, s})
+	c.Unlock()
+}
+
+// Tick applies the pending commands, and replies to each.
+func (c *Console) Tick(now time.Time, state *hexapod.State) error {
+	c.Lock()
+	pending := c.pending
+	c.pending = nil
+	c.Unlock()
+
+	c.written = map[string]float64{}
+	for _, req := range pending {
+		reply, err := c.apply(req.cmd, state)
+		if err != nil {
+			reply = "error: " + err.Error()
+		}
+
+		req.session.reply(reply)
+	}
+
+	return nil
+}
+
+// apply applies the given command, and returns the reply.
+func (c *Console) apply(cmd command, state *hexapod.State) (string, error) {
+	switch cmd.name {
+	case "set":
+		return c.set(cmd.param, cmd.value)
+
+	case "sit":
+		return c.set(clearanceParam, 0)
+
+	case "stand":
+		return c.set(clearanceParam, c.cfg.Clearance)
+
+	case "transport":
+		log.Info("requested transport (via console)")
+		state.StartTransport = true
+		return "folding for transport; hold circle + square to wake", nil
+
+	case "get":
+		v, ok := c.param(cmd.param)
+		if !ok {
+			return "", fmt.Errorf("no such param: %s", cmd.param)
+		}
+		return fmt.Sprintf("%s = %v", cmd.param, v), nil
+
+	case "gait":
+		return c.gait(cmd.gait, state)
+
+	case "gaitstep":
+		return c.gaitStep(cmd.gaitStep, state)
+
+	case "stiffness":
+		return c.stiffness(cmd.stiffness, state)
+
+	case "reset":
+		return c.reset(cmd.counter, state)
+
+	case "estop":
+		if cmd.halt != state.Halt {
+			log.Warnf("halt=%v (via console)", cmd.halt)
+		}
+		state.Halt = cmd.halt
+		if cmd.halt {
+			return "halted", nil
+		}
+		return "resumed", nil
+
+	case "status":
+		return c.status(state), nil
+	}
+
+	// Everything else was answered, or rejected, by handle.
+	return "", fmt.Errorf("unknown command: %s", cmd.name)
+}
+
+// set queues a write to the given param. It's applied at the start of the next
+// tick, like those from the API.
+func (c *Console) set(name string, v float64) (string, error) {
+	err := c.params.Set(map[string]float64{name: v})
+	if err != nil {
+		return "", err
+	}
+
+	log.Infof("setting %s=%v (via console)", name, v)
+	c.written[name] = v
+	return fmt.Sprintf("%s = %v", name, v), nil
+}
+
+// param returns the value of the given param, including any write to it during
+// the current tick.
+func (c *Console) param(name string) (float64, bool) {
+	if v, ok := c.written[name]; ok {
+		return v, true
+	}
+
+	return c.params.Get(name)
+}
+
+// gait selects the named gait, unless it needs more clearance than there is,
+// like the controller, or something else is controlling the legs. That disables
+// the controller's auto gait mode, once it notices.
+func (c *Console) gait(name string, state *hexapod.State) (string, error) {
+	gaits := state.Gaits
+	if gaits == nil {
+		gaits = hexapod.DefaultGaits
+	}
+
+	g, ok := gaits.Get(name)
+	if !ok {
+		var names []string
+		for _, g := range gaits.Gaits() {
+			names = append(names, g.Name)
+		}
+		sort.Strings(names)
+		return "", fmt.Errorf("no such gait: %s (gaits are: %s)", name, strings.Join(names, ", "))
+	}
+
+	if state.Calibrating || state.SelfTesting {
+		return "", fmt.Errorf("can't change the gait while calibrating or self-testing")
+	}
+
+	if clearance, ok := c.param(clearanceParam); ok && g.MinClearance > clearance {
+		return "", fmt.Errorf("gait %s needs %.0fmm clearance, but the clearance is %.0fmm", g, g.MinClearance, clearance)
+	}
+
+	if clearance, ok := c.param(clearanceParam); ok && !g.Fits(clearance) {
+		return "", fmt.Errorf("gait %s needs at most %.0fmm clearance, but the clearance is %.0fmm", g, g.MaxClearance, clearance)
+	}
+
+	if cur, _ := state.ActiveGait(); cur.Name != g.Name {
+		log.Infof("selecting gait %s (via console)", g)
+		state.SetGait(g)
+		state.Publish(hexapod.EventGaitChanged, hexapod.Info, g)
+	}
+
+	return "gait = " + g.Name, nil
+}
+
+// gaitStep asks the legs to pause or step through the gait, which they only do
+// if config.Gait.Debug is set. The reply is where the gait was before, since
+// the legs only see the request on their next tick.
+func (c *Console) gaitStep(r hexapod.GaitStepRequest, state *hexapod.State) (string, error) {
+	d := state.GaitDebug
+	if !d.Enabled {
+		return "", fmt.Errorf("can't use gait %s, since gait.debug isn't set", r)
+	}
+
+	log.Infof("requesting gait %s (via console)", r)
+	state.GaitStep = r
+	return fmt.Sprintf("gait %s, from tick %d of %d (phase %d)", r, d.Tick, d.Length, d.Phase), nil
+}
+
+// stiffness asks the legs to switch to the named stiffness preset, which they do
+// once every foot is on the ground.
+func (c *Console) stiffness(name string, state *hexapod.State) (string, error) {
+	if !config.IsStiffnessPreset(name) {
+		return "", fmt.Errorf("no such stiffness preset: %s (presets are: %s)", name, strings.Join(config.StiffnessPresets, ", "))
+	}
+
+	log.Infof("requesting stiffness %s (via console)", name)
+	state.SetStiffness = name
+	return "stiffness = " + name, nil
+}
+
+// reset asks the odometer to reset the named lifetime counter, e.g. after
+// replacing the servos of a leg. It's ignored if there's no odometer.
+func (c *Console) reset(name string, state *hexapod.State) (string, error) {
+	if !odometer.IsCounter(name) {
+		return "", fmt.Errorf("no such counter: %s (counters are: %s)", name, strings.Join(odometer.Names(), ", "))
+	}
+
+	log.Infof("requesting reset of %s counter (via console)", name)
+	state.ResetCounter = name
+	return "reset " + name, nil
+}
+
+// session is a terminal, or a connection to the socket. Replies are written in
+// its own goroutine, so they never block the loop.
+type session struct {
+	replies chan string
+	done    chan struct{}
+	once    sync.Once
+}
+
+func newSession(w io.Writer) *session {
+	s := &session{
+		replies: make(chan string, replyBuffer),
+		done:    make(chan struct{}),
+	}
+
+	go func() {
+		for {
+			select {
+			case r := <-s.replies:
+				fmt.Fprintln(w, r)
+			case <-s.done:
+				return
+			}
+		}
+	}()
+
+	return s
+}
+
+// reply queues a reply to be written, unless the session has ended, or too
+// many are already waiting.
+func (s *session) reply(r string) {
+	select {
+	case <-s.done:
+	case s.replies <- r:
+	default:
+		log.RateLimited("dropped", time.Second).Warnf("dropped a reply, since the session isn't keeping up")
+	}
+}
+
+// close stops writing replies, once the session has ended.
+func (s *session) close() {
+	s.once.Do(func() { close(s.done) })
+}
+
+// status returns a summary of the state, which fits on one screen.
+func (c *Console) status(state *hexapod.State) string {
+	var flags []string
+	for _, f := range []struct {
+		set  bool
+		name string
+	}{
+		{state.Shutdown, "shutting down"},
+		{state.Halt, "halted"},
+		{state.SafeMode, "safe mode"},
+		{state.SafeMode && !state.Armed, "not armed"},
+		{state.Calibrating, "calibrating"},
+		{state.SelfTesting, "self-testing"},
+		{state.StartupHold, "won't stand up (" + state.Startup.String() + ")"},
+		{state.Transport != hexapod.TransportNone, "transport (" + state.Transport.String() + ")"},
+		{state.Cooling, "cooling"},
+		{state.Resting, "resting"},
+		{state.GaitDebug.Paused, "gait paused"},
+	} {
+		if f.set {
+			flags = append(flags, f.name)
+		}
+	}
+	if len(flags) == 0 {
+		flags = []string{"ok"}
+	}
+
+	gait := "none"
+	if g, ok := state.ActiveGait(); ok {
+		gait = g.Name
+	}
+	if state.AutoGait.Enabled {
+		gait += " (auto)"
+	}
+
+	derate := ""
+	if state.Derate.Active {
+		derate = fmt.Sprintf(", speed limited to %.0f%%", state.Derate.Factor*100)
+	}
+
+	clearance, _ := c.param(clearanceParam)
+	profile := state.Profile
+	if profile == "" {
+		profile = "none"
+	}
+
+	stiffness := state.Stiffness
+	if stiffness == "" {
+		stiffness = "none"
+	}
+
+	p, t := state.Pose, state.Target
+	lines := []string{
+		fmt.Sprintf("status:  %s", strings.Join(flags, ", ")),
+		fmt.Sprintf("pose:    x=%.0f z=%.0f y=%.0f heading=%.0f (drift %.0fmm)", p.Position.X, p.Position.Z, p.Position.Y, p.Heading, state.Drift),
+		fmt.Sprintf("target:  x=%.0f z=%.0f y=%.0f heading=%.0f", t.Position.X, t.Position.Z, t.Position.Y, t.Heading),
+		fmt.Sprintf("gait:    %s, speed %d, clearance %.0fmm, profile %s", gait, state.Speed, clearance, profile),
+		fmt.Sprintf("battery: %.2fV, %.1fA, %.0fmAh drawn%s", state.Voltage, state.Power.Current, state.Power.Charge, derate),
+		fmt.Sprintf("servos:  %.0fC, stiffness %s", state.ServoTemperature, stiffness),
+	}
+
+	if n := state.Navigation; n.Active {
+		lines = append(lines, fmt.Sprintf("nav:     %d waypoints to go, %.0fmm from the next", n.Remaining, n.Distance))
+	} else {
+		lines = append(lines, "nav:     idle")
+	}
+
+	if f := state.Follow; f.Lost {
+		lines = append(lines, fmt.Sprintf("follow:  lost %s, halted", f.Leader))
+	} else if f.Active {
+		lines = append(lines, fmt.Sprintf("follow:  %s, %.0fmm from the slot", f.Leader, f.Distance))
+	}
+
+	lines = append(lines, fmt.Sprintf("system:  %dfps, cpu %.0f%%, %.0fC", state.FPS, state.System.CPU*100, state.System.Temperature))
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,353 @@
+package console
+
+import (
+	"bufio"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/adammck/hexapod"
+	"github.com/adammck/hexapod/config"
+	"github.com/adammck/hexapod/hexapodtest"
+	"github.com/adammck/hexapod/params"
+	"github.com/stretchr/testify/assert"
+)
+
+// fixture is a console with a registry containing params like the controller's
+// and the hex's own, and a session whose replies are kept rather than written.
+type fixture struct {
+	*hexapodtest.Clock
+	c         *Console
+	r         *params.Registry
+	s         *session
+	clearance float64
+}
+
+func setup(t *testing.T) *fixture {
+	f := &fixture{
+		Clock:     hexapodtest.NewClock(t, 20*time.Millisecond),
+		r:         params.New(),
+		s:         &session{replies: make(chan string, replyBuffer), done: make(chan struct{})},
+		clearance: 40,
+	}
+	f.State.Gaits = &hexapod.GaitRegistry{}
+
+	assert.NoError(t, f.r.Register(hexapodtest.Param(clearanceParam, params.Float, 0, 120, &f.clearance)))
+	assert.NoError(t, f.r.Register(params.Param{
+		Name: speedParam,
+		Type: params.Int,
+		Min:  hexapod.MinSpeed,
+		Max:  hexapod.MaxSpeed,
+		Get:  func() float64 { return float64(f.State.Speed) },
+		Set:  func(v float64) { f.State.Speed = int(v) },
+	}))
+
+	assert.NoError(t, f.State.Gaits.Register(hexapod.Gait{Name: "wave"}))
+	assert.NoError(t, f.State.Gaits.Register(hexapod.Gait{Name: "tripod", MinClearance: 30}))
+
+	f.c = New(nil, nil, config.Default().Controller, f.r)
+	return f
+}
+
+// run handles each line of the script, ticks (applying the param writes at the
+// start, like the loop), and returns the replies.
+func (f *fixture) run(script string) []string {
+	for _, line := range strings.Split(script, "\n") {
+		f.c.handle(f.s, line)
+	}
+
+	f.r.Apply()
+	f.Tick(f.c)
+	f.r.Apply()
+
+	var out []string
+	for {
+		select {
+		case r := <-f.s.replies:
+			out = append(out, r)
+		default:
+			return out
+		}
+	}
+}
+
+func TestParams(t *testing.T) {
+	f := setup(t)
+	replies := f.run(`
+		clearance 60
+		speed 3
+		param set controller.clearance 65
+		param get hexapod.speed
+	`)
+
+	// Applied in order, so the later clearance wins.
+	assert.Equal(t, []string{
+		"controller.clearance = 60",
+		"hexapod.speed = 3",
+		"controller.clearance = 65",
+		"hexapod.speed = 3",
+	}, replies)
+	assert.Equal(t, 65.0, f.clearance)
+	assert.Equal(t, 3, f.State.Speed)
+
+	assert.Equal(t, []string{"hexapod.speed = 3"}, f.run("param get hexapod.speed"))
+}
+
+func TestSitStand(t *testing.T) {
+	f := setup(t)
+	f.run("sit")
+	assert.Equal(t, 0.0, f.clearance)
+
+	f.clearance = 80
+	f.run("stand")
+	assert.Equal(t, config.Default().Controller.Clearance, f.clearance)
+}
+
+func TestInvalidParams(t *testing.T) {
+	f := setup(t)
+	replies := f.run(`
+		clearance 500
+		speed 2.5
+		param set legs.stepheight 35
+		param get controller.deadzone
+	`)
+
+	// The registry rejects them, so nothing changes.
+	assert.Equal(t, []string{
+		"error: controller.clearance must be between 0 and 120, got 500",
+		"error: hexapod.speed must be an integer, got 2.5",
+		"error: no such param: legs.stepheight",
+		"error: no such param: controller.deadzone",
+	}, replies)
+	assert.Equal(t, 40.0, f.clearance)
+	assert.Equal(t, 0, f.State.Speed)
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, tc := range []struct {
+		line string
+		err  string
+	}{
+		{"jump", "unknown command: jump"},
+		{"clearance", "clearance takes one value"},
+		{"clearance high", `invalid clearance: "high"`},
+		{"speed 1 2", "speed takes one value"},
+		{"gait", "gait takes one name"},
+		{"estop now", "estop takes nothing, or off"},
+		{"sit down", "sit takes nothing"},
+		{"transport now", "transport takes nothing"},
+		{"param", "param takes set or get"},
+		{"param list", "param takes set or get, not list"},
+		{"param set hexapod.speed", "param set takes a name and a value"},
+		{"param set hexapod.speed fast", `invalid value: "fast"`},
+		{"param get", "param get takes a name"},
+		{"legs stiffness", "legs takes stiffness and a preset"},
+		{"legs height 10", "legs takes stiffness and a preset"},
+		{"odometer reset", "odometer takes reset and a counter"},
+		{"odometer show distance", "odometer takes reset and a counter"},
+		{"fault sag", "fault takes a name and a value"},
+		{"fault sag lots", `invalid value: "lots"`},
+	} {
+		t.Run(tc.line, func(t *testing.T) {
+			f := setup(t)
+			before := *f.State
+
+			// The usage is printed straight away, without waiting for a tick,
+			// and nothing is queued.
+			f.c.handle(f.s, tc.line)
+			assert.Equal(t, "error: "+tc.err+"\n"+usage, <-f.s.replies)
+			assert.Empty(t, f.c.pending)
+
+			assert.Empty(t, f.run(""))
+			assert.Equal(t, before, *f.State)
+			assert.Equal(t, 40.0, f.clearance)
+		})
+	}
+}
+
+func TestHelp(t *testing.T) {
+	f := setup(t)
+	assert.Equal(t, []string{usage}, f.run("help\n\n   "))
+}
+
+func TestGait(t *testing.T) {
+	f := setup(t)
+	assert.Equal(t, []string{"gait = tripod"}, f.run("gait tripod"))
+	g, _ := f.State.ActiveGait()
+	assert.Equal(t, "tripod", g.Name)
+	assert.Equal(t, 1, f.State.GaitIndex)
+
+	var events []string
+	for _, e := range f.State.Published() {
+		events = append(events, e.Name)
+	}
+	assert.Equal(t, []string{hexapod.EventGaitChanged}, events)
+
+	assert.Equal(t, []string{"error: no such gait: ripple (gaits are: tripod, wave)"}, f.run("gait ripple"))
+}
+
+func TestGaitStep(t *testing.T) {
+	f := setup(t)
+
+	// Not unless the legs say that it's enabled.
+	assert.Equal(t, []string{"error: can't use gait pause, since gait.debug isn't set"}, f.run("gait pause"))
+	assert.Equal(t, hexapod.GaitStepNone, f.State.GaitStep)
+
+	f.State.GaitDebug = hexapod.GaitDebug{Enabled: true, Tick: 14, Length: 60, Phase: 0}
+	for _, tc := range []struct {
+		line string
+		want hexapod.GaitStepRequest
+	}{
+		{"gait pause", hexapod.GaitPause},
+		{"gait step-phase", hexapod.GaitStepPhase},
+		{"gait step-cycle", hexapod.GaitStepCycle},
+		{"gait resume", hexapod.GaitResume},
+	} {
+		assert.Equal(t, []string{tc.line + ", from tick 14 of 60 (phase 0)"}, f.run(tc.line))
+		assert.Equal(t, tc.want, f.State.GaitStep)
+	}
+
+	// The gait itself is left alone.
+	assert.Equal(t, 0, f.State.GaitIndex)
+	assert.Empty(t, f.State.Published())
+}
+
+func TestStiffness(t *testing.T) {
+	f := setup(t)
+	assert.Equal(t, []string{"stiffness = soft"}, f.run("legs stiffness soft"))
+	assert.Equal(t, "soft", f.State.SetStiffness)
+
+	f.State.SetStiffness = ""
+	assert.Equal(t, []string{"error: no such stiffness preset: squishy (presets are: soft, normal, stiff)"}, f.run("legs stiffness squishy"))
+	assert.Empty(t, f.State.SetStiffness)
+}
+
+func TestResetCounter(t *testing.T) {
+	f := setup(t)
+	assert.Equal(t, []string{"reset steps.FL"}, f.run("odometer reset steps.FL"))
+	assert.Equal(t, "steps.FL", f.State.ResetCounter)
+
+	f.State.ResetCounter = ""
+	assert.Equal(t, []string{"error: no such counter: steps.XX (counters are: distance, steps, torque, bus_errors, steps.FL, steps.FR, steps.MR, steps.BR, steps.BL, steps.ML)"}, f.run("odometer reset steps.XX"))
+	assert.Empty(t, f.State.ResetCounter)
+}
+
+func TestFault(t *testing.T) {
+	f := setup(t)
+	sag := 0.0
+	assert.NoError(t, f.r.Register(params.Param{
+		Name: "sim.sag",
+		Type: params.Float,
+		Min:  0,
+		Max:  12,
+		Get:  func() float64 { return sag },
+		Set:  func(v float64) { sag = v },
+	}))
+
+	assert.Equal(t, []string{"sim.sag = 1.5"}, f.run("fault sag 1.5"))
+	assert.Equal(t, 1.5, sag)
+
+	// Faults are only params while simulating, so there's nothing else to
+	// check that the name is one.
+	assert.Equal(t, []string{"error: no such param: sim.wobble"}, f.run("fault wobble 1"))
+}
+
+func TestGaitObeysController(t *testing.T) {
+	f := setup(t)
+
+	// Like the controller, the gait isn't selected without enough clearance,
+	// even if it was only just lowered.
+	replies := f.run("clearance 20\ngait tripod")
+	assert.Equal(t, []string{
+		"controller.clearance = 20",
+		"error: gait tripod needs 30mm clearance, but the clearance is 20mm",
+	}, replies)
+
+	// Or with too much, for a gait which is only for walking low.
+	assert.NoError(t, f.State.Gaits.Register(hexapod.Gait{Name: "crouch", MaxClearance: 15}))
+	assert.Equal(t, []string{"error: gait crouch needs at most 15mm clearance, but the clearance is 20mm"}, f.run("gait crouch"))
+
+	// Or while the legs are busy.
+	f.State.Calibrating = true
+	assert.Equal(t, []string{"error: can't change the gait while calibrating or self-testing"}, f.run("gait wave"))
+
+	g, _ := f.State.ActiveGait()
+	assert.Equal(t, "wave", g.Name)
+	assert.Empty(t, f.State.Published())
+}
+
+func TestEstop(t *testing.T) {
+	f := setup(t)
+	assert.Equal(t, []string{"halted"}, f.run("estop"))
+	assert.True(t, f.State.Halt)
+
+	assert.Equal(t, []string{"resumed"}, f.run("estop off"))
+	assert.False(t, f.State.Halt)
+}
+
+func TestTransport(t *testing.T) {
+	f := setup(t)
+	assert.Equal(t, []string{"folding for transport; hold circle + square to wake"}, f.run("transport"))
+	assert.True(t, f.State.StartTransport)
+}
+
+func TestStatus(t *testing.T) {
+	f := setup(t)
+	f.State.FPS = 60
+	f.State.Halt = true
+	f.State.StartupHold = true
+	f.State.Startup = hexapod.SituationOnSide
+	f.State.Transport = hexapod.TransportWaking
+	f.State.Voltage = 11.8
+	f.State.Pose.Position.Z = 120
+	f.State.Pose.Position.Y = 40
+	f.State.Stiffness = "normal"
+	f.State.AutoGait.Enabled = true
+	f.State.Derate = hexapod.Derate{Active: true, Factor: 0.8, Battery: 0.8, Thermal: 1}
+
+	assert.Equal(t, []string{"" +
+		"status:  halted, won't stand up (on its side), transport (waking)\n" +
+		"pose:    x=0 z=120 y=40 heading=0 (drift 0mm)\n" +
+		"target:  x=0 z=0 y=0 heading=0\n" +
+		"gait:    wave (auto), speed 0, clearance 40mm, profile none\n" +
+		"battery: 11.80V, 0.0A, 0mAh drawn, speed limited to 80%\n" +
+		"servos:  0C, stiffness normal\n" +
+		"nav:     idle\n" +
+		"system:  60fps, cpu 0%, 0C",
+	}, f.run("status"))
+}
+
+func TestSocket(t *testing.T) {
+	f := setup(t)
+	path := filepath.Join(t.TempDir(), "console.sock")
+	f.c = Listen(path, config.Default().Controller, f.r)
+	assert.NoError(t, f.c.Boot())
+	defer f.c.Shutdown()
+
+	conn, err := net.Dial("unix", path)
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	_, err = conn.Write([]byte("speed 2\nestop\n"))
+	assert.NoError(t, err)
+
+	// Tick until both have been applied.
+	for i := 0; i < 100 && !f.State.Halt; i++ {
+		f.r.Apply()
+		assert.NoError(t, f.c.Tick(time.Now(), f.State))
+		time.Sleep(10 * time.Millisecond)
+	}
+	f.r.Apply()
+
+	r := bufio.NewReader(conn)
+	for _, want := range []string{"hexapod.speed = 2\n", "halted\n"} {
+		line, err := r.ReadString('\n')
+		assert.NoError(t, err)
+		assert.Equal(t, want, line)
+	}
+
+	assert.True(t, f.State.Halt)
+	assert.Equal(t, 2, f.State.Speed)
+}
@@ -0,0 +1,479 @@
+package controller
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/adammck/hexapod"
+)
+
+// when is what a button (or chord) has to do to run an action.
+type when int
+
+const (
+
+	// The action runs when the chord is pressed, once per press, like Latch.
+	onPress when = iota
+
+	// The action runs when the chord is tapped, double tapped, or held, like
+	// Tapper. A tap is reported a little late, after the double tap window.
+	onTap
+	onDoubleTap
+	onHold
+
+	// The action runs every tick, with whether the chord is held.
+	whileHeld
+)
+
+// action is something which a button (or chord) does, by name, so the buttons
+// can be remapped. See config.Controller.Buttons.
+type action struct {
+	name string
+	on   when
+
+	// The button (or chord) which the action is bound to, unless the config
+	// says otherwise. Empty if it's unbound by default.
+	chord string
+
+	// Whether the action still runs while calibrating or self-testing, when
+	// the buttons are otherwise ignored (or drive the calibration wizard).
+	always bool
+
+	// Does the action. For whileHeld, this is called every tick, with whether
+	// the chord is held; otherwise only when it's triggered, with true.
+	run func(c *Controller, now time.Time, state *hexapod.State, on bool)
+}
+
+// actions are every action, in the order they're run when more than one is
+// triggered in a tick. Actions bound to the same chord must be run by uses of
+// it which can be told apart (see conflicts), and a chord doesn't count as held
+// while a bigger one which contains it is, so e.g. pressing select + up doesn't
+// raise the clearance as well as switching the stiffness.
+var actions = [...]action{
+
+	// At any time, pressing start shuts down the hex.
+	{"shutdown", onPress, "start", true, func(c *Controller, now time.Time, state *hexapod.State, _ bool) {
+		if !state.Shutdown {
+			state.Shutdown = true
+			state.Publish(hexapod.EventShutdownRequested, hexapod.Warning, "pressed START")
+		}
+	}},
+
+	// Hold the inspection pose by holding triangle, or toggle it by pressing
+	// triangle. It's abandoned if a leg can't reach, since the pose is at the
+	// edge of their range.
+	{"inspect", whileHeld, "triangle", false, func(c *Controller, now time.Time, state *hexapod.State, on bool) {
+		c.inspect.button(now, on, func() string {
+			return refuseInspection(state, c.moving)
+		})
+	}},
+
+	// Toggle the posing mode by pressing L1 + left while standing still.
+	{"posing", onPress, "l1+left", false, func(c *Controller, now time.Time, state *hexapod.State, _ bool) {
+		c.posing.toggle(state, func() string {
+			return refusePosing(state, c.moving, c.inspect.active())
+		})
+	}},
+
+	// Toggle the tuning mode by holding cross while standing still. It locks
+	// out everything else (but shutting down) until it's held again.
+	{"tuning", onHold, "cross", false, func(c *Controller, now time.Time, state *hexapod.State, _ bool) {
+		c.toggleTuning(state, func() string {
+			return refuseTuning(c.moving, c.posing.active(), c.inspect.active())
+		})
+	}},
+
+	// Toggle target orientation mode by pressing PS. The head nods, so it's
+	// obvious that something happened.
+	{"orientation", onPress, "ps", false, func(c *Controller, now time.Time, state *hexapod.State, _ bool) {
+		c.setTargetOrientation = !c.setTargetOrientation
+		log.Infof("setTargetOrientation=%v", c.setTargetOrientation)
+		state.Gesture = hexapod.GestureNod
+	}},
+
+	// Change the clearance by pressing up or down, which cancels any duck.
+	{"clearance_up", onPress, "up", false, func(c *Controller, now time.Time, state *hexapod.State, _ bool) {
+		c.duck.cancel()
+		c.setClearance(state, math.Min(c.clearance+c.clearanceStep, c.maxClearance))
+	}},
+	{"clearance_down", onPress, "down", false, func(c *Controller, now time.Time, state *hexapod.State, _ bool) {
+		c.duck.cancel()
+		c.setClearance(state, math.Max(c.clearance-c.clearanceStep, c.minClearance))
+	}},
+
+	// Change the speed by pressing right or left.
+	{"speed_up", onPress, "right", false, func(c *Controller, now time.Time, state *hexapod.State, _ bool) {
+		state.Speed += 1
+		state.Publish(hexapod.EventSpeedChanged, hexapod.Info, state.Speed)
+	}},
+	{"speed_down", onPress, "left", false, func(c *Controller, now time.Time, state *hexapod.State, _ bool) {
+		state.Speed -= 1
+		state.Publish(hexapod.EventSpeedChanged, hexapod.Info, state.Speed)
+	}},
+
+	// Cycle through gaits by pressing select + triangle (or backwards, if
+	// that's bound), which disables the auto gait mode, and toggle that by
+	// pressing select + right.
+	{"next_gait", onPress, "select+triangle", false, func(c *Controller, now time.Time, state *hexapod.State, _ bool) {
+		c.disableAutoGait(state, "selected a gait by hand")
+		c.nextGait(state, state.NextGait)
+	}},
+	{"previous_gait", onPress, "", false, func(c *Controller, now time.Time, state *hexapod.State, _ bool) {
+		c.disableAutoGait(state, "selected a gait by hand")
+		c.nextGait(state, state.PreviousGait)
+	}},
+	{"auto_gait", onPress, "select+right", false, func(c *Controller, now time.Time, state *hexapod.State, _ bool) {
+		c.toggleAutoGait(state)
+	}},
+
+	// Dump the flight recorder (and write the session summary) by pressing
+	// select + square.
+	{"dump", onPress, "select+square", false, func(c *Controller, now time.Time, state *hexapod.State, _ bool) {
+		state.Dump = true
+		log.Info("requested flight recorder dump")
+	}},
+
+	// Cycle through profiles by pressing select + down.
+	{"next_profile", onPress, "select+down", false, func(c *Controller, now time.Time, state *hexapod.State, _ bool) {
+		state.NextProfile = true
+		log.Info("requested next profile")
+	}},
+
+	// Cycle through stiffness presets by pressing select + up. The legs switch
+	// once every foot is down.
+	{"next_stiffness", onPress, "select+up", false, func(c *Controller, now time.Time, state *hexapod.State, _ bool) {
+		state.SetStiffness = nextStiffness(state.Stiffness)
+		log.Infof("requested stiffness=%s", state.SetStiffness)
+	}},
+
+	// Start the calibration wizard by pressing select + circle while parked.
+	{"calibrate", onPress, "select+circle", false, func(c *Controller, now time.Time, state *hexapod.State, _ bool) {
+		state.Calibration = hexapod.CalibrationStart
+		log.Info("requested calibration")
+	}},
+
+	// Run the self-test by pressing select + R1 while parked.
+	{"selftest", onPress, "select+r1", false, func(c *Controller, now time.Time, state *hexapod.State, _ bool) {
+		state.StartSelfTest = true
+		log.Info("requested self-test")
+	}},
+
+	// Roll the hex back over by holding select + PS once it has fallen onto
+	// its back, which is deliberate, since it's rough on the legs.
+	{"righting", onHold, "select+ps", false, func(c *Controller, now time.Time, state *hexapod.State, _ bool) {
+		state.StartRighting = true
+		log.Info("requested righting")
+	}},
+
+	// Fold the legs up for transport by holding select + down, which is
+	// deliberate, since the hex can't move again until it's woken up.
+	{"transport", onHold, "select+down", false, func(c *Controller, now time.Time, state *hexapod.State, _ bool) {
+		state.StartTransport = true
+		log.Info("requested transport")
+	}},
+
+	// Stand up anyway, if the startup check says that the hex isn't on its
+	// feet, by double tapping select + PS. It's the operator's call, e.g. if
+	// the IMU is wrong.
+	{"stand_anyway", onDoubleTap, "select+ps", false, func(c *Controller, now time.Time, state *hexapod.State, _ bool) {
+		state.StandAnyway = true
+		log.Info("requested standing up anyway")
+	}},
+
+	// Step the feet back under the body, one at a time, by tapping select + PS
+	// while parked, after moving the body around on them for a while.
+	{"recentre", onTap, "select+ps", false, func(c *Controller, now time.Time, state *hexapod.State, _ bool) {
+		state.Recentre = true
+		log.Info("requested stance re-centring")
+	}},
+
+	// Walk the canned route by tapping select + cross, set the home pose by
+	// holding it, and return there by double tapping it.
+	{"route", onTap, "select+cross", false, func(c *Controller, now time.Time, state *hexapod.State, _ bool) {
+		state.StartRoute = true
+		log.Info("requested route")
+	}},
+	{"home_set", onHold, "select+cross", false, func(c *Controller, now time.Time, state *hexapod.State, _ bool) {
+		state.Home = hexapod.HomeSet
+		log.Info("requested home set")
+	}},
+	{"home_return", onDoubleTap, "select+cross", false, func(c *Controller, now time.Time, state *hexapod.State, _ bool) {
+		state.Home = hexapod.HomeReturn
+		log.Info("requested return home")
+	}},
+
+	// Set the tempo to step in time with by tapping select + L1 to the beat,
+	// and clear it by holding it.
+	{"tempo_tap", onPress, "select+l1", false, func(c *Controller, now time.Time, state *hexapod.State, _ bool) {
+		if bpm, ok := c.tempo.Tap(now); ok {
+			c.setTempo(bpm)
+		}
+	}},
+	{"tempo_clear", onHold, "select+l1", false, func(c *Controller, now time.Time, state *hexapod.State, _ bool) {
+		c.tempo = Tempo{}
+		c.setTempo(0)
+	}},
+}
+
+// tuningAction is the index of the action which toggles the tuning mode, which
+// is the only one (which doesn't always run) not locked out while tuning.
+var tuningAction = actionIndex("tuning")
+
+// buttonNames are the names of the buttons which can be bound, in the order
+// they're written in a chord.
+var buttonNames = [...]struct {
+	name string
+	bit  uint16
+}{
+	{"select", hexapod.ButtonSelect},
+	{"start", hexapod.ButtonStart},
+	{"ps", hexapod.ButtonPS},
+	{"up", hexapod.ButtonUp},
+	{"down", hexapod.ButtonDown},
+	{"left", hexapod.ButtonLeft},
+	{"right", hexapod.ButtonRight},
+	{"l1", hexapod.ButtonL1},
+	{"r1", hexapod.ButtonR1},
+	{"triangle", hexapod.ButtonTriangle},
+	{"circle", hexapod.ButtonCircle},
+	{"cross", hexapod.ButtonCross},
+	{"square", hexapod.ButtonSquare},
+}
+
+// binding is a chord which at least one action is bound to, and what it did on
+// the last tick. Actions bound to the same chord share it, so e.g. a tap and
+// a hold of it are told apart by the same Tapper.
+type binding struct {
+	chord uint16
+
+	// The chords of the other bindings which contain this one.
+	bigger []uint16
+
+	latch  Latch
+	tapper Tapper
+
+	held    bool
+	pressed bool
+	gesture Gesture
+}
+
+// bindings are the chords which the actions are bound to.
+type bindings struct {
+	chords []binding
+
+	// The index (in chords) of the one which each action is bound to, or -1
+	// if it's unbound.
+	bound []int
+}
+
+// bind returns the bindings of the default map, overridden by the given one
+// (from action name to chord), or an error if it names an action or button
+// which doesn't exist, or binds actions to the same chord which can't be told
+// apart.
+func bind(overrides map[string]string) (*bindings, error) {
+	chords := make([]string, len(actions))
+	for i, a := range actions {
+		chords[i] = a.chord
+	}
+
+	// Sorted, so the first error is always the same one.
+	names := make([]string, 0, len(overrides))
+	for name := range overrides {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		i := actionIndex(name)
+		if i < 0 {
+			return nil, fmt.Errorf("unknown action %q in controller.buttons (valid actions: %s)", name, strings.Join(actionNames(), ", "))
+		}
+
+		chords[i] = overrides[name]
+	}
+
+	b := &bindings{bound: make([]int, len(actions))}
+	masks := make([]uint16, len(actions))
+	for i := range actions {
+		b.bound[i] = -1
+
+		m, err := parseChord(chords[i])
+		if err != nil {
+			return nil, fmt.Errorf("%s (in controller.buttons.%s)", err, actions[i].name)
+		}
+		if m == 0 {
+			continue
+		}
+
+		masks[i] = m
+		for j := 0; j < i; j++ {
+			if masks[j] == m && conflicts(actions[i].on, actions[j].on) {
+				return nil, fmt.Errorf("controller.buttons.%s and controller.buttons.%s are both bound to %s, and can't be told apart", actions[j].name, actions[i].name, chordName(m))
+			}
+
+			if masks[j] == m {
+				b.bound[i] = b.bound[j]
+			}
+		}
+
+		if b.bound[i] < 0 {
+			b.bound[i] = len(b.chords)
+			b.chords = append(b.chords, binding{chord: m})
+		}
+	}
+
+	for i := range b.chords {
+		for j := range b.chords {
+			big, small := b.chords[j].chord, b.chords[i].chord
+			if big != small && big&small == small {
+				b.chords[i].bigger = append(b.chords[i].bigger, big)
+			}
+		}
+	}
+
+	return b, nil
+}
+
+// conflicts returns true if actions run at the given times can't be bound to
+// the same chord, because the same use of it would trigger both. A hold can be
+// told apart from anything but holding on, and a tap from a double tap.
+func conflicts(a, b when) bool {
+	switch {
+	case a == whileHeld || b == whileHeld:
+		return true
+	case a == onHold || b == onHold:
+		return a == b
+	case a == onPress || b == onPress:
+		return true
+	}
+
+	return a == b
+}
+
+// run updates what each chord did, given the buttons which are pressed (see
+// hexapod.Input.Buttons).
+func (b *bindings) run(now time.Time, pressed uint16) {
+	for i := range b.chords {
+		ch := &b.chords[i]
+		ch.held = pressed&ch.chord == ch.chord
+		for _, big := range ch.bigger {
+			if pressed&big == big {
+				ch.held = false
+			}
+		}
+
+		ch.pressed = ch.latch.Run(ch.held)
+		ch.gesture = ch.tapper.Run(now, ch.held)
+	}
+}
+
+// dispatch runs every action which was triggered on this tick (see run), and
+// every bound whileHeld action, in order. Only those which always run are run
+// if always is true, and only the others otherwise. While tuning, the others
+// are locked out, apart from toggling the tuning mode, and the whileHeld ones
+// are run as if released.
+func (c *Controller) dispatch(now time.Time, state *hexapod.State, always bool) {
+	for i := range actions {
+		a := &actions[i]
+		if a.always != always {
+			continue
+		}
+
+		if !always && c.tuning.active && i != tuningAction {
+			if a.on == whileHeld {
+				a.run(c, now, state, false)
+			}
+			continue
+		}
+
+		j := c.buttons.bound[i]
+		if j < 0 {
+			if a.on == whileHeld {
+				a.run(c, now, state, false)
+			}
+			continue
+		}
+
+		ch := &c.buttons.chords[j]
+		switch {
+		case a.on == whileHeld:
+			a.run(c, now, state, ch.held)
+		case a.on == onPress && ch.pressed,
+			a.on == onTap && ch.gesture == Tap,
+			a.on == onDoubleTap && ch.gesture == DoubleTap,
+			a.on == onHold && ch.gesture == Hold:
+			a.run(c, now, state, true)
+		}
+	}
+}
+
+// parseChord returns the bits (see hexapod.Input.Buttons) of the buttons in the
+// given chord, which are joined by "+", or zero if it's empty.
+func parseChord(s string) (uint16, error) {
+	if s == "" {
+		return 0, nil
+	}
+
+	var m uint16
+	for _, part := range strings.Split(s, "+") {
+		bit := buttonBit(strings.TrimSpace(part))
+		if bit == 0 {
+			valid := make([]string, len(buttonNames))
+			for i, b := range buttonNames {
+				valid[i] = b.name
+			}
+
+			return 0, fmt.Errorf("unknown button %q in %q (valid buttons: %s)", part, s, strings.Join(valid, ", "))
+		}
+
+		m |= bit
+	}
+
+	return m, nil
+}
+
+// chordName returns the given bits as a chord, like "select+up".
+func chordName(m uint16) string {
+	var parts []string
+	for _, b := range buttonNames {
+		if m&b.bit != 0 {
+			parts = append(parts, b.name)
+		}
+	}
+
+	return strings.Join(parts, "+")
+}
+
+func buttonBit(name string) uint16 {
+	for _, b := range buttonNames {
+		if b.name == name {
+			return b.bit
+		}
+	}
+
+	return 0
+}
+
+func actionIndex(name string) int {
+	for i, a := range actions {
+		if a.name == name {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// actionNames returns the names of every action, sorted.
+func actionNames() []string {
+	out := make([]string, len(actions))
+	for i, a := range actions {
+		out[i] = a.name
+	}
+
+	sort.Strings(out)
+	return out
+}
@@ -0,0 +1,229 @@
+package controller
+
+import (
+	"testing"
+
+	"github.com/adammck/hexapod"
+	"github.com/adammck/hexapod/config"
+	"github.com/adammck/sixaxis"
+	"github.com/stretchr/testify/assert"
+)
+
+// boundTo returns the chord which the given action is bound to, or nil.
+func boundTo(c *Controller, name string) *binding {
+	i := c.buttons.bound[actionIndex(name)]
+	if i < 0 {
+		return nil
+	}
+
+	return &c.buttons.chords[i]
+}
+
+func TestDefaultButtons(t *testing.T) {
+	c := NewScripted(sixaxis.New(nil), config.Default().Controller, config.Default().Head)
+	assert.NoError(t, c.buttonsErr)
+
+	for name, chord := range map[string]uint16{
+		"shutdown":       hexapod.ButtonStart,
+		"inspect":        hexapod.ButtonTriangle,
+		"tuning":         hexapod.ButtonCross,
+		"orientation":    hexapod.ButtonPS,
+		"posing":         hexapod.ButtonL1 | hexapod.ButtonLeft,
+		"clearance_up":   hexapod.ButtonUp,
+		"clearance_down": hexapod.ButtonDown,
+		"speed_up":       hexapod.ButtonRight,
+		"speed_down":     hexapod.ButtonLeft,
+		"next_gait":      hexapod.ButtonSelect | hexapod.ButtonTriangle,
+		"auto_gait":      hexapod.ButtonSelect | hexapod.ButtonRight,
+		"dump":           hexapod.ButtonSelect | hexapod.ButtonSquare,
+		"next_profile":   hexapod.ButtonSelect | hexapod.ButtonDown,
+		"next_stiffness": hexapod.ButtonSelect | hexapod.ButtonUp,
+		"calibrate":      hexapod.ButtonSelect | hexapod.ButtonCircle,
+		"selftest":       hexapod.ButtonSelect | hexapod.ButtonR1,
+		"transport":      hexapod.ButtonSelect | hexapod.ButtonDown,
+		"righting":       hexapod.ButtonSelect | hexapod.ButtonPS,
+		"recentre":       hexapod.ButtonSelect | hexapod.ButtonPS,
+		"stand_anyway":   hexapod.ButtonSelect | hexapod.ButtonPS,
+		"route":          hexapod.ButtonSelect | hexapod.ButtonCross,
+		"home_set":       hexapod.ButtonSelect | hexapod.ButtonCross,
+		"home_return":    hexapod.ButtonSelect | hexapod.ButtonCross,
+		"tempo_tap":      hexapod.ButtonSelect | hexapod.ButtonL1,
+		"tempo_clear":    hexapod.ButtonSelect | hexapod.ButtonL1,
+	} {
+		if b := boundTo(c, name); assert.NotNil(t, b, name) {
+			assert.Equal(t, chordName(chord), chordName(b.chord), name)
+		}
+	}
+
+	assert.Nil(t, boundTo(c, "previous_gait"))
+
+	// Actions bound to the same chord share it.
+	assert.Same(t, boundTo(c, "route"), boundTo(c, "home_set"))
+}
+
+func TestBindErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		buttons map[string]string
+		err     string
+	}{
+		{
+			name:    "unknown action",
+			buttons: map[string]string{"jump": "cross"},
+			err:     `unknown action "jump" in controller.buttons (valid actions: auto_gait, calibrate, clearance_down, clearance_up, dump, home_return, home_set, inspect, next_gait, next_profile, next_stiffness, orientation, posing, previous_gait, recentre, righting, route, selftest, shutdown, speed_down, speed_up, stand_anyway, tempo_clear, tempo_tap, transport, tuning)`,
+		},
+		{
+			name:    "unknown button",
+			buttons: map[string]string{"dump": "select+r3"},
+			err:     `unknown button "r3" in "select+r3" (valid buttons: select, start, ps, up, down, left, right, l1, r1, triangle, circle, cross, square) (in controller.buttons.dump)`,
+		},
+		{
+			name:    "empty button",
+			buttons: map[string]string{"dump": "select+"},
+			err:     `unknown button "" in "select+"`,
+		},
+		{
+			name:    "two presses of the same chord",
+			buttons: map[string]string{"dump": "select+up"},
+			err:     "controller.buttons.dump and controller.buttons.next_stiffness are both bound to select+up, and can't be told apart",
+		},
+		{
+			name:    "a press and a tap of the same chord",
+			buttons: map[string]string{"route": "select+l1"},
+			err:     "controller.buttons.route and controller.buttons.tempo_tap are both bound to select+l1",
+		},
+		{
+			name:    "two holds of the same chord",
+			buttons: map[string]string{"home_set": "select+l1"},
+			err:     "controller.buttons.home_set and controller.buttons.tempo_clear are both bound to select+l1",
+		},
+		{
+			name:    "holding on to the same chord as a press",
+			buttons: map[string]string{"inspect": "ps"},
+			err:     "controller.buttons.inspect and controller.buttons.orientation are both bound to ps",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := config.Default().Controller
+			cfg.Buttons = tc.buttons
+			c := NewScripted(sixaxis.New(nil), cfg, config.Default().Head)
+			if err := c.Boot(); assert.Error(t, err) {
+				assert.Contains(t, err.Error(), tc.err)
+			}
+		})
+	}
+}
+
+func TestBindOverrides(t *testing.T) {
+
+	// Moving an action frees its old chord, an empty chord unbinds one, and
+	// the buttons in a chord can be in any order.
+	b, err := bind(map[string]string{
+		"next_stiffness": "square",
+		"clearance_up":   "select+up",
+		"dump":           "",
+		"previous_gait":  "triangle+select",
+		"next_gait":      "select+l1+triangle",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, -1, b.bound[actionIndex("dump")])
+
+	chord := func(name string) string {
+		return chordName(b.chords[b.bound[actionIndex(name)]].chord)
+	}
+	assert.Equal(t, "square", chord("next_stiffness"))
+	assert.Equal(t, "select+up", chord("clearance_up"))
+	assert.Equal(t, "select+triangle", chord("previous_gait"))
+	assert.Equal(t, "select+l1+triangle", chord("next_gait"))
+}
+
+// remappedCases are tick cases with a button map other than the default.
+var remappedCases = []tickCase{
+	{
+		name:    "a remapped action is triggered by its new chord",
+		buttons: map[string]string{"dump": "square"},
+		ticks:   []input{func(sa *sixaxis.SA) { sa.Square = 255 }},
+		want: func(s *hexapod.State) {
+			s.Input = hexapod.Input{Buttons: hexapod.ButtonSquare}
+			s.Dump = true
+			s.LookAt = &ahead
+		},
+	},
+	{
+		name:    "a remapped action isn't triggered by its old chord",
+		buttons: map[string]string{"dump": "circle"},
+		ticks:   []input{func(sa *sixaxis.SA) { sa.Select = true; sa.Square = 255 }},
+		want: func(s *hexapod.State) {
+			s.Input = hexapod.Input{Buttons: hexapod.ButtonSelect | hexapod.ButtonSquare}
+			s.LookAt = &ahead
+		},
+	},
+	{
+		name:    "an unbound action isn't triggered",
+		buttons: map[string]string{"shutdown": ""},
+		ticks:   []input{func(sa *sixaxis.SA) { sa.Start = true }},
+		want: func(s *hexapod.State) {
+			s.Input = hexapod.Input{Buttons: hexapod.ButtonStart}
+			s.LookAt = &ahead
+		},
+	},
+	{
+		name:    "swapped actions are triggered by each other's chords",
+		buttons: map[string]string{"speed_up": "left", "speed_down": "right"},
+		prior:   func(s *hexapod.State) { s.Speed = 2 },
+		ticks:   append(repeat(3, func(sa *sixaxis.SA) { sa.Left = 255 }, release), func(sa *sixaxis.SA) { sa.Right = 255 }),
+		want: func(s *hexapod.State) {
+			s.Input = hexapod.Input{Buttons: hexapod.ButtonRight}
+			s.Speed = 4
+			s.LookAt = &ahead
+		},
+		events: []string{hexapod.EventSpeedChanged, hexapod.EventSpeedChanged, hexapod.EventSpeedChanged, hexapod.EventSpeedChanged},
+	},
+	{
+		name:    "the previous gait can be bound",
+		buttons: map[string]string{"previous_gait": "select+left"},
+		ticks:   []input{func(sa *sixaxis.SA) { sa.Select = true; sa.Left = 255 }},
+		want: func(s *hexapod.State) {
+			s.Input = hexapod.Input{Buttons: hexapod.ButtonSelect | hexapod.ButtonLeft}
+			s.Gait = trot
+			s.GaitIndex = 1
+			s.LookAt = &ahead
+		},
+		events: []string{hexapod.EventGaitChanged},
+	},
+	{
+		name:    "a chord isn't held while a bigger one is",
+		buttons: map[string]string{"dump": "square", "next_profile": "square+cross"},
+		ticks:   []input{func(sa *sixaxis.SA) { sa.Square = 255; sa.Cross = 255 }},
+		want: func(s *hexapod.State) {
+			s.Input = hexapod.Input{Buttons: hexapod.ButtonSquare | hexapod.ButtonCross}
+			s.NextProfile = true
+			s.LookAt = &ahead
+		},
+	},
+	{
+		name:    "a remapped action is ignored while calibrating",
+		buttons: map[string]string{"dump": "cross"},
+		prior:   func(s *hexapod.State) { s.Calibrating = true },
+		ticks:   []input{func(sa *sixaxis.SA) { sa.Cross = 255 }},
+		want: func(s *hexapod.State) {
+			s.Input = hexapod.Input{Buttons: hexapod.ButtonCross}
+			s.Calibration = hexapod.CalibrationCapture
+		},
+	},
+	{
+		name:    "a remapped shutdown still works while calibrating",
+		buttons: map[string]string{"shutdown": "select+start"},
+		prior:   func(s *hexapod.State) { s.Calibrating = true },
+		ticks:   []input{func(sa *sixaxis.SA) { sa.Start = true }, func(sa *sixaxis.SA) { sa.Select = true }},
+		want: func(s *hexapod.State) {
+			s.Input = hexapod.Input{Buttons: hexapod.ButtonSelect | hexapod.ButtonStart}
+			s.Shutdown = true
+		},
+		events: []string{hexapod.EventShutdownRequested},
+	},
+}
+
+func TestRemappedTickCases(t *testing.T) {
+	runTickCases(t, remappedCases)
+}
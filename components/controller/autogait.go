@@ -0,0 +1,143 @@
+package controller
+
+import (
+	"github.com/adammck/hexapod"
+	"github.com/adammck/hexapod/components/legs/gait"
+)
+
+// autoGaits are the gaits which the auto gait mode picks between, from the
+// slowest to the fastest. The speed thresholds are between each pair.
+var autoGaits = []string{gait.Wave, gait.Ripple, gait.Tripod}
+
+// autoGait tracks the auto gait mode, which picks the gait from how fast the
+// hex is being told to walk. See config.Controller.AutoGait.
+type autoGait struct {
+	enabled bool
+
+	// The speed as of the last tick, and the gait which it picked, or empty if
+	// nothing has been picked since the mode was enabled. If the active gait
+	// is ever something else, it was selected by something other than this
+	// (e.g. the console), which disables the mode.
+	speed  float64
+	picked string
+}
+
+// status returns the state of the mode, for State.AutoGait.
+func (a autoGait) status() hexapod.AutoGait {
+	if !a.enabled {
+		return hexapod.AutoGait{}
+	}
+
+	return hexapod.AutoGait{Enabled: true, Speed: a.speed, Gait: a.picked}
+}
+
+// toggleAutoGait enables the auto gait mode if it's disabled, or vice versa.
+func (c *Controller) toggleAutoGait(state *hexapod.State) {
+	c.auto = autoGait{enabled: !c.auto.enabled}
+	state.AutoGait = c.auto.status()
+	log.Infof("auto gait=%v", c.auto.enabled)
+	state.Publish(hexapod.EventAutoGaitChanged, hexapod.Info, c.auto.enabled)
+}
+
+// disableAutoGait disables the auto gait mode, if it's enabled, e.g. because a
+// gait was selected by hand.
+func (c *Controller) disableAutoGait(state *hexapod.State, why string) {
+	if !c.auto.enabled {
+		return
+	}
+
+	c.auto = autoGait{}
+	state.AutoGait = c.auto.status()
+	log.Infof("auto gait=false (%s)", why)
+	state.Publish(hexapod.EventAutoGaitChanged, hexapod.Info, false)
+}
+
+// commandedSpeed returns how fast the hex is being told to walk, which is the
+// distance between the pose and the target which was just set from the left
+// stick (like lookScale), i.e. the distance per step cycle. Turning on the spot
+// doesn't count.
+func commandedSpeed(state *hexapod.State) float64 {
+	d := state.Target.Position.Subtract(state.Pose.Position)
+	d.Y = 0
+	return d.Magnitude()
+}
+
+// autoGaitBand returns the index (in autoGaits) of the gait to use at the given
+// speed, given the index of the active one, or -1 if it isn't one of them (in
+// which case there's nothing to stick to). It only steps down once the speed
+// is below the threshold by more than the hysteresis.
+func (c *Controller) autoGaitBand(speed float64, cur int) int {
+	thresholds := []float64{c.cfg.AutoGaitSlow, c.cfg.AutoGaitFast}
+	if cur < 0 {
+		cur = 0
+	}
+
+	for cur < len(thresholds) && speed > thresholds[cur] {
+		cur++
+	}
+
+	for cur > 0 && speed < thresholds[cur-1]-c.cfg.AutoGaitHysteresis {
+		cur--
+	}
+
+	return cur
+}
+
+// updateAutoGait switches to the gait for the commanded speed, if the auto gait
+// mode is enabled. Like selecting a gait by hand, this only changes the state;
+// the legs switch between step cycles. It leaves the gait alone in safe mode,
+// which forces the wave gait, and while crouching, which forces the crouch.
+func (c *Controller) updateAutoGait(state *hexapod.State) {
+	defer func() { state.AutoGait = c.auto.status() }()
+
+	if !c.auto.enabled || c.crouch.low {
+		return
+	}
+
+	// Safe mode switches to the wave gait itself, which isn't a selection.
+	if state.SafeMode {
+		c.auto.picked = ""
+		return
+	}
+
+	cur, _ := state.ActiveGait()
+	if c.auto.picked != "" && cur.Name != c.auto.picked {
+		c.disableAutoGait(state, "gait "+cur.Name+" was selected")
+		return
+	}
+
+	gaits := state.Gaits
+	if gaits == nil {
+		gaits = hexapod.DefaultGaits
+	}
+
+	c.auto.speed = commandedSpeed(state)
+	band := -1
+	for i, name := range autoGaits {
+		if name == cur.Name {
+			band = i
+		}
+	}
+
+	// If the gait for the speed can't be used at the current clearance, try the
+	// slower ones instead, since they step lower.
+	var next hexapod.Gait
+	found := false
+	for i := c.autoGaitBand(c.auto.speed, band); i >= 0 && !found; i-- {
+		next, found = gaits.Get(autoGaits[i])
+		found = found && next.Fits(c.clearance)
+	}
+
+	if !found {
+		return
+	}
+
+	c.auto.picked = next.Name
+	if next.Name == cur.Name {
+		return
+	}
+
+	log.Infof("commanded speed is %.0fmm per step, switching to gait %s (was %s)", c.auto.speed, next, cur)
+	state.SetGait(next)
+	state.Publish(hexapod.EventGaitChanged, hexapod.Info, next)
+}
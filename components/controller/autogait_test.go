@@ -0,0 +1,165 @@
+package controller
+
+import (
+	"testing"
+	"time"
+
+	"github.com/adammck/hexapod"
+	"github.com/adammck/hexapod/components/legs/gait"
+	"github.com/adammck/hexapod/config"
+	"github.com/adammck/sixaxis"
+	"github.com/stretchr/testify/assert"
+)
+
+// autoSetup returns a controller in auto gait mode, and a parked state with the
+// real gaits, walking with the wave.
+func autoSetup(t *testing.T) (*Controller, *hexapod.State) {
+	cfg := config.Default().Controller
+	cfg.AutoGait = true
+	c := NewScripted(sixaxis.New(nil), cfg, config.Default().Head)
+
+	state := parked()
+	state.Gaits = hexapod.DefaultGaits
+	g, ok := state.Gaits.Get(gait.Wave)
+	assert.True(t, ok)
+	state.SetGait(g)
+
+	return c, &state
+}
+
+// commandSpeed sets the target the given distance ahead of the pose, like the
+// left stick would, and returns the gait which the auto gait mode picks.
+func commandSpeed(c *Controller, state *hexapod.State, speed float64) string {
+	state.Target = state.Pose
+	state.Target.Position.Z += speed
+	c.updateAutoGait(state)
+	g, _ := state.ActiveGait()
+	return g.Name
+}
+
+func TestAutoGaitSweep(t *testing.T) {
+	c, state := autoSetup(t)
+	cfg := c.cfg
+
+	// Returns the speeds (from the given list) at which the gait changed, and
+	// what it changed to.
+	sweep := func(speeds []float64) map[float64]string {
+		out := map[float64]string{}
+		prev, _ := state.ActiveGait()
+		for _, v := range speeds {
+			g := commandSpeed(c, state, v)
+			if g != prev.Name {
+				out[v] = g
+			}
+			prev, _ = state.ActiveGait()
+		}
+		return out
+	}
+
+	var up, down []float64
+	for v := 0.0; v <= 100; v++ {
+		up = append(up, v)
+		down = append([]float64{v}, down...)
+	}
+
+	// Going up, each switch is just past the threshold.
+	assert.Equal(t, map[float64]string{
+		cfg.AutoGaitSlow + 1: gait.Ripple,
+		cfg.AutoGaitFast + 1: gait.Tripod,
+	}, sweep(up))
+
+	// Coming back down, only once it's below by more than the hysteresis.
+	assert.Equal(t, map[float64]string{
+		cfg.AutoGaitFast - cfg.AutoGaitHysteresis - 1: gait.Ripple,
+		cfg.AutoGaitSlow - cfg.AutoGaitHysteresis - 1: gait.Wave,
+	}, sweep(down))
+
+	assert.Equal(t, hexapod.AutoGait{Enabled: true, Speed: 0, Gait: gait.Wave}, state.AutoGait)
+}
+
+func TestAutoGaitHysteresis(t *testing.T) {
+	c, state := autoSetup(t)
+
+	// Hovering around the fast threshold doesn't flap.
+	assert.Equal(t, gait.Tripod, commandSpeed(c, state, 80))
+	for _, v := range []float64{74, 76, 70, 78, 66, 80} {
+		assert.Equal(t, gait.Tripod, commandSpeed(c, state, v), "at %v", v)
+	}
+
+	// Nor the slow one.
+	assert.Equal(t, gait.Ripple, commandSpeed(c, state, 50))
+	for _, v := range []float64{39, 41, 31, 45, 30} {
+		assert.Equal(t, gait.Ripple, commandSpeed(c, state, v), "at %v", v)
+	}
+
+	// It can skip the ripple, in either direction.
+	assert.Equal(t, gait.Wave, commandSpeed(c, state, 0))
+	assert.Equal(t, gait.Tripod, commandSpeed(c, state, 90))
+}
+
+func TestAutoGaitClearance(t *testing.T) {
+	c, state := autoSetup(t)
+
+	// The tripod needs more clearance than this, so it's the ripple instead.
+	tripod, _ := state.Gaits.Get(gait.Tripod)
+	c.clearance = tripod.MinClearance - 5
+	assert.Equal(t, gait.Ripple, commandSpeed(c, state, 90))
+	assert.Equal(t, gait.Ripple, state.AutoGait.Gait)
+}
+
+func TestAutoGaitManual(t *testing.T) {
+	c, state := autoSetup(t)
+	assert.Equal(t, gait.Tripod, commandSpeed(c, state, 90))
+
+	// Selecting a gait by hand disables the mode.
+	c.sa.Select = true
+	c.sa.Triangle = 255
+	assert.NoError(t, c.Tick(time.Unix(0, 0), state))
+	assert.False(t, c.auto.enabled)
+	assert.Equal(t, hexapod.AutoGait{}, state.AutoGait)
+	manual, _ := state.ActiveGait()
+	assert.NotEqual(t, gait.Tripod, manual.Name)
+	assert.Equal(t, manual.Name, commandSpeed(c, state, 10))
+
+	// Select + right enables it again.
+	*c.sa = *sixaxis.New(nil)
+	c.sa.Select = true
+	c.sa.Right = 255
+	assert.NoError(t, c.Tick(time.Unix(0, 0), state))
+	assert.True(t, c.auto.enabled)
+	assert.Equal(t, gait.Wave, commandSpeed(c, state, 10))
+}
+
+func TestAutoGaitSelectedElsewhere(t *testing.T) {
+	c, state := autoSetup(t)
+	assert.Equal(t, gait.Tripod, commandSpeed(c, state, 90))
+
+	// Selecting a gait some other way (e.g. via the console) is noticed on the
+	// next tick, which disables the mode.
+	ripple, _ := state.Gaits.Get(gait.Ripple)
+	state.SetGait(ripple)
+	assert.Equal(t, gait.Ripple, commandSpeed(c, state, 90))
+	assert.False(t, c.auto.enabled)
+
+	var names []string
+	for _, e := range state.Published() {
+		names = append(names, e.Name)
+	}
+	assert.Equal(t, []string{hexapod.EventGaitChanged, hexapod.EventAutoGaitChanged}, names)
+}
+
+func TestAutoGaitSafeMode(t *testing.T) {
+	c, state := autoSetup(t)
+	assert.Equal(t, gait.Tripod, commandSpeed(c, state, 90))
+
+	// Safe mode forces the wave, which doesn't count as a selection.
+	wave, _ := state.Gaits.Get(gait.Wave)
+	state.SafeMode = true
+	state.SetGait(wave)
+	assert.Equal(t, gait.Wave, commandSpeed(c, state, 90))
+	assert.True(t, c.auto.enabled)
+
+	state.SafeMode = false
+	assert.Equal(t, gait.Tripod, commandSpeed(c, state, 90))
+	assert.True(t, c.auto.enabled)
+}
@@ -0,0 +1,97 @@
+package controller
+
+import (
+	"math"
+
+	"github.com/adammck/hexapod/math3d"
+)
+
+const (
+
+	// The full travel of the analog sticks (either side of neutral), and of the
+	// triggers, which are pressure-sensitive, like the buttons.
+	maxStick   = 127.0
+	maxTrigger = 255.0
+
+	rotateWithStick = "stick"
+)
+
+// curve shapes a fraction of full travel of a stick or trigger: zero within the
+// deadzone, and then the expo curve from there up to one at full travel. More
+// than one (e.g. in the corners of the range of a stick) is left alone.
+func curve(v, deadzone, expo float64) float64 {
+	if v <= deadzone {
+		return 0
+	}
+
+	if v >= 1 {
+		return v
+	}
+
+	n := (v - deadzone) / (1 - deadzone)
+	return (1-expo)*n + expo*n*n*n
+}
+
+// stick returns the position of an analog stick as a vector on the XZ plane,
+// with each component between -1 and 1 (or a bit more in the corners). Pushing
+// the stick up is forwards. The curve is applied to the distance from neutral,
+// so the deadzone is round, and the direction is unchanged.
+func (c *Controller) stick(x, y int) math3d.Vector3 {
+	v := math3d.Vector3{
+		X: float64(x) / maxStick,
+		Z: float64(-y) / maxStick,
+	}
+
+	m := v.Magnitude()
+	if m == 0 {
+		return v
+	}
+
+	return v.Scaled(curve(m, c.deadzone, c.expo) / m)
+}
+
+// trigger returns how far a trigger is pressed, from zero to one, before the
+// curve is applied.
+func trigger(v int32) float64 {
+	return math.Max(0, math.Min(float64(v)/maxTrigger, 1))
+}
+
+// rotation returns how fast to rotate, from -1 (full left) to 1 (full right).
+//
+// With the triggers, the curve is applied to the difference between them, so
+// either one alone has the same deadzone as a stick, and both pressed together
+// cancel out cleanly, rather than wobbling around zero as their pressures
+// drift apart. It saturates when either is fully pressed and the other isn't.
+//
+// With the stick, it's the right stick, unless R1 is held, since that's when
+// it sets the offset instead.
+//
+// While the camera boom is engaged, L2 doesn't turn (since it's holding the
+// boom), and neither does the right stick (since it's moving the boom).
+func (c *Controller) rotation() float64 {
+	if c.cfg.Rotation == rotateWithStick {
+		if c.sa.R1 > minButtonPressure || c.boom.active {
+			return 0
+		}
+
+		return math.Max(-1, math.Min(c.stick(int(c.sa.RightStick.X), 0).X, 1))
+	}
+
+	l2 := trigger(c.sa.L2)
+	if c.boom.active {
+		l2 = 0
+	}
+
+	turn := trigger(c.sa.R2) - l2
+	return math.Copysign(curve(math.Abs(turn), c.deadzone, c.expo), turn)
+}
+
+// turning returns true if something other than L2 is turning the hex, which
+// would stop the camera boom from being engaged.
+func (c *Controller) turning() bool {
+	if c.cfg.Rotation == rotateWithStick {
+		return c.rotation() != 0
+	}
+
+	return curve(trigger(c.sa.R2), c.deadzone, c.expo) != 0
+}
@@ -0,0 +1,131 @@
+package controller
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/adammck/hexapod/config"
+	"github.com/adammck/hexapod/math3d"
+	"github.com/adammck/hexapod/params"
+	"github.com/adammck/sixaxis"
+	"github.com/stretchr/testify/assert"
+)
+
+// headingRate returns how far (in degrees per step cycle) the controller turns
+// the target from the pose, given the input.
+func headingRate(t *testing.T, cfg config.Controller, in input) float64 {
+	sa := sixaxis.New(nil)
+	in(sa)
+
+	c := NewScripted(sa, cfg, config.Default().Head)
+	c.Params = params.New()
+	assert.NoError(t, c.Boot())
+
+	state := parked()
+	assert.NoError(t, c.Tick(time.Unix(0, 0), &state))
+	return math3d.AngleDiff(state.Target.Heading, state.Pose.Heading)
+}
+
+func TestTriggers(t *testing.T) {
+	cfg := config.Default().Controller
+	cfg.RotSpeed = 20
+	cfg.Deadzone = 0.1
+
+	// The curve of a fraction of full travel, with the deadzone above.
+	linear := func(v float64) float64 { return (v - 0.1) / 0.9 }
+	cubic := func(v float64) float64 { return 0.5*linear(v) + 0.5*linear(v)*linear(v)*linear(v) }
+
+	for _, tc := range []struct {
+		r2, l2 int32
+		linear float64
+		cubic  float64
+	}{
+		{0, 0, 0, 0},
+
+		// Within the deadzone, with either trigger.
+		{20, 0, 0, 0},
+		{0, 25, 0, 0},
+
+		// Either alone uses its whole range.
+		{64, 0, 20 * linear(64.0/255), 20 * cubic(64.0/255)},
+		{128, 0, 20 * linear(128.0/255), 20 * cubic(128.0/255)},
+		{0, 128, -20 * linear(128.0/255), -20 * cubic(128.0/255)},
+		{255, 0, 20, 20},
+		{0, 255, -20, -20},
+
+		// Out of range is full, not more.
+		{300, 0, 20, 20},
+
+		// Both at once subtract, and cancel out within the deadzone of each
+		// other, even if they're both pressed hard.
+		{255, 255, 0, 0},
+		{240, 255, 0, 0},
+		{255, 200, 20 * linear(55.0/255), 20 * cubic(55.0/255)},
+		{64, 192, -20 * linear(128.0/255), -20 * cubic(128.0/255)},
+	} {
+		t.Run(fmt.Sprintf("R2=%d,L2=%d", tc.r2, tc.l2), func(t *testing.T) {
+			in := func(sa *sixaxis.SA) { sa.R2, sa.L2 = tc.r2, tc.l2 }
+			assert.InDelta(t, tc.linear, headingRate(t, cfg, in), tolerance)
+
+			expo := cfg
+			expo.Expo = 0.5
+			assert.InDelta(t, tc.cubic, headingRate(t, expo, in), tolerance)
+
+			// Unless the stick rotates instead.
+			stick := cfg
+			stick.Rotation = "stick"
+			assert.InDelta(t, 0, headingRate(t, stick, in), tolerance)
+		})
+	}
+}
+
+func TestRotateWithStick(t *testing.T) {
+	cfg := config.Default().Controller
+	cfg.RotSpeed = 20
+	cfg.Deadzone = 0.1
+	cfg.Rotation = "stick"
+
+	for _, tc := range []struct {
+		name string
+		in   input
+		want float64
+	}{
+		{"right", func(sa *sixaxis.SA) { sa.RightStick.X = 127 }, 20},
+		{"left", func(sa *sixaxis.SA) { sa.RightStick.X = -128 }, -20},
+		{"half", func(sa *sixaxis.SA) { sa.RightStick.X = 64 }, 20 * (64.0/127 - 0.1) / 0.9},
+		{"deadzone", func(sa *sixaxis.SA) { sa.RightStick.X = 10 }, 0},
+		{"up and down don't", func(sa *sixaxis.SA) { sa.RightStick.Y = 127 }, 0},
+		{"not while setting the offset", func(sa *sixaxis.SA) { sa.RightStick.X = 127; sa.R1 = 255 }, 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.InDelta(t, tc.want, headingRate(t, cfg, tc.in), tolerance)
+		})
+	}
+
+	// The focal point doesn't follow, so the head doesn't pan.
+	sa := sixaxis.New(nil)
+	sa.RightStick.X = 127
+	c := NewScripted(sa, cfg, config.Default().Head)
+	c.Params = params.New()
+	assert.NoError(t, c.Boot())
+
+	state := parked()
+	assert.NoError(t, c.Tick(time.Unix(0, 0), &state))
+	assert.InDelta(t, ahead.X, state.LookAt.X, tolerance)
+	assert.InDelta(t, ahead.Y, state.LookAt.Y, tolerance)
+	assert.InDelta(t, ahead.Z, state.LookAt.Z, tolerance)
+}
+
+func TestStick(t *testing.T) {
+	c := &Controller{deadzone: 0.1, expo: 0.5}
+
+	// The deadzone is round, and the direction is kept.
+	assert.Equal(t, math3d.Vector3{}, c.stick(8, -8))
+	v := c.stick(60, -60)
+	assert.InDelta(t, v.X, v.Z, 1e-9)
+
+	// Full travel is unchanged, even in the corners.
+	assert.Equal(t, math3d.Vector3{X: 1}, c.stick(127, 0))
+	assert.Equal(t, math3d.Vector3{X: 1, Z: 1}, c.stick(127, -127))
+}
@@ -0,0 +1,88 @@
+package controller
+
+import (
+	"math"
+	"time"
+
+	"github.com/adammck/hexapod/math3d"
+)
+
+// boom tracks the camera boom mode, in which the focal point is held still in
+// the world space (rather than moving with the body) and the right stick moves
+// it like the head of a tripod: up and down, and panning around the hex at the
+// same distance. After it's released, the focal point blends back to wherever
+// it would otherwise be.
+type boom struct {
+	active bool
+
+	// The focal point (in the world space) while active, or the one which it
+	// was released at while blending back.
+	point math3d.Vector3
+
+	// When the point was last moved, or when it was released.
+	last     time.Time
+	released time.Time
+	blending bool
+}
+
+// engage starts holding the given focal point.
+func (b *boom) engage(now time.Time, point math3d.Vector3) {
+	b.active = true
+	b.blending = false
+	b.point = point
+	b.last = now
+}
+
+// release stops holding the focal point, and starts blending back from it.
+func (b *boom) release(now time.Time) {
+	b.active = false
+	b.blending = true
+	b.released = now
+}
+
+// move moves the focal point by the given position of the right stick, at the
+// given speed (in mm per second), and returns it. The Y component raises it,
+// and the X pans it to the right (as seen from the origin, which is usually
+// the position of the body) around the origin, without changing its distance
+// from it on the ground.
+func (b *boom) move(now time.Time, right math3d.Vector3, speed float64, origin math3d.Vector3) math3d.Vector3 {
+	dt := now.Sub(b.last).Seconds()
+	b.last = now
+
+	b.point.Y += right.Z * speed * dt
+
+	h := math3d.Vector3{X: b.point.X - origin.X, Z: b.point.Z - origin.Z}
+	d := h.Magnitude()
+	if d > 0 && right.X != 0 {
+
+		// The direction to the right of the one from the origin to the point,
+		// which has the same length, so the pan is a rotation of h towards it.
+		r := math3d.Vector3{X: h.Z, Z: -h.X}
+		a := right.X * speed * dt / d
+		b.point.X = origin.X + h.X*math.Cos(a) + r.X*math.Sin(a)
+		b.point.Z = origin.Z + h.Z*math.Cos(a) + r.Z*math.Sin(a)
+	}
+
+	return b.point
+}
+
+// blend returns the focal point to use after the boom was released, given the
+// one to use otherwise: it starts from where the boom left it, and moves
+// linearly to the other over the given duration, after which it's just the
+// other.
+func (b *boom) blend(now time.Time, v math3d.Vector3, d time.Duration) math3d.Vector3 {
+	if !b.blending {
+		return v
+	}
+
+	t := 1.0
+	if d > 0 {
+		t = now.Sub(b.released).Seconds() / d.Seconds()
+	}
+	if t >= 1 {
+		b.blending = false
+		return v
+	}
+
+	return *b.point.Add(v.Subtract(b.point).Scaled(t))
+}
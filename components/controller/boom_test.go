@@ -0,0 +1,168 @@
+package controller
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/adammck/hexapod"
+	"github.com/adammck/hexapod/config"
+	"github.com/adammck/hexapod/math3d"
+	"github.com/adammck/hexapod/params"
+	"github.com/adammck/sixaxis"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBoomMove(t *testing.T) {
+	t0 := time.Unix(0, 0)
+	origin := math3d.Vector3{X: 100, Y: 40, Z: -50}
+	b := boom{}
+	b.engage(t0, math3d.Vector3{X: 100, Y: 80, Z: 450})
+
+	// Up raises it, and nothing else.
+	p := b.move(t0.Add(100*time.Millisecond), math3d.Vector3{Z: 1}, 100, origin)
+	assert.InDelta(t, 0, p.Distance(math3d.Vector3{X: 100, Y: 90, Z: 450}), tolerance)
+
+	// Right pans it to the right, around the origin, at the same distance and
+	// height. A quarter of the way around is 785mm, which is 7.85s at 100mm/s.
+	p = b.move(t0.Add(100*time.Millisecond+7850*time.Millisecond), math3d.Vector3{X: 1}, 100, origin)
+	assert.InDelta(t, 90, p.Y, tolerance)
+	assert.InDelta(t, 600, p.X, 0.5)
+	assert.InDelta(t, -50, p.Z, 0.5)
+	assert.InDelta(t, 500, math.Hypot(p.X-origin.X, p.Z-origin.Z), tolerance)
+}
+
+func TestBoomBlend(t *testing.T) {
+	t0 := time.Unix(0, 0)
+	d := 500 * time.Millisecond
+	from := math3d.Vector3{X: 0, Y: 100, Z: 500}
+	to := math3d.Vector3{X: 100, Y: 0, Z: 500}
+
+	// Nothing to blend from until it's been released.
+	b := boom{}
+	assert.Equal(t, to, b.blend(t0, to, d))
+
+	b.engage(t0, from)
+	b.release(t0)
+	assert.Equal(t, from, b.blend(t0, to, d))
+	assert.Equal(t, math3d.Vector3{X: 50, Y: 50, Z: 500}, b.blend(t0.Add(d/2), to, d))
+	assert.Equal(t, to, b.blend(t0.Add(d), to, d))
+
+	// Once it's finished, it stays finished.
+	assert.Equal(t, to, b.blend(t0, to, d))
+}
+
+func TestBoom(t *testing.T) {
+	cfg := config.Default().Controller
+	cfg.BoomPressure = 0.9
+	cfg.BoomSpeed = 100
+	cfg.BoomBlend = config.Duration{Duration: 500 * time.Millisecond}
+
+	sa := sixaxis.New(nil)
+	c := NewScripted(sa, cfg, config.Default().Head)
+	c.Params = params.New()
+	assert.NoError(t, c.Boot())
+
+	// Ticks from the parked pose, with the body at the given height, and
+	// returns the focal point and the target heading.
+	t0 := time.Unix(0, 0)
+	tick := func(at time.Duration, height float64) (math3d.Vector3, float64) {
+		state := parked()
+		state.Pose.Position.Y = height
+		assert.NoError(t, c.Tick(t0.Add(at), &state))
+		return *state.LookAt, state.Target.Heading
+	}
+
+	at := func(ms int) time.Duration {
+		return time.Duration(ms) * time.Millisecond
+	}
+
+	normal, _ := tick(0, 40)
+
+	// Holding L2 holds the focal point, rather than turning.
+	sa.L2 = 255
+	fp, heading := tick(at(100), 40)
+	assert.True(t, c.boom.active)
+	assert.Equal(t, normal, fp)
+	assert.Equal(t, 30.0, heading)
+
+	// Raising the body (and the clearance) doesn't drag it.
+	for i, h := range []float64{45, 50, 55, 60} {
+		sa.Up = 255
+		if i%2 == 1 {
+			sa.Up = 0
+		}
+		fp, _ = tick(at(200+i*100), h)
+		assert.Equal(t, normal, fp, "height=%.0f", h)
+	}
+	assert.Equal(t, cfg.Clearance+2*cfg.ClearanceStep, c.clearance)
+	sa.Up = 0
+
+	// The right stick moves it, in the world space. Up is only up.
+	sa.RightStick.Y = -127
+	fp, _ = tick(at(600), 60)
+	assert.InDelta(t, normal.Y+10, fp.Y, tolerance)
+	assert.InDelta(t, normal.X, fp.X, tolerance)
+	assert.InDelta(t, normal.Z, fp.Z, tolerance)
+
+	// And sideways pans it, at the same distance, without turning.
+	sa.RightStick.Y = 0
+	sa.RightStick.X = 127
+	fp, heading = tick(at(700), 60)
+	assert.Equal(t, 30.0, heading)
+	assert.InDelta(t, normal.Y+10, fp.Y, tolerance)
+	assert.False(t, fp.Distance(math3d.Vector3{X: normal.X, Y: normal.Y + 10, Z: normal.Z}) < 1)
+	dist := func(v math3d.Vector3) float64 { return math.Hypot(v.X-100, v.Z+50) }
+	assert.InDelta(t, dist(normal), dist(fp), tolerance)
+
+	// Letting go blends back to the usual focal point (which is higher now,
+	// with the clearance) over the blend.
+	sa.L2 = 0
+	sa.RightStick.X = 0
+	held := fp
+	fp, _ = tick(at(1000), 60)
+	assert.False(t, c.boom.active)
+	assert.Equal(t, held, fp)
+
+	want := c.focalPoint(math3d.Pose{Position: math3d.Vector3{X: 100, Y: c.clearance, Z: -50}, Heading: 30}, math3d.Vector3{}, hexapod.Ground{})
+	fp, _ = tick(at(1250), 60)
+	mid := *held.Add(want.Subtract(held).Scaled(0.5))
+	assert.InDelta(t, 0, fp.Distance(mid), tolerance)
+
+	fp, _ = tick(at(1500), 60)
+	assert.InDelta(t, 0, fp.Distance(want), tolerance)
+}
+
+func TestBoomNotWhileTurning(t *testing.T) {
+	cfg := config.Default().Controller
+	cfg.BoomPressure = 0.9
+
+	sa := sixaxis.New(nil)
+	c := NewScripted(sa, cfg, config.Default().Head)
+	c.Params = params.New()
+	assert.NoError(t, c.Boot())
+
+	state := parked()
+	sa.R2 = 255
+	assert.NoError(t, c.Tick(time.Unix(0, 0), &state))
+
+	// L2 past the pressure while turning right is just turning.
+	sa.L2 = 255
+	state = parked()
+	assert.NoError(t, c.Tick(time.Unix(1, 0), &state))
+	assert.False(t, c.boom.active)
+}
+
+func TestBoomDisabled(t *testing.T) {
+	sa := sixaxis.New(nil)
+	c := NewScripted(sa, config.Default().Controller, config.Default().Head)
+	c.Params = params.New()
+	assert.NoError(t, c.Boot())
+
+	// By default, L2 turns left however hard it's pressed.
+	sa.L2 = 255
+	state := parked()
+	assert.NoError(t, c.Tick(time.Unix(0, 0), &state))
+	assert.False(t, c.boom.active)
+	assert.NotEqual(t, 30.0, state.Target.Heading)
+}
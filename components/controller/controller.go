@@ -2,58 +2,117 @@ package controller
 
 import (
 	"io"
+	"math"
 	"time"
 
-	"github.com/Sirupsen/logrus"
 	"github.com/adammck/hexapod"
+	"github.com/adammck/hexapod/config"
 	"github.com/adammck/hexapod/math3d"
+	"github.com/adammck/hexapod/params"
+	"github.com/adammck/hexapod/units"
 	"github.com/adammck/sixaxis"
 )
 
 const (
-	moveSpeed           = 100.0
-	rotSpeed            = 15.0
-	horizontalLookScale = 250.0
-	verticalLookScale   = 250.0
-
-	focalHorizontalOffset = 0
-	focalVerticalOffset   = 43 + 34.5 // y offset from origin + y distance to middle of lens
-	focalDistance         = 500
-
-	// Distance to adjust the clearance each time Up or Down is pressed.
-	clearanceStep = 10
 
 	// Minimum pressure needed to trigger a button press.
 	minButtonPressure = 10
 
-	// Maximum angle (in degrees) to bank to the left or right using the
-	// orientation of the controller.
-	bankScale = 15.0
-
-	// Maximum angle (in degrees) to pitch forwards or backwards using the
-	// orientation of the controller.
-	pitchScale = 15.0
+	// How far (as a fraction of full travel) a stick or trigger must be moved
+	// from neutral to count, since they never quite settle at zero.
+	minStick = 0.1
 
-	// TODO: Document what 'offset' is, here and in the legs.
-	xOffsetScale = 40.0
-	zOffsetScale = 40.0
+	// How far (in mm or degrees) the pose can move in a tick while the hex
+	// counts as standing still.
+	stillTolerance = 0.1
 )
 
 type Controller struct {
-	sa *sixaxis.SA
+	sa  *sixaxis.SA
+	cfg config.Controller
 
-	clearance float64
+	// The geometry of the head, which the neutral focal point is relative to.
+	// It's the same config which the head aims from, so they agree.
+	head config.Head
+
+	// Whether to read the sixaxis from its device in the background. This is
+	// false if something else is setting its state, e.g. a test script.
+	read bool
 
-	// Keep track of whether various buttons were being pressed during the
-	// previous tick, to avoid key repeat.
-	upLatch    Latch
-	downLatch  Latch
-	leftLatch  Latch
-	rightLatch Latch
-	psLatch    Latch
+	// The reader which the sixaxis is read from, if it is.
+	link *link
 
-	// Track select + button options, which change states.
-	selectTriangle Latch
+	// The registry to register the params with at boot. This is params.Default
+	// unless changed, so more than one instance can be booted (e.g. in tests).
+	Params *params.Registry
+
+	// The clearance which the operator set, and the temporary one which is
+	// lowered from it to duck under things.
+	clearance float64
+	duck      duck
+
+	// Whether the hex is walking backwards, and whether the focal point has
+	// moved behind because of it.
+	reverse    reverse
+	lookBehind bool
+
+	// Whether the focal point is being held in the world space by L2. See
+	// config.Controller.BoomPressure.
+	boom boom
+
+	// The speed boost, while R1 and L1 are held. See
+	// config.Controller.TurboFactor.
+	turbo turbo
+
+	// The automatic switch to the crouch gait, while the clearance is low. See
+	// config.Controller.CrouchClearance.
+	crouch crouch
+
+	// The automatic choice of gait, from the commanded speed. See
+	// config.Controller.AutoGait.
+	auto autoGait
+
+	// The focal point which State.LookAt points to, which is kept here rather
+	// than allocated every tick.
+	lookAt math3d.Vector3
+
+	// Tunable params. See registerParams.
+	moveSpeed     float64
+	rotSpeed      float64
+	deadzone      float64
+	expo          float64
+	minClearance  float64
+	maxClearance  float64
+	clearanceStep float64
+
+	// The chords which the actions are bound to, which keep track of whether
+	// they were held during the previous tick, to avoid key repeat. See
+	// actions. If the config's map is invalid, that's returned by Boot.
+	buttons    *bindings
+	buttonsErr error
+
+	// The tempo, which is tapped in time with music.
+	tempo Tempo
+
+	// The inspection pose, which can be held or toggled while standing still.
+	// The pose is compared to the last tick's to tell, and whether the hex is
+	// moving is kept for the action.
+	inspect  preset
+	lastPose math3d.Pose
+	hasLast  bool
+	moving   bool
+
+	// The posing mode, for photography, which can be toggled while standing
+	// still. See config.Controller.PoseSpeed.
+	posing posing
+
+	// The tuning mode, for nudging the params from the controller, which can
+	// be toggled while standing still. See config.Controller.Tuning.
+	tuning tuning
+
+	// Only used while calibrating.
+	crossLatch    Latch
+	triangleLatch Latch
 
 	// Enable target orientation mode, where the target bank/pitch (x/y) are set
 	// using the controller orientation. Press the PS button to toggle. Defaults
@@ -61,22 +120,146 @@ type Controller struct {
 	setTargetOrientation bool
 }
 
-var log = logrus.WithFields(logrus.Fields{
-	"pkg": "controller",
-})
+var log = hexapod.NewLog("controller")
+
+func New(r io.Reader, cfg config.Controller, head config.Head) *Controller {
+	l := &link{r: r}
+	c := NewScripted(sixaxis.New(l), cfg, head)
+	c.read = true
+	c.link = l
+	return c
+}
+
+// LastInput returns when the controller was last read from its device, or zero
+// if it never was (or it isn't read from one, because it's scripted).
+func (c *Controller) LastInput() time.Time {
+	if c.link == nil {
+		return time.Time{}
+	}
+
+	return c.link.read()
+}
+
+// NewScripted creates a controller which uses the state of the given sixaxis
+// as is, rather than reading it from a device. The caller can set its fields
+// between ticks to script the input.
+func NewScripted(sa *sixaxis.SA, cfg config.Controller, head config.Head) *Controller {
+	c := &Controller{
+		sa:            sa,
+		cfg:           cfg,
+		head:          head,
+		Params:        params.Default,
+		clearance:     cfg.Clearance,
+		duck:          duck{cfg: cfg},
+		turbo:         newTurbo(cfg),
+		auto:          autoGait{enabled: cfg.AutoGait},
+		moveSpeed:     cfg.MoveSpeed,
+		rotSpeed:      cfg.RotSpeed,
+		deadzone:      cfg.Deadzone,
+		expo:          cfg.Expo,
+		minClearance:  cfg.MinClearance,
+		maxClearance:  cfg.MaxClearance,
+		clearanceStep: cfg.ClearanceStep,
+	}
 
-func New(r io.Reader) *Controller {
-	return &Controller{
-		sa:        sixaxis.New(r),
-		clearance: 40,
+	c.buttons, c.buttonsErr = bind(cfg.Buttons)
+	if c.buttonsErr != nil {
+		c.buttons = &bindings{bound: make([]int, len(actions))}
+		for i := range c.buttons.bound {
+			c.buttons.bound[i] = -1
+		}
+	}
+
+	c.inspect = preset{
+		name: "inspection",
+		ramp: cfg.InspectRamp.Duration,
+		pose: c.inspectionPose,
 	}
+
+	return c
 }
 
+// Writes returns hexapod.Commander, since the controller is where the commands
+// come from.
+func (c *Controller) Writes() hexapod.Role {
+	return hexapod.Commander
+}
+
+// Source implements hexapod.Source.
+func (c *Controller) Source() string {
+	return "controller"
+}
+
+// Boot returns an error if the button map in the config is invalid, since it's
+// checked when the controller is created.
 func (c *Controller) Boot() error {
-	go c.sa.Run()
+	if c.buttonsErr != nil {
+		return c.buttonsErr
+	}
+
+	err := c.registerParams(c.Params)
+	if err != nil {
+		return err
+	}
+
+	if c.read {
+		go c.sa.Run()
+	}
+
+	return nil
+}
+
+func (c *Controller) registerParams(r *params.Registry) error {
+	for _, p := range []params.Param{
+
+		// Distance (in mm) to move per step cycle at full stick.
+		floatParam("controller.move_speed", 0, 200, 10, &c.moveSpeed),
+
+		// Angle (in degrees) to rotate per step cycle at full trigger.
+		floatParam("controller.rot_speed", 0, 45, 1, &c.rotSpeed),
+
+		// The deadzone and curve of the sticks and triggers. See curve.
+		floatParam("controller.deadzone", 0, 0.5, 0.01, &c.deadzone),
+		floatParam("controller.expo", 0, 1, 0.05, &c.expo),
+
+		// Limits of the clearance which can be set via Up and Down.
+		floatParam("controller.min_clearance", 0, 120, 5, &c.minClearance),
+		floatParam("controller.max_clearance", 0, 120, 5, &c.maxClearance),
+
+		// Distance to adjust the clearance each time Up or Down is pressed.
+		floatParam("controller.clearance_step", 1, 40, 1, &c.clearanceStep),
+
+		// The current clearance. Not clamped to the limits above until it's
+		// next changed via Up or Down.
+		floatParam("controller.clearance", 0, 120, 5, &c.clearance),
+	} {
+		err := r.Register(p)
+		if err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
+func floatParam(name string, min, max, step float64, f *float64) params.Param {
+	return params.Param{
+		Name: name,
+		Type: params.Float,
+		Min:  min,
+		Max:  max,
+		Step: step,
+		Get:  func() float64 { return *f },
+		Set:  func(v float64) { *f = v },
+	}
+}
+
+// Essential returns true, because without the controller nothing tells the legs
+// where to go (or to stop). If it panics or wedges, the hex sits down instead.
+func (c *Controller) Essential() bool {
+	return true
+}
+
 func (c *Controller) Tick(now time.Time, state *hexapod.State) error {
 
 	// Do nothing if we're shutting down.
@@ -84,99 +267,477 @@ func (c *Controller) Tick(now time.Time, state *hexapod.State) error {
 		return nil
 	}
 
-	// At any time, pressing start shuts down the hex.
-	if c.sa.Start && !state.Shutdown {
-		log.Warn("Pressed START, shutting down")
-		state.Shutdown = true
+	// Keep a copy of the raw input, for the flight recorder.
+	state.Input = c.input()
+
+	c.moving = c.walking(state)
+
+	// The actions which always run (i.e. shutting down) do so now, since the
+	// buttons do other things while calibrating.
+	c.buttons.run(now, state.Input.Buttons)
+	c.dispatch(now, state, true)
+
+	// While calibrating, stay where we are, and use the buttons to drive the
+	// wizard instead: cross to capture the active leg, triangle to skip it.
+	if state.Calibrating {
+		state.Target = state.Pose
+
+		if c.crossLatch.Run(c.sa.Cross > minButtonPressure) {
+			state.Calibration = hexapod.CalibrationCapture
+		}
+
+		if c.triangleLatch.Run(c.sa.Triangle > minButtonPressure) {
+			state.Calibration = hexapod.CalibrationSkip
+		}
+
+		return nil
+	}
+
+	// While self-testing, stay where we are. The self-test moves the joints
+	// itself, and aborts if start is pressed.
+	if state.SelfTesting {
+		state.Target = state.Pose
+		return nil
+	}
+
+	// While tuning, stay where we are too, at the clearance (which might be
+	// what's being tuned), and use the d-pad to pick and nudge the params
+	// instead. Nothing else works, apart from stopping tuning.
+	if c.tuning.active {
+		c.dispatch(now, state, false)
+		if c.tuning.active {
+			c.tune(state)
+		}
+
+		state.Target = state.Pose
+		state.Target.Position.Y = c.clearance
+		return nil
+	}
+
+	// While posing (or easing back afterwards), stay where we are too, on the
+	// same footholds, and use the sticks and triggers to move the chassis on
+	// them instead. The buttons still work, including to stop posing.
+	if c.posing.active() {
+		c.dispatch(now, state, false)
+		if saturated(state) {
+			c.posing.abort("a leg is saturated")
+		}
+
+		move := c.stick(int(c.sa.LeftStick.X), int(c.sa.LeftStick.Y))
+		tilt := c.stick(int(c.sa.RightStick.X), int(c.sa.RightStick.Y))
+		turn := trigger(c.sa.R2) - trigger(c.sa.L2)
+		turn = math.Copysign(curve(math.Abs(turn), c.deadzone, c.expo), turn)
+		c.posing.update(now, c.cfg, move, tilt, turn)
+		c.posing.apply(state, c.clearance)
+		return nil
 	}
 
 	// Set the target position and heading (rotation around the plane parallel
 	// to the ground) relative to the current pose, such that holding e.g. up on
 	// the left stick moves the machine steadily forwards.
+	//
+	// Walking backwards is slower, if configured to be, since the operator has
+	// to look over the top of the hex to see where it's going. The turbo makes
+	// everything faster, for a little while. The derate (from the battery and
+	// the servo temperature) limits both the speed and the boost.
+	move := c.stick(int(c.sa.LeftStick.X), int(c.sa.LeftStick.Y))
+	c.reverse.update(now, move.Z)
+	c.updateBoom(now)
+	c.updateTurbo(now, state)
+	d := state.Derate.Scale()
 	state.Target = state.Pose.Add(math3d.Pose{
-		Position: math3d.Vector3{
-			X: (float64(c.sa.LeftStick.X) / 127.0) * moveSpeed,
-			Z: (float64(-c.sa.LeftStick.Y) / 127.0) * moveSpeed,
-		},
-		Heading: (float64(c.sa.R2-c.sa.L2) / 127.0) * rotSpeed,
+		Position: c.reverse.limit(move, c.cfg.ReverseSpeed).Scaled(c.moveSpeed * d * (1 + (c.turbo.factor-1)*d)),
+		Heading:  c.rotation() * c.rotSpeed,
 	})
+	state.Target.Heading = math3d.WrapDegrees(state.Target.Heading)
+
+	// If a halt has been requested, ignore the sticks and stay where we are.
+	if state.Halt {
+		state.Target = state.Pose
+	}
 
 	// Set the target Y position (clearance between chassis and ground)
-	// absolutely. We don't want the body to rise continuously.
-	state.Target.Position.Y = c.clearance
+	// absolutely. We don't want the body to rise continuously. It's lower than
+	// the operator's clearance while ducking under something ahead.
+	forward := !state.Halt && c.stick(0, int(c.sa.LeftStick.Y)).Z >= minStick
+	clearance := c.duck.clearance(now, c.clearance, c.duckLimit(state), forward, state.Range)
+	state.Target.Position.Y = clearance
 
 	// If target orientation mode is enabled, set the target XZ orientation to
 	// match the controller. (Note that the axes are different and inverted.)
 	if c.setTargetOrientation {
-		state.Target.Pitch = -c.sa.Orientation.Y() * pitchScale
-		state.Target.Bank = -c.sa.Orientation.X() * bankScale
+		state.Target.Pitch = math3d.ClampDegrees(-c.sa.Orientation.Y()*c.cfg.PitchScale, -c.cfg.PitchScale, c.cfg.PitchScale)
+		state.Target.Bank = math3d.ClampDegrees(-c.sa.Orientation.X()*c.cfg.BankScale, -c.cfg.BankScale, c.cfg.BankScale)
 	} else {
 		state.Target.Pitch = 0
 		state.Target.Bank = 0
 	}
 
 	// Set offset using the right stick while R1 is held down.
+	right := c.stick(int(c.sa.RightStick.X), int(c.sa.RightStick.Y))
 	if c.sa.R1 > minButtonPressure {
 		state.Offset = math3d.Vector3{
-			X: (float64(c.sa.RightStick.X) / 127.0 * xOffsetScale),
-			Z: (float64(c.sa.RightStick.Y*-1) / 127.0 * zOffsetScale),
+			X: right.X * c.cfg.XOffsetScale,
+			Z: right.Z * c.cfg.ZOffsetScale,
 		}
+	} else if c.boom.active {
+
+		// While L2 is held, the focal point stays where it is in the world
+		// space, however the body moves, and the right stick moves it.
+		c.lookAt = c.boom.move(now, right, c.cfg.BoomSpeed, state.Pose.Position)
+		state.LookAt = &c.lookAt
 	} else {
 
 		// Use the right stick to set the focal point, which the head aims at. Note
-		// that (a) we discard the pitch+bank orientation of the hex pose, so that
-		// our focal point is "forwards" relative to the ground rather than the
-		// chassis, and (b) that the Y axis is inverted from the pull-down-to-look-
-		// up scheme often used in games. This is all very silly, but looks cool.
-		fp := state.Pose.Add(math3d.Pose{
-			Pitch: -state.Pose.Pitch,
-			Bank:  -state.Pose.Bank,
-		}).Add(math3d.Pose{
-			Position: math3d.Vector3{
-				X: (float64(c.sa.RightStick.X) / 127.0 * horizontalLookScale) + focalHorizontalOffset,
-				Y: (float64(c.sa.RightStick.Y*-1) / 127.0 * verticalLookScale) + focalVerticalOffset,
-				Z: focalDistance,
-			},
-			Heading: 0,
-		}).Position
-		state.LookAt = &fp
+		// that the Y axis is inverted from the pull-down-to-look-up scheme often
+		// used in games. This is all very silly, but looks cool.
+		//
+		// It's relative to the clearance (above the ground under the body)
+		// rather than the actual height of the body, which bobs up and down
+		// while walking, so the head can hold its gaze steady while the body
+		// moves underneath it.
+		//
+		// While the right stick rotates the hex, it only moves the focal point
+		// up and down, so the head doesn't swing around while steering. And it
+		// moves less while walking fast, if configured to.
+		//
+		// After walking backwards for a while, the neutral focal point moves
+		// behind, if configured to, so the head watches the ground at the rear.
+		//
+		// Just after the boom was released, it blends back to here from where
+		// the boom left it.
+		ref := state.Pose
+		ref.Position.Y = state.Ground.Height(ref.Position.X, ref.Position.Z) + clearance
+		c.lookBehind = c.cfg.ReverseLook && !state.Halt && c.reverse.sustained(now, c.cfg.ReverseLookDelay.Duration)
+		if c.cfg.Rotation == rotateWithStick {
+			right.X = 0
+		}
+		c.lookAt = c.boom.blend(now, c.focalPoint(ref, right.Scaled(c.lookScale(state)), state.Ground), c.cfg.BoomBlend.Duration)
+		state.LookAt = &c.lookAt
+	}
+
+	// Run the actions which were triggered by the buttons, including holding
+	// or toggling the inspection pose (see actions).
+	c.dispatch(now, state, false)
+	if saturated(state) {
+		c.inspect.abort("a leg is saturated")
+	}
+	c.inspect.apply(now, state)
+
+	// Crouch while the clearance is low, and stop once it's raised again.
+	// Otherwise, pick the gait for the speed, if the auto gait mode is on.
+	c.updateCrouch(state)
+	c.updateAutoGait(state)
+
+	return nil
+}
+
+// setTempo sets the gait.bpm param, which the legs register. It's applied at
+// the start of the next tick.
+func (c *Controller) setTempo(bpm float64) {
+	err := c.Params.Set(map[string]float64{"gait.bpm": bpm})
+	if err != nil {
+		log.Warnf("%s (while setting tempo)", err)
+		return
 	}
 
-	// Toggle target orientation mode by pressing PS.
-	if c.psLatch.Run(c.sa.PS) {
-		c.setTargetOrientation = !c.setTargetOrientation
-		log.Infof("setTargetOrientation=%v", c.setTargetOrientation)
+	if bpm == 0 {
+		log.Info("cleared tempo")
+		return
 	}
 
-	// Increase clearance by pressing Up
-	if c.upLatch.Run(c.sa.Up > minButtonPressure) {
-		c.clearance += clearanceStep
-		log.Infof("clearance=%v", c.clearance)
+	log.Infof("set tempo to %.1f bpm", bpm)
+}
+
+// setClearance changes the clearance, and publishes an event if that was a
+// change, i.e. it wasn't already at the limit.
+func (c *Controller) setClearance(state *hexapod.State, v float64) {
+	if v == c.clearance {
+		return
 	}
 
-	// Decrease clearance by pressing Down
-	if c.downLatch.Run(c.sa.Down > minButtonPressure) {
-		c.clearance -= clearanceStep
-		log.Infof("clearance=%v", c.clearance)
+	c.clearance = v
+	state.Publish(hexapod.EventClearanceChanged, hexapod.Info, v)
+}
+
+// walking returns true if the hex is being told to walk, or has moved since the
+// last tick, e.g. because it's still finishing a step.
+func (c *Controller) walking(state *hexapod.State) bool {
+	moved := c.hasLast && (state.Pose.Position.Distance(c.lastPose.Position) > stillTolerance ||
+		math.Abs(math3d.AngleDiff(state.Pose.Heading, c.lastPose.Heading)) > stillTolerance)
+	c.lastPose, c.hasLast = state.Pose, true
+
+	move := c.stick(int(c.sa.LeftStick.X), int(c.sa.LeftStick.Y))
+	return moved || move.Magnitude() >= minStick || math.Abs(c.rotation()) >= minStick
+}
+
+// inspectionPose returns the inspection pose: as high as the clearance goes,
+// pitched nose-down, looking at the ground ahead.
+func (c *Controller) inspectionPose(state *hexapod.State) presetPose {
+	level := math3d.Pose{Position: state.Pose.Position, Heading: state.Pose.Heading}
+	look := level.Add(math3d.Pose{Position: math3d.Vector3{Z: c.cfg.InspectDistance}}).Position
+	look.Y = 0
+
+	return presetPose{
+		clearance: c.maxClearance,
+		pitch:     c.cfg.InspectPitch,
+		lookAt:    look,
 	}
+}
 
-	// Increase speed by pressing right
-	if c.rightLatch.Run(c.sa.Right > minButtonPressure) {
-		state.Speed += 1
-		log.Infof("Speed=%v", state.Speed)
+// refuseInspection returns why the inspection pose can't be engaged, or an
+// empty string if it can.
+func refuseInspection(state *hexapod.State, walking bool) string {
+	switch {
+	case state.SafeMode:
+		return "in safe mode"
+	case walking:
+		return "walking"
+	case saturated(state):
+		return "a leg is saturated"
 	}
 
-	// Decrease speed by pressing left
-	if c.leftLatch.Run(c.sa.Left > minButtonPressure) {
-		state.Speed -= 1
-		log.Infof("Speed=%v", state.Speed)
+	return ""
+}
+
+// refusePosing returns why the posing mode can't be engaged, or an empty string
+// if it can.
+func refusePosing(state *hexapod.State, walking, inspecting bool) string {
+	switch {
+	case walking:
+		return "walking"
+	case inspecting:
+		return "in the inspection pose"
+	case saturated(state):
+		return "a leg is saturated"
 	}
 
-	// Cycle through gaits by pressing select + triangle
-	if c.selectTriangle.Run(c.sa.Select && c.sa.Triangle > minButtonPressure) {
-		state.GaitIndex += 1
-		log.Infof("GaitIndex=%v", state.GaitIndex)
+	return ""
+}
+
+// saturated returns true if any leg couldn't reach its goal on the last tick.
+func saturated(state *hexapod.State) bool {
+	for _, s := range state.Saturated {
+		if s {
+			return true
+		}
 	}
 
-	return nil
+	return false
+}
+
+// duckLimit returns the lowest clearance which can be ducked to: the min
+// clearance, or whatever the active gait needs, if that's more.
+func (c *Controller) duckLimit(state *hexapod.State) float64 {
+	g, _ := state.ActiveGait()
+	return math.Max(c.minClearance, g.MinClearance)
+}
+
+// nextStiffness returns the stiffness preset after the given one, wrapping
+// around. The first is returned if the given one isn't a preset at all, e.g.
+// because the legs haven't set it yet.
+func nextStiffness(name string) string {
+	for i, p := range config.StiffnessPresets {
+		if p == name {
+			return config.StiffnessPresets[(i+1)%len(config.StiffnessPresets)]
+		}
+	}
+
+	return config.StiffnessPresets[0]
+}
+
+// nextGait selects the next registered gait (or the previous, given
+// State.PreviousGait), skipping any which need more (or less) than the current
+// clearance. Selecting one by hand means the gait from before an automatic
+// crouch isn't restored. Nothing can be selected in safe mode, which forces the
+// wave gait.
+func (c *Controller) nextGait(state *hexapod.State, next func(func(hexapod.Gait) bool) (hexapod.Gait, bool)) {
+	cur, _ := state.ActiveGait()
+	if state.SafeMode {
+		log.Warnf("the gait can't be changed in safe mode, keeping %s", cur)
+		return
+	}
+
+	g, ok := next(func(g hexapod.Gait) bool {
+		if g.MinClearance > c.clearance {
+			log.Warnf("not selecting gait %s: it needs %s clearance, but the clearance is %s", g, units.MM(g.MinClearance), units.MM(c.clearance))
+			return false
+		}
+
+		if !g.Fits(c.clearance) {
+			log.Warnf("not selecting gait %s: it needs at most %s clearance, but the clearance is %s", g, units.MM(g.MaxClearance), units.MM(c.clearance))
+			return false
+		}
+
+		return true
+	})
+
+	if !ok || g.Name == cur.Name {
+		log.Warnf("no other gait can be selected, keeping %s", cur)
+		return
+	}
+
+	c.crouch.restore = false
+	state.SetGait(g)
+	state.Publish(hexapod.EventGaitChanged, hexapod.Info, g)
+}
+
+// input returns a compact copy of the current controller state.
+func (c *Controller) input() hexapod.Input {
+	in := hexapod.Input{
+		LeftX:  int(c.sa.LeftStick.X),
+		LeftY:  int(c.sa.LeftStick.Y),
+		RightX: int(c.sa.RightStick.X),
+		RightY: int(c.sa.RightStick.Y),
+		L2:     int(c.sa.L2),
+		R2:     int(c.sa.R2),
+	}
+
+	buttons := []struct {
+		bit     uint16
+		pressed bool
+	}{
+		{hexapod.ButtonSelect, c.sa.Select},
+		{hexapod.ButtonStart, c.sa.Start},
+		{hexapod.ButtonPS, c.sa.PS},
+		{hexapod.ButtonUp, c.sa.Up > minButtonPressure},
+		{hexapod.ButtonDown, c.sa.Down > minButtonPressure},
+		{hexapod.ButtonLeft, c.sa.Left > minButtonPressure},
+		{hexapod.ButtonRight, c.sa.Right > minButtonPressure},
+		{hexapod.ButtonL1, c.sa.L1 > minButtonPressure},
+		{hexapod.ButtonR1, c.sa.R1 > minButtonPressure},
+		{hexapod.ButtonTriangle, c.sa.Triangle > minButtonPressure},
+		{hexapod.ButtonCircle, c.sa.Circle > minButtonPressure},
+		{hexapod.ButtonCross, c.sa.Cross > minButtonPressure},
+		{hexapod.ButtonSquare, c.sa.Square > minButtonPressure},
+	}
+
+	for _, b := range buttons {
+		if b.pressed {
+			in.Buttons |= b.bit
+		}
+	}
+
+	return in
+}
+
+// SetInput sets the state of the given sixaxis from a copy of its input, e.g.
+// from the flight recorder, so it can be replayed via a scripted controller.
+// The pressure-sensitive buttons are pressed fully, since the copy only says
+// whether they were pressed. The orientation is left alone.
+func SetInput(sa *sixaxis.SA, in hexapod.Input) {
+	sa.LeftStick.X, sa.LeftStick.Y = int32(in.LeftX), int32(in.LeftY)
+	sa.RightStick.X, sa.RightStick.Y = int32(in.RightX), int32(in.RightY)
+	sa.L2, sa.R2 = int32(in.L2), int32(in.R2)
+
+	pressed := func(bit uint16) bool {
+		return in.Buttons&bit != 0
+	}
+
+	pressure := func(bit uint16) int32 {
+		if pressed(bit) {
+			return 255
+		}
+		return 0
+	}
+
+	sa.Select = pressed(hexapod.ButtonSelect)
+	sa.Start = pressed(hexapod.ButtonStart)
+	sa.PS = pressed(hexapod.ButtonPS)
+	sa.Up = pressure(hexapod.ButtonUp)
+	sa.Down = pressure(hexapod.ButtonDown)
+	sa.Left = pressure(hexapod.ButtonLeft)
+	sa.Right = pressure(hexapod.ButtonRight)
+	sa.L1 = pressure(hexapod.ButtonL1)
+	sa.R1 = pressure(hexapod.ButtonR1)
+	sa.Triangle = pressure(hexapod.ButtonTriangle)
+	sa.Circle = pressure(hexapod.ButtonCircle)
+	sa.Cross = pressure(hexapod.ButtonCross)
+	sa.Square = pressure(hexapod.ButtonSquare)
+}
+
+// lookScale returns the fraction of the look scales to use, given how fast the
+// hex is being told to walk. That's the distance between the pose and the
+// target which was just set from the left stick, as a fraction of the move
+// speed, so it doesn't need any more state. Rotation doesn't count.
+func (c *Controller) lookScale(state *hexapod.State) float64 {
+	if c.cfg.WalkingLookScale == 1 || c.moveSpeed == 0 {
+		return 1
+	}
+
+	d := state.Target.Position.Subtract(state.Pose.Position)
+	d.Y = 0
+	speed := math.Min(d.Magnitude()/c.moveSpeed, 1)
+
+	return 1 - (1-c.cfg.WalkingLookScale)*math.Pow(speed, c.cfg.WalkingLookCurve)
+}
+
+// updateBoom engages the boom while L2 is held past the boom pressure (and R1,
+// which sets the offset instead, isn't), as long as the hex isn't turning when
+// it's pressed, and releases it when either is let go.
+func (c *Controller) updateBoom(now time.Time) {
+	held := c.cfg.BoomPressure > 0 && trigger(c.sa.L2) >= c.cfg.BoomPressure && c.sa.R1 <= minButtonPressure
+
+	switch {
+	case !c.boom.active && held && !c.turning():
+		log.Info("holding the focal point for the camera boom")
+		c.boom.engage(now, c.lookAt)
+
+	case c.boom.active && !held:
+		log.Info("released the camera boom")
+		c.boom.release(now)
+	}
+}
+
+// updateTurbo engages the turbo when R1 and L1 (but not select) are pressed
+// together, and publishes an event whenever it starts, ends, or is ready again.
+func (c *Controller) updateTurbo(now time.Time, state *hexapod.State) {
+	held := !c.sa.Select && c.sa.R1 > minButtonPressure && c.sa.L1 > minButtonPressure
+	p, changed := c.turbo.update(now, held, saturated(state))
+	state.Turbo = c.turbo.status(now)
+
+	if !changed {
+		return
+	}
+
+	switch p {
+	case turboBoost:
+		log.Infof("turbo engaged (%.1fx)", c.turbo.factor)
+		state.Publish(hexapod.EventTurboEngaged, hexapod.Info, c.turbo.factor)
+
+	case turboCooldown:
+		log.Infof("turbo cooling down for %s", c.cfg.TurboCooldown.Duration)
+		state.Publish(hexapod.EventTurboCooling, hexapod.Info, c.cfg.TurboCooldown.Seconds())
+
+	case turboReady:
+		log.Info("turbo ready")
+		state.Publish(hexapod.EventTurboReady, hexapod.Info, nil)
+	}
+}
+
+// focalPoint returns the point (in the world space) which the head should aim
+// at, given the position of the right stick. The pitch+bank orientation of the
+// pose is discarded, so that the focal point is "forwards" relative to the
+// ground rather than the chassis. At neutral, it's level with and straight
+// ahead of the camera lens. On a slope, it rises or falls with the given ground
+// from under the pose to under the focal point, so it stays as far above it.
+func (c *Controller) focalPoint(pose math3d.Pose, right math3d.Vector3, ground hexapod.Ground) math3d.Vector3 {
+	tilt := math3d.Pose{Pitch: pose.Pitch, Bank: pose.Bank}
+	level := pose.Add(tilt.Inverse())
+
+	neutral := math3d.Vector3{X: c.head.Lens[0], Y: c.head.Lens[1], Z: c.head.FocalDistance}
+	if c.lookBehind {
+		neutral = math3d.Vector3{X: c.cfg.ReverseFocalHorizontalOffset, Y: c.cfg.ReverseFocalVerticalOffset, Z: c.cfg.ReverseFocalDistance}
+	}
+
+	fp := level.Add(math3d.Pose{
+		Position: math3d.Vector3{
+			X: (right.X * c.cfg.HorizontalLookScale) + neutral.X,
+			Y: (right.Z * c.cfg.VerticalLookScale) + neutral.Y,
+			Z: neutral.Z,
+		},
+	}).Position
+
+	fp.Y += ground.Height(fp.X, fp.Z) - ground.Height(pose.Position.X, pose.Position.Z)
+	return fp
 }
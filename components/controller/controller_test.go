@@ -0,0 +1,306 @@
+package controller
+
+import (
+	"testing"
+	"time"
+
+	"github.com/adammck/hexapod"
+	"github.com/adammck/hexapod/config"
+	"github.com/adammck/hexapod/math3d"
+	"github.com/adammck/hexapod/params"
+	"github.com/adammck/sixaxis"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFocalPointFlat(t *testing.T) {
+	c := &Controller{cfg: config.Default().Controller, head: config.Default().Head}
+
+	// The constants from before the config existed.
+	const (
+		horizontalLookScale   = 250.0
+		verticalLookScale     = 250.0
+		focalHorizontalOffset = 0
+		focalVerticalOffset   = 43 + 34.5
+		focalDistance         = 500
+	)
+
+	poses := []math3d.Pose{
+		{},
+		{Position: math3d.Vector3{X: 100, Y: 40, Z: -300}},
+		{Position: math3d.Vector3{X: 100, Y: 40, Z: -300}, Heading: 45},
+		{Position: math3d.Vector3{X: -10, Y: 60, Z: 20}, Heading: -170},
+	}
+
+	sticks := []math3d.Vector3{
+		{},
+		c.stick(127, 0),
+		c.stick(0, -127),
+		c.stick(-64, 100),
+	}
+
+	for _, p := range poses {
+		for _, s := range sticks {
+
+			// The computation from before focalPoint existed.
+			exp := p.Add(math3d.Pose{
+				Pitch: -p.Pitch,
+				Bank:  -p.Bank,
+			}).Add(math3d.Pose{
+				Position: math3d.Vector3{
+					X: (s.X * horizontalLookScale) + focalHorizontalOffset,
+					Y: (s.Z * verticalLookScale) + focalVerticalOffset,
+					Z: focalDistance,
+				},
+				Heading: 0,
+			}).Position
+
+			act := c.focalPoint(p, s, hexapod.Ground{})
+			assert.InDelta(t, exp.X, act.X, 0.0001, "%s %s", p, s)
+			assert.InDelta(t, exp.Y, act.Y, 0.0001, "%s %s", p, s)
+			assert.InDelta(t, exp.Z, act.Z, 0.0001, "%s %s", p, s)
+		}
+	}
+}
+
+func TestFocalPointIgnoresTilt(t *testing.T) {
+	c := &Controller{cfg: config.Default().Controller, head: config.Default().Head}
+	flat := math3d.Pose{Position: math3d.Vector3{X: 100, Y: 40, Z: -300}, Heading: 30}
+	tilted := flat
+	tilted.Pitch = 10
+	tilted.Bank = -5
+
+	s := c.stick(30, -60)
+	exp := c.focalPoint(flat, s, hexapod.Ground{})
+	act := c.focalPoint(tilted, s, hexapod.Ground{})
+	assert.InDelta(t, exp.X, act.X, 0.0001)
+	assert.InDelta(t, exp.Y, act.Y, 0.0001)
+	assert.InDelta(t, exp.Z, act.Z, 0.0001)
+
+	// Looking straight ahead, from the center of the lens.
+	fp := c.focalPoint(math3d.Pose{}, math3d.Vector3{}, hexapod.Ground{})
+	assert.Equal(t, math3d.Vector3{X: c.head.Lens[0], Y: c.head.Lens[1], Z: c.head.FocalDistance}, fp)
+}
+
+// slope returns the ground Y = a*X + b*Z + c.
+func slope(a, b, c float64) hexapod.Ground {
+	n := math3d.Vector3{X: -a, Y: 1, Z: -b}
+	m := n.Magnitude()
+	return hexapod.Ground{Normal: n.MultiplyByScalar(1 / m), Offset: c / m, Feet: 6}
+}
+
+func TestFocalPointSlope(t *testing.T) {
+	c := &Controller{cfg: config.Default().Controller, head: config.Default().Head}
+	pose := math3d.Pose{Position: math3d.Vector3{X: 100, Y: 40, Z: -300}}
+	s := c.stick(30, -60)
+
+	// Rising ahead, so it's that much higher, but no further away.
+	gr := slope(0.05, 0.2, 10)
+	flat := c.focalPoint(pose, s, hexapod.Ground{})
+	fp := c.focalPoint(pose, s, gr)
+	assert.InDelta(t, flat.X, fp.X, 0.0001)
+	assert.InDelta(t, flat.Z, fp.Z, 0.0001)
+	assert.InDelta(t, flat.Y+0.05*(flat.X-pose.Position.X)+0.2*(flat.Z-pose.Position.Z), fp.Y, 0.0001)
+
+	// Level ground at any height makes no difference, since the pose is
+	// already above it.
+	assert.Equal(t, flat, c.focalPoint(pose, s, slope(0, 0, -30)))
+
+	// The controller puts the pose at the clearance above the ground under
+	// it, so the whole thing rises with it.
+	tick := func(gr hexapod.Ground) math3d.Vector3 {
+		c := NewScripted(sixaxis.New(nil), config.Default().Controller, config.Default().Head)
+		c.Params = params.New()
+		assert.NoError(t, c.Boot())
+
+		state := parked()
+		state.Ground = gr
+		assert.NoError(t, c.Tick(time.Unix(0, 0), &state))
+		return *state.LookAt
+	}
+
+	base := tick(hexapod.Ground{})
+	raised := tick(slope(0, 0, 30))
+	assert.InDelta(t, base.Y+30, raised.Y, 0.0001)
+
+	sloped := tick(slope(0, 0.2, 10))
+	assert.InDelta(t, base.Y+0.2*base.Z+10, sloped.Y, 0.0001)
+	assert.InDelta(t, base.Z, sloped.Z, 0.0001)
+}
+
+func TestFocalPointGeometry(t *testing.T) {
+	pose := math3d.Pose{Position: math3d.Vector3{X: 100, Y: 40, Z: -300}, Heading: 90}
+
+	for _, tc := range []struct {
+		name string
+		head func(h *config.Head)
+		want math3d.Vector3
+	}{
+		{
+			name: "default",
+			head: func(h *config.Head) {},
+			want: math3d.Vector3{X: 0, Y: 77.5, Z: 500},
+		},
+		{
+			// A head which sits 20mm higher, with the lens off to one side
+			// and looking further ahead. The focal point stays level with the
+			// lens, so the head doesn't tilt down at neutral.
+			name: "raised",
+			head: func(h *config.Head) {
+				h.Mount = [3]float64{0, 63, 70}
+				h.Lens = [3]float64{-15, 97.5, 80}
+				h.FocalDistance = 800
+			},
+			want: math3d.Vector3{X: -15, Y: 97.5, Z: 800},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := config.Default()
+			tc.head(&cfg.Head)
+			assert.NoError(t, cfg.Validate())
+
+			c := &Controller{cfg: cfg.Controller, head: cfg.Head}
+			fp := c.focalPoint(pose, math3d.Vector3{}, hexapod.Ground{})
+
+			// Relative to the body, it's straight ahead of the lens.
+			local := fp.MultiplyByMatrix44(pose.ToLocal())
+			assert.InDelta(t, 0, local.Distance(tc.want), 0.0001, "%s", local)
+			assert.InDelta(t, pose.Position.Y+tc.want.Y, fp.Y, 0.0001)
+		})
+	}
+}
+
+func TestWalkingLookScale(t *testing.T) {
+	tick := func(cfg config.Controller, in input) hexapod.State {
+		sa := sixaxis.New(nil)
+		in(sa)
+		c := NewScripted(sa, cfg, config.Default().Head)
+		c.Params = params.New()
+		assert.NoError(t, c.Boot())
+
+		state := parked()
+		assert.NoError(t, c.Tick(time.Unix(0, 0), &state))
+		return state
+	}
+
+	// How far the focal point moves at full right stick, with the left stick
+	// at the given position.
+	displacement := func(cfg config.Controller, y int32) float64 {
+		still := tick(cfg, func(sa *sixaxis.SA) { sa.LeftStick.Y = y })
+		look := tick(cfg, func(sa *sixaxis.SA) { sa.LeftStick.Y = y; sa.RightStick.X = 127 })
+		return look.LookAt.Distance(*still.LookAt)
+	}
+
+	cfg := config.Default().Controller
+
+	// Off by default.
+	assert.InDelta(t, cfg.HorizontalLookScale, displacement(cfg, 0), tolerance)
+	assert.InDelta(t, cfg.HorizontalLookScale, displacement(cfg, -127), tolerance)
+
+	cfg.WalkingLookScale = 0.5
+	assert.InDelta(t, cfg.HorizontalLookScale, displacement(cfg, 0), tolerance)
+	assert.InDelta(t, cfg.HorizontalLookScale*0.5, displacement(cfg, -127), tolerance)
+
+	// The offset (with R1 held) isn't scaled.
+	offset := func(y int32) math3d.Vector3 {
+		return tick(cfg, func(sa *sixaxis.SA) { sa.LeftStick.Y = y; sa.RightStick.X = 127; sa.R1 = 255 }).Offset
+	}
+	assert.Equal(t, math3d.Vector3{X: cfg.XOffsetScale}, offset(0))
+	assert.Equal(t, offset(0), offset(-127))
+}
+
+func TestTapTempo(t *testing.T) {
+	sa := sixaxis.New(nil)
+	c := NewScripted(sa, config.Default().Controller, config.Default().Head)
+	c.Params = params.New()
+	assert.NoError(t, c.Boot())
+
+	// The legs register the param, not the controller.
+	var bpm float64
+	assert.NoError(t, c.Params.Register(params.Param{
+		Name: "gait.bpm",
+		Type: params.Float,
+		Max:  300,
+		Get:  func() float64 { return bpm },
+		Set:  func(v float64) { bpm = v },
+	}))
+
+	state := parked()
+	now := time.Unix(0, 0)
+	tick := func(n int, press bool) {
+		for i := 0; i < n; i++ {
+			sa.Select = press
+			sa.L1 = 0
+			if press {
+				sa.L1 = 255
+			}
+			now = now.Add(time.Second / 60)
+			assert.NoError(t, c.Tick(now, &state))
+			c.Params.Apply()
+		}
+	}
+
+	// Four taps, half a second apart, is 120 bpm.
+	for i := 0; i < 4; i++ {
+		tick(5, true)
+		tick(25, false)
+	}
+	assert.Equal(t, 120.0, bpm)
+
+	// Holding it clears the tempo.
+	tick(62, true)
+	tick(1, false)
+	assert.Equal(t, 0.0, bpm)
+}
+
+func TestSetInput(t *testing.T) {
+	for _, in := range []hexapod.Input{
+		{},
+		{LeftX: -127, LeftY: 64, RightX: 12, RightY: -1, L2: 255, R2: 30},
+		{Buttons: hexapod.ButtonSelect | hexapod.ButtonCross | hexapod.ButtonL1},
+		{Buttons: 1<<13 - 1},
+	} {
+		sa := sixaxis.New(nil)
+		SetInput(sa, in)
+
+		c := NewScripted(sa, config.Default().Controller, config.Default().Head)
+		assert.Equal(t, in, c.input())
+	}
+
+	// The sixaxis itself has the values, in its own types.
+	sa := sixaxis.New(nil)
+	SetInput(sa, hexapod.Input{LeftX: -127, RightY: 64, L2: 255, Buttons: hexapod.ButtonUp})
+	assert.Equal(t, int32(-127), sa.LeftStick.X)
+	assert.Equal(t, int32(64), sa.RightStick.Y)
+	assert.Equal(t, int32(255), sa.L2)
+	assert.Equal(t, int32(0), sa.R2)
+	assert.Equal(t, int32(255), sa.Up)
+
+	// Buttons which aren't in the input are released.
+	sa.Triangle = 255
+	sa.Start = true
+	SetInput(sa, hexapod.Input{})
+	assert.Equal(t, int32(0), sa.Triangle)
+	assert.Equal(t, int32(0), sa.Up)
+	assert.False(t, sa.Start)
+}
+
+func BenchmarkTick(b *testing.B) {
+	sa := sixaxis.New(nil)
+	sa.LeftStick.Y = -127
+	sa.RightStick.X = 64
+
+	c := NewScripted(sa, config.Default().Controller, config.Default().Head)
+	c.Params = params.New()
+	assert.NoError(b, c.Boot())
+
+	state := &hexapod.State{}
+	now := time.Unix(0, 0)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		now = now.Add(time.Second / 60)
+		c.Tick(now, state)
+	}
+}
@@ -0,0 +1,62 @@
+package controller
+
+import (
+	"github.com/adammck/hexapod"
+	"github.com/adammck/hexapod/units"
+)
+
+// crouch tracks the automatic switch to the crouch gait, which happens when the
+// clearance is lowered to the crouch clearance, and is undone when it's raised
+// back above it (plus the hysteresis). See config.Controller.CrouchClearance.
+type crouch struct {
+
+	// Whether the clearance is low, i.e. it was lowered to the crouch clearance
+	// and hasn't been raised back above it yet. Only crossing it switches, so
+	// selecting another gait by hand while it's low sticks.
+	low bool
+
+	// Whether to switch back to the previous gait when it's raised, which is
+	// only if the switch was automatic, and no gait was selected since.
+	restore  bool
+	previous hexapod.Gait
+}
+
+// updateCrouch switches to (or back from) the crouch gait, if the clearance just
+// crossed the crouch clearance. Like selecting a gait by hand, this only changes
+// the state; the legs switch between step cycles.
+func (c *Controller) updateCrouch(state *hexapod.State) {
+	if c.cfg.CrouchClearance <= 0 {
+		return
+	}
+
+	g, ok := state.CrouchGait()
+	if !ok {
+		return
+	}
+
+	cur, _ := state.ActiveGait()
+	switch {
+	case !c.crouch.low && c.clearance <= c.cfg.CrouchClearance:
+		c.crouch.low = true
+		if cur.Name == g.Name || !g.Fits(c.clearance) {
+			return
+		}
+
+		log.Infof("clearance is %s, crouching with gait %s (was %s)", units.MM(c.clearance), g, cur)
+		c.crouch.restore = true
+		c.crouch.previous = cur
+		state.SetGait(g)
+		state.Publish(hexapod.EventGaitChanged, hexapod.Info, g)
+
+	case c.crouch.low && c.clearance > c.cfg.CrouchClearance+c.cfg.CrouchHysteresis:
+		c.crouch.low = false
+		if !c.crouch.restore || cur.Name != g.Name {
+			return
+		}
+
+		log.Infof("clearance is %s, switching back to gait %s", units.MM(c.clearance), c.crouch.previous)
+		c.crouch.restore = false
+		state.SetGait(c.crouch.previous)
+		state.Publish(hexapod.EventGaitChanged, hexapod.Info, c.crouch.previous)
+	}
+}
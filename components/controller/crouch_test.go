@@ -0,0 +1,100 @@
+package controller
+
+import (
+	"testing"
+
+	"github.com/adammck/hexapod"
+	"github.com/adammck/hexapod/config"
+	"github.com/adammck/sixaxis"
+	"github.com/stretchr/testify/assert"
+)
+
+var crawl = hexapod.Gait{Name: "crawl", MaxClearance: 35}
+
+func TestCrouch(t *testing.T) {
+	c := NewScripted(sixaxis.New(nil), config.Default().Controller, config.Default().Head)
+
+	state := parked()
+	assert.NoError(t, state.Gaits.Register(crawl))
+	state.SetGait(trot)
+
+	// Sets the clearance, and returns the active gait.
+	at := func(clearance float64) string {
+		c.clearance = clearance
+		c.updateCrouch(&state)
+		g, _ := state.ActiveGait()
+		return g.Name
+	}
+
+	// Returns the names of the events published so far.
+	events := func() []string {
+		var out []string
+		for _, e := range state.Published() {
+			out = append(out, e.Name)
+		}
+		return out
+	}
+
+	// Lowering it to the crouch clearance crouches, and raising it back just
+	// above doesn't switch back until it's past the hysteresis.
+	assert.Equal(t, "trot", at(30))
+	assert.Equal(t, "crawl", at(25))
+	assert.Equal(t, []string{hexapod.EventGaitChanged}, events())
+	assert.Equal(t, "crawl", at(35))
+	assert.Equal(t, "trot", at(40))
+	assert.Equal(t, []string{hexapod.EventGaitChanged, hexapod.EventGaitChanged}, events())
+
+	// Selecting a gait by hand while crouched means it isn't restored, and it
+	// isn't crouched again until the clearance crosses the threshold again.
+	assert.Equal(t, "crawl", at(20))
+	c.nextGait(&state, state.NextGait)
+	assert.Equal(t, "walk", at(15))
+	assert.Equal(t, "walk", at(40))
+	assert.Equal(t, "crawl", at(25))
+}
+
+func TestCrouchDisabled(t *testing.T) {
+	cfg := config.Default().Controller
+	cfg.CrouchClearance = 0
+	c := NewScripted(sixaxis.New(nil), cfg, config.Default().Head)
+
+	state := parked()
+	assert.NoError(t, state.Gaits.Register(crawl))
+	c.clearance = 10
+	c.updateCrouch(&state)
+
+	g, _ := state.ActiveGait()
+	assert.Equal(t, "walk", g.Name)
+}
+
+func TestNextGaitMaxClearance(t *testing.T) {
+	c := NewScripted(sixaxis.New(nil), config.Default().Controller, config.Default().Head)
+
+	state := parked()
+	assert.NoError(t, state.Gaits.Register(crawl))
+
+	// At the default clearance, the crawl is too low to select, so it wraps
+	// around; below its max, it's next after the trot (the gallop needs more).
+	state.SetGait(trot)
+	c.nextGait(&state, state.NextGait)
+	g, _ := state.ActiveGait()
+	assert.Equal(t, "walk", g.Name)
+
+	c.clearance = 30
+	state.SetGait(trot)
+	c.nextGait(&state, state.NextGait)
+	g, _ = state.ActiveGait()
+	assert.Equal(t, "crawl", g.Name)
+}
+
+func TestNextGaitSafeMode(t *testing.T) {
+	c := NewScripted(sixaxis.New(nil), config.Default().Controller, config.Default().Head)
+
+	state := parked()
+	state.SafeMode = true
+	c.nextGait(&state, state.NextGait)
+
+	g, _ := state.ActiveGait()
+	assert.Equal(t, "walk", g.Name)
+	assert.Empty(t, state.Published())
+}
@@ -0,0 +1,87 @@
+package controller
+
+import (
+	"math"
+	"time"
+
+	"github.com/adammck/hexapod/config"
+	"github.com/adammck/hexapod/units"
+)
+
+// duck lowers the clearance while walking forwards towards something low, like
+// the edge of a table, so the hex can walk under it. It's separate from the
+// controller's clearance, which is what the operator set via Up and Down, so
+// that can be restored once the hex is out the other side.
+//
+// Whatever is seen has to be (roughly) at the height of the body for the
+// rangefinder to see it, so the hex ducks until it can't see it any more, or
+// reaches the min clearance. It stays ducked until nothing has been seen for
+// the restore, by which time it's hopefully clear.
+type duck struct {
+	cfg config.Controller
+
+	// Whether the clearance is being lowered, and what to.
+	active bool
+	to     float64
+
+	// When the clearance was last lowered, and when something was last seen
+	// within the duck distance.
+	stepped time.Time
+	seen    time.Time
+
+	// Whether the operator has taken over (by pressing Up or Down) from the
+	// current duck. This lasts until nothing has been seen for the restore, so
+	// the hex doesn't duck again under the same thing.
+	cancelled bool
+}
+
+// clearance returns the clearance to target, given the operator's clearance,
+// the lowest which can be ducked to, whether the hex is walking forwards, and
+// the range (in mm) to whatever is ahead, or zero if nothing is.
+func (d *duck) clearance(now time.Time, operator, min float64, forward bool, rng float64) float64 {
+	seen := d.cfg.DuckDistance > 0 && rng > 0 && rng <= d.cfg.DuckDistance
+	if seen {
+		d.seen = now
+	}
+
+	switch {
+	case seen && forward && !d.cancelled:
+		if !d.active {
+			log.Infof("something %s ahead, ducking", units.MM(rng))
+			d.active = true
+			d.to = operator
+			d.stepped = time.Time{}
+		}
+
+		if d.to > min && now.Sub(d.stepped) >= d.cfg.DuckInterval.Duration {
+			d.to = math.Max(min, d.to-d.cfg.DuckStep)
+			d.stepped = now
+		}
+
+	case !seen && now.Sub(d.seen) >= d.cfg.DuckRestore.Duration:
+		if d.active {
+			log.Infof("nothing ahead for %s, restoring clearance to %s", d.cfg.DuckRestore.Duration, units.MM(operator))
+		}
+
+		d.active = false
+		d.cancelled = false
+	}
+
+	if !d.active {
+		return operator
+	}
+
+	// Never raise the clearance, e.g. if the param was lowered while ducking.
+	return math.Min(d.to, operator)
+}
+
+// cancel stops the current duck (if any), because the operator has changed the
+// clearance themself.
+func (d *duck) cancel() {
+	if d.active {
+		log.Info("clearance changed, no longer ducking")
+	}
+
+	d.active = false
+	d.cancelled = true
+}
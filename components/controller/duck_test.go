@@ -0,0 +1,167 @@
+package controller
+
+import (
+	"testing"
+	"time"
+
+	"github.com/adammck/hexapod/config"
+	"github.com/stretchr/testify/assert"
+)
+
+// step is a tick of a duck script: the range to whatever is ahead, whether the
+// hex is walking forwards, whether the operator pressed Up or Down (before the
+// tick), and the clearance which the tick should return.
+type step struct {
+	rng     float64
+	forward bool
+	cancel  bool
+	want    float64
+}
+
+// times repeats a step.
+func times(n int, s step) []step {
+	var steps []step
+	for i := 0; i < n; i++ {
+		steps = append(steps, s)
+	}
+
+	return steps
+}
+
+// script returns the steps given, in order.
+func script(parts ...[]step) []step {
+	var steps []step
+	for _, p := range parts {
+		steps = append(steps, p...)
+	}
+
+	return steps
+}
+
+func TestDuck(t *testing.T) {
+
+	// Each step is 100ms, and the clearance is lowered 5mm at most every 200ms.
+	cfg := config.Default().Controller
+	cfg.DuckDistance = 300
+	cfg.DuckStep = 5
+	cfg.DuckInterval = config.Duration{200 * time.Millisecond}
+	cfg.DuckRestore = config.Duration{time.Second}
+
+	const operator, min = 40.0, 20.0
+
+	cases := []struct {
+		name  string
+		cfg   func(c *config.Controller)
+		steps []step
+	}{
+		{
+			name: "ducks in steps until the path is clear, then restores",
+			steps: script(
+				times(2, step{rng: 0, forward: true, want: 40}),
+				times(1, step{rng: 600, forward: true, want: 40}),
+				times(2, step{rng: 280, forward: true, want: 35}),
+				times(2, step{rng: 250, forward: true, want: 30}),
+				times(1, step{rng: 220, forward: true, want: 25}),
+
+				// It's clear underneath, so walk under it for a while.
+				times(9, step{rng: 0, forward: true, want: 25}),
+				times(5, step{rng: 0, forward: true, want: 40}),
+			),
+		},
+		{
+			name: "stops at the min clearance",
+			steps: script(
+				times(2, step{rng: 250, forward: true, want: 35}),
+				times(2, step{rng: 250, forward: true, want: 30}),
+				times(2, step{rng: 250, forward: true, want: 25}),
+				times(10, step{rng: 250, forward: true, want: 20}),
+			),
+		},
+		{
+			name: "doesn't duck below the min clearance, even in one step",
+			cfg: func(c *config.Controller) {
+				c.DuckStep = 15
+			},
+			steps: script(
+				times(2, step{rng: 250, forward: true, want: 25}),
+				times(5, step{rng: 250, forward: true, want: 20}),
+			),
+		},
+		{
+			name: "only ducks when walking forwards",
+			steps: script(
+				times(5, step{rng: 250, forward: false, want: 40}),
+				times(1, step{rng: 250, forward: true, want: 35}),
+			),
+		},
+		{
+			name: "stays ducked when stopped underneath, until nothing is seen",
+			steps: script(
+				times(2, step{rng: 250, forward: true, want: 35}),
+				times(1, step{rng: 250, forward: true, want: 30}),
+				times(20, step{rng: 150, forward: false, want: 30}),
+				times(9, step{rng: 0, forward: false, want: 30}),
+				times(1, step{rng: 0, forward: false, want: 40}),
+			),
+		},
+		{
+			name: "things seen while restoring delay it",
+			steps: script(
+				times(1, step{rng: 250, forward: true, want: 35}),
+				times(5, step{rng: 0, forward: true, want: 35}),
+				times(1, step{rng: 250, forward: false, want: 35}),
+				times(9, step{rng: 0, forward: true, want: 35}),
+				times(1, step{rng: 0, forward: true, want: 40}),
+			),
+		},
+		{
+			name: "ducks again after restoring",
+			steps: script(
+				times(1, step{rng: 250, forward: true, want: 35}),
+				times(9, step{rng: 0, forward: true, want: 35}),
+				times(1, step{rng: 0, forward: true, want: 40}),
+				times(1, step{rng: 250, forward: true, want: 35}),
+			),
+		},
+		{
+			name: "the operator cancels it until nothing is seen",
+			steps: script(
+				times(2, step{rng: 250, forward: true, want: 35}),
+				times(1, step{rng: 250, forward: true, want: 30}),
+				times(1, step{rng: 250, forward: true, cancel: true, want: 40}),
+				times(10, step{rng: 250, forward: true, want: 40}),
+				times(10, step{rng: 0, forward: true, want: 40}),
+				times(1, step{rng: 250, forward: true, want: 35}),
+			),
+		},
+		{
+			name: "a duck distance of zero disables it",
+			cfg: func(c *config.Controller) {
+				c.DuckDistance = 0
+			},
+			steps: script(
+				times(5, step{rng: 1, forward: true, want: 40}),
+			),
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := cfg
+			if tc.cfg != nil {
+				tc.cfg(&c)
+			}
+
+			d := duck{cfg: c}
+			now := time.Unix(0, 0)
+			for i, s := range tc.steps {
+				if s.cancel {
+					d.cancel()
+				}
+
+				now = now.Add(100 * time.Millisecond)
+				assert.Equal(t, s.want, d.clearance(now, operator, min, s.forward, s.rng), "step %d", i)
+			}
+		})
+	}
+}
@@ -1,11 +1,8 @@
 package controller
 
-type Latch struct {
-	val bool
-}
+import (
+	"github.com/adammck/hexapod/buttons"
+)
 
-func (l *Latch) Run(v bool) bool {
-	r := v && !l.val
-	l.val = v
-	return r
-}
+// Latch is an alias of buttons.Latch, which used to live here.
+type Latch = buttons.Latch
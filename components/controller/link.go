@@ -0,0 +1,34 @@
+package controller
+
+import (
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// link wraps the reader which the sixaxis is read from, to keep track of when
+// it was last read from successfully. The sixaxis doesn't say when it's gone
+// quiet, since it keeps its last state, but a live one reports continuously.
+type link struct {
+	r    io.Reader
+	last int64 // UnixNano, or zero if never read
+}
+
+func (l *link) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	if n > 0 {
+		atomic.StoreInt64(&l.last, time.Now().UnixNano())
+	}
+
+	return n, err
+}
+
+// read returns when the reader was last read from, or zero if it never was.
+func (l *link) read() time.Time {
+	ns := atomic.LoadInt64(&l.last)
+	if ns == 0 {
+		return time.Time{}
+	}
+
+	return time.Unix(0, ns)
+}
@@ -0,0 +1,145 @@
+package controller
+
+import (
+	"math"
+	"time"
+
+	"github.com/adammck/hexapod"
+	"github.com/adammck/hexapod/config"
+	"github.com/adammck/hexapod/math3d"
+)
+
+// How close (in mm, or degrees) to where it started the chassis must get after
+// posing for it to count as back there, since the smoothing never quite gets
+// all the way.
+const poseEpsilon = 0.01
+
+// bodyPose is how far the chassis has been moved on its feet while posing, from
+// where it was when posing started.
+type bodyPose struct {
+	offset math3d.Vector3
+	pitch  float64
+	bank   float64
+	yaw    float64
+}
+
+// posing tracks the posing mode, for photography, in which the hex stays where
+// it is on planted feet, and the sticks move the chassis on them instead, very
+// slowly and within tight limits. Once it's toggled off, the chassis eases back
+// to where it started, and the hex carries on as usual from there. See
+// config.Controller.PoseSpeed.
+type posing struct {
+	engaged bool
+	last    time.Time
+
+	// Where the sticks have moved the chassis to, and where it's been smoothed
+	// to so far, which is where it actually goes.
+	set bodyPose
+	cur bodyPose
+
+	// The offset which the chassis was at when posing started, which it's
+	// moved relative to, and restored to afterwards.
+	base        math3d.Vector3
+	baseHeading float64
+}
+
+// toggle engages the posing mode, unless refuse returns a reason not to, or
+// disengages it if it's engaged.
+func (p *posing) toggle(state *hexapod.State, refuse func() string) {
+	if p.engaged {
+		log.Info("disengaging posing mode")
+		p.engaged = false
+		return
+	}
+
+	if reason := refuse(); reason != "" {
+		log.Warnf("not engaging posing mode: %s", reason)
+		return
+	}
+
+	// Posing again while still easing back carries on from there.
+	if !p.active() {
+		p.base = state.Offset
+		p.baseHeading = state.OffsetHeading
+		p.last = time.Time{}
+	}
+
+	log.Info("engaging posing mode")
+	p.engaged = true
+}
+
+// abort disengages the posing mode (if it's engaged) for the given reason.
+func (p *posing) abort(reason string) {
+	if !p.engaged {
+		return
+	}
+
+	log.Warnf("aborting posing mode: %s", reason)
+	p.engaged = false
+}
+
+// active returns true while posing, or easing back afterwards.
+func (p *posing) active() bool {
+	return p.engaged || p.cur != (bodyPose{})
+}
+
+// update moves the chassis by the positions of the left stick (move) and the
+// right stick (tilt), and the difference between the triggers (turn), while
+// engaged, or back to where it started if not, and smooths it.
+func (p *posing) update(now time.Time, cfg config.Controller, move, tilt math3d.Vector3, turn float64) {
+	dt := 0.0
+	if !p.last.IsZero() {
+		dt = now.Sub(p.last).Seconds()
+	}
+	p.last = now
+
+	if p.engaged {
+		s := &p.set
+		s.offset.X += move.X * cfg.PoseSpeed * dt
+		s.offset.Z += move.Z * cfg.PoseSpeed * dt
+		s.offset = s.offset.ClampLength(cfg.PoseReach)
+
+		s.pitch = clamp(s.pitch+tilt.Z*cfg.PoseTurnSpeed*dt, cfg.PosePitch)
+		s.bank = clamp(s.bank+tilt.X*cfg.PoseTurnSpeed*dt, cfg.PoseBank)
+		s.yaw = clamp(s.yaw+turn*cfg.PoseTurnSpeed*dt, cfg.PoseYaw)
+	} else {
+		p.set = bodyPose{}
+	}
+
+	// Ease towards it, exponentially, so the chassis never jerks, however the
+	// sticks are flicked.
+	k := 1.0
+	if sm := cfg.PoseSmoothing.Duration; sm > 0 {
+		k = 1 - math.Exp(-dt/sm.Seconds())
+	}
+
+	c := &p.cur
+	c.offset = *c.offset.Add(p.set.offset.Subtract(c.offset).Scaled(k))
+	c.pitch = lerp(c.pitch, p.set.pitch, k)
+	c.bank = lerp(c.bank, p.set.bank, k)
+	c.yaw = lerp(c.yaw, p.set.yaw, k)
+
+	if !p.engaged && c.offset.Magnitude() < poseEpsilon && math.Abs(c.pitch) < poseEpsilon && math.Abs(c.bank) < poseEpsilon && math.Abs(c.yaw) < poseEpsilon {
+		log.Info("posing mode finished")
+		p.cur = bodyPose{}
+	}
+}
+
+// apply holds the hex where it is, at the given clearance, and moves the
+// chassis on its feet to where it's been smoothed to.
+func (p *posing) apply(state *hexapod.State, clearance float64) {
+	state.Target = state.Pose
+	state.Target.Position.Y = clearance
+	state.Target.Pitch = p.cur.pitch
+	state.Target.Bank = p.cur.bank
+	state.Offset = *p.base.Add(p.cur.offset)
+	state.OffsetHeading = p.baseHeading + p.cur.yaw
+
+	// Holding still for a photo looks idle, but it isn't.
+	state.KeepAwake = true
+}
+
+// clamp returns v, limited to between -limit and limit.
+func clamp(v, limit float64) float64 {
+	return math.Max(-limit, math.Min(v, limit))
+}
@@ -0,0 +1,118 @@
+package controller
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/adammck/hexapod"
+	"github.com/adammck/hexapod/config"
+	"github.com/adammck/hexapod/math3d"
+	"github.com/adammck/hexapod/params"
+	"github.com/adammck/sixaxis"
+	"github.com/stretchr/testify/assert"
+)
+
+// l1Left presses L1 + left, which toggles the posing mode.
+func l1Left(sa *sixaxis.SA) {
+	sa.L1 = 255
+	sa.Left = 255
+}
+
+func TestPosing(t *testing.T) {
+	cfg := config.Default().Controller
+	sa := sixaxis.New(nil)
+	c := NewScripted(sa, cfg, config.Default().Head)
+	c.Params = params.New()
+	assert.NoError(t, c.Boot())
+
+	state := parked()
+	pose := state.Pose
+	now := time.Unix(0, 0)
+	tick := func(in input) {
+		if in != nil {
+			in(sa)
+		}
+		now = now.Add(time.Second / 60)
+		assert.NoError(t, c.Tick(now, &state))
+	}
+
+	// The feet stay exactly where they are, since the hex never walks (or
+	// turns) anywhere, and the chassis stays within the envelope.
+	held := func() {
+		assert.Equal(t, pose.Position.X, state.Target.Position.X)
+		assert.Equal(t, pose.Position.Z, state.Target.Position.Z)
+		assert.Equal(t, pose.Heading, state.Target.Heading)
+		assert.LessOrEqual(t, state.Offset.Magnitude(), cfg.PoseReach+tolerance)
+		assert.LessOrEqual(t, math.Abs(state.Target.Pitch), cfg.PosePitch+tolerance)
+		assert.LessOrEqual(t, math.Abs(state.Target.Bank), cfg.PoseBank+tolerance)
+		assert.LessOrEqual(t, math.Abs(state.OffsetHeading), cfg.PoseYaw+tolerance)
+	}
+
+	tick(l1Left)
+	tick(release)
+	assert.True(t, c.posing.engaged)
+
+	// Push everything to the limits, in one corner and then the other, for
+	// long enough to get there.
+	for _, in := range []input{
+		func(sa *sixaxis.SA) {
+			sa.LeftStick.X, sa.LeftStick.Y = 127, -127
+			sa.RightStick.X, sa.RightStick.Y = 127, -127
+			sa.R2 = 255
+		},
+		func(sa *sixaxis.SA) {
+			*sa = *sixaxis.New(nil)
+			sa.LeftStick.X, sa.LeftStick.Y = -127, 127
+			sa.RightStick.X, sa.RightStick.Y = -127, 127
+			sa.L2 = 255
+		},
+	} {
+		tick(in)
+		for i := 0; i < 60*20; i++ {
+			tick(nil)
+			held()
+		}
+	}
+
+	assert.InDelta(t, cfg.PoseReach, state.Offset.Magnitude(), tolerance)
+	assert.InDelta(t, -cfg.PosePitch, state.Target.Pitch, tolerance)
+	assert.InDelta(t, -cfg.PoseBank, state.Target.Bank, tolerance)
+	assert.InDelta(t, -cfg.PoseYaw, state.OffsetHeading, tolerance)
+
+	// Toggling it off eases the chassis back to where it started, on the same
+	// footholds, before the sticks walk the hex anywhere again.
+	tick(release)
+	tick(l1Left)
+	tick(release)
+	assert.False(t, c.posing.engaged)
+	for c.posing.active() {
+		held()
+		tick(nil)
+	}
+
+	assert.Equal(t, math3d.Vector3{}, state.Offset)
+	assert.Equal(t, 0.0, state.OffsetHeading)
+	assert.Equal(t, 0.0, state.Target.Pitch)
+	assert.Equal(t, 0.0, state.Target.Bank)
+}
+
+func TestPosingRefused(t *testing.T) {
+	for name, prior := range map[string]func(sa *sixaxis.SA, s *hexapod.State){
+		"walking":   func(sa *sixaxis.SA, s *hexapod.State) { sa.LeftStick.Y = -127 },
+		"saturated": func(sa *sixaxis.SA, s *hexapod.State) { s.Saturated[0] = true },
+	} {
+		t.Run(name, func(t *testing.T) {
+			sa := sixaxis.New(nil)
+			c := NewScripted(sa, config.Default().Controller, config.Default().Head)
+			c.Params = params.New()
+			assert.NoError(t, c.Boot())
+
+			state := parked()
+			prior(sa, &state)
+			l1Left(sa)
+			assert.NoError(t, c.Tick(time.Unix(0, 0), &state))
+			assert.False(t, c.posing.active())
+		})
+	}
+}
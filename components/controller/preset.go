@@ -0,0 +1,151 @@
+package controller
+
+import (
+	"math"
+	"time"
+
+	"github.com/adammck/hexapod"
+	"github.com/adammck/hexapod/math3d"
+)
+
+// presetPose is the targets which a preset holds.
+type presetPose struct {
+	clearance float64
+	pitch     float64
+	bank      float64
+	lookAt    math3d.Vector3
+}
+
+// preset is a pose which the hex can be put in with a button, like the
+// inspection pose. Pressing the button engages it, and releasing the button
+// disengages it if it was held for the hold time; otherwise it stays engaged
+// until the button is pressed again. It's separate from the controller so it
+// can be tested without a sixaxis.
+//
+// It's mixed in over the targets which the controller would otherwise set,
+// ramping in when engaged and back out when not, so they (e.g. the clearance)
+// are restored afterwards without the preset having to keep them. While it's
+// mixed in at all, the hex stays where it is.
+type preset struct {
+	name string
+	ramp time.Duration
+
+	// Returns the pose to hold, given the state.
+	pose func(state *hexapod.State) presetPose
+
+	// Whether the preset is engaged, and how far (from zero to one) it's mixed
+	// in, as of the last tick.
+	engaged bool
+	mix     float64
+	last    time.Time
+
+	// Whether the button was pressed on the last tick, when it was pressed, and
+	// whether that press engaged the preset (rather than toggling it off).
+	down    bool
+	since   time.Time
+	engager bool
+
+	// The focal point which State.LookAt points to while mixed in, which is
+	// kept here rather than allocated every tick, and the one it's mixed from.
+	lookAt  math3d.Vector3
+	base    math3d.Vector3
+	hasBase bool
+}
+
+// button is called every tick with whether the button is pressed. If pressing
+// it would engage the preset, refuse is called, and the press is ignored if it
+// returns a reason not to.
+func (p *preset) button(now time.Time, v bool, refuse func() string) {
+	switch {
+	case v && !p.down:
+		p.since = now
+		p.engager = false
+
+		if p.engaged {
+			log.Infof("disengaging %s pose", p.name)
+			p.engaged = false
+		} else if reason := refuse(); reason != "" {
+			log.Warnf("not engaging %s pose: %s", p.name, reason)
+		} else {
+			log.Infof("engaging %s pose", p.name)
+			p.engaged = true
+			p.engager = true
+		}
+
+	case !v && p.down:
+		if p.engaged && p.engager && now.Sub(p.since) >= holdTime {
+			log.Infof("released, disengaging %s pose", p.name)
+			p.engaged = false
+		}
+	}
+
+	p.down = v
+}
+
+// abort disengages the preset (if it's engaged) for the given reason.
+func (p *preset) abort(reason string) {
+	if !p.engaged {
+		return
+	}
+
+	log.Warnf("aborting %s pose: %s", p.name, reason)
+	p.engaged = false
+}
+
+// apply ramps the mix towards the preset if it's engaged, or away if not, and
+// mixes the preset's pose into the targets on the state.
+func (p *preset) apply(now time.Time, state *hexapod.State) {
+	step := 1.0
+	if p.ramp > 0 {
+		step = 0
+		if !p.last.IsZero() {
+			step = now.Sub(p.last).Seconds() / p.ramp.Seconds()
+		}
+	}
+	p.last = now
+
+	if p.engaged {
+		p.mix = math.Min(1, p.mix+step)
+	} else {
+		p.mix = math.Max(0, p.mix-step)
+	}
+
+	if p.mix == 0 {
+		return
+	}
+
+	pp := p.pose(state)
+
+	state.Target.Position.X = state.Pose.Position.X
+	state.Target.Position.Z = state.Pose.Position.Z
+	state.Target.Heading = state.Pose.Heading
+	state.Target.Position.Y = lerp(state.Target.Position.Y, pp.clearance, p.mix)
+	state.Target.Pitch = lerp(state.Target.Pitch, pp.pitch, p.mix)
+	state.Target.Bank = lerp(state.Target.Bank, pp.bank, p.mix)
+
+	// The focal point isn't set every tick (e.g. while R1 is held), in which
+	// case it's still ours from the last one, so mix from the last which wasn't.
+	if state.LookAt != nil && state.LookAt != &p.lookAt {
+		p.base = *state.LookAt
+		p.hasBase = true
+	}
+
+	if p.hasBase {
+		p.lookAt = *p.base.Add(pp.lookAt.Subtract(p.base).Scaled(p.mix))
+	} else {
+		p.lookAt = pp.lookAt
+	}
+	state.LookAt = &p.lookAt
+
+	// Holding still in a pose looks idle, but it isn't.
+	state.KeepAwake = true
+}
+
+// active returns true if the preset is mixed in at all.
+func (p *preset) active() bool {
+	return p.mix > 0
+}
+
+func lerp(a, b, t float64) float64 {
+	return a + (b-a)*t
+}
@@ -0,0 +1,162 @@
+package controller
+
+import (
+	"testing"
+	"time"
+
+	"github.com/adammck/hexapod"
+	"github.com/adammck/hexapod/config"
+	"github.com/adammck/hexapod/math3d"
+	"github.com/adammck/hexapod/params"
+	"github.com/adammck/sixaxis"
+	"github.com/stretchr/testify/assert"
+)
+
+// The focal point of the inspection pose, from the parked pose: on the ground,
+// 250mm ahead.
+var inspectAt = math3d.Vector3{X: 225, Y: 0, Z: 166.506}
+
+func TestInspection(t *testing.T) {
+	setup := func() (*sixaxis.SA, *Controller, *hexapod.State, func(n int, in input)) {
+		sa := sixaxis.New(nil)
+		c := NewScripted(sa, config.Default().Controller, config.Default().Head)
+		c.Params = params.New()
+		assert.NoError(t, c.Boot())
+
+		state := parked()
+		now := time.Unix(0, 0)
+		tick := func(n int, in input) {
+			for i := 0; i < n; i++ {
+				if in != nil {
+					in(sa)
+				}
+
+				// The legs reset this every tick.
+				state.KeepAwake = false
+
+				now = now.Add(time.Second / 60)
+				assert.NoError(t, c.Tick(now, &state))
+			}
+		}
+
+		return sa, c, &state, tick
+	}
+
+	triangle := func(sa *sixaxis.SA) { sa.Triangle = 255 }
+
+	// inPose checks that the state is (all the way) in the inspection pose.
+	inPose := func(t *testing.T, state *hexapod.State) {
+		assert.InDelta(t, 100, state.Target.Position.X, tolerance)
+		assert.InDelta(t, 120, state.Target.Position.Y, tolerance)
+		assert.InDelta(t, -50, state.Target.Position.Z, tolerance)
+		assert.InDelta(t, 30, state.Target.Heading, tolerance)
+		assert.InDelta(t, 10, state.Target.Pitch, tolerance)
+		assert.InDelta(t, 0, state.Target.Bank, tolerance)
+		assert.InDelta(t, 0, state.LookAt.Distance(inspectAt), tolerance)
+		assert.True(t, state.KeepAwake)
+	}
+
+	// restored checks that the state is back where it was before.
+	restored := func(t *testing.T, state *hexapod.State) {
+		assert.InDelta(t, 100, state.Target.Position.X, tolerance)
+		assert.InDelta(t, 40, state.Target.Position.Y, tolerance)
+		assert.InDelta(t, -50, state.Target.Position.Z, tolerance)
+		assert.InDelta(t, 0, state.Target.Pitch, tolerance)
+		assert.InDelta(t, 0, state.LookAt.Distance(ahead), tolerance)
+		assert.False(t, state.KeepAwake)
+	}
+
+	t.Run("held", func(t *testing.T) {
+		_, c, state, tick := setup()
+
+		// It ramps in over a second.
+		tick(30, triangle)
+		assert.Greater(t, state.Target.Position.Y, 60.0)
+		assert.Less(t, state.Target.Position.Y, 100.0)
+		assert.Greater(t, state.Target.Pitch, 2.0)
+		assert.Less(t, state.Target.Pitch, 8.0)
+
+		// And holds while triangle is held, ignoring the sticks.
+		tick(40, triangle)
+		inPose(t, state)
+		tick(60, func(sa *sixaxis.SA) { sa.Triangle = 255; sa.LeftStick.Y = -127 })
+		inPose(t, state)
+
+		// Letting go ramps back out.
+		tick(30, release)
+		assert.Greater(t, state.Target.Position.Y, 60.0)
+		assert.Less(t, state.Target.Position.Y, 100.0)
+
+		tick(31, release)
+		restored(t, state)
+		assert.False(t, c.inspect.active())
+	})
+
+	t.Run("toggled", func(t *testing.T) {
+		_, _, state, tick := setup()
+
+		// A short press stays in the pose once released.
+		tick(5, triangle)
+		tick(120, release)
+		inPose(t, state)
+
+		// Until it's pressed again.
+		tick(5, triangle)
+		tick(61, release)
+		restored(t, state)
+	})
+
+	t.Run("restores the clearance which was set before", func(t *testing.T) {
+		_, _, state, tick := setup()
+		tick(1, func(sa *sixaxis.SA) { sa.Down = 255 })
+		tick(1, release)
+
+		tick(5, triangle)
+		tick(120, release)
+		inPose(t, state)
+
+		tick(5, triangle)
+		tick(61, release)
+		assert.InDelta(t, 30, state.Target.Position.Y, tolerance)
+	})
+
+	t.Run("refuses while walking", func(t *testing.T) {
+		_, c, state, tick := setup()
+		tick(5, func(sa *sixaxis.SA) { sa.LeftStick.Y = -127; sa.Triangle = 255 })
+		tick(5, release)
+		assert.False(t, c.inspect.active())
+		assert.InDelta(t, 40, state.Target.Position.Y, tolerance)
+
+		// Or still finishing a step, after the stick is released.
+		tick(5, func(sa *sixaxis.SA) { state.Pose.Position.Z += 5; sa.Triangle = 255 })
+		tick(5, release)
+		assert.False(t, c.inspect.active())
+	})
+
+	t.Run("refuses in safe mode", func(t *testing.T) {
+		_, c, state, tick := setup()
+		state.SafeMode = true
+		tick(5, triangle)
+		tick(60, release)
+		assert.False(t, c.inspect.active())
+		assert.InDelta(t, 40, state.Target.Position.Y, tolerance)
+	})
+
+	t.Run("aborts when a leg is saturated", func(t *testing.T) {
+		_, c, state, tick := setup()
+		tick(5, triangle)
+		tick(60, release)
+		inPose(t, state)
+
+		state.Saturated[4] = true
+		tick(1, nil)
+		state.Saturated[4] = false
+		tick(61, nil)
+		restored(t, state)
+		assert.False(t, c.inspect.engaged)
+
+		// Which isn't a toggle, so the next press engages it again.
+		tick(5, triangle)
+		assert.True(t, c.inspect.engaged)
+	})
+}
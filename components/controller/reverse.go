@@ -0,0 +1,57 @@
+package controller
+
+import (
+	"time"
+
+	"github.com/adammck/hexapod/math3d"
+)
+
+const (
+
+	// How far (as a fraction of full travel) the left stick must be pulled
+	// back to start reversing, and how far it must return towards neutral (or
+	// past it) to stop. The gap between them is so the stick wobbling around
+	// either doesn't flip the speed limit and the focal point back and forth.
+	reverseEngage  = 0.3
+	reverseRelease = 0.1
+)
+
+// reverse tracks whether the hex is being told to walk backwards, which limits
+// the speed of it, and (if it lasts long enough) moves the focal point behind.
+type reverse struct {
+	active bool
+	since  time.Time
+}
+
+// update takes the Z component of the left stick (which is negative when it's
+// pulled back), and returns whether the hex is reversing.
+func (r *reverse) update(now time.Time, z float64) bool {
+	switch {
+	case !r.active && z <= -reverseEngage:
+		r.active = true
+		r.since = now
+
+	case r.active && z > -reverseRelease:
+		r.active = false
+	}
+
+	return r.active
+}
+
+// sustained returns true if the hex has been reversing for at least the given
+// duration.
+func (r *reverse) sustained(now time.Time, d time.Duration) bool {
+	return r.active && now.Sub(r.since) >= d
+}
+
+// limit returns the given movement (in the body space) with its backwards
+// component scaled by the given fraction, while reversing. The sideways
+// component is left alone, so walking diagonally backwards turns a bit more
+// sideways rather than slowing down evenly.
+func (r *reverse) limit(move math3d.Vector3, speed float64) math3d.Vector3 {
+	if r.active && move.Z < 0 {
+		move.Z *= speed
+	}
+
+	return move
+}
@@ -0,0 +1,133 @@
+package controller
+
+import (
+	"testing"
+	"time"
+
+	"github.com/adammck/hexapod/config"
+	"github.com/adammck/hexapod/math3d"
+	"github.com/adammck/hexapod/params"
+	"github.com/adammck/sixaxis"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReverseHysteresis(t *testing.T) {
+	r := reverse{}
+	now := time.Unix(0, 0)
+
+	for _, tc := range []struct {
+		z    float64
+		want bool
+	}{
+		{0, false},
+		{-0.2, false},
+
+		// Engages past the threshold, and stays engaged while wobbling back
+		// towards neutral, until it's nearly there.
+		{-0.3, true},
+		{-0.15, true},
+		{-0.4, true},
+		{-0.1, true},
+		{-0.05, false},
+
+		// Doesn't flicker around zero.
+		{0.05, false},
+		{-0.05, false},
+		{-0.25, false},
+		{-1, true},
+		{1, false},
+	} {
+		assert.Equal(t, tc.want, r.update(now, tc.z), "z=%.2f", tc.z)
+	}
+}
+
+func TestReverseSustained(t *testing.T) {
+	r := reverse{}
+	t0 := time.Unix(0, 0)
+	d := 500 * time.Millisecond
+
+	assert.False(t, r.sustained(t0, 0))
+	r.update(t0, -1)
+	assert.True(t, r.sustained(t0, 0))
+	assert.False(t, r.sustained(t0.Add(d-time.Millisecond), d))
+	assert.True(t, r.sustained(t0.Add(d), d))
+
+	// Nudging the stick around while reversing doesn't reset the delay.
+	r.update(t0.Add(d), -0.2)
+	assert.True(t, r.sustained(t0.Add(d), d))
+
+	r.update(t0.Add(2*d), 0)
+	assert.False(t, r.sustained(t0.Add(2*d), 0))
+}
+
+func TestReverseLimit(t *testing.T) {
+	r := reverse{}
+	back := math3d.Vector3{X: 0.5, Z: -1}
+	fwd := math3d.Vector3{X: 0.5, Z: 1}
+
+	// Nothing is limited until it's reversing.
+	assert.Equal(t, back, r.limit(back, 0.5))
+
+	r.update(time.Unix(0, 0), back.Z)
+	assert.Equal(t, math3d.Vector3{X: 0.5, Z: -0.5}, r.limit(back, 0.5))
+	assert.Equal(t, back, r.limit(back, 1))
+	assert.Equal(t, fwd, r.limit(fwd, 0.5))
+}
+
+func TestReverse(t *testing.T) {
+	cfg := config.Default().Controller
+	cfg.ReverseSpeed = 0.5
+	cfg.ReverseLook = true
+	cfg.ReverseLookDelay = config.Duration{Duration: 500 * time.Millisecond}
+
+	sa := sixaxis.New(nil)
+	c := NewScripted(sa, cfg, config.Default().Head)
+	c.Params = params.New()
+	assert.NoError(t, c.Boot())
+
+	// Ticks from the parked pose with the left stick at the given Y (which is
+	// positive when pulled back), and returns how far the target moved, and
+	// the focal point in the body space.
+	t0 := time.Unix(0, 0)
+	tick := func(at time.Duration, y int32) (float64, math3d.Vector3) {
+		sa.LeftStick.Y = y
+		state := parked()
+		assert.NoError(t, c.Tick(t0.Add(at), &state))
+
+		moved := state.Target.Position.Subtract(state.Pose.Position)
+		moved.Y = 0
+
+		ref := state.Pose
+		ref.Position.Y = c.clearance
+		return moved.Magnitude(), state.LookAt.MultiplyByMatrix44(ref.ToLocal())
+	}
+
+	head := config.Default().Head
+	ahead := math3d.Vector3{X: head.Lens[0], Y: head.Lens[1], Z: head.FocalDistance}
+	behind := math3d.Vector3{X: cfg.ReverseFocalHorizontalOffset, Y: cfg.ReverseFocalVerticalOffset, Z: cfg.ReverseFocalDistance}
+
+	// Forwards is full speed, and the head looks ahead.
+	d, look := tick(0, -127)
+	assert.InDelta(t, cfg.MoveSpeed, d, tolerance)
+	assert.InDelta(t, 0, look.Distance(ahead), tolerance)
+
+	// Backwards is half speed straight away, but the head keeps looking ahead
+	// until it's been reversing for the delay.
+	d, look = tick(100*time.Millisecond, 127)
+	assert.InDelta(t, cfg.MoveSpeed/2, d, tolerance)
+	assert.InDelta(t, 0, look.Distance(ahead), tolerance)
+
+	_, look = tick(599*time.Millisecond, 127)
+	assert.InDelta(t, 0, look.Distance(ahead), tolerance)
+
+	_, look = tick(600*time.Millisecond, 127)
+	assert.InDelta(t, 0, look.Distance(behind), tolerance)
+
+	// Easing off (but not all the way) keeps it.
+	_, look = tick(700*time.Millisecond, 30)
+	assert.InDelta(t, 0, look.Distance(behind), tolerance)
+
+	// Letting go looks ahead again.
+	_, look = tick(800*time.Millisecond, 0)
+	assert.InDelta(t, 0, look.Distance(ahead), tolerance)
+}
@@ -0,0 +1,74 @@
+package controller
+
+import (
+	"time"
+)
+
+const (
+
+	// How long a button must be held before it counts as held.
+	holdTime = time.Second
+
+	// How long after a tap is released the next one must start, to count as a
+	// double tap.
+	doubleTapWindow = 400 * time.Millisecond
+)
+
+// Gesture is what a Tapper saw.
+type Gesture int
+
+const (
+	NoGesture Gesture = iota
+	Tap
+	DoubleTap
+	Hold
+)
+
+// Tapper tells taps, double taps, and holds of a button (or chord) apart, like
+// Latch does for presses. A tap is only reported once the double tap window
+// has passed without another, so it's a little late.
+type Tapper struct {
+	down  bool
+	since time.Time
+	held  bool
+
+	// Whether a tap has been released, but not reported yet, and when.
+	tapped bool
+	upAt   time.Time
+}
+
+// Run is called every tick with whether the button is pressed, and returns
+// what (if anything) was completed at that time.
+func (t *Tapper) Run(now time.Time, v bool) Gesture {
+	switch {
+	case v && !t.down:
+		t.down = true
+		t.since = now
+		t.held = false
+
+	case v && !t.held && now.Sub(t.since) >= holdTime:
+		t.held = true
+		t.tapped = false
+		return Hold
+
+	case !v && t.down:
+		t.down = false
+		if t.held {
+			break
+		}
+
+		if t.tapped {
+			t.tapped = false
+			return DoubleTap
+		}
+
+		t.tapped = true
+		t.upAt = now
+
+	case !v && t.tapped && now.Sub(t.upAt) > doubleTapWindow:
+		t.tapped = false
+		return Tap
+	}
+
+	return NoGesture
+}
@@ -0,0 +1,46 @@
+package controller
+
+import (
+	"math"
+	"time"
+)
+
+const (
+
+	// How many taps it takes to set a tempo.
+	tempoTaps = 4
+
+	// The longest gap between taps which counts towards the same tempo. This
+	// is 30 bpm.
+	tempoGap = 2 * time.Second
+)
+
+// Tempo turns taps into a tempo (in beats per minute), like tapping along to a
+// song. Taps which are too far apart start again.
+type Tempo struct {
+	taps []time.Time
+}
+
+// Tap records a tap at the given time, and returns the tempo once enough taps
+// have been recorded in a row, rounded to a tenth of a beat per minute. The
+// taps are forgotten once a tempo is returned, so the next starts again.
+func (t *Tempo) Tap(now time.Time) (float64, bool) {
+	if n := len(t.taps); n > 0 && now.Sub(t.taps[n-1]) > tempoGap {
+		t.taps = t.taps[:0]
+	}
+
+	t.taps = append(t.taps, now)
+	if len(t.taps) < tempoTaps {
+		return 0, false
+	}
+
+	// The mean interval is the time from the first tap to the last, over the
+	// number of intervals between them.
+	d := t.taps[len(t.taps)-1].Sub(t.taps[0]) / time.Duration(len(t.taps)-1)
+	t.taps = t.taps[:0]
+	if d <= 0 {
+		return 0, false
+	}
+
+	return math.Round(600/d.Seconds()) / 10, true
+}
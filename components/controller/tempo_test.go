@@ -0,0 +1,45 @@
+package controller
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTempo(t *testing.T) {
+	var tm Tempo
+	now := time.Unix(0, 0)
+	tap := func(d time.Duration) (float64, bool) {
+		now = now.Add(d)
+		return tm.Tap(now)
+	}
+
+	// Four taps half a second apart is 120 bpm. The first three aren't enough.
+	for i := 0; i < 3; i++ {
+		_, ok := tap(500 * time.Millisecond)
+		assert.False(t, ok)
+	}
+	bpm, ok := tap(500 * time.Millisecond)
+	assert.True(t, ok)
+	assert.Equal(t, 120.0, bpm)
+
+	// Uneven taps are averaged, and rounded to a tenth.
+	tap(time.Second)
+	tap(700 * time.Millisecond)
+	tap(600 * time.Millisecond)
+	bpm, ok = tap(800 * time.Millisecond)
+	assert.True(t, ok)
+	assert.Equal(t, 85.7, bpm)
+
+	// A long gap starts again, so the taps before it don't count.
+	tap(time.Second)
+	tap(time.Second)
+	_, ok = tap(3 * time.Second)
+	assert.False(t, ok)
+	tap(time.Second)
+	tap(time.Second)
+	bpm, ok = tap(time.Second)
+	assert.True(t, ok)
+	assert.Equal(t, 60.0, bpm)
+}
@@ -0,0 +1,753 @@
+package controller
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/adammck/hexapod"
+	"github.com/adammck/hexapod/config"
+	"github.com/adammck/hexapod/math3d"
+	"github.com/adammck/hexapod/params"
+	"github.com/adammck/sixaxis"
+	"github.com/stretchr/testify/assert"
+)
+
+// The tolerance when comparing floats in the state.
+const tolerance = 0.001
+
+// input is a change to the controller before a tick. The sixaxis keeps its
+// state between ticks, so buttons stay pressed until released.
+type input func(sa *sixaxis.SA)
+
+// release lets go of everything.
+func release(sa *sixaxis.SA) {
+	*sa = *sixaxis.New(nil)
+}
+
+// tickCase is the state before some ticks, the input before each of them, and
+// the changes to the state which they should have made.
+type tickCase struct {
+	name  string
+	prior func(s *hexapod.State)
+	ticks []input
+	want  func(s *hexapod.State)
+
+	// The names of the events which should have been published, in order.
+	// Nothing collects them between ticks, so this covers every tick.
+	events []string
+
+	// Anything else to check, which isn't in the state.
+	check func(t *testing.T, c *Controller)
+
+	// The button map to use, instead of the default. See actions.
+	buttons map[string]string
+}
+
+// parked returns the state which every case starts from: standing at the
+// default clearance, somewhere away from the origin, facing in some direction
+// other than forwards.
+func parked() hexapod.State {
+	p := math3d.Pose{Position: math3d.Vector3{X: 100, Y: 40, Z: -50}, Heading: 30}
+	return hexapod.State{
+		Gaits:     testGaits(),
+		Commands:  hexapod.Commands{Target: p},
+		Estimates: hexapod.Estimates{Pose: p},
+	}
+}
+
+// testGaits returns a registry of gaits to cycle through, the last of which
+// needs more than the default clearance.
+func testGaits() *hexapod.GaitRegistry {
+	r := &hexapod.GaitRegistry{}
+	for _, g := range []hexapod.Gait{walk, trot, gallop} {
+		if err := r.Register(g); err != nil {
+			panic(err)
+		}
+	}
+
+	return r
+}
+
+var (
+	walk   = hexapod.Gait{Name: "walk"}
+	trot   = hexapod.Gait{Name: "trot", MinClearance: 20}
+	gallop = hexapod.Gait{Name: "gallop", MinClearance: 60}
+)
+
+// The focal point when looking straight ahead from the parked pose.
+var ahead = math3d.Vector3{X: 350, Y: 117.5, Z: 383.013}
+
+// aheadAt returns the focal point when looking straight ahead from the parked
+// pose with the given clearance, which it moves with straight away.
+func aheadAt(clearance float64) *math3d.Vector3 {
+	v := ahead
+	v.Y += clearance - 40
+	return &v
+}
+
+var tickCases = []tickCase{
+	{
+		name:  "shutting down does nothing",
+		prior: func(s *hexapod.State) { s.Shutdown = true; s.Target.Position.Y = 0 },
+		ticks: []input{func(sa *sixaxis.SA) { sa.LeftStick.Y = -127; sa.Up = 255; sa.Start = true }},
+		want:  func(s *hexapod.State) {},
+	},
+	{
+		name:  "neutral",
+		prior: func(s *hexapod.State) { s.Target.Pitch = 5; s.Target.Bank = -5 },
+		ticks: []input{nil},
+		want: func(s *hexapod.State) {
+			s.Target.Pitch = 0
+			s.Target.Bank = 0
+			s.LookAt = &ahead
+		},
+	},
+	{
+		name:  "start shuts down, but finishes the tick",
+		ticks: []input{func(sa *sixaxis.SA) { sa.Start = true; sa.LeftStick.Y = -127 }},
+		want: func(s *hexapod.State) {
+			s.Shutdown = true
+			s.Input = hexapod.Input{LeftY: -127, Buttons: hexapod.ButtonStart}
+			s.Target.Position = math3d.Vector3{X: 150, Y: 40, Z: 36.603}
+			s.LookAt = &ahead
+		},
+		events: []string{hexapod.EventShutdownRequested},
+	},
+	{
+		name:  "left stick moves relative to the pose",
+		ticks: []input{func(sa *sixaxis.SA) { sa.LeftStick.X = 127; sa.LeftStick.Y = 127 }},
+		want: func(s *hexapod.State) {
+			s.Input = hexapod.Input{LeftX: 127, LeftY: 127}
+			s.Target.Position = math3d.Vector3{X: 136.603, Y: 40, Z: -186.603}
+			s.LookAt = &ahead
+		},
+	},
+	{
+		name:  "triggers rotate",
+		ticks: []input{func(sa *sixaxis.SA) { sa.R2 = 127; sa.L2 = 64 }},
+		want: func(s *hexapod.State) {
+			s.Input = hexapod.Input{L2: 64, R2: 127}
+			s.Target.Heading = 30 + 15*(63.0/255-0.05)/0.95
+			s.LookAt = &ahead
+		},
+	},
+	{
+		name:  "rotating wraps the heading",
+		prior: func(s *hexapod.State) { s.Pose.Heading = 170 },
+		ticks: []input{func(sa *sixaxis.SA) { sa.R2 = 255 }},
+		want: func(s *hexapod.State) {
+			s.Input = hexapod.Input{R2: 255}
+			s.Target.Heading = -175
+			s.LookAt = &math3d.Vector3{X: 186.824, Y: 117.5, Z: -542.404}
+		},
+	},
+	{
+		name:  "halt holds the pose, but not the clearance",
+		prior: func(s *hexapod.State) { s.Halt = true; s.Pose.Position.Y = 10 },
+		ticks: []input{func(sa *sixaxis.SA) { sa.LeftStick.Y = -127; sa.R2 = 127 }},
+		want: func(s *hexapod.State) {
+			s.Input = hexapod.Input{LeftY: -127, R2: 127}
+			s.Target.Position.Y = 40
+			s.LookAt = &ahead
+		},
+	},
+	{
+		name:  "R1 sets the offset instead of the focal point",
+		ticks: []input{func(sa *sixaxis.SA) { sa.R1 = 255; sa.RightStick.X = 127; sa.RightStick.Y = 127 }},
+		want: func(s *hexapod.State) {
+			s.Input = hexapod.Input{RightX: 127, RightY: 127, Buttons: hexapod.ButtonR1}
+			s.Offset = math3d.Vector3{X: 40, Z: -40}
+		},
+	},
+	{
+		name:  "R1 and L1 boost the move speed",
+		ticks: []input{func(sa *sixaxis.SA) { sa.R1 = 255; sa.L1 = 255; sa.LeftStick.X = 127; sa.LeftStick.Y = 127 }},
+		want: func(s *hexapod.State) {
+			s.Input = hexapod.Input{LeftX: 127, LeftY: 127, Buttons: hexapod.ButtonL1 | hexapod.ButtonR1}
+			s.Target.Position = math3d.Vector3{X: 154.904, Y: 40, Z: -254.904}
+			s.Turbo = hexapod.Turbo{Active: true, Factor: 1.5, Remaining: 4 * time.Second, Cooldown: 14 * time.Second}
+		},
+		events: []string{hexapod.EventTurboEngaged},
+	},
+	{
+		name:  "the derate limits the move speed",
+		prior: func(s *hexapod.State) { s.Derate = hexapod.Derate{Active: true, Factor: 0.5, Battery: 0.5, Thermal: 1} },
+		ticks: []input{func(sa *sixaxis.SA) { sa.LeftStick.X = 127; sa.LeftStick.Y = 127 }},
+		want: func(s *hexapod.State) {
+			s.Input = hexapod.Input{LeftX: 127, LeftY: 127}
+			s.Target.Position = math3d.Vector3{X: 118.301, Y: 40, Z: -118.301}
+			s.LookAt = &ahead
+		},
+	},
+	{
+		name:  "the derate limits the boost too",
+		prior: func(s *hexapod.State) { s.Derate = hexapod.Derate{Active: true, Factor: 0.5, Battery: 0.5, Thermal: 1} },
+		ticks: []input{func(sa *sixaxis.SA) { sa.R1 = 255; sa.L1 = 255; sa.LeftStick.X = 127; sa.LeftStick.Y = 127 }},
+		want: func(s *hexapod.State) {
+			s.Input = hexapod.Input{LeftX: 127, LeftY: 127, Buttons: hexapod.ButtonL1 | hexapod.ButtonR1}
+			s.Target.Position = math3d.Vector3{X: 122.876, Y: 40, Z: -135.377}
+			s.Turbo = hexapod.Turbo{Active: true, Factor: 1.5, Remaining: 4 * time.Second, Cooldown: 14 * time.Second}
+		},
+		events: []string{hexapod.EventTurboEngaged},
+	},
+	{
+		name: "select, R1, and L1 don't boost the move speed",
+		ticks: []input{func(sa *sixaxis.SA) {
+			sa.Select = true
+			sa.R1 = 255
+			sa.L1 = 255
+			sa.LeftStick.X = 127
+			sa.LeftStick.Y = 127
+		}},
+		want: func(s *hexapod.State) {
+			s.Input = hexapod.Input{LeftX: 127, LeftY: 127, Buttons: hexapod.ButtonSelect | hexapod.ButtonL1 | hexapod.ButtonR1}
+			s.Target.Position = math3d.Vector3{X: 136.603, Y: 40, Z: -186.603}
+			s.StartSelfTest = true
+		},
+	},
+	{
+		name:  "right stick moves the focal point",
+		ticks: []input{func(sa *sixaxis.SA) { sa.RightStick.X = 127; sa.RightStick.Y = 127 }},
+		want: func(s *hexapod.State) {
+			s.Input = hexapod.Input{RightX: 127, RightY: 127}
+			s.LookAt = &math3d.Vector3{X: 566.506, Y: -132.5, Z: 258.013}
+		},
+	},
+	{
+		name:  "focal point ignores the tilt of the pose",
+		prior: func(s *hexapod.State) { s.Pose.Pitch = 10; s.Pose.Bank = -5 },
+		ticks: []input{nil},
+		want: func(s *hexapod.State) {
+			s.Target.Pitch = 0
+			s.Target.Bank = 0
+			s.LookAt = &ahead
+		},
+	},
+	{
+		name:  "the focal point is relative to the clearance, not the pose",
+		prior: func(s *hexapod.State) { s.Pose.Position.Y = 48; s.Pose.Pitch = 4; s.Pose.Bank = -3 },
+		ticks: []input{nil},
+		want: func(s *hexapod.State) {
+			s.LookAt = &ahead
+		},
+	},
+	{
+		name:  "PS toggles orientation mode once per press",
+		ticks: []input{func(sa *sixaxis.SA) { sa.PS = true }, nil, nil},
+		want: func(s *hexapod.State) {
+			s.Input = hexapod.Input{Buttons: hexapod.ButtonPS}
+			s.LookAt = &ahead
+			s.Gesture = hexapod.GestureNod
+		},
+		check: func(t *testing.T, c *Controller) {
+			assert.True(t, c.setTargetOrientation)
+		},
+	},
+	{
+		name: "orientation mode sets the pitch and bank from the controller",
+		ticks: []input{
+			func(sa *sixaxis.SA) { sa.PS = true },
+			release,
+		},
+		want: func(s *hexapod.State) {
+			s.Target.Pitch = math3d.ClampDegrees(-sixaxis.New(nil).Orientation.Y()*15, -15, 15)
+			s.Target.Bank = math3d.ClampDegrees(-sixaxis.New(nil).Orientation.X()*15, -15, 15)
+			s.LookAt = &ahead
+			s.Gesture = hexapod.GestureNod
+		},
+		check: func(t *testing.T, c *Controller) {
+			assert.True(t, c.setTargetOrientation)
+		},
+	},
+	{
+		name:  "PS twice turns orientation mode off",
+		prior: func(s *hexapod.State) { s.Target.Pitch = 5 },
+		ticks: []input{func(sa *sixaxis.SA) { sa.PS = true }, release, func(sa *sixaxis.SA) { sa.PS = true }, release},
+		want: func(s *hexapod.State) {
+			s.Target.Pitch = 0
+			s.LookAt = &ahead
+			s.Gesture = hexapod.GestureNod
+		},
+		check: func(t *testing.T, c *Controller) {
+			assert.False(t, c.setTargetOrientation)
+		},
+	},
+	{
+		name:  "up raises the clearance once per press",
+		ticks: []input{func(sa *sixaxis.SA) { sa.Up = 255 }, nil, nil, release},
+		want: func(s *hexapod.State) {
+			s.Target.Position.Y = 50
+			s.LookAt = aheadAt(50)
+		},
+		events: []string{hexapod.EventClearanceChanged},
+	},
+	{
+		name:  "up is limited to the max clearance",
+		ticks: repeat(9, func(sa *sixaxis.SA) { sa.Up = 255 }, release),
+		want: func(s *hexapod.State) {
+			s.Target.Position.Y = 120
+			s.LookAt = aheadAt(120)
+		},
+		events: []string{hexapod.EventClearanceChanged, hexapod.EventClearanceChanged, hexapod.EventClearanceChanged, hexapod.EventClearanceChanged, hexapod.EventClearanceChanged, hexapod.EventClearanceChanged, hexapod.EventClearanceChanged, hexapod.EventClearanceChanged},
+	},
+	{
+		name:  "down lowers the clearance",
+		ticks: repeat(2, func(sa *sixaxis.SA) { sa.Down = 255 }, release),
+		want: func(s *hexapod.State) {
+			s.Target.Position.Y = 20
+			s.LookAt = aheadAt(20)
+		},
+		events: []string{hexapod.EventClearanceChanged, hexapod.EventClearanceChanged},
+	},
+	{
+		name:  "down is limited to the min clearance",
+		ticks: repeat(5, func(sa *sixaxis.SA) { sa.Down = 255 }, release),
+		want: func(s *hexapod.State) {
+			s.Target.Position.Y = 0
+			s.LookAt = aheadAt(0)
+		},
+		events: []string{hexapod.EventClearanceChanged, hexapod.EventClearanceChanged, hexapod.EventClearanceChanged, hexapod.EventClearanceChanged},
+	},
+	{
+		name:  "something ahead ducks while walking forwards",
+		prior: func(s *hexapod.State) { s.Range = 250 },
+		ticks: []input{func(sa *sixaxis.SA) { sa.LeftStick.Y = -127 }},
+		want: func(s *hexapod.State) {
+			s.Input = hexapod.Input{LeftY: -127}
+			s.Target.Position = math3d.Vector3{X: 150, Y: 35, Z: 36.603}
+			s.LookAt = aheadAt(35)
+		},
+	},
+	{
+		name:  "something ahead doesn't duck while halted",
+		prior: func(s *hexapod.State) { s.Range = 250; s.Halt = true },
+		ticks: []input{func(sa *sixaxis.SA) { sa.LeftStick.Y = -127 }},
+		want: func(s *hexapod.State) {
+			s.Input = hexapod.Input{LeftY: -127}
+			s.LookAt = &ahead
+		},
+	},
+	{
+		name:  "up cancels a duck, and raises the operator's clearance",
+		prior: func(s *hexapod.State) { s.Range = 250 },
+		ticks: []input{func(sa *sixaxis.SA) { sa.LeftStick.Y = -127 }, func(sa *sixaxis.SA) { sa.Up = 255 }, release},
+		want: func(s *hexapod.State) {
+			s.Target.Position.Y = 50
+			s.LookAt = aheadAt(50)
+		},
+		events: []string{hexapod.EventClearanceChanged},
+		check: func(t *testing.T, c *Controller) {
+			assert.Equal(t, 50.0, c.clearance)
+			assert.False(t, c.duck.active)
+		},
+	},
+	{
+		name:  "select + down is the next profile, not the clearance",
+		ticks: []input{func(sa *sixaxis.SA) { sa.Select = true; sa.Down = 255 }, nil},
+		want: func(s *hexapod.State) {
+			s.Input = hexapod.Input{Buttons: hexapod.ButtonSelect | hexapod.ButtonDown}
+			s.NextProfile = true
+			s.LookAt = &ahead
+		},
+	},
+	{
+		name:  "select + up is the next stiffness, not the clearance",
+		prior: func(s *hexapod.State) { s.Stiffness = "normal" },
+		ticks: []input{func(sa *sixaxis.SA) { sa.Select = true; sa.Up = 255 }, nil},
+		want: func(s *hexapod.State) {
+			s.Input = hexapod.Input{Buttons: hexapod.ButtonSelect | hexapod.ButtonUp}
+			s.SetStiffness = "stiff"
+			s.LookAt = &ahead
+		},
+	},
+	{
+		name:  "select + up wraps around to the first stiffness",
+		prior: func(s *hexapod.State) { s.Stiffness = "stiff" },
+		ticks: []input{func(sa *sixaxis.SA) { sa.Select = true; sa.Up = 255 }},
+		want: func(s *hexapod.State) {
+			s.Input = hexapod.Input{Buttons: hexapod.ButtonSelect | hexapod.ButtonUp}
+			s.SetStiffness = "soft"
+			s.LookAt = &ahead
+		},
+	},
+	{
+		name:  "right and left change the speed",
+		prior: func(s *hexapod.State) { s.Speed = 2 },
+		ticks: append(repeat(3, func(sa *sixaxis.SA) { sa.Right = 255 }, release), func(sa *sixaxis.SA) { sa.Left = 255 }),
+		want: func(s *hexapod.State) {
+			s.Input = hexapod.Input{Buttons: hexapod.ButtonLeft}
+			s.Speed = 4
+			s.LookAt = &ahead
+		},
+		events: []string{hexapod.EventSpeedChanged, hexapod.EventSpeedChanged, hexapod.EventSpeedChanged, hexapod.EventSpeedChanged},
+	},
+	{
+		name:  "select + triangle is the next gait, once per press",
+		ticks: []input{func(sa *sixaxis.SA) { sa.Select = true; sa.Triangle = 255 }, nil, nil},
+		want: func(s *hexapod.State) {
+			s.Input = hexapod.Input{Buttons: hexapod.ButtonSelect | hexapod.ButtonTriangle}
+			s.Gait = trot
+			s.GaitIndex = 1
+			s.LookAt = &ahead
+		},
+		events: []string{hexapod.EventGaitChanged},
+	},
+	{
+		name:  "select + triangle starts from the gait index",
+		prior: func(s *hexapod.State) { s.GaitIndex = 3 },
+		ticks: []input{func(sa *sixaxis.SA) { sa.Select = true; sa.Triangle = 255 }},
+		want: func(s *hexapod.State) {
+			s.Input = hexapod.Input{Buttons: hexapod.ButtonSelect | hexapod.ButtonTriangle}
+			s.Gait = trot
+			s.GaitIndex = 1
+			s.LookAt = &ahead
+		},
+		events: []string{hexapod.EventGaitChanged},
+	},
+	{
+		name:  "select + triangle skips gaits which need more clearance",
+		prior: func(s *hexapod.State) { s.SetGait(trot) },
+		ticks: []input{func(sa *sixaxis.SA) { sa.Select = true; sa.Triangle = 255 }},
+		want: func(s *hexapod.State) {
+			s.Input = hexapod.Input{Buttons: hexapod.ButtonSelect | hexapod.ButtonTriangle}
+			s.Gait = walk
+			s.GaitIndex = 0
+			s.LookAt = &ahead
+		},
+		events: []string{hexapod.EventGaitChanged},
+	},
+	{
+		name:  "select + triangle selects gaits which need more clearance once it's raised",
+		prior: func(s *hexapod.State) { s.SetGait(trot) },
+		ticks: append(repeat(2, func(sa *sixaxis.SA) { sa.Up = 255 }, release), func(sa *sixaxis.SA) { sa.Select = true; sa.Triangle = 255 }),
+		want: func(s *hexapod.State) {
+			s.Input = hexapod.Input{Buttons: hexapod.ButtonSelect | hexapod.ButtonTriangle}
+			s.Target.Position.Y = 60
+			s.Gait = gallop
+			s.GaitIndex = 2
+			s.LookAt = aheadAt(60)
+		},
+		events: []string{hexapod.EventClearanceChanged, hexapod.EventClearanceChanged, hexapod.EventGaitChanged},
+	},
+	{
+		name: "select + triangle keeps the gait if no others can be selected",
+		prior: func(s *hexapod.State) {
+			s.Gaits = &hexapod.GaitRegistry{}
+			s.Gaits.Register(walk)
+			s.Gaits.Register(gallop)
+			s.SetGait(walk)
+		},
+		ticks: []input{func(sa *sixaxis.SA) { sa.Select = true; sa.Triangle = 255 }},
+		want: func(s *hexapod.State) {
+			s.Input = hexapod.Input{Buttons: hexapod.ButtonSelect | hexapod.ButtonTriangle}
+			s.LookAt = &ahead
+		},
+	},
+	{
+		name:  "triangle then select is neither the next gait nor the inspection pose",
+		prior: func(s *hexapod.State) { s.Saturated[0] = true },
+		ticks: []input{
+			func(sa *sixaxis.SA) { sa.Triangle = 255 },
+			func(sa *sixaxis.SA) { sa.Select = true; sa.Triangle = 0 },
+		},
+		want: func(s *hexapod.State) {
+			s.Input = hexapod.Input{Buttons: hexapod.ButtonSelect}
+			s.LookAt = &ahead
+		},
+	},
+	{
+		name:  "select + square dumps the flight recorder",
+		ticks: []input{func(sa *sixaxis.SA) { sa.Select = true; sa.Square = 255 }},
+		want: func(s *hexapod.State) {
+			s.Input = hexapod.Input{Buttons: hexapod.ButtonSelect | hexapod.ButtonSquare}
+			s.Dump = true
+			s.LookAt = &ahead
+		},
+	},
+	{
+		name:  "select + circle starts calibration",
+		ticks: []input{func(sa *sixaxis.SA) { sa.Select = true; sa.Circle = 255 }},
+		want: func(s *hexapod.State) {
+			s.Input = hexapod.Input{Buttons: hexapod.ButtonSelect | hexapod.ButtonCircle}
+			s.Calibration = hexapod.CalibrationStart
+			s.LookAt = &ahead
+		},
+	},
+	{
+		name:  "select + R1 starts the self-test",
+		ticks: []input{func(sa *sixaxis.SA) { sa.Select = true; sa.R1 = 255 }},
+		want: func(s *hexapod.State) {
+			s.Input = hexapod.Input{Buttons: hexapod.ButtonSelect | hexapod.ButtonR1}
+			s.StartSelfTest = true
+		},
+	},
+	{
+		name:  "tapping select + cross starts the route, once it isn't a double tap",
+		ticks: wait([]input{selectCross, release}, 25),
+		want: func(s *hexapod.State) {
+			s.StartRoute = true
+			s.LookAt = &ahead
+		},
+	},
+	{
+		name:  "tapping select + cross waits for a double tap",
+		ticks: wait([]input{selectCross, release}, 23),
+		want: func(s *hexapod.State) {
+			s.LookAt = &ahead
+		},
+	},
+	{
+		name:  "tapping select + PS re-centres the stance, and doesn't toggle orientation",
+		ticks: wait([]input{func(sa *sixaxis.SA) { sa.Select = true; sa.PS = true }, release}, 25),
+		want: func(s *hexapod.State) {
+			s.Recentre = true
+			s.LookAt = &ahead
+		},
+	},
+	{
+		name:  "double tapping select + PS stands up anyway",
+		ticks: wait([]input{selectPS, release, selectPS, release}, 30),
+		want: func(s *hexapod.State) {
+			s.StandAnyway = true
+			s.LookAt = &ahead
+		},
+	},
+	{
+		name:  "double tapping select + cross returns home",
+		ticks: wait([]input{selectCross, release, selectCross, release}, 30),
+		want: func(s *hexapod.State) {
+			s.Home = hexapod.HomeReturn
+			s.LookAt = &ahead
+		},
+		check: func(t *testing.T, c *Controller) {
+			assert.Equal(t, NoGesture, boundTo(c, "route").tapper.Run(time.Unix(10, 0), false))
+		},
+	},
+	{
+		name:  "holding select + cross sets home",
+		ticks: wait([]input{selectCross}, 61),
+		want: func(s *hexapod.State) {
+			s.Input = hexapod.Input{Buttons: hexapod.ButtonSelect | hexapod.ButtonCross}
+			s.Home = hexapod.HomeSet
+			s.LookAt = &ahead
+		},
+	},
+	{
+		name:  "releasing select + cross after holding it isn't a tap",
+		ticks: wait(append(wait([]input{selectCross}, 61), release), 30),
+		want: func(s *hexapod.State) {
+			s.Home = hexapod.HomeSet
+			s.LookAt = &ahead
+		},
+	},
+	{
+		name:  "light presses don't count",
+		ticks: []input{func(sa *sixaxis.SA) { sa.Up = 5; sa.Right = 5; sa.R1 = 5 }},
+		want: func(s *hexapod.State) {
+			s.LookAt = &ahead
+		},
+	},
+	{
+		name:  "calibrating holds the pose, and cross captures",
+		prior: func(s *hexapod.State) { s.Calibrating = true; s.Target.Position.X = 0 },
+		ticks: []input{func(sa *sixaxis.SA) { sa.Cross = 255; sa.LeftStick.Y = -127; sa.Up = 255 }},
+		want: func(s *hexapod.State) {
+			s.Input = hexapod.Input{LeftY: -127, Buttons: hexapod.ButtonCross | hexapod.ButtonUp}
+			s.Target = s.Pose
+			s.Calibration = hexapod.CalibrationCapture
+		},
+	},
+	{
+		name:  "calibrating, triangle skips once per press",
+		prior: func(s *hexapod.State) { s.Calibrating = true },
+		ticks: []input{func(sa *sixaxis.SA) { sa.Triangle = 255 }, func(sa *sixaxis.SA) { sa.Select = true }},
+		want: func(s *hexapod.State) {
+			s.Input = hexapod.Input{Buttons: hexapod.ButtonTriangle | hexapod.ButtonSelect}
+		},
+	},
+	{
+		name:  "self-testing holds the pose, and ignores the buttons",
+		prior: func(s *hexapod.State) { s.SelfTesting = true; s.Target.Position.X = 0 },
+		ticks: []input{func(sa *sixaxis.SA) { sa.Triangle = 255; sa.LeftStick.Y = -127; sa.Up = 255 }},
+		want: func(s *hexapod.State) {
+			s.Input = hexapod.Input{LeftY: -127, Buttons: hexapod.ButtonTriangle | hexapod.ButtonUp}
+			s.Target = s.Pose
+		},
+		check: func(t *testing.T, c *Controller) {
+			assert.False(t, c.inspect.engaged)
+			assert.Equal(t, 40.0, c.clearance)
+		},
+	},
+}
+
+// selectCross presses select + cross.
+func selectCross(sa *sixaxis.SA) {
+	sa.Select = true
+	sa.Cross = 255
+}
+
+// selectPS presses select + PS.
+func selectPS(sa *sixaxis.SA) {
+	sa.Select = true
+	sa.PS = true
+}
+
+// wait returns the inputs followed by n ticks with no changes.
+func wait(in []input, n int) []input {
+	return append(in, make([]input, n)...)
+}
+
+// repeat returns the inputs to press and release n times.
+func repeat(n int, press, rel input) []input {
+	var out []input
+	for i := 0; i < n; i++ {
+		out = append(out, press, rel)
+	}
+
+	return out
+}
+
+func TestTickCases(t *testing.T) {
+	runTickCases(t, tickCases)
+}
+
+func runTickCases(t *testing.T, cases []tickCase) {
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := config.Default().Controller
+			cfg.Buttons = tc.buttons
+			sa := sixaxis.New(nil)
+			c := NewScripted(sa, cfg, config.Default().Head)
+			c.Params = params.New()
+			assert.NoError(t, c.Boot())
+
+			state := parked()
+			if tc.prior != nil {
+				tc.prior(&state)
+			}
+
+			want := state
+			tc.want(&want)
+
+			now := time.Unix(0, 0)
+			for i, in := range tc.ticks {
+				if in != nil {
+					in(sa)
+				}
+
+				now = now.Add(time.Second / 60)
+				assert.NoError(t, c.Tick(now, &state))
+
+				// The calibration component resets requests once it handles
+				// them, so only the last tick's should be left.
+				if i < len(tc.ticks)-1 {
+					state.Calibration = hexapod.CalibrationNone
+				}
+			}
+
+			for _, d := range diff("State", reflect.ValueOf(want), reflect.ValueOf(state)) {
+				t.Error(d)
+			}
+
+			var events []string
+			for _, e := range state.Published() {
+				events = append(events, e.Name)
+			}
+			assert.Equal(t, tc.events, events)
+
+			if tc.check != nil {
+				tc.check(t, c)
+			}
+		})
+	}
+}
+
+func TestDiff(t *testing.T) {
+	a := parked()
+	b := a
+	b.Target.Position.Z += tolerance / 2
+	assert.Empty(t, diff("State", reflect.ValueOf(a), reflect.ValueOf(b)))
+
+	v := math3d.Vector3{X: 1}
+	b.Target.Heading = 31
+	b.LookAt = &v
+	b.Feet[2].Y = -1
+	b.Input.Buttons = hexapod.ButtonUp
+	assert.Equal(t, []string{
+		"State.Target.Heading: want 30.000, got 31.000",
+		"State.LookAt: want nil, got &{1.000 0.000 0.000}",
+		"State.Input.Buttons: want 0, got 8",
+		"State.Feet[2].Y: want 0.000, got -1.000",
+	}, diff("State", reflect.ValueOf(a), reflect.ValueOf(b)))
+}
+
+// diff returns a line for each field (named from the given prefix) which
+// differs between the two values, which must be of the same type. Floats are
+// compared with the tolerance, and pointers by what they point to.
+func diff(name string, want, got reflect.Value) []string {
+	var out []string
+
+	switch want.Kind() {
+	case reflect.Float64:
+		w, g := want.Float(), got.Float()
+		if math.Abs(w-g) > tolerance || math.IsNaN(w) != math.IsNaN(g) {
+			out = append(out, fmt.Sprintf("%s: want %0.3f, got %0.3f", name, w, g))
+		}
+
+	case reflect.Struct:
+		for i := 0; i < want.NumField(); i++ {
+			f := want.Type().Field(i)
+			if f.PkgPath != "" {
+				continue
+			}
+
+			// Embedded sections are named as they're used, without the
+			// section.
+			n := name + "." + f.Name
+			if f.Anonymous {
+				n = name
+			}
+
+			out = append(out, diff(n, want.Field(i), got.Field(i))...)
+		}
+
+	case reflect.Array:
+		for i := 0; i < want.Len(); i++ {
+			out = append(out, diff(fmt.Sprintf("%s[%d]", name, i), want.Index(i), got.Index(i))...)
+		}
+
+	case reflect.Ptr:
+		if want.IsNil() || got.IsNil() {
+			if want.IsNil() != got.IsNil() {
+				out = append(out, fmt.Sprintf("%s: want %s, got %s", name, show(want), show(got)))
+			}
+
+			break
+		}
+
+		out = append(out, diff(name, want.Elem(), got.Elem())...)
+
+	default:
+		if want.Interface() != got.Interface() {
+			out = append(out, fmt.Sprintf("%s: want %v, got %v", name, want.Interface(), got.Interface()))
+		}
+	}
+
+	return out
+}
+
+// show formats a pointer to a vector for diff.
+func show(v reflect.Value) string {
+	if v.IsNil() {
+		return "nil"
+	}
+
+	if p, ok := v.Interface().(*math3d.Vector3); ok {
+		return fmt.Sprintf("&{%0.3f %0.3f %0.3f}", p.X, p.Y, p.Z)
+	}
+
+	return fmt.Sprintf("%v", v.Interface())
+}
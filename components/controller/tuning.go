@@ -0,0 +1,153 @@
+package controller
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/adammck/hexapod"
+	"github.com/adammck/hexapod/params"
+)
+
+// tuning tracks the tuning mode, for tweaking the params in the field without a
+// laptop, in which the hex stays where it is and the d-pad picks a param from
+// the shortlist (left and right) and nudges it by its step (up and down). The
+// writes go through the registry, like those from the API. Every other action
+// is locked out meanwhile, so nothing moves by accident. See
+// config.Controller.Tuning.
+type tuning struct {
+	active bool
+
+	// The params in the shortlist which were registered when the tuning mode
+	// was engaged, and the index of the selected one, which is kept for next
+	// time.
+	names []string
+	index int
+
+	left, right, up, down Latch
+}
+
+// toggleTuning engages the tuning mode, unless refuse returns a reason not to,
+// or there's nothing to tune, or disengages it if it's engaged.
+func (c *Controller) toggleTuning(state *hexapod.State, refuse func() string) {
+	t := &c.tuning
+	if t.active {
+		log.Info("disengaging tuning mode")
+		t.active = false
+		state.Publish(hexapod.EventTuningChanged, hexapod.Info, false)
+		return
+	}
+
+	if reason := refuse(); reason != "" {
+		log.Warnf("not engaging tuning mode: %s", reason)
+		return
+	}
+
+	t.names = t.names[:0]
+	for _, name := range c.cfg.Tuning {
+		if _, ok := c.Params.Lookup(name); !ok {
+			log.Warnf("can't tune %s, since no such param is registered", name)
+			continue
+		}
+
+		t.names = append(t.names, name)
+	}
+
+	if len(t.names) == 0 {
+		log.Warn("not engaging tuning mode: there are no params to tune")
+		return
+	}
+
+	if t.index >= len(t.names) {
+		t.index = 0
+	}
+
+	// Whatever is already held on the d-pad doesn't count until it's pressed
+	// again.
+	b := state.Input.Buttons
+	t.left.Run(b&hexapod.ButtonLeft != 0)
+	t.right.Run(b&hexapod.ButtonRight != 0)
+	t.up.Run(b&hexapod.ButtonUp != 0)
+	t.down.Run(b&hexapod.ButtonDown != 0)
+
+	t.active = true
+	log.Infof("engaging tuning mode, with %s", c.describeParam(t.names[t.index]))
+	state.Publish(hexapod.EventTuningChanged, hexapod.Info, true)
+}
+
+// refuseTuning returns why the tuning mode can't be engaged, or an empty
+// string if it can.
+func refuseTuning(walking, posing, inspecting bool) string {
+	switch {
+	case walking:
+		return "walking"
+	case posing:
+		return "posing"
+	case inspecting:
+		return "in the inspection pose"
+	}
+
+	return ""
+}
+
+// tune picks and nudges the params with the d-pad, while tuning.
+func (c *Controller) tune(state *hexapod.State) {
+	t := &c.tuning
+	b := state.Input.Buttons
+	left := t.left.Run(b&hexapod.ButtonLeft != 0)
+	right := t.right.Run(b&hexapod.ButtonRight != 0)
+	up := t.up.Run(b&hexapod.ButtonUp != 0)
+	down := t.down.Run(b&hexapod.ButtonDown != 0)
+
+	n := len(t.names)
+	switch {
+	case right:
+		t.index = (t.index + 1) % n
+		log.Infof("tuning %s", c.describeParam(t.names[t.index]))
+
+	case left:
+		t.index = (t.index - 1 + n) % n
+		log.Infof("tuning %s", c.describeParam(t.names[t.index]))
+
+	case up:
+		c.nudge(state, t.names[t.index], 1)
+
+	case down:
+		c.nudge(state, t.names[t.index], -1)
+	}
+}
+
+// nudge writes the named param, one step in the given direction (up if it's
+// positive), as far as its limit.
+func (c *Controller) nudge(state *hexapod.State, name string, dir float64) {
+	p, ok := c.Params.Lookup(name)
+	if !ok {
+		log.Warnf("can't tune %s, since it's no longer registered", name)
+		return
+	}
+
+	v := math.Max(p.Min, math.Min(p.Max, p.Value+math.Copysign(p.Step, dir)))
+	if p.Type != params.Float {
+		v = math.Round(v)
+	}
+
+	if v == p.Value {
+		log.Warnf("can't tune %s any further than %v", name, v)
+		return
+	}
+
+	err := c.Params.Set(map[string]float64{name: v})
+	if err != nil {
+		log.Warnf("%s (while tuning)", err)
+		return
+	}
+
+	log.Infof("tuned %s from %v to %v", name, p.Value, v)
+	p.Value = v
+	state.Publish(hexapod.EventParamTuned, hexapod.Info, p)
+}
+
+// describeParam returns the name of the param, with its value and step.
+func (c *Controller) describeParam(name string) string {
+	p, _ := c.Params.Lookup(name)
+	return fmt.Sprintf("%s=%v (step %v)", p.Name, p.Value, p.Step)
+}
@@ -0,0 +1,194 @@
+package controller
+
+import (
+	"testing"
+	"time"
+
+	"github.com/adammck/hexapod"
+	"github.com/adammck/hexapod/config"
+	"github.com/adammck/hexapod/hexapodtest"
+	"github.com/adammck/hexapod/params"
+	"github.com/adammck/sixaxis"
+	"github.com/stretchr/testify/assert"
+)
+
+// holdCross holds cross, which toggles the tuning mode once it's held.
+func holdCross(sa *sixaxis.SA) {
+	sa.Cross = 255
+}
+
+// tuningFixture ticks a controller at 60Hz, with a registry which the pending
+// writes are applied from before each tick, like the main loop, and a step
+// height param like the legs'.
+type tuningFixture struct {
+	*hexapodtest.Clock
+	sa     *sixaxis.SA
+	c      *Controller
+	height float64
+}
+
+func newTuningFixture(t *testing.T) *tuningFixture {
+	f := &tuningFixture{Clock: hexapodtest.NewClock(t, tuningTick), sa: sixaxis.New(nil), height: 40}
+	f.c = NewScripted(f.sa, config.Default().Controller, config.Default().Head)
+	f.c.Params = params.New()
+	assert.NoError(t, f.c.Params.Register(params.Param{
+		Name: "legs.step_height",
+		Type: params.Float,
+		Min:  0,
+		Max:  80,
+		Step: 5,
+		Get:  func() float64 { return f.height },
+		Set:  func(v float64) { f.height = v },
+	}))
+	assert.NoError(t, f.c.Boot())
+	state := parked()
+	f.State = &state
+	return f
+}
+
+func (f *tuningFixture) tick(in input) {
+	f.c.Params.Apply()
+	if in != nil {
+		in(f.sa)
+	}
+	f.Tick(f.c)
+}
+
+// The interval between the fixture's ticks.
+const tuningTick = time.Second / 60
+
+// hold holds cross for long enough to toggle the tuning mode. The hold counts
+// from the first tick which it's held on, so it's held for one more tick than
+// fits in the hold time, which isn't a whole number of them.
+func (f *tuningFixture) hold() {
+	n := int((holdTime+tuningTick-1)/tuningTick) + 1
+	for i := 0; i < n; i++ {
+		f.tick(holdCross)
+	}
+}
+
+// toggle holds cross for long enough to toggle the tuning mode, and releases
+// it.
+func (f *tuningFixture) toggle() {
+	f.tick(release)
+	f.hold()
+	f.tick(release)
+}
+
+// press presses the given input for a tick, and releases it.
+func (f *tuningFixture) press(in input) {
+	f.tick(in)
+	f.tick(release)
+}
+
+// events returns the tuning events which the controller has published, as the
+// payloads (whether it's tuning, or the value which was tuned).
+func (f *tuningFixture) events() []interface{} {
+	var out []interface{}
+	for _, e := range f.State.Published() {
+		if e.Name == hexapod.EventTuningChanged || e.Name == hexapod.EventParamTuned {
+			out = append(out, e.Payload)
+		}
+	}
+
+	return out
+}
+
+func TestTuning(t *testing.T) {
+	f := newTuningFixture(t)
+	pose := f.State.Pose
+	speed := f.State.Speed
+	clearance := f.c.clearance
+	deadzone := f.c.deadzone
+
+	f.toggle()
+	assert.True(t, f.c.tuning.active)
+
+	// The shortlist is the default one, without gait.base_ticks_per_step,
+	// which isn't registered here.
+	assert.Equal(t, []string{"legs.step_height", "controller.deadzone", "controller.move_speed"}, f.c.tuning.names)
+
+	// Up nudges the selected param by its step, which is applied next tick.
+	f.press(func(sa *sixaxis.SA) { sa.Up = 255 })
+	f.press(func(sa *sixaxis.SA) { sa.Up = 255 })
+	f.tick(nil)
+	assert.Equal(t, 50.0, f.height)
+
+	// Right selects the next, and down nudges that one.
+	f.press(func(sa *sixaxis.SA) { sa.Right = 255 })
+	f.press(func(sa *sixaxis.SA) { sa.Down = 255 })
+	f.tick(nil)
+	assert.InDelta(t, deadzone-0.01, f.c.deadzone, 1e-9)
+
+	// Left goes back (and wraps around), and nothing goes past the limit.
+	f.press(func(sa *sixaxis.SA) { sa.Left = 255 })
+	for i := 0; i < 10; i++ {
+		f.press(func(sa *sixaxis.SA) { sa.Up = 255 })
+	}
+	f.tick(nil)
+	assert.Equal(t, 80.0, f.height)
+	f.press(func(sa *sixaxis.SA) { sa.Left = 255 })
+	if assert.True(t, f.c.tuning.index >= 0 && f.c.tuning.index < len(f.c.tuning.names)) {
+		assert.Equal(t, "controller.move_speed", f.c.tuning.names[f.c.tuning.index])
+	}
+
+	// Meanwhile, the sticks and the d-pad's usual actions are locked out, so
+	// the hex stays exactly where it is.
+	for i := 0; i < 60; i++ {
+		f.tick(func(sa *sixaxis.SA) {
+			sa.LeftStick.X, sa.LeftStick.Y = 127, -127
+			sa.R2 = 255
+			sa.Triangle = 255
+		})
+		assert.Equal(t, pose.Position.X, f.State.Target.Position.X)
+		assert.Equal(t, pose.Position.Z, f.State.Target.Position.Z)
+		assert.Equal(t, pose.Heading, f.State.Target.Heading)
+		assert.Equal(t, clearance, f.State.Target.Position.Y)
+	}
+	assert.Equal(t, speed, f.State.Speed)
+	assert.Equal(t, clearance, f.c.clearance)
+	assert.False(t, f.c.inspect.active())
+
+	f.toggle()
+	assert.False(t, f.c.tuning.active)
+
+	assert.Equal(t, []interface{}{
+		true,
+		params.Value{Name: "legs.step_height", Type: params.Float, Min: 0, Max: 80, Step: 5, Value: 45},
+		params.Value{Name: "legs.step_height", Type: params.Float, Min: 0, Max: 80, Step: 5, Value: 50},
+		params.Value{Name: "controller.deadzone", Type: params.Float, Min: 0, Max: 0.5, Step: 0.01, Value: deadzone - 0.01},
+		params.Value{Name: "legs.step_height", Type: params.Float, Min: 0, Max: 80, Step: 5, Value: 55},
+		params.Value{Name: "legs.step_height", Type: params.Float, Min: 0, Max: 80, Step: 5, Value: 60},
+		params.Value{Name: "legs.step_height", Type: params.Float, Min: 0, Max: 80, Step: 5, Value: 65},
+		params.Value{Name: "legs.step_height", Type: params.Float, Min: 0, Max: 80, Step: 5, Value: 70},
+		params.Value{Name: "legs.step_height", Type: params.Float, Min: 0, Max: 80, Step: 5, Value: 75},
+		params.Value{Name: "legs.step_height", Type: params.Float, Min: 0, Max: 80, Step: 5, Value: 80},
+		false,
+	}, f.events())
+
+	// Once it's over, the d-pad and the sticks work as usual again.
+	f.press(func(sa *sixaxis.SA) { sa.Right = 255 })
+	assert.Equal(t, speed+1, f.State.Speed)
+	f.tick(func(sa *sixaxis.SA) { sa.LeftStick.Y = -127 })
+	assert.NotEqual(t, pose.Position, f.State.Target.Position)
+}
+
+func TestTuningRefused(t *testing.T) {
+	for name, prior := range map[string]func(f *tuningFixture){
+		"walking": func(f *tuningFixture) { f.sa.LeftStick.Y = -127 },
+		"posing": func(f *tuningFixture) {
+			f.press(l1Left)
+		},
+		"nothing to tune": func(f *tuningFixture) {
+			f.c.cfg.Tuning = []string{"legs.nope"}
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			f := newTuningFixture(t)
+			prior(f)
+			f.hold()
+			assert.False(t, f.c.tuning.active)
+			assert.Empty(t, f.events())
+		})
+	}
+}
@@ -0,0 +1,144 @@
+package controller
+
+import (
+	"math"
+	"time"
+
+	"github.com/adammck/hexapod"
+	"github.com/adammck/hexapod/config"
+)
+
+type turboPhase int
+
+const (
+	turboReady turboPhase = iota
+	turboBoost
+	turboDecay
+	turboCooldown
+)
+
+// turbo tracks the speed boost, which multiplies the move speed while R1 and L1
+// are held, for a limited time. See config.Controller.TurboFactor.
+type turbo struct {
+	cfg config.Controller
+
+	phase turboPhase
+
+	// When the current phase started, and when the last update was.
+	since time.Time
+	last  time.Time
+
+	// The highest factor which the boost is allowed, which starts at the turbo
+	// factor and is reduced while any leg is saturated, and the factor which
+	// the decay started from.
+	limit float64
+	from  float64
+
+	// The multiplier which the move speed should be scaled by.
+	factor float64
+
+	// Whether the buttons were held on the last update, so holding them through
+	// the cooldown doesn't engage it again as soon as it ends.
+	held bool
+}
+
+func newTurbo(cfg config.Controller) turbo {
+	return turbo{cfg: cfg, factor: 1}
+}
+
+// update advances the boost, given whether the buttons are held and whether any
+// leg is saturated, and returns the phase which it moved to, if it changed.
+func (t *turbo) update(now time.Time, held, saturated bool) (turboPhase, bool) {
+	pressed := held && !t.held
+	t.held = held
+
+	dt := 0.0
+	if !t.last.IsZero() {
+		dt = now.Sub(t.last).Seconds()
+	}
+	t.last = now
+
+	elapsed := now.Sub(t.since)
+	prev := t.phase
+
+	switch t.phase {
+	case turboReady:
+		if pressed && t.cfg.TurboFactor > 1 {
+			t.start(now, turboBoost)
+			t.limit = t.cfg.TurboFactor
+		}
+
+	case turboBoost:
+		if !held || elapsed >= t.cfg.TurboDuration.Duration {
+			t.start(now, turboDecay)
+			t.from = t.factor
+		}
+
+	case turboDecay:
+		if elapsed >= t.cfg.TurboDecay.Duration {
+			t.start(now, turboCooldown)
+		}
+
+	case turboCooldown:
+		if elapsed >= t.cfg.TurboCooldown.Duration {
+			t.start(now, turboReady)
+		}
+	}
+
+	// The decay might have just started, so this is separate.
+	elapsed = now.Sub(t.since)
+	switch t.phase {
+	case turboBoost:
+		t.factor = t.limit
+
+	case turboDecay:
+		f := 0.0
+		if d := t.cfg.TurboDecay.Duration; d > 0 {
+			f = 1 - elapsed.Seconds()/d.Seconds()
+		}
+		t.factor = 1 + (t.from-1)*f
+
+	default:
+		t.factor = 1
+	}
+
+	// Reduce the limit while saturated, which lasts for the rest of the boost,
+	// rather than bouncing back as soon as the legs can reach again.
+	if saturated && (t.phase == turboBoost || t.phase == turboDecay) {
+		t.limit = math.Max(1, t.limit-t.cfg.TurboBackoff*dt)
+		t.factor = math.Min(t.factor, t.limit)
+	}
+
+	return t.phase, t.phase != prev
+}
+
+func (t *turbo) start(now time.Time, p turboPhase) {
+	t.phase = p
+	t.since = now
+}
+
+// status returns the state of the boost, for the State.
+func (t *turbo) status(now time.Time) hexapod.Turbo {
+	elapsed := now.Sub(t.since)
+	cooldown := t.cfg.TurboCooldown.Duration
+	out := hexapod.Turbo{}
+
+	switch t.phase {
+	case turboBoost:
+		out.Active = true
+		out.Factor = t.factor
+		out.Remaining = t.cfg.TurboDuration.Duration - elapsed + t.cfg.TurboDecay.Duration
+		out.Cooldown = out.Remaining + cooldown
+
+	case turboDecay:
+		out.Active = true
+		out.Factor = t.factor
+		out.Remaining = t.cfg.TurboDecay.Duration - elapsed
+		out.Cooldown = out.Remaining + cooldown
+
+	case turboCooldown:
+		out.Cooldown = cooldown - elapsed
+	}
+
+	return out
+}
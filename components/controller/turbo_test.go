@@ -0,0 +1,112 @@
+package controller
+
+import (
+	"testing"
+	"time"
+
+	"github.com/adammck/hexapod"
+	"github.com/adammck/hexapod/config"
+	"github.com/stretchr/testify/assert"
+)
+
+// turboAt returns a time the given number of milliseconds after the epoch.
+func turboAt(ms int) time.Time {
+	return time.Unix(0, 0).Add(time.Duration(ms) * time.Millisecond)
+}
+
+func TestTurbo(t *testing.T) {
+	tb := newTurbo(config.Default().Controller)
+
+	// Updates the turbo at the given time, and returns the phase if it changed,
+	// or -1 if it didn't.
+	update := func(ms int, held, saturated bool) turboPhase {
+		p, changed := tb.update(turboAt(ms), held, saturated)
+		if !changed {
+			return -1
+		}
+		return p
+	}
+
+	assert.Equal(t, turboPhase(-1), update(0, false, false))
+	assert.Equal(t, 1.0, tb.factor)
+	assert.Equal(t, hexapod.Turbo{}, tb.status(turboAt(0)))
+
+	// Holding the buttons boosts for the duration.
+	assert.Equal(t, turboBoost, update(100, true, false))
+	assert.Equal(t, 1.5, tb.factor)
+	assert.Equal(t, hexapod.Turbo{Active: true, Factor: 1.5, Remaining: 4 * time.Second, Cooldown: 14 * time.Second}, tb.status(turboAt(100)))
+
+	assert.Equal(t, turboPhase(-1), update(3000, true, false))
+	assert.Equal(t, 1.5, tb.factor)
+
+	// Then decays, even though they're still held.
+	assert.Equal(t, turboDecay, update(3100, true, false))
+	assert.Equal(t, 1.5, tb.factor)
+	assert.Equal(t, turboPhase(-1), update(3600, true, false))
+	assert.InDelta(t, 1.25, tb.factor, tolerance)
+	assert.Equal(t, hexapod.Turbo{Active: true, Factor: tb.factor, Remaining: 500 * time.Millisecond, Cooldown: 10500 * time.Millisecond}, tb.status(turboAt(3600)))
+
+	// Then cools down.
+	assert.Equal(t, turboCooldown, update(4100, true, false))
+	assert.Equal(t, 1.0, tb.factor)
+	assert.Equal(t, hexapod.Turbo{Cooldown: 10 * time.Second}, tb.status(turboAt(4100)))
+	assert.Equal(t, turboPhase(-1), update(10000, false, false))
+	assert.Equal(t, turboPhase(-1), update(10100, true, false))
+	assert.Equal(t, hexapod.Turbo{Cooldown: 4 * time.Second}, tb.status(turboAt(10100)))
+
+	// Once it's ready, holding the buttons from before doesn't engage it; they
+	// have to be pressed again.
+	assert.Equal(t, turboReady, update(14100, true, false))
+	assert.Equal(t, turboPhase(-1), update(14200, true, false))
+	assert.Equal(t, turboPhase(-1), update(14300, false, false))
+	assert.Equal(t, turboBoost, update(14400, true, false))
+
+	// Letting go early decays straight away.
+	assert.Equal(t, turboDecay, update(15400, false, false))
+	assert.Equal(t, 1.5, tb.factor)
+	assert.Equal(t, turboPhase(-1), update(15900, false, false))
+	assert.InDelta(t, 1.25, tb.factor, tolerance)
+	assert.Equal(t, turboCooldown, update(16400, false, false))
+}
+
+func TestTurboSaturation(t *testing.T) {
+	tb := newTurbo(config.Default().Controller)
+	tb.update(turboAt(0), true, false)
+	assert.Equal(t, 1.5, tb.factor)
+
+	// Saturating reduces the factor by the backoff (one per second)...
+	tb.update(turboAt(100), true, true)
+	tb.update(turboAt(200), true, true)
+	assert.InDelta(t, 1.3, tb.factor, tolerance)
+
+	// ...for the rest of the boost, even once the legs can reach again.
+	tb.update(turboAt(300), true, false)
+	tb.update(turboAt(2000), true, false)
+	assert.InDelta(t, 1.3, tb.factor, tolerance)
+	assert.InDelta(t, 1.3, tb.status(turboAt(2000)).Factor, tolerance)
+
+	// The decay starts from the reduced factor.
+	tb.update(turboAt(2100), false, false)
+	tb.update(turboAt(2600), false, false)
+	assert.InDelta(t, 1.15, tb.factor, tolerance)
+
+	// Saturating during the decay reduces it further, but never below one.
+	for ms := 2700; ms <= 3000; ms += 100 {
+		tb.update(turboAt(ms), false, true)
+	}
+	assert.Equal(t, 1.0, tb.factor)
+	assert.Equal(t, turboDecay, tb.phase)
+}
+
+func TestTurboDisabled(t *testing.T) {
+	cfg := config.Default().Controller
+	cfg.TurboFactor = 1
+	tb := newTurbo(cfg)
+
+	for ms := 0; ms < 1000; ms += 100 {
+		_, changed := tb.update(turboAt(ms), true, false)
+		assert.False(t, changed)
+	}
+	assert.Equal(t, 1.0, tb.factor)
+	assert.Equal(t, hexapod.Turbo{}, tb.status(turboAt(1000)))
+}
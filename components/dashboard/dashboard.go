@@ -0,0 +1,155 @@
+// Package dashboard serves a web page which draws the hex (from the telemetry
+// stream) from above, and optionally drives a scripted controller from the
+// keyboard. It's meant for the simulator, where there's no sixaxis to hold,
+// and nothing to look at.
+package dashboard
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/adammck/hexapod"
+	"github.com/adammck/hexapod/components/controller"
+	"github.com/adammck/sixaxis"
+)
+
+var log = hexapod.NewLog("dashboard")
+
+//go:embed index.html
+var page string
+
+var tmpl = template.Must(template.New("index").Parse(page))
+
+// Dashboard is a component which serves the page, and (if Keyboard is set)
+// sets the state of the sixaxis whenever the keys held on the page change. It
+// must come before the controller which reads it.
+type Dashboard struct {
+	port          int
+	telemetryPort int
+
+	// The scripted sixaxis (see controller.NewScripted) to drive from the
+	// keyboard, or nil for the page to only be looked at.
+	Keyboard *sixaxis.SA
+
+	// Written by the HTTP handler, and read by the main loop. The keys are
+	// by KeyboardEvent.code.
+	mu      sync.Mutex
+	held    map[string]bool
+	changed bool
+}
+
+// New creates a dashboard which will listen on the given port, and read the
+// telemetry stream from the other given port, on the same host.
+func New(port, telemetryPort int) *Dashboard {
+	return &Dashboard{
+		port:          port,
+		telemetryPort: telemetryPort,
+		held:          map[string]bool{},
+	}
+}
+
+// Boot starts the HTTP server in the background.
+func (d *Dashboard) Boot() error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", d.handleIndex)
+	mux.HandleFunc("/keys", d.handleKeys)
+
+	addr := fmt.Sprintf(":%d", d.port)
+	log.Infof("serving dashboard on http://localhost%s", addr)
+
+	go func() {
+		err := http.ListenAndServe(addr, mux)
+		log.Errorf("server stopped: %s", err)
+	}()
+
+	return nil
+}
+
+// Tick sets the sixaxis from the keys, if any are held, or if they've changed
+// since the last tick (so letting go of everything releases the sixaxis). It
+// leaves the sixaxis alone otherwise, so something else (e.g. a replay) can
+// drive it until a key is pressed.
+func (d *Dashboard) Tick(now time.Time, state *hexapod.State) error {
+	if d.Keyboard == nil {
+		return nil
+	}
+
+	d.mu.Lock()
+	if !d.changed && len(d.held) == 0 {
+		d.mu.Unlock()
+		return nil
+	}
+	in := keyInput(d.held)
+	d.changed = false
+	d.mu.Unlock()
+
+	controller.SetInput(d.Keyboard, in)
+	return nil
+}
+
+// help is a line of the key help on the page.
+type help struct {
+	Code string
+	Desc string
+}
+
+func (d *Dashboard) handleIndex(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+
+	var keyHelp []help
+	if d.Keyboard != nil {
+		for _, k := range keys {
+			keyHelp = append(keyHelp, help{k.code, k.desc})
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err := tmpl.Execute(w, struct {
+		TelemetryPort int
+		Keys          []help
+	}{d.telemetryPort, keyHelp})
+	if err != nil {
+		log.Warnf("%s (while rendering dashboard)", err)
+	}
+}
+
+// handleKeys replaces the keys which are held with those in the body, which is
+// a JSON list of KeyboardEvent.code.
+func (d *Dashboard) handleKeys(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if d.Keyboard == nil {
+		http.Error(w, "keyboard is disabled", http.StatusForbidden)
+		return
+	}
+
+	var codes []string
+	err := json.NewDecoder(r.Body).Decode(&codes)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	held := make(map[string]bool, len(codes))
+	for _, c := range codes {
+		held[c] = true
+	}
+
+	d.mu.Lock()
+	d.held = held
+	d.changed = true
+	d.mu.Unlock()
+
+	w.WriteHeader(http.StatusNoContent)
+}
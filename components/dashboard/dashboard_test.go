@@ -0,0 +1,90 @@
+package dashboard
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/adammck/hexapod"
+	"github.com/adammck/sixaxis"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeyInput(t *testing.T) {
+	assert.Equal(t, hexapod.Input{}, keyInput(nil))
+
+	assert.Equal(t, hexapod.Input{LeftX: 127, LeftY: -127, R2: 255, Buttons: hexapod.ButtonSelect | hexapod.ButtonTriangle},
+		keyInput(map[string]bool{"KeyW": true, "KeyD": true, "KeyE": true, "ShiftLeft": true, "KeyT": true, "KeyY": true}))
+
+	// Opposite keys cancel out.
+	assert.Equal(t, hexapod.Input{}, keyInput(map[string]bool{"KeyW": true, "KeyS": true}))
+}
+
+func TestKeys(t *testing.T) {
+	sa := sixaxis.New(nil)
+	d := New(0, 8001)
+	d.Keyboard = sa
+
+	post := func(body string) int {
+		w := httptest.NewRecorder()
+		d.handleKeys(w, httptest.NewRequest("POST", "/keys", strings.NewReader(body)))
+		return w.Code
+	}
+
+	state := &hexapod.State{}
+	now := time.Unix(0, 0)
+
+	// Nothing is held yet, so the sixaxis is left alone.
+	sa.LeftStick.X = 50
+	assert.NoError(t, d.Tick(now, state))
+	assert.Equal(t, int32(50), sa.LeftStick.X)
+
+	// The keys aren't applied until the next tick.
+	assert.Equal(t, http.StatusNoContent, post(`["KeyW", "ArrowUp"]`))
+	assert.Equal(t, int32(0), sa.LeftStick.Y)
+	assert.NoError(t, d.Tick(now, state))
+	assert.Equal(t, int32(-127), sa.LeftStick.Y)
+	assert.Equal(t, int32(0), sa.LeftStick.X)
+	assert.Equal(t, int32(255), sa.Up)
+
+	// Letting go releases everything, once.
+	assert.Equal(t, http.StatusNoContent, post(`[]`))
+	assert.NoError(t, d.Tick(now, state))
+	assert.Equal(t, int32(0), sa.LeftStick.Y)
+	assert.Equal(t, int32(0), sa.Up)
+	sa.LeftStick.X = 50
+	assert.NoError(t, d.Tick(now, state))
+	assert.Equal(t, int32(50), sa.LeftStick.X)
+
+	assert.Equal(t, http.StatusBadRequest, post(`{`))
+
+	w := httptest.NewRecorder()
+	d.handleKeys(w, httptest.NewRequest("GET", "/keys", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+
+	d.Keyboard = nil
+	assert.Equal(t, http.StatusForbidden, post(`["KeyW"]`))
+}
+
+func TestIndex(t *testing.T) {
+	d := New(0, 8001)
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		d.handleIndex(w, httptest.NewRequest("GET", path, nil))
+		return w
+	}
+
+	// The page is told where the telemetry is, and only shows the key help
+	// when the keyboard is enabled.
+	w := get("/")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "const telemetryPort =  8001 ;")
+	assert.NotContains(t, w.Body.String(), "KeyW")
+
+	d.Keyboard = sixaxis.New(nil)
+	assert.Contains(t, get("/").Body.String(), `data-code="KeyW"`)
+
+	assert.Equal(t, http.StatusNotFound, get("/nope").Code)
+}
@@ -0,0 +1,52 @@
+package dashboard
+
+import (
+	"github.com/adammck/hexapod"
+)
+
+// keys is what each key (by KeyboardEvent.code, so it doesn't depend on the
+// layout) does to the controller input while it's held. The sticks are pushed
+// all the way, and the buttons pressed fully. The help on the page is made
+// from the descriptions, so they're kept short.
+var keys = []struct {
+	code string
+	desc string
+	set  func(in *hexapod.Input)
+}{
+	{"KeyW", "forwards", func(in *hexapod.Input) { in.LeftY -= 127 }},
+	{"KeyS", "backwards", func(in *hexapod.Input) { in.LeftY += 127 }},
+	{"KeyA", "left", func(in *hexapod.Input) { in.LeftX -= 127 }},
+	{"KeyD", "right", func(in *hexapod.Input) { in.LeftX += 127 }},
+	{"KeyQ", "turn left (L2)", func(in *hexapod.Input) { in.L2 = 255 }},
+	{"KeyE", "turn right (R2)", func(in *hexapod.Input) { in.R2 = 255 }},
+	{"KeyI", "look up", func(in *hexapod.Input) { in.RightY -= 127 }},
+	{"KeyK", "look down", func(in *hexapod.Input) { in.RightY += 127 }},
+	{"KeyJ", "look left", func(in *hexapod.Input) { in.RightX -= 127 }},
+	{"KeyL", "look right", func(in *hexapod.Input) { in.RightX += 127 }},
+	{"ArrowUp", "clearance up", func(in *hexapod.Input) { in.Buttons |= hexapod.ButtonUp }},
+	{"ArrowDown", "clearance down", func(in *hexapod.Input) { in.Buttons |= hexapod.ButtonDown }},
+	{"ArrowRight", "faster", func(in *hexapod.Input) { in.Buttons |= hexapod.ButtonRight }},
+	{"ArrowLeft", "slower", func(in *hexapod.Input) { in.Buttons |= hexapod.ButtonLeft }},
+	{"ShiftLeft", "select", func(in *hexapod.Input) { in.Buttons |= hexapod.ButtonSelect }},
+	{"KeyT", "triangle", func(in *hexapod.Input) { in.Buttons |= hexapod.ButtonTriangle }},
+	{"KeyC", "circle", func(in *hexapod.Input) { in.Buttons |= hexapod.ButtonCircle }},
+	{"KeyX", "cross", func(in *hexapod.Input) { in.Buttons |= hexapod.ButtonCross }},
+	{"KeyZ", "square", func(in *hexapod.Input) { in.Buttons |= hexapod.ButtonSquare }},
+	{"KeyF", "L1", func(in *hexapod.Input) { in.Buttons |= hexapod.ButtonL1 }},
+	{"KeyR", "R1", func(in *hexapod.Input) { in.Buttons |= hexapod.ButtonR1 }},
+	{"KeyP", "PS", func(in *hexapod.Input) { in.Buttons |= hexapod.ButtonPS }},
+	{"Escape", "start (shut down)", func(in *hexapod.Input) { in.Buttons |= hexapod.ButtonStart }},
+}
+
+// keyInput returns the controller input while the given keys are held. Keys
+// which don't do anything are ignored, and opposite keys cancel out.
+func keyInput(held map[string]bool) hexapod.Input {
+	var in hexapod.Input
+	for _, k := range keys {
+		if held[k.code] {
+			k.set(&in)
+		}
+	}
+
+	return in
+}
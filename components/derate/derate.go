@@ -0,0 +1,99 @@
+// Package derate limits how fast the hex walks as the battery runs down and the
+// servos heat up, since they get slower and weaker, and walking at full speed
+// gets sloppy and stalls more.
+package derate
+
+import (
+	"math"
+	"time"
+
+	"github.com/adammck/hexapod"
+	"github.com/adammck/hexapod/config"
+)
+
+var log = hexapod.NewLog("derate")
+
+// Derate is a component which sets State.Derate every tick, from the averaged
+// battery voltage and the servo temperature. Each policy is a curve from full
+// speed down to its factor (see config.Derate), and the lowest factor wins, so
+// they compose. The controller and navigator scale their moves by it, so this
+// must come before them.
+//
+// Only the voltage and temperature which have been read count: while either is
+// zero, its policy is full speed.
+type Derate struct {
+	cfg    config.Derate
+	safety config.Safety
+
+	// Whether the speed has been limited since boot, so that's only published
+	// once. The battery only gets flatter, so it would otherwise flap around
+	// the nominal voltage as the readings wobble.
+	engaged bool
+}
+
+// New creates a derate component. The safety config is for the voltage which
+// the battery policy reaches its factor at.
+func New(cfg config.Derate, safety config.Safety) *Derate {
+	return &Derate{
+		cfg:    cfg,
+		safety: safety,
+	}
+}
+
+// Writes returns hexapod.Commander, since it limits the speed.
+func (d *Derate) Writes() hexapod.Role {
+	return hexapod.Commander
+}
+
+func (d *Derate) Boot() error {
+	return nil
+}
+
+func (d *Derate) Tick(now time.Time, state *hexapod.State) error {
+	state.Derate = Evaluate(d.cfg, d.safety, state.Voltage, state.ServoTemperature)
+
+	if state.Derate.Active && !d.engaged {
+		d.engaged = true
+		log.Warnf("limiting speed to %.0f%% (%.2fV, %.0fC)", state.Derate.Factor*100, state.Voltage, state.ServoTemperature)
+		state.Publish(hexapod.EventDerateEngaged, hexapod.Warning, state.Derate.Factor)
+	}
+
+	return nil
+}
+
+// Evaluate returns the limit on the speed at the given voltage and servo
+// temperature, either of which can be zero if it hasn't been read.
+func Evaluate(cfg config.Derate, safety config.Safety, voltage, temp float64) hexapod.Derate {
+	b := 1.0
+	if voltage > 0 {
+		b = curve(cfg.NominalVoltage-voltage, cfg.NominalVoltage-safety.MinVoltage, cfg.BatteryFactor, cfg.BatteryCurve)
+	}
+
+	t := 1.0
+	if temp > 0 {
+		t = curve(temp-cfg.WarmTemperature, cfg.HotTemperature-cfg.WarmTemperature, cfg.ThermalFactor, cfg.ThermalCurve)
+	}
+
+	f := math.Min(b, t)
+	if f >= 1 {
+		return hexapod.Derate{}
+	}
+
+	return hexapod.Derate{Active: true, Factor: f, Battery: b, Thermal: t}
+}
+
+// curve returns the factor which is x along a span, where zero (or less) is full
+// speed and the whole span (or more) is the given factor. The fraction of the
+// way along is raised to the given power. A span of zero is a step.
+func curve(x, span, factor, pow float64) float64 {
+	if x <= 0 {
+		return 1
+	}
+
+	p := 1.0
+	if span > 0 {
+		p = math.Min(x/span, 1)
+	}
+
+	return 1 - (1-factor)*math.Pow(p, pow)
+}
@@ -0,0 +1,112 @@
+package derate
+
+import (
+	"testing"
+	"time"
+
+	"github.com/adammck/hexapod"
+	"github.com/adammck/hexapod/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBattery(t *testing.T) {
+	cfg := config.Default()
+
+	// The factor at each voltage, with no temperature reading. The nominal
+	// voltage is 11.1V, and the min voltage (at which it's 60%) is 9.6V.
+	for _, tc := range []struct {
+		voltage float64
+		want    float64
+	}{
+		{0, 1},
+		{12.6, 1},
+		{11.1, 1},
+		{10.35, 0.8},
+		{9.6, 0.6},
+		{9.0, 0.6},
+	} {
+		d := Evaluate(cfg.Derate, cfg.Safety, tc.voltage, 0)
+		assert.InDelta(t, tc.want, d.Scale(), 1e-9, "at %vV", tc.voltage)
+		assert.Equal(t, tc.want < 1, d.Active, "at %vV", tc.voltage)
+	}
+
+	// A steeper curve holds on to full speed for longer, but ends up in the
+	// same place.
+	cfg.Derate.BatteryCurve = 2
+	assert.InDelta(t, 0.9, Evaluate(cfg.Derate, cfg.Safety, 10.35, 0).Factor, 1e-9)
+	assert.InDelta(t, 0.6, Evaluate(cfg.Derate, cfg.Safety, 9.6, 0).Factor, 1e-9)
+
+	// A factor of one disables it.
+	cfg.Derate.BatteryFactor = 1
+	assert.Equal(t, hexapod.Derate{}, Evaluate(cfg.Derate, cfg.Safety, 9.6, 0))
+}
+
+func TestThermal(t *testing.T) {
+	cfg := config.Default()
+
+	// Likewise from 55C (full speed) to 65C (70%), with no voltage reading.
+	for _, tc := range []struct {
+		temp float64
+		want float64
+	}{
+		{0, 1},
+		{40, 1},
+		{55, 1},
+		{60, 0.85},
+		{65, 0.7},
+		{80, 0.7},
+	} {
+		d := Evaluate(cfg.Derate, cfg.Safety, 0, tc.temp)
+		assert.InDelta(t, tc.want, d.Scale(), 1e-9, "at %vC", tc.temp)
+	}
+}
+
+func TestComposition(t *testing.T) {
+	cfg := config.Default()
+
+	// The lowest factor wins, whichever it is, and both are reported.
+	assert.Equal(t, hexapod.Derate{Active: true, Factor: 0.7, Battery: 0.8, Thermal: 0.7}, round(Evaluate(cfg.Derate, cfg.Safety, 10.35, 70)))
+	assert.Equal(t, hexapod.Derate{Active: true, Factor: 0.6, Battery: 0.6, Thermal: 0.85}, round(Evaluate(cfg.Derate, cfg.Safety, 9.6, 60)))
+
+	// Either one alone is enough to limit it.
+	assert.Equal(t, hexapod.Derate{Active: true, Factor: 0.85, Battery: 1, Thermal: 0.85}, round(Evaluate(cfg.Derate, cfg.Safety, 12, 60)))
+	assert.Equal(t, hexapod.Derate{Active: true, Factor: 0.8, Battery: 0.8, Thermal: 1}, round(Evaluate(cfg.Derate, cfg.Safety, 10.35, 30)))
+	assert.Equal(t, hexapod.Derate{}, Evaluate(cfg.Derate, cfg.Safety, 12, 30))
+}
+
+// round rounds the factors to two places, to compare them.
+func round(d hexapod.Derate) hexapod.Derate {
+	r := func(v float64) float64 { return float64(int(v*100+0.5)) / 100 }
+	return hexapod.Derate{Active: d.Active, Factor: r(d.Factor), Battery: r(d.Battery), Thermal: r(d.Thermal)}
+}
+
+func TestTick(t *testing.T) {
+	cfg := config.Default()
+	d := New(cfg.Derate, cfg.Safety)
+	assert.NoError(t, d.Boot())
+
+	state := &hexapod.State{}
+	now := time.Unix(0, 0)
+	tick := func(voltage, temp float64) hexapod.Derate {
+		state.Voltage = voltage
+		state.ServoTemperature = temp
+		now = now.Add(time.Second)
+		assert.NoError(t, d.Tick(now, state))
+		return state.Derate
+	}
+
+	// Nothing until the battery starts to run down.
+	assert.Equal(t, hexapod.Derate{}, tick(12, 40))
+	assert.Empty(t, state.Published())
+
+	// It's published the first time that it engages, but not again after
+	// it wobbles above the nominal voltage and back.
+	assert.True(t, tick(11, 40).Active)
+	assert.False(t, tick(11.2, 40).Active)
+	assert.True(t, tick(10.9, 40).Active)
+
+	if ev := state.Published(); assert.Len(t, ev, 1) {
+		assert.Equal(t, hexapod.EventDerateEngaged, ev[0].Name)
+		assert.Equal(t, hexapod.Warning, ev[0].Severity)
+	}
+}
@@ -0,0 +1,46 @@
+package discovery
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// BeaconVersion is incremented whenever the beacon payload changes in a way
+// which might break a client. Beacons with a different version are ignored.
+const BeaconVersion = 1
+
+// Beacon is broadcast periodically by each hexapod, so clients can find it
+// without knowing its address. Zero ports mean that the service is disabled.
+type Beacon struct {
+	Version       int    `json:"version"`
+	Name          string `json:"name"`
+	ID            string `json:"id"`
+	Description   string `json:"description,omitempty"`
+	Firmware      string `json:"firmware"`
+	APIPort       int    `json:"api_port"`
+	TelemetryPort int    `json:"telemetry_port"`
+	Battery       int    `json:"battery"`
+	Shutdown      bool   `json:"shutdown"`
+}
+
+// Encode returns the wire format of the beacon.
+func (b Beacon) Encode() ([]byte, error) {
+	b.Version = BeaconVersion
+	return json.Marshal(b)
+}
+
+// Decode parses a beacon, and returns an error if it's garbage or from an
+// incompatible version.
+func Decode(p []byte) (Beacon, error) {
+	var b Beacon
+	err := json.Unmarshal(p, &b)
+	if err != nil {
+		return Beacon{}, err
+	}
+
+	if b.Version != BeaconVersion {
+		return Beacon{}, fmt.Errorf("unsupported beacon version: %d", b.Version)
+	}
+
+	return b, nil
+}
@@ -0,0 +1,85 @@
+package discovery
+
+import (
+	"net"
+	"sort"
+	"time"
+)
+
+// Robot is a hexapod which was discovered by Discover.
+type Robot struct {
+	Addr     net.IP
+	Beacon   Beacon
+	LastSeen time.Time
+}
+
+// Discover listens for beacons on the given port for the given duration, and
+// returns every robot which sent one, sorted by name. Robots usually send many
+// beacons during the window; only the most recent one from each is returned.
+func Discover(port int, timeout time.Duration) ([]Robot, error) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{Port: port})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	return collect(conn, time.Now().Add(timeout))
+}
+
+func collect(conn net.PacketConn, deadline time.Time) ([]Robot, error) {
+	err := conn.SetReadDeadline(deadline)
+	if err != nil {
+		return nil, err
+	}
+
+	// Keyed by the address and name, in case two robots are (somehow) behind
+	// the same address.
+	type key struct {
+		addr string
+		name string
+	}
+
+	seen := map[key]*Robot{}
+	buf := make([]byte, 1024)
+
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				break
+			}
+			return nil, err
+		}
+
+		// Ignore anything which isn't a (compatible) beacon. There might be
+		// something else on the port, and we can't do anything about it.
+		b, err := Decode(buf[:n])
+		if err != nil {
+			continue
+		}
+
+		ip := addr.(*net.UDPAddr).IP
+		k := key{ip.String(), b.Name}
+		if r, ok := seen[k]; ok {
+			r.Beacon = b
+			r.LastSeen = time.Now()
+			continue
+		}
+
+		seen[k] = &Robot{Addr: ip, Beacon: b, LastSeen: time.Now()}
+	}
+
+	out := make([]Robot, 0, len(seen))
+	for _, r := range seen {
+		out = append(out, *r)
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Beacon.Name != out[j].Beacon.Name {
+			return out[i].Beacon.Name < out[j].Beacon.Name
+		}
+		return out[i].Addr.String() < out[j].Addr.String()
+	})
+
+	return out, nil
+}
@@ -0,0 +1,101 @@
+package discovery
+
+import (
+	"net"
+	"time"
+
+	"github.com/adammck/hexapod"
+	"github.com/adammck/hexapod/components/voltage"
+	"github.com/adammck/hexapod/config"
+)
+
+var log = hexapod.NewLog("discovery")
+
+const (
+
+	// DefaultPort is the well-known UDP port which beacons are sent to.
+	DefaultPort = 7337
+
+	// How long to wait for a single beacon to be sent. This is called from the
+	// main loop, so must be short; a UDP send shouldn't block anyway.
+	writeTimeout = 5 * time.Millisecond
+)
+
+// Discovery is a component which broadcasts a Beacon every interval.
+type Discovery struct {
+	beacon   Beacon
+	interval time.Duration
+	safety   config.Safety
+	addr     *net.UDPAddr
+	conn     net.PacketConn
+
+	// The time at which the last beacon was sent.
+	last time.Time
+}
+
+// New creates a discovery component which broadcasts to the given port every
+// interval. The beacon's firmware and ports are fixed; the rest is copied from
+// the state before each broadcast, including the name and ID (see
+// State.Identity), unless it has none. The safety config is used to estimate
+// the battery level.
+func New(b Beacon, port int, interval time.Duration, safety config.Safety) *Discovery {
+	return newWithAddr(b, &net.UDPAddr{IP: net.IPv4bcast, Port: port}, interval, safety)
+}
+
+func newWithAddr(b Beacon, addr *net.UDPAddr, interval time.Duration, safety config.Safety) *Discovery {
+	return &Discovery{
+		beacon:   b,
+		interval: interval,
+		safety:   safety,
+		addr:     addr,
+	}
+}
+
+// Boot opens the socket which beacons are sent from.
+func (d *Discovery) Boot() error {
+	conn, err := net.ListenPacket("udp4", ":0")
+	if err != nil {
+		return err
+	}
+
+	log.Infof("broadcasting beacons to %s every %s", d.addr, d.interval)
+	d.conn = conn
+	return nil
+}
+
+// Tick sends a beacon, if enough time has passed since the last one. Errors
+// are logged rather than returned, since the hexapod is fine without them.
+func (d *Discovery) Tick(now time.Time, state *hexapod.State) error {
+	if now.Sub(d.last) < d.interval {
+		return nil
+	}
+
+	d.last = now
+
+	b := d.beacon
+	b.Shutdown = state.Shutdown
+
+	if id := state.Identity; id.ID != "" {
+		b.Name = id.Name
+		b.ID = id.ID
+		b.Description = id.Description
+	}
+
+	// Leave the battery at zero (unknown) until the first voltage check.
+	if state.Voltage > 0 {
+		b.Battery = voltage.Percent(state.Voltage, d.safety)
+	}
+
+	p, err := b.Encode()
+	if err != nil {
+		return err
+	}
+
+	d.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	_, err = d.conn.WriteTo(p, d.addr)
+	if err != nil {
+		log.RateLimited("send", time.Minute).Warnf("%s (while sending beacon)", err)
+	}
+
+	return nil
+}
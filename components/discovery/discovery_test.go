@@ -0,0 +1,119 @@
+package discovery
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/adammck/hexapod"
+	"github.com/adammck/hexapod/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBeaconRoundTrip(t *testing.T) {
+	b := Beacon{
+		Name:          "hexapod",
+		Firmware:      "1.2.3",
+		APIPort:       8000,
+		TelemetryPort: 8001,
+		Battery:       50,
+		Shutdown:      true,
+	}
+
+	p, err := b.Encode()
+	assert.NoError(t, err)
+
+	b2, err := Decode(p)
+	assert.NoError(t, err)
+	b.Version = BeaconVersion
+	assert.Equal(t, b, b2)
+
+	_, err = Decode([]byte(`{"version":999,"name":"future"}`))
+	assert.Error(t, err)
+
+	_, err = Decode([]byte(`garbage`))
+	assert.Error(t, err)
+}
+
+func TestDiscoverDeduplicates(t *testing.T) {
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer conn.Close()
+	addr := conn.LocalAddr().(*net.UDPAddr)
+
+	alpha := newWithAddr(Beacon{Name: "alpha", APIPort: 8000}, addr, time.Second, config.Default().Safety)
+	beta := newWithAddr(Beacon{Name: "beta"}, addr, time.Second, config.Default().Safety)
+	assert.NoError(t, alpha.Boot())
+	assert.NoError(t, beta.Boot())
+
+	// Alpha sends a few beacons, and the last one has a different battery.
+	start := time.Now()
+	state := &hexapod.State{}
+	for i := 0; i < 5; i++ {
+		if i == 4 {
+			state.Voltage = 12.6
+		}
+		assert.NoError(t, alpha.Tick(start.Add(time.Duration(i)*time.Second), state))
+	}
+	assert.NoError(t, beta.Tick(start, &hexapod.State{Shutdown: true}))
+
+	// The identity in the state wins over the name in the beacon.
+	gamma := newWithAddr(Beacon{Name: "alpha"}, addr, time.Second, config.Default().Safety)
+	assert.NoError(t, gamma.Boot())
+	id := hexapod.Identity{Name: "gamma", ID: "gamma-1", Description: "spare"}
+	assert.NoError(t, gamma.Tick(start, &hexapod.State{Identity: id}))
+
+	// Junk on the same port is ignored.
+	_, err = conn.WriteTo([]byte("hello"), addr)
+	assert.NoError(t, err)
+
+	robots, err := collect(conn, time.Now().Add(200*time.Millisecond))
+	assert.NoError(t, err)
+	if !assert.Len(t, robots, 3) {
+		return
+	}
+
+	assert.Equal(t, "alpha", robots[0].Beacon.Name)
+	assert.Equal(t, 8000, robots[0].Beacon.APIPort)
+	assert.Equal(t, 100, robots[0].Beacon.Battery)
+	assert.Equal(t, "127.0.0.1", robots[0].Addr.String())
+
+	assert.Equal(t, "beta", robots[1].Beacon.Name)
+	assert.True(t, robots[1].Beacon.Shutdown)
+
+	assert.Equal(t, "gamma", robots[2].Beacon.Name)
+	assert.Equal(t, "gamma-1", robots[2].Beacon.ID)
+	assert.Equal(t, "spare", robots[2].Beacon.Description)
+}
+
+func TestRateLimit(t *testing.T) {
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer conn.Close()
+
+	d := newWithAddr(Beacon{Name: "alpha"}, conn.LocalAddr().(*net.UDPAddr), time.Second, config.Default().Safety)
+	assert.NoError(t, d.Boot())
+
+	start := time.Now()
+	for i := 0; i < 10; i++ {
+		assert.NoError(t, d.Tick(start.Add(time.Duration(i)*100*time.Millisecond), &hexapod.State{}))
+	}
+
+	// Only the first tick should have sent anything.
+	n := 0
+	buf := make([]byte, 1024)
+	conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	for {
+		_, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			break
+		}
+		n += 1
+	}
+
+	assert.Equal(t, 1, n)
+}
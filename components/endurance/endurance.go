@@ -0,0 +1,282 @@
+// Package endurance parks the hex for a rest now and then on a long run, while
+// its servos are heating up, so they can cool down before they overheat.
+package endurance
+
+import (
+	"math"
+	"time"
+
+	"github.com/adammck/hexapod"
+	"github.com/adammck/hexapod/config"
+	"github.com/adammck/hexapod/math3d"
+	"github.com/adammck/hexapod/params"
+)
+
+var log = hexapod.NewLog("endurance")
+
+const (
+
+	// How often to sample the servo temperature for the trend. It's only read
+	// every safety.voltage_interval, so most samples repeat the last reading,
+	// which doesn't hurt the fit.
+	sampleInterval = 5 * time.Second
+
+	// The fewest samples which a trend is fitted to, the fraction of the window
+	// which they must span, and how long ago the latest can be. Any older, and
+	// the readings have stopped.
+	minSamples = 3
+	minSpan    = 0.5
+	maxAge     = 3 * sampleInterval
+
+	// How far (in mm) the target can be from the pose, and how far (in degrees)
+	// it can be turned from it, before the hex counts as walking.
+	walkingDistance = 1.0
+	walkingAngle    = 1.0
+)
+
+// sample is a servo temperature reading, and when it was taken.
+type sample struct {
+	at   time.Time
+	temp float64
+}
+
+// Endurance is a component which, in endurance mode, parks the hex for a rest
+// after every config.Endurance.Walk of walking, if the servo temperature is
+// rising fast enough to reach the warning temperature within the horizon. The
+// sooner it would, the longer the rest. While resting, State.Cooling is set,
+// so the LEDs and buzzer can show that it's on purpose.
+//
+// The temperature is State.ServoTemperature, which is only one servo, but the
+// others are about as hot. If there are no readings (or too few to fit a trend
+// to), the hex doesn't rest at all, rather than guessing.
+//
+// Rests are timed by the clock rather than by counting ticks, and end early if
+// the sticks are used. Afterwards the target is left to whichever component
+// set it before, so the hex carries on with whatever it was told to do. This
+// must come after every component which sets the target, so that it can hold
+// it while resting.
+type Endurance struct {
+	cfg config.Endurance
+
+	// The registry to register the params with at boot. This is params.Default
+	// unless changed, so more than one instance can be booted (e.g. in tests).
+	Params *params.Registry
+
+	// Tunable params. See Boot.
+	enabled bool
+
+	// The readings within the window, oldest first, and when the last was.
+	samples []sample
+	sampled time.Time
+
+	// The time of the last tick, and how long the hex has walked since the
+	// last rest (or boot).
+	last   time.Time
+	walked time.Duration
+
+	// Whether the hex is resting, and until when.
+	resting bool
+	until   time.Time
+
+	// Whether there was no trend last time it was needed, so that's only
+	// logged once.
+	noTrend bool
+}
+
+// New creates an endurance component.
+func New(cfg config.Endurance) *Endurance {
+	return &Endurance{
+		cfg:     cfg,
+		Params:  params.Default,
+		enabled: cfg.Enabled,
+	}
+}
+
+// Writes returns hexapod.Commander, since it holds the target while resting.
+func (e *Endurance) Writes() hexapod.Role {
+	return hexapod.Commander
+}
+
+func (e *Endurance) Boot() error {
+	return e.Params.Register(params.Param{
+
+		// Whether endurance mode is on. Turning it off ends any rest.
+		Name: "endurance.enabled",
+		Type: params.Bool,
+		Min:  0,
+		Max:  1,
+		Get: func() float64 {
+			if e.enabled {
+				return 1
+			}
+			return 0
+		},
+		Set: func(v float64) { e.enabled = v != 0 },
+	})
+}
+
+func (e *Endurance) Tick(now time.Time, state *hexapod.State) error {
+	var dt time.Duration
+	if !e.last.IsZero() {
+		dt = now.Sub(e.last)
+	}
+	e.last = now
+
+	e.sample(now, state.ServoTemperature)
+
+	if e.resting {
+		switch {
+		case state.Shutdown:
+			e.resume(state, "shutting down")
+		case !e.enabled:
+			e.resume(state, "endurance mode disabled")
+		case state.Input.Walking():
+			e.resume(state, "manual input")
+		case !now.Before(e.until):
+			e.resume(state, "rested")
+		default:
+			hold(state)
+		}
+
+		return nil
+	}
+
+	if !e.enabled || state.Shutdown || state.Halt || state.Calibrating || state.SelfTesting || state.Fallen {
+		return nil
+	}
+
+	if walking(state.Pose, state.Target) {
+		e.walked += dt
+	}
+
+	// Don't start a rest while the sticks are being used, since it'd only end
+	// straight away.
+	if e.walked < e.cfg.Walk.Duration || state.Input.Walking() {
+		return nil
+	}
+
+	temp, slope, ok := e.trend(now)
+	if !ok {
+		if !e.noTrend {
+			log.Warn("not enough servo temperature readings to tell whether to rest")
+			e.noTrend = true
+		}
+		return nil
+	}
+	e.noTrend = false
+
+	d, ok := e.restFor(temp, slope)
+	if !ok {
+		return nil
+	}
+
+	log.Infof("servos are at %.0fC and rising %.2fC/min, resting for %s", temp, slope, d)
+	e.resting = true
+	e.until = now.Add(d)
+	state.Cooling = true
+	state.Publish(hexapod.EventCoolingStarted, hexapod.Info, d.Seconds())
+	hold(state)
+
+	return nil
+}
+
+// resume ends the rest, leaving the target to whichever component set it.
+func (e *Endurance) resume(state *hexapod.State, reason string) {
+	log.Infof("resuming (%s)", reason)
+	e.resting = false
+	e.walked = 0
+	state.Cooling = false
+	state.Publish(hexapod.EventCoolingEnded, hexapod.Info, reason)
+}
+
+// sample records the temperature, if it's been read and it's time to. Readings
+// which have fallen out of the window are dropped, so if the temperature stops
+// being read, there's soon no trend.
+func (e *Endurance) sample(now time.Time, temp float64) {
+	cutoff := now.Add(-e.cfg.Window.Duration)
+	i := 0
+	for i < len(e.samples) && e.samples[i].at.Before(cutoff) {
+		i++
+	}
+	e.samples = e.samples[i:]
+
+	if temp <= 0 || (!e.sampled.IsZero() && now.Sub(e.sampled) < sampleInterval) {
+		return
+	}
+
+	e.sampled = now
+	e.samples = append(e.samples, sample{now, temp})
+}
+
+// trend returns the latest temperature, and the rate (in degrees C per minute)
+// which it's changing at, fitted to the samples by least squares. It returns
+// false if there aren't enough recent samples for that to mean anything.
+func (e *Endurance) trend(now time.Time) (float64, float64, bool) {
+	n := len(e.samples)
+	if n < minSamples || now.Sub(e.samples[n-1].at) > maxAge {
+		return 0, 0, false
+	}
+
+	start := e.samples[0].at
+	if e.samples[n-1].at.Sub(start) < time.Duration(float64(e.cfg.Window.Duration)*minSpan) {
+		return 0, 0, false
+	}
+
+	var sx, sy, sxx, sxy float64
+	for _, s := range e.samples {
+		x := s.at.Sub(start).Minutes()
+		sx += x
+		sy += s.temp
+		sxx += x * x
+		sxy += x * s.temp
+	}
+
+	fn := float64(n)
+	slope := (fn*sxy - sx*sy) / (fn*sxx - sx*sx)
+	return e.samples[n-1].temp, slope, true
+}
+
+// restFor returns how long to rest for, given the temperature and its trend,
+// or false if it isn't heading for the warning temperature within the horizon.
+// The rest is min_rest if it would get there at the horizon, and as much longer
+// as it would get there sooner, up to max_rest.
+func (e *Endurance) restFor(temp, slope float64) (time.Duration, bool) {
+	if slope <= 0 {
+		return 0, false
+	}
+
+	eta := time.Duration((e.cfg.WarnTemperature - temp) / slope * float64(time.Minute))
+	if eta > e.cfg.Horizon.Duration {
+		return 0, false
+	}
+
+	min, max := e.cfg.MinRest.Duration, e.cfg.MaxRest.Duration
+	if eta <= 0 {
+		return max, true
+	}
+
+	d := time.Duration(float64(min) * float64(e.cfg.Horizon.Duration) / float64(eta))
+	if d > max {
+		d = max
+	}
+
+	return d.Round(time.Second), true
+}
+
+// hold parks the hex where it is.
+func hold(state *hexapod.State) {
+	state.Target = state.Pose
+	state.Target.Position.Y = 0
+	state.Target.Pitch = 0
+	state.Target.Bank = 0
+}
+
+// walking returns true if the target is far enough from the pose that the hex
+// is walking towards it.
+func walking(pose, target math3d.Pose) bool {
+	dx := target.Position.X - pose.Position.X
+	dz := target.Position.Z - pose.Position.Z
+
+	return math.Sqrt(dx*dx+dz*dz) > walkingDistance ||
+		math.Abs(math3d.AngleDiff(pose.Heading, target.Heading)) > walkingAngle
+}
@@ -0,0 +1,201 @@
+package endurance
+
+import (
+	"testing"
+	"time"
+
+	"github.com/adammck/hexapod"
+	"github.com/adammck/hexapod/config"
+	"github.com/adammck/hexapod/hexapodtest"
+	"github.com/adammck/hexapod/math3d"
+	"github.com/adammck/hexapod/params"
+	"github.com/stretchr/testify/assert"
+)
+
+const step = 100 * time.Millisecond
+
+// fixture ticks the endurance component, with a synthetic temperature, and
+// records the rests which it takes.
+type fixture struct {
+	*hexapodtest.Clock
+	e     *Endurance
+	start time.Time
+
+	// The temperature, given the minutes since the start. Zero is no reading.
+	temp func(min float64) float64
+
+	// Called before each tick, to set up the input.
+	input func(state *hexapod.State)
+
+	rests []rest
+}
+
+// rest is when a rest started (since the start), and how long it lasted, or zero
+// if it hasn't ended yet.
+type rest struct {
+	at  time.Duration
+	dur time.Duration
+}
+
+func setup(t *testing.T, temp func(float64) float64) *fixture {
+	cfg := config.Default().Endurance
+	cfg.Enabled = true
+
+	e := New(cfg)
+	e.Params = params.New()
+	assert.NoError(t, e.Boot())
+
+	c := hexapodtest.NewClock(t, step)
+	return &fixture{
+		Clock: c,
+		e:     e,
+		start: c.Now,
+		temp:  temp,
+	}
+}
+
+// walk ticks for the given duration, with the controller (or whatever) setting
+// the target ahead of the pose on every tick, as it does while walking.
+func (f *fixture) walk(d time.Duration) {
+	end := f.Now.Add(d)
+	for ; f.Now.Before(end); f.Now = f.Now.Add(f.Interval) {
+		s := f.State
+		s.Target = s.Pose
+		s.Target.Position.Y = 40
+		s.Target.Position.Z += 50
+		s.Input = hexapod.Input{}
+		if f.input != nil {
+			f.input(s)
+		}
+
+		s.ServoTemperature = f.temp(f.Now.Sub(f.start).Minutes())
+		was := s.Cooling
+		assert.NoError(f.T, f.e.Tick(f.Now, s))
+
+		if s.Cooling && !was {
+			f.rests = append(f.rests, rest{at: f.Now.Sub(f.start)})
+		}
+		if !s.Cooling && was {
+			r := &f.rests[len(f.rests)-1]
+			r.dur = f.Now.Sub(f.start) - r.at
+		}
+
+		// The target is held where the hex is, sitting down.
+		if s.Cooling {
+			assert.Equal(f.T, math3d.Pose{}, s.Target)
+		}
+	}
+}
+
+// rising returns a temperature which rises linearly from 40C at the given rate
+// (in degrees C per minute).
+func rising(rate float64) func(float64) float64 {
+	return func(min float64) float64 {
+		return 40 + rate*min
+	}
+}
+
+func TestNoRestWhileCool(t *testing.T) {
+	for name, temp := range map[string]func(float64) float64{
+		"flat":    func(float64) float64 { return 50 },
+		"falling": func(min float64) float64 { return 60 - min },
+
+		// At 1C/min from 40C, it'd take 25 minutes to reach 65C, which is past
+		// the ten minute horizon, for the first fifteen minutes.
+		"rising slowly": rising(1),
+	} {
+		t.Run(name, func(t *testing.T) {
+			f := setup(t, temp)
+			f.walk(14 * time.Minute)
+			assert.Empty(t, f.rests)
+		})
+	}
+}
+
+func TestRestsWhileHeatingUp(t *testing.T) {
+	f := setup(t, rising(2))
+	f.walk(10 * time.Minute)
+
+	// At 2C/min from 40C, it's heading for 65C within the ten minute horizon
+	// from 2m30s, so it rests then (rather than at two minutes), and after every
+	// two minutes of walking after that. This trend carries on rising while it
+	// rests, so each rest is longer than the last, since it's getting closer.
+	assert.Equal(t, []rest{
+		{2*time.Minute + 30*time.Second, 15 * time.Second},
+		{4*time.Minute + 45*time.Second, 19 * time.Second},
+		{7*time.Minute + 4*time.Second, 27 * time.Second},
+		{9*time.Minute + 31*time.Second, 0},
+	}, f.rests)
+
+	// Until it's about to reach the warning temperature, when the rests are as
+	// long as they get.
+	f.walk(5 * time.Minute)
+	assert.Equal(t, 50*time.Second, f.rests[3].dur)
+	assert.Equal(t, time.Minute, f.rests[4].dur)
+}
+
+func TestRestsLongerWhenHeatingFaster(t *testing.T) {
+	slow := setup(t, rising(2.5))
+	slow.walk(3 * time.Minute)
+	fast := setup(t, rising(4))
+	fast.walk(3 * time.Minute)
+
+	assert.Equal(t, []rest{{2 * time.Minute, 19 * time.Second}}, slow.rests)
+	assert.Equal(t, []rest{{2 * time.Minute, 35 * time.Second}}, fast.rests)
+}
+
+func TestNoRestWithoutReadings(t *testing.T) {
+	f := setup(t, func(float64) float64 { return 0 })
+	f.walk(5 * time.Minute)
+	assert.Empty(t, f.rests)
+
+	// Or once they stop, even if the last few were heading for the warning.
+	f = setup(t, func(min float64) float64 {
+		if min > 1 {
+			return 0
+		}
+		return 40 + 5*min
+	})
+	f.walk(5 * time.Minute)
+	assert.Empty(t, f.rests)
+}
+
+func TestOnlyCountsWalking(t *testing.T) {
+	f := setup(t, rising(2))
+
+	// Standing still for a while doesn't count towards the walk.
+	f.input = func(s *hexapod.State) { s.Target = s.Pose }
+	f.walk(3 * time.Minute)
+	assert.Empty(t, f.rests)
+
+	f.input = nil
+	f.walk(time.Minute + 59*time.Second)
+	assert.Empty(t, f.rests)
+	f.walk(2 * time.Second)
+	assert.Len(t, f.rests, 1)
+}
+
+func TestManualInputOverrides(t *testing.T) {
+	f := setup(t, rising(2))
+	f.walk(2*time.Minute + 35*time.Second)
+	assert.True(t, f.State.Cooling)
+
+	f.input = func(s *hexapod.State) { s.Input.LeftY = -127 }
+	f.walk(step)
+	assert.False(t, f.State.Cooling)
+	assert.Equal(t, 5*time.Second, f.rests[0].dur)
+
+	// And no rest starts while it's being used, even though it's due.
+	f.walk(3 * time.Minute)
+	assert.Len(t, f.rests, 1)
+	assert.Equal(t, []string{hexapod.EventCoolingStarted, hexapod.EventCoolingEnded}, f.Events())
+}
+
+func TestDisabled(t *testing.T) {
+	f := setup(t, rising(2))
+	assert.NoError(t, f.e.Params.Set(map[string]float64{"endurance.enabled": 0}))
+	f.e.Params.Apply()
+
+	f.walk(5 * time.Minute)
+	assert.Empty(t, f.rests)
+}
@@ -0,0 +1,77 @@
+package follow
+
+import (
+	"net"
+	"time"
+
+	"github.com/adammck/hexapod"
+)
+
+var log = hexapod.NewLog("follow")
+
+// How long to wait for a single packet to be sent. This is called from the main
+// loop, so must be short; a UDP send shouldn't block anyway.
+const writeTimeout = 5 * time.Millisecond
+
+// Broadcaster is a component which broadcasts the pose every interval, so that
+// other hexes can follow this one.
+type Broadcaster struct {
+	addr     *net.UDPAddr
+	interval time.Duration
+	conn     net.PacketConn
+
+	// The time at which the last packet was sent.
+	last time.Time
+}
+
+// NewBroadcaster creates a component which broadcasts the pose to the given
+// port every interval, along with the name and ID from the state.
+func NewBroadcaster(port int, interval time.Duration) *Broadcaster {
+	return newBroadcasterWithAddr(&net.UDPAddr{IP: net.IPv4bcast, Port: port}, interval)
+}
+
+func newBroadcasterWithAddr(addr *net.UDPAddr, interval time.Duration) *Broadcaster {
+	return &Broadcaster{
+		addr:     addr,
+		interval: interval,
+	}
+}
+
+// Boot opens the socket which packets are sent from.
+func (b *Broadcaster) Boot() error {
+	conn, err := net.ListenPacket("udp4", ":0")
+	if err != nil {
+		return err
+	}
+
+	log.Infof("broadcasting pose to %s every %s", b.addr, b.interval)
+	b.conn = conn
+	return nil
+}
+
+// Tick sends the pose, if enough time has passed since the last one. Errors are
+// logged rather than returned, since the hex is fine without followers.
+func (b *Broadcaster) Tick(now time.Time, state *hexapod.State) error {
+	if now.Sub(b.last) < b.interval {
+		return nil
+	}
+
+	b.last = now
+
+	p, err := Packet{
+		ID:   state.Identity.ID,
+		Name: state.Identity.Name,
+		Pose: state.Pose,
+	}.Encode()
+	if err != nil {
+		return err
+	}
+
+	b.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	_, err = b.conn.WriteTo(p, b.addr)
+	if err != nil {
+		log.RateLimited("send", time.Minute).Warnf("%s (while sending pose)", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,237 @@
+package follow
+
+import (
+	"math"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/adammck/dynamixel/network"
+	"github.com/adammck/hexapod"
+	"github.com/adammck/hexapod/components/legs"
+	"github.com/adammck/hexapod/components/navigator"
+	"github.com/adammck/hexapod/components/sim"
+	"github.com/adammck/hexapod/config"
+	"github.com/adammck/hexapod/math3d"
+	"github.com/adammck/hexapod/params"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPacketRoundTrip(t *testing.T) {
+	p := Packet{
+		ID:   "alpha",
+		Name: "Alpha",
+		Pose: math3d.Pose{Position: math3d.Vector3{X: 10, Y: 40, Z: -20}, Heading: 45},
+	}
+
+	b, err := p.Encode()
+	assert.NoError(t, err)
+
+	p2, err := Decode(b)
+	assert.NoError(t, err)
+	p.Version = PacketVersion
+	assert.Equal(t, p, p2)
+
+	_, err = Decode([]byte(`{"version":999,"id":"future"}`))
+	assert.Error(t, err)
+
+	_, err = Decode([]byte(`garbage`))
+	assert.Error(t, err)
+}
+
+// at returns a packet from the leader at the given X/Z position and heading.
+func at(x, z, heading float64) Packet {
+	return Packet{ID: "alpha", Name: "Alpha", Pose: math3d.Pose{Position: math3d.Vector3{X: x, Z: z}, Heading: heading}}
+}
+
+func TestFollower(t *testing.T) {
+	cfg := config.Default().Follow
+	cfg.Leader = "alpha"
+	f := newFollowerWithAddr(cfg, "127.0.0.1:0")
+
+	now := time.Unix(0, 0)
+	state := &hexapod.State{}
+	tick := func(d time.Duration) {
+		now = now.Add(d)
+		assert.NoError(t, f.Tick(now, state))
+	}
+
+	// Nothing is touched until the leader is heard from.
+	state.Target.Position.Z = 5
+	tick(time.Second)
+	assert.Equal(t, hexapod.Follow{}, state.Follow)
+	assert.Equal(t, 5.0, state.Target.Position.Z)
+
+	// This hex is in the slot when it is, wherever that is in each world
+	// space, and facing the same way as the leader.
+	state.Pose = math3d.Pose{Position: math3d.Vector3{X: 100, Y: 40, Z: 100}}
+	f.receive(at(1000, 0, 90))
+	tick(time.Second / 10)
+	assert.Equal(t, hexapod.Follow{Active: true, Leader: "Alpha"}, state.Follow)
+	assert.InDelta(t, 100, state.Target.Position.X, 1e-6)
+	assert.InDelta(t, 100, state.Target.Position.Z, 1e-6)
+	if ev := state.Published(); assert.Len(t, ev, 1) {
+		assert.Equal(t, hexapod.EventLeaderFound, ev[0].Name)
+	}
+
+	// The leader walks forwards by 50mm, and then by another 50mm, so this
+	// hex walks forwards too, straight away.
+	f.receive(at(1050, 0, 90))
+	tick(time.Second / 10)
+	assert.InDelta(t, 50, state.Follow.Distance, 1e-6)
+	assert.InDelta(t, 100, state.Target.Position.X, 1e-6)
+	assert.InDelta(t, 150, state.Target.Position.Z, 1e-6)
+	assert.InDelta(t, 0, state.Target.Heading, 1e-6)
+
+	f.receive(at(1100, 0, 90))
+	tick(time.Second / 10)
+	assert.InDelta(t, 100, state.Follow.Distance, 1e-6)
+	assert.InDelta(t, 200, state.Target.Position.Z, 1e-6)
+
+	// Whenever the sticks are used, it leaves the target to the controller.
+	state.Input.LeftY = 50
+	state.Target.Position.Z = 0
+	tick(time.Second / 10)
+	assert.True(t, state.Follow.Paused)
+	assert.Equal(t, 0.0, state.Target.Position.Z)
+
+	// Having been left behind by then, it closes in from the pose once they're
+	// released, no faster than the closing speed: 10mm per tenth of a second.
+	state.Input.LeftY = 0
+	f.receive(at(1100, 0, 90))
+	tick(time.Second / 10)
+	assert.False(t, state.Follow.Paused)
+	assert.InDelta(t, 110, state.Target.Position.Z, 1e-6)
+	tick(time.Second / 10)
+	assert.InDelta(t, 120, state.Target.Position.Z, 1e-6)
+
+	// Once the leader hasn't been heard from for too long, it halts straight
+	// away, holding the target at the pose.
+	state.Pose.Position.Z = 130
+	state.Pose.Heading = 5
+	tick(cfg.Timeout.Duration)
+	assert.True(t, state.Follow.Lost)
+	assert.Equal(t, 130.0, state.Target.Position.Z)
+	assert.Equal(t, 5.0, state.Target.Heading)
+	if ev := state.Published(); assert.Len(t, ev, 2) {
+		assert.Equal(t, hexapod.EventLeaderLost, ev[1].Name)
+		assert.Equal(t, hexapod.Warning, ev[1].Severity)
+	}
+
+	// It carries on once it is, in the same world space, closing in from the
+	// pose again.
+	f.receive(at(1100, 0, 90))
+	tick(time.Second / 10)
+	assert.False(t, state.Follow.Lost)
+	assert.InDelta(t, 70, state.Follow.Distance, 1e-6)
+	assert.InDelta(t, 140, state.Target.Position.Z, 1e-6)
+	assert.Len(t, state.Published(), 2)
+
+	// And turns to face the same way as the leader, while it's close to the
+	// slot.
+	f.receive(at(1100, 0, 93))
+	tick(time.Second / 10)
+	assert.InDelta(t, 3, state.Target.Heading, 1e-6)
+}
+
+// walker is a simulated hex, with extra components after the legs.
+type walker struct {
+	h *hexapod.Hexapod
+	l *legs.Legs
+}
+
+func newWalker(t *testing.T, cfg config.Config, id string, extra ...hexapod.Component) *walker {
+	bus := sim.NewBus()
+	n := network.New(bus)
+
+	cfg.Identity.ID = id
+	cfg.Identity.Name = id
+	h := hexapod.New(n, cfg)
+	h.Params = params.New()
+	l := legs.New(n, cfg.Legs, cfg.Gait)
+	l.Params = h.Params
+	s := sim.New(bus, l)
+	s.Params = h.Params
+	h.Add(l)
+	h.Add(s)
+	for _, c := range extra {
+		h.Add(c)
+	}
+
+	assert.NoError(t, h.Boot())
+	h.State.Target.Position.Y = cfg.Controller.Clearance
+	return &walker{h, l}
+}
+
+func TestFollowLeader(t *testing.T) {
+	cfg := config.Default()
+	cfg.Follow.Leader = "alpha"
+
+	// The follower listens on loopback, and the leader sends to it rather
+	// than broadcasting.
+	f := newFollowerWithAddr(cfg.Follow, "127.0.0.1:0")
+	follower := newWalker(t, cfg, "beta", f)
+	b := newBroadcasterWithAddr(f.conn.LocalAddr().(*net.UDPAddr), cfg.Follow.Interval.Duration)
+	nav := navigator.New(cfg.Navigator)
+	leader := newWalker(t, cfg, "alpha", nav, b)
+
+	now := time.Unix(0, 0)
+	tick := func() {
+		now = now.Add(time.Second / 60)
+		assert.NoError(t, leader.h.Tick(now))
+
+		// Give the packet (if any) a chance to arrive, since it's sent in
+		// real time, not the simulated time.
+		time.Sleep(200 * time.Microsecond)
+		assert.NoError(t, follower.h.Tick(now))
+	}
+
+	// Stand both up. The legs wait (in real time) for the feet to reach their
+	// home positions first. The follower is placed in its slot behind the
+	// leader, since they both start at the origin of their own world.
+	for i := 0; i < 2000 && (leader.h.State.Pose.Position.Y < cfg.Controller.Clearance || follower.h.State.Pose.Position.Y < cfg.Controller.Clearance); i++ {
+		tick()
+		time.Sleep(time.Millisecond)
+	}
+	assert.True(t, follower.h.State.Follow.Active)
+	assert.InDelta(t, 0, follower.h.State.Follow.Distance, 1)
+
+	// The leader walks forwards, turns right on the spot, and walks again.
+	nav.Add(navigator.Waypoint{Forward: 400}, navigator.Waypoint{Turn: 60}, navigator.Waypoint{Forward: 300})
+
+	// The follower stays within about a step of its slot while the leader is
+	// walking straight, and falls further behind while it's turning, since
+	// the slot swings around a long way for the follower to walk.
+	straight, worst := 0.0, 0.0
+	for i := 0; i < 60*60 && (leader.h.State.Navigation.Active || i < 60); i++ {
+		tick()
+		d := follower.h.State.Follow.Distance
+		worst = math.Max(worst, d)
+		if n := leader.h.State.Navigation; n.Active && n.Reached == 0 {
+			straight = math.Max(straight, d)
+		}
+	}
+	assert.False(t, leader.h.State.Navigation.Active, "leader didn't finish its route")
+	assert.True(t, straight > 20, "follower never fell behind, so didn't move? (%.0fmm)", straight)
+	assert.True(t, straight < 120, "follower fell %.0fmm behind while walking straight", straight)
+	assert.True(t, worst < 250, "follower fell %.0fmm behind", worst)
+
+	// Nothing holds the leader's target at its pose once it's finished, since
+	// there's no controller, so do that here.
+	leader.h.State.Target.Position.X = leader.h.State.Pose.Position.X
+	leader.h.State.Target.Position.Z = leader.h.State.Pose.Position.Z
+	leader.h.State.Target.Heading = leader.h.State.Pose.Heading
+	for i := 0; i < 60*15; i++ {
+		tick()
+	}
+
+	// It catches up, to where it would be in the leader's world space if it
+	// were in the slot. It started in the slot, at the origin of its own.
+	slot := leader.h.State.Pose.Add(math3d.Pose{Position: math3d.Vector3{Z: -cfg.Follow.Behind}})
+	p := follower.h.State.Pose
+	assert.False(t, follower.h.State.Follow.Lost)
+	assert.InDelta(t, 0, follower.h.State.Follow.Distance, 60)
+	assert.InDelta(t, slot.Position.X, p.Position.X, 60)
+	assert.InDelta(t, slot.Position.Z, p.Position.Z-cfg.Follow.Behind, 60)
+	assert.InDelta(t, 0, math3d.AngleDiff(slot.Heading, p.Heading), 10)
+}
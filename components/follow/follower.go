@@ -0,0 +1,268 @@
+package follow
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/adammck/hexapod"
+	"github.com/adammck/hexapod/config"
+	"github.com/adammck/hexapod/math3d"
+	"github.com/adammck/hexapod/units"
+	"github.com/adammck/hexapod/utils"
+)
+
+const (
+
+	// The furthest (in mm, and degrees) which the target is set from the pose,
+	// like the navigator, so the hex walks at about the same pace as at full
+	// stick.
+	maxMove = 100
+	maxTurn = 15
+
+	// Further than this (in mm) from the chase, the follower faces the way
+	// it's walking (or away, if that's behind it), since the legs are much
+	// slower sideways. Closer, it faces the way the leader is.
+	faceDistance = 50
+)
+
+// Follower is a component which walks to the slot behind the leader, by setting
+// the target towards it every tick. It has the same priority as the navigator,
+// so must come after it (and the controller); whenever the sticks are in use,
+// the navigator has somewhere to go, or the hex is halted, it leaves the target
+// alone until they're released.
+//
+// The slot is where the leader says it is, plus the offset in the config,
+// moved into this hex's world space. There's no shared map, so that's done by
+// assuming that this hex is in its slot when it first hears from the leader.
+//
+// The slot is chased rather than walked to directly: the chase moves with the
+// slot, and closes in on it no faster than the max closing speed, so the
+// follower doesn't make a dash for it after falling behind (or being moved).
+// If the leader isn't heard from for too long, the follower halts straight
+// away, by holding the target at the pose until it is again.
+type Follower struct {
+	cfg  config.Follow
+	addr string
+	conn net.PacketConn
+
+	// The most recent packet from the leader, and whether it's new since the
+	// last tick. Set by the listener.
+	mu     sync.Mutex
+	packet Packet
+	fresh  bool
+
+	// Only touched from the main loop. When the leader was last heard from (in the
+	// tick's time), and the transform from its world space to ours.
+	heard time.Time
+	found bool
+	frame math3d.Pose
+
+	// The chase, and where the slot was on the last tick, so the chase can be
+	// moved with it.
+	chase   math3d.Pose
+	slot    math3d.Pose
+	chasing bool
+	last    time.Time
+}
+
+// NewFollower creates a component which follows the leader in the config, by
+// listening for its pose on the port in the config.
+func NewFollower(cfg config.Follow) *Follower {
+	return newFollowerWithAddr(cfg, fmt.Sprintf(":%d", cfg.Port))
+}
+
+func newFollowerWithAddr(cfg config.Follow, addr string) *Follower {
+	return &Follower{
+		cfg:  cfg,
+		addr: addr,
+	}
+}
+
+// Writes returns hexapod.Commander, since the follower sets the target.
+func (f *Follower) Writes() hexapod.Role {
+	return hexapod.Commander
+}
+
+// Source implements hexapod.Autonomous.
+func (f *Follower) Source() string {
+	return "follow"
+}
+
+// Cancel forgets where the slot is, when the countdown before following is
+// cancelled, so it starts over from wherever the hex is when it next hears from
+// the leader, as if it had just booted. It implements hexapod.Autonomous.
+func (f *Follower) Cancel() {
+	f.found = false
+	f.chasing = false
+}
+
+// Boot opens the socket, and listens for packets in the background.
+func (f *Follower) Boot() error {
+	conn, err := net.ListenPacket("udp4", f.addr)
+	if err != nil {
+		return err
+	}
+
+	log.Infof("listening for %s on %s", f.cfg.Leader, conn.LocalAddr())
+	f.conn = conn
+	go f.listen()
+	return nil
+}
+
+func (f *Follower) listen() {
+	buf := make([]byte, 1024)
+
+	for {
+		n, _, err := f.conn.ReadFrom(buf)
+		if err != nil {
+			log.Errorf("stopped listening: %s", err)
+			return
+		}
+
+		// Ignore anything which isn't a (compatible) packet from the leader.
+		// Every hex broadcasts to the same port.
+		p, err := Decode(buf[:n])
+		if err != nil || p.ID != f.cfg.Leader {
+			continue
+		}
+
+		f.receive(p)
+	}
+}
+
+// receive records a packet from the leader.
+func (f *Follower) receive(p Packet) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.packet = p
+	f.fresh = true
+}
+
+func (f *Follower) Tick(now time.Time, state *hexapod.State) error {
+	f.mu.Lock()
+	p, fresh := f.packet, f.fresh
+	f.fresh = false
+	f.mu.Unlock()
+
+	dt := now.Sub(f.last)
+	if f.last.IsZero() {
+		dt = 0
+	}
+	f.last = now
+
+	if fresh {
+		f.heard = now
+	}
+
+	// Nothing to follow yet.
+	if f.heard.IsZero() {
+		return nil
+	}
+
+	slot := flat(p.Pose).Add(math3d.Pose{Position: math3d.Vector3{X: f.cfg.Right, Z: -f.cfg.Behind}})
+	if !f.found {
+		f.found = true
+		f.frame = flat(state.Pose).Add(slot.Inverse())
+		log.Infof("following %s, from %s", p.Name, flat(state.Pose))
+		state.Publish(hexapod.EventLeaderFound, hexapod.Info, p.Name)
+	}
+
+	slot = f.frame.Add(slot)
+	slot.Heading = math3d.WrapDegrees(slot.Heading)
+
+	d := slot.Position.Subtract(state.Pose.Position)
+	d.Y = 0
+
+	lost := now.Sub(f.heard) > f.cfg.Timeout.Duration
+	switch {
+	case lost && !state.Follow.Lost:
+		log.Warnf("lost %s, after %s without a pose, so halting", p.Name, now.Sub(f.heard))
+		state.Publish(hexapod.EventLeaderLost, hexapod.Warning, p.Name)
+	case !lost && state.Follow.Lost:
+		log.Infof("found %s again, %s from the slot", p.Name, units.MM(d.Magnitude()))
+	}
+
+	state.Follow = hexapod.Follow{
+		Active:   true,
+		Paused:   paused(state),
+		Lost:     lost,
+		Leader:   p.Name,
+		Distance: d.Magnitude(),
+	}
+
+	if state.Follow.Paused {
+		f.chasing = false
+		return nil
+	}
+
+	if lost {
+		f.chasing = false
+		state.Target.Position.X = state.Pose.Position.X
+		state.Target.Position.Z = state.Pose.Position.Z
+		state.Target.Heading = state.Pose.Heading
+		return nil
+	}
+
+	f.move(slot, state.Pose, dt)
+
+	// Only replace the walking part of the target, like the navigator. It's no
+	// faster than the derate allows.
+	d = f.chase.Position.Subtract(state.Pose.Position)
+	d.Y = 0
+	d = d.ClampLength(maxMove * state.Derate.Scale())
+
+	angle := math3d.AngleDiff(f.chase.Heading, state.Pose.Heading)
+	if d.Magnitude() > faceDistance {
+		angle = math3d.AngleDiff(utils.Deg(math.Atan2(d.X, d.Z)), state.Pose.Heading)
+		if math.Abs(angle) > 90 {
+			angle = math3d.WrapDegrees(angle + 180)
+		}
+	}
+
+	state.Target.Position.X = state.Pose.Position.X + d.X
+	state.Target.Position.Z = state.Pose.Position.Z + d.Z
+	state.Target.Heading = math3d.WrapDegrees(state.Pose.Heading + math3d.ClampDegrees(angle, -maxTurn, maxTurn))
+
+	return nil
+}
+
+// move moves the chase along with the slot (which is at the given pose in the
+// world space) since the last tick, and closes it in on the slot by however
+// far it can in the given time. The chase starts at the given pose of the hex.
+func (f *Follower) move(slot, pose math3d.Pose, dt time.Duration) {
+	if !f.chasing {
+		f.chasing = true
+		f.chase = flat(pose)
+		f.slot = slot
+	}
+
+	delta := slot.Position.Subtract(f.slot.Position)
+	f.slot = slot
+
+	gap := slot.Position.Subtract(*f.chase.Position.Add(delta))
+	gap.Y = 0
+	gap = gap.ClampLength(f.cfg.MaxClosingSpeed * dt.Seconds())
+
+	f.chase.Position = *f.chase.Position.Add(delta).Add(gap)
+	f.chase.Heading = slot.Heading
+}
+
+// flat returns the pose without the clearance or tilt, since the slot is on the
+// ground however either hex is oriented.
+func flat(p math3d.Pose) math3d.Pose {
+	return math3d.Pose{
+		Position: math3d.Vector3{X: p.Position.X, Z: p.Position.Z},
+		Heading:  p.Heading,
+	}
+}
+
+// paused returns true if something else should be in control of the target,
+// including the navigator while it has somewhere to go.
+func paused(state *hexapod.State) bool {
+	nav := state.Navigation.Active && !state.Navigation.Paused
+	return nav || state.Halt || state.Shutdown || state.Calibrating || state.SelfTesting || state.Fallen || state.Cooling || state.Input.Walking()
+}
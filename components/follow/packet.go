@@ -0,0 +1,45 @@
+// Package follow lets one hex follow another around, at a fixed offset. The
+// leader broadcasts its pose estimate over UDP, and the follower walks to the
+// slot behind it. See config.Follow.
+package follow
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/adammck/hexapod/math3d"
+)
+
+// PacketVersion is incremented whenever the packet changes in a way which might
+// break a follower. Packets with a different version are ignored.
+const PacketVersion = 1
+
+// Packet is broadcast by the leader every interval, with its pose estimate.
+type Packet struct {
+	Version int         `json:"version"`
+	ID      string      `json:"id"`
+	Name    string      `json:"name"`
+	Pose    math3d.Pose `json:"pose"`
+}
+
+// Encode returns the wire format of the packet.
+func (p Packet) Encode() ([]byte, error) {
+	p.Version = PacketVersion
+	return json.Marshal(p)
+}
+
+// Decode parses a packet, and returns an error if it's garbage or from an
+// incompatible version.
+func Decode(b []byte) (Packet, error) {
+	var p Packet
+	err := json.Unmarshal(b, &p)
+	if err != nil {
+		return Packet{}, err
+	}
+
+	if p.Version != PacketVersion {
+		return Packet{}, fmt.Errorf("unsupported packet version: %d", p.Version)
+	}
+
+	return p, nil
+}
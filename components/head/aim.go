@@ -0,0 +1,100 @@
+package head
+
+import (
+	"math"
+	"time"
+
+	"github.com/adammck/hexapod/config"
+	"github.com/adammck/hexapod/math3d"
+	"github.com/adammck/hexapod/utils"
+)
+
+const (
+
+	// How close (in degrees) to directly behind the head a point must be for
+	// the head to stay on whichever side it's already on, rather than sweeping
+	// across to the other stop as the point crosses behind it.
+	wrapMargin = 30
+
+	// How close (in mm) to directly above or below the head a point must be
+	// for the pan to be left alone, since it's meaningless there.
+	overhead = 1
+)
+
+// aim turns the point to look at into pan and tilt angles, within the limits
+// of the head, and moves towards them no faster than the max speed. It's
+// separate from the head so it can be tested without any servos.
+type aim struct {
+	cfg config.Head
+
+	// The angles which were most recently sent to the servos, and when.
+	pan  float64
+	tilt float64
+	last time.Time
+}
+
+func newAim(cfg config.Head) aim {
+	return aim{
+		cfg:  cfg,
+		pan:  cfg.NeutralPan,
+		tilt: cfg.NeutralTilt,
+	}
+}
+
+// update moves towards the given point, in the head space, or to neutral if
+// it's nil. It returns true if the point was outside the limits, and so was
+// clamped to the nearest edge.
+func (a *aim) update(now time.Time, v *math3d.Vector3) bool {
+	pan, tilt := a.cfg.NeutralPan, a.cfg.NeutralTilt
+	clamped := false
+
+	if v != nil {
+		pan, tilt = a.angles(*v)
+
+		cp := clamp(pan, a.cfg.MinPan, a.cfg.MaxPan)
+		ct := clamp(tilt, a.cfg.MinTilt, a.cfg.MaxTilt)
+		clamped = cp != pan || ct != tilt
+		pan, tilt = cp, ct
+	}
+
+	// Nothing moves on the first tick, since there's no telling how long it's
+	// been. The head starts at neutral.
+	var step float64
+	if !a.last.IsZero() {
+		step = a.cfg.MaxSpeed * now.Sub(a.last).Seconds()
+	}
+	a.last = now
+
+	a.pan += clamp(pan-a.pan, -step, step)
+	a.tilt += clamp(tilt-a.tilt, -step, step)
+	return clamped
+}
+
+// angles returns the pan and tilt (in degrees) to point at the given point,
+// before clamping.
+func (a *aim) angles(v math3d.Vector3) (float64, float64) {
+	flat := math.Hypot(v.X, v.Z)
+	tilt := utils.Deg(math.Atan2(v.Y, flat))
+	if flat < overhead {
+		return a.pan, tilt
+	}
+
+	// Points behind are at the same stop until they're nearly behind, and
+	// then at whichever the head is nearest, so it doesn't flap from one to
+	// the other when they're directly behind.
+	pan := utils.Deg(math.Atan2(v.X, v.Z))
+	if math.Abs(pan) > 180-wrapMargin {
+		mid := (a.cfg.MinPan + a.cfg.MaxPan) / 2
+		if a.pan < mid {
+			pan = -math.Abs(pan)
+		} else {
+			pan = math.Abs(pan)
+		}
+	}
+
+	return pan, tilt
+}
+
+func clamp(v, min, max float64) float64 {
+	return math.Max(math.Min(v, max), min)
+}
@@ -0,0 +1,120 @@
+package head
+
+import (
+	"testing"
+	"time"
+
+	"github.com/adammck/hexapod/config"
+	"github.com/adammck/hexapod/math3d"
+	"github.com/stretchr/testify/assert"
+)
+
+const tick = time.Second / 60
+
+// look updates the aim every tick for the given duration, starting at now, and
+// returns whether the last update was clamped.
+func look(a *aim, now *time.Time, v *math3d.Vector3, d time.Duration) bool {
+	var clamped bool
+	for end := now.Add(d); now.Before(end); *now = now.Add(tick) {
+		clamped = a.update(*now, v)
+	}
+
+	return clamped
+}
+
+func newTestAim() *aim {
+	a := newAim(config.Default().Head)
+	return &a
+}
+
+func TestAimIsRateLimited(t *testing.T) {
+	a := newTestAim()
+	now := time.Unix(0, 0)
+
+	// Ahead and to the right, within range. Nothing moves on the first tick,
+	// and then 3 degrees per tick.
+	v := math3d.Vector3{X: 50, Y: 30, Z: 100}
+	assert.False(t, a.update(now, &v))
+	assert.Equal(t, 0.0, a.pan)
+
+	now = now.Add(tick)
+	a.update(now, &v)
+	assert.InDelta(t, 3, a.pan, 0.001)
+	assert.InDelta(t, 3, a.tilt, 0.001)
+
+	// It gets there soon enough.
+	assert.False(t, look(a, &now, &v, time.Second))
+	assert.InDelta(t, 26.6, a.pan, 0.1)
+	assert.InDelta(t, 15, a.tilt, 0.1)
+}
+
+func TestAimBehind(t *testing.T) {
+	a := newTestAim()
+	now := time.Unix(0, 0)
+
+	// Just to the right, to start with.
+	v := math3d.Vector3{X: 10, Z: 100}
+	look(a, &now, &v, time.Second)
+	assert.InDelta(t, 5.7, a.pan, 0.1)
+
+	// Nearly behind, but a little to the left. The head stays to the right,
+	// rather than sweeping across to the left stop.
+	v = math3d.Vector3{X: -10, Z: -100}
+	assert.True(t, look(a, &now, &v, time.Second))
+	assert.Equal(t, 45.0, a.pan)
+
+	// Which it does once the point is well to the left.
+	v = math3d.Vector3{X: -100, Z: -100}
+	assert.True(t, look(a, &now, &v, time.Second))
+	assert.Equal(t, -45.0, a.pan)
+
+	// And stays there when it's nearly behind to the right.
+	v = math3d.Vector3{X: 10, Z: -100}
+	assert.True(t, look(a, &now, &v, time.Second))
+	assert.Equal(t, -45.0, a.pan)
+}
+
+func TestAimOverhead(t *testing.T) {
+	a := newTestAim()
+	now := time.Unix(0, 0)
+
+	v := math3d.Vector3{X: -20, Z: 100}
+	look(a, &now, &v, time.Second)
+	pan := a.pan
+
+	// Directly above, the tilt stops at the limit, and the pan is left
+	// wherever it was.
+	v = math3d.Vector3{Y: 200}
+	assert.True(t, look(a, &now, &v, time.Second))
+	assert.Equal(t, 20.0, a.tilt)
+	assert.Equal(t, pan, a.pan)
+
+	// Likewise below.
+	v = math3d.Vector3{Y: -200}
+	assert.True(t, look(a, &now, &v, time.Second))
+	assert.Equal(t, -10.0, a.tilt)
+	assert.Equal(t, pan, a.pan)
+}
+
+func TestAimReturnsToNeutral(t *testing.T) {
+	cfg := config.Default().Head
+	cfg.NeutralPan = 10
+	cfg.NeutralTilt = -5
+	a := newAim(cfg)
+	now := time.Unix(0, 0)
+
+	v := math3d.Vector3{X: -200, Y: 100, Z: 100}
+	look(&a, &now, &v, time.Second)
+	assert.Equal(t, -45.0, a.pan)
+	assert.Equal(t, 20.0, a.tilt)
+
+	// With nothing to look at, it eases back, at the same speed.
+	assert.False(t, a.update(now, nil))
+	assert.InDelta(t, -42, a.pan, 0.001)
+	assert.InDelta(t, 17, a.tilt, 0.001)
+
+	now = now.Add(tick)
+	look(&a, &now, nil, time.Second)
+	assert.Equal(t, 10.0, a.pan)
+	assert.Equal(t, -5.0, a.tilt)
+}
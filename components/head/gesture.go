@@ -0,0 +1,129 @@
+package head
+
+import (
+	"math"
+	"time"
+
+	"github.com/adammck/hexapod"
+	"github.com/adammck/hexapod/config"
+)
+
+// The number of cycles in each gesture.
+const (
+	nodCycles   = 2
+	shakeCycles = 3
+)
+
+// gestures plays gestures one after the other, by replacing the tracked angles
+// (see aim) with an oscillation around wherever the head was pointing when the
+// gesture started, and then blending back to them. It's separate from the head
+// so it can be tested without any servos.
+type gestures struct {
+	cfg   config.Head
+	queue []hexapod.Gesture
+
+	// The gesture in progress (which is none between gestures), when it
+	// started, and the angles when it started.
+	current hexapod.Gesture
+	start   time.Time
+	pan     float64
+	tilt    float64
+
+	// When the current gesture stops and starts blending back. It's usually
+	// the end of the last cycle, but is sooner if cancelled.
+	until time.Time
+}
+
+func newGestures(cfg config.Head) gestures {
+	return gestures{cfg: cfg}
+}
+
+// add queues the given gesture, to start once any before it have finished.
+func (g *gestures) add(gesture hexapod.Gesture) {
+	g.queue = append(g.queue, gesture)
+}
+
+// cancel drops the queue, and stops the current gesture (if any) where it is,
+// and starts blending back. Unlike stopping dead, that doesn't snap the head.
+func (g *gestures) cancel(now time.Time) {
+	g.queue = nil
+
+	if g.current != hexapod.GestureNone && now.Before(g.until) {
+		log.Infof("cancelling %s", g.current)
+		g.pan, g.tilt = g.offset(now)
+		g.until = now
+	}
+}
+
+// update returns the angles to point the head at, given the tracked angles,
+// and starts the next gesture if there's nothing in progress.
+func (g *gestures) update(now time.Time, pan, tilt float64) (float64, float64) {
+	if g.current != hexapod.GestureNone && !now.Before(g.until.Add(g.cfg.GestureBlend.Duration)) {
+		g.current = hexapod.GestureNone
+	}
+
+	if g.current == hexapod.GestureNone {
+		if len(g.queue) == 0 {
+			return pan, tilt
+		}
+
+		g.current, g.queue = g.queue[0], g.queue[1:]
+		g.start, g.pan, g.tilt = now, pan, tilt
+		g.until = now.Add(g.duration())
+		log.Infof("%s", g.current)
+	}
+
+	if now.Before(g.until) {
+		p, t := g.offset(now)
+		return clamp(p, g.cfg.MinPan, g.cfg.MaxPan), clamp(t, g.cfg.MinTilt, g.cfg.MaxTilt)
+	}
+
+	// Blend back, easing in and out, from where the gesture stopped.
+	f := 1.0
+	if b := g.cfg.GestureBlend.Duration; b > 0 {
+		f = smoothstep(float64(now.Sub(g.until)) / float64(b))
+	}
+
+	p := g.pan + (pan-g.pan)*f
+	t := g.tilt + (tilt-g.tilt)*f
+	return clamp(p, g.cfg.MinPan, g.cfg.MaxPan), clamp(t, g.cfg.MinTilt, g.cfg.MaxTilt)
+}
+
+// offset returns the angles of the current gesture at the given time, before
+// clamping. A nod starts downwards, and a shake to the right.
+func (g *gestures) offset(now time.Time) (float64, float64) {
+	switch g.current {
+	case hexapod.GestureNod:
+		return g.pan, g.tilt - g.cfg.NodAmplitude*g.wave(now, g.cfg.NodPeriod.Duration)
+
+	case hexapod.GestureShake:
+		return g.pan + g.cfg.ShakeAmplitude*g.wave(now, g.cfg.ShakePeriod.Duration), g.tilt
+	}
+
+	return g.pan, g.tilt
+}
+
+// wave returns a sine wave with the given period, starting at zero when the
+// gesture started.
+func (g *gestures) wave(now time.Time, period time.Duration) float64 {
+	return math.Sin(2 * math.Pi * float64(now.Sub(g.start)) / float64(period))
+}
+
+// duration returns how long the current gesture lasts, not including the blend
+// back.
+func (g *gestures) duration() time.Duration {
+	switch g.current {
+	case hexapod.GestureNod:
+		return nodCycles * g.cfg.NodPeriod.Duration
+	case hexapod.GestureShake:
+		return shakeCycles * g.cfg.ShakePeriod.Duration
+	}
+
+	return 0
+}
+
+// smoothstep eases from zero to one as x goes from zero to one.
+func smoothstep(x float64) float64 {
+	x = clamp(x, 0, 1)
+	return x * x * (3 - 2*x)
+}
@@ -0,0 +1,88 @@
+package head
+
+import (
+	"testing"
+	"time"
+
+	"github.com/adammck/hexapod"
+	"github.com/adammck/hexapod/config"
+	"github.com/stretchr/testify/assert"
+)
+
+// at returns the angles from the gestures at the given offset from the start
+// of the test, given the tracked angles.
+func at(g *gestures, d time.Duration, pan, tilt float64) []float64 {
+	p, t := g.update(time.Unix(0, 0).Add(d), pan, tilt)
+	return []float64{p, t}
+}
+
+func TestNod(t *testing.T) {
+	g := newGestures(config.Default().Head)
+	g.add(hexapod.GestureNod)
+
+	// Two cycles of 500ms, down first, around wherever it was tracking when
+	// it started. The tracking is ignored until the gesture finishes.
+	assert.Equal(t, []float64{5, 2}, at(&g, 0, 5, 2))
+	assert.Equal(t, hexapod.GestureNod, g.current)
+	assert.InDeltaSlice(t, []float64{5, -8}, at(&g, 125*time.Millisecond, 6, 2), 0.001)
+	assert.InDeltaSlice(t, []float64{5, 2}, at(&g, 250*time.Millisecond, 7, 2), 0.001)
+	assert.InDeltaSlice(t, []float64{5, 12}, at(&g, 375*time.Millisecond, 8, 2), 0.001)
+	assert.InDeltaSlice(t, []float64{5, -8}, at(&g, 625*time.Millisecond, 9, 2), 0.001)
+
+	// Then it eases back to the tracking, over 300ms.
+	assert.InDeltaSlice(t, []float64{5, 2}, at(&g, time.Second, 20, 0), 0.001)
+	assert.InDeltaSlice(t, []float64{12.5, 1}, at(&g, 1150*time.Millisecond, 20, 0), 0.001)
+	assert.Equal(t, hexapod.GestureNod, g.current)
+
+	assert.Equal(t, []float64{20, 0}, at(&g, 1300*time.Millisecond, 20, 0))
+	assert.Equal(t, hexapod.GestureNone, g.current)
+}
+
+func TestShake(t *testing.T) {
+	g := newGestures(config.Default().Head)
+	g.add(hexapod.GestureShake)
+
+	// Three cycles of 400ms, right first, clamped to the limits.
+	at(&g, 0, 40, 0)
+	assert.InDeltaSlice(t, []float64{45, 0}, at(&g, 100*time.Millisecond, 40, 0), 0.001)
+	assert.InDeltaSlice(t, []float64{25, 0}, at(&g, 300*time.Millisecond, 40, 0), 0.001)
+	assert.InDeltaSlice(t, []float64{25, 0}, at(&g, 1100*time.Millisecond, 40, 0), 0.001)
+	assert.InDeltaSlice(t, []float64{40, 0}, at(&g, 1200*time.Millisecond, 40, 0), 0.001)
+	assert.Equal(t, hexapod.GestureShake, g.current)
+}
+
+func TestGesturesQueue(t *testing.T) {
+	g := newGestures(config.Default().Head)
+	g.add(hexapod.GestureNod)
+	at(&g, 0, 0, 0)
+
+	// A shake requested during the nod waits until it has finished, including
+	// blending back.
+	g.add(hexapod.GestureShake)
+	at(&g, 600*time.Millisecond, 0, 0)
+	assert.Equal(t, hexapod.GestureNod, g.current)
+	at(&g, 1200*time.Millisecond, 0, 0)
+	assert.Equal(t, hexapod.GestureNod, g.current)
+
+	assert.Equal(t, []float64{0, 0}, at(&g, 1300*time.Millisecond, 0, 0))
+	assert.Equal(t, hexapod.GestureShake, g.current)
+	assert.InDeltaSlice(t, []float64{15, 0}, at(&g, 1400*time.Millisecond, 0, 0), 0.001)
+}
+
+func TestCancelGestures(t *testing.T) {
+	g := newGestures(config.Default().Head)
+	g.add(hexapod.GestureNod)
+	g.add(hexapod.GestureShake)
+	at(&g, 0, 5, 2)
+	assert.InDeltaSlice(t, []float64{5, -8}, at(&g, 125*time.Millisecond, 5, 2), 0.001)
+
+	// It blends back from wherever it was, rather than snapping.
+	g.cancel(time.Unix(0, 0).Add(125 * time.Millisecond))
+	assert.InDeltaSlice(t, []float64{5, -8}, at(&g, 125*time.Millisecond, 5, 2), 0.001)
+	assert.InDeltaSlice(t, []float64{5, -3}, at(&g, 275*time.Millisecond, 5, 2), 0.001)
+
+	// And the shake is dropped.
+	assert.Equal(t, []float64{5, 2}, at(&g, 425*time.Millisecond, 5, 2))
+	assert.Equal(t, hexapod.GestureNone, g.current)
+	assert.Empty(t, g.queue)
+}
@@ -1,50 +1,83 @@
+// Package head points the pan/tilt head, and the camera on it, at the focal
+// point.
 package head
 
 import (
 	"fmt"
 	"math"
+	"sync"
 	"time"
 
-	"github.com/Sirupsen/logrus"
 	"github.com/adammck/dynamixel/servo"
 	"github.com/adammck/hexapod"
+	"github.com/adammck/hexapod/config"
 	"github.com/adammck/hexapod/math3d"
 	"github.com/adammck/hexapod/servos"
+	"github.com/adammck/hexapod/units"
 	"github.com/adammck/hexapod/utils"
 )
 
-var log = logrus.WithFields(logrus.Fields{
-	"pkg": "head",
-})
+var log = hexapod.NewLog("head")
 
 const (
 	moveSpeed   = 1023
 	torqueLimit = 1023
+
+	// How far (in raw units, out of 127) any stick must be moved from neutral
+	// to abort a look path.
+	deadzone = 10
 )
 
-type Config struct {
-	UpLimit    float64
-	DownLimit  float64
-	LeftLimit  float64
-	RightLimit float64
-}
+// Head is a component which points the head at State.LookAt, within the limits
+// and max speed in the config, or back to neutral when there's nothing to look
+// at. It writes where it's pointing to State.Head.
+//
+// It also plays the gestures requested via State.Gesture, in order, which take
+// over from State.LookAt until they've finished. Halting or shutting down
+// cancels them.
+//
+// It also plays look paths (see Look), which take over from State.LookAt, and
+// so from the sticks, until they've finished. Moving any stick, halting, or
+// shutting down aborts them, and the head sweeps back to State.LookAt.
+//
+// Its torque is reduced while the legs are over their torque budget, to
+// whatever State.Budget says.
+type Head struct {
+	o   math3d.Pose
+	h   *servo.Servo
+	v   *servo.Servo
+	aim aim
+	g   gestures
+	p   path
+
+	// The look path which was requested via Look (or whether StopLook was
+	// called) since the last tick, and the pose during the last tick, which it
+	// was validated against. These are set from other goroutines, so locked.
+	mu      sync.Mutex
+	pending LookPath
+	stop    bool
+	pose    math3d.Pose
 
-var defaultConfig = &Config{
-	UpLimit:    10,
-	DownLimit:  -20,
-	LeftLimit:  -45,
-	RightLimit: 45,
+	// The torque limit which was most recently written to the servos.
+	torque int
 }
 
-type Head struct {
-	o math3d.Pose
-	h *servo.Servo
-	v *servo.Servo
-	c *Config
+// New creates a head component, with its origin at the given pose relative to
+// the hexapod, and the given pan (h) and tilt (v) servos.
+func New(o math3d.Pose, h, v *servo.Servo, cfg config.Head) *Head {
+	return &Head{
+		o:      o,
+		h:      h,
+		v:      v,
+		aim:    newAim(cfg),
+		g:      newGestures(cfg),
+		torque: torqueLimit,
+	}
 }
 
-func New(o math3d.Pose, h, v *servo.Servo) *Head {
-	return &Head{o, h, v, defaultConfig}
+// Writes returns hexapod.Estimator, since the head sets State.Head.
+func (h *Head) Writes() hexapod.Role {
+	return hexapod.Estimator
 }
 
 func (h *Head) Servos() []*servo.Servo {
@@ -71,30 +104,174 @@ func (h *Head) Boot() error {
 	return nil
 }
 
+// local transforms the given point (in the world space) into the hexapod space,
+// then into the head space, such that the point at the origin of the head is
+// [0, 0, 0]. This is redone every tick from the whole pose, including the pitch,
+// bank, and height which bob about while walking, so the gaze stays on the
+// point while the body moves underneath it.
+func (h *Head) local(pose math3d.Pose, p math3d.Vector3) math3d.Vector3 {
+	return p.MultiplyByMatrix44(pose.ToLocal()).MultiplyByMatrix44(h.o.ToLocal())
+}
+
+// world returns the point at the given distance from the head, in the direction
+// which it points at the given angles, in the world space. It's the opposite of
+// local, for a point which the head is looking at.
+func (h *Head) world(pose math3d.Pose, pan, tilt, dist float64) math3d.Vector3 {
+	p, t := utils.Rad(pan), utils.Rad(tilt)
+	d := math3d.Vector3{X: math.Sin(p) * math.Cos(t), Y: math.Sin(t), Z: math.Cos(p) * math.Cos(t)}
+	return d.MultiplyByScalar(dist).MultiplyByMatrix44(h.o.ToWorld()).MultiplyByMatrix44(pose.ToWorld())
+}
+
+// Look starts sweeping the head through the given path on the next tick, from
+// wherever it's pointing, instead of whatever it was told to look at. Any path
+// which was already being played is abandoned. Unlike most methods here, this
+// is safe to call from any goroutine.
+//
+// The whole path is rejected, with an error saying why, if any of its points
+// are beyond the limits of the head. Since the points are in the world space,
+// that's from the pose during the last tick; if the hex moves while the path
+// is being played, the head is clamped to its limits as usual.
+func (h *Head) Look(p LookPath) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	err := p.validate(h.aim.cfg, func(v math3d.Vector3) math3d.Vector3 {
+		return h.local(h.pose, v)
+	})
+	if err != nil {
+		return err
+	}
+
+	h.pending = p
+	h.stop = false
+	return nil
+}
+
+// StopLook abandons the look path (if any) on the next tick. Like Look, this is
+// safe to call from any goroutine.
+func (h *Head) StopLook() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.pending = nil
+	h.stop = true
+}
+
 func (h *Head) Tick(now time.Time, state *hexapod.State) error {
+	if state.Gesture != hexapod.GestureNone {
+		h.g.add(state.Gesture)
+		state.Gesture = hexapod.GestureNone
+	}
+
+	if state.Halt || state.Shutdown {
+		h.g.cancel(now)
+	}
+
+	at := h.focus(now, state)
+	var v *math3d.Vector3
+	if at != nil {
+		p := h.local(state.Pose, *at)
+		v = &p
+	}
+
+	clamped := h.aim.update(now, v)
+	if clamped {
+		log.RateLimited("clamped", 10*time.Second).Infof("look at %v is out of range, so clamped to pan=%s, tilt=%s", *at, units.Deg(h.aim.pan), units.Deg(h.aim.tilt))
+	}
 
-	// Nothing to do if there is no target.
-	if state.LookAt == nil {
-		return nil
+	pan, tilt := h.g.update(now, h.aim.pan, h.aim.tilt)
+	state.Head = hexapod.Head{
+		Pan:     pan,
+		Tilt:    tilt,
+		Clamped: clamped,
+		Gesture: h.g.current,
+		Path:    h.p.active(),
 	}
 
-	// Transform the lookat vector into the hexapod space, then into the head
-	// space, such that the point at the origin of the head is [0, 0, 0].
-	v := state.LookAt.MultiplyByMatrix44(state.Pose.ToLocal()).MultiplyByMatrix44(h.o.ToLocal())
+	torque := torqueLimit
+	if state.Budget.HeadTorque > 0 {
+		torque = state.Budget.HeadTorque
+	}
 
-	// Transform the vector (from the origin to the dest) into horizontal (X)
-	// and vertical (Y) angles. There is no Z between two vectors.
-	x := 0 - utils.Deg(math.Atan(v.X/v.Z))
-	y := 0 - utils.Deg(math.Atan(v.Y/v.Z))
+	if torque != h.torque {
+		for _, s := range h.Servos() {
+			err := s.SetTorqueLimit(torque)
+			if err != nil {
+				return fmt.Errorf("%s (while setting torque limit)", err)
+			}
+		}
 
-	// Constrain angles to avoid mechanical damage.
-	// TODO: Encapsulate this in a Servo object, or use the limit registers.
-	x = math.Max(math.Min(x, h.c.RightLimit), h.c.LeftLimit)
-	y = math.Max(math.Min(y, h.c.UpLimit), h.c.DownLimit)
+		h.torque = torque
+	}
 
-	// Update servos every tick.
-	// TODO: Maybe only update if the x/y has changed.
-	servos.RegMoveTo(h.h, x)
-	servos.RegMoveTo(h.v, y)
+	// The servos turn the other way: to the left and down as their angles
+	// increase.
+	// TODO: Maybe only update if the angles have changed.
+	servos.RegMoveTo(h.h, -pan)
+	servos.RegMoveTo(h.v, -tilt)
 	return nil
 }
+
+// focus returns the point to look at, in the world space, which is the look
+// path's while one is being played, or State.LookAt, which is nil if there's
+// nothing to look at.
+func (h *Head) focus(now time.Time, state *hexapod.State) *math3d.Vector3 {
+	h.updatePath(now, state)
+	if p, ok := h.p.update(now); ok {
+		return &p
+	}
+
+	return state.LookAt
+}
+
+// updatePath starts the look path which was requested via Look, if any, from
+// wherever the head is pointing, or aborts the one in progress if it was told
+// to stop, or something else has taken over.
+func (h *Head) updatePath(now time.Time, state *hexapod.State) {
+	h.mu.Lock()
+	pending, stop := h.pending, h.stop
+	h.pending, h.stop = nil, false
+	h.pose = state.Pose
+	h.mu.Unlock()
+
+	if stop && h.p.active() {
+		log.Info("stopped look path")
+		h.p.stop()
+	}
+
+	if pending != nil {
+		dist := h.local(state.Pose, pending[0].At).Magnitude()
+		h.p.begin(now, h.world(state.Pose, h.aim.pan, h.aim.tilt, dist), pending)
+		log.Infof("playing look path through %d points", len(pending))
+	}
+
+	if !h.p.active() {
+		return
+	}
+
+	var why string
+	switch {
+	case state.Shutdown:
+		why = "shutting down"
+	case state.Halt:
+		why = "halted"
+	case sticks(state.Input):
+		why = "a stick was moved"
+	}
+
+	if why != "" {
+		log.Infof("aborted look path, because %s", why)
+		h.p.stop()
+	}
+}
+
+// sticks returns true if either stick is being used.
+func sticks(in hexapod.Input) bool {
+	for _, v := range []int{in.LeftX, in.LeftY, in.RightX, in.RightY} {
+		if v > deadzone || v < -deadzone {
+			return true
+		}
+	}
+
+	return false
+}
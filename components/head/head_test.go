@@ -0,0 +1,63 @@
+package head
+
+import (
+	"math"
+	"testing"
+
+	"github.com/adammck/hexapod/math3d"
+	"github.com/adammck/hexapod/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+// The mount of the head on the real hex. See main.
+var mount = math3d.Pose{Position: math3d.Vector3{X: 0, Y: 43.0, Z: 70}}
+
+// gaze returns the position of the head in the world space, and the direction
+// (as a unit vector) which it's looking in, given the pose of the body and the
+// pan and tilt.
+func gaze(h *Head, pose math3d.Pose, pan, tilt float64) (math3d.Vector3, math3d.Vector3) {
+	p, t := utils.Rad(pan), utils.Rad(tilt)
+	d := math3d.Vector3{X: math.Sin(p) * math.Cos(t), Y: math.Sin(t), Z: math.Cos(p) * math.Cos(t)}
+
+	origin := math3d.ZeroVector3.MultiplyByMatrix44(h.o.ToWorld()).MultiplyByMatrix44(pose.ToWorld())
+	d = d.TransformDirection(h.o.ToWorld()).TransformDirection(pose.ToWorld())
+	return origin, d.Unit()
+}
+
+// angle returns the angle (in degrees) between the two unit vectors.
+func angle(a, b math3d.Vector3) float64 {
+	return utils.Deg(math.Acos(math.Min(1, a.Dot(b))))
+}
+
+func TestGazeStaysOnFocalPoint(t *testing.T) {
+	h := &Head{o: mount}
+	a := newTestAim()
+	focal := math3d.Vector3{X: 300, Y: 117.5, Z: 400}
+
+	level := math3d.Pose{Position: math3d.Vector3{X: 100, Y: 40, Z: -50}, Heading: 30}
+	pan, tilt := a.angles(h.local(level, focal))
+	_, want := gaze(h, level, pan, tilt)
+
+	// Sweep the body through the sort of leaning and bobbing which happens
+	// while walking, with the focal point fixed.
+	for _, pitch := range []float64{-8, -3, 0, 4, 8} {
+		for _, bank := range []float64{-8, 0, 5} {
+			for _, y := range []float64{30, 40, 48} {
+				pose := level
+				pose.Position.Y = y
+				pose.Pitch = pitch
+				pose.Bank = bank
+
+				pan, tilt := a.angles(h.local(pose, focal))
+				origin, d := gaze(h, pose, pan, tilt)
+
+				// It's looking straight at the focal point.
+				assert.InDelta(t, 0, angle(d, focal.Subtract(origin).Unit()), 0.01, "pose: %v", pose)
+
+				// Which is about the same direction as when level, give or
+				// take the head itself moving with the body.
+				assert.InDelta(t, 0, angle(d, want), 3, "pose: %v", pose)
+			}
+		}
+	}
+}
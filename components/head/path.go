@@ -0,0 +1,128 @@
+package head
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/adammck/hexapod/config"
+	"github.com/adammck/hexapod/math3d"
+	"github.com/adammck/hexapod/utils"
+)
+
+// easings are the easings which each point of a look path can use, by name.
+// The default (if it's empty) is in-out, which stops at every point.
+var easings = map[string]math3d.Easing{
+	"":       math3d.EaseInOutCubic,
+	"in-out": math3d.EaseInOutCubic,
+	"in":     math3d.EaseInCubic,
+	"out":    math3d.EaseOutCubic,
+	"linear": math3d.Linear,
+}
+
+// LookPoint is a point (in the world space) to look at, how long it takes to
+// sweep there from the previous point (or from wherever the head is pointing,
+// for the first), and the name of the easing to sweep there with: in-out (the
+// default), in, out, or linear. To hold on a point for a while, repeat it.
+type LookPoint struct {
+	At       math3d.Vector3  `json:"at"`
+	Duration config.Duration `json:"duration"`
+	Easing   string          `json:"easing,omitempty"`
+}
+
+// LookPath is an ordered list of points for the head to sweep through, e.g. to
+// pan smoothly across a room while filming. See Head.Look.
+type LookPath []LookPoint
+
+// validate returns an error if the path is empty, or any of its points are
+// invalid, or any of them are beyond the limits of the head, given a function
+// which transforms them into the head space.
+func (p LookPath) validate(cfg config.Head, local func(math3d.Vector3) math3d.Vector3) error {
+	if len(p) == 0 {
+		return fmt.Errorf("the path is empty")
+	}
+
+	for i, pt := range p {
+		if pt.Duration.Duration <= 0 {
+			return fmt.Errorf("point %d has no duration", i)
+		}
+
+		if _, ok := easings[pt.Easing]; !ok {
+			return fmt.Errorf("point %d has no such easing: %s", i, pt.Easing)
+		}
+
+		pan, tilt, ok := reach(cfg, local(pt.At))
+		if !ok {
+			return fmt.Errorf("point %d (%s) is out of range, at pan=%.1f, tilt=%.1f", i, pt.At, pan, tilt)
+		}
+	}
+
+	return nil
+}
+
+// reach returns the pan and tilt (in degrees) to point at the given point, in
+// the head space, and whether that's within the limits. Unlike aim.angles,
+// points behind aren't moved to either stop, since they're out of range.
+func reach(cfg config.Head, v math3d.Vector3) (float64, float64, bool) {
+	flat := math.Hypot(v.X, v.Z)
+	tilt := utils.Deg(math.Atan2(v.Y, flat))
+	ok := tilt >= cfg.MinTilt && tilt <= cfg.MaxTilt
+	if flat < overhead {
+		return 0, tilt, ok
+	}
+
+	pan := utils.Deg(math.Atan2(v.X, v.Z))
+	return pan, tilt, ok && pan >= cfg.MinPan && pan <= cfg.MaxPan
+}
+
+// path plays a look path, by returning the point to look at every tick, in
+// place of State.LookAt. It's separate from the head so it can be tested
+// without any servos.
+type path struct {
+	points LookPath
+
+	// The index of the point being swept to (which is len(points) once the
+	// path has finished), where the sweep started, and when.
+	i     int
+	from  math3d.Vector3
+	start time.Time
+}
+
+// begin starts playing the given path, from the given point.
+func (p *path) begin(now time.Time, from math3d.Vector3, points LookPath) {
+	p.points = points
+	p.i = 0
+	p.from = from
+	p.start = now
+}
+
+// stop abandons the path (if any), wherever it's got to.
+func (p *path) stop() {
+	p.i = len(p.points)
+}
+
+// active returns true while the path is being played.
+func (p *path) active() bool {
+	return p.i < len(p.points)
+}
+
+// update returns the point to look at, in the world space, and false once the
+// path has finished (or if there isn't one). Each sweep starts when the one
+// before should have ended, rather than when it did, so the path takes as long
+// as it says, however the ticks fall.
+func (p *path) update(now time.Time) (math3d.Vector3, bool) {
+	for p.active() {
+		pt := p.points[p.i]
+		d := now.Sub(p.start)
+		if d < pt.Duration.Duration {
+			f := easings[pt.Easing](float64(d) / float64(pt.Duration.Duration))
+			return *p.from.Add(pt.At.Subtract(p.from).MultiplyByScalar(f)), true
+		}
+
+		p.from = pt.At
+		p.start = p.start.Add(pt.Duration.Duration)
+		p.i += 1
+	}
+
+	return math3d.Vector3{}, false
+}
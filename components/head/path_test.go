@@ -0,0 +1,204 @@
+package head
+
+import (
+	"testing"
+	"time"
+
+	"github.com/adammck/hexapod"
+	"github.com/adammck/hexapod/config"
+	"github.com/adammck/hexapod/math3d"
+	"github.com/stretchr/testify/assert"
+)
+
+// A path across the front of the hex, from the left to the right and back to
+// the middle, all within the limits of the default config, from the pose below.
+var (
+	left   = math3d.Vector3{X: -300, Y: 100, Z: 600}
+	right  = math3d.Vector3{X: 300, Y: 150, Z: 600}
+	middle = math3d.Vector3{X: 0, Y: 100, Z: 800}
+	sweep  = LookPath{
+		{At: left, Duration: config.Duration{Duration: time.Second}},
+		{At: right, Duration: config.Duration{Duration: 4 * time.Second}, Easing: "linear"},
+		{At: middle, Duration: config.Duration{Duration: 2 * time.Second}, Easing: "out"},
+	}
+)
+
+var standing = math3d.Pose{Position: math3d.Vector3{Y: 40}}
+
+func newTestHead() *Head {
+	return &Head{o: mount, aim: *newTestAim(), pose: standing}
+}
+
+// play ticks the head (without the servos) for the given duration, starting at
+// now, and returns what it looked at, and its angles, every tick.
+func play(h *Head, now *time.Time, state *hexapod.State, d time.Duration) ([]math3d.Vector3, [][2]float64) {
+	var at []math3d.Vector3
+	var angles [][2]float64
+
+	for end := now.Add(d); now.Before(end); *now = now.Add(tick) {
+		v := h.focus(*now, state)
+		var l *math3d.Vector3
+		if v != nil {
+			at = append(at, *v)
+			p := h.local(state.Pose, *v)
+			l = &p
+		}
+
+		h.aim.update(*now, l)
+		angles = append(angles, [2]float64{h.aim.pan, h.aim.tilt})
+	}
+
+	return at, angles
+}
+
+func TestLookPath(t *testing.T) {
+	h := newTestHead()
+	now := time.Unix(100, 0)
+	state := &hexapod.State{}
+	state.Pose = standing
+
+	// Settle at neutral first.
+	play(h, &now, state, time.Second)
+	assert.NoError(t, h.Look(sweep))
+
+	at, angles := play(h, &now, state, 8*time.Second)
+	assert.False(t, h.p.active())
+
+	// It starts straight ahead, where the head was pointing, and passes
+	// through every point.
+	first := h.local(standing, at[0])
+	assert.InDelta(t, 0, first.X, 5)
+	for _, pt := range sweep {
+		var nearest float64 = 1e9
+		for _, v := range at {
+			if d := v.Distance(pt.At); d < nearest {
+				nearest = d
+			}
+		}
+
+		assert.InDelta(t, 0, nearest, 5, "never reached %s", pt.At)
+	}
+
+	// It never jumps, and nor does the gaze, which keeps up with it.
+	for i := 1; i < len(at); i++ {
+		assert.Less(t, at[i].Distance(at[i-1]), 20.0, "tick %d", i)
+	}
+
+	for i := 1; i < len(at); i++ {
+		assert.InDelta(t, angles[i-1][0], angles[i][0], 2.0, "tick %d", i)
+		assert.InDelta(t, angles[i-1][1], angles[i][1], 1.0, "tick %d", i)
+	}
+
+	for i, v := range at {
+		pan, tilt, ok := reach(h.aim.cfg, h.local(standing, v))
+		assert.True(t, ok)
+		assert.InDelta(t, pan, angles[i][0], 2.0, "tick %d", i)
+		assert.InDelta(t, tilt, angles[i][1], 1.0, "tick %d", i)
+	}
+
+	// It lasts as long as the path says, and then the head goes back to
+	// neutral, since there's nothing else to look at.
+	assert.InDelta(t, 7*time.Second, time.Duration(len(at))*tick, float64(2*tick))
+	assert.Equal(t, [2]float64{0, 0}, angles[len(angles)-1])
+}
+
+func TestLookPathOverridesLookAt(t *testing.T) {
+	h := newTestHead()
+	now := time.Unix(100, 0)
+	state := &hexapod.State{}
+	state.Pose = standing
+	state.LookAt = &math3d.Vector3{X: 0, Y: 0, Z: 1000}
+
+	assert.NoError(t, h.Look(sweep[:1]))
+	at, _ := play(h, &now, state, 2*time.Second)
+
+	// The path ends at the left, and then it's back to the focal point.
+	assert.InDelta(t, 0, at[len(at)/2-1].Distance(left), 1)
+	assert.Equal(t, *state.LookAt, at[len(at)-1])
+}
+
+func TestLookPathAborts(t *testing.T) {
+	for name, set := range map[string]func(*hexapod.State){
+		"left stick":  func(s *hexapod.State) { s.Input.LeftY = -60 },
+		"right stick": func(s *hexapod.State) { s.Input.RightX = 30 },
+		"halt":        func(s *hexapod.State) { s.Halt = true },
+		"shutdown":    func(s *hexapod.State) { s.Shutdown = true },
+	} {
+		t.Run(name, func(t *testing.T) {
+			h := newTestHead()
+			now := time.Unix(100, 0)
+			state := &hexapod.State{}
+			state.Pose = standing
+
+			// A little drift of the sticks doesn't count.
+			state.Input.RightY = 5
+			assert.NoError(t, h.Look(sweep))
+			play(h, &now, state, 2*time.Second)
+			assert.True(t, h.p.active())
+
+			set(state)
+			_, angles := play(h, &now, state, tick)
+			assert.False(t, h.p.active())
+
+			// The head sweeps back to neutral from wherever it was, rather
+			// than snapping.
+			_, back := play(h, &now, state, time.Second)
+			assert.InDelta(t, angles[0][0], back[0][0], 3.0)
+			assert.Equal(t, [2]float64{0, 0}, back[len(back)-1])
+		})
+	}
+}
+
+func TestStopLook(t *testing.T) {
+	h := newTestHead()
+	now := time.Unix(100, 0)
+	state := &hexapod.State{}
+
+	assert.NoError(t, h.Look(sweep))
+	play(h, &now, state, time.Second)
+	assert.True(t, h.p.active())
+
+	h.StopLook()
+	play(h, &now, state, tick)
+	assert.False(t, h.p.active())
+}
+
+func TestLookPathRejected(t *testing.T) {
+	for name, p := range map[string]LookPath{
+		"empty":     {},
+		"behind":    append(LookPath{sweep[0]}, LookPoint{At: math3d.Vector3{Z: -500}, Duration: config.Duration{Duration: time.Second}}),
+		"too high":  append(LookPath{sweep[0]}, LookPoint{At: math3d.Vector3{Y: 1000, Z: 300}, Duration: config.Duration{Duration: time.Second}}),
+		"too left":  append(LookPath{sweep[0]}, LookPoint{At: math3d.Vector3{X: -800, Y: 80, Z: 300}, Duration: config.Duration{Duration: time.Second}}),
+		"overhead":  {{At: math3d.Vector3{Y: 1000, Z: 70}, Duration: config.Duration{Duration: time.Second}}},
+		"instant":   {{At: left}},
+		"no easing": {{At: left, Duration: config.Duration{Duration: time.Second}, Easing: "bouncy"}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			h := newTestHead()
+			now := time.Unix(100, 0)
+			state := &hexapod.State{}
+			state.Pose = standing
+
+			// The whole path is rejected, not just the points out of range,
+			// and whatever was playing carries on.
+			assert.NoError(t, h.Look(sweep))
+			play(h, &now, state, time.Second)
+			assert.Error(t, h.Look(p))
+			assert.Nil(t, h.pending)
+
+			play(h, &now, state, time.Second)
+			assert.True(t, h.p.active())
+			assert.Equal(t, sweep, h.p.points)
+		})
+	}
+
+	// Points are checked from the pose during the last tick, since they're in
+	// the world space, so the same path is out of range after turning around.
+	h := newTestHead()
+	now := time.Unix(100, 0)
+	state := &hexapod.State{}
+	state.Pose = standing
+	state.Pose.Heading = 180
+	play(h, &now, state, tick)
+	assert.Error(t, h.Look(sweep))
+}
@@ -0,0 +1,74 @@
+// Package joints is what the components which take the servos of the legs
+// over from the legs component, to move them one joint at a time (like the
+// self-test, the righting, and the transport mode), have in common.
+package joints
+
+import (
+	"github.com/adammck/hexapod/components/legs"
+)
+
+// DegreesPerUnit is the number of degrees per unit of servo position (AX-12).
+const DegreesPerUnit = 300.0 / 1024.0
+
+// Names are the names of the joints of each leg, from the body outwards.
+var Names = [4]string{"coxa", "femur", "tibia", "tarsus"}
+
+// Servo is the part of a leg joint which those components need. Positions,
+// limits, speeds and torques are in servo units, as they're read and written.
+type Servo interface {
+	Ping() error
+	PresentPosition() (int, error)
+	SetGoalPosition(pos int) error
+	CWAngleLimit() (int, error)
+	CCWAngleLimit() (int, error)
+	MovingSpeed() (int, error)
+	SetMovingSpeed(speed int) error
+	TorqueLimit() (int, error)
+	SetTorqueLimit(val int) error
+	SetLED(state bool) error
+	SetTorqueEnable(state bool) error
+
+	// Position returns the goal position which moves the servo to the given
+	// angle (in degrees), relative to its calibrated zero.
+	Position(angle float64) (int, error)
+}
+
+// Leg is a named set of servos to move together, in the same order as Names,
+// and where it is on the chassis. Legs which are neither at the front nor the
+// rear are in the middle.
+type Leg struct {
+	Name   string
+	Left   bool
+	Front  bool
+	Rear   bool
+	Servos [4]Servo
+}
+
+// FromLegs returns the legs to move, from the legs component.
+func FromLegs(ls [6]*legs.Leg) []Leg {
+	out := make([]Leg, len(ls))
+	for i, l := range ls {
+		out[i] = Leg{
+			Name:   l.Name,
+			Left:   l.Origin.X < 0,
+			Front:  l.Origin.Z > 0,
+			Rear:   l.Origin.Z < 0,
+			Servos: [4]Servo{l.Coxa, l.Femur, l.Tibia, l.Tarsus},
+		}
+	}
+
+	return out
+}
+
+// Count returns the number of joints in the given legs.
+func Count(ls []Leg) int {
+	return len(ls) * len(Names)
+}
+
+// Joint returns the name (like "FL.femur") and servo of the i'th joint of the
+// given legs, counting from the first joint of the first leg.
+func Joint(ls []Leg, i int) (string, Servo) {
+	l := ls[i/len(Names)]
+	j := i % len(Names)
+	return l.Name + "." + Names[j], l.Servos[j]
+}
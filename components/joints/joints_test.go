@@ -0,0 +1,99 @@
+package joints
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// mockServo does nothing, but can be told apart from the others.
+type mockServo struct {
+	Servo
+	id int
+}
+
+func TestJoint(t *testing.T) {
+	var ls []Leg
+	for i, name := range []string{"FL", "FR"} {
+		l := Leg{Name: name}
+		for j := range l.Servos {
+			l.Servos[j] = &mockServo{id: i*10 + j}
+		}
+
+		ls = append(ls, l)
+	}
+
+	assert.Equal(t, 8, Count(ls))
+
+	for i, exp := range []struct {
+		name string
+		id   int
+	}{
+		{"FL.coxa", 0},
+		{"FL.tarsus", 3},
+		{"FR.coxa", 10},
+		{"FR.tibia", 12},
+	} {
+		n := []int{0, 3, 4, 6}[i]
+		name, s := Joint(ls, n)
+		assert.Equal(t, exp.name, name)
+		assert.Equal(t, exp.id, s.(*mockServo).id)
+	}
+}
+
+// slowServo remembers its speed and torque limit, and can fail to set them.
+type slowServo struct {
+	Servo
+	speed, torque int
+	err           error
+}
+
+func (s *slowServo) PresentPosition() (int, error) { return 600, nil }
+func (s *slowServo) CWAngleLimit() (int, error)    { return 100, nil }
+func (s *slowServo) CCWAngleLimit() (int, error)   { return 900, nil }
+func (s *slowServo) MovingSpeed() (int, error)     { return s.speed, nil }
+func (s *slowServo) TorqueLimit() (int, error)     { return s.torque, nil }
+
+func (s *slowServo) SetMovingSpeed(speed int) error {
+	s.speed = speed
+	return s.err
+}
+
+func (s *slowServo) SetTorqueLimit(val int) error {
+	s.torque = val
+	return s.err
+}
+
+func TestSet(t *testing.T) {
+	l := Leg{Name: "FL"}
+	var ss [4]*slowServo
+	for i := range l.Servos {
+		ss[i] = &slowServo{speed: 1023, torque: 800}
+		l.Servos[i] = ss[i]
+	}
+
+	s := NewSet([]Leg{l})
+	assert.NoError(t, s.Reduce(100, 300))
+	assert.Equal(t, []int{600, 600, 600, 600}, s.Goals)
+	assert.Equal(t, 100, s.Clamp(0, 50))
+	assert.Equal(t, 900, s.Clamp(0, 1000))
+	assert.Equal(t, 500, s.Clamp(0, 500))
+	for _, sv := range ss {
+		assert.Equal(t, 100, sv.speed)
+		assert.Equal(t, 300, sv.torque)
+	}
+
+	// Those which can't be restored don't stop the rest, and are only
+	// restored once.
+	ss[1].err = errors.New("timeout")
+	assert.EqualError(t, s.Restore(), "timeout (while restoring FL.femur)")
+	for _, sv := range ss {
+		assert.Equal(t, 1023, sv.speed)
+	}
+
+	ss[1].err = nil
+	ss[0].speed = 5
+	assert.NoError(t, s.Restore())
+	assert.Equal(t, 5, ss[0].speed)
+}
@@ -0,0 +1,119 @@
+package joints
+
+import (
+	"fmt"
+	"strings"
+)
+
+// saved is the settings of a servo which Reduce changes, to be restored
+// afterwards.
+type saved struct {
+	speed  int
+	torque int
+}
+
+// Set is every joint of some legs, counting from the first joint of the first
+// leg, with the goal position of each, as last written, and its limits. Those
+// are read by Reduce, each time the joints are taken over, since the legs could
+// have moved, or been moved, meanwhile.
+type Set struct {
+	legs []Leg
+
+	Goals []int
+	CW    []int
+	CCW   []int
+
+	saved  []saved
+	nsaved int
+}
+
+// NewSet returns a set of the joints of the given legs.
+func NewSet(ls []Leg) *Set {
+	n := Count(ls)
+	return &Set{
+		legs:  ls,
+		Goals: make([]int, n),
+		CW:    make([]int, n),
+		CCW:   make([]int, n),
+		saved: make([]saved, n),
+	}
+}
+
+// Reduce saves the speed and torque limit of every servo, and then sets them to
+// the given (low) values. It reads the present position of each, to sweep from,
+// and its limits too.
+func (s *Set) Reduce(speed, torque int) error {
+	for i := range s.Goals {
+		name, sv := Joint(s.legs, i)
+		var err error
+
+		s.saved[i].speed, err = sv.MovingSpeed()
+		if err == nil {
+			s.saved[i].torque, err = sv.TorqueLimit()
+		}
+		if err == nil {
+			s.Goals[i], err = sv.PresentPosition()
+		}
+		if err == nil {
+			s.CW[i], err = sv.CWAngleLimit()
+		}
+		if err == nil {
+			s.CCW[i], err = sv.CCWAngleLimit()
+		}
+		if err != nil {
+			return fmt.Errorf("%s (while reading %s)", err, name)
+		}
+
+		s.nsaved = i + 1
+	}
+
+	for i := range s.Goals {
+		name, sv := Joint(s.legs, i)
+
+		err := sv.SetMovingSpeed(speed)
+		if err == nil {
+			err = sv.SetTorqueLimit(torque)
+		}
+		if err != nil {
+			return fmt.Errorf("%s (while slowing %s)", err, name)
+		}
+	}
+
+	return nil
+}
+
+// Restore sets the speed and torque limit of every servo back to what they
+// were saved as by Reduce. Servos which weren't saved yet are left as they
+// are. Those which can't be restored are skipped, and returned as one error.
+func (s *Set) Restore() error {
+	var errs []string
+	for i := range s.Goals[:s.nsaved] {
+		name, sv := Joint(s.legs, i)
+
+		err := sv.SetMovingSpeed(s.saved[i].speed)
+		if err == nil {
+			err = sv.SetTorqueLimit(s.saved[i].torque)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s (while restoring %s)", err, name))
+		}
+	}
+
+	s.nsaved = 0
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+
+	return nil
+}
+
+// Clamp returns the given goal position of the i'th joint, within its limits.
+func (s *Set) Clamp(i, pos int) int {
+	if pos < s.CW[i] {
+		return s.CW[i]
+	} else if pos > s.CCW[i] {
+		return s.CCW[i]
+	}
+
+	return pos
+}
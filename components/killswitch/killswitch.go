@@ -0,0 +1,120 @@
+// Package killswitch halts the hex when a physical switch is opened, like the
+// e-stop in the API, and shuts it down if the switch is held open.
+package killswitch
+
+import (
+	"time"
+
+	"github.com/adammck/hexapod"
+	"github.com/adammck/hexapod/config"
+)
+
+var log = hexapod.NewLog("killswitch")
+
+// Pin is a GPIO input which the switch is on.
+type Pin interface {
+
+	// Read returns whether the pin is high. It must return quickly, since it's
+	// called from the main loop.
+	Read() (bool, error)
+
+	Close() error
+}
+
+// KillSwitch is a component which reads the switch every tick, and halts the
+// hex (see State.Halt) while it's open. If it's held open for long enough, the
+// hex shuts down too. Failing to read the pin counts as open.
+//
+// The switch being closed again doesn't resume; that needs the same resume as
+// any other halt, via the API or MQTT. Until the switch is closed, the halt is
+// set again every tick, so resuming does nothing.
+//
+// This has to come before the controller, so the target is held on the same
+// tick as the switch is opened.
+type KillSwitch struct {
+	pin Pin
+	cfg config.KillSwitch
+
+	// The most recent reading (before debouncing), and when it changed to
+	// that. The debounced state follows it once it's been the same for long
+	// enough.
+	raw   bool
+	rawAt time.Time
+
+	// Whether the switch is open (after debouncing), and when it opened.
+	open   bool
+	openAt time.Time
+}
+
+// New creates a kill switch component which reads the given pin.
+func New(pin Pin, cfg config.KillSwitch) *KillSwitch {
+	return &KillSwitch{
+		pin: pin,
+		cfg: cfg,
+	}
+}
+
+// Writes returns hexapod.Commander, since the kill switch halts the hex.
+func (k *KillSwitch) Writes() hexapod.Role {
+	return hexapod.Commander
+}
+
+// Essential returns true, because the kill switch is there for when everything
+// else has gone wrong, so mustn't be disabled.
+func (k *KillSwitch) Essential() bool {
+	return true
+}
+
+func (k *KillSwitch) Boot() error {
+	return nil
+}
+
+// Close closes the pin.
+func (k *KillSwitch) Close() error {
+	return k.pin.Close()
+}
+
+func (k *KillSwitch) Tick(now time.Time, state *hexapod.State) error {
+	open := k.read()
+
+	if k.rawAt.IsZero() || open != k.raw {
+		k.raw = open
+		k.rawAt = now
+	}
+
+	if k.raw != k.open && now.Sub(k.rawAt) >= k.cfg.Debounce.Duration {
+		k.open = k.raw
+		if k.open {
+			log.Warn("kill switch opened, halting")
+			k.openAt = k.rawAt
+		} else {
+			log.Warn("kill switch closed, but still halted until resumed")
+		}
+	}
+
+	if !k.open {
+		return nil
+	}
+
+	state.Halt = true
+
+	if !state.Shutdown && now.Sub(k.openAt) > k.cfg.ShutdownAfter.Duration {
+		state.Shutdown = true
+		state.Publish(hexapod.EventShutdownRequested, hexapod.Warning, "kill switch held open")
+	}
+
+	return nil
+}
+
+// read returns whether the switch is open, given the polarity. Errors count as
+// open, since the switch can't be trusted.
+func (k *KillSwitch) read() bool {
+	high, err := k.pin.Read()
+	if err != nil {
+		log.RateLimited("read", 10*time.Second).Errorf("error reading kill switch, so assuming it's open: %s", err)
+		return true
+	}
+
+	// When it's active low, the pin is pulled high while the switch is open.
+	return high == k.cfg.ActiveLow
+}
@@ -0,0 +1,227 @@
+package killswitch
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/adammck/hexapod"
+	"github.com/adammck/hexapod/config"
+	"github.com/stretchr/testify/assert"
+)
+
+// The interval between ticks. It's a bit faster than usual, so the durations
+// are round.
+const step = 10 * time.Millisecond
+
+// rig ticks a kill switch with a mock pin, wired active low.
+type rig struct {
+	t     *testing.T
+	k     *KillSwitch
+	pin   *Mock
+	state *hexapod.State
+	start time.Time
+	now   time.Time
+}
+
+func newRig(t *testing.T) *rig {
+	pin := &Mock{}
+	k := New(pin, config.Default().KillSwitch)
+	assert.NoError(t, k.Boot())
+
+	start := time.Unix(0, 0)
+	return &rig{t: t, k: k, pin: pin, state: &hexapod.State{}, start: start, now: start}
+}
+
+// run ticks every step for the given duration, with the pin high (which is
+// open) or low, and returns the offset of the first tick (from the rig's
+// creation) on which the state was halted, or -1 if it wasn't.
+func (r *rig) run(high bool, d, step time.Duration) time.Duration {
+	r.pin.Set(high)
+	halted := time.Duration(-1)
+
+	end := r.now.Add(d)
+	for ; r.now.Before(end); r.now = r.now.Add(step) {
+		assert.NoError(r.t, r.k.Tick(r.now, r.state))
+		if r.state.Halt && halted < 0 {
+			halted = r.now.Sub(r.start)
+		}
+	}
+
+	return halted
+}
+
+func shutdowns(s *hexapod.State) int {
+	n := 0
+	for _, e := range s.Published() {
+		if e.Name == hexapod.EventShutdownRequested {
+			n++
+		}
+	}
+
+	return n
+}
+
+func TestOpenHalts(t *testing.T) {
+	r := newRig(t)
+	assert.Equal(t, time.Duration(-1), r.run(false, time.Second, step))
+
+	// Halted once it's been open for the debounce interval.
+	assert.Equal(t, 1020*time.Millisecond, r.run(true, 100*time.Millisecond, step))
+	assert.True(t, r.k.open)
+	assert.False(t, r.state.Shutdown)
+}
+
+func TestBounce(t *testing.T) {
+	r := newRig(t)
+	r.run(false, time.Second, step)
+
+	// Blips which are shorter than the debounce interval are ignored, however
+	// many of them there are.
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, time.Duration(-1), r.run(true, 15*time.Millisecond, 5*time.Millisecond))
+		assert.Equal(t, time.Duration(-1), r.run(false, 10*time.Millisecond, 5*time.Millisecond))
+	}
+	assert.False(t, r.k.open)
+
+	// Contacts bouncing as the switch opens delay the halt until they settle.
+	r.run(true, 5*time.Millisecond, 5*time.Millisecond)
+	r.run(false, 5*time.Millisecond, 5*time.Millisecond)
+	r.run(true, 10*time.Millisecond, 5*time.Millisecond)
+	r.run(false, 5*time.Millisecond, 5*time.Millisecond)
+	settled := r.now.Sub(r.start)
+	assert.Equal(t, settled+20*time.Millisecond, r.run(true, 100*time.Millisecond, 5*time.Millisecond))
+	assert.True(t, r.k.open)
+
+	// And as it closes, which is still open until they settle.
+	r.run(false, 10*time.Millisecond, 5*time.Millisecond)
+	r.run(true, 5*time.Millisecond, 5*time.Millisecond)
+	r.run(false, 15*time.Millisecond, 5*time.Millisecond)
+	assert.True(t, r.k.open)
+	r.run(false, 10*time.Millisecond, 5*time.Millisecond)
+	assert.False(t, r.k.open)
+}
+
+func TestEscalation(t *testing.T) {
+	r := newRig(t)
+	r.run(false, time.Second, step)
+
+	// Opening it for a little while only halts.
+	r.run(true, 1500*time.Millisecond, step)
+	assert.True(t, r.state.Halt)
+	assert.False(t, r.state.Shutdown)
+
+	// Closing it again doesn't resume on its own.
+	r.run(false, time.Second, step)
+	assert.False(t, r.k.open)
+	assert.True(t, r.state.Halt)
+
+	// Resuming while it's open doesn't stick, and doesn't reset the time
+	// until it shuts down.
+	r.run(true, time.Second, step)
+	r.state.Halt = false
+	r.run(true, step, step)
+	assert.True(t, r.state.Halt)
+
+	// It shuts down once the switch has been open for more than two seconds,
+	// counting from when it first read open rather than when that was
+	// debounced.
+	r.run(true, time.Second-step, step)
+	assert.False(t, r.state.Shutdown)
+	r.run(true, step, step)
+	assert.False(t, r.state.Shutdown)
+	r.run(true, step, step)
+	assert.True(t, r.state.Shutdown)
+	assert.Equal(t, 1, shutdowns(r.state))
+
+	// Only once.
+	r.run(true, time.Second, step)
+	assert.Equal(t, 1, shutdowns(r.state))
+}
+
+func TestResume(t *testing.T) {
+	r := newRig(t)
+	r.run(false, time.Second, step)
+	r.run(true, 500*time.Millisecond, step)
+	r.run(false, 500*time.Millisecond, step)
+
+	// Once it's closed, resuming works as usual.
+	r.state.Halt = false
+	r.run(false, time.Second, step)
+	assert.False(t, r.state.Halt)
+	assert.False(t, r.state.Shutdown)
+}
+
+func TestPolarity(t *testing.T) {
+	cfg := config.Default().KillSwitch
+	cfg.ActiveLow = false
+	pin := &Mock{}
+	k := New(pin, cfg)
+	state := &hexapod.State{}
+	now := time.Unix(0, 0)
+
+	// Active high, so low is open.
+	pin.Set(true)
+	for i := 0; i < 10; i++ {
+		assert.NoError(t, k.Tick(now, state))
+		now = now.Add(step)
+	}
+	assert.False(t, state.Halt)
+
+	pin.Set(false)
+	for i := 0; i < 10; i++ {
+		assert.NoError(t, k.Tick(now, state))
+		now = now.Add(step)
+	}
+	assert.True(t, state.Halt)
+}
+
+func TestReadError(t *testing.T) {
+	r := newRig(t)
+	r.run(false, time.Second, step)
+
+	// Errors count as open.
+	r.pin.Fail(errors.New("oh no"))
+	r.run(false, 100*time.Millisecond, step)
+	assert.True(t, r.state.Halt)
+	assert.True(t, r.k.open)
+}
+
+func TestSysfs(t *testing.T) {
+	dir := t.TempDir()
+
+	// The pin hasn't been exported, so it is. The kernel would then create
+	// its directory, which doesn't happen here.
+	_, err := NewSysfs(dir, 17)
+	assert.Error(t, err)
+	b, err := os.ReadFile(filepath.Join(dir, "export"))
+	assert.NoError(t, err)
+	assert.Equal(t, "17", string(b))
+
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "gpio17"), 0755))
+	p, err := NewSysfs(dir, 17)
+	assert.NoError(t, err)
+	b, err = os.ReadFile(filepath.Join(dir, "gpio17", "direction"))
+	assert.NoError(t, err)
+	assert.Equal(t, "in", string(b))
+
+	value := filepath.Join(dir, "gpio17", "value")
+	for _, x := range []struct {
+		s    string
+		high bool
+		err  bool
+	}{
+		{"1\n", true, false},
+		{"0\n", false, false},
+		{"x\n", false, true},
+	} {
+		assert.NoError(t, os.WriteFile(value, []byte(x.s), 0644))
+		high, err := p.Read()
+		assert.Equal(t, x.high, high, x.s)
+		assert.Equal(t, x.err, err != nil, x.s)
+	}
+
+	assert.NoError(t, p.Close())
+}
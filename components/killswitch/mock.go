@@ -0,0 +1,39 @@
+package killswitch
+
+import (
+	"sync"
+)
+
+// Mock is a Pin whose level (or error) is set by the caller.
+type Mock struct {
+	mu   sync.Mutex
+	high bool
+	err  error
+}
+
+func (m *Mock) Read() (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.high, m.err
+}
+
+func (m *Mock) Close() error {
+	return nil
+}
+
+// Set sets the level which the pin reads.
+func (m *Mock) Set(high bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.high = high
+}
+
+// Fail makes every read return the given error, or stops it if nil.
+func (m *Mock) Fail(err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.err = err
+}
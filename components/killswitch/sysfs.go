@@ -0,0 +1,65 @@
+package killswitch
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Sysfs is a Pin for a GPIO input, via the sysfs interface, e.g.
+// /sys/class/gpio/gpio17.
+type Sysfs struct {
+	path string
+}
+
+// NewSysfs returns the GPIO pin with the given number, under the given sysfs
+// directory (usually /sys/class/gpio), exporting it first if it hasn't been
+// already, and making it an input.
+func NewSysfs(dir string, n int) (*Sysfs, error) {
+	path := filepath.Join(dir, fmt.Sprintf("gpio%d", n))
+
+	_, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		err = write(filepath.Join(dir, "export"), strconv.Itoa(n))
+		if err != nil {
+			return nil, fmt.Errorf("error exporting GPIO pin: %s", err)
+		}
+	} else if err != nil {
+		return nil, err
+	}
+
+	err = write(filepath.Join(path, "direction"), "in")
+	if err != nil {
+		return nil, fmt.Errorf("error setting GPIO pin direction: %s", err)
+	}
+
+	return &Sysfs{path: path}, nil
+}
+
+// Read returns whether the pin is high.
+func (s *Sysfs) Read() (bool, error) {
+	b, err := os.ReadFile(filepath.Join(s.path, "value"))
+	if err != nil {
+		return false, err
+	}
+
+	switch v := strings.TrimSpace(string(b)); v {
+	case "0":
+		return false, nil
+	case "1":
+		return true, nil
+	default:
+		return false, fmt.Errorf("invalid GPIO value: %q", v)
+	}
+}
+
+// Close does nothing, since the pin is left exported for next time.
+func (s *Sysfs) Close() error {
+	return nil
+}
+
+func write(path, s string) error {
+	return os.WriteFile(path, []byte(s), 0644)
+}
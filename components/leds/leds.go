@@ -0,0 +1,275 @@
+// Package leds drives a strip of RGB LEDs (e.g. WS2812) under the chassis,
+// which shows what the hex is up to: idle, walking, cooling down, self-testing,
+// low on battery, about to move by itself, stopped, or shutting down.
+package leds
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/adammck/hexapod"
+	"github.com/adammck/hexapod/config"
+	"github.com/adammck/hexapod/math3d"
+)
+
+var log = hexapod.NewLog("leds")
+
+const (
+
+	// How far (in mm) the target can be from the pose, and how far (in degrees)
+	// it can be turned from it, before the hex is considered to be walking.
+	walkingDistance = 1.0
+	walkingAngle    = 1.0
+)
+
+// Color is the colour of a single LED.
+type Color struct {
+	R, G, B uint8
+}
+
+// Driver writes frames to the strip. There's only a Mock so far.
+type Driver interface {
+
+	// Len returns the number of LEDs on the strip.
+	Len() int
+
+	// Write sets every LED on the strip, in order. The frame is reused, so
+	// mustn't be kept.
+	Write(frame []Color) error
+
+	Close() error
+}
+
+// Status is what the strip is showing. When more than one applies, the highest
+// wins.
+type Status int
+
+const (
+	Idle Status = iota
+	Walking
+	Safe
+	Cooling
+	SelfTesting
+	Battery
+	Countdown
+	Stopped
+	ShuttingDown
+)
+
+func (s Status) String() string {
+	switch s {
+	case Idle:
+		return "idle"
+	case Walking:
+		return "walking"
+	case Safe:
+		return "safe mode"
+	case Cooling:
+		return "cooling"
+	case SelfTesting:
+		return "self-testing"
+	case Battery:
+		return "battery"
+	case Countdown:
+		return "countdown"
+	case Stopped:
+		return "stopped"
+	case ShuttingDown:
+		return "shutting down"
+	}
+
+	return fmt.Sprintf("status(%d)", int(s))
+}
+
+// LEDs is a component which renders the pattern for the current status to the
+// strip on every tick. The animations are driven by the time passed to Tick,
+// rather than by counting ticks, so they run at the same rate when the loop is
+// slow or jittery.
+type LEDs struct {
+	drv        Driver
+	brightness float64
+	fallen     float64
+
+	// The pattern for each status.
+	patterns [ShuttingDown + 1]pattern
+
+	// Set when a battery_low event is received, and cleared once the voltage
+	// is back above the minimum.
+	lowBattery bool
+
+	// The status being shown, how far through a cycle of its pattern we are
+	// (from zero to one), and the time of the last tick.
+	status Status
+	phase  float64
+	last   time.Time
+
+	frame []Color
+}
+
+// New creates an LED component which writes to the given driver. It returns
+// an error if any of the patterns in the config don't exist.
+func New(drv Driver, cfg config.LEDs) (*LEDs, error) {
+	l := &LEDs{
+		drv:        drv,
+		brightness: cfg.Brightness,
+		fallen:     cfg.FallenAngle,
+		frame:      make([]Color, drv.Len()),
+	}
+
+	for s, p := range map[Status]config.Pattern{
+		Idle:         cfg.Idle,
+		Walking:      cfg.Walking,
+		Safe:         cfg.SafeMode,
+		Cooling:      cfg.Cooling,
+		SelfTesting:  cfg.SelfTest,
+		Battery:      cfg.Battery,
+		Countdown:    cfg.Countdown,
+		Stopped:      cfg.Stopped,
+		ShuttingDown: cfg.Shutdown,
+	} {
+		r, ok := renderers[p.Name]
+		if !ok {
+			return nil, fmt.Errorf("unknown LED pattern for %s: %q", s, p.Name)
+		}
+
+		l.patterns[s] = pattern{
+			render: r,
+			color:  Color(p.Color),
+			period: p.Period.Duration,
+		}
+	}
+
+	return l, nil
+}
+
+// Boot blanks the strip, which might still be showing whatever it was when the
+// last run ended.
+func (l *LEDs) Boot() error {
+	return l.blank()
+}
+
+// Close blanks the strip, and closes the driver.
+func (l *LEDs) Close() error {
+	err := l.blank()
+	if err != nil {
+		return err
+	}
+
+	return l.drv.Close()
+}
+
+// Shutdown closes the strip, once the loop has stopped.
+func (l *LEDs) Shutdown() error {
+	return l.Close()
+}
+
+func (l *LEDs) blank() error {
+	for i := range l.frame {
+		l.frame[i] = Color{}
+	}
+
+	return l.drv.Write(l.frame)
+}
+
+// Wants implements hexapod.Subscriber.
+func (l *LEDs) Wants(e *hexapod.Event) bool {
+	return e.Name == hexapod.EventBatteryLow
+}
+
+// Notify implements hexapod.Subscriber.
+func (l *LEDs) Notify(events []hexapod.Event) {
+	l.lowBattery = true
+}
+
+// Tick renders the current status to the strip. Errors are logged rather than
+// returned, since the hexapod is fine without its LEDs.
+func (l *LEDs) Tick(now time.Time, state *hexapod.State) error {
+	if l.lowBattery && !state.BatteryLow {
+		l.lowBattery = false
+	}
+
+	s := l.statusOf(state)
+	p := &l.patterns[s]
+
+	// The walking pattern runs faster or slower with the speed, so the phase
+	// is advanced rather than recalculated from the time, so that it doesn't
+	// jump when the speed changes.
+	rate := 1.0
+	if s == Walking {
+		rate = float64(state.Speed-hexapod.MinSpeed+1) / float64(1-hexapod.MinSpeed)
+	}
+
+	if s != l.status {
+		log.Infof("status: %s", s)
+		l.status = s
+		l.phase = 0
+	} else if !l.last.IsZero() && p.period > 0 && now.After(l.last) {
+		l.phase += now.Sub(l.last).Seconds() / p.period.Seconds() * rate
+		l.phase -= math.Floor(l.phase)
+	}
+
+	l.last = now
+
+	p.render(l.frame, p.color, l.phase)
+	for i := range l.frame {
+		l.frame[i] = scale(l.frame[i], l.brightness)
+	}
+
+	err := l.drv.Write(l.frame)
+	if err != nil {
+		log.RateLimited("write", 5*time.Second).Warnf("error writing to LED strip: %s", err)
+	}
+
+	return nil
+}
+
+// statusOf returns the status which should be shown for the given state.
+func (l *LEDs) statusOf(state *hexapod.State) Status {
+	p := state.Pose
+
+	switch {
+	case state.Shutdown:
+		return ShuttingDown
+
+	// Until the hex is armed in safe mode, it's halted, which is shown as safe
+	// mode rather than stopped, so it's clear why.
+	case state.Halt && !disarmed(state) || math.Abs(p.Pitch) > l.fallen || math.Abs(p.Bank) > l.fallen:
+		return Stopped
+
+	// Warning anyone nearby that the hex is about to move matters more than
+	// the battery, which will still be low afterwards.
+	case state.Countdown.Active:
+		return Countdown
+
+	case l.lowBattery:
+		return Battery
+
+	case state.SelfTesting:
+		return SelfTesting
+
+	case state.Cooling:
+		return Cooling
+
+	case state.SafeMode:
+		return Safe
+
+	case walking(state.Pose, state.Target):
+		return Walking
+	}
+
+	return Idle
+}
+
+// disarmed returns true if the hex is in safe mode, and hasn't been armed yet.
+func disarmed(state *hexapod.State) bool {
+	return state.SafeMode && !state.Armed
+}
+
+func walking(pose, target math3d.Pose) bool {
+	dx := target.Position.X - pose.Position.X
+	dz := target.Position.Z - pose.Position.Z
+
+	return math.Sqrt(dx*dx+dz*dz) > walkingDistance ||
+		math.Abs(math3d.AngleDiff(pose.Heading, target.Heading)) > walkingAngle
+}
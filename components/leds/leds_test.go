@@ -0,0 +1,320 @@
+package leds
+
+import (
+	"testing"
+	"time"
+
+	"github.com/adammck/hexapod"
+	"github.com/adammck/hexapod/config"
+	"github.com/adammck/hexapod/math3d"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	off    = Color{}
+	green  = Color{0, 255, 0}
+	blue   = Color{0, 0, 255}
+	orange = Color{255, 128, 0}
+	red    = Color{255, 0, 0}
+	purple = Color{128, 0, 255}
+	white  = Color{255, 255, 255}
+)
+
+// The gaps between ticks, which are cycled through, so the loop is nowhere near
+// steady.
+var jitter = []time.Duration{
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	16 * time.Millisecond,
+	40 * time.Millisecond,
+}
+
+// setup returns an LED component (with the default patterns, at full
+// brightness) on a mock strip of four LEDs.
+func setup(t *testing.T) (*LEDs, *Mock) {
+	cfg := config.Default().LEDs
+	cfg.Brightness = 1
+
+	m := NewMock(4)
+	l, err := New(m, cfg)
+	assert.NoError(t, err)
+	assert.NoError(t, l.Boot())
+
+	return l, m
+}
+
+// player ticks the component with jittery gaps, and remembers where it got to,
+// so the state can be changed between plays.
+type player struct {
+	t     *testing.T
+	l     *LEDs
+	m     *Mock
+	now   time.Time
+	ticks int
+}
+
+func newPlayer(t *testing.T) *player {
+	l, m := setup(t)
+	return &player{t: t, l: l, m: m, now: time.Unix(0, 0)}
+}
+
+// play ticks once now, and then until each of the given offsets from now, and
+// returns the frame after each of those ticks.
+func (p *player) play(state *hexapod.State, at ...time.Duration) [][]Color {
+	start := p.now
+	tick := func() []Color {
+		assert.NoError(p.t, p.l.Tick(p.now, state))
+		f, _ := p.m.Frame()
+		return f
+	}
+
+	out := [][]Color{tick()}
+	for _, d := range at {
+		for {
+			next := p.now.Add(jitter[p.ticks%len(jitter)])
+			p.ticks += 1
+			if end := start.Add(d); !next.Before(end) {
+				p.now = end
+				break
+			}
+
+			p.now = next
+			tick()
+		}
+
+		out = append(out, tick())
+	}
+
+	return out
+}
+
+func all(c Color) []Color {
+	return []Color{c, c, c, c}
+}
+
+// standing returns the state of a hex standing still somewhere.
+func standing() *hexapod.State {
+	p := math3d.Pose{Position: math3d.Vector3{X: 100, Y: 40, Z: -50}, Heading: 30}
+	return &hexapod.State{
+		Commands:  hexapod.Commands{Target: p},
+		Estimates: hexapod.Estimates{Pose: p},
+	}
+}
+
+func TestIdleBreathes(t *testing.T) {
+	p := newPlayer(t)
+	third := 4 * time.Second / 3
+	assert.Equal(t, [][]Color{
+		all(off),
+		all(Color{0, 191, 0}),
+		all(green),
+		all(Color{0, 191, 0}),
+		all(off),
+		all(green),
+	}, p.play(standing(), third, 2*time.Second, 2*third, 4*time.Second, 6*time.Second))
+}
+
+func TestWalkingChases(t *testing.T) {
+	p := newPlayer(t)
+	s := standing()
+	s.Target.Position.Z += 50
+
+	dim := Color{0, 0, 64}
+	assert.Equal(t, [][]Color{
+		{blue, off, off, dim},
+		{dim, blue, off, off},
+		{off, off, dim, blue},
+		{blue, off, off, dim},
+	}, p.play(s, 300*time.Millisecond, 800*time.Millisecond, 1100*time.Millisecond))
+
+	// Turning on the spot counts as walking, too.
+	s = standing()
+	s.Target.Heading += 10
+	assert.Equal(t, Walking, p.l.statusOf(s))
+}
+
+func TestWalkingChasesWithSpeed(t *testing.T) {
+	p := newPlayer(t)
+	s := standing()
+	s.Target.Position.X += 50
+
+	// At the minimum speed, a second is barely any of the way around.
+	s.Speed = hexapod.MinSpeed
+	f := p.play(s, time.Second)
+	assert.Equal(t, f[0], f[1])
+
+	// And changing the speed doesn't make the head jump.
+	s.Speed = hexapod.MaxSpeed
+	f = p.play(s, 0)
+	assert.Equal(t, blue, f[0][0])
+
+	// At the maximum, a cycle takes 31/39ths of a second.
+	f = p.play(s, 500*time.Millisecond, 800*time.Millisecond)
+	assert.Equal(t, blue, f[1][2])
+	assert.Equal(t, blue, f[2][0])
+}
+
+func TestBatteryWarning(t *testing.T) {
+	p := newPlayer(t)
+	s := standing()
+	s.BatteryLow = true
+	p.l.Notify([]hexapod.Event{{Name: hexapod.EventBatteryLow, Payload: 9.2}})
+
+	for _, f := range p.play(s, time.Second, 2*time.Second) {
+		assert.Equal(t, all(orange), f)
+	}
+
+	// Walking doesn't hide it.
+	s.Target.Position.Z += 50
+	assert.Equal(t, all(orange), p.play(s)[0])
+
+	// Until the battery is replaced.
+	s = standing()
+	assert.Equal(t, all(off), p.play(s)[0])
+	assert.Equal(t, Idle, p.l.status)
+}
+
+func TestStoppedFlashes(t *testing.T) {
+	p := newPlayer(t)
+	s := standing()
+	s.Halt = true
+
+	assert.Equal(t, [][]Color{
+		all(red),
+		all(off),
+		all(red),
+		all(off),
+	}, p.play(s, 300*time.Millisecond, 600*time.Millisecond, 1900*time.Millisecond))
+}
+
+func TestCountdownFlashes(t *testing.T) {
+	p := newPlayer(t)
+	s := standing()
+	s.Countdown = hexapod.Countdown{Active: true, Source: "navigator", Remaining: 3 * time.Second}
+
+	assert.Equal(t, [][]Color{
+		all(white),
+		all(off),
+		all(white),
+		all(off),
+	}, p.play(s, 150*time.Millisecond, 260*time.Millisecond, 400*time.Millisecond))
+
+	// It's more important than the battery, but not than a halt.
+	p.l.Notify([]hexapod.Event{{Name: hexapod.EventBatteryLow, Payload: 9.2}})
+	assert.Equal(t, Countdown, p.l.statusOf(s))
+	s.Halt = true
+	assert.Equal(t, Stopped, p.l.statusOf(s))
+}
+
+func TestFallenFlashes(t *testing.T) {
+	l, _ := setup(t)
+	s := standing()
+	assert.Equal(t, Idle, l.statusOf(s))
+
+	s.Pose.Bank = -40
+	assert.Equal(t, Idle, l.statusOf(s))
+
+	s.Pose.Bank = -50
+	assert.Equal(t, Stopped, l.statusOf(s))
+
+	s.Pose.Bank = 0
+	s.Pose.Pitch = 90
+	assert.Equal(t, Stopped, l.statusOf(s))
+}
+
+func TestSelfTestStatus(t *testing.T) {
+	l, _ := setup(t)
+	s := standing()
+	s.SelfTesting = true
+	assert.Equal(t, SelfTesting, l.statusOf(s))
+
+	// A low battery is more important.
+	l.Notify([]hexapod.Event{{Name: hexapod.EventBatteryLow, Payload: 9.2}})
+	assert.Equal(t, Battery, l.statusOf(s))
+}
+
+func TestCoolingStatus(t *testing.T) {
+	l, _ := setup(t)
+	s := standing()
+	s.Cooling = true
+	assert.Equal(t, Cooling, l.statusOf(s))
+
+	// Even if the legs are still moving, e.g. to finish a step.
+	s.Target.Position.Z += 50
+	assert.Equal(t, Cooling, l.statusOf(s))
+}
+
+func TestSafeModeStatus(t *testing.T) {
+	l, _ := setup(t)
+	s := standing()
+	assert.Equal(t, Idle, l.statusOf(s))
+
+	// Until it's armed, the halt is shown as safe mode rather than stopped.
+	s.SafeMode = true
+	s.Halt = true
+	assert.Equal(t, Safe, l.statusOf(s))
+
+	// Once it's armed, a halt is shown as usual, and walking is shown as safe
+	// mode too.
+	s.Armed = true
+	assert.Equal(t, Stopped, l.statusOf(s))
+	s.Halt = false
+	s.Target.Position.Z += 50
+	assert.Equal(t, Safe, l.statusOf(s))
+
+	// Cooling is more important.
+	s.Cooling = true
+	assert.Equal(t, Cooling, l.statusOf(s))
+}
+
+func TestShutdownWipes(t *testing.T) {
+	p := newPlayer(t)
+	p.play(standing(), 1500*time.Millisecond)
+
+	// Shutting down wins over everything, and starts from the beginning of
+	// the wipe, wherever the last pattern was.
+	s := standing()
+	s.Shutdown = true
+	s.Halt = true
+	assert.Equal(t, [][]Color{
+		all(purple),
+		{purple, purple, purple, off},
+		{purple, off, off, off},
+		all(purple),
+	}, p.play(s, 300*time.Millisecond, 800*time.Millisecond, 1100*time.Millisecond))
+}
+
+func TestBrightness(t *testing.T) {
+	cfg := config.Default().LEDs
+	cfg.Brightness = 0.5
+	cfg.Idle = config.Pattern{Name: "solid", Color: config.Color{R: 200, G: 100, B: 1}}
+
+	m := NewMock(2)
+	l, err := New(m, cfg)
+	assert.NoError(t, err)
+
+	assert.NoError(t, l.Tick(time.Unix(0, 0), standing()))
+	f, n := m.Frame()
+	assert.Equal(t, []Color{{100, 50, 1}, {100, 50, 1}}, f)
+	assert.Equal(t, 1, n)
+}
+
+func TestUnknownPattern(t *testing.T) {
+	cfg := config.Default().LEDs
+	cfg.Stopped.Name = "disco"
+
+	_, err := New(NewMock(4), cfg)
+	assert.EqualError(t, err, `unknown LED pattern for stopped: "disco"`)
+}
+
+func TestClose(t *testing.T) {
+	p := newPlayer(t)
+	s := standing()
+	s.Halt = true
+	assert.Equal(t, all(red), p.play(s)[0])
+
+	assert.NoError(t, p.l.Close())
+	f, _ := p.m.Frame()
+	assert.Equal(t, all(off), f)
+	assert.True(t, p.m.Closed())
+}
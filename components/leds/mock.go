@@ -0,0 +1,58 @@
+package leds
+
+import (
+	"sync"
+)
+
+// Mock is a Driver which doesn't drive anything, but keeps a copy of the last
+// frame written to it. Until there's a real driver, this is also used when the
+// strip is configured.
+type Mock struct {
+	mu     sync.Mutex
+	last   []Color
+	writes int
+	closed bool
+}
+
+// NewMock returns a mock strip with the given number of LEDs.
+func NewMock(n int) *Mock {
+	return &Mock{last: make([]Color, n)}
+}
+
+func (m *Mock) Len() int {
+	return len(m.last)
+}
+
+func (m *Mock) Write(frame []Color) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	copy(m.last, frame)
+	m.writes += 1
+	return nil
+}
+
+func (m *Mock) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.closed = true
+	return nil
+}
+
+// Frame returns a copy of the last frame written, and the number of frames
+// which have been written so far.
+func (m *Mock) Frame() ([]Color, int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]Color{}, m.last...), m.writes
+}
+
+// Closed returns whether Close has been called.
+func (m *Mock) Closed() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.closed
+}
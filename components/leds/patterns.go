@@ -0,0 +1,87 @@
+package leds
+
+import (
+	"math"
+	"time"
+)
+
+// pattern is a renderer, and the colour and period to render it with.
+type pattern struct {
+	render renderer
+	color  Color
+	period time.Duration
+}
+
+// renderer fills the frame with a pattern of the given colour, at the given
+// phase (from zero to one) through a single cycle of it.
+type renderer func(frame []Color, c Color, phase float64)
+
+// The patterns which can be named in the config.
+var renderers = map[string]renderer{
+	"solid":   solid,
+	"breathe": breathe,
+	"chase":   chase,
+	"flash":   flash,
+	"wipe":    wipe,
+}
+
+// solid sets every LED to the colour, ignoring the phase.
+func solid(frame []Color, c Color, phase float64) {
+	for i := range frame {
+		frame[i] = c
+	}
+}
+
+// breathe fades every LED from off to the colour and back, smoothly.
+func breathe(frame []Color, c Color, phase float64) {
+	solid(frame, scale(c, (1-math.Cos(2*math.Pi*phase))/2), phase)
+}
+
+// chase runs a single LED along the strip, with a dimmer one trailing it.
+func chase(frame []Color, c Color, phase float64) {
+	n := len(frame)
+	if n == 0 {
+		return
+	}
+
+	head := int(phase*float64(n)) % n
+	for i := range frame {
+		frame[i] = Color{}
+	}
+
+	frame[head] = c
+	frame[(head-1+n)%n] = scale(c, 0.25)
+}
+
+// flash sets every LED to the colour for the first half of the cycle, and off
+// for the second.
+func flash(frame []Color, c Color, phase float64) {
+	if phase >= 0.5 {
+		c = Color{}
+	}
+
+	solid(frame, c, phase)
+}
+
+// wipe starts with every LED set to the colour, and turns them off one at a
+// time from the end of the strip.
+func wipe(frame []Color, c Color, phase float64) {
+	lit := len(frame) - int(phase*float64(len(frame)))
+	for i := range frame {
+		if i < lit {
+			frame[i] = c
+		} else {
+			frame[i] = Color{}
+		}
+	}
+}
+
+// scale returns the colour with each channel multiplied by f, which should be
+// between zero and one.
+func scale(c Color, f float64) Color {
+	return Color{
+		R: uint8(math.Round(float64(c.R) * f)),
+		G: uint8(math.Round(float64(c.G) * f)),
+		B: uint8(math.Round(float64(c.B) * f)),
+	}
+}
@@ -0,0 +1,116 @@
+package legs
+
+import (
+	"math"
+	"time"
+
+	"github.com/adammck/hexapod"
+	"github.com/adammck/hexapod/config"
+)
+
+// The stages of the budget, which config.Budget.Priority lists in order.
+const (
+	stageSwing = "swing"
+	stageHead  = "head"
+	stageGait  = "gait"
+)
+
+// The torque limit which the head is reduced from. It always runs at full.
+const headTorque = 1023
+
+// budget keeps the current drawn within the torque budget, by stepping the
+// level of reduction up while it's over, and back down once it's fallen below
+// by the hysteresis, no more than one step per interval. It doesn't touch the
+// servos itself; see update.
+type budget struct {
+	cfg config.Budget
+
+	level   int
+	changed time.Time
+}
+
+// max returns the highest level, at which every stage is fully reduced.
+func (b *budget) max() int {
+	return len(b.cfg.Priority) * b.cfg.Steps
+}
+
+// update steps the level towards keeping State.Power.Current within the budget,
+// and writes it to State.Budget. It publishes EventOverBudget when it starts
+// slowing the gait.
+func (b *budget) update(now time.Time, state *hexapod.State) {
+	cur := state.Power.Current
+	prev := state.Budget
+
+	if b.cfg.Current > 0 && now.Sub(b.changed) >= b.cfg.Interval.Duration {
+		switch {
+		case cur > b.cfg.Current && b.level < b.max():
+			b.level++
+			b.changed = now
+
+		case cur < b.cfg.Current-b.cfg.Hysteresis && b.level > 0:
+			b.level--
+			b.changed = now
+		}
+	}
+
+	state.Budget = b.state()
+	next := state.Budget
+	if next.Level == prev.Level {
+		return
+	}
+
+	if prev.Gait == 0 && next.Gait > 0 {
+		log.Warnf("drawing %.1fA, still over the budget of %.1fA, so slowing the gait", cur, b.cfg.Current)
+		state.Publish(hexapod.EventOverBudget, hexapod.Warning, cur)
+		return
+	}
+
+	if next.Level > prev.Level {
+		log.Infof("drawing %.1fA, over the budget of %.1fA, so reducing to level %d", cur, b.cfg.Current, next.Level)
+	} else {
+		log.Infof("drawing %.1fA, under the budget of %.1fA, so restoring to level %d", cur, b.cfg.Current, next.Level)
+	}
+}
+
+// state returns how far each stage is reduced at the current level. The stages
+// are reduced one after another, so each is only reduced once the ones before
+// it (in the priority order) are fully reduced.
+func (b *budget) state() hexapod.Budget {
+	out := hexapod.Budget{Level: b.level}
+
+	for i, s := range b.cfg.Priority {
+		n := b.level - i*b.cfg.Steps
+		r := math.Max(0, math.Min(float64(n)/float64(b.cfg.Steps), 1))
+
+		switch s {
+		case stageSwing:
+			out.Swing = r
+		case stageHead:
+			out.Head = r
+			if r > 0 {
+				out.HeadTorque = reduce(headTorque, b.cfg.HeadTorque, r)
+			}
+		case stageGait:
+			out.Gait = r
+		}
+	}
+
+	return out
+}
+
+// swingTorque returns the torque limit for the servos of legs which are in the
+// air, given the usual limit.
+func (b *budget) swingTorque(state *hexapod.State, full int) int {
+	return reduce(full, b.cfg.SwingTorque, state.Budget.Swing)
+}
+
+// slowTicks returns the number of ticks to add to the ticks per step.
+func (b *budget) slowTicks(state *hexapod.State) int {
+	return int(math.Round(float64(b.cfg.SlowTicks) * state.Budget.Gait))
+}
+
+// reduce returns the given fraction of the way from one torque limit to the
+// other, rounded.
+func reduce(from, to int, r float64) int {
+	return int(math.Round(float64(from) + float64(to-from)*r))
+}
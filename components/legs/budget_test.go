@@ -0,0 +1,142 @@
+package legs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/adammck/hexapod"
+	"github.com/adammck/hexapod/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func testBudget() *budget {
+	return &budget{cfg: config.Budget{
+		Current:     4,
+		Hysteresis:  0.5,
+		Interval:    config.Duration{Duration: 100 * time.Millisecond},
+		Priority:    []string{"swing", "head", "gait"},
+		Steps:       2,
+		SwingTorque: 300,
+		HeadTorque:  200,
+		SlowTicks:   8,
+	}}
+}
+
+// drive updates the budget every 100ms with each of the currents in turn, and
+// returns the budget after each, and the names of the events published.
+func drive(b *budget, state *hexapod.State, currents ...float64) ([]hexapod.Budget, []string) {
+	var out []hexapod.Budget
+	var events []string
+	t0 := time.Unix(0, 0)
+
+	for i, c := range currents {
+		n := len(state.Published())
+		state.Power.Current = c
+		b.update(t0.Add(time.Duration(i)*100*time.Millisecond), state)
+		out = append(out, state.Budget)
+
+		for _, e := range state.Published()[n:] {
+			events = append(events, e.Name)
+		}
+	}
+
+	return out, events
+}
+
+func TestBudgetOrder(t *testing.T) {
+	b := testBudget()
+	state := &hexapod.State{}
+	got, events := drive(b, state, 6, 6, 6, 6, 6, 6, 6)
+
+	// The swing legs are reduced first, then the head, then the gait, and it
+	// stays there while it's still over.
+	assert.Equal(t, []hexapod.Budget{
+		{Level: 1, Swing: 0.5},
+		{Level: 2, Swing: 1},
+		{Level: 3, Swing: 1, Head: 0.5, HeadTorque: 612},
+		{Level: 4, Swing: 1, Head: 1, HeadTorque: 200},
+		{Level: 5, Swing: 1, Head: 1, HeadTorque: 200, Gait: 0.5},
+		{Level: 6, Swing: 1, Head: 1, HeadTorque: 200, Gait: 1},
+		{Level: 6, Swing: 1, Head: 1, HeadTorque: 200, Gait: 1},
+	}, got)
+
+	// Only slowing the gait is warned about.
+	assert.Equal(t, []string{hexapod.EventOverBudget}, events)
+
+	// The magnitudes, at full reduction.
+	assert.Equal(t, 300, b.swingTorque(state, 1023))
+	assert.Equal(t, 8, b.slowTicks(state))
+	state.Budget.Swing, state.Budget.Gait = 0.5, 0.5
+	assert.Equal(t, 662, b.swingTorque(state, 1023))
+	assert.Equal(t, 4, b.slowTicks(state))
+}
+
+func TestBudgetHysteresis(t *testing.T) {
+	b := testBudget()
+	state := &hexapod.State{}
+	got, _ := drive(b, state,
+
+		// Up to the head.
+		5, 5, 5,
+
+		// Below the budget, but not by the hysteresis, so it holds.
+		3.8, 3.6, 3.9,
+
+		// Over again, then well below, so it's restored in the reverse order.
+		4.1, 3, 3, 3, 3, 3,
+
+		// Hovering around the budget doesn't flap.
+		4.2, 3.9, 4.2, 3.9,
+	)
+
+	levels := make([]int, len(got))
+	for i, b := range got {
+		levels[i] = b.Level
+	}
+
+	assert.Equal(t, []int{1, 2, 3, 3, 3, 3, 4, 3, 2, 1, 0, 0, 1, 1, 2, 2}, levels)
+	assert.Equal(t, hexapod.Budget{Level: 3, Swing: 1, Head: 0.5, HeadTorque: 612}, got[7])
+	assert.Equal(t, hexapod.Budget{}, got[10])
+}
+
+func TestBudgetInterval(t *testing.T) {
+	b := testBudget()
+	b.cfg.Interval.Duration = 250 * time.Millisecond
+	state := &hexapod.State{}
+	got, _ := drive(b, state, 6, 6, 6, 6, 6, 6)
+
+	levels := make([]int, len(got))
+	for i, b := range got {
+		levels[i] = b.Level
+	}
+
+	// Ticks are every 100ms, so it only steps on every third.
+	assert.Equal(t, []int{1, 1, 1, 2, 2, 2}, levels)
+}
+
+func TestBudgetPriority(t *testing.T) {
+	b := testBudget()
+	b.cfg.Priority = []string{"gait", "head"}
+	state := &hexapod.State{}
+	got, events := drive(b, state, 6, 6, 6, 6, 6)
+
+	// The gait is first, so that's warned about straight away. The swing legs
+	// aren't listed, so are never reduced.
+	assert.Equal(t, []hexapod.Budget{
+		{Level: 1, Gait: 0.5},
+		{Level: 2, Gait: 1},
+		{Level: 3, Gait: 1, Head: 0.5, HeadTorque: 612},
+		{Level: 4, Gait: 1, Head: 1, HeadTorque: 200},
+		{Level: 4, Gait: 1, Head: 1, HeadTorque: 200},
+	}, got)
+	assert.Equal(t, []string{hexapod.EventOverBudget}, events)
+}
+
+func TestBudgetDisabled(t *testing.T) {
+	b := testBudget()
+	b.cfg.Current = 0
+	state := &hexapod.State{}
+	got, events := drive(b, state, 6, 20, 6)
+	assert.Equal(t, []hexapod.Budget{{}, {}, {}}, got)
+	assert.Empty(t, events)
+}
@@ -0,0 +1,80 @@
+package legs
+
+import (
+	"math"
+)
+
+// The most beats per step (or steps per beat) which the cadence will lock to.
+const maxSubdivision = 8
+
+// cadence locks the steps to a tempo, so the hex can walk in time with music.
+// Each step takes a whole number of beats, or a beat is a whole number of
+// steps, whichever is closest to the pace which the speed calls for. It's
+// separate from the legs so it can be tested without any servos.
+//
+// A step is a whole number of ticks, so the period usually doesn't divide
+// exactly. Rather than drift off the beat, the ticks per step are varied (by
+// one) between cycles, so they add up to the beat over time. Only the tempo is
+// locked, not the phase; the first step is on the first beat.
+type cadence struct {
+
+	// The period (in ticks per step) which the cadence is locked to, and the
+	// ticks which are owed to it by the cycles so far. This is reset when
+	// the period changes, e.g. when the speed does.
+	period float64
+	owed   float64
+}
+
+// beatPeriod returns the period (in ticks per step, but not necessarily a
+// whole number) which is a subdivision or multiple of a beat at the given tempo
+// (in beats per minute) and tick rate, and is closest to the given natural
+// period. Returns false if none is within the given limits.
+func beatPeriod(bpm float64, fps int, natural, min, max int) (float64, bool) {
+	if bpm <= 0 || fps <= 0 || natural <= 0 {
+		return 0, false
+	}
+
+	beat := float64(fps) * 60 / bpm
+
+	var best float64
+	for n := 1; n <= maxSubdivision; n++ {
+		for _, p := range []float64{beat / float64(n), beat * float64(n)} {
+			if math.Round(p) < float64(min) || math.Round(p) > float64(max) {
+				continue
+			}
+
+			// Compared by ratio, since a step which is twice as long is as
+			// far off as one which is half as long.
+			if best == 0 || math.Abs(math.Log(p/float64(natural))) < math.Abs(math.Log(best/float64(natural))) {
+				best = p
+			}
+		}
+	}
+
+	return best, best > 0
+}
+
+// ticks returns the ticks per step for the next step cycle, which has the given
+// number of steps, to stay locked to the given period. It's always within the
+// given limits.
+func (c *cadence) ticks(period float64, steps, min, max int) int {
+	if period != c.period {
+		c.period = period
+		c.owed = 0
+	}
+
+	c.owed += period * float64(steps)
+	tps := clamp(min, max, int(math.Round(c.owed/float64(steps))))
+	c.owed -= float64(tps * steps)
+
+	return tps
+}
+
+// stride returns the distance to step in a cycle whose steps take the given
+// number of ticks, to keep the speed which the given distance would have been
+// at the natural number of ticks. It's limited to the max step distance, since
+// the legs can't reach any further, so the hex walks slower than commanded if
+// the tempo is much slower than the natural pace.
+func stride(dist float64, natural, tps int, max float64) float64 {
+	return math.Min(max, dist*float64(tps)/float64(natural))
+}
@@ -0,0 +1,72 @@
+package legs
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBeatPeriod(t *testing.T) {
+	for _, tc := range []struct {
+		bpm     float64
+		natural int
+		want    float64
+		ok      bool
+	}{
+		// A beat at 120 bpm and 60 fps is 30 ticks, so steps can take 30 ticks,
+		// or 15, or 10, etc, or 60, or 90, etc.
+		{120, 30, 30, true},
+		{120, 20, 15, true},
+		{120, 12, 10, true},
+		{120, 50, 60, true},
+
+		// Periods aren't always whole numbers of ticks.
+		{100, 36, 36, true},
+		{140, 25, 25.714, true},
+		{128, 14, 14.063, true},
+
+		// Too fast, or too slow, to fit within the limits.
+		{1, 20, 0, false},
+		{0, 20, 0, false},
+	} {
+		p, ok := beatPeriod(tc.bpm, 60, tc.natural, 10, 90)
+		assert.Equal(t, tc.ok, ok, "bpm=%v, natural=%d", tc.bpm, tc.natural)
+		assert.InDelta(t, tc.want, p, 0.001, "bpm=%v, natural=%d", tc.bpm, tc.natural)
+	}
+}
+
+func TestCadenceDoesntDrift(t *testing.T) {
+	var c cadence
+
+	// At 140 bpm and 60 fps, a beat is 25.714 ticks. Six steps per cycle.
+	p, ok := beatPeriod(140, 60, 25, 10, 90)
+	assert.True(t, ok)
+
+	total := 0
+	seen := map[int]bool{}
+	for i := 0; i < 100; i++ {
+		tps := c.ticks(p, 6, 10, 90)
+		seen[tps] = true
+		total += tps * 6
+
+		// The steps are never more than half a tick off the beat in total.
+		assert.InDelta(t, p*6*float64(i+1), float64(total), 6*0.5)
+	}
+
+	// Only the two nearest whole numbers of ticks are used.
+	assert.Equal(t, map[int]bool{25: true, 26: true}, seen)
+
+	// Changing the period starts again.
+	assert.Equal(t, 30, c.ticks(30, 6, 10, 90))
+	assert.Equal(t, 30, c.ticks(30, 6, 10, 90))
+}
+
+func TestStride(t *testing.T) {
+
+	// Taking twice as long to step takes twice the stride, to keep the speed.
+	assert.Equal(t, 40.0, stride(20, 15, 30, 60))
+	assert.Equal(t, 10.0, stride(20, 30, 15, 60))
+
+	// But no further than the legs can reach.
+	assert.Equal(t, 60.0, stride(40, 15, 30, 60))
+}
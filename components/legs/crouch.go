@@ -0,0 +1,44 @@
+package legs
+
+import (
+	"math"
+
+	"github.com/adammck/hexapod"
+	"github.com/adammck/hexapod/components/legs/gait"
+)
+
+// crouching returns whether the state's active gait is the crouch gait.
+func crouching(state *hexapod.State) bool {
+	g, ok := state.ActiveGait()
+	return ok && g.Name == gait.Crouch
+}
+
+// stepTuning returns the tuning which the next step cycle should use. That's the
+// latched tuning, unless the crouch gait is active, in which case the stance is
+// wider, the steps lower, and the cycle longer. See config.Crouch.
+func (l *Legs) stepTuning(state *hexapod.State) tuning {
+	t := l.tuning
+	l.crouching = crouching(state)
+	if !l.crouching {
+		return t
+	}
+
+	c := l.gaitCfg.Crouch
+	t.stepRadius = c.StepRadius
+	t.stepRadii = [6]float64{}
+	t.stepHeight = c.StepHeight
+	t.ticksPerStep = int(math.Round(float64(t.ticksPerStep) * c.TicksScale))
+
+	return t
+}
+
+// maxStepDistance returns the furthest that the hex should move per step cycle,
+// before the reach checks. It's shorter while crouching, since the legs are
+// folded up, and the workspace is flatter.
+func (l *Legs) maxStepDistance() float64 {
+	if l.crouching {
+		return l.gaitCfg.Crouch.MaxStepDistance
+	}
+
+	return l.cfg.MaxStepDistance
+}
@@ -0,0 +1,81 @@
+package legs
+
+import (
+	"fmt"
+
+	"github.com/adammck/hexapod"
+	"github.com/adammck/hexapod/math3d"
+)
+
+// feedbackTurn returns the indexes (into every joint of every leg, from the
+// first leg's coxa) of the given number of joints whose turn it is to be read,
+// starting from next, and the index to start from on the next tick.
+func feedbackTurn(next, n, joints int) ([]int, int) {
+	if n > joints {
+		n = joints
+	}
+
+	out := make([]int, n)
+	for i := range out {
+		out[i] = (next + i) % joints
+	}
+
+	return out, (next + n) % joints
+}
+
+// readFeedback reads the present position of the joints whose turn it is (see
+// config.Legs.Feedback), which is enough for them to be recorded by the servo
+// capture, if there is one (see servos.Capture). The angles of the legs on the
+// ground are kept, to measure where their feet are (see measuredFeet), but
+// those of the legs in the air are forgotten, since they're still moving.
+func (l *Legs) readFeedback() error {
+	if l.cfg.Feedback <= 0 {
+		return nil
+	}
+
+	for i, s := range l.swing {
+		if s {
+			l.read[i] = [4]bool{}
+		}
+	}
+
+	var turn []int
+	turn, l.feedback = feedbackTurn(l.feedback, l.cfg.Feedback, len(l.Legs)*4)
+	for _, i := range turn {
+		j := l.Legs[i/4].joints()[i%4]
+		a, err := j.Angle()
+		if err != nil {
+			return fmt.Errorf("%s (while reading %s #%d position)", err, l.Legs[i/4].Name, j.ID)
+		}
+
+		l.angles[i/4][i%4] = a
+		l.read[i/4][i%4] = !l.swing[i/4]
+	}
+
+	return nil
+}
+
+// measuredFeet returns where the feet on the ground are (in the world space),
+// from the angles of their joints, as last read, and which of them aren't
+// known, because they're in the air, or their joints haven't all been read
+// since they were put down. The joints are read a few at a time, so this
+// assumes that the body hasn't moved far since.
+func (l *Legs) measuredFeet(state *hexapod.State) ([6]math3d.Vector3, [6]bool) {
+	var feet [6]math3d.Vector3
+	var unknown [6]bool
+	world := state.World()
+
+	for i, r := range l.read {
+		if l.swing[i] || !(r[0] && r[1] && r[2] && r[3]) {
+			unknown[i] = true
+			continue
+		}
+
+		// The tarsus is told to go a little further than it's meant to. See
+		// Leg.PresentPosition.
+		a := l.angles[i]
+		feet[i] = l.Legs[i].end(a[0], a[1], a[2], a[3]-tarsusExtraAngle).MultiplyByMatrix44(world)
+	}
+
+	return feet, unknown
+}
@@ -0,0 +1,23 @@
+package legs
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFeedbackTurn(t *testing.T) {
+	turn, next := feedbackTurn(0, 4, 24)
+	assert.Equal(t, []int{0, 1, 2, 3}, turn)
+	assert.Equal(t, 4, next)
+
+	// It wraps around, so every joint takes a turn.
+	turn, next = feedbackTurn(22, 4, 24)
+	assert.Equal(t, []int{22, 23, 0, 1}, turn)
+	assert.Equal(t, 2, next)
+
+	// Asking for more than there are reads each of them once.
+	turn, next = feedbackTurn(5, 30, 24)
+	assert.Len(t, turn, 24)
+	assert.Equal(t, 5, next)
+}
@@ -7,6 +7,10 @@ const (
 type Frame struct {
 	XZ float64
 	Y  float64
+
+	// Whether the foot is in the air, i.e. moving between its old and new
+	// positions. Y is a bell curve, which is never quite zero, so can't tell.
+	Swing bool
 }
 
 type Frames []Frame
@@ -1,16 +1,90 @@
 package gait
 
 import (
+	"fmt"
 	"math"
+
+	"github.com/adammck/hexapod"
+)
+
+// The names of the gaits which TheGait can make.
+const (
+	Wave   = "wave"
+	Ripple = "ripple"
+	Tripod = "tripod"
+	Crouch = "crouch"
 )
 
+// The number of legs which step at once in each gait, by name.
+var groupSizes = map[string]int{}
+
+// Register the gaits with the core, slowest first, which is the order that the
+// controller cycles through them in. Gaits which move more legs at once need
+// more clearance, since the chassis sags while they're in the air.
+//
+// The crouch gait is last, so the indexes of the others don't change. It steps
+// like the wave gait, but the legs use their crouch config while it's active
+// (see config.Crouch), so it's only for walking with the body low.
+func init() {
+	register(hexapod.Gait{Name: Wave, MinSpeed: hexapod.MinSpeed, MaxSpeed: 0}, 1)
+	register(hexapod.Gait{Name: Ripple, MinSpeed: -10, MaxSpeed: 4, MinClearance: 20}, 2)
+	register(hexapod.Gait{Name: Tripod, MinSpeed: -5, MaxSpeed: hexapod.MaxSpeed, MinClearance: 30}, 3)
+	register(hexapod.Gait{Name: Crouch, MinSpeed: hexapod.MinSpeed, MaxSpeed: 0, MaxClearance: 35}, 1)
+}
+
+func register(g hexapod.Gait, groupSize int) {
+	hexapod.RegisterGait(g)
+	groupSizes[g.Name] = groupSize
+}
+
+// Make returns the gait with the given name, with the given number of ticks per
+// step. It returns an error if the name isn't one of the above.
+//
+// The duty factor is the fraction of the cycle which each foot spends on the
+// ground. Zero uses the gait's own, which is as long as the other groups take
+// to step (e.g. half, for the tripod). Those which are higher shorten the swing
+// to leave more feet on the ground at once, but those which are lower are the
+// same as zero, since they'd lift more feet at once than the gait is meant to.
+func Make(name string, ticksPerStep int, dutyFactor float64) (Gait, error) {
+	gs, ok := groupSizes[name]
+	if !ok {
+		return Gait{}, fmt.Errorf("unknown gait: %q", name)
+	}
+
+	swing := float64(ticksPerStep)
+	if s := float64(ticksPerStep*(6/gs)) * (1 - dutyFactor); dutyFactor > 0 && s < swing {
+		swing = math.Max(s, 1)
+	}
+
+	return makeGait(gs, ticksPerStep, swing), nil
+}
+
+// Steps returns the number of steps in each step cycle of the gait with the
+// given name, i.e. the number of groups of legs which step in turn, or zero if
+// there's no such gait.
+func Steps(name string) int {
+	gs, ok := groupSizes[name]
+	if !ok {
+		return 0
+	}
+
+	return 6 / gs
+}
+
 func TheGait(groupSize int, ticksPerStep int) Gait {
+	return makeGait(groupSize, ticksPerStep, float64(ticksPerStep))
+}
+
+// makeGait returns the gait with the given number of legs in each group, where
+// each foot is in the air for the given number of ticks (which is no more than
+// ticks per step) around the center of its step.
+func makeGait(groupSize int, ticksPerStep int, swing float64) Gait {
 	ticksPerStepCycle := ticksPerStep * (6 / groupSize)
 	cc := curveCenters(groupSize, ticksPerStepCycle)
 
 	var legs [numLegs]Frames
 	for i := 0; i < numLegs; i += 1 {
-		legs[i] = singleLegGait(ticksPerStepCycle, ticksPerStep, cc[i])
+		legs[i] = singleLegGait(ticksPerStepCycle, swing, cc[i])
 	}
 
 	return Gait{
@@ -82,9 +156,8 @@ func curveCenters(groupSize int, ticksPerStepCycle int) [numLegs]float64 {
 	}
 }
 
-func singleLegGait(ticksPerStepCycle, ticksPerStep int, stepCurveCenter float64) Frames {
+func singleLegGait(ticksPerStepCycle int, tps float64, stepCurveCenter float64) Frames {
 	frameList := make(Frames, ticksPerStepCycle)
-	tps := float64(ticksPerStep)
 
 	curveStart := stepCurveCenter - tps/2
 	curveEnd := stepCurveCenter + tps/2
@@ -105,6 +178,7 @@ func singleLegGait(ticksPerStepCycle, ticksPerStep int, stepCurveCenter float64)
 		} else {
 			x := (i - curveStart) / tps
 			f.XZ = 0.5 - (math.Cos(x*math.Pi) / 2)
+			f.Swing = f.XZ > 0 && f.XZ < 1
 		}
 
 		frameList[int(i)] = f
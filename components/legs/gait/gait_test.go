@@ -0,0 +1,143 @@
+package gait
+
+import (
+	"testing"
+
+	"github.com/adammck/hexapod"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTheGait(t *testing.T) {
+	for _, gs := range []int{1, 2, 3} {
+		g := TheGait(gs, 10)
+		assert.Equal(t, 10*6/gs, g.Length())
+
+		// Every foot starts and ends the cycle (more or less) on the ground,
+		// and is lifted for part of it.
+		for i := 0; i < numLegs; i++ {
+			assert.InDelta(t, 0.0, g.Frame(i, 0).Y, 0.05, "group=%d leg=%d", gs, i)
+			assert.InDelta(t, 0.0, g.Frame(i, g.Length()-1).Y, 0.05, "group=%d leg=%d", gs, i)
+
+			lifted := false
+			for n := 0; n < g.Length(); n++ {
+				lifted = lifted || g.Frame(i, n).Y > 0.9
+			}
+			assert.True(t, lifted, "group=%d leg=%d", gs, i)
+		}
+	}
+}
+
+func TestSwing(t *testing.T) {
+	for _, name := range []string{Wave, Ripple, Tripod} {
+		g, err := Make(name, 10, 0)
+		assert.NoError(t, err)
+
+		// Each foot swings once per cycle, for a single run of frames in which
+		// it's moving, and is (more or less) on the ground otherwise.
+		for i := 0; i < numLegs; i++ {
+			runs := 0
+			for n := 0; n < g.Length(); n++ {
+				f := g.Frame(i, n)
+				assert.Equal(t, f.XZ > 0 && f.XZ < 1, f.Swing, "%s leg=%d frame=%d", name, i, n)
+				if f.Swing && (n == 0 || !g.Frame(i, n-1).Swing) {
+					runs++
+				}
+				if !f.Swing {
+					assert.True(t, f.Y < 0.01, "%s leg=%d frame=%d: y=%f", name, i, n, f.Y)
+				}
+			}
+			assert.Equal(t, 1, runs, "%s leg=%d", name, i)
+		}
+	}
+}
+
+func TestSteps(t *testing.T) {
+	assert.Equal(t, 6, Steps(Wave))
+	assert.Equal(t, 3, Steps(Ripple))
+	assert.Equal(t, 2, Steps(Tripod))
+	assert.Equal(t, 0, Steps("moonwalk"))
+
+	g, err := Make(Ripple, 10, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, Steps(Ripple)*10, g.Length())
+}
+
+func TestDutyFactor(t *testing.T) {
+
+	// swinging returns the number of frames during which the given foot is
+	// moving, i.e. not on the ground.
+	swinging := func(g Gait, leg int) int {
+		n := 0
+		for i := 0; i < g.Length(); i++ {
+			if f := g.Frame(leg, i); f.XZ > 0 && f.XZ < 1 {
+				n++
+			}
+		}
+		return n
+	}
+
+	natural, err := Make(Tripod, 10, 0)
+	assert.NoError(t, err)
+
+	// A quarter of the cycle in the air, rather than half.
+	g, err := Make(Tripod, 10, 0.75)
+	assert.NoError(t, err)
+	assert.Equal(t, natural.Length(), g.Length())
+
+	for i := 0; i < numLegs; i++ {
+		assert.Equal(t, 9, swinging(natural, i), "leg=%d", i)
+		assert.Equal(t, 5, swinging(g, i), "leg=%d", i)
+
+		// The feet still start and end the cycle where they should.
+		assert.Equal(t, 0.0, g.Frame(i, 0).XZ, "leg=%d", i)
+		assert.Equal(t, 1.0, g.Frame(i, g.Length()-1).XZ, "leg=%d", i)
+		assert.InDelta(t, 0.0, g.Frame(i, 0).Y, 0.05, "leg=%d", i)
+	}
+
+	// Lower than the gait's own is the same as its own.
+	g, err = Make(Tripod, 10, 0.3)
+	assert.NoError(t, err)
+	assert.Equal(t, natural, g)
+}
+
+// BenchmarkFrames is the gait part of a tick while stepping: looking up the
+// frame of every leg.
+func BenchmarkFrames(b *testing.B) {
+	g := TheGait(3, 10)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	var sum float64
+	for i := 0; i < b.N; i++ {
+		n := i % g.Length()
+		for j := 0; j < numLegs; j++ {
+			f := g.Frame(j, n)
+			sum += f.XZ + f.Y
+		}
+	}
+}
+
+func TestRegistered(t *testing.T) {
+	var names []string
+	for _, g := range hexapod.DefaultGaits.Gaits() {
+		names = append(names, g.Name)
+	}
+
+	// Slowest first, so GaitIndex means what it always did, then the crouch
+	// gait, which steps like the wave.
+	assert.Equal(t, []string{Wave, Ripple, Tripod, Crouch}, names)
+
+	for i, name := range names {
+		g, err := Make(name, 10, 0)
+		assert.NoError(t, err)
+		assert.Equal(t, TheGait([]int{1, 2, 3, 1}[i], 10), g)
+	}
+
+	crouch, _ := hexapod.DefaultGaits.Get(Crouch)
+	assert.False(t, crouch.Fits(40))
+	assert.True(t, crouch.Fits(0))
+
+	_, err := Make("nope", 10, 0)
+	assert.Error(t, err)
+}
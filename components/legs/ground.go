@@ -0,0 +1,92 @@
+package legs
+
+import (
+	"math"
+
+	"github.com/adammck/hexapod"
+	"github.com/adammck/hexapod/math3d"
+)
+
+// groundOutlier is how far (in mm) above or below the plane through the other
+// feet on the ground a foot can be before it's left out of the fit, e.g.
+// because it's standing on a block.
+const groundOutlier = 10.0
+
+// at returns the height (Y) of the ground at the given X/Z.
+func (g ground) at(x, z float64) float64 {
+	return g.a*x + g.b*z + g.c
+}
+
+// state returns the ground as a plane in the state, fitted to the given number
+// of feet.
+func (g ground) state(feet int) hexapod.Ground {
+	n := math3d.Vector3{X: -g.a, Y: 1, Z: -g.b}
+	m := n.Magnitude()
+	return hexapod.Ground{
+		Normal: n.MultiplyByScalar(1 / m),
+		Offset: g.c / m,
+		Feet:   feet,
+	}
+}
+
+// estimateGround returns the ground fitted to the feet which are on it, like
+// fitGround, but leaving out any which are too far out of line with the rest,
+// and the number of feet which it was fitted to. Those are left out one at a
+// time, furthest first, by how far each is from the plane through the others.
+// It takes at least five to tell which of them is out, so with four or fewer,
+// none are.
+func estimateGround(feet [6]math3d.Vector3, swing [6]bool) (ground, int) {
+	out := swing
+	n := 0
+	for _, s := range out {
+		if !s {
+			n++
+		}
+	}
+
+	for ; n > 4; n-- {
+		worst, dist := -1, groundOutlier
+		for i, f := range feet {
+			if out[i] {
+				continue
+			}
+
+			rest := out
+			rest[i] = true
+			if d := math.Abs(fitGround(feet, rest).height(f)); d > dist {
+				worst, dist = i, d
+			}
+		}
+
+		if worst < 0 {
+			break
+		}
+
+		out[worst] = true
+	}
+
+	return fitGround(feet, out), n
+}
+
+// updateGround fits the ground to where the feet on it were measured to be (see
+// measuredFeet), for the guard and the clearance on the next tick, and sets it
+// in the state. That's not where they were told to go (at Y=0, or on the ground
+// remembered under them; see terrain), since they land wherever the ground
+// stops them. Until at least three have been measured, it stays as it was,
+// which to start with (and without the feedback) is the Y=0 plane.
+func (l *Legs) updateGround(state *hexapod.State) {
+	feet, unknown := l.measuredFeet(state)
+	gr, n := estimateGround(feet, unknown)
+	if n >= 3 {
+		l.ground = gr
+		l.groundFeet = n
+	}
+
+	state.Ground = l.ground.state(l.groundFeet)
+}
+
+// floor returns the height of the ground under the body, which the clearance is
+// above, rather than above Y=0, so the body rises and falls with a slope.
+func (l *Legs) floor(state *hexapod.State) float64 {
+	return l.ground.at(state.Pose.Position.X, state.Pose.Position.Z)
+}
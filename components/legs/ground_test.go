@@ -0,0 +1,168 @@
+package legs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/adammck/hexapod"
+	"github.com/adammck/hexapod/config"
+	"github.com/adammck/hexapod/math3d"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEstimateGround(t *testing.T) {
+	for _, gr := range []ground{
+		{},
+		{c: -5},
+		{a: -0.05, b: 0.2, c: 3},
+	} {
+		// Flat or sloped, every foot is in line.
+		feet := homeFeet(gr)
+		got, n := estimateGround(feet, [6]bool{})
+		assert.InDeltaSlice(t, []float64{gr.a, gr.b, gr.c}, []float64{got.a, got.b, got.c}, 1e-9)
+		assert.Equal(t, 6, n)
+
+		// One standing on a block is left out, whichever it is.
+		for i := range feet {
+			blocked := feet
+			blocked[i].Y += 40
+			got, n := estimateGround(blocked, [6]bool{})
+			assert.InDeltaSlice(t, []float64{gr.a, gr.b, gr.c}, []float64{got.a, got.b, got.c}, 1e-9, "block under %d", i)
+			assert.Equal(t, 5, n, "block under %d", i)
+		}
+
+		// Even with one in the air.
+		blocked := feet
+		blocked[0].Y += 40
+		blocked[3].Y += 30
+		got, n = estimateGround(blocked, [6]bool{false, false, false, true, false, false})
+		assert.InDeltaSlice(t, []float64{gr.a, gr.b, gr.c}, []float64{got.a, got.b, got.c}, 1e-9)
+		assert.Equal(t, 4, n)
+
+		// A little out of line isn't enough.
+		bumped := feet
+		bumped[2].Y += 2
+		_, n = estimateGround(bumped, [6]bool{})
+		assert.Equal(t, 6, n)
+	}
+
+	// With only four on the ground, there's no telling which is on the
+	// block, so it's the same as fitting them all.
+	feet := homeFeet(ground{})
+	feet[0].Y += 40
+	swing := [6]bool{false, false, true, false, false, true}
+	got, n := estimateGround(feet, swing)
+	assert.Equal(t, fitGround(feet, swing), got)
+	assert.Equal(t, 4, n)
+}
+
+// measure sets the angles of the joints of every leg as if they'd been read
+// with the feet at the given positions (in the world space), and the body where
+// the state says.
+func measure(l *Legs, state *hexapod.State, feet [6]math3d.Vector3) {
+	for i, leg := range l.Legs {
+		l.angles[i] = leg.solve(feet[i].MultiplyByMatrix44(state.Local()))
+		l.read[i] = [4]bool{true, true, true, true}
+	}
+}
+
+func TestMeasuredFeet(t *testing.T) {
+	l := &Legs{Legs: bareLegs()}
+	state := &hexapod.State{}
+	state.Pose = math3d.Pose{Position: math3d.Vector3{X: 30, Y: 50, Z: -20}, Heading: 20, Pitch: 5}
+	feet := homeFeet(ground{a: 0.1, c: -10})
+	for i := range feet {
+		feet[i] = *feet[i].Add(math3d.Vector3{X: 30, Z: -20})
+	}
+
+	measure(l, state, feet)
+	got, unknown := l.measuredFeet(state)
+	assert.Equal(t, [6]bool{}, unknown)
+	for i := range feet {
+		assert.InDelta(t, 0, got[i].Distance(feet[i]), 1e-6, "foot %d", i)
+	}
+
+	// Not while a foot is in the air, or until every joint of its leg has
+	// been read since it was put down.
+	l.swing[1] = true
+	l.read[4][2] = false
+	_, unknown = l.measuredFeet(state)
+	assert.Equal(t, [6]bool{false, true, false, false, true, false}, unknown)
+}
+
+func TestUpdateGround(t *testing.T) {
+	gr := ground{a: -0.05, b: 0.2, c: 3}
+	l := &Legs{Legs: bareLegs()}
+	state := &hexapod.State{}
+	state.Pose.Position.Y = 50
+
+	// Where the feet were told to go doesn't matter, so until they've been
+	// measured, it's the Y=0 plane.
+	l.feet = homeFeet(gr)
+	l.updateGround(state)
+	assert.Equal(t, ground{}, l.ground)
+	assert.Equal(t, 0, state.Ground.Feet)
+	assert.Equal(t, 0.0, state.Ground.Height(100, 200))
+
+	// Once they have, it's the slope they're really on. The plane in the
+	// state is the same one, with an upwards unit normal, which tilts.
+	feet := homeFeet(gr)
+	feet[4].Y += 40
+	measure(l, state, feet)
+	l.updateGround(state)
+	assert.Equal(t, 5, state.Ground.Feet)
+	assert.InDelta(t, 1, state.Ground.Normal.Magnitude(), 1e-9)
+	assert.True(t, state.Ground.Normal.Y > 0)
+	assert.True(t, state.Ground.Normal.X > 0.01 && state.Ground.Normal.Z < -0.1, "%v", state.Ground.Normal)
+	for _, v := range []math3d.Vector3{{}, {X: 100, Z: -50}, {X: -300, Z: 200}} {
+		assert.InDelta(t, gr.at(v.X, v.Z), state.Ground.Height(v.X, v.Z), 1e-6, "at %v", v)
+	}
+
+	// With fewer than three known, it stays as it was.
+	prev := l.ground
+	l.swing = [6]bool{true, true, false, true, true, false}
+	measure(l, state, homeFeet(ground{}))
+	l.updateGround(state)
+	assert.Equal(t, prev, l.ground)
+	assert.Equal(t, 5, state.Ground.Feet)
+
+	// The clearance is above the ground under the body.
+	state.Pose.Position = math3d.Vector3{X: 100, Y: 60, Z: 200}
+	assert.InDelta(t, -5+40+3, l.floor(state), 1e-6)
+
+	// And the zero value is the Y=0 plane.
+	assert.Equal(t, 0.0, hexapod.Ground{}.Height(100, 200))
+	assert.Equal(t, 0.0, (&Legs{}).floor(state))
+}
+
+func TestGuardGround(t *testing.T) {
+	l := &Legs{Legs: bareLegs(), guard: guard{cfg: config.Default().Legs.Chassis}}
+	l.feet = homeFeet(ground{})
+	l.feet[0].Y += 40
+	state := &hexapod.State{}
+	now := time.Unix(0, 0)
+	measure(l, state, l.feet)
+	l.updateGround(state)
+
+	// The front left foot is on a block, but that doesn't mean the ground
+	// under the front of the body is any higher, so pitching forwards as
+	// far as it could on the flat isn't clamped.
+	p := pitchLimit(25) - 0.5
+	aim := math3d.Pose{Position: math3d.Vector3{Y: 25}, Pitch: p}
+	l.guardAim(now, state, &aim)
+	assert.Equal(t, p, aim.Pitch)
+	assert.False(t, l.guard.clamped)
+
+	// Fitting to all of them would have.
+	l.ground = fitGround(l.feet, [6]bool{})
+	l.guardAim(now, state, &aim)
+	assert.True(t, aim.Pitch < p, "%v", aim.Pitch)
+
+	// On a slope rising ahead, it can pitch forwards less than on the flat.
+	l.feet = homeFeet(ground{b: 0.05})
+	measure(l, state, l.feet)
+	l.updateGround(state)
+	aim = math3d.Pose{Position: math3d.Vector3{Y: 40}, Pitch: pitchLimit(40) - 0.5}
+	l.guardAim(now, state, &aim)
+	assert.InDelta(t, slopeLimit(40, l.guard.cfg.Length/2, 0.05), aim.Pitch, 0.02)
+}
@@ -0,0 +1,253 @@
+package legs
+
+import (
+	"math"
+	"time"
+
+	"github.com/adammck/hexapod"
+	"github.com/adammck/hexapod/config"
+	"github.com/adammck/hexapod/math3d"
+)
+
+const (
+
+	// How far (in mm) the chassis can be inside the ground or the margin of
+	// a foot before it counts, so that it's clear once it's been clamped to
+	// exactly the limit, despite the rounding.
+	guardTolerance = 0.01
+
+	// The number of times to halve the range of the pitch and bank while
+	// clamping them, which gets within a hundredth of a degree or so.
+	guardIterations = 12
+
+	// The most often that clamping is published and logged.
+	guardInterval = 10 * time.Second
+)
+
+// ground is the plane which the feet are standing on, in the world space, as
+// the height (Y) at each X/Z: Y = a*X + b*Z + c.
+type ground struct {
+	a, b, c float64
+}
+
+// height returns how far the given point is above the ground, vertically. It's
+// negative below.
+func (g ground) height(v math3d.Vector3) float64 {
+	return v.Y - g.at(v.X, v.Z)
+}
+
+// fitGround returns the plane through the given feet (in the world space) which
+// are on the ground, i.e. not swinging. That's a least squares fit, since there
+// are usually more than three. With fewer, or if they're in a line, it's level
+// with their average height (or all of the feet's, if all are swinging).
+func fitGround(feet [6]math3d.Vector3, swing [6]bool) ground {
+	var buf [6]math3d.Vector3
+	pts := buf[:0]
+	for i, f := range feet {
+		if !swing[i] {
+			pts = append(pts, f)
+		}
+	}
+	if len(pts) == 0 {
+		pts = feet[:]
+	}
+
+	// The normal equations, relative to the centroid so they're better
+	// conditioned (and c drops out).
+	var mx, my, mz float64
+	for _, p := range pts {
+		mx += p.X
+		my += p.Y
+		mz += p.Z
+	}
+	n := float64(len(pts))
+	mx, my, mz = mx/n, my/n, mz/n
+
+	var sxx, sxz, szz, sxy, szy float64
+	for _, p := range pts {
+		x, y, z := p.X-mx, p.Y-my, p.Z-mz
+		sxx += x * x
+		sxz += x * z
+		szz += z * z
+		sxy += x * y
+		szy += z * y
+	}
+
+	det := sxx*szz - sxz*sxz
+	if len(pts) < 3 || math.Abs(det) < 1e-6 {
+		return ground{c: my}
+	}
+
+	a := (sxy*szz - szy*sxz) / det
+	b := (szy*sxx - sxy*sxz) / det
+	return ground{a: a, b: b, c: my - a*mx - b*mz}
+}
+
+// guard keeps the chassis out of the ground, and off the feet, by clamping the
+// clearance, pitch and bank which the legs aim for. See config.Chassis.
+type guard struct {
+	cfg config.Chassis
+
+	// Whether the aim was clamped on the last tick, and when that was last
+	// published, so it isn't on every tick.
+	clamped   bool
+	published time.Time
+}
+
+func (g *guard) enabled() bool {
+	return g.cfg.Width > 0 && g.cfg.Length > 0
+}
+
+// corners returns the corners of the chassis, in the world space, with the body
+// (i.e. the pose plus the offset) at the given pose.
+func (g *guard) corners(body math3d.Pose) [8]math3d.Vector3 {
+	m := body.ToWorld()
+	w, l := g.cfg.Width/2, g.cfg.Length/2
+
+	var out [8]math3d.Vector3
+	i := 0
+	for _, x := range [2]float64{-w, w} {
+		for _, y := range [2]float64{0, g.cfg.Height} {
+			for _, z := range [2]float64{-l, l} {
+				out[i] = math3d.Vector3{X: x, Y: y, Z: z}.MultiplyByMatrix44(m)
+				i++
+			}
+		}
+	}
+
+	return out
+}
+
+// footDistance returns the distance between the chassis and the given foot (in
+// the body space, via local), or zero if the foot is inside it.
+func (g *guard) footDistance(local math3d.Matrix44, foot math3d.Vector3) float64 {
+	v := foot.MultiplyByMatrix44(local)
+	dx := math.Max(math.Abs(v.X)-g.cfg.Width/2, 0)
+	dz := math.Max(math.Abs(v.Z)-g.cfg.Length/2, 0)
+	dy := math.Max(math.Max(-v.Y, v.Y-g.cfg.Height), 0)
+	return math.Sqrt(dx*dx + dy*dy + dz*dz)
+}
+
+// clear returns whether the chassis, with the body at the given pose plus the
+// offset, is above the ground, and at least the margin from every foot.
+func (g *guard) clear(pose math3d.Pose, offset math3d.Vector3, gr ground, feet [6]math3d.Vector3) bool {
+	body := pose.Add(math3d.Pose{Position: offset})
+	for _, c := range g.corners(body) {
+		if gr.height(c) < -guardTolerance {
+			return false
+		}
+	}
+
+	local := body.ToLocal()
+	for _, f := range feet {
+		if g.footDistance(local, f) < g.cfg.FootMargin-guardTolerance {
+			return false
+		}
+	}
+
+	return true
+}
+
+// lift returns how far the chassis (at the given pose plus the offset, which must
+// be level) must be raised to keep it above the ground, and above the margin
+// over any foot which is under it. Feet which are above the bottom of the
+// chassis can't be helped by raising it, so are ignored.
+func (g *guard) lift(pose math3d.Pose, offset math3d.Vector3, gr ground, feet [6]math3d.Vector3) float64 {
+	body := pose.Add(math3d.Pose{Position: offset})
+	d := 0.0
+	for _, c := range g.corners(body) {
+		d = math.Max(d, -gr.height(c))
+	}
+
+	m := g.cfg.FootMargin
+	local := body.ToLocal()
+	for _, f := range feet {
+		v := f.MultiplyByMatrix44(local)
+		if v.Y > 0 {
+			continue
+		}
+
+		dx := math.Max(math.Abs(v.X)-g.cfg.Width/2, 0)
+		dz := math.Max(math.Abs(v.Z)-g.cfg.Length/2, 0)
+		if h := dx*dx + dz*dz; h < m*m {
+			d = math.Max(d, v.Y+math.Sqrt(m*m-h))
+		}
+	}
+
+	return d
+}
+
+// clamp returns the pose closest to the given one (where the body is aimed, not
+// including the offset) at which the chassis is clear of the ground and the
+// feet, and whether that's different. The pitch and bank are reduced together
+// first, since they usually cause it, and the body is only raised if it isn't
+// clear even when level. If it can't be cleared, the best effort is returned.
+func (g *guard) clamp(body math3d.Pose, offset math3d.Vector3, gr ground, feet [6]math3d.Vector3) (math3d.Pose, bool) {
+	if g.clear(body, offset, gr, feet) {
+		return body, false
+	}
+
+	level := body
+	level.Pitch, level.Bank = 0, 0
+	if !g.clear(level, offset, gr, feet) {
+		d := g.lift(level, offset, gr, feet)
+		level.Position.Y += d
+		body.Position.Y += d
+
+		if g.clear(body, offset, gr, feet) {
+			return body, true
+		}
+
+		if !g.clear(level, offset, gr, feet) {
+			return level, true
+		}
+	}
+
+	// The fraction of the pitch and bank which can be kept.
+	lo, hi := 0.0, 1.0
+	for i := 0; i < guardIterations; i++ {
+		t := (lo + hi) / 2
+		p := level
+		p.Pitch, p.Bank = body.Pitch*t, body.Bank*t
+		if g.clear(p, offset, gr, feet) {
+			lo = t
+		} else {
+			hi = t
+		}
+	}
+
+	level.Pitch, level.Bank = body.Pitch*lo, body.Bank*lo
+	return level, true
+}
+
+// guardAim clamps the clearance, pitch and bank of the aim, so the chassis stays
+// clear of the ground (as estimated from the feet which are on it, on the last
+// tick; see updateGround) and the feet.
+// The body is at the current X/Z position and heading, plus the offset. When it
+// starts clamping, that's published and logged, but not too often.
+func (l *Legs) guardAim(now time.Time, state *hexapod.State, aim *math3d.Pose) {
+	if !l.guard.enabled() {
+		return
+	}
+
+	body := math3d.Pose{
+		Position: math3d.Vector3{X: state.Pose.Position.X, Y: aim.Position.Y, Z: state.Pose.Position.Z},
+		Heading:  state.Pose.Heading,
+		Pitch:    aim.Pitch,
+		Bank:     aim.Bank,
+	}
+
+	p, clamped := l.guard.clamp(body, state.Offset, l.ground, l.feet)
+	if clamped && !l.guard.clamped && now.Sub(l.guard.published) >= guardInterval {
+		log.Warnf("clamping clearance from %.0f to %.0f, pitch from %.1f to %.1f, and bank from %.1f to %.1f, to keep the chassis clear", body.Position.Y, p.Position.Y, body.Pitch, p.Pitch, body.Bank, p.Bank)
+		state.Publish(hexapod.EventChassisClamped, hexapod.Warning, nil)
+		l.guard.published = now
+	}
+
+	l.guard.clamped = clamped
+	if clamped {
+		aim.Position.Y = p.Position.Y
+		aim.Pitch = p.Pitch
+		aim.Bank = p.Bank
+	}
+}
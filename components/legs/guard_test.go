@@ -0,0 +1,237 @@
+package legs
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/adammck/hexapod"
+	"github.com/adammck/hexapod/config"
+	"github.com/adammck/hexapod/math3d"
+	"github.com/adammck/hexapod/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+// homeFeet returns the home position of every foot at the default step radius,
+// with each on the given ground.
+func homeFeet(gr ground) [6]math3d.Vector3 {
+	var out [6]math3d.Vector3
+	for i, leg := range bareLegs() {
+		v := homePosition(&math3d.ZeroVector3, leg, math3d.Pose{}, config.Default().Legs.StepRadius)
+		v.Y = gr.a*v.X + gr.b*v.Z + gr.c
+		out[i] = v
+	}
+
+	return out
+}
+
+// pitchLimit returns the most that the default chassis can pitch forwards at the
+// given clearance over flat ground, which is when the bottom of the front
+// touches it.
+func pitchLimit(clearance float64) float64 {
+	return utils.Deg(math.Asin(clearance / (config.Default().Legs.Chassis.Length / 2)))
+}
+
+// slopeLimit returns the most that the chassis can tilt at the given clearance,
+// with its edge the given distance from the middle, over ground which rises
+// towards that edge at the given slope (or falls, if negative).
+func slopeLimit(clearance, edge, slope float64) float64 {
+	return utils.Deg(math.Asin(clearance/(edge*math.Sqrt(1+slope*slope))) - math.Atan(slope))
+}
+
+func TestFitGround(t *testing.T) {
+	for _, gr := range []ground{
+		{},
+		{c: -5},
+		{a: 0.1},
+		{a: -0.05, b: 0.2, c: 3},
+	} {
+		// From every foot, or those on the ground, ignoring one in the air.
+		feet := homeFeet(gr)
+		assert.InDeltaSlice(t, []float64{gr.a, gr.b, gr.c}, fit(feet, [6]bool{}), 1e-9)
+
+		feet[2].Y += 40
+		assert.InDeltaSlice(t, []float64{gr.a, gr.b, gr.c}, fit(feet, [6]bool{false, false, true, false, false, false}), 1e-9)
+	}
+
+	// Not enough feet for a slope, so it's level with them.
+	feet := homeFeet(ground{a: 0.1})
+	assert.Equal(t, ground{c: feet[1].Y}, fitGround(feet, [6]bool{true, false, true, true, true, true}))
+}
+
+// fit returns the coefficients of the ground fitted to the given feet.
+func fit(feet [6]math3d.Vector3, swing [6]bool) []float64 {
+	gr := fitGround(feet, swing)
+	return []float64{gr.a, gr.b, gr.c}
+}
+
+func TestGuardPitch(t *testing.T) {
+	g := &guard{cfg: config.Default().Legs.Chassis}
+	feet := homeFeet(ground{})
+	limit := pitchLimit(25)
+
+	// Just inside the limit is left alone, either way.
+	for _, p := range []float64{limit - 0.1, -(limit - 0.1)} {
+		pose := math3d.Pose{Position: math3d.Vector3{Y: 25}, Pitch: p}
+		got, clamped := g.clamp(pose, math3d.ZeroVector3, ground{}, feet)
+		assert.False(t, clamped, "at %v", p)
+		assert.Equal(t, pose, got)
+	}
+
+	// Just outside, or well outside, is clamped to the limit, keeping the
+	// clearance and the proportion of the bank.
+	for _, p := range []float64{limit + 0.1, -(limit + 0.1), 30, -30} {
+		pose := math3d.Pose{Position: math3d.Vector3{Y: 25}, Pitch: p}
+		got, clamped := g.clamp(pose, math3d.ZeroVector3, ground{}, feet)
+		assert.True(t, clamped, "at %v", p)
+		assert.InDelta(t, math.Copysign(limit, p), got.Pitch, 0.02, "at %v", p)
+		assert.Equal(t, 25.0, got.Position.Y)
+	}
+
+	pose := math3d.Pose{Position: math3d.Vector3{Y: 25}, Pitch: 30, Bank: 10}
+	got, _ := g.clamp(pose, math3d.ZeroVector3, ground{}, feet)
+	assert.InDelta(t, got.Pitch/3, got.Bank, 1e-9)
+	assert.True(t, g.clear(got, math3d.ZeroVector3, ground{}, feet))
+}
+
+func TestGuardOffset(t *testing.T) {
+	g := &guard{cfg: config.Default().Legs.Chassis}
+	feet := homeFeet(ground{})
+
+	// Shifting the body forwards makes the front of the chassis closer to the
+	// ground when pitched forwards, but only when pitched.
+	pose := math3d.Pose{Position: math3d.Vector3{Y: 25}, Pitch: pitchLimit(25) - 1}
+	_, clamped := g.clamp(pose, math3d.ZeroVector3, ground{}, feet)
+	assert.False(t, clamped)
+
+	offset := math3d.Vector3{Z: 30}
+	got, clamped := g.clamp(pose, offset, ground{}, feet)
+	assert.True(t, clamped)
+	assert.True(t, got.Pitch < pose.Pitch)
+
+	pose.Pitch = 0
+	_, clamped = g.clamp(pose, offset, ground{}, feet)
+	assert.False(t, clamped)
+}
+
+func TestGuardSlope(t *testing.T) {
+	g := &guard{cfg: config.Default().Legs.Chassis}
+	half := g.cfg.Length / 2
+
+	// The ground rises towards the front, by 5mm per 100mm, so the front of
+	// the chassis is closer to it than the clearance says.
+	gr := ground{b: 0.05}
+	feet := homeFeet(gr)
+	front := half * gr.b
+
+	// Pitching forwards hits it sooner, and backwards later. Just inside and
+	// outside each is left alone, or clamped.
+	fwd, back := slopeLimit(40, half, gr.b), slopeLimit(40, half, -gr.b)
+	assert.True(t, fwd < pitchLimit(40) && back > pitchLimit(40))
+	for _, tc := range []struct {
+		pitch   float64
+		clamped bool
+	}{
+		{fwd - 0.1, false},
+		{fwd + 0.1, true},
+		{-(back - 0.1), false},
+		{-(back + 0.1), true},
+	} {
+		pose := math3d.Pose{Position: math3d.Vector3{Y: 40}, Pitch: tc.pitch}
+		_, clamped := g.clamp(pose, math3d.ZeroVector3, fitGround(feet, [6]bool{}), feet)
+		assert.Equal(t, tc.clamped, clamped, "at %v", tc.pitch)
+	}
+
+	// Even level, it's lower than that at the front, so it's raised to clear
+	// it. Pitching backwards would do, but pitch is never added.
+	pose := math3d.Pose{Position: math3d.Vector3{Y: front - 2}}
+	got, clamped := g.clamp(pose, math3d.ZeroVector3, fitGround(feet, [6]bool{}), feet)
+	assert.True(t, clamped)
+	assert.InDelta(t, front, got.Position.Y, 1e-6)
+	assert.Equal(t, 0.0, got.Pitch)
+
+	// Sloped sideways, with a limit on the bank from the width instead.
+	gr = ground{a: -0.05}
+	feet = homeFeet(gr)
+	limit := slopeLimit(40, g.cfg.Width/2, -gr.a)
+	for _, tc := range []struct {
+		bank    float64
+		clamped bool
+	}{
+		{limit - 0.1, false},
+		{limit + 0.1, true},
+	} {
+
+		// Banking lowers the left side (X<0), which is where it's higher.
+		pose := math3d.Pose{Position: math3d.Vector3{Y: 40}, Bank: tc.bank}
+		_, clamped := g.clamp(pose, math3d.ZeroVector3, fitGround(feet, [6]bool{}), feet)
+		assert.Equal(t, tc.clamped, clamped, "at %v", tc.bank)
+	}
+}
+
+func TestGuardFeet(t *testing.T) {
+	g := &guard{cfg: config.Default().Legs.Chassis}
+	m := g.cfg.FootMargin
+
+	// A foot raised right under the belly, just outside and inside the margin.
+	for _, tc := range []struct {
+		y       float64
+		clamped bool
+	}{
+		{25 - m - 0.1, false},
+		{25 - m + 0.1, true},
+	} {
+		feet := homeFeet(ground{})
+		feet[0] = math3d.Vector3{Y: tc.y}
+		pose := math3d.Pose{Position: math3d.Vector3{Y: 25}}
+		got, clamped := g.clamp(pose, math3d.ZeroVector3, ground{}, feet)
+		assert.Equal(t, tc.clamped, clamped, "at %v", tc.y)
+
+		// Which is fixed by raising the body.
+		assert.InDelta(t, math.Max(25, tc.y+m), got.Position.Y, 1e-6)
+		assert.True(t, g.clear(got, math3d.ZeroVector3, ground{}, feet))
+	}
+
+	// And by reducing the pitch, for one near the front.
+	feet := homeFeet(ground{})
+	feet[0] = math3d.Vector3{Z: g.cfg.Length/2 + m/2, Y: 5}
+	pose := math3d.Pose{Position: math3d.Vector3{Y: 25}, Pitch: 8}
+	got, clamped := g.clamp(pose, math3d.ZeroVector3, fitGround(feet, [6]bool{true}), feet)
+	assert.True(t, clamped)
+	assert.True(t, got.Pitch > 0 && got.Pitch < pose.Pitch, "%v", got.Pitch)
+	assert.Equal(t, 25.0, got.Position.Y)
+}
+
+func TestGuardAim(t *testing.T) {
+	l := &Legs{guard: guard{cfg: config.Default().Legs.Chassis}}
+	l.feet = homeFeet(ground{})
+	state := &hexapod.State{}
+
+	aim := func(now time.Time, pitch float64) math3d.Pose {
+		a := math3d.Pose{Position: math3d.Vector3{Y: 25, Z: 500}, Pitch: pitch}
+		l.guardAim(now, state, &a)
+		return a
+	}
+
+	// Only the clearance, pitch, and bank are clamped, not the target.
+	now := time.Unix(0, 0)
+	a := aim(now, 30)
+	assert.InDelta(t, pitchLimit(25), a.Pitch, 0.02)
+	assert.Equal(t, 500.0, a.Position.Z)
+
+	// It's published when it starts clamping, but not again until a while
+	// later, however often it comes and goes.
+	for i := 0; i < 20; i++ {
+		now = now.Add(time.Second / 2)
+		aim(now, float64(30*(i%2)))
+	}
+
+	if ev := state.Published(); assert.Len(t, ev, 2) {
+		assert.Equal(t, hexapod.EventChassisClamped, ev[0].Name)
+		assert.Equal(t, hexapod.Warning, ev[0].Severity)
+	}
+
+	// Disabled without a size.
+	l.guard = guard{}
+	assert.Equal(t, 30.0, aim(now, 30).Pitch)
+}
@@ -5,12 +5,15 @@ import (
 	"math"
 	"time"
 
-	"github.com/Sirupsen/logrus"
 	"github.com/adammck/dynamixel/network"
 	"github.com/adammck/dynamixel/servo"
 	"github.com/adammck/hexapod"
 	"github.com/adammck/hexapod/components/legs/gait"
+	"github.com/adammck/hexapod/config"
 	"github.com/adammck/hexapod/math3d"
+	"github.com/adammck/hexapod/params"
+	"github.com/adammck/hexapod/servos"
+	"github.com/adammck/hexapod/utils"
 )
 
 type State string
@@ -20,54 +23,18 @@ const (
 	sStandUp  State = "sStandUp"
 	sSitDown  State = "sSitDown"
 	sStepping State = "sStepping"
-
-	moveSpeedSlow   = 512
-	torqueLimitSlow = 256
-
-	moveSpeedFast   = 1023
-	torqueLimitFast = 1023
-
-	// The distance (in mm) to adjust the Y position to meet the Y target each
-	// tick. This mostly controls the time it takes to stand up and sit down.
-	yMoveSpeed = 1
-
-	bankMoveSpeed  = 0.5
-	pitchMoveSpeed = 0.5
-
-	// Distance (on the X/Z axis) from the origin to the point at which the feet
-	// should be positioned. This isn't adjustable at runtime, because there are
-	// very few valid settings.
-	stepRadius = 240.0
-
-	// The number of ticks per step, i.e. a single foot is lifted, moved to its
-	// new position, and put down.
-	baseTicksPerStep = 20
-
-	// The minimum number of ticks allowed per step.
-	minTicksPerStep = 4
-
-	// The maximum number of ticks allowed per step.
-	maxTicksPerStep = 80
-
-	// The offset (on the Y axis) which feet should be moved to on the up step,
-	// relative to the origin.
-	stepHeight = 40.0
-
-	// Minimum distance which the desired foot position should be from its
-	// actual position before a step should be taken to correct it.
-	minStepDistance = 20.0
-
-	// Minimum distance to turn (heading), before making a step.
-	minTurnDistance = 5.0
-
-	// The distance (in mm) which the hex can move per step cycle. This should
-	// be determined experimentally; too high and the legs get tangled up.
-	maxStepDistance = 90.0
 )
 
 type Legs struct {
 	Network *network.Network
 
+	// The registry to register the params with at boot. This is params.Default
+	// unless changed, so more than one instance can be booted (e.g. in tests).
+	Params *params.Registry
+
+	cfg     config.Legs
+	gaitCfg config.Gait
+
 	// The state that the legs are currently in.
 	State        State
 	stateCounter int
@@ -75,14 +42,57 @@ type Legs struct {
 
 	Gait gait.Gait
 
+	// The name and ticks per step which Gait was made with, so it's only made
+	// again when they change.
+	gaitName string
+	gaitTPS  int
+
+	// The ticks per step which the speed calls for, which is also gaitTPS
+	// unless the cadence is locked to a tempo.
+	naturalTPS int
+
+	// Gaits which were made with the same name, by ticks per step. The cadence
+	// alternates between two, so they aren't made every cycle.
+	gaits map[int]gait.Gait
+
+	// The tempo (in beats per minute) to step in time with, or zero for none.
+	// This is a tunable param; see Boot.
+	bpm     float64
+	cadence cadence
+
+	// The gait params which the current step cycle was started with, and those
+	// which the next will be. These are tunable params (see Boot), which are
+	// written to pending, and only take effect between cycles, so changing
+	// them mid-step can't make the feet jump.
+	tuning  tuning
+	pending tuning
+
+	// The tuning which the current step cycle actually uses, which is the
+	// above, adjusted for the gait, and whether that's the crouch gait. See
+	// stepTuning.
+	step      tuning
+	crouching bool
+
 	// ???
 	Legs [6]*Leg
 
-	// Defaults to false, and set to true by the goroutine started by Boot once
+	// Whether the feet have been sent to their home positions, which happens
+	// on the first tick that the startup check allows. See start.
+	started bool
+
+	// Defaults to false, and set to true by the goroutine started by start once
 	// the feet have reached the home position and are ready to start the main
 	// tick loop.
 	ready bool
 
+	// Whether the hex was fallen (or being righted) as of the last tick, so the
+	// legs stand up again once it's the right way up. See restand.
+	fallen bool
+
+	// Whether the legs were folded for transport as of the last tick, so they
+	// stand up again once they've been unfolded. See restand.
+	transported bool
+
 	// The pose (copied from the state) at the start of the current step cycle.
 	// We use this to calculate the pose for each intra-cycle frame.
 	lastPose math3d.Pose
@@ -104,42 +114,159 @@ type Legs struct {
 	// World positions of the NEXT foot position. These are nil if we're okay
 	// with where the foot is now, but are set if the foot should be relocated.
 	nextFeet [6]math3d.Vector3
+
+	// The goal positions of every servo, which are sent in a single packet at
+	// the end of each tick. This is kept to avoid allocating every tick.
+	goals *servos.SyncWrite
+
+	// Whether the current step cycle is moving, rather than standing still.
+	walking bool
+
+	// When to rest, after standing still for a while.
+	idle idle
+
+	// Moves the feet to their new home positions while parked, if the step
+	// radius changes, or to re-centre them under the body.
+	stance stance
+
+	// Keeps the current within the torque budget, by reducing the torque of
+	// the legs in the air, and slowing the gait.
+	budget budget
+
+	// Whether each leg is in the air on the current tick, and the torque limit
+	// which was most recently written to its servos because of that, or zero
+	// if it was left to whatever else sets it.
+	swing       [6]bool
+	swingTorque [6]int
+
+	// Whether the LEDs of each leg were most recently written on, for the
+	// debug LED mode. See updateLEDs.
+	leds [6]bool
+
+	// The index of the joint whose present position is read next, for the
+	// feedback, and the angle of each joint of each leg as of when it was last
+	// read, and whether it has been since that leg's foot was put down. See
+	// readFeedback.
+	feedback int
+	angles   [6][4]float64
+	read     [6][4]bool
+
+	// The compliance preset of the servos, which is switched between steps.
+	stiffness stiffness
+
+	// Clocks the step cycle, which can be paused and stepped through, for
+	// debugging.
+	stepper stepper
+
+	// Keeps the chassis out of the ground, and off the feet.
+	guard guard
+
+	// The ground which the feet are standing on, as of the last tick, and the
+	// number of feet which it was fitted to.
+	ground     ground
+	groundFeet int
+
+	// Scales down the lean while the stance is narrow.
+	lean lean
+
+	// Remembers the height of the ground under each foot.
+	terrain terrain
+
+	// Counts the steps and the time that the servos are holding the legs up.
+	usage usage
+}
+
+// layout is where each leg is attached to the chassis, and the IDs of its
+// servos (from baseID+1 at the coxa). The leg origins are relative to the
+// hexapod origin, which is the X/Z center of the body, level with the bottom
+// of the coxas (which protrude slightly below the body) on the Y axis.
+//
+// Note that the angles are the direction in which the leg is pointing, NOT the
+// angle between the hex and leg origins.
+var layout = [6]struct {
+	baseID int
+	name   string
+	origin math3d.Vector3
+	angle  float64
+}{
+	{40, "FL", math3d.Vector3{X: -61.167, Y: 24, Z: 98}, 300}, // Front Left  - 0
+	{50, "FR", math3d.Vector3{X: 61.167, Y: 24, Z: 98}, 60},   // Front Right - 1
+	{60, "MR", math3d.Vector3{X: 81, Y: 24, Z: 0}, 90},        // Mid Right   - 2
+	{10, "BR", math3d.Vector3{X: 61.167, Y: 24, Z: -98}, 120}, // Back Right  - 3
+	{20, "BL", math3d.Vector3{X: -61.167, Y: 24, Z: -98}, 240}, // Back Left   - 4
+	{30, "ML", math3d.Vector3{X: -81, Y: 24, Z: 0}, 270},      // Mid Left    - 5
 }
 
-var log = logrus.WithFields(logrus.Fields{
-	"pkg": "legs",
-})
+// tuning is the gait params which can be changed at runtime. See Boot.
+type tuning struct {
+
+	// The ticks per step at speed zero.
+	ticksPerStep int
+
+	// The fraction of the cycle which each foot spends on the ground, or zero
+	// for the gait's own. See gait.Make.
+	dutyFactor float64
+
+	// The offset (on the Y axis) which feet are lifted to on the up step.
+	stepHeight float64
+
+	// The distance from the center of the body to each foot's home position,
+	// and the same for each leg, in the same order as Legs, or zero for the
+	// former. See radius.
+	stepRadius float64
+	stepRadii  [6]float64
+}
+
+// radius returns the step radius of the given leg.
+func (t tuning) radius(i int) float64 {
+	if t.stepRadii[i] > 0 {
+		return t.stepRadii[i]
+	}
+
+	return t.stepRadius
+}
+
+var log = hexapod.NewLog("legs")
+
+func New(n *network.Network, cfg config.Legs, gaitCfg config.Gait) *Legs {
+	t := tuning{
+		ticksPerStep: gaitCfg.BaseTicksPerStep,
+		dutyFactor:   gaitCfg.DutyFactor,
+		stepHeight:   cfg.StepHeight,
+		stepRadius:   cfg.StepRadius,
+	}
+	copy(t.stepRadii[:], cfg.StepRadii)
 
-func New(n *network.Network) *Legs {
 	l := &Legs{
 		Network: n,
-		Legs: [6]*Leg{
-
-			// Leg origins are relative to the hexapod origin, which is the X/Z
-			// center of the body, level with the bottom of the coxas (which
-			// protrude slightly below the body) on the Y axis.
-			//
-			// Note that the angles are the direction in which the leg is
-			// pointing, NOT the angle between the hex and leg origins.
-			//
-			NewLeg(n, 40, "FL", math3d.MakeVector3(-61.167, 24, 98), 300),  // Front Left  - 0
-			NewLeg(n, 50, "FR", math3d.MakeVector3(61.167, 24, 98), 60),    // Front Right - 1
-			NewLeg(n, 60, "MR", math3d.MakeVector3(81, 24, 0), 90),         // Mid Right   - 2
-			NewLeg(n, 10, "BR", math3d.MakeVector3(61.167, 24, -98), 120),  // Back Right  - 3
-			NewLeg(n, 20, "BL", math3d.MakeVector3(-61.167, 24, -98), 240), // Back Left   - 4
-			NewLeg(n, 30, "ML", math3d.MakeVector3(-81, 24, 0), 270),       // Mid Left    - 5
-		},
+		Params:  params.Default,
+		cfg:     cfg,
+		gaitCfg: gaitCfg,
+		tuning:  t,
+		pending: t,
+		step:    t,
+		bpm:     gaitCfg.BPM,
+		goals:   servos.NewGoalPositions(),
+		idle:    newIdle(cfg),
+		budget:  budget{cfg: cfg.Budget},
+
+		stiffness: newStiffness(cfg.Stiffness),
+		stepper:   stepper{enabled: gaitCfg.Debug},
+		guard:     guard{cfg: cfg.Chassis},
+		lean:      lean{cfg: cfg.Lean},
+		terrain:   terrain{cfg: cfg.Terrain},
 	}
 
-	// Initialize each foot to its home position. This will be written to the
-	// servos during boot.
-	l.feet = [6]math3d.Vector3{
-		l.homeFootPosition(&math3d.ZeroVector3, l.Legs[0], math3d.Pose{}),
-		l.homeFootPosition(&math3d.ZeroVector3, l.Legs[1], math3d.Pose{}),
-		l.homeFootPosition(&math3d.ZeroVector3, l.Legs[2], math3d.Pose{}),
-		l.homeFootPosition(&math3d.ZeroVector3, l.Legs[3], math3d.Pose{}),
-		l.homeFootPosition(&math3d.ZeroVector3, l.Legs[4], math3d.Pose{}),
-		l.homeFootPosition(&math3d.ZeroVector3, l.Legs[5], math3d.Pose{}),
+	for i, p := range layout {
+		origin := p.origin
+		l.Legs[i] = NewLeg(n, p.baseID, p.name, &origin, p.angle)
+	}
+
+	// Initialize each foot to its home position. This is written to the
+	// servos on the first tick which the startup check allows. See start.
+	for i := range l.Legs {
+		l.feet[i] = l.homeFootPosition(&math3d.ZeroVector3, i, math3d.Pose{})
+		l.stance.placed[i] = t.radius(i)
 	}
 
 	// Reset the state, to set the timer.
@@ -148,12 +275,120 @@ func New(n *network.Network) *Legs {
 	return l
 }
 
-func (l *Legs) makeGait(index, speed int) error {
-	idx := (index % 3) + 1
-	tps := clamp(minTicksPerStep, maxTicksPerStep, baseTicksPerStep-(speed*2))
-	log.Infof("Gait: index=%d, tps=%d", idx, tps)
-	l.Gait = gait.TheGait(idx, tps)
-	return nil
+// gaitStatus returns where the legs are in the step cycle, on a tick which has
+// just played the frame before the state counter, at the given FPS.
+func (l *Legs) gaitStatus(fps int) hexapod.GaitStatus {
+	s := hexapod.GaitStatus{
+		Walking: true,
+		Phase:   float64(l.stateCounter-1) / float64(l.Gait.Length()),
+		Swing:   l.swing,
+		Landing: l.nextFeet,
+	}
+
+	if l.gaitTPS > 0 {
+		s.NextPhaseTicks = (l.gaitTPS - l.stateCounter%l.gaitTPS) % l.gaitTPS
+	}
+
+	if fps > 0 {
+		s.NextPhase = time.Duration(s.NextPhaseTicks) * time.Second / time.Duration(fps)
+	}
+
+	return s
+}
+
+// makeGait makes the state's active gait, unless it was already made with the
+// same speed. If it can't be made (e.g. because another package registered it),
+// the previous gait is kept, or the wave gait is used if there isn't one yet.
+func (l *Legs) makeGait(state *hexapod.State) {
+	name := gait.Wave
+	if g, ok := state.ActiveGait(); ok {
+		name = g.Name
+	}
+
+	min, max := l.gaitCfg.MinTicksPerStep, l.gaitCfg.MaxTicksPerStep
+	tps := clamp(min, max, l.step.ticksPerStep-(state.Speed*2)+l.budget.slowTicks(state))
+	l.naturalTPS = tps
+
+	if l.bpm > 0 {
+		if p, ok := beatPeriod(l.bpm, state.FPS, tps, min, max); ok {
+			tps = l.cadence.ticks(p, gait.Steps(name), min, max)
+		} else {
+			log.RateLimited("bpm", 5*time.Second).Warnf("can't step in time with %v bpm at %d-%d ticks per step", l.bpm, min, max)
+		}
+	}
+
+	if l.Gait.Length() > 0 && name == l.gaitName && tps == l.gaitTPS {
+		return
+	}
+
+	if name != l.gaitName {
+		l.gaits = map[int]gait.Gait{}
+	}
+
+	if g, ok := l.gaits[tps]; ok {
+		l.Gait = g
+		l.gaitTPS = tps
+		return
+	}
+
+	g, err := gait.Make(name, tps, l.step.dutyFactor)
+	if err != nil {
+		log.RateLimited("gait", 5*time.Second).Warnf("%s (while making gait)", err)
+		if l.Gait.Length() > 0 {
+			return
+		}
+
+		name = gait.Wave
+		g, _ = gait.Make(name, tps, l.step.dutyFactor)
+		l.gaits = map[int]gait.Gait{}
+	}
+
+	log.Infof("Gait: %s, tps=%d", name, tps)
+	l.Gait = g
+	l.gaitName = name
+	l.gaitTPS = tps
+	l.gaits[tps] = g
+}
+
+// latch applies any changes to the params at the start of a step cycle, and
+// copies them to the state.
+func (l *Legs) latch(state *hexapod.State) {
+	if l.pending != l.tuning {
+		for _, c := range []struct {
+			name     string
+			old, new float64
+		}{
+			{"gait.base_ticks_per_step", float64(l.tuning.ticksPerStep), float64(l.pending.ticksPerStep)},
+			{"gait.duty_factor", l.tuning.dutyFactor, l.pending.dutyFactor},
+			{"legs.step_height", l.tuning.stepHeight, l.pending.stepHeight},
+			{"legs.step_radius", l.tuning.stepRadius, l.pending.stepRadius},
+		} {
+			if c.old != c.new {
+				log.Infof("%s changed from %v to %v", c.name, c.old, c.new)
+			}
+		}
+
+		for i, leg := range l.Legs {
+			if old, new := l.tuning.stepRadii[i], l.pending.stepRadii[i]; old != new {
+				log.Infof("%s changed from %v to %v", radiusParam(leg), old, new)
+			}
+		}
+
+		// The gaits which were already made have the old duty factor.
+		if l.pending.dutyFactor != l.tuning.dutyFactor {
+			l.gaitName = ""
+		}
+
+		l.tuning = l.pending
+	}
+
+	l.step = l.stepTuning(state)
+	state.GaitParams = hexapod.GaitParams{
+		TicksPerStep: l.step.ticksPerStep,
+		DutyFactor:   l.step.dutyFactor,
+		StepHeight:   l.step.stepHeight,
+		StepRadius:   l.step.stepRadius,
+	}
 }
 
 func (l *Legs) distanceFromHome() (float64, error) {
@@ -206,32 +441,128 @@ func (l *Legs) waitForReady() {
 	l.ready = true
 }
 
+// Boot registers the params, with the same ranges as the config allows. The
+// values of the gait params are those which the next step cycle will use,
+// which are only applied once the current one has finished. See latch.
+//
 // TODO: Maybe provide State to boot, in case we have an initial pose? We're
 //       using the zero value now, which seems like a shaky assumption.
 func (l *Legs) Boot() error {
+	p := &l.pending
+	for _, param := range []params.Param{
+		{
+			Name: "legs.step_height",
+			Type: params.Float,
+			Min:  0,
+			Max:  80,
+			Step: 5,
+			Get:  func() float64 { return p.stepHeight },
+			Set:  func(v float64) { p.stepHeight = v },
+		},
+		{
+			Name: "legs.step_radius",
+			Type: params.Float,
+			Min:  100,
+			Max:  400,
+			Step: 10,
+			Get:  func() float64 { return p.stepRadius },
+			Set:  func(v float64) { p.stepRadius = v },
+		},
+		{
+			Name: "gait.base_ticks_per_step",
+			Type: params.Int,
+			Min:  float64(l.gaitCfg.MinTicksPerStep),
+			Max:  float64(l.gaitCfg.MaxTicksPerStep),
+			Get:  func() float64 { return float64(p.ticksPerStep) },
+			Set:  func(v float64) { p.ticksPerStep = int(v) },
+		},
+		{
+			Name: "gait.duty_factor",
+			Type: params.Float,
+			Min:  0,
+			Max:  0.9,
+			Step: 0.05,
+			Get:  func() float64 { return p.dutyFactor },
+			Set:  func(v float64) { p.dutyFactor = v },
+		},
+		{
+			Name: "gait.bpm",
+			Type: params.Float,
+			Min:  0,
+			Max:  300,
+			Step: 1,
+			Get:  func() float64 { return l.bpm },
+			Set:  func(v float64) { l.bpm = v },
+		},
+	} {
+		err := l.Params.Register(param)
+		if err != nil {
+			return err
+		}
+	}
+
+	// The step radius of each leg, or zero for legs.step_radius.
+	for i, leg := range l.Legs {
+		i := i
+		err := l.Params.Register(params.Param{
+			Name: radiusParam(leg),
+			Type: params.Float,
+			Min:  0,
+			Max:  400,
+			Get:  func() float64 { return p.stepRadii[i] },
+			Set:  func(v float64) { p.stepRadii[i] = v },
+		})
+		if err != nil {
+			return err
+		}
+	}
 
 	// Set all servos slow.
 	for _, s := range l.Servos() {
 
-		err := s.SetMovingSpeed(moveSpeedSlow)
+		err := s.SetMovingSpeed(l.cfg.MoveSpeedSlow)
 		if err != nil {
 			return fmt.Errorf("%s (while setting move speed)", err)
 		}
 
-		err = s.SetTorqueLimit(torqueLimitSlow)
+		err = s.SetTorqueLimit(l.cfg.TorqueLimitSlow)
 		if err != nil {
 			return fmt.Errorf("%s (while setting torque limit)", err)
 		}
 	}
 
-	// Set the target for each foot to its home position. This is buffered, and
-	// will be executed once all Boot methods have been called.
+	err := l.bootLEDs()
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// start sets the goal of each foot to its home position, and waits (in another
+// goroutine) for them to get there, before standing up. The goals are buffered,
+// and will be executed at the end of the tick.
+func (l *Legs) start() {
+	l.started = true
+
 	for i, leg := range l.Legs {
 		leg.SetGoal(l.feet[i])
 	}
 
 	go l.waitForReady()
-	return nil
+}
+
+// Essential returns true, because the hexapod can't do much without legs. As
+// such, the core will try to sit down and shut down if the legs panic.
+func (l *Legs) Essential() bool {
+	return true
+}
+
+// Writes returns hexapod.Estimator, since the legs move the chassis, so know
+// where it is, and hexapod.Commander, since they clear the offset once they've
+// re-centred the feet under it. See recentre.
+func (l *Legs) Writes() hexapod.Role {
+	return hexapod.Estimator | hexapod.Commander
 }
 
 func (l *Legs) Servos() []*servo.Servo {
@@ -253,33 +584,140 @@ func (l *Legs) SetState(s State) {
 	l.State = s
 }
 
+// restand puts the feet back at their home positions, with the chassis on the
+// ground where it is now, and stands up again, like after booting. This is for
+// after the hex has been righted or unfolded, so the legs are wherever that
+// left them, and the step cycle was cut short.
+func (l *Legs) restand(state *hexapod.State) {
+	state.Pose.Position.Y = 0
+	state.Pose.Pitch = 0
+	state.Pose.Bank = 0
+	for i := range l.Legs {
+		l.feet[i] = l.homeFootPosition(&state.Offset, i, state.Pose)
+	}
+
+	l.ground = ground{}
+	l.groundFeet = 0
+	l.read = [6][4]bool{}
+	l.terrain.reset()
+	l.walking = false
+	l.SetState(sDefault)
+}
+
 // homeFootPosition returns a vector in the WORLD coordinate space for the home
-// position of the given leg.
-func (l *Legs) homeFootPosition(offset *math3d.Vector3, leg *Leg, pose math3d.Pose) math3d.Vector3 {
+// position of the leg at the given index.
+func (l *Legs) homeFootPosition(offset *math3d.Vector3, i int, pose math3d.Pose) math3d.Vector3 {
+	return homePosition(offset, l.Legs[i], pose, l.step.radius(i))
+}
+
+// homePosition returns the home position of the given leg, in the world space,
+// with the chassis at the given pose, and the feet at the given step radius.
+func homePosition(offset *math3d.Vector3, leg *Leg, pose math3d.Pose, radius float64) math3d.Vector3 {
 	hyp := math.Sqrt((leg.Origin.X * leg.Origin.X) + (leg.Origin.Z * leg.Origin.Z))
-	v := pose.Add(math3d.Pose{*offset, 0, 0, 0}).Add(math3d.Pose{math3d.Vector3{0, 0, 10}, 0, 0, 0}).Add(math3d.Pose{*leg.Origin, leg.Angle, 0, 0}).Add(math3d.Pose{math3d.Vector3{0, 0, stepRadius - hyp}, 0, 0, 0}).Position
+	v := pose.Add(math3d.Pose{*offset, 0, 0, 0}).Add(math3d.Pose{math3d.Vector3{0, 0, 10}, 0, 0, 0}).Add(math3d.Pose{*leg.Origin, leg.Angle, 0, 0}).Add(math3d.Pose{math3d.Vector3{0, 0, radius - hyp}, 0, 0, 0}).Position
 	v.Y = 0.0
 	return v
 }
 
 func (l *Legs) Tick(now time.Time, state *hexapod.State) error {
-	l.stateCounter += 1
+
+	// Leave the servos alone while they're being calibrated, since writing a
+	// goal position re-enables the torque, or tested, since the self-test is
+	// moving them. These are only possible while parked, so there's no step
+	// cycle to interrupt.
+	if state.Calibrating || state.SelfTesting {
+		l.usage.pause()
+		return nil
+	}
+
+	// Leave them alone while the hex is on its back too, where walking would
+	// only flail, and the righting might be rolling it over. Unless it's
+	// shutting down, in which case sit down as usual, whichever way up.
+	if (state.Fallen || state.Righting) && !state.Shutdown {
+		l.fallen = true
+		l.usage.pause()
+		return nil
+	}
+
+	if l.fallen {
+		l.fallen = false
+		if l.ready {
+			log.Info("the right way up, standing up again")
+			l.restand(state)
+		}
+	}
+
+	// And while the legs are folded for transport, from when the transport
+	// starts folding them until it's unfolded them again. The servos are limp
+	// in between, so the legs could be anywhere.
+	if state.Transport.OwnsLegs() {
+		l.transported = true
+		l.usage.pause()
+		return nil
+	}
+
+	if l.transported {
+		l.transported = false
+		if l.ready {
+			log.Info("unfolded, standing up again")
+			l.restand(state)
+		}
+	}
+
+	// Don't even put the feet down until the startup check says that the hex
+	// is on its feet, since it would only flail on its side or back. The servos
+	// are left limp meanwhile, even if it's shutting down, since there's
+	// nothing to sit down from.
+	if !l.started {
+		if state.StartupHold {
+			l.usage.pause()
+			return nil
+		}
+
+		l.start()
+	}
+
+	// A paused gait holds the step cycle where it is, so the feet stay put, but
+	// shutting down always carries on, so the hex can sit down.
+	l.updateStepper(state)
+	held := l.State == sStepping && !state.Shutdown && !l.stepper.advance()
+	if !held {
+		l.stateCounter += 1
+	}
 
 	if !l.ready {
+		l.usage.pause()
 		return nil
 	}
 
+	l.budget.update(now, state)
+	l.swing = [6]bool{}
+
+	// The target is a command, so isn't ours to change. This is where the
+	// chassis should actually go, which is the target unless sitting down,
+	// with the clearance above the ground rather than Y=0.
+	aim := state.Target
+	aim.Position.Y += l.floor(state)
+	stepping := l.State == sStepping
+
+	// Where the legs are in the step cycle, which stays where it was while
+	// the gait is held, and is zero unless it's played below.
+	var status hexapod.GaitStatus
+	if held {
+		status = state.GaitStatus
+	}
+
 	// TODO: Remove the state machine altogether? The first two are just waiting
 	//       for the pose to converge with target, which the third also does.
 	switch l.State {
 	case sDefault:
 		for _, s := range l.Servos() {
-			err := s.SetMovingSpeed(moveSpeedFast)
+			err := s.SetMovingSpeed(l.cfg.MoveSpeedFast)
 			if err != nil {
 				return fmt.Errorf("%s (while setting move speed)", err)
 			}
 
-			err = s.SetTorqueLimit(torqueLimitFast)
+			err = s.SetTorqueLimit(l.cfg.TorqueLimitFast)
 			if err != nil {
 				return fmt.Errorf("%s (while setting torque limit)", err)
 			}
@@ -295,30 +733,59 @@ func (l *Legs) Tick(now time.Time, state *hexapod.State) error {
 			break
 		}
 
-		yOffset := (state.Target.Position.Y - state.Pose.Position.Y)
+		yOffset := (aim.Position.Y - state.Pose.Position.Y)
 		if math.Abs(yOffset) < 1 {
+			l.target = state.Pose
 			l.SetState(sStepping)
 		}
 
-	// While in the sitdown state, force the target Y position to zero and wait
-	// for the position to meet it before halting. Don't check state.Shutdown,
-	// because we're already on the way.
+	// While in the sitdown state, aim for the ground (whatever the target
+	// says) and wait for the position to meet it before halting. Don't check
+	// state.Shutdown, because we're already on the way.
 	case sSitDown:
-		state.Target.Position.Y = 0
-		state.Target.Bank = 0
-		state.Target.Pitch = 0
+		aim.Position.Y = l.floor(state)
+		aim.Bank = 0
+		aim.Pitch = 0
 
-		yOffset := (state.Target.Position.Y - state.Pose.Position.Y)
+		yOffset := (aim.Position.Y - state.Pose.Position.Y)
 		if math.Abs(yOffset) < 1 {
 			l.ready = false
 		}
 
 	case sStepping:
 
+		// While the gait is paused, hold the pose where it was paused, for the
+		// same reason as while parked (see below). Between cycles, that's
+		// where the last one ended.
+		if held {
+			r := 1.0
+			if l.stateCounter > 0 {
+				r = float64(l.stateCounter) / float64(l.Gait.Length())
+			}
+
+			p := l.lastPose.Interpolate(l.target, r)
+			state.Pose.Position.X = p.Position.X
+			state.Pose.Position.Z = p.Position.Z
+			state.Pose.Heading = math3d.WrapDegrees(p.Heading)
+			break
+		}
+
 		// If this is the first tick in a step cycle, calculate the next target
 		// position, which is simply the move distance in the direction of the
 		// actual target position (which may be further away).
 		if l.stateCounter == 1 {
+			l.latch(state)
+
+			// While parked, the pose is held where the last step ended (see
+			// below), so start from there, rather than from wherever an
+			// estimate which lags behind has put it since. Otherwise moving
+			// the chassis over the feet (e.g. by changing the offset) looks
+			// like the hex has moved, and it takes a step to get back.
+			if !l.walking {
+				state.Pose.Position.X = l.target.Position.X
+				state.Pose.Position.Z = l.target.Position.Z
+				state.Pose.Heading = l.target.Heading
+			}
 
 			// Record current state
 			l.lastPose = state.Pose
@@ -329,23 +796,38 @@ func (l *Legs) Tick(now time.Time, state *hexapod.State) error {
 			// Ignore Y axis for target and pose; we take care of that below.
 			// TODO: Fix this ugly mess.
 			xzPosePos := state.Pose.Position
-			xzTargetPos := state.Target.Position
+			xzTargetPos := aim.Position
 			xzPosePos.Y = 0
 			xzTargetPos.Y = 0
 
 			vecToGoal := xzTargetPos.Subtract(xzPosePos)
 			distToGoal := vecToGoal.Magnitude()
 
-			// Cap the distance we wil (attempt to) step at the max.
-			distToStep := math.Min(distToGoal, maxStepDistance)
+			// Cap the distance we wil (attempt to) step at the max, or less if
+			// any foot would leave its workspace before then.
+			maxStep := l.maxStep(state, vecToGoal)
+			distToStep := math.Min(distToGoal, maxStep)
 
 			// If the target position is closer than the minimum, or the heading
 			// is close enough, we're finished. This is the end of the idle loop
-			// when the machine is standing still.
-			if distToStep < minStepDistance && math.Abs(state.Target.Heading-state.Pose.Heading) < minTurnDistance {
-				l.target = l.lastPose
+			// when the machine is standing still. A foot which is being moved
+			// to a new stance is put down before stepping (or sitting down).
+			parked := distToStep < l.cfg.MinStepDistance && math.Abs(math3d.AngleDiff(aim.Heading, state.Pose.Heading)) < l.cfg.MinTurnDistance
+			if parked || l.stance.moving {
+				l.walking = false
 				//log.Infof("not stepping")
-				if state.Shutdown {
+
+				// Hold the pose where the last step ended, since that's what
+				// the feet were placed around. Otherwise an estimate which lags
+				// behind (like the simulator's) moves the feet, which moves the
+				// estimate further, and so on.
+				state.Pose.Position.X = l.target.Position.X
+				state.Pose.Position.Z = l.target.Position.Z
+				state.Pose.Heading = l.target.Heading
+
+				l.recentre(now, state, parked)
+				moving := l.reposition(state, parked && !state.Shutdown)
+				if state.Shutdown && !moving {
 					l.SetState(sSitDown)
 				} else {
 					l.SetState(sStepping)
@@ -354,19 +836,33 @@ func (l *Legs) Tick(now time.Time, state *hexapod.State) error {
 			}
 
 			// Generate the gait for this step cycle, in case this is the first
-			// step since boot, or the gait index has changed since last time.
-			l.makeGait(state.GaitIndex, state.Speed)
+			// step since boot, or the gait has changed since last time.
+			l.recentre(now, state, false)
+			l.walking = true
+			l.makeGait(state)
+
+			// Keep the speed when the cadence is locked to a tempo, by taking
+			// longer (or shorter) strides at the slower (or faster) pace.
+			if l.gaitTPS != l.naturalTPS {
+				distToStep = stride(distToStep, l.naturalTPS, l.gaitTPS, maxStep)
+			}
 
 			// Calculate the target position for the origin.
 			vecToStep := vecToGoal.Unit().MultiplyByScalar(distToStep)
 			l.target.Position = *l.lastPose.Position.Add(vecToStep)
-			l.target.Heading = state.Target.Heading
+			l.target.Heading = aim.Heading
 			log.Infof("stepping from %v to %v", l.lastPose, l.target)
 
+			// The feet sweep an arc while turning, which can slip as much
+			// as walking the same distance.
+			arc := utils.Rad(math.Abs(math3d.AngleDiff(l.target.Heading, l.lastPose.Heading))) * l.step.stepRadius
+			state.Drift += (distToStep + arc) * l.cfg.DriftRate
+
 			// Calculate the target position for each foot. Might be where they
 			// already are, if we're not stepping.
-			for i, leg := range l.Legs {
-				l.nextFeet[i] = l.homeFootPosition(&state.Offset, leg, l.target)
+			for i := range l.Legs {
+				l.nextFeet[i] = l.homeFootPosition(&state.Offset, i, l.target)
+				l.stance.placed[i] = l.step.radius(i)
 			}
 		}
 
@@ -374,18 +870,20 @@ func (l *Legs) Tick(now time.Time, state *hexapod.State) error {
 		// rotation (for now), so the hex will walk sideways or backwards if the
 		// target happens to be in that direction.
 		r := float64(l.stateCounter) / float64(l.Gait.Length())
-		v := l.target.Position.Subtract(l.lastPose.Position)
-		rr := l.target.Heading - l.lastPose.Heading
+		p := l.lastPose.Interpolate(l.target, r)
 
-		// Ignore Y axis; we set that below, without tweening.
+		// Ignore Y axis; we set that below, without tweening. Same for the
+		// pitch and bank.
 		y := state.Pose.Position.Y
-		state.Pose.Position = *l.lastPose.Position.Add(v.MultiplyByScalar(r))
+		state.Pose.Position = p.Position
 		state.Pose.Position.Y = y
 
-		state.Pose.Heading = l.lastPose.Heading + (r * rr)
+		// The tween itself isn't wrapped, so it's continuous across 180.
+		state.Pose.Heading = math3d.WrapDegrees(p.Heading)
 
 		// Update the Y goal (distance from ground) of each foot according to
-		// the precomputed map.
+		// the precomputed map. That's above Y=0; the height of the ground
+		// under each foot is added when it's sent (see terrain).
 		for i, _ := range l.Legs {
 			f := l.Gait.Frame(i, l.stateCounter-1)
 
@@ -394,14 +892,17 @@ func (l *Legs) Tick(now time.Time, state *hexapod.State) error {
 			vv := l.nextFeet[i].Subtract(l.lastFeet[i])
 			vvv := vv.MultiplyByScalar(f.XZ)
 
-			l.feet[i].Y = stepHeight * f.Y
+			l.feet[i].Y = l.step.stepHeight * f.Y
+			l.swing[i] = f.Swing
 			l.feet[i].X = l.lastFeet[i].X + vvv.X
 			l.feet[i].Z = l.lastFeet[i].Z + vvv.Z
 		}
+		status = l.gaitStatus(state.FPS)
 
 		// If this is the last tick in the cycle, reset the state such that the
 		// next tick is #1.
 		if l.stateCounter >= l.Gait.Length() {
+			l.stepper.cycles++
 			if state.Shutdown {
 				l.SetState(sSitDown)
 			} else {
@@ -413,32 +914,186 @@ func (l *Legs) Tick(now time.Time, state *hexapod.State) error {
 		return fmt.Errorf("unknown state: %#v", l.State)
 	}
 
+	tick := 0
+	if stepping {
+		tick = l.stateCounter
+		if !held {
+			l.stepper.played(tick, l.gaitTPS)
+		}
+	}
+	state.GaitDebug = l.stepper.status(tick, l.gaitTPS, l.Gait.Length())
+	state.GaitStatus = status
+
+	err := l.rest(now, state, &aim)
+	if err != nil {
+		return err
+	}
+
+	l.limitLean(now, state, &aim)
+	l.guardAim(now, state, &aim)
+
+	err = l.limitSwing(state)
+	if err != nil {
+		return err
+	}
+
+	l.updateStiffness(state)
+
 	// Adjust the clearance if that's gotten off. This is how we stand up, sit
 	// down, and adjust the clearance at runtime.
-	yOffset := math.Max(-yMoveSpeed, math.Min(yMoveSpeed, (state.Target.Position.Y-state.Pose.Position.Y)))
+	yOffset := math.Max(-l.cfg.YMoveSpeed, math.Min(l.cfg.YMoveSpeed, (aim.Position.Y-state.Pose.Position.Y)))
 	if yOffset != 0 {
 		state.Pose.Position.Y += yOffset
 	}
 
 	// Same for the x/z orientation
-	bankOffset := math.Max(-bankMoveSpeed, math.Min(bankMoveSpeed, (state.Target.Bank-state.Pose.Bank)))
+	bankOffset := math.Max(-l.cfg.BankMoveSpeed, math.Min(l.cfg.BankMoveSpeed, (aim.Bank-state.Pose.Bank)))
 	if bankOffset != 0 {
 		state.Pose.Bank += bankOffset
 	}
 
-	pitchOffset := math.Max(-pitchMoveSpeed, math.Min(pitchMoveSpeed, (state.Target.Pitch-state.Pose.Pitch)))
+	pitchOffset := math.Max(-l.cfg.PitchMoveSpeed, math.Min(l.cfg.PitchMoveSpeed, (aim.Pitch-state.Pose.Pitch)))
 	if pitchOffset != 0 {
 		state.Pose.Pitch += pitchOffset
 	}
 
-	// Update the goal of each leg.
+	// Update the goal of each leg, and send them all at once.
+	l.goals.Reset()
+	slipped := false
 	for i, leg := range l.Legs {
-		pp := l.feet[i].MultiplyByMatrix44(state.Local())
-		err := leg.SetGoal(pp)
+		pp := l.terrain.foot(i, l.feet[i]).MultiplyByMatrix44(state.Local())
+		state.Saturated[i] = !leg.InReach(pp)
+		state.Feet[i] = pp
+		slipped = slipped || state.Saturated[i]
+
+		// This happens every tick until the target changes, so don't spam. The
+		// leg is left at its last goal, since there's no solution for this one.
+		if state.Saturated[i] {
+			log.RateLimited("saturated-"+leg.Name, time.Second).Warnf("%s goal out of reach: %v", leg.Name, pp)
+			continue
+		}
+
+		err := leg.Goal(pp, l.goals)
+		if err != nil {
+			log.RateLimited("goal-"+leg.Name, time.Second).Warnf("%s (while setting goal position)", err)
+			continue
+		}
+	}
+
+	l.updateStability(state)
+	l.updateGround(state)
+	l.updateTerrain(now, state)
+
+	err = l.updateLEDs(state)
+	if err != nil {
+		log.RateLimited("leds", time.Second).Warnf("%s", err)
+	}
+
+	// A foot which can't reach the ground where it's meant to be probably
+	// isn't, so the pose is probably wrong, too. That only matters while
+	// walking, since standing still doesn't move the pose.
+	if slipped && l.walking {
+		state.Drift += l.cfg.SlipDrift
+	}
+
+	err = l.goals.WriteTo(l.Network)
+	if err != nil {
+		log.RateLimited("goals", time.Second).Warnf("%s (while sending goal positions)", err)
+	}
+
+	err = l.stiffness.writeTo(l.Network)
+	if err != nil {
+		log.RateLimited("stiffness", time.Second).Warnf("%s", err)
+	}
+
+	err = l.readFeedback()
+	if err != nil {
+		log.RateLimited("feedback", time.Second).Warnf("%s", err)
+	}
+
+	state.Usage = l.usage.update(now, l.swing, held)
+	return nil
+}
+
+// rest lowers the aim while the legs are resting, and sets the torque limits
+// when the phase changes to or from relaxed. Waking up restores the torque
+// straight away, but the clearance through the usual ramp.
+func (l *Legs) rest(now time.Time, state *hexapod.State, aim *math3d.Pose) error {
+	prev := l.idle.phase
+	p := l.idle.update(now, state, l.State == sStepping && !l.walking && !l.stance.moving)
+	state.KeepAwake = false
+	state.Resting = p != awake
+
+	if p != awake {
+		aim.Position.Y -= l.idle.drop
+	}
+
+	if (p == relaxed) == (prev == relaxed) {
+		return nil
+	}
+
+	torque := l.cfg.TorqueLimitFast
+	if p == relaxed {
+		torque = l.cfg.TorqueLimitRest
+	}
+
+	for _, s := range l.Servos() {
+		err := s.SetTorqueLimit(torque)
+		if err != nil {
+			return fmt.Errorf("%s (while setting torque limit)", err)
+		}
+	}
+
+	return nil
+}
+
+// updateStiffness queues a switch to the requested stiffness preset, if any, and
+// switches once every foot is on the ground.
+func (l *Legs) updateStiffness(state *hexapod.State) {
+	if name := state.SetStiffness; name != "" {
+		state.SetStiffness = ""
+		err := l.stiffness.request(name)
 		if err != nil {
-			log.Warnf("%s (while setting goal position)", err)
+			log.Warn(err)
+		}
+	}
+
+	if l.stiffness.update(l.Legs, l.swing) {
+		log.Infof("stiffness=%s", l.stiffness.active)
+		state.Publish(hexapod.EventStiffnessChanged, hexapod.Info, l.stiffness.active)
+	}
+
+	state.Stiffness = l.stiffness.active
+}
+
+// limitSwing sets the torque limit of each leg which is in the air to what the
+// budget allows, and restores it once the leg is back on the ground (or the
+// budget allows more). Legs on the ground always have the full torque, since
+// they're holding the body up.
+func (l *Legs) limitSwing(state *hexapod.State) error {
+	for i, leg := range l.Legs {
+		torque := 0
+		if l.swing[i] && state.Budget.Swing > 0 {
+			torque = l.budget.swingTorque(state, l.cfg.TorqueLimitFast)
+		}
+
+		if torque == l.swingTorque[i] {
 			continue
 		}
+
+		write := torque
+		if write == 0 {
+			write = l.cfg.TorqueLimitFast
+		}
+
+		for _, s := range leg.Servos() {
+			err := s.SetTorqueLimit(write)
+			if err != nil {
+				return fmt.Errorf("%s (while setting torque limit)", err)
+			}
+		}
+
+		l.swingTorque[i] = torque
 	}
 
 	return nil
@@ -0,0 +1,149 @@
+package legs
+
+import (
+	"math"
+	"time"
+
+	"github.com/adammck/hexapod"
+	"github.com/adammck/hexapod/config"
+	"github.com/adammck/hexapod/math3d"
+)
+
+const (
+
+	// How far a stick or trigger can be from neutral before it counts as
+	// input, since they never quite settle at zero.
+	idleDeadzone = 12
+
+	// How far (in mm or degrees) the commands can drift before they count as
+	// having changed.
+	idleTolerance = 0.5
+)
+
+// phase is how rested the legs are.
+type phase int
+
+const (
+	awake phase = iota
+
+	// The body is being lowered (or has been), at full torque.
+	lowering
+
+	// The body has been lowered, and the torque reduced.
+	relaxed
+)
+
+// idle decides when the legs should rest. That's after standing still for a
+// while, with no input and no changes to the clearance, orientation, offset or
+// focal point. It's separate from the legs so it can be tested without any
+// servos.
+type idle struct {
+	after time.Duration
+	drop  float64
+
+	phase phase
+
+	// The start of the current idle period, or zero if there was activity on
+	// the last tick.
+	since time.Time
+
+	// The commands on the last tick, to compare to.
+	last    snapshot
+	hasLast bool
+}
+
+// snapshot is the part of the commands which count as activity when changed.
+// The focal point is relative to the pose (and its height to the clearance),
+// since the controller moves it with the body, which is itself moving while
+// resting.
+type snapshot struct {
+	clearance float64
+	pitch     float64
+	bank      float64
+	offset    math3d.Vector3
+	look      math3d.Vector3
+	hasLook   bool
+}
+
+func newIdle(cfg config.Legs) idle {
+	return idle{
+		after: cfg.RestAfter.Duration,
+		drop:  cfg.RestDrop,
+	}
+}
+
+// update returns the phase which the legs should be in, given the state, and
+// whether they're standing still (i.e. not stepping, sitting down, etc).
+func (i *idle) update(now time.Time, state *hexapod.State, still bool) phase {
+	s := snap(state)
+	changed := i.hasLast && s.differs(i.last)
+	i.last, i.hasLast = s, true
+
+	if i.after <= 0 || !still || changed || state.Shutdown || state.Calibrating || state.KeepAwake || active(state.Input) {
+		if i.phase != awake {
+			log.Info("waking up")
+		}
+
+		i.phase = awake
+		i.since = time.Time{}
+		return i.phase
+	}
+
+	if i.since.IsZero() {
+		i.since = now
+	}
+
+	if i.phase == awake && now.Sub(i.since) >= i.after {
+		log.Infof("resting, after %s idle", i.after)
+		i.phase = lowering
+	}
+
+	// Only relax once the body is down, so it doesn't slump.
+	if i.phase == lowering && math.Abs(state.Target.Position.Y-i.drop-state.Pose.Position.Y) < 1 {
+		i.phase = relaxed
+	}
+
+	return i.phase
+}
+
+func snap(state *hexapod.State) snapshot {
+	s := snapshot{
+		clearance: state.Target.Position.Y,
+		pitch:     state.Target.Pitch,
+		bank:      state.Target.Bank,
+		offset:    state.Offset,
+	}
+
+	if state.LookAt != nil {
+		s.look = state.LookAt.Subtract(state.Pose.Position)
+		s.look.Y = state.LookAt.Y - state.Target.Position.Y
+		s.hasLook = true
+	}
+
+	return s
+}
+
+func (s snapshot) differs(o snapshot) bool {
+	return math.Abs(s.clearance-o.clearance) > idleTolerance ||
+		math.Abs(math3d.AngleDiff(s.pitch, o.pitch)) > idleTolerance ||
+		math.Abs(math3d.AngleDiff(s.bank, o.bank)) > idleTolerance ||
+		s.offset.Distance(o.offset) > idleTolerance ||
+		s.hasLook != o.hasLook ||
+		s.look.Distance(o.look) > idleTolerance
+}
+
+// active returns true if any button is pressed, or any stick or trigger has
+// been moved from neutral.
+func active(in hexapod.Input) bool {
+	if in.Buttons != 0 {
+		return true
+	}
+
+	for _, v := range []int{in.LeftX, in.LeftY, in.RightX, in.RightY, in.L2, in.R2} {
+		if v > idleDeadzone || v < -idleDeadzone {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,141 @@
+package legs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/adammck/hexapod"
+	"github.com/adammck/hexapod/config"
+	"github.com/adammck/hexapod/math3d"
+	"github.com/stretchr/testify/assert"
+)
+
+// parked returns the state of a hex standing still at the default clearance.
+func parked() *hexapod.State {
+	p := math3d.Pose{Position: math3d.Vector3{X: 100, Y: 40, Z: -50}, Heading: 30}
+	look := math3d.Vector3{X: 100, Y: 117.5, Z: 450}
+	return &hexapod.State{
+		Commands:  hexapod.Commands{Target: p, LookAt: &look},
+		Estimates: hexapod.Estimates{Pose: p},
+	}
+}
+
+func newTestIdle() *idle {
+	cfg := config.Default().Legs
+	cfg.RestAfter = config.Duration{Duration: time.Minute}
+	i := newIdle(cfg)
+	return &i
+}
+
+// run updates the idle policy once a second for the given number of seconds,
+// lowering the pose towards the target like the legs would while resting, and
+// returns the phase after the last update. The focal point stays where it is,
+// like the controller keeps it, since it's relative to the clearance.
+func run(i *idle, now *time.Time, s *hexapod.State, secs int) phase {
+	var p phase
+	for n := 0; n < secs; n++ {
+		p = i.update(*now, s, true)
+
+		y := s.Target.Position.Y
+		if p != awake {
+			y -= i.drop
+		}
+		s.Pose.Position.Y = y
+
+		*now = now.Add(time.Second)
+	}
+
+	return p
+}
+
+func TestIdleRests(t *testing.T) {
+	i := newTestIdle()
+	s := parked()
+	now := time.Unix(0, 0)
+
+	assert.Equal(t, awake, run(i, &now, s, 60))
+
+	// Lowered first, and then relaxed once the body is down.
+	assert.Equal(t, lowering, i.update(now, s, true))
+	assert.Equal(t, relaxed, run(i, &now, s, 2))
+	assert.Equal(t, 30.0, s.Pose.Position.Y)
+	assert.Equal(t, relaxed, run(i, &now, s, 600))
+}
+
+func TestIdleWakesOnInput(t *testing.T) {
+	for _, in := range []hexapod.Input{
+		{Buttons: hexapod.ButtonCross},
+		{LeftY: -60},
+		{RightX: 20},
+		{R2: 100},
+	} {
+		i := newTestIdle()
+		s := parked()
+		now := time.Unix(0, 0)
+		assert.Equal(t, relaxed, run(i, &now, s, 65))
+
+		s.Input = in
+		assert.Equal(t, awake, i.update(now, s, true), "%+v", in)
+
+		// And the timer starts again once it's let go.
+		s.Input = hexapod.Input{}
+		assert.Equal(t, awake, run(i, &now, s, 30), "%+v", in)
+	}
+}
+
+func TestIdleIgnoresStickNoise(t *testing.T) {
+	i := newTestIdle()
+	s := parked()
+	s.Input = hexapod.Input{LeftX: 3, RightY: -5}
+	now := time.Unix(0, 0)
+	assert.Equal(t, relaxed, run(i, &now, s, 65))
+}
+
+func TestIdleWakesOnCommands(t *testing.T) {
+	for name, change := range map[string]func(s *hexapod.State){
+		"clearance": func(s *hexapod.State) { s.Target.Position.Y += 10 },
+		"pitch":     func(s *hexapod.State) { s.Target.Pitch = 5 },
+		"bank":      func(s *hexapod.State) { s.Target.Bank = -5 },
+		"offset":    func(s *hexapod.State) { s.Offset.X = 20 },
+		"look at":   func(s *hexapod.State) { s.LookAt.X += 30 },
+		"no look":   func(s *hexapod.State) { s.LookAt = nil },
+	} {
+		i := newTestIdle()
+		s := parked()
+		now := time.Unix(0, 0)
+		assert.Equal(t, relaxed, run(i, &now, s, 65), name)
+
+		change(s)
+		assert.Equal(t, awake, i.update(now, s, true), name)
+	}
+}
+
+func TestIdleNeverRestsWhileBusy(t *testing.T) {
+	for name, busy := range map[string]func(s *hexapod.State){
+		"shutdown":    func(s *hexapod.State) { s.Shutdown = true },
+		"calibrating": func(s *hexapod.State) { s.Calibrating = true },
+		"keep awake":  func(s *hexapod.State) { s.KeepAwake = true },
+	} {
+		i := newTestIdle()
+		s := parked()
+		busy(s)
+		now := time.Unix(0, 0)
+		assert.Equal(t, awake, run(i, &now, s, 600), name)
+	}
+
+	// Or while walking.
+	i := newTestIdle()
+	now := time.Unix(0, 0)
+	for n := 0; n < 600; n++ {
+		assert.Equal(t, awake, i.update(now, parked(), false))
+		now = now.Add(time.Second)
+	}
+}
+
+func TestIdleDisabled(t *testing.T) {
+	cfg := config.Default().Legs
+	cfg.RestAfter = config.Duration{}
+	i := newIdle(cfg)
+	now := time.Unix(0, 0)
+	assert.Equal(t, awake, run(&i, &now, parked(), 3600))
+}
@@ -0,0 +1,33 @@
+package legs
+
+import (
+	"github.com/adammck/dynamixel/servo"
+	"github.com/adammck/hexapod/servos"
+)
+
+// The zero angle which servos start with, i.e. the middle of their range.
+const defaultZero = 150.0
+
+// Joint is a servo in a leg. It remembers its zero angle (which the servo
+// doesn't expose), so that goal positions can be calculated here and sent to
+// every servo at once, rather than one at a time.
+type Joint struct {
+	*servo.Servo
+	zero float64
+}
+
+func newJoint(s *servo.Servo) *Joint {
+	return &Joint{Servo: s, zero: defaultZero}
+}
+
+// SetZero sets the origin angle (in degrees) of the servo.
+func (j *Joint) SetZero(offset float64) {
+	j.zero = offset
+	j.Servo.SetZero(offset)
+}
+
+// Position returns the goal position which moves the servo to the given angle,
+// relative to its zero angle.
+func (j *Joint) Position(angle float64) (int, error) {
+	return servos.Position(j.zero, angle)
+}
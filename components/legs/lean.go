@@ -0,0 +1,66 @@
+package legs
+
+import (
+	"math"
+	"time"
+
+	"github.com/adammck/hexapod"
+	"github.com/adammck/hexapod/config"
+	"github.com/adammck/hexapod/math3d"
+)
+
+// lean scales down the pitch and bank which the legs aim for, by how stable the
+// hex is. See config.Lean.
+type lean struct {
+	cfg config.Lean
+
+	// The stability margin, smoothed, and when it was last updated, or zero
+	// if it never has been.
+	margin float64
+	last   time.Time
+}
+
+// update smooths the given margin into the last, as of the given time.
+func (ln *lean) update(now time.Time, margin float64) {
+	if ln.last.IsZero() || ln.cfg.Smoothing.Duration <= 0 {
+		ln.margin = margin
+		ln.last = now
+		return
+	}
+
+	dt := now.Sub(ln.last)
+	if dt <= 0 {
+		return
+	}
+
+	ln.margin += (margin - ln.margin) * (1 - math.Exp(-dt.Seconds()/ln.cfg.Smoothing.Seconds()))
+	ln.last = now
+}
+
+// scale returns the fraction of the lean which is allowed at the smoothed
+// margin, from zero (level) to one. It's one until there's a margin to go by.
+func (ln *lean) scale() float64 {
+	if ln.last.IsZero() {
+		return 1
+	}
+
+	f := (ln.margin - ln.cfg.MinMargin) / (ln.cfg.FullMargin - ln.cfg.MinMargin)
+	return math.Max(0, math.Min(1, f))
+}
+
+// limitLean scales the pitch and bank of the aim by the stability margin, which
+// the legs estimated on the last tick (see updateStability). Until they have,
+// e.g. just after standing up, it's left alone.
+func (l *Legs) limitLean(now time.Time, state *hexapod.State, aim *math3d.Pose) {
+	if !l.lean.cfg.Enabled {
+		return
+	}
+
+	if state.Stability.Planted > 0 {
+		l.lean.update(now, state.Stability.Margin)
+	}
+
+	s := l.lean.scale()
+	aim.Pitch *= s
+	aim.Bank *= s
+}
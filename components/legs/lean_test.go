@@ -0,0 +1,74 @@
+package legs
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/adammck/hexapod"
+	"github.com/adammck/hexapod/config"
+	"github.com/adammck/hexapod/math3d"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLeanScale(t *testing.T) {
+	ln := lean{cfg: config.Lean{FullMargin: 120, MinMargin: 40}}
+	now := time.Now()
+
+	// Until there's a margin to go by, it's left alone.
+	assert.Equal(t, 1.0, ln.scale())
+
+	for _, tc := range []struct {
+		margin float64
+		want   float64
+	}{
+		{200, 1},
+		{120, 1},
+		{80, 0.5},
+		{40, 0},
+		{-30, 0},
+	} {
+		ln.update(now, tc.margin)
+		assert.InDelta(t, tc.want, ln.scale(), 1e-9, "margin=%v", tc.margin)
+	}
+}
+
+func TestLeanSmoothing(t *testing.T) {
+	ln := lean{cfg: config.Lean{FullMargin: 120, MinMargin: 40, Smoothing: config.Duration{Duration: 100 * time.Millisecond}}}
+	now := time.Now()
+
+	// The first sample is taken as it is.
+	ln.update(now, 120)
+	assert.Equal(t, 120.0, ln.margin)
+
+	// After one time constant, it's most of the way to the next.
+	now = now.Add(100 * time.Millisecond)
+	ln.update(now, 40)
+	assert.InDelta(t, 40+80/math.E, ln.margin, 1e-9)
+
+	// No time passing doesn't move it.
+	ln.update(now, 0)
+	assert.InDelta(t, 40+80/math.E, ln.margin, 1e-9)
+}
+
+func TestLimitLean(t *testing.T) {
+	l := &Legs{lean: lean{cfg: config.Lean{Enabled: true, FullMargin: 120, MinMargin: 40}}}
+	state := &hexapod.State{}
+	now := time.Now()
+
+	// Nothing planted yet, so nothing to go by.
+	aim := math3d.Pose{Pitch: 10, Bank: -6, Heading: 30}
+	l.limitLean(now, state, &aim)
+	assert.Equal(t, math3d.Pose{Pitch: 10, Bank: -6, Heading: 30}, aim)
+
+	// Scaled towards level, but the heading is left alone.
+	state.Stability = hexapod.Stability{Margin: 80, Planted: 3}
+	l.limitLean(now, state, &aim)
+	assert.Equal(t, math3d.Pose{Pitch: 5, Bank: -3, Heading: 30}, aim)
+
+	// Disabled, it's left alone.
+	l.lean.cfg.Enabled = false
+	aim = math3d.Pose{Pitch: 10}
+	l.limitLean(now, state, &aim)
+	assert.Equal(t, 10.0, aim.Pitch)
+}
@@ -0,0 +1,76 @@
+package legs
+
+import (
+	"fmt"
+
+	"github.com/adammck/hexapod"
+)
+
+// The modes of the debug LEDs. See config.Legs.DebugLEDs.
+const (
+	ledsPhase = "phase"
+	ledsFault = "fault"
+)
+
+// alarmAll is the value of the alarm LED register which makes a servo light its
+// own LED when it reports any error (voltage, angle limit, overheating, range,
+// checksum, overload, or instruction), rather than only the default overheating
+// and overload. Those are reported in the status packets, which the legs never
+// ask for, so this is the only way to see them without making the bus busier.
+const alarmAll = 0x7F
+
+// bootLEDs configures the servos for the debug LED mode, if any.
+func (l *Legs) bootLEDs() error {
+	if l.cfg.DebugLEDs != ledsFault {
+		return nil
+	}
+
+	for _, s := range l.Servos() {
+		err := s.SetAlarmLED(alarmAll)
+		if err != nil {
+			return fmt.Errorf("%s (while setting alarm LED)", err)
+		}
+	}
+
+	return nil
+}
+
+// ledOn returns whether the LEDs of a leg should be lit in the given mode,
+// given whether it's in the air, and whether its goal is out of reach.
+func ledOn(mode string, swing, saturated bool) bool {
+	switch mode {
+	case ledsPhase:
+		return swing
+	case ledsFault:
+		return saturated
+	}
+
+	return false
+}
+
+// updateLEDs lights the LEDs of each leg for the debug LED mode, if any. Like
+// limitSwing, they're only written when they change (they're off at power on,
+// and after shutdown), so each servo gets at most one write per phase change.
+func (l *Legs) updateLEDs(state *hexapod.State) error {
+	if l.cfg.DebugLEDs == "" {
+		return nil
+	}
+
+	for i, leg := range l.Legs {
+		on := ledOn(l.cfg.DebugLEDs, l.swing[i], state.Saturated[i])
+		if on == l.leds[i] {
+			continue
+		}
+
+		for _, s := range leg.Servos() {
+			err := s.SetLED(on)
+			if err != nil {
+				return fmt.Errorf("%s (while setting LED)", err)
+			}
+		}
+
+		l.leds[i] = on
+	}
+
+	return nil
+}
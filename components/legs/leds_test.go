@@ -0,0 +1,23 @@
+package legs
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLEDOn(t *testing.T) {
+	for _, tc := range []struct {
+		mode             string
+		swing, saturated bool
+		out              bool
+	}{
+		{"", true, true, false},
+		{ledsPhase, true, false, true},
+		{ledsPhase, false, true, false},
+		{ledsFault, true, false, false},
+		{ledsFault, false, true, true},
+	} {
+		assert.Equal(t, tc.out, ledOn(tc.mode, tc.swing, tc.saturated), "ledOn(%q, %v, %v)", tc.mode, tc.swing, tc.saturated)
+	}
+}
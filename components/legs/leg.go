@@ -4,7 +4,6 @@ import (
 	"fmt"
 	"math"
 
-	"github.com/Sirupsen/logrus"
 	"github.com/adammck/dynamixel/network"
 	"github.com/adammck/dynamixel/servo"
 	"github.com/adammck/hexapod/math3d"
@@ -34,10 +33,10 @@ const (
 type Leg struct {
 	Name   string
 	Origin *math3d.Vector3
-	Coxa   *servo.Servo
-	Femur  *servo.Servo
-	Tibia  *servo.Servo
-	Tarsus *servo.Servo
+	Coxa   *Joint
+	Femur  *Joint
+	Tibia  *Joint
+	Tarsus *Joint
 
 	// TODO: Rename this to 'Heading', since that's what it is.
 	Angle float64
@@ -60,46 +59,56 @@ func NewLeg(network *network.Network, baseId int, name string, origin *math3d.Ve
 	}
 }
 
-func mustGetServo(network *network.Network, ID int) *servo.Servo {
+func mustGetServo(network *network.Network, ID int) *Joint {
 	s, err := servos.New(network, ID)
 	if err != nil {
 		panic(err)
 	}
 
-	return s
+	return newJoint(s)
 }
 
-// Matrix returns a pointer to a 4x4 matrix, to transform a vector in the leg's
-// coordinate space into the parent (hexapod) space.
+// Matrix returns a 4x4 matrix, to transform a vector in the leg's coordinate
+// space into the parent (hexapod) space.
 func (leg *Leg) Matrix() math3d.Matrix44 {
-	return *math3d.MakeMatrix44(*leg.Origin, *math3d.MakeSingularEulerAngle(math3d.RotationHeading, leg.Angle))
+	return math3d.FromPose(math3d.Pose{Position: *leg.Origin, Heading: leg.Angle})
 }
 
 // Servos returns an array of all servos attached to this leg.
 func (leg *Leg) Servos() []*servo.Servo {
 	return []*servo.Servo{
-		leg.Coxa,
-		leg.Femur,
-		leg.Tibia,
-		leg.Tarsus,
+		leg.Coxa.Servo,
+		leg.Femur.Servo,
+		leg.Tibia.Servo,
+		leg.Tarsus.Servo,
 	}
 }
 
+// joints returns the joints of this leg, from the body outwards. Unlike
+// Servos, this doesn't allocate, so it's fine to call in the main loop.
+func (leg *Leg) joints() [4]*Joint {
+	return [4]*Joint{leg.Coxa, leg.Femur, leg.Tibia, leg.Tarsus}
+}
+
 func (leg *Leg) SetLED(state bool) {
 	for _, s := range leg.Servos() {
 		s.SetLED(state)
 	}
 }
 
-// rootSegment returns the segment at the origin of this leg.
-func (leg *Leg) rootSegment() *Segment {
+// segment returns the matrix which transforms from the space of a segment to
+// the world space. The segment starts at the given offset in its parent space
+// (which is transformed by the given matrix), and is rotated by the given
+// angles (in degrees) from there.
+func segment(parent math3d.Matrix44, offset math3d.Vector3, heading, pitch float64) math3d.Matrix44 {
+	return math3d.FromPose(math3d.Pose{Position: offset, Heading: heading, Pitch: pitch}).MultiplyMatrix(parent)
+}
 
-	// The position of the leg in world space must be specified by two segments.
-	// The first positions it, then the second (which is always zero-length)
-	// rotates it into the home orientation. This is the opposite of most
-	// segments, which rotate from their start, rather than their end.
-	s1 := MakeRootSegment(*leg.Origin)
-	return MakeSegment("s2", s1, *math3d.MakeSingularEulerAngle(math3d.RotationHeading, leg.Angle), *math3d.MakeVector3(0, 0, 0))
+// coxa returns the matrix which transforms from the space of the coxa, at the
+// given angle, to the hexapod space. Like the other segments, it rotates from
+// its start (the origin of the leg, pointing along its heading).
+func (leg *Leg) coxa(coxPos float64) math3d.Matrix44 {
+	return segment(leg.Matrix(), math3d.ZeroVector3, coxPos, 0)
 }
 
 // PresentPosition returns the actual present posion (relative to the center of
@@ -131,18 +140,76 @@ func (leg *Leg) PresentPosition() (math3d.Vector3, error) {
 	// Remove the extra angle added by SetGoal.
 	tarPos -= tarsusExtraAngle
 
-	root := leg.rootSegment()
-	coxa := MakeSegment("coxa", root, *math3d.MakeSingularEulerAngle(math3d.RotationHeading, coxPos), *math3d.MakeVector3(0, coxaOffsetY, coxaOffsetZ))
-	femur := MakeSegment("femur", coxa, *math3d.MakeSingularEulerAngle(math3d.RotationPitch, femPos), *math3d.MakeVector3(0, 0, femurLength))
-	tibia := MakeSegment("tibia", femur, *math3d.MakeSingularEulerAngle(math3d.RotationPitch, tibPos), *math3d.MakeVector3(0, 0, tibiaLength))
-	tarsus := MakeSegment("tarsus", tibia, *math3d.MakeSingularEulerAngle(math3d.RotationPitch, tarPos), *math3d.MakeVector3(0, 0, tarsusLength))
+	return leg.end(coxPos, femPos, tibPos, tarPos), nil
+}
+
+// end returns the position (in the hexapod space) of the end of the leg, with
+// the joints at the given angles.
+func (leg *Leg) end(coxPos, femPos, tibPos, tarPos float64) math3d.Vector3 {
+	coxa := leg.coxa(coxPos)
+	femur := segment(coxa, math3d.Vector3{X: 0, Y: coxaOffsetY, Z: coxaOffsetZ}, 0, femPos)
+	tibia := segment(femur, math3d.Vector3{X: 0, Y: 0, Z: femurLength}, 0, tibPos)
+	tarsus := segment(tibia, math3d.Vector3{X: 0, Y: 0, Z: tibiaLength}, 0, tarPos)
 
-	return tarsus.End(), nil
+	return math3d.Vector3{X: 0, Y: 0, Z: tarsusLength}.MultiplyByMatrix44(tarsus)
 }
 
 // SetGoal sets the goal position of the leg to the given vector in the chassis
-// coordinate space.
+// coordinate space. The goals are buffered until the next ACTION.
 func (leg *Leg) SetGoal(vt math3d.Vector3) error {
+	angles := leg.solve(vt)
+
+	// Move the servos!
+	var first error
+	for i, j := range leg.joints() {
+		err := servos.RegMoveTo(j.Servo, angles[i])
+		if err != nil && first == nil {
+			first = err
+		}
+	}
+
+	return first
+}
+
+// Goal adds the goal positions which move the leg to the given vector (in the
+// chassis coordinate space) to the sync write. If any of them are out of
+// range, none are added.
+func (leg *Leg) Goal(vt math3d.Vector3, w *servos.SyncWrite) error {
+	pos, err := leg.Positions(vt)
+	if err != nil {
+		return err
+	}
+
+	for i, j := range leg.joints() {
+		w.Set(j.ID, pos[i])
+	}
+
+	return nil
+}
+
+// Positions returns the positions of the coxa, femur, tibia, and tarsus servos
+// (in that order) which put the end of the leg at the given vector in the
+// chassis coordinate space, or an error if any of them are out of range.
+func (leg *Leg) Positions(vt math3d.Vector3) ([4]int, error) {
+	angles := leg.solve(vt)
+
+	var pos [4]int
+	for i, j := range leg.joints() {
+		p, err := j.Position(angles[i])
+		if err != nil {
+			return pos, fmt.Errorf("%s (while setting %s #%d)", err, leg.Name, j.ID)
+		}
+
+		pos[i] = p
+	}
+
+	return pos, nil
+}
+
+// solve returns the angles of the coxa, femur, tibia, and tarsus servos (in
+// that order) which put the end of the leg at the given vector in the chassis
+// coordinate space.
+func (leg *Leg) solve(vt math3d.Vector3) [4]float64 {
 
 	// Solve the angle of the coxa by looking at the position of the target from
 	// above (x,z). Note that "above" here is in the chassis space, which might
@@ -155,8 +222,7 @@ func (leg *Leg) SetGoal(vt math3d.Vector3) error {
 	// from the above. So the rest of the function can use 2d trig on the (z,y)
 	// axis in the coxa space. More cheating!
 
-	root := leg.rootSegment()
-	coxa := MakeSegment("coxa", root, *math3d.MakeSingularEulerAngle(math3d.RotationHeading, coxPos), *math3d.MakeVector3(0, coxaOffsetY, coxaOffsetZ))
+	coxa := leg.coxa(coxPos)
 
 	// The following points (vr,vt) and lengths (a,b,c) are known:
 	//
@@ -172,7 +238,7 @@ func (leg *Leg) SetGoal(vt math3d.Vector3) error {
 	//                |
 	//              (vt)
 	//
-	vr := coxa.End()
+	vr := math3d.Vector3{X: 0, Y: coxaOffsetY, Z: coxaOffsetZ}.MultiplyByMatrix44(coxa)
 	a := femurLength
 	b := tibiaLength
 	c := tarsusLength
@@ -224,53 +290,70 @@ func (leg *Leg) SetGoal(vt math3d.Vector3) error {
 	err := false
 
 	if math.IsNaN(coxPos) {
-		logrus.Errorf("invalid %s coxa angle: %0.2f", leg.Name, coxPos)
+		log.Errorf("invalid %s coxa angle: %0.2f", leg.Name, coxPos)
 		err = true
 	}
 
 	if math.IsNaN(femPos) {
-		logrus.Errorf("invalid %s femur angle: %0.2f", leg.Name, femPos)
+		log.Errorf("invalid %s femur angle: %0.2f", leg.Name, femPos)
 		err = true
 	}
 
 	if math.IsNaN(tibPos) {
-		logrus.Errorf("invalid %s tibia angle: %0.2f", leg.Name, tibPos)
+		log.Errorf("invalid %s tibia angle: %0.2f", leg.Name, tibPos)
 		err = true
 	}
 
 	if math.IsNaN(tarPos) {
-		logrus.Errorf("invalid %s tarsus angle: %0.2f", leg.Name, tarPos)
+		log.Errorf("invalid %s tarsus angle: %0.2f", leg.Name, tarPos)
 		err = true
 	}
 
 	// Dump a bunch of debugging info and crash if anything went wrong. This is
 	// of course way too hasty, but handy for now.
 	if err {
-		logrus.Errorf("a=%0.2f, b=%0.2f, c=%0.2f, d=%0.2f, e=%0.2f, f=%0.2f, g=%0.2f", a, b, c, d, e, f, g)
-		logrus.Errorf("aa=%0.2f, bb=%0.2f, cc=%0.2f, dd=%0.2f, ee=%0.2f, hh=%0.2f", aa, bb, cc, dd, ee, hh)
+		log.Errorf("a=%0.2f, b=%0.2f, c=%0.2f, d=%0.2f, e=%0.2f, f=%0.2f, g=%0.2f", a, b, c, d, e, f, g)
+		log.Errorf("aa=%0.2f, bb=%0.2f, cc=%0.2f, dd=%0.2f, ee=%0.2f, hh=%0.2f", aa, bb, cc, dd, ee, hh)
 		panic("goal out of range")
 	}
 
-	// Move the servos!
-	err1 := servos.RegMoveTo(leg.Coxa, coxPos)
-	err2 := servos.RegMoveTo(leg.Femur, femPos)
-	err3 := servos.RegMoveTo(leg.Tibia, tibPos)
-	err4 := servos.RegMoveTo(leg.Tarsus, tarPos+tarsusExtraAngle)
+	return [4]float64{coxPos, femPos, tibPos, tarPos + tarsusExtraAngle}
+}
 
-	if err1 != nil {
-		return err1
-	}
-	if err2 != nil {
-		return err2
-	}
-	if err3 != nil {
-		return err3
+// InReach returns true if the given vector (in the chassis coordinate space) is
+// within reach of the leg. SetGoal and Goal panic if it isn't, so this can be
+// used to detect that in advance.
+func (leg *Leg) InReach(vt math3d.Vector3) bool {
+	coxPos := utils.Deg(math.Atan2(vt.X-leg.Origin.X, vt.Z-leg.Origin.Z)) - leg.Angle
+	coxa := leg.coxa(coxPos)
+
+	// Same as solve: the femur and tibia must form a triangle between the end
+	// of the coxa and the top of the tarsus (which is directly above the goal).
+	vr := math3d.Vector3{X: 0, Y: coxaOffsetY, Z: coxaOffsetZ}.MultiplyByMatrix44(coxa)
+	vq := *vt.Add(math3d.Vector3{X: 0, Y: tarsusLength, Z: 0})
+	d := vr.Distance(vq)
+
+	return d <= femurLength+tibiaLength && d >= math.Abs(femurLength-tibiaLength)
+}
+
+// Reachable returns true if the leg can put its foot at the given vector (in
+// the chassis coordinate space): it's InReach, and the angle of every joint is
+// within the range of its servo. Collisions with the body, and the other legs,
+// aren't considered.
+func (leg *Leg) Reachable(vt math3d.Vector3) bool {
+	if !leg.InReach(vt) {
+		return false
 	}
-	if err4 != nil {
-		return err4
+
+	angles := leg.solve(vt)
+	for i, j := range leg.joints() {
+		_, err := j.Position(angles[i])
+		if err != nil {
+			return false
+		}
 	}
 
-	return nil
+	return true
 }
 
 // sss returns the angle α, given the length of sides a, b, and c.
@@ -0,0 +1,102 @@
+package legs
+
+import (
+	"testing"
+
+	"github.com/adammck/hexapod/math3d"
+	"github.com/adammck/hexapod/servos"
+	"github.com/stretchr/testify/assert"
+)
+
+// testLegs returns the legs (in the same places as New) without any servos
+// behind them, which is enough to solve and build goals, but not to read.
+func testLegs() [6]*Leg {
+	return bareLegs()
+}
+
+// goalFor returns a reachable goal for the leg, in the chassis space.
+func goalFor(leg *Leg, y float64) math3d.Vector3 {
+	v := math3d.Pose{Position: *leg.Origin, Heading: leg.Angle}.Add(math3d.Pose{Position: math3d.Vector3{Z: 120}}).Position
+	v.Y = y
+	return v
+}
+
+func TestSolve(t *testing.T) {
+	for _, leg := range testLegs() {
+		for _, y := range []float64{-60, -40, 0} {
+			vt := goalFor(leg, y)
+			assert.True(t, leg.InReach(vt), "%s %v", leg.Name, vt)
+
+			// Putting the angles back through FK ends up where we started.
+			a := leg.solve(vt)
+			act := leg.end(a[0], a[1], a[2], a[3]-tarsusExtraAngle)
+			assert.InDelta(t, vt.X, act.X, 0.0001, "%s %v", leg.Name, vt)
+			assert.InDelta(t, vt.Y, act.Y, 0.0001, "%s %v", leg.Name, vt)
+			assert.InDelta(t, vt.Z, act.Z, 0.0001, "%s %v", leg.Name, vt)
+		}
+	}
+
+	// The tarsus is always vertical.
+	leg := testLegs()[2]
+	a := leg.solve(goalFor(leg, -50))
+	assert.InDelta(t, 90.0, a[1]+a[2]+a[3]-tarsusExtraAngle, 0.0001)
+}
+
+func TestGoal(t *testing.T) {
+	ls := testLegs()
+	w := servos.NewGoalPositions()
+
+	for _, leg := range ls {
+		assert.NoError(t, leg.Goal(goalFor(leg, -40), w))
+	}
+	assert.Equal(t, 24, w.Len())
+
+	// Same positions as sending each angle to the servo.
+	leg := ls[0]
+	a := leg.solve(goalFor(leg, -40))
+	p, err := servos.Position(150, a[1])
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{byte(leg.Femur.ID), byte(p), byte(p >> 8)}, w.Bytes()[10:13])
+
+	// A goal which the servos can't reach adds nothing.
+	w.Reset()
+	leg.Coxa.SetZero(320)
+	assert.Error(t, leg.Goal(goalFor(leg, -40), w))
+	assert.Equal(t, 0, w.Len())
+}
+
+func BenchmarkSolve(b *testing.B) {
+	leg := testLegs()[0]
+	vt := goalFor(leg, -40)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		vt.Y = -40 + float64(i%20)
+		leg.InReach(vt)
+		leg.solve(vt)
+	}
+}
+
+func BenchmarkGoals(b *testing.B) {
+	ls := testLegs()
+	w := servos.NewGoalPositions()
+
+	var vts [6]math3d.Vector3
+	for i, leg := range ls {
+		vts[i] = goalFor(leg, -40)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		w.Reset()
+		for j, leg := range ls {
+			leg.InReach(vts[j])
+			leg.Goal(vts[j], w)
+		}
+		w.Bytes()
+	}
+}
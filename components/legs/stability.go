@@ -0,0 +1,139 @@
+package legs
+
+import (
+	"math"
+
+	"github.com/adammck/hexapod"
+	"github.com/adammck/hexapod/math3d"
+)
+
+// polygon is a convex polygon on the ground, on the X/Z plane of the world
+// space (so the Y of each point is ignored), anticlockwise from above, i.e.
+// from +X towards +Z. It has no more corners than there are feet, so it's kept
+// in an array, rather than allocated every tick.
+type polygon struct {
+	pts [6]math3d.Vector3
+	n   int
+}
+
+// cross returns the z component of the cross product of (a - o) and (b - o) on
+// the X/Z plane, which is positive if o, a, b turn anticlockwise.
+func cross(o, a, b math3d.Vector3) float64 {
+	return (a.X-o.X)*(b.Z-o.Z) - (a.Z-o.Z)*(b.X-o.X)
+}
+
+// supportPolygon returns the support polygon, i.e. the convex hull of the feet
+// (in the world space) which are on the ground, i.e. not swinging. With fewer
+// than three, or if they're in a line, it has fewer than three corners.
+func supportPolygon(feet [6]math3d.Vector3, swing [6]bool) polygon {
+	var pts [6]math3d.Vector3
+	n := 0
+	for i, f := range feet {
+		if !swing[i] {
+			pts[n] = f
+			n++
+		}
+	}
+
+	// Sorted by X, then Z, for the monotone chain. There are only six, so an
+	// insertion sort is plenty.
+	for i := 1; i < n; i++ {
+		for j := i; j > 0 && (pts[j].X < pts[j-1].X || pts[j].X == pts[j-1].X && pts[j].Z < pts[j-1].Z); j-- {
+			pts[j], pts[j-1] = pts[j-1], pts[j]
+		}
+	}
+
+	if n < 3 {
+		p := polygon{n: n}
+		copy(p.pts[:], pts[:n])
+		return p
+	}
+
+	// Andrew's monotone chain: the lower hull, left to right, and then the
+	// upper, right to left. The last point of each is the first of the other.
+	var hull [12]math3d.Vector3
+	k := 0
+	for i := 0; i < n; i++ {
+		for k >= 2 && cross(hull[k-2], hull[k-1], pts[i]) <= 0 {
+			k--
+		}
+		hull[k] = pts[i]
+		k++
+	}
+	for i, lo := n-2, k+1; i >= 0; i-- {
+		for k >= lo && cross(hull[k-2], hull[k-1], pts[i]) <= 0 {
+			k--
+		}
+		hull[k] = pts[i]
+		k++
+	}
+
+	p := polygon{n: k - 1}
+	copy(p.pts[:], hull[:k-1])
+	return p
+}
+
+// segmentDistance returns the distance on the X/Z plane from the point to the
+// line segment between a and b (which can be the same point).
+func segmentDistance(v, a, b math3d.Vector3) float64 {
+	dx, dz := b.X-a.X, b.Z-a.Z
+	t := 0.0
+	if sq := dx*dx + dz*dz; sq > 0 {
+		t = math.Max(0, math.Min(1, ((v.X-a.X)*dx+(v.Z-a.Z)*dz)/sq))
+	}
+
+	return math.Hypot(v.X-(a.X+t*dx), v.Z-(a.Z+t*dz))
+}
+
+// margin returns the distance on the X/Z plane from the point to the nearest
+// edge of the polygon, which is positive if it's inside, and negative if it's
+// outside. With one or two corners, it's the distance to the corner or the
+// line between them, negated, since the point can't be inside either. With
+// none, it's zero.
+func (p polygon) margin(v math3d.Vector3) float64 {
+	switch p.n {
+	case 0:
+		return 0
+	case 1:
+		return -segmentDistance(v, p.pts[0], p.pts[0])
+	case 2:
+		return -segmentDistance(v, p.pts[0], p.pts[1])
+	}
+
+	d := math.Inf(1)
+	inside := true
+	for i := 0; i < p.n; i++ {
+		a, b := p.pts[i], p.pts[(i+1)%p.n]
+		d = math.Min(d, segmentDistance(v, a, b))
+		if cross(a, b, v) < 0 {
+			inside = false
+		}
+	}
+
+	if !inside {
+		return -d
+	}
+
+	return d
+}
+
+// updateStability sets the stability from the feet which are on the ground,
+// and the center of mass of the body (i.e. the pose plus the offset), which is
+// projected straight down onto the ground, since that's the way it would tip.
+func (l *Legs) updateStability(state *hexapod.State) {
+	body := state.Pose.Add(math3d.Pose{Position: state.Offset, Heading: state.OffsetHeading})
+	c := l.cfg.Chassis.CenterOfMass
+	com := math3d.Vector3{X: c[0], Y: c[1], Z: c[2]}.MultiplyByMatrix44(body.ToWorld())
+
+	planted := 0
+	for _, s := range l.swing {
+		if !s {
+			planted++
+		}
+	}
+
+	state.Stability = hexapod.Stability{
+		Margin:  supportPolygon(l.feet, l.swing).margin(com),
+		Planted: planted,
+	}
+}
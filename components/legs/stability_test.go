@@ -0,0 +1,133 @@
+package legs
+
+import (
+	"math"
+	"testing"
+
+	"github.com/adammck/hexapod"
+	"github.com/adammck/hexapod/config"
+	"github.com/adammck/hexapod/math3d"
+	"github.com/adammck/hexapod/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+// square is a stance with four feet on the corners of a square, 200mm across,
+// and the other two in the air over its middle.
+var square = struct {
+	feet  [6]math3d.Vector3
+	swing [6]bool
+}{
+	feet: [6]math3d.Vector3{
+		{X: -100, Z: 100},
+		{X: 100, Z: 100},
+		{X: 20, Y: 40},
+		{X: 100, Z: -100},
+		{X: -100, Z: -100},
+		{X: -20, Y: 40},
+	},
+	swing: [6]bool{false, false, true, false, false, true},
+}
+
+// tripod is a stance with three feet on the corners of an equilateral
+// triangle, 200mm from its middle (so its edges are 100mm from it), and the
+// other three in the air.
+var tripod = struct {
+	feet  [6]math3d.Vector3
+	swing [6]bool
+}{
+	feet: [6]math3d.Vector3{
+		{X: 0, Z: 200},
+		{X: 10, Y: 40, Z: 10},
+		{X: 200 * math.Cos(math.Pi/6), Z: -100},
+		{X: 10, Y: 40, Z: -10},
+		{X: -200 * math.Cos(math.Pi/6), Z: -100},
+		{X: -10, Y: 40},
+	},
+	swing: [6]bool{false, true, false, true, false, true},
+}
+
+func TestSupportPolygon(t *testing.T) {
+	p := supportPolygon(square.feet, square.swing)
+	assert.Equal(t, 4, p.n)
+
+	// Anticlockwise, so every corner turns the same way.
+	for i := 0; i < p.n; i++ {
+		assert.Greater(t, cross(p.pts[i], p.pts[(i+1)%p.n], p.pts[(i+2)%p.n]), 0.0)
+	}
+
+	// A foot inside the others isn't a corner, and nor is one in line with
+	// two others.
+	feet := square.feet
+	feet[2] = math3d.Vector3{X: 10, Z: 20}
+	feet[5] = math3d.Vector3{X: 100}
+	assert.Equal(t, 4, supportPolygon(feet, [6]bool{}).n)
+
+	assert.Equal(t, 3, supportPolygon(tripod.feet, tripod.swing).n)
+
+	// Three feet in a line are only a line.
+	feet = [6]math3d.Vector3{{X: -100}, {}, {X: 100}}
+	assert.Equal(t, 2, supportPolygon(feet, [6]bool{false, false, false, true, true, true}).n)
+}
+
+func TestMargin(t *testing.T) {
+	sq := supportPolygon(square.feet, square.swing)
+	tri := supportPolygon(tripod.feet, tripod.swing)
+
+	for _, tc := range []struct {
+		name string
+		p    polygon
+		com  math3d.Vector3
+		want float64
+	}{
+		{"middle of the square", sq, math3d.Vector3{}, 100},
+		{"off center", sq, math3d.Vector3{X: 50, Z: -20}, 50},
+		{"height doesn't matter", sq, math3d.Vector3{X: 50, Y: 200}, 50},
+		{"on an edge", sq, math3d.Vector3{X: 100, Z: 30}, 0},
+		{"outside an edge", sq, math3d.Vector3{X: 150}, -50},
+		{"outside a corner", sq, math3d.Vector3{X: 130, Z: -140}, -50},
+		{"middle of the tripod", tri, math3d.Vector3{}, 100},
+		{"towards a corner of the tripod", tri, math3d.Vector3{Z: 100}, 50},
+		{"behind the tripod", tri, math3d.Vector3{Z: -130}, -30},
+		{"two feet", polygon{pts: [6]math3d.Vector3{{X: -100}, {X: 100}}, n: 2}, math3d.Vector3{X: 30, Z: 40}, -40},
+		{"beyond the end of two feet", polygon{pts: [6]math3d.Vector3{{X: -100}, {X: 100}}, n: 2}, math3d.Vector3{X: 130, Z: 40}, -50},
+		{"one foot", polygon{pts: [6]math3d.Vector3{{X: 10, Z: 10}}, n: 1}, math3d.Vector3{X: 40, Z: 50}, -50},
+		{"one foot, right under it", polygon{pts: [6]math3d.Vector3{{X: 10, Z: 10}}, n: 1}, math3d.Vector3{X: 10, Z: 10}, 0},
+		{"no feet", polygon{}, math3d.Vector3{X: 40, Z: 50}, 0},
+	} {
+		assert.InDelta(t, tc.want, tc.p.margin(tc.com), 1e-9, tc.name)
+	}
+}
+
+func TestUpdateStability(t *testing.T) {
+	l := &Legs{
+		cfg:   config.Legs{Chassis: config.Chassis{CenterOfMass: [3]float64{0, 30, -10}}},
+		feet:  square.feet,
+		swing: square.swing,
+	}
+
+	// The center of mass is behind the origin, and moves with the pose and
+	// the offset, but not the clearance.
+	state := &hexapod.State{}
+	state.Pose.Position = math3d.Vector3{X: 20, Y: 60}
+	state.Offset = math3d.Vector3{Z: -50}
+	l.updateStability(state)
+	assert.Equal(t, 4, state.Stability.Planted)
+	assert.InDelta(t, 40, state.Stability.Margin, 1e-9)
+
+	// Tilting the body moves it, since it's above the origin, and the offset
+	// tilts with the body. Pitching by 10 degrees brings both of them towards
+	// the front: the offset to Z=-50cos(10), and the center of mass another
+	// 10cos(10) - 30sin(10) behind that, from the rear edge at Z=-100.
+	state.Pose.Pitch = 10
+	l.updateStability(state)
+	pitch := utils.Rad(10)
+	assert.InDelta(t, 100-60*math.Cos(pitch)+30*math.Sin(pitch), state.Stability.Margin, 1e-9)
+
+	// Outside the tripod.
+	l.feet, l.swing = tripod.feet, tripod.swing
+	state.Pose = math3d.Pose{Position: math3d.Vector3{Z: -150}}
+	state.Offset = math3d.Vector3{}
+	l.updateStability(state)
+	assert.Equal(t, 3, state.Stability.Planted)
+	assert.InDelta(t, -60, state.Stability.Margin, 1e-9)
+}
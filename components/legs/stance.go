@@ -0,0 +1,205 @@
+package legs
+
+import (
+	"math"
+	"strings"
+	"time"
+
+	"github.com/adammck/hexapod"
+	"github.com/adammck/hexapod/math3d"
+)
+
+// How far (in mm, on the X/Z plane) a foot can be from its home position and
+// still count as there, when re-centring the stance.
+const recentreTolerance = 1.0
+
+// stance is where the feet were placed, and the foot which is being moved to
+// its new home position, if any. See reposition.
+type stance struct {
+
+	// The step radius which each foot was most recently placed at.
+	placed [6]float64
+
+	// Whether a foot is being moved, which one, how many ticks it has been
+	// moving for, and where (in the world space) from and to.
+	moving   bool
+	leg      int
+	tick     int
+	from, to math3d.Vector3
+
+	// Whether the feet are being re-centred under the body, and when they were
+	// first displaced from there by more than the recentre distance, or zero if
+	// they aren't. See recentre.
+	recentring bool
+	displaced  time.Time
+}
+
+// recentre decides whether to re-centre the stance, which moves every foot
+// which isn't at its home position under the body (including the offset) back
+// there, one at a time, and then folds the offset into the pose. That's after
+// the feet have been displaced by more than the recentre distance, on average,
+// for the recentre delay, or when requested (see State.Recentre).
+//
+// It never starts while walking, or while anything else is holding the body in
+// a pose (which keeps the legs awake, like the controller's presets), or while
+// resting, and stops (once the foot which is moving is down) if any of those
+// start.
+func (l *Legs) recentre(now time.Time, state *hexapod.State, parked bool) {
+	s := &l.stance
+	requested := state.Recentre
+	state.Recentre = false
+
+	reason := ""
+	switch {
+	case !parked || state.Shutdown:
+		reason = "walking"
+	case state.KeepAwake:
+		reason = "holding a pose"
+	case state.Resting:
+		reason = "resting"
+	}
+
+	if reason != "" {
+		if requested {
+			log.Warnf("not re-centring the stance: %s", reason)
+		}
+		if s.recentring {
+			log.Warnf("stopped re-centring the stance: %s", reason)
+		}
+
+		s.recentring = false
+		s.displaced = time.Time{}
+		return
+	}
+
+	if s.recentring {
+		return
+	}
+
+	if requested {
+		log.Info("re-centring the stance, as requested")
+		s.recentring = true
+		return
+	}
+
+	d := l.displacement(state)
+	if l.cfg.RecentreDistance == 0 || d <= l.cfg.RecentreDistance {
+		s.displaced = time.Time{}
+		return
+	}
+
+	if s.displaced.IsZero() {
+		s.displaced = now
+	}
+
+	if now.Sub(s.displaced) >= l.cfg.RecentreAfter.Duration {
+		log.Infof("re-centring the stance, since the feet are %.0fmm from their home positions", d)
+		s.recentring = true
+	}
+}
+
+// displacement returns the average distance (in mm, on the X/Z plane) of the
+// feet from their home positions, with the chassis where it is on them.
+func (l *Legs) displacement(state *hexapod.State) float64 {
+	var sum float64
+	for i := range l.Legs {
+		sum += flat(l.feet[i]).Distance(flat(l.homeFootPosition(&state.Offset, i, l.target)))
+	}
+
+	return sum / float64(len(l.Legs))
+}
+
+// recentred finishes re-centring the stance, by moving the pose to where the
+// offset put the chassis, and clearing the offset, so the chassis stays where
+// it is, over the feet. Only the X/Z offset is folded; the Y is a height.
+func (l *Legs) recentred(state *hexapod.State) {
+	off := math3d.Vector3{X: state.Offset.X, Z: state.Offset.Z}
+	level := math3d.Pose{Position: l.target.Position, Heading: l.target.Heading}
+	p := level.Add(math3d.Pose{Position: off}).Position
+
+	l.target.Position.X, l.target.Position.Z = p.X, p.Z
+	state.Pose.Position.X, state.Pose.Position.Z = p.X, p.Z
+	state.Offset.X, state.Offset.Z = 0, 0
+
+	log.Infof("re-centred the stance, and folded the offset (%v) into the pose", off)
+	state.Publish(hexapod.EventStanceRecentred, hexapod.Info, off.Magnitude())
+	l.stance.recentring = false
+	l.stance.displaced = time.Time{}
+}
+
+// flat returns the vector with the Y component zeroed.
+func flat(v math3d.Vector3) math3d.Vector3 {
+	v.Y = 0
+	return v
+}
+
+// reposition moves the feet to their home positions one at a time while parked,
+// if any leg's step radius has changed since its foot was placed. Each foot is
+// lifted to the step height and put down at its new home over the base ticks
+// per step, like a very slow wave gait. Dragging the feet would scuff them (and
+// the floor), and lifting more than one at once might drop the body.
+//
+// While re-centring (see recentre), each foot which isn't at its home position
+// is moved the same way, and once none are left, the offset is folded into the
+// pose.
+//
+// The move in progress is always finished, but no more are started unless
+// start is true. It returns true while a foot is moving.
+func (l *Legs) reposition(state *hexapod.State, start bool) bool {
+	s := &l.stance
+
+	if !s.moving {
+		if !start {
+			return false
+		}
+
+		for i, leg := range l.Legs {
+			r := l.step.radius(i)
+			to := l.homeFootPosition(&state.Offset, i, l.target)
+			off := flat(l.feet[i]).Distance(to)
+			if r == s.placed[i] && !(s.recentring && off > recentreTolerance) {
+				continue
+			}
+
+			if r != s.placed[i] {
+				log.Infof("moving %s foot from step radius %v to %v", leg.Name, s.placed[i], r)
+			} else {
+				log.Infof("moving %s foot %.0fmm back to its home position", leg.Name, off)
+			}
+
+			s.moving, s.leg, s.tick = true, i, 0
+			s.from = l.feet[i]
+			s.to = to
+			s.placed[i] = r
+			break
+		}
+
+		if !s.moving {
+			if s.recentring {
+				l.recentred(state)
+			}
+			return false
+		}
+	}
+
+	s.tick++
+	r := math.Min(float64(s.tick)/float64(l.step.ticksPerStep), 1)
+
+	f := *s.from.Add(s.to.Subtract(s.from).MultiplyByScalar(r))
+	f.Y = l.step.stepHeight * math.Sin(math.Pi*r)
+	l.feet[s.leg] = f
+	l.swing[s.leg] = r < 1
+
+	if r >= 1 {
+		l.feet[s.leg] = s.to
+		s.moving = false
+	}
+
+	return true
+}
+
+// radiusParam returns the name of the param which sets the step radius of the
+// given leg.
+func radiusParam(leg *Leg) string {
+	return "legs.step_radius_" + strings.ToLower(leg.Name)
+}
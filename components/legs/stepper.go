@@ -0,0 +1,101 @@
+package legs
+
+import (
+	"github.com/adammck/hexapod"
+)
+
+// stepper clocks the step cycle, i.e. decides whether each tick plays the next
+// frame of the gait. It always does, unless the gait has been paused (see
+// config.Gait.Debug), in which case the feet are frozen until it's told to play
+// up to the next phase boundary or the end of the cycle, at the usual rate of
+// a frame per tick, so the servos move at their usual speed. It's separate from
+// the legs so it can be tested without any servos.
+type stepper struct {
+	enabled bool
+	paused  bool
+
+	// Where the paused gait is playing up to, which is one of the step
+	// requests, or none to stay frozen.
+	until hexapod.GaitStepRequest
+
+	// The step cycles walked since boot.
+	cycles int
+}
+
+// request applies the given step request. Stepping while walking pauses at the
+// end of the step, rather than freezing straight away.
+func (s *stepper) request(r hexapod.GaitStepRequest) {
+	switch r {
+	case hexapod.GaitPause:
+		s.paused = true
+		s.until = hexapod.GaitStepNone
+
+	case hexapod.GaitResume:
+		s.paused = false
+		s.until = hexapod.GaitStepNone
+
+	case hexapod.GaitStepPhase, hexapod.GaitStepCycle:
+		s.paused = true
+		s.until = r
+	}
+}
+
+// advance returns whether the gait should play the next frame on this tick.
+func (s *stepper) advance() bool {
+	return !s.paused || s.until != hexapod.GaitStepNone
+}
+
+// played is called after each tick which played a frame, with the number of
+// the cycle's ticks played so far (zero if that was the last), and the ticks
+// per step. Once the paused gait reaches what it's playing up to, it's frozen
+// again. A tick which wasn't part of a step cycle (because there was nowhere
+// to walk) ends the cycle straight away.
+func (s *stepper) played(tick, tps int) {
+	if !s.paused || s.until == hexapod.GaitStepNone {
+		return
+	}
+
+	if tick == 0 || (s.until == hexapod.GaitStepPhase && tps > 0 && tick%tps == 0) {
+		s.until = hexapod.GaitStepNone
+	}
+}
+
+// status returns where the legs are in the step cycle, given the number of the
+// cycle's ticks played so far, the ticks per step, and the number in the cycle.
+func (s *stepper) status(tick, tps, length int) hexapod.GaitDebug {
+	if !s.enabled {
+		return hexapod.GaitDebug{}
+	}
+
+	phase := 0
+	if tps > 0 {
+		phase = tick / tps
+	}
+
+	return hexapod.GaitDebug{
+		Enabled: true,
+		Paused:  s.paused,
+		Tick:    tick,
+		Length:  length,
+		Phase:   phase,
+		Cycles:  s.cycles,
+	}
+}
+
+// updateStepper applies the state's step request, if any, unless stepping
+// through the gait isn't enabled.
+func (l *Legs) updateStepper(state *hexapod.State) {
+	r := state.GaitStep
+	if r == hexapod.GaitStepNone {
+		return
+	}
+
+	state.GaitStep = hexapod.GaitStepNone
+	if !l.stepper.enabled {
+		log.Warnf("ignoring gait %s, since gait.debug isn't set", r)
+		return
+	}
+
+	log.Infof("gait %s", r)
+	l.stepper.request(r)
+}
@@ -0,0 +1,72 @@
+package legs
+
+import (
+	"testing"
+
+	"github.com/adammck/hexapod"
+	"github.com/stretchr/testify/assert"
+)
+
+// clock drives a stepper like the legs do, through a cycle of three steps of
+// ten ticks each, and returns the status after each tick.
+type clock struct {
+	s    stepper
+	tick int
+}
+
+func (c *clock) run(n int) hexapod.GaitDebug {
+	for i := 0; i < n; i++ {
+		if c.s.advance() {
+			c.tick++
+			if c.tick >= 30 {
+				c.tick = 0
+				c.s.cycles++
+			}
+			c.s.played(c.tick, 10)
+		}
+	}
+
+	return c.s.status(c.tick, 10, 30)
+}
+
+func TestStepper(t *testing.T) {
+	c := &clock{s: stepper{enabled: true}}
+
+	// Walking as usual, until it's paused.
+	assert.Equal(t, hexapod.GaitDebug{Enabled: true, Tick: 14, Length: 30, Phase: 1}, c.run(14))
+	c.s.request(hexapod.GaitPause)
+	assert.Equal(t, hexapod.GaitDebug{Enabled: true, Paused: true, Tick: 14, Length: 30, Phase: 1}, c.run(100))
+
+	// Stepping a phase plays up to the end of the step which it was paused in.
+	c.s.request(hexapod.GaitStepPhase)
+	assert.Equal(t, 20, c.run(100).Tick)
+	c.s.request(hexapod.GaitStepPhase)
+	assert.Equal(t, hexapod.GaitDebug{Enabled: true, Paused: true, Tick: 0, Length: 30, Phase: 0, Cycles: 1}, c.run(100))
+
+	// Stepping a cycle plays up to the end of the cycle, from a boundary or not.
+	c.s.request(hexapod.GaitStepCycle)
+	assert.Equal(t, hexapod.GaitDebug{Enabled: true, Paused: true, Tick: 0, Length: 30, Phase: 0, Cycles: 2}, c.run(100))
+	c.s.request(hexapod.GaitStepPhase)
+	assert.Equal(t, 1, c.run(100).Phase)
+	c.s.request(hexapod.GaitStepCycle)
+	assert.Equal(t, hexapod.GaitDebug{Enabled: true, Paused: true, Tick: 0, Length: 30, Phase: 0, Cycles: 3}, c.run(100))
+
+	// Stepping while walking pauses at the end of the step.
+	c.s.request(hexapod.GaitResume)
+	assert.Equal(t, hexapod.GaitDebug{Enabled: true, Tick: 5, Length: 30, Phase: 0, Cycles: 3}, c.run(5))
+	c.s.request(hexapod.GaitStepPhase)
+	assert.Equal(t, hexapod.GaitDebug{Enabled: true, Paused: true, Tick: 10, Length: 30, Phase: 1, Cycles: 3}, c.run(100))
+
+	// And resuming carries on from there.
+	c.s.request(hexapod.GaitResume)
+	assert.Equal(t, hexapod.GaitDebug{Enabled: true, Tick: 0, Length: 30, Phase: 0, Cycles: 5}, c.run(50))
+}
+
+func TestStepperDisabled(t *testing.T) {
+	l := &Legs{}
+	state := &hexapod.State{GaitStep: hexapod.GaitPause}
+	l.updateStepper(state)
+	assert.Equal(t, hexapod.GaitStepNone, state.GaitStep)
+	assert.True(t, l.stepper.advance())
+	assert.Equal(t, hexapod.GaitDebug{}, l.stepper.status(14, 10, 30))
+}
@@ -0,0 +1,106 @@
+package legs
+
+import (
+	"fmt"
+
+	"github.com/adammck/dynamixel/network"
+	"github.com/adammck/hexapod/config"
+	"github.com/adammck/hexapod/servos"
+)
+
+// The AX-12 compliance registers. Each is a pair of one byte registers, for
+// the clockwise and then counterclockwise directions, so both are written at
+// once, as if they were a single two byte register.
+const (
+	complianceMarginAddr = 0x1a
+	complianceSlopeAddr  = 0x1c
+)
+
+// stiffness switches the compliance of the servos between the presets (see
+// config.Stiffness). A switch waits until every foot is on the ground, since
+// changing the compliance of a leg mid-swing would jerk it, and the registers
+// are cached, so only those which differ between the presets are written.
+type stiffness struct {
+	cfg config.Stiffness
+
+	// The preset which the servos are set to (or empty, until the first has
+	// been applied), and the one to switch to, or empty if there isn't one.
+	active  string
+	pending string
+
+	margins *servos.Registers
+	slopes  *servos.Registers
+}
+
+// newStiffness returns the stiffness of the given config, which switches to its
+// initial preset as soon as every foot is on the ground.
+func newStiffness(cfg config.Stiffness) stiffness {
+	return stiffness{
+		cfg:     cfg,
+		pending: cfg.Preset,
+		margins: servos.NewRegisters(complianceMarginAddr),
+		slopes:  servos.NewRegisters(complianceSlopeAddr),
+	}
+}
+
+// request queues a switch to the named preset, replacing any switch which is
+// already waiting.
+func (s *stiffness) request(name string) error {
+	if _, ok := s.cfg.Compliance(name); !ok {
+		return fmt.Errorf("no such stiffness preset: %s", name)
+	}
+
+	s.pending = name
+	if name == s.active {
+		s.pending = ""
+	}
+
+	return nil
+}
+
+// update queues the writes to switch the servos of the given legs to the
+// pending preset, if there is one and none of the legs are swinging, and
+// returns true if it did.
+func (s *stiffness) update(legs [6]*Leg, swing [6]bool) bool {
+	if s.pending == "" {
+		return false
+	}
+
+	for _, sw := range swing {
+		if sw {
+			return false
+		}
+	}
+
+	c, _ := s.cfg.Compliance(s.pending)
+	for _, leg := range legs {
+		for i, j := range leg.joints() {
+			s.margins.Set(j.ID, both(c.Margin[i]))
+			s.slopes.Set(j.ID, both(c.Slope[i]))
+		}
+	}
+
+	s.active = s.pending
+	s.pending = ""
+	return true
+}
+
+// writeTo sends the queued writes, if there are any.
+func (s *stiffness) writeTo(n *network.Network) error {
+	err := s.margins.WriteTo(n)
+	if err != nil {
+		return fmt.Errorf("%s (while setting compliance margins)", err)
+	}
+
+	err = s.slopes.WriteTo(n)
+	if err != nil {
+		return fmt.Errorf("%s (while setting compliance slopes)", err)
+	}
+
+	return nil
+}
+
+// both returns the given value of a one byte register twice, for a pair of them.
+func both(v int) int {
+	return v | v<<8
+}
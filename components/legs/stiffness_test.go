@@ -0,0 +1,130 @@
+package legs
+
+import (
+	"testing"
+
+	"github.com/adammck/dynamixel/network"
+	"github.com/adammck/hexapod"
+	"github.com/adammck/hexapod/components/legs/gait"
+	"github.com/adammck/hexapod/config"
+	"github.com/stretchr/testify/assert"
+)
+
+// nopSerial is a serial port which nothing ever replies to.
+type nopSerial struct{}
+
+func (nopSerial) Read(b []byte) (int, error)  { return 0, nil }
+func (nopSerial) Write(b []byte) (int, error) { return len(b), nil }
+func (nopSerial) Close() error                { return nil }
+
+// registers returns the value of the (paired) compliance register of every
+// servo which the given preset of the default config sets, by ID, for the
+// margin or the slope.
+func registers(name string, slope bool) map[int]int {
+	c, _ := config.Default().Legs.Stiffness.Compliance(name)
+	vs := c.Margin
+	if slope {
+		vs = c.Slope
+	}
+
+	out := map[int]int{}
+	for _, p := range layout {
+		for i, v := range vs {
+			out[p.baseID+1+i] = v | v<<8
+		}
+	}
+
+	return out
+}
+
+func TestStiffnessPresets(t *testing.T) {
+	for _, name := range config.StiffnessPresets {
+		s := newStiffness(config.Default().Legs.Stiffness)
+		assert.NoError(t, s.request(name), name)
+		assert.True(t, s.update(bareLegs(), [6]bool{}), name)
+		assert.Equal(t, name, s.active)
+
+		// Every register of every servo, the first time.
+		assert.Equal(t, registers(name, false), s.margins.Pending(), name)
+		assert.Equal(t, registers(name, true), s.slopes.Pending(), name)
+	}
+
+	s := newStiffness(config.Default().Legs.Stiffness)
+	assert.EqualError(t, s.request("squishy"), "no such stiffness preset: squishy")
+}
+
+func TestStiffnessCached(t *testing.T) {
+	n := network.New(nopSerial{})
+	s := newStiffness(config.Default().Legs.Stiffness)
+	assert.True(t, s.update(bareLegs(), [6]bool{}))
+	assert.Equal(t, "normal", s.active)
+	assert.NoError(t, s.writeTo(n))
+
+	// From normal to stiff, every margin changes, but only the slopes of the
+	// coxae and tarsi.
+	assert.NoError(t, s.request("stiff"))
+	assert.True(t, s.update(bareLegs(), [6]bool{}))
+	assert.Equal(t, registers("stiff", false), s.margins.Pending())
+
+	slopes := map[int]int{}
+	for _, p := range layout {
+		slopes[p.baseID+1] = 16 | 16<<8
+		slopes[p.baseID+4] = 16 | 16<<8
+	}
+	assert.Equal(t, slopes, s.slopes.Pending())
+	assert.NoError(t, s.writeTo(n))
+
+	// Asking for the active preset does nothing.
+	assert.NoError(t, s.request("stiff"))
+	assert.False(t, s.update(bareLegs(), [6]bool{}))
+	assert.Empty(t, s.margins.Pending())
+	assert.Empty(t, s.slopes.Pending())
+}
+
+func TestStiffnessDeferred(t *testing.T) {
+	l := &Legs{Legs: bareLegs(), stiffness: newStiffness(config.Default().Legs.Stiffness)}
+	g, err := gait.Make(gait.Tripod, 20, 0)
+	assert.NoError(t, err)
+
+	// Step through a couple of cycles of the tripod gait, asking for the soft
+	// preset on the fifth tick, while half of the legs are in the air.
+	state := &hexapod.State{}
+	changed := -1
+	for i := 0; i < g.Length()*2; i++ {
+		for j := range l.swing {
+			l.swing[j] = g.Frame(j, i%g.Length()).Swing
+		}
+
+		if i == 5 {
+			state.SetStiffness = "soft"
+		}
+
+		before := state.Stiffness
+		l.updateStiffness(state)
+		assert.Empty(t, state.SetStiffness)
+
+		// The first is applied straight away, since the feet start down.
+		if i == 0 {
+			assert.Equal(t, "normal", state.Stiffness)
+			continue
+		}
+
+		if state.Stiffness != before {
+			if changed < 0 {
+				changed = i
+			}
+
+			for _, sw := range l.swing {
+				assert.False(t, sw, "switched mid-swing, on tick %d", i)
+			}
+		}
+	}
+
+	// It's applied once every foot is down again, at the end of the step.
+	assert.Equal(t, "soft", state.Stiffness)
+	assert.Equal(t, 20, changed)
+	if ev := state.Published(); assert.Len(t, ev, 2) {
+		assert.Equal(t, hexapod.EventStiffnessChanged, ev[1].Name)
+		assert.Equal(t, "soft", ev[1].Payload)
+	}
+}
@@ -0,0 +1,126 @@
+package legs
+
+import (
+	"math"
+	"time"
+
+	"github.com/adammck/hexapod"
+	"github.com/adammck/hexapod/config"
+	"github.com/adammck/hexapod/math3d"
+)
+
+// terrain remembers the height of the ground under each foot, from where it was
+// measured to be each time it landed, so it's put down there next time. See
+// config.Terrain.
+//
+// The feet are planned at Y=0, as if the ground were flat, and this raises (or
+// lowers) each one onto the ground it remembers, so a foot which lands on a
+// step doesn't lift that corner of the body, and the fitted ground (which the
+// clearance is above) is the average of them. It doesn't level the body itself;
+// the pitch and bank are still the pose's, which is where an IMU comes in.
+type terrain struct {
+	cfg config.Terrain
+
+	// The height under each foot, in the same order as Legs, the lowest
+	// which each has been measured at since it last landed, and whether it
+	// has been, and when the heights last decayed, or zero if they never
+	// have.
+	heights [6]float64
+	low     [6]float64
+	landed  [6]bool
+	last    time.Time
+}
+
+// touchdown averages the height which the given foot was measured at, while it
+// was down, into the one remembered for it.
+func (t *terrain) touchdown(i int, y float64) {
+	t.heights[i] += (y - t.heights[i]) / float64(t.cfg.Touchdowns)
+}
+
+// flat returns true if the heights are all within the flat distance of each
+// other.
+func (t *terrain) flat() bool {
+	lo, hi := t.heights[0], t.heights[0]
+	for _, h := range t.heights[1:] {
+		lo = math.Min(lo, h)
+		hi = math.Max(hi, h)
+	}
+
+	return hi-lo <= t.cfg.Flat
+}
+
+// decay moves the heights towards zero, as of the given time, if the ground is
+// flat or the hex is parked.
+func (t *terrain) decay(now time.Time, parked bool) {
+	if t.last.IsZero() {
+		t.last = now
+		return
+	}
+
+	dt := now.Sub(t.last)
+	if dt <= 0 {
+		return
+	}
+
+	t.last = now
+	if t.cfg.Decay.Duration <= 0 || !(parked || t.flat()) {
+		return
+	}
+
+	f := math.Exp(-dt.Seconds() / t.cfg.Decay.Seconds())
+	for i := range t.heights {
+		t.heights[i] *= f
+	}
+}
+
+// reset forgets the heights, e.g. after standing up again somewhere else.
+func (t *terrain) reset() {
+	t.heights = [6]float64{}
+	t.low = [6]float64{}
+	t.landed = [6]bool{}
+	t.last = time.Time{}
+}
+
+// foot returns where to put the given foot, which was planned as if the ground
+// were at Y=0, on the ground remembered under it.
+func (t *terrain) foot(i int, v math3d.Vector3) math3d.Vector3 {
+	v.Y += t.heights[i]
+	return v
+}
+
+// updateTerrain averages the height of each foot which has lifted since the
+// last tick into the height remembered under it, and decays them. That's the
+// lowest it was measured at (see measuredFeet) while it was down, rather than
+// the first, since it's often still coming down for a few ticks after it lands,
+// or slowed by the servos. Without the feedback, they're never measured, so
+// stay at zero.
+func (l *Legs) updateTerrain(now time.Time, state *hexapod.State) {
+	t := &l.terrain
+	if !t.cfg.Enabled {
+		return
+	}
+
+	feet, unknown := l.measuredFeet(state)
+	for i := range feet {
+		if l.swing[i] {
+			if t.landed[i] {
+				t.touchdown(i, t.low[i])
+			}
+
+			t.landed[i] = false
+			continue
+		}
+
+		if unknown[i] {
+			continue
+		}
+
+		if !t.landed[i] || feet[i].Y < t.low[i] {
+			t.low[i] = feet[i].Y
+		}
+
+		t.landed[i] = true
+	}
+
+	t.decay(now, !l.walking)
+}
@@ -0,0 +1,164 @@
+package legs
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/adammck/hexapod"
+	"github.com/adammck/hexapod/config"
+	"github.com/adammck/hexapod/math3d"
+	"github.com/adammck/hexapod/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+// stepped returns the height of a ground with a 30mm step across it, under the
+// front feet (at their home positions) but not the rest.
+func stepped(v math3d.Vector3) float64 {
+	if v.Z > 50 {
+		return 30
+	}
+
+	return 0
+}
+
+// land puts the given feet down on the stepped ground, where the terrain says,
+// or wherever the ground stops them on the way there, measures them, and then
+// lifts them all again.
+func land(l *Legs, now time.Time, state *hexapod.State, planned [6]math3d.Vector3) {
+	var feet [6]math3d.Vector3
+	for i, f := range planned {
+		v := l.terrain.foot(i, f)
+		v.Y = math.Max(v.Y, stepped(v))
+		feet[i] = v
+	}
+
+	l.swing = [6]bool{}
+	measure(l, state, feet)
+	l.updateTerrain(now, state)
+
+	l.swing = [6]bool{true, true, true, true, true, true}
+	l.updateTerrain(now, state)
+}
+
+// tipped returns the pitch (in degrees) which the body is tipped by when the
+// given feet are put down where the terrain says on the stepped ground. Those
+// which land before they get there lift the body by the difference, so it
+// tilts to the plane through those differences.
+func tipped(t *terrain, planned [6]math3d.Vector3) float64 {
+	var lift [6]math3d.Vector3
+	for i, f := range planned {
+		v := t.foot(i, f)
+		lift[i] = math3d.Vector3{X: v.X, Y: math.Max(0, stepped(v)-v.Y), Z: v.Z}
+	}
+
+	return utils.Deg(math.Atan(fitGround(lift, [6]bool{}).b))
+}
+
+func newTerrainLegs(cfg config.Terrain) (*Legs, *hexapod.State) {
+	l := &Legs{Legs: bareLegs(), terrain: terrain{cfg: cfg}, walking: true}
+	state := &hexapod.State{}
+	state.Pose.Position.Y = 60
+	return l, state
+}
+
+// terrainConfig returns the default config of the terrain, but enabled.
+func terrainConfig() config.Terrain {
+	cfg := config.Default().Legs.Terrain
+	cfg.Enabled = true
+	return cfg
+}
+
+func TestTerrainStep(t *testing.T) {
+	l, state := newTerrainLegs(terrainConfig())
+	feet := homeFeet(ground{})
+	now := time.Unix(100, 0)
+
+	// Without any memory, the front feet hit the step before they're down, and
+	// tip the body back.
+	before := math.Abs(tipped(&l.terrain, feet))
+	assert.Greater(t, before, 3.0)
+
+	// After a few steps, they're put down on it instead, and the body stays
+	// (almost) level.
+	for n := 0; n < 5; n++ {
+		now = now.Add(time.Second)
+		land(l, now, state, feet)
+	}
+
+	after := math.Abs(tipped(&l.terrain, feet))
+	assert.Less(t, after, before/5)
+
+	for i, f := range feet {
+		assert.InDelta(t, stepped(f), l.terrain.heights[i], 5, "foot %d", i)
+	}
+
+	// Each landing only counts once, when the foot lifts, however long it stays
+	// down, at the lowest it was measured at, e.g. after the step has been taken
+	// away, and the foot comes down further than it was put.
+	h := l.terrain.heights[0]
+	l.swing = [6]bool{}
+	for _, y := range []float64{10, 0, 0} {
+		down := feet
+		for i := range down {
+			down[i].Y = y
+		}
+
+		measure(l, state, down)
+		l.updateTerrain(now, state)
+		assert.Equal(t, h, l.terrain.heights[0])
+	}
+
+	l.swing = [6]bool{true, true, true, true, true, true}
+	l.updateTerrain(now, state)
+	assert.InDelta(t, h*2/3, l.terrain.heights[0], 1e-6)
+}
+
+func TestTerrainDecay(t *testing.T) {
+	cfg := terrainConfig()
+	l, state := newTerrainLegs(cfg)
+	feet := homeFeet(ground{})
+	now := time.Unix(100, 0)
+	for n := 0; n < 5; n++ {
+		land(l, now, state, feet)
+	}
+
+	// While walking over the step, the heights are kept.
+	h := l.terrain.heights
+	now = now.Add(10 * cfg.Decay.Duration)
+	l.updateTerrain(now, state)
+	assert.Equal(t, h, l.terrain.heights)
+
+	// Once parked, they decay towards zero, by the time constant.
+	l.walking = false
+	now = now.Add(cfg.Decay.Duration)
+	l.updateTerrain(now, state)
+	assert.InDelta(t, h[0]/math.E, l.terrain.heights[0], 1e-9)
+
+	now = now.Add(10 * cfg.Decay.Duration)
+	l.updateTerrain(now, state)
+	for i := range feet {
+		assert.InDelta(t, 0, l.terrain.heights[i], 0.01, "foot %d", i)
+	}
+
+	// And while walking over ground which is (nearly) flat.
+	l.walking = true
+	l.terrain.heights = [6]float64{3, 4, 2, 3, 1, 5}
+	now = now.Add(10 * cfg.Decay.Duration)
+	l.updateTerrain(now, state)
+	for i := range feet {
+		assert.InDelta(t, 0, l.terrain.heights[i], 0.01, "foot %d", i)
+	}
+}
+
+func TestTerrainDisabled(t *testing.T) {
+	cfg := config.Default().Legs.Terrain
+	cfg.Enabled = false
+	l, state := newTerrainLegs(cfg)
+	feet := homeFeet(ground{})
+	for n := 0; n < 5; n++ {
+		land(l, time.Unix(100, 0), state, feet)
+	}
+
+	assert.Equal(t, [6]float64{}, l.terrain.heights)
+}
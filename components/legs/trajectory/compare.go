@@ -0,0 +1,83 @@
+package trajectory
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Deviation summarizes how far the trajectory of a single leg strayed from the
+// expected one.
+type Deviation struct {
+	Leg string
+
+	// The largest distance (in mm) between the expected and actual positions,
+	// and the first tick at which it happened.
+	Max  float64
+	Tick int
+
+	// The number of ticks at which the foot was swinging when it should have
+	// been on the ground, or vice versa.
+	Swings int
+}
+
+func (d Deviation) String() string {
+	return fmt.Sprintf("%s: max deviation %0.3fmm at tick %d, %d swing/stance changes", d.Leg, d.Max, d.Tick, d.Swings)
+}
+
+// Compare returns the deviation of each leg (in the order they first appear in
+// want) of the actual trajectory from the expected one. It's an error if they
+// don't have the same ticks and legs, since the positions can't be compared.
+func Compare(want, got []Sample) ([]Deviation, error) {
+	if len(want) != len(got) {
+		return nil, fmt.Errorf("expected %d samples, got %d", len(want), len(got))
+	}
+
+	idx := map[string]int{}
+	out := []Deviation{}
+
+	for i := range want {
+		w, g := want[i], got[i]
+		if w.Tick != g.Tick || w.Leg != g.Leg {
+			return nil, fmt.Errorf("expected sample %d to be %s at tick %d, got %s at tick %d", i, w.Leg, w.Tick, g.Leg, g.Tick)
+		}
+
+		j, ok := idx[w.Leg]
+		if !ok {
+			j = len(out)
+			idx[w.Leg] = j
+			out = append(out, Deviation{Leg: w.Leg})
+		}
+
+		d := &out[j]
+		dist := w.Foot.Distance(g.Foot)
+		if dist > d.Max {
+			d.Max = dist
+			d.Tick = w.Tick
+		}
+
+		if w.Swing != g.Swing {
+			d.Swings += 1
+		}
+	}
+
+	return out, nil
+}
+
+// Exceeds returns a summary of the deviations, one leg per line, and whether
+// any of them are more than the given tolerance (in mm), or changed between
+// swing and stance at all.
+func Exceeds(devs []Deviation, tolerance float64) (string, bool) {
+	var b strings.Builder
+	bad := false
+
+	for _, d := range devs {
+		if d.Max > tolerance || d.Swings > 0 {
+			bad = true
+		}
+
+		b.WriteString(d.String())
+		b.WriteString("\n")
+	}
+
+	return b.String(), bad
+}
@@ -0,0 +1,102 @@
+package trajectory
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/adammck/hexapod/math3d"
+)
+
+var csvHeader = []string{"tick", "leg", "x", "y", "z", "swing"}
+
+// WriteCSV writes the given samples as CSV, with a header row. Positions are
+// rounded to a thousandth of a mm, which is plenty for plotting, and keeps the
+// golden files stable.
+func WriteCSV(w io.Writer, samples []Sample) error {
+	cw := csv.NewWriter(w)
+
+	err := cw.Write(csvHeader)
+	if err != nil {
+		return err
+	}
+
+	f := func(v float64) string {
+		return strconv.FormatFloat(v, 'f', 3, 64)
+	}
+
+	for _, s := range samples {
+		swing := "0"
+		if s.Swing {
+			swing = "1"
+		}
+
+		err := cw.Write([]string{
+			strconv.Itoa(s.Tick),
+			s.Leg,
+			f(s.Foot.X), f(s.Foot.Y), f(s.Foot.Z),
+			swing,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+// ReadCSV reads the samples from CSV written by WriteCSV.
+func ReadCSV(r io.Reader) ([]Sample, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = len(csvHeader)
+
+	rows, err := cr.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("missing header")
+	}
+
+	out := make([]Sample, 0, len(rows)-1)
+	for i, row := range rows[1:] {
+		s, err := parseRow(row)
+		if err != nil {
+			return nil, fmt.Errorf("%s (at line %d)", err, i+2)
+		}
+
+		out = append(out, s)
+	}
+
+	return out, nil
+}
+
+func parseRow(row []string) (Sample, error) {
+	t, err := strconv.Atoi(row[0])
+	if err != nil {
+		return Sample{}, err
+	}
+
+	var v [3]float64
+	for i := range v {
+		v[i], err = strconv.ParseFloat(row[2+i], 64)
+		if err != nil {
+			return Sample{}, err
+		}
+	}
+
+	swing, err := strconv.ParseBool(row[5])
+	if err != nil {
+		return Sample{}, err
+	}
+
+	return Sample{
+		Tick:  t,
+		Leg:   row[1],
+		Foot:  math3d.Vector3{X: v[0], Y: v[1], Z: v[2]},
+		Swing: swing,
+	}, nil
+}
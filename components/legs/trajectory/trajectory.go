@@ -0,0 +1,150 @@
+// Package trajectory runs the legs against a scripted command sequence, and
+// records where they put the feet, so that changes to the gait can be plotted
+// and compared against golden files.
+package trajectory
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/adammck/dynamixel/network"
+	"github.com/adammck/hexapod"
+	"github.com/adammck/hexapod/components/legs"
+	"github.com/adammck/hexapod/components/sim"
+	"github.com/adammck/hexapod/config"
+	"github.com/adammck/hexapod/math3d"
+	"github.com/adammck/hexapod/params"
+)
+
+const (
+
+	// The tick rate of the fake clock.
+	fps = 60
+
+	// How long (in real time) to wait for the legs to reach their home
+	// positions before giving up.
+	readyTimeout = 10 * time.Second
+
+	// The height (in mm) above the ground, in the world space, above which a
+	// foot counts as swinging rather than on the ground.
+	swingThreshold = 0.01
+)
+
+// Step is one part of a script. For each of its ticks, the target is moved by
+// Move (relative to the target itself), like the controller does with the
+// sticks.
+type Step struct {
+	Ticks int
+	Move  math3d.Pose
+}
+
+// Script is a fixed command sequence to run the legs against. The hex stands
+// up to the clearance during the first step, so that should usually be long
+// enough to finish.
+type Script struct {
+	GaitIndex int
+	Clearance float64
+	Steps     []Step
+}
+
+// Ticks returns the total number of ticks in the script.
+func (s Script) Ticks() int {
+	n := 0
+	for _, st := range s.Steps {
+		n += st.Ticks
+	}
+
+	return n
+}
+
+// Sample is the goal of a single foot at a single tick.
+type Sample struct {
+	Tick int
+	Leg  string
+
+	// The goal position, in the hexapod (body) space, as sent to the servos.
+	Foot math3d.Vector3
+
+	// Whether the foot is off the ground.
+	Swing bool
+}
+
+// Record runs the legs (with the given config) against the script, and returns
+// a sample for each leg at each tick, in order. Time is faked, and the servos
+// are simulated, so the output depends only on the config and the script.
+func Record(cfg config.Config, s Script) ([]Sample, error) {
+	bus := sim.NewBus()
+	n := network.New(bus)
+
+	h := hexapod.NewHexapod(n, fps)
+	h.Params = params.New()
+
+	l := legs.New(n, cfg.Legs, cfg.Gait)
+	l.Params = h.Params
+	h.Add(l)
+
+	err := h.Boot()
+	if err != nil {
+		return nil, fmt.Errorf("%s (while booting)", err)
+	}
+
+	now := time.Unix(0, 0)
+	dt := time.Second / fps
+	tick := func() error {
+		now = now.Add(dt)
+		return h.Tick(now)
+	}
+
+	// The legs wait (in real time, in the background) for the servos to reach
+	// their home positions before doing anything. Nothing is recorded until
+	// then, since the number of ticks it takes isn't predictable.
+	deadline := time.Now().Add(readyTimeout)
+	for l.State == "" {
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("legs weren't ready after %s", readyTimeout)
+		}
+
+		bus.Step(dt)
+		err = tick()
+		if err != nil {
+			return nil, err
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+
+	h.State.GaitIndex = s.GaitIndex
+	h.State.Target.Position.Y = s.Clearance
+
+	out := make([]Sample, 0, s.Ticks()*len(l.Legs))
+	t := 0
+
+	for _, st := range s.Steps {
+		for i := 0; i < st.Ticks; i++ {
+			y := h.State.Target.Position.Y
+			h.State.Target = h.State.Target.Add(st.Move)
+			h.State.Target.Position.Y = y
+			h.State.Target.Heading = math3d.WrapDegrees(h.State.Target.Heading)
+
+			err = tick()
+			if err != nil {
+				return nil, fmt.Errorf("%s (at tick %d)", err, t)
+			}
+
+			w := h.State.World()
+			for j, leg := range l.Legs {
+				f := h.State.Feet[j]
+				out = append(out, Sample{
+					Tick:  t,
+					Leg:   leg.Name,
+					Foot:  f,
+					Swing: f.MultiplyByMatrix44(w).Y > swingThreshold,
+				})
+			}
+
+			t += 1
+		}
+	}
+
+	return out, nil
+}
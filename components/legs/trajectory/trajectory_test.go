@@ -0,0 +1,144 @@
+package trajectory
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/adammck/hexapod/config"
+	"github.com/adammck/hexapod/math3d"
+	"github.com/stretchr/testify/assert"
+)
+
+// Run with -update to regenerate the golden files after an intentional change
+// to the gait, then check the diff (or plot the CSVs) before committing them.
+var update = flag.Bool("update", false, "regenerate the golden trajectory files")
+
+// The maximum deviation (in mm) from the golden trajectory, which allows for
+// rounding in the files.
+const tolerance = 0.01
+
+// golden compares the samples to those in the named golden file, or writes
+// them to it if -update was given.
+func golden(t *testing.T, name string, got []Sample) {
+	path := filepath.Join("testdata", name+".csv")
+
+	if *update {
+		var buf bytes.Buffer
+		assert.NoError(t, WriteCSV(&buf, got))
+		assert.NoError(t, os.MkdirAll("testdata", 0755))
+		assert.NoError(t, ioutil.WriteFile(path, buf.Bytes(), 0644))
+		return
+	}
+
+	f, err := os.Open(path)
+	if !assert.NoError(t, err, "run with -update to create it") {
+		return
+	}
+	defer f.Close()
+
+	want, err := ReadCSV(f)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	devs, err := Compare(want, got)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	summary, bad := Exceeds(devs, tolerance)
+	if bad {
+		t.Errorf("trajectory differs from %s (run with -update if that's intended):\n%s", path, summary)
+	}
+}
+
+func record(t *testing.T, s Script) []Sample {
+	out, err := Record(config.Default(), s)
+	assert.NoError(t, err)
+	assert.Len(t, out, s.Ticks()*6)
+	return out
+}
+
+// Tripod gait (two groups of three).
+const tripod = 2
+
+func TestStraight(t *testing.T) {
+	golden(t, "tripod_straight", record(t, Script{
+		GaitIndex: tripod,
+		Clearance: 40,
+		Steps: []Step{
+			{Ticks: 60},
+			{Ticks: 120, Move: math3d.Pose{Position: math3d.Vector3{Z: 2}}},
+			{Ticks: 120},
+		},
+	}))
+}
+
+func TestArc(t *testing.T) {
+	golden(t, "tripod_arc", record(t, Script{
+		GaitIndex: tripod,
+		Clearance: 40,
+		Steps: []Step{
+			{Ticks: 60},
+			{Ticks: 180, Move: math3d.Pose{Position: math3d.Vector3{Z: 1.5}, Heading: 0.5}},
+			{Ticks: 120},
+		},
+	}))
+}
+
+func TestCompare(t *testing.T) {
+	want := []Sample{
+		{Tick: 0, Leg: "FL", Foot: math3d.Vector3{X: 1}},
+		{Tick: 0, Leg: "FR"},
+		{Tick: 1, Leg: "FL", Foot: math3d.Vector3{X: 2}},
+		{Tick: 1, Leg: "FR", Swing: true},
+	}
+
+	got := []Sample{
+		{Tick: 0, Leg: "FL", Foot: math3d.Vector3{X: 1, Y: 0.5}},
+		{Tick: 0, Leg: "FR"},
+		{Tick: 1, Leg: "FL", Foot: math3d.Vector3{X: 5}},
+		{Tick: 1, Leg: "FR"},
+	}
+
+	devs, err := Compare(want, got)
+	assert.NoError(t, err)
+	assert.Equal(t, []Deviation{
+		{Leg: "FL", Max: 3, Tick: 1},
+		{Leg: "FR", Swings: 1},
+	}, devs)
+
+	summary, bad := Exceeds(devs, 5)
+	assert.True(t, bad)
+	assert.Equal(t, "FL: max deviation 3.000mm at tick 1, 0 swing/stance changes\nFR: max deviation 0.000mm at tick 0, 1 swing/stance changes\n", summary)
+
+	_, bad = Exceeds(devs[:1], 5)
+	assert.False(t, bad)
+
+	_, err = Compare(want, got[:3])
+	assert.EqualError(t, err, "expected 4 samples, got 3")
+
+	_, err = Compare(want[:2], got[1:3])
+	assert.EqualError(t, err, "expected sample 0 to be FL at tick 0, got FR at tick 0")
+}
+
+func TestCSV(t *testing.T) {
+	in := []Sample{
+		{Tick: 0, Leg: "FL", Foot: math3d.Vector3{X: 1.23456, Y: -40, Z: 200}},
+		{Tick: 1, Leg: "MR", Foot: math3d.Vector3{X: -1}, Swing: true},
+	}
+
+	var buf bytes.Buffer
+	assert.NoError(t, WriteCSV(&buf, in))
+	assert.Equal(t, "tick,leg,x,y,z,swing\n0,FL,1.235,-40.000,200.000,0\n1,MR,-1.000,0.000,0.000,1\n", buf.String())
+
+	out, err := ReadCSV(&buf)
+	assert.NoError(t, err)
+	assert.Len(t, out, 2)
+	assert.Equal(t, 1.235, out[0].Foot.X)
+	assert.Equal(t, in[1], out[1])
+}
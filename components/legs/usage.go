@@ -0,0 +1,50 @@
+package legs
+
+import (
+	"time"
+
+	"github.com/adammck/hexapod"
+)
+
+// usage counts the steps which each leg takes, and how long the servos spend
+// holding the legs up, for the lifetime counters. See hexapod.Usage. It's
+// separate from the legs so it can be tested without any servos.
+type usage struct {
+	total hexapod.Usage
+
+	// Whether each foot was in the air as of the last update, so each lift is
+	// only counted once.
+	lifted [6]bool
+
+	// The time of the last update, or zero if the servos weren't holding the
+	// legs up on the last tick, so the time since then isn't counted.
+	last time.Time
+}
+
+// update counts the feet which were lifted on this tick, and the time since
+// the last, and returns the totals. While the gait is held, the feet are
+// treated as being where they were, so a foot which was paused in the air
+// isn't counted again when it carries on.
+func (u *usage) update(now time.Time, swing [6]bool, held bool) hexapod.Usage {
+	if !held {
+		for i, sw := range swing {
+			if sw && !u.lifted[i] {
+				u.total.Steps[i]++
+			}
+		}
+		u.lifted = swing
+	}
+
+	if !u.last.IsZero() {
+		u.total.Torque += now.Sub(u.last)
+	}
+	u.last = now
+
+	return u.total
+}
+
+// pause stops counting the time until the next update, while the legs are
+// leaving the servos alone.
+func (u *usage) pause() {
+	u.last = time.Time{}
+}
@@ -0,0 +1,41 @@
+package legs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUsage(t *testing.T) {
+	u := usage{}
+	now := time.Unix(0, 0)
+	tick := func(swing [6]bool, held bool) {
+		u.update(now, swing, held)
+		now = now.Add(time.Second)
+	}
+
+	// Each lift counts once, however long the foot is in the air.
+	up := [6]bool{true, false, true}
+	tick([6]bool{}, false)
+	tick(up, false)
+	tick(up, false)
+	tick([6]bool{}, false)
+	tick(up, false)
+	assert.Equal(t, [6]int{2, 0, 2}, u.total.Steps)
+	assert.Equal(t, 4*time.Second, u.total.Torque)
+
+	// A foot which is paused in the air isn't counted again when the gait
+	// carries on, even though it isn't swinging while held.
+	tick([6]bool{}, true)
+	tick(up, false)
+	assert.Equal(t, [6]int{2, 0, 2}, u.total.Steps)
+
+	// The time while paused isn't counted.
+	u.pause()
+	now = now.Add(time.Hour)
+	tick([6]bool{}, false)
+	assert.Equal(t, 6*time.Second, u.total.Torque)
+	tick([6]bool{}, false)
+	assert.Equal(t, 7*time.Second, u.total.Torque)
+}
@@ -0,0 +1,231 @@
+package legs
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/adammck/dynamixel/servo"
+	"github.com/adammck/hexapod"
+	"github.com/adammck/hexapod/config"
+	"github.com/adammck/hexapod/math3d"
+)
+
+const (
+
+	// The spacing (in mm) of the grid which the workspace is sampled over.
+	workspaceSpacing = 5.0
+
+	// The number of directions (evenly spaced around the neutral position)
+	// which reach checks, and the furthest it looks in each. No foot can reach
+	// further than the length of the whole leg.
+	reachDirections = 16
+	maxReach        = coxaOffsetZ + femurLength + tibiaLength
+
+	// The precision (in mm) to which reach finds the edge of the workspace.
+	reachPrecision = 0.5
+)
+
+// Workspace is the envelope of positions which a foot can reach on the ground,
+// with the chassis level at a given clearance, in the chassis space. It's the
+// result of IK over a grid, so is only as precise as the spacing.
+type Workspace struct {
+	Leg       string  `json:"leg"`
+	Clearance float64 `json:"clearance"`
+	Spacing   float64 `json:"spacing"`
+
+	// The reachable points of the grid, and the point which the foot is placed
+	// at when standing still.
+	Reachable []math3d.Vector3 `json:"reachable"`
+	Neutral   math3d.Vector3   `json:"neutral"`
+
+	// The distance which the foot can move in any direction from the neutral
+	// position, while staying within reach. See reach.
+	Reach float64 `json:"reach"`
+
+	// The corners of the box which the stride planner keeps the foot within,
+	// which is the neutral position plus or minus the max step distance (or
+	// the reach, if that's less) on the X and Z axes.
+	StrideMin math3d.Vector3 `json:"stride_min"`
+	StrideMax math3d.Vector3 `json:"stride_max"`
+}
+
+// NewWorkspace samples the workspace of the leg at the given clearance, with
+// its foot at the given step radius, and the max step distance in the config.
+func NewWorkspace(leg *Leg, cfg config.Legs, radius, clearance float64) Workspace {
+	neutral := homePosition(&math3d.ZeroVector3, leg, math3d.Pose{}, radius)
+	neutral.Y = -clearance
+
+	r := reach(leg, neutral)
+	stride := math.Min(cfg.MaxStepDistance, r)
+
+	ws := Workspace{
+		Leg:       leg.Name,
+		Clearance: clearance,
+		Spacing:   workspaceSpacing,
+		Neutral:   neutral,
+		Reach:     r,
+		StrideMin: math3d.Vector3{X: neutral.X - stride, Y: neutral.Y, Z: neutral.Z - stride},
+		StrideMax: math3d.Vector3{X: neutral.X + stride, Y: neutral.Y, Z: neutral.Z + stride},
+	}
+
+	// The grid is aligned to the chassis origin (rather than the leg), so the
+	// samples of mirrored legs are mirrored too.
+	n := math.Ceil(maxReach / workspaceSpacing)
+	cx := math.Round(leg.Origin.X / workspaceSpacing)
+	cz := math.Round(leg.Origin.Z / workspaceSpacing)
+	for i := cx - n; i <= cx+n; i++ {
+		for j := cz - n; j <= cz+n; j++ {
+			v := math3d.Vector3{X: i * workspaceSpacing, Y: -clearance, Z: j * workspaceSpacing}
+			if leg.Reachable(v) {
+				ws.Reachable = append(ws.Reachable, v)
+			}
+		}
+	}
+
+	return ws
+}
+
+// Workspaces returns the workspace of every leg, in the same order as
+// Legs.Legs, at the step radius of each in the config. This doesn't need the
+// servos, so can be run without the hardware.
+func Workspaces(cfg config.Legs, clearance float64) []Workspace {
+	t := tuning{stepRadius: cfg.StepRadius}
+	copy(t.stepRadii[:], cfg.StepRadii)
+
+	out := make([]Workspace, 0, len(layout))
+	for i, leg := range bareLegs() {
+		out = append(out, NewWorkspace(leg, cfg, t.radius(i), clearance))
+	}
+
+	return out
+}
+
+// bareLegs returns the legs in the layout, with servos which aren't on any
+// network, and so are only good for solving.
+func bareLegs() [6]*Leg {
+	var out [6]*Leg
+	for i, p := range layout {
+		j := func(id int) *Joint { return newJoint(&servo.Servo{ID: p.baseID + id}) }
+		origin := p.origin
+		out[i] = &Leg{Name: p.name, Origin: &origin, Angle: p.angle, Coxa: j(1), Femur: j(2), Tibia: j(3), Tarsus: j(4)}
+	}
+
+	return out
+}
+
+// reach returns how far the foot can move in any direction on the ground from
+// the given position (in the chassis space) while staying reachable, or zero
+// if the position itself isn't. It searches along a number of rays, so assumes
+// that the workspace doesn't have any holes that close to the foot.
+func reach(leg *Leg, from math3d.Vector3) float64 {
+	out := maxReach
+	for i := 0; i < reachDirections; i++ {
+		a := 2 * math.Pi * float64(i) / reachDirections
+		out = reachTowards(leg, from, math3d.Vector3{X: math.Sin(a), Z: math.Cos(a)}, out)
+	}
+
+	return out
+}
+
+// reachTowards returns how far (up to max) the foot can move from the given
+// position in the given direction (both in the chassis space) while staying
+// reachable, or zero if the position itself isn't.
+func reachTowards(leg *Leg, from, dir math3d.Vector3, max float64) float64 {
+	at := func(d float64) math3d.Vector3 {
+		return *from.Add(dir.MultiplyByScalar(d))
+	}
+
+	if !leg.Reachable(from) {
+		return 0
+	}
+
+	if leg.Reachable(at(max)) {
+		return max
+	}
+
+	// The edge is between lo (which is reachable) and hi (which isn't).
+	lo, hi := 0.0, max
+	for hi-lo > reachPrecision {
+		mid := (lo + hi) / 2
+		if leg.Reachable(at(mid)) {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+
+	return lo
+}
+
+// WriteWorkspacesJSON writes the given workspaces as JSON, for plotting. It's
+// not indented, since there are thousands of points.
+func WriteWorkspacesJSON(w io.Writer, wss []Workspace) error {
+	return json.NewEncoder(w).Encode(wss)
+}
+
+// WriteWorkspacesPLY writes the given workspaces as an ASCII PLY point cloud.
+// The reachable points are grey, the neutral positions red, and the corners of
+// the stride boxes green.
+func WriteWorkspacesPLY(w io.Writer, wss []Workspace) error {
+	n := 0
+	for _, ws := range wss {
+		n += len(ws.Reachable) + 5
+	}
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "ply\nformat ascii 1.0\ncomment hexapod leg workspaces\nelement vertex %d\n", n)
+	fmt.Fprintf(bw, "property float x\nproperty float y\nproperty float z\n")
+	fmt.Fprintf(bw, "property uchar red\nproperty uchar green\nproperty uchar blue\nend_header\n")
+
+	vertex := func(v math3d.Vector3, r, g, b int) {
+		fmt.Fprintf(bw, "%.2f %.2f %.2f %d %d %d\n", v.X, v.Y, v.Z, r, g, b)
+	}
+
+	for _, ws := range wss {
+		for _, v := range ws.Reachable {
+			vertex(v, 160, 160, 160)
+		}
+
+		vertex(ws.Neutral, 255, 0, 0)
+
+		min, max := ws.StrideMin, ws.StrideMax
+		for _, c := range []math3d.Vector3{
+			{X: min.X, Y: min.Y, Z: min.Z},
+			{X: max.X, Y: min.Y, Z: min.Z},
+			{X: max.X, Y: min.Y, Z: max.Z},
+			{X: min.X, Y: min.Y, Z: max.Z},
+		} {
+			vertex(c, 0, 255, 0)
+		}
+	}
+
+	return bw.Flush()
+}
+
+// maxStep returns how far the chassis can step towards the goal this cycle,
+// which is the max step distance, unless any foot would leave its workspace
+// first. While stepping, each foot is (in the chassis space) somewhere between
+// its home position and that minus the step, so that's the direction checked.
+// Feet which can't reach their home position at all are ignored, since their
+// workspace has nothing useful to say about how far they can go.
+func (l *Legs) maxStep(state *hexapod.State, vecToGoal math3d.Vector3) float64 {
+	m := state.Local()
+	dir := vecToGoal.MultiplyByScalar(-1).TransformDirection(m)
+	dir.Y = 0
+	dir = dir.Unit()
+
+	out := l.maxStepDistance()
+	for i, leg := range l.Legs {
+		from := l.homeFootPosition(&state.Offset, i, state.Pose).MultiplyByMatrix44(m)
+		if !leg.Reachable(from) {
+			continue
+		}
+
+		out = reachTowards(leg, from, dir, out)
+	}
+
+	return out
+}
@@ -0,0 +1,166 @@
+package legs
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/adammck/hexapod"
+	"github.com/adammck/hexapod/config"
+	"github.com/adammck/hexapod/math3d"
+	"github.com/stretchr/testify/assert"
+)
+
+// mirrored returns the reachable points of the workspace (mirrored across the
+// Z axis, if mirror is true) as strings, sorted so they can be compared. Zero
+// is subtracted from, rather than negated, so it isn't formatted as -0.
+func mirrored(ws Workspace, mirror bool) []string {
+	out := make([]string, 0, len(ws.Reachable))
+	for _, v := range ws.Reachable {
+		if mirror {
+			v.X = 0 - v.X
+		}
+		out = append(out, fmt.Sprintf("%.1f,%.1f,%.1f", v.X, v.Y, v.Z))
+	}
+
+	sort.Strings(out)
+	return out
+}
+
+func TestWorkspaceSymmetry(t *testing.T) {
+	wss := Workspaces(config.Default().Legs, 40)
+
+	// The geometry is symmetric across the Z axis, so the workspaces of the
+	// legs on either side should be too.
+	for _, pair := range [][2]int{{0, 1}, {2, 5}, {3, 4}} {
+		l, r := wss[pair[0]], wss[pair[1]]
+		t.Run(l.Leg+"/"+r.Leg, func(t *testing.T) {
+			assert.NotEmpty(t, l.Reachable)
+			assert.Equal(t, mirrored(l, true), mirrored(r, false))
+			assert.InDelta(t, -l.Neutral.X, r.Neutral.X, 1e-9)
+			assert.InDelta(t, l.Neutral.Z, r.Neutral.Z, 1e-9)
+			assert.InDelta(t, l.Reach, r.Reach, reachPrecision)
+		})
+	}
+}
+
+func TestNeutralInWorkspace(t *testing.T) {
+	cfg := config.Default().Legs
+	for _, clearance := range []float64{20, 40, 80} {
+		for _, ws := range Workspaces(cfg, clearance) {
+			t.Run(fmt.Sprintf("%s/%.0f", ws.Leg, clearance), func(t *testing.T) {
+				assert.Greater(t, ws.Reach, 0.0)
+
+				// The nearest point on the grid is within the workspace too,
+				// unless the neutral is right on the edge, which it isn't.
+				near := math3d.Vector3{
+					X: math.Round(ws.Neutral.X/ws.Spacing) * ws.Spacing,
+					Y: -clearance,
+					Z: math.Round(ws.Neutral.Z/ws.Spacing) * ws.Spacing,
+				}
+				assert.Contains(t, ws.Reachable, near)
+
+				// The stride box is within the reach.
+				stride := math.Min(cfg.MaxStepDistance, ws.Reach)
+				assert.InDelta(t, stride, ws.Neutral.X-ws.StrideMin.X, 1e-9)
+				assert.InDelta(t, stride, ws.StrideMax.Z-ws.Neutral.Z, 1e-9)
+			})
+		}
+	}
+}
+
+func TestWriteWorkspaces(t *testing.T) {
+	wss := Workspaces(config.Default().Legs, 40)
+
+	var b bytes.Buffer
+	assert.NoError(t, WriteWorkspacesJSON(&b, wss))
+
+	var decoded []Workspace
+	assert.NoError(t, json.Unmarshal(b.Bytes(), &decoded))
+	assert.Equal(t, wss, decoded)
+
+	b.Reset()
+	assert.NoError(t, WriteWorkspacesPLY(&b, wss))
+
+	// The header says how many vertices follow, which is every reachable
+	// point, plus the neutral and stride corners of each leg.
+	n := 0
+	for _, ws := range wss {
+		n += len(ws.Reachable) + 5
+	}
+
+	var header, vertices int
+	s := bufio.NewScanner(&b)
+	for s.Scan() {
+		if header >= 0 {
+			if s.Text() == fmt.Sprintf("element vertex %d", n) {
+				header = -1
+			}
+			continue
+		}
+		if s.Text() != "end_header" && !strings.HasPrefix(s.Text(), "property") {
+			vertices++
+		}
+	}
+
+	assert.Equal(t, -1, header)
+	assert.Equal(t, n, vertices)
+}
+
+func TestMaxStep(t *testing.T) {
+	cfg := config.Default().Legs
+	l := &Legs{cfg: cfg, Legs: bareLegs(), step: tuning{stepRadius: cfg.StepRadius}}
+	state := &hexapod.State{}
+	state.Pose.Position.Y = 40
+
+	// Walking forwards, the feet can reach much further than the max.
+	assert.Equal(t, cfg.MaxStepDistance, l.maxStep(state, math3d.Vector3{Z: 500}))
+
+	// But sideways, the feet on the far side run out of reach first, and
+	// those on the near side would be folded under the chassis. The same,
+	// whichever way it's facing.
+	right := l.maxStep(state, math3d.Vector3{X: 500})
+	assert.Less(t, right, cfg.MaxStepDistance)
+	assert.Greater(t, right, cfg.MinStepDistance)
+
+	state.Pose.Heading = 90
+	assert.InDelta(t, right, l.maxStep(state, math3d.Vector3{Z: -500}), reachPrecision)
+}
+
+func TestStepRadii(t *testing.T) {
+	cfg := config.Default().Legs
+	base := Workspaces(cfg, 40)
+
+	// Widen the middle legs.
+	cfg.StepRadii = []float64{0, 0, cfg.StepRadius + 30, 0, 0, cfg.StepRadius + 30}
+	wide := Workspaces(cfg, 40)
+
+	for i := range base {
+		t.Run(base[i].Leg, func(t *testing.T) {
+			moved := wide[i].Neutral.Distance(base[i].Neutral)
+			if cfg.StepRadii[i] == 0 {
+				assert.Equal(t, base[i], wide[i])
+				return
+			}
+
+			// Straight out, since the radius is along the leg.
+			assert.InDelta(t, 30, moved, 1e-9)
+			assert.InDelta(t, base[i].Neutral.Z, wide[i].Neutral.Z, 1e-9)
+			assert.Greater(t, math.Abs(wide[i].Neutral.X), math.Abs(base[i].Neutral.X))
+		})
+	}
+
+	// The legs (and so their reach checks) use the same radii.
+	l := &Legs{cfg: cfg, Legs: bareLegs(), step: tuning{stepRadius: cfg.StepRadius}}
+	copy(l.step.stepRadii[:], cfg.StepRadii)
+	for i := range l.Legs {
+		home := l.homeFootPosition(&math3d.ZeroVector3, i, math3d.Pose{})
+		home.Y = -40
+		assert.InDelta(t, 0, home.Distance(wide[i].Neutral), 1e-9, l.Legs[i].Name)
+	}
+}
@@ -0,0 +1,97 @@
+package mqtt
+
+import (
+	"time"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+)
+
+// Client is the subset of an MQTT client which the component needs. It's an
+// interface so that the tests don't need a broker.
+type Client interface {
+
+	// Connect starts connecting to the broker in the background. It should keep
+	// retrying (and reconnect after disconnection) until Disconnect is called.
+	Connect() error
+
+	// IsConnected returns true if the client is currently connected.
+	IsConnected() bool
+
+	// Publish sends a message without waiting for it to be acknowledged, since
+	// it's called from the main loop.
+	Publish(topic string, retained bool, payload string) error
+
+	// Disconnect stops the client, waiting (briefly) for pending messages.
+	Disconnect()
+}
+
+// Handler is called with every message received on the command topics. It's
+// called from the client's own goroutine, not from the main loop.
+type Handler func(topic string, retained bool, payload []byte)
+
+// How long to wait for pending messages when disconnecting, in milliseconds.
+const disconnectQuiesce = 250
+
+type pahoClient struct {
+	c paho.Client
+}
+
+// NewClient returns a Client connected to the given broker (e.g.
+// "tcp://localhost:1883"). It registers a last will on statusTopic, so the
+// broker will mark the hexapod offline if the connection is lost, subscribes to
+// cmdTopic (again after every reconnect), and publishes online to statusTopic
+// every time it connects.
+func NewClient(broker, clientID, statusTopic, cmdTopic string, h Handler) Client {
+	opts := paho.NewClientOptions().
+		AddBroker(broker).
+		SetClientID(clientID).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetConnectRetryInterval(5*time.Second).
+		SetMaxReconnectInterval(30*time.Second).
+		SetWill(statusTopic, offline, 1, true)
+
+	opts.SetOnConnectHandler(func(c paho.Client) {
+		log.Infof("connected to %s", broker)
+
+		c.Subscribe(cmdTopic, 1, func(c paho.Client, m paho.Message) {
+			h(m.Topic(), m.Retained(), m.Payload())
+		})
+
+		c.Publish(statusTopic, 1, true, online)
+	})
+
+	opts.SetConnectionLostHandler(func(c paho.Client, err error) {
+		log.Warnf("lost connection to %s: %s", broker, err)
+	})
+
+	return &pahoClient{paho.NewClient(opts)}
+}
+
+func (p *pahoClient) Connect() error {
+
+	// Don't wait for the token. With ConnectRetry enabled, it doesn't complete
+	// until the first connection succeeds, which might be never.
+	p.c.Connect()
+	return nil
+}
+
+func (p *pahoClient) IsConnected() bool {
+	return p.c.IsConnectionOpen()
+}
+
+func (p *pahoClient) Publish(topic string, retained bool, payload string) error {
+	t := p.c.Publish(topic, 0, retained, payload)
+
+	// Only report errors which have already happened.
+	select {
+	case <-t.Done():
+		return t.Error()
+	default:
+		return nil
+	}
+}
+
+func (p *pahoClient) Disconnect() {
+	p.c.Disconnect(disconnectQuiesce)
+}
@@ -0,0 +1,89 @@
+package mqtt
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/adammck/hexapod"
+)
+
+const (
+
+	// The clearance to set when sitting and standing. Standing is the same as
+	// the controller's default.
+	sitClearance   = 0.0
+	standClearance = 40.0
+
+	// The param which sit and stand write to.
+	clearanceParam = "controller.clearance"
+)
+
+// command is a validated message from one of the command topics.
+type command struct {
+	name string
+
+	// Only one of these is relevant, depending on the name.
+	clearance float64
+	halt      bool
+	speed     int
+}
+
+// parseCommand validates a message received on the given topic (which must be
+// below prefix/cmd/), and returns the command it represents. Only a few
+// commands are accepted, and none of them can make the hexapod walk.
+//
+// Retained messages are always rejected, since they might have been sent long
+// ago, and would be replayed every time we reconnect.
+func parseCommand(prefix, topic string, retained bool, payload []byte) (command, error) {
+	name := strings.TrimPrefix(topic, prefix+"/cmd/")
+	if name == topic {
+		return command{}, fmt.Errorf("not a command topic: %s", topic)
+	}
+
+	if retained {
+		return command{}, fmt.Errorf("ignoring retained command: %s", name)
+	}
+
+	p := strings.TrimSpace(string(payload))
+	cmd := command{name: name}
+
+	switch name {
+	case "sit", "stand":
+		if p != "" {
+			return command{}, fmt.Errorf("%s takes no payload, got %q", name, p)
+		}
+
+		cmd.clearance = sitClearance
+		if name == "stand" {
+			cmd.clearance = standClearance
+		}
+
+	case "estop":
+		switch strings.ToLower(p) {
+		case "", "on", "true", "1":
+			cmd.halt = true
+		case "off", "false", "0":
+			cmd.halt = false
+		default:
+			return command{}, fmt.Errorf("invalid estop payload: %q", p)
+		}
+
+	case "set-speed":
+		n, err := strconv.Atoi(p)
+		if err != nil {
+			return command{}, fmt.Errorf("invalid speed: %q", p)
+		}
+
+		if n < hexapod.MinSpeed || n > hexapod.MaxSpeed {
+			return command{}, fmt.Errorf("speed must be between %d and %d, got %d", hexapod.MinSpeed, hexapod.MaxSpeed, n)
+		}
+
+		cmd.speed = n
+
+	default:
+		return command{}, fmt.Errorf("unknown command: %s", name)
+	}
+
+	return cmd, nil
+}
@@ -0,0 +1,245 @@
+package mqtt
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/adammck/hexapod"
+	"github.com/adammck/hexapod/components/telemetry"
+	"github.com/adammck/hexapod/params"
+)
+
+var log = logrus.WithFields(logrus.Fields{
+	"pkg": "mqtt",
+})
+
+// Payloads of the status topic.
+const (
+	online  = "online"
+	offline = "offline"
+)
+
+// MQTT is a component which publishes some of the state to an MQTT broker, and
+// accepts a few simple commands:
+//
+//	<prefix>/status           online or offline (retained, and the last will)
+//	<prefix>/state/voltage    the battery voltage
+//	<prefix>/state/pose       the current pose, as a hexapod.SnapshotPose
+//	<prefix>/state/speed      the current speed
+//	<prefix>/state/status     ok, halted, or shutdown
+//
+//	<prefix>/cmd/sit          lower the chassis to the ground
+//	<prefix>/cmd/stand        raise the chassis to the default clearance
+//	<prefix>/cmd/estop        halt, or resume if the payload is "off"
+//	<prefix>/cmd/set-speed    set the speed to the (integer) payload
+//
+// Commands arrive on the client's goroutine, so are validated and queued, then
+// applied during the next Tick, the same as those from the API.
+type MQTT struct {
+	sync.Mutex
+	client Client
+	prefix string
+	params *params.Registry
+
+	// The decimator which samples the state every interval, which may be
+	// shared with other consumers.
+	decimator *telemetry.Decimator
+
+	// Whether the hex was halted as of the last tick, for the status, which
+	// isn't in the snapshot.
+	halted bool
+
+	// Whether we've already said goodbye, after shutdown was requested.
+	closed bool
+
+	// Set by the handler, applied during the next Tick. Nil means no change.
+	halt  *bool
+	speed *int
+}
+
+// New creates an MQTT component which connects to the given broker, publishes
+// the state under the given topic prefix every interval, and writes sit and
+// stand commands to the given param registry. The state is sampled by the given
+// decimator, or one of its own if nil.
+func New(broker, prefix string, interval time.Duration, r *params.Registry, d *telemetry.Decimator) *MQTT {
+	m := newMQTT(nil, prefix, interval, r, d)
+
+	host, _ := os.Hostname()
+	id := fmt.Sprintf("hexapod-%s-%d", host, os.Getpid())
+
+	m.client = NewClient(broker, id, m.topic("status"), m.topic("cmd/#"), m.handle)
+	return m
+}
+
+func newMQTT(c Client, prefix string, interval time.Duration, r *params.Registry, d *telemetry.Decimator) *MQTT {
+	if d == nil {
+		d = telemetry.NewDecimator()
+	}
+
+	m := &MQTT{
+		client:    c,
+		prefix:    prefix,
+		params:    r,
+		decimator: d,
+	}
+
+	rate := 0.0
+	if interval > 0 {
+		rate = 1 / interval.Seconds()
+	}
+
+	// The fields are fixed, so this can't fail.
+	d.Subscribe("mqtt", telemetry.Profile{
+		Rate:   rate,
+		Fields: []string{"shutdown", "pose", "speed", "voltage"},
+	}, m.publish)
+
+	return m
+}
+
+func (m *MQTT) topic(suffix string) string {
+	return m.prefix + "/" + suffix
+}
+
+// Writes returns hexapod.Commander, since the halt and speed can be set via
+// MQTT.
+func (m *MQTT) Writes() hexapod.Role {
+	return hexapod.Commander
+}
+
+// Source implements hexapod.Source.
+func (m *MQTT) Source() string {
+	return "mqtt"
+}
+
+// Boot starts connecting to the broker in the background. We don't wait for
+// the connection, since the hexapod is perfectly usable without it.
+func (m *MQTT) Boot() error {
+	return m.client.Connect()
+}
+
+// handle is called by the client (in its own goroutine) for every message on
+// the command topics.
+func (m *MQTT) handle(topic string, retained bool, payload []byte) {
+	cmd, err := parseCommand(m.prefix, topic, retained, payload)
+	if err != nil {
+		log.Warnf("rejected command: %s", err)
+		return
+	}
+
+	log.Infof("received command: %s %s", cmd.name, payload)
+
+	switch cmd.name {
+	case "sit", "stand":
+
+		// The clearance belongs to the controller, so go via the param registry
+		// rather than fighting over the state.
+		err = m.params.Set(map[string]float64{clearanceParam: cmd.clearance})
+		if err != nil {
+			log.Warnf("error setting clearance: %s", err)
+		}
+
+	case "estop":
+		m.Lock()
+		m.halt = &cmd.halt
+		m.Unlock()
+
+	case "set-speed":
+		m.Lock()
+		m.speed = &cmd.speed
+		m.Unlock()
+	}
+}
+
+// Tick applies any pending commands, and publishes the state if enough time
+// has passed since it was last published.
+func (m *MQTT) Tick(now time.Time, state *hexapod.State) error {
+	if state.Shutdown {
+		m.close(state)
+		return nil
+	}
+
+	m.Lock()
+	if m.halt != nil {
+		if *m.halt != state.Halt {
+			log.Warnf("halt=%v (via MQTT)", *m.halt)
+		}
+		state.Halt = *m.halt
+		m.halt = nil
+	}
+	if m.speed != nil {
+		log.Infof("Speed=%v (via MQTT)", *m.speed)
+		state.Speed = *m.speed
+		m.speed = nil
+	}
+	m.halted = state.Halt
+	m.Unlock()
+
+	return m.decimator.Tick(now, state)
+}
+
+// publish publishes the state messages from the sample.
+func (m *MQTT) publish(s *telemetry.Sample) {
+
+	// Don't bother building the messages if there's nobody to send them to.
+	// The client will reconnect on its own.
+	if m.closed || !m.client.IsConnected() {
+		return
+	}
+
+	for _, msg := range m.messages(&s.Snapshot) {
+		err := m.client.Publish(m.topic(msg.topic), true, msg.payload)
+		if err != nil {
+			log.Warnf("error publishing %s: %s", msg.topic, err)
+		}
+	}
+}
+
+type message struct {
+	topic   string
+	payload string
+}
+
+// messages returns the state messages to publish.
+func (m *MQTT) messages(s *hexapod.Snapshot) []message {
+	pose, _ := json.Marshal(s.Pose)
+
+	return []message{
+		{"state/voltage", fmt.Sprintf("%.2f", s.Voltage)},
+		{"state/pose", string(pose)},
+		{"state/speed", fmt.Sprintf("%d", s.Speed)},
+		{"state/status", status(s.Shutdown, m.halted)},
+	}
+}
+
+func status(shutdown, halted bool) string {
+	switch {
+	case shutdown:
+		return "shutdown"
+	case halted:
+		return "halted"
+	default:
+		return "ok"
+	}
+}
+
+// close publishes the final status (once), marks the hexapod offline, and
+// disconnects. Any commands still pending are dropped.
+func (m *MQTT) close(state *hexapod.State) {
+	if m.closed {
+		return
+	}
+
+	m.closed = true
+
+	if m.client.IsConnected() {
+		m.client.Publish(m.topic("state/status"), true, status(state.Shutdown, state.Halt))
+		m.client.Publish(m.topic("status"), true, offline)
+	}
+
+	m.client.Disconnect()
+}
@@ -0,0 +1,160 @@
+package mqtt
+
+import (
+	"testing"
+	"time"
+
+	"github.com/adammck/hexapod"
+	"github.com/adammck/hexapod/params"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeClient is a Client which records everything published to it.
+type fakeClient struct {
+	connected    bool
+	disconnected bool
+	published    map[string]string
+}
+
+func (c *fakeClient) Connect() error {
+	c.connected = true
+	return nil
+}
+
+func (c *fakeClient) IsConnected() bool {
+	return c.connected
+}
+
+func (c *fakeClient) Publish(topic string, retained bool, payload string) error {
+	if c.published == nil {
+		c.published = map[string]string{}
+	}
+	c.published[topic] = payload
+	return nil
+}
+
+func (c *fakeClient) Disconnect() {
+	c.connected = false
+	c.disconnected = true
+}
+
+// setup returns an MQTT component with a fake client, and a registry containing
+// a clearance param like the controller's.
+func setup(t *testing.T) (*MQTT, *fakeClient, *params.Registry, *float64) {
+	r := params.New()
+	clearance := 40.0
+	assert.NoError(t, r.Register(params.Param{
+		Name: clearanceParam,
+		Type: params.Float,
+		Min:  0,
+		Max:  120,
+		Get:  func() float64 { return clearance },
+		Set:  func(v float64) { clearance = v },
+	}))
+
+	c := &fakeClient{}
+	m := newMQTT(c, "hexapod", time.Second, r, nil)
+	assert.NoError(t, m.Boot())
+	return m, c, r, &clearance
+}
+
+func TestPublish(t *testing.T) {
+	m, c, _, _ := setup(t)
+	state := &hexapod.State{Commands: hexapod.Commands{Speed: 2}, Measurements: hexapod.Measurements{Voltage: 11.1}}
+	state.Pose.Position.Y = 40
+
+	start := time.Now()
+	assert.NoError(t, m.Tick(start, state))
+	assert.Equal(t, "11.10", c.published["hexapod/state/voltage"])
+	assert.Equal(t, "2", c.published["hexapod/state/speed"])
+	assert.Equal(t, "ok", c.published["hexapod/state/status"])
+	assert.JSONEq(t, `{"x":0,"y":40,"z":0,"heading":0,"pitch":0,"bank":0}`, c.published["hexapod/state/pose"])
+
+	// Nothing more is published until the interval has passed.
+	state.Voltage = 10
+	assert.NoError(t, m.Tick(start.Add(500*time.Millisecond), state))
+	assert.Equal(t, "11.10", c.published["hexapod/state/voltage"])
+	assert.NoError(t, m.Tick(start.Add(time.Second), state))
+	assert.Equal(t, "10.00", c.published["hexapod/state/voltage"])
+
+	// Nor while disconnected.
+	c.connected = false
+	state.Voltage = 9
+	assert.NoError(t, m.Tick(start.Add(2*time.Second), state))
+	assert.Equal(t, "10.00", c.published["hexapod/state/voltage"])
+}
+
+func TestCommands(t *testing.T) {
+	m, _, r, clearance := setup(t)
+	state := &hexapod.State{}
+
+	m.handle("hexapod/cmd/estop", false, nil)
+	m.handle("hexapod/cmd/set-speed", false, []byte("3"))
+	m.handle("hexapod/cmd/sit", false, nil)
+
+	// Nothing is applied until the next tick.
+	assert.False(t, state.Halt)
+	assert.NoError(t, m.Tick(time.Now(), state))
+	assert.True(t, state.Halt)
+	assert.Equal(t, 3, state.Speed)
+
+	// The clearance is applied by the core, via the registry.
+	r.Apply()
+	assert.Equal(t, 0.0, *clearance)
+
+	m.handle("hexapod/cmd/estop", false, []byte("off"))
+	m.handle("hexapod/cmd/stand", false, nil)
+	assert.NoError(t, m.Tick(time.Now(), state))
+	r.Apply()
+	assert.False(t, state.Halt)
+	assert.Equal(t, 40.0, *clearance)
+}
+
+func TestRejectsInvalidCommands(t *testing.T) {
+	for _, tc := range []struct {
+		topic    string
+		retained bool
+		payload  string
+	}{
+		{"hexapod/cmd/stand", true, ""},
+		{"hexapod/cmd/estop", true, "off"},
+		{"hexapod/cmd/walk", false, ""},
+		{"hexapod/cmd/sit", false, "now"},
+		{"hexapod/cmd/estop", false, "maybe"},
+		{"hexapod/cmd/set-speed", false, "fast"},
+		{"hexapod/cmd/set-speed", false, "9"},
+		{"hexapod/cmd/set-speed", false, "-31"},
+		{"other/cmd/sit", false, ""},
+	} {
+		_, err := parseCommand("hexapod", tc.topic, tc.retained, []byte(tc.payload))
+		assert.Error(t, err, "%s %q", tc.topic, tc.payload)
+	}
+
+	// And none of them change anything.
+	m, _, r, clearance := setup(t)
+	state := &hexapod.State{Commands: hexapod.Commands{Halt: true}}
+	m.handle("hexapod/cmd/estop", true, []byte("off"))
+	m.handle("hexapod/cmd/stand", true, nil)
+	m.handle("hexapod/cmd/set-speed", false, []byte("100"))
+	*clearance = 0
+	assert.NoError(t, m.Tick(time.Now(), state))
+	assert.Nil(t, r.Apply())
+	assert.True(t, state.Halt)
+	assert.Equal(t, 0, state.Speed)
+	assert.Equal(t, 0.0, *clearance)
+}
+
+func TestShutdownMarksOffline(t *testing.T) {
+	m, c, _, _ := setup(t)
+	state := &hexapod.State{Shutdown: true}
+
+	assert.NoError(t, m.Tick(time.Now(), state))
+	assert.Equal(t, "offline", c.published["hexapod/status"])
+	assert.Equal(t, "shutdown", c.published["hexapod/state/status"])
+	assert.True(t, c.disconnected)
+
+	// Commands received after shutdown are ignored.
+	m.handle("hexapod/cmd/estop", false, nil)
+	assert.NoError(t, m.Tick(time.Now(), state))
+	assert.False(t, state.Halt)
+}
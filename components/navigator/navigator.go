@@ -0,0 +1,331 @@
+// Package navigator walks the hex through a queue of waypoints, one after the
+// other, using the pose estimate to tell when each has been reached.
+package navigator
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/adammck/hexapod"
+	"github.com/adammck/hexapod/config"
+	"github.com/adammck/hexapod/math3d"
+	"github.com/adammck/hexapod/units"
+	"github.com/adammck/hexapod/utils"
+)
+
+var log = hexapod.NewLog("navigator")
+
+const (
+
+	// The furthest (in mm, and degrees) which the target is set from the pose,
+	// so the hex walks at about the same pace as at full stick.
+	maxMove = 100
+	maxTurn = 15
+)
+
+// Waypoint is a move relative to where the previous one ended (or the pose,
+// for the first): a distance (in mm) forwards and to the right, and then a turn
+// (in degrees) to the right. See config.Waypoint, which converts to this.
+type Waypoint struct {
+	Forward float64 `json:"forward"`
+	Right   float64 `json:"right"`
+	Turn    float64 `json:"turn"`
+}
+
+// from returns the pose at the end of the waypoint, if it starts at the given
+// pose.
+func (w Waypoint) from(p math3d.Pose) math3d.Pose {
+	g := p.Add(math3d.Pose{
+		Position: math3d.Vector3{X: w.Right, Z: w.Forward},
+		Heading:  w.Turn,
+	})
+
+	g.Heading = math3d.WrapDegrees(g.Heading)
+	return g
+}
+
+// Navigator is a component which walks to each waypoint in its queue, by
+// setting the target towards it every tick. It has lower priority than the
+// controller, so must come after it; whenever the sticks are in use (or the
+// hex is halted), it leaves the target alone until they're released.
+//
+// It can also remember a home pose, and walk back to it: turning to face it,
+// walking straight there, and then turning to the heading it had. Touching the
+// sticks cancels that, rather than pausing it, since it's more likely to be
+// heading somewhere unexpected.
+//
+// The goal of each waypoint is relative to the goal of the previous one rather
+// than to wherever the hex ended up, so errors within the tolerance don't add
+// up over a route. Once the queue is empty, the target is left to the
+// controller, which holds it at the pose.
+type Navigator struct {
+	cfg   config.Navigator
+	route []Waypoint
+
+	// Shared with the API, via Add, Clear, and Status.
+	mu      sync.Mutex
+	queue   []Waypoint
+	cleared bool
+	status  hexapod.Navigation
+
+	// Only touched by Tick.
+	active  bool
+	goal    math3d.Pose
+	reached int
+
+	// The home pose, and the drift when it was set. See HomeSet.
+	home      math3d.Pose
+	hasHome   bool
+	homeDrift float64
+	homing    bool
+}
+
+// New creates a navigator component with the given tolerances and canned
+// route, which is walked when State.StartRoute is set.
+func New(cfg config.Navigator) *Navigator {
+	n := &Navigator{cfg: cfg}
+	for _, w := range cfg.Route {
+		n.route = append(n.route, Waypoint(w))
+	}
+
+	return n
+}
+
+// Writes returns hexapod.Commander, since the navigator sets the target.
+func (n *Navigator) Writes() hexapod.Role {
+	return hexapod.Commander
+}
+
+// Source implements hexapod.Autonomous.
+func (n *Navigator) Source() string {
+	return "navigator"
+}
+
+// Cancel clears the queue, when the countdown before walking it is cancelled.
+// It implements hexapod.Autonomous.
+func (n *Navigator) Cancel() {
+	n.Clear()
+}
+
+func (n *Navigator) Boot() error {
+	return nil
+}
+
+// Add appends waypoints to the end of the queue.
+func (n *Navigator) Add(ws ...Waypoint) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.queue = append(n.queue, ws...)
+}
+
+// Clear empties the queue, which stops the hex on the next tick.
+func (n *Navigator) Clear() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.queue = nil
+	n.cleared = true
+	n.homing = false
+}
+
+// Status returns the progress as of the last tick, and a copy of the queue,
+// starting with the current waypoint.
+func (n *Navigator) Status() (hexapod.Navigation, []Waypoint) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	return n.status, append([]Waypoint{}, n.queue...)
+}
+
+func (n *Navigator) Tick(now time.Time, state *hexapod.State) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if state.StartRoute {
+		state.StartRoute = false
+		if len(n.route) == 0 {
+			log.Warn("can't start route, because none is configured")
+		} else {
+			log.Infof("starting route of %d waypoints", len(n.route))
+			n.queue = append(n.queue, n.route...)
+		}
+	}
+
+	// Anything added since the clear starts again from the pose.
+	if n.cleared {
+		n.cleared = false
+		if n.active {
+			log.Info("waypoints cleared")
+			n.active = false
+		}
+	}
+
+	n.handleHome(state)
+
+	if n.homing && state.Input.Walking() {
+		log.Info("return home cancelled")
+		n.queue = nil
+		n.active = false
+		n.homing = false
+	}
+
+	if len(n.queue) == 0 {
+		n.update(state, hexapod.Navigation{})
+		return nil
+	}
+
+	if !n.active {
+		n.active = true
+		n.reached = 0
+		n.goal = n.queue[0].from(flat(state.Pose))
+		log.Infof("navigating to %s", n.goal)
+	}
+
+	dist, angle := n.remaining(state.Pose)
+	if dist <= n.cfg.Tolerance && math.Abs(angle) <= n.cfg.AngleTolerance {
+		n.reached += 1
+		n.queue = n.queue[1:]
+		state.Publish(hexapod.EventWaypointReached, hexapod.Info, n.reached)
+
+		// Stop here, by leaving the target to the controller.
+		if len(n.queue) == 0 {
+			log.Infof("finished route of %d waypoints", n.reached)
+			state.Publish(hexapod.EventRouteFinished, hexapod.Info, n.reached)
+			n.active = false
+			n.homing = false
+			n.update(state, hexapod.Navigation{})
+			return nil
+		}
+
+		n.goal = n.queue[0].from(n.goal)
+		log.Infof("reached waypoint %d, navigating to %s", n.reached, n.goal)
+		dist, angle = n.remaining(state.Pose)
+	}
+
+	n.update(state, hexapod.Navigation{
+		Active:    true,
+		Paused:    paused(state),
+		Goal:      n.goal,
+		Distance:  dist,
+		Angle:     angle,
+		Reached:   n.reached,
+		Remaining: len(n.queue),
+	})
+
+	if state.Navigation.Paused {
+		return nil
+	}
+
+	// Only replace the walking part of the target, like any other input. The
+	// controller still owns the clearance and orientation. It's no faster than
+	// the derate allows.
+	d := n.goal.Position.Subtract(state.Pose.Position)
+	d.Y = 0
+	d = d.ClampLength(maxMove * state.Derate.Scale())
+
+	state.Target.Position.X = state.Pose.Position.X + d.X
+	state.Target.Position.Z = state.Pose.Position.Z + d.Z
+	state.Target.Heading = math3d.WrapDegrees(state.Pose.Heading + math3d.ClampDegrees(angle, -maxTurn, maxTurn))
+
+	return nil
+}
+
+// update sets the navigation in the state (and the copy for Status) to the
+// given progress, plus the home.
+func (n *Navigator) update(state *hexapod.State, nav hexapod.Navigation) {
+	nav.Homing = n.homing
+	nav.HasHome = n.hasHome
+	nav.Home = n.home
+
+	state.Navigation = nav
+	n.status = nav
+}
+
+// handleHome sets or returns to the home pose, if requested, and forgets it if
+// the pose has drifted too much since it was set.
+func (n *Navigator) handleHome(state *hexapod.State) {
+	req := state.Home
+	state.Home = hexapod.HomeNone
+
+	if n.hasHome && state.Drift-n.homeDrift > n.cfg.MaxHomeDrift {
+		log.Warnf("forgetting home, since the pose may have drifted by %s since it was set", units.MM(state.Drift-n.homeDrift))
+		n.hasHome = false
+		n.home = math3d.Pose{}
+
+		if n.homing {
+			n.queue = nil
+			n.active = false
+			n.homing = false
+		}
+	}
+
+	switch req {
+	case hexapod.HomeSet:
+		n.home = flat(state.Pose)
+		n.hasHome = true
+		n.homeDrift = state.Drift
+		log.Infof("home set to %s", n.home)
+
+	case hexapod.HomeReturn:
+		if !n.hasHome {
+			log.Warn("can't return home, because it isn't set")
+			return
+		}
+
+		from := flat(state.Pose)
+		d := from.Position.Distance(n.home.Position)
+		if d > n.cfg.MaxHomeDistance {
+			log.Warnf("not returning home, because it's %s away (the limit is %s)", units.MM(d), units.MM(n.cfg.MaxHomeDistance))
+			return
+		}
+
+		log.Infof("returning home, %s away", units.MM(d))
+		n.queue = append(n.queue[:0], n.homeRoute(from)...)
+		n.active = false
+		n.homing = true
+	}
+}
+
+// homeRoute returns the waypoints to get home from the given pose: turn to face
+// it, walk there, and turn to the heading it was set with. If it's already
+// within the tolerance, it just shuffles there.
+func (n *Navigator) homeRoute(from math3d.Pose) []Waypoint {
+	rel := n.home.RelativeTo(from)
+	rel.Heading = math3d.WrapDegrees(rel.Heading)
+	dist := math.Hypot(rel.Position.X, rel.Position.Z)
+
+	if dist <= n.cfg.Tolerance {
+		return []Waypoint{{Forward: rel.Position.Z, Right: rel.Position.X, Turn: rel.Heading}}
+	}
+
+	bearing := utils.Deg(math.Atan2(rel.Position.X, rel.Position.Z))
+	return []Waypoint{
+		{Turn: bearing},
+		{Forward: dist},
+		{Turn: math3d.AngleDiff(rel.Heading, bearing)},
+	}
+}
+
+// remaining returns the distance (in mm, ignoring the clearance) and angle from
+// the given pose to the current goal.
+func (n *Navigator) remaining(pose math3d.Pose) (float64, float64) {
+	d := n.goal.Position.Subtract(pose.Position)
+	d.Y = 0
+	return d.Magnitude(), math3d.AngleDiff(n.goal.Heading, pose.Heading)
+}
+
+// flat returns the pose without the clearance or tilt, so waypoints are walked
+// along the ground however the body is oriented.
+func flat(p math3d.Pose) math3d.Pose {
+	return math3d.Pose{
+		Position: math3d.Vector3{X: p.Position.X, Z: p.Position.Z},
+		Heading:  p.Heading,
+	}
+}
+
+// paused returns true if something else should be in control of the target.
+func paused(state *hexapod.State) bool {
+	return state.Halt || state.Shutdown || state.Calibrating || state.SelfTesting || state.Fallen || state.Cooling || state.Input.Walking()
+}
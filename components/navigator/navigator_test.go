@@ -0,0 +1,299 @@
+package navigator
+
+import (
+	"testing"
+	"time"
+
+	"github.com/adammck/hexapod"
+	"github.com/adammck/hexapod/config"
+	"github.com/adammck/hexapod/math3d"
+	"github.com/stretchr/testify/assert"
+)
+
+// standing returns the state of a hex standing at the given pose, with the
+// target held there, as the controller would.
+func standing(p math3d.Pose) *hexapod.State {
+	p.Position.Y = 40
+	return &hexapod.State{
+		Commands:  hexapod.Commands{Target: p},
+		Estimates: hexapod.Estimates{Pose: p},
+	}
+}
+
+// walk ticks the navigator until it's finished (or gives up), moving the pose
+// straight to the target after each tick, like very obedient legs. It returns
+// the number of ticks, and the names of the events published. The events
+// aren't taken by a core between ticks, so they pile up.
+func walk(t *testing.T, n *Navigator, state *hexapod.State) (int, []string) {
+	for i := 1; i <= 100; i++ {
+		assert.NoError(t, n.Tick(time.Now(), state))
+		if !state.Navigation.Active {
+			var events []string
+			for _, e := range state.Published() {
+				events = append(events, e.Name)
+			}
+
+			return i, events
+		}
+
+		state.Pose = state.Target
+	}
+
+	t.Fatalf("didn't finish: %+v", state.Navigation)
+	return 0, nil
+}
+
+func TestWalksToWaypoints(t *testing.T) {
+	n := New(config.Default().Navigator)
+	n.Add(Waypoint{Forward: 250}, Waypoint{Turn: -90}, Waypoint{Forward: 150, Right: 50})
+
+	state := standing(math3d.Pose{Position: math3d.Vector3{X: 10, Z: 20}, Heading: 90})
+	assert.NoError(t, n.Tick(time.Now(), state))
+
+	// The target is limited to a full stick's worth from the pose, and keeps
+	// the clearance.
+	assert.InDelta(t, 110, state.Target.Position.X, 0.001)
+	assert.Equal(t, 40.0, state.Target.Position.Y)
+	assert.InDelta(t, 20, state.Target.Position.Z, 0.001)
+	assert.Equal(t, 90.0, state.Target.Heading)
+
+	nav := state.Navigation
+	assert.True(t, nav.Active)
+	assert.False(t, nav.Paused)
+	assert.InDelta(t, 260, nav.Goal.Position.X, 0.001)
+	assert.InDelta(t, 250, nav.Distance, 0.001)
+	assert.Equal(t, 0, nav.Reached)
+	assert.Equal(t, 3, nav.Remaining)
+
+	// Then two more ticks forwards (100, then 50mm), six turning (15 degrees
+	// each), two forwards again, and one to notice that it's there.
+	state.Pose = state.Target
+	ticks, events := walk(t, n, state)
+	assert.Equal(t, 11, ticks)
+	assert.Equal(t, []string{
+		hexapod.EventWaypointReached,
+		hexapod.EventWaypointReached,
+		hexapod.EventWaypointReached,
+		hexapod.EventRouteFinished,
+	}, events)
+
+	// The last waypoint is forwards (to the north now), and to the right.
+	assert.InDelta(t, 310, state.Pose.Position.X, 0.001)
+	assert.InDelta(t, 170, state.Pose.Position.Z, 0.001)
+	assert.InDelta(t, 0, state.Pose.Heading, 0.001)
+	assert.Equal(t, hexapod.Navigation{}, state.Navigation)
+	assert.Empty(t, n.queue)
+}
+
+func TestDerate(t *testing.T) {
+	n := New(config.Default().Navigator)
+	n.Add(Waypoint{Forward: 250})
+
+	// The target is only as far from the pose as the derate allows.
+	state := standing(math3d.Pose{})
+	state.Derate = hexapod.Derate{Active: true, Factor: 0.6, Battery: 0.6, Thermal: 1}
+	assert.NoError(t, n.Tick(time.Now(), state))
+	assert.InDelta(t, 60, state.Target.Position.Z, 0.001)
+}
+
+func TestGoalsAreRelativeToPreviousGoal(t *testing.T) {
+	n := New(config.Default().Navigator)
+	n.Add(Waypoint{Forward: 100}, Waypoint{Forward: 100})
+
+	// Pretend that the hex came up short of the first waypoint, but within
+	// the tolerance. The next goal is still 100mm from the first.
+	state := standing(math3d.Pose{})
+	assert.NoError(t, n.Tick(time.Now(), state))
+	state.Pose.Position.Z = 90
+	assert.NoError(t, n.Tick(time.Now(), state))
+
+	assert.Equal(t, 1, state.Navigation.Reached)
+	assert.Equal(t, math3d.Vector3{Z: 200}, state.Navigation.Goal.Position)
+	assert.InDelta(t, 110, state.Navigation.Distance, 0.001)
+}
+
+func TestSticksPause(t *testing.T) {
+	n := New(config.Default().Navigator)
+	n.Add(Waypoint{Forward: 500})
+
+	// The controller has put the target wherever it likes, which is left
+	// alone while the stick is held.
+	state := standing(math3d.Pose{})
+	state.Target.Position.X = 50
+	state.Input.LeftX = 100
+	assert.NoError(t, n.Tick(time.Now(), state))
+	assert.Equal(t, 50.0, state.Target.Position.X)
+	assert.True(t, state.Navigation.Active)
+	assert.True(t, state.Navigation.Paused)
+
+	// So is halting.
+	state.Input.LeftX = 0
+	state.Halt = true
+	assert.NoError(t, n.Tick(time.Now(), state))
+	assert.Equal(t, 50.0, state.Target.Position.X)
+	assert.True(t, state.Navigation.Paused)
+
+	// But a little noise on the sticks doesn't count, and other buttons can
+	// still be used.
+	state.Halt = false
+	state.Input = hexapod.Input{LeftY: 5, RightX: 127, Buttons: hexapod.ButtonUp}
+	assert.NoError(t, n.Tick(time.Now(), state))
+	assert.Equal(t, math3d.Vector3{Y: 40, Z: 100}, state.Target.Position)
+	assert.False(t, state.Navigation.Paused)
+}
+
+func TestStartRoute(t *testing.T) {
+	cfg := config.Default().Navigator
+	cfg.Route = []config.Waypoint{{Forward: 100}, {Turn: 90}}
+	n := New(cfg)
+
+	state := standing(math3d.Pose{})
+	state.StartRoute = true
+	assert.NoError(t, n.Tick(time.Now(), state))
+	assert.False(t, state.StartRoute)
+
+	nav, q := n.Status()
+	assert.Equal(t, []Waypoint{{Forward: 100}, {Turn: 90}}, q)
+	assert.Equal(t, 2, nav.Remaining)
+
+	state.Pose = state.Target
+	ticks, _ := walk(t, n, state)
+	assert.Equal(t, 7, ticks)
+	assert.InDelta(t, 90, state.Pose.Heading, 0.001)
+
+	// Without a route, nothing happens.
+	n = New(config.Default().Navigator)
+	state = standing(math3d.Pose{})
+	state.StartRoute = true
+	assert.NoError(t, n.Tick(time.Now(), state))
+	assert.False(t, state.StartRoute)
+	assert.False(t, state.Navigation.Active)
+}
+
+func TestClear(t *testing.T) {
+	n := New(config.Default().Navigator)
+	n.Add(Waypoint{Forward: 500})
+
+	state := standing(math3d.Pose{})
+	assert.NoError(t, n.Tick(time.Now(), state))
+	state.Pose = state.Target
+
+	// Clearing stops on the next tick, leaving the target where the
+	// controller put it.
+	n.Clear()
+	state.Target = state.Pose
+	assert.NoError(t, n.Tick(time.Now(), state))
+	assert.Equal(t, state.Pose, state.Target)
+	assert.False(t, state.Navigation.Active)
+
+	// Anything queued after that starts from wherever the hex is now.
+	n.Add(Waypoint{Forward: 50})
+	assert.NoError(t, n.Tick(time.Now(), state))
+	assert.Equal(t, math3d.Vector3{Z: 150}, state.Navigation.Goal.Position)
+}
+
+func TestReturnHome(t *testing.T) {
+	n := New(config.Default().Navigator)
+	home := math3d.Pose{Position: math3d.Vector3{X: 100, Z: -50}, Heading: 30}
+
+	state := standing(home)
+	state.Home = hexapod.HomeSet
+	assert.NoError(t, n.Tick(time.Now(), state))
+	assert.Equal(t, hexapod.HomeNone, state.Home)
+	assert.Equal(t, hexapod.Navigation{HasHome: true, Home: home}, state.Navigation)
+
+	// Wander off somewhere, and come back.
+	state.Pose = math3d.Pose{Position: math3d.Vector3{X: 400, Y: 40, Z: 300}, Heading: -60}
+	state.Target = state.Pose
+	state.Home = hexapod.HomeReturn
+	assert.NoError(t, n.Tick(time.Now(), state))
+	assert.True(t, state.Navigation.Homing)
+
+	// Turn left to face home, walk there, and turn most of the way around to
+	// the heading it was set with.
+	_, q := n.Status()
+	if assert.Len(t, q, 3) {
+		assert.InDelta(t, -79.4, q[0].Turn, 0.1)
+		assert.InDelta(t, 461, q[1].Forward, 1)
+		assert.InDelta(t, 169.4, q[2].Turn, 0.1)
+	}
+
+	state.Pose = state.Target
+	walk(t, n, state)
+	assert.InDelta(t, home.Position.X, state.Pose.Position.X, 0.001)
+	assert.InDelta(t, home.Position.Z, state.Pose.Position.Z, 0.001)
+	assert.InDelta(t, home.Heading, state.Pose.Heading, n.cfg.AngleTolerance)
+
+	// Home is kept, so it can be returned to again.
+	assert.Equal(t, hexapod.Navigation{HasHome: true, Home: home}, state.Navigation)
+}
+
+func TestReturnHomeFromNearby(t *testing.T) {
+	n := New(config.Default().Navigator)
+	state := standing(math3d.Pose{})
+	state.Home = hexapod.HomeSet
+	assert.NoError(t, n.Tick(time.Now(), state))
+
+	// Close enough not to bother turning to face it.
+	state.Pose = math3d.Pose{Position: math3d.Vector3{X: 10, Y: 40, Z: -10}, Heading: 20}
+	state.Home = hexapod.HomeReturn
+	assert.NoError(t, n.Tick(time.Now(), state))
+
+	_, q := n.Status()
+	if assert.Len(t, q, 1) {
+		assert.InDelta(t, -20, q[0].Turn, 0.001)
+	}
+}
+
+func TestSticksCancelReturnHome(t *testing.T) {
+	n := New(config.Default().Navigator)
+	state := standing(math3d.Pose{})
+	state.Home = hexapod.HomeSet
+	assert.NoError(t, n.Tick(time.Now(), state))
+
+	state.Pose.Position.Z = 500
+	state.Target = state.Pose
+	state.Home = hexapod.HomeReturn
+	assert.NoError(t, n.Tick(time.Now(), state))
+	assert.True(t, state.Navigation.Homing)
+
+	// Unlike a route, which only pauses.
+	state.Pose = state.Target
+	state.Input.R2 = 127
+	assert.NoError(t, n.Tick(time.Now(), state))
+	assert.False(t, state.Navigation.Active)
+	assert.False(t, state.Navigation.Homing)
+	assert.True(t, state.Navigation.HasHome)
+
+	_, q := n.Status()
+	assert.Empty(t, q)
+}
+
+func TestReturnHomeLimits(t *testing.T) {
+	n := New(config.Default().Navigator)
+	state := standing(math3d.Pose{})
+
+	// Nowhere to go yet.
+	state.Home = hexapod.HomeReturn
+	assert.NoError(t, n.Tick(time.Now(), state))
+	assert.False(t, state.Navigation.Active)
+
+	state.Home = hexapod.HomeSet
+	state.Drift = 100
+	assert.NoError(t, n.Tick(time.Now(), state))
+
+	// Too far away.
+	state.Pose.Position.X = 3500
+	state.Home = hexapod.HomeReturn
+	assert.NoError(t, n.Tick(time.Now(), state))
+	assert.False(t, state.Navigation.Active)
+
+	// Close enough, but the pose has drifted too much since home was set,
+	// so it's forgotten.
+	state.Pose.Position.X = 1000
+	state.Home = hexapod.HomeReturn
+	state.Drift = 601
+	assert.NoError(t, n.Tick(time.Now(), state))
+	assert.False(t, state.Navigation.Active)
+	assert.False(t, state.Navigation.HasHome)
+}
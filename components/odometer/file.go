@@ -0,0 +1,74 @@
+package odometer
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+)
+
+// Load reads the counters from the file at the given path. If the file doesn't
+// exist, they're all zero. Temporary files left behind by a crash mid-save are
+// removed, since the previous save is still intact.
+func Load(path string) (Counters, error) {
+	stale, _ := filepath.Glob(path + ".tmp*")
+	for _, p := range stale {
+		log.Warnf("removing temporary file left by an unfinished save: %s", p)
+		os.Remove(p)
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return Counters{}, nil
+	}
+	if err != nil {
+		return Counters{}, err
+	}
+
+	var c Counters
+	err = json.Unmarshal(b, &c)
+	if err != nil {
+		return Counters{}, fmt.Errorf("%s (while parsing %s)", err, path)
+	}
+
+	if math.IsNaN(c.Distance) || c.Distance < 0 || math.IsNaN(c.TorqueHours) || c.TorqueHours < 0 {
+		return Counters{}, fmt.Errorf("invalid counters in %s: %+v", path, c)
+	}
+
+	return c, nil
+}
+
+// Save writes the counters to the file at the given path. It writes to a
+// temporary file first, so a crash mid-write can't leave it truncated.
+func (c Counters) Save(path string) error {
+	b, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+
+	_, err = tmp.Write(append(b, '\n'))
+	if err == nil {
+		err = tmp.Close()
+	} else {
+		tmp.Close()
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	err = os.Rename(tmp.Name(), path)
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	return nil
+}
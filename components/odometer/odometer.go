@@ -0,0 +1,337 @@
+// Package odometer keeps lifetime counters for the wear and tear on the hex (how
+// far it has walked, how many steps each leg has taken, etc), which survive
+// restarts, so worn out parts can be replaced before they fail.
+package odometer
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adammck/hexapod"
+	"github.com/adammck/hexapod/math3d"
+)
+
+var log = hexapod.NewLog("odometer")
+
+// Flush is how often the counters are saved while running, so at most this
+// much is lost if the hex crashes (or the battery is pulled) rather than
+// shutting down.
+const Flush = time.Minute
+
+// The names of the legs, in the same order as hexapod.Usage.Steps (and
+// legs.Legs), for the names of their step counters.
+var legNames = [6]string{"FL", "FR", "MR", "BR", "BL", "ML"}
+
+// Counters are the lifetime totals, as persisted.
+type Counters struct {
+
+	// How far (in mm) the hex has walked, according to the pose, over the
+	// ground. Turning on the spot doesn't count.
+	Distance float64 `json:"distance"`
+
+	// The number of steps which each leg has taken, in the same order as
+	// legNames.
+	Steps [6]int64 `json:"steps"`
+
+	// How long (in hours) the servos have spent holding the legs up. See
+	// hexapod.Usage.
+	TorqueHours float64 `json:"torque_hours"`
+
+	// The number of errors sending to the servos.
+	BusErrors int64 `json:"bus_errors"`
+
+	// When each counter was last reset (see Reset), by name, if ever.
+	Resets map[string]time.Time `json:"resets,omitempty"`
+
+	// When the counters were last saved.
+	Saved time.Time `json:"saved"`
+}
+
+// copy returns a copy of the counters which doesn't share the map.
+func (c Counters) copy() Counters {
+	out := c
+	if c.Resets != nil {
+		out.Resets = make(map[string]time.Time, len(c.Resets))
+		for k, v := range c.Resets {
+			out.Resets[k] = v
+		}
+	}
+
+	return out
+}
+
+// Names returns the names of the counters which can be reset. Resetting
+// "steps" resets every leg, and e.g. "steps.FL" only the front left.
+func Names() []string {
+	names := []string{"distance", "steps", "torque", "bus_errors"}
+	for _, n := range legNames {
+		names = append(names, "steps."+n)
+	}
+
+	return names
+}
+
+// IsCounter returns true if the given name is one of Names.
+func IsCounter(name string) bool {
+	for _, n := range Names() {
+		if n == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Reset is the payload of hexapod.EventCounterReset: the name of the counter,
+// and what it was before it was reset (the total, for all of the steps).
+type Reset struct {
+	Counter string  `json:"counter"`
+	Was     float64 `json:"was"`
+}
+
+// Odometer is a component which adds to the lifetime counters every tick, from
+// the pose, the usage which the legs count (see hexapod.Usage), and the loop
+// stats. The counters are loaded at Boot, and saved every Flush (if they've
+// changed), and when shutting down.
+type Odometer struct {
+	path  string
+	stats func() hexapod.LoopStats
+	flush time.Duration
+
+	// The totals so far. Counters is called from other goroutines, so they're
+	// locked (along with the rest, which is only touched by Tick).
+	mu       sync.Mutex
+	counters Counters
+
+	// The state as of the last tick, which the totals are added to from. The
+	// usage and the bus errors are counted from boot, so they start at zero.
+	last      time.Time
+	pose      math3d.Pose
+	usage     hexapod.Usage
+	busErrors int64
+
+	// The time at which the counters were last saved (or loaded), and whether
+	// they've changed since.
+	saved time.Time
+	dirty bool
+}
+
+// New creates an odometer which persists the counters to the given path. The
+// loop stats (which are usually Hexapod.LoopStats) are read for the bus errors.
+func New(path string, stats func() hexapod.LoopStats) *Odometer {
+	return &Odometer{
+		path:  path,
+		stats: stats,
+		flush: Flush,
+	}
+}
+
+// Boot loads the counters. If the file is invalid, it's moved aside (so it can
+// be fixed by hand) and the counters start again from zero, rather than
+// stopping the hexapod from booting.
+func (o *Odometer) Boot() error {
+	c, err := Load(o.path)
+	if err != nil {
+		log.Warnf("%s (while loading counters)", err)
+
+		bad := o.path + ".corrupt"
+		err = os.Rename(o.path, bad)
+		if err != nil {
+			log.Warnf("%s (while moving counters aside)", err)
+		} else {
+			log.Warnf("moved invalid counters to %s, starting from zero", bad)
+		}
+	}
+
+	o.mu.Lock()
+	o.counters = c
+	o.mu.Unlock()
+
+	log.Infof("loaded counters from %s: %s", o.path, describe(c))
+	return nil
+}
+
+func (o *Odometer) Tick(now time.Time, state *hexapod.State) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.update(now, state)
+
+	reset := false
+	if name := state.ResetCounter; name != "" {
+		reset = o.reset(now, name, state)
+		state.ResetCounter = ""
+	}
+
+	if !o.dirty {
+		return nil
+	}
+
+	// Resets are saved straight away, since they're rare, and it'd be easy to
+	// forget to do it again after a crash. Otherwise they're saved once more by
+	// Shutdown, after the legs have sat down.
+	if now.Sub(o.saved) < o.flush && !reset {
+		return nil
+	}
+
+	err := o.save(now)
+	if err != nil {
+		log.RateLimited("save", time.Minute).Warnf("%s (while saving counters)", err)
+	}
+
+	return nil
+}
+
+// update adds the last tick to the totals. The lock must be held.
+func (o *Odometer) update(now time.Time, state *hexapod.State) {
+	c := &o.counters
+	p := state.Pose
+	u := state.Usage
+
+	if o.last.IsZero() {
+		o.saved = now
+	} else {
+		d := math.Hypot(p.Position.X-o.pose.Position.X, p.Position.Z-o.pose.Position.Z)
+		if d > 0 {
+			c.Distance += d
+			o.dirty = true
+		}
+	}
+
+	// The legs never count backwards, but don't trust that.
+	for i := range u.Steps {
+		if n := u.Steps[i] - o.usage.Steps[i]; n > 0 {
+			c.Steps[i] += int64(n)
+			o.dirty = true
+		}
+	}
+
+	if d := u.Torque - o.usage.Torque; d > 0 {
+		c.TorqueHours += d.Hours()
+		o.dirty = true
+	}
+
+	if o.stats != nil {
+		n := o.stats().BusErrors
+		if n > o.busErrors {
+			c.BusErrors += n - o.busErrors
+			o.dirty = true
+		}
+		o.busErrors = n
+	}
+
+	o.last = now
+	o.pose = p
+	o.usage = u
+}
+
+// reset resets the named counter, and logs (and publishes) what it was, and
+// returns true unless there's no such counter. The lock must be held.
+func (o *Odometer) reset(now time.Time, name string, state *hexapod.State) bool {
+	c := &o.counters
+	var was float64
+
+	switch {
+	case name == "distance":
+		was, c.Distance = c.Distance, 0
+
+	case name == "torque":
+		was, c.TorqueHours = c.TorqueHours, 0
+
+	case name == "bus_errors":
+		was, c.BusErrors = float64(c.BusErrors), 0
+
+	case name == "steps":
+		for i := range c.Steps {
+			was += float64(c.Steps[i])
+			c.Steps[i] = 0
+		}
+
+	case strings.HasPrefix(name, "steps."):
+		i := leg(strings.TrimPrefix(name, "steps."))
+		if i < 0 {
+			log.Warnf("ignoring reset of unknown counter: %s", name)
+			return false
+		}
+		was, c.Steps[i] = float64(c.Steps[i]), 0
+
+	default:
+		log.Warnf("ignoring reset of unknown counter: %s", name)
+		return false
+	}
+
+	if c.Resets == nil {
+		c.Resets = map[string]time.Time{}
+	}
+	c.Resets[name] = now
+	o.dirty = true
+
+	log.Warnf("reset %s counter (was %v)", name, was)
+	state.Publish(hexapod.EventCounterReset, hexapod.Info, Reset{Counter: name, Was: was})
+	return true
+}
+
+// leg returns the index of the leg with the given name, or -1.
+func leg(name string) int {
+	for i, n := range legNames {
+		if n == name {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// save writes the counters to the file. If that fails, they're still dirty, so
+// it's tried again on the next tick. The lock must be held.
+func (o *Odometer) save(now time.Time) error {
+	c := o.counters
+	c.Saved = now
+	err := c.Save(o.path)
+	if err != nil {
+		return err
+	}
+
+	o.counters.Saved = now
+	o.saved = now
+	o.dirty = false
+	return nil
+}
+
+// Counters returns the totals so far. Unlike most methods of components, this
+// is safe to call from any goroutine.
+func (o *Odometer) Counters() Counters {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	// The map is copied, since the next reset would change it underneath.
+	return o.counters.copy()
+}
+
+// Shutdown saves the counters, once the loop has stopped, in case they've
+// changed since they were last saved.
+func (o *Odometer) Shutdown() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if !o.dirty {
+		return nil
+	}
+
+	return o.save(time.Now())
+}
+
+// describe returns the counters as a short string, for logging.
+func describe(c Counters) string {
+	steps := make([]string, len(legNames))
+	for i, n := range legNames {
+		steps[i] = fmt.Sprintf("%s=%d", n, c.Steps[i])
+	}
+
+	return fmt.Sprintf("walked %.0fmm, %.1f torque hours, %d bus errors, steps %s",
+		c.Distance, c.TorqueHours, c.BusErrors, strings.Join(steps, " "))
+}
@@ -0,0 +1,253 @@
+package odometer
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/adammck/hexapod"
+	"github.com/adammck/hexapod/hexapodtest"
+	"github.com/stretchr/testify/assert"
+)
+
+type fixture struct {
+	*hexapodtest.Clock
+	path      string
+	busErrors int64
+}
+
+func setup(t *testing.T) *fixture {
+	c := hexapodtest.NewClock(t, time.Second)
+	c.Now = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	return &fixture{
+		Clock: c,
+		path:  filepath.Join(t.TempDir(), "odometer.json"),
+	}
+}
+
+func (f *fixture) new(t *testing.T) *Odometer {
+	o := New(f.path, func() hexapod.LoopStats {
+		return hexapod.LoopStats{BusErrors: f.busErrors}
+	})
+	assert.NoError(t, o.Boot())
+	return o
+}
+
+// tick ticks the odometer the given number of times, a second apart, calling
+// the func before each.
+func (f *fixture) tick(o *Odometer, n int, fn func()) {
+	for i := 0; i < n; i++ {
+		fn()
+		f.Tick(o)
+	}
+}
+
+func (f *fixture) load(t *testing.T) Counters {
+	c, err := Load(f.path)
+	assert.NoError(t, err)
+	return c
+}
+
+func TestAccumulate(t *testing.T) {
+	f := setup(t)
+	o := f.new(t)
+	s := f.State
+
+	// Walk 500mm forwards and 300mm sideways, and then turn on the spot, which
+	// doesn't count, while the legs step and the bus errors pile up.
+	f.tick(o, 1, func() {})
+	f.tick(o, 10, func() {
+		s.Pose.Position.Z += 50
+		s.Usage.Steps[0]++
+		s.Usage.Steps[3] += 2
+		s.Usage.Torque += 30 * time.Minute
+		f.busErrors++
+	})
+	f.tick(o, 3, func() {
+		s.Pose.Position.X -= 100
+	})
+	f.tick(o, 5, func() {
+		s.Pose.Heading += 10
+		s.Usage.Torque += 6 * time.Minute
+	})
+
+	c := o.Counters()
+	assert.InDelta(t, 800, c.Distance, 0.001)
+	assert.Equal(t, [6]int64{10, 0, 0, 20, 0, 0}, c.Steps)
+	assert.InDelta(t, 5.5, c.TorqueHours, 0.001)
+	assert.Equal(t, int64(10), c.BusErrors)
+
+	// The next session carries on from there, even though the legs and the
+	// loop count from zero again.
+	assert.NoError(t, o.Shutdown())
+	f.State = &hexapod.State{}
+	f.busErrors = 0
+	s = f.State
+
+	o = f.new(t)
+	f.tick(o, 1, func() {})
+	f.tick(o, 2, func() {
+		s.Pose.Position.Z -= 100
+		s.Usage.Steps[0]++
+		f.busErrors++
+	})
+
+	c = o.Counters()
+	assert.InDelta(t, 1000, c.Distance, 0.001)
+	assert.Equal(t, [6]int64{12, 0, 0, 20, 0, 0}, c.Steps)
+	assert.InDelta(t, 5.5, c.TorqueHours, 0.001)
+	assert.Equal(t, int64(12), c.BusErrors)
+}
+
+func TestFlush(t *testing.T) {
+	f := setup(t)
+	o := f.new(t)
+	s := f.State
+
+	// Nothing is written until a minute has passed, and then only once a
+	// minute while walking.
+	f.tick(o, 60, func() {
+		s.Pose.Position.Z += 10
+	})
+	assert.NoFileExists(t, f.path)
+
+	f.tick(o, 1, func() {
+		s.Pose.Position.Z += 10
+	})
+	assert.InDelta(t, 600, f.load(t).Distance, 0.001)
+	assert.Equal(t, f.Now, f.load(t).Saved)
+
+	f.tick(o, 30, func() {
+		s.Pose.Position.Z += 10
+	})
+	assert.InDelta(t, 600, f.load(t).Distance, 0.001)
+
+	// Or not at all while standing still, once what was walked is written,
+	// since nothing changes.
+	saved := f.load(t).Saved
+	f.tick(o, 30, func() {})
+	assert.InDelta(t, 900, f.load(t).Distance, 0.001)
+	f.tick(o, 120, func() {})
+	assert.Equal(t, saved.Add(time.Minute), f.load(t).Saved)
+
+	// The rest is written at shutdown.
+	f.tick(o, 1, func() {
+		s.Pose.Position.Z += 10
+	})
+	assert.NoError(t, o.Shutdown())
+	assert.InDelta(t, 910, f.load(t).Distance, 0.001)
+}
+
+func TestCrashRecovery(t *testing.T) {
+	f := setup(t)
+	o := f.new(t)
+	s := f.State
+
+	f.tick(o, 61, func() {
+		s.Pose.Position.Z += 10
+		s.Usage.Steps[1]++
+	})
+
+	// Crash, without shutting down, halfway through the next save. The file
+	// is as of the last flush, and the half-written one is left behind.
+	f.tick(o, 10, func() {
+		s.Pose.Position.Z += 10
+	})
+	tmp := f.path + ".tmp123"
+	assert.NoError(t, os.WriteFile(tmp, []byte(`{"distance": 12`), 0644))
+
+	// The next boot picks up from the last flush, and removes the debris.
+	f.State = &hexapod.State{}
+	s = f.State
+	o = f.new(t)
+	assert.NoFileExists(t, tmp)
+
+	c := o.Counters()
+	assert.InDelta(t, 600, c.Distance, 0.001)
+	assert.Equal(t, int64(61), c.Steps[1])
+
+	f.tick(o, 1, func() {})
+	f.tick(o, 1, func() {
+		s.Pose.Position.Z += 10
+	})
+	assert.InDelta(t, 610, o.Counters().Distance, 0.001)
+}
+
+func TestCorruptFile(t *testing.T) {
+	f := setup(t)
+	assert.NoError(t, os.WriteFile(f.path, []byte(`{"distance": `), 0644))
+
+	// The counters start from zero, rather than failing to boot, and the bad
+	// file is kept for fixing by hand.
+	o := f.new(t)
+	assert.Equal(t, Counters{}, o.Counters())
+	assert.FileExists(t, f.path+".corrupt")
+	assert.NoFileExists(t, f.path)
+}
+
+func TestReset(t *testing.T) {
+	f := setup(t)
+	o := f.new(t)
+	s := f.State
+
+	f.tick(o, 1, func() {})
+	f.tick(o, 10, func() {
+		s.Pose.Position.Z += 10
+		for i := range s.Usage.Steps {
+			s.Usage.Steps[i]++
+		}
+		s.Usage.Torque += time.Hour
+	})
+
+	// Reset the step counter of one leg, e.g. after replacing its servos. It's
+	// saved straight away, without waiting for the flush.
+	reset := f.Now.Add(f.Interval)
+	f.tick(o, 1, func() {
+		s.ResetCounter = "steps.BR"
+	})
+	assert.Empty(t, s.ResetCounter)
+
+	c := f.load(t)
+	assert.Equal(t, [6]int64{10, 10, 10, 0, 10, 10}, c.Steps)
+	assert.InDelta(t, 100, c.Distance, 0.001)
+	assert.InDelta(t, 10, c.TorqueHours, 0.001)
+	assert.Equal(t, map[string]time.Time{"steps.BR": reset}, c.Resets)
+
+	events := s.Published()
+	assert.Len(t, events, 1)
+	assert.Equal(t, hexapod.EventCounterReset, events[0].Name)
+	assert.Equal(t, Reset{Counter: "steps.BR", Was: 10}, events[0].Payload)
+
+	// It carries on counting from zero.
+	f.tick(o, 1, func() {
+		s.Usage.Steps[3]++
+	})
+	assert.Equal(t, int64(1), o.Counters().Steps[3])
+
+	// The others are reset the same way.
+	f.tick(o, 1, func() {
+		s.ResetCounter = "distance"
+	})
+	c = o.Counters()
+	assert.Equal(t, 0.0, c.Distance)
+	assert.InDelta(t, 10, c.TorqueHours, 0.001)
+	assert.Len(t, c.Resets, 2)
+
+	// Unknown counters are ignored.
+	f.tick(o, 1, func() {
+		s.ResetCounter = "steps.XX"
+	})
+	assert.Empty(t, s.ResetCounter)
+	assert.Equal(t, [6]int64{10, 10, 10, 1, 10, 10}, o.Counters().Steps)
+	assert.Len(t, o.Counters().Resets, 2)
+}
+
+func TestNames(t *testing.T) {
+	for _, n := range Names() {
+		assert.True(t, IsCounter(n), n)
+	}
+
+	assert.False(t, IsCounter("steps.XX"))
+	assert.False(t, IsCounter("voltage"))
+}
@@ -0,0 +1,333 @@
+// Package power estimates the current drawn from the battery, from the load on
+// each servo, since there's no current sensor. It's rough, but good enough to
+// tell a lazy afternoon from a hill climb, and it can be calibrated against a
+// meter to do better.
+package power
+
+import (
+	"errors"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/adammck/hexapod"
+	"github.com/adammck/hexapod/config"
+	"github.com/adammck/hexapod/params"
+	"github.com/adammck/hexapod/servos"
+)
+
+var log = hexapod.NewLog("power")
+
+// The range of the params which calibration sets. See config.Power.
+const (
+	maxBase = 5
+	minGain = 0.1
+	maxGain = 10
+)
+
+// Servo is anything which can report its model and present load, e.g. a
+// servo.Servo.
+type Servo interface {
+	ModelNumber() (int, error)
+	PresentLoad() (int, error)
+}
+
+// Point is a single calibration reading: the current (in amps) modelled for
+// the servos (before the gain is applied), and the current which was actually
+// measured at the time.
+type Point struct {
+	Load    float64 `json:"load"`
+	Current float64 `json:"current"`
+}
+
+// Calibration is the readings taken so far, and the params fitted to them.
+type Calibration struct {
+	Points []Point `json:"points"`
+	Base   float64 `json:"base"`
+	Gain   float64 `json:"gain"`
+}
+
+// Power is a component which reads the present load of one servo per tick (so
+// each is read every few hundred ms, without hogging the bus), and models the
+// current drawn by each from its load. The sum of those (times the gain), plus
+// the base current drawn by everything else, is smoothed and written to
+// State.Power every tick, along with the charge drawn since boot.
+//
+// The base and gain are params, so they can be persisted by the settings
+// component. Calibrate fits them to readings from a meter.
+type Power struct {
+	cfg    config.Power
+	servos []Servo
+
+	// The registry to register the params with at boot. This is params.Default
+	// unless changed, so more than one instance can be booted (e.g. in tests).
+	Params *params.Registry
+
+	// The model of each servo (read at boot), its most recent load (from zero
+	// to one, regardless of direction), and the next servo to read.
+	models []config.PowerModel
+	loads  []float64
+	next   int
+
+	// When the last tick was, and the charge (in mAh) drawn since boot.
+	last   time.Time
+	charge float64
+
+	// Shared with the API, via Calibrate and Calibration. The load is the
+	// smoothed current modelled for the servos, before the gain.
+	mu     sync.Mutex
+	base   float64
+	gain   float64
+	load   float64
+	points []Point
+
+	// Held by Calibrate, so two readings can't race.
+	calibrating sync.Mutex
+}
+
+// New creates a power estimator which reads the load of the given servos.
+func New(srvs []Servo, cfg config.Power) *Power {
+	return &Power{
+		cfg:    cfg,
+		servos: srvs,
+		Params: params.Default,
+		base:   cfg.Base,
+		gain:   cfg.Gain,
+	}
+}
+
+// Writes returns hexapod.Estimator, since the current is only an estimate.
+func (p *Power) Writes() hexapod.Role {
+	return hexapod.Estimator
+}
+
+// Boot registers the power.base and power.gain params, and reads the model of
+// each servo. Servos whose model can't be read (or isn't in the config) use the
+// first model in the config.
+func (p *Power) Boot() error {
+	for _, prm := range []params.Param{
+		{
+			Name: "power.base",
+			Type: params.Float,
+			Min:  0,
+			Max:  maxBase,
+			Get:  func() float64 { return p.get(&p.base) },
+			Set:  func(v float64) { p.set(&p.base, v) },
+		},
+		{
+			Name: "power.gain",
+			Type: params.Float,
+			Min:  minGain,
+			Max:  maxGain,
+			Get:  func() float64 { return p.get(&p.gain) },
+			Set:  func(v float64) { p.set(&p.gain, v) },
+		},
+	} {
+		err := p.Params.Register(prm)
+		if err != nil {
+			return err
+		}
+	}
+
+	p.models = make([]config.PowerModel, len(p.servos))
+	p.loads = make([]float64, len(p.servos))
+
+	for i, s := range p.servos {
+		p.models[i] = p.cfg.Models[0]
+
+		n, err := s.ModelNumber()
+		if err != nil {
+			log.Warnf("%s (while reading model of servo %d)", err, i)
+			continue
+		}
+
+		m, ok := p.model(n)
+		if !ok {
+			log.Warnf("no power model for servo %d (model %d), using model %d", i, n, m.Model)
+		}
+		p.models[i] = m
+	}
+
+	return nil
+}
+
+// model returns the config for the given model number, or the first (and
+// false) if there isn't one.
+func (p *Power) model(n int) (config.PowerModel, bool) {
+	for _, m := range p.cfg.Models {
+		if m.Model == n {
+			return m, true
+		}
+	}
+
+	return p.cfg.Models[0], false
+}
+
+func (p *Power) get(v *float64) float64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return *v
+}
+
+func (p *Power) set(v *float64, f float64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	*v = f
+}
+
+func (p *Power) Tick(now time.Time, state *hexapod.State) error {
+	if len(p.servos) > 0 {
+		p.read()
+	}
+
+	// The current modelled for the servos, before the gain.
+	var sum float64
+	for i, l := range p.loads {
+		m := p.models[i]
+		sum += m.Idle + m.Stall*l
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.last.IsZero() {
+		p.load = sum
+	} else {
+		dt := now.Sub(p.last)
+		p.load = smooth(p.load, sum, dt, p.cfg.Smoothing.Duration)
+
+		// mAh, from amps and seconds. The raw sum is integrated, rather than
+		// the smoothed, since smoothing only delays it.
+		p.charge += (p.base + p.gain*sum) * dt.Seconds() / 3.6
+	}
+	p.last = now
+
+	state.Power = hexapod.Power{
+		Current: p.base + p.gain*p.load,
+		Charge:  p.charge,
+	}
+
+	return nil
+}
+
+// read reads the load of the next servo. If it can't be read, the last reading
+// is kept.
+func (p *Power) read() {
+	i := p.next
+	p.next = (p.next + 1) % len(p.servos)
+
+	v, err := p.servos[i].PresentLoad()
+	if err != nil {
+		log.RateLimited("read", time.Minute).Warnf("%s (while reading load of servo %d)", err, i)
+		return
+	}
+
+	p.loads[i] = math.Abs(servos.Load(v))
+}
+
+// smooth returns the exponential moving average of prev and v, over a time
+// constant of tc. A time constant of zero means no smoothing.
+func smooth(prev, v float64, dt, tc time.Duration) float64 {
+	if tc <= 0 {
+		return v
+	}
+
+	a := 1 - math.Exp(-dt.Seconds()/tc.Seconds())
+	return prev + a*(v-prev)
+}
+
+// Calibrate records the given current (in amps), which should have been
+// measured (with a meter between the battery and the hex) while the hex was
+// doing whatever it's doing now, and fits the base and gain to every reading
+// so far. The new params are applied at the next tick. This is safe to call
+// from any goroutine.
+//
+// With only one reading (or readings at about the same load), only the gain is
+// fitted, so take a couple at different loads, e.g. parked and mid-stride, to
+// fit the base too.
+func (p *Power) Calibrate(current float64) (Calibration, error) {
+	if math.IsNaN(current) || current <= 0 {
+		return Calibration{}, errors.New("current must be greater than zero")
+	}
+
+	p.calibrating.Lock()
+	defer p.calibrating.Unlock()
+
+	p.mu.Lock()
+	pts := append(append([]Point{}, p.points...), Point{p.load, current})
+	base, gain, err := fit(pts, p.base)
+	p.mu.Unlock()
+	if err != nil {
+		return Calibration{}, err
+	}
+
+	// Not while holding mu, since the registry calls the params (which take
+	// it) while holding its own lock.
+	err = p.Params.Set(map[string]float64{"power.base": base, "power.gain": gain})
+	if err != nil {
+		return Calibration{}, err
+	}
+
+	log.Infof("calibrated with %d readings: base=%.3fA, gain=%.3f", len(pts), base, gain)
+
+	p.mu.Lock()
+	p.points = pts
+	p.mu.Unlock()
+
+	return Calibration{pts, base, gain}, nil
+}
+
+// Calibration returns the readings taken so far, and the current params. This
+// is safe to call from any goroutine.
+func (p *Power) Calibration() Calibration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return Calibration{append([]Point{}, p.points...), p.base, p.gain}
+}
+
+// ResetCalibration forgets the readings taken so far, but not the params which
+// were fitted to them. This is safe to call from any goroutine.
+func (p *Power) ResetCalibration() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.points = nil
+}
+
+// fit returns the base and gain which best fit (by least squares) the given
+// readings. If they're all at about the same load, the given base is kept, and
+// only the gain is fitted.
+func fit(pts []Point, base float64) (float64, float64, error) {
+	n := float64(len(pts))
+
+	var ml, mc float64
+	for _, pt := range pts {
+		ml += pt.Load / n
+		mc += pt.Current / n
+	}
+
+	var cov, v float64
+	for _, pt := range pts {
+		cov += (pt.Load - ml) * (pt.Current - mc)
+		v += (pt.Load - ml) * (pt.Load - ml)
+	}
+
+	// Less than 10mA apart, on average.
+	var gain float64
+	if v/n < 0.01*0.01 {
+		if ml <= 0 {
+			return 0, 0, errors.New("can't calibrate with no load")
+		}
+		gain = (mc - base) / ml
+	} else {
+		gain = cov / v
+		base = mc - gain*ml
+	}
+
+	if gain < minGain || gain > maxGain || base < 0 || base > maxBase {
+		return 0, 0, errors.New("readings don't fit the model (is the meter in the right place?)")
+	}
+
+	return base, gain, nil
+}
@@ -0,0 +1,200 @@
+package power
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/adammck/hexapod"
+	"github.com/adammck/hexapod/config"
+	"github.com/adammck/hexapod/params"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeServo struct {
+	model int
+	load  int
+	err   error
+	reads int
+}
+
+func (s *fakeServo) ModelNumber() (int, error) {
+	return s.model, s.err
+}
+
+func (s *fakeServo) PresentLoad() (int, error) {
+	s.reads++
+	return s.load, s.err
+}
+
+func testConfig() config.Power {
+	return config.Power{
+		Base: 0.5,
+		Gain: 1,
+		Models: []config.PowerModel{
+			{Model: 12, Idle: 0.05, Stall: 1.0},
+			{Model: 18, Idle: 0.1, Stall: 2.0},
+		},
+	}
+}
+
+// setup returns a booted power estimator for the given servos, with its own
+// registry.
+func setup(t *testing.T, cfg config.Power, srvs ...*fakeServo) (*Power, *params.Registry) {
+	ss := make([]Servo, len(srvs))
+	for i, s := range srvs {
+		ss[i] = s
+	}
+
+	p := New(ss, cfg)
+	p.Params = params.New()
+	assert.NoError(t, p.Boot())
+	return p, p.Params
+}
+
+func TestAggregation(t *testing.T) {
+	a := &fakeServo{model: 12, load: 512}
+	b := &fakeServo{model: 12, load: 1024 + 1023}
+	c := &fakeServo{model: 18, load: 0}
+	d := &fakeServo{model: 99, load: 1023}
+	p, _ := setup(t, testConfig(), a, b, c, d)
+
+	// Each tick reads a single servo, in turn.
+	state := &hexapod.State{}
+	now := time.Unix(0, 0)
+	for i := 0; i < 8; i++ {
+		assert.NoError(t, p.Tick(now, state))
+		now = now.Add(time.Second / 60)
+	}
+	assert.Equal(t, []int{2, 2, 2, 2}, []int{a.reads, b.reads, c.reads, d.reads})
+
+	// The direction of the load doesn't matter. The unknown model uses the
+	// first.
+	want := 0.5 + (0.05 + 1.0*512/1023) + (0.05 + 1.0) + (0.1 + 0) + (0.05 + 1.0)
+	assert.InDelta(t, want, state.Power.Current, 0.0001)
+
+	// A servo which can't be read keeps its last reading.
+	b.err = errors.New("timeout")
+	for i := 0; i < 4; i++ {
+		assert.NoError(t, p.Tick(now, state))
+		now = now.Add(time.Second / 60)
+	}
+	assert.InDelta(t, want, state.Power.Current, 0.0001)
+
+	// The gain only applies to the servos.
+	p.set(&p.gain, 2)
+	assert.NoError(t, p.Tick(now, state))
+	assert.InDelta(t, 0.5+(want-0.5)*2, state.Power.Current, 0.0001)
+}
+
+func TestSmoothingAndCharge(t *testing.T) {
+	cfg := testConfig()
+	cfg.Smoothing = config.Duration{Duration: time.Second}
+	cfg.Base = 0
+
+	s := &fakeServo{model: 12, load: 0}
+	p, _ := setup(t, cfg, s)
+
+	state := &hexapod.State{}
+	now := time.Unix(0, 0)
+	tick := func(d time.Duration) {
+		for end := now.Add(d); now.Before(end); now = now.Add(10 * time.Millisecond) {
+			assert.NoError(t, p.Tick(now, state))
+		}
+	}
+
+	tick(time.Second)
+	assert.InDelta(t, 0.05, state.Power.Current, 0.0001)
+
+	// A step from idle to full load takes the time constant to get about 63%
+	// of the way there, and four times that to get about 98%.
+	s.load = 1023
+	tick(time.Second)
+	assert.InDelta(t, 0.05+(1-math.Exp(-1)), state.Power.Current, 0.01)
+	tick(3 * time.Second)
+	assert.InDelta(t, 0.05+(1-math.Exp(-4)), state.Power.Current, 0.01)
+
+	// The charge is from the raw current, so is exactly a second at 0.05A and
+	// four at 1.05A, give or take one tick, in mAh.
+	assert.InDelta(t, (0.05*1+1.05*4)/3.6, state.Power.Charge, 1.05*0.01/3.6)
+}
+
+func TestCalibrate(t *testing.T) {
+	s := &fakeServo{model: 12, load: 0}
+	p, r := setup(t, testConfig(), s)
+
+	state := &hexapod.State{}
+	now := time.Unix(0, 0)
+	tick := func() {
+		r.Apply()
+		assert.NoError(t, p.Tick(now, state))
+		now = now.Add(time.Second / 60)
+	}
+
+	// At idle, the servo is modelled at 0.05A, plus the 0.5A base. With only
+	// one reading, only the gain is fitted, so it's (0.6-0.5)/0.05.
+	tick()
+	c, err := p.Calibrate(0.6)
+	assert.NoError(t, err)
+	assert.Equal(t, []Point{{0.05, 0.6}}, c.Points)
+	assert.InDelta(t, 0.5, c.Base, 0.0001)
+	assert.InDelta(t, 2, c.Gain, 0.0001)
+
+	// The params are applied at the next tick.
+	assert.InDelta(t, 0.55, state.Power.Current, 0.0001)
+	tick()
+	assert.InDelta(t, 0.6, state.Power.Current, 0.0001)
+
+	// Start again, at full load, where the servo is modelled at 1.05A, and
+	// then at idle. The two readings fit a line from (0.05, 0.35) to (1.05,
+	// 1.65), which is a base of 0.285A and a gain of 1.3.
+	p.ResetCalibration()
+	assert.Len(t, p.Calibration().Points, 0)
+
+	s.load = 1023
+	tick()
+	_, err = p.Calibrate(1.65)
+	assert.NoError(t, err)
+	s.load = 0
+	tick()
+	c, err = p.Calibrate(0.35)
+	assert.NoError(t, err)
+	if assert.Len(t, c.Points, 2) {
+		assert.InDelta(t, 1.05, c.Points[0].Load, 0.0001)
+		assert.InDelta(t, 0.05, c.Points[1].Load, 0.0001)
+	}
+	assert.InDelta(t, 0.285, c.Base, 0.0001)
+	assert.InDelta(t, 1.3, c.Gain, 0.0001)
+
+	s.load = 1023
+	tick()
+	assert.InDelta(t, 1.65, state.Power.Current, 0.0001)
+	assert.Equal(t, c, p.Calibration())
+
+	v, _ := r.Get("power.gain")
+	assert.InDelta(t, 1.3, v, 0.0001)
+}
+
+func TestCalibrateRejectsNonsense(t *testing.T) {
+	p, _ := setup(t, testConfig(), &fakeServo{model: 12, load: 0})
+	assert.NoError(t, p.Tick(time.Unix(0, 0), &hexapod.State{}))
+
+	for _, v := range []float64{0, -1, math.NaN()} {
+		_, err := p.Calibrate(v)
+		assert.Error(t, err, "%v", v)
+	}
+
+	// Less than the base, which would need a negative gain.
+	_, err := p.Calibrate(0.4)
+	assert.Error(t, err)
+
+	// Nothing was recorded.
+	assert.Len(t, p.Calibration().Points, 0)
+
+	// There's nothing to calibrate against without any servos.
+	p, _ = setup(t, testConfig())
+	assert.NoError(t, p.Tick(time.Unix(0, 0), &hexapod.State{}))
+	_, err = p.Calibrate(1)
+	assert.Error(t, err)
+}
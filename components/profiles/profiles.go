@@ -0,0 +1,259 @@
+package profiles
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/adammck/hexapod"
+	"github.com/adammck/hexapod/config"
+	"github.com/adammck/hexapod/math3d"
+	"github.com/adammck/hexapod/params"
+)
+
+var log = hexapod.NewLog("profiles")
+
+// RampDuration is how long it takes to move each param to its new value when
+// the profile is switched while walking. When standing still, the new values
+// are applied right away.
+const RampDuration = 2 * time.Second
+
+// Profiles is a component which switches between the named sets of params in
+// the config, when State.NextProfile is set (e.g. by the controller).
+//
+// Params are layered: the values when the component first ticks are the
+// defaults, the active profile overrides some of them, and any runtime
+// adjustment (e.g. via the API, or Up and Down on the controller) overrides
+// that, even across later profile switches. Params which aren't mentioned by
+// any profile are never touched.
+//
+// Like settings, this must be added after every component which registers one
+// of the params, since they're validated at Boot.
+type Profiles struct {
+	profiles []config.Profile
+	initial  string
+	registry *params.Registry
+	duration time.Duration
+
+	// The index of the active profile, or -1 if none is.
+	active int
+
+	// The params mentioned by any profile, and their types.
+	types map[string]params.Type
+
+	// The default value of each param, as of the first tick. Nil until then.
+	base map[string]float64
+
+	// The value which each param should have, if nobody else has written to it.
+	// This is the last value written by this component (or the default).
+	expected map[string]float64
+
+	// Params which have been written by something else, and so are left alone.
+	adjusted map[string]bool
+
+	// Params which are moving towards their new values.
+	ramps map[string]ramp
+}
+
+type ramp struct {
+	from  float64
+	to    float64
+	start time.Time
+	dur   time.Duration
+}
+
+// at returns the value of the ramp at the given time, and whether it's done.
+func (r ramp) at(now time.Time) (float64, bool) {
+	t := 1.0
+	if r.dur > 0 {
+		t = math.Min(1, float64(now.Sub(r.start))/float64(r.dur))
+	}
+
+	return r.from + ((r.to - r.from) * t), t >= 1
+}
+
+// New creates a profiles component which switches between the given profiles,
+// starting with the named one (or none, if empty), by writing to the params in
+// the given registry.
+func New(profiles []config.Profile, initial string, r *params.Registry) *Profiles {
+	return &Profiles{
+		profiles: profiles,
+		initial:  initial,
+		registry: r,
+		duration: RampDuration,
+		active:   -1,
+		types:    map[string]params.Type{},
+		adjusted: map[string]bool{},
+		ramps:    map[string]ramp{},
+	}
+}
+
+// Boot checks that every value in every profile could be written to its param,
+// so that typos are caught at boot rather than when switching in the field.
+func (p *Profiles) Boot() error {
+	vals := map[string]params.Value{}
+	for _, v := range p.registry.Values() {
+		vals[v.Name] = v
+	}
+
+	for _, pr := range p.profiles {
+		for n, v := range pr.Params {
+			err := p.registry.Validate(n, v)
+			if err != nil {
+				return fmt.Errorf("%s (in profile %s)", err, pr.Name)
+			}
+
+			p.types[n] = vals[n].Type
+		}
+	}
+
+	log.Infof("loaded %d profiles", len(p.profiles))
+	return nil
+}
+
+func (p *Profiles) Tick(now time.Time, state *hexapod.State) error {
+	if len(p.profiles) == 0 {
+		state.NextProfile = false
+		return nil
+	}
+
+	cur := p.current()
+	writes := map[string]float64{}
+
+	if p.base == nil {
+		p.base = cur
+		p.expected = map[string]float64{}
+		for n, v := range cur {
+			p.expected[n] = v
+		}
+
+		if p.initial != "" {
+			p.activate(p.find(p.initial), now, 0)
+		}
+	}
+
+	// Anything which doesn't have the value it should have was written by some
+	// other component, so stop ramping it, and leave it alone from now on.
+	for n, v := range cur {
+		if v != p.expected[n] {
+			if !p.adjusted[n] {
+				log.Debugf("%s adjusted to %v, ignoring profiles", n, v)
+			}
+
+			p.adjusted[n] = true
+			p.expected[n] = v
+			delete(p.ramps, n)
+		}
+	}
+
+	if state.NextProfile {
+		state.NextProfile = false
+
+		var d time.Duration
+		if walking(state) {
+			d = p.duration
+		}
+
+		p.activate((p.active+1)%len(p.profiles), now, d)
+	}
+
+	for n, r := range p.ramps {
+		v, done := r.at(now)
+		if done {
+			delete(p.ramps, n)
+		}
+
+		if p.types[n] == params.Int || p.types[n] == params.Bool {
+			v = math.Round(v)
+		}
+
+		if v != p.expected[n] {
+			writes[n] = v
+		}
+	}
+
+	if len(writes) > 0 {
+		err := p.registry.Set(writes)
+		if err != nil {
+			log.RateLimited("set", time.Second).Warnf("%s (while applying profile)", err)
+		} else {
+			for n, v := range writes {
+				p.expected[n] = v
+			}
+		}
+	}
+
+	state.Profile = p.name()
+	return nil
+}
+
+// activate switches to the profile at the given index, by ramping each param
+// (which hasn't been adjusted) to its new value over the given duration.
+func (p *Profiles) activate(i int, now time.Time, d time.Duration) {
+	if i < 0 {
+		return
+	}
+
+	p.active = i
+	pr := p.profiles[i]
+	log.Infof("activating profile: %s", pr.Name)
+
+	for n := range p.types {
+		if p.adjusted[n] {
+			continue
+		}
+
+		to, ok := pr.Params[n]
+		if !ok {
+			to = p.base[n]
+		}
+
+		p.ramps[n] = ramp{
+			from:  p.expected[n],
+			to:    to,
+			start: now,
+			dur:   d,
+		}
+	}
+}
+
+// find returns the index of the profile with the given name, or -1.
+func (p *Profiles) find(name string) int {
+	for i, pr := range p.profiles {
+		if pr.Name == name {
+			return i
+		}
+	}
+
+	log.Warnf("no such profile: %s", name)
+	return -1
+}
+
+func (p *Profiles) name() string {
+	if p.active < 0 {
+		return ""
+	}
+
+	return p.profiles[p.active].Name
+}
+
+// current returns the current value of each param mentioned by any profile.
+func (p *Profiles) current() map[string]float64 {
+	out := map[string]float64{}
+	for _, v := range p.registry.Values() {
+		if _, ok := p.types[v.Name]; ok {
+			out[v.Name] = v.Value
+		}
+	}
+
+	return out
+}
+
+// walking returns true if the target is away from the current pose, i.e. the
+// legs are (or will soon be) stepping towards it.
+func walking(state *hexapod.State) bool {
+	d := state.Target.Position.Subtract(state.Pose.Position)
+	d.Y = 0
+
+	return d.Length() > 1 || math.Abs(math3d.AngleDiff(state.Target.Heading, state.Pose.Heading)) > 0.5
+}
@@ -0,0 +1,162 @@
+package profiles
+
+import (
+	"testing"
+	"time"
+
+	"github.com/adammck/hexapod"
+	"github.com/adammck/hexapod/config"
+	"github.com/adammck/hexapod/hexapodtest"
+	"github.com/adammck/hexapod/math3d"
+	"github.com/adammck/hexapod/params"
+	"github.com/stretchr/testify/assert"
+)
+
+type fixture struct {
+	r     *params.Registry
+	p     *Profiles
+	state *hexapod.State
+	t0    time.Time
+
+	clearance  float64
+	stepHeight float64
+	speed      float64
+	other      float64
+}
+
+var testProfiles = []config.Profile{
+	{Name: "indoor", Params: map[string]float64{"controller.clearance": 30, "legs.step_height": 25, "hexapod.speed": -4}},
+	{Name: "outdoor", Params: map[string]float64{"controller.clearance": 80}},
+}
+
+func setup(t *testing.T, initial string) *fixture {
+	f := &fixture{
+		r:          params.New(),
+		state:      &hexapod.State{},
+		t0:         time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+		clearance:  40,
+		stepHeight: 40,
+	}
+
+	for _, p := range []params.Param{
+		hexapodtest.Param("controller.clearance", params.Float, 0, 120, &f.clearance),
+		hexapodtest.Param("legs.step_height", params.Float, 0, 80, &f.stepHeight),
+		hexapodtest.Param("hexapod.speed", params.Int, -30, 8, &f.speed),
+		hexapodtest.Param("legs.other", params.Float, 0, 10, &f.other),
+	} {
+		assert.NoError(t, f.r.Register(p))
+	}
+
+	f.p = New(testProfiles, initial, f.r)
+	assert.NoError(t, f.p.Boot())
+	return f
+}
+
+// tick applies pending param writes and ticks the component, like the main
+// loop does, and then applies whatever the component wrote.
+func (f *fixture) tick(t *testing.T, d time.Duration) {
+	f.r.Apply()
+	assert.NoError(t, f.p.Tick(f.t0.Add(d), f.state))
+	f.r.Apply()
+}
+
+func TestLayering(t *testing.T) {
+	f := setup(t, "")
+
+	// No profile yet, so just the defaults.
+	f.tick(t, 0)
+	assert.Equal(t, "", f.state.Profile)
+	assert.Equal(t, 40.0, f.clearance)
+
+	// Standing still, so the profile is applied right away.
+	f.state.NextProfile = true
+	f.tick(t, 100*time.Millisecond)
+	assert.False(t, f.state.NextProfile)
+	assert.Equal(t, "indoor", f.state.Profile)
+	assert.Equal(t, 30.0, f.clearance)
+	assert.Equal(t, 25.0, f.stepHeight)
+	assert.Equal(t, -4.0, f.speed)
+
+	// Adjust the clearance at runtime, like the controller does.
+	f.clearance = 50
+	f.tick(t, 200*time.Millisecond)
+
+	// Params which the next profile doesn't override go back to the defaults,
+	// except the clearance, which was adjusted.
+	f.state.NextProfile = true
+	f.tick(t, 300*time.Millisecond)
+	assert.Equal(t, "outdoor", f.state.Profile)
+	assert.Equal(t, 50.0, f.clearance)
+	assert.Equal(t, 40.0, f.stepHeight)
+	assert.Equal(t, 0.0, f.speed)
+
+	// Wraps around, and never touches params which no profile mentions.
+	f.other = 5
+	f.state.NextProfile = true
+	f.tick(t, 400*time.Millisecond)
+	assert.Equal(t, "indoor", f.state.Profile)
+	assert.Equal(t, 50.0, f.clearance)
+	assert.Equal(t, 25.0, f.stepHeight)
+	assert.Equal(t, 5.0, f.other)
+}
+
+func TestInitial(t *testing.T) {
+	f := setup(t, "outdoor")
+	f.tick(t, 0)
+	assert.Equal(t, "outdoor", f.state.Profile)
+	assert.Equal(t, 80.0, f.clearance)
+	assert.Equal(t, 40.0, f.stepHeight)
+
+	f.state.NextProfile = true
+	f.tick(t, 100*time.Millisecond)
+	assert.Equal(t, "indoor", f.state.Profile)
+	assert.Equal(t, 30.0, f.clearance)
+}
+
+func TestRamp(t *testing.T) {
+	f := setup(t, "")
+	f.tick(t, 0)
+
+	// Walking forwards.
+	f.state.Target = math3d.Pose{Position: math3d.Vector3{Z: 100}}
+	f.state.NextProfile = true
+	f.tick(t, time.Second)
+	assert.Equal(t, "indoor", f.state.Profile)
+	assert.Equal(t, 40.0, f.clearance)
+
+	f.tick(t, 1500*time.Millisecond)
+	assert.Equal(t, 37.5, f.clearance)
+	assert.Equal(t, 36.25, f.stepHeight)
+	assert.Equal(t, -1.0, f.speed)
+
+	f.tick(t, 2*time.Second)
+	assert.Equal(t, 35.0, f.clearance)
+	assert.Equal(t, -2.0, f.speed)
+
+	// Adjusting a param while it's ramping stops it there.
+	f.stepHeight = 60
+	f.tick(t, 2500*time.Millisecond)
+	assert.Equal(t, 60.0, f.stepHeight)
+	assert.Equal(t, 32.5, f.clearance)
+
+	f.tick(t, 3*time.Second)
+	f.tick(t, 10*time.Second)
+	assert.Equal(t, 30.0, f.clearance)
+	assert.Equal(t, 60.0, f.stepHeight)
+	assert.Equal(t, -4.0, f.speed)
+
+	// Turning on the spot counts as walking, too.
+	assert.True(t, walking(&hexapod.State{Commands: hexapod.Commands{Target: math3d.Pose{Heading: 10}}}))
+	assert.False(t, walking(&hexapod.State{Commands: hexapod.Commands{Target: math3d.Pose{Position: math3d.Vector3{Y: 40}}}}))
+}
+
+func TestBootInvalid(t *testing.T) {
+	r := params.New()
+	assert.NoError(t, r.Register(params.Param{Name: "hexapod.speed", Type: params.Int, Min: -30, Max: 8, Get: func() float64 { return 0 }, Set: func(float64) {}}))
+
+	p := New([]config.Profile{{Name: "fast", Params: map[string]float64{"hexapod.speed": 20}}}, "", r)
+	assert.EqualError(t, p.Boot(), "hexapod.speed must be between -30 and 8, got 20 (in profile fast)")
+
+	p = New([]config.Profile{{Name: "typo", Params: map[string]float64{"hexapod.sped": 1}}}, "", r)
+	assert.EqualError(t, p.Boot(), "no such param: hexapod.sped (in profile typo)")
+}
@@ -0,0 +1,65 @@
+package rangefinder
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// IIO is a Sensor for a distance sensor with an Industrial I/O driver (e.g. the
+// VL53L0X), via sysfs, e.g. /sys/bus/iio/devices/iio:device0. Reading the raw
+// value triggers a measurement, so can take tens of ms.
+type IIO struct {
+	path string
+
+	// The mm per raw unit.
+	scale float64
+}
+
+// NewIIO returns the IIO device in the given sysfs directory. Its scale is read
+// once, since it doesn't change. IIO distances are in meters once scaled, but
+// a device without a scale is assumed to report mm.
+func NewIIO(dir string) (*IIO, error) {
+	path := filepath.Join(dir, "in_distance_raw")
+	_, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("not a distance sensor: %s", err)
+	}
+
+	s := &IIO{path: path, scale: 1}
+
+	v, err := readFloat(filepath.Join(dir, "in_distance_scale"))
+	if err == nil {
+		s.scale = v * 1000
+	} else if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("error reading scale: %s", err)
+	}
+
+	return s, nil
+}
+
+// Read returns the distance (in mm) which the sensor measures.
+func (s *IIO) Read() (float64, error) {
+	v, err := readFloat(s.path)
+	if err != nil {
+		return 0, err
+	}
+
+	return v * s.scale, nil
+}
+
+// Close does nothing, since the driver owns the device.
+func (s *IIO) Close() error {
+	return nil
+}
+
+func readFloat(path string) (float64, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.ParseFloat(strings.TrimSpace(string(b)), 64)
+}
@@ -0,0 +1,39 @@
+package rangefinder
+
+import (
+	"sync"
+)
+
+// Mock is a Sensor whose distance (or error) is set by the caller.
+type Mock struct {
+	mu   sync.Mutex
+	dist float64
+	err  error
+}
+
+func (m *Mock) Read() (float64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.dist, m.err
+}
+
+func (m *Mock) Close() error {
+	return nil
+}
+
+// Set sets the distance (in mm) which the sensor reads.
+func (m *Mock) Set(dist float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.dist = dist
+}
+
+// Fail makes every read return the given error, or stops it if nil.
+func (m *Mock) Fail(err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.err = err
+}
@@ -0,0 +1,120 @@
+// Package rangefinder measures the distance to whatever is ahead of the hex, at
+// about the height of the body, so the controller can duck under it.
+package rangefinder
+
+import (
+	"sync"
+	"time"
+
+	"github.com/adammck/hexapod"
+	"github.com/adammck/hexapod/config"
+)
+
+var log = hexapod.NewLog("rangefinder")
+
+// Sensor is a distance sensor, pointed forwards (or forwards and a little up)
+// from the front of the chassis.
+type Sensor interface {
+
+	// Read returns the distance (in mm) to the nearest thing in view. It can
+	// take a while, since it's called in the background, not the main loop.
+	Read() (float64, error)
+
+	Close() error
+}
+
+// Rangefinder is a component which reads the sensor in the background, every
+// interval, and copies the most recent reading into State.Range every tick. A
+// reading which is out of range, or older than the stale limit, counts as
+// nothing in range. Failed reads are logged and skipped.
+type Rangefinder struct {
+	sensor Sensor
+	cfg    config.Rangefinder
+
+	// Closed to stop the background reads, and by them once they've stopped.
+	// These are nil until booted.
+	stop chan struct{}
+	done chan struct{}
+
+	// The most recent reading, and when it was made.
+	mu   sync.Mutex
+	dist float64
+	at   time.Time
+}
+
+// New creates a rangefinder component which reads the given sensor.
+func New(sensor Sensor, cfg config.Rangefinder) *Rangefinder {
+	return &Rangefinder{
+		sensor: sensor,
+		cfg:    cfg,
+	}
+}
+
+// Writes returns hexapod.Sensor, since the rangefinder measures the world.
+func (r *Rangefinder) Writes() hexapod.Role {
+	return hexapod.Sensor
+}
+
+func (r *Rangefinder) Boot() error {
+	r.stop = make(chan struct{})
+	r.done = make(chan struct{})
+	go r.run()
+	return nil
+}
+
+// Shutdown stops the background reads, and closes the sensor.
+func (r *Rangefinder) Shutdown() error {
+	if r.stop != nil {
+		close(r.stop)
+		<-r.done
+		r.stop = nil
+	}
+
+	return r.sensor.Close()
+}
+
+func (r *Rangefinder) run() {
+	defer close(r.done)
+
+	t := time.NewTicker(r.cfg.Interval.Duration)
+	defer t.Stop()
+
+	for {
+		r.sample(time.Now())
+
+		select {
+		case <-r.stop:
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// sample reads the sensor once, and keeps the reading if it worked.
+func (r *Rangefinder) sample(now time.Time) {
+	d, err := r.sensor.Read()
+	if err != nil {
+		log.RateLimited("read", 10*time.Second).Errorf("error reading rangefinder: %s", err)
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.dist = d
+	r.at = now
+}
+
+func (r *Rangefinder) Tick(now time.Time, state *hexapod.State) error {
+	r.mu.Lock()
+	d, at := r.dist, r.at
+	r.mu.Unlock()
+
+	if at.IsZero() || now.Sub(at) > r.cfg.StaleAfter.Duration || d <= 0 || d > r.cfg.MaxRange {
+		state.Range = 0
+		return nil
+	}
+
+	state.Range = d
+	return nil
+}
@@ -0,0 +1,93 @@
+package rangefinder
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/adammck/hexapod"
+	"github.com/adammck/hexapod/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTick(t *testing.T) {
+	m := &Mock{}
+	r := New(m, config.Default().Rangefinder)
+	state := &hexapod.State{}
+	now := time.Unix(0, 0)
+
+	// Nothing is in range until the first reading.
+	assert.NoError(t, r.Tick(now, state))
+	assert.Equal(t, 0.0, state.Range)
+
+	m.Set(250)
+	r.sample(now)
+	assert.NoError(t, r.Tick(now, state))
+	assert.Equal(t, 250.0, state.Range)
+
+	// Readings beyond the max range don't count.
+	m.Set(1500)
+	r.sample(now)
+	assert.NoError(t, r.Tick(now, state))
+	assert.Equal(t, 0.0, state.Range)
+
+	// Failed reads keep the last reading, until it's stale.
+	m.Set(300)
+	r.sample(now)
+	m.Fail(errors.New("bus error"))
+	now = now.Add(400 * time.Millisecond)
+	r.sample(now)
+	assert.NoError(t, r.Tick(now, state))
+	assert.Equal(t, 300.0, state.Range)
+
+	now = now.Add(200 * time.Millisecond)
+	r.sample(now)
+	assert.NoError(t, r.Tick(now, state))
+	assert.Equal(t, 0.0, state.Range)
+}
+
+func TestBackground(t *testing.T) {
+	m := &Mock{}
+	m.Set(200)
+
+	r := New(m, config.Default().Rangefinder)
+	assert.NoError(t, r.Boot())
+
+	state := &hexapod.State{}
+	assert.Eventually(t, func() bool {
+		assert.NoError(t, r.Tick(time.Now(), state))
+		return state.Range == 200
+	}, time.Second, 10*time.Millisecond)
+
+	assert.NoError(t, r.Shutdown())
+}
+
+func TestIIO(t *testing.T) {
+	dir := t.TempDir()
+	_, err := NewIIO(dir)
+	assert.Error(t, err)
+
+	raw := filepath.Join(dir, "in_distance_raw")
+	assert.NoError(t, os.WriteFile(raw, []byte("412\n"), 0644))
+
+	// Without a scale, it's in mm.
+	s, err := NewIIO(dir)
+	assert.NoError(t, err)
+	d, err := s.Read()
+	assert.NoError(t, err)
+	assert.Equal(t, 412.0, d)
+
+	// With one, it's in meters once scaled.
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "in_distance_scale"), []byte("0.002000\n"), 0644))
+	s, err = NewIIO(dir)
+	assert.NoError(t, err)
+	d, err = s.Read()
+	assert.NoError(t, err)
+	assert.InDelta(t, 824.0, d, 0.0001)
+
+	assert.NoError(t, os.WriteFile(raw, []byte("nope"), 0644))
+	_, err = s.Read()
+	assert.Error(t, err)
+}
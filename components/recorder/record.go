@@ -0,0 +1,244 @@
+package recorder
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/adammck/hexapod"
+	"github.com/adammck/hexapod/math3d"
+)
+
+// magic is written at the start of every dump, so that the decoder can refuse
+// to decode garbage (or a dump from an incompatible version).
+var magic = [4]byte{'H', 'X', 'R', '2'}
+
+// magicV1 is the first version, from before the gait params were recorded. Its
+// records are the same, but without the Stride on the end, so can still be
+// decoded.
+var magicV1 = [4]byte{'H', 'X', 'R', '1'}
+
+// Pose is a compact copy of a math3d.Pose. Single precision is plenty for the
+// purposes of figuring out what went wrong.
+type Pose struct {
+	X, Y, Z              float32
+	Heading, Pitch, Bank float32
+}
+
+// Record is a single tick's worth of interesting state. It's a fixed size, so
+// that the ring can be preallocated and the dump format is trivial.
+type Record struct {
+	Time      int64 // unix nanoseconds
+	Target    Pose
+	Pose      Pose
+	Clearance float32
+	Voltage   float32
+
+	// Raw controller input.
+	LeftX, LeftY   int8
+	RightX, RightY int8
+	L2, R2         uint8
+	Buttons        uint16
+
+	// Bitmask of saturated legs; bit n is legs.Legs[n].
+	Saturated uint8
+
+	// Non-zero if shutdown had been requested.
+	Shutdown uint8
+
+	// The gait params which the legs were stepping with, which are all zero in
+	// dumps from before they were recorded.
+	Stride Stride
+}
+
+// Stride is a compact copy of a hexapod.GaitParams.
+type Stride struct {
+	TicksPerStep uint8
+	DutyFactor   float32
+	StepHeight   float32
+	StepRadius   float32
+}
+
+func makePose(p math3d.Pose) Pose {
+	return Pose{
+		X:       float32(p.Position.X),
+		Y:       float32(p.Position.Y),
+		Z:       float32(p.Position.Z),
+		Heading: float32(p.Heading),
+		Pitch:   float32(p.Pitch),
+		Bank:    float32(p.Bank),
+	}
+}
+
+// Make returns a record of the given state, e.g. to compare a simulation with a
+// dump, record for record.
+func Make(now time.Time, state *hexapod.State) Record {
+	var r Record
+	r.fill(now, state)
+	return r
+}
+
+// fill overwrites the record with the given state. It's done in place (rather
+// than returning a new Record) to keep the per-tick cost down.
+func (r *Record) fill(now time.Time, state *hexapod.State) {
+	r.Time = now.UnixNano()
+	r.Target = makePose(state.Target)
+	r.Pose = makePose(state.Pose)
+	r.Clearance = float32(state.Target.Position.Y)
+	r.Voltage = float32(state.Voltage)
+
+	r.LeftX = int8(clampInt(state.Input.LeftX, -128, 127))
+	r.LeftY = int8(clampInt(state.Input.LeftY, -128, 127))
+	r.RightX = int8(clampInt(state.Input.RightX, -128, 127))
+	r.RightY = int8(clampInt(state.Input.RightY, -128, 127))
+	r.L2 = uint8(clampInt(state.Input.L2, 0, 255))
+	r.R2 = uint8(clampInt(state.Input.R2, 0, 255))
+	r.Buttons = state.Input.Buttons
+
+	r.Saturated = 0
+	for i, s := range state.Saturated {
+		if s {
+			r.Saturated |= 1 << uint(i)
+		}
+	}
+
+	r.Shutdown = 0
+	if state.Shutdown {
+		r.Shutdown = 1
+	}
+
+	g := state.GaitParams
+	r.Stride = Stride{
+		TicksPerStep: uint8(clampInt(g.TicksPerStep, 0, 255)),
+		DutyFactor:   float32(g.DutyFactor),
+		StepHeight:   float32(g.StepHeight),
+		StepRadius:   float32(g.StepRadius),
+	}
+}
+
+// Input returns the raw controller input which the record was made with, e.g.
+// to replay it.
+func (r *Record) Input() hexapod.Input {
+	return hexapod.Input{
+		LeftX:   int(r.LeftX),
+		LeftY:   int(r.LeftY),
+		RightX:  int(r.RightX),
+		RightY:  int(r.RightY),
+		L2:      int(r.L2),
+		R2:      int(r.R2),
+		Buttons: r.Buttons,
+	}
+}
+
+func clampInt(v, min, max int) int {
+	if v < min {
+		return min
+	}
+	if v > max {
+		return max
+	}
+	return v
+}
+
+// Encode writes the given records (oldest first) to w.
+func Encode(w io.Writer, records []Record) error {
+	_, err := w.Write(magic[:])
+	if err != nil {
+		return err
+	}
+
+	return binary.Write(w, binary.LittleEndian, records)
+}
+
+// Decode reads all of the records from a dump written by Encode, or by the
+// previous version.
+func Decode(r io.Reader) ([]Record, error) {
+	var m [4]byte
+	_, err := io.ReadFull(r, m[:])
+	if err != nil {
+		return nil, fmt.Errorf("%s (while reading header)", err)
+	}
+
+	// Older records are shorter, so are read into the start of the buffer,
+	// leaving the rest zero.
+	buf := make([]byte, binary.Size(Record{}))
+	size := len(buf)
+	switch m {
+	case magic:
+	case magicV1:
+		size -= binary.Size(Stride{})
+	default:
+		return nil, fmt.Errorf("not a flight recorder dump: %q", m[:])
+	}
+
+	out := []Record{}
+	for {
+		_, err := io.ReadFull(r, buf[:size])
+		if err == io.EOF {
+			return out, nil
+		}
+		if err != nil {
+			return out, fmt.Errorf("%s (while reading record %d)", err, len(out))
+		}
+
+		var rec Record
+		err = binary.Read(bytes.NewReader(buf), binary.LittleEndian, &rec)
+		if err != nil {
+			return out, fmt.Errorf("%s (while reading record %d)", err, len(out))
+		}
+
+		out = append(out, rec)
+	}
+}
+
+var csvHeader = []string{
+	"time",
+	"target_x", "target_y", "target_z", "target_heading", "target_pitch", "target_bank",
+	"pose_x", "pose_y", "pose_z", "pose_heading", "pose_pitch", "pose_bank",
+	"clearance", "voltage",
+	"left_x", "left_y", "right_x", "right_y", "l2", "r2", "buttons",
+	"saturated", "shutdown",
+	"ticks_per_step", "duty_factor", "step_height", "step_radius",
+}
+
+// WriteCSV writes the given records as CSV, with a header row.
+func WriteCSV(w io.Writer, records []Record) error {
+	cw := csv.NewWriter(w)
+
+	err := cw.Write(csvHeader)
+	if err != nil {
+		return err
+	}
+
+	f := func(v float32) string {
+		return strconv.FormatFloat(float64(v), 'f', 2, 32)
+	}
+
+	i := func(v int) string {
+		return strconv.Itoa(v)
+	}
+
+	for _, r := range records {
+		err := cw.Write([]string{
+			time.Unix(0, r.Time).UTC().Format(time.RFC3339Nano),
+			f(r.Target.X), f(r.Target.Y), f(r.Target.Z), f(r.Target.Heading), f(r.Target.Pitch), f(r.Target.Bank),
+			f(r.Pose.X), f(r.Pose.Y), f(r.Pose.Z), f(r.Pose.Heading), f(r.Pose.Pitch), f(r.Pose.Bank),
+			f(r.Clearance), f(r.Voltage),
+			i(int(r.LeftX)), i(int(r.LeftY)), i(int(r.RightX)), i(int(r.RightY)), i(int(r.L2)), i(int(r.R2)),
+			fmt.Sprintf("%#04x", r.Buttons),
+			fmt.Sprintf("%06b", r.Saturated),
+			i(int(r.Shutdown)),
+			i(int(r.Stride.TicksPerStep)), f(r.Stride.DutyFactor), f(r.Stride.StepHeight), f(r.Stride.StepRadius),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,216 @@
+package recorder
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/adammck/hexapod"
+	"github.com/adammck/hexapod/servos"
+)
+
+var log = logrus.WithFields(logrus.Fields{
+	"pkg": "recorder",
+})
+
+// Recorder is a flight recorder: it keeps the last few seconds of state in a
+// ring buffer, and dumps it to disk when something goes wrong. It's cheap
+// enough to run every tick, so should always be enabled.
+type Recorder struct {
+	dir string
+
+	// Preallocated ring of records. next is the index which will be written
+	// next, and n is the number of valid records (up to len(ring)).
+	ring []Record
+	next int
+	n    int
+
+	// The capture of the servo positions, which is dumped alongside the
+	// records (with the extension .servos rather than .rec), or nil to not
+	// bother. Only the samples from the time of the records are dumped.
+	Servos *servos.Capture
+
+	// The journal of what each source commanded, which is dumped alongside the
+	// records too (with the extension .journal, as JSON lines), or nil to not
+	// bother. Likewise, only the entries from the time of the records.
+	Journal *hexapod.Journal
+}
+
+// New creates a recorder which keeps (approximately) the given duration of
+// records, assuming that Tick is called fps times per second. Dumps are
+// written to dir.
+func New(dir string, d time.Duration, fps int) *Recorder {
+	size := int(d.Seconds() * float64(fps))
+	if size < 1 {
+		size = 1
+	}
+
+	return &Recorder{
+		dir:  dir,
+		ring: make([]Record, size),
+	}
+}
+
+func (r *Recorder) Boot() error {
+	return nil
+}
+
+// Tick records the state, and dumps the buffer if a dump has been requested.
+func (r *Recorder) Tick(now time.Time, state *hexapod.State) error {
+	r.ring[r.next].fill(now, state)
+	r.next = (r.next + 1) % len(r.ring)
+	if r.n < len(r.ring) {
+		r.n += 1
+	}
+
+	if state.Dump {
+		state.Dump = false
+		_, err := r.Dump()
+		if err != nil {
+
+			// Don't return the error. We don't want to crash the hexapod just
+			// because the disk is full.
+			log.Errorf("%s (while dumping flight recorder)", err)
+		}
+	}
+
+	return nil
+}
+
+// Records returns a copy of the records in the ring, oldest first.
+func (r *Recorder) Records() []Record {
+	out := make([]Record, 0, r.n)
+	start := (r.next - r.n + len(r.ring)) % len(r.ring)
+
+	for i := 0; i < r.n; i++ {
+		out = append(out, r.ring[(start+i)%len(r.ring)])
+	}
+
+	return out
+}
+
+// Dump writes the contents of the ring to a timestamped file in the dump dir,
+// and returns its path. This is called from the main loop (via Tick, or
+// Shutdown), so doesn't need any locking.
+func (r *Recorder) Dump() (string, error) {
+	name := fmt.Sprintf("flight-%s.rec", time.Now().Format("20060102-150405.000"))
+	path := filepath.Join(r.dir, name)
+
+	f, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	err = Encode(w, r.Records())
+	if err != nil {
+		return "", err
+	}
+
+	err = w.Flush()
+	if err != nil {
+		return "", err
+	}
+
+	log.Warnf("dumped %d records to %s", r.n, path)
+
+	if r.Servos != nil {
+		err = r.dumpServos(strings.TrimSuffix(path, ".rec") + ".servos")
+		if err != nil {
+			return "", err
+		}
+	}
+
+	if r.Journal != nil {
+		err = r.dumpJournal(strings.TrimSuffix(path, ".rec") + ".journal")
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return path, nil
+}
+
+// dumpServos writes the servo samples from the time of the records (or all of
+// them, if there aren't any records yet) to the given path.
+func (r *Recorder) dumpServos(path string) error {
+	samples := r.Servos.Samples()
+	if records := r.Records(); len(records) > 0 {
+		t0 := records[0].Time
+		i := 0
+		for i < len(samples) && samples[i].Time < t0 {
+			i++
+		}
+		samples = samples[i:]
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	err = servos.EncodeCapture(w, samples)
+	if err != nil {
+		return err
+	}
+
+	err = w.Flush()
+	if err != nil {
+		return err
+	}
+
+	log.Warnf("dumped %d servo samples to %s", len(samples), path)
+	return nil
+}
+
+// dumpJournal writes the journal entries from the time of the records (or all
+// of them, if there aren't any records yet) to the given path, one per line.
+func (r *Recorder) dumpJournal(path string) error {
+	entries := r.Journal.Entries("")
+	if records := r.Records(); len(records) > 0 {
+		t0 := time.Unix(0, records[0].Time)
+		i := 0
+		for i < len(entries) && entries[i].Time.Before(t0) {
+			i++
+		}
+		entries = entries[i:]
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for i := range entries {
+		err = enc.Encode(&entries[i])
+		if err != nil {
+			return err
+		}
+	}
+
+	err = w.Flush()
+	if err != nil {
+		return err
+	}
+
+	log.Warnf("dumped %d journal entries to %s", len(entries), path)
+	return nil
+}
+
+// Shutdown dumps the ring, once the loop has stopped, so there's a record of
+// how the session ended.
+func (r *Recorder) Shutdown() error {
+	_, err := r.Dump()
+	return err
+}
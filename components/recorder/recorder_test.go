@@ -0,0 +1,271 @@
+package recorder
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/adammck/dynamixel/network"
+	"github.com/adammck/hexapod"
+	fake_serial "github.com/adammck/hexapod/fake/serial"
+	"github.com/adammck/hexapod/math3d"
+	"github.com/adammck/hexapod/servos"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRingTruncation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "recorder")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// One second at 10fps = ten records.
+	r := New(dir, time.Second, 10)
+	state := &hexapod.State{}
+	start := time.Date(2017, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	// Fill the ring two and a half times over, incrementing the clearance each
+	// tick so we can tell the records apart.
+	for i := 0; i < 25; i++ {
+		state.Target.Position.Y = float64(i)
+		state.Saturated[i%6] = i%2 == 0
+		state.Dump = (i == 24)
+		assert.NoError(t, r.Tick(start.Add(time.Duration(i)*time.Millisecond), state))
+	}
+
+	assert.False(t, state.Dump, "expected Dump to be reset")
+
+	files, err := filepath.Glob(filepath.Join(dir, "flight-*.rec"))
+	assert.NoError(t, err)
+	if !assert.Len(t, files, 1) {
+		return
+	}
+
+	f, err := os.Open(files[0])
+	assert.NoError(t, err)
+	defer f.Close()
+
+	records, err := Decode(f)
+	assert.NoError(t, err)
+	if !assert.Len(t, records, 10) {
+		return
+	}
+
+	// Only the last ten ticks should have survived, oldest first.
+	for i, rec := range records {
+		assert.Equal(t, float32(15+i), rec.Clearance)
+		assert.Equal(t, start.Add(time.Duration(15+i)*time.Millisecond).UnixNano(), rec.Time)
+	}
+}
+
+// nopSerial is a serial port which nothing ever replies to.
+type nopSerial struct{}
+
+func (nopSerial) Read(b []byte) (int, error)  { return 0, nil }
+func (nopSerial) Write(b []byte) (int, error) { return len(b), nil }
+func (nopSerial) Close() error                { return nil }
+
+func TestDumpServos(t *testing.T) {
+	dir, err := ioutil.TempDir("", "recorder")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	now := time.Unix(1000, 0)
+	p := servos.NewPort(nopSerial{})
+	p.Capture = servos.NewCapture(100)
+	p.Capture.Now = func() time.Time { return now }
+
+	// A goal from before the first record, which isn't dumped, and one after.
+	w := servos.NewGoalPositions()
+	w.Set(11, 100)
+	p.Write(w.Bytes())
+
+	r := New(dir, time.Second, 10)
+	r.Servos = p.Capture
+	now = now.Add(time.Second)
+	assert.NoError(t, r.Tick(now, &hexapod.State{}))
+
+	w.Reset()
+	w.Set(11, 200)
+	p.Write(w.Bytes())
+
+	path, err := r.Dump()
+	assert.NoError(t, err)
+
+	f, err := os.Open(strings.TrimSuffix(path, ".rec") + ".servos")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer f.Close()
+
+	samples, err := servos.DecodeCapture(f)
+	assert.NoError(t, err)
+	assert.Equal(t, []servos.Sample{
+		{Time: now.UnixNano(), ID: 11, Kind: servos.SampleGoal, Position: 200},
+	}, samples)
+}
+
+// pad is a source which moves the target forwards on every tick.
+type pad struct{}
+
+func (pad) Boot() error    { return nil }
+func (pad) Source() string { return "pad" }
+
+func (pad) Tick(now time.Time, state *hexapod.State) error {
+	state.Target.Position.Z += 10
+	return nil
+}
+
+func TestDumpJournal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "recorder")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	h := hexapod.NewHexapod(network.New(&fake_serial.FakeSerial{}), 10)
+	h.Journal = hexapod.NewJournal(time.Second, 10)
+	h.Add(pad{})
+
+	r := New(dir, time.Second, 10)
+	r.Journal = h.Journal
+
+	// A tick from before the first record, which isn't dumped, and one after.
+	now := time.Unix(1000, 0)
+	assert.NoError(t, h.Tick(now))
+	now = now.Add(100 * time.Millisecond)
+	assert.NoError(t, h.Tick(now))
+	assert.NoError(t, r.Tick(now, h.State))
+
+	path, err := r.Dump()
+	assert.NoError(t, err)
+
+	b, err := ioutil.ReadFile(strings.TrimSuffix(path, ".rec") + ".journal")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	var entries []hexapod.JournalEntry
+	for _, line := range strings.Split(strings.TrimSpace(string(b)), "\n") {
+		var e hexapod.JournalEntry
+		assert.NoError(t, json.Unmarshal([]byte(line), &e))
+		entries = append(entries, e)
+	}
+
+	if assert.Len(t, entries, 2) {
+		assert.Equal(t, hexapod.ParamsSource, entries[0].Source)
+		assert.Equal(t, "pad", entries[1].Source)
+		assert.Equal(t, []string{"Target.Position.Z=20.000"}, entries[1].Commands)
+		assert.True(t, entries[1].Won)
+		assert.True(t, entries[1].Time.Equal(now))
+	}
+}
+
+func TestPartialRing(t *testing.T) {
+	r := New("", time.Second, 10)
+	state := &hexapod.State{}
+
+	for i := 0; i < 3; i++ {
+		state.Target.Position.Y = float64(i)
+		r.Tick(time.Unix(int64(i), 0), state)
+	}
+
+	records := r.Records()
+	assert.Len(t, records, 3)
+	for i, rec := range records {
+		assert.Equal(t, float32(i), rec.Clearance)
+	}
+}
+
+func TestRoundTripCSV(t *testing.T) {
+	state := &hexapod.State{
+		Commands: hexapod.Commands{
+			Target: math3d.Pose{Position: math3d.Vector3{Y: 40}},
+			Input: hexapod.Input{
+				LeftX:   127,
+				LeftY:   -200, // out of range; should be clamped
+				R2:      300,  // same
+				Buttons: hexapod.ButtonSelect | hexapod.ButtonSquare,
+			},
+		},
+		Measurements: hexapod.Measurements{Voltage: 11.5},
+		Estimates: hexapod.Estimates{
+			Pose:       math3d.Pose{Position: math3d.Vector3{X: 1, Y: 2, Z: 3}, Heading: 45},
+			GaitParams: hexapod.GaitParams{TicksPerStep: 20, DutyFactor: 0.75, StepHeight: 40, StepRadius: 240},
+		},
+	}
+	state.Saturated[1] = true
+
+	var rec Record
+	rec.fill(time.Unix(0, 0), state)
+
+	buf := &bytes.Buffer{}
+	assert.NoError(t, Encode(buf, []Record{rec, rec}))
+
+	records, err := Decode(buf)
+	assert.NoError(t, err)
+	assert.Equal(t, []Record{rec, rec}, records)
+	assert.Equal(t, int8(-128), rec.LeftY)
+	assert.Equal(t, uint8(255), rec.R2)
+
+	out := &bytes.Buffer{}
+	assert.NoError(t, WriteCSV(out, records))
+
+	rows, err := csv.NewReader(out).ReadAll()
+	assert.NoError(t, err)
+	assert.Len(t, rows, 3)
+	assert.Equal(t, csvHeader, rows[0])
+	for _, row := range rows[1:] {
+		assert.Len(t, row, len(csvHeader))
+		assert.Equal(t, "45.00", row[4+6])
+		assert.Equal(t, "40.00", row[13])
+		assert.Equal(t, "000010", row[22])
+		assert.Equal(t, []string{"20", "0.75", "40.00", "240.00"}, row[24:])
+	}
+}
+
+func TestDecodeV1(t *testing.T) {
+	state := &hexapod.State{}
+	state.Target.Position.Y = 40
+	state.GaitParams.StepHeight = 40
+
+	var rec Record
+	rec.fill(time.Unix(0, 0), state)
+
+	// The first version didn't have the stride on the end of each record.
+	v1 := bytes.NewBuffer(append([]byte{}, magicV1[:]...))
+	for i := 0; i < 2; i++ {
+		b := &bytes.Buffer{}
+		assert.NoError(t, binary.Write(b, binary.LittleEndian, rec))
+		v1.Write(b.Bytes()[:b.Len()-binary.Size(Stride{})])
+	}
+
+	records, err := Decode(v1)
+	assert.NoError(t, err)
+	rec.Stride = Stride{}
+	assert.Equal(t, []Record{rec, rec}, records)
+}
+
+func TestDecodeGarbage(t *testing.T) {
+	_, err := Decode(bytes.NewBufferString("nope, not a dump"))
+	assert.Error(t, err)
+}
+
+func TestInput(t *testing.T) {
+	in := hexapod.Input{LeftX: -128, LeftY: 127, RightX: 5, RightY: -5, L2: 255, R2: 1, Buttons: hexapod.ButtonSelect | hexapod.ButtonSquare}
+	state := &hexapod.State{}
+	state.Input = in
+
+	var r Record
+	r.fill(time.Unix(0, 0), state)
+	assert.Equal(t, in, r.Input())
+
+	// Out of range sticks are clamped, like they're recorded.
+	state.Input.LeftX = -300
+	r.fill(time.Unix(0, 0), state)
+	assert.Equal(t, -128, r.Input().LeftX)
+}
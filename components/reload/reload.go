@@ -0,0 +1,171 @@
+package reload
+
+import (
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adammck/hexapod"
+	"github.com/adammck/hexapod/config"
+	"github.com/adammck/hexapod/params"
+)
+
+var log = hexapod.NewLog("reload")
+
+// Reloader is a component which re-reads the config file when asked to (e.g.
+// on SIGHUP), or when it changes, and applies whatever changed since it was
+// last read. Only keys which are also registered as params can be changed at
+// runtime; anything else (e.g. the leg geometry) needs a restart, and is just
+// logged.
+//
+// Changes are written via the params registry, so they're applied between
+// ticks like any other runtime change. Params which weren't changed in the
+// file are left alone, so adjustments made at runtime aren't clobbered.
+type Reloader struct {
+	sync.Mutex
+	path     string
+	registry *params.Registry
+
+	// How often to check whether the file has changed, or zero to only reload
+	// when asked. This polls the mtime rather than using inotify, since the
+	// file is tiny and rarely changes.
+	interval time.Duration
+
+	// The flattened config as of the last reload, minus any changes which were
+	// rejected, and the mtime of the file at that point.
+	active  map[string]interface{}
+	modTime time.Time
+}
+
+// Result is the outcome of a reload: the keys which were applied, and those
+// which were rejected because they can't be changed without a restart.
+type Result struct {
+	Applied  []string
+	Rejected []string
+}
+
+// New creates a reloader for the config file at the given path, which was most
+// recently loaded as cfg, writing to params in the given registry.
+func New(path string, cfg config.Config, r *params.Registry, interval time.Duration) *Reloader {
+	return &Reloader{
+		path:     path,
+		registry: r,
+		interval: interval,
+		active:   cfg.Flatten(),
+		modTime:  modTime(path),
+	}
+}
+
+// Boot starts watching the file for changes, if enabled.
+func (r *Reloader) Boot() error {
+	if r.interval > 0 {
+		log.Infof("watching %s for changes every %s", r.path, r.interval)
+		go r.watch()
+	}
+
+	return nil
+}
+
+func (r *Reloader) Tick(now time.Time, state *hexapod.State) error {
+	return nil
+}
+
+func (r *Reloader) watch() {
+	for range time.Tick(r.interval) {
+		if r.modified() {
+			r.Reload()
+		}
+	}
+}
+
+// modified returns true if the mtime of the file has changed since the last
+// reload. The file disappearing doesn't count.
+func (r *Reloader) modified() bool {
+	r.Lock()
+	defer r.Unlock()
+
+	t := modTime(r.path)
+	return !t.IsZero() && !t.Equal(r.modTime)
+}
+
+// Reload re-reads the config file, and queues writes to the params whose keys
+// changed. If the file is invalid, or any of the new values can't be written,
+// nothing is changed. This is safe to call from any goroutine.
+func (r *Reloader) Reload() (Result, error) {
+	r.Lock()
+	defer r.Unlock()
+
+	log.Infof("reloading config from %s", r.path)
+	r.modTime = modTime(r.path)
+
+	// Load falls back to the defaults if the file doesn't exist, which is fine
+	// at boot, but would revert everything here.
+	_, err := os.Stat(r.path)
+	if err != nil {
+		log.Warnf("%s (while reloading config)", err)
+		return Result{}, err
+	}
+
+	cfg, err := config.Load(r.path)
+	if err != nil {
+		log.Warnf("%s (while reloading config)", err)
+		return Result{}, err
+	}
+
+	registered := map[string]bool{}
+	for _, v := range r.registry.Values() {
+		registered[v.Name] = true
+	}
+
+	var res Result
+	flat := cfg.Flatten()
+	writes := map[string]float64{}
+
+	for _, k := range config.Diff(r.active, flat) {
+		f, ok := flat[k].(float64)
+		if ok && registered[k] {
+			writes[k] = f
+			res.Applied = append(res.Applied, k)
+		} else {
+			res.Rejected = append(res.Rejected, k)
+		}
+	}
+
+	err = r.registry.Set(writes)
+	if err != nil {
+		log.Warnf("%s (while reloading config)", err)
+		return Result{}, err
+	}
+
+	if len(res.Applied) > 0 {
+		log.Infof("applied config changes: %s", strings.Join(res.Applied, ", "))
+	}
+
+	if len(res.Rejected) > 0 {
+		log.Warnf("config changes need a restart to take effect: %s", strings.Join(res.Rejected, ", "))
+	}
+
+	if len(res.Applied) == 0 && len(res.Rejected) == 0 {
+		log.Info("config unchanged")
+	}
+
+	// Keep the old values of whatever was rejected, so they're reported again
+	// on the next reload, until the hexapod is restarted.
+	for _, k := range res.Applied {
+		r.active[k] = flat[k]
+	}
+
+	return res, nil
+}
+
+// modTime returns the mtime of the file at the given path, or the zero time if
+// it can't be read.
+func modTime(path string) time.Time {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+
+	return fi.ModTime()
+}
@@ -0,0 +1,143 @@
+package reload
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/adammck/hexapod/config"
+	"github.com/adammck/hexapod/hexapodtest"
+	"github.com/adammck/hexapod/params"
+	"github.com/stretchr/testify/assert"
+)
+
+type fixture struct {
+	path string
+	r    *params.Registry
+	rl   *Reloader
+
+	moveSpeed  float64
+	stepHeight float64
+}
+
+func setup(t *testing.T, toml string) *fixture {
+	f := &fixture{
+		path: filepath.Join(t.TempDir(), "hexapod.toml"),
+		r:    params.New(),
+	}
+	f.write(t, toml)
+
+	cfg, err := config.Load(f.path)
+	assert.NoError(t, err)
+	f.moveSpeed = cfg.Controller.MoveSpeed
+	f.stepHeight = cfg.Legs.StepHeight
+
+	for _, p := range []params.Param{
+		hexapodtest.Param("controller.move_speed", params.Float, 0, 200, &f.moveSpeed),
+		hexapodtest.Param("legs.step_height", params.Float, 0, 80, &f.stepHeight),
+	} {
+		assert.NoError(t, f.r.Register(p))
+	}
+
+	f.rl = New(f.path, cfg, f.r, 0)
+	assert.NoError(t, f.rl.Boot())
+	return f
+}
+
+func (f *fixture) write(t *testing.T, toml string) {
+	assert.NoError(t, os.WriteFile(f.path, []byte(toml), 0644))
+}
+
+func TestReload(t *testing.T) {
+	f := setup(t, "[controller]\nmove_speed = 120.0\n")
+
+	// Adjust one at runtime, which shouldn't be clobbered if the file doesn't
+	// change it.
+	f.stepHeight = 30
+	f.r.Apply()
+
+	f.write(t, `
+[controller]
+move_speed = 150.0
+rot_speed = 20.0
+
+[legs]
+step_radius = 250.0
+
+[gait]
+base_ticks_per_step = 30
+`)
+
+	res, err := f.rl.Reload()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"controller.move_speed"}, res.Applied)
+	assert.Equal(t, []string{"controller.rot_speed", "gait.base_ticks_per_step", "legs.step_radius"}, res.Rejected)
+
+	// Nothing happens until the main loop applies it.
+	assert.Equal(t, 120.0, f.moveSpeed)
+	f.r.Apply()
+	assert.Equal(t, 150.0, f.moveSpeed)
+	assert.Equal(t, 30.0, f.stepHeight)
+
+	// Now change the step height, too. The rejected changes are still pending a
+	// restart, so are reported again.
+	f.write(t, `
+[controller]
+move_speed = 150.0
+rot_speed = 20.0
+
+[legs]
+step_radius = 250.0
+step_height = 50.0
+
+[gait]
+base_ticks_per_step = 30
+`)
+
+	res, err = f.rl.Reload()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"legs.step_height"}, res.Applied)
+	assert.Equal(t, []string{"controller.rot_speed", "gait.base_ticks_per_step", "legs.step_radius"}, res.Rejected)
+	f.r.Apply()
+	assert.Equal(t, 150.0, f.moveSpeed)
+	assert.Equal(t, 50.0, f.stepHeight)
+}
+
+func TestReloadInvalid(t *testing.T) {
+	f := setup(t, "")
+
+	// Invalid config, so nothing is applied.
+	f.write(t, "[controller]\nmove_speed = 150.0\n[legs]\nstep_height = 500.0\n")
+	_, err := f.rl.Reload()
+	assert.EqualError(t, err, "legs.step_height: must be between 0 and 80, but is 500 (while loading "+f.path+")")
+
+	f.write(t, "[controller]\nmove_sped = 150.0\n")
+	_, err = f.rl.Reload()
+	assert.Error(t, err)
+
+	// Missing files aren't the defaults, like they are at boot.
+	assert.NoError(t, os.Remove(f.path))
+	_, err = f.rl.Reload()
+	assert.Error(t, err)
+
+	f.r.Apply()
+	assert.Equal(t, 100.0, f.moveSpeed)
+	assert.Equal(t, 40.0, f.stepHeight)
+}
+
+func TestModified(t *testing.T) {
+	f := setup(t, "")
+	assert.False(t, f.rl.modified())
+
+	later := time.Now().Add(time.Minute)
+	assert.NoError(t, os.Chtimes(f.path, later, later))
+	assert.True(t, f.rl.modified())
+
+	_, err := f.rl.Reload()
+	assert.NoError(t, err)
+	assert.False(t, f.rl.modified())
+
+	assert.NoError(t, os.Remove(f.path))
+	assert.False(t, f.rl.modified())
+}
@@ -0,0 +1,315 @@
+package replay
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+
+	"github.com/adammck/hexapod/components/recorder"
+	"github.com/adammck/hexapod/servos"
+)
+
+// Stats summarizes the error between two sessions, sampled at the time of each
+// record, e.g. the error in the angle of a joint.
+type Stats struct {
+
+	// The root mean square, and greatest, of the error.
+	RMS float64 `json:"rms"`
+	Max float64 `json:"max"`
+
+	// How quickly the error grows (or shrinks, if negative), per second, which
+	// is the slope of the line which fits it best. Errors which accumulate,
+	// like the distance walked, drift even if they're small at any moment.
+	Drift float64 `json:"drift"`
+
+	// The root mean square of the error within each second.
+	Windows []float64 `json:"windows"`
+}
+
+// JointStats is the error in the angle of a single servo.
+type JointStats struct {
+	ID int `json:"id"`
+
+	// Whether the present positions were compared, or only the goals, which is
+	// all there is for a joint unless it was read (see config.Legs.Feedback).
+	Present bool `json:"present"`
+
+	Stats
+}
+
+// Report is the divergence of one session from another.
+type Report struct {
+
+	// How long (in seconds) the sessions were compared for, from the first
+	// record of each, which is as long as the shorter one.
+	Duration float64 `json:"duration"`
+
+	// The distance (in mm) between the positions of the hex, and the
+	// difference (in degrees) between their headings, relative to where each
+	// started, so the sessions needn't have been recorded in the same place.
+	Position Stats `json:"position"`
+	Heading  Stats `json:"heading"`
+
+	// The error (in degrees) in the angle of each servo which is in both
+	// sessions, ordered by ID.
+	Joints []JointStats `json:"joints"`
+}
+
+// Compare returns the divergence of got from want, e.g. of a simulation (see
+// Simulate) from the session on the hardware whose input it replayed. They're
+// compared at the time (since the first record of want) of each record of want,
+// with got interpolated between its records, and the servo samples of both.
+func Compare(want, got Session) (Report, error) {
+	if len(want.Records) == 0 || len(got.Records) == 0 {
+		return Report{}, errors.New("nothing to compare")
+	}
+
+	w0, g0 := want.Records[0].Time, got.Records[0].Time
+	d := math.Min(duration(want.Records).Seconds(), duration(got.Records).Seconds())
+	r := Report{Duration: d}
+
+	// The times to compare at.
+	var times []float64
+	for _, rec := range want.Records {
+		t := float64(rec.Time-w0) / 1e9
+		if t > d {
+			break
+		}
+		times = append(times, t)
+	}
+
+	wx, wz, wh := poses(want.Records)
+	gx, gz, gh := poses(got.Records)
+	r.Position = compare(times, d, func(t float64) (float64, bool) {
+		x1, ok1 := wx.at(t)
+		z1, ok2 := wz.at(t)
+		x2, ok3 := gx.at(t)
+		z2, ok4 := gz.at(t)
+		return math.Hypot(x2-x1, z2-z1), ok1 && ok2 && ok3 && ok4
+	})
+	r.Heading = compare(times, d, func(t float64) (float64, bool) {
+		h1, ok1 := wh.at(t)
+		h2, ok2 := gh.at(t)
+		return math.Abs(wrap(h2 - h1)), ok1 && ok2
+	})
+
+	wj, gj := joints(want.Servos, w0), joints(got.Servos, g0)
+	for id, a := range wj {
+		b, ok := gj[id]
+		if !ok {
+			continue
+		}
+
+		r.Joints = append(r.Joints, JointStats{
+			ID:      id,
+			Present: a.present && b.present,
+			Stats: compare(times, d, func(t float64) (float64, bool) {
+				v1, ok1 := a.pick(b).at(t)
+				v2, ok2 := b.pick(a).at(t)
+				return math.Abs(v2 - v1), ok1 && ok2
+			}),
+		})
+	}
+
+	sort.Slice(r.Joints, func(i, j int) bool { return r.Joints[i].ID < r.Joints[j].ID })
+	return r, nil
+}
+
+// compare returns the stats of the given error function at the given times
+// (within d seconds), skipping those where it has no value.
+func compare(times []float64, d float64, err func(t float64) (float64, bool)) Stats {
+	n := int(math.Max(1, math.Ceil(d)))
+	sums := make([]float64, n)
+	counts := make([]int, n)
+
+	var s Stats
+	var ts, es []float64
+	for _, t := range times {
+		e, ok := err(t)
+		if !ok {
+			continue
+		}
+
+		ts = append(ts, t)
+		es = append(es, e)
+		s.Max = math.Max(s.Max, e)
+
+		w := int(t)
+		if w >= n {
+			w = n - 1
+		}
+		sums[w] += e * e
+		counts[w]++
+	}
+
+	if len(es) == 0 {
+		return s
+	}
+
+	var sum float64
+	for _, e := range es {
+		sum += e * e
+	}
+	s.RMS = math.Sqrt(sum / float64(len(es)))
+	s.Drift = slope(ts, es)
+
+	s.Windows = make([]float64, n)
+	for i := range sums {
+		if counts[i] > 0 {
+			s.Windows[i] = math.Sqrt(sums[i] / float64(counts[i]))
+		}
+	}
+
+	return s
+}
+
+// slope returns the slope of the least squares fit of y over x.
+func slope(x, y []float64) float64 {
+	n := float64(len(x))
+	var sx, sy, sxx, sxy float64
+	for i := range x {
+		sx += x[i]
+		sy += y[i]
+		sxx += x[i] * x[i]
+		sxy += x[i] * y[i]
+	}
+
+	d := n*sxx - sx*sx
+	if d == 0 {
+		return 0
+	}
+
+	return (n*sxy - sx*sy) / d
+}
+
+// series is a value over time (in seconds), which is interpolated between the
+// points. The times must be in order.
+type series struct {
+	t, v []float64
+}
+
+func (s *series) add(t, v float64) {
+	s.t = append(s.t, t)
+	s.v = append(s.v, v)
+}
+
+// at returns the value at the given time, interpolated between the points on
+// either side, or false if it's outside of the series.
+func (s series) at(t float64) (float64, bool) {
+	i := sort.SearchFloat64s(s.t, t)
+	switch {
+	case i == len(s.t):
+		return 0, false
+	case s.t[i] == t:
+		return s.v[i], true
+	case i == 0:
+		return 0, false
+	}
+
+	f := (t - s.t[i-1]) / (s.t[i] - s.t[i-1])
+	return s.v[i-1] + f*(s.v[i]-s.v[i-1]), true
+}
+
+// poses returns the position (X and Z) and heading of each record, relative to
+// the first. The heading is unwrapped, so it can be interpolated.
+func poses(records []recorder.Record) (x, z, h series) {
+	p0 := records[0].Pose
+	var prev float64
+	for i, rec := range records {
+		t := float64(rec.Time-records[0].Time) / 1e9
+		x.add(t, float64(rec.Pose.X-p0.X))
+		z.add(t, float64(rec.Pose.Z-p0.Z))
+
+		heading := float64(rec.Pose.Heading - p0.Heading)
+		if i > 0 {
+			heading = prev + wrap(heading-prev)
+		}
+		h.add(t, heading)
+		prev = heading
+	}
+
+	return x, z, h
+}
+
+// joint is the angles of a servo over time: the goals which were written to it,
+// and the present positions which were read back, if any.
+type joint struct {
+	goals   series
+	present bool
+	actual  series
+}
+
+// pick returns the present positions if both joints have them, since that's
+// what the servo actually did, or the goals otherwise, so like is compared
+// with like.
+func (j *joint) pick(other *joint) series {
+	if j.present && other.present {
+		return j.actual
+	}
+
+	return j.goals
+}
+
+// joints returns the angles of each servo in the given samples, by ID, timed
+// from t0 (unix nanoseconds).
+func joints(samples []servos.Sample, t0 int64) map[int]*joint {
+	out := map[int]*joint{}
+	for _, s := range samples {
+		t := float64(s.Time-t0) / 1e9
+		if t < 0 {
+			continue
+		}
+
+		j, ok := out[int(s.ID)]
+		if !ok {
+			j = &joint{}
+			out[int(s.ID)] = j
+		}
+
+		switch s.Kind {
+		case servos.SampleGoal:
+			j.goals.add(t, s.Degrees())
+		case servos.SamplePresent:
+			j.present = true
+			j.actual.add(t, s.Degrees())
+		}
+	}
+
+	return out
+}
+
+// wrap returns the given angle (in degrees) within -180 and 180.
+func wrap(a float64) float64 {
+	a = math.Mod(a+180, 360)
+	if a < 0 {
+		a += 360
+	}
+
+	return a - 180
+}
+
+// WriteText writes the report to w, in a form for people.
+func (r Report) WriteText(w io.Writer) error {
+	var err error
+	printf := func(format string, a ...interface{}) {
+		if err == nil {
+			_, err = fmt.Fprintf(w, format, a...)
+		}
+	}
+
+	printf("compared %.1fs\n\n", r.Duration)
+	printf("%-10s %8s %8s %10s\n", "", "rms", "max", "drift/s")
+	printf("%-10s %8.1f %8.1f %10.2f  (mm)\n", "position", r.Position.RMS, r.Position.Max, r.Position.Drift)
+	printf("%-10s %8.1f %8.1f %10.2f  (deg)\n", "heading", r.Heading.RMS, r.Heading.Max, r.Heading.Drift)
+	for _, j := range r.Joints {
+		kind := "goal"
+		if j.Present {
+			kind = "present"
+		}
+		printf("%-10s %8.2f %8.2f %10.3f  (deg, %s)\n", fmt.Sprintf("#%d", j.ID), j.RMS, j.Max, j.Drift, kind)
+	}
+
+	return err
+}
@@ -0,0 +1,124 @@
+package replay
+
+import (
+	"bytes"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/adammck/hexapod/components/recorder"
+	"github.com/adammck/hexapod/servos"
+	"github.com/stretchr/testify/assert"
+)
+
+// degrees is the angle of a single unit of servo position.
+var degrees = servos.Sample{Position: 1}.Degrees()
+
+// session returns a synthetic session, of a record (and a sample of each given
+// servo) every 100ms for the given number of ticks, starting at t0. The pose
+// and the servo positions are set by the given function, of the time (in
+// seconds) since t0.
+func session(t0 time.Time, ticks int, fn func(t float64, r *recorder.Record, s map[uint8][2]uint16)) Session {
+	var out Session
+	for i := 0; i < ticks; i++ {
+		t := float64(i) / 10
+		now := t0.Add(time.Duration(i) * 100 * time.Millisecond).UnixNano()
+		r := recorder.Record{Time: now}
+		s := map[uint8][2]uint16{}
+		fn(t, &r, s)
+		out.Records = append(out.Records, r)
+
+		for _, id := range []uint8{11, 12, 13} {
+			v, ok := s[id]
+			if !ok {
+				continue
+			}
+
+			out.Servos = append(out.Servos, servos.Sample{Time: now, ID: id, Kind: servos.SampleGoal, Position: v[0]})
+			if v[1] != 0 {
+				out.Servos = append(out.Servos, servos.Sample{Time: now, ID: id, Kind: servos.SamplePresent, Position: v[1]})
+			}
+		}
+	}
+
+	return out
+}
+
+func TestCompare(t *testing.T) {
+
+	// Standing still, facing 355 degrees, for two seconds.
+	want := session(time.Unix(1000, 0), 21, func(t float64, r *recorder.Record, s map[uint8][2]uint16) {
+		r.Pose = recorder.Pose{X: 100, Z: 200, Heading: 355}
+		s[11] = [2]uint16{512, 0}
+		s[12] = [2]uint16{512, 500}
+		s[13] = [2]uint16{512, 0}
+	})
+
+	// Somewhere else, at some other time, for longer. The hex creeps forwards
+	// at 10mm/s, and turns at 5 degrees/s (past 360), while servo #11 is a
+	// few degrees off all along, and #12 drifts by a unit every tick.
+	got := session(time.Unix(5000, 0), 26, func(t float64, r *recorder.Record, s map[uint8][2]uint16) {
+		r.Pose = recorder.Pose{X: -300, Z: 10 * float32(t), Heading: float32(math.Mod(355+5*t, 360))}
+		s[11] = [2]uint16{522, 0}
+		s[12] = [2]uint16{512, 500 + uint16(math.Round(t*10))}
+	})
+
+	r, err := Compare(want, got)
+	assert.NoError(t, err)
+	assert.Equal(t, 2.0, r.Duration)
+
+	// The error is 10t at t = 0, 0.1, ... 2.0.
+	assert.InDelta(t, 11.690, r.Position.RMS, 0.001)
+	assert.InDelta(t, 20.0, r.Position.Max, 0.001)
+	assert.InDelta(t, 10.0, r.Position.Drift, 0.001)
+	if assert.Len(t, r.Position.Windows, 2) {
+		assert.InDelta(t, 5.339, r.Position.Windows[0], 0.001)
+		assert.InDelta(t, 15.330, r.Position.Windows[1], 0.001)
+	}
+
+	// The heading is half as far off, despite wrapping.
+	assert.InDelta(t, 11.690/2, r.Heading.RMS, 0.001)
+	assert.InDelta(t, 10.0, r.Heading.Max, 0.001)
+	assert.InDelta(t, 5.0, r.Heading.Drift, 0.001)
+
+	// #13 isn't in both, so isn't compared.
+	if assert.Len(t, r.Joints, 2) {
+		j := r.Joints[0]
+		assert.Equal(t, 11, j.ID)
+		assert.False(t, j.Present)
+		assert.InDelta(t, 10*degrees, j.RMS, 0.001)
+		assert.InDelta(t, 10*degrees, j.Max, 0.001)
+		assert.InDelta(t, 0, j.Drift, 0.001)
+
+		// The goals are the same, but the present positions aren't.
+		j = r.Joints[1]
+		assert.Equal(t, 12, j.ID)
+		assert.True(t, j.Present)
+		assert.InDelta(t, 20*degrees, j.Max, 0.001)
+		assert.InDelta(t, 10*degrees, j.Drift, 0.001)
+	}
+
+	var buf bytes.Buffer
+	assert.NoError(t, r.WriteText(&buf))
+	assert.Contains(t, buf.String(), "compared 2.0s")
+	assert.Contains(t, buf.String(), "position       11.7     20.0      10.00  (mm)")
+	assert.Contains(t, buf.String(), "(deg, present)")
+}
+
+func TestCompareSame(t *testing.T) {
+	s := session(time.Unix(1000, 0), 10, func(t float64, r *recorder.Record, s map[uint8][2]uint16) {
+		r.Pose = recorder.Pose{X: float32(t), Heading: float32(t)}
+		s[11] = [2]uint16{uint16(512 + t*10), 0}
+	})
+
+	r, err := Compare(s, s)
+	assert.NoError(t, err)
+	assert.Zero(t, r.Position.Max)
+	assert.Zero(t, r.Heading.Max)
+	if assert.Len(t, r.Joints, 1) {
+		assert.Zero(t, r.Joints[0].Max)
+	}
+
+	_, err = Compare(Session{}, s)
+	assert.EqualError(t, err, "nothing to compare")
+}
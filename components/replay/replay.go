@@ -0,0 +1,113 @@
+// Package replay plays the controller input from a flight recorder dump back
+// through a scripted controller (see controller.NewScripted), so a session can
+// be watched again in the simulator, or played as a demo. It can also replay a
+// session headlessly (see Simulate), and compare the result with the original
+// (see Compare), to see how far the simulator diverges from the hardware.
+package replay
+
+import (
+	"errors"
+	"time"
+
+	"github.com/adammck/hexapod"
+	"github.com/adammck/hexapod/components/controller"
+	"github.com/adammck/hexapod/components/recorder"
+	"github.com/adammck/sixaxis"
+)
+
+var log = hexapod.NewLog("replay")
+
+// Replay is a component which sets the state of a sixaxis from the input of
+// each record, at the same time (relative to the first record, and the first
+// tick) as it was recorded. It must come before the controller which reads the
+// sixaxis, so each input is used on the same tick.
+//
+// Start is never pressed, so the replay doesn't shut the hex down, even if the
+// session it was recorded from ended that way.
+type Replay struct {
+	sa      *sixaxis.SA
+	records []recorder.Record
+
+	// Whether to start again from the first record once the last is reached,
+	// rather than releasing everything and stopping.
+	Loop bool
+
+	// The time of the tick at which the first record was played, and the
+	// index of the next record to play.
+	start time.Time
+	next  int
+
+	// Whether the last record has been played, and Loop wasn't set.
+	done bool
+}
+
+// New creates a replay of the given records (oldest first, as decoded by
+// recorder.Decode) to the given sixaxis.
+func New(sa *sixaxis.SA, records []recorder.Record) *Replay {
+	return &Replay{
+		sa:      sa,
+		records: records,
+	}
+}
+
+func (r *Replay) Boot() error {
+	if len(r.records) == 0 {
+		return errors.New("nothing to replay")
+	}
+
+	log.Infof("replaying %d records (%s)", len(r.records), duration(r.records))
+	return nil
+}
+
+// duration returns the time from the first record to the last.
+func duration(records []recorder.Record) time.Duration {
+	return time.Duration(records[len(records)-1].Time - records[0].Time)
+}
+
+func (r *Replay) Tick(now time.Time, state *hexapod.State) error {
+	if r.done {
+		return nil
+	}
+
+	if r.start.IsZero() {
+		r.start = now
+	}
+
+	// Play every record which is due, but only set the last, since the
+	// controller wouldn't see the others anyway.
+	t0 := r.records[0].Time
+	elapsed := now.Sub(r.start)
+	var in *hexapod.Input
+	for r.next < len(r.records) && time.Duration(r.records[r.next].Time-t0) <= elapsed {
+		v := r.records[r.next].Input()
+		in = &v
+		r.next++
+	}
+
+	if in != nil {
+		in.Buttons &^= hexapod.ButtonStart
+		controller.SetInput(r.sa, *in)
+	}
+
+	if r.next < len(r.records) {
+		return nil
+	}
+
+	if r.Loop {
+		log.Info("replay finished, starting again")
+		r.start = time.Time{}
+		r.next = 0
+		return nil
+	}
+
+	log.Info("replay finished")
+	controller.SetInput(r.sa, hexapod.Input{})
+	r.done = true
+	return nil
+}
+
+// Done returns true once the last record has been played, unless Loop is set.
+// Like Tick, it must only be called from the main loop.
+func (r *Replay) Done() bool {
+	return r.done
+}
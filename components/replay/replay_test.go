@@ -0,0 +1,88 @@
+package replay
+
+import (
+	"testing"
+	"time"
+
+	"github.com/adammck/hexapod"
+	"github.com/adammck/hexapod/components/recorder"
+	"github.com/adammck/sixaxis"
+	"github.com/stretchr/testify/assert"
+)
+
+// records returns a recording of pushing the left stick forwards for 100ms, and
+// then pressing start (and select) for 100ms.
+func records() []recorder.Record {
+	t0 := time.Unix(1000, 0).UnixNano()
+	ms := int64(time.Millisecond)
+	return []recorder.Record{
+		{Time: t0},
+		{Time: t0 + 50*ms, LeftY: -127},
+		{Time: t0 + 100*ms, LeftY: -127},
+		{Time: t0 + 150*ms, Buttons: hexapod.ButtonStart | hexapod.ButtonSelect},
+		{Time: t0 + 250*ms},
+	}
+}
+
+func TestReplay(t *testing.T) {
+	sa := sixaxis.New(nil)
+	r := New(sa, records())
+	assert.NoError(t, r.Boot())
+
+	state := &hexapod.State{}
+	now := time.Unix(0, 0)
+	at := func(d time.Duration) {
+		for end := now.Add(d); now.Before(end); now = now.Add(10 * time.Millisecond) {
+			assert.NoError(t, r.Tick(now, state))
+		}
+	}
+
+	at(10 * time.Millisecond)
+	assert.Equal(t, int32(0), sa.LeftStick.Y)
+
+	at(50 * time.Millisecond)
+	assert.Equal(t, int32(-127), sa.LeftStick.Y)
+
+	// Start is never pressed, but select is.
+	at(100 * time.Millisecond)
+	assert.Equal(t, int32(0), sa.LeftStick.Y)
+	assert.True(t, sa.Select)
+	assert.False(t, sa.Start)
+
+	// Once the last record is played, everything is released.
+	at(100 * time.Millisecond)
+	assert.False(t, sa.Select)
+	assert.True(t, r.Done())
+
+	// And the sixaxis is left alone after that.
+	sa.LeftStick.X = 50
+	at(time.Second)
+	assert.Equal(t, int32(50), sa.LeftStick.X)
+}
+
+func TestReplayLoop(t *testing.T) {
+	sa := sixaxis.New(nil)
+	r := New(sa, records())
+	r.Loop = true
+	assert.NoError(t, r.Boot())
+
+	state := &hexapod.State{}
+	now := time.Unix(0, 0)
+	var pushed int
+	for i := 0; i < 100; i++ {
+		assert.NoError(t, r.Tick(now, state))
+		if sa.LeftStick.Y != 0 {
+			pushed++
+		}
+		now = now.Add(10 * time.Millisecond)
+	}
+
+	// A second, which is four times through, each of which pushes the stick
+	// for 100ms, give or take a tick.
+	assert.False(t, r.Done())
+	assert.InDelta(t, 40, pushed, 4)
+}
+
+func TestReplayNothing(t *testing.T) {
+	assert.Error(t, New(sixaxis.New(nil), nil).Boot())
+}
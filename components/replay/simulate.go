@@ -0,0 +1,124 @@
+package replay
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/adammck/dynamixel/network"
+	"github.com/adammck/hexapod"
+	"github.com/adammck/hexapod/components/controller"
+	"github.com/adammck/hexapod/components/legs"
+	"github.com/adammck/hexapod/components/recorder"
+	"github.com/adammck/hexapod/components/sim"
+	"github.com/adammck/hexapod/config"
+	"github.com/adammck/hexapod/servos"
+	"github.com/adammck/sixaxis"
+)
+
+// How long (in real time) to wait for the simulated legs to reach their home
+// positions, before the replay starts.
+const readyTimeout = 10 * time.Second
+
+// Session is a recording of what the hex did: flight recorder records (which
+// include the controller input), and the servo samples from the same time. The
+// samples can be empty, e.g. if --record-servos wasn't set.
+type Session struct {
+	Records []recorder.Record
+	Servos  []servos.Sample
+}
+
+// Simulate replays the controller input from the given records through the
+// simulator (see sim.Bus), with the real legs and controller, as fast as it
+// can, and returns the session which it recorded: a record for each tick at the
+// given fps, and a sample of every goal and present position on the simulated
+// bus.
+//
+// The replay starts once the legs have reached their home positions, and the
+// session is recorded from then on, so it starts from the same place as a
+// session which was recorded from boot.
+func Simulate(cfg config.Config, records []recorder.Record, fps int) (Session, error) {
+	if len(records) == 0 {
+		return Session{}, errors.New("nothing to replay")
+	}
+
+	// Every joint is read back on every tick, which costs nothing here, so the
+	// simulated present positions can be compared with the real ones.
+	cfg.Legs.Feedback = 24
+
+	now := time.Unix(0, 0)
+	bus := sim.NewBus()
+	port := servos.NewPort(bus)
+	port.Capture = servos.NewCapture(int(duration(records).Seconds()+2) * fps * 64)
+	port.Capture.Now = func() time.Time { return now }
+	n := network.New(port)
+
+	h := hexapod.NewHexapod(n, fps)
+	l := legs.New(n, cfg.Legs, cfg.Gait)
+	sa := sixaxis.New(nil)
+	h.Add(l)
+	h.Add(sim.New(bus, l))
+	h.Add(controller.NewScripted(sa, cfg.Controller, cfg.Head))
+
+	err := h.Boot()
+	if err != nil {
+		return Session{}, err
+	}
+
+	tick := func() error {
+		now = now.Add(time.Second / time.Duration(fps))
+		return h.Tick(now)
+	}
+
+	deadline := time.Now().Add(readyTimeout)
+	for l.State == "" {
+		if time.Now().After(deadline) {
+			return Session{}, fmt.Errorf("legs weren't ready after %s", readyTimeout)
+		}
+
+		err = tick()
+		if err != nil {
+			return Session{}, err
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+
+	// The replay is ticked here, rather than added to the hex, so it starts
+	// now, rather than at boot.
+	rp := New(sa, records)
+	err = rp.Boot()
+	if err != nil {
+		return Session{}, err
+	}
+
+	var out Session
+	start := now
+	for !rp.Done() {
+		next := now.Add(time.Second / time.Duration(fps))
+		err = rp.Tick(next, h.State)
+		if err != nil {
+			return out, err
+		}
+
+		err = tick()
+		if err != nil {
+			return out, err
+		}
+
+		if h.State.Shutdown {
+			return out, fmt.Errorf("shut down %s into the replay", now.Sub(start))
+		}
+
+		out.Records = append(out.Records, recorder.Make(now, h.State))
+	}
+
+	// Only the samples from the replay, not from standing up.
+	for _, s := range port.Capture.Samples() {
+		if s.Time > start.UnixNano() {
+			out.Servos = append(out.Servos, s)
+		}
+	}
+
+	return out, nil
+}
@@ -0,0 +1,46 @@
+package replay
+
+import (
+	"testing"
+	"time"
+
+	"github.com/adammck/hexapod/components/recorder"
+	"github.com/adammck/hexapod/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSimulate(t *testing.T) {
+	t0 := time.Unix(1000, 0).UnixNano()
+	ms := int64(time.Millisecond)
+	records := []recorder.Record{
+		{Time: t0},
+		{Time: t0 + 100*ms, LeftY: -127},
+		{Time: t0 + 500*ms},
+	}
+
+	s, err := Simulate(config.Default(), records, 60)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	// A record for every tick of the replay, which starts when the legs are
+	// ready, and samples of the goals and present positions of every joint.
+	assert.InDelta(t, 30, len(s.Records), 2)
+	assert.InDelta(t, 0.5, duration(s.Records).Seconds(), 0.05)
+
+	ids := map[uint8]bool{}
+	for _, smp := range s.Servos {
+		assert.True(t, smp.Time > s.Records[0].Time-int64(time.Second/60))
+		ids[smp.ID] = true
+	}
+	assert.Len(t, ids, 24)
+
+	// A simulation is the same as itself.
+	r, err := Compare(s, s)
+	assert.NoError(t, err)
+	assert.Len(t, r.Joints, 24)
+	for _, j := range r.Joints {
+		assert.True(t, j.Present)
+		assert.Zero(t, j.Max)
+	}
+}
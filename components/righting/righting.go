@@ -0,0 +1,366 @@
+// Package righting notices when the hex has fallen onto its back, from the
+// IMU's gravity vector, and rolls it back over when the operator asks it to.
+package righting
+
+import (
+	"math"
+	"time"
+
+	"github.com/adammck/hexapod"
+	"github.com/adammck/hexapod/components/joints"
+	"github.com/adammck/hexapod/config"
+	"github.com/adammck/hexapod/math3d"
+	"github.com/adammck/hexapod/utils"
+)
+
+var log = hexapod.NewLog("righting")
+
+const (
+
+	// The range (in g) which the magnitude of the acceleration must be within
+	// for the hex to count as still, rather than tumbling or being carried.
+	minGravity = 0.8
+	maxGravity = 1.2
+
+	// How many times to try rolling over before giving up. The first attempt
+	// pushes with the left legs, and the second with the right.
+	maxAttempts = 2
+)
+
+// IMU is the part of an inertial measurement unit which the righting needs.
+type IMU interface {
+
+	// Acceleration returns the acceleration (in g) along each axis of the
+	// chassis space, which is just gravity while the hex is still. That's up
+	// (+Y) while it's the right way up.
+	Acceleration() (math3d.Vector3, error)
+}
+
+// pose is the angle (in degrees) of each joint of a leg, relative to its zero,
+// in the same order as joints.Names. Negative raises the femur.
+type pose [4]float64
+
+var (
+
+	// Folded up against the chassis, out of the way of the floor.
+	tucked = pose{0, 0, 110, 60}
+
+	// Swung all the way over, and straight, to lever that side of the chassis
+	// up off the floor (which is above it, in the chassis space).
+	pushed = pose{0, -80, 20, 0}
+
+	// Where the legs put the feet, at their home positions with the chassis on
+	// the ground, so that they have nothing to move when they take over.
+	seated = pose{0, -67, 84, 79}
+)
+
+type phase int
+
+const (
+	idle phase = iota
+	tucking
+	rolling
+	waiting
+	seating
+	givingUp
+)
+
+// Righting is a component which sets State.Fallen while the IMU says that the
+// hex is lying still on its back, and rolls it back over when State.StartRighting
+// asks it to (i.e. the operator has confirmed that it's clear to).
+//
+// It tucks all of the legs in, and then swings the legs on one side over, to
+// roll the chassis onto the other. Once the IMU says it's upright, the legs are
+// put down around it, and the legs component stands it up, as it does after
+// booting. If it's not upright soon enough, it tucks and tries again from the
+// other side, and then gives up, leaving the legs tucked. Each joint is swept
+// no faster than MaxRate per tick, within its angle limits, at reduced speed
+// and torque, which are restored afterwards. The legs leave the servos alone
+// while the hex is fallen or being righted (see State.Fallen).
+//
+// This must be added before the legs, for the same reasons as the self-test.
+type Righting struct {
+	legs []joints.Leg
+	imu  IMU
+	cfg  config.Righting
+
+	// Whether the hex was fallen as of the last tick, and since when the IMU
+	// has said (while still) that it was the other way up, or zero if it
+	// hasn't.
+	fallen  bool
+	flipped time.Time
+
+	phase    phase
+	attempts int
+	until    time.Time
+
+	// The goal position of each joint, as last written, and its limits, which
+	// are read once, when righting starts, and where it's being swept to,
+	// counting from the first joint of the first leg.
+	joints  *joints.Set
+	targets []int
+}
+
+// New creates a righting component for the given legs and IMU.
+func New(ls []joints.Leg, imu IMU, cfg config.Righting) *Righting {
+	n := joints.Count(ls)
+	return &Righting{
+		legs:    ls,
+		imu:     imu,
+		cfg:     cfg,
+		joints:  joints.NewSet(ls),
+		targets: make([]int, n),
+	}
+}
+
+func (r *Righting) Boot() error {
+	return nil
+}
+
+func (r *Righting) Tick(now time.Time, state *hexapod.State) error {
+	req := state.StartRighting
+	state.StartRighting = false
+
+	r.detect(now, state)
+
+	if r.phase == idle {
+		if req {
+			r.begin(state)
+		}
+
+		return nil
+	}
+
+	if state.Shutdown {
+		log.Warn("shutting down, aborting righting")
+		r.stop(state)
+		return nil
+	}
+
+	switch r.phase {
+	case tucking:
+		if r.sweep() {
+			r.start(rolling, r.push(r.attempts))
+		}
+
+	case rolling:
+		if r.sweep() {
+			r.phase = waiting
+			r.until = now.Add(r.cfg.Timeout.Duration)
+		}
+
+	case waiting:
+		if !r.fallen {
+			log.Info("upright, putting the legs down")
+			r.start(seating, r.all(seated))
+			break
+		}
+
+		if now.Before(r.until) {
+			break
+		}
+
+		if r.attempts < maxAttempts {
+			r.attempts += 1
+			log.Warnf("still upside down, trying again (attempt %d of %d)", r.attempts, maxAttempts)
+			r.start(tucking, r.all(tucked))
+			break
+		}
+
+		r.start(givingUp, r.all(tucked))
+
+	case seating:
+		if r.sweep() {
+			log.Infof("righted after %d attempt(s)", r.attempts)
+			state.Publish(hexapod.EventRightingSucceeded, hexapod.Info, r.attempts)
+			r.stop(state)
+		}
+
+	case givingUp:
+		if r.sweep() {
+			log.Errorf("still upside down after %d attempts, giving up", r.attempts)
+			state.Publish(hexapod.EventRightingFailed, hexapod.Critical, r.attempts)
+			r.stop(state)
+		}
+	}
+
+	return nil
+}
+
+// detect reads the IMU, and updates State.Fallen once it has said that the hex
+// is the other way up for long enough, while still.
+//
+// If the IMU can't be read, nothing is known about which way up the hex is, so
+// it stays as it was, but must be seen the other way up for the whole settle
+// time again before it counts.
+func (r *Righting) detect(now time.Time, state *hexapod.State) {
+	a, err := r.imu.Acceleration()
+	if err != nil {
+		log.RateLimited("imu", 5*time.Second).Warnf("%s (while reading IMU)", err)
+		r.flipped = time.Time{}
+		state.Fallen = r.fallen
+		return
+	}
+
+	g := a.Magnitude()
+	still := g >= minGravity && g <= maxGravity
+
+	// The cosine of the angle between gravity and up, in the chassis space.
+	cos := a.Y / g
+	limit := math.Cos(utils.Rad(r.cfg.MaxTilt))
+
+	flipped := still && ((!r.fallen && cos < -limit) || (r.fallen && cos > limit))
+	if !flipped {
+		r.flipped = time.Time{}
+	} else if r.flipped.IsZero() {
+		r.flipped = now
+	}
+
+	if !r.flipped.IsZero() && now.Sub(r.flipped) >= r.cfg.Settle.Duration {
+		r.fallen = !r.fallen
+		r.flipped = time.Time{}
+
+		if r.fallen {
+			log.Warn("fallen onto its back")
+			state.Publish(hexapod.EventFallen, hexapod.Warning, nil)
+		} else {
+			log.Info("the right way up again")
+		}
+	}
+
+	state.Fallen = r.fallen
+}
+
+func (r *Righting) begin(state *hexapod.State) {
+	if state.Shutdown {
+		return
+	}
+
+	if !r.fallen {
+		log.Warn("can't right unless fallen")
+		return
+	}
+
+	if state.Calibrating || state.SelfTesting {
+		log.Warn("can't right while calibrating or self-testing")
+		return
+	}
+
+	err := r.reduce()
+	if err != nil {
+		log.Warnf("%s, not righting", err)
+		r.restore()
+		return
+	}
+
+	log.Info("starting righting")
+	r.attempts = 1
+	r.start(tucking, r.all(tucked))
+	state.Righting = true
+}
+
+// stop restores the servos, and hands them back to the legs.
+func (r *Righting) stop(state *hexapod.State) {
+	r.restore()
+	r.phase = idle
+	state.Righting = false
+}
+
+// start starts sweeping the joints to the given targets, in the given phase.
+func (r *Righting) start(p phase, targets []int) {
+	r.phase = p
+	copy(r.targets, targets)
+}
+
+// sweep moves the goal of each joint towards its target by no more than the
+// max rate, within its limits, and returns whether they've all arrived. Goals
+// which can't be written are logged, and treated as arrived, since there's
+// nothing else to do about them mid-roll.
+func (r *Righting) sweep() bool {
+	rate := int(math.Max(1, math.Round(r.cfg.MaxRate/joints.DegreesPerUnit)))
+	done := true
+
+	for i, t := range r.targets {
+		g := r.joints.Goals[i]
+		if g == t {
+			continue
+		}
+
+		d := t - g
+		if d > rate {
+			d = rate
+		} else if d < -rate {
+			d = -rate
+		}
+
+		g += d
+		if g != t {
+			done = false
+		}
+
+		name, s := joints.Joint(r.legs, i)
+		err := s.SetGoalPosition(g)
+		if err != nil {
+			log.Warnf("%s (while moving %s)", err, name)
+			g = t
+		}
+
+		r.joints.Goals[i] = g
+	}
+
+	return done
+}
+
+// all returns the target of each joint which puts every leg in the given pose.
+func (r *Righting) all(p pose) []int {
+	return r.pose(func(joints.Leg) pose { return p })
+}
+
+// push returns the target of each joint for the given attempt, which pushes
+// with the legs on one side, and keeps the others tucked.
+func (r *Righting) push(attempt int) []int {
+	left := attempt%2 == 1
+	return r.pose(func(l joints.Leg) pose {
+		if l.Left == left {
+			return pushed
+		}
+
+		return tucked
+	})
+}
+
+// pose returns the target of each joint which puts each leg in the pose which
+// the given func returns for it, clamped to the limits of the joint. Angles
+// which are out of range are left where they are.
+func (r *Righting) pose(f func(joints.Leg) pose) []int {
+	out := make([]int, len(r.targets))
+	for i := range out {
+		l := r.legs[i/len(joints.Names)]
+		name, s := joints.Joint(r.legs, i)
+
+		p, err := s.Position(f(l)[i%len(joints.Names)])
+		if err != nil {
+			log.Warnf("%s (while posing %s)", err, name)
+			p = r.joints.Goals[i]
+		}
+
+		out[i] = r.joints.Clamp(i, p)
+	}
+
+	return out
+}
+
+// reduce saves the speed and torque limit of every servo, and then sets them to
+// the configured (low) values. See joints.Set.Reduce.
+func (r *Righting) reduce() error {
+	return r.joints.Reduce(r.cfg.MoveSpeed, r.cfg.TorqueLimit)
+}
+
+// restore sets the speed and torque limit of every servo back to what they
+// were saved as. Servos which weren't saved yet are left as they are.
+func (r *Righting) restore() {
+	err := r.joints.Restore()
+	if err != nil {
+		log.Warnf("%s", err)
+	}
+}
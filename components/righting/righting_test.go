@@ -0,0 +1,342 @@
+package righting
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/adammck/hexapod"
+	"github.com/adammck/hexapod/components/joints"
+	"github.com/adammck/hexapod/config"
+	"github.com/adammck/hexapod/hexapodtest"
+	"github.com/adammck/hexapod/math3d"
+	"github.com/stretchr/testify/assert"
+)
+
+// The limits of every mock servo, which some of the poses are beyond.
+const (
+	mockCW  = 200
+	mockCCW = 800
+)
+
+// mockServo moves to its goal straight away.
+type mockServo struct {
+	pos    int
+	goals  []int
+	speed  int
+	torque int
+}
+
+func (s *mockServo) PresentPosition() (int, error) {
+	return s.pos, nil
+}
+
+func (s *mockServo) SetGoalPosition(pos int) error {
+	s.goals = append(s.goals, pos)
+	s.pos = pos
+	return nil
+}
+
+func (s *mockServo) CWAngleLimit() (int, error) {
+	return mockCW, nil
+}
+
+func (s *mockServo) CCWAngleLimit() (int, error) {
+	return mockCCW, nil
+}
+
+func (s *mockServo) MovingSpeed() (int, error) {
+	return s.speed, nil
+}
+
+func (s *mockServo) SetMovingSpeed(speed int) error {
+	s.speed = speed
+	return nil
+}
+
+func (s *mockServo) TorqueLimit() (int, error) {
+	return s.torque, nil
+}
+
+func (s *mockServo) SetTorqueLimit(val int) error {
+	s.torque = val
+	return nil
+}
+
+func (s *mockServo) Position(angle float64) (int, error) {
+	return 512 + int(math.Round(angle/joints.DegreesPerUnit)), nil
+}
+
+func (s *mockServo) Ping() error {
+	return nil
+}
+
+func (s *mockServo) SetLED(state bool) error {
+	return nil
+}
+
+func (s *mockServo) SetTorqueEnable(state bool) error {
+	return nil
+}
+
+type mockIMU struct {
+	a   math3d.Vector3
+	err error
+}
+
+func (m *mockIMU) Acceleration() (math3d.Vector3, error) {
+	return m.a, m.err
+}
+
+var (
+	upright  = math3d.Vector3{Y: 1}
+	inverted = math3d.Vector3{X: 0.1, Y: -0.95}
+)
+
+type fixture struct {
+	*hexapodtest.Clock
+	r      *Righting
+	servos [][4]*mockServo
+	imu    *mockIMU
+
+	// Called after every tick, e.g. to roll the hex over once the legs have
+	// pushed far enough.
+	after func()
+}
+
+func setup(t *testing.T) *fixture {
+	f := &fixture{
+		Clock: hexapodtest.NewClock(t, 20*time.Millisecond),
+		imu:   &mockIMU{a: upright},
+	}
+
+	var ls []joints.Leg
+	for _, name := range []string{"FL", "FR"} {
+		var ms [4]*mockServo
+		var ss [4]joints.Servo
+		for i := range ms {
+			ms[i] = &mockServo{pos: 512, speed: 1023, torque: 1023}
+			ss[i] = ms[i]
+		}
+
+		f.servos = append(f.servos, ms)
+		ls = append(ls, joints.Leg{Name: name, Left: name == "FL", Servos: ss})
+	}
+
+	f.r = New(ls, f.imu, config.Default().Righting)
+	assert.NoError(t, f.r.Boot())
+	return f
+}
+
+func (f *fixture) tick() {
+	f.Tick(f.r)
+	if f.after != nil {
+		f.after()
+	}
+}
+
+// fall turns the hex over, and ticks until it counts as fallen.
+func (f *fixture) fall() {
+	f.imu.a = inverted
+	for i := 0; i < 120 && !f.State.Fallen; i++ {
+		f.tick()
+	}
+	assert.True(f.T, f.State.Fallen)
+}
+
+// run starts righting, and ticks until it finishes, or a minute passes. While
+// it's running, the speed and torque of every servo must be reduced.
+func (f *fixture) run() []string {
+	f.State.StartRighting = true
+	f.tick()
+	assert.True(f.T, f.State.Righting)
+
+	for i := 0; i < 3600 && f.State.Righting; i++ {
+		for _, ms := range f.servos {
+			for _, s := range ms {
+				assert.Equal(f.T, 128, s.speed)
+				assert.Equal(f.T, 384, s.torque)
+			}
+		}
+
+		f.tick()
+	}
+	assert.False(f.T, f.State.Righting)
+	return f.Events()
+}
+
+// assertSwept asserts that each joint was only ever moved within its limits,
+// and by no more than the max rate (3 degrees, or 10 units) per tick.
+func (f *fixture) assertSwept() {
+	for _, ms := range f.servos {
+		for _, s := range ms {
+			prev := 512
+			for _, g := range s.goals {
+				assert.GreaterOrEqual(f.T, g, mockCW)
+				assert.LessOrEqual(f.T, g, mockCCW)
+				assert.LessOrEqual(f.T, math.Abs(float64(g-prev)), 10.0)
+				prev = g
+			}
+		}
+	}
+}
+
+func TestDetectsFall(t *testing.T) {
+	f := setup(t)
+	f.tick()
+	assert.False(t, f.State.Fallen)
+
+	// Being tumbled (or carried) upside down doesn't count, nor does lying on
+	// its side.
+	for _, a := range []math3d.Vector3{{Y: -2}, {Y: -0.3}, {X: 1}} {
+		f.imu.a = a
+		for i := 0; i < 120; i++ {
+			f.tick()
+		}
+		assert.False(t, f.State.Fallen, "%v", a)
+	}
+
+	// Only once it's been upside down for as long as the settle time.
+	f.imu.a = inverted
+	for i := 0; i < 50; i++ {
+		f.tick()
+	}
+	assert.False(t, f.State.Fallen)
+
+	f.tick()
+	assert.True(t, f.State.Fallen)
+	assert.Equal(t, hexapod.EventFallen, f.State.Published()[0].Name)
+
+	// Likewise once it's the right way up again, e.g. by hand.
+	f.imu.a = upright
+	for i := 0; i < 50; i++ {
+		f.tick()
+	}
+	assert.True(t, f.State.Fallen)
+
+	f.tick()
+	assert.False(t, f.State.Fallen)
+
+	// Nothing was moved.
+	for _, ms := range f.servos {
+		for _, s := range ms {
+			assert.Empty(t, s.goals)
+		}
+	}
+}
+
+func TestIMUDropouts(t *testing.T) {
+	f := setup(t)
+
+	// A failed read doesn't stop the loop, but starts the settle time again,
+	// since the hex might have been the right way up in the meantime.
+	f.imu.a = inverted
+	for i := 0; i < 40; i++ {
+		f.tick()
+	}
+	f.imu.err = errors.New("timeout")
+	f.tick()
+	f.imu.err = nil
+
+	for i := 0; i < 50; i++ {
+		f.tick()
+	}
+	assert.False(t, f.State.Fallen)
+
+	f.tick()
+	assert.True(t, f.State.Fallen)
+
+	// Nor does it count as being the right way up.
+	f.imu.err = errors.New("timeout")
+	for i := 0; i < 100; i++ {
+		f.tick()
+	}
+	assert.True(t, f.State.Fallen)
+}
+
+func TestOnlyWhileFallen(t *testing.T) {
+	f := setup(t)
+	f.State.StartRighting = true
+	f.tick()
+	assert.False(t, f.State.Righting)
+	assert.False(t, f.State.StartRighting)
+
+	f.fall()
+	f.State.SelfTesting = true
+	f.State.StartRighting = true
+	f.tick()
+	assert.False(t, f.State.Righting)
+}
+
+func TestRights(t *testing.T) {
+	f := setup(t)
+	f.fall()
+
+	// Roll over once the left legs have pushed far enough.
+	fl := f.servos[0]
+	f.after = func() {
+		if fl[1].pos < 300 {
+			f.imu.a = upright
+		}
+	}
+
+	events := f.run()
+	assert.Equal(t, []string{hexapod.EventFallen, hexapod.EventRightingSucceeded}, events)
+	assert.Equal(t, 1, f.State.Published()[1].Payload)
+	assert.False(t, f.State.Fallen)
+	f.assertSwept()
+
+	// The right legs stayed tucked, with the tibia only as far as its limit,
+	// and all of them were put down to be stood up.
+	fr := f.servos[1]
+	assert.NotContains(t, fr[1].goals, 239)
+	assert.Contains(t, fr[2].goals, mockCCW)
+	for _, ms := range f.servos {
+		assert.Equal(t, []int{512, 283, 799, 782}, []int{ms[0].pos, ms[1].pos, ms[2].pos, ms[3].pos})
+		for _, s := range ms {
+			assert.Equal(t, 1023, s.speed)
+			assert.Equal(t, 1023, s.torque)
+		}
+	}
+}
+
+func TestGivesUp(t *testing.T) {
+	f := setup(t)
+	f.fall()
+
+	events := f.run()
+	assert.Equal(t, []string{hexapod.EventFallen, hexapod.EventRightingFailed}, events)
+	assert.Equal(t, 2, f.State.Published()[1].Payload)
+	assert.True(t, f.State.Fallen)
+	f.assertSwept()
+
+	// It pushed with each side in turn, and was left tucked.
+	for _, ms := range f.servos {
+		assert.Contains(t, ms[1].goals, 239)
+		assert.Equal(t, []int{512, 512, 800, 717}, []int{ms[0].pos, ms[1].pos, ms[2].pos, ms[3].pos})
+		for _, s := range ms {
+			assert.Equal(t, 1023, s.torque)
+		}
+	}
+}
+
+func TestAbortsOnShutdown(t *testing.T) {
+	f := setup(t)
+	f.fall()
+
+	f.State.StartRighting = true
+	f.tick()
+	f.tick()
+	assert.True(t, f.State.Righting)
+
+	f.State.Shutdown = true
+	f.tick()
+	assert.False(t, f.State.Righting)
+	for _, ms := range f.servos {
+		for _, s := range ms {
+			assert.Equal(t, 1023, s.speed)
+			assert.Equal(t, 1023, s.torque)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package rosbridge
+
+import (
+	"encoding/json"
+	"math"
+	"time"
+
+	"github.com/adammck/hexapod"
+	"github.com/adammck/hexapod/math3d"
+	"github.com/adammck/hexapod/utils"
+)
+
+// The hexapod and ROS use different coordinate systems. The hexapod is X right,
+// Y up, Z forwards (left-handed), in millimeters and degrees. ROS (REP 103) is X
+// forwards, Y left, Z up (right-handed), in meters and radians. The functions
+// in this file convert between them.
+
+// op is a single rosbridge protocol message. Only the fields used by the ops we
+// send or receive are included.
+type op struct {
+	Op    string      `json:"op"`
+	Topic string      `json:"topic"`
+	Type  string      `json:"type,omitempty"`
+	Msg   interface{} `json:"msg,omitempty"`
+}
+
+// incoming is the same as op, but leaves the msg undecoded until we know what
+// type it is.
+type incoming struct {
+	Op    string          `json:"op"`
+	Topic string          `json:"topic"`
+	Msg   json.RawMessage `json:"msg"`
+}
+
+type Time struct {
+	Secs  int64 `json:"secs"`
+	Nsecs int64 `json:"nsecs"`
+}
+
+type Header struct {
+	Stamp   Time   `json:"stamp"`
+	FrameID string `json:"frame_id"`
+}
+
+type Point struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+	Z float64 `json:"z"`
+}
+
+type Quaternion struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+	Z float64 `json:"z"`
+	W float64 `json:"w"`
+}
+
+type Pose struct {
+	Position    Point      `json:"position"`
+	Orientation Quaternion `json:"orientation"`
+}
+
+// PoseStamped is a geometry_msgs/PoseStamped.
+type PoseStamped struct {
+	Header Header `json:"header"`
+	Pose   Pose   `json:"pose"`
+}
+
+// Twist is a geometry_msgs/Twist.
+type Twist struct {
+	Linear  Point `json:"linear"`
+	Angular Point `json:"angular"`
+}
+
+func stamp(t time.Time) Time {
+	return Time{Secs: t.Unix(), Nsecs: int64(t.Nanosecond())}
+}
+
+// toROS converts a vector in millimeters from the hexapod space to meters in
+// the ROS space.
+func toROS(v math3d.Vector3) Point {
+	return Point{X: v.Z / 1000, Y: -v.X / 1000, Z: v.Y / 1000}
+}
+
+// fromROS is the inverse of toROS.
+func fromROS(p Point) math3d.Vector3 {
+	return math3d.Vector3{X: -p.Y * 1000, Y: p.Z * 1000, Z: p.X * 1000}
+}
+
+// newPoseStamped converts a hexapod pose to a ROS pose in the given frame.
+// Positive heading is clockwise from above, so is negative yaw. Pitch (nose
+// down) and bank (right side down) have the same sign in both.
+func newPoseStamped(now time.Time, frame string, p math3d.Pose) PoseStamped {
+	return PoseStamped{
+		Header: Header{Stamp: stamp(now), FrameID: frame},
+		Pose: Pose{
+			Position:    toROS(p.Position),
+			Orientation: quaternion(utils.Rad(p.Bank), utils.Rad(p.Pitch), utils.Rad(-p.Heading)),
+		},
+	}
+}
+
+// quaternion returns the quaternion for the given ROS roll, pitch, and yaw (in
+// radians), which are applied in that order around the fixed axes.
+func quaternion(roll, pitch, yaw float64) Quaternion {
+	cr, sr := math.Cos(roll/2), math.Sin(roll/2)
+	cp, sp := math.Cos(pitch/2), math.Sin(pitch/2)
+	cy, sy := math.Cos(yaw/2), math.Sin(yaw/2)
+
+	return Quaternion{
+		X: sr*cp*cy - cr*sp*sy,
+		Y: cr*sp*cy + sr*cp*sy,
+		Z: cr*cp*sy - sr*sp*cy,
+		W: cr*cp*cy + sr*sp*sy,
+	}
+}
+
+// commandedTwist returns the velocity which the state is currently asking for.
+// Target isn't a velocity, but it's always set (by the controller, or by us) to
+// where the hexapod should be after about one horizon, so we can pretend.
+func commandedTwist(state *hexapod.State, horizon time.Duration) Twist {
+	d := state.Target.Position.MultiplyByMatrix44(state.Pose.ToLocal())
+	d.Y = 0
+
+	s := horizon.Seconds()
+	l := toROS(d)
+
+	return Twist{
+		Linear:  Point{X: l.X / s, Y: l.Y / s},
+		Angular: Point{Z: utils.Rad(-math3d.AngleDiff(state.Target.Heading, state.Pose.Heading)) / s},
+	}
+}
+
+// targetFromTwist returns the target which a velocity means, relative to the
+// given pose. This is the inverse of commandedTwist, except that the result is
+// clamped to the given limits (in mm and degrees), like the controller's.
+func targetFromTwist(pose math3d.Pose, t Twist, horizon time.Duration, maxMove, maxRot float64) math3d.Pose {
+	s := horizon.Seconds()
+
+	v := fromROS(Point{X: t.Linear.X * s, Y: t.Linear.Y * s}).ClampLength(maxMove)
+
+	h := -utils.Deg(t.Angular.Z * s)
+	h = math.Max(-maxRot, math.Min(maxRot, h))
+
+	return pose.Add(math3d.Pose{
+		Position: v,
+		Heading:  h,
+	})
+}
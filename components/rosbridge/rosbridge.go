@@ -0,0 +1,288 @@
+package rosbridge
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/adammck/hexapod"
+	"github.com/gorilla/websocket"
+)
+
+var log = hexapod.NewLog("rosbridge")
+
+const (
+	poseType  = "geometry_msgs/PoseStamped"
+	twistType = "geometry_msgs/Twist"
+
+	// The frame which poses are published in.
+	frameID = "odom"
+
+	// How far ahead the target is set from a cmd_vel. See commandedTwist.
+	horizon = time.Second
+
+	// Limits of the target set from a cmd_vel, per horizon. Same as the
+	// controller's default move and rotation speeds.
+	maxMove = 100.0
+	maxRot  = 15.0
+
+	// How long a cmd_vel is obeyed for. ROS nodes publish cmd_vel continuously
+	// while they want to move, so if they stop (or we stop hearing them), so
+	// should we.
+	staleAfter = 500 * time.Millisecond
+
+	// The number of messages to buffer for sending. Anything more than this is
+	// dropped, since old poses are worthless.
+	queueSize = 8
+
+	// How long to wait before reconnecting after the connection is lost.
+	reconnectInterval = 5 * time.Second
+
+	writeTimeout = 5 * time.Second
+)
+
+// Bridge is a component which publishes the pose and commanded velocity to a
+// rosbridge server, and optionally accepts velocity commands from it.
+//
+// Commands from ROS are a low priority input: they're ignored while the hex is
+// halted or shutting down, or while the controller is being used.
+type Bridge struct {
+	url      string
+	prefix   string
+	cmdVel   string
+	interval time.Duration
+	last     time.Time
+
+	sync.Mutex
+
+	// The queue of the current connection, or nil if disconnected.
+	out chan []byte
+
+	// The most recently received cmd_vel, and when. Zero if none, or if the
+	// connection has been lost since.
+	twist   Twist
+	twistAt time.Time
+}
+
+// New creates a bridge which connects to the rosbridge server at the given URL
+// (e.g. ws://localhost:9090), and publishes <prefix>/pose and <prefix>/twist
+// at the given rate. If cmdVel is not empty, that topic is subscribed to.
+func New(url, prefix, cmdVel string, rate int) *Bridge {
+	return &Bridge{
+		url:      url,
+		prefix:   prefix,
+		cmdVel:   cmdVel,
+		interval: time.Second / time.Duration(rate),
+	}
+}
+
+// Writes returns hexapod.Commander, since the bridge sets the target from
+// velocity commands.
+func (b *Bridge) Writes() hexapod.Role {
+	return hexapod.Commander
+}
+
+// Source implements hexapod.Autonomous.
+func (b *Bridge) Source() string {
+	return "rosbridge"
+}
+
+// Cancel forgets the last cmd_vel, when the countdown before obeying it is
+// cancelled, so only a newer one can start it again. It implements
+// hexapod.Autonomous.
+func (b *Bridge) Cancel() {
+	b.Lock()
+	defer b.Unlock()
+
+	b.twist = Twist{}
+	b.twistAt = time.Time{}
+}
+
+// Boot starts connecting to the server in the background. The hexapod doesn't
+// need ROS, so we don't wait.
+func (b *Bridge) Boot() error {
+	go b.run()
+	return nil
+}
+
+// run connects to the server, and reconnects whenever the connection is lost.
+func (b *Bridge) run() {
+	for {
+		err := b.connect()
+		log.RateLimited("connect", time.Minute).Warnf("%s (while connected to %s)", err, b.url)
+		b.disconnected()
+		time.Sleep(reconnectInterval)
+	}
+}
+
+// connect connects to the server and handles messages until the connection is
+// lost, then returns why.
+func (b *Bridge) connect() error {
+	conn, _, err := websocket.DefaultDialer.Dial(b.url, nil)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	log.Infof("connected to %s", b.url)
+
+	// Advertise before enabling the queue, so these always go first.
+	for _, o := range b.setup() {
+		err = conn.WriteJSON(o)
+		if err != nil {
+			return err
+		}
+	}
+
+	out := make(chan []byte, queueSize)
+	b.Lock()
+	b.out = out
+	b.Unlock()
+
+	// Stop the writer when the reader fails.
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case p := <-out:
+				conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+				err := conn.WriteMessage(websocket.TextMessage, p)
+				if err != nil {
+
+					// Unblock the reader.
+					conn.Close()
+					return
+				}
+			}
+		}
+	}()
+
+	for {
+		_, p, err := conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+
+		b.handle(p, time.Now())
+	}
+}
+
+// setup returns the ops to send upon connecting.
+func (b *Bridge) setup() []op {
+	ops := []op{
+		{Op: "advertise", Topic: b.prefix + "/pose", Type: poseType},
+		{Op: "advertise", Topic: b.prefix + "/twist", Type: twistType},
+	}
+
+	if b.cmdVel != "" {
+		ops = append(ops, op{Op: "subscribe", Topic: b.cmdVel, Type: twistType})
+	}
+
+	return ops
+}
+
+// disconnected drops the queue, and forgets the last cmd_vel, so we stop moving
+// right away rather than waiting for it to go stale.
+func (b *Bridge) disconnected() {
+	b.Lock()
+	defer b.Unlock()
+
+	b.out = nil
+	b.twist = Twist{}
+	b.twistAt = time.Time{}
+}
+
+// handle is called (from the connection goroutine) with every message received
+// from the server.
+func (b *Bridge) handle(p []byte, at time.Time) {
+	var in incoming
+	err := json.Unmarshal(p, &in)
+	if err != nil {
+		log.RateLimited("invalid", time.Minute).Warnf("invalid message: %s", err)
+		return
+	}
+
+	if in.Op != "publish" || b.cmdVel == "" || in.Topic != b.cmdVel {
+		return
+	}
+
+	var t Twist
+	err = json.Unmarshal(in.Msg, &t)
+	if err != nil {
+		log.RateLimited("invalid", time.Minute).Warnf("invalid twist: %s", err)
+		return
+	}
+
+	b.Lock()
+	b.twist = t
+	b.twistAt = at
+	b.Unlock()
+}
+
+// Tick sets the target from the latest cmd_vel (if it's fresh, and nothing
+// else is in control), and publishes the pose and twist if it's time.
+func (b *Bridge) Tick(now time.Time, state *hexapod.State) error {
+	b.Lock()
+	twist, twistAt := b.twist, b.twistAt
+	b.Unlock()
+
+	if b.obey(now, twistAt, state) {
+
+		// Only replace the walking part of the target. The controller still
+		// owns the clearance and orientation.
+		t := targetFromTwist(state.Pose, twist, horizon, maxMove, maxRot)
+		t.Position.Y = state.Target.Position.Y
+		t.Pitch = state.Target.Pitch
+		t.Bank = state.Target.Bank
+		state.Target = t
+	}
+
+	if now.Sub(b.last) < b.interval {
+		return nil
+	}
+
+	b.last = now
+	b.publish(b.prefix+"/pose", newPoseStamped(now, frameID, state.Pose))
+	b.publish(b.prefix+"/twist", commandedTwist(state, horizon))
+	return nil
+}
+
+// obey returns true if a cmd_vel received at the given time should be obeyed.
+func (b *Bridge) obey(now, at time.Time, state *hexapod.State) bool {
+	if at.IsZero() || now.Sub(at) > staleAfter {
+		return false
+	}
+
+	if state.Halt || state.Shutdown {
+		return false
+	}
+
+	// The controller takes priority over cmd_vel while it's being used.
+	return !state.Input.Walking()
+}
+
+// publish queues a message, or drops it if the connection is down or behind.
+func (b *Bridge) publish(topic string, msg interface{}) {
+	b.Lock()
+	out := b.out
+	b.Unlock()
+
+	if out == nil {
+		return
+	}
+
+	p, err := json.Marshal(op{Op: "publish", Topic: topic, Msg: msg})
+	if err != nil {
+		log.Warnf("%s (while encoding %s)", err, topic)
+		return
+	}
+
+	select {
+	case out <- p:
+	default:
+	}
+}
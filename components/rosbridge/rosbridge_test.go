@@ -0,0 +1,195 @@
+package rosbridge
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/adammck/hexapod"
+	"github.com/adammck/hexapod/math3d"
+	"github.com/adammck/hexapod/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+const epsilon = 0.0001
+
+func TestPoseStampedJSON(t *testing.T) {
+	now := time.Unix(1500000000, 250)
+	p := math3d.Pose{
+		Position: math3d.Vector3{X: 100, Y: 40, Z: 1000},
+		Heading:  90,
+	}
+
+	b, err := json.Marshal(op{Op: "publish", Topic: "/hexapod/pose", Msg: newPoseStamped(now, "odom", p)})
+	assert.NoError(t, err)
+
+	var m map[string]interface{}
+	assert.NoError(t, json.Unmarshal(b, &m))
+	assert.Equal(t, "publish", m["op"])
+	assert.Equal(t, "/hexapod/pose", m["topic"])
+
+	msg := m["msg"].(map[string]interface{})
+	header := msg["header"].(map[string]interface{})
+	assert.Equal(t, "odom", header["frame_id"])
+	assert.Equal(t, map[string]interface{}{"secs": 1500000000.0, "nsecs": 250.0}, header["stamp"])
+
+	pose := msg["pose"].(map[string]interface{})
+	pos := pose["position"].(map[string]interface{})
+	assert.InDelta(t, 1.0, pos["x"], epsilon)
+	assert.InDelta(t, -0.1, pos["y"], epsilon)
+	assert.InDelta(t, 0.04, pos["z"], epsilon)
+
+	// Heading clockwise by 90 degrees is yaw by -90 degrees.
+	q := pose["orientation"].(map[string]interface{})
+	assert.InDelta(t, 0, q["x"], epsilon)
+	assert.InDelta(t, 0, q["y"], epsilon)
+	assert.InDelta(t, -math.Sqrt2/2, q["z"], epsilon)
+	assert.InDelta(t, math.Sqrt2/2, q["w"], epsilon)
+}
+
+func TestTwistJSON(t *testing.T) {
+	b, err := json.Marshal(op{Op: "publish", Topic: "/hexapod/twist", Msg: Twist{Linear: Point{X: 1}}})
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"op":"publish","topic":"/hexapod/twist","msg":{"linear":{"x":1,"y":0,"z":0},"angular":{"x":0,"y":0,"z":0}}}`, string(b))
+}
+
+func TestSetup(t *testing.T) {
+	b := New("ws://localhost:9090", "/hexapod", "/cmd_vel", 10)
+	ops := b.setup()
+	assert.Equal(t, []op{
+		{Op: "advertise", Topic: "/hexapod/pose", Type: poseType},
+		{Op: "advertise", Topic: "/hexapod/twist", Type: twistType},
+		{Op: "subscribe", Topic: "/cmd_vel", Type: twistType},
+	}, ops)
+
+	b = New("ws://localhost:9090", "/hexapod", "", 10)
+	assert.Len(t, b.setup(), 2)
+}
+
+func TestTwistRoundTrip(t *testing.T) {
+	pose := math3d.Pose{Position: math3d.Vector3{X: 10, Y: 40, Z: 20}, Heading: 30}
+	in := Twist{Linear: Point{X: 0.05, Y: -0.02}, Angular: Point{Z: 0.1}}
+
+	state := &hexapod.State{
+		Commands:  hexapod.Commands{Target: targetFromTwist(pose, in, horizon, maxMove, maxRot)},
+		Estimates: hexapod.Estimates{Pose: pose},
+	}
+	out := commandedTwist(state, horizon)
+	assert.InDelta(t, in.Linear.X, out.Linear.X, epsilon)
+	assert.InDelta(t, in.Linear.Y, out.Linear.Y, epsilon)
+	assert.InDelta(t, in.Angular.Z, out.Angular.Z, epsilon)
+
+	// Turning left across 180 degrees is still a small turn.
+	state = &hexapod.State{
+		Commands:  hexapod.Commands{Target: math3d.Pose{Heading: 165}},
+		Estimates: hexapod.Estimates{Pose: math3d.Pose{Heading: -175}},
+	}
+	out = commandedTwist(state, horizon)
+	assert.InDelta(t, utils.Rad(20), out.Angular.Z, epsilon)
+}
+
+func cmdVel(t *testing.T, b *Bridge, at time.Time, twist Twist) {
+	p, err := json.Marshal(op{Op: "publish", Topic: "/cmd_vel", Msg: twist})
+	assert.NoError(t, err)
+	b.handle(p, at)
+}
+
+func TestCmdVelSetsTarget(t *testing.T) {
+	b := New("ws://localhost:9090", "/hexapod", "/cmd_vel", 10)
+	start := time.Now()
+
+	// Forwards at 5cm/s, and turning left fast enough to be clamped.
+	cmdVel(t, b, start, Twist{Linear: Point{X: 0.05}, Angular: Point{Z: 1}})
+
+	state := &hexapod.State{}
+	state.Target.Position.Y = 40
+	state.Target.Pitch = 5
+	assert.NoError(t, b.Tick(start, state))
+	assert.InDelta(t, 50, state.Target.Position.Z, epsilon)
+	assert.InDelta(t, 0, state.Target.Position.X, epsilon)
+	assert.InDelta(t, -maxRot, state.Target.Heading, epsilon)
+
+	// The clearance and orientation are left alone.
+	assert.Equal(t, 40.0, state.Target.Position.Y)
+	assert.Equal(t, 5.0, state.Target.Pitch)
+
+	// The distance is clamped too.
+	cmdVel(t, b, start, Twist{Linear: Point{X: 0.3, Y: 0.4}})
+	state = &hexapod.State{}
+	assert.NoError(t, b.Tick(start, state))
+	assert.InDelta(t, maxMove, state.Target.Position.Magnitude(), epsilon)
+	assert.InDelta(t, 60, state.Target.Position.Z, epsilon)
+	assert.InDelta(t, -80, state.Target.Position.X, epsilon)
+}
+
+func TestCmdVelFailsafe(t *testing.T) {
+	b := New("ws://localhost:9090", "/hexapod", "/cmd_vel", 10)
+	start := time.Now()
+	fwd := Twist{Linear: Point{X: 0.05}}
+
+	// Stale.
+	cmdVel(t, b, start, fwd)
+	state := &hexapod.State{}
+	assert.NoError(t, b.Tick(start.Add(staleAfter+time.Millisecond), state))
+	assert.Equal(t, math3d.Pose{}, state.Target)
+
+	// Halted, or shutting down.
+	for _, s := range []*hexapod.State{{Commands: hexapod.Commands{Halt: true}}, {Shutdown: true}} {
+		assert.NoError(t, b.Tick(start, s))
+		assert.Equal(t, math3d.Pose{}, s.Target)
+	}
+
+	// The controller is being used.
+	state = &hexapod.State{Commands: hexapod.Commands{Input: hexapod.Input{LeftY: -100}}}
+	assert.NoError(t, b.Tick(start, state))
+	assert.Equal(t, math3d.Pose{}, state.Target)
+
+	// But a slightly off-center stick is fine.
+	state = &hexapod.State{Commands: hexapod.Commands{Input: hexapod.Input{LeftY: 3}}}
+	assert.NoError(t, b.Tick(start, state))
+	assert.InDelta(t, 50, state.Target.Position.Z, epsilon)
+
+	// Losing the connection stops it immediately.
+	b.disconnected()
+	state = &hexapod.State{}
+	assert.NoError(t, b.Tick(start, state))
+	assert.Equal(t, math3d.Pose{}, state.Target)
+
+	// Messages on other topics (or without a subscription) are ignored.
+	b2 := New("ws://localhost:9090", "/hexapod", "", 10)
+	cmdVel(t, b2, start, fwd)
+	state = &hexapod.State{}
+	assert.NoError(t, b2.Tick(start, state))
+	assert.Equal(t, math3d.Pose{}, state.Target)
+}
+
+func TestPublishDropsWhenDisconnected(t *testing.T) {
+	b := New("ws://localhost:9090", "/hexapod", "", 10)
+	start := time.Now()
+
+	// Not connected, so nothing to do.
+	assert.NoError(t, b.Tick(start, &hexapod.State{}))
+
+	out := make(chan []byte, queueSize)
+	b.out = out
+
+	// Too soon since the last publish.
+	assert.NoError(t, b.Tick(start.Add(10*time.Millisecond), &hexapod.State{}))
+	assert.Len(t, out, 0)
+
+	assert.NoError(t, b.Tick(start.Add(100*time.Millisecond), &hexapod.State{}))
+	assert.Len(t, out, 2)
+
+	var o incoming
+	assert.NoError(t, json.Unmarshal(<-out, &o))
+	assert.Equal(t, "/hexapod/pose", o.Topic)
+	assert.NoError(t, json.Unmarshal(<-out, &o))
+	assert.Equal(t, "/hexapod/twist", o.Topic)
+
+	// If the queue is full, messages are dropped rather than blocking.
+	for i := 0; i < queueSize; i++ {
+		assert.NoError(t, b.Tick(start.Add(time.Duration(i+2)*100*time.Millisecond), &hexapod.State{}))
+	}
+	assert.Len(t, out, queueSize)
+}
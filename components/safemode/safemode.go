@@ -0,0 +1,137 @@
+// Package safemode restricts what the hex can do at runtime while it's in safe
+// mode, which is for the first boot on a freshly built frame. See
+// config.Config.Safe for the rest of the restrictions.
+package safemode
+
+import (
+	"math"
+	"time"
+
+	"github.com/adammck/hexapod"
+	"github.com/adammck/hexapod/components/legs/gait"
+	"github.com/adammck/hexapod/config"
+)
+
+var log = hexapod.NewLog("safemode")
+
+const (
+
+	// The buttons which must be held (without select) to arm the hex, and for
+	// how long. Neither does anything else without select.
+	armButtons = hexapod.ButtonCircle | hexapod.ButtonSquare
+	armHold    = 2 * time.Second
+
+	// How often to remind that the hex isn't armed.
+	remindInterval = 30 * time.Second
+)
+
+// SafeMode is a component which holds the hex to the restrictions of safe mode
+// on every tick, after everything which sets the targets, so nothing can get
+// around them: the speed is the minimum, the gait is the wave, and the
+// clearance stays within the controller's (restricted) range.
+//
+// The hex starts halted, and stays that way until it's armed by holding circle
+// and square for a couple of seconds, so it doesn't walk off as soon as it
+// boots, e.g. because a stick isn't centered. It stands up while halted, so
+// the legs can be watched before anything else happens. Arming releases the
+// halt, even if something else (e.g. the API) requested it meanwhile.
+//
+// State.SafeMode is set on every tick, and State.Armed once it's armed, so the
+// LEDs and telemetry can show them.
+type SafeMode struct {
+	cfg config.Controller
+
+	// Whether the hex has been armed, and since when the buttons have been held
+	// (or zero, if they aren't).
+	armed bool
+	held  time.Time
+
+	// When the hex was last reminded to be armed.
+	reminded time.Time
+}
+
+// New returns a safe mode component, which keeps the clearance within the
+// given controller config's range.
+func New(cfg config.Controller) *SafeMode {
+	return &SafeMode{cfg: cfg}
+}
+
+// Essential returns true, since the restrictions must not be lifted by a panic.
+func (s *SafeMode) Essential() bool {
+	return true
+}
+
+// Writes returns hexapod.Commander, since it overrides the targets.
+func (s *SafeMode) Writes() hexapod.Role {
+	return hexapod.Commander
+}
+
+func (s *SafeMode) Boot() error {
+	log.Warn("SAFE MODE: speed, torque, clearance, and gait are restricted; hold circle + square to arm")
+	return nil
+}
+
+func (s *SafeMode) Tick(now time.Time, state *hexapod.State) error {
+	state.SafeMode = true
+
+	state.Speed = hexapod.MinSpeed
+
+	if cur, _ := state.ActiveGait(); cur.Name != gait.Wave {
+		if g, ok := state.LookupGait(gait.Wave); ok {
+			log.RateLimited("gait", 10*time.Second).Warnf("forcing gait %s in safe mode (was %s)", g, cur)
+			state.SetGait(g)
+		}
+	}
+
+	y := &state.Target.Position.Y
+	*y = math.Min(math.Max(*y, s.cfg.MinClearance), s.cfg.MaxClearance)
+
+	s.arm(now, state)
+	if !s.armed {
+		hold(state)
+
+		if now.Sub(s.reminded) >= remindInterval {
+			log.Warn("not armed; hold circle + square to arm")
+			s.reminded = now
+		}
+	}
+
+	state.Armed = s.armed
+	return nil
+}
+
+// arm arms the hex once the arming buttons have been held for long enough. It
+// can't be disarmed, except by restarting.
+func (s *SafeMode) arm(now time.Time, state *hexapod.State) {
+	if s.armed || state.Shutdown {
+		return
+	}
+
+	b := state.Input.Buttons
+	if b&armButtons != armButtons || b&hexapod.ButtonSelect != 0 {
+		s.held = time.Time{}
+		return
+	}
+
+	if s.held.IsZero() {
+		s.held = now
+	}
+
+	if now.Sub(s.held) < armHold {
+		return
+	}
+
+	log.Warn("armed; walking is allowed (still in safe mode)")
+	s.armed = true
+	state.Halt = false
+	state.Publish(hexapod.EventArmed, hexapod.Info, nil)
+}
+
+// hold halts the hex where it is, but keeps the clearance, as the controller
+// does while halted.
+func hold(state *hexapod.State) {
+	state.Halt = true
+	y := state.Target.Position.Y
+	state.Target = state.Pose
+	state.Target.Position.Y = y
+}
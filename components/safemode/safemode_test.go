@@ -0,0 +1,132 @@
+package safemode
+
+import (
+	"testing"
+	"time"
+
+	"github.com/adammck/hexapod"
+	"github.com/adammck/hexapod/components/legs/gait"
+	"github.com/adammck/hexapod/config"
+	"github.com/adammck/hexapod/math3d"
+	"github.com/stretchr/testify/assert"
+)
+
+var t0 = time.Unix(1000, 0)
+
+// standing returns the state of a hex standing somewhere, which has been told
+// to walk forwards quickly, with the tripod gait, as high as it can.
+func standing() hexapod.State {
+	p := math3d.Pose{Position: math3d.Vector3{X: 100, Y: 40, Z: -50}, Heading: 30}
+	s := hexapod.State{
+		Commands:  hexapod.Commands{Target: p, Speed: hexapod.MaxSpeed},
+		Estimates: hexapod.Estimates{Pose: p},
+	}
+
+	tripod, _ := s.LookupGait(gait.Tripod)
+	s.SetGait(tripod)
+	s.Target.Position.Z += 50
+	s.Target.Position.Y = 120
+	return s
+}
+
+const arm = hexapod.ButtonCircle | hexapod.ButtonSquare
+
+// setup returns a safe mode component with the safe config, and a function to
+// tick it at the given time (in ms), with the given buttons held.
+func setup(t *testing.T) (*SafeMode, *hexapod.State, func(ms int, buttons uint16)) {
+	s := New(config.Default().Safe().Controller)
+	assert.NoError(t, s.Boot())
+
+	state := standing()
+	tick := func(ms int, buttons uint16) {
+		state.Input.Buttons = buttons
+		assert.NoError(t, s.Tick(t0.Add(time.Duration(ms)*time.Millisecond), &state))
+	}
+
+	return s, &state, tick
+}
+
+func TestRestrictions(t *testing.T) {
+	_, state, tick := setup(t)
+
+	// Without safe mode, nothing is restricted.
+	g, _ := state.ActiveGait()
+	assert.Equal(t, gait.Tripod, g.Name)
+	assert.False(t, state.SafeMode)
+
+	tick(0, 0)
+	assert.True(t, state.SafeMode)
+	assert.False(t, state.Armed)
+	assert.Equal(t, hexapod.MinSpeed, state.Speed)
+	g, _ = state.ActiveGait()
+	assert.Equal(t, gait.Wave, g.Name)
+	assert.Equal(t, hexapod.DefaultGaits.Index(gait.Wave), state.GaitIndex)
+	assert.Equal(t, float64(config.SafeMaxClearance), state.Target.Position.Y)
+
+	// They're applied on every tick, whatever the others set.
+	state.Speed = 3
+	state.Target.Position.Y = 10
+	ripple, _ := state.LookupGait(gait.Ripple)
+	state.SetGait(ripple)
+	tick(20, 0)
+	assert.Equal(t, hexapod.MinSpeed, state.Speed)
+	g, _ = state.ActiveGait()
+	assert.Equal(t, gait.Wave, g.Name)
+	assert.Equal(t, float64(config.SafeMinClearance), state.Target.Position.Y)
+}
+
+func TestHaltedUntilArmed(t *testing.T) {
+	_, state, tick := setup(t)
+
+	// It stands where it is, at the (clamped) clearance.
+	tick(0, 0)
+	assert.True(t, state.Halt)
+	assert.Equal(t, state.Pose.Position.X, state.Target.Position.X)
+	assert.Equal(t, state.Pose.Position.Z, state.Target.Position.Z)
+	assert.Equal(t, float64(config.SafeMaxClearance), state.Target.Position.Y)
+
+	// Letting go before it's held for long enough doesn't arm it, and nor
+	// does holding select too, or only one of the buttons.
+	tick(100, arm)
+	tick(1500, arm)
+	tick(1600, 0)
+	tick(2500, arm)
+	tick(4000, arm|hexapod.ButtonSelect)
+	tick(4600, hexapod.ButtonCircle)
+	tick(7000, hexapod.ButtonCircle)
+	assert.True(t, state.Halt)
+	assert.False(t, state.Armed)
+	assert.Empty(t, state.Published())
+
+	// Holding both for long enough arms it.
+	tick(8000, arm)
+	tick(9000, arm)
+	assert.True(t, state.Halt)
+	tick(10000, arm)
+	assert.False(t, state.Halt)
+	assert.True(t, state.Armed)
+	if ev := state.Published(); assert.Len(t, ev, 1) {
+		assert.Equal(t, hexapod.EventArmed, ev[0].Name)
+	}
+
+	// Then it can walk (still restricted), and halts are left alone.
+	state.Target.Position.Z = state.Pose.Position.Z + 50
+	tick(10100, 0)
+	assert.False(t, state.Halt)
+	assert.Equal(t, state.Pose.Position.Z+50, state.Target.Position.Z)
+	assert.Equal(t, hexapod.MinSpeed, state.Speed)
+
+	state.Halt = true
+	tick(10200, arm)
+	tick(13000, arm)
+	assert.True(t, state.Halt)
+	assert.True(t, state.Armed)
+}
+
+func TestNotArmedWhileShuttingDown(t *testing.T) {
+	_, state, tick := setup(t)
+	state.Shutdown = true
+	tick(0, arm)
+	tick(3000, arm)
+	assert.False(t, state.Armed)
+}
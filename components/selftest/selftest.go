@@ -0,0 +1,534 @@
+// Package selftest checks each subsystem of the hex while it's parked, and
+// reports which passed: the servos, the battery, the controller link, the IMU,
+// and whether each joint actually moves where it's told to.
+package selftest
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/adammck/hexapod"
+	"github.com/adammck/hexapod/components/joints"
+	"github.com/adammck/hexapod/components/voltage"
+	"github.com/adammck/hexapod/config"
+	"github.com/adammck/hexapod/math3d"
+	"github.com/adammck/hexapod/units"
+)
+
+var log = hexapod.NewLog("selftest")
+
+const (
+
+	// The maximum clearance (in mm) at which the hex counts as parked, and so
+	// the self-test can be started.
+	maxParkedClearance = 1.0
+
+	// The range (in g) which the magnitude of the acceleration must be within
+	// for the IMU to count as sane. The hex is parked, so it's only gravity.
+	minGravity = 0.8
+	maxGravity = 1.2
+)
+
+// IMU is the part of an inertial measurement unit which the self-test needs.
+type IMU interface {
+
+	// Acceleration returns the acceleration (in g) along each axis, which is
+	// just gravity while the hex is still.
+	Acceleration() (math3d.Vector3, error)
+}
+
+type phase int
+
+const (
+	idle phase = iota
+	pinging
+	checking
+	moving
+)
+
+// saved is the settings of a servo which the self-test changes, to be restored
+// afterwards.
+type saved struct {
+	speed  int
+	torque int
+}
+
+// SelfTest is a component which runs the self-test when State.StartSelfTest
+// asks it to (or at boot, if AtBoot is set), and records the results in
+// State.SelfTest.
+//
+// It only starts while parked. Each check is spread over as many ticks as it
+// takes, so the loop keeps running: every servo is pinged, one per tick; then
+// the battery, the controller link and the IMU are checked; and then each
+// joint is moved a few degrees, slowly and at reduced torque, one at a time,
+// to check that its position feedback follows. The legs leave the servos alone
+// meanwhile (see State.SelfTesting).
+//
+// Once it's finished, the results are logged and an event is published, which
+// the LEDs and buzzer show. A failure is only fatal if the legs can't be
+// trusted, i.e. the servos or joints failed, in which case a shutdown is
+// requested. Shutting down mid-way aborts, putting the joint which is being
+// tested back where it was.
+//
+// This must be added before the legs (and after the calibration), for the same
+// reasons as the calibration.
+type SelfTest struct {
+	legs   []joints.Leg
+	cfg    config.SelfTest
+	safety config.Safety
+
+	// The battery to read the voltage of, a func which returns when the
+	// controller was last read, and the IMU. Any which are nil are skipped.
+	Voltage voltage.HasVoltage
+	Link    func() time.Time
+	IMU     IMU
+
+	// Run the self-test on the first tick, as if asked to.
+	AtBoot bool
+
+	// Whether the first tick has happened yet, for AtBoot.
+	ticked bool
+
+	phase   phase
+	results hexapod.SelfTest
+
+	// The index of the servo being pinged, or the joint being moved, counting
+	// from the first joint of the first leg. Failures are counted as we go.
+	next     int
+	failures int
+
+	// The settings to restore to the servos of the leg being tested, the
+	// position which the joint being tested started at and was moved to, and
+	// when to check it. Out is true until it's been checked, and moved back.
+	saved  [4]saved
+	nsaved int
+	start  int
+	goal   int
+	out    bool
+	until  time.Time
+}
+
+// New creates a self-test component for the given legs. The safety config is
+// used to tell whether the battery is charged.
+func New(ls []joints.Leg, cfg config.SelfTest, safety config.Safety) *SelfTest {
+	return &SelfTest{
+		legs:   ls,
+		cfg:    cfg,
+		safety: safety,
+	}
+}
+
+func (t *SelfTest) Boot() error {
+	return nil
+}
+
+func (t *SelfTest) Tick(now time.Time, state *hexapod.State) error {
+	req := state.StartSelfTest
+	state.StartSelfTest = false
+
+	if !t.ticked {
+		t.ticked = true
+		req = req || t.AtBoot
+	}
+
+	if t.phase == idle {
+		if req {
+			t.begin(state)
+		}
+
+		return nil
+	}
+
+	if state.Shutdown {
+		log.Warn("shutting down, aborting self-test")
+		t.abort()
+		t.phase = idle
+		state.SelfTesting = false
+		return nil
+	}
+
+	switch t.phase {
+	case pinging:
+		t.ping()
+
+	case checking:
+		t.check(now)
+
+	case moving:
+		t.move(now)
+	}
+
+	state.SelfTest = t.results
+
+	if t.phase == idle {
+		t.finish(state)
+	}
+
+	return nil
+}
+
+func (t *SelfTest) begin(state *hexapod.State) {
+	if state.Shutdown {
+		return
+	}
+
+	if state.Calibrating {
+		log.Warn("can't self-test while calibrating")
+		return
+	}
+
+	if state.Pose.Position.Y > maxParkedClearance {
+		log.Warnf("can't self-test unless parked (clearance=%s)", units.MM(state.Pose.Position.Y))
+		return
+	}
+
+	log.Info("starting self-test")
+	t.results = hexapod.SelfTest{}
+	t.phase = pinging
+	t.next = 0
+	t.failures = 0
+
+	state.SelfTesting = true
+	state.SelfTest = t.results
+}
+
+// ping pings the next servo, and moves on to the other checks after the last.
+func (t *SelfTest) ping() {
+	name, s := joints.Joint(t.legs, t.next)
+	err := s.Ping()
+	if err != nil {
+		log.Warnf("%s (while pinging %s)", err, name)
+		t.failures += 1
+	}
+
+	t.next += 1
+	if t.next < joints.Count(t.legs) {
+		return
+	}
+
+	t.results.Servos = result(t.failures == 0)
+	t.phase = checking
+}
+
+// check checks the battery, the controller link, and the IMU, which are each
+// quick, and then starts moving the joints, unless the servos failed, since
+// that would only fail noisily too.
+func (t *SelfTest) check(now time.Time) {
+	t.results.Battery = t.checkBattery()
+	t.results.Controller = t.checkLink(now)
+	t.results.IMU = t.checkIMU()
+
+	if t.results.Servos != hexapod.SelfTestPassed {
+		t.results.Joints = hexapod.SelfTestSkipped
+		t.phase = idle
+		return
+	}
+
+	t.phase = moving
+	t.next = 0
+	t.failures = 0
+	t.startJoint(now)
+}
+
+func (t *SelfTest) checkBattery() hexapod.SelfTestResult {
+	if t.Voltage == nil {
+		return hexapod.SelfTestSkipped
+	}
+
+	v, err := t.Voltage.Voltage()
+	if err != nil {
+		log.Warnf("%s (while reading voltage)", err)
+		return hexapod.SelfTestFailed
+	}
+
+	if v < t.safety.MinVoltage {
+		log.Warnf("battery is at %0.1fV, below %0.1fV", v, t.safety.MinVoltage)
+		return hexapod.SelfTestFailed
+	}
+
+	log.Infof("battery is at %0.1fV", v)
+	return hexapod.SelfTestPassed
+}
+
+func (t *SelfTest) checkLink(now time.Time) hexapod.SelfTestResult {
+	if t.Link == nil {
+		return hexapod.SelfTestSkipped
+	}
+
+	last := t.Link()
+	if last.IsZero() {
+		log.Warn("controller has never been read")
+		return hexapod.SelfTestFailed
+	}
+
+	if d := now.Sub(last); d > t.cfg.LinkTimeout.Duration {
+		log.Warnf("controller was last read %s ago", d.Round(time.Millisecond))
+		return hexapod.SelfTestFailed
+	}
+
+	return hexapod.SelfTestPassed
+}
+
+func (t *SelfTest) checkIMU() hexapod.SelfTestResult {
+	if t.IMU == nil {
+		return hexapod.SelfTestSkipped
+	}
+
+	a, err := t.IMU.Acceleration()
+	if err != nil {
+		log.Warnf("%s (while reading IMU)", err)
+		return hexapod.SelfTestFailed
+	}
+
+	g := a.Magnitude()
+	if math.IsNaN(g) || g < minGravity || g > maxGravity {
+		log.Warnf("IMU reads %0.2fg, which isn't gravity", g)
+		return hexapod.SelfTestFailed
+	}
+
+	return hexapod.SelfTestPassed
+}
+
+// move checks on the joint being tested, once it's had time to settle, and
+// then moves it back, or moves on to the next one.
+func (t *SelfTest) move(now time.Time) {
+	if now.Before(t.until) {
+		return
+	}
+
+	name, s := joints.Joint(t.legs, t.next)
+	if t.out {
+		t.out = false
+		t.until = now.Add(t.cfg.Settle.Duration)
+
+		err := t.checkJoint(s)
+		if err != nil {
+			log.Warnf("%s (while testing %s)", err, name)
+			t.failures += 1
+		}
+
+		err = s.SetGoalPosition(t.start)
+		if err != nil {
+			log.Warnf("%s (while moving %s back)", err, name)
+		}
+
+		return
+	}
+
+	t.finishJoint()
+	t.next += 1
+	t.startJoint(now)
+}
+
+// startJoint starts testing the next joint, reducing the speed and torque of
+// its leg first if it's the first joint of that leg. Joints which can't be
+// moved are failed, and skipped. After the last joint, the test is finished.
+func (t *SelfTest) startJoint(now time.Time) {
+	for ; t.next < joints.Count(t.legs); t.next += 1 {
+		l := t.legs[t.next/len(joints.Names)]
+
+		if t.next%len(joints.Names) == 0 {
+			err := t.reduce(l)
+			if err != nil {
+				log.Warnf("%s (while slowing %s), skipping it", err, l.Name)
+				t.failures += len(joints.Names)
+				t.restore(l)
+				t.next += len(joints.Names) - 1
+				continue
+			}
+		}
+
+		name, s := joints.Joint(t.legs, t.next)
+		err := t.moveJoint(s)
+		if err != nil {
+			log.Warnf("%s (while moving %s)", err, name)
+			t.failures += 1
+			t.finishJoint()
+			continue
+		}
+
+		s.SetLED(true)
+		t.out = true
+		t.until = now.Add(t.cfg.Settle.Duration)
+		return
+	}
+
+	t.results.Joints = result(t.failures == 0)
+	t.phase = idle
+}
+
+// finishJoint turns off the LED of the joint which was being tested, and
+// restores the speed and torque of its leg if it was the last joint of it.
+func (t *SelfTest) finishJoint() {
+	_, s := joints.Joint(t.legs, t.next)
+	s.SetLED(false)
+
+	if t.next%len(joints.Names) == len(joints.Names)-1 {
+		t.restore(t.legs[t.next/len(joints.Names)])
+	}
+}
+
+// moveJoint moves the servo a few degrees from where it is now, in whichever
+// direction stays within its angle limits.
+func (t *SelfTest) moveJoint(s joints.Servo) error {
+	p, err := s.PresentPosition()
+	if err != nil {
+		return err
+	}
+
+	cw, err := s.CWAngleLimit()
+	if err != nil {
+		return err
+	}
+
+	ccw, err := s.CCWAngleLimit()
+	if err != nil {
+		return err
+	}
+
+	d := int(math.Round(t.cfg.JointDelta / joints.DegreesPerUnit))
+	g := p + d
+	if g > ccw {
+		g = p - d
+	}
+	if g < cw {
+		return fmt.Errorf("no room to move within limits (%d-%d) from %d", cw, ccw, p)
+	}
+
+	t.start, t.goal = p, g
+	return s.SetGoalPosition(g)
+}
+
+// checkJoint returns an error if the servo isn't close enough to the goal.
+func (t *SelfTest) checkJoint(s joints.Servo) error {
+	p, err := s.PresentPosition()
+	if err != nil {
+		return err
+	}
+
+	off := math.Abs(float64(p-t.goal)) * joints.DegreesPerUnit
+	if off > t.cfg.JointTolerance {
+		return fmt.Errorf("position didn't track: moved from %d to %d, but wanted %d (%0.1f degrees off)", t.start, p, t.goal, off)
+	}
+
+	return nil
+}
+
+// reduce saves the speed and torque limit of each servo in the leg, and then
+// sets them to the configured (low) values.
+func (t *SelfTest) reduce(l joints.Leg) error {
+	for i, s := range l.Servos {
+		var err error
+		t.saved[i].speed, err = s.MovingSpeed()
+		if err != nil {
+			return err
+		}
+
+		t.saved[i].torque, err = s.TorqueLimit()
+		if err != nil {
+			return err
+		}
+
+		t.nsaved = i + 1
+	}
+
+	for _, s := range l.Servos {
+		err := s.SetMovingSpeed(t.cfg.MoveSpeed)
+		if err != nil {
+			return err
+		}
+
+		err = s.SetTorqueLimit(t.cfg.TorqueLimit)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// restore sets the speed and torque limit of each servo in the leg back to what
+// they were saved as. Servos which weren't saved yet are left as they are.
+func (t *SelfTest) restore(l joints.Leg) {
+	for i, s := range l.Servos[:t.nsaved] {
+		err := s.SetMovingSpeed(t.saved[i].speed)
+		if err == nil {
+			err = s.SetTorqueLimit(t.saved[i].torque)
+		}
+		if err != nil {
+			log.Warnf("%s (while restoring %s.%s)", err, l.Name, joints.Names[i])
+		}
+	}
+
+	t.nsaved = 0
+}
+
+// abort puts the joint being tested back where it was, and restores its leg.
+func (t *SelfTest) abort() {
+	if t.phase != moving || t.next >= joints.Count(t.legs) {
+		return
+	}
+
+	name, s := joints.Joint(t.legs, t.next)
+	if t.out {
+		err := s.SetGoalPosition(t.start)
+		if err != nil {
+			log.Warnf("%s (while moving %s back)", err, name)
+		}
+	}
+
+	s.SetLED(false)
+	t.restore(t.legs[t.next/len(joints.Names)])
+}
+
+// finish reports the results, and requests a shutdown if the legs failed.
+func (t *SelfTest) finish(state *hexapod.State) {
+	state.SelfTesting = false
+
+	r := t.results
+	for _, s := range []struct {
+		name   string
+		result hexapod.SelfTestResult
+	}{
+		{"servos", r.Servos},
+		{"battery", r.Battery},
+		{"controller", r.Controller},
+		{"imu", r.IMU},
+		{"joints", r.Joints},
+	} {
+		if s.result == hexapod.SelfTestFailed {
+			log.Warnf("%s: %s", s.name, s.result)
+		} else {
+			log.Infof("%s: %s", s.name, s.result)
+		}
+	}
+
+	failed := r.Failed()
+	if len(failed) == 0 {
+		log.Info("self-test passed")
+		state.Publish(hexapod.EventSelfTestPassed, hexapod.Info, r)
+		return
+	}
+
+	legs := r.Servos == hexapod.SelfTestFailed || r.Joints == hexapod.SelfTestFailed
+	if !legs {
+		log.Warnf("self-test failed: %s", strings.Join(failed, ", "))
+		state.Publish(hexapod.EventSelfTestFailed, hexapod.Warning, failed)
+		return
+	}
+
+	log.Errorf("self-test failed: %s; the legs can't be trusted, shutting down", strings.Join(failed, ", "))
+	state.Publish(hexapod.EventSelfTestFailed, hexapod.Critical, failed)
+	state.Shutdown = true
+	state.Publish(hexapod.EventShutdownRequested, hexapod.Warning, "self-test failed")
+}
+
+func result(ok bool) hexapod.SelfTestResult {
+	if ok {
+		return hexapod.SelfTestPassed
+	}
+
+	return hexapod.SelfTestFailed
+}
@@ -0,0 +1,339 @@
+package selftest
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/adammck/hexapod"
+	"github.com/adammck/hexapod/components/joints"
+	"github.com/adammck/hexapod/config"
+	"github.com/adammck/hexapod/hexapodtest"
+	"github.com/adammck/hexapod/math3d"
+	"github.com/stretchr/testify/assert"
+)
+
+// mockServo moves to its goal straight away, unless it's stuck.
+type mockServo struct {
+	pos     int
+	goals   []int
+	speed   int
+	torque  int
+	led     bool
+	stuck   bool
+	pingErr error
+}
+
+func (s *mockServo) Ping() error {
+	return s.pingErr
+}
+
+func (s *mockServo) PresentPosition() (int, error) {
+	return s.pos, nil
+}
+
+func (s *mockServo) SetGoalPosition(pos int) error {
+	s.goals = append(s.goals, pos)
+	if !s.stuck {
+		s.pos = pos
+	}
+	return nil
+}
+
+func (s *mockServo) CWAngleLimit() (int, error) {
+	return 0, nil
+}
+
+func (s *mockServo) CCWAngleLimit() (int, error) {
+	return 1023, nil
+}
+
+func (s *mockServo) MovingSpeed() (int, error) {
+	return s.speed, nil
+}
+
+func (s *mockServo) SetMovingSpeed(speed int) error {
+	s.speed = speed
+	return nil
+}
+
+func (s *mockServo) TorqueLimit() (int, error) {
+	return s.torque, nil
+}
+
+func (s *mockServo) SetTorqueLimit(val int) error {
+	s.torque = val
+	return nil
+}
+
+func (s *mockServo) SetLED(state bool) error {
+	s.led = state
+	return nil
+}
+
+func (s *mockServo) SetTorqueEnable(state bool) error {
+	return nil
+}
+
+func (s *mockServo) Position(angle float64) (int, error) {
+	return 512 + int(math.Round(angle/joints.DegreesPerUnit)), nil
+}
+
+type mockVoltage struct {
+	v   float64
+	err error
+}
+
+func (m *mockVoltage) Voltage() (float64, error) {
+	return m.v, m.err
+}
+
+type mockIMU struct {
+	a   math3d.Vector3
+	err error
+}
+
+func (m *mockIMU) Acceleration() (math3d.Vector3, error) {
+	return m.a, m.err
+}
+
+type fixture struct {
+	*hexapodtest.Clock
+	st     *SelfTest
+	servos [][4]*mockServo
+	volts  *mockVoltage
+	imu    *mockIMU
+}
+
+func setup(t *testing.T) *fixture {
+	f := &fixture{
+		Clock: hexapodtest.NewClock(t, time.Second/60),
+		volts: &mockVoltage{v: 12},
+		imu:   &mockIMU{a: math3d.Vector3{Y: -1}},
+	}
+
+	var ls []joints.Leg
+	for _, name := range []string{"FL", "FR"} {
+		var ms [4]*mockServo
+		var ss [4]joints.Servo
+		for i := range ms {
+			ms[i] = &mockServo{pos: 512, speed: 1023, torque: 1023}
+			ss[i] = ms[i]
+		}
+
+		f.servos = append(f.servos, ms)
+		ls = append(ls, joints.Leg{Name: name, Servos: ss})
+	}
+
+	f.st = New(ls, config.Default().SelfTest, config.Default().Safety)
+	f.st.Voltage = f.volts
+	f.st.IMU = f.imu
+	f.st.Link = func() time.Time { return f.Now }
+	assert.NoError(t, f.st.Boot())
+	return f
+}
+
+func (f *fixture) tick() {
+	f.Tick(f.st)
+}
+
+// run starts the self-test, and ticks until it finishes, or a minute passes.
+func (f *fixture) run() []string {
+	f.State.StartSelfTest = true
+	f.tick()
+	assert.True(f.T, f.State.SelfTesting)
+
+	for i := 0; i < 3600 && f.State.SelfTesting; i++ {
+		f.tick()
+	}
+	assert.False(f.T, f.State.SelfTesting)
+	return f.Events()
+}
+
+func TestPasses(t *testing.T) {
+	f := setup(t)
+	events := f.run()
+
+	assert.Equal(t, hexapod.SelfTest{
+		Servos:     hexapod.SelfTestPassed,
+		Battery:    hexapod.SelfTestPassed,
+		Controller: hexapod.SelfTestPassed,
+		IMU:        hexapod.SelfTestPassed,
+		Joints:     hexapod.SelfTestPassed,
+	}, f.State.SelfTest)
+	assert.Equal(t, []string{hexapod.EventSelfTestPassed}, events)
+	assert.False(t, f.State.Shutdown)
+
+	// Each joint was moved 5 degrees and back, and left as it was.
+	for _, ms := range f.servos {
+		for _, s := range ms {
+			assert.Equal(t, []int{529, 512}, s.goals)
+			assert.Equal(t, 1023, s.speed)
+			assert.Equal(t, 1023, s.torque)
+			assert.False(t, s.led)
+		}
+	}
+}
+
+func TestMovesSlowlyOneJointAtATime(t *testing.T) {
+	f := setup(t)
+	f.State.StartSelfTest = true
+	for i := 0; i < 10; i++ {
+		f.tick()
+	}
+
+	// Only the first joint of the first leg has moved, slowly.
+	fl := f.servos[0]
+	assert.Equal(t, []int{529}, fl[0].goals)
+	assert.True(t, fl[0].led)
+	assert.Empty(t, fl[1].goals)
+	assert.Empty(t, f.servos[1][0].goals)
+	assert.Equal(t, 64, fl[0].speed)
+	assert.Equal(t, 256, fl[3].torque)
+	assert.Equal(t, 1023, f.servos[1][0].torque)
+}
+
+func TestMovesAwayFromLimits(t *testing.T) {
+	f := setup(t)
+	f.servos[1][2].pos = 1020
+	f.run()
+
+	assert.Equal(t, []int{1003, 1020}, f.servos[1][2].goals)
+	assert.Equal(t, hexapod.SelfTestPassed, f.State.SelfTest.Joints)
+}
+
+func TestStuckJointFails(t *testing.T) {
+	f := setup(t)
+	f.servos[1][1].stuck = true
+	events := f.run()
+
+	assert.Equal(t, hexapod.SelfTestFailed, f.State.SelfTest.Joints)
+	assert.Equal(t, []string{hexapod.EventSelfTestFailed, hexapod.EventShutdownRequested}, events)
+	assert.True(t, f.State.Shutdown)
+
+	// The rest were still tested.
+	assert.Equal(t, []int{529, 512}, f.servos[1][3].goals)
+}
+
+func TestPingFailureSkipsJoints(t *testing.T) {
+	f := setup(t)
+	f.servos[0][2].pingErr = errors.New("timeout")
+	f.run()
+
+	assert.Equal(t, hexapod.SelfTestFailed, f.State.SelfTest.Servos)
+	assert.Equal(t, hexapod.SelfTestSkipped, f.State.SelfTest.Joints)
+	assert.Empty(t, f.servos[0][0].goals)
+	assert.True(t, f.State.Shutdown)
+}
+
+func TestOtherFailuresAreNotFatal(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		setup func(f *fixture)
+		want  hexapod.SelfTest
+	}{
+		{
+			name:  "low battery",
+			setup: func(f *fixture) { f.volts.v = 9.4 },
+			want:  hexapod.SelfTest{Battery: hexapod.SelfTestFailed},
+		},
+		{
+			name:  "battery can't be read",
+			setup: func(f *fixture) { f.volts.err = errors.New("timeout") },
+			want:  hexapod.SelfTest{Battery: hexapod.SelfTestFailed},
+		},
+		{
+			name:  "controller link is stale",
+			setup: func(f *fixture) { f.st.Link = func() time.Time { return f.Now.Add(-2 * time.Second) } },
+			want:  hexapod.SelfTest{Controller: hexapod.SelfTestFailed},
+		},
+		{
+			name:  "controller was never read",
+			setup: func(f *fixture) { f.st.Link = func() time.Time { return time.Time{} } },
+			want:  hexapod.SelfTest{Controller: hexapod.SelfTestFailed},
+		},
+		{
+			name:  "IMU reads nothing",
+			setup: func(f *fixture) { f.imu.a = math3d.Vector3{} },
+			want:  hexapod.SelfTest{IMU: hexapod.SelfTestFailed},
+		},
+		{
+			name:  "IMU can't be read",
+			setup: func(f *fixture) { f.imu.err = errors.New("i2c") },
+			want:  hexapod.SelfTest{IMU: hexapod.SelfTestFailed},
+		},
+		{
+			name: "nothing to check",
+			setup: func(f *fixture) {
+				f.st.Voltage = nil
+				f.st.Link = nil
+				f.st.IMU = nil
+			},
+			want: hexapod.SelfTest{
+				Battery:    hexapod.SelfTestSkipped,
+				Controller: hexapod.SelfTestSkipped,
+				IMU:        hexapod.SelfTestSkipped,
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			f := setup(t)
+			tc.setup(f)
+			events := f.run()
+
+			// Whatever isn't expected to fail (or be skipped) passes.
+			want := tc.want
+			for _, r := range []*hexapod.SelfTestResult{&want.Servos, &want.Battery, &want.Controller, &want.IMU, &want.Joints} {
+				if *r == hexapod.SelfTestNotRun {
+					*r = hexapod.SelfTestPassed
+				}
+			}
+
+			assert.Equal(t, want, f.State.SelfTest)
+			assert.False(t, f.State.Shutdown)
+
+			if len(want.Failed()) > 0 {
+				assert.Equal(t, []string{hexapod.EventSelfTestFailed}, events)
+			} else {
+				assert.Equal(t, []string{hexapod.EventSelfTestPassed}, events)
+			}
+		})
+	}
+}
+
+func TestOnlyWhileParked(t *testing.T) {
+	f := setup(t)
+	f.State.Pose.Position.Y = 40
+	f.State.StartSelfTest = true
+	f.tick()
+
+	assert.False(t, f.State.SelfTesting)
+	assert.False(t, f.State.StartSelfTest)
+}
+
+func TestAtBoot(t *testing.T) {
+	f := setup(t)
+	f.st.AtBoot = true
+	f.tick()
+	assert.True(t, f.State.SelfTesting)
+}
+
+func TestShutdownAborts(t *testing.T) {
+	f := setup(t)
+	f.State.StartSelfTest = true
+	for i := 0; i < 10; i++ {
+		f.tick()
+	}
+
+	f.State.Shutdown = true
+	f.tick()
+	assert.False(t, f.State.SelfTesting)
+
+	// The joint which was out is moved back, and its leg restored.
+	fl := f.servos[0]
+	assert.Equal(t, []int{529, 512}, fl[0].goals)
+	assert.False(t, fl[0].led)
+	assert.Equal(t, 1023, fl[0].speed)
+	assert.Equal(t, 1023, fl[3].torque)
+}
@@ -0,0 +1,265 @@
+// Package session keeps totals for the whole run (how far the hex walked, how
+// often it was stopped, etc), and writes a summary of them when it finishes.
+package session
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/adammck/hexapod"
+	"github.com/adammck/hexapod/components/odometer"
+	"github.com/adammck/hexapod/components/voltage"
+	"github.com/adammck/hexapod/config"
+	"github.com/adammck/hexapod/math3d"
+)
+
+var log = hexapod.NewLog("session")
+
+// How far (in mm, or degrees) the pose has to move during a tick for the hex to
+// count as walking, rather than parked, so float noise doesn't count.
+const (
+	minMove = 0.01
+	minTurn = 0.01
+)
+
+// Summary is the totals for a session, from boot until End.
+type Summary struct {
+
+	// The ID of the robot (see State.Identity), which is also in the name of
+	// the file.
+	Robot string `json:"robot"`
+
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+
+	// How far (in mm) the hex walked, according to the pose, over the ground.
+	// Turning on the spot doesn't count.
+	Distance float64 `json:"distance"`
+
+	// How long (in seconds) the hex spent walking (or turning), and standing
+	// still.
+	Walking float64 `json:"walking"`
+	Parked  float64 `json:"parked"`
+
+	// The first and last voltage readings, and roughly how much of the
+	// battery (from zero to 100) was used between them. There's nothing to
+	// measure the current, so this is only from the voltage drop.
+	StartVoltage float64 `json:"start_voltage"`
+	EndVoltage   float64 `json:"end_voltage"`
+	BatteryUsed  int     `json:"battery_used"`
+
+	// Roughly how much charge (in mAh) was drawn from the battery, according
+	// to the power estimate (see State.Power), or zero if there isn't one.
+	Charge float64 `json:"charge"`
+
+	// The hottest (in degrees C) that the servo and the CPU got, or zero if
+	// they can't be read.
+	MaxServoTemperature float64 `json:"max_servo_temperature"`
+	MaxCPUTemperature   float64 `json:"max_cpu_temperature"`
+
+	// The number of times that a leg couldn't reach its goal (see
+	// State.Saturated), counting each leg separately, and once until it can
+	// reach again.
+	Saturations int `json:"saturations"`
+
+	// The number of errors sending to the servos, and the number of times
+	// that the hex was halted (see State.Halt).
+	BusErrors int64 `json:"bus_errors"`
+	Estops    int   `json:"estops"`
+
+	// How long (in seconds) the legs spent at each stiffness preset (see
+	// State.Stiffness), by name. The one at the end is in the state.
+	Stiffness map[string]float64 `json:"stiffness"`
+
+	// The state as of the end, in the same format as the telemetry and the
+	// API, so it can be read by the same tools.
+	State hexapod.Snapshot `json:"state"`
+
+	// The lifetime counters (including this session) as of the summary, or nil
+	// if there's no odometer.
+	Odometer *odometer.Counters `json:"odometer,omitempty"`
+}
+
+// Session is a component which accumulates a Summary every tick, from the
+// state and the loop stats. The summary is written (to the log, and as JSON to
+// a file in the dump dir) by Emit, which is called when shutting down, or via
+// the API, or when the flight recorder is dumped, so the totals survive a
+// panic.
+//
+// This has to come before the flight recorder, which clears State.Dump.
+type Session struct {
+	dir    string
+	safety config.Safety
+	stats  func() hexapod.LoopStats
+
+	// The odometer to include the lifetime counters of, or nil if there isn't
+	// one.
+	Odometer *odometer.Odometer
+
+	// The totals so far. Emit is called from other goroutines, so they're
+	// locked.
+	mu  sync.Mutex
+	sum Summary
+
+	// The state as of the last tick. Only touched by Tick.
+	last      time.Time
+	pose      math3d.Pose
+	halt      bool
+	saturated [6]bool
+}
+
+// New creates a session component which writes summaries to the given dir. The
+// loop stats (which are usually Hexapod.LoopStats) are read for the bus
+// errors, and the safety config is used to estimate the battery used.
+func New(dir string, stats func() hexapod.LoopStats, safety config.Safety) *Session {
+	return &Session{
+		dir:    dir,
+		stats:  stats,
+		safety: safety,
+	}
+}
+
+func (s *Session) Boot() error {
+	return nil
+}
+
+func (s *Session) Tick(now time.Time, state *hexapod.State) error {
+	s.mu.Lock()
+	s.update(now, state)
+	s.mu.Unlock()
+
+	if state.Dump {
+		_, err := s.Emit("flight recorder dump")
+		if err != nil {
+			log.Errorf("%s (while writing session summary)", err)
+		}
+	}
+
+	return nil
+}
+
+// update adds the last tick to the totals. The lock must be held.
+func (s *Session) update(now time.Time, state *hexapod.State) {
+	sum := &s.sum
+	p := state.Pose
+
+	if s.last.IsZero() {
+		sum.Robot = state.Identity.ID
+		sum.Start = now
+	} else {
+		dt := now.Sub(s.last).Seconds()
+		d := math.Hypot(p.Position.X-s.pose.Position.X, p.Position.Z-s.pose.Position.Z)
+		turn := math.Abs(math3d.AngleDiff(s.pose.Heading, p.Heading))
+
+		sum.Distance += d
+		if d > minMove || turn > minTurn {
+			sum.Walking += dt
+		} else {
+			sum.Parked += dt
+		}
+
+		if st := state.Stiffness; st != "" {
+			if sum.Stiffness == nil {
+				sum.Stiffness = map[string]float64{}
+			}
+			sum.Stiffness[st] += dt
+		}
+	}
+
+	if state.Halt && !s.halt {
+		sum.Estops++
+	}
+
+	for i, sat := range state.Saturated {
+		if sat && !s.saturated[i] {
+			sum.Saturations++
+		}
+	}
+
+	if v := state.Voltage; v > 0 {
+		if sum.StartVoltage == 0 {
+			sum.StartVoltage = v
+		}
+		sum.EndVoltage = v
+		sum.BatteryUsed = voltage.Percent(sum.StartVoltage, s.safety) - voltage.Percent(v, s.safety)
+	}
+
+	sum.Charge = state.Power.Charge
+	sum.MaxServoTemperature = math.Max(sum.MaxServoTemperature, state.ServoTemperature)
+	sum.MaxCPUTemperature = math.Max(sum.MaxCPUTemperature, state.System.Temperature)
+
+	if s.stats != nil {
+		sum.BusErrors = s.stats().BusErrors
+	}
+
+	sum.End = now
+	sum.State = state.Snapshot(now)
+	s.last = now
+	s.pose = p
+	s.halt = state.Halt
+	s.saturated = state.Saturated
+}
+
+// Summary returns the totals so far. Unlike most methods of components, this is
+// safe to call from any goroutine.
+func (s *Session) Summary() Summary {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// The map is copied, since the next tick would change it underneath.
+	sum := s.sum
+	if s.sum.Stiffness != nil {
+		sum.Stiffness = make(map[string]float64, len(s.sum.Stiffness))
+		for k, v := range s.sum.Stiffness {
+			sum.Stiffness[k] = v
+		}
+	}
+
+	if s.Odometer != nil {
+		c := s.Odometer.Counters()
+		sum.Odometer = &c
+	}
+
+	return sum
+}
+
+// Emit logs the totals so far, and writes them to a timestamped JSON file in
+// the dump dir, and returns its path. The reason is only logged. This is safe
+// to call from any goroutine.
+func (s *Session) Emit(reason string) (string, error) {
+	sum := s.Summary()
+
+	log.Infof("session summary (%s): walked %.0fmm in %.0fs, parked for %.0fs, used %d%% of the battery (%.2fv to %.2fv, about %.0fmAh), max temperature %.0fC (servo) and %.0fC (CPU), %d saturations, %d bus errors, %d e-stops",
+		reason, sum.Distance, sum.Walking, sum.Parked, sum.BatteryUsed, sum.StartVoltage, sum.EndVoltage, sum.Charge,
+		sum.MaxServoTemperature, sum.MaxCPUTemperature, sum.Saturations, sum.BusErrors, sum.Estops)
+
+	b, err := json.MarshalIndent(sum, "", "  ")
+	if err != nil {
+		return "", err
+	}
+
+	name := fmt.Sprintf("session-%s.json", time.Now().Format("20060102-150405.000"))
+	if sum.Robot != "" {
+		name = fmt.Sprintf("session-%s-%s.json", sum.Robot, time.Now().Format("20060102-150405.000"))
+	}
+	path := filepath.Join(s.dir, name)
+
+	err = os.WriteFile(path, append(b, '\n'), 0644)
+	if err != nil {
+		return "", err
+	}
+
+	log.Infof("wrote session summary to %s", path)
+	return path, nil
+}
+
+// Shutdown emits the summary, once the loop has stopped.
+func (s *Session) Shutdown() error {
+	_, err := s.Emit("shutdown")
+	return err
+}
@@ -0,0 +1,166 @@
+package session
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/adammck/hexapod"
+	"github.com/adammck/hexapod/components/odometer"
+	"github.com/adammck/hexapod/config"
+	"github.com/adammck/hexapod/math3d"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScriptedSession(t *testing.T) {
+	var busErrors int64
+	stats := func() hexapod.LoopStats {
+		return hexapod.LoopStats{BusErrors: busErrors}
+	}
+
+	dir := t.TempDir()
+	s := New(dir, stats, config.Default().Safety)
+	assert.NoError(t, s.Boot())
+
+	state := &hexapod.State{}
+	start := time.Unix(1000, 0)
+	now := start
+
+	tick := func(n int, f func()) {
+		for i := 0; i < n; i++ {
+			f()
+			assert.NoError(t, s.Tick(now, state))
+			now = now.Add(100 * time.Millisecond)
+		}
+	}
+
+	// Stand still for a second, at full battery.
+	state.Voltage = 12.0
+	state.Stiffness = "normal"
+	state.ServoTemperature = 35
+	tick(10, func() {})
+
+	// Walk forwards 1m over two seconds, while a leg saturates twice, and the
+	// servos warm up.
+	i := 0
+	tick(20, func() {
+		state.Pose.Position.Z += 50
+		state.Saturated[2] = i%10 < 3
+		state.ServoTemperature += 0.5
+		i++
+	})
+
+	// Turn on the spot for a second. That counts as walking, but not as
+	// distance.
+	tick(10, func() {
+		state.Pose.Heading += 9
+	})
+
+	// Get halted twice (for more than one tick, each time), while parked, and
+	// cooling down.
+	tick(10, func() {
+		state.Halt = i%5 < 3
+		state.ServoTemperature -= 1
+		state.System.Temperature = 55
+		i++
+	})
+
+	// Walk sideways 300mm, and diagonally for 500mm, while the bus errors
+	// pile up and the battery runs down.
+	tick(10, func() {
+		state.Halt = false
+		state.Pose.Position.X += 30
+		busErrors++
+		state.Voltage = 11.2
+	})
+	// And soften up for the last second.
+	state.Stiffness = "soft"
+	tick(10, func() {
+		state.Pose.Position.X -= 30
+		state.Pose.Position.Z += 40
+		state.Voltage = 10.6
+		state.Power.Charge += 2.5
+	})
+
+	sum := s.Summary()
+	assert.Equal(t, start, sum.Start)
+	assert.Equal(t, start.Add(6900*time.Millisecond), sum.End)
+	assert.InDelta(t, 1800, sum.Distance, 0.001)
+	assert.InDelta(t, 5, sum.Walking, 0.001)
+	assert.InDelta(t, 1.9, sum.Parked, 0.001)
+	assert.Equal(t, 12.0, sum.StartVoltage)
+	assert.Equal(t, 10.6, sum.EndVoltage)
+	assert.Equal(t, 47, sum.BatteryUsed)
+	assert.Equal(t, 25.0, sum.Charge)
+	assert.Equal(t, 45.0, sum.MaxServoTemperature)
+	assert.Equal(t, 55.0, sum.MaxCPUTemperature)
+	assert.Equal(t, 2, sum.Saturations)
+	assert.Equal(t, int64(10), sum.BusErrors)
+	assert.Equal(t, 2, sum.Estops)
+	assert.InDelta(t, 5.9, sum.Stiffness["normal"], 0.001)
+	assert.InDelta(t, 1, sum.Stiffness["soft"], 0.001)
+	assert.Len(t, sum.Stiffness, 2)
+	assert.Equal(t, "soft", sum.State.Stiffness)
+	assert.Equal(t, hexapod.SnapshotVersion, sum.State.Version)
+	assert.Equal(t, sum.End, sum.State.Time)
+	assert.Equal(t, 10.6, sum.State.Voltage)
+
+	// The summary is written as JSON, which can be read back.
+	path, err := s.Emit("test")
+	assert.NoError(t, err)
+	assert.Equal(t, dir, filepath.Dir(path))
+
+	b, err := os.ReadFile(path)
+	assert.NoError(t, err)
+
+	var got Summary
+	assert.NoError(t, json.Unmarshal(b, &got))
+	assert.True(t, sum.Start.Equal(got.Start))
+	assert.True(t, sum.End.Equal(got.End))
+	assert.True(t, sum.State.Time.Equal(got.State.Time))
+	got.Start, got.End, got.State.Time = sum.Start, sum.End, sum.State.Time
+	assert.Equal(t, sum, got)
+}
+
+func TestEmitOnDump(t *testing.T) {
+	dir := t.TempDir()
+	s := New(dir, nil, config.Default().Safety)
+
+	state := &hexapod.State{Identity: hexapod.Identity{Name: "Hex Two", ID: "hex-2"}}
+	state.Pose = math3d.Pose{Position: math3d.Vector3{X: 100}}
+	assert.NoError(t, s.Tick(time.Unix(0, 0), state))
+
+	files, _ := filepath.Glob(filepath.Join(dir, "session-*.json"))
+	assert.Len(t, files, 0)
+
+	// The dump is left for the flight recorder to clear.
+	state.Dump = true
+	assert.NoError(t, s.Tick(time.Unix(1, 0), state))
+	assert.True(t, state.Dump)
+
+	// The file is named after the robot.
+	files, _ = filepath.Glob(filepath.Join(dir, "session-hex-2-*.json"))
+	assert.Len(t, files, 1)
+	assert.Equal(t, "hex-2", s.Summary().Robot)
+}
+
+func TestOdometer(t *testing.T) {
+	dir := t.TempDir()
+	s := New(dir, nil, config.Default().Safety)
+	assert.Nil(t, s.Summary().Odometer)
+
+	o := odometer.New(filepath.Join(dir, "odometer.json"), nil)
+	assert.NoError(t, o.Boot())
+	s.Odometer = o
+
+	state := &hexapod.State{}
+	state.Usage.Steps[3] = 7
+	assert.NoError(t, o.Tick(time.Unix(0, 0), state))
+	assert.NoError(t, s.Tick(time.Unix(0, 0), state))
+
+	sum := s.Summary()
+	assert.NotNil(t, sum.Odometer)
+	assert.Equal(t, int64(7), sum.Odometer.Steps[3])
+}
@@ -0,0 +1,251 @@
+package settings
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/adammck/hexapod"
+	"github.com/adammck/hexapod/params"
+)
+
+var log = hexapod.NewLog("settings")
+
+// Keys are the params which are persisted by default. This is deliberately an
+// explicit list rather than every param, since most of them are tuning which
+// should come from the config file, not linger from whatever was last tried.
+var Keys = []string{
+	"controller.clearance",
+	"hexapod.speed",
+	"power.base",
+	"power.gain",
+}
+
+// Debounce is how long to wait after the last change before saving, so that
+// e.g. holding Up doesn't write the file every tick.
+const Debounce = 2 * time.Second
+
+// Settings is a component which persists a few params to a JSON file, so they
+// survive restarts. They're loaded at Boot, and saved shortly after they stop
+// changing.
+//
+// This must be added after every component which registers one of the keys,
+// since params are registered during Boot.
+type Settings struct {
+	path     string
+	keys     []string
+	registry *params.Registry
+	debounce time.Duration
+
+	// The values as of the last tick, and as of the last save (or load). Nil
+	// until the first tick.
+	last  map[string]float64
+	saved map[string]float64
+
+	// The time at which the values last changed, or zero if they've been saved
+	// since.
+	changed time.Time
+}
+
+// New creates a settings component which persists the given keys (of params in
+// the given registry) to the given path.
+func New(path string, keys []string, r *params.Registry) *Settings {
+	return &Settings{
+		path:     path,
+		keys:     keys,
+		registry: r,
+		debounce: Debounce,
+	}
+}
+
+// Boot loads the settings file, if it exists, and queues the values to be
+// applied before the first tick. Values which are out of range (e.g. because
+// the file was edited by hand) are clamped. Invalid files are ignored, rather
+// than stopping the hexapod from booting.
+func (s *Settings) Boot() error {
+	b, err := ioutil.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		log.Infof("no settings file at %s, using defaults", s.path)
+		return nil
+	}
+	if err != nil {
+		log.Warnf("%s (while reading settings)", err)
+		return nil
+	}
+
+	var vals map[string]float64
+	err = json.Unmarshal(b, &vals)
+	if err != nil {
+		log.Warnf("ignoring corrupt settings file %s: %s", s.path, err)
+		return nil
+	}
+
+	w := s.clamp(vals)
+	err = s.registry.Set(w)
+	if err != nil {
+		log.Warnf("%s (while applying settings)", err)
+		return nil
+	}
+
+	log.Infof("loaded %d settings from %s", len(w), s.path)
+	return nil
+}
+
+// clamp returns the values for the persisted keys which are registered, clamped
+// to the range of each param, and rounded if they're integers.
+func (s *Settings) clamp(vals map[string]float64) map[string]float64 {
+	ps := map[string]params.Value{}
+	for _, v := range s.registry.Values() {
+		ps[v.Name] = v
+	}
+
+	out := map[string]float64{}
+	for _, k := range s.keys {
+		v, ok := vals[k]
+		if !ok {
+			continue
+		}
+
+		p, ok := ps[k]
+		if !ok {
+			log.Warnf("ignoring setting for unknown param: %s", k)
+			continue
+		}
+
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			log.Warnf("ignoring invalid setting: %s=%v", k, v)
+			continue
+		}
+
+		c := v
+		if p.Type == params.Int || p.Type == params.Bool {
+			c = math.Round(c)
+		}
+		c = math.Max(p.Min, math.Min(p.Max, c))
+		if c != v {
+			log.Warnf("clamped setting %s from %v to %v", k, v, c)
+		}
+
+		out[k] = c
+	}
+
+	return out
+}
+
+// Tick saves the settings if they've changed, and then not changed for a while.
+// They're also saved right away when shutting down, since there might not be
+// another chance.
+func (s *Settings) Tick(now time.Time, state *hexapod.State) error {
+	cur := s.current()
+
+	// The first tick is after the loaded values were applied, so just remember
+	// them as they are.
+	if s.last == nil {
+		s.last = cur
+		s.saved = cur
+		return nil
+	}
+
+	if !equal(cur, s.last) {
+		s.last = cur
+		s.changed = now
+	}
+
+	if s.changed.IsZero() {
+		return nil
+	}
+
+	if now.Sub(s.changed) < s.debounce && !state.Shutdown {
+		return nil
+	}
+
+	s.changed = time.Time{}
+	if equal(cur, s.saved) {
+		return nil
+	}
+
+	err := s.save(cur)
+	if err != nil {
+		log.RateLimited("save", time.Minute).Warnf("%s (while saving settings)", err)
+		return nil
+	}
+
+	s.saved = cur
+	return nil
+}
+
+// current returns the current values of the persisted keys which are
+// registered.
+func (s *Settings) current() map[string]float64 {
+	want := map[string]bool{}
+	for _, k := range s.keys {
+		want[k] = true
+	}
+
+	out := map[string]float64{}
+	for _, v := range s.registry.Values() {
+		if want[v.Name] {
+			out[v.Name] = v.Value
+		}
+	}
+
+	return out
+}
+
+// save writes the given values to the settings file. It writes to a temporary
+// file first, so a crash or power cut mid-write can't leave a truncated file.
+func (s *Settings) save(vals map[string]float64) error {
+	b, err := json.MarshalIndent(vals, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(s.path), filepath.Base(s.path)+".tmp")
+	if err != nil {
+		return err
+	}
+
+	_, err = tmp.Write(append(b, '\n'))
+	if err == nil {
+		err = tmp.Close()
+	} else {
+		tmp.Close()
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	err = os.Rename(tmp.Name(), s.path)
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	log.Infof("saved settings: %s", describe(vals))
+	return nil
+}
+
+func equal(a, b map[string]float64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for k, v := range a {
+		if bv, ok := b[k]; !ok || bv != v {
+			return false
+		}
+	}
+
+	return true
+}
+
+// describe returns the values as a short string, for logging. Maps are sorted
+// by key when marshalled, so this is deterministic.
+func describe(vals map[string]float64) string {
+	b, _ := json.Marshal(vals)
+	return string(b)
+}
@@ -0,0 +1,147 @@
+package settings
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/adammck/hexapod"
+	"github.com/adammck/hexapod/hexapodtest"
+	"github.com/adammck/hexapod/params"
+	"github.com/stretchr/testify/assert"
+)
+
+type fixture struct {
+	path      string
+	r         *params.Registry
+	clearance float64
+	speed     float64
+	other     float64
+}
+
+func setup(t *testing.T) (*fixture, func()) {
+	dir, err := ioutil.TempDir("", "settings")
+	assert.NoError(t, err)
+
+	f := &fixture{
+		path:      filepath.Join(dir, "settings.json"),
+		r:         params.New(),
+		clearance: 40,
+	}
+
+	assert.NoError(t, f.r.Register(hexapodtest.Param("controller.clearance", params.Float, 20, 100, &f.clearance)))
+	assert.NoError(t, f.r.Register(hexapodtest.Param("hexapod.speed", params.Int, -30, 8, &f.speed)))
+	assert.NoError(t, f.r.Register(hexapodtest.Param("legs.other", params.Float, 0, 10, &f.other)))
+
+	return f, func() { os.RemoveAll(dir) }
+}
+
+func (f *fixture) new() *Settings {
+	return New(f.path, []string{"controller.clearance", "hexapod.speed"}, f.r)
+}
+
+func (f *fixture) read(t *testing.T) string {
+	b, err := ioutil.ReadFile(f.path)
+	assert.NoError(t, err)
+	return string(b)
+}
+
+func TestSaveDebounce(t *testing.T) {
+	f, cleanup := setup(t)
+	defer cleanup()
+
+	s := f.new()
+	assert.NoError(t, s.Boot())
+
+	state := &hexapod.State{}
+	t0 := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	tick := func(d time.Duration) {
+		f.r.Apply()
+		assert.NoError(t, s.Tick(t0.Add(d), state))
+	}
+
+	tick(0)
+	assert.NoFileExists(t, f.path)
+
+	// Keep changing the clearance, like holding Up on the controller. Nothing is
+	// saved until it stops.
+	for i := 1; i <= 30; i++ {
+		f.clearance = 40 + float64(i)
+		tick(time.Duration(i) * 100 * time.Millisecond)
+	}
+	for i := 31; i < 50; i++ {
+		tick(time.Duration(i) * 100 * time.Millisecond)
+	}
+	assert.NoFileExists(t, f.path)
+
+	tick(5 * time.Second)
+	assert.JSONEq(t, `{"controller.clearance": 70, "hexapod.speed": 0}`, f.read(t))
+
+	// Changes to other params aren't saved.
+	assert.NoError(t, os.Remove(f.path))
+	f.other = 5
+	tick(6 * time.Second)
+	tick(10 * time.Second)
+	assert.NoFileExists(t, f.path)
+
+	// Changing and then changing back doesn't rewrite the file.
+	f.speed = 2
+	tick(11 * time.Second)
+	f.speed = 0
+	tick(12 * time.Second)
+	tick(15 * time.Second)
+	assert.NoFileExists(t, f.path)
+
+	// Shutdown saves right away.
+	f.speed = -4
+	tick(16 * time.Second)
+	state.Shutdown = true
+	tick(16*time.Second + 100*time.Millisecond)
+	assert.JSONEq(t, `{"controller.clearance": 70, "hexapod.speed": -4}`, f.read(t))
+}
+
+func TestLoadClamp(t *testing.T) {
+	f, cleanup := setup(t)
+	defer cleanup()
+
+	err := ioutil.WriteFile(f.path, []byte(`{"controller.clearance": 500, "hexapod.speed": -3.6, "legs.other": 3, "nope": 1}`), 0644)
+	assert.NoError(t, err)
+
+	s := f.new()
+	assert.NoError(t, s.Boot())
+	f.r.Apply()
+
+	assert.Equal(t, 100.0, f.clearance)
+	assert.Equal(t, -4.0, f.speed)
+
+	// Params which aren't persisted are left alone, even if they're in the file.
+	assert.Equal(t, 0.0, f.other)
+
+	// Loading doesn't count as a change, so doesn't rewrite the file.
+	state := &hexapod.State{}
+	t0 := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	assert.NoError(t, s.Tick(t0, state))
+	assert.NoError(t, s.Tick(t0.Add(time.Minute), state))
+	assert.Contains(t, f.read(t), "500")
+}
+
+func TestCorrupt(t *testing.T) {
+	f, cleanup := setup(t)
+	defer cleanup()
+
+	for _, body := range []string{"", "{", `["controller.clearance"]`, `{"controller.clearance": "high"}`} {
+		err := ioutil.WriteFile(f.path, []byte(body), 0644)
+		assert.NoError(t, err)
+
+		s := f.new()
+		assert.NoError(t, s.Boot(), "body: %q", body)
+		f.r.Apply()
+		assert.Equal(t, 40.0, f.clearance, "body: %q", body)
+	}
+
+	// A missing file is fine, too.
+	assert.NoError(t, os.Remove(f.path))
+	assert.NoError(t, f.new().Boot())
+}
@@ -0,0 +1,583 @@
+package sim
+
+import (
+	"bytes"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/adammck/hexapod/config"
+)
+
+// Instructions, from the Dynamixel protocol (v1).
+const (
+	iPing      byte = 0x01
+	iReadData  byte = 0x02
+	iWriteData byte = 0x03
+	iRegWrite  byte = 0x04
+	iAction    byte = 0x05
+	iSyncWrite byte = 0x83
+
+	broadcastID = 0xFE
+
+	// The error bit of a status packet which reports that the instruction's
+	// checksum didn't match.
+	eChecksum byte = 0x10
+)
+
+// Addresses in the AX-12 control table.
+const (
+	aModelNumber     = 0x00
+	aFirmwareVersion = 0x02
+	aID              = 0x03
+	aCWAngleLimit    = 0x06
+	aCCWAngleLimit   = 0x08
+	aReturnLevel     = 0x10
+	aTorqueEnable    = 0x18
+	aLED             = 0x19
+	aGoalPosition    = 0x1e
+	aMovingSpeed     = 0x20
+	aTorqueLimit     = 0x22
+	aPresentPosition = 0x24
+	aPresentVoltage  = 0x2a
+	aRegistered      = 0x2c
+	aMoving          = 0x2e
+
+	tableSize = 0x32
+)
+
+const (
+
+	// The speed of a servo (in positions per second) when the moving speed is
+	// zero, which means as fast as possible. A moving speed of one is 0.111rpm;
+	// this is 1023 of that.
+	maxPositionsPerSecond = 1023 * 0.111 * 360 / 60 * (1023.0 / 300)
+
+	// The voltage reported by every servo, in tenths of a volt. This is a fully
+	// charged battery, so the voltage check is happy, unless it's sagging (see
+	// config.Sim).
+	voltage = 126
+)
+
+// Bus is a simulated Dynamixel bus, which can be used in place of the serial
+// port. It speaks enough of the protocol for the servo package to work as it
+// does with real servos: every ID responds to pings, reads, and writes,
+// buffered writes are applied by ACTION, and sync writes are applied to each of
+// the servos they address.
+//
+// Servos don't move by themselves. Each call to Step moves every servo (with
+// its torque enabled) towards its goal position, at its moving speed. Load and
+// gravity aren't simulated at all.
+//
+// The bus is perfect unless it's told to inject faults (see SetFaults), in which
+// case the servos' responses are noisy, late or corrupt, as real ones sometimes
+// are. The simulated IMU (see IMU) is kept with the bus, since it's part of the
+// same simulated world, and has faults of its own.
+type Bus struct {
+	sync.Mutex
+	servos map[int]*servo
+
+	// How long each packet takes to handle, to simulate the time spent on the
+	// wire and waiting for the servo. Zero by default.
+	Latency time.Duration
+
+	// If not nil, called with the ID and params (starting with the address) of
+	// every write, buffered write, and servo in a sync write, as it's received. This is called with the
+	// bus locked, so it mustn't call back into it.
+	OnWrite func(id int, params []byte)
+
+	// Bytes which have been written but aren't a complete packet yet, and
+	// responses waiting to be read.
+	in  bytes.Buffer
+	out bytes.Buffer
+
+	// The params of a single servo's part of a sync write.
+	scratch [tableSize + 1]byte
+
+	// The total latency of every packet handled so far.
+	busy time.Duration
+
+	// The faults to inject, and where their randomness comes from. They're not
+	// injected while exact, which is how the simulator reads where the servos
+	// really are.
+	faults config.Sim
+	rand   *rand.Rand
+	exact  bool
+
+	imu *IMU
+}
+
+type servo struct {
+	table [tableSize]byte
+
+	// The present position, which is more precise than the control table.
+	pos float64
+
+	// Writes waiting for ACTION, each prefixed by its length. This is reused,
+	// to avoid allocating for every buffered write.
+	pending []byte
+
+	// The fixed bias of the present position, from -1 to 1, which is scaled by
+	// config.Sim.PositionBias.
+	bias float64
+}
+
+// NewBus returns an empty simulated bus. Servos are created as they're first
+// addressed.
+func NewBus() *Bus {
+	return &Bus{
+		servos: map[int]*servo{},
+		rand:   rand.New(rand.NewSource(0)),
+		imu:    newIMU(),
+	}
+}
+
+// SetFaults sets the faults to inject, which can be changed at any time. If the
+// seed has changed, the randomness starts again from it, and the bias of each
+// servo is picked again.
+func (b *Bus) SetFaults(f config.Sim) {
+	b.Lock()
+	defer b.Unlock()
+
+	if f.Seed != b.faults.Seed {
+		b.rand = rand.New(rand.NewSource(f.Seed))
+		for id, s := range b.servos {
+			s.bias = bias(f.Seed, id)
+		}
+	}
+
+	b.faults = f
+	b.imu.setFaults(f)
+}
+
+// Exact stops injecting faults (or starts again), while the simulator reads
+// where the servos really are, since it's the world rather than a sensor.
+func (b *Bus) Exact(exact bool) {
+	b.Lock()
+	defer b.Unlock()
+	b.exact = exact
+}
+
+// IMU returns the simulated IMU, which the simulator keeps level with the
+// chassis.
+func (b *Bus) IMU() *IMU {
+	return b.imu
+}
+
+// bias returns the bias of the servo with the given ID, from -1 to 1, which is
+// the same for the same seed, whatever order the servos are created in.
+func bias(seed int64, id int) float64 {
+	return rand.New(rand.NewSource(seed<<8|int64(id))).Float64()*2 - 1
+}
+
+// fault returns true, at the given rate (from zero to one), if faults are being
+// injected.
+func (b *Bus) fault(rate float64) bool {
+	return !b.exact && rate > 0 && b.rand.Float64() < rate
+}
+
+func newServo(id int) *servo {
+	s := &servo{pos: 512}
+	put(s.table[:], aModelNumber, 12, 2)
+	s.table[aFirmwareVersion] = 24
+	s.table[aID] = byte(id)
+	s.table[aReturnLevel] = 2
+	put(s.table[:], aCCWAngleLimit, 1023, 2)
+	put(s.table[:], aGoalPosition, 512, 2)
+	put(s.table[:], aTorqueLimit, 1023, 2)
+	put(s.table[:], aPresentPosition, 512, 2)
+	s.table[aPresentVoltage] = voltage
+	return s
+}
+
+func (b *Bus) servo(id int) *servo {
+	s, ok := b.servos[id]
+	if !ok {
+		s = newServo(id)
+		s.bias = bias(b.faults.Seed, id)
+		b.servos[id] = s
+	}
+
+	return s
+}
+
+// Read returns whatever the servos have sent in response. Like a serial port,
+// it doesn't block; it returns io.EOF if nothing is waiting.
+func (b *Bus) Read(p []byte) (int, error) {
+	b.Lock()
+	defer b.Unlock()
+	return b.out.Read(p)
+}
+
+// Write sends instruction packets to the servos, which are handled right away.
+// Packets can be split across writes.
+func (b *Bus) Write(p []byte) (int, error) {
+	b.Lock()
+	defer b.Unlock()
+
+	b.in.Write(p)
+	for b.packet() {
+		b.delay()
+		b.busy += b.Latency
+	}
+
+	return len(p), nil
+}
+
+// delay blocks for the latency. It spins rather than sleeping, since sleeps can
+// be much longer than the typical latency.
+func (b *Bus) delay() {
+	start := time.Now()
+	for time.Since(start) < b.Latency {
+	}
+}
+
+func (b *Bus) Close() error {
+	return nil
+}
+
+// packet handles the next complete packet in the input buffer, if there is one,
+// and returns whether there might be another.
+func (b *Bus) packet() bool {
+	buf := b.in.Bytes()
+
+	// Skip to the header, discarding anything before it.
+	i := bytes.Index(buf, []byte{0xff, 0xff})
+	if i < 0 {
+		return false
+	}
+	if i > 0 {
+		b.in.Next(i)
+		buf = b.in.Bytes()
+	}
+
+	if len(buf) < 4 {
+		return false
+	}
+
+	// Header, ID, length, instruction + params, checksum.
+	n := 4 + int(buf[3])
+	if len(buf) < n {
+		return false
+	}
+
+	// The packet is handled in place, and only discarded afterwards, since
+	// handling it doesn't write to the input buffer.
+	pkt := buf[:n]
+	defer b.in.Next(n)
+
+	var sum byte
+	for _, c := range pkt[2 : n-1] {
+		sum += c
+	}
+	if ^sum != pkt[n-1] {
+		return true
+	}
+
+	// The length includes the instruction and checksum.
+	if pkt[3] < 2 {
+		return true
+	}
+
+	b.handle(int(pkt[2]), pkt[4], pkt[5:n-1])
+	return true
+}
+
+func (b *Bus) handle(id int, inst byte, params []byte) {
+	if id == broadcastID && inst == iSyncWrite {
+		b.sync(params)
+		return
+	}
+
+	if id == broadcastID {
+		for _, s := range b.servos {
+			b.instruct(s, inst, params)
+		}
+
+		return
+	}
+
+	if b.OnWrite != nil && (inst == iWriteData || inst == iRegWrite) {
+		b.OnWrite(id, params)
+	}
+
+	s := b.servo(id)
+	if b.instruct(s, inst, params) {
+		b.respond(id, s, inst, params)
+	}
+}
+
+// sync performs a sync write, which is the address and length of the data, then
+// the ID and data of each servo.
+func (b *Bus) sync(params []byte) {
+	if len(params) < 2 {
+		return
+	}
+
+	addr, n := params[0], int(params[1])
+	if n < 1 || n > tableSize {
+		return
+	}
+
+	for i := 2; i+1+n <= len(params); i += 1 + n {
+		p := b.scratch[:1+n]
+		p[0] = addr
+		copy(p[1:], params[i+1:i+1+n])
+
+		id := int(params[i])
+		if b.OnWrite != nil {
+			b.OnWrite(id, p)
+		}
+
+		b.servo(id).write(p)
+	}
+}
+
+// instruct performs the instruction on a single servo, and returns whether it
+// should respond.
+func (b *Bus) instruct(s *servo, inst byte, params []byte) bool {
+	switch inst {
+	case iPing:
+		return true
+
+	case iReadData:
+		return len(params) == 2 && s.table[aReturnLevel] >= 1
+
+	case iWriteData:
+		if len(params) < 1 {
+			return false
+		}
+
+		// Check the return level after the write, since it might be to the
+		// return level itself. That's what the servo package expects.
+		s.write(params)
+		return s.table[aReturnLevel] == 2
+
+	case iRegWrite:
+		if len(params) < 1 {
+			return false
+		}
+
+		s.pending = append(s.pending, byte(len(params)))
+		s.pending = append(s.pending, params...)
+		s.table[aRegistered] = 1
+		return s.table[aReturnLevel] == 2
+
+	case iAction:
+		for p := s.pending; len(p) > 0; {
+			n := int(p[0])
+			s.write(p[1 : 1+n])
+			p = p[1+n:]
+		}
+
+		s.pending = s.pending[:0]
+		s.table[aRegistered] = 0
+	}
+
+	return false
+}
+
+// respond sends the status packet for the given instruction to the servo. Only
+// reads return any params; reads past the end of the table return zeros. If
+// faults are being injected, the response might not be sent at all, or report a
+// checksum error, and the present position is noisy.
+func (b *Bus) respond(id int, s *servo, inst byte, params []byte) {
+	if b.fault(b.faults.TimeoutRate) {
+		return
+	}
+
+	n := 0
+	if inst == iReadData {
+		n = int(params[1])
+	}
+
+	// The reader doesn't check the checksum of the response, so a corrupted
+	// one is reported by the servo instead, as if the instruction was. It's
+	// still been performed, but whatever sent it will just send it again.
+	var errs byte
+	if b.fault(b.faults.ChecksumRate) {
+		errs = eChecksum
+	}
+
+	// Header, ID, length, error.
+	b.out.Write([]byte{0xff, 0xff, byte(id), byte(n + 2), errs})
+	sum := byte(id) + byte(n+2) + errs
+
+	// Only the reads which include the present position are noisy.
+	table := s.table
+	if n > 0 && !b.exact && int(params[0]) <= aPresentPosition+1 && int(params[0])+n > aPresentPosition {
+		put(table[:], aPresentPosition, b.noisy(s), 2)
+	}
+
+	for i := 0; i < n; i++ {
+		var c byte
+		if a := int(params[0]) + i; a < tableSize {
+			c = table[a]
+		}
+
+		b.out.WriteByte(c)
+		sum += c
+	}
+
+	b.out.WriteByte(^sum)
+}
+
+// noisy returns the present position of the servo, as it reads with the bias
+// and noise of the faults.
+func (b *Bus) noisy(s *servo) int {
+	f := b.faults
+	p := s.pos + s.bias*f.PositionBias
+	if f.PositionNoise > 0 {
+		p += b.rand.NormFloat64() * f.PositionNoise
+	}
+
+	return int(math.Max(0, math.Min(1023, math.Round(p))))
+}
+
+// write writes the data (starting with the address) to the control table.
+// Writes to read-only registers (and the ID, since that would move the servo)
+// are ignored. Like a real AX-12, setting the goal position enables the torque.
+func (s *servo) write(params []byte) {
+	addr := int(params[0])
+
+	for i, c := range params[1:] {
+		a := addr + i
+		if a <= aID || (a >= aPresentPosition && a <= aMoving) || a >= tableSize {
+			continue
+		}
+
+		s.table[a] = c
+		if a == aGoalPosition || a == aGoalPosition+1 {
+			s.table[aTorqueEnable] = 1
+		}
+	}
+}
+
+// Step moves every servo towards its goal, as if the given amount of time had
+// passed. The stuck servo, if there is one, stays where it is. The voltage sags
+// by the fraction of the servos which are working.
+func (b *Bus) Step(dt time.Duration) {
+	b.Lock()
+	defer b.Unlock()
+
+	n := 0
+	for id, s := range b.servos {
+		if s.step(dt, b.faults.Stuck != 0 && id == b.faults.Stuck) {
+			n++
+		}
+	}
+
+	v := voltage
+	if len(b.servos) > 0 {
+		v -= int(math.Round(b.faults.Sag * 10 * float64(n) / float64(len(b.servos))))
+	}
+
+	for _, s := range b.servos {
+		s.table[aPresentVoltage] = byte(v)
+	}
+}
+
+// step moves the servo towards its goal, unless it's stuck, and returns whether
+// it's working, i.e. moved during the step, or is still trying to.
+func (s *servo) step(dt time.Duration, stuck bool) bool {
+	moving, moved := false, false
+
+	if s.table[aTorqueEnable] != 0 {
+		goal := float64(get(s.table[:], aGoalPosition, 2))
+
+		rate := maxPositionsPerSecond
+		if sp := get(s.table[:], aMovingSpeed, 2); sp > 0 {
+			rate = maxPositionsPerSecond * float64(sp) / 1023
+		}
+
+		d := goal - s.pos
+		max := rate * dt.Seconds()
+		if math.Abs(d) > max {
+			d = math.Copysign(max, d)
+		}
+		if stuck {
+			d = 0
+		}
+
+		s.pos += d
+		moved = d != 0
+		moving = math.Abs(goal-s.pos) >= 1
+	}
+
+	put(s.table[:], aPresentPosition, int(math.Round(s.pos)), 2)
+	s.table[aMoving] = 0
+	if moving {
+		s.table[aMoving] = 1
+	}
+
+	return moving || moved
+}
+
+// Busy returns how long the bus has spent handling packets, i.e. the latency of
+// every packet so far. Unlike the time it really took, that doesn't depend on
+// how busy the machine running it is.
+func (b *Bus) Busy() time.Duration {
+	b.Lock()
+	defer b.Unlock()
+	return b.busy
+}
+
+// Limits returns the CW and CCW angle limits (as positions) of the servo with
+// the given ID, and whether it's on the bus.
+func (b *Bus) Limits(id int) (int, int, bool) {
+	b.Lock()
+	defer b.Unlock()
+
+	s, ok := b.servos[id]
+	if !ok {
+		return 0, 0, false
+	}
+
+	return get(s.table[:], aCWAngleLimit, 2), get(s.table[:], aCCWAngleLimit, 2), true
+}
+
+// Position returns the present position of the servo with the given ID, and
+// whether it's on the bus.
+func (b *Bus) Position(id int) (float64, bool) {
+	b.Lock()
+	defer b.Unlock()
+
+	s, ok := b.servos[id]
+	if !ok {
+		return 0, false
+	}
+
+	return s.pos, true
+}
+
+// SetPosition moves the servo with the given ID to the given present position,
+// e.g. back to where it stalled, without changing its goal. It does nothing if
+// the servo isn't on the bus.
+func (b *Bus) SetPosition(id int, pos float64) {
+	b.Lock()
+	defer b.Unlock()
+
+	s, ok := b.servos[id]
+	if !ok {
+		return
+	}
+
+	s.pos = pos
+	put(s.table[:], aPresentPosition, int(math.Round(s.pos)), 2)
+}
+
+func get(t []byte, addr, n int) int {
+	if n == 1 {
+		return int(t[addr])
+	}
+
+	return int(t[addr]) | int(t[addr+1])<<8
+}
+
+func put(t []byte, addr, v, n int) {
+	t[addr] = byte(v & 0xff)
+	if n == 2 {
+		t[addr+1] = byte((v >> 8) & 0xff)
+	}
+}
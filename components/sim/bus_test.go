@@ -0,0 +1,270 @@
+package sim
+
+import (
+	"testing"
+	"time"
+
+	"github.com/adammck/dynamixel/network"
+	"github.com/adammck/dynamixel/servo/ax"
+	"github.com/adammck/hexapod/config"
+	"github.com/adammck/hexapod/servos"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBus(t *testing.T) {
+	b := NewBus()
+	s, err := ax.New(network.New(b), 11)
+	assert.NoError(t, err)
+
+	assert.NoError(t, s.Ping())
+
+	n, err := s.ModelNumber()
+	assert.NoError(t, err)
+	assert.Equal(t, 12, n)
+
+	assert.NoError(t, s.SetMovingSpeed(100))
+	assert.NoError(t, s.SetGoalPosition(612))
+
+	p, err := s.GoalPosition()
+	assert.NoError(t, err)
+	assert.Equal(t, 612, p)
+
+	// Servos don't move until time passes. At a moving speed of 100, that's
+	// about 227 positions per second.
+	p, err = s.PresentPosition()
+	assert.NoError(t, err)
+	assert.Equal(t, 512, p)
+
+	b.Step(100 * time.Millisecond)
+	p, err = s.PresentPosition()
+	assert.NoError(t, err)
+	assert.InDelta(t, 535, p, 1)
+
+	b.Step(time.Second)
+	p, err = s.PresentPosition()
+	assert.NoError(t, err)
+	assert.Equal(t, 612, p)
+
+	m, err := s.Moving()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, m)
+
+	// The precise position is available to the simulator.
+	pos, ok := b.Position(11)
+	assert.True(t, ok)
+	assert.Equal(t, 612.0, pos)
+
+	_, ok = b.Position(12)
+	assert.False(t, ok)
+}
+
+func TestBusBuffered(t *testing.T) {
+	b := NewBus()
+	s, err := ax.New(network.New(b), 11)
+	assert.NoError(t, err)
+
+	// Without return packets, like the legs use.
+	assert.NoError(t, s.SetReturnLevel(1))
+
+	s.SetBuffered(true)
+	assert.NoError(t, s.SetGoalPosition(400))
+	s.SetBuffered(false)
+
+	b.Step(time.Second)
+	p, err := s.GoalPosition()
+	assert.NoError(t, err)
+	assert.Equal(t, 512, p)
+
+	// Nothing happens until ACTION, which is broadcast.
+	b.Write([]byte{0xff, 0xff, 0xfe, 0x02, 0x05, 0xfa})
+	b.Step(time.Second)
+	p, err = s.PresentPosition()
+	assert.NoError(t, err)
+	assert.Equal(t, 400, p)
+
+	// Setting the goal enables the torque, like a real AX-12, but a servo
+	// with the torque off stays where it is.
+	assert.NoError(t, s.SetGoalPosition(500))
+	assert.NoError(t, s.SetTorqueEnable(false))
+	b.Step(time.Second)
+	p, err = s.PresentPosition()
+	assert.NoError(t, err)
+	assert.Equal(t, 400, p)
+}
+
+func TestBusSyncWrite(t *testing.T) {
+	b := NewBus()
+	n := network.New(b)
+
+	var ids []int
+	b.OnWrite = func(id int, params []byte) {
+		ids = append(ids, id)
+	}
+
+	w := servos.NewGoalPositions()
+	w.Set(11, 400)
+	w.Set(12, 600)
+	assert.NoError(t, w.WriteTo(n))
+	assert.Equal(t, []int{11, 12}, ids)
+
+	// Applied right away, and nothing is sent back.
+	b.Step(time.Second)
+	p, ok := b.Position(11)
+	assert.True(t, ok)
+	assert.Equal(t, 400.0, p)
+	p, ok = b.Position(12)
+	assert.True(t, ok)
+	assert.Equal(t, 600.0, p)
+
+	s, err := ax.New(n, 12)
+	assert.NoError(t, err)
+	gp, err := s.GoalPosition()
+	assert.NoError(t, err)
+	assert.Equal(t, 600, gp)
+}
+
+func TestBusNoise(t *testing.T) {
+	b := NewBus()
+	s, err := ax.New(network.New(b), 11)
+	assert.NoError(t, err)
+
+	// The bias is the same for every read.
+	b.SetFaults(config.Sim{Seed: 1, PositionBias: 10})
+	p, err := s.PresentPosition()
+	assert.NoError(t, err)
+	assert.InDelta(t, 512, p, 10)
+	for i := 0; i < 10; i++ {
+		q, err := s.PresentPosition()
+		assert.NoError(t, err)
+		assert.Equal(t, p, q)
+	}
+
+	// The noise isn't.
+	b.SetFaults(config.Sim{Seed: 1, PositionNoise: 5})
+	seen := map[int]bool{}
+	for i := 0; i < 20; i++ {
+		q, err := s.PresentPosition()
+		assert.NoError(t, err)
+		assert.InDelta(t, 512, q, 30)
+		seen[q] = true
+	}
+	assert.Greater(t, len(seen), 1)
+
+	// Nor is either for the simulator, which knows where the servo really is,
+	// nor does either change where it goes.
+	b.Exact(true)
+	p, err = s.PresentPosition()
+	assert.NoError(t, err)
+	assert.Equal(t, 512, p)
+	b.Exact(false)
+
+	pos, _ := b.Position(11)
+	assert.Equal(t, 512.0, pos)
+}
+
+func TestBusErrors(t *testing.T) {
+	b := NewBus()
+	s, err := ax.New(network.New(b), 11)
+	assert.NoError(t, err)
+
+	b.SetFaults(config.Sim{ChecksumRate: 1})
+	_, err = s.PresentPosition()
+	assert.Error(t, err)
+
+	b.SetFaults(config.Sim{TimeoutRate: 1})
+	_, err = s.PresentPosition()
+	assert.Error(t, err)
+
+	// At a lower rate, only some of the reads fail.
+	b.SetFaults(config.Sim{Seed: 1, ChecksumRate: 0.5})
+	failed := 0
+	for i := 0; i < 40; i++ {
+		_, err = s.PresentPosition()
+		if err != nil {
+			failed++
+		}
+	}
+	assert.Greater(t, failed, 5)
+	assert.Less(t, failed, 35)
+
+	// And none once the faults are gone.
+	b.SetFaults(config.Sim{})
+	p, err := s.PresentPosition()
+	assert.NoError(t, err)
+	assert.Equal(t, 512, p)
+}
+
+func TestBusBusy(t *testing.T) {
+	b := NewBus()
+	b.Latency = time.Millisecond
+	s, err := ax.New(network.New(b), 11)
+	assert.NoError(t, err)
+
+	// A read is a single packet (once the servo's return level is known),
+	// however long it really took.
+	_, err = s.PresentPosition()
+	assert.NoError(t, err)
+
+	start := b.Busy()
+	_, err = s.PresentPosition()
+	assert.NoError(t, err)
+	assert.Equal(t, time.Millisecond, b.Busy()-start)
+}
+
+func TestBusStuck(t *testing.T) {
+	b := NewBus()
+	s, err := ax.New(network.New(b), 11)
+	assert.NoError(t, err)
+
+	// It tries to move, but doesn't get anywhere.
+	b.SetFaults(config.Sim{Stuck: 11})
+	assert.NoError(t, s.SetGoalPosition(612))
+	b.Step(time.Second)
+	p, err := s.PresentPosition()
+	assert.NoError(t, err)
+	assert.Equal(t, 512, p)
+
+	m, err := s.Moving()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, m)
+
+	// Until it's freed.
+	b.SetFaults(config.Sim{})
+	b.Step(time.Second)
+	p, err = s.PresentPosition()
+	assert.NoError(t, err)
+	assert.Equal(t, 612, p)
+}
+
+func TestBusSag(t *testing.T) {
+	b := NewBus()
+	n := network.New(b)
+	s1, err := ax.New(n, 11)
+	assert.NoError(t, err)
+	s2, err := ax.New(n, 12)
+	assert.NoError(t, err)
+
+	b.SetFaults(config.Sim{Sag: 2})
+	b.Step(time.Second)
+	v, err := s2.Voltage()
+	assert.NoError(t, err)
+	assert.InDelta(t, 12.6, v, 0.01)
+
+	// One of the two servos is moving, so it's half of the sag, as reported by
+	// both.
+	assert.NoError(t, s1.SetGoalPosition(1000))
+	b.Step(100 * time.Millisecond)
+	v, err = s1.Voltage()
+	assert.NoError(t, err)
+	assert.InDelta(t, 11.6, v, 0.01)
+	v, err = s2.Voltage()
+	assert.NoError(t, err)
+	assert.InDelta(t, 11.6, v, 0.01)
+
+	// It's still working for the step in which it arrives, but not after.
+	b.Step(time.Second)
+	b.Step(time.Second)
+	v, err = s2.Voltage()
+	assert.NoError(t, err)
+	assert.InDelta(t, 12.6, v, 0.01)
+}
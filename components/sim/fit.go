@@ -0,0 +1,52 @@
+package sim
+
+import (
+	"math"
+
+	"github.com/adammck/hexapod/math3d"
+)
+
+// fit returns the motion (on the XZ plane) which best moves each point in b to
+// the corresponding point in a, i.e. such that fit(a, b).Add(Pose{Position:
+// b[i]}) is close to a[i]. This is the 2D Kabsch algorithm. With one point, it
+// can only translate; with none, it returns the zero pose. The Y axis is
+// ignored.
+func fit(a, b []math3d.Vector3) math3d.Pose {
+	n := len(a)
+	if n == 0 || n != len(b) {
+		return math3d.Pose{}
+	}
+
+	var ca, cb math3d.Vector3
+	for i := range a {
+		ca = *ca.Add(a[i])
+		cb = *cb.Add(b[i])
+	}
+	ca = ca.Scaled(1 / float64(n))
+	cb = cb.Scaled(1 / float64(n))
+	ca.Y = 0
+	cb.Y = 0
+
+	// Find the heading which best rotates the points of b (around its centroid)
+	// onto those of a. Positive headings are clockwise from above, so rotate
+	// X towards -Z.
+	var dot, cross float64
+	for i := range a {
+		pa := a[i].Subtract(ca)
+		pb := b[i].Subtract(cb)
+		dot += pb.X*pa.X + pb.Z*pa.Z
+		cross += pb.Z*pa.X - pb.X*pa.Z
+	}
+
+	h := 0.0
+	if n > 1 {
+		h = math3d.WrapDegrees(math.Atan2(cross, dot) * 180 / math.Pi)
+	}
+
+	// Then translate the rotated centroid of b onto that of a.
+	rcb := math3d.Pose{Heading: h}.Add(math3d.Pose{Position: cb}).Position
+	t := ca.Subtract(rcb)
+	t.Y = 0
+
+	return math3d.Pose{Position: t, Heading: h}
+}
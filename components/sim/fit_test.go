@@ -0,0 +1,42 @@
+package sim
+
+import (
+	"testing"
+
+	"github.com/adammck/hexapod/math3d"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFit(t *testing.T) {
+	b := []math3d.Vector3{
+		{X: 100, Y: -40, Z: 200},
+		{X: -120, Y: -40, Z: 10},
+		{X: 30, Y: -40, Z: -150},
+	}
+
+	for _, want := range []math3d.Pose{
+		{},
+		{Position: math3d.Vector3{X: 5, Z: -12}},
+		{Heading: 7},
+		{Position: math3d.Vector3{X: -20, Z: 3}, Heading: -170},
+	} {
+		a := make([]math3d.Vector3, len(b))
+		for i := range b {
+			a[i] = want.Add(math3d.Pose{Position: b[i]}).Position
+		}
+
+		got := fit(a, b)
+		assert.InDelta(t, want.Position.X, got.Position.X, 1e-6, "%v", want)
+		assert.InDelta(t, want.Position.Z, got.Position.Z, 1e-6, "%v", want)
+		assert.InDelta(t, want.Heading, got.Heading, 1e-6, "%v", want)
+		assert.Equal(t, 0.0, got.Position.Y)
+	}
+}
+
+func TestFitFewPoints(t *testing.T) {
+	assert.Equal(t, math3d.Pose{}, fit(nil, nil))
+
+	// One point can only translate.
+	p := fit([]math3d.Vector3{{X: 1, Y: 2, Z: 3}}, []math3d.Vector3{{X: 4, Y: 5, Z: 6}})
+	assert.Equal(t, math3d.Pose{Position: math3d.Vector3{X: -3, Z: -3}}, p)
+}
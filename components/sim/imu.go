@@ -0,0 +1,85 @@
+package sim
+
+import (
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/adammck/hexapod/config"
+	"github.com/adammck/hexapod/math3d"
+)
+
+// errDropout is returned by IMU.Acceleration when a read fails.
+var errDropout = errors.New("simulated IMU dropout")
+
+// IMU is a simulated IMU, which reads gravity as it would be in the chassis
+// space, tilted by the pitch and bank which the simulator sets every tick. There
+// is no gravity otherwise, so the hex never falls over; it's for testing what
+// reads the IMU (e.g. the righting and the self-test) against its faults, which
+// are drift around the roll axis, and reads which fail.
+//
+// Unlike the bus, this is read from outside the main loop, so it's locked.
+type IMU struct {
+	mu sync.Mutex
+
+	pitch float64
+	bank  float64
+
+	// How far (in degrees) the reading has drifted so far, and how fast it
+	// drifts, and the fraction of reads which fail.
+	drift   float64
+	rate    float64
+	dropout float64
+
+	seed int64
+	rand *rand.Rand
+}
+
+func newIMU() *IMU {
+	return &IMU{
+		rand: rand.New(rand.NewSource(0)),
+	}
+}
+
+// setFaults sets the drift rate and dropout rate. The drift so far is kept, as
+// it would be by a real IMU. Like the bus, the randomness starts again if the
+// seed has changed.
+func (i *IMU) setFaults(f config.Sim) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if f.Seed != i.seed {
+		i.seed = f.Seed
+		i.rand = rand.New(rand.NewSource(f.Seed))
+	}
+
+	i.rate = f.IMUDrift
+	i.dropout = f.IMUDropoutRate
+}
+
+// update sets the pitch and bank (in degrees) of the chassis, and drifts for
+// the given time.
+func (i *IMU) update(dt time.Duration, pitch, bank float64) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.pitch = pitch
+	i.bank = bank
+	i.drift += i.rate * dt.Seconds()
+}
+
+// Acceleration returns gravity (in g) in the chassis space, which is up (+Y)
+// while the chassis is level, plus the drift. It returns an error at the
+// dropout rate.
+func (i *IMU) Acceleration() (math3d.Vector3, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if i.dropout > 0 && i.rand.Float64() < i.dropout {
+		return math3d.ZeroVector3, errDropout
+	}
+
+	p := math3d.Pose{Pitch: i.pitch, Bank: i.bank + i.drift}
+	return math3d.Vector3{Y: 1}.MultiplyByMatrix44(p.ToLocal()), nil
+}
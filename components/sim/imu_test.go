@@ -0,0 +1,50 @@
+package sim
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/adammck/hexapod/config"
+	"github.com/adammck/hexapod/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIMU(t *testing.T) {
+	i := newIMU()
+	a, err := i.Acceleration()
+	assert.NoError(t, err)
+	assert.InDelta(t, 1, a.Y, 1e-9)
+
+	// Gravity tilts with the chassis.
+	i.update(time.Second, 0, 30)
+	a, err = i.Acceleration()
+	assert.NoError(t, err)
+	assert.InDelta(t, math.Cos(utils.Rad(30)), a.Y, 1e-6)
+	assert.InDelta(t, 1, a.Magnitude(), 1e-6)
+
+	// The drift adds up, even once the chassis is level again.
+	i.setFaults(config.Sim{IMUDrift: 10})
+	i.update(3*time.Second, 0, 0)
+	a, err = i.Acceleration()
+	assert.NoError(t, err)
+	assert.InDelta(t, math.Cos(utils.Rad(30)), a.Y, 1e-6)
+
+	i.setFaults(config.Sim{})
+	i.update(3*time.Second, 0, 0)
+	a, err = i.Acceleration()
+	assert.NoError(t, err)
+	assert.InDelta(t, math.Cos(utils.Rad(30)), a.Y, 1e-6)
+
+	// Only some reads fail.
+	i.setFaults(config.Sim{Seed: 1, IMUDropoutRate: 0.5})
+	failed := 0
+	for n := 0; n < 40; n++ {
+		_, err = i.Acceleration()
+		if err != nil {
+			failed++
+		}
+	}
+	assert.Greater(t, failed, 5)
+	assert.Less(t, failed, 35)
+}
@@ -0,0 +1,285 @@
+package sim
+
+import (
+	"math"
+	"time"
+
+	"github.com/adammck/hexapod"
+	"github.com/adammck/hexapod/components/legs"
+	"github.com/adammck/hexapod/config"
+	"github.com/adammck/hexapod/math3d"
+	"github.com/adammck/hexapod/params"
+)
+
+var log = hexapod.NewLog("sim")
+
+// The distance (in mm) above the lowest foot, within which a foot counts as
+// being on the ground.
+const groundTolerance = 2.0
+
+// Sim is a component which moves the simulated hexapod, for running without
+// any hardware. Together with Bus (in place of the serial port), it acts as the
+// servos and the world they walk on: the legs component sends the same goals
+// as it would to real servos, Bus moves the servos towards them, and Sim works
+// out where the feet are (via FK), which are on the ground, and how the chassis
+// must have moved for the planted feet to stay put.
+//
+// The result is written to the X/Z position and heading of State.Pose, so the
+// pose is what the simulated hex actually did, rather than what the legs asked
+// for. The clearance, pitch and bank are left to the legs, since there's no
+// gravity to disagree with them.
+//
+// The ground is flat, unless Ground says otherwise. A foot which is moved into
+// it stops where it touched, as the servos would stall against it, and the body
+// is held up by the rest, so the legs find out (with the feedback) that it's
+// higher than they put it.
+//
+// Faults (see config.Sim) are injected by the bus, but the simulator owns them,
+// and registers a param for each (except the seed), so they can be switched on
+// and off at runtime, e.g. via the console. The simulator itself always reads
+// where the servos really are, whatever the faults.
+//
+// This must be added after the legs.
+type Sim struct {
+	bus  *Bus
+	legs [6]*legs.Leg
+
+	// The faults to inject, which are set on the bus at Boot. None by default.
+	Faults config.Sim
+
+	Params *params.Registry
+
+	// The height (in mm) of the ground at the given X/Z in the world space, or
+	// nil if it's flat at zero. It should be flat under the hex where it boots,
+	// since the feet don't start on it.
+	Ground func(x, z float64) float64
+
+	// The time of the previous tick, or zero before the first.
+	last time.Time
+
+	// The pose (on the XZ plane) of the chassis, i.e. State.Pose plus the
+	// offset, and the position of each foot in that space as of the previous
+	// tick.
+	pose    math3d.Pose
+	feet    [6]math3d.Vector3
+	planted [6]bool
+
+	// Whether the chassis is being held off the ground by the feet.
+	standing bool
+}
+
+// New creates a simulator for the given legs, which must be on the given bus.
+func New(bus *Bus, l *legs.Legs) *Sim {
+	return &Sim{
+		bus:    bus,
+		legs:   l.Legs,
+		Params: params.Default,
+	}
+}
+
+// Writes returns hexapod.Estimator, since the simulator knows where the hex
+// really is, better than the legs do.
+func (s *Sim) Writes() hexapod.Role {
+	return hexapod.Estimator
+}
+
+// Boot sets the faults on the bus, and registers their params, which are named
+// as in the config, e.g. sim.timeout_rate.
+func (s *Sim) Boot() error {
+	log.Warn("simulating servos; nothing will actually move")
+	if s.Faults != (config.Sim{}) {
+		log.Warnf("injecting faults: %+v", s.Faults)
+	}
+
+	s.bus.SetFaults(s.Faults)
+
+	f := &s.Faults
+	for _, p := range []params.Param{
+		s.fault("sim.position_noise", &f.PositionNoise, 100),
+		s.fault("sim.position_bias", &f.PositionBias, 100),
+		s.fault("sim.timeout_rate", &f.TimeoutRate, 1),
+		s.fault("sim.checksum_rate", &f.ChecksumRate, 1),
+		s.fault("sim.imu_drift", &f.IMUDrift, 90),
+		s.fault("sim.imu_dropout_rate", &f.IMUDropoutRate, 1),
+		s.fault("sim.sag", &f.Sag, 12),
+		{
+			Name: "sim.stuck",
+			Type: params.Int,
+			Min:  0,
+			Max:  253,
+			Get:  func() float64 { return float64(f.Stuck) },
+			Set: func(v float64) {
+				f.Stuck = int(v)
+				s.bus.SetFaults(s.Faults)
+			},
+		},
+	} {
+		err := s.Params.Register(p)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// fault returns a param which sets the given fault, from zero to max, on the
+// bus.
+func (s *Sim) fault(name string, v *float64, max float64) params.Param {
+	return params.Param{
+		Name: name,
+		Type: params.Float,
+		Min:  0,
+		Max:  max,
+		Get:  func() float64 { return *v },
+		Set: func(val float64) {
+			*v = val
+			s.bus.SetFaults(s.Faults)
+		},
+	}
+}
+
+func (s *Sim) Tick(now time.Time, state *hexapod.State) error {
+	if s.last.IsZero() {
+		s.last = now
+		s.pose = math3d.Pose{Position: state.Pose.Position, Heading: state.Pose.Heading}.Add(offset(state))
+		s.pose.Position.Y = 0
+		s.read(state)
+		return nil
+	}
+
+	dt := now.Sub(s.last)
+	s.bus.Step(dt)
+	s.stall(state)
+	s.bus.imu.update(dt, state.Pose.Pitch, state.Pose.Bank)
+	s.last = now
+
+	prev, wasPlanted := s.feet, s.planted
+	if !s.read(state) {
+		return nil
+	}
+
+	// Fit the motion of the feet which were on the ground both before and
+	// after this step. They didn't really move, so the chassis did. Unless the
+	// feet are lower than the chassis, it's sitting on the ground, and the feet
+	// just slide around.
+	var a, b []math3d.Vector3
+	for i := range s.feet {
+		if s.standing && wasPlanted[i] && s.planted[i] {
+			a = append(a, prev[i])
+			b = append(b, s.feet[i])
+		}
+	}
+
+	s.pose = s.pose.Add(fit(a, b))
+	s.pose.Heading = math3d.WrapDegrees(s.pose.Heading)
+
+	p := s.pose.Add(offset(state).Inverse())
+	state.Pose.Position.X = p.Position.X
+	state.Pose.Position.Z = p.Position.Z
+	state.Pose.Heading = p.Heading
+
+	return nil
+}
+
+// offset returns the pose of the chassis relative to the state's pose, which is
+// where the legs put it on its feet.
+func offset(state *hexapod.State) math3d.Pose {
+	return math3d.Pose{Position: state.Offset, Heading: state.OffsetHeading}
+}
+
+// chassis returns the pose of the chassis in the world space, as the simulator
+// has it: where it's moved it on the XZ plane, but at the clearance, pitch and
+// bank which the legs put it at.
+func (s *Sim) chassis(state *hexapod.State) math3d.Pose {
+	p := s.pose
+	p.Position.Y = state.Pose.Position.Y + state.Offset.Y
+	p.Pitch = state.Pose.Pitch
+	p.Bank = state.Pose.Bank
+	return p
+}
+
+// ground returns the height of the ground at the given X/Z in the world space.
+func (s *Sim) ground(x, z float64) float64 {
+	if s.Ground == nil {
+		return 0
+	}
+
+	return s.Ground(x, z)
+}
+
+// stall moves each foot which the last step of the bus moved into the ground
+// back up onto it, as the servos would stall against it, without changing
+// their goals. The rest are left alone.
+func (s *Sim) stall(state *hexapod.State) {
+	if s.Ground == nil {
+		return
+	}
+
+	p := s.chassis(state)
+	world, local := p.ToWorld(), p.ToLocal()
+	s.bus.Exact(true)
+	defer s.bus.Exact(false)
+
+	for _, leg := range s.legs {
+		v, err := leg.PresentPosition()
+		if err != nil {
+			continue
+		}
+
+		w := v.MultiplyByMatrix44(world)
+		g := s.ground(w.X, w.Z)
+		if w.Y >= g {
+			continue
+		}
+
+		w.Y = g
+		pos, err := leg.Positions(w.MultiplyByMatrix44(local))
+		if err != nil {
+			continue
+		}
+
+		for i, j := range []*legs.Joint{leg.Coxa, leg.Femur, leg.Tibia, leg.Tarsus} {
+			s.bus.SetPosition(j.ID, float64(pos[i]))
+		}
+	}
+}
+
+// read updates the position of each foot from the servos, and which of them
+// are on the ground, and returns whether that worked. If any can't be read,
+// nothing is changed.
+func (s *Sim) read(state *hexapod.State) bool {
+	var feet [6]math3d.Vector3
+
+	// This is where the feet really are, not what the legs would read.
+	s.bus.Exact(true)
+	defer s.bus.Exact(false)
+
+	// The network is already locked by the hexapod.
+	w := s.chassis(state).ToWorld()
+	lowest := math.Inf(1)
+	for i, leg := range s.legs {
+		v, err := leg.PresentPosition()
+		if err != nil {
+			log.RateLimited("read", time.Second).Warnf("%s (while reading foot position)", err)
+			return false
+		}
+
+		// Level the feet, so the ground is flat even if the chassis isn't, and
+		// measure them from the ground under them, if it isn't.
+		feet[i] = math3d.Pose{Pitch: state.Pose.Pitch, Bank: state.Pose.Bank}.Add(math3d.Pose{Position: v}).Position
+		if s.Ground != nil {
+			g := v.MultiplyByMatrix44(w)
+			feet[i].Y -= s.ground(g.X, g.Z)
+		}
+		lowest = math.Min(lowest, feet[i].Y)
+	}
+
+	s.feet = feet
+	s.standing = lowest < -groundTolerance
+	for i := range feet {
+		s.planted[i] = feet[i].Y-lowest < groundTolerance
+	}
+
+	return true
+}
@@ -0,0 +1,787 @@
+package sim
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/adammck/dynamixel/network"
+	"github.com/adammck/hexapod"
+	"github.com/adammck/hexapod/components/controller"
+	"github.com/adammck/hexapod/components/legs"
+	"github.com/adammck/hexapod/components/legs/gait"
+	"github.com/adammck/hexapod/config"
+	"github.com/adammck/hexapod/math3d"
+	"github.com/adammck/hexapod/params"
+	"github.com/adammck/hexapod/utils"
+	"github.com/adammck/sixaxis"
+	"github.com/stretchr/testify/assert"
+)
+
+// standUp boots a simulated hex with the given config, stands it up, and
+// returns it and its bus, with a func to tick it at 60Hz.
+func standUp(t *testing.T, cfg config.Config) (*hexapod.Hexapod, *legs.Legs, *Bus, func()) {
+	h, l, bus, tick := boot(t, cfg)
+
+	// Stand up. The legs wait (in real time) for the feet to reach their home
+	// positions first, so give them a chance.
+	h.State.Target.Position.Y = cfg.Controller.Clearance
+	for i := 0; i < 2000 && h.State.Pose.Position.Y < cfg.Controller.Clearance; i++ {
+		tick()
+		time.Sleep(time.Millisecond)
+	}
+	assert.Equal(t, cfg.Controller.Clearance, h.State.Pose.Position.Y)
+
+	return h, l, bus, tick
+}
+
+// boot boots a simulated hex with the given config, like standUp, but doesn't
+// tick it.
+func boot(t *testing.T, cfg config.Config) (*hexapod.Hexapod, *legs.Legs, *Bus, func()) {
+	bus := NewBus()
+	n := network.New(bus)
+
+	h := hexapod.NewHexapod(n, 60)
+	h.Params = params.New()
+	l := legs.New(n, cfg.Legs, cfg.Gait)
+	l.Params = h.Params
+	s := New(bus, l)
+	s.Params = h.Params
+	h.Add(l)
+	h.Add(s)
+	assert.NoError(t, h.Boot())
+
+	now := time.Unix(0, 0)
+	tick := func() {
+		now = now.Add(time.Second / 60)
+		assert.NoError(t, h.Tick(now))
+	}
+
+	return h, l, bus, tick
+}
+
+func TestWalk(t *testing.T) {
+	h, _, _, tick := standUp(t, config.Default())
+
+	// Standing still doesn't go anywhere.
+	for i := 0; i < 60; i++ {
+		tick()
+	}
+	start := h.State.Pose
+	assert.InDelta(t, 0, start.Position.X, 1)
+	assert.InDelta(t, 0, start.Position.Z, 1)
+
+	// Walk forwards, and wait until we stop.
+	h.State.Target.Position.Z = 300
+	for i := 0; i < 60*30; i++ {
+		tick()
+	}
+
+	// The pose is how far the simulated feet pushed the chassis, not how far
+	// the legs wanted to go, so it's a little short. The legs stop within the
+	// minimum step distance of the target.
+	end := h.State.Pose
+	assert.InDelta(t, 300, end.Position.Z-start.Position.Z, 300*0.15)
+	assert.InDelta(t, 0, end.Position.X-start.Position.X, 10)
+	assert.InDelta(t, 0, end.Heading-start.Heading, 2)
+}
+
+func TestFaults(t *testing.T) {
+	h, _, bus, tick := standUp(t, config.Default())
+
+	// The faults can be switched on at runtime, via the params.
+	assert.NoError(t, h.Params.Set(map[string]float64{
+		"sim.position_noise": 20,
+		"sim.position_bias":  10,
+		"sim.sag":            1.5,
+	}))
+	tick()
+	assert.Equal(t, config.Sim{PositionNoise: 20, PositionBias: 10, Sag: 1.5}, bus.faults)
+
+	// But they're only for the legs. The simulator reads where the feet really
+	// are, so the chassis doesn't wander.
+	start := h.State.Pose
+	for i := 0; i < 60; i++ {
+		tick()
+	}
+	assert.Equal(t, start, h.State.Pose)
+
+	// The IMU tilts with the chassis.
+	h.State.Target.Bank = 10
+	for i := 0; i < 60; i++ {
+		tick()
+	}
+	assert.Greater(t, h.State.Pose.Bank, 5.0)
+	a, err := bus.IMU().Acceleration()
+	assert.NoError(t, err)
+	assert.InDelta(t, math.Cos(utils.Rad(h.State.Pose.Bank)), a.Y, 0.001)
+}
+
+func TestRestand(t *testing.T) {
+	cfg := config.Default()
+	h, l, _, tick := standUp(t, cfg)
+
+	// Fall over mid-stride, and then be put the right way up (by hand, or the
+	// righting) a little further on, lying on the ground.
+	h.State.Target.Position.Z = 300
+	for i := 0; i < 90; i++ {
+		tick()
+	}
+	assert.Equal(t, legs.State("sStepping"), l.State)
+
+	h.State.Fallen = true
+	for i := 0; i < 60; i++ {
+		tick()
+	}
+
+	h.State.Pose.Position.Z += 50
+	h.State.Fallen = false
+	tick()
+	assert.Equal(t, legs.State("sStandUp"), l.State)
+	assert.Less(t, h.State.Pose.Position.Y, cfg.Controller.Clearance)
+
+	// The legs stand it up again where it is, and carry on.
+	for i := 0; i < 600 && l.State != "sStepping"; i++ {
+		tick()
+	}
+	assert.Equal(t, legs.State("sStepping"), l.State)
+	assert.InDelta(t, cfg.Controller.Clearance, h.State.Pose.Position.Y, 1)
+}
+
+func TestStartupHold(t *testing.T) {
+	cfg := config.Default()
+	h, l, bus, tick := boot(t, cfg)
+
+	var writes int
+	bus.OnWrite = func(id int, params []byte) {
+		writes++
+	}
+
+	// While the startup check says that the hex isn't on its feet, the legs
+	// don't write anything, so the servos stay limp wherever they are.
+	h.State.StartupHold = true
+	h.State.Target.Position.Y = cfg.Controller.Clearance
+	for i := 0; i < 120; i++ {
+		tick()
+	}
+	assert.Equal(t, 0, writes)
+	assert.Equal(t, legs.State(""), l.State)
+	assert.Equal(t, 0.0, h.State.Pose.Position.Y)
+
+	// Once it is, they stand up as usual.
+	h.State.StartupHold = false
+	for i := 0; i < 2000 && h.State.Pose.Position.Y < cfg.Controller.Clearance; i++ {
+		tick()
+		time.Sleep(time.Millisecond)
+	}
+	assert.Greater(t, writes, 0)
+	assert.Equal(t, cfg.Controller.Clearance, h.State.Pose.Position.Y)
+}
+
+func TestStance(t *testing.T) {
+	cfg := config.Default()
+	h, l, _, tick := standUp(t, cfg)
+	for i := 0; i < 60; i++ {
+		tick()
+	}
+
+	// The distance (on the X/Z plane) of each foot from its leg's origin, plus
+	// the 10mm which the home positions are shifted forwards by. The radius is
+	// along the leg, so this is the step radius, plus a constant.
+	spread := func() [6]float64 {
+		var out [6]float64
+		for i, leg := range l.Legs {
+			f := h.State.Feet[i]
+			out[i] = math.Hypot(f.X-leg.Origin.X, f.Z-(leg.Origin.Z+10))
+		}
+		return out
+	}
+
+	// Ticks until the feet have stopped moving, and returns the legs which
+	// were lifted, in order. Only one is ever lifted at a time.
+	lifted := func() []int {
+		var out []int
+		ground := h.State.Feet
+		for n := 0; n < 60*5; n++ {
+			tick()
+
+			up := -1
+			for i, f := range h.State.Feet {
+				if f.Y > ground[i].Y+1 {
+					assert.Equal(t, -1, up, "lifted %d and %d together", up, i)
+					up = i
+				}
+			}
+
+			if up >= 0 && (len(out) == 0 || out[len(out)-1] != up) {
+				out = append(out, up)
+			}
+		}
+
+		return out
+	}
+
+	start := h.State.Pose
+	before := spread()
+
+	// Widening the stance walks every foot out, one at a time.
+	assert.NoError(t, l.Params.Set(map[string]float64{"legs.step_radius": cfg.Legs.StepRadius + 20}))
+	assert.Equal(t, []int{0, 1, 2, 3, 4, 5}, lifted())
+	after := spread()
+	for i := range after {
+		assert.InDelta(t, before[i]+20, after[i], 0.5, l.Legs[i].Name)
+	}
+
+	// Then widening one leg only moves that one.
+	assert.NoError(t, l.Params.Set(map[string]float64{"legs.step_radius_mr": cfg.Legs.StepRadius + 50}))
+	assert.Equal(t, []int{2}, lifted())
+	final := spread()
+	for i := range final {
+		want := after[i]
+		if i == 2 {
+			want += 30
+		}
+		assert.InDelta(t, want, final[i], 0.5, l.Legs[i].Name)
+	}
+
+	// The body stayed put the whole time.
+	assert.Equal(t, start.Position.Y, h.State.Pose.Position.Y)
+	assert.InDelta(t, start.Position.X, h.State.Pose.Position.X, 1)
+	assert.InDelta(t, start.Position.Z, h.State.Pose.Position.Z, 1)
+	assert.Equal(t, start.Heading, h.State.Pose.Heading)
+	assert.Equal(t, [6]bool{}, h.State.Saturated)
+}
+
+func TestRecentre(t *testing.T) {
+	cfg := config.Default()
+	cfg.Legs.RecentreAfter = config.Duration{Duration: time.Second}
+	h, _, _, tick := standUp(t, cfg)
+	for i := 0; i < 60; i++ {
+		tick()
+	}
+
+	neutral := h.State.Feet
+	start := h.State.Pose
+
+	// Shove the body over the feet, like holding R1 does, which skews the
+	// stance under it.
+	off := math3d.Vector3{X: 30, Z: -25}
+	h.State.Offset = off
+	tick()
+	assert.InDelta(t, neutral[0].X-off.X, h.State.Feet[0].X, 0.5)
+
+	// Nothing happens while something is holding the body in a pose.
+	for i := 0; i < 60*3; i++ {
+		h.State.KeepAwake = true
+		tick()
+	}
+	assert.Equal(t, off, h.State.Offset)
+
+	// Then the feet are stepped back under the body, at most one in the air
+	// at a time, until the offset is cleared.
+	for n := 0; n < 60*30 && h.State.Offset != (math3d.Vector3{}); n++ {
+		tick()
+
+		ground := h.State.Feet[0].Y
+		for _, f := range h.State.Feet {
+			ground = math.Min(ground, f.Y)
+		}
+
+		up := 0
+		for _, f := range h.State.Feet {
+			if f.Y > ground+1 {
+				up++
+			}
+		}
+		assert.LessOrEqual(t, up, 1, "tick %d", n)
+	}
+
+	assert.Equal(t, math3d.Vector3{}, h.State.Offset)
+	for i, f := range h.State.Feet {
+		assert.InDelta(t, neutral[i].X, f.X, 0.5, "leg %d", i)
+		assert.InDelta(t, neutral[i].Y, f.Y, 0.5, "leg %d", i)
+		assert.InDelta(t, neutral[i].Z, f.Z, 0.5, "leg %d", i)
+	}
+
+	// The body stayed put, and the pose was moved to where it is.
+	want := start.Add(math3d.Pose{Position: off})
+	assert.InDelta(t, want.Position.X, h.State.Pose.Position.X, 1)
+	assert.InDelta(t, want.Position.Z, h.State.Pose.Position.Z, 1)
+	assert.Equal(t, start.Heading, h.State.Pose.Heading)
+	assert.Equal(t, [6]bool{}, h.State.Saturated)
+}
+
+func TestCrouch(t *testing.T) {
+	cfg := config.Default()
+	cfg.Controller.Clearance = 25
+	h, _, _, tick := standUp(t, cfg)
+
+	// The tripod gait needs more clearance than this, so the controller won't
+	// select it, but the crouch gait will do.
+	tripod, _ := hexapod.DefaultGaits.Get(gait.Tripod)
+	crouch, _ := hexapod.DefaultGaits.Get(gait.Crouch)
+	assert.False(t, tripod.Fits(cfg.Controller.Clearance))
+	assert.True(t, crouch.Fits(cfg.Controller.Clearance))
+
+	// Walk forwards in the crouch gait, which takes longer, since the steps
+	// are shorter and slower.
+	h.State.SetGait(crouch)
+	start := h.State.Pose
+	h.State.Target.Position.Z = 300
+	for i := 0; i < 60*60; i++ {
+		tick()
+		assert.Equal(t, [6]bool{}, h.State.Saturated, "tick %d", i)
+	}
+
+	end := h.State.Pose
+	assert.InDelta(t, 300, end.Position.Z-start.Position.Z, 300*0.15)
+	assert.InDelta(t, 0, end.Position.X-start.Position.X, 10)
+	assert.Equal(t, cfg.Controller.Clearance, end.Position.Y)
+
+	// With the crouch config, rather than the legs'.
+	c := cfg.Gait.Crouch
+	assert.Equal(t, c.StepRadius, h.State.GaitParams.StepRadius)
+	assert.Equal(t, c.StepHeight, h.State.GaitParams.StepHeight)
+	assert.Equal(t, 30, h.State.GaitParams.TicksPerStep)
+}
+
+func TestGaitStepping(t *testing.T) {
+	cfg := config.Default()
+	cfg.Gait.Debug = true
+	h, _, _, tick := standUp(t, cfg)
+
+	// Walk forwards for a bit, and pause mid-step.
+	h.State.Target.Position.Z = 1000
+	for i := 0; i < 45; i++ {
+		tick()
+	}
+	h.State.GaitStep = hexapod.GaitPause
+	tick()
+
+	d := h.State.GaitDebug
+	tps := h.State.GaitParams.TicksPerStep
+	assert.True(t, d.Paused)
+	assert.NotZero(t, d.Tick%tps)
+
+	// The feet stay put, but the body still follows the clearance.
+	feet, pose := h.State.Feet, h.State.Pose
+	h.State.Target.Position.Y -= 10
+	for i := 0; i < 60; i++ {
+		tick()
+	}
+	assert.Equal(t, d, h.State.GaitDebug)
+	assert.Equal(t, pose.Position.Y-10, h.State.Pose.Position.Y)
+	for i := range feet {
+		assert.InDelta(t, feet[i].X, h.State.Feet[i].X, 1e-9)
+		assert.InDelta(t, feet[i].Z, h.State.Feet[i].Z, 1e-9)
+	}
+
+	// Returns the status once the gait has stopped playing after the request,
+	// checking that it played a frame per tick.
+	step := func(r hexapod.GaitStepRequest) hexapod.GaitDebug {
+		h.State.GaitStep = r
+		prev := h.State.GaitDebug
+		for i := 0; i < 60*10; i++ {
+			tick()
+			d := h.State.GaitDebug
+			if d == prev {
+				break
+			}
+			if d.Tick != 0 {
+				assert.Equal(t, prev.Tick+1, d.Tick)
+			}
+			prev = d
+		}
+		return prev
+	}
+
+	// Each phase is a step, of which there are six in the wave gait.
+	steps := gait.Steps(gait.Wave)
+	d = step(hexapod.GaitStepPhase)
+	assert.Equal(t, 0, d.Tick%tps)
+	assert.Equal(t, (d.Phase+1)%steps, step(hexapod.GaitStepPhase).Phase)
+
+	// A cycle ends back at the start.
+	cycles := h.State.GaitDebug.Cycles
+	d = step(hexapod.GaitStepCycle)
+	assert.Equal(t, hexapod.GaitDebug{Enabled: true, Paused: true, Length: tps * steps, Cycles: cycles + 1}, d)
+	d = step(hexapod.GaitStepCycle)
+	assert.Equal(t, hexapod.GaitDebug{Enabled: true, Paused: true, Length: tps * steps, Cycles: cycles + 2}, d)
+
+	// Resuming carries on walking.
+	z := h.State.Pose.Position.Z
+	h.State.GaitStep = hexapod.GaitResume
+	for i := 0; i < 60; i++ {
+		tick()
+	}
+	assert.False(t, h.State.GaitDebug.Paused)
+	assert.True(t, h.State.Pose.Position.Z > z+10)
+	assert.Equal(t, [6]bool{}, h.State.Saturated)
+}
+
+func TestGaitSteppingDisabled(t *testing.T) {
+	h, _, _, tick := standUp(t, config.Default())
+	h.State.Target.Position.Z = 1000
+	h.State.GaitStep = hexapod.GaitPause
+	tick()
+
+	// It's ignored, and the status left zero.
+	z := h.State.Pose.Position.Z
+	for i := 0; i < 60; i++ {
+		tick()
+	}
+	assert.Equal(t, hexapod.GaitStepNone, h.State.GaitStep)
+	assert.Equal(t, hexapod.GaitDebug{}, h.State.GaitDebug)
+	assert.True(t, h.State.Pose.Position.Z > z+10)
+}
+
+func TestGaitStatus(t *testing.T) {
+	for _, name := range []string{gait.Wave, gait.Tripod} {
+		t.Run(name, func(t *testing.T) {
+			cfg := config.Default()
+
+			h, _, _, tick := standUp(t, cfg)
+
+			// Stop the simulator correcting the pose after the legs have set
+			// the feet, so they can be compared in the world space.
+			h.Components = h.Components[:1]
+
+			// Nothing is playing while parked.
+			tick()
+			assert.Equal(t, hexapod.GaitStatus{}, h.State.GaitStatus)
+
+			g, _ := h.State.LookupGait(name)
+			h.State.SetGait(g)
+			h.State.Target.Position.Z = 1000
+
+			prev, cycles := 0.0, 0
+			var landing [6]math3d.Vector3
+			for i := 0; i < 60*10; i++ {
+				tick()
+				s := h.State.GaitStatus
+				if !s.Walking {
+					continue
+				}
+
+				// The phase goes up by a frame per tick, and wraps around.
+				tps := h.State.GaitParams.TicksPerStep
+				length := tps * gait.Steps(name)
+				frame := int(math.Round(s.Phase * float64(length)))
+				assert.InDelta(t, float64(frame)/float64(length), s.Phase, 1e-9)
+				if s.Phase < prev {
+					assert.Equal(t, 0, frame, "tick %d", i)
+					cycles++
+				} else if prev > 0 {
+					assert.InDelta(t, prev+1/float64(length), s.Phase, 1e-9, "tick %d", i)
+				}
+				prev = s.Phase
+
+				// The feet which are in the air are those which the gait says.
+				want, err := gait.Make(name, tps, cfg.Gait.DutyFactor)
+				assert.NoError(t, err)
+				for leg := range s.Swing {
+					assert.Equal(t, want.Frame(leg, frame).Swing, s.Swing[leg], "tick %d, leg %d", i, leg)
+				}
+
+				// The phase ends with the step, and the cycle, where the feet
+				// were planned to land when it started, give or take the last
+				// frame of the last foot to step, which is a hair short.
+				assert.Equal(t, (tps-(frame+1)%tps)%tps, s.NextPhaseTicks)
+				if frame > 0 {
+					assert.Equal(t, landing, s.Landing, "tick %d", i)
+				}
+				landing = s.Landing
+				if frame == length-1 {
+					w := h.State.World()
+					for leg, v := range h.State.Feet {
+						p := v.MultiplyByMatrix44(w)
+						assert.InDelta(t, s.Landing[leg].X, p.X, 1)
+						assert.InDelta(t, s.Landing[leg].Z, p.Z, 1)
+						assert.Equal(t, 0.0, s.Landing[leg].Y)
+					}
+				}
+			}
+
+			assert.True(t, cycles > 1, "only %d cycles", cycles)
+		})
+	}
+}
+
+func TestAutoGait(t *testing.T) {
+	cfg := config.Default()
+	cfg.Controller.AutoGait = true
+	cc := cfg.Controller
+
+	// Like standUp, but driven by a scripted controller, which stands up to the
+	// clearance itself.
+	bus := NewBus()
+	n := network.New(bus)
+	h := hexapod.NewHexapod(n, 60)
+	h.Params = params.New()
+	sa := sixaxis.New(nil)
+	c := controller.NewScripted(sa, cc, cfg.Head)
+	c.Params = h.Params
+	l := legs.New(n, cfg.Legs, cfg.Gait)
+	l.Params = h.Params
+	s := New(bus, l)
+	s.Params = h.Params
+	h.Add(c)
+	h.Add(l)
+	h.Add(s)
+	assert.NoError(t, h.Boot())
+
+	now := time.Unix(0, 0)
+	tick := func() {
+		now = now.Add(time.Second / 60)
+		assert.NoError(t, h.Tick(now))
+	}
+
+	for i := 0; i < 2000 && h.State.Pose.Position.Y < cc.Clearance; i++ {
+		tick()
+		time.Sleep(time.Millisecond)
+	}
+	assert.Equal(t, cc.Clearance, h.State.Pose.Position.Y)
+
+	// A switch of gait, and the commanded speed on the ticks before and of it.
+	type change struct {
+		gait          string
+		before, after float64
+	}
+
+	// Pushes the left stick from one position to another, a notch every few
+	// ticks, and returns the gaits which that switched to. The number of feet
+	// in the air at once is checked (once the legs have had a chance to
+	// switch) in between.
+	sweep := func(from, to int32) []change {
+		var out []change
+		step := int32(1)
+		if to < from {
+			step = -1
+		}
+
+		for y := from; y != to+step; y += step {
+			sa.LeftStick.Y = -y
+			for i := 0; i < 4; i++ {
+				before := h.State.AutoGait.Speed
+				prev, _ := h.State.ActiveGait()
+				tick()
+
+				g, _ := h.State.ActiveGait()
+				if g.Name != prev.Name {
+					out = append(out, change{g.Name, before, h.State.AutoGait.Speed})
+				}
+			}
+		}
+
+		return out
+	}
+
+	// How many feet are in the air at once, at most, over a few seconds at
+	// the current position of the stick.
+	lifted := func() int {
+		most := 0
+		for i := 0; i < 60*5; i++ {
+			tick()
+			ground := h.State.Feet[0].Y
+			for _, f := range h.State.Feet {
+				ground = math.Min(ground, f.Y)
+			}
+
+			up := 0
+			for _, f := range h.State.Feet {
+				if f.Y > ground+1 {
+					up++
+				}
+			}
+			if up > most {
+				most = up
+			}
+		}
+
+		return most
+	}
+
+	// Pushing the stick forwards switches up just past each threshold.
+	up := sweep(0, 127)
+	if assert.Len(t, up, 2) {
+		assert.Equal(t, gait.Ripple, up[0].gait)
+		assert.True(t, up[0].before <= cc.AutoGaitSlow && up[0].after > cc.AutoGaitSlow, "%+v", up[0])
+		assert.Equal(t, gait.Tripod, up[1].gait)
+		assert.True(t, up[1].before <= cc.AutoGaitFast && up[1].after > cc.AutoGaitFast, "%+v", up[1])
+	}
+	assert.Equal(t, 3, lifted())
+
+	// Letting it back switches down, but only past the hysteresis.
+	down := sweep(127, 20)
+	if assert.Len(t, down, 2) {
+		assert.Equal(t, gait.Ripple, down[0].gait)
+		assert.True(t, down[0].before >= cc.AutoGaitFast-cc.AutoGaitHysteresis && down[0].after < cc.AutoGaitFast-cc.AutoGaitHysteresis, "%+v", down[0])
+		assert.Equal(t, gait.Wave, down[1].gait)
+		assert.True(t, down[1].before >= cc.AutoGaitSlow-cc.AutoGaitHysteresis && down[1].after < cc.AutoGaitSlow-cc.AutoGaitHysteresis, "%+v", down[1])
+	}
+	assert.Equal(t, 1, lifted())
+	assert.Equal(t, [6]bool{}, h.State.Saturated)
+	assert.True(t, h.State.AutoGait.Enabled)
+}
+
+// ledWrites counts the writes to the LED register of each servo, by ID, until
+// the returned func is called, which returns them and starts again.
+func ledWrites(bus *Bus) func() map[int][]bool {
+	var writes map[int][]bool
+	reset := func() map[int][]bool {
+		out := writes
+		writes = map[int][]bool{}
+		return out
+	}
+	reset()
+
+	bus.OnWrite = func(id int, params []byte) {
+		if params[0] == aLED {
+			writes[id] = append(writes[id], params[1] != 0)
+		}
+	}
+
+	return reset
+}
+
+func TestDebugLEDs(t *testing.T) {
+	cfg := config.Default()
+	cfg.Legs.DebugLEDs = "phase"
+	h, l, bus, tick := standUp(t, cfg)
+	writes := ledWrites(bus)
+
+	// Standing still doesn't light anything, so doesn't write anything.
+	for i := 0; i < 60; i++ {
+		tick()
+	}
+	assert.Empty(t, writes())
+
+	// The step height is a bell curve, which is never quite zero, so a foot
+	// is only in the air while it's above its height at the start and end of
+	// the swing (when it's not moving), and is still moving up or down. The
+	// last foot of the cycle is left a hair above the ground when it stops.
+	// See singleLegGait.
+	ground := h.State.Feet
+	prev := h.State.Feet
+	liftoff := cfg.Legs.StepHeight * math.Pow(2, -math.E*math.E)
+	lifted := [6]bool{}
+	steps := 0
+
+	// Walk forwards until we stop. Every servo of a leg is written once on
+	// the tick which it's lifted (on) and the one which it lands (off), and
+	// never in between.
+	h.State.Target.Position.Z = 300
+	for n := 0; n < 60*30; n++ {
+		tick()
+		w := writes()
+
+		for i, leg := range l.Legs {
+			y := h.State.Feet[i].Y
+			up := y-ground[i].Y > liftoff+1e-6 && y != prev[i].Y
+			for _, s := range leg.Servos() {
+				if up == lifted[i] {
+					assert.Empty(t, w[s.ID], "tick %d: %s wrote without changing phase", n, leg.Name)
+				} else {
+					assert.Equal(t, []bool{up}, w[s.ID], "tick %d: %s changed phase", n, leg.Name)
+				}
+				delete(w, s.ID)
+			}
+
+			if up && !lifted[i] {
+				steps++
+			}
+			lifted[i] = up
+		}
+
+		assert.Empty(t, w, "tick %d: wrote to servos which aren't in a leg", n)
+		prev = h.State.Feet
+	}
+
+	// It did walk, and put every foot down at the end.
+	assert.True(t, steps >= 6, "only %d steps", steps)
+	assert.Equal(t, [6]bool{}, lifted)
+}
+
+func TestDebugLEDsOff(t *testing.T) {
+	h, _, bus, tick := standUp(t, config.Default())
+	writes := ledWrites(bus)
+
+	h.State.Target.Position.Z = 300
+	for n := 0; n < 60*5; n++ {
+		tick()
+	}
+	assert.Empty(t, writes())
+}
+
+// walkOnto walks the hex from flat ground half way onto a platform of the given
+// height, and returns how far (in mm) above the ground under it each foot was
+// on average, while it was on the ground, once half way on.
+func walkOnto(t *testing.T, cfg config.Config, height float64) [6]float64 {
+	platform := func(x, z float64) float64 {
+		if z > 200 {
+			return height
+		}
+		return 0
+	}
+
+	h, _, _, tick := standUp(t, cfg)
+	s := h.Components[1].(*Sim)
+	s.Ground = platform
+
+	// Until the front and middle feet are on the platform, and the back ones
+	// are about to step onto it.
+	var sum [6]float64
+	var n [6]int
+	h.State.Target.Position.Z = 1000
+	for i := 0; i < 60*30 && h.State.Pose.Position.Z < 330; i++ {
+		tick()
+		if h.State.Pose.Position.Z < 250 {
+			continue
+		}
+
+		w := h.State.World()
+		for j, f := range h.State.Feet {
+			if s.planted[j] {
+				v := f.MultiplyByMatrix44(w)
+				sum[j] += v.Y - platform(v.X, v.Z)
+				n[j]++
+			}
+		}
+	}
+
+	var avg [6]float64
+	for i := range avg {
+		if assert.NotZero(t, n[i], "foot %d was never on the ground", i) {
+			avg[i] = sum[i] / float64(n[i])
+		}
+	}
+
+	return avg
+}
+
+// TestTerrain walks half way onto a platform, on which the feet stall before
+// they reach where they were put down, so with the feedback, the legs remember
+// how high it is under each, and put them down on it instead.
+func TestTerrain(t *testing.T) {
+	const height = 15.0
+
+	cfg := config.Default()
+	cfg.Legs.Feedback = 24
+	cfg.Legs.Terrain.Touchdowns = 1
+
+	// Without it, the feet on the platform are put down as far below it.
+	off := walkOnto(t, cfg, height)
+	for i, v := range off {
+		if v < -1 {
+			assert.InDelta(t, -height, v, 2, "foot %d", i)
+		}
+	}
+
+	// With it, they're all put down (almost) on it. Not quite, since the legs
+	// lower them a little further to be sure they've landed.
+	cfg.Legs.Terrain.Enabled = true
+	on := walkOnto(t, cfg, height)
+	for i, v := range on {
+		assert.InDelta(t, 0, v, 4, "foot %d", i)
+	}
+}
@@ -0,0 +1,246 @@
+// Package startup checks which way up the hex is before the legs stand it up
+// for the first time, from the IMU's gravity vector (and the weight on each
+// leg), so it doesn't flail on the bench while it's lying on its side.
+package startup
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/adammck/hexapod"
+	"github.com/adammck/hexapod/components/legs"
+	"github.com/adammck/hexapod/config"
+	"github.com/adammck/hexapod/math3d"
+	"github.com/adammck/hexapod/servos"
+	"github.com/adammck/hexapod/utils"
+)
+
+var log = hexapod.NewLog("startup")
+
+// Servo is the part of a femur which the startup check needs, to feel how much
+// weight its leg is bearing. Positions and loads are in servo units, as they're
+// read and written.
+type Servo interface {
+	PresentPosition() (int, error)
+	SetGoalPosition(pos int) error
+	PresentLoad() (int, error)
+}
+
+// Leg is the femur of a leg, and which side of the chassis it's on.
+type Leg struct {
+	Name  string
+	Left  bool
+	Femur Servo
+}
+
+// FromLegs returns the legs to check, from the legs component.
+func FromLegs(ls [6]*legs.Leg) []Leg {
+	out := make([]Leg, len(ls))
+	for i, l := range ls {
+		out[i] = Leg{
+			Name:  l.Name,
+			Left:  l.Origin.X < 0,
+			Femur: l.Femur,
+		}
+	}
+
+	return out
+}
+
+// IMU is the part of an inertial measurement unit which the startup check
+// needs, like the righting's.
+type IMU interface {
+
+	// Acceleration returns the acceleration (in g) along each axis of the
+	// chassis space, which is just gravity while the hex is still. That's up
+	// (+Y) while it's the right way up.
+	Acceleration() (math3d.Vector3, error)
+}
+
+// Startup is a component which sets State.StartupHold from the first tick
+// until the hex has been on its feet for long enough, so the legs leave the
+// servos alone (and limp) rather than standing up. Where it found the hex is
+// written to State.Startup every tick meanwhile, since it might be picked up
+// and put down the right way. State.StandAnyway lets the operator overrule it.
+// Once the legs have been let go, it does nothing else until the hex is woken
+// from transport, when it checks again before the legs are unfolded (without
+// the weight on the legs, since they're still folded up). Noticing that the
+// hex has fallen over later is the righting's job.
+//
+// If the config says to feel the weight on the legs, the femurs are held where
+// they are (at whatever torque limit the legs booted them with) while checking,
+// since they can't feel anything while they're limp.
+//
+// This must be added before the legs, for the same reasons as the righting.
+type Startup struct {
+	legs []Leg
+	imu  IMU
+	cfg  config.Startup
+
+	// Whether the legs have been let go, and where the hex was found as of the
+	// last tick, and since when it has been on its feet, or zero if it isn't.
+	done      bool
+	situation hexapod.Situation
+	upright   time.Time
+
+	// Whether the femurs are being held, so their loads can be read.
+	holding bool
+
+	// The transport phase as of the last tick, to notice being woken.
+	transport hexapod.Transport
+}
+
+// New creates a startup check for the given legs and IMU.
+func New(ls []Leg, imu IMU, cfg config.Startup) *Startup {
+	return &Startup{
+		legs: ls,
+		imu:  imu,
+		cfg:  cfg,
+	}
+}
+
+func (s *Startup) Boot() error {
+	return nil
+}
+
+func (s *Startup) Tick(now time.Time, state *hexapod.State) error {
+	anyway := state.StandAnyway
+	state.StandAnyway = false
+
+	waking := state.Transport == hexapod.TransportWaking
+	if waking && s.transport != hexapod.TransportWaking {
+		log.Info("woken from transport, checking again")
+		s.done = false
+		s.situation = hexapod.SituationUnknown
+		s.upright = time.Time{}
+		s.holding = false
+	}
+
+	s.transport = state.Transport
+
+	if s.done {
+		return nil
+	}
+
+	sit := s.classify(waking)
+	if sit != s.situation {
+		s.situation = sit
+		if sit == hexapod.SituationUpright {
+			log.Info("on its feet")
+		} else {
+			log.Warnf("%s, not standing up", sit)
+			state.Publish(hexapod.EventStartupHeld, hexapod.Warning, sit.String())
+		}
+	}
+
+	state.Startup = sit
+
+	if anyway {
+		log.Warnf("standing up anyway, %s", sit)
+		s.release(state)
+		return nil
+	}
+
+	if sit != hexapod.SituationUpright {
+		s.upright = time.Time{}
+	} else if s.upright.IsZero() {
+		s.upright = now
+	}
+
+	if !s.upright.IsZero() && now.Sub(s.upright) >= s.cfg.Settle.Duration {
+		s.release(state)
+		return nil
+	}
+
+	state.StartupHold = true
+	return nil
+}
+
+// release lets the legs stand up. The femurs are left as they are, since the
+// legs write their goals next.
+func (s *Startup) release(state *hexapod.State) {
+	s.done = true
+	state.StartupHold = false
+}
+
+// classify returns where the hex is, from the IMU, and then from the weight on
+// its legs, unless they're folded. If the IMU can't be read, it's unknown.
+func (s *Startup) classify(folded bool) hexapod.Situation {
+	a, err := s.imu.Acceleration()
+	if err != nil {
+		log.RateLimited("imu", 5*time.Second).Warnf("%s (while reading IMU)", err)
+		return hexapod.SituationUnknown
+	}
+
+	g := a.Magnitude()
+	if g < s.cfg.MinGravity || g > s.cfg.MaxGravity {
+		return hexapod.SituationInAir
+	}
+
+	// The cosine of the angle between gravity and up, in the chassis space.
+	cos := a.Y / g
+	limit := math.Cos(utils.Rad(s.cfg.MaxTilt))
+
+	switch {
+	case cos < -limit:
+		return hexapod.SituationOnBack
+	case cos < limit:
+		return hexapod.SituationOnSide
+	}
+
+	if s.cfg.MinLoadedLegs == 0 || folded {
+		return hexapod.SituationUpright
+	}
+
+	loaded, err := s.loaded()
+	if err != nil {
+		log.RateLimited("load", 5*time.Second).Warnf("%s", err)
+		return hexapod.SituationUnknown
+	}
+
+	if !loaded {
+		return hexapod.SituationInAir
+	}
+
+	return hexapod.SituationUpright
+}
+
+// loaded returns whether enough legs on each side are bearing weight for the
+// hex to be on the ground. Held up by hand (or by one side), they aren't. The
+// femurs are held where they are first, if they aren't already.
+func (s *Startup) loaded() (bool, error) {
+	if !s.holding {
+		for _, l := range s.legs {
+			p, err := l.Femur.PresentPosition()
+			if err == nil {
+				err = l.Femur.SetGoalPosition(p)
+			}
+			if err != nil {
+				return false, fmt.Errorf("%s (while holding %s.femur)", err, l.Name)
+			}
+		}
+
+		s.holding = true
+	}
+
+	var left, right int
+	for _, l := range s.legs {
+		v, err := l.Femur.PresentLoad()
+		if err != nil {
+			return false, fmt.Errorf("%s (while reading load of %s.femur)", err, l.Name)
+		}
+
+		if math.Abs(servos.Load(v)) < s.cfg.MinLoad {
+			continue
+		}
+
+		if l.Left {
+			left++
+		} else {
+			right++
+		}
+	}
+
+	return left >= s.cfg.MinLoadedLegs && right >= s.cfg.MinLoadedLegs, nil
+}
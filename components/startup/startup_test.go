@@ -0,0 +1,267 @@
+package startup
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/adammck/hexapod"
+	"github.com/adammck/hexapod/config"
+	"github.com/adammck/hexapod/hexapodtest"
+	"github.com/adammck/hexapod/math3d"
+	"github.com/stretchr/testify/assert"
+)
+
+// mockServo reports a fixed load, and remembers the goals written to it.
+type mockServo struct {
+	pos   int
+	load  int
+	goals []int
+}
+
+func (s *mockServo) PresentPosition() (int, error) {
+	return s.pos, nil
+}
+
+func (s *mockServo) SetGoalPosition(pos int) error {
+	s.goals = append(s.goals, pos)
+	return nil
+}
+
+func (s *mockServo) PresentLoad() (int, error) {
+	return s.load, nil
+}
+
+type mockIMU struct {
+	a   math3d.Vector3
+	err error
+}
+
+func (m *mockIMU) Acceleration() (math3d.Vector3, error) {
+	return m.a, m.err
+}
+
+// Loads, as read from the servo: bearing weight (in either direction, which is
+// the bit above the magnitude), and not.
+const (
+	heavy   = 200
+	heavyCW = 200 | 1<<10
+	light   = 10
+)
+
+var (
+	upright = math3d.Vector3{Y: 1}
+	onSide  = math3d.Vector3{X: -0.98, Y: 0.1}
+	onBack  = math3d.Vector3{X: 0.1, Y: -0.95}
+)
+
+type fixture struct {
+	*hexapodtest.Clock
+	s      *Startup
+	servos []*mockServo
+	imu    *mockIMU
+}
+
+// setup returns a startup check of four legs (the first two on the left), with
+// the given number of loaded legs on each side required, which are all bearing
+// weight, on a hex which is upright.
+func setup(t *testing.T, minLoaded int) *fixture {
+	f := &fixture{
+		Clock: hexapodtest.NewClock(t, 20*time.Millisecond),
+		imu:   &mockIMU{a: upright},
+	}
+
+	var ls []Leg
+	for i, name := range []string{"FL", "BL", "FR", "BR"} {
+		ms := &mockServo{pos: 400 + i, load: heavy}
+		f.servos = append(f.servos, ms)
+		ls = append(ls, Leg{Name: name, Left: i < 2, Femur: ms})
+	}
+
+	cfg := config.Default().Startup
+	cfg.Enabled = true
+	cfg.MinLoadedLegs = minLoaded
+
+	f.s = New(ls, f.imu, cfg)
+	assert.NoError(t, f.s.Boot())
+	return f
+}
+
+// loads sets the load of each femur, in the same order as the legs.
+func (f *fixture) loads(ls ...int) {
+	for i, l := range ls {
+		f.servos[i].load = l
+	}
+}
+
+// tick ticks for the given duration, at 50Hz.
+func (f *fixture) tick(d time.Duration) {
+	f.TickFor(f.s, d)
+}
+
+func TestClassify(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		a     math3d.Vector3
+		err   error
+		loads []int
+		want  hexapod.Situation
+	}{
+		{name: "level", a: upright, want: hexapod.SituationUpright},
+		{name: "tilted a little", a: math3d.Vector3{X: 0.34, Y: 0.94}, want: hexapod.SituationUpright},
+		{name: "tilted a lot", a: math3d.Vector3{Z: 0.7, Y: 0.7}, want: hexapod.SituationOnSide},
+		{name: "on its side", a: onSide, want: hexapod.SituationOnSide},
+		{name: "on its back", a: onBack, want: hexapod.SituationOnBack},
+		{name: "being shaken", a: math3d.Vector3{Y: 1.5}, want: hexapod.SituationInAir},
+		{name: "falling", a: math3d.Vector3{Y: 0.3}, want: hexapod.SituationInAir},
+		{name: "no IMU", err: errors.New("no"), want: hexapod.SituationUnknown},
+
+		{name: "on the ground", a: upright, loads: []int{heavy, heavy, heavy, heavy}, want: hexapod.SituationUpright},
+		{name: "on uneven ground", a: upright, loads: []int{heavy, light, heavyCW, light}, want: hexapod.SituationUpright},
+		{name: "held up", a: upright, loads: []int{light, light, light, light}, want: hexapod.SituationInAir},
+		{name: "held up by one side", a: upright, loads: []int{heavy, heavy, light, light}, want: hexapod.SituationInAir},
+
+		// The loads aren't read unless the IMU says it's upright.
+		{name: "on its side, with weight on its legs", a: onSide, loads: []int{heavy, heavy, heavy, heavy}, want: hexapod.SituationOnSide},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			// The loads only count if the config says that they do.
+			minLoaded := 0
+			if tc.loads != nil {
+				minLoaded = 1
+			}
+
+			f := setup(t, minLoaded)
+			f.loads(tc.loads...)
+
+			f.imu.a = tc.a
+			f.imu.err = tc.err
+			f.tick(20 * time.Millisecond)
+			assert.Equal(t, tc.want, f.State.Startup)
+		})
+	}
+}
+
+func TestUpright(t *testing.T) {
+	f := setup(t, 0)
+
+	// Held until it has been upright for the settle time.
+	f.tick(20 * time.Millisecond)
+	assert.True(t, f.State.StartupHold)
+	assert.Equal(t, hexapod.SituationUpright, f.State.Startup)
+
+	f.tick(500 * time.Millisecond)
+	assert.False(t, f.State.StartupHold)
+	assert.Empty(t, f.Events())
+
+	// And never again, even if it falls over.
+	f.imu.a = onBack
+	f.tick(time.Second)
+	assert.False(t, f.State.StartupHold)
+	assert.Equal(t, hexapod.SituationUpright, f.State.Startup)
+}
+
+func TestOnSide(t *testing.T) {
+	f := setup(t, 0)
+	f.imu.a = onSide
+
+	f.tick(5 * time.Second)
+	assert.True(t, f.State.StartupHold)
+	assert.Equal(t, hexapod.SituationOnSide, f.State.Startup)
+	assert.Equal(t, []string{hexapod.EventStartupHeld}, f.Events())
+
+	// Rolled over onto its back, it's still held, and says so.
+	f.imu.a = onBack
+	f.tick(time.Second)
+	assert.True(t, f.State.StartupHold)
+	assert.Equal(t, hexapod.SituationOnBack, f.State.Startup)
+	assert.Equal(t, []string{hexapod.EventStartupHeld, hexapod.EventStartupHeld}, f.Events())
+
+	// Put down the right way up, but only briefly, doesn't count.
+	f.imu.a = upright
+	f.tick(200 * time.Millisecond)
+	f.imu.a = onSide
+	f.tick(20 * time.Millisecond)
+	f.imu.a = upright
+	f.tick(400 * time.Millisecond)
+	assert.True(t, f.State.StartupHold)
+
+	f.tick(200 * time.Millisecond)
+	assert.False(t, f.State.StartupHold)
+	assert.Equal(t, hexapod.SituationUpright, f.State.Startup)
+}
+
+func TestInAir(t *testing.T) {
+	f := setup(t, 2)
+	f.loads(light, light, light, light)
+
+	f.tick(time.Second)
+	assert.True(t, f.State.StartupHold)
+	assert.Equal(t, hexapod.SituationInAir, f.State.Startup)
+
+	// The femurs are held where they were, once, so they can feel the weight.
+	for _, s := range f.servos {
+		assert.Equal(t, []int{s.pos}, s.goals)
+	}
+
+	// Put down on three legs isn't enough.
+	f.loads(heavy, heavy, heavy, light)
+	f.tick(time.Second)
+	assert.True(t, f.State.StartupHold)
+
+	f.loads(heavy, heavy, heavy, heavy)
+	f.tick(time.Second)
+	assert.False(t, f.State.StartupHold)
+	assert.Equal(t, hexapod.SituationUpright, f.State.Startup)
+}
+
+func TestStandAnyway(t *testing.T) {
+	f := setup(t, 0)
+	f.imu.err = errors.New("no IMU")
+
+	f.tick(time.Second)
+	assert.True(t, f.State.StartupHold)
+	assert.Equal(t, hexapod.SituationUnknown, f.State.Startup)
+
+	f.State.StandAnyway = true
+	f.tick(20 * time.Millisecond)
+	assert.False(t, f.State.StartupHold)
+	assert.False(t, f.State.StandAnyway)
+
+	// It's reset even once it's too late to matter.
+	f.State.StandAnyway = true
+	f.tick(20 * time.Millisecond)
+	assert.False(t, f.State.StandAnyway)
+}
+
+func TestWokenFromTransport(t *testing.T) {
+	f := setup(t, 1)
+	f.tick(time.Second)
+	assert.False(t, f.State.StartupHold)
+
+	// Nothing changes while folding, or folded.
+	f.imu.a = onSide
+	for _, p := range []hexapod.Transport{hexapod.TransportLowering, hexapod.TransportFolding, hexapod.TransportFolded} {
+		f.State.Transport = p
+		f.tick(time.Second)
+		assert.False(t, f.State.StartupHold)
+	}
+
+	// Once woken, it checks again, without the loads, since the legs are
+	// folded up, and the femurs aren't held.
+	f.loads(light, light, light, light)
+	n := len(f.servos[0].goals)
+	f.State.Transport = hexapod.TransportWaking
+	f.tick(time.Second)
+	assert.True(t, f.State.StartupHold)
+	assert.Equal(t, hexapod.SituationOnSide, f.State.Startup)
+
+	f.imu.a = upright
+	f.tick(20 * time.Millisecond)
+	assert.True(t, f.State.StartupHold)
+	assert.Equal(t, hexapod.SituationUpright, f.State.Startup)
+
+	f.tick(time.Second)
+	assert.False(t, f.State.StartupHold)
+	assert.Equal(t, n, len(f.servos[0].goals))
+}
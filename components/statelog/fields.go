@@ -0,0 +1,124 @@
+package statelog
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/adammck/hexapod"
+)
+
+// field is a named group of columns which can be selected for logging. Each
+// field knows how to extract its values from the state.
+type field struct {
+	name    string
+	columns []string
+	values  func(now time.Time, state *hexapod.State, dst []float64) []float64
+}
+
+var legNames = []string{"fl", "fr", "mr", "br", "bl", "ml"}
+
+// fields is the list of all fields which can be logged, in the order in which
+// they're written. The timestamp is always written first, so isn't listed.
+var fields = []field{
+	{
+		name:    "pose",
+		columns: []string{"pose_x", "pose_y", "pose_z", "pose_heading", "pose_pitch", "pose_bank"},
+		values: func(now time.Time, s *hexapod.State, dst []float64) []float64 {
+			p := s.Pose
+			return append(dst, p.Position.X, p.Position.Y, p.Position.Z, p.Heading, p.Pitch, p.Bank)
+		},
+	},
+	{
+		name:    "target",
+		columns: []string{"target_x", "target_y", "target_z", "target_heading", "target_pitch", "target_bank"},
+		values: func(now time.Time, s *hexapod.State, dst []float64) []float64 {
+			p := s.Target
+			return append(dst, p.Position.X, p.Position.Y, p.Position.Z, p.Heading, p.Pitch, p.Bank)
+		},
+	},
+	{
+		name:    "clearance",
+		columns: []string{"clearance"},
+		values: func(now time.Time, s *hexapod.State, dst []float64) []float64 {
+			return append(dst, s.Target.Position.Y)
+		},
+	},
+	{
+		name:    "offset",
+		columns: []string{"offset_x", "offset_y", "offset_z"},
+		values: func(now time.Time, s *hexapod.State, dst []float64) []float64 {
+			return append(dst, s.Offset.X, s.Offset.Y, s.Offset.Z)
+		},
+	},
+	{
+		name:    "feet",
+		columns: footColumns(),
+		values: func(now time.Time, s *hexapod.State, dst []float64) []float64 {
+			for _, f := range s.Feet {
+				dst = append(dst, f.X, f.Y, f.Z)
+			}
+			return dst
+		},
+	},
+	{
+		name:    "voltage",
+		columns: []string{"voltage"},
+		values: func(now time.Time, s *hexapod.State, dst []float64) []float64 {
+			return append(dst, s.Voltage)
+		},
+	},
+}
+
+func footColumns() []string {
+	c := []string{}
+	for _, n := range legNames {
+		c = append(c, "foot_"+n+"_x", "foot_"+n+"_y", "foot_"+n+"_z")
+	}
+	return c
+}
+
+// FieldNames returns the names of all of the fields which can be selected.
+// Selecting all of them is the default.
+func FieldNames() []string {
+	names := make([]string, len(fields))
+	for i, f := range fields {
+		names[i] = f.name
+	}
+	return names
+}
+
+// selectFields returns the fields with the given names, in the canonical order
+// (regardless of the order of names), or an error if any are unknown.
+func selectFields(names []string) ([]field, error) {
+	want := map[string]bool{}
+	for _, n := range names {
+		n = strings.TrimSpace(n)
+		if n == "" {
+			continue
+		}
+
+		found := false
+		for _, f := range fields {
+			if f.name == n {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return nil, fmt.Errorf("unknown field: %q (valid fields: %s)", n, strings.Join(FieldNames(), ", "))
+		}
+
+		want[n] = true
+	}
+
+	out := []field{}
+	for _, f := range fields {
+		if want[f.name] {
+			out = append(out, f)
+		}
+	}
+
+	return out, nil
+}
@@ -0,0 +1,110 @@
+package statelog
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Opener opens the file at the given path for writing. It's pluggable so the
+// tests can replace it with something slow.
+type Opener func(path string) (io.WriteCloser, error)
+
+func createFile(path string) (io.WriteCloser, error) {
+	return os.Create(path)
+}
+
+// rotator writes (buffered) to a sequence of numbered files, starting a new one
+// whenever the current one would grow past the size limit. Lines are never split
+// across files, so each one can be parsed on its own.
+type rotator struct {
+	open   Opener
+	dir    string
+	prefix string
+	ext    string
+	max    int64
+
+	// Written to the start of every file (i.e. the CSV header). May be nil.
+	header []byte
+
+	f    io.WriteCloser
+	w    *bufio.Writer
+	n    int
+	size int64
+}
+
+// path returns the path of the nth file.
+func (r *rotator) path(n int) string {
+	return filepath.Join(r.dir, fmt.Sprintf("%s-%03d.%s", r.prefix, n, r.ext))
+}
+
+// writeLine writes a single (newline-terminated) line, rotating first if it
+// wouldn't fit in the current file.
+func (r *rotator) writeLine(line []byte) error {
+	if r.f == nil || (r.size+int64(len(line)) > r.max && r.size > int64(len(r.header))) {
+		err := r.rotate()
+		if err != nil {
+			return err
+		}
+	}
+
+	n, err := r.w.Write(line)
+	r.size += int64(n)
+	return err
+}
+
+func (r *rotator) rotate() error {
+	if r.f != nil {
+		err := r.Close()
+		if err != nil {
+			return err
+		}
+		r.n += 1
+	}
+
+	f, err := r.open(r.path(r.n))
+	if err != nil {
+		return err
+	}
+
+	r.f = f
+	r.w = bufio.NewWriter(f)
+	r.size = 0
+
+	if r.header != nil {
+		n, err := r.w.Write(r.header)
+		r.size += int64(n)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Flush writes any buffered lines to the current file.
+func (r *rotator) Flush() error {
+	if r.w == nil {
+		return nil
+	}
+
+	return r.w.Flush()
+}
+
+func (r *rotator) Close() error {
+	if r.f == nil {
+		return nil
+	}
+
+	err := r.w.Flush()
+	cerr := r.f.Close()
+	r.f = nil
+	r.w = nil
+
+	if err != nil {
+		return err
+	}
+	return cerr
+}
@@ -0,0 +1,271 @@
+package statelog
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/adammck/hexapod"
+	"github.com/adammck/hexapod/params"
+)
+
+var log = logrus.WithFields(logrus.Fields{
+	"pkg": "statelog",
+})
+
+type Format string
+
+const (
+	CSV   Format = "csv"
+	JSONL Format = "jsonl"
+
+	// The number of rows to buffer between the main loop and the writer. If the
+	// writer falls further behind than this, rows are dropped.
+	bufferSize = 1024
+
+	// How often to flush buffered rows to disk.
+	flushInterval = time.Second
+)
+
+// StateLog is a component which writes one row per tick to a file, for offline
+// analysis. Rows are formatted during Tick (since that's the only time the
+// state can be read), but written to disk in a separate goroutine, so a slow
+// disk can't stall the main loop.
+type StateLog struct {
+
+	// The number of rows dropped because the writer couldn't keep up. Accessed
+	// atomically, since the writer goroutine logs it. This must be the first
+	// field to guarantee 64-bit alignment on ARM.
+	dropped uint64
+
+	format  Format
+	fields  []field
+	columns []string
+	out     *rotator
+
+	rows chan []byte
+	done chan struct{}
+
+	// A row is written every this many ticks, and the number of ticks since
+	// the last one. See the statelog.every param.
+	every int
+	skip  int
+
+	// The registry to register the params with at boot. This is params.Default
+	// when created via New, so more than one instance can be booted in tests.
+	Params *params.Registry
+
+	// Reused between ticks, to avoid allocating.
+	vals []float64
+}
+
+// New creates a state logger which writes the given fields (see FieldNames) in
+// the given format to files in dir, starting a new file every maxBytes.
+func New(dir string, format Format, fieldNames []string, maxBytes int64) (*StateLog, error) {
+	sl, err := newWithOpener(dir, format, fieldNames, maxBytes, createFile)
+	if err != nil {
+		return nil, err
+	}
+
+	sl.Params = params.Default
+	return sl, nil
+}
+
+func newWithOpener(dir string, format Format, fieldNames []string, maxBytes int64, open Opener) (*StateLog, error) {
+	if format != CSV && format != JSONL {
+		return nil, fmt.Errorf("unknown format: %q", format)
+	}
+
+	f, err := selectFields(fieldNames)
+	if err != nil {
+		return nil, err
+	}
+
+	sl := &StateLog{
+		format: format,
+		fields: f,
+		rows:   make(chan []byte, bufferSize),
+		done:   make(chan struct{}),
+		every:  1,
+		Params: params.New(),
+	}
+
+	sl.columns = []string{"time"}
+	for _, f := range sl.fields {
+		sl.columns = append(sl.columns, f.columns...)
+	}
+
+	sl.out = &rotator{
+		open:   open,
+		dir:    dir,
+		prefix: fmt.Sprintf("state-%s", time.Now().Format("20060102-150405")),
+		ext:    string(format),
+		max:    maxBytes,
+		header: sl.header(),
+	}
+
+	return sl, nil
+}
+
+// Columns returns the names of the columns which will be written, in order.
+func (sl *StateLog) Columns() []string {
+	return sl.columns
+}
+
+// header returns the line to write at the start of each file. For CSV, that's
+// the column names. For JSONL, it's an object containing the column names,
+// which is ignored by most tools, but spares the reader from guessing.
+func (sl *StateLog) header() []byte {
+	if sl.format == CSV {
+		return []byte(strings.Join(sl.columns, ",") + "\n")
+	}
+
+	cols := make([]string, 0, len(sl.columns))
+	for _, c := range sl.columns {
+		cols = append(cols, strconv.Quote(c))
+	}
+
+	return []byte(fmt.Sprintf("{\"schema\":[%s]}\n", strings.Join(cols, ",")))
+}
+
+// Boot registers the statelog.every param, which thins out the rows (e.g. to
+// save CPU) by only writing one every so many ticks, and starts the writer.
+func (sl *StateLog) Boot() error {
+	err := sl.Params.Register(params.Param{
+		Name: "statelog.every",
+		Type: params.Int,
+		Min:  1,
+		Max:  600,
+		Get:  func() float64 { return float64(sl.every) },
+		Set:  func(v float64) { sl.every = int(v) },
+	})
+	if err != nil {
+		return err
+	}
+
+	go sl.run()
+	return nil
+}
+
+// Tick formats a row for the current state, and passes it to the writer. This
+// never blocks; if the writer has fallen behind, the row is dropped.
+func (sl *StateLog) Tick(now time.Time, state *hexapod.State) error {
+	sl.skip++
+	if sl.skip < sl.every {
+		return nil
+	}
+	sl.skip = 0
+
+	sl.vals = sl.vals[:0]
+	for _, f := range sl.fields {
+		sl.vals = f.values(now, state, sl.vals)
+	}
+
+	select {
+	case sl.rows <- sl.formatRow(now, sl.vals):
+	default:
+		atomic.AddUint64(&sl.dropped, 1)
+	}
+
+	return nil
+}
+
+// Dropped returns the number of rows which have been dropped so far.
+func (sl *StateLog) Dropped() uint64 {
+	return atomic.LoadUint64(&sl.dropped)
+}
+
+func (sl *StateLog) formatRow(now time.Time, vals []float64) []byte {
+	cols := sl.columns
+	b := make([]byte, 0, 16*len(cols))
+	ts := now.UTC().Format(time.RFC3339Nano)
+
+	if sl.format == CSV {
+		b = append(b, ts...)
+		for _, v := range vals {
+			b = append(b, ',')
+			b = appendFloat(b, v, false)
+		}
+
+	} else {
+		b = append(b, `{"time":"`...)
+		b = append(b, ts...)
+		b = append(b, '"')
+		for i, v := range vals {
+			b = append(b, ',')
+			b = strconv.AppendQuote(b, cols[i+1])
+			b = append(b, ':')
+			b = appendFloat(b, v, true)
+		}
+		b = append(b, '}')
+	}
+
+	return append(b, '\n')
+}
+
+// appendFloat appends v to b with a couple of decimal places, which is plenty
+// for millimeters and degrees. NaN and Inf aren't valid JSON, so are written as
+// null in that case; we still want to see them.
+func appendFloat(b []byte, v float64, json bool) []byte {
+	if json && (math.IsNaN(v) || math.IsInf(v, 0)) {
+		return append(b, "null"...)
+	}
+
+	return strconv.AppendFloat(b, v, 'f', 2, 64)
+}
+
+// run writes rows to disk until Close is called. Runs in its own goroutine.
+func (sl *StateLog) run() {
+	defer close(sl.done)
+
+	t := time.NewTicker(flushInterval)
+	defer t.Stop()
+
+	var prevDropped uint64
+
+	for {
+		select {
+		case row, ok := <-sl.rows:
+			if !ok {
+				err := sl.out.Close()
+				if err != nil {
+					log.Errorf("%s (while closing)", err)
+				}
+				return
+			}
+
+			err := sl.out.writeLine(row)
+			if err != nil {
+				log.Errorf("%s (while writing row)", err)
+			}
+
+		case <-t.C:
+			err := sl.out.Flush()
+			if err != nil {
+				log.Errorf("%s (while flushing)", err)
+			}
+
+			if d := sl.Dropped(); d > prevDropped {
+				log.Warnf("dropped %d rows (writer is too slow)", d-prevDropped)
+				prevDropped = d
+			}
+		}
+	}
+}
+
+// Close stops accepting rows, and waits for the rows already buffered to be
+// written. Tick must not be called again afterwards.
+func (sl *StateLog) Close() {
+	close(sl.rows)
+	<-sl.done
+}
+
+// Shutdown closes the log, once the loop has stopped.
+func (sl *StateLog) Shutdown() error {
+	sl.Close()
+	return nil
+}
@@ -0,0 +1,232 @@
+package statelog
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/adammck/hexapod"
+	"github.com/adammck/hexapod/math3d"
+	"github.com/stretchr/testify/assert"
+)
+
+// memFS is an Opener which writes to in-memory buffers.
+type memFS struct {
+	sync.Mutex
+	files map[string]*bytes.Buffer
+
+	// Optional delay for each write, to simulate a slow disk.
+	delay time.Duration
+}
+
+type memFile struct {
+	fs  *memFS
+	buf *bytes.Buffer
+}
+
+func (f *memFile) Write(p []byte) (int, error) {
+	time.Sleep(f.fs.delay)
+	f.fs.Lock()
+	defer f.fs.Unlock()
+	return f.buf.Write(p)
+}
+
+func (f *memFile) Close() error {
+	return nil
+}
+
+func (fs *memFS) open(path string) (io.WriteCloser, error) {
+	fs.Lock()
+	defer fs.Unlock()
+	if fs.files == nil {
+		fs.files = map[string]*bytes.Buffer{}
+	}
+	b := &bytes.Buffer{}
+	fs.files[path] = b
+	return &memFile{fs, b}, nil
+}
+
+func (fs *memFS) paths() []string {
+	fs.Lock()
+	defer fs.Unlock()
+	p := []string{}
+	for k := range fs.files {
+		p = append(p, k)
+	}
+	sort.Strings(p)
+	return p
+}
+
+func testState(i int) *hexapod.State {
+	return &hexapod.State{
+		Commands:     hexapod.Commands{Target: math3d.Pose{Position: math3d.Vector3{X: float64(i + 1), Y: 40}}},
+		Measurements: hexapod.Measurements{Voltage: 11.1},
+		Estimates:    hexapod.Estimates{Pose: math3d.Pose{Position: math3d.Vector3{X: float64(i), Y: 40}}},
+	}
+}
+
+func run(t *testing.T, sl *StateLog, n int) {
+	assert.NoError(t, sl.Boot())
+	start := time.Date(2017, 6, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < n; i++ {
+		sl.Tick(start.Add(time.Duration(i)*time.Millisecond), testState(i))
+	}
+	sl.Close()
+}
+
+func TestCSVHeaderMatchesRows(t *testing.T) {
+	fs := &memFS{}
+	sl, err := newWithOpener("/logs", CSV, []string{"voltage", "pose", "feet"}, 1<<20, fs.open)
+	assert.NoError(t, err)
+	run(t, sl, 10)
+
+	paths := fs.paths()
+	if !assert.Len(t, paths, 1) {
+		return
+	}
+
+	rows, err := csv.NewReader(fs.files[paths[0]]).ReadAll()
+	assert.NoError(t, err)
+	assert.Len(t, rows, 11)
+
+	// Fields are written in the canonical order, not the order requested.
+	assert.Equal(t, sl.Columns(), rows[0])
+	assert.Equal(t, []string{"time", "pose_x", "pose_y"}, rows[0][:3])
+	assert.Equal(t, "voltage", rows[0][len(rows[0])-1])
+	assert.Equal(t, 1+6+18+1, len(rows[0]))
+
+	for i, row := range rows[1:] {
+		assert.Len(t, row, len(rows[0]))
+		assert.Equal(t, float64ToString(float64(i)), row[1])
+		assert.Equal(t, "11.10", row[len(row)-1])
+	}
+}
+
+func float64ToString(f float64) string {
+	return string(appendFloat(nil, f, false))
+}
+
+func TestJSONLSchemaMatchesRows(t *testing.T) {
+	fs := &memFS{}
+	sl, err := newWithOpener("/logs", JSONL, []string{"target", "clearance"}, 1<<20, fs.open)
+	assert.NoError(t, err)
+	run(t, sl, 5)
+
+	paths := fs.paths()
+	if !assert.Len(t, paths, 1) {
+		return
+	}
+
+	sc := bufio.NewScanner(fs.files[paths[0]])
+	assert.True(t, sc.Scan())
+
+	var header struct {
+		Schema []string `json:"schema"`
+	}
+	assert.NoError(t, json.Unmarshal(sc.Bytes(), &header))
+	assert.Equal(t, sl.Columns(), header.Schema)
+
+	n := 0
+	for sc.Scan() {
+		var row map[string]interface{}
+		assert.NoError(t, json.Unmarshal(sc.Bytes(), &row))
+
+		keys := []string{}
+		for k := range row {
+			keys = append(keys, k)
+		}
+
+		exp := append([]string{}, header.Schema...)
+		sort.Strings(keys)
+		sort.Strings(exp)
+		assert.Equal(t, exp, keys)
+		assert.Equal(t, float64(n+1), row["target_x"])
+		n += 1
+	}
+
+	assert.Equal(t, 5, n)
+}
+
+func TestRotation(t *testing.T) {
+	fs := &memFS{}
+	sl, err := newWithOpener("/logs", CSV, []string{"clearance"}, 256, fs.open)
+	assert.NoError(t, err)
+	run(t, sl, 100)
+
+	paths := fs.paths()
+	assert.True(t, len(paths) > 1, "expected more than one file, got %d", len(paths))
+
+	total := 0
+	for _, p := range paths {
+		b := fs.files[p].Bytes()
+		assert.True(t, len(b) <= 256, "%s is %d bytes", p, len(b))
+
+		// Every file starts with the header, and contains only whole rows.
+		lines := strings.Split(strings.TrimSuffix(string(b), "\n"), "\n")
+		assert.Equal(t, "time,clearance", lines[0])
+		for _, l := range lines[1:] {
+			assert.True(t, strings.HasSuffix(l, ",40.00"), "bad row: %q", l)
+		}
+		total += len(lines) - 1
+	}
+
+	assert.Equal(t, 100, total)
+	assert.True(t, strings.HasSuffix(paths[0], "-000.csv"))
+	assert.True(t, strings.HasSuffix(paths[1], "-001.csv"))
+}
+
+func TestSlowWriterDoesNotBlock(t *testing.T) {
+	fs := &memFS{delay: 50 * time.Millisecond}
+	sl, err := newWithOpener("/logs", CSV, FieldNames(), 1<<20, fs.open)
+	assert.NoError(t, err)
+	assert.NoError(t, sl.Boot())
+
+	n := bufferSize * 3
+	start := time.Now()
+	for i := 0; i < n; i++ {
+		sl.Tick(start, testState(i))
+	}
+
+	// The ticks should have been near instant, despite every write to the disk
+	// taking 50ms. The surplus rows were dropped.
+	assert.True(t, time.Since(start) < time.Second, "ticks took %s", time.Since(start))
+	assert.True(t, sl.Dropped() >= uint64(n-2*bufferSize), "only dropped %d", sl.Dropped())
+}
+
+func TestUnknownField(t *testing.T) {
+	_, err := New("/tmp", CSV, []string{"pose", "nope"}, 1024)
+	assert.Error(t, err)
+
+	_, err = New("/tmp", Format("xml"), []string{"pose"}, 1024)
+	assert.Error(t, err)
+}
+
+func TestEvery(t *testing.T) {
+	fs := &memFS{}
+	sl, err := newWithOpener("/logs", CSV, []string{"pose"}, 1<<20, fs.open)
+	assert.NoError(t, err)
+	assert.NoError(t, sl.Boot())
+
+	// Only every third tick is written.
+	assert.NoError(t, sl.Params.Set(map[string]float64{"statelog.every": 3}))
+	sl.Params.Apply()
+
+	start := time.Date(2017, 6, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 9; i++ {
+		sl.Tick(start.Add(time.Duration(i)*time.Millisecond), testState(i))
+	}
+	sl.Close()
+
+	rows, err := csv.NewReader(fs.files[fs.paths()[0]]).ReadAll()
+	assert.NoError(t, err)
+	assert.Len(t, rows, 4)
+	assert.Equal(t, float64ToString(2), rows[1][1])
+	assert.Equal(t, float64ToString(5), rows[2][1])
+}
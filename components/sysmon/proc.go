@@ -0,0 +1,142 @@
+package sysmon
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sample reads the CPU, temperature, and memory into cur. Anything which can't
+// be read (e.g. there's no thermal zone off the Pi) is left as it was, and the
+// error logged now and then.
+func (s *Sysmon) sample() {
+	busy, total, err := s.readCPU()
+	if err == nil {
+		if s.prevTotal > 0 && total > s.prevTotal {
+			s.cur.CPU = float64(busy-s.prevBusy) / float64(total-s.prevTotal)
+		}
+		s.prevBusy, s.prevTotal = busy, total
+	} else {
+		log.RateLimited("cpu", time.Minute).Warnf("%s (while reading CPU usage)", err)
+	}
+
+	temp, err := s.readTemperature()
+	if err == nil {
+		s.cur.Temperature = temp
+	} else {
+		log.RateLimited("temperature", time.Minute).Debugf("%s (while reading temperature)", err)
+	}
+
+	used, total, err := s.readMemory()
+	if err == nil {
+		s.cur.MemUsed, s.cur.MemTotal = used, total
+	} else {
+		log.RateLimited("memory", time.Minute).Warnf("%s (while reading memory)", err)
+	}
+}
+
+// readCPU returns the time which every CPU has spent busy, and in total, since
+// boot, in jiffies. Only the difference between samples means anything.
+func (s *Sysmon) readCPU() (uint64, uint64, error) {
+	b, err := os.ReadFile(filepath.Join(s.proc, "stat"))
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// The first line is the sum of every CPU: user, nice, system, idle,
+	// iowait, irq, softirq, and steal. (Guest time is already in user.)
+	line, _, _ := bytes.Cut(b, []byte("\n"))
+	f := strings.Fields(string(line))
+	if len(f) < 5 || f[0] != "cpu" {
+		return 0, 0, fmt.Errorf("invalid stat: %q", line)
+	}
+
+	var busy, total uint64
+	for i, field := range f[1:] {
+		if i >= 8 {
+			break
+		}
+
+		v, err := strconv.ParseUint(field, 10, 64)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid stat: %q", line)
+		}
+
+		total += v
+		if i != 3 && i != 4 {
+			busy += v
+		}
+	}
+
+	return busy, total, nil
+}
+
+// readTemperature returns the temperature of the CPU, in degrees C.
+func (s *Sysmon) readTemperature() (float64, error) {
+	b, err := os.ReadFile(filepath.Join(s.sysfs, "class/thermal/thermal_zone0/temp"))
+	if err != nil {
+		return 0, err
+	}
+
+	// It's in thousandths of a degree.
+	v, err := strconv.ParseInt(strings.TrimSpace(string(b)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid temperature: %q", b)
+	}
+
+	return float64(v) / 1000, nil
+}
+
+// readMemory returns the memory (in bytes) which is in use, and in total. Only
+// the memory which isn't available counts as used, since the kernel fills the
+// rest with caches which it'll drop when it needs to.
+func (s *Sysmon) readMemory() (uint64, uint64, error) {
+	f, err := os.Open(filepath.Join(s.proc, "meminfo"))
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+
+	var total, avail uint64
+	var haveTotal, haveAvail bool
+
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		// e.g. "MemTotal:         443888 kB"
+		fs := strings.Fields(sc.Text())
+		if len(fs) < 2 {
+			continue
+		}
+
+		var dst *uint64
+		switch fs[0] {
+		case "MemTotal:":
+			dst, haveTotal = &total, true
+		case "MemAvailable:":
+			dst, haveAvail = &avail, true
+		default:
+			continue
+		}
+
+		v, err := strconv.ParseUint(fs[1], 10, 64)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid meminfo: %q", sc.Text())
+		}
+		*dst = v * 1024
+	}
+
+	if err := sc.Err(); err != nil {
+		return 0, 0, err
+	}
+
+	if !haveTotal || !haveAvail || avail > total {
+		return 0, 0, fmt.Errorf("invalid meminfo")
+	}
+
+	return total - avail, total, nil
+}
@@ -0,0 +1,188 @@
+// Package sysmon watches the health of the computer which the hex runs on, and
+// sheds load when the main loop can't keep up, since a stuttering gait is worse
+// than slower telemetry.
+package sysmon
+
+import (
+	"expvar"
+	"time"
+
+	"github.com/adammck/hexapod"
+	"github.com/adammck/hexapod/config"
+	"github.com/adammck/hexapod/params"
+)
+
+var log = hexapod.NewLog("sysmon")
+
+// How often to sample the system, and check whether to shed load.
+const interval = time.Second
+
+// Exported via expvar, for the diagnostics server, alongside the loop stats.
+var (
+	expCPU         = expvar.NewFloat("sysmon.cpu")
+	expTemperature = expvar.NewFloat("sysmon.temperature")
+	expMemUsed     = expvar.NewInt("sysmon.mem_used")
+	expShed        = expvar.NewInt("sysmon.shed")
+)
+
+// shed is a step of load shedding which is in effect, and the value which its
+// param had before.
+type shed struct {
+	step int
+	was  float64
+}
+
+// Sysmon is a component which samples the CPU usage, CPU temperature, and
+// memory every second, into State.System.
+//
+// It also sheds load: while too many ticks are overrunning, it works through
+// the steps in the config, one at a time, setting each param to the value
+// given. Once the ticks are back within budget, the steps are restored one at
+// a time, in reverse. Changes to those params made (e.g. via the API) while
+// they're shed are overwritten when they're restored.
+type Sysmon struct {
+	cfg    config.Sysmon
+	stats  func() hexapod.LoopStats
+	params *params.Registry
+
+	// Where to read the system stats from. These are /proc and /sys, except
+	// in tests.
+	proc  string
+	sysfs string
+
+	// When the last sample was taken, and the CPU counters as of then.
+	last      time.Time
+	prevBusy  uint64
+	prevTotal uint64
+
+	// The steps which are in effect, in the order they were shed, and the
+	// index of the next step to shed.
+	shed []shed
+	next int
+
+	// When the last step was shed or restored.
+	changed time.Time
+
+	// The most recent sample, which is copied into the state every tick.
+	cur hexapod.System
+}
+
+// New creates a sysmon component which reads the tick timings from the given
+// func (which is usually Hexapod.LoopStats), and sheds load by writing to the
+// params in the given registry.
+func New(stats func() hexapod.LoopStats, r *params.Registry, cfg config.Sysmon) *Sysmon {
+	return &Sysmon{
+		cfg:    cfg,
+		stats:  stats,
+		params: r,
+		proc:   "/proc",
+		sysfs:  "/sys",
+	}
+}
+
+// Writes returns hexapod.Sensor, since sysmon measures the system.
+func (s *Sysmon) Writes() hexapod.Role {
+	return hexapod.Sensor
+}
+
+func (s *Sysmon) Boot() error {
+	return nil
+}
+
+func (s *Sysmon) Tick(now time.Time, state *hexapod.State) error {
+	if !s.last.IsZero() && now.Sub(s.last) < interval {
+		state.System = s.cur
+		return nil
+	}
+
+	// Don't shed anything until the tick timings have settled after booting.
+	if s.last.IsZero() {
+		s.changed = now
+	}
+	s.last = now
+
+	s.sample()
+
+	ls := s.stats()
+	if ls.Window > 0 {
+		s.cur.Overrun = float64(ls.Overruns) / float64(ls.Window)
+	}
+
+	s.balance(now, s.cur.Overrun)
+	s.cur.Shed = len(s.shed)
+
+	expCPU.Set(s.cur.CPU)
+	expTemperature.Set(s.cur.Temperature)
+	expMemUsed.Set(int64(s.cur.MemUsed))
+	expShed.Set(int64(s.cur.Shed))
+
+	state.System = s.cur
+	return nil
+}
+
+// balance sheds or restores a single step, if the overrun rate calls for it
+// and the last change has had time to settle.
+func (s *Sysmon) balance(now time.Time, overrun float64) {
+	if now.Sub(s.changed) < s.cfg.Settle.Duration {
+		return
+	}
+
+	if overrun > s.cfg.ShedAbove {
+		if s.shedNext(overrun) {
+			s.changed = now
+		}
+	} else if overrun < s.cfg.RestoreBelow {
+		if s.restore(overrun) {
+			s.changed = now
+		}
+	}
+}
+
+// shedNext sheds the next step whose param exists, and returns whether there
+// was one.
+func (s *Sysmon) shedNext(overrun float64) bool {
+	for ; s.next < len(s.cfg.Shed); s.next++ {
+		step := s.cfg.Shed[s.next]
+
+		was, ok := s.params.Get(step.Param)
+		if !ok {
+			log.Debugf("can't shed %s, since there's no such param", step.Param)
+			continue
+		}
+
+		err := s.params.Set(map[string]float64{step.Param: step.Value})
+		if err != nil {
+			log.Warnf("%s (while shedding load)", err)
+			continue
+		}
+
+		log.Warnf("%.0f%% of ticks are overrunning, so shedding load: %s=%v (was %v)", overrun*100, step.Param, step.Value, was)
+		s.shed = append(s.shed, shed{s.next, was})
+		s.next++
+		return true
+	}
+
+	return false
+}
+
+// restore restores the most recently shed step, and returns whether there was
+// one.
+func (s *Sysmon) restore(overrun float64) bool {
+	if len(s.shed) == 0 {
+		return false
+	}
+
+	sh := s.shed[len(s.shed)-1]
+	s.shed = s.shed[:len(s.shed)-1]
+	s.next = sh.step
+	step := s.cfg.Shed[sh.step]
+
+	err := s.params.Set(map[string]float64{step.Param: sh.was})
+	if err != nil {
+		log.Warnf("%s (while restoring load)", err)
+		return true
+	}
+
+	log.Infof("%.0f%% of ticks are overrunning, so restoring load: %s=%v", overrun*100, step.Param, sh.was)
+	return true
+}
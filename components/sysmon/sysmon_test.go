@@ -0,0 +1,191 @@
+package sysmon
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/adammck/hexapod"
+	"github.com/adammck/hexapod/config"
+	"github.com/adammck/hexapod/params"
+	"github.com/stretchr/testify/assert"
+)
+
+// rig ticks a sysmon component once a second, with made up tick timings, and
+// applies its param writes like the core would.
+type rig struct {
+	t        *testing.T
+	s        *Sysmon
+	r        *params.Registry
+	state    *hexapod.State
+	overruns int
+	start    time.Time
+	secs     int
+
+	// The params which are shed in the tests.
+	telemetryRate float64
+	statelogEvery float64
+}
+
+func newRig(t *testing.T, cfg config.Sysmon) *rig {
+	rg := &rig{
+		t:             t,
+		r:             params.New(),
+		state:         &hexapod.State{},
+		start:         time.Unix(0, 0),
+		telemetryRate: 10,
+		statelogEvery: 1,
+	}
+
+	for name, v := range map[string]*float64{"telemetry.rate": &rg.telemetryRate, "statelog.every": &rg.statelogEvery} {
+		v := v
+		assert.NoError(t, rg.r.Register(params.Param{
+			Name: name,
+			Type: params.Int,
+			Min:  1,
+			Max:  600,
+			Get:  func() float64 { return *v },
+			Set:  func(f float64) { *v = f },
+		}))
+	}
+
+	stats := func() hexapod.LoopStats {
+		return hexapod.LoopStats{Overruns: rg.overruns, Window: 120}
+	}
+
+	rg.s = New(stats, rg.r, cfg)
+	rg.s.proc = t.TempDir()
+	rg.s.sysfs = t.TempDir()
+	assert.NoError(t, rg.s.Boot())
+	return rg
+}
+
+// run ticks once a second for the given number of seconds, with the given
+// number of overruns (out of 120) in the loop stats.
+func (rg *rig) run(overruns, secs int) {
+	rg.overruns = overruns
+	for i := 0; i < secs; i++ {
+		rg.r.Apply()
+		assert.NoError(rg.t, rg.s.Tick(rg.start.Add(time.Duration(rg.secs)*time.Second), rg.state))
+		rg.secs++
+	}
+	rg.r.Apply()
+}
+
+// values returns the shed params, and the number of steps shed.
+func (rg *rig) values() []float64 {
+	return []float64{rg.statelogEvery, rg.telemetryRate, float64(rg.state.System.Shed)}
+}
+
+func TestShedding(t *testing.T) {
+	rg := newRig(t, config.Default().Sysmon)
+
+	// Nothing is shed for the first five seconds, while it settles after
+	// booting, even though it's overrunning.
+	rg.run(30, 5)
+	assert.Equal(t, []float64{1, 10, 0}, rg.values())
+	assert.InDelta(t, 0.25, rg.state.System.Overrun, 0.001)
+
+	// Then a step every five seconds, in order, until there's nothing left
+	// to shed.
+	rg.run(30, 1)
+	assert.Equal(t, []float64{6, 10, 1}, rg.values())
+	rg.run(30, 4)
+	assert.Equal(t, []float64{6, 10, 1}, rg.values())
+	rg.run(30, 1)
+	assert.Equal(t, []float64{6, 2, 2}, rg.values())
+	rg.run(30, 5)
+	assert.Equal(t, []float64{60, 2, 3}, rg.values())
+	rg.run(30, 20)
+	assert.Equal(t, []float64{60, 2, 3}, rg.values())
+
+	// Between the thresholds, nothing changes.
+	rg.run(6, 20)
+	assert.Equal(t, []float64{60, 2, 3}, rg.values())
+
+	// Once there's headroom again, they're restored in reverse, to what they
+	// were before each step.
+	rg.run(0, 1)
+	assert.Equal(t, []float64{6, 2, 2}, rg.values())
+	rg.run(0, 5)
+	assert.Equal(t, []float64{6, 10, 1}, rg.values())
+	rg.run(0, 5)
+	assert.Equal(t, []float64{1, 10, 0}, rg.values())
+	rg.run(0, 20)
+	assert.Equal(t, []float64{1, 10, 0}, rg.values())
+
+	// And shed again if it starts overrunning again.
+	rg.run(30, 1)
+	assert.Equal(t, []float64{6, 10, 1}, rg.values())
+
+	// A partial recovery restores the last step, and the next shed is that
+	// step again.
+	rg.run(30, 5)
+	assert.Equal(t, []float64{6, 2, 2}, rg.values())
+	rg.run(0, 5)
+	assert.Equal(t, []float64{6, 10, 1}, rg.values())
+	rg.run(30, 5)
+	assert.Equal(t, []float64{6, 2, 2}, rg.values())
+}
+
+func TestSheddingSkipsMissingParams(t *testing.T) {
+	cfg := config.Default().Sysmon
+	cfg.Shed = []config.Shed{
+		{"rosbridge.rate", 1},
+		{"telemetry.rate", 2},
+		{"mqtt.rate", 1},
+	}
+
+	rg := newRig(t, cfg)
+	rg.run(30, 6)
+	assert.Equal(t, []float64{1, 2, 1}, rg.values())
+
+	// Nothing left to shed.
+	rg.run(30, 10)
+	assert.Equal(t, []float64{1, 2, 1}, rg.values())
+
+	rg.run(0, 5)
+	assert.Equal(t, []float64{1, 10, 0}, rg.values())
+}
+
+func write(t *testing.T, path, s string) {
+	assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+	assert.NoError(t, os.WriteFile(path, []byte(s), 0644))
+}
+
+func stat(user, idle int) string {
+	return fmt.Sprintf("cpu  %d 0 0 %d 0 0 0 0 0 0\ncpu0 %d 0 0 %d 0 0 0 0 0 0\nintr 1234\n", user, idle, user, idle)
+}
+
+func TestSample(t *testing.T) {
+	rg := newRig(t, config.Default().Sysmon)
+	write(t, filepath.Join(rg.s.proc, "stat"), stat(1000, 9000))
+	write(t, filepath.Join(rg.s.proc, "meminfo"), "MemTotal:         443888 kB\nMemFree:           12000 kB\nMemAvailable:     243888 kB\n")
+	write(t, filepath.Join(rg.s.sysfs, "class/thermal/thermal_zone0/temp"), "48312\n")
+
+	// The CPU usage needs two samples.
+	rg.run(0, 1)
+	sys := rg.state.System
+	assert.Equal(t, 0.0, sys.CPU)
+	assert.Equal(t, 48.312, sys.Temperature)
+	assert.Equal(t, uint64(200000*1024), sys.MemUsed)
+	assert.Equal(t, uint64(443888*1024), sys.MemTotal)
+
+	// It's only sampled once a second, but copied to the state every tick.
+	write(t, filepath.Join(rg.s.proc, "stat"), stat(1030, 9070))
+	rg.state.System = hexapod.System{}
+	assert.NoError(t, rg.s.Tick(rg.start.Add(500*time.Millisecond), rg.state))
+	assert.Equal(t, sys, rg.state.System)
+
+	rg.run(0, 1)
+	assert.InDelta(t, 0.3, rg.state.System.CPU, 0.001)
+
+	// Anything which can't be read is left as it was.
+	assert.NoError(t, os.Remove(filepath.Join(rg.s.sysfs, "class/thermal/thermal_zone0/temp")))
+	write(t, filepath.Join(rg.s.proc, "stat"), "nonsense")
+	rg.run(0, 1)
+	assert.InDelta(t, 0.3, rg.state.System.CPU, 0.001)
+	assert.Equal(t, 48.312, rg.state.System.Temperature)
+}
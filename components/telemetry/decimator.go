@@ -0,0 +1,346 @@
+package telemetry
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/adammck/hexapod"
+)
+
+// The slack when comparing the tokens in a bucket to one, since adding up
+// fractions of a token every tick (of a whole number of nanoseconds) doesn't
+// quite get there.
+const tokenEpsilon = 1e-6
+
+// Profile is what a consumer of the state wants from the decimator: how often,
+// which fields, and which changes to be told about straight away.
+type Profile struct {
+
+	// The number of samples per second, or zero for every tick.
+	Rate float64
+
+	// The names of the fields of the snapshot (by their JSON names, see
+	// FieldNames) to copy into each sample, or empty for all of them. The
+	// version, units, identity and time are always copied.
+	Fields []string
+
+	// Fields to watch, with the amount which any of their values must change
+	// by (since the last sample) to deliver another sample straight away,
+	// whatever the rate. Only numeric fields (and flags) can be watched.
+	Watch map[string]float64
+}
+
+// Sample is what a consumer is given: a snapshot of the state at the time, with
+// only the fields which it asked for.
+type Sample struct {
+	Snapshot hexapod.Snapshot
+
+	// Set if the sample was delivered early, because a watched field changed,
+	// rather than because it was due.
+	Changed bool
+}
+
+// Consumer is a subscription to the decimator.
+type Consumer struct {
+	name    string
+	profile Profile
+	fields  []*field
+	watch   []*field
+	deliver func(s *Sample)
+
+	// The token bucket, which fills at the rate, and the time at which it was
+	// last filled. Whatever's left over after spending a token is carried over,
+	// so the rate doesn't drift down to the nearest multiple of the tick period.
+	tokens float64
+	filled time.Time
+
+	// The values of the watched fields when the last sample was delivered, or
+	// nil if none has been yet.
+	watched []float64
+
+	// Reused between ticks, to avoid allocating.
+	values []float64
+	sample Sample
+}
+
+// SetRate changes the number of samples per second, or zero for every tick.
+// This must be called from the main loop, like a param setter.
+func (c *Consumer) SetRate(r float64) {
+	c.profile.Rate = r
+}
+
+// Decimator samples the state for any number of consumers, each at its own
+// rate, but takes only a single snapshot per tick, however many of them are
+// due. Each consumer's component ticks it, and only the first call per tick
+// does anything, so it doesn't matter which of them are enabled.
+type Decimator struct {
+	consumers []*Consumer
+	last      time.Time
+	snap      hexapod.Snapshot
+}
+
+// NewDecimator returns a decimator with no consumers.
+func NewDecimator() *Decimator {
+	return &Decimator{}
+}
+
+// Subscribe adds a consumer with the given profile, which is called (from the
+// main loop) with each of its samples. The sample is reused, so mustn't be
+// kept. The name is only for errors and logs.
+func (d *Decimator) Subscribe(name string, p Profile, deliver func(s *Sample)) (*Consumer, error) {
+	if p.Rate < 0 {
+		return nil, fmt.Errorf("%s: negative rate: %v", name, p.Rate)
+	}
+
+	c := &Consumer{
+		name:    name,
+		profile: p,
+		deliver: deliver,
+		tokens:  1,
+	}
+
+	for _, n := range p.Fields {
+		f, err := lookupField(n)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", name, err)
+		}
+		c.fields = append(c.fields, f)
+	}
+
+	// Watch the fields in the same order every time, to compare the values.
+	names := make([]string, 0, len(p.Watch))
+	for n := range p.Watch {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	for _, n := range names {
+		f, err := lookupField(n)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", name, err)
+		}
+		if f.numbers == nil {
+			return nil, fmt.Errorf("%s: field can't be watched: %q", name, n)
+		}
+		c.watch = append(c.watch, f)
+	}
+
+	d.consumers = append(d.consumers, c)
+	return c, nil
+}
+
+// Tick delivers a sample to each consumer which is due one, or whose watched
+// fields have changed, unless it's already been called during this tick.
+func (d *Decimator) Tick(now time.Time, state *hexapod.State) error {
+	if !d.last.IsZero() && !now.After(d.last) {
+		return nil
+	}
+	d.last = now
+
+	taken := false
+	for _, c := range d.consumers {
+		due := c.fill(now)
+		if !due && len(c.watch) == 0 {
+			continue
+		}
+
+		// The snapshot is only taken if someone wants it, and only once.
+		if !taken {
+			d.snap = state.Snapshot(now)
+			taken = true
+		}
+
+		changed := c.changed(&d.snap)
+		if !due && !changed {
+			continue
+		}
+
+		if due {
+			c.spend()
+		}
+
+		c.sample.Changed = changed && !due
+		c.copy(&c.sample.Snapshot, &d.snap)
+		c.deliver(&c.sample)
+	}
+
+	return nil
+}
+
+// fill adds the tokens for the time since it was last filled, and returns
+// whether there's one to spend. With no rate, there always is.
+func (c *Consumer) fill(now time.Time) bool {
+	if c.profile.Rate == 0 {
+		c.tokens = 1
+		return true
+	}
+
+	if !c.filled.IsZero() {
+		c.tokens += now.Sub(c.filled).Seconds() * c.profile.Rate
+	}
+	c.filled = now
+
+	return c.tokens >= 1-tokenEpsilon
+}
+
+// spend spends a token. If there's still another one left over, the consumer
+// has fallen far behind (e.g. after a stall), so just start counting again.
+func (c *Consumer) spend() {
+	c.tokens -= 1
+	if c.tokens >= 1-tokenEpsilon {
+		c.tokens = 0
+	}
+}
+
+// changed returns true if any of the watched fields has changed by more than
+// its threshold since the last sample, and remembers the values if so (or if
+// this is the first).
+func (c *Consumer) changed(s *hexapod.Snapshot) bool {
+	if len(c.watch) == 0 {
+		return false
+	}
+
+	c.values = c.values[:0]
+	changed := c.watched == nil
+	for _, f := range c.watch {
+		n := len(c.values)
+		c.values = f.numbers(s, c.values)
+		if c.watched == nil {
+			continue
+		}
+
+		eps := c.profile.Watch[f.name]
+		for i := n; i < len(c.values); i++ {
+			if math.Abs(c.values[i]-c.watched[i]) > eps {
+				changed = true
+			}
+		}
+	}
+
+	// Only remember the values which were delivered, so a slow drift adds up.
+	if changed {
+		c.watched = append(c.watched[:0], c.values...)
+	}
+
+	return changed
+}
+
+// copy copies the fields which the consumer wants from the snapshot, or all of
+// them if it didn't say.
+func (c *Consumer) copy(dst, src *hexapod.Snapshot) {
+	if len(c.fields) == 0 {
+		*dst = *src
+		return
+	}
+
+	*dst = hexapod.Snapshot{
+		Version: src.Version,
+		Minor:   src.Minor,
+		Units:   src.Units,
+		Robot:   src.Robot,
+		Name:    src.Name,
+		Time:    src.Time,
+	}
+
+	for _, f := range c.fields {
+		f.copy(dst, src)
+	}
+}
+
+// field is a field of the snapshot which a consumer can ask for, by its JSON
+// name. Fields which can be watched know how to extract their values.
+type field struct {
+	name    string
+	copy    func(dst, src *hexapod.Snapshot)
+	numbers func(s *hexapod.Snapshot, dst []float64) []float64
+}
+
+// fields are the fields of the snapshot which can be asked for.
+var fields = []*field{
+	{"fps", func(d, s *hexapod.Snapshot) { d.FPS = s.FPS }, func(s *hexapod.Snapshot, dst []float64) []float64 {
+		return append(dst, float64(s.FPS))
+	}},
+	{"shutdown", func(d, s *hexapod.Snapshot) { d.Shutdown = s.Shutdown }, func(s *hexapod.Snapshot, dst []float64) []float64 {
+		return append(dst, flag(s.Shutdown))
+	}},
+	{"pose", func(d, s *hexapod.Snapshot) { d.Pose = s.Pose }, func(s *hexapod.Snapshot, dst []float64) []float64 {
+		return appendPose(dst, s.Pose)
+	}},
+	{"target", func(d, s *hexapod.Snapshot) { d.Target = s.Target }, func(s *hexapod.Snapshot, dst []float64) []float64 {
+		return appendPose(dst, s.Target)
+	}},
+	{"offset", func(d, s *hexapod.Snapshot) { d.Offset = s.Offset }, func(s *hexapod.Snapshot, dst []float64) []float64 {
+		return append(dst, s.Offset.X, s.Offset.Y, s.Offset.Z)
+	}},
+	{"look_at", func(d, s *hexapod.Snapshot) { d.LookAt = s.LookAt }, nil},
+	{"clearance", func(d, s *hexapod.Snapshot) { d.Clearance = s.Clearance }, func(s *hexapod.Snapshot, dst []float64) []float64 {
+		return append(dst, s.Clearance)
+	}},
+	{"speed", func(d, s *hexapod.Snapshot) { d.Speed = s.Speed }, func(s *hexapod.Snapshot, dst []float64) []float64 {
+		return append(dst, float64(s.Speed))
+	}},
+	{"gait", func(d, s *hexapod.Snapshot) { d.Gait, d.GaitIndex = s.Gait, s.GaitIndex }, func(s *hexapod.Snapshot, dst []float64) []float64 {
+		return append(dst, float64(s.GaitIndex))
+	}},
+	{"voltage", func(d, s *hexapod.Snapshot) { d.Voltage = s.Voltage }, func(s *hexapod.Snapshot, dst []float64) []float64 {
+		return append(dst, s.Voltage)
+	}},
+	{"current", func(d, s *hexapod.Snapshot) { d.Current = s.Current }, func(s *hexapod.Snapshot, dst []float64) []float64 {
+		return append(dst, s.Current)
+	}},
+	{"charge", func(d, s *hexapod.Snapshot) { d.Charge = s.Charge }, func(s *hexapod.Snapshot, dst []float64) []float64 {
+		return append(dst, s.Charge)
+	}},
+	{"resting", func(d, s *hexapod.Snapshot) { d.Resting = s.Resting }, func(s *hexapod.Snapshot, dst []float64) []float64 {
+		return append(dst, flag(s.Resting))
+	}},
+	{"turbo", func(d, s *hexapod.Snapshot) { d.Turbo = s.Turbo }, func(s *hexapod.Snapshot, dst []float64) []float64 {
+		return append(dst, flag(s.Turbo.Active), s.Turbo.Factor)
+	}},
+	{"safe_mode", func(d, s *hexapod.Snapshot) { d.SafeMode, d.Armed = s.SafeMode, s.Armed }, func(s *hexapod.Snapshot, dst []float64) []float64 {
+		return append(dst, flag(s.SafeMode), flag(s.Armed))
+	}},
+	{"stiffness", func(d, s *hexapod.Snapshot) { d.Stiffness = s.Stiffness }, nil},
+	{"derate", func(d, s *hexapod.Snapshot) { d.Derate = s.Derate }, func(s *hexapod.Snapshot, dst []float64) []float64 {
+		return append(dst, s.Derate)
+	}},
+	{"feet", func(d, s *hexapod.Snapshot) { d.Feet = s.Feet }, func(s *hexapod.Snapshot, dst []float64) []float64 {
+		for _, f := range s.Feet {
+			dst = append(dst, f.X, f.Y, f.Z)
+		}
+		return dst
+	}},
+}
+
+// FieldNames returns the names of the fields which a profile can ask for.
+func FieldNames() []string {
+	names := make([]string, len(fields))
+	for i, f := range fields {
+		names[i] = f.name
+	}
+	return names
+}
+
+func lookupField(name string) (*field, error) {
+	for _, f := range fields {
+		if f.name == name {
+			return f, nil
+		}
+	}
+
+	return nil, fmt.Errorf("unknown field: %q (valid fields: %s)", name, strings.Join(FieldNames(), ", "))
+}
+
+func appendPose(dst []float64, p hexapod.SnapshotPose) []float64 {
+	return append(dst, p.X, p.Y, p.Z, p.Heading, p.Pitch, p.Bank)
+}
+
+func flag(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
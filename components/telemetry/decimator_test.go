@@ -0,0 +1,142 @@
+package telemetry
+
+import (
+	"testing"
+	"time"
+
+	"github.com/adammck/hexapod"
+	"github.com/adammck/hexapod/math3d"
+	"github.com/stretchr/testify/assert"
+)
+
+// counter subscribes a consumer to the decimator, and returns the samples which
+// it was given (copied, since they're reused).
+func counter(t *testing.T, d *Decimator, p Profile) *[]Sample {
+	var out []Sample
+	_, err := d.Subscribe("test", p, func(s *Sample) {
+		out = append(out, *s)
+	})
+	assert.NoError(t, err)
+	return &out
+}
+
+func TestDecimatorRates(t *testing.T) {
+	d := NewDecimator()
+	fast := counter(t, d, Profile{})
+	ten := counter(t, d, Profile{Rate: 10})
+	one := counter(t, d, Profile{Rate: 1})
+
+	// Tick for one (fake) second at 60fps, twice per tick, like two components
+	// sharing the decimator would.
+	state := &hexapod.State{}
+	start := time.Date(2017, 6, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 60; i++ {
+		now := start.Add(time.Duration(i) * (time.Second / 60))
+		assert.NoError(t, d.Tick(now, state))
+		assert.NoError(t, d.Tick(now, state))
+	}
+
+	assert.Len(t, *fast, 60)
+	assert.Len(t, *ten, 10)
+	assert.Len(t, *one, 1)
+
+	for _, s := range *ten {
+		assert.False(t, s.Changed)
+	}
+}
+
+func TestDecimatorFields(t *testing.T) {
+	d := NewDecimator()
+	all := counter(t, d, Profile{})
+	some := counter(t, d, Profile{Fields: []string{"voltage", "pose"}})
+
+	state := &hexapod.State{}
+	state.Identity.ID = "abc"
+	state.Voltage = 11.5
+	state.Speed = 3
+	state.Pose = math3d.Pose{Position: math3d.Vector3{X: 10, Y: 20, Z: 30}}
+	now := time.Date(2017, 6, 1, 0, 0, 0, 0, time.UTC)
+	assert.NoError(t, d.Tick(now, state))
+
+	assert.Len(t, *all, 1)
+	assert.Equal(t, state.Snapshot(now), (*all)[0].Snapshot)
+
+	// The header is always there, but only the fields which were asked for.
+	assert.Len(t, *some, 1)
+	s := (*some)[0].Snapshot
+	assert.Equal(t, hexapod.SnapshotVersion, s.Version)
+	assert.Equal(t, "abc", s.Robot)
+	assert.Equal(t, now, s.Time)
+	assert.Equal(t, 11.5, s.Voltage)
+	assert.Equal(t, 10.0, s.Pose.X)
+	assert.Equal(t, 0, s.Speed)
+	assert.Equal(t, 0.0, s.Clearance)
+}
+
+func TestDecimatorChanges(t *testing.T) {
+	d := NewDecimator()
+	samples := counter(t, d, Profile{
+		Rate:   1,
+		Fields: []string{"voltage"},
+		Watch:  map[string]float64{"voltage": 0.5},
+	})
+
+	state := &hexapod.State{Measurements: hexapod.Measurements{Voltage: 12}}
+	now := time.Date(2017, 6, 1, 0, 0, 0, 0, time.UTC)
+	tick := func(v float64) {
+		now = now.Add(time.Second / 60)
+		state.Voltage = v
+		assert.NoError(t, d.Tick(now, state))
+	}
+
+	// The first is due anyway.
+	tick(12)
+	assert.Len(t, *samples, 1)
+	assert.False(t, (*samples)[0].Changed)
+
+	// Small changes wait for the next sample.
+	tick(11.8)
+	tick(12.2)
+	assert.Len(t, *samples, 1)
+
+	// Big ones don't.
+	tick(11.4)
+	assert.Len(t, *samples, 2)
+	assert.True(t, (*samples)[1].Changed)
+	assert.Equal(t, 11.4, (*samples)[1].Snapshot.Voltage)
+
+	// A slow drift adds up, since it's compared to the last sample delivered.
+	tick(11.2)
+	tick(11.0)
+	tick(10.8)
+	assert.Len(t, *samples, 3)
+	assert.Equal(t, 10.8, (*samples)[2].Snapshot.Voltage)
+
+	// Changes don't spend the token, so the regular sample is still due a
+	// second after the first.
+	for i := 0; i < 60; i++ {
+		tick(10.8)
+	}
+	assert.Len(t, *samples, 4)
+	assert.False(t, (*samples)[3].Changed)
+}
+
+func TestDecimatorInvalid(t *testing.T) {
+	d := NewDecimator()
+	deliver := func(s *Sample) {}
+
+	for name, p := range map[string]Profile{
+		"unknown field":   {Fields: []string{"nope"}},
+		"unknown watch":   {Watch: map[string]float64{"nope": 1}},
+		"unwatchable":     {Watch: map[string]float64{"stiffness": 1}},
+		"negative rate":   {Rate: -1},
+		"watch (look_at)": {Watch: map[string]float64{"look_at": 1}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := d.Subscribe("test", p, deliver)
+			assert.Error(t, err)
+		})
+	}
+
+	assert.Empty(t, d.consumers)
+}
@@ -0,0 +1,102 @@
+package telemetry
+
+import (
+	"sync"
+)
+
+// queue is a bounded FIFO of encoded messages. When it's full, pushing a new
+// message discards the oldest one, so a slow client sees gaps rather than lag,
+// and (more importantly) never blocks the sender.
+type queue struct {
+	ch chan []byte
+}
+
+func newQueue(size int) *queue {
+	return &queue{
+		ch: make(chan []byte, size),
+	}
+}
+
+// push adds a message to the queue without blocking. Returns true if an older
+// message was dropped to make room.
+func (q *queue) push(msg []byte) bool {
+	dropped := false
+
+	for {
+		select {
+		case q.ch <- msg:
+			return dropped
+
+		default:
+			// Full. Discard the oldest message and try again. The receiver might
+			// have beaten us to it, in which case there's nothing to discard.
+			select {
+			case <-q.ch:
+				dropped = true
+			default:
+			}
+		}
+	}
+}
+
+// hub keeps track of the connected clients, and fans out each message to all
+// of them. It's safe to call from multiple goroutines.
+type hub struct {
+	sync.Mutex
+
+	// The number of messages to buffer per client before dropping.
+	size int
+
+	clients map[*queue]struct{}
+
+	// The total number of messages dropped (across all clients) because they
+	// couldn't keep up. Mostly for testing.
+	dropped int
+}
+
+func newHub(size int) *hub {
+	return &hub{
+		size:    size,
+		clients: map[*queue]struct{}{},
+	}
+}
+
+func (h *hub) add() *queue {
+	h.Lock()
+	defer h.Unlock()
+
+	q := newQueue(h.size)
+	h.clients[q] = struct{}{}
+	return q
+}
+
+func (h *hub) remove(q *queue) {
+	h.Lock()
+	defer h.Unlock()
+	delete(h.clients, q)
+}
+
+func (h *hub) count() int {
+	h.Lock()
+	defer h.Unlock()
+	return len(h.clients)
+}
+
+// broadcast pushes the message onto the queue of every client. It never blocks
+// (except to acquire the lock), regardless of how slow the clients are.
+func (h *hub) broadcast(msg []byte) {
+	h.Lock()
+	defer h.Unlock()
+
+	for q := range h.clients {
+		if q.push(msg) {
+			h.dropped += 1
+		}
+	}
+}
+
+func (h *hub) droppedCount() int {
+	h.Lock()
+	defer h.Unlock()
+	return h.dropped
+}
@@ -0,0 +1,187 @@
+package telemetry
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/adammck/hexapod"
+	"github.com/adammck/hexapod/params"
+	"github.com/adammck/hexapod/units"
+	"github.com/gorilla/websocket"
+)
+
+var log = logrus.WithFields(logrus.Fields{
+	"pkg": "telemetry",
+})
+
+const (
+
+	// The number of snapshots to buffer for each client. At 10Hz this is a
+	// couple of seconds, which is plenty; anything older than that is useless
+	// to a dashboard anyway.
+	queueSize = 16
+
+	// How long to wait for a single websocket write before giving up on the
+	// client. This only blocks the client's own goroutine, never the loop.
+	writeTimeout = 5 * time.Second
+)
+
+// Telemetry is a component which streams snapshots of the state as JSON over a
+// websocket, at a fixed rate (independent of the main loop).
+type Telemetry struct {
+	port int
+	rate int
+
+	// The units which the snapshots are converted to.
+	units units.System
+
+	// The registry to register the params with at boot. This is params.Default
+	// unless changed, so more than one instance can be booted (e.g. in tests).
+	Params *params.Registry
+
+	// The decimator which samples the state at the rate, which may be shared
+	// with other consumers, and the subscription to it.
+	decimator *Decimator
+	consumer  *Consumer
+
+	// The error from encoding the last sample, which Tick returns.
+	err error
+
+	hub      *hub
+	upgrader websocket.Upgrader
+}
+
+// New creates a telemetry component which will listen on the given port, and
+// send a snapshot (in the given units) to each client rate times per second.
+// The state is sampled by the given decimator, or one of its own if nil.
+func New(port int, rate int, u units.System, d *Decimator) *Telemetry {
+	if d == nil {
+		d = NewDecimator()
+	}
+
+	t := &Telemetry{
+		port:      port,
+		rate:      rate,
+		units:     u,
+		Params:    params.Default,
+		decimator: d,
+		hub:       newHub(queueSize),
+		upgrader: websocket.Upgrader{
+
+			// Allow the dashboard to be served from anywhere.
+			CheckOrigin: func(r *http.Request) bool { return true },
+		},
+	}
+
+	// Every field, since the dashboard draws most of them.
+	t.consumer, _ = d.Subscribe("telemetry", Profile{Rate: float64(rate)}, t.broadcast)
+	return t
+}
+
+// Boot registers the telemetry.rate param (in snapshots per second), and starts
+// the HTTP server in the background.
+func (t *Telemetry) Boot() error {
+	err := t.Params.Register(params.Param{
+		Name: "telemetry.rate",
+		Type: params.Int,
+		Min:  1,
+		Max:  100,
+		Get:  func() float64 { return float64(t.rate) },
+		Set: func(v float64) {
+			t.rate = int(v)
+			t.consumer.SetRate(v)
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/telemetry", t)
+
+	addr := fmt.Sprintf(":%d", t.port)
+	log.Infof("listening on %s", addr)
+
+	go func() {
+		err := http.ListenAndServe(addr, mux)
+		log.Errorf("server stopped: %s", err)
+	}()
+
+	return nil
+}
+
+// Tick ticks the decimator, which calls broadcast when a snapshot is due. The
+// snapshot is taken there, rather than in the client goroutines, because this
+// is the only time that state is safe to read.
+func (t *Telemetry) Tick(now time.Time, state *hexapod.State) error {
+	t.err = nil
+	err := t.decimator.Tick(now, state)
+	if err != nil {
+		return err
+	}
+
+	return t.err
+}
+
+// broadcast sends the sample to every client.
+func (t *Telemetry) broadcast(s *Sample) {
+
+	// Don't bother encoding anything if nobody is listening.
+	if t.hub.count() == 0 {
+		return
+	}
+
+	b, err := json.Marshal(s.Snapshot.In(t.units))
+	if err != nil {
+		t.err = err
+		return
+	}
+
+	t.hub.broadcast(b)
+}
+
+// ServeHTTP upgrades the connection to a websocket, and sends snapshots to it
+// until the client goes away.
+func (t *Telemetry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	conn, err := t.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Warnf("%s (while upgrading connection)", err)
+		return
+	}
+	defer conn.Close()
+
+	q := t.hub.add()
+	defer t.hub.remove(q)
+	log.Infof("client connected: %s", r.RemoteAddr)
+
+	// Read (and discard) anything the client sends, so that we notice when it
+	// disconnects. Gorilla requires this to process control frames anyway.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case msg := <-q.ch:
+			conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+			err := conn.WriteMessage(websocket.TextMessage, msg)
+			if err != nil {
+				log.Infof("client disconnected: %s (%s)", r.RemoteAddr, err)
+				return
+			}
+
+		case <-done:
+			log.Infof("client disconnected: %s", r.RemoteAddr)
+			return
+		}
+	}
+}
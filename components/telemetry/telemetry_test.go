@@ -0,0 +1,135 @@
+package telemetry
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/adammck/hexapod"
+	"github.com/adammck/hexapod/math3d"
+	"github.com/adammck/hexapod/units"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+func dial(t *testing.T, tel *Telemetry) (*websocket.Conn, func()) {
+	srv := httptest.NewServer(tel)
+	url := "ws" + strings.TrimPrefix(srv.URL, "http")
+
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("error dialing: %s", err)
+	}
+
+	// Wait for the server to register the client, or the first few ticks will
+	// be dropped on the floor.
+	for i := 0; tel.hub.count() == 0; i++ {
+		if i > 100 {
+			t.Fatal("client never registered")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	return conn, func() {
+		conn.Close()
+		srv.Close()
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	tel := New(0, 10, units.Internal, nil)
+	conn, done := dial(t, tel)
+	defer done()
+
+	state := &hexapod.State{}
+	state.Target = math3d.Pose{Position: math3d.Vector3{Y: 40}}
+
+	// Tick for one (fake) second at 60fps.
+	start := time.Date(2017, 6, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 60; i++ {
+		assert.NoError(t, tel.Tick(start.Add(time.Duration(i)*(time.Second/60)), state))
+	}
+
+	n := 0
+	for {
+		conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+		_, b, err := conn.ReadMessage()
+		if err != nil {
+			break
+		}
+
+		var s hexapod.Snapshot
+		assert.NoError(t, json.Unmarshal(b, &s))
+		assert.Equal(t, hexapod.SnapshotVersion, s.Version)
+		assert.Equal(t, 40.0, s.Clearance)
+		n += 1
+	}
+
+	assert.Equal(t, 10, n)
+}
+
+func TestQueueDropsOldest(t *testing.T) {
+	q := newQueue(4)
+
+	for i := 0; i < 10; i++ {
+		q.push([]byte(fmt.Sprintf("%d", i)))
+	}
+
+	assert.Equal(t, 4, len(q.ch))
+	for _, exp := range []string{"6", "7", "8", "9"} {
+		assert.Equal(t, exp, string(<-q.ch))
+	}
+}
+
+func TestUnits(t *testing.T) {
+	tel := New(0, 10, units.System{Length: units.Meters, Angle: units.Radians}, nil)
+	conn, done := dial(t, tel)
+	defer done()
+
+	state := &hexapod.State{}
+	state.Target = math3d.Pose{Position: math3d.Vector3{Y: 40}, Heading: 180}
+	assert.NoError(t, tel.Tick(time.Date(2017, 6, 1, 0, 0, 0, 0, time.UTC), state))
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, b, err := conn.ReadMessage()
+	assert.NoError(t, err)
+
+	var s hexapod.Snapshot
+	assert.NoError(t, json.Unmarshal(b, &s))
+	assert.Equal(t, units.System{Length: "m", Angle: "rad"}, s.Units)
+	assert.InDelta(t, 0.04, s.Clearance, 1e-9)
+	assert.InDelta(t, math.Pi, s.Target.Heading, 1e-9)
+
+	// The state itself isn't touched.
+	assert.Equal(t, 40.0, state.Target.Position.Y)
+}
+
+func TestSlowClientDoesNotBlock(t *testing.T) {
+	tel := New(0, 10, units.Internal, nil)
+
+	// Register a client which never reads from its queue.
+	q := tel.hub.add()
+	state := &hexapod.State{}
+
+	finished := make(chan struct{})
+	go func() {
+		start := time.Now()
+		for i := 0; i < 1000; i++ {
+			tel.Tick(start.Add(time.Duration(i)*time.Second), state)
+		}
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Tick blocked on a slow client")
+	}
+
+	assert.Equal(t, queueSize, len(q.ch))
+	assert.Equal(t, 1000-queueSize, tel.hub.droppedCount())
+}
@@ -0,0 +1,240 @@
+// Package tracker points the head at whatever an external vision process finds
+// in the camera frame, like a face or a marker. The vision happens elsewhere;
+// this only takes the results, so doesn't need OpenCV or the camera.
+package tracker
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/adammck/hexapod"
+	"github.com/adammck/hexapod/config"
+	"github.com/adammck/hexapod/math3d"
+	"github.com/adammck/hexapod/utils"
+)
+
+var log = hexapod.NewLog("tracker")
+
+// How far the right stick can be from neutral before the operator is looking
+// around themselves, and takes priority.
+const deadzone = 10
+
+// Detection is something which the vision process found in the camera frame.
+// X and Y are from zero to one, from the top left of the frame, and Confidence
+// is from zero (a guess) to one (certain).
+type Detection struct {
+	X          float64 `json:"x"`
+	Y          float64 `json:"y"`
+	Confidence float64 `json:"confidence"`
+}
+
+func (d Detection) validate() error {
+	for _, f := range []struct {
+		name string
+		v    float64
+	}{
+		{"x", d.X},
+		{"y", d.Y},
+		{"confidence", d.Confidence},
+	} {
+		if !(f.v >= 0 && f.v <= 1) {
+			return fmt.Errorf("%s must be between 0 and 1, not %v", f.name, f.v)
+		}
+	}
+
+	return nil
+}
+
+// Tracker is a component which accepts detections via HTTP, and sets the focal
+// point to them:
+//
+//	POST /detection  a detection, as a JSON Detection
+//
+// Each is converted into a point in the world space, given where the head was
+// pointing and the pose when it's received, at the distance in the config. The
+// focal point is then smoothed towards that point, since detections are noisy.
+//
+// Detections are a low priority input, so this has to come after the
+// controller (and before the head). The controller's focal point is left alone
+// while the right stick is being used, or if the most recent detection isn't
+// confident enough, or is stale.
+type Tracker struct {
+	port  int
+	mount math3d.Pose
+	cfg   config.Tracker
+
+	// The most recent detection, when it was received, and whether it's new
+	// since the last tick. Set by the handler.
+	sync.Mutex
+	det   Detection
+	detAt time.Time
+	fresh bool
+
+	// Only touched by Tick. The point which the most recent detection was at,
+	// and the smoothed focal point which is heading towards it.
+	target   math3d.Vector3
+	focal    math3d.Vector3
+	tracking bool
+	last     time.Time
+}
+
+// New creates a tracker component which will accept detections on the given
+// port, from a camera at the origin of a head mounted at the given pose.
+func New(port int, mount math3d.Pose, cfg config.Tracker) *Tracker {
+	return &Tracker{
+		port:  port,
+		mount: mount,
+		cfg:   cfg,
+	}
+}
+
+// Writes returns hexapod.Commander, since the tracker sets the focal point.
+func (t *Tracker) Writes() hexapod.Role {
+	return hexapod.Commander
+}
+
+// Boot starts the HTTP server in the background.
+func (t *Tracker) Boot() error {
+	addr := fmt.Sprintf(":%d", t.port)
+	log.Infof("listening for detections on %s", addr)
+
+	go func() {
+		err := http.ListenAndServe(addr, t)
+		log.Errorf("server stopped: %s", err)
+	}()
+
+	return nil
+}
+
+func (t *Tracker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/detection" {
+		http.NotFound(w, r)
+		return
+	}
+
+	if r.Method != "POST" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var d Detection
+	err := json.NewDecoder(r.Body).Decode(&d)
+	if err == nil {
+		err = d.validate()
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid detection: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	t.detect(d, time.Now())
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// detect records a detection, received at the given time.
+func (t *Tracker) detect(d Detection, at time.Time) {
+	t.Lock()
+	defer t.Unlock()
+
+	t.det = d
+	t.detAt = at
+	t.fresh = true
+}
+
+func (t *Tracker) Tick(now time.Time, state *hexapod.State) error {
+	t.Lock()
+	d, at, fresh := t.det, t.detAt, t.fresh
+	t.fresh = false
+	t.Unlock()
+
+	dt := now.Sub(t.last)
+	t.last = now
+
+	// Converted straight away, even if it isn't going to be used yet, since
+	// the head and body might have moved by then.
+	if fresh {
+		t.target = t.point(state.Pose, state.Head, d)
+	}
+
+	var why string
+	switch {
+	case at.IsZero():
+		why = "nothing detected"
+	case now.Sub(at) > t.cfg.StaleAfter.Duration:
+		why = "detection is stale"
+	case d.Confidence < t.cfg.MinConfidence:
+		why = "detection isn't confident enough"
+	case operator(state.Input):
+		why = "operator is looking around"
+	}
+
+	if why != "" {
+		if t.tracking {
+			log.Infof("stopped tracking, because %s", why)
+			t.tracking = false
+		}
+
+		return nil
+	}
+
+	// Start at the target, rather than sweeping over from wherever the focal
+	// point was before.
+	if !t.tracking {
+		log.Infof("tracking, with confidence %.2f", d.Confidence)
+		t.tracking = true
+		t.focal = t.target
+	} else if tc := t.cfg.Smoothing.Duration; tc > 0 {
+		f := 1 - math.Exp(-dt.Seconds()/tc.Seconds())
+		t.focal = *t.focal.Add(t.target.Subtract(t.focal).MultiplyByScalar(f))
+	} else {
+		t.focal = t.target
+	}
+
+	state.LookAt = &t.focal
+	return nil
+}
+
+// point returns the position (in the world space) of the given detection, at
+// the configured distance from the camera, if it was made with the head at the
+// given angles on a body with the given pose.
+func (t *Tracker) point(pose math3d.Pose, head hexapod.Head, d Detection) math3d.Vector3 {
+
+	// The direction in the camera space, which is the head space before it
+	// turns. Y is flipped, since images go downwards.
+	dir := math3d.Vector3{
+		X: (2*d.X - 1) * math.Tan(utils.Rad(t.cfg.HorizontalFOV/2)),
+		Y: (1 - 2*d.Y) * math.Tan(utils.Rad(t.cfg.VerticalFOV/2)),
+		Z: 1,
+	}.Unit()
+
+	// Tilt it up, and then pan it to the right, like the head does.
+	pan, tilt := utils.Rad(head.Pan), utils.Rad(head.Tilt)
+	dir = math3d.Vector3{
+		X: dir.X,
+		Y: dir.Y*math.Cos(tilt) + dir.Z*math.Sin(tilt),
+		Z: dir.Z*math.Cos(tilt) - dir.Y*math.Sin(tilt),
+	}
+	dir = math3d.Vector3{
+		X: dir.X*math.Cos(pan) + dir.Z*math.Sin(pan),
+		Y: dir.Y,
+		Z: dir.Z*math.Cos(pan) - dir.X*math.Sin(pan),
+	}
+
+	return dir.MultiplyByScalar(t.cfg.Distance).MultiplyByMatrix44(t.mount.ToWorld()).MultiplyByMatrix44(pose.ToWorld())
+}
+
+// operator returns true if the right stick is being used, to look around or to
+// set the offset.
+func operator(in hexapod.Input) bool {
+	for _, v := range []int{in.RightX, in.RightY} {
+		if v > deadzone || v < -deadzone {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,189 @@
+package tracker
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/adammck/hexapod"
+	"github.com/adammck/hexapod/config"
+	"github.com/adammck/hexapod/math3d"
+	"github.com/adammck/hexapod/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+// The mount of the head on the real hex. See main.
+var mount = math3d.Pose{Position: math3d.Vector3{X: 0, Y: 43.0, Z: 70}}
+
+// The controller's focal point, which the tracker should leave alone when it
+// isn't tracking.
+var ahead = math3d.Vector3{X: 0, Y: 117.5, Z: 500}
+
+func newTestTracker() *Tracker {
+	return New(0, mount, config.Default().Tracker)
+}
+
+// standing returns the state of a hex standing at the origin, with the
+// controller looking straight ahead.
+func standing() *hexapod.State {
+	look := ahead
+	return &hexapod.State{
+		Commands:  hexapod.Commands{LookAt: &look},
+		Estimates: hexapod.Estimates{Pose: math3d.Pose{Position: math3d.Vector3{Y: 40}}},
+	}
+}
+
+// bearing returns the angles (in degrees) to the right and up to the given
+// point, from the camera on a head which is pointing straight ahead.
+func bearing(pose math3d.Pose, p math3d.Vector3) (float64, float64) {
+	v := p.MultiplyByMatrix44(pose.ToLocal()).MultiplyByMatrix44(mount.ToLocal())
+	return utils.Deg(math.Atan2(v.X, v.Z)), utils.Deg(math.Atan2(v.Y, math.Hypot(v.X, v.Z)))
+}
+
+func TestPoint(t *testing.T) {
+	tr := newTestTracker()
+	cfg := tr.cfg
+
+	// In the middle of the frame is straight ahead of the camera, at the
+	// configured distance.
+	pose := math3d.Pose{Position: math3d.Vector3{Y: 40}}
+	p := tr.point(pose, hexapod.Head{}, Detection{X: 0.5, Y: 0.5})
+	assert.InDelta(t, 0, p.X, 0.001)
+	assert.InDelta(t, 83, p.Y, 0.001)
+	assert.InDelta(t, 1070, p.Z, 0.001)
+
+	// The edges of the frame are at the edges of the field of view, and the
+	// top is up.
+	pan, tilt := bearing(pose, tr.point(pose, hexapod.Head{}, Detection{X: 1, Y: 0.5}))
+	assert.InDelta(t, cfg.HorizontalFOV/2, pan, 0.001)
+	assert.InDelta(t, 0, tilt, 0.001)
+	pan, tilt = bearing(pose, tr.point(pose, hexapod.Head{}, Detection{X: 0.5, Y: 0}))
+	assert.InDelta(t, 0, pan, 0.001)
+	assert.InDelta(t, cfg.VerticalFOV/2, tilt, 0.001)
+
+	// They're relative to wherever the head is pointing.
+	pan, tilt = bearing(pose, tr.point(pose, hexapod.Head{Pan: 20, Tilt: 10}, Detection{X: 0.5, Y: 0.5}))
+	assert.InDelta(t, 20, pan, 0.001)
+	assert.InDelta(t, 10, tilt, 0.001)
+
+	pan, tilt = bearing(pose, tr.point(pose, hexapod.Head{Pan: -15}, Detection{X: 0.25, Y: 0.5}))
+	assert.InDelta(t, -15-utils.Deg(math.Atan(0.5*math.Tan(utils.Rad(cfg.HorizontalFOV/2)))), pan, 0.001)
+	assert.InDelta(t, 0, tilt, 0.001)
+
+	// And to the pose of the body, including its orientation. Turned to the
+	// right, ahead of the camera is the +X axis.
+	pose = math3d.Pose{Position: math3d.Vector3{X: 100, Y: 40, Z: 200}, Heading: 90}
+	p = tr.point(pose, hexapod.Head{}, Detection{X: 0.5, Y: 0.5})
+	assert.InDelta(t, 1170, p.X, 0.001)
+	assert.InDelta(t, 83, p.Y, 0.001)
+	assert.InDelta(t, 200, p.Z, 0.001)
+
+	pose = math3d.Pose{Position: math3d.Vector3{Y: 40}, Pitch: 10, Bank: -5}
+	pan, tilt = bearing(pose, tr.point(pose, hexapod.Head{Pan: 5, Tilt: -5}, Detection{X: 0.5, Y: 0.5}))
+	assert.InDelta(t, 5, pan, 0.001)
+	assert.InDelta(t, -5, tilt, 0.001)
+}
+
+func TestTracks(t *testing.T) {
+	tr := newTestTracker()
+	state := standing()
+	now := time.Unix(0, 0)
+
+	// Nothing to track yet.
+	assert.NoError(t, tr.Tick(now, state))
+	assert.Equal(t, ahead, *state.LookAt)
+
+	// The first detection is followed straight away.
+	tr.detect(Detection{X: 0.5, Y: 0.5, Confidence: 0.9}, now)
+	now = now.Add(time.Second / 60)
+	assert.NoError(t, tr.Tick(now, state))
+	assert.Equal(t, math3d.Vector3{Y: 83, Z: 1070}, *state.LookAt)
+
+	// Later ones are smoothed towards.
+	want := tr.point(state.Pose, state.Head, Detection{X: 1, Y: 0.5})
+	tr.detect(Detection{X: 1, Y: 0.5, Confidence: 0.9}, now)
+	now = now.Add(time.Second / 60)
+	assert.NoError(t, tr.Tick(now, state))
+	f := 1 - math.Exp(-(1.0/60)/0.2)
+	assert.InDelta(t, want.X*f, state.LookAt.X, 0.001)
+
+	// And gets there in the end, as the vision process keeps detecting the
+	// same thing.
+	for i := 0; i < 60; i++ {
+		tr.detect(Detection{X: 1, Y: 0.5, Confidence: 0.9}, now)
+		now = now.Add(time.Second / 60)
+		assert.NoError(t, tr.Tick(now, state))
+	}
+	assert.InDelta(t, want.X, state.LookAt.X, 5)
+}
+
+func TestHandover(t *testing.T) {
+	tr := newTestTracker()
+	state := standing()
+	now := time.Unix(0, 0)
+	tracked := math3d.Vector3{Y: 83, Z: 1070}
+
+	tick := func() {
+		now = now.Add(time.Second / 60)
+		look := ahead
+		state.LookAt = &look
+		assert.NoError(t, tr.Tick(now, state))
+	}
+
+	tr.detect(Detection{X: 0.5, Y: 0.5, Confidence: 0.9}, now)
+	tick()
+	assert.Equal(t, tracked, *state.LookAt)
+	assert.True(t, tr.tracking)
+
+	// The operator wins while they're using the right stick.
+	state.Input.RightX = 100
+	tick()
+	assert.Equal(t, ahead, *state.LookAt)
+	assert.False(t, tr.tracking)
+
+	// But a little noise on it doesn't count.
+	state.Input.RightX = 5
+	tick()
+	assert.Equal(t, tracked, *state.LookAt)
+
+	// Detections go stale, after which it's back to the controller.
+	now = now.Add(500 * time.Millisecond)
+	tick()
+	assert.Equal(t, ahead, *state.LookAt)
+	assert.False(t, tr.tracking)
+
+	// As do detections which aren't confident enough, even though they're
+	// fresh.
+	tr.detect(Detection{X: 0.5, Y: 0.5, Confidence: 0.9}, now)
+	tick()
+	assert.Equal(t, tracked, *state.LookAt)
+	tr.detect(Detection{X: 0.2, Y: 0.5, Confidence: 0.3}, now)
+	tick()
+	assert.Equal(t, ahead, *state.LookAt)
+	assert.False(t, tr.tracking)
+}
+
+func TestServeHTTP(t *testing.T) {
+	tr := newTestTracker()
+
+	post := func(path, body string) int {
+		w := httptest.NewRecorder()
+		tr.ServeHTTP(w, httptest.NewRequest("POST", path, strings.NewReader(body)))
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusAccepted, post("/detection", `{"x": 0.25, "y": 0.75, "confidence": 0.8}`))
+	assert.Equal(t, Detection{X: 0.25, Y: 0.75, Confidence: 0.8}, tr.det)
+	assert.True(t, tr.fresh)
+
+	assert.Equal(t, http.StatusBadRequest, post("/detection", `{"x": 1.5, "y": 0.5, "confidence": 0.8}`))
+	assert.Equal(t, http.StatusBadRequest, post("/detection", `{"x": 0.5`))
+	assert.Equal(t, http.StatusNotFound, post("/nope", `{}`))
+
+	w := httptest.NewRecorder()
+	tr.ServeHTTP(w, httptest.NewRequest("GET", "/detection", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+}
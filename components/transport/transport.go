@@ -0,0 +1,392 @@
+// Package transport folds the legs up against the chassis and turns the torque
+// off when asked to, so the hex can be carried (e.g. in a bag), and keeps it
+// still until it's woken up again.
+package transport
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/adammck/hexapod"
+	"github.com/adammck/hexapod/components/joints"
+	"github.com/adammck/hexapod/config"
+)
+
+var log = hexapod.NewLog("transport")
+
+const (
+
+	// The buttons which must be held (without select) to wake the hex up, which
+	// are the same as those which arm it in safe mode.
+	wakeButtons = hexapod.ButtonCircle | hexapod.ButtonSquare
+
+	// How close (in mm) to the ground under it the chassis must be before the
+	// legs are folded.
+	seatedHeight = 1
+)
+
+// pose is the angle (in degrees) of each joint of a leg, relative to its zero,
+// in the same order as joints.Names. Negative raises the femur.
+type pose [4]float64
+
+var (
+
+	// Where the legs put the feet, at their home positions with the chassis on
+	// the ground, like the righting's. Unfolding ends here, so the legs have
+	// nothing to move when they take over.
+	seated = pose{0, -67, 84, 79}
+
+	// With the foot lifted clear of the floor, which the chassis is sitting on.
+	lifted = pose{0, -80, 84, 79}
+
+	// Folded up tight against the side of the chassis.
+	tucked = pose{0, -85, 140, 90}
+)
+
+// step is a pose for one leg to sweep to, by its index.
+type step struct {
+	leg  int
+	pose pose
+}
+
+// Transport is a component which folds the legs up against the chassis when
+// State.StartTransport asks it to, and turns the torque off, so the hex can be
+// carried. It halts the hex and lowers the chassis to the ground first, and
+// then folds one leg at a time (so neighbours never move at once), in a fixed
+// order: the middle legs, then the front, then the rear, each lifting its foot,
+// tucking in, and then (at the front and rear) swinging away from the middle
+// legs, so they nest. Each joint is swept no faster than MaxRate per tick,
+// within its angle limits, at reduced speed and torque.
+//
+// Once folded, the hex stays halted, and nothing moves (whatever anything else
+// asks for) until circle and square are held for a while. Then it waits for
+// the startup check (if there is one) to say that the hex is the right way up,
+// unfolds the legs in the opposite order, and hands them back to the legs,
+// which stand up as they do after booting. State.Transport says where it's up
+// to throughout.
+//
+// This must be added before the legs, for the same reasons as the righting,
+// and after the startup check, which it waits for.
+type Transport struct {
+	legs []joints.Leg
+	cfg  config.Transport
+
+	// The order to fold the legs in, by index.
+	order []int
+
+	phase hexapod.Transport
+	steps []step
+	held  time.Time
+
+	// The goal position of each joint, as last written, and its limits, which
+	// are read each time it starts moving them.
+	joints *joints.Set
+}
+
+// New creates a transport component for the given legs.
+func New(ls []joints.Leg, cfg config.Transport) *Transport {
+	t := &Transport{
+		legs:   ls,
+		cfg:    cfg,
+		order:  make([]int, len(ls)),
+		joints: joints.NewSet(ls),
+	}
+
+	for i := range t.order {
+		t.order[i] = i
+	}
+
+	sort.SliceStable(t.order, func(a, b int) bool {
+		la, lb := ls[t.order[a]], ls[t.order[b]]
+		if rank(la) != rank(lb) {
+			return rank(la) < rank(lb)
+		}
+
+		return la.Left && !lb.Left
+	})
+
+	return t
+}
+
+// rank returns when the given leg is folded: the middle legs first, since
+// the others swing away from them, then the front, then the rear.
+func rank(l joints.Leg) int {
+	switch {
+	case l.Front:
+		return 1
+	case l.Rear:
+		return 2
+	}
+
+	return 0
+}
+
+// Writes returns hexapod.Commander, since it holds the target on the ground
+// while lowering.
+func (t *Transport) Writes() hexapod.Role {
+	return hexapod.Commander
+}
+
+func (t *Transport) Boot() error {
+	return nil
+}
+
+func (t *Transport) Tick(now time.Time, state *hexapod.State) error {
+	req := state.StartTransport
+	state.StartTransport = false
+
+	if t.phase == hexapod.TransportNone && req {
+		t.begin(state)
+	}
+
+	if t.phase == hexapod.TransportNone {
+		state.Transport = t.phase
+		return nil
+	}
+
+	// Nothing else can move the hex until it's handed back.
+	state.Halt = true
+
+	switch t.phase {
+	case hexapod.TransportLowering:
+		if state.Shutdown {
+			log.Warn("shutting down, not folding")
+			t.phase = hexapod.TransportNone
+			break
+		}
+
+		state.Target = state.Pose
+		state.Target.Position.Y = 0
+		state.Target.Pitch = 0
+		state.Target.Bank = 0
+
+		p := state.Pose.Position
+		if p.Y-state.Ground.Height(p.X, p.Z) > seatedHeight {
+			break
+		}
+
+		err := t.reduce()
+		if err != nil {
+			log.Warnf("%s, not folding", err)
+			t.restore()
+			t.phase = hexapod.TransportNone
+			break
+		}
+
+		log.Info("on the ground, folding the legs")
+		t.start(hexapod.TransportFolding, t.fold())
+
+	case hexapod.TransportFolding:
+		if state.Shutdown {
+			log.Warn("shutting down, aborting folding")
+			t.restore()
+			t.phase = hexapod.TransportNone
+			break
+		}
+
+		if t.sweep() {
+			t.restore()
+			t.torque(false)
+			log.Info("folded for transport; hold circle + square to wake")
+			state.Publish(hexapod.EventTransportFolded, hexapod.Info, nil)
+			t.phase = hexapod.TransportFolded
+		}
+
+	case hexapod.TransportFolded:
+		if t.wake(now, state) {
+			log.Info("woken up")
+			state.Publish(hexapod.EventTransportWoken, hexapod.Info, nil)
+			t.phase = hexapod.TransportWaking
+		}
+
+	case hexapod.TransportWaking:
+		if state.StartupHold || state.Shutdown {
+			break
+		}
+
+		err := t.reduce()
+		if err != nil {
+			log.RateLimited("unfold", 5*time.Second).Warnf("%s, not unfolding", err)
+			t.restore()
+			break
+		}
+
+		log.Info("unfolding the legs")
+		t.torque(true)
+		t.start(hexapod.TransportUnfolding, t.unfold())
+
+	case hexapod.TransportUnfolding:
+		if t.sweep() {
+			t.restore()
+			log.Info("unfolded, handing back to the legs")
+			t.phase = hexapod.TransportNone
+			state.Halt = false
+		}
+	}
+
+	state.Transport = t.phase
+	return nil
+}
+
+// begin starts lowering the chassis to fold the legs, unless something else is
+// going on which it would interfere with.
+func (t *Transport) begin(state *hexapod.State) {
+	if state.Shutdown {
+		return
+	}
+
+	if state.Calibrating || state.SelfTesting || state.Fallen || state.Righting || state.StartupHold {
+		log.Warn("can't fold while calibrating, self-testing, fallen, or not stood up")
+		return
+	}
+
+	log.Info("lowering to fold the legs for transport")
+	t.phase = hexapod.TransportLowering
+	t.held = time.Time{}
+}
+
+// wake returns whether the wake buttons have been held (without select) for
+// long enough.
+func (t *Transport) wake(now time.Time, state *hexapod.State) bool {
+	b := state.Input.Buttons
+	if b&wakeButtons != wakeButtons || b&hexapod.ButtonSelect != 0 {
+		t.held = time.Time{}
+		return false
+	}
+
+	if t.held.IsZero() {
+		t.held = now
+	}
+
+	return now.Sub(t.held) >= t.cfg.WakeHold.Duration
+}
+
+// fold returns the steps which fold every leg up, one at a time, in order.
+func (t *Transport) fold() []step {
+	var out []step
+	for _, i := range t.order {
+		out = append(out, step{i, lifted}, step{i, tucked}, step{i, t.swung(t.legs[i])})
+	}
+
+	return out
+}
+
+// unfold returns the steps which undo fold, one leg at a time, in the opposite
+// order, ending with the feet on the ground.
+func (t *Transport) unfold() []step {
+	var out []step
+	for j := len(t.order) - 1; j >= 0; j-- {
+		i := t.order[j]
+		out = append(out, step{i, tucked}, step{i, lifted}, step{i, seated})
+	}
+
+	return out
+}
+
+// swung returns the folded pose of the given leg, which at the front swings
+// forwards, and at the rear backwards, away from the middle legs. A positive
+// coxa angle turns the foot towards +X, so that's the opposite way on each
+// side.
+func (t *Transport) swung(l joints.Leg) pose {
+	p := tucked
+	if l.Front || l.Rear {
+		p[0] = t.cfg.Swing
+		if l.Left != l.Front {
+			p[0] = -t.cfg.Swing
+		}
+	}
+
+	return p
+}
+
+// start starts sweeping through the given steps, in the given phase.
+func (t *Transport) start(p hexapod.Transport, steps []step) {
+	t.phase = p
+	t.steps = steps
+}
+
+// sweep moves the goal of each joint of the leg in the current step towards
+// the step's pose by no more than the max rate, within its limits, and moves
+// on to the next step once they've arrived. It returns whether every step is
+// done. Goals which can't be written are logged, and treated as arrived.
+func (t *Transport) sweep() bool {
+	if len(t.steps) == 0 {
+		return true
+	}
+
+	rate := int(math.Max(1, math.Round(t.cfg.MaxRate/joints.DegreesPerUnit)))
+	s := t.steps[0]
+	done := true
+
+	for j, a := range s.pose {
+		i := s.leg*len(joints.Names) + j
+		name, sv := joints.Joint(t.legs, i)
+
+		target, err := sv.Position(a)
+		if err != nil {
+			log.Warnf("%s (while posing %s)", err, name)
+			continue
+		}
+
+		target = t.joints.Clamp(i, target)
+		g := t.joints.Goals[i]
+		if g == target {
+			continue
+		}
+
+		d := target - g
+		if d > rate {
+			d = rate
+		} else if d < -rate {
+			d = -rate
+		}
+
+		g += d
+		if g != target {
+			done = false
+		}
+
+		err = sv.SetGoalPosition(g)
+		if err != nil {
+			log.Warnf("%s (while moving %s)", err, name)
+			g = target
+		}
+
+		t.joints.Goals[i] = g
+	}
+
+	if done {
+		t.steps = t.steps[1:]
+	}
+
+	return len(t.steps) == 0
+}
+
+// torque turns the torque of every servo on or off. It's off while folded, so
+// the legs can be pushed around while the hex is being carried, rather than
+// fighting it.
+func (t *Transport) torque(on bool) {
+	for i := range t.joints.Goals {
+		name, s := joints.Joint(t.legs, i)
+		err := s.SetTorqueEnable(on)
+		if err != nil {
+			log.Warnf("%s (while setting torque of %s)", err, name)
+		}
+	}
+}
+
+// reduce saves the speed and torque limit of every servo, and then sets them to
+// the configured (low) values. See joints.Set.Reduce.
+func (t *Transport) reduce() error {
+	return t.joints.Reduce(t.cfg.MoveSpeed, t.cfg.TorqueLimit)
+}
+
+// restore sets the speed and torque limit of every servo back to what they
+// were saved as. Servos which weren't saved yet are left as they are.
+func (t *Transport) restore() {
+	err := t.joints.Restore()
+	if err != nil {
+		log.Warnf("%s", err)
+	}
+}
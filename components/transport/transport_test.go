@@ -0,0 +1,375 @@
+package transport
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/adammck/hexapod"
+	"github.com/adammck/hexapod/components/joints"
+	"github.com/adammck/hexapod/config"
+	"github.com/adammck/hexapod/hexapodtest"
+	"github.com/adammck/hexapod/math3d"
+	"github.com/stretchr/testify/assert"
+)
+
+// The limits of every mock servo, which some of the poses are beyond.
+const (
+	mockCW  = 200
+	mockCCW = 800
+)
+
+// mockServo moves to its goal straight away, and records the name of its leg
+// in moves each time it's moved, so the order of the legs can be checked.
+type mockServo struct {
+	leg    string
+	moves  *[]string
+	pos    int
+	goals  []int
+	speed  int
+	torque int
+	on     bool
+}
+
+func (s *mockServo) PresentPosition() (int, error) {
+	return s.pos, nil
+}
+
+func (s *mockServo) SetGoalPosition(pos int) error {
+	s.goals = append(s.goals, pos)
+	s.pos = pos
+	*s.moves = append(*s.moves, s.leg)
+	return nil
+}
+
+func (s *mockServo) CWAngleLimit() (int, error) {
+	return mockCW, nil
+}
+
+func (s *mockServo) CCWAngleLimit() (int, error) {
+	return mockCCW, nil
+}
+
+func (s *mockServo) MovingSpeed() (int, error) {
+	return s.speed, nil
+}
+
+func (s *mockServo) SetMovingSpeed(speed int) error {
+	s.speed = speed
+	return nil
+}
+
+func (s *mockServo) TorqueLimit() (int, error) {
+	return s.torque, nil
+}
+
+func (s *mockServo) SetTorqueLimit(val int) error {
+	s.torque = val
+	return nil
+}
+
+func (s *mockServo) SetTorqueEnable(state bool) error {
+	s.on = state
+	return nil
+}
+
+func (s *mockServo) Position(angle float64) (int, error) {
+	return 512 + int(math.Round(angle/joints.DegreesPerUnit)), nil
+}
+
+func (s *mockServo) Ping() error {
+	return nil
+}
+
+func (s *mockServo) SetLED(state bool) error {
+	return nil
+}
+
+type fixture struct {
+	*hexapodtest.Clock
+	tr     *Transport
+	servos map[string][4]*mockServo
+	moves  []string
+}
+
+// setup creates a transport component for six legs, in the same order as the
+// legs component has them, standing at the seated pose.
+func setup(t *testing.T) *fixture {
+	f := &fixture{
+		Clock:  hexapodtest.NewClock(t, 20*time.Millisecond),
+		servos: map[string][4]*mockServo{},
+	}
+
+	var ls []joints.Leg
+	for _, name := range []string{"FL", "FR", "MR", "BR", "BL", "ML"} {
+		var ms [4]*mockServo
+		var ss [4]joints.Servo
+		for i := range ms {
+			ms[i] = &mockServo{leg: name, moves: &f.moves, speed: 1023, torque: 1023, on: true}
+			ms[i].pos, _ = ms[i].Position(seated[i])
+			ss[i] = ms[i]
+		}
+
+		f.servos[name] = ms
+		ls = append(ls, joints.Leg{
+			Name:   name,
+			Left:   name[1] == 'L',
+			Front:  name[0] == 'F',
+			Rear:   name[0] == 'B',
+			Servos: ss,
+		})
+	}
+
+	f.tr = New(ls, config.Default().Transport)
+	assert.NoError(t, f.tr.Boot())
+	return f
+}
+
+func (f *fixture) tick() {
+	f.Tick(f.tr)
+}
+
+// until ticks until the given phase is reached, or a minute passes. While the
+// legs are moving, the speed and torque of every servo must be reduced.
+func (f *fixture) until(p hexapod.Transport) {
+	for i := 0; i < 3000 && f.State.Transport != p; i++ {
+		f.tick()
+
+		ph := f.State.Transport
+		if ph == hexapod.TransportFolding || ph == hexapod.TransportUnfolding {
+			for _, ms := range f.servos {
+				for _, s := range ms {
+					assert.Equal(f.T, 64, s.speed)
+					assert.Equal(f.T, 256, s.torque)
+				}
+			}
+		}
+	}
+
+	assert.Equal(f.T, p, f.State.Transport)
+}
+
+// fold starts folding, and ticks until it's done.
+func (f *fixture) fold() {
+	f.State.StartTransport = true
+	f.until(hexapod.TransportFolded)
+}
+
+// order returns the order in which the legs were moved, asserting that each
+// leg was moved all at once, rather than interleaved with the others.
+func (f *fixture) order() []string {
+	var out []string
+	for _, name := range f.moves {
+		if len(out) > 0 && out[len(out)-1] == name {
+			continue
+		}
+
+		assert.NotContains(f.T, out, name, "legs were interleaved: %v", out)
+		out = append(out, name)
+	}
+
+	return out
+}
+
+// assertPose asserts that every joint of the given leg is at the given pose,
+// within the limits.
+func (f *fixture) assertPose(name string, p pose) {
+	for i, s := range f.servos[name] {
+		exp, _ := s.Position(p[i])
+		exp = int(math.Min(math.Max(float64(exp), mockCW), mockCCW))
+		assert.Equal(f.T, exp, s.pos, "%s.%s", name, joints.Names[i])
+	}
+}
+
+// assertSwept asserts that each joint was only ever moved within its limits,
+// and by no more than the max rate (2 degrees, or 7 units) per tick.
+func (f *fixture) assertSwept() {
+	for _, ms := range f.servos {
+		for i, s := range ms {
+			prev, _ := s.Position(seated[i])
+			for _, g := range s.goals {
+				assert.GreaterOrEqual(f.T, g, mockCW)
+				assert.LessOrEqual(f.T, g, mockCCW)
+				assert.LessOrEqual(f.T, math.Abs(float64(g-prev)), 7.0)
+				prev = g
+			}
+		}
+	}
+}
+
+func TestLowersBeforeFolding(t *testing.T) {
+	f := setup(t)
+	f.State.Pose.Position.Y = 60
+	f.State.Pose.Pitch = 3
+	f.State.Pose.Position.X = 10
+	f.State.StartTransport = true
+
+	for i := 0; i < 10; i++ {
+		f.tick()
+		assert.False(t, f.State.StartTransport)
+		assert.Equal(t, hexapod.TransportLowering, f.State.Transport)
+		assert.True(t, f.State.Halt)
+		assert.Equal(t, 0.0, f.State.Target.Position.Y)
+		assert.Equal(t, 0.0, f.State.Target.Pitch)
+		assert.Equal(t, 10.0, f.State.Target.Position.X)
+	}
+
+	// Nothing moves until the chassis is on the ground.
+	assert.Empty(t, f.moves)
+
+	// Which is wherever the ground is.
+	f.State.Ground = hexapod.Ground{Normal: math3d.Vector3{Y: 1}, Offset: 20}
+	f.State.Pose.Position.Y = 20.5
+	f.until(hexapod.TransportFolding)
+}
+
+func TestFolds(t *testing.T) {
+	f := setup(t)
+	f.fold()
+
+	assert.Equal(t, []string{"ML", "MR", "FL", "FR", "BL", "BR"}, f.order())
+	f.assertSwept()
+	assert.Contains(t, f.Events(), hexapod.EventTransportFolded)
+	assert.True(t, f.State.Halt)
+
+	// The front legs swing forwards, and the rear backwards.
+	swing := config.Default().Transport.Swing
+	for name, coxa := range map[string]float64{
+		"ML": 0, "MR": 0,
+		"FL": swing, "FR": -swing,
+		"BL": -swing, "BR": swing,
+	} {
+		p := tucked
+		p[0] = coxa
+		f.assertPose(name, p)
+	}
+
+	// The speed and torque limit are restored, but the torque is off.
+	for _, ms := range f.servos {
+		for _, s := range ms {
+			assert.Equal(t, 1023, s.speed)
+			assert.Equal(t, 1023, s.torque)
+			assert.False(t, s.on)
+		}
+	}
+}
+
+func TestStaysFolded(t *testing.T) {
+	f := setup(t)
+	f.fold()
+	n := len(f.moves)
+
+	// Nothing can release the halt, or start folding again.
+	for i := 0; i < 100; i++ {
+		f.State.Halt = false
+		f.State.StartTransport = true
+		f.tick()
+		assert.True(t, f.State.Halt)
+		assert.False(t, f.State.StartTransport)
+	}
+
+	// Holding the wake buttons with select doesn't count, and neither does
+	// letting go too soon.
+	f.State.Input.Buttons = wakeButtons | hexapod.ButtonSelect
+	for i := 0; i < 200; i++ {
+		f.tick()
+	}
+
+	f.State.Input.Buttons = wakeButtons
+	for i := 0; i < 50; i++ {
+		f.tick()
+	}
+
+	f.State.Input.Buttons = 0
+	f.tick()
+
+	f.State.Input.Buttons = wakeButtons
+	for i := 0; i < 50; i++ {
+		f.tick()
+	}
+
+	assert.Equal(t, hexapod.TransportFolded, f.State.Transport)
+	assert.Equal(t, n, len(f.moves))
+}
+
+func TestWakes(t *testing.T) {
+	f := setup(t)
+	f.fold()
+	f.moves = nil
+
+	// It waits for the startup check, if there is one.
+	f.State.StartupHold = true
+	f.State.Input.Buttons = wakeButtons
+	f.until(hexapod.TransportWaking)
+	assert.Contains(t, f.Events(), hexapod.EventTransportWoken)
+	f.State.Input.Buttons = 0
+
+	for i := 0; i < 100; i++ {
+		f.tick()
+		assert.Equal(t, hexapod.TransportWaking, f.State.Transport)
+		assert.True(t, f.State.Halt)
+	}
+	assert.Empty(t, f.moves)
+
+	f.State.StartupHold = false
+	f.until(hexapod.TransportNone)
+
+	assert.Equal(t, []string{"BR", "BL", "FR", "FL", "MR", "ML"}, f.order())
+	assert.False(t, f.State.Halt)
+
+	for name, ms := range f.servos {
+		f.assertPose(name, seated)
+		for _, s := range ms {
+			assert.Equal(t, 1023, s.speed)
+			assert.Equal(t, 1023, s.torque)
+			assert.True(t, s.on)
+		}
+	}
+}
+
+func TestRefuses(t *testing.T) {
+	for name, set := range map[string]func(*hexapod.State){
+		"shutdown":    func(s *hexapod.State) { s.Shutdown = true },
+		"calibrating": func(s *hexapod.State) { s.Calibrating = true },
+		"selftesting": func(s *hexapod.State) { s.SelfTesting = true },
+		"fallen":      func(s *hexapod.State) { s.Fallen = true },
+		"righting":    func(s *hexapod.State) { s.Righting = true },
+		"startup":     func(s *hexapod.State) { s.StartupHold = true },
+	} {
+		t.Run(name, func(t *testing.T) {
+			f := setup(t)
+			set(f.State)
+
+			f.State.StartTransport = true
+			for i := 0; i < 10; i++ {
+				f.tick()
+			}
+
+			assert.False(t, f.State.StartTransport)
+			assert.Equal(t, hexapod.TransportNone, f.State.Transport)
+			assert.False(t, f.State.Halt)
+			assert.Empty(t, f.moves)
+		})
+	}
+}
+
+func TestShutdownAborts(t *testing.T) {
+	f := setup(t)
+	f.State.StartTransport = true
+	f.until(hexapod.TransportFolding)
+	for i := 0; i < 20; i++ {
+		f.tick()
+	}
+
+	f.State.Shutdown = true
+	f.tick()
+	assert.Equal(t, hexapod.TransportNone, f.State.Transport)
+
+	for _, ms := range f.servos {
+		for _, s := range ms {
+			assert.Equal(t, 1023, s.speed)
+			assert.Equal(t, 1023, s.torque)
+			assert.True(t, s.on)
+		}
+	}
+}
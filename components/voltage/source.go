@@ -0,0 +1,120 @@
+package voltage
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Across is a HasVoltage which reads every servo, and returns the lowest (or
+// highest) voltage which any of them reports. The servos see the battery
+// through different lengths of cable, so the lowest is the most conservative.
+type Across struct {
+	servos []HasVoltage
+	max    bool
+}
+
+// NewAcross returns the lowest voltage of the given servos, or the highest, if
+// max is true.
+func NewAcross(servos []HasVoltage, max bool) *Across {
+	return &Across{servos, max}
+}
+
+// Voltage reads every servo, and fails if any of them can't be read, since a
+// servo which has fallen off the bus might be the one which is lowest.
+func (a *Across) Voltage() (float64, error) {
+	if len(a.servos) == 0 {
+		return 0, fmt.Errorf("no servos to read the voltage from")
+	}
+
+	var out float64
+	for i, s := range a.servos {
+		v, err := s.Voltage()
+		if err != nil {
+			return 0, err
+		}
+
+		if i == 0 || (a.max && v > out) || (!a.max && v < out) {
+			out = v
+		}
+	}
+
+	return out, nil
+}
+
+// PresentTemperature returns the temperature of the hottest servo which can
+// report it, since that's the one which limits how long the hex can walk.
+func (a *Across) PresentTemperature() (int, error) {
+	var out int
+	var found bool
+	for _, s := range a.servos {
+		ht, ok := s.(HasTemperature)
+		if !ok {
+			continue
+		}
+
+		t, err := ht.PresentTemperature()
+		if err != nil {
+			return 0, err
+		}
+
+		if !found || t > out {
+			out = t
+			found = true
+		}
+	}
+
+	if !found {
+		return 0, fmt.Errorf("no servos can report their temperature")
+	}
+
+	return out, nil
+}
+
+// ADC is a HasVoltage for a dedicated ADC channel with an Industrial I/O driver
+// (e.g. the ADS1115), via sysfs, which reads the battery through a voltage
+// divider.
+type ADC struct {
+	channel string
+	divider float64
+}
+
+// NewADC returns the IIO channel with the given path prefix, e.g.
+// /sys/bus/iio/devices/iio:device0/in_voltage0, whose readings are multiplied
+// by the divider to get the battery voltage.
+func NewADC(channel string, divider float64) (*ADC, error) {
+	_, err := os.Stat(channel + "_raw")
+	if err != nil {
+		return nil, fmt.Errorf("not a voltage channel: %s", err)
+	}
+
+	return &ADC{channel, divider}, nil
+}
+
+// Voltage reads the channel. IIO voltages are in mV once scaled. The scale is
+// read every time, since some drivers change it with the gain.
+func (a *ADC) Voltage() (float64, error) {
+	raw, err := readFloat(a.channel + "_raw")
+	if err != nil {
+		return 0, err
+	}
+
+	scale := 1.0
+	if v, err := readFloat(a.channel + "_scale"); err == nil {
+		scale = v
+	} else if !os.IsNotExist(err) {
+		return 0, fmt.Errorf("error reading scale: %s", err)
+	}
+
+	return raw * scale / 1000 * a.divider, nil
+}
+
+func readFloat(path string) (float64, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.ParseFloat(strings.TrimSpace(string(b)), 64)
+}
@@ -1,75 +1,211 @@
 package voltage
 
 import (
-	log "github.com/Sirupsen/logrus"
-	"github.com/adammck/hexapod"
+	"math"
 	"time"
+
+	"github.com/adammck/hexapod"
+	"github.com/adammck/hexapod/config"
 )
 
-var logger = log.WithFields(log.Fields{
-	"pkg": "voltage",
-})
+var log = hexapod.NewLog("voltage")
 
-const (
+// How long to wait for a voltage check before skipping it. Reading from a servo
+// normally takes a few ms, but a wedged bus can block for much longer than one
+// frame, so the check is run in a goroutine. See hexapod.Blocking.
+const tickTimeout = 100 * time.Millisecond
+
+type HasVoltage interface {
+	Voltage() (float64, error)
+}
+
+// HasTemperature can optionally be implemented by the HasVoltage, if it can also
+// read its temperature (in degrees C), as servos can.
+type HasTemperature interface {
+	PresentTemperature() (int, error)
+}
 
-	// The number of seconds between voltage checks. These are pretty quick, but
-	// not instant. Running at low voltage for too long will damage the battery,
-	// so it should be checked pretty regularly.
-	interval = 15
+// level is how low the battery is, in increasing order of concern.
+type level int
 
-	// The voltage at which the hexapod should shut down.
-	minimum = 9.6
+const (
+	ok level = iota
+	low
+	critical
 )
 
-type HasVoltage interface {
-	Voltage() (float64, error)
+func (l level) String() string {
+	switch l {
+	case low:
+		return "low"
+	case critical:
+		return "critical"
+	}
+
+	return "ok"
 }
 
-type VoltageCheck struct {
+// sample is a single reading.
+type sample struct {
 	t time.Time
+	v float64
+}
+
+type VoltageCheck struct {
+	cfg    config.Voltage
+	safety config.Safety
 	HasVoltage
+
+	// When the next check is due.
+	next time.Time
+
+	// The readings within the window, oldest first.
+	samples []sample
+
+	// How low the (averaged) battery voltage currently is.
+	level level
 }
 
-func New(hv HasVoltage) *VoltageCheck {
+// New creates a voltage check, which reads from hv. The checks are pretty quick,
+// but not instant, so are only done every safety.VoltageInterval, and averaged
+// over cfg.Window to smooth out the sag while the servos are working.
+func New(hv HasVoltage, cfg config.Voltage, safety config.Safety) *VoltageCheck {
 	return &VoltageCheck{
-		time.Time{},
-		hv,
+		cfg:        cfg,
+		safety:     safety,
+		HasVoltage: hv,
 	}
 }
 
+// Writes returns hexapod.Sensor.
+func (vc *VoltageCheck) Writes() hexapod.Role {
+	return hexapod.Sensor
+}
+
 func (vc *VoltageCheck) Boot() error {
 	return nil
 }
 
+// TickTimeout implements hexapod.Blocking.
+func (vc *VoltageCheck) TickTimeout() time.Duration {
+	return tickTimeout
+}
+
+// Tick reads the voltage, if a check is due, and updates the average, min, and
+// max in the state. When the average falls below safety.MinVoltage, it
+// publishes hexapod.EventBatteryLow as a warning, and the program should be
+// terminated as soon as possible to preserve the battery. Below the critical
+// voltage, the event is hexapod.Critical instead.
+//
+// Each is only published once per crossing: the battery must recover to the
+// threshold plus cfg.Hysteresis before it can be published again.
 func (vc *VoltageCheck) Tick(now time.Time, state *hexapod.State) error {
-	if !state.Shutdown && vc.NeedsVoltageCheck() {
-		return vc.CheckVoltage()
+	if state.Shutdown || now.Before(vc.next) {
+		return nil
+	}
+
+	val, err := vc.Voltage()
+	vc.next = now.Add(vc.safety.VoltageInterval.Duration)
+	if err != nil {
+		return err
+	}
+
+	vc.add(now, val)
+	avg, min, max := vc.stats()
+	log.Infof("voltage: %.2fv (avg=%.2fv, min=%.2fv, max=%.2fv)", val, avg, min, max)
+
+	state.Voltage = avg
+	state.VoltageMin = min
+	state.VoltageMax = max
+	vc.update(avg, state)
+
+	// Don't fail the check for this, since it's only for information.
+	if ht, ok := vc.HasVoltage.(HasTemperature); ok {
+		t, err := ht.PresentTemperature()
+		if err != nil {
+			log.Warnf("%s (while reading temperature)", err)
+		} else {
+			state.ServoTemperature = float64(t)
+		}
 	}
 
 	return nil
 }
 
-// NeedsVoltageCheck returns true if it's been a while since we checked the
-// voltage level. The timeout is pretty arbitrary.
-func (vc *VoltageCheck) NeedsVoltageCheck() bool {
-	return time.Since(vc.t) > (interval * time.Second)
+// add appends a reading, and drops those which have fallen out of the window.
+// The latest is always kept, even if the window is zero.
+func (vc *VoltageCheck) add(now time.Time, v float64) {
+	vc.samples = append(vc.samples, sample{now, v})
+
+	cutoff := now.Add(-vc.cfg.Window.Duration)
+	i := 0
+	for i < len(vc.samples)-1 && !vc.samples[i].t.After(cutoff) {
+		i++
+	}
+
+	vc.samples = vc.samples[i:]
 }
 
-// CheckVoltage fetches the voltage level of an arbitrary servo, and returns an
-// error if it's too low. In this case, the program should be terminated as soon
-// as possible to preserve the battery.
-func (vc *VoltageCheck) CheckVoltage() error {
-	val, err := vc.Voltage()
-	vc.t = time.Now()
-	if err != nil {
-		return err
+// stats returns the average, min, and max of the readings within the window.
+func (vc *VoltageCheck) stats() (avg, min, max float64) {
+	min, max = math.Inf(1), math.Inf(-1)
+	for _, s := range vc.samples {
+		avg += s.v
+		min = math.Min(min, s.v)
+		max = math.Max(max, s.v)
 	}
 
-	if val < minimum {
-		logger.Warnf("low voltage: %.2fv", val)
-	} else {
-		logger.Infof("voltage: %.2fv", val)
+	return avg / float64(len(vc.samples)), min, max
+}
+
+// classify returns the level of the given voltage, ignoring the hysteresis.
+func (vc *VoltageCheck) classify(v float64) level {
+	switch {
+	case v < vc.safety.CriticalVoltage:
+		return critical
+	case v < vc.safety.MinVoltage:
+		return low
 	}
 
-	return nil
+	return ok
+}
+
+// update moves to the level of the given average voltage, publishing the event
+// if it's worse than before. It only moves to a better level once the average
+// has cleared the threshold by the hysteresis.
+func (vc *VoltageCheck) update(avg float64, state *hexapod.State) {
+	next := vc.classify(avg)
+	if next < vc.level {
+		next = vc.classify(avg - vc.cfg.Hysteresis)
+		if next > vc.level {
+			next = vc.level
+		}
+	}
+
+	switch {
+	case next > vc.level && next == critical:
+		state.Publish(hexapod.EventBatteryLow, hexapod.Critical, avg)
+	case next > vc.level:
+		state.Publish(hexapod.EventBatteryLow, hexapod.Warning, avg)
+	case next < vc.level:
+		log.Infof("battery recovered from %s to %s at %.2fv", vc.level, next, avg)
+	}
+
+	vc.level = next
+	state.BatteryLow = next >= low
+	state.BatteryCritical = next == critical
+}
+
+// Percent returns a (very) rough estimate of the remaining battery, from zero
+// at the minimum voltage to 100 at fully charged. LiPo discharge isn't linear,
+// so this is only good enough for a dashboard.
+func Percent(v float64, cfg config.Safety) int {
+	p := (v - cfg.MinVoltage) / (cfg.FullVoltage - cfg.MinVoltage) * 100
+	if p < 0 {
+		return 0
+	}
+	if p > 100 {
+		return 100
+	}
+	return int(p)
 }
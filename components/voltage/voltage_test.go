@@ -0,0 +1,214 @@
+package voltage
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/adammck/hexapod"
+	"github.com/adammck/hexapod/config"
+	"github.com/stretchr/testify/assert"
+)
+
+// trace is a HasVoltage which returns voltages from a function of the time
+// since the start, plus some noise.
+type trace struct {
+	start time.Time
+	now   time.Time
+	noise float64
+	rand  *rand.Rand
+	f     func(t time.Duration) float64
+}
+
+func newTrace(noise float64, f func(t time.Duration) float64) *trace {
+	start := time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC)
+	return &trace{
+		start: start,
+		now:   start,
+		noise: noise,
+		rand:  rand.New(rand.NewSource(1)),
+		f:     f,
+	}
+}
+
+func (tr *trace) Voltage() (float64, error) {
+	return tr.f(tr.now.Sub(tr.start)) + (tr.rand.Float64()*2-1)*tr.noise, nil
+}
+
+// event is a published battery event, and when, since the start of the trace.
+type event struct {
+	at       time.Duration
+	severity hexapod.Severity
+}
+
+// run ticks the check once a second for the given duration, and returns the
+// events which it published, and the final state.
+func run(vc *VoltageCheck, tr *trace, d time.Duration) ([]event, *hexapod.State) {
+	var events []event
+	state := &hexapod.State{}
+
+	for ; tr.now.Sub(tr.start) <= d; tr.now = tr.now.Add(time.Second) {
+		n := len(state.Published())
+		vc.Tick(tr.now, state)
+		for _, e := range state.Published()[n:] {
+			events = append(events, event{tr.now.Sub(tr.start), e.Severity})
+		}
+	}
+
+	return events, state
+}
+
+func setup(tr *trace, window time.Duration, hysteresis float64) *VoltageCheck {
+	safety := config.Default().Safety
+	safety.MinVoltage = 10
+	safety.CriticalVoltage = 9.5
+	safety.VoltageInterval = config.Duration{Duration: 5 * time.Second}
+
+	cfg := config.Default().Voltage
+	cfg.Window = config.Duration{Duration: window}
+	cfg.Hysteresis = hysteresis
+	return New(tr, cfg, safety)
+}
+
+// discharge falls linearly from 11V to 9V over 200s, then stays there.
+func discharge(t time.Duration) float64 {
+	return 11 - 2*math.Min(t.Seconds(), 200)/200
+}
+
+func TestNoisyDischarge(t *testing.T) {
+	tr := newTrace(0.3, discharge)
+	events, state := run(setup(tr, 30*time.Second, 0.2), tr, 300*time.Second)
+
+	// One warning, shortly after crossing 10V (at 100s), and one critical
+	// shortly after 9.5V (at 150s). The window lags by half its length.
+	if assert.Len(t, events, 2) {
+		assert.Equal(t, hexapod.Warning, events[0].severity)
+		assert.InDelta(t, 115, events[0].at.Seconds(), 15)
+		assert.Equal(t, hexapod.Critical, events[1].severity)
+		assert.InDelta(t, 165, events[1].at.Seconds(), 15)
+	}
+
+	assert.True(t, state.BatteryLow)
+	assert.True(t, state.BatteryCritical)
+	assert.InDelta(t, 9, state.Voltage, 0.15)
+	assert.Less(t, state.VoltageMin, state.Voltage)
+	assert.Greater(t, state.VoltageMax, state.Voltage)
+}
+
+func TestNoisyDischargeWithoutSmoothing(t *testing.T) {
+	tr := newTrace(0.3, discharge)
+	events, _ := run(setup(tr, 0, 0), tr, 300*time.Second)
+
+	// The same trace flaps around each threshold without the window and the
+	// hysteresis, which is why they're there.
+	assert.Greater(t, len(events), 4)
+}
+
+func TestHovering(t *testing.T) {
+
+	// Hovers just below the minimum for five minutes, then is recharged.
+	tr := newTrace(0.3, func(t time.Duration) float64 {
+		if t < 300*time.Second {
+			return 9.95
+		}
+		return 12
+	})
+
+	// The noise is above the minimum at plenty of the checks, but it only
+	// warns once, and has recovered within a window of the recharge.
+	events, state := run(setup(tr, 30*time.Second, 0.2), tr, 330*time.Second)
+	assert.Equal(t, []event{{30 * time.Second, hexapod.Warning}}, events)
+	assert.False(t, state.BatteryLow)
+	assert.InDelta(t, 12, state.Voltage, 0.3)
+}
+
+func TestRecoverFromCritical(t *testing.T) {
+	safety := config.Default().Safety
+	vc := New(nil, config.Default().Voltage, safety)
+	state := &hexapod.State{}
+
+	for _, tc := range []struct {
+		avg      float64
+		low      bool
+		critical bool
+		events   int
+	}{
+		{12, false, false, 0},
+
+		// Straight to critical only publishes one event.
+		{8.5, true, true, 1},
+
+		// Above critical, but not by the hysteresis.
+		{safety.CriticalVoltage + 0.1, true, true, 0},
+
+		// Above critical by the hysteresis, but still low.
+		{safety.CriticalVoltage + 0.3, true, false, 0},
+		{safety.MinVoltage + 0.1, true, false, 0},
+
+		// And back down to critical again.
+		{8.5, true, true, 1},
+		{safety.MinVoltage + 0.3, false, false, 0},
+	} {
+		t.Run(fmt.Sprintf("%.2fV", tc.avg), func(t *testing.T) {
+			n := len(state.Published())
+			vc.update(tc.avg, state)
+			assert.Equal(t, tc.low, state.BatteryLow)
+			assert.Equal(t, tc.critical, state.BatteryCritical)
+			assert.Len(t, state.Published()[n:], tc.events)
+		})
+	}
+}
+
+// servo is a HasVoltage which can also report its temperature.
+type servo struct {
+	v float64
+	t int
+}
+
+func (s servo) Voltage() (float64, error) {
+	return s.v, nil
+}
+
+func (s servo) PresentTemperature() (int, error) {
+	return s.t, nil
+}
+
+func TestAcross(t *testing.T) {
+	servos := []HasVoltage{servo{11.9, 40}, servo{11.6, 52}, servo{11.8, 45}}
+
+	v, err := NewAcross(servos, false).Voltage()
+	assert.NoError(t, err)
+	assert.Equal(t, 11.6, v)
+
+	v, err = NewAcross(servos, true).Voltage()
+	assert.NoError(t, err)
+	assert.Equal(t, 11.9, v)
+
+	temp, err := NewAcross(servos, false).PresentTemperature()
+	assert.NoError(t, err)
+	assert.Equal(t, 52, temp)
+
+	_, err = NewAcross(nil, false).Voltage()
+	assert.EqualError(t, err, "no servos to read the voltage from")
+}
+
+func TestADC(t *testing.T) {
+	dir := t.TempDir()
+	channel := filepath.Join(dir, "in_voltage0")
+	assert.NoError(t, os.WriteFile(channel+"_raw", []byte("1500\n"), 0644))
+	assert.NoError(t, os.WriteFile(channel+"_scale", []byte("2.0\n"), 0644))
+
+	// 1500 * 2mV through a 4:1 divider.
+	adc, err := NewADC(channel, 4)
+	assert.NoError(t, err)
+	v, err := adc.Voltage()
+	assert.NoError(t, err)
+	assert.Equal(t, 12.0, v)
+
+	_, err = NewADC(filepath.Join(dir, "in_voltage1"), 4)
+	assert.Error(t, err)
+}
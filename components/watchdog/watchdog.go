@@ -0,0 +1,138 @@
+// Package watchdog stops the servos if the main loop stalls, e.g. on a serial
+// read which never returns, or a deadlock. Otherwise they'd hold their last
+// goal forever, and could overheat.
+package watchdog
+
+import (
+	"os"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/adammck/hexapod"
+	"github.com/adammck/hexapod/config"
+	"github.com/adammck/hexapod/servos"
+)
+
+var log = hexapod.NewLog("watchdog")
+
+// ExitCode is what the process exits with after the loop stalls, so whatever
+// restarts it can tell that apart from a fatal error (1) or a panic (2).
+const ExitCode = 3
+
+// How long the emergency stop waits for a write in progress to finish, before
+// giving up. A healthy write takes well under a millisecond.
+const busTimeout = 100 * time.Millisecond
+
+// Watchdog is a component which is petted every tick. If a tick doesn't arrive
+// in time, it stops the servos via the emergency path of the port (see
+// servos.Port.Emergency), which doesn't need the network lock, and exits.
+//
+// It's armed at Boot, so should come after any components which take a while
+// to boot.
+type Watchdog struct {
+	port    *servos.Port
+	timeout time.Duration
+
+	// When the last tick happened, in nanoseconds since the epoch. This is the
+	// only thing shared with the main loop, so there's no lock to get stuck
+	// on.
+	last int64
+
+	stop chan struct{}
+	once sync.Once
+
+	// Called to exit. It's os.Exit, except in tests.
+	exit func(int)
+}
+
+// New creates a watchdog which stops the servos on the given port if there's
+// no tick for the configured number of tick periods.
+func New(port *servos.Port, period time.Duration, cfg config.Watchdog) *Watchdog {
+	return &Watchdog{
+		port:    port,
+		timeout: time.Duration(cfg.Ticks) * period,
+		stop:    make(chan struct{}),
+		exit:    os.Exit,
+	}
+}
+
+// Essential returns true, because the watchdog is only useful if it's always
+// running.
+func (w *Watchdog) Essential() bool {
+	return true
+}
+
+// Boot arms the watchdog.
+func (w *Watchdog) Boot() error {
+	log.Infof("armed, with a timeout of %s", w.timeout)
+	w.pet()
+	go w.watch()
+	return nil
+}
+
+// Tick pets the watchdog. It uses the wall clock rather than now, since that's
+// what the watchdog checks against.
+func (w *Watchdog) Tick(now time.Time, state *hexapod.State) error {
+	w.pet()
+	return nil
+}
+
+// Stop disarms the watchdog, before the loop is stopped on purpose.
+func (w *Watchdog) Stop() {
+	w.once.Do(func() {
+		close(w.stop)
+	})
+}
+
+// Shutdown disarms the watchdog, once the loop has stopped. It should be
+// registered before any components whose Shutdown might take a while, since
+// it's only disarmed when its own turn comes.
+func (w *Watchdog) Shutdown() error {
+	w.Stop()
+	return nil
+}
+
+func (w *Watchdog) pet() {
+	atomic.StoreInt64(&w.last, time.Now().UnixNano())
+}
+
+// watch checks a few times per timeout whether the loop has stalled, until
+// it's stopped, or has stalled.
+func (w *Watchdog) watch() {
+	t := time.NewTicker(w.timeout / 4)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-w.stop:
+			return
+
+		case <-t.C:
+			d := time.Since(time.Unix(0, atomic.LoadInt64(&w.last)))
+			if d > w.timeout {
+				w.bite(d)
+				return
+			}
+		}
+	}
+}
+
+// bite stops the servos and exits, after logging every goroutine, to show
+// where the loop is stuck.
+func (w *Watchdog) bite(stalled time.Duration) {
+	log.Errorf("main loop has stalled for %s, stopping servos and exiting", stalled)
+
+	err := w.port.Emergency(busTimeout)
+	if err != nil {
+		log.Errorf("error stopping servos: %s", err)
+	} else {
+		log.Error("stopped servos")
+	}
+
+	buf := make([]byte, 1<<20)
+	log.Errorf("goroutines:\n%s", buf[:runtime.Stack(buf, true)])
+
+	w.exit(ExitCode)
+}
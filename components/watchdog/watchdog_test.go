@@ -0,0 +1,92 @@
+package watchdog
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/adammck/hexapod"
+	"github.com/adammck/hexapod/config"
+	"github.com/adammck/hexapod/servos"
+	"github.com/stretchr/testify/assert"
+)
+
+// serial is a serial port which keeps everything written to it.
+type serial struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (s *serial) Read(b []byte) (int, error) {
+	return 0, nil
+}
+
+func (s *serial) Write(b []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.buf.Write(b)
+}
+
+func (s *serial) Close() error {
+	return nil
+}
+
+func (s *serial) Bytes() []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]byte{}, s.buf.Bytes()...)
+}
+
+const period = 10 * time.Millisecond
+
+func newTestWatchdog() (*Watchdog, *serial, chan int) {
+	s := &serial{}
+	w := New(servos.NewPort(s), period, config.Watchdog{Ticks: 10})
+
+	exited := make(chan int, 1)
+	w.exit = func(code int) {
+		exited <- code
+	}
+
+	return w, s, exited
+}
+
+func TestStall(t *testing.T) {
+	w, s, exited := newTestWatchdog()
+	assert.NoError(t, w.Boot())
+	defer w.Stop()
+
+	// Nothing happens while it's being petted.
+	state := &hexapod.State{}
+	for i := 0; i < 30; i++ {
+		assert.NoError(t, w.Tick(time.Now(), state))
+		time.Sleep(period)
+	}
+	assert.Empty(t, exited)
+	assert.Empty(t, s.Bytes())
+
+	// But once it isn't, it stops the servos and exits.
+	select {
+	case code := <-exited:
+		assert.Equal(t, ExitCode, code)
+	case <-time.After(time.Second):
+		t.Fatal("didn't exit")
+	}
+
+	// The torque limit, via broadcast, is first.
+	assert.Equal(t, []byte{0xFF, 0xFF, 0xFE, 0x05, 0x03, 0x22}, s.Bytes()[:6])
+}
+
+func TestStop(t *testing.T) {
+	w, s, exited := newTestWatchdog()
+	assert.NoError(t, w.Boot())
+	w.Stop()
+	w.Stop()
+
+	time.Sleep(20 * period)
+	assert.Empty(t, exited)
+	assert.Empty(t, s.Bytes())
+}
@@ -0,0 +1,1514 @@
+// Package config contains the tunable constants of the whole hexapod, which
+// can be overridden by a TOML file. The defaults are the values which were
+// compiled in before this existed, so no file means no change.
+//
+// Some of these can also be changed at runtime via the params registry; the
+// config only sets their initial values.
+package config
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/adammck/hexapod/units"
+)
+
+type Config struct {
+	Identity    Identity    `toml:"identity"`
+	Controller  Controller  `toml:"controller"`
+	Legs        Legs        `toml:"legs"`
+	Gait        Gait        `toml:"gait"`
+	Safety      Safety      `toml:"safety"`
+	LEDs        LEDs        `toml:"leds"`
+	Navigator   Navigator   `toml:"navigator"`
+	Follow      Follow      `toml:"follow"`
+	Head        Head        `toml:"head"`
+	Tracker     Tracker     `toml:"tracker"`
+	KillSwitch  KillSwitch  `toml:"killswitch"`
+	Rangefinder Rangefinder `toml:"rangefinder"`
+	SelfTest    SelfTest    `toml:"selftest"`
+	Righting    Righting    `toml:"righting"`
+	Startup     Startup     `toml:"startup"`
+	Transport   Transport   `toml:"transport"`
+	Watchdog    Watchdog    `toml:"watchdog"`
+	Sysmon      Sysmon      `toml:"sysmon"`
+	Power       Power       `toml:"power"`
+	Endurance   Endurance   `toml:"endurance"`
+	Derate      Derate      `toml:"derate"`
+	Voltage     Voltage     `toml:"voltage"`
+	Telemetry   Telemetry   `toml:"telemetry"`
+	Bus         Bus         `toml:"bus"`
+	Sim         Sim         `toml:"sim"`
+
+	// Which components to enable or disable, by name, overriding whether they
+	// are by default. The --enable and --disable flags override this in turn.
+	// Names which aren't components are an error at startup (rather than when
+	// the config is parsed), since the config doesn't know what they are. See
+	// hexapod.Catalog.
+	//
+	//	[components]
+	//	telemetry = true
+	//	head = false
+	Components map[string]bool `toml:"components"`
+
+	// The name of the profile to activate at boot, or empty for none. This has
+	// to come before any tables in the file, as top-level keys do in TOML.
+	Profile string `toml:"profile"`
+
+	// Named sets of param overrides, which can be cycled through at runtime.
+	// See Profile.
+	Profiles []Profile `toml:"profiles"`
+
+	// Whether the restrictions of safe mode were applied, by the --safe-mode
+	// flag. This can't be set in the file. See Safe.
+	SafeMode bool `toml:"-"`
+}
+
+// Identity names the robot, so that two of them can be told apart in their
+// logs, telemetry, discovery beacons, etc. Every field is optional: the name
+// defaults to the hostname, and the ID to the name (made safe for MQTT topics
+// and file names). See hexapod.Identity.
+type Identity struct {
+	Name        string `toml:"name"`
+	ID          string `toml:"id"`
+	Description string `toml:"description"`
+}
+
+// Controller configures the sixaxis controller component. Distances are in mm
+// and angles in degrees, as everywhere else.
+type Controller struct {
+
+	// Distance to move per step cycle at full stick.
+	MoveSpeed float64 `toml:"move_speed"`
+
+	// Angle to rotate per step cycle at full trigger.
+	RotSpeed float64 `toml:"rot_speed"`
+
+	// The fraction of the travel of each stick (and trigger) around neutral
+	// which is ignored, since they never quite settle there, and the curve
+	// applied to the rest: zero is linear, and one is cubic, for finer control
+	// near neutral without losing any speed at full travel.
+	Deadzone float64 `toml:"deadzone"`
+	Expo     float64 `toml:"expo"`
+
+	// What rotates the hex: the triggers (R2 to the right, L2 to the left) or
+	// the right stick (left and right), for those who prefer to steer with a
+	// stick. Either "triggers" or "stick".
+	Rotation string `toml:"rotation"`
+
+	// The initial clearance (between chassis and ground), and the limits and
+	// step size when it's adjusted via Up and Down.
+	Clearance     float64 `toml:"clearance"`
+	MinClearance  float64 `toml:"min_clearance"`
+	MaxClearance  float64 `toml:"max_clearance"`
+	ClearanceStep float64 `toml:"clearance_step"`
+
+	// Distance which the focal point moves at full right stick.
+	HorizontalLookScale float64 `toml:"horizontal_look_scale"`
+	VerticalLookScale   float64 `toml:"vertical_look_scale"`
+
+	// The fraction of the look scales above which is left at full walking
+	// speed, so the head is steadier while the body is bouncing around, and
+	// the curve from full scale (standing still) to that: the speed, as a
+	// fraction of the move speed, is raised to its power. One is linear. The
+	// default scale of one disables it.
+	WalkingLookScale float64 `toml:"walking_look_scale"`
+	WalkingLookCurve float64 `toml:"walking_look_curve"`
+
+	// Maximum angle to bank and pitch using the orientation of the controller.
+	BankScale  float64 `toml:"bank_scale"`
+	PitchScale float64 `toml:"pitch_scale"`
+
+	// Maximum offset, set with the right stick while R1 is held.
+	XOffsetScale float64 `toml:"x_offset_scale"`
+	ZOffsetScale float64 `toml:"z_offset_scale"`
+
+	// While walking forwards, if the rangefinder sees something closer than
+	// the duck distance (e.g. the edge of a table), the clearance is lowered
+	// by the duck step every duck interval, down to the min clearance, so the
+	// hex can duck under it. The clearance which was set via Up and Down is
+	// restored once nothing has been seen for the duck restore. A duck
+	// distance of zero disables this.
+	DuckDistance float64  `toml:"duck_distance"`
+	DuckStep     float64  `toml:"duck_step"`
+	DuckInterval Duration `toml:"duck_interval"`
+	DuckRestore  Duration `toml:"duck_restore"`
+
+	// The inspection pose, which triangle holds (or toggles) while standing
+	// still: the max clearance, pitched nose-down by the inspect pitch, with
+	// the head looking at the ground the inspect distance ahead of the origin.
+	// The front of the chassis is about 100mm ahead of it. The pose is ramped
+	// into and out of over the inspect ramp.
+	InspectPitch    float64  `toml:"inspect_pitch"`
+	InspectDistance float64  `toml:"inspect_distance"`
+	InspectRamp     Duration `toml:"inspect_ramp"`
+
+	// The posing mode, for photography, which L1 + left toggles while
+	// standing still. The feet stay put, and the sticks move the chassis on
+	// them: the left stick at the pose speed (in mm per second, at full stick)
+	// up to the pose reach from where it started, and the right stick and
+	// triggers pitch, bank and turn it at the pose turn speed (in degrees per
+	// second) up to the pose pitch, bank and yaw. The chassis follows all of
+	// that smoothed over the pose smoothing, and back to where it started once
+	// the mode is toggled off.
+	PoseSpeed     float64  `toml:"pose_speed"`
+	PoseReach     float64  `toml:"pose_reach"`
+	PoseTurnSpeed float64  `toml:"pose_turn_speed"`
+	PosePitch     float64  `toml:"pose_pitch"`
+	PoseBank      float64  `toml:"pose_bank"`
+	PoseYaw       float64  `toml:"pose_yaw"`
+	PoseSmoothing Duration `toml:"pose_smoothing"`
+
+	// The fraction of the move speed to walk backwards at. Not being able to
+	// see where it's going, full speed in reverse is asking for trouble.
+	ReverseSpeed float64 `toml:"reverse_speed"`
+
+	// Whether the focal point (at neutral right stick) moves behind the hex
+	// once it's been walking backwards for the reverse look delay, so the head
+	// turns (as far as it can) to watch the ground near the rear. The offsets
+	// and distance are relative to the origin of the hex (see Head.Lens), so
+	// the distance is negative to be behind.
+	ReverseLook                  bool     `toml:"reverse_look"`
+	ReverseLookDelay             Duration `toml:"reverse_look_delay"`
+	ReverseFocalHorizontalOffset float64  `toml:"reverse_focal_horizontal_offset"`
+	ReverseFocalVerticalOffset   float64  `toml:"reverse_focal_vertical_offset"`
+	ReverseFocalDistance         float64  `toml:"reverse_focal_distance"`
+
+	// Holding L2 past the boom pressure (as a fraction of its travel) while
+	// not rotating holds the focal point still in the world space, e.g. to keep
+	// looking at a spot on the ground while the clearance changes, for a camera
+	// on a boom. The right stick then moves it up and down (Y) and pans it
+	// around the hex (X) at the boom speed (in mm per second), keeping its
+	// distance, rather than rotating. Letting go blends back to the usual focal
+	// point over the boom blend. Zero disables it, which is the default, since
+	// L2 past the pressure no longer turns to the left.
+	BoomPressure float64  `toml:"boom_pressure"`
+	BoomSpeed    float64  `toml:"boom_speed"`
+	BoomBlend    Duration `toml:"boom_blend"`
+
+	// Holding R1 and L1 together multiplies the move speed by the turbo factor
+	// for up to the turbo duration, after which (or once they're let go) it
+	// decays back over the turbo decay, and can't be engaged again until the
+	// cooldown has passed. While any leg is saturated, the factor is reduced
+	// by the turbo backoff per second, for the rest of the boost. A factor of
+	// one disables it.
+	TurboFactor   float64  `toml:"turbo_factor"`
+	TurboDuration Duration `toml:"turbo_duration"`
+	TurboDecay    Duration `toml:"turbo_decay"`
+	TurboCooldown Duration `toml:"turbo_cooldown"`
+	TurboBackoff  float64  `toml:"turbo_backoff"`
+
+	// Lowering the clearance to the crouch clearance (or below) switches to
+	// the crouch gait, and raising it back above that (by more than the
+	// hysteresis) switches back to whichever gait was active before. The legs
+	// switch gaits between step cycles, as usual. Zero disables it, but the
+	// crouch gait can still be selected with select + triangle.
+	CrouchClearance  float64 `toml:"crouch_clearance"`
+	CrouchHysteresis float64 `toml:"crouch_hysteresis"`
+
+	// Whether to start in auto gait mode, which picks the gait from how fast
+	// the hex is being told to walk (the distance per step cycle, like the
+	// move speed): wave below the slow speed, ripple up to the fast speed, and
+	// tripod above that. Each switch down only happens once the speed is
+	// below the threshold by more than the hysteresis, so it doesn't flap.
+	// Select + right toggles it, and selecting a gait by hand disables it.
+	AutoGait           bool    `toml:"auto_gait"`
+	AutoGaitSlow       float64 `toml:"auto_gait_slow"`
+	AutoGaitFast       float64 `toml:"auto_gait_fast"`
+	AutoGaitHysteresis float64 `toml:"auto_gait_hysteresis"`
+
+	// The button (or chord, like "select+triangle") which each action is bound
+	// to, overriding the default. An empty one unbinds the action. Nothing here
+	// is the default map, which is listed in the controller, along with the
+	// names of the actions and buttons. The sticks, triggers, R1 (which sets
+	// the offset), the turbo, and the calibration wizard aren't remappable.
+	Buttons map[string]string `toml:"buttons"`
+
+	// The params (by name, like legs.step_height) which can be nudged from the
+	// pad in the tuning mode, in the order that left and right cycle through
+	// them. Any which aren't registered when it's engaged are skipped.
+	Tuning []string `toml:"tuning"`
+}
+
+// Legs configures the legs component.
+type Legs struct {
+
+	// Distance (on the X/Z axis) from the origin to the point at which the feet
+	// should be positioned. There are very few valid settings.
+	StepRadius float64 `toml:"step_radius"`
+
+	// The step radius of each leg, overriding the one above, in the order FL,
+	// FR, MR, BR, BL, ML, e.g. to widen the middle legs for a taller stance.
+	// Zero is the step radius above. This can be empty, for all zeros.
+	StepRadii []float64 `toml:"step_radii"`
+
+	// The offset (on the Y axis) which feet are lifted to on the up step.
+	StepHeight float64 `toml:"step_height"`
+
+	// Minimum distance which the desired foot position should be from its
+	// actual position before a step should be taken to correct it.
+	MinStepDistance float64 `toml:"min_step_distance"`
+
+	// The distance which the hex can move per step cycle. Too high and the legs
+	// get tangled up.
+	MaxStepDistance float64 `toml:"max_step_distance"`
+
+	// Minimum angle to turn, before making a step.
+	MinTurnDistance float64 `toml:"min_turn_distance"`
+
+	// Maximum adjustment per tick to meet the target clearance, bank, and
+	// pitch. These mostly control how long it takes to stand up and sit down.
+	YMoveSpeed     float64 `toml:"y_move_speed"`
+	BankMoveSpeed  float64 `toml:"bank_move_speed"`
+	PitchMoveSpeed float64 `toml:"pitch_move_speed"`
+
+	// Servo moving speed and torque limit (out of 1023) while standing up, and
+	// after that.
+	MoveSpeedSlow   int `toml:"move_speed_slow"`
+	TorqueLimitSlow int `toml:"torque_limit_slow"`
+	MoveSpeedFast   int `toml:"move_speed_fast"`
+	TorqueLimitFast int `toml:"torque_limit_fast"`
+
+	// How long to stand still (with no input) before resting, or zero to never
+	// rest. Resting lowers the body by the drop, and then reduces the torque
+	// limit, so the servos don't cook while parked.
+	RestAfter       Duration `toml:"rest_after"`
+	RestDrop        float64  `toml:"rest_drop"`
+	TorqueLimitRest int      `toml:"torque_limit_rest"`
+
+	// How much the pose estimate is assumed to drift (see State.Drift): the
+	// fraction of each step's distance (including the arc of the feet while
+	// turning), and the distance (in mm) for each tick spent walking with a
+	// foot out of reach.
+	DriftRate float64 `toml:"drift_rate"`
+	SlipDrift float64 `toml:"slip_drift"`
+
+	// What to show on the servo LEDs, for debugging on the hardware: "phase"
+	// lights the legs which are in the air, and "fault" lights the legs whose
+	// goal is out of reach (and any servo with an alarm). Empty (the default)
+	// leaves them alone, and costs nothing on the bus.
+	DebugLEDs string `toml:"debug_leds"`
+
+	// The number of servos whose present position is read back on each tick,
+	// taking turns, so the servo capture (see --record-servos) has what they
+	// actually did, not just what they were told, and the ground can be fitted
+	// to where the feet actually are. Each read is a round trip on the bus, so
+	// this is zero (none) by default, which leaves the ground flat.
+	Feedback int `toml:"feedback"`
+
+	// Re-centre the stance while parked, once the feet have been displaced
+	// from their home positions under the body (e.g. by the offset) by more
+	// than the recentre distance (in mm, on average) for the recentre delay.
+	// Each foot is stepped back one at a time, like when the step radius
+	// changes, and then the offset is folded into the pose, so the body stays
+	// put. Zero distance never re-centres by itself, but it can still be asked
+	// for (see State.Recentre).
+	RecentreDistance float64  `toml:"recentre_distance"`
+	RecentreAfter    Duration `toml:"recentre_after"`
+
+	// The torque budget, to avoid browning out. See Budget.
+	Budget Budget `toml:"budget"`
+
+	// The compliance of the servos, chosen from presets at runtime. See
+	// Stiffness.
+	Stiffness Stiffness `toml:"stiffness"`
+
+	// The shape of the body, to keep it out of the ground. See Chassis.
+	Chassis Chassis `toml:"chassis"`
+
+	// How far the body can lean, depending on how stable it is. See Lean.
+	Lean Lean `toml:"lean"`
+
+	// The height of the ground under each foot, as it lands. See Terrain.
+	Terrain Terrain `toml:"terrain"`
+}
+
+// Lean limits how far the body can pitch and bank, by the stability margin
+// (see hexapod.Stability), so it can lean hard on a wide stance with every foot
+// down, but the same lean is scaled down while it's balanced on a few, e.g.
+// mid-step in the tripod gait. The lean is always scaled towards level, never
+// away, and the limits of whatever asked for it (e.g. the controller's pitch
+// and bank scales) still apply.
+type Lean struct {
+	Enabled bool `toml:"enabled"`
+
+	// The margin (in mm) at and above which the lean isn't scaled down, and at
+	// and below which the body is kept level. In between, it's scaled down in
+	// proportion.
+	FullMargin float64 `toml:"full_margin"`
+	MinMargin  float64 `toml:"min_margin"`
+
+	// The time constant of the low-pass filter which the margin is smoothed
+	// by, so the lean doesn't snap as the feet lift and land. It's moved at
+	// most the pitch and bank move speeds per tick anyway, so this only needs
+	// to be a tick or so; much longer, and it lags behind the steps, so it's
+	// only scaled down by the time they're over. Zero doesn't smooth it at all.
+	Smoothing Duration `toml:"smoothing"`
+}
+
+// Terrain is the memory of the height of the ground under each foot, which is
+// measured (with the feedback) each time it lands, so it's put down there next
+// time, rather than at Y=0, and the body stays level over a step or a bump
+// instead of being tipped by the feet which hit it first. It only evens out the
+// feet; the pitch and bank of the body are still the pose's, and leveling that
+// with an IMU (if there is one) is left to whatever sets the pose.
+//
+// It's off by default, since it needs the feedback, and remembers whatever the
+// feet are measured at, so on servos whose positions are noisy or biased by
+// more than the flat distance, it tips the feet instead.
+type Terrain struct {
+	Enabled bool `toml:"enabled"`
+
+	// The number of touch-downs which the height under each foot is averaged
+	// over. One takes the last as it is.
+	Touchdowns int `toml:"touchdowns"`
+
+	// How far (in mm) apart the heights can be for the ground to count as
+	// flat, and the time constant which they decay towards zero with while
+	// it is, or while parked, since a foot which is put down above the ground
+	// never finds out that it's lower. Zero never decays them.
+	Flat  float64  `toml:"flat"`
+	Decay Duration `toml:"decay"`
+}
+
+// Chassis is the shape of the body, for the collision guard, which clamps the
+// clearance, pitch and bank which the legs aim for, so the body isn't driven
+// into the ground (e.g. by pitching forwards at a low clearance) or onto a foot.
+// It's modelled as a box, centered on the origin, from the bottom of the coxae
+// upwards, and the ground as the plane through the feet which are on it. It
+// also says where the weight is, for the stability margin.
+type Chassis struct {
+
+	// The size (in mm) of the box, from side to side, front to back, and from
+	// bottom to top. A width or length of zero disables the guard.
+	Width  float64 `toml:"width"`
+	Length float64 `toml:"length"`
+	Height float64 `toml:"height"`
+
+	// How close (in mm) the box can come to any foot.
+	FootMargin float64 `toml:"foot_margin"`
+
+	// The position (in mm, relative to the origin of the hex) of the center
+	// of mass, for the stability margin (see hexapod.Stability). It's usually
+	// a little above the origin, and behind it if the battery is.
+	CenterOfMass [3]float64 `toml:"center_of_mass"`
+}
+
+// Gait configures the timing of the step cycle.
+type Gait struct {
+
+	// The number of ticks per step (i.e. a single foot is lifted, moved to its
+	// new position, and put down) at speed zero, and the limits when the speed
+	// is changed.
+	BaseTicksPerStep int `toml:"base_ticks_per_step"`
+	MinTicksPerStep  int `toml:"min_ticks_per_step"`
+	MaxTicksPerStep  int `toml:"max_ticks_per_step"`
+
+	// The tempo (in beats per minute) to step in time with, or zero to step at
+	// whatever pace the speed calls for. This is the initial value of the
+	// gait.bpm param, which can also be tapped on the controller.
+	BPM float64 `toml:"bpm"`
+
+	// The fraction of each step cycle which a foot spends on the ground, or
+	// zero for as long as the other feet take to step, which is half for the
+	// tripod gait and more for the others. This is the initial value of the
+	// gait.duty_factor param. Values lower than the gait's own are ignored.
+	DutyFactor float64 `toml:"duty_factor"`
+
+	// Whether the gait can be paused, and stepped through one phase or cycle at
+	// a time, via the console or the API, for debugging foot placement. Only
+	// the feet are frozen while paused; the body still follows the clearance,
+	// bank and pitch.
+	Debug bool `toml:"debug"`
+
+	// The crouch gait, for walking with the body low.
+	Crouch Crouch `toml:"crouch"`
+}
+
+// Crouch configures the crouch gait, which walks one leg at a time with the body
+// low (e.g. to pass under furniture), where the legs are folded up and can't
+// reach as far. It's used in place of the legs config while that gait is
+// active. See controller.crouch_clearance to select it automatically.
+type Crouch struct {
+
+	// The step radius of every leg, which is wider than usual to keep the
+	// knees out of the way, and the step height, which is lower since there's
+	// less room to lift the feet.
+	StepRadius float64 `toml:"step_radius"`
+	StepHeight float64 `toml:"step_height"`
+
+	// The number of ticks per step, as a multiple of the usual, since a slower
+	// step is steadier with the body so close to the ground.
+	TicksScale float64 `toml:"ticks_scale"`
+
+	// The distance which the hex can move per step cycle, in place of
+	// legs.max_step_distance, since the workspace is smaller.
+	MaxStepDistance float64 `toml:"max_step_distance"`
+}
+
+// Safety configures the thresholds which protect the hardware.
+type Safety struct {
+
+	// The voltage below which to warn that the battery needs charging, and the
+	// voltage of a fully charged battery (3S LiPo).
+	MinVoltage  float64 `toml:"min_voltage"`
+	FullVoltage float64 `toml:"full_voltage"`
+
+	// The voltage below which the battery is about to be damaged, and the hex
+	// should be stopped right now.
+	CriticalVoltage float64 `toml:"critical_voltage"`
+
+	// How often to check the voltage. Running at low voltage for too long will
+	// damage the battery.
+	VoltageInterval Duration `toml:"voltage_interval"`
+
+	// How long to wait for components to stop after requesting shutdown,
+	// before powering off the servos.
+	ShutdownGrace Duration `toml:"shutdown_grace"`
+
+	// How long a component can go without ticking successfully before it's
+	// declared unhealthy, and how many times to restart it before giving up.
+	HealthWindow Duration `toml:"health_window"`
+	Restarts     int      `toml:"restarts"`
+
+	// How long to count down (on the buzzer and the LEDs, if there are any)
+	// before an autonomous source like the navigator is allowed to start
+	// moving the hex, so it doesn't startle anyone standing next to it, or zero
+	// not to. Countdowns overrides it for a single source, by name: navigator,
+	// follow, or rosbridge. See hexapod.Autonomous.
+	Countdown  Duration            `toml:"countdown"`
+	Countdowns map[string]Duration `toml:"countdowns"`
+}
+
+// LEDs configures the status LED strip, which shows a pattern for each of the
+// statuses below. See the leds component for the names of the patterns.
+type LEDs struct {
+
+	// The number of LEDs on the strip, or zero if there isn't one.
+	Count int `toml:"count"`
+
+	// Every colour is scaled by this, from zero (off) to one. These strips are
+	// very bright, and draw a lot of current at full brightness.
+	Brightness float64 `toml:"brightness"`
+
+	// The angle (in degrees) which the pose can pitch or bank by before the hex
+	// is considered to have fallen over.
+	FallenAngle float64 `toml:"fallen_angle"`
+
+	Idle     Pattern `toml:"idle"`
+	Walking  Pattern `toml:"walking"`
+	Battery  Pattern `toml:"battery"`
+	Stopped  Pattern `toml:"stopped"`
+	Cooling  Pattern `toml:"cooling"`
+	SelfTest Pattern `toml:"selftest"`
+	Shutdown Pattern `toml:"shutdown"`
+
+	// Shown while counting down before an autonomous source starts moving the
+	// hex (see Safety.Countdown), to warn anyone nearby.
+	Countdown Pattern `toml:"countdown"`
+
+	// Shown instead of the idle and walking patterns while in safe mode (see
+	// Config.Safe), so it's obvious that the hex is restricted.
+	SafeMode Pattern `toml:"safe_mode"`
+}
+
+// Pattern is an animation for the LED strip.
+type Pattern struct {
+	Name  string `toml:"name"`
+	Color Color  `toml:"color"`
+
+	// How long a single cycle of the animation takes. For the walking pattern,
+	// this is at speed zero; it's faster or slower with the speed.
+	Period Duration `toml:"period"`
+}
+
+// Navigator configures the waypoint navigator, and the canned route which it
+// walks when asked to via the controller (select + cross).
+type Navigator struct {
+
+	// How close (in mm) the pose must get to a waypoint, and how closely (in
+	// degrees) it must be facing the same way, for it to count as reached.
+	// These can't be less than legs.min_step_distance and
+	// legs.min_turn_distance, since the legs won't step any closer.
+	Tolerance      float64 `toml:"tolerance"`
+	AngleTolerance float64 `toml:"angle_tolerance"`
+
+	// The canned route, or empty for none:
+	//
+	//	[[navigator.route]]
+	//	forward = 500.0
+	//
+	//	[[navigator.route]]
+	//	turn = -90.0
+	Route []Waypoint `toml:"route"`
+
+	// The furthest (in mm) the navigator will walk back to the home pose, and
+	// how far the pose can drift (see State.Drift) after setting it before
+	// it's forgotten, since it can't be trusted any more.
+	MaxHomeDistance float64 `toml:"max_home_distance"`
+	MaxHomeDrift    float64 `toml:"max_home_drift"`
+}
+
+// Follow configures following another hex around, at a fixed offset. The leader
+// broadcasts its pose over UDP, and the follower walks to the slot behind it.
+// There's no shared map, so the follower must be standing in its slot when it
+// first hears from the leader, and the two poses drift apart from there.
+type Follow struct {
+
+	// Whether to broadcast the pose, so that other hexes can follow this one.
+	Broadcast bool `toml:"broadcast"`
+
+	// The ID of the hex to follow (see identity.id), or empty to not follow
+	// anything.
+	Leader string `toml:"leader"`
+
+	// The UDP port which the pose is broadcast to, and listened for on, and
+	// how often it's broadcast.
+	Port     int      `toml:"port"`
+	Interval Duration `toml:"interval"`
+
+	// Where (in mm) the slot is, relative to the leader: behind it, and to its
+	// right. Negative values are in front, and to the left.
+	Behind float64 `toml:"behind"`
+	Right  float64 `toml:"right"`
+
+	// The fastest (in mm/s) the follower closes in on its slot, on top of how
+	// fast the leader is moving it, e.g. after falling behind.
+	MaxClosingSpeed float64 `toml:"max_closing_speed"`
+
+	// How long the leader can go unheard before the follower halts.
+	Timeout Duration `toml:"timeout"`
+}
+
+// Waypoint is a move relative to where the previous one ended: a distance (in
+// mm) forwards and to the right, and then a turn (in degrees) to the right.
+// Negative values go backwards and to the left.
+type Waypoint struct {
+	Forward float64 `toml:"forward"`
+	Right   float64 `toml:"right"`
+	Turn    float64 `toml:"turn"`
+}
+
+// Head configures the pan/tilt head which the camera is mounted on. Angles are
+// in degrees from looking straight ahead, to the right and up.
+type Head struct {
+
+	// The position (in mm, relative to the origin of the hex) of the head,
+	// i.e. where its pan and tilt axes cross, which it turns around to aim
+	// at the focal point. The tracker uses it too, to place detections.
+	Mount [3]float64 `toml:"mount"`
+
+	// The position (in mm, relative to the origin of the hex) of the middle
+	// of the camera lens, with the head at neutral. At neutral right stick,
+	// the controller puts the focal point straight ahead of it, level with
+	// it, at the focal distance (which is also from the origin) ahead.
+	Lens          [3]float64 `toml:"lens"`
+	FocalDistance float64    `toml:"focal_distance"`
+
+	// The range which it can turn through without hitting the mechanical
+	// stops. Anything outside is clamped to the nearest edge.
+	MinPan  float64 `toml:"min_pan"`
+	MaxPan  float64 `toml:"max_pan"`
+	MinTilt float64 `toml:"min_tilt"`
+	MaxTilt float64 `toml:"max_tilt"`
+
+	// The fastest (in degrees per second) which it turns, so flicking the
+	// stick sweeps the head around rather than snapping it against the stops.
+	MaxSpeed float64 `toml:"max_speed"`
+
+	// Where it looks when there's nothing to look at (State.LookAt is nil).
+	NeutralPan  float64 `toml:"neutral_pan"`
+	NeutralTilt float64 `toml:"neutral_tilt"`
+
+	// How far (in degrees) either side the head moves, and how long each cycle
+	// takes, when nodding (which tilts it) and shaking (which pans it).
+	NodAmplitude   float64  `toml:"nod_amplitude"`
+	NodPeriod      Duration `toml:"nod_period"`
+	ShakeAmplitude float64  `toml:"shake_amplitude"`
+	ShakePeriod    Duration `toml:"shake_period"`
+
+	// How long it takes to ease back to whatever it was looking at, after a
+	// gesture.
+	GestureBlend Duration `toml:"gesture_blend"`
+}
+
+// Tracker configures the tracker, which points the head at whatever an external
+// vision process finds in the camera frame.
+type Tracker struct {
+
+	// Detections which are less confident than this (from zero to one) are
+	// ignored.
+	MinConfidence float64 `toml:"min_confidence"`
+
+	// How long a detection is followed for, after which the focal point is
+	// left to the controller again.
+	StaleAfter Duration `toml:"stale_after"`
+
+	// The time constant of the smoothing of the focal point, or zero to
+	// follow each detection straight away.
+	Smoothing Duration `toml:"smoothing"`
+
+	// The field of view (in degrees) of the camera.
+	HorizontalFOV float64 `toml:"horizontal_fov"`
+	VerticalFOV   float64 `toml:"vertical_fov"`
+
+	// How far away (in mm) detections are assumed to be, since the camera
+	// can't tell.
+	Distance float64 `toml:"distance"`
+}
+
+// KillSwitch configures the kill switch, which is a physical switch on a GPIO
+// pin. Opening it halts the hex, and holding it open shuts it down.
+type KillSwitch struct {
+
+	// The (sysfs) number of the GPIO pin which the switch is on, or -1 if
+	// there isn't one.
+	Pin int `toml:"pin"`
+
+	// Whether the pin is low while the switch is closed, as it is when the
+	// switch is wired to ground with a pull-up. That way a broken wire reads
+	// as open.
+	ActiveLow bool `toml:"active_low"`
+
+	// How long the pin has to stay at the same level before the switch is
+	// considered to have changed, to ignore contact bounce.
+	Debounce Duration `toml:"debounce"`
+
+	// How long the switch can be held open before the hex shuts down, rather
+	// than only halting.
+	ShutdownAfter Duration `toml:"shutdown_after"`
+}
+
+// Rangefinder configures the rangefinder, which measures the distance to
+// whatever is ahead of the hex, at about the height of the body.
+type Rangefinder struct {
+
+	// How often to read the sensor. Each read can take tens of ms, so they're
+	// made in the background rather than every tick.
+	Interval Duration `toml:"interval"`
+
+	// Readings further than this (in mm) count as nothing in range, since
+	// sensors tend to get noisy near the limit of their range.
+	MaxRange float64 `toml:"max_range"`
+
+	// How long the last reading is trusted for, if the sensor stops
+	// responding. After that, nothing is in range.
+	StaleAfter Duration `toml:"stale_after"`
+}
+
+// SelfTest configures the self-test, which checks each subsystem while parked,
+// when asked to via the controller (select + R1) or at boot.
+type SelfTest struct {
+
+	// How far (in degrees) to move each joint, how close (in degrees) it must
+	// get for its position feedback to count as tracking, and how long to wait
+	// for it to get there.
+	JointDelta     float64  `toml:"joint_delta"`
+	JointTolerance float64  `toml:"joint_tolerance"`
+	Settle         Duration `toml:"settle"`
+
+	// The torque limit and moving speed (in servo units, from 0 to 1023) to
+	// move the joints with. These are low, so that a leg which is caught on
+	// something can't hurt itself (or the something).
+	TorqueLimit int `toml:"torque_limit"`
+	MoveSpeed   int `toml:"move_speed"`
+
+	// How recently the controller must have been read for its link to count as
+	// alive.
+	LinkTimeout Duration `toml:"link_timeout"`
+}
+
+// Righting configures the righting, which notices when the hex has fallen onto
+// its back, and rolls it back over when asked to. It's off by default, since
+// rolling over is rough on the legs.
+type Righting struct {
+	Enabled bool `toml:"enabled"`
+
+	// How far (in degrees) from upside down the IMU must read, and for how long
+	// while still, for the hex to count as fallen. It counts as upright again
+	// within the same angle of the right way up, for as long.
+	MaxTilt float64  `toml:"max_tilt"`
+	Settle  Duration `toml:"settle"`
+
+	// How long to wait, once the legs have pushed, for the hex to be upright
+	// before trying again (from the other side). It only tries twice.
+	Timeout Duration `toml:"timeout"`
+
+	// The most (in degrees) which any joint is moved per tick, so the legs
+	// sweep from one pose to the next rather than jumping.
+	MaxRate float64 `toml:"max_rate"`
+
+	// The torque limit and moving speed (in servo units, from 0 to 1023) to
+	// move the joints with, like the self-test's.
+	TorqueLimit int `toml:"torque_limit"`
+	MoveSpeed   int `toml:"move_speed"`
+}
+
+// Startup configures the startup check, which reads which way up the hex is
+// from the IMU before the legs stand it up for the first time, and keeps them
+// limp unless it's on its feet. It's off by default, since it needs an IMU.
+type Startup struct {
+	Enabled bool `toml:"enabled"`
+
+	// How far (in degrees) from the right way up the IMU may read for the hex
+	// to count as on its feet, and from upside down for it to count as on its
+	// back. Anywhere in between is on its side.
+	MaxTilt float64 `toml:"max_tilt"`
+
+	// The range (in g) which the magnitude of the acceleration must be within
+	// for the hex to count as still, rather than being carried around.
+	MinGravity float64 `toml:"min_gravity"`
+	MaxGravity float64 `toml:"max_gravity"`
+
+	// How long the hex must be on its feet, without a break, before the legs
+	// stand it up.
+	Settle Duration `toml:"settle"`
+
+	// The load (as a fraction of the max torque) above which a femur counts as
+	// bearing weight, and how many legs on each side must be, for the hex to
+	// count as on the ground, rather than held up off it. Zero legs skips this,
+	// which needs the femurs to be held where they are (at the legs' slow
+	// torque limit) while checking, since they can't feel anything while limp.
+	MinLoad       float64 `toml:"min_load"`
+	MinLoadedLegs int     `toml:"min_loaded_legs"`
+}
+
+// Transport configures the transport mode, which folds the legs up against the
+// chassis and turns the torque off, so the hex can be carried, e.g. in a bag.
+type Transport struct {
+
+	// The most (in degrees) which any joint is moved per tick, and the torque
+	// limit and moving speed (in servo units, from 0 to 1023) to move them with,
+	// like the righting's.
+	MaxRate     float64 `toml:"max_rate"`
+	TorqueLimit int     `toml:"torque_limit"`
+	MoveSpeed   int     `toml:"move_speed"`
+
+	// How far (in degrees) the front and rear legs are swung away from the
+	// middle ones once they're folded, so they nest.
+	Swing float64 `toml:"swing"`
+
+	// How long circle and square must be held to wake the hex up again.
+	WakeHold Duration `toml:"wake_hold"`
+}
+
+// Watchdog configures the watchdog, which stops the servos and exits if the
+// main loop stalls.
+type Watchdog struct {
+
+	// How many tick periods can pass without a tick before the loop is
+	// considered stalled, or zero to disable the watchdog (e.g. while using a
+	// debugger).
+	Ticks int `toml:"ticks"`
+}
+
+// Sysmon configures the system monitor, which sheds load when the main loop
+// can't keep up, by turning other components down via their params.
+type Sysmon struct {
+
+	// Load is shed when more than this fraction (from zero to one) of recent
+	// ticks took longer than the tick period, and restored once fewer than
+	// this fraction did.
+	ShedAbove    float64 `toml:"shed_above"`
+	RestoreBelow float64 `toml:"restore_below"`
+
+	// How long to wait after shedding or restoring a step, before the next, so
+	// its effect has time to show up in the tick timings. This is also how
+	// long to wait after booting, which is always slow.
+	Settle Duration `toml:"settle"`
+
+	// What to shed, in order, one step at a time:
+	//
+	//	[[sysmon.shed]]
+	//	param = "telemetry.rate"
+	//	value = 2.0
+	//
+	// Each step is restored (in the reverse order) to whatever it was before.
+	// Steps whose param doesn't exist, because the component which registers
+	// it isn't running, are skipped.
+	Shed []Shed `toml:"shed"`
+}
+
+// Shed is a single step of load shedding, which sets a param to a value.
+type Shed struct {
+	Param string  `toml:"param"`
+	Value float64 `toml:"value"`
+}
+
+// Power configures the power estimator, which guesses the current drawn from
+// the battery from the load on each servo, since there's no current sensor.
+type Power struct {
+
+	// The time constant of the low-pass filter which smooths the estimate,
+	// since the load readings are noisy, or zero for no smoothing.
+	Smoothing Duration `toml:"smoothing"`
+
+	// The current (in amps) drawn by everything other than the servos, and the
+	// multiplier for the current modelled for the servos. These are the
+	// initial values of the power.base and power.gain params, which are set by
+	// calibration, so they'll usually come from the settings file instead.
+	Base float64 `toml:"base"`
+	Gain float64 `toml:"gain"`
+
+	// How much current each model of servo draws:
+	//
+	//	[[power.models]]
+	//	model = 12 # AX-12A
+	//	idle = 0.05
+	//	stall = 1.5
+	//
+	// Servos whose model isn't listed (or can't be read) use the first.
+	Models []PowerModel `toml:"models"`
+}
+
+// Endurance configures endurance mode, which parks the hex for a rest now and
+// then on a long run, while its servos are heating up towards the warning
+// temperature, so they can cool down.
+type Endurance struct {
+
+	// Whether endurance mode is on. This is the initial value of the
+	// endurance.enabled param, which can be changed via the API.
+	Enabled bool `toml:"enabled"`
+
+	// The servo temperature (in degrees C) to stay below, and how far ahead to
+	// extrapolate the trend when deciding whether it's heading there.
+	WarnTemperature float64  `toml:"warn_temperature"`
+	Horizon         Duration `toml:"horizon"`
+
+	// How far back to fit the trend of the temperature over. Readings only
+	// arrive every safety.voltage_interval, so this should span a few.
+	Window Duration `toml:"window"`
+
+	// How long to walk between rests, and the shortest and longest rest. The
+	// sooner the warning temperature would be reached, the longer the rest.
+	Walk    Duration `toml:"walk"`
+	MinRest Duration `toml:"min_rest"`
+	MaxRest Duration `toml:"max_rest"`
+}
+
+// Derate configures the limits on the controller's move speed (and on the extra
+// speed from the turbo) as the battery runs down and the servos heat up, since
+// they get slower and weaker, and walking at full speed gets sloppy. Each is a
+// factor (from zero to one) of the full speed, and the lowest one wins.
+type Derate struct {
+
+	// Below the nominal voltage (of the averaged readings), the speed drops
+	// along the curve to the battery factor at safety.min_voltage, and stays
+	// there below it. The curve is applied to how far along the way the
+	// voltage is: one is linear, and more holds on to full speed for longer.
+	// A factor of one disables it.
+	NominalVoltage float64 `toml:"nominal_voltage"`
+	BatteryFactor  float64 `toml:"battery_factor"`
+	BatteryCurve   float64 `toml:"battery_curve"`
+
+	// Likewise, above the warm temperature (of the servos, in degrees C) the
+	// speed drops along the curve to the thermal factor at the hot
+	// temperature.
+	WarmTemperature float64 `toml:"warm_temperature"`
+	HotTemperature  float64 `toml:"hot_temperature"`
+	ThermalFactor   float64 `toml:"thermal_factor"`
+	ThermalCurve    float64 `toml:"thermal_curve"`
+}
+
+// Voltage configures where the battery voltage is read from, and how the
+// readings are smoothed before they're compared to the thresholds in Safety.
+type Voltage struct {
+
+	// Where to read the voltage from: "servo" (the first leg's coxa), "min" or
+	// "max" (the lowest or highest across every servo in the legs), or "adc" (a
+	// dedicated ADC, see ADC).
+	Source string `toml:"source"`
+
+	// The IIO channel of the ADC, e.g. /sys/bus/iio/devices/iio:device0/in_voltage0,
+	// and the ratio of the voltage divider which the battery is read through,
+	// which its readings are multiplied by. Only used if the source is "adc".
+	ADC     string  `toml:"adc"`
+	Divider float64 `toml:"divider"`
+
+	// How far back to average the readings over, which should span a few of
+	// safety.voltage_interval. Zero only uses the latest.
+	Window Duration `toml:"window"`
+
+	// How far (in volts) the average must rise above safety.min_voltage (or
+	// safety.critical_voltage) before the battery counts as recovered, so it
+	// doesn't flap when it's hovering around the threshold.
+	Hysteresis float64 `toml:"hysteresis"`
+}
+
+// Telemetry configures the units of the snapshots which the telemetry and the
+// API send, which are recorded in each one. Everything else (including the
+// logs, and the session summaries) stays in millimeters and degrees.
+type Telemetry struct {
+
+	// "mm" or "m", and "deg" or "rad".
+	LengthUnit string `toml:"length_unit"`
+	AngleUnit  string `toml:"angle_unit"`
+}
+
+// Units returns the units which the snapshots should be converted to.
+func (t Telemetry) Units() units.System {
+	return units.System{Length: t.LengthUnit, Angle: t.AngleUnit}
+}
+
+// Bus configures the timing of the transactions on the servo bus, which is meant
+// for finding out why a tick's writes occasionally take much longer than usual.
+// See servos.Latency.
+type Bus struct {
+
+	// A single transaction (a packet written, and its reply, if any) which
+	// takes longer than this is logged as a warning straight away, with where
+	// the time went. Zero disables the warnings.
+	SlowTransaction Duration `toml:"slow_transaction"`
+
+	// The number of the slowest transactions of the last minute to keep.
+	Worst int `toml:"worst"`
+
+	// How often to log a summary of the transactions since the last one, or
+	// zero to never log them.
+	SummaryInterval Duration `toml:"summary_interval"`
+}
+
+// Sim configures the faults which the simulated servos (see the --sim flag, and
+// hexapod-sim) inject, for seeing how the rest of the hex copes with them.
+// Everything is off by default, so the simulated servos are perfect. All but the
+// seed can also be changed at runtime, via the params of the same names.
+type Sim struct {
+
+	// The seed of the random faults, so that a run can be repeated.
+	Seed int64 `toml:"seed"`
+
+	// The standard deviation of the noise added to each reading of a servo's
+	// present position, and the largest fixed bias of each servo's readings,
+	// which is picked (from the seed) per servo. Both are in servo positions.
+	// The servos still go where they're told.
+	PositionNoise float64 `toml:"position_noise"`
+	PositionBias  float64 `toml:"position_bias"`
+
+	// The fraction (from zero to one) of the packets which a servo should
+	// respond to, which it doesn't, so the read times out, and of those which
+	// are corrupted, so it reports a checksum error.
+	TimeoutRate  float64 `toml:"timeout_rate"`
+	ChecksumRate float64 `toml:"checksum_rate"`
+
+	// How fast (in degrees per second) the IMU's reading drifts around the
+	// roll axis, and the fraction of reads of it which fail.
+	IMUDrift       float64 `toml:"imu_drift"`
+	IMUDropoutRate float64 `toml:"imu_dropout_rate"`
+
+	// How far (in volts) the battery voltage which the servos report sags
+	// while every servo is moving (or trying to), in proportion to the
+	// fraction which are.
+	Sag float64 `toml:"sag"`
+
+	// The ID of a servo which is stuck where it is, whatever its goal position
+	// (but still responds as usual), or zero for none.
+	Stuck int `toml:"stuck"`
+}
+
+// Budget configures the torque budget, which the legs keep the current drawn
+// from the battery (as estimated by the power component) within, since the BEC
+// browns out (and reboots the RPi) if too much is drawn for too long, e.g. while
+// climbing over something.
+//
+// While it's over the budget, the legs reduce one step every interval, working
+// through the stages in the priority order:
+//
+//	"swing"  the torque limit of the legs which are in the air
+//	"head"   the torque limit of the head
+//	"gait"   the speed of the gait, which is warned about, since it's obvious
+//
+// Each stage is reduced over the given number of steps, down to the swing
+// torque (out of 1023), the head torque, or the slow ticks added to the ticks
+// per step. Once the current has fallen below the budget by the hysteresis,
+// the steps are restored one every interval, in the reverse order.
+type Budget struct {
+
+	// The most current (in amps) to draw, or zero to never reduce anything.
+	Current    float64 `toml:"current"`
+	Hysteresis float64 `toml:"hysteresis"`
+
+	Interval Duration `toml:"interval"`
+	Priority []string `toml:"priority"`
+	Steps    int      `toml:"steps"`
+
+	SwingTorque int `toml:"swing_torque"`
+	HeadTorque  int `toml:"head_torque"`
+	SlowTicks   int `toml:"slow_ticks"`
+}
+
+// StiffnessPresets are the names of the stiffness presets, from the softest to
+// the stiffest, which is the order that the controller cycles through them.
+var StiffnessPresets = []string{"soft", "normal", "stiff"}
+
+// IsStiffnessPreset returns true if the given name is one of the stiffness
+// presets.
+func IsStiffnessPreset(name string) bool {
+	for _, p := range StiffnessPresets {
+		if p == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Stiffness configures the compliance of the leg servos, i.e. how far they give
+// when pushed away from their goals. Softer joints touch down more quietly
+// (e.g. on hardwood), while stiffer ones place the feet more precisely (e.g. on
+// tile). The preset can be changed at runtime, via the console, the API, or
+// select + up, but the legs only switch while every foot is on the ground.
+type Stiffness struct {
+
+	// The preset to start with: soft, normal, or stiff.
+	Preset string `toml:"preset"`
+
+	Soft   Compliance `toml:"soft"`
+	Normal Compliance `toml:"normal"`
+	Stiff  Compliance `toml:"stiff"`
+}
+
+// Compliance is the compliance margin and slope (see the AX-12 manual) of each
+// joint of every leg, in the order coxa, femur, tibia, tarsus, which are the
+// same in both directions. The margin (0-254) is how far a joint can be from
+// its goal before the servo pushes back at all, and the slope (1-254) is how
+// far it pushes back more gently than full torque, so higher values of either
+// are softer.
+type Compliance struct {
+	Margin []int `toml:"margin"`
+	Slope  []int `toml:"slope"`
+}
+
+// Compliance returns the compliance of the named preset, or false if there's no
+// such preset.
+func (s Stiffness) Compliance(name string) (Compliance, bool) {
+	switch name {
+	case "soft":
+		return s.Soft, true
+	case "normal":
+		return s.Normal, true
+	case "stiff":
+		return s.Stiff, true
+	}
+
+	return Compliance{}, false
+}
+
+// PowerModel is the current (in amps) which a model of servo (by its model
+// number) draws while holding still unloaded, and the extra which it draws at
+// full load. The current is assumed to be linear in between.
+type PowerModel struct {
+	Model int     `toml:"model"`
+	Idle  float64 `toml:"idle"`
+	Stall float64 `toml:"stall"`
+}
+
+// Color is an RGB colour which is written as a hex string (e.g. "#ff8000") in
+// the config file.
+type Color struct {
+	R, G, B uint8
+}
+
+func (c Color) MarshalText() ([]byte, error) {
+	return []byte(fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)), nil
+}
+
+func (c *Color) UnmarshalText(b []byte) error {
+	s := string(b)
+	if len(s) != 7 || s[0] != '#' {
+		return fmt.Errorf("invalid color: %q (want e.g. #ff8000)", s)
+	}
+
+	v, err := strconv.ParseUint(s[1:], 16, 32)
+	if err != nil {
+		return fmt.Errorf("invalid color: %q (want e.g. #ff8000)", s)
+	}
+
+	c.R, c.G, c.B = uint8(v>>16), uint8(v>>8), uint8(v)
+	return nil
+}
+
+// Duration is a time.Duration which is written as a string (e.g. "15s") in the
+// config file.
+type Duration struct {
+	time.Duration
+}
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+func (d *Duration) UnmarshalText(b []byte) error {
+	v, err := time.ParseDuration(string(b))
+	if err != nil {
+		return err
+	}
+
+	d.Duration = v
+	return nil
+}
+
+// Default returns the default config.
+func Default() Config {
+	return Config{
+		Controller: Controller{
+			MoveSpeed:           100,
+			RotSpeed:            15,
+			Deadzone:            0.05,
+			Expo:                0,
+			Rotation:            "triggers",
+			Clearance:           40,
+			MinClearance:        0,
+			MaxClearance:        120,
+			ClearanceStep:       10,
+			HorizontalLookScale: 250,
+			VerticalLookScale:   250,
+			WalkingLookScale:    1,
+			WalkingLookCurve:    1,
+			BankScale:           15,
+			PitchScale:          15,
+			XOffsetScale:        40,
+			ZOffsetScale:        40,
+			DuckDistance:        300,
+			DuckStep:            5,
+			DuckInterval:        Duration{200 * time.Millisecond},
+			DuckRestore:         Duration{2 * time.Second},
+			InspectPitch:        10,
+			InspectDistance:     250,
+			InspectRamp:         Duration{time.Second},
+			PoseSpeed:           10,
+			PoseReach:           25,
+			PoseTurnSpeed:       4,
+			PosePitch:           6,
+			PoseBank:            6,
+			PoseYaw:             8,
+			PoseSmoothing:       Duration{750 * time.Millisecond},
+
+			ReverseSpeed:                 1,
+			ReverseLook:                  false,
+			ReverseLookDelay:             Duration{500 * time.Millisecond},
+			ReverseFocalHorizontalOffset: 150,
+			ReverseFocalVerticalOffset:   -40,
+			ReverseFocalDistance:         -200,
+
+			BoomPressure: 0,
+			BoomSpeed:    100,
+			BoomBlend:    Duration{500 * time.Millisecond},
+
+			TurboFactor:   1.5,
+			TurboDuration: Duration{3 * time.Second},
+			TurboDecay:    Duration{time.Second},
+			TurboCooldown: Duration{10 * time.Second},
+			TurboBackoff:  1,
+
+			CrouchClearance:  25,
+			CrouchHysteresis: 10,
+
+			AutoGait:           false,
+			AutoGaitSlow:       40,
+			AutoGaitFast:       75,
+			AutoGaitHysteresis: 10,
+
+			Tuning: []string{"legs.step_height", "gait.base_ticks_per_step", "controller.deadzone", "controller.move_speed"},
+		},
+		Legs: Legs{
+			StepRadius:      240,
+			StepHeight:      40,
+			MinStepDistance: 20,
+			MaxStepDistance: 90,
+			MinTurnDistance: 5,
+			YMoveSpeed:      1,
+			BankMoveSpeed:   0.5,
+			PitchMoveSpeed:  0.5,
+			MoveSpeedSlow:   512,
+			TorqueLimitSlow: 256,
+			MoveSpeedFast:   1023,
+			TorqueLimitFast: 1023,
+			RestAfter:       Duration{3 * time.Minute},
+			RestDrop:        10,
+			TorqueLimitRest: 256,
+			DriftRate:       0.05,
+			SlipDrift:       5,
+
+			RecentreDistance: 25,
+			RecentreAfter:    Duration{5 * time.Second},
+
+			Budget: Budget{
+				Current:     0,
+				Hysteresis:  0.5,
+				Interval:    Duration{250 * time.Millisecond},
+				Priority:    []string{"swing", "head", "gait"},
+				Steps:       4,
+				SwingTorque: 300,
+				HeadTorque:  200,
+				SlowTicks:   8,
+			},
+			Stiffness: Stiffness{
+				Preset: "normal",
+				Soft: Compliance{
+					Margin: []int{1, 1, 1, 1},
+					Slope:  []int{64, 128, 128, 64},
+				},
+				Normal: Compliance{
+					Margin: []int{1, 1, 1, 1},
+					Slope:  []int{32, 32, 32, 32},
+				},
+				Stiff: Compliance{
+					Margin: []int{0, 0, 0, 0},
+					Slope:  []int{16, 32, 32, 16},
+				},
+			},
+			Chassis: Chassis{
+				Width:        170,
+				Length:       230,
+				Height:       60,
+				FootMargin:   15,
+				CenterOfMass: [3]float64{0, 30, 0},
+			},
+			Lean: Lean{
+				Enabled:    true,
+				FullMargin: 100,
+				MinMargin:  10,
+				Smoothing:  Duration{20 * time.Millisecond},
+			},
+			Terrain: Terrain{
+				Enabled:    false,
+				Touchdowns: 3,
+				Flat:       5,
+				Decay:      Duration{2 * time.Second},
+			},
+		},
+		Gait: Gait{
+			BaseTicksPerStep: 20,
+			MinTicksPerStep:  4,
+			MaxTicksPerStep:  80,
+			Crouch: Crouch{
+				StepRadius:      250,
+				StepHeight:      20,
+				TicksScale:      1.5,
+				MaxStepDistance: 50,
+			},
+		},
+		Safety: Safety{
+			MinVoltage:      9.6,
+			FullVoltage:     12.6,
+			CriticalVoltage: 9.0,
+			VoltageInterval: Duration{15 * time.Second},
+			ShutdownGrace:   Duration{2 * time.Second},
+			HealthWindow:    Duration{2 * time.Second},
+			Restarts:        3,
+			Countdown:       Duration{3 * time.Second},
+		},
+		LEDs: LEDs{
+			Count:       0,
+			Brightness:  0.25,
+			FallenAngle: 45,
+			Idle:        Pattern{"breathe", Color{0, 255, 0}, Duration{4 * time.Second}},
+			Walking:     Pattern{"chase", Color{0, 0, 255}, Duration{time.Second}},
+			Battery:     Pattern{"solid", Color{255, 128, 0}, Duration{}},
+			Stopped:     Pattern{"flash", Color{255, 0, 0}, Duration{500 * time.Millisecond}},
+			Cooling:     Pattern{"breathe", Color{0, 255, 255}, Duration{3 * time.Second}},
+			SelfTest:    Pattern{"chase", Color{255, 255, 0}, Duration{2 * time.Second}},
+			Shutdown:    Pattern{"wipe", Color{128, 0, 255}, Duration{time.Second}},
+			Countdown:   Pattern{"flash", Color{255, 255, 255}, Duration{250 * time.Millisecond}},
+			SafeMode:    Pattern{"flash", Color{255, 255, 0}, Duration{2 * time.Second}},
+		},
+		Navigator: Navigator{
+			Tolerance:       25,
+			AngleTolerance:  6,
+			MaxHomeDistance: 3000,
+			MaxHomeDrift:    500,
+		},
+		Follow: Follow{
+			Port:            7338,
+			Interval:        Duration{50 * time.Millisecond},
+			Behind:          400,
+			MaxClosingSpeed: 100,
+			Timeout:         Duration{500 * time.Millisecond},
+		},
+		Head: Head{
+			Mount:          [3]float64{0, 43, 70},
+			Lens:           [3]float64{0, 43 + 34.5, 70}, // mount + y distance to middle of lens
+			FocalDistance:  500,
+			MinPan:         -45,
+			MaxPan:         45,
+			MinTilt:        -10,
+			MaxTilt:        20,
+			MaxSpeed:       180,
+			NeutralPan:     0,
+			NeutralTilt:    0,
+			NodAmplitude:   10,
+			NodPeriod:      Duration{500 * time.Millisecond},
+			ShakeAmplitude: 15,
+			ShakePeriod:    Duration{400 * time.Millisecond},
+			GestureBlend:   Duration{300 * time.Millisecond},
+		},
+		Tracker: Tracker{
+			MinConfidence: 0.5,
+			StaleAfter:    Duration{500 * time.Millisecond},
+			Smoothing:     Duration{200 * time.Millisecond},
+			HorizontalFOV: 62.2,
+			VerticalFOV:   48.8,
+			Distance:      1000,
+		},
+		KillSwitch: KillSwitch{
+			Pin:           -1,
+			ActiveLow:     true,
+			Debounce:      Duration{20 * time.Millisecond},
+			ShutdownAfter: Duration{2 * time.Second},
+		},
+		Rangefinder: Rangefinder{
+			Interval:   Duration{50 * time.Millisecond},
+			MaxRange:   1200,
+			StaleAfter: Duration{500 * time.Millisecond},
+		},
+		SelfTest: SelfTest{
+			JointDelta:     5,
+			JointTolerance: 2,
+			Settle:         Duration{500 * time.Millisecond},
+			TorqueLimit:    256,
+			MoveSpeed:      64,
+			LinkTimeout:    Duration{time.Second},
+		},
+		Righting: Righting{
+			MaxTilt:     45,
+			Settle:      Duration{time.Second},
+			Timeout:     Duration{3 * time.Second},
+			MaxRate:     3,
+			TorqueLimit: 384,
+			MoveSpeed:   128,
+		},
+		Startup: Startup{
+			MaxTilt:    30,
+			MinGravity: 0.8,
+			MaxGravity: 1.2,
+			Settle:     Duration{500 * time.Millisecond},
+			MinLoad:    0.05,
+		},
+		Transport: Transport{
+			MaxRate:     2,
+			TorqueLimit: 256,
+			MoveSpeed:   64,
+			Swing:       35,
+			WakeHold:    Duration{2 * time.Second},
+		},
+		Watchdog: Watchdog{
+			Ticks: 30,
+		},
+		Sysmon: Sysmon{
+			ShedAbove:    0.1,
+			RestoreBelow: 0.02,
+			Settle:       Duration{5 * time.Second},
+			Shed: []Shed{
+				{"statelog.every", 6},
+				{"telemetry.rate", 2},
+				{"statelog.every", 60},
+			},
+		},
+		Power: Power{
+			Smoothing: Duration{time.Second},
+			Base:      0.4,
+			Gain:      1,
+			Models: []PowerModel{
+				{Model: 12, Idle: 0.05, Stall: 1.5},
+			},
+		},
+		Endurance: Endurance{
+			Enabled:         false,
+			WarnTemperature: 65,
+			Horizon:         Duration{10 * time.Minute},
+			Window:          Duration{2 * time.Minute},
+			Walk:            Duration{2 * time.Minute},
+			MinRest:         Duration{15 * time.Second},
+			MaxRest:         Duration{time.Minute},
+		},
+		Derate: Derate{
+			NominalVoltage:  11.1,
+			BatteryFactor:   0.6,
+			BatteryCurve:    1,
+			WarmTemperature: 55,
+			HotTemperature:  65,
+			ThermalFactor:   0.7,
+			ThermalCurve:    1,
+		},
+		Voltage: Voltage{
+			Source:     "servo",
+			Divider:    1,
+			Window:     Duration{45 * time.Second},
+			Hysteresis: 0.2,
+		},
+		Telemetry: Telemetry{
+			LengthUnit: units.Millimeters,
+			AngleUnit:  units.Degrees,
+		},
+		Bus: Bus{
+			SlowTransaction: Duration{20 * time.Millisecond},
+			Worst:           10,
+			SummaryInterval: Duration{time.Minute},
+		},
+	}
+}
+
+// Load reads the config file at the given path, and returns it merged over the
+// defaults. If the path is empty or the file doesn't exist, the defaults are
+// returned.
+func Load(path string) (Config, error) {
+	if path == "" {
+		return Default(), nil
+	}
+
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return Default(), nil
+	}
+	if err != nil {
+		return Config{}, err
+	}
+
+	c, err := Parse(string(b))
+	if err != nil {
+		return Config{}, fmt.Errorf("%s (while loading %s)", err, path)
+	}
+
+	return c, nil
+}
+
+// Parse parses the given TOML, and returns it merged over the defaults. Keys
+// which aren't part of the config are an error, since they're probably typos.
+func Parse(s string) (Config, error) {
+	c := Default()
+
+	md, err := toml.Decode(s, &c)
+	if err != nil {
+		return Config{}, err
+	}
+
+	if u := md.Undecoded(); len(u) > 0 {
+		keys := make([]string, len(u))
+		for i, k := range u {
+			keys[i] = k.String()
+		}
+		return Config{}, fmt.Errorf("unknown keys: %s", strings.Join(keys, ", "))
+	}
+
+	err = c.Validate()
+	if err != nil {
+		return Config{}, err
+	}
+
+	return c, nil
+}
+
+// Encode returns the config as TOML, which Parse can read back.
+func (c Config) Encode() (string, error) {
+	var sb strings.Builder
+	err := toml.NewEncoder(&sb).Encode(c)
+	if err != nil {
+		return "", err
+	}
+
+	return sb.String(), nil
+}
@@ -0,0 +1,711 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDefault(t *testing.T) {
+	c := Default()
+	assert.NoError(t, c.Validate())
+
+	// Spot check a few against the old constants.
+	assert.Equal(t, 100.0, c.Controller.MoveSpeed)
+	assert.Equal(t, 77.5, c.Head.Lens[1])
+	assert.Equal(t, 240.0, c.Legs.StepRadius)
+	assert.Equal(t, 20, c.Gait.BaseTicksPerStep)
+	assert.Equal(t, 15*time.Second, c.Safety.VoltageInterval.Duration)
+}
+
+func TestDefaultRoundTrip(t *testing.T) {
+	s, err := Default().Encode()
+	assert.NoError(t, err)
+	assert.Contains(t, s, `voltage_interval = "15s"`)
+
+	c, err := Parse(s)
+	assert.NoError(t, err)
+	assert.Equal(t, Default(), c)
+
+	// Nothing at all is the defaults, too.
+	c, err = Parse("")
+	assert.NoError(t, err)
+	assert.Equal(t, Default(), c)
+}
+
+func TestLoadFull(t *testing.T) {
+	c, err := Load("testdata/full.toml")
+	assert.NoError(t, err)
+
+	assert.Equal(t, Identity{
+		Name:        "Hex Two",
+		ID:          "hex-2",
+		Description: "the one with the blue legs",
+	}, c.Identity)
+
+	assert.Equal(t, Controller{
+		MoveSpeed:           150,
+		RotSpeed:            20,
+		Deadzone:            0.08,
+		Expo:                0.4,
+		Rotation:            "stick",
+		Clearance:           50,
+		MinClearance:        20,
+		MaxClearance:        100,
+		ClearanceStep:       5,
+		HorizontalLookScale: 200,
+		VerticalLookScale:   150,
+		WalkingLookScale:    0.5,
+		WalkingLookCurve:    2,
+		BankScale:           10,
+		PitchScale:          12,
+		XOffsetScale:        30,
+		ZOffsetScale:        35,
+		DuckDistance:        250,
+		DuckStep:            4,
+		DuckInterval:        Duration{100 * time.Millisecond},
+		DuckRestore:         Duration{3 * time.Second},
+		InspectPitch:        12,
+		InspectDistance:     300,
+		InspectRamp:         Duration{500 * time.Millisecond},
+		PoseSpeed:           5,
+		PoseReach:           15,
+		PoseTurnSpeed:       2,
+		PosePitch:           4,
+		PoseBank:            3,
+		PoseYaw:             5,
+		PoseSmoothing:       Duration{time.Second},
+
+		ReverseSpeed:                 0.6,
+		ReverseLook:                  true,
+		ReverseLookDelay:             Duration{750 * time.Millisecond},
+		ReverseFocalHorizontalOffset: -120,
+		ReverseFocalVerticalOffset:   -30,
+		ReverseFocalDistance:         -150,
+
+		BoomPressure: 0.9,
+		BoomSpeed:    150,
+		BoomBlend:    Duration{300 * time.Millisecond},
+
+		TurboFactor:   2,
+		TurboDuration: Duration{5 * time.Second},
+		TurboDecay:    Duration{500 * time.Millisecond},
+		TurboCooldown: Duration{20 * time.Second},
+		TurboBackoff:  2,
+
+		CrouchClearance:  20,
+		CrouchHysteresis: 5,
+
+		AutoGait:           true,
+		AutoGaitSlow:       30,
+		AutoGaitFast:       60,
+		AutoGaitHysteresis: 5,
+
+		Buttons: map[string]string{
+			"dump":        "square",
+			"next_gait":   "select+l1",
+			"tempo_tap":   "",
+			"tempo_clear": "",
+		},
+
+		Tuning: []string{"gait.duty_factor", "legs.step_height"},
+	}, c.Controller)
+
+	assert.Equal(t, Legs{
+		StepRadius:      250,
+		StepRadii:       []float64{0, 0, 270, 0, 0, 270},
+		StepHeight:      50,
+		MinStepDistance: 15,
+		MaxStepDistance: 80,
+		MinTurnDistance: 3,
+		YMoveSpeed:      2,
+		BankMoveSpeed:   0.25,
+		PitchMoveSpeed:  0.75,
+		MoveSpeedSlow:   400,
+		TorqueLimitSlow: 300,
+		MoveSpeedFast:   900,
+		TorqueLimitFast: 1000,
+		RestAfter:       Duration{10 * time.Minute},
+		RestDrop:        15,
+		TorqueLimitRest: 200,
+		DriftRate:       0.1,
+		SlipDrift:       2.5,
+		DebugLEDs:       "phase",
+		Feedback:        4,
+
+		RecentreDistance: 40,
+		RecentreAfter:    Duration{10 * time.Second},
+
+		Budget: Budget{
+			Current:     4.5,
+			Hysteresis:  0.8,
+			Interval:    Duration{500 * time.Millisecond},
+			Priority:    []string{"head", "swing", "gait"},
+			Steps:       3,
+			SwingTorque: 400,
+			HeadTorque:  100,
+			SlowTicks:   6,
+		},
+		Stiffness: Stiffness{
+			Preset: "soft",
+			Soft: Compliance{
+				Margin: []int{2, 2, 2, 2},
+				Slope:  []int{128, 128, 128, 128},
+			},
+			Normal: Compliance{
+				Margin: []int{1, 1, 1, 1},
+				Slope:  []int{64, 32, 32, 64},
+			},
+			Stiff: Compliance{
+				Margin: []int{0, 1, 1, 0},
+				Slope:  []int{8, 16, 16, 8},
+			},
+		},
+		Chassis: Chassis{
+			Width:        180,
+			Length:       240,
+			Height:       50,
+			FootMargin:   20,
+			CenterOfMass: [3]float64{5, 25, -15},
+		},
+		Lean: Lean{
+			FullMargin: 110,
+			MinMargin:  30,
+			Smoothing:  Duration{250 * time.Millisecond},
+		},
+		Terrain: Terrain{
+			Enabled:    true,
+			Touchdowns: 4,
+			Flat:       8,
+			Decay:      Duration{3 * time.Second},
+		},
+	}, c.Legs)
+
+	assert.Equal(t, Gait{
+		BaseTicksPerStep: 30,
+		MinTicksPerStep:  8,
+		MaxTicksPerStep:  60,
+		BPM:              120,
+		DutyFactor:       0.6,
+		Debug:            true,
+		Crouch: Crouch{
+			StepRadius:      260,
+			StepHeight:      15,
+			TicksScale:      2,
+			MaxStepDistance: 40,
+		},
+	}, c.Gait)
+
+	assert.Equal(t, Safety{
+		MinVoltage:      10,
+		FullVoltage:     12.4,
+		CriticalVoltage: 9.5,
+		VoltageInterval: Duration{30 * time.Second},
+		ShutdownGrace:   Duration{1500 * time.Millisecond},
+		HealthWindow:    Duration{5 * time.Second},
+		Restarts:        1,
+		Countdown:       Duration{5 * time.Second},
+		Countdowns: map[string]Duration{
+			"follow":    {},
+			"rosbridge": {time.Second},
+		},
+	}, c.Safety)
+
+	assert.Equal(t, LEDs{
+		Count:       12,
+		Brightness:  0.5,
+		FallenAngle: 60,
+		Idle:        Pattern{"solid", Color{0, 255, 128}, Duration{2 * time.Second}},
+		Walking:     Pattern{"chase", Color{0, 128, 255}, Duration{1500 * time.Millisecond}},
+		Battery:     Pattern{"breathe", Color{255, 160, 0}, Duration{time.Second}},
+		Stopped:     Pattern{"flash", Color{255, 0, 0}, Duration{250 * time.Millisecond}},
+		Cooling:     Pattern{"solid", Color{0, 255, 255}, Duration{}},
+		SelfTest:    Pattern{"breathe", Color{255, 255, 0}, Duration{time.Second}},
+		Shutdown:    Pattern{"wipe", Color{255, 255, 255}, Duration{3 * time.Second}},
+		Countdown:   Pattern{"flash", Color{255, 255, 255}, Duration{100 * time.Millisecond}},
+		SafeMode:    Pattern{"chase", Color{255, 0, 255}, Duration{time.Second}},
+	}, c.LEDs)
+
+	assert.Equal(t, Navigator{
+		Tolerance:       30,
+		AngleTolerance:  8,
+		Route:           []Waypoint{{Forward: 500}, {Turn: -90}, {Forward: 300, Right: 20}},
+		MaxHomeDistance: 2000,
+		MaxHomeDrift:    250,
+	}, c.Navigator)
+
+	assert.Equal(t, Follow{
+		Broadcast:       true,
+		Leader:          "alpha-1",
+		Port:            7400,
+		Interval:        Duration{100 * time.Millisecond},
+		Behind:          500,
+		Right:           150,
+		MaxClosingSpeed: 150,
+		Timeout:         Duration{time.Second},
+	}, c.Follow)
+
+	assert.Equal(t, Head{
+		Mount:          [3]float64{5, 63, 72},
+		Lens:           [3]float64{10, 97.5, 75},
+		FocalDistance:  600,
+		MinPan:         -60,
+		MaxPan:         50,
+		MinTilt:        -15,
+		MaxTilt:        25,
+		MaxSpeed:       90,
+		NeutralPan:     5,
+		NeutralTilt:    -5,
+		NodAmplitude:   8,
+		NodPeriod:      Duration{600 * time.Millisecond},
+		ShakeAmplitude: 20,
+		ShakePeriod:    Duration{500 * time.Millisecond},
+		GestureBlend:   Duration{200 * time.Millisecond},
+	}, c.Head)
+
+	assert.Equal(t, Tracker{
+		MinConfidence: 0.7,
+		StaleAfter:    Duration{time.Second},
+		Smoothing:     Duration{100 * time.Millisecond},
+		HorizontalFOV: 53.5,
+		VerticalFOV:   41.4,
+		Distance:      1500,
+	}, c.Tracker)
+
+	assert.Equal(t, KillSwitch{
+		Pin:           17,
+		ActiveLow:     false,
+		Debounce:      Duration{50 * time.Millisecond},
+		ShutdownAfter: Duration{3 * time.Second},
+	}, c.KillSwitch)
+
+	assert.Equal(t, Rangefinder{
+		Interval:   Duration{100 * time.Millisecond},
+		MaxRange:   800,
+		StaleAfter: Duration{time.Second},
+	}, c.Rangefinder)
+
+	assert.Equal(t, SelfTest{
+		JointDelta:     8,
+		JointTolerance: 3,
+		Settle:         Duration{750 * time.Millisecond},
+		TorqueLimit:    300,
+		MoveSpeed:      100,
+		LinkTimeout:    Duration{2 * time.Second},
+	}, c.SelfTest)
+
+	assert.Equal(t, Righting{
+		Enabled:     true,
+		MaxTilt:     30,
+		Settle:      Duration{2 * time.Second},
+		Timeout:     Duration{5 * time.Second},
+		MaxRate:     2,
+		TorqueLimit: 300,
+		MoveSpeed:   100,
+	}, c.Righting)
+
+	assert.Equal(t, Startup{
+		Enabled:       true,
+		MaxTilt:       20,
+		MinGravity:    0.9,
+		MaxGravity:    1.1,
+		Settle:        Duration{time.Second},
+		MinLoad:       0.1,
+		MinLoadedLegs: 2,
+	}, c.Startup)
+
+	assert.Equal(t, Transport{
+		MaxRate:     1.5,
+		TorqueLimit: 200,
+		MoveSpeed:   50,
+		Swing:       30,
+		WakeHold:    Duration{3 * time.Second},
+	}, c.Transport)
+
+	assert.Equal(t, Watchdog{Ticks: 20}, c.Watchdog)
+
+	assert.Equal(t, Sysmon{
+		ShedAbove:    0.2,
+		RestoreBelow: 0.05,
+		Settle:       Duration{10 * time.Second},
+		Shed:         []Shed{{"telemetry.rate", 1}},
+	}, c.Sysmon)
+
+	assert.Equal(t, Power{
+		Smoothing: Duration{2 * time.Second},
+		Base:      0.5,
+		Gain:      1.2,
+		Models:    []PowerModel{{12, 0.04, 1.2}, {18, 0.05, 2.2}},
+	}, c.Power)
+
+	assert.Equal(t, Endurance{
+		Enabled:         true,
+		WarnTemperature: 60,
+		Horizon:         Duration{5 * time.Minute},
+		Window:          Duration{90 * time.Second},
+		Walk:            Duration{3 * time.Minute},
+		MinRest:         Duration{20 * time.Second},
+		MaxRest:         Duration{45 * time.Second},
+	}, c.Endurance)
+
+	assert.Equal(t, Derate{
+		NominalVoltage:  11.4,
+		BatteryFactor:   0.5,
+		BatteryCurve:    2,
+		WarmTemperature: 50,
+		HotTemperature:  60,
+		ThermalFactor:   0.8,
+		ThermalCurve:    0.5,
+	}, c.Derate)
+
+	assert.Equal(t, Voltage{
+		Source:     "adc",
+		ADC:        "/sys/bus/iio/devices/iio:device0/in_voltage0",
+		Divider:    4,
+		Window:     Duration{time.Minute},
+		Hysteresis: 0.3,
+	}, c.Voltage)
+
+	assert.Equal(t, Telemetry{LengthUnit: "m", AngleUnit: "rad"}, c.Telemetry)
+
+	assert.Equal(t, Bus{
+		SlowTransaction: Duration{15 * time.Millisecond},
+		Worst:           5,
+		SummaryInterval: Duration{30 * time.Second},
+	}, c.Bus)
+
+	assert.Equal(t, Sim{
+		Seed:           42,
+		PositionNoise:  1.5,
+		PositionBias:   4,
+		TimeoutRate:    0.01,
+		ChecksumRate:   0.02,
+		IMUDrift:       0.5,
+		IMUDropoutRate: 0.1,
+		Sag:            1.2,
+		Stuck:          13,
+	}, c.Sim)
+
+	assert.Equal(t, map[string]bool{"head": false, "telemetry": true}, c.Components)
+
+	assert.Equal(t, "outdoor", c.Profile)
+	assert.Equal(t, []Profile{
+		{Name: "indoor", Params: map[string]float64{"controller.clearance": 30, "legs.step_height": 25, "hexapod.speed": -4}},
+		{Name: "outdoor", Params: map[string]float64{"controller.clearance": 80}},
+	}, c.Profiles)
+
+	// And back again.
+	s, err := c.Encode()
+	assert.NoError(t, err)
+	c2, err := Parse(s)
+	assert.NoError(t, err)
+	assert.Equal(t, c, c2)
+}
+
+func TestLoadPartial(t *testing.T) {
+	c, err := Parse("[legs]\nstep_height = 60.0\n")
+	assert.NoError(t, err)
+
+	exp := Default()
+	exp.Legs.StepHeight = 60
+	assert.Equal(t, exp, c)
+}
+
+func TestLoadMissing(t *testing.T) {
+	c, err := Load(filepath.Join(t.TempDir(), "nope.toml"))
+	assert.NoError(t, err)
+	assert.Equal(t, Default(), c)
+
+	c, err = Load("")
+	assert.NoError(t, err)
+	assert.Equal(t, Default(), c)
+}
+
+func TestLoadInvalid(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "bad.toml")
+	assert.NoError(t, os.WriteFile(p, []byte("[controller]\nmove_speed = 999.0\n"), 0644))
+
+	_, err := Load(p)
+	assert.EqualError(t, err, "controller.move_speed: must be between 0 and 200, but is 999 (while loading "+p+")")
+}
+
+func TestValidationFailures(t *testing.T) {
+	type eg struct {
+		toml string
+		key  string
+	}
+
+	examples := []eg{
+		{"[identity]\nid = \"Hex 2\"", "identity.id"},
+		{"[controller]\nrot_speed = -1.0", "controller.rot_speed"},
+		{"[controller]\ndeadzone = 0.6", "controller.deadzone"},
+		{"[controller]\nexpo = 1.5", "controller.expo"},
+		{"[controller]\nrotation = \"wheel\"", "controller.rotation"},
+		{"[controller]\ntuning = [\"legs.step_height\", \"\"]", "controller.tuning[1]"},
+		{"[controller]\ntuning = [\"legs.step_height\", \"legs.step_height\"]", "controller.tuning[1]"},
+		{"[controller]\nmin_clearance = 50.0\nmax_clearance = 40.0", "controller.max_clearance"},
+		{"[controller]\nclearance_step = 0.0", "controller.clearance_step"},
+		{"[controller]\nwalking_look_scale = 1.5", "controller.walking_look_scale"},
+		{"[controller]\nwalking_look_curve = 0.0", "controller.walking_look_curve"},
+		{"[head]\nfocal_distance = 50.0", "head.focal_distance"},
+		{"[head]\nmount = [0.0, 600.0, 0.0]", "head.mount[1]"},
+		{"[head]\nlens = [0.0, 163.0, 70.0]", "head.lens"},
+		{"[legs]\nstep_radius = 50.0", "legs.step_radius"},
+		{"[legs.chassis]\ncenter_of_mass = [0.0, 30.0, 300.0]", "legs.chassis.center_of_mass[2]"},
+		{"[legs.lean]\nfull_margin = 0.0", "legs.lean.full_margin"},
+		{"[legs.lean]\nmin_margin = 150.0", "legs.lean.min_margin"},
+		{"[legs.terrain]\ntouchdowns = 0", "legs.terrain.touchdowns"},
+		{"[legs.terrain]\nflat = -1.0", "legs.terrain.flat"},
+		{"[legs]\nstep_radii = [250.0, 250.0]", "legs.step_radii"},
+		{"[legs]\nstep_radii = [0.0, 0.0, 500.0, 0.0, 0.0, 0.0]", "legs.step_radii[2]"},
+		{"[legs]\nmin_step_distance = 0.0", "legs.min_step_distance"},
+		{"[legs]\nmax_step_distance = 10.0", "legs.max_step_distance"},
+		{"[legs]\ny_move_speed = nan", "legs.y_move_speed"},
+		{"[legs]\ntorque_limit_fast = 2000", "legs.torque_limit_fast"},
+		{"[legs]\nrest_after = \"-1m\"", "legs.rest_after"},
+		{"[legs]\ntorque_limit_rest = 0", "legs.torque_limit_rest"},
+		{"[legs.stiffness]\npreset = \"squishy\"", "legs.stiffness.preset"},
+		{"[legs.stiffness.soft]\nslope = [64, 64]", "legs.stiffness.soft.slope"},
+		{"[legs.stiffness.stiff]\nmargin = [0, 0, 300, 0]", "legs.stiffness.stiff.margin[2]"},
+		{"[gait]\nmin_ticks_per_step = 0", "gait.min_ticks_per_step"},
+		{"[gait]\nbase_ticks_per_step = 100", "gait.base_ticks_per_step"},
+		{"[gait]\nmin_ticks_per_step = 30", "gait.base_ticks_per_step"},
+		{"[gait]\nbpm = -60.0", "gait.bpm"},
+		{"[gait]\nduty_factor = 0.95", "gait.duty_factor"},
+		{"[safety]\nfull_voltage = 9.0", "safety.full_voltage"},
+		{"[safety]\ncritical_voltage = 10.0", "safety.critical_voltage"},
+		{"[safety]\nvoltage_interval = \"10ms\"", "safety.voltage_interval"},
+		{"[safety]\nshutdown_grace = \"-1s\"", "safety.shutdown_grace"},
+		{"[safety]\nhealth_window = \"10ms\"", "safety.health_window"},
+		{"[safety]\nrestarts = -1", "safety.restarts"},
+		{"[safety]\ncountdown = \"-1s\"", "safety.countdown"},
+		{"[safety.countdowns]\nnavigator = \"-1s\"", "safety.countdowns.navigator"},
+		{"[leds]\ncount = -1", "leds.count"},
+		{"[leds]\nbrightness = 1.5", "leds.brightness"},
+		{"[leds.idle]\nname = \"\"", "leds.idle.name"},
+		{"[leds.stopped]\nperiod = \"-1s\"", "leds.stopped.period"},
+		{"[leds.safe_mode]\nname = \"\"", "leds.safe_mode.name"},
+		{"[leds.countdown]\nperiod = \"-1s\"", "leds.countdown.period"},
+		{"[legs]\ndrift_rate = 2.0", "legs.drift_rate"},
+		{"[navigator]\ntolerance = 10.0", "navigator.tolerance"},
+		{"[navigator]\nmax_home_drift = 0.0", "navigator.max_home_drift"},
+		{"[legs]\nmin_turn_distance = 10.0", "navigator.angle_tolerance"},
+		{"[follow]\nleader = \"Alpha\"", "follow.leader"},
+		{"[follow]\nport = 0", "follow.port"},
+		{"[follow]\nbehind = 100.0", "follow.behind"},
+		{"[follow]\nmax_closing_speed = 0.0", "follow.max_closing_speed"},
+		{"[follow]\ntimeout = \"10ms\"", "follow.timeout"},
+		{"[[navigator.route]]\nturn = 90.0\n[[navigator.route]]\nforward = nan", "navigator.route[1].forward"},
+		{"[head]\nmax_tilt = 120.0", "head.max_tilt"},
+		{"[head]\nmax_pan = 30.0\nneutral_pan = 40.0", "head.neutral_pan"},
+		{"[head]\nshake_period = \"10ms\"", "head.shake_period"},
+		{"[tracker]\nmin_confidence = 1.5", "tracker.min_confidence"},
+		{"[tracker]\nhorizontal_fov = 180.0", "tracker.horizontal_fov"},
+		{"[killswitch]\npin = -2", "killswitch.pin"},
+		{"[killswitch]\ndebounce = \"-1ms\"", "killswitch.debounce"},
+		{"[killswitch]\ndebounce = \"1s\"\nshutdown_after = \"500ms\"", "killswitch.shutdown_after"},
+		{"[controller]\nduck_step = 0", "controller.duck_step"},
+		{"[controller]\ninspect_pitch = 45.0", "controller.inspect_pitch"},
+		{"[controller]\npose_reach = 100.0", "controller.pose_reach"},
+		{"[controller]\npose_pitch = -1.0", "controller.pose_pitch"},
+		{"[controller]\nreverse_speed = 0.0", "controller.reverse_speed"},
+		{"[controller]\nreverse_look_delay = \"-1s\"", "controller.reverse_look_delay"},
+		{"[controller]\nboom_pressure = 1.5", "controller.boom_pressure"},
+		{"[controller]\nboom_speed = 0.0", "controller.boom_speed"},
+		{"[controller]\nboom_blend = \"-1s\"", "controller.boom_blend"},
+		{"[controller]\nturbo_factor = 0.5", "controller.turbo_factor"},
+		{"[controller]\nturbo_duration = \"0s\"", "controller.turbo_duration"},
+		{"[controller]\nturbo_decay = \"-1s\"", "controller.turbo_decay"},
+		{"[controller]\nturbo_cooldown = \"-1s\"", "controller.turbo_cooldown"},
+		{"[controller]\nturbo_backoff = -1.0", "controller.turbo_backoff"},
+		{"[controller]\ncrouch_clearance = -1.0", "controller.crouch_clearance"},
+		{"[controller]\ncrouch_hysteresis = 50.0", "controller.crouch_hysteresis"},
+		{"[controller]\nauto_gait_hysteresis = 50.0", "controller.auto_gait_hysteresis"},
+		{"[controller]\nauto_gait_slow = 5.0", "controller.auto_gait_slow"},
+		{"[controller]\nauto_gait_fast = 45.0", "controller.auto_gait_fast"},
+		{"[gait.crouch]\nstep_radius = 50.0", "gait.crouch.step_radius"},
+		{"[gait.crouch]\nstep_height = 100.0", "gait.crouch.step_height"},
+		{"[gait.crouch]\nticks_scale = 0.5", "gait.crouch.ticks_scale"},
+		{"[gait.crouch]\nmax_step_distance = 5.0", "gait.crouch.max_step_distance"},
+		{"[rangefinder]\ninterval = \"1ms\"", "rangefinder.interval"},
+		{"[rangefinder]\ninterval = \"100ms\"\nstale_after = \"50ms\"", "rangefinder.stale_after"},
+		{"[selftest]\njoint_delta = 4.0\njoint_tolerance = 5.0", "selftest.joint_tolerance"},
+		{"[selftest]\ntorque_limit = 0", "selftest.torque_limit"},
+		{"[righting]\nmax_tilt = 120.0", "righting.max_tilt"},
+		{"[righting]\nsettle = \"2s\"\ntimeout = \"1s\"", "righting.timeout"},
+		{"[righting]\nmax_rate = 0.0", "righting.max_rate"},
+		{"[transport]\nswing = 90.0", "transport.swing"},
+		{"[transport]\nwake_hold = \"100ms\"", "transport.wake_hold"},
+		{"[startup]\nmax_tilt = 90.0", "startup.max_tilt"},
+		{"[startup]\nmax_gravity = 0.5", "startup.max_gravity"},
+		{"[startup]\nmin_loaded_legs = 4", "startup.min_loaded_legs"},
+		{"[endurance]\nwarn_temperature = 100.0", "endurance.warn_temperature"},
+		{"[endurance]\nmin_rest = \"30s\"\nmax_rest = \"20s\"", "endurance.max_rest"},
+		{"[derate]\nnominal_voltage = 13.0", "derate.nominal_voltage"},
+		{"[derate]\nbattery_factor = 0.0", "derate.battery_factor"},
+		{"[derate]\nhot_temperature = 50.0", "derate.hot_temperature"},
+		{"[derate]\nthermal_factor = 1.5", "derate.thermal_factor"},
+		{"[voltage]\nsource = \"servos\"", "voltage.source"},
+		{"[telemetry]\nlength_unit = \"cm\"", "telemetry.length_unit"},
+		{"[telemetry]\nangle_unit = \"degrees\"", "telemetry.angle_unit"},
+		{"[bus]\nslow_transaction = \"-1ms\"", "bus.slow_transaction"},
+		{"[bus]\nworst = 0", "bus.worst"},
+		{"[bus]\nsummary_interval = \"-1s\"", "bus.summary_interval"},
+		{"[sim]\nposition_noise = -1.0", "sim.position_noise"},
+		{"[sim]\ntimeout_rate = 1.5", "sim.timeout_rate"},
+		{"[sim]\nchecksum_rate = -0.1", "sim.checksum_rate"},
+		{"[sim]\nimu_dropout_rate = 2.0", "sim.imu_dropout_rate"},
+		{"[sim]\nsag = 20.0", "sim.sag"},
+		{"[sim]\nstuck = 254", "sim.stuck"},
+		{"[voltage]\nsource = \"adc\"", "voltage.adc"},
+		{"[voltage]\ndivider = 0.0", "voltage.divider"},
+		{"[voltage]\nhysteresis = -0.1", "voltage.hysteresis"},
+		{"[legs]\ndebug_leds = \"swing\"", "legs.debug_leds"},
+		{"[legs]\nfeedback = 25", "legs.feedback"},
+		{"[legs]\nrecentre_distance = -1.0", "legs.recentre_distance"},
+		{"[legs]\nrecentre_after = \"-1s\"", "legs.recentre_after"},
+		{"[legs.budget]\ncurrent = -1.0", "legs.budget.current"},
+		{"[legs.chassis]\nwidth = -10.0", "legs.chassis.width"},
+		{"[legs.chassis]\nfoot_margin = 100.0", "legs.chassis.foot_margin"},
+		{"[legs.budget]\npriority = [\"swing\", \"legs\"]", "legs.budget.priority[1]"},
+		{"[legs.budget]\npriority = [\"head\", \"head\"]", "legs.budget.priority[1]"},
+		{"[legs.budget]\nsteps = 0", "legs.budget.steps"},
+		{"[legs.budget]\nswing_torque = 2000", "legs.budget.swing_torque"},
+		{"[watchdog]\nticks = 1", "watchdog.ticks"},
+		{"[sysmon]\nshed_above = 0.1\nrestore_below = 0.2", "sysmon.restore_below"},
+		{"[[sysmon.shed]]\nparam = \"\"", "sysmon.shed[0].param"},
+		{"[[sysmon.shed]]\nparam = \"telemetry.rate\"\nvalue = nan", "sysmon.shed[0].value"},
+		{"[power]\nsmoothing = \"-1s\"", "power.smoothing"},
+		{"[power]\ngain = 0.0", "power.gain"},
+		{"[power]\nmodels = []", "power.models"},
+		{"[[power.models]]\nmodel = 12\n[[power.models]]\nmodel = 12", "power.models[1].model"},
+		{"[[power.models]]\nmodel = 12\nstall = 20.0", "power.models[0].stall"},
+		{"[[profiles]]\nname = \"\"", "profiles[0].name"},
+		{"[[profiles]]\nname = \"a\"\n[[profiles]]\nname = \"a\"", "profiles[1].name"},
+		{"[[profiles]]\nname = \"a\"\n[profiles.params]\n\"legs.step_height\" = inf", "profiles.a.legs.step_height"},
+		{"profile = \"outdoor\"", "profile"},
+	}
+
+	for _, x := range examples {
+		_, err := Parse(x.toml)
+		if assert.IsType(t, &FieldError{}, err, x.toml) {
+			assert.Equal(t, x.key, err.(*FieldError).Key, x.toml)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+
+	// Typos are errors, rather than silently ignored.
+	_, err := Parse("[controller]\nmove_sped = 100.0\n[legs]\nstep = 1")
+	assert.EqualError(t, err, "unknown keys: controller.move_sped, legs.step")
+
+	_, err = Parse("[controller]\nmove_speed = \"fast\"")
+	assert.Error(t, err)
+
+	_, err = Parse("[safety]\nvoltage_interval = \"soon\"")
+	assert.Error(t, err)
+
+	_, err = Parse("[leds.idle]\ncolor = \"green\"")
+	assert.Error(t, err)
+
+	_, err = Parse("[controller")
+	assert.Error(t, err)
+}
+
+func TestFlatten(t *testing.T) {
+	f := Default().Flatten()
+	assert.Equal(t, 100.0, f["controller.move_speed"])
+	assert.Equal(t, 20.0, f["gait.base_ticks_per_step"])
+	assert.Equal(t, Duration{15 * time.Second}, f["safety.voltage_interval"])
+	assert.Equal(t, Color{0, 255, 0}, f["leds.idle.color"])
+	assert.Equal(t, "", f["profile"])
+	assert.Contains(t, f, "profiles")
+	assert.NotContains(t, f, "controller")
+}
+
+func TestDiff(t *testing.T) {
+	a := Default()
+	assert.Empty(t, Diff(a.Flatten(), a.Flatten()))
+
+	b := Default()
+	b.Legs.StepHeight = 60
+	b.Controller.MoveSpeed = 150
+	b.Safety.ShutdownGrace = Duration{time.Second}
+	b.Profiles = []Profile{{Name: "indoor"}}
+	assert.Equal(t, []string{"controller.move_speed", "legs.step_height", "profiles", "safety.shutdown_grace"}, Diff(a.Flatten(), b.Flatten()))
+	assert.Equal(t, Diff(a.Flatten(), b.Flatten()), Diff(b.Flatten(), a.Flatten()))
+}
+
+func TestSafe(t *testing.T) {
+	for _, path := range []string{"", "testdata/full.toml"} {
+		c := Default()
+		if path != "" {
+			var err error
+			c, err = Load(path)
+			assert.NoError(t, err)
+		}
+
+		// Nothing is restricted until it's applied.
+		assert.False(t, c.SafeMode)
+		assert.Nil(t, c.SafeOverrides())
+		assert.Greater(t, c.Legs.TorqueLimitFast, SafeTorqueLimit)
+		assert.Greater(t, c.Controller.TurboFactor, 1.0)
+		assert.Greater(t, c.Controller.CrouchClearance, 0.0)
+
+		s := c.Safe()
+		assert.NoError(t, s.Validate(), path)
+		assert.True(t, s.SafeMode)
+
+		assert.LessOrEqual(t, s.Legs.TorqueLimitSlow, SafeTorqueLimit)
+		assert.LessOrEqual(t, s.Legs.TorqueLimitFast, SafeTorqueLimit)
+		assert.LessOrEqual(t, s.Legs.TorqueLimitRest, SafeTorqueLimit)
+		assert.LessOrEqual(t, s.Legs.Budget.SwingTorque, SafeTorqueLimit)
+		assert.LessOrEqual(t, s.SelfTest.TorqueLimit, SafeTorqueLimit)
+		assert.LessOrEqual(t, s.Righting.TorqueLimit, SafeTorqueLimit)
+		assert.LessOrEqual(t, s.Transport.TorqueLimit, SafeTorqueLimit)
+
+		// Limits which were already lower are kept.
+		assert.Equal(t, min(c.Legs.TorqueLimitRest, SafeTorqueLimit), s.Legs.TorqueLimitRest)
+
+		cc := s.Controller
+		assert.GreaterOrEqual(t, cc.MinClearance, float64(SafeMinClearance))
+		assert.LessOrEqual(t, cc.MaxClearance, float64(SafeMaxClearance))
+		assert.True(t, cc.Clearance >= cc.MinClearance && cc.Clearance <= cc.MaxClearance, "clearance=%v", cc.Clearance)
+		assert.Equal(t, 1.0, cc.TurboFactor)
+		assert.Equal(t, 0.0, cc.CrouchClearance)
+
+		assert.Equal(t, "", s.Profile)
+		assert.Empty(t, s.Profiles)
+
+		// The components which would relax it are disabled.
+		assert.Equal(t, map[string]bool{
+			"navigator": false,
+			"profiles":  false,
+			"settings":  false,
+			"reload":    false,
+			"safemode":  true,
+		}, s.SafeOverrides())
+
+		// The original is untouched.
+		assert.False(t, c.SafeMode)
+		assert.Greater(t, c.Controller.TurboFactor, 1.0)
+	}
+}
+
+func TestSafeClearance(t *testing.T) {
+
+	// A range which doesn't overlap the safe one is replaced by it.
+	c := Default()
+	c.Controller.MinClearance = 60
+	c.Controller.MaxClearance = 100
+	c.Controller.Clearance = 80
+
+	cc := c.Safe().Controller
+	assert.Equal(t, float64(SafeMinClearance), cc.MinClearance)
+	assert.Equal(t, float64(SafeMaxClearance), cc.MaxClearance)
+	assert.Equal(t, float64(SafeMaxClearance), cc.Clearance)
+}
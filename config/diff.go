@@ -0,0 +1,61 @@
+package config
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Flatten returns every value in the config, keyed by its full key (e.g.
+// controller.move_speed), which is also the name of the param if it can be
+// changed at runtime. Numbers are returned as float64s, like params. Other
+// values (durations, profiles) are returned as they are.
+func (c Config) Flatten() map[string]interface{} {
+	out := map[string]interface{}{}
+	flatten(reflect.ValueOf(c), "", out)
+	return out
+}
+
+var (
+	durationType = reflect.TypeOf(Duration{})
+	colorType    = reflect.TypeOf(Color{})
+)
+
+func flatten(v reflect.Value, prefix string, out map[string]interface{}) {
+	t := v.Type()
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		key := strings.Split(f.Tag.Get("toml"), ",")[0]
+		if key == "" || key == "-" {
+			continue
+		}
+		key = prefix + key
+
+		fv := v.Field(i)
+		switch {
+		case fv.Kind() == reflect.Struct && fv.Type() != durationType && fv.Type() != colorType:
+			flatten(fv, key+".", out)
+		case fv.Kind() == reflect.Float64:
+			out[key] = fv.Float()
+		case fv.Kind() == reflect.Int:
+			out[key] = float64(fv.Int())
+		default:
+			out[key] = fv.Interface()
+		}
+	}
+}
+
+// Diff returns the keys whose values differ between the two flattened configs
+// (see Flatten), sorted.
+func Diff(a, b map[string]interface{}) []string {
+	var keys []string
+	for k, va := range a {
+		if !reflect.DeepEqual(va, b[k]) {
+			keys = append(keys, k)
+		}
+	}
+
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,61 @@
+package config
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// Profile is a named set of overrides, which can be switched to at runtime
+// (e.g. a cautious "indoor" profile, and a faster "outdoor" one). Rather than
+// mirroring the config sections, the overrides are keyed by param name, since
+// they're applied via the params registry like any other runtime change:
+//
+//	[[profiles]]
+//	name = "indoor"
+//
+//	[profiles.params]
+//	"controller.clearance" = 30.0
+//	"legs.step_height" = 25.0
+//	"hexapod.speed" = -4.0
+//
+// The params are only checked against the registry at boot, since they're
+// registered by the components which own them.
+type Profile struct {
+	Name   string             `toml:"name"`
+	Params map[string]float64 `toml:"params"`
+}
+
+func (c Config) validateProfiles() error {
+	seen := map[string]bool{}
+
+	for i, p := range c.Profiles {
+		if p.Name == "" {
+			return &FieldError{fmt.Sprintf("profiles[%d].name", i), "must not be empty"}
+		}
+
+		if seen[p.Name] {
+			return &FieldError{fmt.Sprintf("profiles[%d].name", i), fmt.Sprintf("duplicate profile: %s", p.Name)}
+		}
+		seen[p.Name] = true
+
+		// Sort the names, so that errors are deterministic.
+		keys := make([]string, 0, len(p.Params))
+		for k := range p.Params {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			if v := p.Params[k]; math.IsNaN(v) || math.IsInf(v, 0) {
+				return &FieldError{fmt.Sprintf("profiles.%s.%s", p.Name, k), fmt.Sprintf("must be a number, but is %v", v)}
+			}
+		}
+	}
+
+	if c.Profile != "" && !seen[c.Profile] {
+		return &FieldError{"profile", fmt.Sprintf("no such profile: %s", c.Profile)}
+	}
+
+	return nil
+}
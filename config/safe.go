@@ -0,0 +1,82 @@
+package config
+
+import (
+	"math"
+)
+
+// The limits of safe mode. See Config.Safe.
+const (
+
+	// The highest torque limit (out of 1023) which any servo is set to, which
+	// is 40% of the max, so a misconfigured leg can't strip its gears.
+	SafeTorqueLimit = 409
+
+	// The range which the clearance can be set within. The body isn't lowered
+	// far enough to drag, nor raised to where the legs are near full reach.
+	SafeMinClearance = 30
+	SafeMaxClearance = 50
+)
+
+// SafeComponents are the components which are disabled (or enabled) in safe
+// mode, overriding both the config and the flags. The navigator walks routes,
+// and the profiles, settings, and reloader all write params (or config) which
+// would override the restrictions.
+var SafeComponents = map[string]bool{
+	"navigator": false,
+	"profiles":  false,
+	"settings":  false,
+	"reload":    false,
+	"safemode":  true,
+}
+
+// Safe returns a copy of the config with the restrictions of safe mode, which is
+// for the first boot on a freshly built frame, when a servo might be wired or
+// configured wrong. It's applied after everything else (the file, and then the
+// flags), so nothing can relax it:
+//
+//   - the torque limit of every leg is at most the safe torque limit
+//   - the clearance stays within the safe range
+//   - the turbo, the crouch gait, and the inspection pose are disabled
+//   - no profile is active, and none can be switched to
+//
+// The safemode component (see SafeComponents) does the rest while running:
+// it holds the speed at the minimum, forces the wave gait, and halts the hex
+// until it has been armed.
+func (c Config) Safe() Config {
+	c.SafeMode = true
+
+	l := &c.Legs
+	l.TorqueLimitSlow = min(l.TorqueLimitSlow, SafeTorqueLimit)
+	l.TorqueLimitFast = min(l.TorqueLimitFast, SafeTorqueLimit)
+	l.TorqueLimitRest = min(l.TorqueLimitRest, SafeTorqueLimit)
+	l.Budget.SwingTorque = min(l.Budget.SwingTorque, SafeTorqueLimit)
+	c.SelfTest.TorqueLimit = min(c.SelfTest.TorqueLimit, SafeTorqueLimit)
+	c.Righting.TorqueLimit = min(c.Righting.TorqueLimit, SafeTorqueLimit)
+	c.Transport.TorqueLimit = min(c.Transport.TorqueLimit, SafeTorqueLimit)
+
+	cc := &c.Controller
+	cc.MinClearance = math.Max(cc.MinClearance, SafeMinClearance)
+	cc.MaxClearance = math.Min(cc.MaxClearance, SafeMaxClearance)
+	if cc.MinClearance > cc.MaxClearance {
+		cc.MinClearance, cc.MaxClearance = SafeMinClearance, SafeMaxClearance
+	}
+	cc.Clearance = math.Min(math.Max(cc.Clearance, cc.MinClearance), cc.MaxClearance)
+	cc.TurboFactor = 1
+	cc.CrouchClearance = 0
+
+	c.Profile = ""
+	c.Profiles = nil
+
+	return c
+}
+
+// SafeOverrides returns the component overrides of safe mode (see
+// SafeComponents), which go after every other override, or nil if the config
+// isn't in safe mode.
+func (c Config) SafeOverrides() map[string]bool {
+	if !c.SafeMode {
+		return nil
+	}
+
+	return SafeComponents
+}
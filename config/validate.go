@@ -0,0 +1,641 @@
+package config
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/adammck/hexapod/units"
+)
+
+// FieldError is returned by Validate when a value is out of range.
+type FieldError struct {
+
+	// The full key of the field, e.g. controller.move_speed.
+	Key string
+	Msg string
+}
+
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Key, e.Msg)
+}
+
+// Validate returns an error naming the first invalid field, or nil if they're
+// all okay. The ranges are the same as the params registry allows for the
+// ones which can be changed at runtime.
+func (c Config) Validate() error {
+	cc, l, g, s, leds, n, h, tr, k, rf, st, w, sm, p, e, v := c.Controller, c.Legs, c.Gait, c.Safety, c.LEDs, c.Navigator, c.Head, c.Tracker, c.KillSwitch, c.Rangefinder, c.SelfTest, c.Watchdog, c.Sysmon, c.Power, c.Endurance, c.Voltage
+
+	for _, err := range []error{
+		c.Identity.validate(),
+
+		between("controller.move_speed", cc.MoveSpeed, 0, 200),
+		between("controller.rot_speed", cc.RotSpeed, 0, 45),
+		between("controller.deadzone", cc.Deadzone, 0, 0.5),
+		between("controller.expo", cc.Expo, 0, 1),
+		cc.validateRotation(),
+		cc.validateTuning(),
+		between("controller.clearance", cc.Clearance, 0, 120),
+		between("controller.min_clearance", cc.MinClearance, 0, 120),
+		between("controller.max_clearance", cc.MaxClearance, cc.MinClearance, 120),
+		between("controller.clearance_step", cc.ClearanceStep, 1, 40),
+		between("controller.horizontal_look_scale", cc.HorizontalLookScale, 0, 1000),
+		between("controller.vertical_look_scale", cc.VerticalLookScale, 0, 1000),
+		between("controller.walking_look_scale", cc.WalkingLookScale, 0, 1),
+		between("controller.walking_look_curve", cc.WalkingLookCurve, 0.1, 10),
+		between("controller.bank_scale", cc.BankScale, 0, 45),
+		between("controller.pitch_scale", cc.PitchScale, 0, 45),
+		between("controller.x_offset_scale", cc.XOffsetScale, 0, 100),
+		between("controller.z_offset_scale", cc.ZOffsetScale, 0, 100),
+		between("controller.duck_distance", cc.DuckDistance, 0, 5000),
+		between("controller.duck_step", cc.DuckStep, 1, 40),
+		duration("controller.duck_interval", cc.DuckInterval.Duration, 0),
+		duration("controller.duck_restore", cc.DuckRestore.Duration, 0),
+		between("controller.inspect_pitch", cc.InspectPitch, -30, 30),
+		between("controller.inspect_distance", cc.InspectDistance, 0, 2000),
+		duration("controller.inspect_ramp", cc.InspectRamp.Duration, 0),
+		between("controller.pose_speed", cc.PoseSpeed, 0, 50),
+		between("controller.pose_reach", cc.PoseReach, 0, 60),
+		between("controller.pose_turn_speed", cc.PoseTurnSpeed, 0, 20),
+		between("controller.pose_pitch", cc.PosePitch, 0, 15),
+		between("controller.pose_bank", cc.PoseBank, 0, 15),
+		between("controller.pose_yaw", cc.PoseYaw, 0, 20),
+		duration("controller.pose_smoothing", cc.PoseSmoothing.Duration, 0),
+		between("controller.reverse_speed", cc.ReverseSpeed, 0.1, 1),
+		duration("controller.reverse_look_delay", cc.ReverseLookDelay.Duration, 0),
+		between("controller.reverse_focal_horizontal_offset", cc.ReverseFocalHorizontalOffset, -1000, 1000),
+		between("controller.reverse_focal_vertical_offset", cc.ReverseFocalVerticalOffset, -1000, 1000),
+		between("controller.reverse_focal_distance", cc.ReverseFocalDistance, -1000, 1000),
+		between("controller.boom_pressure", cc.BoomPressure, 0, 1),
+		between("controller.boom_speed", cc.BoomSpeed, 1, 1000),
+		duration("controller.boom_blend", cc.BoomBlend.Duration, 0),
+		between("controller.turbo_factor", cc.TurboFactor, 1, 3),
+		duration("controller.turbo_duration", cc.TurboDuration.Duration, time.Millisecond),
+		duration("controller.turbo_decay", cc.TurboDecay.Duration, 0),
+		duration("controller.turbo_cooldown", cc.TurboCooldown.Duration, 0),
+		between("controller.turbo_backoff", cc.TurboBackoff, 0, 10),
+		between("controller.crouch_clearance", cc.CrouchClearance, 0, 120),
+		between("controller.crouch_hysteresis", cc.CrouchHysteresis, 0, 40),
+		between("controller.auto_gait_hysteresis", cc.AutoGaitHysteresis, 0, 40),
+		between("controller.auto_gait_slow", cc.AutoGaitSlow, cc.AutoGaitHysteresis, 200),
+		between("controller.auto_gait_fast", cc.AutoGaitFast, cc.AutoGaitSlow+cc.AutoGaitHysteresis, 200),
+
+		between("legs.step_radius", l.StepRadius, 100, 400),
+		l.validateStepRadii(),
+		between("legs.step_height", l.StepHeight, 0, 80),
+		positive("legs.min_step_distance", l.MinStepDistance),
+		between("legs.max_step_distance", l.MaxStepDistance, l.MinStepDistance, 200),
+		positive("legs.min_turn_distance", l.MinTurnDistance),
+		positive("legs.y_move_speed", l.YMoveSpeed),
+		positive("legs.bank_move_speed", l.BankMoveSpeed),
+		positive("legs.pitch_move_speed", l.PitchMoveSpeed),
+		between("legs.move_speed_slow", float64(l.MoveSpeedSlow), 1, 1023),
+		between("legs.torque_limit_slow", float64(l.TorqueLimitSlow), 1, 1023),
+		between("legs.move_speed_fast", float64(l.MoveSpeedFast), 1, 1023),
+		between("legs.torque_limit_fast", float64(l.TorqueLimitFast), 1, 1023),
+		duration("legs.rest_after", l.RestAfter.Duration, 0),
+		between("legs.rest_drop", l.RestDrop, 0, 40),
+		between("legs.torque_limit_rest", float64(l.TorqueLimitRest), 1, 1023),
+		between("legs.drift_rate", l.DriftRate, 0, 1),
+		between("legs.slip_drift", l.SlipDrift, 0, 100),
+		l.validateDebugLEDs(),
+		between("legs.feedback", float64(l.Feedback), 0, 24),
+		between("legs.recentre_distance", l.RecentreDistance, 0, 200),
+		duration("legs.recentre_after", l.RecentreAfter.Duration, 0),
+		between("legs.budget.current", l.Budget.Current, 0, 30),
+		between("legs.budget.hysteresis", l.Budget.Hysteresis, 0, 10),
+		duration("legs.budget.interval", l.Budget.Interval.Duration, 0),
+		l.Budget.validatePriority(),
+		between("legs.budget.steps", float64(l.Budget.Steps), 1, 20),
+		between("legs.budget.swing_torque", float64(l.Budget.SwingTorque), 0, 1023),
+		between("legs.budget.head_torque", float64(l.Budget.HeadTorque), 0, 1023),
+		between("legs.budget.slow_ticks", float64(l.Budget.SlowTicks), 0, 100),
+		l.Stiffness.validate(),
+		between("legs.chassis.width", l.Chassis.Width, 0, 400),
+		between("legs.chassis.length", l.Chassis.Length, 0, 500),
+		between("legs.chassis.height", l.Chassis.Height, 0, 200),
+		between("legs.chassis.foot_margin", l.Chassis.FootMargin, 0, 50),
+		l.Chassis.validateCenterOfMass(),
+		between("legs.lean.full_margin", l.Lean.FullMargin, 1, 500),
+		between("legs.lean.min_margin", l.Lean.MinMargin, 0, l.Lean.FullMargin-1),
+		duration("legs.lean.smoothing", l.Lean.Smoothing.Duration, 0),
+		between("legs.terrain.touchdowns", float64(l.Terrain.Touchdowns), 1, 20),
+		between("legs.terrain.flat", l.Terrain.Flat, 0, 50),
+		duration("legs.terrain.decay", l.Terrain.Decay.Duration, 0),
+
+		between("gait.min_ticks_per_step", float64(g.MinTicksPerStep), 1, 1000),
+		between("gait.max_ticks_per_step", float64(g.MaxTicksPerStep), float64(g.MinTicksPerStep), 1000),
+		between("gait.base_ticks_per_step", float64(g.BaseTicksPerStep), float64(g.MinTicksPerStep), float64(g.MaxTicksPerStep)),
+		between("gait.bpm", g.BPM, 0, 300),
+		between("gait.duty_factor", g.DutyFactor, 0, 0.9),
+		between("gait.crouch.step_radius", g.Crouch.StepRadius, 100, 400),
+		between("gait.crouch.step_height", g.Crouch.StepHeight, 0, 80),
+		between("gait.crouch.ticks_scale", g.Crouch.TicksScale, 1, 4),
+		between("gait.crouch.max_step_distance", g.Crouch.MaxStepDistance, l.MinStepDistance, 200),
+
+		between("safety.min_voltage", s.MinVoltage, 6, 20),
+		between("safety.full_voltage", s.FullVoltage, s.MinVoltage, 20),
+		between("safety.critical_voltage", s.CriticalVoltage, 6, s.MinVoltage),
+		duration("safety.voltage_interval", s.VoltageInterval.Duration, time.Second),
+		duration("safety.shutdown_grace", s.ShutdownGrace.Duration, 0),
+		duration("safety.health_window", s.HealthWindow.Duration, 100*time.Millisecond),
+		between("safety.restarts", float64(s.Restarts), 0, 100),
+		duration("safety.countdown", s.Countdown.Duration, 0),
+		s.validateCountdowns(),
+
+		between("leds.count", float64(leds.Count), 0, 1000),
+		between("leds.brightness", leds.Brightness, 0, 1),
+		between("leds.fallen_angle", leds.FallenAngle, 1, 180),
+		leds.Idle.validate("leds.idle"),
+		leds.Walking.validate("leds.walking"),
+		leds.Battery.validate("leds.battery"),
+		leds.Stopped.validate("leds.stopped"),
+		leds.Cooling.validate("leds.cooling"),
+		leds.SelfTest.validate("leds.selftest"),
+		leds.Shutdown.validate("leds.shutdown"),
+		leds.Countdown.validate("leds.countdown"),
+		leds.SafeMode.validate("leds.safe_mode"),
+
+		between("navigator.tolerance", n.Tolerance, l.MinStepDistance, 200),
+		between("navigator.angle_tolerance", n.AngleTolerance, l.MinTurnDistance, 45),
+		between("navigator.max_home_distance", n.MaxHomeDistance, 0, 100000),
+		positive("navigator.max_home_drift", n.MaxHomeDrift),
+		n.validateRoute(),
+
+		c.Follow.validateLeader(),
+		between("follow.port", float64(c.Follow.Port), 1, 65535),
+		duration("follow.interval", c.Follow.Interval.Duration, 10*time.Millisecond),
+		between("follow.behind", c.Follow.Behind, -5000, 5000),
+		between("follow.right", c.Follow.Right, -5000, 5000),
+		c.Follow.validateSlot(),
+		between("follow.max_closing_speed", c.Follow.MaxClosingSpeed, 1, 1000),
+		duration("follow.timeout", c.Follow.Timeout.Duration, c.Follow.Interval.Duration),
+
+		h.validateGeometry(),
+		between("head.min_pan", h.MinPan, -180, 0),
+		between("head.max_pan", h.MaxPan, 0, 180),
+		between("head.min_tilt", h.MinTilt, -90, 0),
+		between("head.max_tilt", h.MaxTilt, 0, 90),
+		between("head.max_speed", h.MaxSpeed, 1, 1000),
+		between("head.neutral_pan", h.NeutralPan, h.MinPan, h.MaxPan),
+		between("head.neutral_tilt", h.NeutralTilt, h.MinTilt, h.MaxTilt),
+		between("head.nod_amplitude", h.NodAmplitude, 0, 45),
+		duration("head.nod_period", h.NodPeriod.Duration, 50*time.Millisecond),
+		between("head.shake_amplitude", h.ShakeAmplitude, 0, 45),
+		duration("head.shake_period", h.ShakePeriod.Duration, 50*time.Millisecond),
+		duration("head.gesture_blend", h.GestureBlend.Duration, 0),
+
+		between("tracker.min_confidence", tr.MinConfidence, 0, 1),
+		duration("tracker.stale_after", tr.StaleAfter.Duration, 50*time.Millisecond),
+		duration("tracker.smoothing", tr.Smoothing.Duration, 0),
+		between("tracker.horizontal_fov", tr.HorizontalFOV, 1, 170),
+		between("tracker.vertical_fov", tr.VerticalFOV, 1, 170),
+		between("tracker.distance", tr.Distance, 100, 10000),
+
+		between("killswitch.pin", float64(k.Pin), -1, 1023),
+		duration("killswitch.debounce", k.Debounce.Duration, 0),
+		duration("killswitch.shutdown_after", k.ShutdownAfter.Duration, k.Debounce.Duration),
+
+		duration("rangefinder.interval", rf.Interval.Duration, 10*time.Millisecond),
+		between("rangefinder.max_range", rf.MaxRange, 1, 10000),
+		duration("rangefinder.stale_after", rf.StaleAfter.Duration, rf.Interval.Duration),
+
+		between("selftest.joint_delta", st.JointDelta, 1, 20),
+		between("selftest.joint_tolerance", st.JointTolerance, 0.5, st.JointDelta),
+		duration("selftest.settle", st.Settle.Duration, 50*time.Millisecond),
+		between("selftest.torque_limit", float64(st.TorqueLimit), 1, 1023),
+		between("selftest.move_speed", float64(st.MoveSpeed), 1, 1023),
+		duration("selftest.link_timeout", st.LinkTimeout.Duration, 100*time.Millisecond),
+
+		between("righting.max_tilt", c.Righting.MaxTilt, 5, 90),
+		duration("righting.settle", c.Righting.Settle.Duration, 100*time.Millisecond),
+		duration("righting.timeout", c.Righting.Timeout.Duration, c.Righting.Settle.Duration),
+		between("righting.max_rate", c.Righting.MaxRate, 0.5, 20),
+		between("righting.torque_limit", float64(c.Righting.TorqueLimit), 1, 1023),
+		between("righting.move_speed", float64(c.Righting.MoveSpeed), 1, 1023),
+
+		between("startup.max_tilt", c.Startup.MaxTilt, 5, 85),
+		between("startup.min_gravity", c.Startup.MinGravity, 0, 1),
+		between("startup.max_gravity", c.Startup.MaxGravity, 1, 3),
+		duration("startup.settle", c.Startup.Settle.Duration, 0),
+		between("startup.min_load", c.Startup.MinLoad, 0, 1),
+		between("startup.min_loaded_legs", float64(c.Startup.MinLoadedLegs), 0, 3),
+
+		between("transport.max_rate", c.Transport.MaxRate, 0.5, 20),
+		between("transport.torque_limit", float64(c.Transport.TorqueLimit), 1, 1023),
+		between("transport.move_speed", float64(c.Transport.MoveSpeed), 1, 1023),
+		between("transport.swing", c.Transport.Swing, 0, 60),
+		duration("transport.wake_hold", c.Transport.WakeHold.Duration, 500*time.Millisecond),
+
+		w.validate(),
+
+		between("sysmon.shed_above", sm.ShedAbove, 0, 1),
+		between("sysmon.restore_below", sm.RestoreBelow, 0, sm.ShedAbove),
+		duration("sysmon.settle", sm.Settle.Duration, 0),
+		sm.validateShed(),
+
+		duration("power.smoothing", p.Smoothing.Duration, 0),
+		between("power.base", p.Base, 0, 5),
+		between("power.gain", p.Gain, 0.1, 10),
+		p.validateModels(),
+
+		between("endurance.warn_temperature", e.WarnTemperature, 30, 85),
+		duration("endurance.horizon", e.Horizon.Duration, time.Minute),
+		duration("endurance.window", e.Window.Duration, 30*time.Second),
+		duration("endurance.walk", e.Walk.Duration, 10*time.Second),
+		duration("endurance.min_rest", e.MinRest.Duration, time.Second),
+		duration("endurance.max_rest", e.MaxRest.Duration, e.MinRest.Duration),
+
+		between("derate.nominal_voltage", c.Derate.NominalVoltage, s.MinVoltage, s.FullVoltage),
+		between("derate.battery_factor", c.Derate.BatteryFactor, 0.1, 1),
+		between("derate.battery_curve", c.Derate.BatteryCurve, 0.1, 10),
+		between("derate.warm_temperature", c.Derate.WarmTemperature, 20, 85),
+		between("derate.hot_temperature", c.Derate.HotTemperature, c.Derate.WarmTemperature, 100),
+		between("derate.thermal_factor", c.Derate.ThermalFactor, 0.1, 1),
+		between("derate.thermal_curve", c.Derate.ThermalCurve, 0.1, 10),
+
+		v.validateSource(),
+		positive("voltage.divider", v.Divider),
+		duration("voltage.window", v.Window.Duration, 0),
+		between("voltage.hysteresis", v.Hysteresis, 0, 2),
+
+		c.Telemetry.validateUnits(),
+
+		duration("bus.slow_transaction", c.Bus.SlowTransaction.Duration, 0),
+		between("bus.worst", float64(c.Bus.Worst), 1, 100),
+		duration("bus.summary_interval", c.Bus.SummaryInterval.Duration, 0),
+
+		between("sim.position_noise", c.Sim.PositionNoise, 0, 100),
+		between("sim.position_bias", c.Sim.PositionBias, 0, 100),
+		between("sim.timeout_rate", c.Sim.TimeoutRate, 0, 1),
+		between("sim.checksum_rate", c.Sim.ChecksumRate, 0, 1),
+		between("sim.imu_drift", c.Sim.IMUDrift, 0, 90),
+		between("sim.imu_dropout_rate", c.Sim.IMUDropoutRate, 0, 1),
+		between("sim.sag", c.Sim.Sag, 0, 12),
+		between("sim.stuck", float64(c.Sim.Stuck), 0, 253),
+
+		c.validateProfiles(),
+	} {
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// validate checks the pattern's period. The name is checked by the leds
+// component, which knows which patterns exist.
+func (p Pattern) validate(key string) error {
+	if p.Name == "" {
+		return &FieldError{key + ".name", "must not be empty"}
+	}
+
+	return duration(key+".period", p.Period.Duration, 0)
+}
+
+// validate checks the number of ticks, which can be zero (to disable the
+// watchdog) but is otherwise at least two, since a tick can be late without
+// the loop having stalled.
+func (w Watchdog) validate() error {
+	if w.Ticks == 0 {
+		return nil
+	}
+
+	return between("watchdog.ticks", float64(w.Ticks), 2, 1000)
+}
+
+func (sm Sysmon) validateShed() error {
+	for i, s := range sm.Shed {
+		if s.Param == "" {
+			return &FieldError{fmt.Sprintf("sysmon.shed[%d].param", i), "must not be empty"}
+		}
+
+		if math.IsNaN(s.Value) || math.IsInf(s.Value, 0) {
+			return &FieldError{fmt.Sprintf("sysmon.shed[%d].value", i), fmt.Sprintf("must be a number, but is %v", s.Value)}
+		}
+	}
+
+	return nil
+}
+
+// validate checks that the ID (if it's set) is safe to use in MQTT topics and
+// file names, which is why it exists separately from the name.
+func (i Identity) validate() error {
+	for _, r := range i.ID {
+		if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-') {
+			return &FieldError{"identity.id", fmt.Sprintf("must only contain lowercase letters, digits, and dashes, but is %q", i.ID)}
+		}
+	}
+
+	return nil
+}
+
+// validateLeader checks that the leader looks like an ID, like identity.id.
+func (f Follow) validateLeader() error {
+	for _, r := range f.Leader {
+		if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-') {
+			return &FieldError{"follow.leader", fmt.Sprintf("must only contain lowercase letters, digits, and dashes, but is %q", f.Leader)}
+		}
+	}
+
+	return nil
+}
+
+// The closest (in mm) that the slot can be to the leader, so the follower
+// doesn't walk into it.
+const minFollowDistance = 250
+
+// validateSlot checks that the slot isn't on top of the leader.
+func (f Follow) validateSlot() error {
+	if d := math.Hypot(f.Behind, f.Right); d < minFollowDistance {
+		return &FieldError{"follow.behind", fmt.Sprintf("must be at least %s from the leader (with follow.right), but is %s", units.MM(minFollowDistance), units.MM(d))}
+	}
+
+	return nil
+}
+
+// validateTuning checks that each param in the tuning shortlist is named, and
+// only listed once. Whether it exists isn't known until the components which
+// register them have booted.
+func (cc Controller) validateTuning() error {
+	seen := map[string]bool{}
+	for i, name := range cc.Tuning {
+		key := fmt.Sprintf("controller.tuning[%d]", i)
+		if name == "" {
+			return &FieldError{key, "must not be empty"}
+		}
+
+		if seen[name] {
+			return &FieldError{key, fmt.Sprintf("duplicate param: %s", name)}
+		}
+		seen[name] = true
+	}
+
+	return nil
+}
+
+// validateCenterOfMass checks that the center of mass is somewhere near the
+// chassis, rather than e.g. in meters.
+func (c Chassis) validateCenterOfMass() error {
+	for i, v := range c.CenterOfMass {
+		err := between(fmt.Sprintf("legs.chassis.center_of_mass[%d]", i), v, -200, 200)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// validateRotation checks that the rotation is one of those which the
+// controller knows.
+func (cc Controller) validateRotation() error {
+	switch cc.Rotation {
+	case "triggers", "stick":
+		return nil
+	}
+
+	return &FieldError{"controller.rotation", fmt.Sprintf("must be triggers or stick, but is %q", cc.Rotation)}
+}
+
+// The furthest (in mm) that the middle of the lens can be from the head mount,
+// since the camera is on the head.
+const maxLensOffset = 100
+
+// validateGeometry checks that the mount and the lens are within the chassis,
+// that the lens is on the head (rather than somewhere it couldn't be turned
+// by it), and that the focal point is in front of the lens.
+func (h Head) validateGeometry() error {
+	for _, v := range []struct {
+		key string
+		v   [3]float64
+	}{
+		{"head.mount", h.Mount},
+		{"head.lens", h.Lens},
+	} {
+		for i, c := range v.v {
+			err := between(fmt.Sprintf("%s[%d]", v.key, i), c, -500, 500)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	var sq float64
+	for i := range h.Lens {
+		d := h.Lens[i] - h.Mount[i]
+		sq += d * d
+	}
+	if d := math.Sqrt(sq); d > maxLensOffset {
+		return &FieldError{"head.lens", fmt.Sprintf("must be within %dmm of head.mount, since the camera is on the head, but is %.1fmm away", maxLensOffset, d)}
+	}
+
+	return between("head.focal_distance", h.FocalDistance, h.Lens[2]+1, 10000)
+}
+
+// validateUnits checks that the units are ones which the snapshots can be
+// converted to.
+func (t Telemetry) validateUnits() error {
+	if !units.IsLength(t.LengthUnit) {
+		return &FieldError{"telemetry.length_unit", fmt.Sprintf("must be mm or m, but is %q", t.LengthUnit)}
+	}
+
+	if !units.IsAngle(t.AngleUnit) {
+		return &FieldError{"telemetry.angle_unit", fmt.Sprintf("must be deg or rad, but is %q", t.AngleUnit)}
+	}
+
+	return nil
+}
+
+// validateSource checks that the source is one of those which the voltage
+// component knows, and that there's an ADC to read if it's that.
+func (v Voltage) validateSource() error {
+	switch v.Source {
+	case "servo", "min", "max":
+		return nil
+	case "adc":
+		if v.ADC == "" {
+			return &FieldError{"voltage.adc", "must be set if the source is adc"}
+		}
+		return nil
+	}
+
+	return &FieldError{"voltage.source", fmt.Sprintf("must be servo, min, max, or adc, but is %q", v.Source)}
+}
+
+// validateStepRadii checks that there's one step radius per leg, if any, and
+// that each is either zero or in the same range as the step radius.
+func (l Legs) validateStepRadii() error {
+	if len(l.StepRadii) == 0 {
+		return nil
+	}
+
+	if len(l.StepRadii) != 6 {
+		return &FieldError{"legs.step_radii", fmt.Sprintf("must have one per leg (6), but has %d", len(l.StepRadii))}
+	}
+
+	for i, r := range l.StepRadii {
+		if r == 0 {
+			continue
+		}
+
+		err := between(fmt.Sprintf("legs.step_radii[%d]", i), r, 100, 400)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// validateDebugLEDs checks that the LED mode is one which the legs know.
+func (l Legs) validateDebugLEDs() error {
+	switch l.DebugLEDs {
+	case "", "phase", "fault":
+		return nil
+	}
+
+	return &FieldError{"legs.debug_leds", fmt.Sprintf("must be phase, fault, or empty, but is %q", l.DebugLEDs)}
+}
+
+// validate checks that the preset exists, and that each has the margin and slope
+// of every joint, within the range of the registers.
+func (s Stiffness) validate() error {
+	if _, ok := s.Compliance(s.Preset); !ok {
+		return &FieldError{"legs.stiffness.preset", fmt.Sprintf("must be soft, normal, or stiff, but is %q", s.Preset)}
+	}
+
+	for _, name := range StiffnessPresets {
+		c, _ := s.Compliance(name)
+		for _, f := range []struct {
+			key      string
+			vs       []int
+			min, max float64
+		}{
+			{"margin", c.Margin, 0, 254},
+			{"slope", c.Slope, 1, 254},
+		} {
+			key := fmt.Sprintf("legs.stiffness.%s.%s", name, f.key)
+			if len(f.vs) != 4 {
+				return &FieldError{key, fmt.Sprintf("must have one per joint (4), but has %d", len(f.vs))}
+			}
+
+			for i, v := range f.vs {
+				err := between(fmt.Sprintf("%s[%d]", key, i), float64(v), f.min, f.max)
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// validatePriority checks that each stage is one which the legs know, and is
+// only listed once. Stages which aren't listed are never reduced.
+func (b Budget) validatePriority() error {
+	seen := map[string]bool{}
+	for i, s := range b.Priority {
+		switch s {
+		case "swing", "head", "gait":
+		default:
+			return &FieldError{fmt.Sprintf("legs.budget.priority[%d]", i), fmt.Sprintf("must be swing, head, or gait, but is %q", s)}
+		}
+
+		if seen[s] {
+			return &FieldError{fmt.Sprintf("legs.budget.priority[%d]", i), fmt.Sprintf("duplicate stage: %s", s)}
+		}
+		seen[s] = true
+	}
+
+	return nil
+}
+
+// validateCountdowns checks the countdown of each source, in order of name, so
+// the same one is always reported first.
+func (s Safety) validateCountdowns() error {
+	names := make([]string, 0, len(s.Countdowns))
+	for name := range s.Countdowns {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		err := duration("safety.countdowns."+name, s.Countdowns[name].Duration, 0)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// validateModels checks that there's at least one model, since unknown servos
+// fall back to the first, and that each is only listed once.
+func (p Power) validateModels() error {
+	if len(p.Models) == 0 {
+		return &FieldError{"power.models", "must not be empty"}
+	}
+
+	seen := map[int]bool{}
+	for i, m := range p.Models {
+		if seen[m.Model] {
+			return &FieldError{fmt.Sprintf("power.models[%d].model", i), fmt.Sprintf("duplicate model: %d", m.Model)}
+		}
+		seen[m.Model] = true
+
+		for _, err := range []error{
+			between(fmt.Sprintf("power.models[%d].idle", i), m.Idle, 0, 1),
+			between(fmt.Sprintf("power.models[%d].stall", i), m.Stall, 0, 10),
+		} {
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (n Navigator) validateRoute() error {
+	for i, w := range n.Route {
+		for _, err := range []error{
+			between(fmt.Sprintf("navigator.route[%d].forward", i), w.Forward, -10000, 10000),
+			between(fmt.Sprintf("navigator.route[%d].right", i), w.Right, -10000, 10000),
+			between(fmt.Sprintf("navigator.route[%d].turn", i), w.Turn, -360, 360),
+		} {
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func between(key string, v, min, max float64) error {
+	if math.IsNaN(v) || v < min || v > max {
+		return &FieldError{key, fmt.Sprintf("must be between %v and %v, but is %v", min, max, v)}
+	}
+
+	return nil
+}
+
+func positive(key string, v float64) error {
+	if math.IsNaN(v) || v <= 0 {
+		return &FieldError{key, fmt.Sprintf("must be greater than zero, but is %v", v)}
+	}
+
+	return nil
+}
+
+func duration(key string, d, min time.Duration) error {
+	if d < min {
+		return &FieldError{key, fmt.Sprintf("must be at least %s, but is %s", min, d)}
+	}
+
+	return nil
+}
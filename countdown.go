@@ -0,0 +1,189 @@
+package hexapod
+
+import (
+	"math"
+	"time"
+
+	"github.com/adammck/hexapod/math3d"
+)
+
+const (
+
+	// How long an autonomous component can leave the target alone, once it's
+	// moving, before it has to count down again. This covers the pauses in an
+	// otherwise continuous movement, like between one waypoint and the next.
+	countdownRearm = time.Second
+)
+
+// Autonomous is an optional interface for components which move the hex by
+// themselves, rather than on behalf of someone holding the controller, like
+// the navigator. Before the first tick on which one moves the target, the core
+// counts down for Hexapod.Countdown (or its entry in Hexapod.Countdowns, by
+// Source), holding the target where it was, and setting State.Countdown and
+// publishing EventCountdown so the buzzer and LEDs (or the logs, without them)
+// can warn anyone nearby.
+//
+// Any manual input (the sticks, triggers, or a newly pressed button), a halt,
+// or a shutdown during the countdown cancels it, and Cancel is called, so the
+// component gives up whatever it was about to do. If it tries again, it counts
+// down again. Once the countdown is over, the component moves the hex freely
+// until it has left the target alone for a second.
+type Autonomous interface {
+
+	// Source returns the name of the component in the config, e.g. navigator,
+	// which it's also journaled under.
+	Source
+
+	// Cancel is called from the main loop, after Tick.
+	Cancel()
+}
+
+// Countdown is the progress of the countdown before an autonomous component
+// can move the hex.
+type Countdown struct {
+	Active bool
+
+	// The source which is counting down (see Autonomous), and how long it has
+	// to go.
+	Source    string
+	Remaining time.Duration
+}
+
+// countdown is the core's view of a single autonomous component.
+type countdown struct {
+
+	// When the countdown started, or zero if it isn't counting down, and the
+	// buttons which were held then, which don't cancel it.
+	started time.Time
+	buttons uint16
+
+	// The number of seconds which were last announced.
+	announced int
+
+	// Set once the countdown is over, until the component leaves the target
+	// alone for countdownRearm. The last time it moved the target.
+	moving bool
+	last   time.Time
+}
+
+// countdownFor returns how long the given source must count down for.
+func (h *Hexapod) countdownFor(source string) time.Duration {
+	if d, ok := h.Countdowns[source]; ok {
+		return d
+	}
+
+	return h.Countdown
+}
+
+// gate is called after an autonomous component has ticked, with the target from
+// before its tick. If it moved the target, and isn't already moving, the target
+// is put back until the countdown is over.
+func (h *Hexapod) gate(now time.Time, a Autonomous, target math3d.Pose) {
+	s := h.State
+	source := a.Source()
+	moved := s.Target != target
+
+	cd, ok := h.countdowns[a]
+	if !ok {
+		cd = &countdown{}
+		h.countdowns[a] = cd
+	}
+
+	if cd.started.IsZero() {
+		switch {
+		case cd.moving && moved:
+			cd.last = now
+			return
+
+		case cd.moving && now.Sub(cd.last) >= countdownRearm:
+			cd.moving = false
+			return
+
+		case !moved:
+			return
+		}
+
+		d := h.countdownFor(source)
+		if d <= 0 {
+			cd.moving = true
+			cd.last = now
+			return
+		}
+
+		log.Infof("%s wants to move, so counting down for %s", source, d)
+		cd.started = now
+		cd.buttons = s.Input.Buttons
+		cd.announced = 0
+	}
+
+	if why := intervention(s, cd.buttons); why != "" {
+		log.Warnf("cancelled the countdown for %s, since %s", source, why)
+		h.publish(now, EventCountdownCancelled, Warning, source)
+		a.Cancel()
+		s.Target = target
+		h.endCountdown(cd, source)
+		return
+	}
+
+	// Forget any buttons which have been released, so pressing them again
+	// counts.
+	cd.buttons &= s.Input.Buttons
+
+	// It gave up by itself, e.g. because the navigator was cleared.
+	if !moved {
+		log.Infof("%s stopped before its countdown was over", source)
+		h.endCountdown(cd, source)
+		return
+	}
+
+	left := h.countdownFor(source) - now.Sub(cd.started)
+	if left <= 0 {
+		h.publish(now, EventCountdown, Info, 0)
+		h.endCountdown(cd, source)
+		cd.moving = true
+		cd.last = now
+		return
+	}
+
+	s.Target = target
+	s.Countdown = Countdown{Active: true, Source: source, Remaining: left}
+
+	if secs := int(math.Ceil(left.Seconds())); secs != cd.announced {
+		cd.announced = secs
+		h.publish(now, EventCountdown, Info, secs)
+	}
+}
+
+// endCountdown resets the countdown of the given source, and the state, unless
+// another source has taken it over.
+func (h *Hexapod) endCountdown(cd *countdown, source string) {
+	*cd = countdown{}
+	if h.State.Countdown.Source == source {
+		h.State.Countdown = Countdown{}
+	}
+}
+
+// intervention returns why the countdown should be cancelled, or the empty
+// string if it shouldn't. Buttons which were already held when it started
+// (e.g. the one which started the route) don't count.
+func intervention(s *State, held uint16) string {
+	in := s.Input
+
+	switch {
+	case s.Shutdown:
+		return "shutting down"
+	case s.Halt:
+		return "halted"
+	case in.Buttons&^held != 0:
+		return "a button was pressed"
+	}
+
+	// Unlike Input.Walking, the right stick counts too.
+	for _, v := range [6]int{in.LeftX, in.LeftY, in.RightX, in.RightY, in.L2, in.R2} {
+		if v > InputDeadzone || v < -InputDeadzone {
+			return "the sticks were moved"
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,196 @@
+package hexapod
+
+import (
+	"testing"
+	"time"
+
+	"github.com/adammck/hexapod/math3d"
+	"github.com/stretchr/testify/assert"
+)
+
+// walker is an autonomous component which moves the target forwards on every
+// tick while it's walking, like the navigator with somewhere to go.
+type walker struct {
+	walking   bool
+	cancelled int
+}
+
+func (w *walker) Boot() error {
+	return nil
+}
+
+func (w *walker) Tick(now time.Time, state *State) error {
+	if w.walking {
+		state.Target.Position.Z += 10
+	}
+
+	return nil
+}
+
+func (w *walker) Writes() Role {
+	return Commander
+}
+
+func (w *walker) Source() string {
+	return "walker"
+}
+
+func (w *walker) Cancel() {
+	w.walking = false
+	w.cancelled += 1
+}
+
+// countdownFixture is a fixture with a walker, and the given countdown.
+type countdownFixture struct {
+	*fixture
+	w *walker
+}
+
+func newCountdownFixture(t *testing.T, d time.Duration) *countdownFixture {
+	f := &countdownFixture{fixture: newFixture(t), w: &walker{}}
+	f.h.Countdown = d
+	f.h.Add(f.w)
+	return f
+}
+
+// tick ticks for the given duration, and returns whether the target was moved
+// on every tick, or on none; it fails if it was moved on some.
+func (f *countdownFixture) tick(d time.Duration) bool {
+	moved := 0
+	ticks := 0
+	for end := f.now.Add(d); f.now.Before(end); ticks++ {
+		before := f.h.State.Target
+		f.step()
+		if f.h.State.Target != before {
+			moved += 1
+		}
+	}
+
+	assert.True(f.t, moved == 0 || moved == ticks, "moved on %d of %d ticks", moved, ticks)
+	return moved > 0
+}
+
+// events returns the countdown events which the core has published, as the
+// payloads (the seconds left, or the source, if cancelled).
+func (f *countdownFixture) events() []interface{} {
+	var out []interface{}
+	for _, e := range f.h.RecentEvents() {
+		if e.Name == EventCountdown || e.Name == EventCountdownCancelled {
+			out = append(out, e.Payload)
+		}
+	}
+
+	return out
+}
+
+func TestCountdownGatesMotion(t *testing.T) {
+	f := newCountdownFixture(t, 3*time.Second)
+	f.w.walking = true
+
+	// Held until the countdown is over, announcing each second.
+	assert.False(t, f.tick(3*time.Second))
+	assert.Equal(t, Countdown{Active: true, Source: "walker", Remaining: 20 * time.Millisecond}, f.h.State.Countdown)
+	assert.Equal(t, []interface{}{3, 2, 1}, f.events())
+
+	assert.True(t, f.tick(time.Second))
+	assert.Equal(t, Countdown{}, f.h.State.Countdown)
+	assert.Equal(t, []interface{}{3, 2, 1, 0}, f.events())
+
+	// A short pause doesn't need another countdown.
+	f.w.walking = false
+	f.tick(500 * time.Millisecond)
+	f.w.walking = true
+	assert.True(t, f.tick(time.Second))
+
+	// A longer one does.
+	f.w.walking = false
+	f.tick(time.Second)
+	f.w.walking = true
+	assert.False(t, f.tick(time.Second))
+	assert.True(t, f.h.State.Countdown.Active)
+	assert.Equal(t, 0, f.w.cancelled)
+}
+
+func TestCountdownGivenUp(t *testing.T) {
+	f := newCountdownFixture(t, 3*time.Second)
+	f.w.walking = true
+	f.tick(time.Second)
+
+	// Stopping by itself isn't cancelling, but it starts over.
+	f.w.walking = false
+	assert.False(t, f.tick(20*time.Millisecond))
+	assert.Equal(t, Countdown{}, f.h.State.Countdown)
+
+	f.w.walking = true
+	assert.False(t, f.tick(3*time.Second))
+	assert.True(t, f.tick(20*time.Millisecond))
+	assert.Equal(t, 0, f.w.cancelled)
+}
+
+func TestCountdownPerSource(t *testing.T) {
+	f := newCountdownFixture(t, 3*time.Second)
+	f.h.Countdowns = map[string]time.Duration{"walker": 0}
+
+	f.w.walking = true
+	assert.True(t, f.tick(time.Second))
+	assert.Empty(t, f.events())
+}
+
+func TestCountdownCancelled(t *testing.T) {
+	for name, intervene := range map[string]func(s *State){
+		"sticks":   func(s *State) { s.Input.LeftY = -100 },
+		"triggers": func(s *State) { s.Input.R2 = 50 },
+		"button":   func(s *State) { s.Input.Buttons |= ButtonCircle },
+		"halt":     func(s *State) { s.Halt = true },
+		"shutdown": func(s *State) { s.Shutdown = true },
+	} {
+		t.Run(name, func(t *testing.T) {
+			f := newCountdownFixture(t, 3*time.Second)
+
+			// The button which started it is still held, which doesn't count,
+			// and nor do the sticks drifting a little.
+			f.h.State.Input.Buttons = ButtonSelect | ButtonCross
+			f.h.State.Input.LeftX = 5
+			f.w.walking = true
+			assert.False(t, f.tick(time.Second))
+			f.h.State.Input.Buttons = ButtonSelect
+			assert.False(t, f.tick(time.Second))
+			assert.Equal(t, 0, f.w.cancelled)
+
+			intervene(f.h.State)
+			assert.False(t, f.tick(20*time.Millisecond))
+			assert.Equal(t, 1, f.w.cancelled)
+			assert.Equal(t, Countdown{}, f.h.State.Countdown)
+			assert.Equal(t, []interface{}{3, 2, "walker"}, f.events())
+
+			// It doesn't move once the countdown would have been over.
+			assert.False(t, f.tick(2*time.Second))
+		})
+	}
+}
+
+func TestCountdownButtonPressedAgain(t *testing.T) {
+	f := newCountdownFixture(t, 3*time.Second)
+	f.h.State.Input.Buttons = ButtonCross
+	f.w.walking = true
+	f.tick(time.Second)
+
+	// Released and pressed again counts.
+	f.h.State.Input.Buttons = 0
+	f.tick(20 * time.Millisecond)
+	f.h.State.Input.Buttons = ButtonCross
+	f.tick(20 * time.Millisecond)
+	assert.Equal(t, 1, f.w.cancelled)
+}
+
+// The target is put back as it was, not to the pose, so whatever else was
+// being done with it (e.g. the clearance) isn't lost.
+func TestCountdownKeepsTarget(t *testing.T) {
+	f := newCountdownFixture(t, time.Second)
+	target := math3d.Pose{Position: math3d.Vector3{Y: 60}, Pitch: 5}
+	f.h.State.Target = target
+
+	f.w.walking = true
+	f.tick(500 * time.Millisecond)
+	assert.Equal(t, target, f.h.State.Target)
+}
@@ -0,0 +1,144 @@
+package diag
+
+import (
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"time"
+
+	"github.com/adammck/hexapod"
+	"github.com/adammck/hexapod/servos"
+)
+
+var log = hexapod.NewLog("diag")
+
+// Server is an HTTP server for diagnosing performance problems while the
+// hexapod is running:
+//
+//	/debug/pprof/  the standard net/http/pprof endpoints
+//	/debug/vars    expvar counters, including tick timing and bus errors
+//	/loop          the timing of recent ticks, per component, as JSON
+//	/bus           the timing of the transactions on the servo bus, as JSON
+//	/journal       what each source commanded recently, as JSON; ?source=
+//	               only includes the entries of that source
+//
+// It isn't a component, because it should keep working even if the main loop
+// is stuck, which is exactly when it's most useful.
+type Server struct {
+	hex      *hexapod.Hexapod
+	bus      *servos.Latency
+	mux      *http.ServeMux
+	listener net.Listener
+}
+
+// Start starts a diagnostics server on the given port, in the background. It's
+// disabled (and returns nil) if port is zero. The server only listens on the
+// loopback interface unless public is true, since pprof can leak all sorts.
+// The bus latency can be nil, if it isn't being recorded.
+func Start(port int, public bool, h *hexapod.Hexapod, bus *servos.Latency) (*Server, error) {
+	if port == 0 {
+		return nil, nil
+	}
+
+	return listen(addr(port, public), h, bus)
+}
+
+func addr(port int, public bool) string {
+	if public {
+		return fmt.Sprintf(":%d", port)
+	}
+
+	return fmt.Sprintf("127.0.0.1:%d", port)
+}
+
+func listen(addr string, h *hexapod.Hexapod, bus *servos.Latency) (*Server, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	s := newServer(h, bus)
+	s.listener = l
+
+	log.Infof("listening on %s", l.Addr())
+	go func() {
+		err := http.Serve(l, s.mux)
+		log.Errorf("server stopped: %s", err)
+	}()
+
+	return s, nil
+}
+
+func newServer(h *hexapod.Hexapod, bus *servos.Latency) *Server {
+	s := &Server{
+		hex: h,
+		bus: bus,
+		mux: http.NewServeMux(),
+	}
+
+	// Register the pprof handlers explicitly, since importing the package only
+	// registers them with the default mux.
+	s.mux.HandleFunc("/debug/pprof/", pprof.Index)
+	s.mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	s.mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	s.mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	s.mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	s.mux.Handle("/debug/vars", expvar.Handler())
+	s.mux.HandleFunc("/loop", s.handleLoop)
+	s.mux.HandleFunc("/bus", s.handleBus)
+	s.mux.HandleFunc("/journal", s.handleJournal)
+
+	return s
+}
+
+// Addr returns the address which the server is listening on.
+func (s *Server) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+// Close stops the server.
+func (s *Server) Close() error {
+	return s.listener.Close()
+}
+
+func (s *Server) handleLoop(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(s.hex.LoopStats())
+	if err != nil {
+		log.Warnf("%s (while writing response)", err)
+	}
+}
+
+func (s *Server) handleBus(w http.ResponseWriter, r *http.Request) {
+	if s.bus == nil {
+		http.Error(w, "bus latency isn't being recorded", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(s.bus.Stats(time.Now()))
+	if err != nil {
+		log.Warnf("%s (while writing response)", err)
+	}
+}
+
+func (s *Server) handleJournal(w http.ResponseWriter, r *http.Request) {
+	if s.hex.Journal == nil {
+		http.Error(w, "the journal isn't enabled", http.StatusNotFound)
+		return
+	}
+
+	entries := s.hex.Journal.Entries(r.URL.Query().Get("source"))
+	if entries == nil {
+		entries = []hexapod.JournalEntry{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(entries)
+	if err != nil {
+		log.Warnf("%s (while writing response)", err)
+	}
+}
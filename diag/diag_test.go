@@ -0,0 +1,169 @@
+package diag
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/adammck/dynamixel/network"
+	"github.com/adammck/hexapod"
+	"github.com/adammck/hexapod/config"
+	fake_serial "github.com/adammck/hexapod/fake/serial"
+	"github.com/adammck/hexapod/servos"
+	"github.com/stretchr/testify/assert"
+)
+
+type slowComponent struct{}
+
+func (c *slowComponent) Boot() error { return nil }
+
+func (c *slowComponent) Tick(now time.Time, state *hexapod.State) error {
+	time.Sleep(2 * time.Millisecond)
+	return nil
+}
+
+func get(t *testing.T, s *Server, path string) (int, []byte) {
+	res, err := http.Get(fmt.Sprintf("http://%s%s", s.Addr(), path))
+	if !assert.NoError(t, err) {
+		return 0, nil
+	}
+	defer res.Body.Close()
+
+	b, err := ioutil.ReadAll(res.Body)
+	assert.NoError(t, err)
+	return res.StatusCode, b
+}
+
+func TestEndpoints(t *testing.T) {
+	h := hexapod.NewHexapod(network.New(&fake_serial.FakeSerial{}), 60)
+	h.Add(&slowComponent{})
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, h.Tick(time.Now()))
+	}
+
+	s, err := listen("127.0.0.1:0", h, nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer s.Close()
+
+	code, body := get(t, s, "/loop")
+	assert.Equal(t, http.StatusOK, code)
+
+	var stats hexapod.LoopStats
+	assert.NoError(t, json.Unmarshal(body, &stats))
+	assert.Equal(t, int64(3), stats.Ticks)
+	if assert.Len(t, stats.Components, 1) {
+		assert.Equal(t, "*diag.slowComponent", stats.Components[0].Name)
+		assert.True(t, stats.Components[0].MeanUs >= 2000)
+		assert.True(t, stats.Total.MaxUs >= stats.Components[0].MaxUs)
+	}
+
+	code, body = get(t, s, "/debug/vars")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Contains(t, string(body), `"hexapod.ticks"`)
+	assert.Contains(t, string(body), `"hexapod.bus_errors"`)
+
+	code, _ = get(t, s, "/debug/pprof/")
+	assert.Equal(t, http.StatusOK, code)
+
+	// The bus latency isn't recorded here, and there's no journal.
+	code, _ = get(t, s, "/bus")
+	assert.Equal(t, http.StatusNotFound, code)
+
+	code, _ = get(t, s, "/journal")
+	assert.Equal(t, http.StatusNotFound, code)
+}
+
+// source is a source which moves the target forwards on every tick.
+type source struct {
+	name string
+}
+
+func (c *source) Boot() error { return nil }
+
+func (c *source) Tick(now time.Time, state *hexapod.State) error {
+	state.Target.Position.Z += 10
+	return nil
+}
+
+func (c *source) Source() string { return c.name }
+
+func TestJournal(t *testing.T) {
+	h := hexapod.NewHexapod(network.New(&fake_serial.FakeSerial{}), 60)
+	h.Journal = hexapod.NewJournal(time.Second, 60)
+	h.Add(&source{name: "pad"})
+	h.Add(&source{name: "nav"})
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, h.Tick(time.Now()))
+	}
+
+	s, err := listen("127.0.0.1:0", h, nil)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer s.Close()
+
+	code, body := get(t, s, "/journal?source=nav")
+	assert.Equal(t, http.StatusOK, code)
+
+	var entries []hexapod.JournalEntry
+	assert.NoError(t, json.Unmarshal(body, &entries))
+	if assert.Len(t, entries, 3) {
+		for _, e := range entries {
+			assert.Equal(t, "nav", e.Source)
+			assert.Len(t, e.Commands, 1)
+			assert.True(t, e.Won)
+		}
+	}
+
+	// Every source, including the params.
+	code, body = get(t, s, "/journal")
+	assert.Equal(t, http.StatusOK, code)
+	assert.NoError(t, json.Unmarshal(body, &entries))
+	assert.Len(t, entries, 9)
+
+	// None, but still a list.
+	code, body = get(t, s, "/journal?source=nobody")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "[]\n", string(body))
+}
+
+func TestBus(t *testing.T) {
+	h := hexapod.NewHexapod(network.New(&fake_serial.FakeSerial{}), 60)
+	p := servos.NewPort(&fake_serial.FakeSerial{})
+	p.Latency = servos.NewLatency(config.Default().Bus)
+	for i := 1; i <= 3; i++ {
+		_, err := p.Write([]byte{0xFF, 0xFF, byte(i), 0x04, 0x03, 0x1E, 0x00, 0x00})
+		assert.NoError(t, err)
+	}
+
+	s, err := listen("127.0.0.1:0", h, p.Latency)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer s.Close()
+
+	code, body := get(t, s, "/bus")
+	assert.Equal(t, http.StatusOK, code)
+
+	var stats servos.LatencyStats
+	assert.NoError(t, json.Unmarshal(body, &stats))
+	assert.Equal(t, int64(3), stats.Transactions)
+	assert.Len(t, stats.Worst, 3)
+	assert.Len(t, stats.Histogram, 8)
+}
+
+func TestDisabled(t *testing.T) {
+	s, err := Start(0, false, nil, nil)
+	assert.NoError(t, err)
+	assert.Nil(t, s)
+}
+
+func TestLocalhostByDefault(t *testing.T) {
+	assert.Equal(t, "127.0.0.1:6060", addr(6060, false))
+	assert.Equal(t, ":6060", addr(6060, true))
+}
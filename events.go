@@ -0,0 +1,318 @@
+package hexapod
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// The number of events to keep for RecentEvents.
+const DefaultEventHistory = 100
+
+// Severity is how much attention an event deserves.
+type Severity int
+
+const (
+	Info Severity = iota
+	Warning
+	Critical
+)
+
+func (s Severity) String() string {
+	switch s {
+	case Info:
+		return "info"
+	case Warning:
+		return "warning"
+	case Critical:
+		return "critical"
+	}
+
+	return fmt.Sprintf("severity(%d)", int(s))
+}
+
+func (s Severity) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// The names of the events which the built-in components publish, so that
+// others can subscribe to them without importing the publisher.
+const (
+	EventGaitChanged       = "gait_changed"
+	EventSpeedChanged      = "speed_changed"
+	EventClearanceChanged  = "clearance_changed"
+	EventShutdownRequested = "shutdown_requested"
+	EventBatteryLow        = "battery_low"
+
+	// Published by the navigator with the number of waypoints reached so far,
+	// and then the number in the whole route once it's empty.
+	EventWaypointReached = "waypoint_reached"
+	EventRouteFinished   = "route_finished"
+
+	// Published by the selftest component once the self-test has finished,
+	// with the State.SelfTest, or the names of the subsystems which failed.
+	EventSelfTestPassed = "selftest_passed"
+	EventSelfTestFailed = "selftest_failed"
+
+	// Published by the righting component when the hex has fallen onto its
+	// back, and once it has rolled it back over, or given up, with how many
+	// attempts it took.
+	EventFallen            = "fallen"
+	EventRightingSucceeded = "righting_succeeded"
+	EventRightingFailed    = "righting_failed"
+
+	// Published by the startup component when it won't let the legs stand up,
+	// with where it found the hex (e.g. "on its side"), and again whenever
+	// that changes, until it's on its feet.
+	EventStartupHeld = "startup_held"
+
+	// Published by the transport component once it has folded the legs up and
+	// turned the torque off, and once it has been woken up again.
+	EventTransportFolded = "transport_folded"
+	EventTransportWoken  = "transport_woken"
+
+	// Published by the endurance component when it parks the hex to let the
+	// servos cool down, with how long for (in seconds), and when it resumes,
+	// with why.
+	EventCoolingStarted = "cooling_started"
+	EventCoolingEnded   = "cooling_ended"
+
+	// Published by the legs when the current is still over the torque budget
+	// (see config.Budget) after reducing whatever comes before the gait, so
+	// they start slowing it, with the current (in amps) as the payload.
+	EventOverBudget = "over_budget"
+
+	// Published by the controller when the turbo is engaged, with its factor;
+	// when it ends, with the cooldown (in seconds); and when it can be engaged
+	// again.
+	EventTurboEngaged = "turbo_engaged"
+	EventTurboCooling = "turbo_cooling"
+	EventTurboReady   = "turbo_ready"
+
+	// Published by the controller when the auto gait mode is enabled or
+	// disabled, with whether it's enabled.
+	EventAutoGaitChanged = "auto_gait_changed"
+
+	// Published by the controller when the tuning mode is engaged or
+	// disengaged, with whether it's engaged, and whenever it nudges a param,
+	// with the params.Value it was set to.
+	EventTuningChanged = "tuning_changed"
+	EventParamTuned    = "param_tuned"
+
+	// Published by the derate component the first time that the speed is
+	// limited, with the factor, since the battery will only get flatter.
+	EventDerateEngaged = "derate_engaged"
+
+	// Published by the safemode component when the hex is armed, and can
+	// walk.
+	EventArmed = "armed"
+
+	// Published by the legs when they switch to another stiffness preset (see
+	// config.Stiffness), with its name.
+	EventStiffnessChanged = "stiffness_changed"
+
+	// Published by the legs when they start clamping the clearance, pitch or
+	// bank, to keep the chassis out of the ground (see config.Chassis). It's
+	// rate limited, since that can come and go with every step.
+	EventChassisClamped = "chassis_clamped"
+
+	// Published by the legs once they've re-centred the stance under the body,
+	// with how far (in mm) the offset which was folded into the pose was.
+	EventStanceRecentred = "stance_recentred"
+
+	// Published by the odometer when one of its lifetime counters is reset,
+	// with the name of the counter, and what it was before.
+	EventCounterReset = "counter_reset"
+
+	// Published by the follower when it first hears from the leader, with its
+	// name, and when it's lost the leader, which halts it.
+	EventLeaderFound = "leader_found"
+	EventLeaderLost  = "leader_lost"
+
+	// Published by the core while an autonomous component is counting down
+	// before it moves the hex (see Autonomous), with the number of seconds
+	// left, once a second, and then zero when it starts moving; or when the
+	// countdown is cancelled, with the name of the source.
+	EventCountdown          = "countdown"
+	EventCountdownCancelled = "countdown_cancelled"
+
+	// Published by the core when a component first becomes unhealthy (see
+	// Hexapod.HealthWindow), with the type of the component as the payload.
+	EventComponentUnhealthy = "component_unhealthy"
+)
+
+// The source of the events which the core publishes itself.
+const coreSource = "hexapod"
+
+// Event is something discrete which happened during a tick, like the gait
+// changing or the battery running low, as opposed to the continuous stuff in
+// the state. Components publish them via State.Publish.
+type Event struct {
+	Name     string      `json:"name"`
+	Severity Severity    `json:"severity"`
+	Payload  interface{} `json:"payload,omitempty"`
+
+	// Set by the core: the time passed to the tick during which the event was
+	// published, and the type of the component which published it.
+	Time   time.Time `json:"time"`
+	Source string    `json:"source"`
+}
+
+// Subscriber is an optional interface for components which want to react to
+// events. At the start of each tick, before Tick is called, Notify is called
+// with the events from the previous tick which Wants accepted, in the order in
+// which they were published. It isn't called if there are none. This all
+// happens in the main loop, so there's nothing to lock.
+//
+// The slice passed to Notify is reused, so mustn't be kept.
+type Subscriber interface {
+	Wants(e *Event) bool
+	Notify(events []Event)
+}
+
+// Publish queues an event, to be delivered to subscribers on the next tick.
+func (s *State) Publish(name string, sev Severity, payload interface{}) {
+	s.events = append(s.events, Event{Name: name, Severity: sev, Payload: payload})
+}
+
+// Published returns the events which have been published during the current
+// component's tick, so far. The core takes them once the tick returns.
+func (s *State) Published() []Event {
+	return s.events
+}
+
+// eventBus collects the events published during a tick, and delivers them on
+// the next. The buffers are swapped rather than reallocated.
+type eventBus struct {
+
+	// Events published during the current tick, and the previous one (which
+	// are being delivered).
+	pending []Event
+	batch   []Event
+
+	// The events for a single subscriber, filtered from the batch.
+	scratch []Event
+
+	// The most recent events, for RecentEvents. This is read from other
+	// goroutines, so is locked.
+	mu      sync.Mutex
+	history []Event
+	next    int
+	size    int
+}
+
+func newEventBus(size int) *eventBus {
+	return &eventBus{history: make([]Event, size)}
+}
+
+// flip starts a new tick: the events published during the last one become
+// the batch to deliver.
+func (b *eventBus) flip() {
+	b.batch, b.pending = b.pending, b.batch[:0]
+}
+
+// collect takes the events which the given component published during its
+// tick from the state, and adds them to the pending events.
+func (b *eventBus) collect(now time.Time, c Component, s *State) {
+	if len(s.events) == 0 {
+		return
+	}
+
+	src := fmt.Sprintf("%T", c)
+	for _, e := range s.events {
+		b.add(now, src, e)
+	}
+
+	s.events = s.events[:0]
+}
+
+// add stamps the event, and adds it to the pending events.
+func (b *eventBus) add(now time.Time, src string, e Event) {
+	e.Time = now
+	e.Source = src
+	b.pending = append(b.pending, e)
+	b.remember(e)
+	logEvent(&e)
+}
+
+// deliver passes the batch to the component, if it's a subscriber and wants
+// any of it.
+func (b *eventBus) deliver(c Component) {
+	sub, ok := c.(Subscriber)
+	if !ok || len(b.batch) == 0 {
+		return
+	}
+
+	b.scratch = b.scratch[:0]
+	for i := range b.batch {
+		if sub.Wants(&b.batch[i]) {
+			b.scratch = append(b.scratch, b.batch[i])
+		}
+	}
+
+	if len(b.scratch) > 0 {
+		sub.Notify(b.scratch)
+	}
+}
+
+func (b *eventBus) remember(e Event) {
+	if len(b.history) == 0 {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.history[b.next] = e
+	b.next = (b.next + 1) % len(b.history)
+	if b.size < len(b.history) {
+		b.size += 1
+	}
+}
+
+// recent returns the remembered events, oldest first.
+func (b *eventBus) recent() []Event {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.size == 0 {
+		return nil
+	}
+
+	out := make([]Event, 0, b.size)
+	start := (b.next - b.size + len(b.history)) % len(b.history)
+	for i := 0; i < b.size; i++ {
+		out = append(out, b.history[(start+i)%len(b.history)])
+	}
+
+	return out
+}
+
+// Events are logged as they're published, since they used to be log lines.
+func logEvent(e *Event) {
+	l := log.WithField("source", e.Source)
+	msg := e.Name
+	if e.Payload != nil {
+		msg = fmt.Sprintf("%s: %v", e.Name, e.Payload)
+	}
+
+	switch e.Severity {
+	case Info:
+		l.Info(msg)
+	case Warning:
+		l.Warn(msg)
+	default:
+		l.Error(msg)
+	}
+}
+
+// publish queues an event from the core itself, rather than a component.
+func (h *Hexapod) publish(now time.Time, name string, sev Severity, payload interface{}) {
+	h.events.add(now, coreSource, Event{Name: name, Severity: sev, Payload: payload})
+}
+
+// RecentEvents returns the most recent events (up to DefaultEventHistory),
+// oldest first. Unlike most methods, this can be called from any goroutine.
+func (h *Hexapod) RecentEvents() []Event {
+	return h.events.recent()
+}
@@ -0,0 +1,121 @@
+package hexapod
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// publishingComponent publishes the given event names (with the tick number as
+// the payload) on every tick.
+type publishingComponent struct {
+	names []string
+	ticks int
+}
+
+func (c *publishingComponent) Boot() error {
+	return nil
+}
+
+func (c *publishingComponent) Tick(now time.Time, state *State) error {
+	c.ticks += 1
+	for _, n := range c.names {
+		state.Publish(n, Info, c.ticks)
+	}
+
+	return nil
+}
+
+// subscribingComponent keeps a copy of every event it's notified of, and how
+// many times it was notified.
+type subscribingComponent struct {
+	want     string
+	got      []Event
+	notifies int
+}
+
+func (c *subscribingComponent) Boot() error {
+	return nil
+}
+
+func (c *subscribingComponent) Tick(now time.Time, state *State) error {
+	return nil
+}
+
+func (c *subscribingComponent) Wants(e *Event) bool {
+	return c.want == "" || e.Name == c.want
+}
+
+func (c *subscribingComponent) Notify(events []Event) {
+	c.notifies += 1
+	c.got = append(c.got, events...)
+}
+
+func TestEventsAreDeliveredNextTick(t *testing.T) {
+	h := newTestHexapod()
+	sub := &subscribingComponent{}
+	a := &publishingComponent{names: []string{"a1", "a2"}}
+	b := &publishingComponent{names: []string{"b"}}
+
+	// The subscriber ticks before and after the publishers, but should see
+	// the same thing either way.
+	late := &subscribingComponent{}
+	h.Add(sub)
+	h.Add(a)
+	h.Add(b)
+	h.Add(late)
+
+	now := time.Now()
+	assert.NoError(t, h.Tick(now))
+	assert.Empty(t, sub.got)
+	assert.Empty(t, late.got)
+
+	assert.NoError(t, h.Tick(now.Add(time.Second)))
+	for _, s := range []*subscribingComponent{sub, late} {
+		assert.Equal(t, 1, s.notifies)
+		if assert.Len(t, s.got, 3) {
+			assert.Equal(t, Event{Name: "a1", Payload: 1, Time: now, Source: "*hexapod.publishingComponent"}, s.got[0])
+			assert.Equal(t, "a2", s.got[1].Name)
+			assert.Equal(t, "b", s.got[2].Name)
+		}
+	}
+
+	// Each tick's events are only delivered once.
+	assert.NoError(t, h.Tick(now.Add(2*time.Second)))
+	assert.Equal(t, 2, sub.notifies)
+	assert.Len(t, sub.got, 6)
+	assert.Equal(t, 2, sub.got[3].Payload)
+}
+
+func TestEventsAreFiltered(t *testing.T) {
+	h := newTestHexapod()
+	one := &subscribingComponent{want: "one"}
+	none := &subscribingComponent{want: "nope"}
+	h.Add(&publishingComponent{names: []string{"one", "two", "one"}})
+	h.Add(one)
+	h.Add(none)
+
+	assert.NoError(t, tickN(t, h, 2))
+	assert.Equal(t, 1, one.notifies)
+	assert.Len(t, one.got, 2)
+	assert.Equal(t, 0, none.notifies)
+}
+
+func TestRecentEvents(t *testing.T) {
+	h := newTestHexapod()
+	assert.Nil(t, h.RecentEvents())
+
+	h.events = newEventBus(3)
+	h.Add(&publishingComponent{names: []string{"x", "y"}})
+	assert.NoError(t, tickN(t, h, 2))
+
+	var got []string
+	for _, e := range h.RecentEvents() {
+		got = append(got, e.Name)
+	}
+
+	// The oldest x is gone.
+	assert.Equal(t, []string{"y", "x", "y"}, got)
+	assert.Equal(t, 2, h.RecentEvents()[2].Payload)
+}
@@ -0,0 +1,60 @@
+package hexapod_test
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/adammck/dynamixel/network"
+	"github.com/adammck/hexapod"
+	"github.com/adammck/hexapod/config"
+	fake_serial "github.com/adammck/hexapod/fake/serial"
+	"github.com/adammck/hexapod/params"
+)
+
+// Greeter is a trivial component, which asks the hex to shut down after a few
+// ticks.
+type Greeter struct {
+	ticks int
+}
+
+func (g *Greeter) Boot() error {
+	fmt.Println("booted")
+	return nil
+}
+
+func (g *Greeter) Tick(now time.Time, state *hexapod.State) error {
+	g.ticks++
+	if g.ticks == 3 {
+		fmt.Println("requesting shutdown")
+		state.Shutdown = true
+	}
+
+	return nil
+}
+
+func (g *Greeter) Shutdown() error {
+	fmt.Println("shut down")
+	return nil
+}
+
+func Example() {
+	cfg := config.Default()
+	cfg.Safety.ShutdownGrace = config.Duration{}
+
+	// A real program would open the serial port which the servos are on, and
+	// register the legs, etc.
+	h := hexapod.New(network.New(&fake_serial.FakeSerial{}), cfg)
+	h.Params = params.New()
+	h.Register(&Greeter{})
+
+	err := h.Run(context.Background())
+	if err != nil {
+		fmt.Println(err)
+	}
+
+	// Output:
+	// booted
+	// requesting shutdown
+	// shut down
+}
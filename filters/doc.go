@@ -0,0 +1,9 @@
+// Package filters contains small, time-aware signal filters for smoothing noisy
+// inputs (sensors, controller sticks) and limiting how fast outputs change.
+//
+// Every filter takes the time of each sample rather than assuming a fixed loop
+// rate, since Tick isn't called at exactly regular intervals. Samples at (or
+// before) the time of the previous sample are treated as arriving
+// simultaneously with it. The first sample after creation or Reset initializes
+// the filter, so there's no ramp up from zero.
+package filters
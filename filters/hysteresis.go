@@ -0,0 +1,49 @@
+package filters
+
+// Hysteresis is a threshold with separate rising and falling levels, so a noisy
+// input close to the threshold doesn't flip the output back and forth. The
+// output turns on when the input reaches the rising level, and stays on until
+// it drops to the falling level.
+//
+// Unlike the other filters, this doesn't care about time.
+type Hysteresis struct {
+	rising  float64
+	falling float64
+	on      bool
+}
+
+// NewHysteresis creates a threshold which turns on at rising, and off at
+// falling. For a low threshold (e.g. battery voltage), use the opposite of the
+// input, or just negate the output.
+func NewHysteresis(rising, falling float64) *Hysteresis {
+	if falling > rising {
+		panic("hysteresis falling level is above the rising level")
+	}
+
+	return &Hysteresis{rising: rising, falling: falling}
+}
+
+// Update adds a sample, and returns whether the output is on.
+func (h *Hysteresis) Update(x float64) bool {
+	if h.on {
+		if x <= h.falling {
+			h.on = false
+		}
+	} else {
+		if x >= h.rising {
+			h.on = true
+		}
+	}
+
+	return h.on
+}
+
+// On returns whether the output is on. It's off before the first sample.
+func (h *Hysteresis) On() bool {
+	return h.on
+}
+
+// Reset turns the output off.
+func (h *Hysteresis) Reset() {
+	h.on = false
+}
@@ -0,0 +1,49 @@
+package filters
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHysteresis(t *testing.T) {
+	h := NewHysteresis(10, 5)
+	assert.False(t, h.On())
+
+	type eg struct {
+		in  float64
+		out bool
+	}
+
+	examples := []eg{
+		{0, false},
+		{9.9, false},
+		{10, true},
+		{7, true},
+		{12, true},
+		{5.1, true},
+		{5, false},
+		{9, false},
+		{6, false},
+		{100, true},
+		{-100, false},
+	}
+
+	for i, x := range examples {
+		assert.Equal(t, x.out, h.Update(x.in), "example %d", i+1)
+	}
+
+	h.Update(20)
+	h.Reset()
+	assert.False(t, h.On())
+	assert.False(t, h.Update(7))
+}
+
+func TestHysteresisSingleLevel(t *testing.T) {
+	h := NewHysteresis(5, 5)
+	assert.True(t, h.Update(5))
+	assert.False(t, h.Update(5))
+	assert.True(t, h.Update(5))
+
+	assert.Panics(t, func() { NewHysteresis(5, 10) })
+}
@@ -0,0 +1,54 @@
+package filters
+
+import (
+	"math"
+	"time"
+)
+
+// FirstOrderLowPass is an exponential smoothing filter, which moves towards its
+// input by a fraction of the difference per sample. The fraction depends on the
+// time since the previous sample, so the response is the same regardless of the
+// sample rate: after one time constant, it's about 63% of the way to a constant
+// input.
+type FirstOrderLowPass struct {
+	tau   time.Duration
+	value float64
+	last  time.Time
+	init  bool
+}
+
+// NewFirstOrderLowPass creates a filter with the given time constant. Longer is
+// smoother, but slower. Zero passes the input through unchanged.
+func NewFirstOrderLowPass(tau time.Duration) *FirstOrderLowPass {
+	return &FirstOrderLowPass{tau: tau}
+}
+
+// Update adds a sample taken at the given time, and returns the new output.
+func (f *FirstOrderLowPass) Update(now time.Time, x float64) float64 {
+	if !f.init || f.tau <= 0 {
+		f.value = x
+		f.last = now
+		f.init = true
+		return f.value
+	}
+
+	dt := now.Sub(f.last)
+	if dt <= 0 {
+		return f.value
+	}
+
+	alpha := 1 - math.Exp(-dt.Seconds()/f.tau.Seconds())
+	f.value += alpha * (x - f.value)
+	f.last = now
+	return f.value
+}
+
+// Value returns the current output, which is zero before the first sample.
+func (f *FirstOrderLowPass) Value() float64 {
+	return f.value
+}
+
+// Reset forgets all previous samples.
+func (f *FirstOrderLowPass) Reset() {
+	*f = FirstOrderLowPass{tau: f.tau}
+}
@@ -0,0 +1,72 @@
+package filters
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var t0 = time.Date(2017, 6, 1, 0, 0, 0, 0, time.UTC)
+
+func at(d time.Duration) time.Time {
+	return t0.Add(d)
+}
+
+func TestLowPassStep(t *testing.T) {
+	f := NewFirstOrderLowPass(time.Second)
+	assert.Equal(t, 0.0, f.Value())
+
+	// The first sample initializes.
+	assert.Equal(t, 10.0, f.Update(at(0), 10))
+
+	// After one time constant, 63% of the way to the step.
+	assert.InDelta(t, 10+(90*(1-math.Exp(-1))), f.Update(at(time.Second), 100), 0.0001)
+
+	// And eventually all of the way.
+	for i := 2; i < 20; i++ {
+		f.Update(at(time.Duration(i)*time.Second), 100)
+	}
+	assert.InDelta(t, 100, f.Value(), 0.0001)
+}
+
+func TestLowPassIrregular(t *testing.T) {
+
+	// The same step, sampled at different rates, gets to the same place.
+	a := NewFirstOrderLowPass(200 * time.Millisecond)
+	b := NewFirstOrderLowPass(200 * time.Millisecond)
+	a.Update(at(0), 0)
+	b.Update(at(0), 0)
+
+	for i := 1; i <= 60; i++ {
+		a.Update(at(time.Duration(i)*time.Second/60), 1)
+	}
+	for _, ms := range []int{3, 50, 51, 120, 400, 401, 980, 1000} {
+		b.Update(at(time.Duration(ms)*time.Millisecond), 1)
+	}
+	assert.InDelta(t, a.Value(), b.Value(), 0.0001)
+
+	// Duplicate and out of order samples don't change anything.
+	v := b.Value()
+	assert.Equal(t, v, b.Update(at(time.Second), 50))
+	assert.Equal(t, v, b.Update(at(time.Millisecond), 50))
+}
+
+func TestLowPassReset(t *testing.T) {
+	f := NewFirstOrderLowPass(time.Second)
+	f.Update(at(0), 10)
+	f.Update(at(time.Second), 20)
+
+	f.Reset()
+	assert.Equal(t, 0.0, f.Value())
+	assert.Equal(t, 50.0, f.Update(at(0), 50))
+	assert.InDelta(t, 50, f.Update(at(time.Millisecond), 0), 0.1)
+}
+
+func TestLowPassZero(t *testing.T) {
+	f := NewFirstOrderLowPass(0)
+	assert.Equal(t, 1.0, f.Update(at(0), 1))
+	assert.Equal(t, 2.0, f.Update(at(0), 2))
+	assert.Equal(t, -3.0, f.Update(at(time.Second), -3))
+}
@@ -0,0 +1,71 @@
+package filters
+
+import (
+	"sort"
+	"time"
+)
+
+// MedianWindow returns the median of the samples taken within a sliding window
+// of time. Unlike averaging, this ignores occasional wild readings entirely.
+type MedianWindow struct {
+	window  time.Duration
+	samples []sample
+	sorted  []float64
+}
+
+type sample struct {
+	t time.Time
+	x float64
+}
+
+// NewMedianWindow creates a filter over the samples from the given duration
+// before the latest one. The latest sample is always included, even if the
+// window is zero.
+func NewMedianWindow(window time.Duration) *MedianWindow {
+	return &MedianWindow{window: window}
+}
+
+// Update adds a sample taken at the given time, and returns the new output.
+func (f *MedianWindow) Update(now time.Time, x float64) float64 {
+
+	// Samples can't go backwards, so treat this one as simultaneous with the
+	// previous one.
+	if n := len(f.samples); n > 0 && now.Before(f.samples[n-1].t) {
+		now = f.samples[n-1].t
+	}
+
+	// Drop the expired samples, shifting the rest down to reuse the space.
+	i := 0
+	for i < len(f.samples) && now.Sub(f.samples[i].t) > f.window {
+		i++
+	}
+	f.samples = append(f.samples[:copy(f.samples, f.samples[i:])], sample{now, x})
+
+	return f.Value()
+}
+
+// Value returns the current output, which is zero before the first sample. For
+// an even number of samples, it's the mean of the middle two.
+func (f *MedianWindow) Value() float64 {
+	n := len(f.samples)
+	if n == 0 {
+		return 0
+	}
+
+	f.sorted = f.sorted[:0]
+	for _, s := range f.samples {
+		f.sorted = append(f.sorted, s.x)
+	}
+	sort.Float64s(f.sorted)
+
+	if n%2 == 1 {
+		return f.sorted[n/2]
+	}
+
+	return (f.sorted[n/2-1] + f.sorted[n/2]) / 2
+}
+
+// Reset forgets all previous samples.
+func (f *MedianWindow) Reset() {
+	f.samples = f.samples[:0]
+}
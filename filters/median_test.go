@@ -0,0 +1,59 @@
+package filters
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMedianWindow(t *testing.T) {
+	f := NewMedianWindow(100 * time.Millisecond)
+	assert.Equal(t, 0.0, f.Value())
+
+	assert.Equal(t, 10.0, f.Update(at(0), 10))
+	assert.Equal(t, 15.0, f.Update(at(10*time.Millisecond), 20))
+
+	// A single wild reading is ignored.
+	assert.Equal(t, 20.0, f.Update(at(20*time.Millisecond), 9000))
+	assert.Equal(t, 25.0, f.Update(at(30*time.Millisecond), 30))
+	assert.Equal(t, 30.0, f.Update(at(40*time.Millisecond), 40))
+
+	// Old samples expire. At 130ms, only the samples from 30ms remain.
+	assert.Equal(t, 40.0, f.Update(at(130*time.Millisecond), 50))
+	assert.Len(t, f.samples, 3)
+
+	// A long gap drops everything except the latest.
+	assert.Equal(t, 1.0, f.Update(at(time.Second), 1))
+	assert.Len(t, f.samples, 1)
+}
+
+func TestMedianWindowIrregular(t *testing.T) {
+	f := NewMedianWindow(time.Second)
+
+	// Out of order samples are treated as simultaneous with the latest.
+	f.Update(at(5*time.Second), 1)
+	f.Update(at(time.Second), 2)
+	f.Update(at(5900*time.Millisecond), 3)
+	assert.Equal(t, 2.0, f.Value())
+
+	f.Update(at(6500*time.Millisecond), 4)
+	assert.Equal(t, 3.5, f.Value())
+}
+
+func TestMedianWindowZero(t *testing.T) {
+	f := NewMedianWindow(0)
+	assert.Equal(t, 1.0, f.Update(at(0), 1))
+	assert.Equal(t, 1.5, f.Update(at(0), 2))
+	assert.Equal(t, 3.0, f.Update(at(time.Millisecond), 3))
+}
+
+func TestMedianWindowReset(t *testing.T) {
+	f := NewMedianWindow(time.Second)
+	f.Update(at(0), 1)
+	f.Update(at(0), 2)
+
+	f.Reset()
+	assert.Equal(t, 0.0, f.Value())
+	assert.Equal(t, 5.0, f.Update(at(0), 5))
+}
@@ -0,0 +1,51 @@
+package filters
+
+import (
+	"math"
+	"time"
+)
+
+// SlewRateLimiter follows its input, but no faster than a maximum rate of
+// change. This is useful to limit acceleration, to avoid jerking the servos.
+type SlewRateLimiter struct {
+	rate  float64
+	value float64
+	last  time.Time
+	init  bool
+}
+
+// NewSlewRateLimiter creates a limiter which changes by at most rate units
+// (e.g. mm or degrees) per second, in either direction.
+func NewSlewRateLimiter(rate float64) *SlewRateLimiter {
+	return &SlewRateLimiter{rate: rate}
+}
+
+// Update adds a sample taken at the given time, and returns the new output.
+func (f *SlewRateLimiter) Update(now time.Time, x float64) float64 {
+	if !f.init {
+		f.value = x
+		f.last = now
+		f.init = true
+		return f.value
+	}
+
+	dt := now.Sub(f.last)
+	if dt <= 0 {
+		return f.value
+	}
+
+	max := f.rate * dt.Seconds()
+	f.value += math.Max(-max, math.Min(max, x-f.value))
+	f.last = now
+	return f.value
+}
+
+// Value returns the current output, which is zero before the first sample.
+func (f *SlewRateLimiter) Value() float64 {
+	return f.value
+}
+
+// Reset forgets all previous samples.
+func (f *SlewRateLimiter) Reset() {
+	*f = SlewRateLimiter{rate: f.rate}
+}
@@ -0,0 +1,49 @@
+package filters
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSlewStep(t *testing.T) {
+	f := NewSlewRateLimiter(10)
+	assert.Equal(t, 0.0, f.Value())
+	assert.Equal(t, 5.0, f.Update(at(0), 5))
+
+	// 10 per second, up and down.
+	assert.InDelta(t, 6, f.Update(at(100*time.Millisecond), 100), 0.0001)
+	assert.InDelta(t, 11, f.Update(at(600*time.Millisecond), 100), 0.0001)
+	assert.InDelta(t, 9, f.Update(at(800*time.Millisecond), -100), 0.0001)
+
+	// Small changes aren't limited.
+	assert.InDelta(t, 9.5, f.Update(at(time.Second), 9.5), 0.0001)
+	assert.InDelta(t, 9.5, f.Update(at(2*time.Second), 9.5), 0.0001)
+}
+
+func TestSlewIrregular(t *testing.T) {
+	f := NewSlewRateLimiter(100)
+	f.Update(at(0), 0)
+
+	// Regardless of how it's sliced, it takes one second to get to 100.
+	for _, ms := range []int{1, 2, 250, 260, 700, 999} {
+		exp := float64(ms) / 10
+		assert.InDelta(t, exp, f.Update(at(time.Duration(ms)*time.Millisecond), 1000), 0.0001)
+	}
+
+	// Out of order samples are ignored.
+	assert.InDelta(t, 99.9, f.Update(at(500*time.Millisecond), 1000), 0.0001)
+	assert.InDelta(t, 100, f.Update(at(time.Second), 1000), 0.0001)
+}
+
+func TestSlewReset(t *testing.T) {
+	f := NewSlewRateLimiter(1)
+	f.Update(at(0), 0)
+	f.Update(at(time.Second), 100)
+	assert.InDelta(t, 1, f.Value(), 0.0001)
+
+	f.Reset()
+	assert.Equal(t, 0.0, f.Value())
+	assert.Equal(t, 100.0, f.Update(at(2*time.Second), 100))
+}
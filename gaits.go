@@ -0,0 +1,217 @@
+package hexapod
+
+import (
+	"fmt"
+)
+
+// Gait describes a way of walking, i.e. which legs step when. The frames
+// themselves are built by the legs; this is what the rest of the hex (and the
+// operator) needs to know to choose between them.
+type Gait struct {
+	Name string
+
+	// The range of State.Speed which the gait is recommended for. Slow gaits
+	// are more stable, but look silly when rushed.
+	MinSpeed int
+	MaxSpeed int
+
+	// The clearance (in mm, between the chassis and the ground) below which the
+	// gait shouldn't be selected, since the feet wouldn't get far enough off the
+	// ground.
+	MinClearance float64
+
+	// The clearance above which the gait shouldn't be selected, since it's
+	// meant for walking with the body low, or zero if there's no limit.
+	MaxClearance float64
+}
+
+func (g Gait) String() string {
+	return g.Name
+}
+
+// Fits returns true if the gait can be used at the given clearance.
+func (g Gait) Fits(clearance float64) bool {
+	return clearance >= g.MinClearance && (g.MaxClearance == 0 || clearance <= g.MaxClearance)
+}
+
+// GaitRegistry is an ordered list of the available gaits. The order is the
+// order they were registered in, which is the order the controller cycles
+// through them in, and what State.GaitIndex refers to.
+type GaitRegistry struct {
+	gaits []Gait
+}
+
+// DefaultGaits is the registry which gait implementations register themselves
+// with, via RegisterGait, and which NewHexapod puts in the state.
+var DefaultGaits = &GaitRegistry{}
+
+// RegisterGait adds the gait to DefaultGaits. It's meant to be called from init
+// functions, so it panics if the gait is invalid or already registered.
+func RegisterGait(g Gait) {
+	err := DefaultGaits.Register(g)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// Register adds the gait to the end of the registry.
+func (r *GaitRegistry) Register(g Gait) error {
+	if g.Name == "" {
+		return fmt.Errorf("gait has no name")
+	}
+
+	if r.Index(g.Name) >= 0 {
+		return fmt.Errorf("gait already registered: %s", g.Name)
+	}
+
+	if g.MinSpeed > g.MaxSpeed {
+		return fmt.Errorf("gait %s: min speed (%d) is greater than max speed (%d)", g.Name, g.MinSpeed, g.MaxSpeed)
+	}
+
+	if g.MaxClearance != 0 && g.MaxClearance < g.MinClearance {
+		return fmt.Errorf("gait %s: max clearance (%v) is less than min clearance (%v)", g.Name, g.MaxClearance, g.MinClearance)
+	}
+
+	r.gaits = append(r.gaits, g)
+	return nil
+}
+
+// Len returns the number of registered gaits.
+func (r *GaitRegistry) Len() int {
+	return len(r.gaits)
+}
+
+// Gaits returns every registered gait, in order.
+func (r *GaitRegistry) Gaits() []Gait {
+	return append([]Gait{}, r.gaits...)
+}
+
+// Get returns the gait with the given name, and whether it's registered.
+func (r *GaitRegistry) Get(name string) (Gait, bool) {
+	i := r.Index(name)
+	if i < 0 {
+		return Gait{}, false
+	}
+
+	return r.gaits[i], true
+}
+
+// Index returns the position of the gait with the given name, or -1 if it isn't
+// registered.
+func (r *GaitRegistry) Index(name string) int {
+	for i, g := range r.gaits {
+		if g.Name == name {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// At returns the gait at the given index, mod the number of gaits, like the old
+// State.GaitIndex. It returns false if no gaits are registered.
+func (r *GaitRegistry) At(i int) (Gait, bool) {
+	n := len(r.gaits)
+	if n == 0 {
+		return Gait{}, false
+	}
+
+	return r.gaits[((i%n)+n)%n], true
+}
+
+// Next returns the first gait after the given one (wrapping around) which ok
+// accepts, or false if none do. The given gait itself is considered last, so
+// it's returned if it's the only acceptable one. If it isn't registered, the
+// search starts at the first gait.
+func (r *GaitRegistry) Next(g Gait, ok func(Gait) bool) (Gait, bool) {
+	return r.step(g, 1, ok)
+}
+
+// Previous is Next, backwards. If the given gait isn't registered, the search
+// starts at the last gait.
+func (r *GaitRegistry) Previous(g Gait, ok func(Gait) bool) (Gait, bool) {
+	return r.step(g, -1, ok)
+}
+
+func (r *GaitRegistry) step(g Gait, dir int, ok func(Gait) bool) (Gait, bool) {
+	n := len(r.gaits)
+	cur := r.Index(g.Name)
+	if cur < 0 && dir < 0 {
+		cur = 0
+	}
+
+	for i := 1; i <= n; i++ {
+		next := r.gaits[((cur+i*dir)%n+n)%n]
+		if ok == nil || ok(next) {
+			return next, true
+		}
+	}
+
+	return Gait{}, false
+}
+
+// Lowest returns the gait with the lowest max clearance, which is the one to
+// crouch with, or false if none of them have one.
+func (r *GaitRegistry) Lowest() (Gait, bool) {
+	var out Gait
+	for _, g := range r.gaits {
+		if g.MaxClearance > 0 && (out.Name == "" || g.MaxClearance < out.MaxClearance) {
+			out = g
+		}
+	}
+
+	return out, out.Name != ""
+}
+
+// registry returns the state's gait registry, or the default one if it hasn't
+// got one (e.g. in tests).
+func (s *State) registry() *GaitRegistry {
+	if s.Gaits != nil {
+		return s.Gaits
+	}
+
+	return DefaultGaits
+}
+
+// ActiveGait returns the gait which should be used. That's State.Gait if it's
+// set, or the gait at State.GaitIndex if not, for callers which only set that.
+// It returns false if neither is registered.
+func (s *State) ActiveGait() (Gait, bool) {
+	if s.Gait.Name != "" {
+		return s.Gait, true
+	}
+
+	return s.registry().At(s.GaitIndex)
+}
+
+// NextGait returns the first gait after the active one, in registry order, which
+// ok accepts. See GaitRegistry.Next.
+func (s *State) NextGait(ok func(Gait) bool) (Gait, bool) {
+	cur, _ := s.ActiveGait()
+	return s.registry().Next(cur, ok)
+}
+
+// PreviousGait returns the first gait before the active one, in registry order,
+// which ok accepts. See GaitRegistry.Previous.
+func (s *State) PreviousGait(ok func(Gait) bool) (Gait, bool) {
+	cur, _ := s.ActiveGait()
+	return s.registry().Previous(cur, ok)
+}
+
+// CrouchGait returns the registered gait to crouch with. See
+// GaitRegistry.Lowest.
+func (s *State) CrouchGait() (Gait, bool) {
+	return s.registry().Lowest()
+}
+
+// LookupGait returns the registered gait with the given name. See
+// GaitRegistry.Get.
+func (s *State) LookupGait(name string) (Gait, bool) {
+	return s.registry().Get(name)
+}
+
+// SetGait selects the given gait, and updates GaitIndex to match.
+func (s *State) SetGait(g Gait) {
+	s.Gait = g
+	s.GaitIndex = s.registry().Index(g.Name)
+}
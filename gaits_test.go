@@ -0,0 +1,168 @@
+package hexapod
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testRegistry(t *testing.T, gaits ...Gait) *GaitRegistry {
+	r := &GaitRegistry{}
+	for _, g := range gaits {
+		assert.NoError(t, r.Register(g))
+	}
+
+	return r
+}
+
+var (
+	slow = Gait{Name: "slow", MinSpeed: -10, MaxSpeed: 0}
+	fast = Gait{Name: "fast", MinSpeed: 0, MaxSpeed: 5, MinClearance: 30}
+	high = Gait{Name: "high", MinClearance: 80}
+)
+
+func TestGaitRegistration(t *testing.T) {
+	r := testRegistry(t, slow, fast, high)
+
+	// Gaits are kept in the order they were registered.
+	assert.Equal(t, []Gait{slow, fast, high}, r.Gaits())
+	assert.Equal(t, 3, r.Len())
+	assert.Equal(t, 1, r.Index("fast"))
+	assert.Equal(t, -1, r.Index("nope"))
+
+	g, ok := r.Get("high")
+	assert.True(t, ok)
+	assert.Equal(t, high, g)
+
+	_, ok = r.Get("nope")
+	assert.False(t, ok)
+
+	// Invalid and duplicate gaits are rejected, and not added.
+	assert.Error(t, r.Register(Gait{}))
+	assert.Error(t, r.Register(Gait{Name: "slow"}))
+	assert.Error(t, r.Register(Gait{Name: "backwards", MinSpeed: 5, MaxSpeed: 0}))
+	assert.Error(t, r.Register(Gait{Name: "squashed", MinClearance: 30, MaxClearance: 20}))
+	assert.Equal(t, 3, r.Len())
+
+	// The returned slice is a copy.
+	r.Gaits()[0].Name = "changed"
+	assert.Equal(t, slow, r.Gaits()[0])
+}
+
+func TestGaitFits(t *testing.T) {
+	low := Gait{Name: "low", MinClearance: 10, MaxClearance: 30}
+	for _, tc := range []struct {
+		g         Gait
+		clearance float64
+		out       bool
+	}{
+		{slow, 0, true},
+		{fast, 29, false},
+		{fast, 30, true},
+		{high, 200, true},
+		{low, 5, false},
+		{low, 10, true},
+		{low, 30, true},
+		{low, 31, false},
+	} {
+		assert.Equal(t, tc.out, tc.g.Fits(tc.clearance), "%s at %v", tc.g, tc.clearance)
+	}
+}
+
+func TestGaitLowest(t *testing.T) {
+	_, ok := testRegistry(t, slow, fast, high).Lowest()
+	assert.False(t, ok)
+
+	crouch := Gait{Name: "crouch", MaxClearance: 30}
+	crawl := Gait{Name: "crawl", MaxClearance: 20}
+	g, ok := testRegistry(t, slow, crouch, crawl, high).Lowest()
+	assert.True(t, ok)
+	assert.Equal(t, crawl, g)
+}
+
+func TestGaitAt(t *testing.T) {
+	r := testRegistry(t, slow, fast, high)
+
+	// Indexes wrap around, like GaitIndex always did.
+	for i, want := range map[int]Gait{0: slow, 2: high, 3: slow, 7: fast, -1: high} {
+		g, ok := r.At(i)
+		assert.True(t, ok)
+		assert.Equal(t, want, g, "index=%d", i)
+	}
+
+	_, ok := (&GaitRegistry{}).At(0)
+	assert.False(t, ok)
+}
+
+func TestGaitNext(t *testing.T) {
+	r := testRegistry(t, slow, fast, high)
+
+	// Cycles in registry order, wrapping around.
+	for cur, want := range map[string]Gait{"slow": fast, "fast": high, "high": slow, "": slow} {
+		g, ok := r.Next(Gait{Name: cur}, nil)
+		assert.True(t, ok)
+		assert.Equal(t, want, g, "from %q", cur)
+	}
+
+	// Gaits which aren't accepted are skipped.
+	lowEnough := func(g Gait) bool { return g.MinClearance <= 40 }
+	g, ok := r.Next(fast, lowEnough)
+	assert.True(t, ok)
+	assert.Equal(t, slow, g)
+
+	// The current gait is returned if it's the only one accepted.
+	g, ok = r.Next(slow, func(g Gait) bool { return g == slow })
+	assert.True(t, ok)
+	assert.Equal(t, slow, g)
+
+	_, ok = r.Next(slow, func(g Gait) bool { return false })
+	assert.False(t, ok)
+
+	_, ok = (&GaitRegistry{}).Next(slow, nil)
+	assert.False(t, ok)
+}
+
+func TestGaitPrevious(t *testing.T) {
+	r := testRegistry(t, slow, fast, high)
+
+	for cur, want := range map[string]Gait{"slow": high, "fast": slow, "high": fast, "": high} {
+		g, ok := r.Previous(Gait{Name: cur}, nil)
+		assert.True(t, ok)
+		assert.Equal(t, want, g, "from %q", cur)
+	}
+
+	lowEnough := func(g Gait) bool { return g.MinClearance <= 40 }
+	g, ok := r.Previous(slow, lowEnough)
+	assert.True(t, ok)
+	assert.Equal(t, fast, g)
+
+	_, ok = (&GaitRegistry{}).Previous(slow, nil)
+	assert.False(t, ok)
+}
+
+func TestActiveGait(t *testing.T) {
+	s := &State{Gaits: testRegistry(t, slow, fast, high)}
+
+	// Without a gait, the index is used.
+	g, ok := s.ActiveGait()
+	assert.True(t, ok)
+	assert.Equal(t, slow, g)
+
+	s.GaitIndex = 4
+	g, _ = s.ActiveGait()
+	assert.Equal(t, fast, g)
+
+	// Setting the gait updates the index to match.
+	s.SetGait(high)
+	assert.Equal(t, 2, s.GaitIndex)
+	g, _ = s.ActiveGait()
+	assert.Equal(t, high, g)
+
+	g, ok = s.NextGait(nil)
+	assert.True(t, ok)
+	assert.Equal(t, slow, g)
+
+	// With no registry, the default is used.
+	_, ok = (&State{}).ActiveGait()
+	assert.Equal(t, DefaultGaits.Len() > 0, ok)
+}
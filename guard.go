@@ -0,0 +1,95 @@
+package hexapod
+
+import (
+	"expvar"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/adammck/hexapod/math3d"
+)
+
+// The number of times that the commands have been replaced with a hold, since
+// some component poisoned them with NaN or Inf.
+var expPoisoned = expvar.NewInt("hexapod.poisoned_commands")
+
+// poisoned returns the name of the first field of the commands which is NaN or
+// Inf, or the empty string if they're all fine. Only the floats which the legs
+// and head act on are checked. This is done after every component, so doesn't
+// use reflection or allocate.
+func (c *Commands) poisoned() string {
+	if f := poisonedVector(&c.Offset); f != "" {
+		return "Offset." + f
+	}
+
+	if bad(c.OffsetHeading) {
+		return "OffsetHeading"
+	}
+
+	if f := poisonedPose(&c.Target); f != "" {
+		return "Target." + f
+	}
+
+	if c.LookAt != nil {
+		if f := poisonedVector(c.LookAt); f != "" {
+			return "LookAt." + f
+		}
+	}
+
+	return ""
+}
+
+func poisonedPose(p *math3d.Pose) string {
+	if f := poisonedVector(&p.Position); f != "" {
+		return "Position." + f
+	}
+
+	switch {
+	case bad(p.Heading):
+		return "Heading"
+	case bad(p.Pitch):
+		return "Pitch"
+	case bad(p.Bank):
+		return "Bank"
+	}
+
+	return ""
+}
+
+func poisonedVector(v *math3d.Vector3) string {
+	switch {
+	case bad(v.X):
+		return "X"
+	case bad(v.Y):
+		return "Y"
+	case bad(v.Z):
+		return "Z"
+	}
+
+	return ""
+}
+
+func bad(f float64) bool {
+	return math.IsNaN(f) || math.IsInf(f, 0)
+}
+
+// guardCommands checks the commands after the given component has ticked, and
+// if it poisoned them, replaces them with a hold at the current pose, so the
+// legs never see the NaN. Whatever the component was trying to do is lost,
+// but a hex standing still is better than servos slamming into their limits.
+func (h *Hexapod) guardCommands(now time.Time, c Component) {
+	f := h.State.Commands.poisoned()
+	if f == "" {
+		return
+	}
+
+	h.healthOf(c, now).poisoned += 1
+	expPoisoned.Add(1)
+
+	log.RateLimited(fmt.Sprintf("poisoned-%T", c), time.Second).Errorf("%T set State.%s to a non-finite value; holding at %v", c, f, h.State.Pose)
+
+	h.State.Target = h.State.Pose
+	h.State.Offset = math3d.Vector3{}
+	h.State.OffsetHeading = 0
+	h.State.LookAt = nil
+}
@@ -0,0 +1,82 @@
+package hexapod
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/adammck/hexapod/math3d"
+	"github.com/stretchr/testify/assert"
+)
+
+// seeingComponent records the commands it sees on every tick, like the legs.
+type seeingComponent struct {
+	seen []Commands
+}
+
+func (c *seeingComponent) Boot() error {
+	return nil
+}
+
+func (c *seeingComponent) Tick(now time.Time, state *State) error {
+	c.seen = append(c.seen, state.Copy().Commands)
+	return nil
+}
+
+func TestPoisoned(t *testing.T) {
+	nan := math.NaN()
+	inf := math.Inf(1)
+
+	for want, f := range map[string]func(c *Commands){
+		"":                  func(c *Commands) {},
+		"Offset.X":          func(c *Commands) { c.Offset.X = nan },
+		"OffsetHeading":     func(c *Commands) { c.OffsetHeading = inf },
+		"Target.Position.Y": func(c *Commands) { c.Target.Position.Y = inf },
+		"Target.Heading":    func(c *Commands) { c.Target.Heading = nan },
+		"Target.Bank":       func(c *Commands) { c.Target.Bank = -inf },
+		"LookAt.Z":          func(c *Commands) { c.LookAt = &math3d.Vector3{Z: nan} },
+	} {
+		c := Commands{Target: math3d.Pose{Position: math3d.Vector3{Y: 40}}}
+		f(&c)
+		assert.Equal(t, want, c.poisoned())
+	}
+}
+
+func TestPoisonedCommandsAreHeld(t *testing.T) {
+	h := newTestHexapod()
+	h.State.Pose = math3d.Pose{Position: math3d.Vector3{X: 10, Y: 40}, Heading: 90}
+
+	// Same order as main: the legs tick before the controller, so see what it
+	// did on the previous tick.
+	legs := &seeingComponent{}
+	ahead := h.State.Pose.Add(math3d.Pose{Position: math3d.Vector3{Z: 1}})
+	ticks := 0
+	ctrl := &writingComponent{Commander, func(s *State) {
+		ticks += 1
+		s.Target = ahead
+		s.Offset = math3d.Vector3{X: 2}
+		if ticks == 3 {
+			s.Target.Position.Z = math.NaN()
+		}
+	}}
+	h.Add(legs)
+	h.Add(ctrl)
+
+	before := expPoisoned.Value()
+	assert.NoError(t, tickN(t, h, 5))
+
+	// The legs never saw the NaN; they saw a hold at the pose instead.
+	assert.Len(t, legs.seen, 5)
+	for i, c := range legs.seen {
+		assert.Equal(t, "", c.poisoned(), "tick %d", i)
+	}
+	assert.Equal(t, h.State.Pose, legs.seen[3].Target)
+	assert.Equal(t, math3d.Vector3{}, legs.seen[3].Offset)
+	assert.Equal(t, ahead, legs.seen[4].Target)
+
+	// The component which poisoned them is blamed.
+	assert.Equal(t, int64(1), expPoisoned.Value()-before)
+	assert.Equal(t, 0, h.Health()[0].Poisoned)
+	assert.Equal(t, 1, h.Health()[1].Poisoned)
+	assert.False(t, h.Failed(ctrl))
+}
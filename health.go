@@ -0,0 +1,202 @@
+package hexapod
+
+import (
+	"fmt"
+	"runtime/debug"
+	"time"
+)
+
+const (
+
+	// The default for Hexapod.HealthWindow: how long a component can go
+	// without a successful tick before it's declared unhealthy.
+	DefaultHealthWindow = 2 * time.Second
+
+	// The default for Hexapod.MaxRestarts.
+	DefaultMaxRestarts = 3
+)
+
+// Blocking is an optional interface for components whose Tick might block on
+// I/O (e.g. reading from a servo). They're ticked in a goroutine, on a copy of
+// the state, and the loop only waits for the given timeout. Changes to the
+// state are only kept if the tick returns in time; if it doesn't, the component
+// is skipped until that tick finally returns.
+//
+// The network is locked by the loop while waiting, but not after the timeout,
+// so a tick which returns late mustn't touch it.
+type Blocking interface {
+	TickTimeout() time.Duration
+}
+
+// Restartable is a Shutdowner which can be booted again. If one becomes
+// unhealthy, it's restarted (by calling Shutdown, then Boot) up to
+// Hexapod.MaxRestarts times, before being disabled. Boot must cope with being
+// called more than once, e.g. by not registering params again. If the component
+// is Blocking, Shutdown may be called while a tick which timed out is still
+// running.
+//
+// Only Blocking components can become unhealthy without being disabled (since
+// others which panic are disabled straight away), so only they're restarted.
+// Other Shutdowners are only shut down once, when Run returns.
+type Restartable interface {
+	Shutdowner
+}
+
+// health is what the loop knows about how a single component is doing.
+type health struct {
+
+	// The time (passed to Tick) of the last tick which returned without error,
+	// panic, or timing out. This is the time of the first tick until then.
+	lastOK time.Time
+
+	// Whether the component hasn't succeeded within the window, as of the most
+	// recent tick.
+	unhealthy bool
+
+	// The number of times the component has been restarted.
+	restarts int
+
+	// The number of ticks after which the commands had to be replaced, since
+	// the component left NaN or Inf in them. See guardCommands.
+	poisoned int
+
+	// If a Blocking component's tick timed out, the channel which its result
+	// will (eventually) be sent to.
+	pending chan tickResult
+}
+
+// tickResult is the outcome of ticking a Blocking component in a goroutine.
+type tickResult struct {
+	err error
+
+	// If the tick panicked, what with, and where.
+	panicked bool
+	r        interface{}
+	stack    []byte
+}
+
+func (h *Hexapod) healthOf(c Component, now time.Time) *health {
+	hs, ok := h.health[c]
+	if !ok {
+		hs = &health{lastOK: now}
+		h.health[c] = hs
+	}
+
+	return hs
+}
+
+// tickBlocking ticks a Blocking component in a goroutine, and waits up to its
+// timeout for the result. Timing out isn't an error; it just doesn't count as
+// a success.
+func (h *Hexapod) tickBlocking(now time.Time, c Component, timeout time.Duration) (bool, error) {
+	hs := h.healthOf(c, now)
+
+	// Skip the component until its previous tick returns. Whatever it did to
+	// its copy of the state is too late to keep.
+	if hs.pending != nil {
+		select {
+		case res := <-hs.pending:
+			hs.pending = nil
+			if res.panicked {
+				return false, h.recovered(c, res.r, res.stack)
+			}
+		default:
+			return false, nil
+		}
+	}
+
+	s := h.State.Copy()
+	ch := make(chan tickResult, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				ch <- tickResult{panicked: true, r: r, stack: debug.Stack()}
+			}
+		}()
+
+		ch <- tickResult{err: c.Tick(now, &s)}
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case res := <-ch:
+		if res.panicked {
+			return false, h.recovered(c, res.r, res.stack)
+		}
+
+		if res.err != nil {
+			return false, fmt.Errorf("%T.Tick returned error: %v", c, res.err)
+		}
+
+		*h.State = s
+		delete(h.panics, c)
+		return true, nil
+
+	case <-timer.C:
+		log.RateLimited(fmt.Sprintf("timeout-%T", c), 5*time.Second).Warnf("%T.Tick took longer than %s, skipping it", c, timeout)
+		hs.pending = ch
+		return false, nil
+	}
+}
+
+// checkHealth records whether the component's tick succeeded, and deals with
+// it if it hasn't for too long. Essential components request a shutdown, since
+// the hex can't carry on safely without them. Others are restarted if they can
+// be, or disabled.
+func (h *Hexapod) checkHealth(now time.Time, c Component, ok bool) {
+	hs := h.healthOf(c, now)
+	if ok {
+		hs.lastOK = now
+		hs.unhealthy = false
+		return
+	}
+
+	if now.Sub(hs.lastOK) <= h.HealthWindow {
+		return
+	}
+
+	if !hs.unhealthy {
+		log.Errorf("%T hasn't ticked successfully for %s", c, now.Sub(hs.lastOK))
+		hs.unhealthy = true
+		h.State.Dump = true
+		h.publish(now, EventComponentUnhealthy, Critical, fmt.Sprintf("%T", c))
+	}
+
+	if isEssential(c) {
+		if !h.State.Shutdown {
+			log.Errorf("essential component %T is unhealthy, requesting shutdown", c)
+			h.State.Shutdown = true
+		}
+
+		return
+	}
+
+	if r, ok := c.(Restartable); ok && hs.restarts < h.MaxRestarts {
+		hs.restarts += 1
+		log.Warnf("restarting %T (%d/%d)", c, hs.restarts, h.MaxRestarts)
+
+		err := h.restart(c, r)
+		if err == nil {
+			hs.lastOK = now
+			hs.unhealthy = false
+			return
+		}
+
+		log.Errorf("%s (while restarting %T)", err, c)
+	}
+
+	log.Errorf("disabling %T", c)
+	h.failed[c] = true
+}
+
+// restart shuts the component down, and boots it again.
+func (h *Hexapod) restart(c Component, r Restartable) error {
+	err := r.Shutdown()
+	if err != nil {
+		log.Warnf("%s (while shutting down %T)", err, c)
+	}
+
+	return c.Boot()
+}
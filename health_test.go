@@ -0,0 +1,239 @@
+package hexapod
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// wedgingComponent is a Blocking component whose Tick blocks (as if waiting on
+// I/O) while it's wedged. If fix is set, restarting it unwedges it.
+type wedgingComponent struct {
+	sync.Mutex
+	wedge chan struct{}
+	fix   bool
+
+	ticks     int
+	boots     int
+	shutdowns int
+}
+
+func newWedging(fix bool) *wedgingComponent {
+	return &wedgingComponent{wedge: make(chan struct{}), fix: fix}
+}
+
+func (c *wedgingComponent) Boot() error {
+	c.Lock()
+	defer c.Unlock()
+	c.boots += 1
+	return nil
+}
+
+func (c *wedgingComponent) Shutdown() error {
+	c.Lock()
+	defer c.Unlock()
+	c.shutdowns += 1
+	if c.fix {
+		c.unwedge()
+	}
+	return nil
+}
+
+func (c *wedgingComponent) TickTimeout() time.Duration {
+	return 5 * time.Millisecond
+}
+
+func (c *wedgingComponent) Tick(now time.Time, state *State) error {
+	c.Lock()
+	c.ticks += 1
+	w := c.wedge
+	c.Unlock()
+
+	state.Speed = 99
+	if w != nil {
+		<-w
+	}
+
+	return nil
+}
+
+// unwedge releases the blocked tick (if any), and lets later ticks return right
+// away. The lock must be held.
+func (c *wedgingComponent) unwedge() {
+	if c.wedge != nil {
+		close(c.wedge)
+		c.wedge = nil
+	}
+}
+
+func (c *wedgingComponent) release() {
+	c.Lock()
+	defer c.Unlock()
+	c.unwedge()
+}
+
+func (c *wedgingComponent) counts() (int, int, int) {
+	c.Lock()
+	defer c.Unlock()
+	return c.ticks, c.boots, c.shutdowns
+}
+
+// essentialWedging is a wedging component which the hex can't do without.
+type essentialWedging struct {
+	*wedgingComponent
+}
+
+func (c essentialWedging) Essential() bool {
+	return true
+}
+
+// notRestartable hides Shutdown, so the component can only be disabled.
+type notRestartable struct {
+	Component
+	Blocking
+}
+
+func newHealthHexapod(window time.Duration, restarts int) *Hexapod {
+	h := newTestHexapod()
+	h.HealthWindow = window
+	h.MaxRestarts = restarts
+	return h
+}
+
+// waitFor ticks (once per frame, starting at the given time) until the wedged
+// tick which is pending has returned, and been reaped.
+func waitFor(t *testing.T, h *Hexapod, c Component, start time.Time) {
+	for i := 0; i < 100; i++ {
+		assert.NoError(t, h.Tick(start.Add(time.Duration(i)*(time.Second/60))))
+		if hs := h.health[c]; hs == nil || hs.pending == nil {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	t.Fatal("pending tick never returned")
+}
+
+func TestBlockingTimeout(t *testing.T) {
+	h := newHealthHexapod(time.Minute, 0)
+	c := newWedging(false)
+	other := &fakeComponent{}
+	h.Add(c)
+	h.Add(other)
+
+	// The wedged tick doesn't stall the loop, and its change to the state
+	// isn't kept.
+	start := time.Now()
+	assert.NoError(t, tickN(t, h, 1))
+	assert.True(t, time.Since(start) < time.Second)
+	assert.Equal(t, 0, h.State.Speed)
+	assert.Equal(t, 1, other.ticks)
+
+	// It isn't ticked again while that tick is still running, but the others
+	// are.
+	assert.NoError(t, tickN(t, h, 5))
+	ticks, _, _ := c.counts()
+	assert.Equal(t, 1, ticks)
+	assert.Equal(t, 6, other.ticks)
+
+	// Once it returns, it's ticked as normal, and its changes are kept.
+	c.release()
+	waitFor(t, h, c, time.Now())
+	ticks, _, _ = c.counts()
+	assert.Equal(t, 2, ticks)
+	assert.Equal(t, 99, h.State.Speed)
+	assert.False(t, h.Failed(c))
+	assert.Equal(t, ComponentHealth{Name: "*hexapod.wedgingComponent"}, h.Health()[0])
+}
+
+func TestUnhealthyComponentIsRestarted(t *testing.T) {
+	h := newHealthHexapod(100*time.Millisecond, 3)
+	c := newWedging(true)
+	h.Add(c)
+
+	// Nothing happens within the window.
+	start := time.Now()
+	for i := 0; i < 6; i++ {
+		assert.NoError(t, h.Tick(start.Add(time.Duration(i)*(time.Second/60))))
+	}
+	_, _, shutdowns := c.counts()
+	assert.Equal(t, 0, shutdowns)
+
+	// Once it passes, the component is shut down (which unwedges it) and
+	// booted again.
+	assert.NoError(t, h.Tick(start.Add(7*(time.Second/60))))
+	_, boots, shutdowns := c.counts()
+	assert.Equal(t, 1, shutdowns)
+	assert.Equal(t, 1, boots)
+	assert.True(t, h.State.Dump)
+
+	if ev := h.RecentEvents(); assert.Len(t, ev, 1) {
+		assert.Equal(t, EventComponentUnhealthy, ev[0].Name)
+		assert.Equal(t, "hexapod", ev[0].Source)
+		assert.Equal(t, "*hexapod.wedgingComponent", ev[0].Payload)
+	}
+
+	// After which it's healthy again.
+	waitFor(t, h, c, start.Add(8*(time.Second/60)))
+	assert.NoError(t, h.Tick(start.Add(time.Second)))
+	assert.False(t, h.Failed(c))
+	assert.Equal(t, ComponentHealth{Name: "*hexapod.wedgingComponent", Restarts: 1}, h.Health()[0])
+}
+
+func TestUnhealthyComponentGivesUp(t *testing.T) {
+	h := newHealthHexapod(100*time.Millisecond, 2)
+	c := newWedging(false)
+	defer c.release()
+	h.Add(c)
+
+	// Restarting doesn't help, so after two restarts (one per window), the
+	// component is disabled.
+	assert.NoError(t, tickN(t, h, 30))
+	_, boots, shutdowns := c.counts()
+	assert.Equal(t, 2, shutdowns)
+	assert.Equal(t, 2, boots)
+	assert.True(t, h.Failed(c))
+	assert.False(t, h.State.Shutdown)
+	assert.Equal(t, ComponentHealth{Name: "*hexapod.wedgingComponent", Failed: true, Unhealthy: true, Restarts: 2}, h.Health()[0])
+}
+
+func TestUnhealthyComponentIsDisabled(t *testing.T) {
+	h := newHealthHexapod(100*time.Millisecond, 3)
+	w := newWedging(false)
+	defer w.release()
+	c := notRestartable{w, w}
+	h.Add(c)
+
+	assert.NoError(t, tickN(t, h, 6))
+	assert.False(t, h.Failed(c))
+
+	assert.NoError(t, tickN(t, h, 10))
+	assert.True(t, h.Failed(c))
+	assert.True(t, h.State.Dump)
+	assert.False(t, h.State.Shutdown)
+
+	_, _, shutdowns := w.counts()
+	assert.Equal(t, 0, shutdowns)
+}
+
+func TestUnhealthyEssentialRequestsShutdown(t *testing.T) {
+	h := newHealthHexapod(100*time.Millisecond, 3)
+	w := newWedging(true)
+	c := essentialWedging{w}
+	h.Add(c)
+
+	assert.NoError(t, tickN(t, h, 6))
+	assert.False(t, h.State.Shutdown)
+
+	// It's neither restarted nor disabled, so it can sit down if it recovers.
+	assert.NoError(t, tickN(t, h, 10))
+	assert.True(t, h.State.Shutdown)
+	assert.False(t, h.Failed(c))
+
+	_, _, shutdowns := w.counts()
+	assert.Equal(t, 0, shutdowns)
+	assert.Equal(t, ComponentHealth{Name: "hexapod.essentialWedging", Essential: true, Unhealthy: true}, h.Health()[0])
+	w.release()
+}
@@ -2,67 +2,326 @@ package hexapod
 
 import (
 	"fmt"
-	"net/http"
+	"runtime/debug"
 	"time"
 
-	"github.com/Sirupsen/logrus"
 	"github.com/adammck/dynamixel/iface"
 	"github.com/adammck/dynamixel/network"
 	proto1 "github.com/adammck/dynamixel/protocol/v1"
 	"github.com/adammck/hexapod/math3d"
+	"github.com/adammck/hexapod/params"
 	"github.com/adammck/hexapod/utils"
 )
 
-type State struct {
+// CalibrationRequest is an action for the calibration wizard. See State.
+type CalibrationRequest int
 
-	// The approximate number of frames per second which the main loop is
-	// currently running at. This can vary quite a bit depending on the load.
-	FPS int
+const (
+	CalibrationNone CalibrationRequest = iota
 
-	// Components can set this to true to indicate that the hex should shut
-	// down. Components which need to clean up before being terminated (e.g.
-	// powering off servos) should check this value frequently.
-	Shutdown bool
+	// Start the wizard. Ignored unless the hex is parked.
+	CalibrationStart
 
-	// The actual pose at the origin, in the world coordinate space. This should
-	// be updated as accurately as possible as the hex walks around.
-	Pose math3d.Pose
+	// Capture the angles of the active leg as its offsets, or skip it.
+	CalibrationCapture
+	CalibrationSkip
+)
+
+// HomeRequest is an action for the navigator's home pose. See State.
+type HomeRequest int
+
+const (
+	HomeNone HomeRequest = iota
+
+	// Remember the current pose as home.
+	HomeSet
+
+	// Walk back to the home pose, if there is one.
+	HomeReturn
+)
+
+// GaitStepRequest is an action for stepping through the gait, for debugging foot
+// placement. See State.
+type GaitStepRequest int
+
+const (
+	GaitStepNone GaitStepRequest = iota
+
+	// Freeze the gait where it is, or carry on walking.
+	GaitPause
+	GaitResume
+
+	// Play the paused gait up to the next phase boundary (i.e. the end of the
+	// current step), or the end of the current cycle, and pause again.
+	GaitStepPhase
+	GaitStepCycle
+)
+
+// gaitStepNames are the names of the requests, as typed into the console.
+var gaitStepNames = map[GaitStepRequest]string{
+	GaitStepNone:  "none",
+	GaitPause:     "pause",
+	GaitResume:    "resume",
+	GaitStepPhase: "step-phase",
+	GaitStepCycle: "step-cycle",
+}
+
+func (r GaitStepRequest) String() string {
+	if s, ok := gaitStepNames[r]; ok {
+		return s
+	}
+
+	return fmt.Sprintf("gait_step(%d)", int(r))
+}
+
+// ParseGaitStep returns the request with the given name, or false if there's no
+// such request. None isn't a request, so isn't parsed.
+func ParseGaitStep(name string) (GaitStepRequest, bool) {
+	for r, s := range gaitStepNames {
+		if s == name && r != GaitStepNone {
+			return r, true
+		}
+	}
+
+	return GaitStepNone, false
+}
+
+// Gesture is a little movement of the head, which temporarily overrides
+// where it's looking. See State.
+type Gesture int
+
+const (
+	GestureNone Gesture = iota
+
+	// Tilt the head down and up, as if to say yes.
+	GestureNod
+
+	// Pan the head left and right, as if to say no.
+	GestureShake
+)
+
+func (g Gesture) String() string {
+	switch g {
+	case GestureNone:
+		return "none"
+	case GestureNod:
+		return "nod"
+	case GestureShake:
+		return "shake"
+	}
+
+	return fmt.Sprintf("gesture(%d)", int(g))
+}
+
+// SelfTestResult is how a subsystem did in the most recent self-test.
+type SelfTestResult int
+
+const (
+	SelfTestNotRun SelfTestResult = iota
+	SelfTestPassed
+	SelfTestFailed
+
+	// The subsystem isn't there to be tested, e.g. there's no IMU.
+	SelfTestSkipped
+)
+
+func (r SelfTestResult) String() string {
+	switch r {
+	case SelfTestNotRun:
+		return "not run"
+	case SelfTestPassed:
+		return "passed"
+	case SelfTestFailed:
+		return "failed"
+	case SelfTestSkipped:
+		return "skipped"
+	}
+
+	return fmt.Sprintf("result(%d)", int(r))
+}
+
+func (r SelfTestResult) MarshalText() ([]byte, error) {
+	return []byte(r.String()), nil
+}
+
+// SelfTest is the result of the most recent self-test (or the one in progress)
+// for each subsystem. See the selftest component.
+type SelfTest struct {
+	Servos     SelfTestResult `json:"servos"`
+	Battery    SelfTestResult `json:"battery"`
+	Controller SelfTestResult `json:"controller"`
+	IMU        SelfTestResult `json:"imu"`
+	Joints     SelfTestResult `json:"joints"`
+}
 
-	// The offset from the actual home position which the feet should be
-	// positioned at.
-	Offset math3d.Vector3
+// Failed returns the names of the subsystems which failed, in the order above.
+func (t SelfTest) Failed() []string {
+	var out []string
+	for _, r := range []struct {
+		name   string
+		result SelfTestResult
+	}{
+		{"servos", t.Servos},
+		{"battery", t.Battery},
+		{"controller", t.Controller},
+		{"imu", t.IMU},
+		{"joints", t.Joints},
+	} {
+		if r.result == SelfTestFailed {
+			out = append(out, r.name)
+		}
+	}
+
+	return out
+}
+
+// Situation is which way up the startup check found the hex, before the legs
+// stood it up. See State.
+type Situation int
+
+const (
+	SituationUnknown Situation = iota
+
+	// On its feet (or its belly), the right way up, and still.
+	SituationUpright
+
+	// The wrong way up, or on its side, somewhere in between.
+	SituationOnBack
+	SituationOnSide
+
+	// Being carried around, or held up off the ground.
+	SituationInAir
+)
+
+func (s Situation) String() string {
+	switch s {
+	case SituationUnknown:
+		return "unknown"
+	case SituationUpright:
+		return "upright"
+	case SituationOnBack:
+		return "on its back"
+	case SituationOnSide:
+		return "on its side"
+	case SituationInAir:
+		return "in the air"
+	}
+
+	return fmt.Sprintf("situation(%d)", int(s))
+}
+
+func (s Situation) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// Transport is how far the transport component is through folding the legs up
+// for carrying, or unfolding them again. See State.Transport.
+type Transport int
 
-	// The target pose of the origin, in the world space. This can be set to
-	// instruct the legs to walk towards an arbitrary point, and the chassis to
-	// orient itself strangely.
-	Target math3d.Pose
+const (
+	TransportNone Transport = iota
 
-	// The point to aim the head (camera) at, in the world space. This is a
-	// pointer so it can be set to nil if there is no target.
-	LookAt *math3d.Vector3
+	// Lowering the chassis to the ground, before folding. The legs are still
+	// in charge of the servos.
+	TransportLowering
 
-	// The index of the gait which should be used, mod however many gaits are
-	// available. (This doesn't really belong here, but is the simplest way to
-	// pass the selection from the controller to the chassis and I am lazy.)
-	GaitIndex int
+	// Folding the legs up, one at a time, and then with the torque off.
+	TransportFolding
+	TransportFolded
 
-	// The increase (or decrease, if negative) from the default speed at which
-	// we should walk. There is no unit; more is just faster.
-	Speed int
+	// Woken up, and waiting for the startup check to say that the hex is on
+	// its belly before unfolding the legs, and handing them back.
+	TransportWaking
+	TransportUnfolding
+)
+
+// OwnsLegs returns whether the transport component has the servos, so the legs
+// must leave them alone.
+func (t Transport) OwnsLegs() bool {
+	return t >= TransportFolding
 }
 
+func (t Transport) String() string {
+	switch t {
+	case TransportNone:
+		return "none"
+	case TransportLowering:
+		return "lowering"
+	case TransportFolding:
+		return "folding"
+	case TransportFolded:
+		return "folded"
+	case TransportWaking:
+		return "waking"
+	case TransportUnfolding:
+		return "unfolding"
+	}
+
+	return fmt.Sprintf("transport(%d)", int(t))
+}
+
+func (t Transport) MarshalText() ([]byte, error) {
+	return []byte(t.String()), nil
+}
+
+// Input is a compact copy of the state of the controller.
+type Input struct {
+	LeftX   int
+	LeftY   int
+	RightX  int
+	RightY  int
+	L2      int
+	R2      int
+	Buttons uint16
+}
+
+// InputDeadzone is how far (out of 127) a stick or trigger can be from neutral
+// before it counts as being used, since they never quite settle at zero.
+const InputDeadzone = 10
+
+// Walking returns true if the controller is being used to walk, i.e. the left
+// stick or either trigger is. The components which walk on their own give way
+// to it.
+func (in Input) Walking() bool {
+	for _, v := range [4]int{in.LeftX, in.LeftY, in.L2, in.R2} {
+		if v > InputDeadzone || v < -InputDeadzone {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Bits of Input.Buttons. The pressure-sensitive buttons are set when pressed
+// past the same threshold as the controller uses.
+const (
+	ButtonSelect uint16 = 1 << iota
+	ButtonStart
+	ButtonPS
+	ButtonUp
+	ButtonDown
+	ButtonLeft
+	ButtonRight
+	ButtonL1
+	ButtonR1
+	ButtonTriangle
+	ButtonCircle
+	ButtonCross
+	ButtonSquare
+)
+
 // World returns a matrix to transform a vector in the coordinate space defined
 // by the Position and Rotation attributes into the world space.
 // TODO: Remove this method.
 func (s *State) World() math3d.Matrix44 {
-	return s.Pose.Add(math3d.Pose{s.Offset, 0, 0, 0}).ToWorld()
+	return s.Pose.Add(math3d.Pose{s.Offset, s.OffsetHeading, 0, 0}).ToWorld()
 }
 
 // Local returns a matrix to transform a vector in the world coordinate space
 // into the space defined by the state (using the Position and Rotation attrs).
 // TODO: Remove this method.
 func (s *State) Local() math3d.Matrix44 {
-	return s.Pose.Add(math3d.Pose{s.Offset, 0, 0, 0}).ToLocal()
+	return s.Pose.Add(math3d.Pose{s.Offset, s.OffsetHeading, 0, 0}).ToLocal()
 }
 
 type Hexapod struct {
@@ -82,19 +341,116 @@ type Hexapod struct {
 	// The FPS which the main loop should try to run at.
 	TargetFPS int
 
+	// How long Run keeps ticking after a shutdown is requested, before shutting
+	// the components down.
+	ShutdownGrace time.Duration
+
+	// Params which can be adjusted at runtime. Pending writes are applied at
+	// the start of each tick, so never race with the components.
+	Params *params.Registry
+
 	// To count the number of times that Tick is called each second.
 	fc *utils.FrameCounter
 
 	// The time at which an FPS warning was last logged. To avoid flooding the
 	// logs if we're running too slowly.
 	prevWarnFPS time.Time
+
+	// Components which have panicked during Tick, and have been disabled as a
+	// result. Essential components are never disabled.
+	failed map[Component]bool
+
+	// The number of consecutive ticks during which each essential component has
+	// panicked. We give up once this passes maxEssentialPanics.
+	panics map[Component]int
+
+	// How long recent ticks took. See LoopStats.
+	stats *loopStats
+
+	// How long a component can go without ticking successfully before it's
+	// declared unhealthy, and how many times a Restartable component will be
+	// restarted before being disabled. See checkHealth.
+	HealthWindow time.Duration
+	MaxRestarts  int
+
+	// The health of each component, as of its last tick.
+	health map[Component]*health
+
+	// How long an Autonomous component must count down for before it can move
+	// the hex, and how long each source must, by name, if it's different. Zero
+	// (the default) lets them move straight away.
+	Countdown  time.Duration
+	Countdowns map[string]time.Duration
+
+	// The countdown of each autonomous component. See gate.
+	countdowns map[Autonomous]*countdown
+
+	// If true, panic if a component writes to a section of the state which it
+	// doesn't declare that it writes. This copies the state before each tick,
+	// so is meant for tests. See StateWriter.
+	Strict bool
+
+	// If not nil, log what each component changes in the state during its
+	// tick. This is for debugging, so is nil by default.
+	StateDiff *StateDiff
+
+	// If not nil, record what each source changes in the commands during its
+	// tick. See Source.
+	Journal *Journal
+
+	// Events published during the last tick, and the most recent ones.
+	events *eventBus
+
+	// A copy of the state from before the current component's tick, for the
+	// above, and of the commands, for the journal. They're kept here, rather
+	// than on the stack, since they would escape.
+	before   State
+	commands Commands
 }
 
+// Component is the extension point of the hexapod: everything it does, from
+// reading the controller to moving the legs, is a component. Boot is called
+// once by Run (or Boot), in the order they were registered, and then Tick every
+// frame, in the same order, with the time and the state. Returning an error
+// from either stops the loop, so prefer logging a warning for anything which
+// isn't fatal.
+//
+// The state belongs to the loop. It may only be read or written during Tick,
+// from the goroutine which called it, and mustn't be kept after Tick returns;
+// anything which runs in the background (e.g. an HTTP handler) should copy
+// what it needs during Tick, under its own lock, or change things via Params.
+// Components may write the fields directly on the state, and only the sections
+// which they declare (see StateWriter). Components which were registered
+// before see the changes on the same tick; those after, on the next.
+//
+// Components may also implement any of the optional interfaces: Essential,
+// StateWriter, Blocking, Restartable, Shutdowner, Source, and Autonomous.
 type Component interface {
 	Boot() error
 	Tick(time.Time, *State) error
 }
 
+// Essential is an optional interface which components can implement to declare
+// that the hexapod can't safely function without them. If an essential
+// component panics, it isn't disabled (like other components), but a shutdown
+// is requested, so it can hopefully sit down before we exit.
+type Essential interface {
+	Essential() bool
+}
+
+const (
+
+	// The number of consecutive panics to tolerate from an essential component
+	// while trying to shut down, before giving up and returning an error.
+	maxEssentialPanics = 3
+
+	// The range of speeds which can be set via the hexapod.speed param. The
+	// legs clamp the step duration outside of this range, so there's no point
+	// going any further.
+	MinSpeed = -30
+	MaxSpeed = 8
+)
+
 // NewHexapod creates a new Hexapod object on the given Dynamixel network.
 func NewHexapod(network *network.Network, targetFPS int) *Hexapod {
 	return &Hexapod{
@@ -104,34 +460,55 @@ func NewHexapod(network *network.Network, targetFPS int) *Hexapod {
 			proto1.New(network),
 		},
 		State: &State{
-			FPS: 0,
-			Pose: math3d.Pose{
-				Position: math3d.ZeroVector3,
-				Heading:  0,
+			FPS:   0,
+			Gaits: DefaultGaits,
+			Commands: Commands{
+				Offset: math3d.Vector3{0, 0, 0},
+				Target: math3d.Pose{
+					Position: math3d.ZeroVector3,
+					Heading:  0,
+				},
+				LookAt:    nil,
+				GaitIndex: 0,
+				Speed:     0,
 			},
-			Offset: math3d.Vector3{0, 0, 0},
-			Target: math3d.Pose{
-				Position: math3d.ZeroVector3,
-				Heading:  0,
+			Estimates: Estimates{
+				Pose: math3d.Pose{
+					Position: math3d.ZeroVector3,
+					Heading:  0,
+				},
 			},
-			LookAt:    nil,
-			GaitIndex: 0,
-			Speed:     0,
 		},
 		TargetFPS: targetFPS,
+		Params:    params.Default,
 		fc:        utils.NewFrameCounter(time.Second),
+		failed:    map[Component]bool{},
+		panics:    map[Component]int{},
+		stats:     &loopStats{},
+
+		HealthWindow:  DefaultHealthWindow,
+		MaxRestarts:   DefaultMaxRestarts,
+		ShutdownGrace: DefaultShutdownGrace,
+		health:        map[Component]*health{},
+		countdowns:    map[Autonomous]*countdown{},
+		events:        newEventBus(DefaultEventHistory),
 	}
 }
 
-// Add registers a component to receive ticks every frame.
+// Add registers a component to receive ticks every frame. See Register.
 func (h *Hexapod) Add(c Component) {
 	h.Components = append(h.Components, c)
 }
 
-// Boot calls Boot on each component.
+// Boot registers the core params, then calls Boot on each component.
 func (h *Hexapod) Boot() error {
+	err := h.registerParams()
+	if err != nil {
+		return err
+	}
+
 	for _, c := range h.Components {
-		err := c.Boot()
+		err = c.Boot()
 		if err != nil {
 			return err
 		}
@@ -143,9 +520,18 @@ func (h *Hexapod) Boot() error {
 	return nil
 }
 
-var log = logrus.WithFields(logrus.Fields{
-	"pkg": "hex",
-})
+func (h *Hexapod) registerParams() error {
+	return h.Params.Register(params.Param{
+		Name: "hexapod.speed",
+		Type: params.Int,
+		Min:  MinSpeed,
+		Max:  MaxSpeed,
+		Get:  func() float64 { return float64(h.State.Speed) },
+		Set:  func(v float64) { h.State.Speed = int(v) },
+	})
+}
+
+var log = NewLog("hex")
 
 // Tick calls Tick on each component, then sends the ACTION instruction to
 // trigger any buffered instructions.
@@ -156,16 +542,89 @@ func (h *Hexapod) Tick(now time.Time) error {
 	h.Network.Lock()
 	defer h.Network.Unlock()
 
+	// Use the wall clock (rather than now) to time the tick, since now might be
+	// some time in the past, or not real at all.
+	start := time.Now()
+
 	// Update the fps counter.
 	h.fc.Frame(now)
 	h.State.FPS = h.fc.Count()
 
-	// Send Tick to every component.
-	for _, c := range h.Components {
-		err := c.Tick(now, h.State)
+	// Apply any param changes which were requested since the last tick. Some
+	// of them are commands (e.g. the speed), which are journaled as if they
+	// came from a source of their own.
+	if h.Journal != nil {
+		h.Journal.begin()
+		h.commands = h.State.Commands
+	}
+
+	for _, n := range h.Params.Apply() {
+		log.Infof("param changed: %s", n)
+	}
+
+	if h.Journal != nil {
+		h.Journal.record(now, ParamsSource, &h.commands, &h.State.Commands)
+	}
+
+	// Deliver the events from the last tick during this one.
+	h.events.flip()
+
+	// Send Tick to every component, skipping those which have failed.
+	for i, c := range h.Components {
+		if h.failed[c] {
+			continue
+		}
+
+		h.events.deliver(c)
+
+		if h.Strict || h.StateDiff != nil {
+			h.before = h.State.Copy()
+		}
+
+		// Autonomous components are gated by the countdown, which needs to know
+		// where the target was, to put it back.
+		a, autonomous := c.(Autonomous)
+		target := h.State.Target
+
+		// Sources are journaled, which needs to know what the commands were.
+		source, journaled := sourceOf(c)
+		journaled = journaled && h.Journal != nil
+		if journaled {
+			h.commands = h.State.Commands
+		}
+
+		t := time.Now()
+		ok, err := h.tickComponent(now, c)
+		h.stats.component(i, c, time.Since(t))
+		h.events.collect(now, c, h.State)
 		if err != nil {
-			return fmt.Errorf("%T.Tick returned error: %v", c, err)
+			return err
+		}
+
+		if h.Strict {
+			h.checkWrites(c, &h.before)
+		}
+
+		if h.StateDiff != nil {
+			h.StateDiff.log(now, c, &h.before, h.State)
 		}
+
+		if journaled && ok {
+			h.Journal.record(now, source, &h.commands, &h.State.Commands)
+		}
+
+		if autonomous && ok {
+			h.gate(now, a, target)
+		}
+
+		// Catch any NaNs before they reach the legs, which tick first.
+		h.guardCommands(now, c)
+
+		h.checkHealth(now, c, ok)
+	}
+
+	if h.Journal != nil {
+		h.Journal.end(&h.State.Commands)
 	}
 
 	if h.State.FPS < h.TargetFPS {
@@ -176,40 +635,119 @@ func (h *Hexapod) Tick(now time.Time) error {
 	}
 
 	// Trigger any buffered instructions written during this tick.
-	h.ActionInstruction()
+	err := h.ActionInstruction()
+	if err != nil {
+		h.stats.busError()
+		log.RateLimited("action", 5*time.Second).Warnf("%s (while sending ACTION)", err)
+	}
 
+	h.stats.tick(time.Since(start))
 	return nil
 }
 
-func (h *Hexapod) ActionInstruction() error {
-	for i := range h.Protocols {
-		err := h.Protocols[i].Action()
-		if err != nil {
-			return err
+// tickComponent calls Tick on a single component, recovering from any panic,
+// and returns whether it succeeded.
+func (h *Hexapod) tickComponent(now time.Time, c Component) (ok bool, err error) {
+	if b, isBlocking := c.(Blocking); isBlocking {
+		return h.tickBlocking(now, c, b.TickTimeout())
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			ok, err = false, h.recovered(c, r, debug.Stack())
 		}
+	}()
+
+	err = c.Tick(now, h.State)
+	if err != nil {
+		return false, fmt.Errorf("%T.Tick returned error: %v", c, err)
+	}
+
+	delete(h.panics, c)
+	return true, nil
+}
+
+// recovered handles a panic from the given component's Tick. Non-essential
+// components are disabled. Essential components are left running, but we
+// request a shutdown, in the hope that the sit-down works.
+func (h *Hexapod) recovered(c Component, r interface{}, stack []byte) error {
+	log.Errorf("%T.Tick panicked: %v\n%s", c, r, stack)
+
+	// Whatever happens next, we'll want to know what led up to this.
+	h.State.Dump = true
+
+	if !isEssential(c) {
+		log.Errorf("disabling %T", c)
+		h.failed[c] = true
+		return nil
+	}
+
+	h.panics[c] += 1
+	if h.panics[c] > maxEssentialPanics {
+		return fmt.Errorf("%T.Tick panicked %d times: %v", c, h.panics[c], r)
+	}
+
+	if !h.State.Shutdown {
+		log.Errorf("essential component %T panicked, requesting shutdown", c)
+		h.State.Shutdown = true
 	}
 
 	return nil
 }
 
-// TODO: Move this stuff to a separate package.
+// Failed returns true if the given component has been disabled.
+func (h *Hexapod) Failed(c Component) bool {
+	return h.failed[c]
+}
 
-var log2 = logrus.WithFields(logrus.Fields{
-	"pkg": "http",
-})
+// ComponentHealth is a summary of the state of a single component.
+type ComponentHealth struct {
+	Name      string `json:"name"`
+	Essential bool   `json:"essential"`
+	Failed    bool   `json:"failed"`
 
-// Remote starts an HTTP server which can update the configuration. It blocks
-// forever, so start it in a goroutine.
-func (h *Hexapod) RunServer(port int) {
-	indexHTML := ""
+	// Whether the component hasn't ticked successfully within the health
+	// window, and how many times it's been restarted because of that.
+	Unhealthy bool `json:"unhealthy"`
+	Restarts  int  `json:"restarts"`
 
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "text/html; charset=utf-8")
-		fmt.Fprintf(w, "<pre>%s</pre>", indexHTML)
-	})
+	// The number of ticks after which the component had left NaN or Inf in the
+	// commands, which were replaced with a hold.
+	Poisoned int `json:"poisoned"`
+}
+
+// Health returns the health of each component, in the order they were added.
+// Like everything else here, it must only be called from the main loop.
+func (h *Hexapod) Health() []ComponentHealth {
+	out := make([]ComponentHealth, len(h.Components))
+	for i, c := range h.Components {
+		out[i] = ComponentHealth{
+			Name:      fmt.Sprintf("%T", c),
+			Essential: isEssential(c),
+			Failed:    h.failed[c],
+		}
 
-	addr := fmt.Sprintf(":%d", port)
-	log2.Infof("listening on %s", addr)
-	err := http.ListenAndServe(addr, nil)
-	panic(err)
+		if hs, ok := h.health[c]; ok {
+			out[i].Unhealthy = hs.unhealthy
+			out[i].Restarts = hs.restarts
+			out[i].Poisoned = hs.poisoned
+		}
+	}
+	return out
+}
+
+func isEssential(c Component) bool {
+	e, ok := c.(Essential)
+	return ok && e.Essential()
+}
+
+func (h *Hexapod) ActionInstruction() error {
+	for i := range h.Protocols {
+		err := h.Protocols[i].Action()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
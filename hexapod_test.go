@@ -0,0 +1,160 @@
+package hexapod
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/adammck/dynamixel/network"
+	fake_serial "github.com/adammck/hexapod/fake/serial"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeComponent counts its ticks, and panics on the Nth (and every subsequent
+// tick, if sticky is set).
+type fakeComponent struct {
+	panicOn   int
+	sticky    bool
+	essential bool
+	ticks     int
+}
+
+func (c *fakeComponent) Boot() error {
+	return nil
+}
+
+func (c *fakeComponent) Tick(now time.Time, state *State) error {
+	c.ticks += 1
+	if c.panicOn > 0 && (c.ticks == c.panicOn || (c.sticky && c.ticks > c.panicOn)) {
+		panic(fmt.Sprintf("tick %d", c.ticks))
+	}
+
+	return nil
+}
+
+type essentialComponent struct {
+	fakeComponent
+}
+
+func (c *essentialComponent) Essential() bool {
+	return true
+}
+
+func newTestHexapod() *Hexapod {
+	return NewHexapod(network.New(&fake_serial.FakeSerial{}), 60)
+}
+
+// fixture ticks a strict test hex every 20ms, on a fake clock.
+type fixture struct {
+	t   *testing.T
+	h   *Hexapod
+	now time.Time
+}
+
+func newFixture(t *testing.T) *fixture {
+	h := newTestHexapod()
+	h.Strict = true
+	return &fixture{t: t, h: h, now: time.Unix(100, 0)}
+}
+
+// step advances the clock by a tick, and ticks the hex.
+func (f *fixture) step() {
+	f.now = f.now.Add(20 * time.Millisecond)
+	assert.NoError(f.t, f.h.Tick(f.now))
+}
+
+func tickN(t *testing.T, h *Hexapod, n int) error {
+	start := time.Now()
+	for i := 0; i < n; i++ {
+		err := h.Tick(start.Add(time.Duration(i) * (time.Second / 60)))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestNonEssentialPanicDisablesComponent(t *testing.T) {
+	h := newTestHexapod()
+	bad := &fakeComponent{panicOn: 3}
+	good := &fakeComponent{}
+	h.Add(bad)
+	h.Add(good)
+
+	assert.NoError(t, tickN(t, h, 10))
+
+	// The bad component stopped receiving ticks after the panic, but the good
+	// one (after it in the list) kept going.
+	assert.Equal(t, 3, bad.ticks)
+	assert.Equal(t, 10, good.ticks)
+	assert.True(t, h.Failed(bad))
+	assert.False(t, h.Failed(good))
+
+	// A dump was requested, but not a shutdown.
+	assert.True(t, h.State.Dump)
+	assert.False(t, h.State.Shutdown)
+}
+
+func TestEssentialPanicRequestsShutdown(t *testing.T) {
+	h := newTestHexapod()
+	legs := &essentialComponent{fakeComponent{panicOn: 5}}
+	other := &fakeComponent{}
+	h.Add(legs)
+	h.Add(other)
+
+	assert.NoError(t, tickN(t, h, 4))
+	assert.False(t, h.State.Shutdown)
+
+	assert.NoError(t, tickN(t, h, 6))
+	assert.True(t, h.State.Shutdown)
+	assert.True(t, h.State.Dump)
+
+	// The essential component keeps ticking, so it can sit down.
+	assert.False(t, h.Failed(legs))
+	assert.Equal(t, 10, legs.ticks)
+	assert.Equal(t, 10, other.ticks)
+}
+
+func TestEssentialPanicGivesUp(t *testing.T) {
+	h := newTestHexapod()
+	legs := &essentialComponent{fakeComponent{panicOn: 2, sticky: true}}
+	h.Add(legs)
+
+	// Panics on ticks 2, 3, 4 are tolerated; tick 5 is one too many.
+	err := tickN(t, h, 10)
+	assert.Error(t, err)
+	assert.Equal(t, 2+maxEssentialPanics, legs.ticks)
+	assert.True(t, h.State.Shutdown)
+}
+
+// slowComponent sleeps for the given duration on every tick in slow.
+type slowComponent struct {
+	fakeComponent
+	slow map[int]time.Duration
+}
+
+func (c *slowComponent) Tick(now time.Time, state *State) error {
+	c.ticks += 1
+	time.Sleep(c.slow[c.ticks])
+	return nil
+}
+
+func TestLoopStatsOverruns(t *testing.T) {
+	h := newTestHexapod()
+	h.Add(&slowComponent{slow: map[int]time.Duration{2: 20 * time.Millisecond, 5: 30 * time.Millisecond}})
+
+	assert.NoError(t, tickN(t, h, 6))
+	s := h.LoopStats()
+	assert.Equal(t, 6, s.Window)
+	assert.Equal(t, 2, s.Overruns)
+}
+
+func TestInputWalking(t *testing.T) {
+	assert.False(t, Input{}.Walking())
+
+	// A little drift doesn't count, and nor does the right stick.
+	assert.False(t, Input{LeftX: InputDeadzone, L2: -InputDeadzone, RightY: 100}.Walking())
+
+	assert.True(t, Input{LeftY: -InputDeadzone - 1}.Walking())
+	assert.True(t, Input{R2: 50}.Walking())
+}
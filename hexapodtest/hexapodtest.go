@@ -0,0 +1,71 @@
+// Package hexapodtest provides helpers for testing components on their own,
+// without a hexapod: a fake clock to tick them with, and params which stand in
+// for those of other components.
+package hexapodtest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/adammck/hexapod"
+	"github.com/adammck/hexapod/params"
+	"github.com/stretchr/testify/assert"
+)
+
+// Clock ticks components with a state of its own, at a fixed interval, on a
+// fake clock, as the hexapod would. A tick which returns an error fails the
+// test.
+type Clock struct {
+	T        *testing.T
+	State    *hexapod.State
+	Now      time.Time
+	Interval time.Duration
+}
+
+// NewClock returns a clock with an empty state, which ticks at the given
+// interval.
+func NewClock(t *testing.T, interval time.Duration) *Clock {
+	return &Clock{
+		T:        t,
+		State:    &hexapod.State{},
+		Now:      time.Unix(100, 0),
+		Interval: interval,
+	}
+}
+
+// Tick advances the clock by the interval, and ticks the given component.
+func (c *Clock) Tick(comp hexapod.Component) {
+	c.Now = c.Now.Add(c.Interval)
+	assert.NoError(c.T, comp.Tick(c.Now, c.State))
+}
+
+// TickFor ticks the given component until the given duration has passed.
+func (c *Clock) TickFor(comp hexapod.Component, d time.Duration) {
+	for end := c.Now.Add(d); c.Now.Before(end); {
+		c.Tick(comp)
+	}
+}
+
+// Events returns the names of the events which have been published to the
+// state, in order.
+func (c *Clock) Events() []string {
+	var out []string
+	for _, e := range c.State.Published() {
+		out = append(out, e.Name)
+	}
+
+	return out
+}
+
+// Param returns a param of the given type and range, which reads and writes
+// the given value.
+func Param(name string, typ params.Type, min, max float64, v *float64) params.Param {
+	return params.Param{
+		Name: name,
+		Type: typ,
+		Min:  min,
+		Max:  max,
+		Get:  func() float64 { return *v },
+		Set:  func(val float64) { *v = val },
+	}
+}
@@ -0,0 +1,95 @@
+package hexapod
+
+import (
+	"expvar"
+	"os"
+	"strings"
+
+	"github.com/adammck/hexapod/config"
+)
+
+// The identity of the robot, exported via expvar for the diagnostics server, so
+// that metrics scraped from more than one can be labelled with it.
+var expIdentity = expvar.NewMap("hexapod.identity")
+
+// Identity names the robot, so that two of them can be told apart in their
+// logs, telemetry, discovery beacons, etc. It's in the state (see
+// State.Identity) so components don't need their own copy of the config, but
+// is read-only: it's fixed when the hexapod is created.
+type Identity struct {
+
+	// The human-readable name, which can be anything.
+	Name string
+
+	// The ID, which is only lowercase letters, digits, and dashes, so it's safe
+	// to use in MQTT topics, file names, etc.
+	ID string
+
+	// An optional description, e.g. to tell apart two robots with the same
+	// name from different owners.
+	Description string
+}
+
+// NewIdentity returns the identity in the config, with the name defaulting to
+// the hostname (or "hexapod", if that can't be read), and the ID to the name.
+func NewIdentity(cfg config.Identity) Identity {
+	id := Identity{
+		Name:        cfg.Name,
+		ID:          cfg.ID,
+		Description: cfg.Description,
+	}
+
+	if id.Name == "" {
+		h, err := os.Hostname()
+		if err != nil || h == "" {
+			h = "hexapod"
+		}
+		id.Name = h
+	}
+
+	if id.ID == "" {
+		id.ID = slug(id.Name)
+	}
+
+	return id
+}
+
+// slug returns the given name in lowercase, with every run of anything other
+// than letters and digits replaced by a single dash. It's "hexapod" if nothing
+// is left.
+func slug(name string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(name) {
+		if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' {
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			dash = false
+		} else {
+			dash = true
+		}
+	}
+
+	if b.Len() == 0 {
+		return "hexapod"
+	}
+
+	return b.String()
+}
+
+// export publishes the identity via expvar and the logs. It's global (like
+// them), so the last hexapod to be created wins.
+func (id Identity) export() {
+	expIdentity.Set("name", stringVar(id.Name))
+	expIdentity.Set("id", stringVar(id.ID))
+	expIdentity.Set("description", stringVar(id.Description))
+	setLogIdentity(id)
+}
+
+func stringVar(s string) *expvar.String {
+	v := &expvar.String{}
+	v.Set(s)
+	return v
+}
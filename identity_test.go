@@ -0,0 +1,73 @@
+package hexapod
+
+import (
+	"expvar"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/adammck/hexapod/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIdentity(t *testing.T) {
+	buf, restore := captureLogs()
+	defer restore()
+
+	// Created before the hexapod, like the package-level logs are.
+	before := NewLog("test-identity-before")
+
+	cfg := config.Default()
+	cfg.Identity = config.Identity{Name: "Hex Two", ID: "hex-2", Description: "the one with the blue legs"}
+	h := New(nil, cfg)
+	assert.Equal(t, Identity{Name: "Hex Two", ID: "hex-2", Description: "the one with the blue legs"}, h.State.Identity)
+
+	// Every log has the ID, including those created before and after.
+	before.Info("before")
+	NewLog("test-identity-after").Info("after")
+	assert.Contains(t, buf.String(), "msg=before pkg=test-identity-before robot=hex-2")
+	assert.Contains(t, buf.String(), "msg=after pkg=test-identity-after robot=hex-2")
+
+	// And the metrics.
+	assert.Equal(t, `"hex-2"`, expvar.Get("hexapod.identity").(*expvar.Map).Get("id").String())
+	assert.Equal(t, `"Hex Two"`, expvar.Get("hexapod.identity").(*expvar.Map).Get("name").String())
+}
+
+func TestIdentityDefaults(t *testing.T) {
+	host, err := os.Hostname()
+	assert.NoError(t, err)
+	id := NewIdentity(config.Identity{})
+	assert.Equal(t, host, id.Name)
+	assert.Equal(t, slug(host), id.ID)
+
+	for name, want := range map[string]string{
+		"hexapod":          "hexapod",
+		"Hex Two":          "hex-two",
+		"  Büro -- Hex #3": "b-ro-hex-3",
+		"!!!":              "hexapod",
+	} {
+		assert.Equal(t, want, NewIdentity(config.Identity{Name: name}).ID, name)
+	}
+}
+
+// renamer is a component which tries to change the identity.
+type renamer struct{}
+
+func (r *renamer) Boot() error {
+	return nil
+}
+
+func (r *renamer) Tick(now time.Time, state *State) error {
+	state.Identity.Name = "imposter"
+	return nil
+}
+
+func TestIdentityIsReadOnly(t *testing.T) {
+	h := New(nil, config.Default())
+	r := &renamer{}
+	before := *h.State
+	assert.NoError(t, r.Tick(time.Time{}, h.State))
+	assert.PanicsWithValue(t, "*hexapod.renamer wrote to State.Identity, which is read-only", func() {
+		h.checkWrites(r, &before)
+	})
+}
@@ -0,0 +1,76 @@
+package integration
+
+import (
+	"testing"
+	"time"
+
+	"github.com/adammck/dynamixel/network"
+	"github.com/adammck/hexapod"
+	"github.com/adammck/hexapod/components/controller"
+	"github.com/adammck/hexapod/components/legs"
+	"github.com/adammck/hexapod/components/sim"
+	"github.com/adammck/hexapod/config"
+	"github.com/adammck/hexapod/params"
+	"github.com/adammck/sixaxis"
+	"github.com/stretchr/testify/assert"
+)
+
+// The most allocations which a tick (of the legs and controller, while walking)
+// may make, on average. At 60Hz, every one is garbage for the collector to
+// stop the loop for; this leaves room for the few which the servo library
+// makes while sending ACTION, and the logging at the start of each step.
+const allocBudget = 4
+
+// TestAllocs runs the hot path (without the sim component, which reads every
+// servo each tick, which the real hex doesn't) and checks how much it
+// allocates, so changes which make the loop slower don't go unnoticed.
+func TestAllocs(t *testing.T) {
+	cfg := config.Default()
+	bus := sim.NewBus()
+	n := network.New(bus)
+
+	hex := hexapod.NewHexapod(n, fps)
+	hex.Params = params.New()
+
+	l := legs.New(n, cfg.Legs, cfg.Gait)
+	l.Params = hex.Params
+
+	sa := sixaxis.New(nil)
+	c := controller.NewScripted(sa, cfg.Controller, cfg.Head)
+	c.Params = hex.Params
+
+	hex.Add(l)
+	hex.Add(c)
+	assert.NoError(t, hex.Boot())
+
+	now := time.Unix(0, 0)
+	dt := time.Second / fps
+	tick := func() {
+		now = now.Add(dt)
+		bus.Step(dt)
+		assert.NoError(t, hex.Tick(now))
+	}
+
+	deadline := time.Now().Add(readyTimeout)
+	for l.State == "" {
+		if time.Now().After(deadline) {
+			t.Fatalf("legs weren't ready after %s", readyTimeout)
+		}
+
+		tick()
+		time.Sleep(time.Millisecond)
+	}
+
+	// Stand up and start walking, so the gait is made before measuring.
+	sa.LeftStick.Y = -127
+	sa.RightStick.X = 64
+	for i := 0; i < 2*fps; i++ {
+		tick()
+	}
+
+	assert.Equal(t, legs.State("sStepping"), l.State)
+
+	allocs := testing.AllocsPerRun(5*fps, tick)
+	assert.LessOrEqual(t, allocs, float64(allocBudget), "allocations per tick")
+	t.Logf("%0.2f allocations per tick", allocs)
+}
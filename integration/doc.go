@@ -0,0 +1,4 @@
+// Package integration has the end-to-end tests, which run the real controller
+// and legs against simulated servos, from scripted controller input down to
+// the bytes written to the bus. There's nothing here but the tests.
+package integration
@@ -0,0 +1,304 @@
+package integration
+
+import (
+	"math"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/adammck/dynamixel/network"
+	"github.com/adammck/hexapod"
+	"github.com/adammck/hexapod/components/controller"
+	"github.com/adammck/hexapod/components/legs"
+	"github.com/adammck/hexapod/components/sim"
+	"github.com/adammck/hexapod/config"
+	"github.com/adammck/hexapod/math3d"
+	"github.com/adammck/hexapod/params"
+	"github.com/adammck/sixaxis"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	fps = 60
+
+	// The time each packet takes on the simulated bus, which is about right for
+	// a short packet at 1Mbps, with no return delay.
+	latency = 80 * time.Microsecond
+
+	// How long (in real time) to wait for the legs to reach their home
+	// positions before giving up.
+	readyTimeout = 10 * time.Second
+
+	// The height (in mm) above the ground, in the world space, above which a
+	// foot counts as swinging. The gait eases the feet down, so they spend a
+	// few ticks a fraction of a mm above the ground.
+	swingThreshold = 1.0
+
+	// The fewest feet which must be on the ground at once, to stay stable.
+	minStance = 3
+
+	// The address of the goal position in the AX-12 control table.
+	goalPositionAddr = 0x1e
+
+	// The furthest (in mm, in the world space) which a foot can move between
+	// ticks. The fastest swings at the default speed and params move about 10mm,
+	// so this is only exceeded if a foot jumps, e.g. because the step height
+	// changed mid-step.
+	maxFootJump = 20.0
+)
+
+// Input is a scripted change to the controller. At the given (simulated) time
+// after the legs are ready, Set is called to update it. The sixaxis keeps that
+// state until the next Input changes it.
+type Input struct {
+	At  time.Duration
+	Set func(sa *sixaxis.SA)
+}
+
+// Write is a scripted write to the params, via the registry, like the API. At
+// the given (simulated) time after the legs are ready, the values are set.
+type Write struct {
+	At     time.Duration
+	Values map[string]float64
+}
+
+// Scenario is a scripted run of the whole hexapod. Every scenario is run with
+// each gait, and checked against the invariants in run, then Check (if given)
+// is called with the final state for anything specific to the scenario.
+type Scenario struct {
+	Name     string
+	Duration time.Duration
+	Inputs   []Input
+	Writes   []Write
+	Check    func(t *testing.T, start, end hexapod.State)
+
+	// Changes the config, which is otherwise the default, before the hex is
+	// created.
+	Config func(cfg *config.Config)
+
+	// Returns any extra components to add after the controller, which are
+	// created afresh for each run, given the legs and the simulated bus.
+	Components func(cfg config.Config, l *legs.Legs, bus *sim.Bus) []hexapod.Component
+}
+
+// goal is a goal position written to the bus.
+type goal struct {
+	id  int
+	pos int
+}
+
+// harness is a hexapod with real components, but simulated servos and input.
+type harness struct {
+	bus  *sim.Bus
+	hex  *hexapod.Hexapod
+	legs *legs.Legs
+	sa   *sixaxis.SA
+
+	now   time.Time
+	goals []goal
+
+	// The position of each foot in the world space after the previous tick,
+	// if there was one.
+	feet    [6]math3d.Vector3
+	hasFeet bool
+}
+
+func newHarness(t *testing.T, cfg config.Config, extra func(config.Config, *legs.Legs, *sim.Bus) []hexapod.Component) *harness {
+	h := &harness{
+		bus: sim.NewBus(),
+		sa:  sixaxis.New(nil),
+		now: time.Unix(0, 0),
+	}
+
+	h.bus.Latency = latency
+	h.bus.OnWrite = func(id int, params []byte) {
+		addr := int(params[0])
+		data := params[1:]
+
+		// Only interested in complete writes to the goal position.
+		i := goalPositionAddr - addr
+		if i < 0 || i+1 >= len(data) {
+			return
+		}
+
+		h.goals = append(h.goals, goal{id, int(data[i]) | int(data[i+1])<<8})
+	}
+
+	n := network.New(h.bus)
+	h.hex = hexapod.NewHexapod(n, fps)
+	h.hex.Params = params.New()
+
+	// Panic if any component writes to the state out of turn.
+	h.hex.Strict = true
+
+	h.legs = legs.New(n, cfg.Legs, cfg.Gait)
+	h.legs.Params = h.hex.Params
+
+	c := controller.NewScripted(h.sa, cfg.Controller, cfg.Head)
+	c.Params = h.hex.Params
+
+	s := sim.New(h.bus, h.legs)
+	s.Params = h.hex.Params
+
+	// Same order as main.
+	h.hex.Add(h.legs)
+	h.hex.Add(s)
+	h.hex.Add(c)
+	if extra != nil {
+		for _, e := range extra(cfg, h.legs, h.bus) {
+			h.hex.Add(e)
+		}
+	}
+
+	assert.NoError(t, h.hex.Boot())
+	return h
+}
+
+// tick advances the fake clock by a frame, and runs a tick. It returns how long
+// the tick spent on the simulated bus, which is most of it on the real thing,
+// and (unlike the real time it took) doesn't depend on how busy the machine
+// running the tests is.
+func (h *harness) tick(t *testing.T) time.Duration {
+	h.now = h.now.Add(time.Second / fps)
+
+	start := h.bus.Busy()
+	err := h.hex.Tick(h.now)
+	d := h.bus.Busy() - start
+
+	assert.NoError(t, err)
+	return d
+}
+
+// ready ticks until the legs have reached their home positions, which they
+// wait for in real time.
+func (h *harness) ready(t *testing.T) bool {
+	deadline := time.Now().Add(readyTimeout)
+
+	for h.legs.State == "" {
+		if time.Now().After(deadline) {
+			t.Errorf("legs weren't ready after %s", readyTimeout)
+			return false
+		}
+
+		h.tick(t)
+		time.Sleep(time.Millisecond)
+	}
+
+	return true
+}
+
+// run runs the scenario, and checks the invariants after every tick.
+func run(t *testing.T, s Scenario, gaitIndex int) {
+	cfg := config.Default()
+	if s.Config != nil {
+		s.Config(&cfg)
+	}
+
+	h := newHarness(t, cfg, s.Components)
+	if !h.ready(t) {
+		return
+	}
+
+	inputs := append([]Input{}, s.Inputs...)
+	sort.SliceStable(inputs, func(i, j int) bool { return inputs[i].At < inputs[j].At })
+
+	writes := append([]Write{}, s.Writes...)
+	sort.SliceStable(writes, func(i, j int) bool { return writes[i].At < writes[j].At })
+
+	h.hex.State.GaitIndex = gaitIndex
+	start := *h.hex.State
+	deadline := time.Second / fps
+
+	ticks := int(s.Duration * fps / time.Second)
+	for i := 0; i < ticks; i++ {
+		at := time.Duration(i) * time.Second / fps
+		for len(inputs) > 0 && inputs[0].At <= at {
+			inputs[0].Set(h.sa)
+			inputs = inputs[1:]
+		}
+
+		for len(writes) > 0 && writes[0].At <= at {
+			if err := h.hex.Params.Set(writes[0].Values); err != nil {
+				t.Errorf("tick %d: %s", i, err)
+				return
+			}
+			writes = writes[1:]
+		}
+
+		h.goals = h.goals[:0]
+		d := h.tick(t)
+
+		ok := h.check(t, i, d, deadline)
+		if !ok {
+			return
+		}
+	}
+
+	if s.Check != nil {
+		s.Check(t, start, *h.hex.State)
+	}
+}
+
+// check asserts the invariants after a tick, and returns false (to stop the
+// scenario, rather than failing every tick after) if any didn't hold.
+func (h *harness) check(t *testing.T, tick int, d, deadline time.Duration) bool {
+	state := h.hex.State
+	ok := true
+
+	if state.Shutdown {
+		t.Errorf("tick %d: shut down (did a component panic?)", tick)
+		ok = false
+	}
+
+	if d > deadline {
+		t.Errorf("tick %d: spent %s on the bus, which is longer than the deadline of %s", tick, d, deadline)
+		ok = false
+	}
+
+	// NaN goals would be caught by SetGoal, but check anyway, since they'd be
+	// sent as garbage. The same goes for the goals on the bus, which must be
+	// within the limits of each servo.
+	for i, f := range state.Feet {
+		if math.IsNaN(f.X) || math.IsNaN(f.Y) || math.IsNaN(f.Z) {
+			t.Errorf("tick %d: foot %d goal is NaN: %v", tick, i, f)
+			ok = false
+		}
+	}
+
+	for _, g := range h.goals {
+		cw, ccw, _ := h.bus.Limits(g.id)
+		if g.pos < cw || g.pos > ccw {
+			t.Errorf("tick %d: goal position of servo %d is %d, outside of its limits (%d-%d)", tick, g.id, g.pos, cw, ccw)
+			ok = false
+		}
+	}
+
+	w := state.World()
+	stance := 0
+	for _, f := range state.Feet {
+		if f.MultiplyByMatrix44(w).Y < swingThreshold {
+			stance += 1
+		}
+	}
+
+	if stance < minStance {
+		t.Errorf("tick %d: only %d feet on the ground", tick, stance)
+		ok = false
+	}
+
+	// The feet move smoothly, even when the gait changes mid-step.
+	for i, f := range state.Feet {
+		p := f.MultiplyByMatrix44(w)
+		if h.hasFeet {
+			d := p.Distance(h.feet[i])
+			if d > maxFootJump {
+				t.Errorf("tick %d: foot %d moved %.1fmm, from %v to %v", tick, i, d, h.feet[i], p)
+				ok = false
+			}
+		}
+		h.feet[i] = p
+	}
+	h.hasFeet = true
+
+	return ok
+}
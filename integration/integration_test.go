@@ -0,0 +1,403 @@
+package integration
+
+import (
+	"fmt"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/adammck/hexapod"
+	"github.com/adammck/hexapod/components/joints"
+	"github.com/adammck/hexapod/components/legs"
+	"github.com/adammck/hexapod/components/navigator"
+	"github.com/adammck/hexapod/components/righting"
+	"github.com/adammck/hexapod/components/sim"
+	"github.com/adammck/hexapod/components/voltage"
+	"github.com/adammck/hexapod/config"
+	"github.com/adammck/hexapod/math3d"
+	"github.com/adammck/sixaxis"
+	"github.com/stretchr/testify/assert"
+)
+
+// The gaits to run each scenario with, by index into hexapod.DefaultGaits.
+var gaits = []struct {
+	name  string
+	index int
+}{
+	{"wave", 0},
+	{"ripple", 1},
+	{"tripod", 2},
+}
+
+// Add new scenarios here. Each is run with every gait.
+var scenarios = []Scenario{
+	{
+		Name:     "straight",
+		Duration: 4 * time.Second,
+		Inputs: []Input{
+			{At: 0, Set: func(sa *sixaxis.SA) { sa.LeftStick.Y = -127 }},
+		},
+		Check: func(t *testing.T, start, end hexapod.State) {
+			assert.True(t, end.Pose.Position.Z-start.Pose.Position.Z > 50, "didn't walk forwards: %v", end.Pose)
+			assert.InDelta(t, start.Pose.Position.X, end.Pose.Position.X, 20)
+		},
+	},
+	{
+		Name:     "rotate",
+		Duration: 4 * time.Second,
+		Inputs: []Input{
+			{At: 0, Set: func(sa *sixaxis.SA) { sa.R2 = 255 }},
+		},
+		Check: func(t *testing.T, start, end hexapod.State) {
+			assert.True(t, math3d.AngleDiff(end.Pose.Heading, start.Pose.Heading) > 5, "didn't turn: %v", end.Pose)
+			assert.InDelta(t, start.Pose.Position.Z, end.Pose.Position.Z, 20)
+		},
+	},
+	{
+		Name:     "clearance while walking",
+		Duration: 4 * time.Second,
+		Inputs: []Input{
+			{At: 0, Set: func(sa *sixaxis.SA) { sa.LeftStick.Y = -127 }},
+			{At: 500 * time.Millisecond, Set: func(sa *sixaxis.SA) { sa.Up = 255 }},
+			{At: 600 * time.Millisecond, Set: func(sa *sixaxis.SA) { sa.Up = 0 }},
+			{At: 700 * time.Millisecond, Set: func(sa *sixaxis.SA) { sa.Up = 255 }},
+			{At: 800 * time.Millisecond, Set: func(sa *sixaxis.SA) { sa.Up = 0 }},
+		},
+		Check: func(t *testing.T, start, end hexapod.State) {
+			cfg := config.Default().Controller
+			assert.Equal(t, cfg.Clearance+2*cfg.ClearanceStep, end.Pose.Position.Y)
+			assert.True(t, end.Pose.Position.Z-start.Pose.Position.Z > 50, "didn't walk forwards: %v", end.Pose)
+		},
+	},
+	{
+		Name:     "stop",
+		Duration: 4 * time.Second,
+		Inputs: []Input{
+			{At: 0, Set: func(sa *sixaxis.SA) { sa.LeftStick.Y = -127; sa.R2 = 255 }},
+			{At: 2 * time.Second, Set: func(sa *sixaxis.SA) { sa.LeftStick.Y = 0; sa.R2 = 0 }},
+		},
+		Check: func(t *testing.T, start, end hexapod.State) {
+			assert.True(t, end.Pose.Position.Z-start.Pose.Position.Z > 50, "didn't walk forwards: %v", end.Pose)
+			assert.Equal(t, end.Pose, end.Target, "didn't stop")
+		},
+	},
+	{
+		Name:     "gait params while walking",
+		Duration: 5 * time.Second,
+		Inputs: []Input{
+			{At: 0, Set: func(sa *sixaxis.SA) { sa.LeftStick.Y = -127 }},
+		},
+		// Each is written mid-step, so would make the feet jump if it were
+		// applied straight away.
+		Writes: []Write{
+			{At: 1000 * time.Millisecond, Values: map[string]float64{"legs.step_height": 70}},
+			{At: 1900 * time.Millisecond, Values: map[string]float64{"legs.step_height": 40}},
+			{At: 2300 * time.Millisecond, Values: map[string]float64{"legs.step_radius": 210}},
+			{At: 2900 * time.Millisecond, Values: map[string]float64{"gait.base_ticks_per_step": 16}},
+			{At: 3500 * time.Millisecond, Values: map[string]float64{"gait.duty_factor": 0.6}},
+		},
+		Check: func(t *testing.T, start, end hexapod.State) {
+			assert.True(t, end.Pose.Position.Z-start.Pose.Position.Z > 50, "didn't walk forwards: %v", end.Pose)
+			assert.Equal(t, hexapod.GaitParams{TicksPerStep: 16, DutyFactor: 0.6, StepHeight: 40, StepRadius: 210}, end.GaitParams)
+		},
+	},
+	{
+		Name:       "waypoints",
+		Duration:   20 * time.Second,
+		Components: route(navigator.Waypoint{Forward: 150}, navigator.Waypoint{Turn: -45}, navigator.Waypoint{Forward: 100, Right: 30}),
+		Check: func(t *testing.T, start, end hexapod.State) {
+			want := math3d.Pose{Position: math3d.Vector3{X: start.Pose.Position.X, Z: start.Pose.Position.Z}, Heading: start.Pose.Heading}
+			want = want.Add(math3d.Pose{Position: math3d.Vector3{Z: 150}})
+			want = want.Add(math3d.Pose{Heading: -45})
+			want = want.Add(math3d.Pose{Position: math3d.Vector3{X: 30, Z: 100}})
+
+			cfg := config.Default().Navigator
+			assert.False(t, end.Navigation.Active, "didn't finish: %+v", end.Navigation)
+			assert.InDelta(t, want.Position.X, end.Pose.Position.X, cfg.Tolerance, "pose: %v, want: %v", end.Pose, want)
+			assert.InDelta(t, want.Position.Z, end.Pose.Position.Z, cfg.Tolerance, "pose: %v, want: %v", end.Pose, want)
+			assert.InDelta(t, 0, math3d.AngleDiff(want.Heading, end.Pose.Heading), cfg.AngleTolerance, "pose: %v, want: %v", end.Pose, want)
+		},
+	},
+	{
+		Name:       "return home",
+		Duration:   16 * time.Second,
+		Components: route(),
+		Inputs: append(wander(),
+			Input{At: 4 * time.Second, Set: func(sa *sixaxis.SA) { sa.Select = true; sa.Cross = 255 }},
+			Input{At: 4100 * time.Millisecond, Set: func(sa *sixaxis.SA) { sa.Select = false; sa.Cross = 0 }},
+			Input{At: 4200 * time.Millisecond, Set: func(sa *sixaxis.SA) { sa.Select = true; sa.Cross = 255 }},
+			Input{At: 4300 * time.Millisecond, Set: func(sa *sixaxis.SA) { sa.Select = false; sa.Cross = 0 }},
+		),
+		Check: func(t *testing.T, start, end hexapod.State) {
+			cfg := config.Default().Navigator
+			assert.True(t, end.Navigation.HasHome)
+			assert.False(t, end.Navigation.Homing, "didn't get home: %+v", end.Navigation)
+			assert.InDelta(t, start.Pose.Position.X, end.Pose.Position.X, cfg.Tolerance, "pose: %v", end.Pose)
+			assert.InDelta(t, start.Pose.Position.Z, end.Pose.Position.Z, cfg.Tolerance, "pose: %v", end.Pose)
+			assert.InDelta(t, 0, math3d.AngleDiff(start.Pose.Heading, end.Pose.Heading), cfg.AngleTolerance, "pose: %v", end.Pose)
+			assert.True(t, end.Drift > start.Drift, "didn't drift: %v", end.Drift)
+		},
+	},
+	{
+		Name:       "return home cancelled",
+		Duration:   8 * time.Second,
+		Components: route(),
+		Inputs: append(wander(),
+			Input{At: 4 * time.Second, Set: func(sa *sixaxis.SA) { sa.Select = true; sa.Cross = 255 }},
+			Input{At: 4100 * time.Millisecond, Set: func(sa *sixaxis.SA) { sa.Select = false; sa.Cross = 0 }},
+			Input{At: 4200 * time.Millisecond, Set: func(sa *sixaxis.SA) { sa.Select = true; sa.Cross = 255 }},
+			Input{At: 4300 * time.Millisecond, Set: func(sa *sixaxis.SA) { sa.Select = false; sa.Cross = 0 }},
+			Input{At: 5 * time.Second, Set: func(sa *sixaxis.SA) { sa.L2 = 255 }},
+			Input{At: 6 * time.Second, Set: func(sa *sixaxis.SA) { sa.L2 = 0 }},
+		),
+		Check: func(t *testing.T, start, end hexapod.State) {
+			assert.True(t, end.Navigation.HasHome)
+			assert.False(t, end.Navigation.Active, "didn't cancel: %+v", end.Navigation)
+			assert.True(t, start.Pose.Position.Z-end.Pose.Position.Z > 50, "went home anyway: %v", end.Pose)
+			assert.Equal(t, end.Pose, end.Target, "didn't stop")
+		},
+	},
+
+	// The rest inject faults via the simulator's params (see config.Sim), as
+	// the console does. Timeouts are left out, since each one costs the
+	// network's whole read timeout (in real time), which is longer than a tick.
+	{
+		Name:     "noisy feedback",
+		Duration: 4 * time.Second,
+		Config: func(cfg *config.Config) {
+			cfg.Legs.Feedback = 4
+		},
+		Inputs: []Input{
+			{At: 0, Set: func(sa *sixaxis.SA) { sa.LeftStick.Y = -127 }},
+		},
+		Writes: []Write{
+			{At: 0, Values: map[string]float64{"sim.position_noise": 5, "sim.position_bias": 10, "sim.checksum_rate": 0.2}},
+		},
+		// The feedback which can't be read is skipped, and nothing steers by
+		// it, so the walk is the same.
+		Check: func(t *testing.T, start, end hexapod.State) {
+			assert.True(t, end.Pose.Position.Z-start.Pose.Position.Z > 50, "didn't walk forwards: %v", end.Pose)
+		},
+	},
+	{
+		Name:     "stuck servo",
+		Duration: 4 * time.Second,
+		Config: func(cfg *config.Config) {
+			cfg.Legs.Feedback = 4
+		},
+		Inputs: []Input{
+			{At: 0, Set: func(sa *sixaxis.SA) { sa.LeftStick.Y = -127 }},
+		},
+		// The femur of the middle right leg, mid-walk.
+		Writes: []Write{
+			{At: time.Second, Values: map[string]float64{"sim.stuck": 62}},
+		},
+		// Its goals are still sent, and it still responds, so the rest of the
+		// legs carry on, and drag that foot along.
+		Check: func(t *testing.T, start, end hexapod.State) {
+			assert.True(t, end.Pose.Position.Z-start.Pose.Position.Z > 50, "didn't walk forwards: %v", end.Pose)
+		},
+	},
+	{
+		Name:       "battery sag",
+		Duration:   3 * time.Second,
+		Components: battery,
+		Inputs: []Input{
+			{At: 0, Set: func(sa *sixaxis.SA) { sa.LeftStick.Y = -127 }},
+		},
+		Writes: []Write{
+			{At: 0, Values: map[string]float64{"sim.sag": 8}},
+		},
+		Check: func(t *testing.T, start, end hexapod.State) {
+			assert.True(t, end.BatteryLow, "battery isn't low: %.2fv", end.Voltage)
+		},
+	},
+	{
+		Name:       "battery sag recovery",
+		Duration:   3 * time.Second,
+		Components: battery,
+		Inputs: []Input{
+			{At: 0, Set: func(sa *sixaxis.SA) { sa.LeftStick.Y = -127 }},
+		},
+		Writes: []Write{
+			{At: 0, Values: map[string]float64{"sim.sag": 8}},
+			{At: 1500 * time.Millisecond, Values: map[string]float64{"sim.sag": 0}},
+		},
+		Check: func(t *testing.T, start, end hexapod.State) {
+			assert.False(t, end.BatteryLow, "battery is still low: %.2fv", end.Voltage)
+			assert.InDelta(t, 12.6, end.Voltage, 0.01)
+		},
+	},
+	{
+		Name:       "imu dropouts",
+		Duration:   4 * time.Second,
+		Components: righter,
+		Inputs: []Input{
+			{At: 0, Set: func(sa *sixaxis.SA) { sa.LeftStick.Y = -127 }},
+		},
+		Writes: []Write{
+			{At: 0, Values: map[string]float64{"sim.imu_dropout_rate": 0.5}},
+		},
+		// The reads which fail are skipped, rather than stopping the loop.
+		Check: func(t *testing.T, start, end hexapod.State) {
+			assert.False(t, end.Fallen)
+			assert.True(t, end.Pose.Position.Z-start.Pose.Position.Z > 50, "didn't walk forwards: %v", end.Pose)
+		},
+	},
+}
+
+// wander returns the inputs to set home (by holding select + cross), and then
+// walk backwards while turning a little for a couple of seconds. Home is then
+// roughly straight ahead, since turning on the spot is slow.
+func wander() []Input {
+	return []Input{
+		{At: 0, Set: func(sa *sixaxis.SA) { sa.Select = true; sa.Cross = 255 }},
+		{At: 1200 * time.Millisecond, Set: func(sa *sixaxis.SA) { sa.Select = false; sa.Cross = 0 }},
+		{At: 1300 * time.Millisecond, Set: func(sa *sixaxis.SA) { sa.LeftStick.Y = 127; sa.R2 = 120 }},
+		{At: 3300 * time.Millisecond, Set: func(sa *sixaxis.SA) { sa.LeftStick.Y = 0; sa.R2 = 0 }},
+	}
+}
+
+// route returns a navigator with the given waypoints queued, for
+// Scenario.Components.
+func route(ws ...navigator.Waypoint) func(config.Config, *legs.Legs, *sim.Bus) []hexapod.Component {
+	return func(cfg config.Config, l *legs.Legs, bus *sim.Bus) []hexapod.Component {
+		n := navigator.New(cfg.Navigator)
+		n.Add(ws...)
+		return []hexapod.Component{n}
+	}
+}
+
+// battery returns a voltage check of the first leg's coxa, for
+// Scenario.Components. It's read four times a second, and not averaged, so it
+// follows the sag straight away.
+func battery(cfg config.Config, l *legs.Legs, bus *sim.Bus) []hexapod.Component {
+	cfg.Safety.VoltageInterval = config.Duration{Duration: 250 * time.Millisecond}
+	cfg.Voltage.Window = config.Duration{}
+	return []hexapod.Component{voltage.New(l.Legs[0].Coxa, cfg.Voltage, cfg.Safety)}
+}
+
+// righter returns the righting, reading the simulated IMU, for
+// Scenario.Components. It's never asked to right the hex, so only watches for
+// falls.
+func righter(cfg config.Config, l *legs.Legs, bus *sim.Bus) []hexapod.Component {
+	return []hexapod.Component{righting.New(joints.FromLegs(l.Legs), bus.IMU(), cfg.Righting)}
+}
+
+func TestScenarios(t *testing.T) {
+	if testing.Short() {
+		t.Skip("runs in real time")
+	}
+
+	for _, s := range scenarios {
+		for _, g := range gaits {
+			s, g := s, g
+			t.Run(fmt.Sprintf("%s/%s", s.Name, g.name), func(t *testing.T) {
+				run(t, s, g.index)
+			})
+		}
+	}
+}
+
+// leaner is a commander which asks for the same pitch on every tick, after the
+// controller has asked for none, like the controller's target orientation mode
+// with the pad held tilted.
+type leaner struct {
+	pitch float64
+}
+
+func (l *leaner) Boot() error {
+	return nil
+}
+
+func (l *leaner) Tick(now time.Time, state *hexapod.State) error {
+	state.Target.Pitch = l.pitch
+	return nil
+}
+
+func (l *leaner) Writes() hexapod.Role {
+	return hexapod.Commander
+}
+
+// TestLeanLimit walks with the tripod gait, with a duty factor which leaves
+// every foot down between steps, while asking for a pitch. It's scaled down
+// while three feet are in the air, and restored while they're all down, and
+// smoothly, rather than snapping or chattering as the feet lift and land.
+func TestLeanLimit(t *testing.T) {
+	if testing.Short() {
+		t.Skip("runs in real time")
+	}
+
+	const pitch = 8.0
+
+	cfg := config.Default()
+	cfg.Gait.DutyFactor = 0.7
+	h := newHarness(t, cfg, func(config.Config, *legs.Legs, *sim.Bus) []hexapod.Component {
+		return []hexapod.Component{&leaner{pitch: pitch}}
+	})
+	if !h.ready(t) {
+		return
+	}
+
+	// Standing on every foot, it leans as far as it's asked to.
+	h.hex.State.GaitIndex = 2
+	for i := 0; i < 2*fps; i++ {
+		h.tick(t)
+	}
+	assert.Equal(t, 6, h.hex.State.Stability.Planted)
+	assert.InDelta(t, pitch, h.hex.State.Pose.Pitch, 1e-6)
+
+	// Walking, skipping the first second while the gait gets going.
+	h.sa.LeftStick.Y = -127
+	for i := 0; i < fps; i++ {
+		h.tick(t)
+	}
+
+	var sum, n [7]float64
+	prev := h.hex.State.Pose.Pitch
+	prevPlanted := h.hex.State.Stability.Planted
+	prevDir := 0.0
+	reversals, changes := 0, 0
+	for i := 0; i < 3*fps; i++ {
+		h.tick(t)
+		s := h.hex.State
+		p := s.Pose.Pitch
+
+		assert.True(t, p >= 0 && p <= pitch+1e-6, "tick %d: pitch is %.2f, outside of 0-%.0f", i, p, pitch)
+		assert.LessOrEqual(t, math.Abs(p-prev), cfg.Legs.PitchMoveSpeed+1e-6, "tick %d: pitch jumped from %.2f to %.2f", i, prev, p)
+
+		sum[s.Stability.Planted] += p
+		n[s.Stability.Planted]++
+
+		if s.Stability.Planted != prevPlanted {
+			changes++
+		}
+		if d := p - prev; math.Abs(d) > 0.01 {
+			if dir := math.Copysign(1, d); prevDir != 0 && dir != prevDir {
+				reversals++
+			}
+			prevDir = math.Copysign(1, d)
+		}
+
+		prev, prevPlanted = p, s.Stability.Planted
+	}
+
+	if assert.NotZero(t, n[3], "never balanced on three feet") && assert.NotZero(t, n[6], "never had every foot down") {
+		tripod, full := sum[3]/n[3], sum[6]/n[6]
+		assert.Less(t, tripod, full-0.5, "pitch on three feet (%.2f) wasn't attenuated from on six (%.2f)", tripod, full)
+	}
+
+	// At most turning around once per change of stance, give or take, and not
+	// chattering back and forth within one.
+	assert.LessOrEqual(t, reversals, 2*changes+2, "pitch reversed %d times in %d changes of stance", reversals, changes)
+
+	// Once it stops, it leans as far as it was asked to again, and stays.
+	h.sa.LeftStick.Y = 0
+	for i := 0; i < 2*fps; i++ {
+		h.tick(t)
+	}
+	for i := 0; i < fps; i++ {
+		h.tick(t)
+		assert.InDelta(t, pitch, h.hex.State.Pose.Pitch, 1e-6, "tick %d", i)
+	}
+}
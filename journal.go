@@ -0,0 +1,193 @@
+package hexapod
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+
+	// The default for how long the journal covers (see NewJournal), which is
+	// long enough to answer "what was it told in the last minute?".
+	DefaultJournalLength = time.Minute
+
+	// The source which the commands changed via params (e.g. hexapod.speed)
+	// are journaled under, since they're applied before any component ticks.
+	ParamsSource = "params"
+)
+
+// Source is an optional interface for components which command the hex on
+// behalf of someone (or something) else, like the controller, the console, the
+// API, and the autonomous components. What each of them changes in the commands
+// during every tick is recorded in the journal (see Hexapod.Journal), under the
+// name which Source returns, which is its name in the config, e.g. controller.
+type Source interface {
+	Source() string
+}
+
+// JournalEntry is what a single source commanded during a single tick: the
+// fields of the commands which it changed, formatted as "path=value" (with the
+// same paths as StateDiff), or none. Won is set on the entry of the source
+// whose commands the tick ended with, i.e. the last one (in the order they
+// were registered) which changed anything that wasn't then overwritten, by
+// another source, or put back, by the countdown. There's no winner if no
+// source changed anything, or all of it was undone.
+type JournalEntry struct {
+	Time     time.Time `json:"time"`
+	Source   string    `json:"source"`
+	Commands []string  `json:"commands,omitempty"`
+	Won      bool      `json:"won,omitempty"`
+
+	// The index of each command's field in the plan, and the value it was set
+	// to, to check whether it's still in effect at the end of the tick.
+	fields []int
+	values []string
+}
+
+// journalTick is the entries of every source during a single tick, in the order
+// they were registered.
+type journalTick struct {
+	entries []JournalEntry
+}
+
+// Journal is a ring of what each source commanded during the last few ticks,
+// separately from what the state ended up as, to answer "what was it told to
+// do?" after something unexpected. It's filled by the core, and can be read
+// from any goroutine; see Entries.
+//
+// The fields of the commands are found (via reflection) once, like StateDiff.
+// The raw input isn't included, since it isn't a command.
+type Journal struct {
+	plan []diffField
+
+	// The tick being recorded, which is swapped into the ring once it's over.
+	cur journalTick
+
+	// The ring of ticks. next is the index which will be written next, and n
+	// is the number of ticks recorded (up to len(ring)). Only this is read from
+	// other goroutines, so only this is locked.
+	mu   sync.Mutex
+	ring []journalTick
+	next int
+	n    int
+}
+
+// NewJournal returns a journal which keeps (approximately) the given duration
+// of ticks, assuming that the hex ticks fps times per second.
+func NewJournal(d time.Duration, fps int) *Journal {
+	size := int(d.Seconds() * float64(fps))
+	if size < 1 {
+		size = 1
+	}
+
+	j := &Journal{ring: make([]journalTick, size)}
+	for _, f := range planDiff(reflect.TypeOf(Commands{}), "", nil, nil) {
+		if strings.HasPrefix(f.path, "Input.") {
+			continue
+		}
+
+		f.eps = DefaultDiffEpsilon
+		j.plan = append(j.plan, f)
+	}
+
+	return j
+}
+
+// begin starts recording a tick.
+func (j *Journal) begin() {
+	j.cur.entries = j.cur.entries[:0]
+}
+
+// record adds what the given source changed in the commands during its tick,
+// compared to the given copy from before it, to the current tick.
+func (j *Journal) record(now time.Time, source string, before, after *Commands) {
+	a, b := reflect.ValueOf(before).Elem(), reflect.ValueOf(after).Elem()
+	e := JournalEntry{Time: now, Source: source}
+
+	for i := range j.plan {
+		f := &j.plan[i]
+		va, oka := f.get(a)
+		vb, okb := f.get(b)
+
+		if oka == okb && (!oka || !changed(va, vb, f.eps)) {
+			continue
+		}
+
+		v := show(vb, okb)
+		e.Commands = append(e.Commands, fmt.Sprintf("%s=%s", f.path, v))
+		e.fields = append(e.fields, i)
+		e.values = append(e.values, v)
+	}
+
+	j.cur.entries = append(j.cur.entries, e)
+}
+
+// end picks the winner of the current tick, given the commands which it ended
+// with, and adds it to the ring.
+func (j *Journal) end(final *Commands) {
+	v := reflect.ValueOf(final).Elem()
+
+	for i := len(j.cur.entries) - 1; i >= 0; i-- {
+		if j.inEffect(&j.cur.entries[i], v) {
+			j.cur.entries[i].Won = true
+			break
+		}
+	}
+
+	// The entries which are swapped out were copied by Entries (if at all), so
+	// their backing array can be reused for the next tick.
+	j.mu.Lock()
+	j.ring[j.next], j.cur = j.cur, j.ring[j.next]
+	j.next = (j.next + 1) % len(j.ring)
+	if j.n < len(j.ring) {
+		j.n += 1
+	}
+	j.mu.Unlock()
+}
+
+// inEffect returns whether any of the commands of the given entry are still
+// what the given commands are set to.
+func (j *Journal) inEffect(e *JournalEntry, final reflect.Value) bool {
+	for k, i := range e.fields {
+		f := &j.plan[i]
+		vf, ok := f.get(final)
+		if show(vf, ok) == e.values[k] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Entries returns the entries in the journal, oldest first, from the given
+// source, or from every source if it's empty. Unlike most methods here, this
+// is safe to call from any goroutine.
+func (j *Journal) Entries(source string) []JournalEntry {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	var out []JournalEntry
+	start := (j.next - j.n + len(j.ring)) % len(j.ring)
+	for i := 0; i < j.n; i++ {
+		for _, e := range j.ring[(start+i)%len(j.ring)].entries {
+			if source == "" || e.Source == source {
+				out = append(out, e)
+			}
+		}
+	}
+
+	return out
+}
+
+// sourceOf returns the name of the given component, if it's a source.
+func sourceOf(c Component) (string, bool) {
+	s, ok := c.(Source)
+	if !ok {
+		return "", false
+	}
+
+	return s.Source(), true
+}
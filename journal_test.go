@@ -0,0 +1,190 @@
+package hexapod
+
+import (
+	"testing"
+	"time"
+
+	"github.com/adammck/hexapod/params"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeSource is a source which runs the given func (if any) every tick.
+type fakeSource struct {
+	name string
+	tick func(state *State)
+}
+
+func (s *fakeSource) Boot() error {
+	return nil
+}
+
+func (s *fakeSource) Tick(now time.Time, state *State) error {
+	if s.tick != nil {
+		s.tick(state)
+	}
+
+	return nil
+}
+
+func (s *fakeSource) Writes() Role {
+	return Commander
+}
+
+func (s *fakeSource) Source() string {
+	return s.name
+}
+
+// journalFixture is a fixture with a journal, and the given components.
+type journalFixture struct {
+	*fixture
+}
+
+func newJournalFixture(t *testing.T, cs ...Component) *journalFixture {
+	f := &journalFixture{newFixture(t)}
+	f.h.Params = params.New()
+	f.h.Journal = NewJournal(time.Second, 50)
+	f.h.Register(cs...)
+	assert.NoError(t, f.h.Boot())
+	return f
+}
+
+func (f *journalFixture) tick(n int) {
+	for i := 0; i < n; i++ {
+		f.step()
+	}
+}
+
+// last returns the source, commands, and whether it won, of each entry of the
+// last tick, from the given sources (which are all the sources).
+func (f *journalFixture) last(sources int) []JournalEntry {
+	es := f.h.Journal.Entries("")
+	if !assert.True(f.t, len(es) >= sources) {
+		return nil
+	}
+
+	var out []JournalEntry
+	for _, e := range es[len(es)-sources:] {
+		assert.Equal(f.t, f.now, e.Time)
+		out = append(out, JournalEntry{Source: e.Source, Commands: e.Commands, Won: e.Won})
+	}
+
+	return out
+}
+
+func TestJournal(t *testing.T) {
+	var pad, net, nav bool
+	f := newJournalFixture(t,
+		&fakeSource{name: "pad", tick: func(s *State) {
+			if pad {
+				s.Target.Position.Z += 10
+				s.Speed = 2
+			}
+		}},
+
+		// Components which aren't sources aren't journaled.
+		&fakeComponent{},
+		&fakeSource{name: "net", tick: func(s *State) {
+			if net {
+				s.Halt = true
+			}
+		}},
+		&fakeSource{name: "nav", tick: func(s *State) {
+			if nav {
+				s.Target.Position.Z = 500
+			}
+		}},
+	)
+
+	// Nothing commanded anything, so nothing won. Params are a source too.
+	f.tick(1)
+	assert.Equal(t, []JournalEntry{
+		{Source: "params"},
+		{Source: "pad"},
+		{Source: "net"},
+		{Source: "nav"},
+	}, f.last(4))
+
+	// Only the pad.
+	pad = true
+	f.tick(1)
+	assert.Equal(t, []JournalEntry{
+		{Source: "params"},
+		{Source: "pad", Commands: []string{"Target.Position.Z=10.000", "Speed=2"}, Won: true},
+		{Source: "net"},
+		{Source: "nav"},
+	}, f.last(4))
+
+	// The navigator overwrites the pad's target, so wins. The pad's speed is
+	// the same as it was, so isn't a command.
+	nav = true
+	f.tick(1)
+	assert.Equal(t, []JournalEntry{
+		{Source: "params"},
+		{Source: "pad", Commands: []string{"Target.Position.Z=20.000"}},
+		{Source: "net"},
+		{Source: "nav", Commands: []string{"Target.Position.Z=500.000"}, Won: true},
+	}, f.last(4))
+
+	// The last source whose commands are still in effect wins, even though the
+	// halt is too.
+	net = true
+	f.tick(1)
+	assert.Equal(t, []JournalEntry{
+		{Source: "params"},
+		{Source: "pad", Commands: []string{"Target.Position.Z=510.000"}},
+		{Source: "net", Commands: []string{"Halt=true"}},
+		{Source: "nav", Commands: []string{"Target.Position.Z=500.000"}, Won: true},
+	}, f.last(4))
+
+	// Filtered by source.
+	es := f.h.Journal.Entries("net")
+	assert.Len(t, es, 4)
+	for _, e := range es {
+		assert.Equal(t, "net", e.Source)
+	}
+	assert.Equal(t, []string{"Halt=true"}, es[3].Commands)
+	assert.Empty(t, f.h.Journal.Entries("nobody"))
+}
+
+func TestJournalParams(t *testing.T) {
+	f := newJournalFixture(t, &fakeSource{name: "pad"})
+	assert.NoError(t, f.h.Params.Set(map[string]float64{"hexapod.speed": 3}))
+	f.tick(1)
+
+	assert.Equal(t, []JournalEntry{
+		{Source: "params", Commands: []string{"Speed=3"}, Won: true},
+		{Source: "pad"},
+	}, f.last(2))
+}
+
+func TestJournalCountdown(t *testing.T) {
+	w := &walker{}
+	f := newJournalFixture(t, w)
+	f.h.Countdown = time.Second
+
+	// The walker is journaled as trying to move, but the countdown put the
+	// target back, so it didn't win.
+	w.walking = true
+	f.tick(1)
+	assert.Equal(t, []JournalEntry{
+		{Source: "params"},
+		{Source: "walker", Commands: []string{"Target.Position.Z=10.000"}},
+	}, f.last(2))
+
+	f.tick(50)
+	assert.Equal(t, []JournalEntry{
+		{Source: "params"},
+		{Source: "walker", Commands: []string{"Target.Position.Z=10.000"}, Won: true},
+	}, f.last(2))
+}
+
+func TestJournalRing(t *testing.T) {
+	f := newJournalFixture(t, &fakeSource{name: "pad"})
+	f.tick(120)
+
+	// A second's worth of ticks, each with an entry from the params and pad.
+	es := f.h.Journal.Entries("pad")
+	assert.Len(t, es, 50)
+	assert.Equal(t, f.now, es[49].Time)
+	assert.Equal(t, f.now.Add(-49*20*time.Millisecond), es[0].Time)
+}
@@ -0,0 +1,234 @@
+package hexapod
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// Log is the logger for a single component. It's a logrus entry with the pkg
+// field set, so can be used exactly like one, but has its own level (which can
+// be set independently of the other components) and can rate-limit noisy
+// messages.
+//
+//	var log = hexapod.NewLog("legs")
+//	log.Infof("hello")
+//	log.RateLimited("saturated", time.Second).Warnf("can't reach")
+type Log struct {
+	*logrus.Entry
+	pkg    string
+	logger *logrus.Logger
+
+	sync.Mutex
+	limits map[string]*limit
+}
+
+type limit struct {
+
+	// The time at which the message was last logged.
+	last time.Time
+
+	// The number of times it was suppressed since then.
+	suppressed int
+}
+
+var (
+	logsMu sync.Mutex
+
+	// All of the logs created so far, by pkg.
+	logs = map[string]*Log{}
+
+	// Levels set (via SetLogLevel) for specific packages. Logs which aren't in
+	// here use the logrus level.
+	logLevels = map[string]logrus.Level{}
+
+	// Fields added to every log (including ones created later), besides the
+	// pkg. See setLogIdentity.
+	logFields = logrus.Fields{}
+
+	// Returns the current time. Replaced in tests.
+	logNow = time.Now
+)
+
+// NewLog returns the Log for the given pkg, creating it if necessary. This is
+// meant to be called once per package, to initialize a package-level var.
+func NewLog(pkg string) *Log {
+	logsMu.Lock()
+	defer logsMu.Unlock()
+
+	if l, ok := logs[pkg]; ok {
+		return l
+	}
+
+	std := logrus.StandardLogger()
+	logger := &logrus.Logger{
+		Out:       std.Out,
+		Formatter: std.Formatter,
+		Hooks:     std.Hooks,
+		Level:     levelFor(pkg),
+	}
+
+	l := &Log{
+		Entry:  logger.WithFields(logFields).WithField("pkg", pkg),
+		pkg:    pkg,
+		logger: logger,
+		limits: map[string]*limit{},
+	}
+
+	logs[pkg] = l
+	return l
+}
+
+// levelFor returns the level which the given pkg should log at. The caller
+// must hold logsMu.
+func levelFor(pkg string) logrus.Level {
+	if lvl, ok := logLevels[pkg]; ok {
+		return lvl
+	}
+
+	return logrus.GetLevel()
+}
+
+// SetLogLevel sets the level of the given pkg. If pkg is empty, the default
+// level is set instead, which applies to logrus itself and every pkg which
+// doesn't have its own level.
+func SetLogLevel(pkg string, lvl logrus.Level) {
+	logsMu.Lock()
+	defer logsMu.Unlock()
+
+	if pkg == "" {
+		logrus.SetLevel(lvl)
+	} else {
+		logLevels[pkg] = lvl
+	}
+
+	for p, l := range logs {
+		l.logger.SetLevel(levelFor(p))
+	}
+}
+
+// SetLogLevels parses a comma-separated list of levels, like
+// "warn,legs=debug,controller=error", and sets them. Entries without a pkg set
+// the default level.
+func SetLogLevels(spec string) error {
+	for _, s := range strings.Split(spec, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+
+		pkg := ""
+		if i := strings.Index(s, "="); i >= 0 {
+			pkg, s = s[:i], s[i+1:]
+		}
+
+		lvl, err := logrus.ParseLevel(s)
+		if err != nil {
+			return fmt.Errorf("invalid log level for %q: %s", pkg, err)
+		}
+
+		SetLogLevel(pkg, lvl)
+	}
+
+	return nil
+}
+
+// setLogOutput redirects every log (including ones created later) to w.
+func setLogOutput(w io.Writer) {
+	logsMu.Lock()
+	defer logsMu.Unlock()
+
+	logrus.SetOutput(w)
+	for _, l := range logs {
+		l.logger.SetOutput(w)
+	}
+}
+
+// setLogIdentity adds the ID of the robot to every log (including ones created
+// later), so the logs of two robots can be told apart once they're collected.
+// This replaces the entry of each log, so must be called before they're used
+// from other goroutines, i.e. before the components are booted.
+func setLogIdentity(id Identity) {
+	logsMu.Lock()
+	defer logsMu.Unlock()
+
+	logFields = logrus.Fields{"robot": id.ID}
+	for p, l := range logs {
+		l.Entry = l.logger.WithFields(logFields).WithField("pkg", p)
+	}
+}
+
+// RateLimited returns an entry which logs at most once per interval for the
+// given key. Messages logged within the interval are dropped, and the next one
+// to be logged after it has passed notes how many were. Keys are per-Log, so
+// don't need to be globally unique.
+func (l *Log) RateLimited(key string, interval time.Duration) *Limited {
+	now := logNow()
+
+	l.Lock()
+	defer l.Unlock()
+
+	lim, ok := l.limits[key]
+	if !ok {
+		lim = &limit{}
+		l.limits[key] = lim
+	}
+
+	if !lim.last.IsZero() && now.Sub(lim.last) < interval {
+		lim.suppressed += 1
+		return &Limited{}
+	}
+
+	n := lim.suppressed
+	lim.last = now
+	lim.suppressed = 0
+
+	return &Limited{entry: l.Entry, repeated: n}
+}
+
+// Limited is returned by RateLimited. Its methods do nothing if the message is
+// being suppressed.
+type Limited struct {
+
+	// The entry to log to, or nil if suppressed.
+	entry *logrus.Entry
+
+	// The number of times the message was suppressed since it was last logged.
+	repeated int
+}
+
+func (l *Limited) format(format string) string {
+	if l.repeated > 0 {
+		return fmt.Sprintf("%s (repeated %d times)", format, l.repeated)
+	}
+
+	return format
+}
+
+func (l *Limited) Debugf(format string, args ...interface{}) {
+	if l.entry != nil {
+		l.entry.Debugf(l.format(format), args...)
+	}
+}
+
+func (l *Limited) Infof(format string, args ...interface{}) {
+	if l.entry != nil {
+		l.entry.Infof(l.format(format), args...)
+	}
+}
+
+func (l *Limited) Warnf(format string, args ...interface{}) {
+	if l.entry != nil {
+		l.entry.Warnf(l.format(format), args...)
+	}
+}
+
+func (l *Limited) Errorf(format string, args ...interface{}) {
+	if l.entry != nil {
+		l.entry.Errorf(l.format(format), args...)
+	}
+}
@@ -0,0 +1,87 @@
+package hexapod
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+// captureLogs redirects all logs to a buffer. Call the returned func to undo.
+func captureLogs() (*bytes.Buffer, func()) {
+	buf := &bytes.Buffer{}
+	out := logrus.StandardLogger().Out
+	lvl := logrus.GetLevel()
+	setLogOutput(buf)
+
+	return buf, func() {
+		setLogOutput(out)
+		SetLogLevel("", lvl)
+	}
+}
+
+func TestPerComponentLogLevels(t *testing.T) {
+	buf, restore := captureLogs()
+	defer restore()
+	a := NewLog("test-a")
+	b := NewLog("test-b")
+
+	assert.NoError(t, SetLogLevels("info,test-a=warn,test-b=debug"))
+	a.Info("a-info")
+	a.Warn("a-warn")
+	b.Debug("b-debug")
+	logrus.Debug("std-debug")
+
+	out := buf.String()
+	assert.NotContains(t, out, "a-info")
+	assert.Contains(t, out, "a-warn")
+	assert.Contains(t, out, "b-debug")
+	assert.Contains(t, out, "pkg=test-b")
+	assert.NotContains(t, out, "std-debug")
+
+	// Changing the default doesn't affect components with their own level.
+	buf.Reset()
+	SetLogLevel("", logrus.ErrorLevel)
+	a.Warn("a-warn")
+	b.Info("b-info")
+	assert.Contains(t, buf.String(), "a-warn")
+	assert.Contains(t, buf.String(), "b-info")
+
+	// The same pkg always gets the same log.
+	assert.True(t, a == NewLog("test-a"))
+
+	assert.Error(t, SetLogLevels("test-a=loud"))
+}
+
+func TestRateLimited(t *testing.T) {
+	buf, restore := captureLogs()
+	defer restore()
+	l := NewLog("test-rate")
+	SetLogLevel("test-rate", logrus.InfoLevel)
+
+	now := time.Date(2017, 6, 1, 0, 0, 0, 0, time.UTC)
+	logNow = func() time.Time { return now }
+	defer func() { logNow = time.Now }()
+
+	for i := 0; i <= 100; i++ {
+		l.RateLimited("sat", time.Second).Warnf("saturated %d", i)
+		now = now.Add(10 * time.Millisecond)
+	}
+
+	// Other keys don't count against this one.
+	l.RateLimited("other", time.Second).Warnf("other")
+
+	out := buf.String()
+	assert.Contains(t, out, "saturated 0\"")
+	assert.Contains(t, out, "saturated 100 (repeated 99 times)")
+	assert.NotContains(t, out, "saturated 50")
+	assert.Contains(t, out, "other")
+
+	// Once the count has been reported, it's reset.
+	buf.Reset()
+	now = now.Add(time.Second)
+	l.RateLimited("sat", time.Second).Warnf("saturated again")
+	assert.Contains(t, buf.String(), "saturated again\"")
+}
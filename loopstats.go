@@ -0,0 +1,162 @@
+package hexapod
+
+import (
+	"expvar"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// The number of ticks to keep timings for. At 60fps, this is a couple of
+// seconds, which is enough to spot a spike without averaging it away.
+const statsWindow = 120
+
+// Counters exported via expvar, for the diagnostics server. These are global
+// (rather than per-Hexapod) because expvar is.
+var (
+	expTicks     = expvar.NewInt("hexapod.ticks")
+	expTickUs    = expvar.NewInt("hexapod.tick_us")
+	expBusErrors = expvar.NewInt("hexapod.bus_errors")
+)
+
+// timing is a ring of recent durations.
+type timing struct {
+	ring [statsWindow]time.Duration
+	n    int
+	i    int
+}
+
+func (t *timing) add(d time.Duration) {
+	t.ring[t.i] = d
+	t.i = (t.i + 1) % statsWindow
+	if t.n < statsWindow {
+		t.n += 1
+	}
+}
+
+func (t *timing) stats(name string) TickStats {
+	s := TickStats{Name: name}
+	if t.n == 0 {
+		return s
+	}
+
+	var sum, max time.Duration
+	for _, d := range t.ring[:t.n] {
+		sum += d
+		if d > max {
+			max = d
+		}
+	}
+
+	last := t.ring[(t.i+statsWindow-1)%statsWindow]
+	s.LastUs = int64(last / time.Microsecond)
+	s.MeanUs = int64(sum / time.Duration(t.n) / time.Microsecond)
+	s.MaxUs = int64(max / time.Microsecond)
+	return s
+}
+
+// over returns the number of durations which are longer than the given one.
+func (t *timing) over(d time.Duration) int {
+	n := 0
+	for _, x := range t.ring[:t.n] {
+		if x > d {
+			n++
+		}
+	}
+
+	return n
+}
+
+// loopStats tracks how long each tick (and each component's part of it) takes.
+// It's written by the main loop, but can be read from any goroutine.
+type loopStats struct {
+	sync.Mutex
+	ticks      int64
+	busErrors  int64
+	total      timing
+	names      []string
+	components []timing
+}
+
+func (s *loopStats) component(i int, c Component, d time.Duration) {
+	s.Lock()
+	defer s.Unlock()
+
+	for len(s.components) <= i {
+		s.components = append(s.components, timing{})
+		s.names = append(s.names, "")
+	}
+
+	// Components don't change places, so the name only needs to be found once.
+	if s.names[i] == "" {
+		s.names[i] = fmt.Sprintf("%T", c)
+	}
+
+	s.components[i].add(d)
+}
+
+func (s *loopStats) tick(d time.Duration) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.ticks += 1
+	s.total.add(d)
+
+	expTicks.Add(1)
+	expTickUs.Set(int64(d / time.Microsecond))
+}
+
+func (s *loopStats) busError() {
+	s.Lock()
+	defer s.Unlock()
+
+	s.busErrors += 1
+	expBusErrors.Add(1)
+}
+
+// TickStats summarizes the duration of the last few ticks, in microseconds.
+type TickStats struct {
+	Name   string `json:"name"`
+	LastUs int64  `json:"last_us"`
+	MeanUs int64  `json:"mean_us"`
+	MaxUs  int64  `json:"max_us"`
+}
+
+// LoopStats summarizes the recent performance of the main loop.
+type LoopStats struct {
+	Ticks      int64       `json:"ticks"`
+	BusErrors  int64       `json:"bus_errors"`
+	Total      TickStats   `json:"total"`
+	Components []TickStats `json:"components"`
+
+	// How many of the last few ticks (the window) took longer than the tick
+	// period, and so delayed the next.
+	Overruns int `json:"overruns"`
+	Window   int `json:"window"`
+}
+
+// LoopStats returns the timing of the last few ticks. Unlike most methods of
+// Hexapod, this is safe to call from any goroutine.
+func (h *Hexapod) LoopStats() LoopStats {
+	s := h.stats
+	s.Lock()
+	defer s.Unlock()
+
+	out := LoopStats{
+		Ticks:      s.ticks,
+		BusErrors:  s.busErrors,
+		Total:      s.total.stats("total"),
+		Components: make([]TickStats, len(s.components)),
+		Window:     s.total.n,
+	}
+
+	if h.TargetFPS > 0 {
+		out.Overruns = s.total.over(time.Second / time.Duration(h.TargetFPS))
+	}
+
+	for i := range s.components {
+		out.Components[i] = s.components[i].stats(s.names[i])
+	}
+
+	return out
+}
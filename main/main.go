@@ -1,36 +1,85 @@
 package main
 
 import (
+	"bufio"
+	"context"
 	"flag"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/adammck/dynamixel/network"
 	"github.com/adammck/hexapod"
-	"github.com/adammck/hexapod/components/controller"
-	"github.com/adammck/hexapod/components/head"
+	"github.com/adammck/hexapod/components/builtin"
+	"github.com/adammck/hexapod/components/discovery"
 	"github.com/adammck/hexapod/components/legs"
 	"io"
 	"io/ioutil"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
-	"github.com/adammck/hexapod/components/voltage"
+	"github.com/adammck/hexapod/components/recorder"
+	"github.com/adammck/hexapod/components/sim"
+	"github.com/adammck/hexapod/components/statelog"
+	"github.com/adammck/hexapod/config"
+	"github.com/adammck/hexapod/diag"
 	fake_serial "github.com/adammck/hexapod/fake/serial"
-	fake_voltage "github.com/adammck/hexapod/fake/voltage"
-	"github.com/adammck/hexapod/math3d"
 	"github.com/adammck/hexapod/servos"
 	"github.com/jacobsa/go-serial/serial"
 )
 
+// captureSize is how many servo samples to keep for each frame per second,
+// with --record-servos: 30 seconds (the same as the flight recorder) of
+// everything on the bus, which is the goals of every servo, plus the feedback.
+const captureSize = 30 * 64
+
 var (
-	serialPort     = flag.String("serial-port", "/dev/ttyACM0", "path to the serial port")
-	controllerPort = flag.String("controller-port", "/dev/input/event1", "path to the sixaxis controller")
-	debug          = flag.Bool("debug", false, "enable verbose logging")
-	httpPort       = flag.Int("http-port", 8000, "port to start HTTP server on")
-	offline        = flag.Bool("offline", false, "run in offline mode (with fake devices)")
-	fps            = flag.Int("fps", 60, "set the number of frames per second")
+	serialPort        = flag.String("serial-port", "/dev/ttyACM0", "path to the serial port")
+	controllerPort    = flag.String("controller-port", "/dev/input/event1", "path to the sixaxis controller")
+	debug             = flag.Bool("debug", false, "enable verbose logging")
+	logLevels         = flag.String("log-levels", os.Getenv("HEXAPOD_LOG"), "comma-separated log levels, e.g. warn,legs=debug (defaults to $HEXAPOD_LOG)")
+	httpPort          = flag.Int("http-port", 8000, "port to start HTTP server on")
+	offline           = flag.Bool("offline", false, "run in offline mode (with fake devices)")
+	simulate          = flag.Bool("sim", false, "simulate the servos (and how they walk), rather than using the serial port")
+	fps               = flag.Int("fps", 60, "set the number of frames per second")
+	telemetryPort     = flag.Int("telemetry-port", 0, "port to stream telemetry on (zero to disable)")
+	telemetryRate     = flag.Int("telemetry-rate", 10, "number of telemetry snapshots to send per second")
+	recorderDir       = flag.String("recorder-dir", "/tmp", "directory to write flight recorder dumps to")
+	decode            = flag.String("decode", "", "convert the given flight recorder dump to CSV on stdout, and exit")
+	dumpWorkspace     = flag.String("dump-workspace", "", "write the reachable envelope of each foot, at the configured clearance, to the given .json or .ply file, and exit")
+	stateLogDir       = flag.String("state-log-dir", "", "directory to write per-tick state logs to (empty to disable)")
+	stateLogFormat    = flag.String("state-log-format", "csv", "format of state logs (csv or jsonl)")
+	stateLogFields    = flag.String("state-log-fields", strings.Join(statelog.FieldNames(), ","), "comma-separated list of fields to log")
+	stateLogSize      = flag.Int64("state-log-size", 10*1024*1024, "maximum size (in bytes) of each state log file")
+	mqttBroker        = flag.String("mqtt-broker", "", "MQTT broker to publish state to, e.g. tcp://localhost:1883 (empty to disable)")
+	mqttPrefix        = flag.String("mqtt-prefix", "", "prefix of the MQTT topics to publish and subscribe to (defaults to hexapod/ and the robot's ID)")
+	mqttInterval      = flag.Duration("mqtt-interval", 5*time.Second, "how often to publish state to MQTT")
+	name              = flag.String("name", "", "name of this hexapod, for discovery, the logs, etc (defaults to identity.name in the config, or the hostname)")
+	discoveryPort     = flag.Int("discovery-port", discovery.DefaultPort, "UDP port to broadcast discovery beacons to")
+	discoveryInterval = flag.Duration("discovery-interval", 2*time.Second, "how often to broadcast discovery beacons (zero to disable)")
+	rosbridgeURL      = flag.String("rosbridge-url", "", "rosbridge server to publish pose to, e.g. ws://localhost:9090 (empty to disable)")
+	rosbridgePrefix   = flag.String("rosbridge-prefix", "/hexapod", "prefix of the ROS topics to publish")
+	rosbridgeCmdVel   = flag.String("rosbridge-cmd-vel", "", "ROS topic to accept velocity commands from, e.g. /cmd_vel (empty to ignore)")
+	rosbridgeRate     = flag.Int("rosbridge-rate", 10, "number of poses to publish to ROS per second")
+	diagPort          = flag.Int("diag-port", 0, "port to serve pprof and loop diagnostics on (zero to disable)")
+	diagPublic        = flag.Bool("diag-public", false, "serve diagnostics on all interfaces, rather than only localhost")
+	trackerPort       = flag.Int("tracker-port", 0, "port to accept detections from a vision process on, to point the head at (zero to disable)")
+	rangefinderIIO    = flag.String("rangefinder-iio", "", "sysfs IIO device of the forward rangefinder, e.g. /sys/bus/iio/devices/iio:device0 (empty to disable)")
+	buzzerPWM         = flag.String("buzzer-pwm", "", "sysfs PWM channel which the buzzer is on, e.g. /sys/class/pwm/pwmchip0/pwm0 (empty to disable)")
+	diffState         = flag.Bool("diff-state", false, "log the changes which each component makes to the state, every tick (slow)")
+	configPath        = flag.String("config", "/etc/hexapod.toml", "path to the config file (defaults are used if it doesn't exist)")
+	configWatch       = flag.Duration("config-watch", 0, "how often to check the config file for changes, and reload it (zero to only reload on SIGHUP)")
+	calibrationPath   = flag.String("calibration-path", "/var/lib/hexapod/calibration.json", "path to the servo calibration offsets")
+	selfTest          = flag.Bool("selftest", false, "run the self-test at boot (it can also be run while parked, with select + R1)")
+	settingsPath      = flag.String("settings-path", "/var/lib/hexapod/settings.json", "path to persist runtime settings (e.g. clearance) to (empty to disable)")
+	odometerPath      = flag.String("odometer-path", "/var/lib/hexapod/odometer.json", "path to persist the lifetime counters (distance walked, steps per leg, etc) to (empty to disable)")
+	consoleSocket     = flag.String("console-socket", "", "unix socket for the console to listen on, if it's enabled (empty to read from stdin)")
+	enable            = flag.String("enable", "", "comma-separated components to enable, overriding the defaults and the config")
+	disable           = flag.String("disable", "", "comma-separated components to disable, overriding the defaults and the config")
+	listComponents    = flag.Bool("list-components", false, "list the components, and whether each would be enabled, and exit")
+	recordServos      = flag.Bool("record-servos", false, "capture the position of every servo on the bus, and dump it alongside the flight recorder (to compare with the simulator, via hexapod-diff)")
+	safeMode          = flag.Bool("safe-mode", false, "restrict the speed, torque, clearance, and gait, and require arming (circle + square), for the first boot on new hardware")
 )
 
 func main() {
@@ -38,7 +87,45 @@ func main() {
 	var err error
 
 	if *debug {
-		log.SetLevel(log.DebugLevel)
+		hexapod.SetLogLevel("", log.DebugLevel)
+	}
+
+	err = hexapod.SetLogLevels(*logLevels)
+	if err != nil {
+		log.Fatalf("error setting log levels: %s", err)
+	}
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		log.Fatalf("error loading config: %s", err)
+	}
+
+	if *name != "" {
+		cfg.Identity.Name = *name
+	}
+
+	// This goes after everything else which changes the config, so nothing can
+	// relax it.
+	if *safeMode {
+		cfg = cfg.Safe()
+		log.Warnf("SAFE MODE: torque limited to %d, clearance to %.0f-%.0fmm, turbo and profiles disabled",
+			config.SafeTorqueLimit, cfg.Controller.MinClearance, cfg.Controller.MaxClearance)
+	}
+
+	if *decode != "" {
+		err = decodeDump(*decode)
+		if err != nil {
+			log.Fatalf("error decoding flight recorder dump: %s", err)
+		}
+		return
+	}
+
+	if *dumpWorkspace != "" {
+		err = writeWorkspaces(*dumpWorkspace, cfg)
+		if err != nil {
+			log.Fatalf("error writing leg workspaces: %s", err)
+		}
+		return
 	}
 
 	sOpts := serial.OpenOptions{
@@ -50,8 +137,70 @@ func main() {
 		InterCharacterTimeout: 100,
 	}
 
+	var bus *sim.Bus
+	if *simulate {
+		bus = sim.NewBus()
+	}
+
+	overrides, err := hexapod.ParseOverrides(*enable, *disable)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	opts := builtin.Options{
+		Bus:               bus,
+		Offline:           *offline,
+		FPS:               *fps,
+		ControllerPort:    *controllerPort,
+		CalibrationPath:   *calibrationPath,
+		SelfTestAtBoot:    *selfTest,
+		RangefinderIIO:    *rangefinderIIO,
+		BuzzerPWM:         *buzzerPWM,
+		RecorderDir:       *recorderDir,
+		HTTPPort:          *httpPort,
+		TelemetryPort:     *telemetryPort,
+		TelemetryRate:     *telemetryRate,
+		TrackerPort:       *trackerPort,
+		ConsoleSocket:     *consoleSocket,
+		MQTTBroker:        *mqttBroker,
+		MQTTPrefix:        *mqttPrefix,
+		MQTTInterval:      *mqttInterval,
+		RosbridgeURL:      *rosbridgeURL,
+		RosbridgePrefix:   *rosbridgePrefix,
+		RosbridgeCmdVel:   *rosbridgeCmdVel,
+		RosbridgeRate:     *rosbridgeRate,
+		DiscoveryPort:     *discoveryPort,
+		DiscoveryInterval: *discoveryInterval,
+		SettingsPath:      *settingsPath,
+		OdometerPath:      *odometerPath,
+		ConfigPath:        *configPath,
+		ConfigWatch:       *configWatch,
+		StateLogDir:       *stateLogDir,
+		StateLogFormat:    *stateLogFormat,
+		StateLogFields:    *stateLogFields,
+		StateLogSize:      *stateLogSize,
+	}
+
+	// The simulated servos come with a simulated IMU.
+	if bus != nil {
+		opts.IMU = bus.IMU()
+	}
+
+	// This is before anything is opened, so it works without the hardware.
+	if *listComponents {
+		err = builtin.New(nil, cfg, opts).Catalog().List(os.Stdout, cfg.Components, overrides, cfg.SafeOverrides())
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	var srl io.ReadWriteCloser
-	if *offline {
+	if bus != nil {
+		log.Warn("using simulated servos")
+		srl = bus
+
+	} else if *offline {
 		log.Warn("using fake serial port")
 		srl = &fake_serial.FakeSerial{}
 
@@ -72,7 +221,14 @@ func main() {
 		log.Infof("purged %d bytes", len(b))
 	}
 
-	network := network.New(srl)
+	// Everything is written via the port, so the watchdog can write to it in an
+	// emergency without splitting a packet.
+	port := servos.NewPort(srl)
+	port.Latency = servos.NewLatency(cfg.Bus)
+	if *recordServos {
+		port.Capture = servos.NewCapture(captureSize * *fps)
+	}
+	network := network.New(port)
 	network.Timeout = 1 * time.Second
 
 	// Optionally log network traffic. This is VERY verbose!
@@ -82,124 +238,86 @@ func main() {
 		})
 	}
 
-	h := hexapod.NewHexapod(network, *fps)
-
-	log.Infof("initializing loop at %dfps", *fps)
-	ticker := time.NewTicker(time.Duration(1000000000 / *fps))
-
-	if *httpPort > 0 {
-		log.Info("starting HTTP interface")
-		go h.RunServer(*httpPort)
-	} else {
-		log.Warn("HTTP interface disabled")
-	}
-
-	log.Info("creating components")
-	l := legs.New(network)
-	h.Add(l)
-
-	var f *os.File
-	if *offline {
-		log.Warn("using fake controller")
-		f, _ = os.Open("/dev/null")
-		defer f.Close()
+	opts.Network = network
+	opts.Port = port
 
-	} else {
-		log.Info("opening controller")
-		f, err = os.Open(*controllerPort)
-		if err != nil {
-			log.Fatalf("error opening controller: %s", err)
-		}
-		defer f.Close()
-	}
-	h.Add(controller.New(f))
+	h := hexapod.New(network, cfg)
+	h.TargetFPS = *fps
 
-	var v voltage.HasVoltage
-	if *offline {
-		log.Warn("using fake voltage check")
-		v = fake_voltage.New(9.6)
-	} else {
-		v = l.Legs[0].Coxa
+	if *diffState {
+		h.StateDiff = hexapod.NewStateDiff(nil)
 	}
-	h.Add(voltage.New(v))
 
-	headH, err := servos.New(network, 71)
-	if err != nil {
-		log.Fatalf("error while initializing servo #71: %s", err)
-	}
-	headV, err := servos.New(network, 72)
+	_, err = diag.Start(*diagPort, *diagPublic, h, port.Latency)
 	if err != nil {
-		log.Fatalf("error while initializing servo #72: %s", err)
+		log.Fatalf("error starting diagnostics server: %s", err)
 	}
-	h.Add(head.New(
-		math3d.Pose{math3d.Vector3{X: 0, Y: 43.0, Z: 70}, 0, 0, 0},
-		headH,
-		headV))
 
-	log.Info("booting components")
-	err = h.Boot()
+	log.Info("creating components")
+	b := builtin.New(h, cfg, opts)
+	cs, err := b.Catalog().Build(cfg.Components, overrides, cfg.SafeOverrides())
 	if err != nil {
-		log.Fatalf("error while booting: %s", err)
+		log.Fatalf("error creating components: %s", err)
 	}
+	h.Register(cs...)
 
 	// Catch both SIGINT (ctrl+c) and SIGTERM (kill/systemd), to allow the hexapod
 	// to power down its servos before exiting.
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Reload the config on SIGHUP. The changes are applied between ticks.
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
 	go func() {
-		for _ = range c {
-			if !h.State.Shutdown {
-				log.Warn("caught signal, requesting shutdown...")
-				h.State.Shutdown = true
+		for _ = range hup {
+			if rl := b.Reloader(); rl != nil {
+				rl.Reload()
 			}
 		}
 	}()
 
-	// Recover from any panics which occurred in the main loop, and shut down
-	// the servos before exiting.
-	defer func() {
-		if r := recover(); r != nil {
-			log.Warnf("recovered from panic: %s", r)
-			servos.Shutdown()
-			os.Exit(1)
-		}
-	}()
-
-	// This is set as soon as h.State.Shutdown becomes true.
-	var shutdownPending time.Time
+	err = h.Run(ctx)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
 
-	// How long to wait for components to stop after requesting shutdown.
-	gracePeriod := 2000 * time.Millisecond
+// decodeDump writes the flight recorder dump at the given path to stdout as CSV.
+func decodeDump(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
 
-	// Run forever
-	// TODO: Move this loop into the hexapod type.
-	log.Info("starting loop")
-	for now := range ticker.C {
-		err = h.Tick(now)
+	records, err := recorder.Decode(bufio.NewReader(f))
+	if err != nil {
+		return err
+	}
 
-		if err != nil {
-			panic(err)
-		}
+	return recorder.WriteCSV(os.Stdout, records)
+}
 
-		// Continue looping if shutdown wasn't requested
-		if !h.State.Shutdown {
-			continue
-		}
+// writeWorkspaces writes the workspace of each leg to the given path, as PLY if
+// it ends with .ply, or JSON otherwise.
+func writeWorkspaces(path string, cfg config.Config) error {
+	wss := legs.Workspaces(cfg.Legs, cfg.Controller.Clearance)
 
-		// On the first loop after shutdown being set, note the time, so we can
-		// continue looping for the grace period without sleeping.
-		if shutdownPending.IsZero() {
-			log.Warnf("shutdown requested, waiting %s...", gracePeriod)
-			shutdownPending = time.Now()
-			continue
-		}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
 
-		// Once the grace period is up, power off the servos and exit.
-		if time.Since(shutdownPending) > gracePeriod {
-			log.Warn("done waiting, shutting down")
-			ticker.Stop()
-			servos.Shutdown()
-			break
-		}
+	if strings.HasSuffix(path, ".ply") {
+		err = legs.WriteWorkspacesPLY(f, wss)
+	} else {
+		err = legs.WriteWorkspacesJSON(f, wss)
+	}
+	if err != nil {
+		f.Close()
+		return err
 	}
+
+	return f.Close()
 }
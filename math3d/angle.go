@@ -0,0 +1,33 @@
+package math3d
+
+import (
+	"math"
+)
+
+// The functions in this file operate on angles in degrees, since that's what
+// poses are stored in. Use utils.Rad and utils.Deg to convert.
+
+// WrapDegrees returns the given angle wrapped into the range (-180, +180].
+func WrapDegrees(a float64) float64 {
+	a = math.Mod(a, 360)
+	if a > 180 {
+		a -= 360
+	} else if a <= -180 {
+		a += 360
+	}
+
+	return a
+}
+
+// AngleDiff returns the signed shortest difference a-b, in the range (-180,
+// +180]. That is, the smallest angle which can be added to b to get to a,
+// modulo 360. For example, AngleDiff(-170, 170) is 20, not -340.
+func AngleDiff(a, b float64) float64 {
+	return WrapDegrees(a - b)
+}
+
+// ClampDegrees returns the given angle wrapped into (-180, +180], then clamped
+// to between min and max, which should be within the same range.
+func ClampDegrees(a, min, max float64) float64 {
+	return math.Max(min, math.Min(max, WrapDegrees(a)))
+}
@@ -0,0 +1,108 @@
+package math3d
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWrapDegrees(t *testing.T) {
+	type eg struct {
+		in  float64
+		out float64
+	}
+
+	examples := []eg{
+		{0, 0},
+		{90, 90},
+		{-90, -90},
+		{179.9, 179.9},
+		{180, 180},
+		{-180, 180},
+		{-179.9, -179.9},
+		{180.1, -179.9},
+		{270, -90},
+		{-270, 90},
+		{360, 0},
+		{-360, 0},
+		{540, 180},
+		{-540, 180},
+		{720 + 45, 45},
+		{-720 - 45, -45},
+		{3600 + 180, 180},
+	}
+
+	for _, x := range examples {
+		assert.InDelta(t, x.out, WrapDegrees(x.in), epsilon, "WrapDegrees(%v)", x.in)
+	}
+
+	// Always in range, and equivalent to the input.
+	for a := -1000.0; a <= 1000; a += 0.5 {
+		w := WrapDegrees(a)
+		assert.True(t, w > -180 && w <= 180, "WrapDegrees(%v) = %v", a, w)
+		assert.InDelta(t, 0, math.Mod(a-w, 360), epsilon)
+	}
+}
+
+func TestAngleDiff(t *testing.T) {
+	type eg struct {
+		a   float64
+		b   float64
+		out float64
+	}
+
+	examples := []eg{
+		{45, 45, 0},
+		{-170, 170, 20},
+		{170, -170, -20},
+		{180, 0, 180},
+		{-180, 0, 180},
+		{0, 180, 180},
+		{0, -180, 180},
+		{0, 730, -10},
+		{-10, 10, -20},
+		{10, -10, 20},
+		{-350, 0, 10},
+		{-90, 90, 180},
+		{1, -179, 180},
+		{2, -179, -179},
+	}
+
+	for _, x := range examples {
+		assert.InDelta(t, x.out, AngleDiff(x.a, x.b), epsilon, "AngleDiff(%v, %v)", x.a, x.b)
+	}
+
+	// Adding the difference to b always gets (a multiple of 360 away from) a.
+	for a := -400.0; a <= 400; a += 7 {
+		for b := -400.0; b <= 400; b += 11 {
+			d := AngleDiff(a, b)
+			assert.True(t, d > -180 && d <= 180)
+			assert.InDelta(t, 0, WrapDegrees(b+d-a), epsilon)
+		}
+	}
+}
+
+func TestClampDegrees(t *testing.T) {
+	type eg struct {
+		in  float64
+		min float64
+		max float64
+		out float64
+	}
+
+	examples := []eg{
+		{10, -15, 15, 10},
+		{20, -15, 15, 15},
+		{-20, -15, 15, -15},
+		{350, -15, 15, -10},
+		{-350, -15, 15, 10},
+		{190, -15, 15, -15},
+		{180, -180, 180, 180},
+		{-180, -180, 0, 0},
+	}
+
+	for _, x := range examples {
+		assert.InDelta(t, x.out, ClampDegrees(x.in, x.min, x.max), epsilon, "ClampDegrees(%v, %v, %v)", x.in, x.min, x.max)
+	}
+}
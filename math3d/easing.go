@@ -0,0 +1,44 @@
+package math3d
+
+// Easing maps the fraction of time elapsed (from zero to one) to the fraction
+// of the distance which should have been covered, for use with Interpolate.
+type Easing func(t float64) float64
+
+// Linear moves at a constant speed.
+func Linear(t float64) float64 {
+	return clamp01(t)
+}
+
+// EaseInCubic starts slowly, and accelerates until the end.
+func EaseInCubic(t float64) float64 {
+	t = clamp01(t)
+	return t * t * t
+}
+
+// EaseOutCubic starts quickly, and decelerates until the end.
+func EaseOutCubic(t float64) float64 {
+	t = clamp01(t) - 1
+	return (t * t * t) + 1
+}
+
+// EaseInOutCubic accelerates until half way, then decelerates. This is the one
+// to use for moving the chassis around, since it never jerks.
+func EaseInOutCubic(t float64) float64 {
+	t = clamp01(t)
+	if t < 0.5 {
+		return 4 * t * t * t
+	}
+
+	t = (2 * t) - 2
+	return (0.5 * t * t * t) + 1
+}
+
+func clamp01(t float64) float64 {
+	if t < 0 {
+		return 0
+	}
+	if t > 1 {
+		return 1
+	}
+	return t
+}
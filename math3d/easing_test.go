@@ -0,0 +1,32 @@
+package math3d
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEasing(t *testing.T) {
+	for _, f := range []Easing{Linear, EaseInCubic, EaseOutCubic, EaseInOutCubic} {
+
+		// All start at zero and end at one, and don't overshoot.
+		assert.InDelta(t, 0, f(0), 0.0001)
+		assert.InDelta(t, 1, f(1), 0.0001)
+		assert.InDelta(t, 0, f(-1), 0.0001)
+		assert.InDelta(t, 1, f(2), 0.0001)
+
+		// And never go backwards.
+		prev := 0.0
+		for i := 0; i <= 100; i++ {
+			v := f(float64(i) / 100)
+			assert.True(t, v >= prev)
+			prev = v
+		}
+	}
+
+	assert.InDelta(t, 0.5, EaseInOutCubic(0.5), 0.0001)
+	assert.InDelta(t, 0.032, EaseInOutCubic(0.2), 0.0001)
+	assert.InDelta(t, 0.968, EaseInOutCubic(0.8), 0.0001)
+	assert.InDelta(t, 0.125, EaseInCubic(0.5), 0.0001)
+	assert.InDelta(t, 0.875, EaseOutCubic(0.5), 0.0001)
+}
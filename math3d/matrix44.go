@@ -24,6 +24,10 @@ type Matrix44 struct {
 	m44 float64 // 15
 }
 
+var (
+	IdentityMatrix44 = Matrix44{m11: 1, m22: 1, m33: 1, m44: 1}
+)
+
 func MakeMatrix44(v Vector3, ea EulerAngles) *Matrix44 {
 	m := &Matrix44{}
 	m.SetRotation(ea)
@@ -31,6 +35,15 @@ func MakeMatrix44(v Vector3, ea EulerAngles) *Matrix44 {
 	return m
 }
 
+// FromPose returns the matrix which transforms from the space of the given pose
+// to its parent space. It's the same as Pose.ToWorld.
+func FromPose(p Pose) Matrix44 {
+	m := Matrix44{}
+	m.SetRotation(p.ea())
+	m.SetTranslation(p.Position)
+	return m
+}
+
 func (m Matrix44) String() string {
 	return fmt.Sprintf(
 		"&M44{%+.4f %+.4f %+.4f %+.4f | %+.4f %+.4f %+.4f %+.4f | %+.4f %+.4f %+.4f %+.4f | %+.4f %+.4f %+.4f %+.4f}",
@@ -51,7 +64,11 @@ func (m Matrix44) Elements() [4][4]float64 {
 	}
 }
 
-// Inverse returns the inverse of the matrix.
+// Inverse returns the inverse of the matrix, such that multiplying the two
+// together (in either order) gives the identity. This works for any invertible
+// matrix, not only for rotations and translations. Singular matrices have no
+// inverse, so the zero matrix is returned instead; check Determinant first if
+// that might happen.
 //
 // This implementation is stolen from threejs, because I don't fully understand
 // it yet. I was expecting it to be rather simpler.
@@ -59,6 +76,38 @@ func (m Matrix44) Elements() [4][4]float64 {
 // See: https://github.com/mrdoob/three.js/blob/master/src/math/Matrix4.js#L605
 //
 func (m Matrix44) Inverse() Matrix44 {
+	a := m.adjugate()
+	d := m.determinant(a)
+	if d == 0 {
+		return Matrix44{}
+	}
+
+	return a.scaled(1 / d)
+}
+
+// Determinant returns the determinant of the matrix, which is zero if it has no
+// inverse, and one if it's only a rotation and translation.
+func (m Matrix44) Determinant() float64 {
+	return m.determinant(m.adjugate())
+}
+
+// determinant returns the determinant of the matrix, given its adjugate.
+func (m Matrix44) determinant(a Matrix44) float64 {
+	return (m.m11 * a.m11) + (m.m12 * a.m21) + (m.m13 * a.m31) + (m.m14 * a.m41)
+}
+
+func (m Matrix44) scaled(s float64) Matrix44 {
+	return Matrix44{
+		m.m11 * s, m.m12 * s, m.m13 * s, m.m14 * s,
+		m.m21 * s, m.m22 * s, m.m23 * s, m.m24 * s,
+		m.m31 * s, m.m32 * s, m.m33 * s, m.m34 * s,
+		m.m41 * s, m.m42 * s, m.m43 * s, m.m44 * s,
+	}
+}
+
+// adjugate returns the transpose of the cofactor matrix, which is the inverse
+// multiplied by the determinant.
+func (m Matrix44) adjugate() Matrix44 {
 	return Matrix44{
 		(m.m23 * m.m34 * m.m42) - (m.m24 * m.m33 * m.m42) + (m.m24 * m.m32 * m.m43) - (m.m22 * m.m34 * m.m43) - (m.m23 * m.m32 * m.m44) + (m.m22 * m.m33 * m.m44),
 		(m.m14 * m.m33 * m.m42) - (m.m13 * m.m34 * m.m42) - (m.m14 * m.m32 * m.m43) + (m.m12 * m.m34 * m.m43) + (m.m13 * m.m32 * m.m44) - (m.m12 * m.m33 * m.m44),
@@ -82,7 +131,15 @@ func (m Matrix44) Inverse() Matrix44 {
 // MultiplyMatrices multiplies two 4x4 matrices together, and returns a pointer
 // to the result.
 func MultiplyMatrices(a Matrix44, b Matrix44) *Matrix44 {
-	return &Matrix44{
+	m := a.MultiplyMatrix(b)
+	return &m
+}
+
+// MultiplyMatrix returns the product a*b. Since vectors are multiplied as rows,
+// this is the transformation a followed by b. That is, transforming a vector by
+// the result is the same as transforming it by a, then by b.
+func (a Matrix44) MultiplyMatrix(b Matrix44) Matrix44 {
+	return Matrix44{
 		(a.m11 * b.m11) + (a.m12 * b.m21) + (a.m13 * b.m31) + (a.m14 * b.m41),
 		(a.m11 * b.m12) + (a.m12 * b.m22) + (a.m13 * b.m32) + (a.m14 * b.m42),
 		(a.m11 * b.m13) + (a.m12 * b.m23) + (a.m13 * b.m33) + (a.m14 * b.m43),
@@ -1,7 +1,11 @@
 package math3d
 
 import (
+	"math"
 	"testing"
+
+	"github.com/adammck/hexapod/utils"
+	"github.com/stretchr/testify/assert"
 )
 
 func TestMakeMatrix44(t *testing.T) {
@@ -46,3 +50,125 @@ func TestMultiply(t *testing.T) {
 		}
 	}
 }
+
+func matricesEqual(a, b Matrix44, delta float64) bool {
+	ea, eb := a.Elements(), b.Elements()
+	for r := range ea {
+		for c := range ea[r] {
+			if math.Abs(ea[r][c]-eb[r][c]) > delta {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+func TestMatrixInverse(t *testing.T) {
+	f := func(ea EulerAngles, v Vector3) bool {
+		m := *MakeMatrix44(v, ea)
+		i := m.Inverse()
+		return matricesEqual(IdentityMatrix44, m.MultiplyMatrix(i), 0.0001) &&
+			matricesEqual(IdentityMatrix44, i.MultiplyMatrix(m), 0.0001) &&
+			math.Abs(m.Determinant()-1) < epsilon
+	}
+
+	check(t, f)
+
+	// Not only rigid transforms.
+	m := Matrix44{2, 0, 0, 0, 0, 4, 0, 0, 0, 0, 0.5, 0, 1, 2, 3, 1}
+	assert.InDelta(t, 4, m.Determinant(), epsilon)
+	assert.True(t, matricesEqual(IdentityMatrix44, m.MultiplyMatrix(m.Inverse()), epsilon))
+	assert.Equal(t, Vector3{1, 1, 1}, Vector3{3, 6, 3.5}.TransformPoint(m.Inverse()))
+
+	m = Matrix44{2, 1, 0, 3, 1, 3, 2, 0, 0, 1, 4, 1, 5, 0, 1, 2}
+	assert.InDelta(t, -136, m.Determinant(), epsilon)
+	assert.True(t, matricesEqual(IdentityMatrix44, m.MultiplyMatrix(m.Inverse()), epsilon))
+
+	// Singular.
+	m = Matrix44{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}
+	assert.Equal(t, 0.0, m.Determinant())
+	assert.Equal(t, Matrix44{}, m.Inverse())
+	assert.Equal(t, IdentityMatrix44, IdentityMatrix44.Inverse())
+}
+
+func TestFromPose(t *testing.T) {
+	f := func(ea EulerAngles, pos, v Vector3) bool {
+		p := Pose{Position: pos, Heading: utils.Deg(ea.Heading), Pitch: utils.Deg(ea.Pitch), Bank: utils.Deg(ea.Bank)}
+		m := FromPose(p)
+		return vectorsEqual(p.Add(Pose{Position: v}).Position, v.TransformPoint(m), 0.0001) &&
+			vectorsEqual(v, v.TransformPoint(m).TransformPoint(p.ToLocal()), 0.0001)
+	}
+
+	check(t, f)
+}
+
+func TestTransformDirection(t *testing.T) {
+	m := FromPose(Pose{Position: Vector3{100, 200, 300}, Heading: 90})
+	assert.True(t, vectorsEqual(Vector3{0, 0, -1}, Vector3{1, 0, 0}.TransformDirection(m), epsilon))
+	assert.True(t, vectorsEqual(Vector3{100, 200, 299}, Vector3{1, 0, 0}.TransformPoint(m), epsilon))
+	assert.Equal(t, ZeroVector3, ZeroVector3.TransformDirection(m))
+
+	// A direction is the difference between two points.
+	f := func(ea EulerAngles, pos, a, b Vector3) bool {
+		m := *MakeMatrix44(pos, ea)
+		return vectorsEqual(a.Subtract(b).TransformDirection(m), a.TransformPoint(m).Subtract(b.TransformPoint(m)), 0.0001)
+	}
+
+	check(t, f)
+}
+
+func TestMultiplyMatrixAssociative(t *testing.T) {
+	f := func(a, b, c EulerAngles, va, vb, vc, v Vector3) bool {
+		ma, mb, mc := *MakeMatrix44(va, a), *MakeMatrix44(vb, b), *MakeMatrix44(vc, c)
+		l := ma.MultiplyMatrix(mb).MultiplyMatrix(mc)
+		r := ma.MultiplyMatrix(mb.MultiplyMatrix(mc))
+		return matricesEqual(l, r, 0.0001) &&
+			vectorsEqual(v.TransformPoint(l), v.TransformPoint(ma).TransformPoint(mb).TransformPoint(mc), 0.0001)
+	}
+
+	check(t, f)
+}
+
+var (
+	benchMatrix Matrix44
+	benchVector Vector3
+)
+
+func BenchmarkFromPose(b *testing.B) {
+	p := Pose{Position: Vector3{1, 2, 3}, Heading: 30, Pitch: 5, Bank: -5}
+	for i := 0; i < b.N; i++ {
+		benchMatrix = FromPose(p)
+	}
+}
+
+func BenchmarkInverse(b *testing.B) {
+	m := *MakeMatrix44(Vector3{1, 2, 3}, EulerAngles{0.1, 0.2, 0.3})
+	for i := 0; i < b.N; i++ {
+		benchMatrix = m.Inverse()
+	}
+}
+
+func BenchmarkMultiplyMatrix(b *testing.B) {
+	m := *MakeMatrix44(Vector3{1, 2, 3}, EulerAngles{0.1, 0.2, 0.3})
+	n := *MakeMatrix44(Vector3{3, 2, 1}, EulerAngles{0.3, 0.2, 0.1})
+	for i := 0; i < b.N; i++ {
+		benchMatrix = m.MultiplyMatrix(n)
+	}
+}
+
+func BenchmarkTransformPoint(b *testing.B) {
+	m := *MakeMatrix44(Vector3{1, 2, 3}, EulerAngles{0.1, 0.2, 0.3})
+	v := Vector3{10, 20, 30}
+	for i := 0; i < b.N; i++ {
+		benchVector = v.TransformPoint(m)
+	}
+}
+
+func BenchmarkTransformDirection(b *testing.B) {
+	m := *MakeMatrix44(Vector3{1, 2, 3}, EulerAngles{0.1, 0.2, 0.3})
+	v := Vector3{10, 20, 30}
+	for i := 0; i < b.N; i++ {
+		benchVector = v.TransformDirection(m)
+	}
+}
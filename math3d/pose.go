@@ -2,6 +2,7 @@ package math3d
 
 import (
 	"fmt"
+
 	"github.com/adammck/hexapod/utils"
 )
 
@@ -27,12 +28,35 @@ func (p Pose) Add(pp Pose) Pose {
 	}
 }
 
+// Inverse returns the pose which undoes this one, such that p.Add(p.Inverse())
+// is always the zero pose. Its position is the origin of the parent space, as
+// seen from this pose, and its angles are negated.
+//
+// Like Add, this treats the angles as independent, so p.Inverse().Add(p) is
+// only zero (and RelativeTo only exact) if p rotates around a single axis,
+// which is usually just the heading.
+func (p Pose) Inverse() Pose {
+	return Pose{
+		Position: ZeroVector3.MultiplyByMatrix44(p.ToLocal()),
+		Heading:  -p.Heading,
+		Pitch:    -p.Pitch,
+		Bank:     -p.Bank,
+	}
+}
+
+// RelativeTo returns this pose (in the parent space) expressed in the space of
+// the other pose, such that other.Add(p.RelativeTo(other)) is p again. It's the
+// same as other.Inverse().Add(p); see Inverse for the caveats.
+func (p Pose) RelativeTo(other Pose) Pose {
+	return other.Inverse().Add(p)
+}
+
 func (p Pose) ToWorld() Matrix44 {
-	return *MakeMatrix44(p.Position, p.ea())
+	return FromPose(p)
 }
 
 func (p Pose) ToLocal() Matrix44 {
-	return MakeMatrix44(p.Position, p.ea()).Inverse()
+	return FromPose(p).Inverse()
 }
 
 // Out returns the given pose (which is assumed to be in this pose's coordinate
@@ -55,3 +79,38 @@ func (p Pose) ea() EulerAngles {
 		Bank:    utils.Rad(p.Bank),
 	}
 }
+
+// MakePose returns a pose at the given position, with the given orientation.
+// See Quaternion.EulerAngles for the range of the resulting angles.
+func MakePose(v Vector3, q Quaternion) Pose {
+	ea := q.EulerAngles()
+	return Pose{
+		Position: v,
+		Heading:  utils.Deg(ea.Heading),
+		Pitch:    utils.Deg(ea.Pitch),
+		Bank:     utils.Deg(ea.Bank),
+	}
+}
+
+// Orientation returns the rotation of the pose as a quaternion, which rotates
+// the same as the rotation part of ToWorld.
+func (p Pose) Orientation() Quaternion {
+	return FromEulerAngles(p.ea())
+}
+
+// Interpolate returns the pose at t (usually between zero and one) of the way
+// from this pose to the other. The position is interpolated linearly, and each
+// angle along the shortest arc, so e.g. a heading of 170 to -170 goes via 180
+// rather than via zero.
+//
+// The angles aren't wrapped; the result is the angle of this pose plus some
+// fraction of the arc, so it might be outside of +/- 180. That keeps headings
+// continuous, which matters because they're compared all over the place.
+func (p Pose) Interpolate(pp Pose, t float64) Pose {
+	return Pose{
+		Position: *p.Position.Add(pp.Position.Subtract(p.Position).MultiplyByScalar(t)),
+		Heading:  p.Heading + (AngleDiff(pp.Heading, p.Heading) * t),
+		Pitch:    p.Pitch + (AngleDiff(pp.Pitch, p.Pitch) * t),
+		Bank:     p.Bank + (AngleDiff(pp.Bank, p.Bank) * t),
+	}
+}
@@ -1,8 +1,10 @@
 package math3d
 
 import (
-	"github.com/stretchr/testify/assert"
+	"math"
 	"testing"
+
+	"github.com/stretchr/testify/assert"
 )
 
 func TestAdd(t *testing.T) {
@@ -14,29 +16,29 @@ func TestAdd(t *testing.T) {
 
 	examples := []eg{
 		{
-			recv: Pose{Vector3{+0, +0, +0}, 0},
-			arg:  Pose{Vector3{+0, +0, +0}, 0},
-			out:  Pose{Vector3{+0, +0, +0}, 0},
+			recv: Pose{Position: Vector3{+0, +0, +0}, Heading: 0},
+			arg:  Pose{Position: Vector3{+0, +0, +0}, Heading: 0},
+			out:  Pose{Position: Vector3{+0, +0, +0}, Heading: 0},
 		},
 		{
-			recv: Pose{Vector3{+0, +0, +0}, 90},
-			arg:  Pose{Vector3{+1, +0, +0}, 0},
-			out:  Pose{Vector3{+0, +0, -1}, 90},
+			recv: Pose{Position: Vector3{+0, +0, +0}, Heading: 90},
+			arg:  Pose{Position: Vector3{+1, +0, +0}, Heading: 0},
+			out:  Pose{Position: Vector3{+0, +0, -1}, Heading: 90},
 		},
 		{
-			recv: Pose{Vector3{+0, +0, +0}, 180},
-			arg:  Pose{Vector3{+1, +0, +0}, 0},
-			out:  Pose{Vector3{-1, +0, +0}, 180},
+			recv: Pose{Position: Vector3{+0, +0, +0}, Heading: 180},
+			arg:  Pose{Position: Vector3{+1, +0, +0}, Heading: 0},
+			out:  Pose{Position: Vector3{-1, +0, +0}, Heading: 180},
 		},
 		{
-			recv: Pose{Vector3{+0, +0, +0}, 270},
-			arg:  Pose{Vector3{+1, +0, +0}, 0},
-			out:  Pose{Vector3{+0, +0, +1}, 270},
+			recv: Pose{Position: Vector3{+0, +0, +0}, Heading: 270},
+			arg:  Pose{Position: Vector3{+1, +0, +0}, Heading: 0},
+			out:  Pose{Position: Vector3{+0, +0, +1}, Heading: 270},
 		},
 		{
-			recv: Pose{Vector3{+9, +1, +9}, 90},
-			arg:  Pose{Vector3{+1, +0, +0}, 90},
-			out:  Pose{Vector3{+9, +1, +8}, 180},
+			recv: Pose{Position: Vector3{+9, +1, +9}, Heading: 90},
+			arg:  Pose{Position: Vector3{+1, +0, +0}, Heading: 90},
+			out:  Pose{Position: Vector3{+9, +1, +8}, Heading: 180},
 		},
 	}
 
@@ -58,9 +60,9 @@ func TestOut(t *testing.T) {
 
 	examples := []eg{
 		{
-			recv: Pose{Vector3{+0, +0, -8}, 90},
-			arg:  Pose{Vector3{+0, +0, -9}, 0},
-			out:  Pose{Vector3{+1, +0, +0}, -90},
+			recv: Pose{Position: Vector3{+0, +0, -8}, Heading: 90},
+			arg:  Pose{Position: Vector3{+0, +0, -9}, Heading: 0},
+			out:  Pose{Position: Vector3{+1, +0, +0}, Heading: -90},
 		},
 	}
 
@@ -72,3 +74,129 @@ func TestOut(t *testing.T) {
 		assert.InDelta(t, act.Heading, x.out.Heading, 0.01, "expected example %d:H to be %0.2f, but was %0.2f", i+1, x.out.Heading, act.Heading)
 	}
 }
+
+func TestInterpolate(t *testing.T) {
+	type eg struct {
+		a   Pose
+		b   Pose
+		t   float64
+		out Pose
+	}
+
+	examples := []eg{
+		{
+			a:   Pose{Position: Vector3{0, 0, 0}, Heading: 0},
+			b:   Pose{Position: Vector3{10, 20, -30}, Heading: 90, Pitch: 10, Bank: -10},
+			t:   0.5,
+			out: Pose{Position: Vector3{5, 10, -15}, Heading: 45, Pitch: 5, Bank: -5},
+		},
+
+		// Across the wrap, in both directions, via 180 rather than zero.
+		{
+			a:   Pose{Heading: 170},
+			b:   Pose{Heading: -170},
+			t:   0.5,
+			out: Pose{Heading: 180},
+		},
+		{
+			a:   Pose{Heading: -170},
+			b:   Pose{Heading: 170},
+			t:   0.25,
+			out: Pose{Heading: -175},
+		},
+
+		// Headings outside of +/- 180 are fine, and aren't wrapped.
+		{
+			a:   Pose{Heading: 350},
+			b:   Pose{Heading: 10},
+			t:   0.5,
+			out: Pose{Heading: 360},
+		},
+		{
+			a:   Pose{Heading: 720},
+			b:   Pose{Heading: 90},
+			t:   1,
+			out: Pose{Heading: 810},
+		},
+
+		// The ends.
+		{
+			a:   Pose{Position: Vector3{1, 2, 3}, Bank: 170},
+			b:   Pose{Position: Vector3{4, 5, 6}, Bank: -170},
+			t:   0,
+			out: Pose{Position: Vector3{1, 2, 3}, Bank: 170},
+		},
+		{
+			a:   Pose{Position: Vector3{1, 2, 3}, Pitch: -170},
+			b:   Pose{Position: Vector3{4, 5, 6}, Pitch: 170},
+			t:   1,
+			out: Pose{Position: Vector3{4, 5, 6}, Pitch: -190},
+		},
+	}
+
+	for i, x := range examples {
+		act := x.a.Interpolate(x.b, x.t)
+		assert.InDelta(t, x.out.Position.X, act.Position.X, 0.01, "example %d:X", i+1)
+		assert.InDelta(t, x.out.Position.Y, act.Position.Y, 0.01, "example %d:Y", i+1)
+		assert.InDelta(t, x.out.Position.Z, act.Position.Z, 0.01, "example %d:Z", i+1)
+		assert.InDelta(t, x.out.Heading, act.Heading, 0.01, "example %d:H", i+1)
+		assert.InDelta(t, x.out.Pitch, act.Pitch, 0.01, "example %d:P", i+1)
+		assert.InDelta(t, x.out.Bank, act.Bank, 0.01, "example %d:B", i+1)
+	}
+}
+
+func TestPoseInverse(t *testing.T) {
+	p := Pose{Position: Vector3{X: 10, Y: 40, Z: -20}, Heading: 30, Pitch: 5, Bank: -10}
+	assert.True(t, posesEqual(Pose{}, p.Add(p.Inverse())))
+
+	// Heading only, so it undoes the other way around, too.
+	p = Pose{Position: Vector3{X: 10, Y: 40, Z: -20}, Heading: 30}
+	assert.True(t, posesEqual(Pose{}, p.Inverse().Add(p)))
+	assert.True(t, posesEqual(p, p.Inverse().Inverse()))
+
+	// See TestAdd.
+	p = Pose{Position: Vector3{X: 9, Y: 1, Z: 9}, Heading: 90}
+	assert.True(t, posesEqual(Pose{Position: Vector3{X: 9, Y: -1, Z: -9}, Heading: -90}, p.Inverse()))
+	assert.True(t, posesEqual(Pose{}, Pose{}.Inverse()))
+}
+
+func TestRelativeTo(t *testing.T) {
+	type eg struct {
+		recv  Pose
+		other Pose
+		out   Pose
+	}
+
+	examples := []eg{
+		{
+			recv:  Pose{Position: Vector3{+9, +1, +8}, Heading: 180},
+			other: Pose{Position: Vector3{+9, +1, +9}, Heading: 90},
+			out:   Pose{Position: Vector3{+1, +0, +0}, Heading: 90},
+		},
+		{
+			recv:  Pose{Position: Vector3{+1, +2, +3}, Heading: 10, Pitch: 5},
+			other: Pose{},
+			out:   Pose{Position: Vector3{+1, +2, +3}, Heading: 10, Pitch: 5},
+		},
+		{
+			recv:  Pose{Position: Vector3{+1, +2, +3}, Bank: 20},
+			other: Pose{Position: Vector3{+1, +2, +3}, Bank: 20},
+			out:   Pose{},
+		},
+	}
+
+	for i, x := range examples {
+		assert.True(t, posesEqual(x.out, x.recv.RelativeTo(x.other)), "example %d: %s", i+1, x.recv.RelativeTo(x.other))
+
+		// Round trip.
+		assert.True(t, posesEqual(x.recv, x.other.Add(x.recv.RelativeTo(x.other))), "example %d", i+1)
+	}
+}
+
+func posesEqual(a, b Pose) bool {
+	const d = 0.0001
+	return vectorsEqual(a.Position, b.Position, d) &&
+		math.Abs(a.Heading-b.Heading) < d &&
+		math.Abs(a.Pitch-b.Pitch) < d &&
+		math.Abs(a.Bank-b.Bank) < d
+}
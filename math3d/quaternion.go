@@ -0,0 +1,179 @@
+package math3d
+
+import (
+	"fmt"
+	"math"
+)
+
+// Quaternion is a rotation. Unlike EulerAngles, they can be composed without
+// any weirdness near the poles, and interpolated smoothly.
+//
+// Only unit quaternions represent rotations. Everything here which returns a
+// quaternion returns a unit one if its inputs were, but floating point errors
+// add up, so call Normalize after composing a lot of them.
+type Quaternion struct {
+	W float64
+	X float64
+	Y float64
+	Z float64
+}
+
+var (
+	IdentityQuaternion = Quaternion{W: 1}
+)
+
+// FromAxisAngle returns a quaternion which rotates by the given angle (in
+// radians) around the given axis. The axis doesn't need to be a unit vector.
+// Positive angles rotate as a rotation matrix with the same angle would.
+func FromAxisAngle(axis Vector3, angle float64) Quaternion {
+	u := axis.Unit()
+	s := math.Sin(angle / 2)
+	return Quaternion{
+		W: math.Cos(angle / 2),
+		X: u.X * s,
+		Y: u.Y * s,
+		Z: u.Z * s,
+	}
+}
+
+// FromEulerAngles returns a quaternion which rotates the same as the matrix
+// returned by MakeMatrix44 for the same Euler angles. That is, applied to a
+// vector, the bank (around the Z axis) is applied first, then the heading
+// (around the Y axis), then the pitch (around the X axis), all around the
+// fixed axes of the parent space.
+func FromEulerAngles(ea EulerAngles) Quaternion {
+	p := FromAxisAngle(Vector3{X: 1}, ea.Pitch)
+	h := FromAxisAngle(Vector3{Y: 1}, ea.Heading)
+	b := FromAxisAngle(Vector3{Z: 1}, ea.Bank)
+	return p.Multiply(h).Multiply(b)
+}
+
+func (q Quaternion) String() string {
+	return fmt.Sprintf("&Quat{w=%+.4f x=%+.4f y=%+.4f z=%+.4f}", q.W, q.X, q.Y, q.Z)
+}
+
+// Multiply returns the product q*r, which is the rotation r followed by q.
+func (q Quaternion) Multiply(r Quaternion) Quaternion {
+	return Quaternion{
+		W: (q.W * r.W) - (q.X * r.X) - (q.Y * r.Y) - (q.Z * r.Z),
+		X: (q.W * r.X) + (q.X * r.W) + (q.Y * r.Z) - (q.Z * r.Y),
+		Y: (q.W * r.Y) - (q.X * r.Z) + (q.Y * r.W) + (q.Z * r.X),
+		Z: (q.W * r.Z) + (q.X * r.Y) - (q.Y * r.X) + (q.Z * r.W),
+	}
+}
+
+// Conjugate returns the conjugate of the quaternion, which (for unit
+// quaternions) is the opposite rotation.
+func (q Quaternion) Conjugate() Quaternion {
+	return Quaternion{W: q.W, X: -q.X, Y: -q.Y, Z: -q.Z}
+}
+
+// Magnitude returns the norm of the quaternion, which is one for rotations.
+func (q Quaternion) Magnitude() float64 {
+	return math.Sqrt((q.W * q.W) + (q.X * q.X) + (q.Y * q.Y) + (q.Z * q.Z))
+}
+
+// Normalize returns the quaternion scaled to a magnitude of one. The zero
+// quaternion isn't a rotation at all, so the identity is returned instead.
+func (q Quaternion) Normalize() Quaternion {
+	m := q.Magnitude()
+	if m == 0 {
+		return IdentityQuaternion
+	}
+
+	return Quaternion{W: q.W / m, X: q.X / m, Y: q.Y / m, Z: q.Z / m}
+}
+
+// Dot returns the dot product of two quaternions. For unit quaternions, this is
+// the cosine of half the angle between them.
+func (q Quaternion) Dot(r Quaternion) float64 {
+	return (q.W * r.W) + (q.X * r.X) + (q.Y * r.Y) + (q.Z * r.Z)
+}
+
+// Rotate returns the given vector rotated by the quaternion. This is the same
+// as multiplying it by the rotation part of the equivalent matrix.
+func (q Quaternion) Rotate(v Vector3) Vector3 {
+
+	// v + 2w(u×v) + 2u×(u×v), where u is the vector part of q. Cheaper than
+	// the full q*v*q' product.
+	u := Vector3{q.X, q.Y, q.Z}
+	t := u.Cross(v).Scaled(2)
+	c := u.Cross(t)
+
+	return Vector3{
+		X: v.X + (q.W * t.X) + c.X,
+		Y: v.Y + (q.W * t.Y) + c.Y,
+		Z: v.Z + (q.W * t.Z) + c.Z,
+	}
+}
+
+// EulerAngles returns the Euler angles (in the order described by
+// FromEulerAngles) which rotate the same as the quaternion. The heading is
+// always between -90 and +90 degrees, and pitch and bank between -180 and +180.
+// At exactly +/- 90 heading (gimbal lock), the bank is zero.
+func (q Quaternion) EulerAngles() EulerAngles {
+	q = q.Normalize()
+
+	// Elements of the equivalent (column-vector) rotation matrix, which is the
+	// transpose of the (row-vector) Matrix44.
+	r11 := 1 - 2*((q.Y*q.Y)+(q.Z*q.Z))
+	r12 := 2 * ((q.X * q.Y) - (q.W * q.Z))
+	r13 := 2 * ((q.X * q.Z) + (q.W * q.Y))
+	r21 := 2 * ((q.X * q.Y) + (q.W * q.Z))
+	r22 := 1 - 2*((q.X*q.X)+(q.Z*q.Z))
+	r23 := 2 * ((q.Y * q.Z) - (q.W * q.X))
+	r33 := 1 - 2*((q.X*q.X)+(q.Y*q.Y))
+
+	// Clamp, since rounding errors can push this slightly past one.
+	sh := math.Max(-1, math.Min(1, r13))
+
+	if math.Abs(sh) > 0.999999 {
+		h := math.Copysign(math.Pi/2, sh)
+		return EulerAngles{
+			Heading: h,
+			Pitch:   math.Copysign(1, sh) * math.Atan2(r21, r22),
+			Bank:    0,
+		}
+	}
+
+	return EulerAngles{
+		Heading: math.Asin(sh),
+		Pitch:   math.Atan2(-r23, r33),
+		Bank:    math.Atan2(-r12, r11),
+	}
+}
+
+// Slerp returns the rotation t (between zero and one) of the way from q to r,
+// along the shortest arc, at a constant angular speed.
+func (q Quaternion) Slerp(r Quaternion, t float64) Quaternion {
+	d := q.Dot(r)
+
+	// r and -r are the same rotation, but interpolating towards the one which is
+	// further away goes the long way round.
+	if d < 0 {
+		r = Quaternion{W: -r.W, X: -r.X, Y: -r.Y, Z: -r.Z}
+		d = -d
+	}
+
+	// Very close together, so lerp to avoid dividing by (almost) zero.
+	if d > 0.9995 {
+		return Quaternion{
+			W: q.W + (r.W-q.W)*t,
+			X: q.X + (r.X-q.X)*t,
+			Y: q.Y + (r.Y-q.Y)*t,
+			Z: q.Z + (r.Z-q.Z)*t,
+		}.Normalize()
+	}
+
+	theta := math.Acos(d)
+	s := math.Sin(theta)
+	a := math.Sin((1-t)*theta) / s
+	b := math.Sin(t*theta) / s
+
+	return Quaternion{
+		W: (a * q.W) + (b * r.W),
+		X: (a * q.X) + (b * r.X),
+		Y: (a * q.Y) + (b * r.Y),
+		Z: (a * q.Z) + (b * r.Z),
+	}
+}
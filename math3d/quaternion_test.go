@@ -0,0 +1,222 @@
+package math3d
+
+import (
+	"math"
+	"math/rand"
+	"reflect"
+	"testing"
+	"testing/quick"
+
+	"github.com/adammck/hexapod/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+const epsilon = 0.000001
+
+// check calls the property function f with random (but repeatable) Euler
+// angles, vectors, and quaternions, and fails the test if it ever returns
+// false. Angles are in radians, within +/- 180deg, except for the heading,
+// which is within +/- 90deg, so the Euler angles which come out of a
+// quaternion are the same as went in.
+func check(t *testing.T, f interface{}) {
+	r := rand.New(rand.NewSource(1))
+	angle := func(max float64) float64 {
+		return utils.Rad((r.Float64()*2 - 1) * max)
+	}
+
+	ft := reflect.TypeOf(f)
+	cfg := &quick.Config{
+		MaxCount: 1000,
+		Rand:     r,
+		Values: func(args []reflect.Value, r *rand.Rand) {
+			for i := range args {
+				switch ft.In(i) {
+				case reflect.TypeOf(EulerAngles{}):
+					args[i] = reflect.ValueOf(EulerAngles{
+						Heading: angle(89.9),
+						Pitch:   angle(179.9),
+						Bank:    angle(179.9),
+					})
+
+				case reflect.TypeOf(Vector3{}):
+					args[i] = reflect.ValueOf(Vector3{
+						X: (r.Float64()*2 - 1) * 1000,
+						Y: (r.Float64()*2 - 1) * 1000,
+						Z: (r.Float64()*2 - 1) * 1000,
+					})
+
+				case reflect.TypeOf(Quaternion{}):
+					axis := Vector3{r.Float64()*2 - 1, r.Float64()*2 - 1, r.Float64()*2 - 1}
+					args[i] = reflect.ValueOf(FromAxisAngle(axis, angle(180)))
+
+				default:
+					panic("can't generate " + ft.In(i).String())
+				}
+			}
+		},
+	}
+
+	assert.NoError(t, quick.Check(f, cfg))
+}
+
+func vectorsEqual(a, b Vector3, delta float64) bool {
+	return a.Subtract(b).Magnitude() < delta
+}
+
+func TestEulerRoundTrip(t *testing.T) {
+	f := func(ea EulerAngles) bool {
+		ea2 := FromEulerAngles(ea).EulerAngles()
+		return math.Abs(ea.Heading-ea2.Heading) < epsilon &&
+			math.Abs(ea.Pitch-ea2.Pitch) < epsilon &&
+			math.Abs(ea.Bank-ea2.Bank) < epsilon
+	}
+
+	check(t, f)
+}
+
+func TestQuaternionRoundTrip(t *testing.T) {
+
+	// Any quaternion can be expressed as Euler angles and back, but might come
+	// back negated, which is the same rotation. So compare by rotating stuff.
+	f := func(q Quaternion, v Vector3) bool {
+		q2 := FromEulerAngles(q.EulerAngles())
+		return vectorsEqual(q.Rotate(v), q2.Rotate(v), 0.0001)
+	}
+
+	check(t, f)
+}
+
+func TestUnitNormPreserved(t *testing.T) {
+	f := func(a, b Quaternion, ea EulerAngles) bool {
+		return math.Abs(a.Magnitude()-1) < epsilon &&
+			math.Abs(a.Multiply(b).Magnitude()-1) < epsilon &&
+			math.Abs(a.Conjugate().Magnitude()-1) < epsilon &&
+			math.Abs(FromEulerAngles(ea).Magnitude()-1) < epsilon
+	}
+
+	check(t, f)
+}
+
+func TestRotateMatchesMatrix(t *testing.T) {
+	f := func(ea EulerAngles, v Vector3) bool {
+		m := MakeMatrix44(ZeroVector3, ea)
+		return vectorsEqual(FromEulerAngles(ea).Rotate(v), v.MultiplyByMatrix44(*m), 0.0001)
+	}
+
+	check(t, f)
+}
+
+func TestComposeMatchesMatrix(t *testing.T) {
+
+	// Multiplying quaternions applies the right-hand one first. Matrices are
+	// multiplied the other way around, since vectors are rows.
+	f := func(a, b EulerAngles, v Vector3) bool {
+		q := FromEulerAngles(a).Multiply(FromEulerAngles(b))
+		m := MultiplyMatrices(*MakeMatrix44(ZeroVector3, b), *MakeMatrix44(ZeroVector3, a))
+		return vectorsEqual(q.Rotate(v), v.MultiplyByMatrix44(*m), 0.0001)
+	}
+
+	check(t, f)
+}
+
+func TestConjugateInverts(t *testing.T) {
+	f := func(q Quaternion, v Vector3) bool {
+		return vectorsEqual(q.Conjugate().Rotate(q.Rotate(v)), v, 0.0001) &&
+			vectorsEqual(q.Multiply(q.Conjugate()).Rotate(v), v, 0.0001)
+	}
+
+	check(t, f)
+}
+
+func TestFromAxisAngle(t *testing.T) {
+
+	// A positive heading turns clockwise (viewed from above), so right becomes
+	// backwards. See TestAdd.
+	q := FromAxisAngle(Vector3{Y: 10}, utils.Rad(90))
+	assert.True(t, vectorsEqual(Vector3{0, 0, -1}, q.Rotate(Vector3{1, 0, 0}), epsilon))
+
+	q = FromAxisAngle(Vector3{X: 1}, utils.Rad(180))
+	assert.True(t, vectorsEqual(Vector3{0, -1, 0}, q.Rotate(Vector3{0, 1, 0}), epsilon))
+
+	assert.Equal(t, IdentityQuaternion, FromAxisAngle(Vector3{Z: 1}, 0))
+}
+
+func TestEulerOrder(t *testing.T) {
+
+	// Bank first, then heading, then pitch. With all three at 90 degrees,
+	// forwards ends up pointing right.
+	ea := EulerAngles{Heading: utils.Rad(90), Pitch: utils.Rad(90), Bank: utils.Rad(90)}
+	q := FromEulerAngles(ea)
+	exp := Vector3{0, 0, 1}.MultiplyByMatrix44(*MakeMatrix44(ZeroVector3, ea))
+	assert.True(t, vectorsEqual(exp, q.Rotate(Vector3{0, 0, 1}), epsilon))
+	assert.True(t, vectorsEqual(Vector3{1, 0, 0}, exp, epsilon))
+
+	// Gimbal lock. Bank is folded into pitch.
+	ea = EulerAngles{Heading: utils.Rad(90), Pitch: utils.Rad(20), Bank: utils.Rad(10)}
+	ea2 := FromEulerAngles(ea).EulerAngles()
+	assert.InDelta(t, utils.Rad(90), ea2.Heading, epsilon)
+	assert.InDelta(t, 0, ea2.Bank, epsilon)
+	assert.InDelta(t, utils.Rad(30), ea2.Pitch, epsilon)
+
+	ea = EulerAngles{Heading: utils.Rad(-90), Pitch: utils.Rad(20), Bank: utils.Rad(10)}
+	q = FromEulerAngles(ea)
+	q2 := FromEulerAngles(q.EulerAngles())
+	v := Vector3{1, 2, 3}
+	assert.True(t, vectorsEqual(q.Rotate(v), q2.Rotate(v), epsilon))
+}
+
+func TestNormalize(t *testing.T) {
+	q := Quaternion{W: 2, X: 0, Y: 0, Z: 0}
+	assert.Equal(t, IdentityQuaternion, q.Normalize())
+	assert.Equal(t, IdentityQuaternion, Quaternion{}.Normalize())
+
+	q = Quaternion{W: 1, X: 1, Y: 1, Z: 1}.Normalize()
+	assert.InDelta(t, 1, q.Magnitude(), epsilon)
+	assert.InDelta(t, 0.5, q.X, epsilon)
+}
+
+func TestPoseOrientation(t *testing.T) {
+	p := Pose{Position: Vector3{1, 2, 3}, Heading: 30, Pitch: -10, Bank: 5}
+
+	// The orientation rotates the same as ToWorld, minus the translation.
+	v := Vector3{10, 20, 30}
+	exp := v.MultiplyByMatrix44(p.ToWorld()).Subtract(p.Position)
+	assert.True(t, vectorsEqual(exp, p.Orientation().Rotate(v), epsilon))
+
+	p2 := MakePose(p.Position, p.Orientation())
+	assert.Equal(t, p.Position, p2.Position)
+	assert.InDelta(t, p.Heading, p2.Heading, epsilon)
+	assert.InDelta(t, p.Pitch, p2.Pitch, epsilon)
+	assert.InDelta(t, p.Bank, p2.Bank, epsilon)
+}
+
+func TestSlerp(t *testing.T) {
+	a := FromAxisAngle(Vector3{Y: 1}, utils.Rad(170))
+	b := FromAxisAngle(Vector3{Y: 1}, utils.Rad(-170))
+
+	// Halfway goes via 180, not via zero.
+	mid := a.Slerp(b, 0.5)
+	assert.True(t, vectorsEqual(Vector3{-1, 0, 0}, mid.Rotate(Vector3{1, 0, 0}), epsilon))
+
+	// The ends are the ends.
+	v := Vector3{1, 2, 3}
+	assert.True(t, vectorsEqual(a.Rotate(v), a.Slerp(b, 0).Rotate(v), epsilon))
+	assert.True(t, vectorsEqual(b.Rotate(v), a.Slerp(b, 1).Rotate(v), epsilon))
+
+	// Constant angular speed, and unit norm, all the way.
+	f := func(q, r Quaternion) bool {
+		total := math.Acos(math.Min(1, math.Abs(q.Dot(r))))
+		for i := 0; i <= 10; i++ {
+			s := q.Slerp(r, float64(i)/10)
+			if math.Abs(s.Magnitude()-1) > epsilon {
+				return false
+			}
+			if math.Abs(math.Acos(math.Min(1, math.Abs(q.Dot(s))))-total*float64(i)/10) > 0.001 {
+				return false
+			}
+		}
+		return true
+	}
+
+	check(t, f)
+}
@@ -1,8 +1,10 @@
 package math3d
 
 import (
+	"encoding/json"
 	"fmt"
 	"math"
+	"strconv"
 )
 
 type Vector3 struct {
@@ -84,6 +86,23 @@ func (v Vector3) MultiplyByMatrix44(m Matrix44) Vector3 {
 	}
 }
 
+// TransformPoint returns the point transformed by the given matrix, including
+// its translation. It's the same as MultiplyByMatrix44.
+func (v Vector3) TransformPoint(m Matrix44) Vector3 {
+	return v.MultiplyByMatrix44(m)
+}
+
+// TransformDirection returns the direction transformed by the given matrix.
+// Directions (unlike points) aren't anywhere, so only the rotation is applied,
+// not the translation.
+func (v Vector3) TransformDirection(m Matrix44) Vector3 {
+	return Vector3{
+		(v.X * m.m11) + (v.Y * m.m21) + (v.Z * m.m31),
+		(v.X * m.m12) + (v.Y * m.m22) + (v.Z * m.m32),
+		(v.X * m.m13) + (v.Y * m.m23) + (v.Z * m.m33),
+	}
+}
+
 // MultiplyByScaler returns a new vector by multiply each attribute by the given
 // scalar. This can be used to project a distance along the vector.
 func (v Vector3) MultiplyByScalar(s float64) Vector3 {
@@ -93,3 +112,107 @@ func (v Vector3) MultiplyByScalar(s float64) Vector3 {
 		(v.Z * s),
 	}
 }
+
+// Length returns the length of the vector. It's the same as Magnitude.
+func (v Vector3) Length() float64 {
+	return math.Sqrt(v.Dot(v))
+}
+
+// Normalized returns the vector scaled to a length of one. The zero vector has
+// no direction, so is returned unchanged.
+func (v Vector3) Normalized() Vector3 {
+	l := v.Length()
+	if l == 0 {
+		return ZeroVector3
+	}
+
+	return v.Scaled(1 / l)
+}
+
+// Dot returns the dot product of two vectors. For unit vectors, this is the
+// cosine of the angle between them.
+func (v Vector3) Dot(vv Vector3) float64 {
+	return (v.X * vv.X) + (v.Y * vv.Y) + (v.Z * vv.Z)
+}
+
+// Cross returns the cross product of two vectors, which is perpendicular to
+// both of them.
+func (v Vector3) Cross(vv Vector3) Vector3 {
+	return Vector3{
+		X: (v.Y * vv.Z) - (v.Z * vv.Y),
+		Y: (v.Z * vv.X) - (v.X * vv.Z),
+		Z: (v.X * vv.Y) - (v.Y * vv.X),
+	}
+}
+
+// DistanceTo returns the distance between this vector and another.
+func (v Vector3) DistanceTo(vv Vector3) float64 {
+	return v.Subtract(vv).Length()
+}
+
+// Scaled returns the vector with each component multiplied by s.
+func (v Vector3) Scaled(s float64) Vector3 {
+	return Vector3{
+		X: v.X * s,
+		Y: v.Y * s,
+		Z: v.Z * s,
+	}
+}
+
+// ClampLength returns the vector scaled down to the given length, if it's any
+// longer than that. Shorter vectors (including the zero vector) are returned
+// unchanged.
+func (v Vector3) ClampLength(max float64) Vector3 {
+	l := v.Length()
+	if l <= max {
+		return v
+	}
+
+	return v.Scaled(max / l)
+}
+
+// MarshalJSON encodes the vector as a compact [x,y,z] array.
+func (v Vector3) MarshalJSON() ([]byte, error) {
+	for _, f := range []float64{v.X, v.Y, v.Z} {
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			return nil, fmt.Errorf("can't encode %s as JSON", v)
+		}
+	}
+
+	b := make([]byte, 0, 32)
+	b = append(b, '[')
+	b = strconv.AppendFloat(b, v.X, 'g', -1, 64)
+	b = append(b, ',')
+	b = strconv.AppendFloat(b, v.Y, 'g', -1, 64)
+	b = append(b, ',')
+	b = strconv.AppendFloat(b, v.Z, 'g', -1, 64)
+	return append(b, ']'), nil
+}
+
+// UnmarshalJSON decodes a vector from an [x,y,z] array, or (for compatibility
+// with older clients) an {"x":0,"y":0,"z":0} object.
+func (v *Vector3) UnmarshalJSON(b []byte) error {
+	var a []float64
+	if err := json.Unmarshal(b, &a); err == nil {
+		if len(a) != 3 {
+			return fmt.Errorf("expected 3 elements in vector, got %d", len(a))
+		}
+
+		*v = Vector3{a[0], a[1], a[2]}
+		return nil
+	}
+
+	var o struct {
+		X float64 `json:"x"`
+		Y float64 `json:"y"`
+		Z float64 `json:"z"`
+	}
+
+	err := json.Unmarshal(b, &o)
+	if err != nil {
+		return fmt.Errorf("invalid vector: %s", string(b))
+	}
+
+	*v = Vector3{o.X, o.Y, o.Z}
+	return nil
+}
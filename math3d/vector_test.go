@@ -1,8 +1,11 @@
 package math3d
 
 import (
-	"github.com/stretchr/testify/assert"
+	"encoding/json"
+	"math"
 	"testing"
+
+	"github.com/stretchr/testify/assert"
 )
 
 func TestMagnitude(t *testing.T) {
@@ -77,3 +80,112 @@ func TestMultiplyByScalar(t *testing.T) {
 	vExp = Vector3{X: 2, Y: 4, Z: 6}
 	assert.Equal(t, vExp, vAct)
 }
+
+func TestLength(t *testing.T) {
+	assert.Equal(t, 0.0, ZeroVector3.Length())
+	assert.Equal(t, 5.0, Vector3{X: 3, Z: -4}.Length())
+
+	f := func(v Vector3) bool {
+		return v.Length() == v.Magnitude()
+	}
+
+	check(t, f)
+}
+
+func TestNormalized(t *testing.T) {
+	assert.Equal(t, ZeroVector3, ZeroVector3.Normalized())
+	assert.Equal(t, Vector3{Y: -1}, Vector3{Y: -0.001}.Normalized())
+
+	f := func(v Vector3) bool {
+		n := v.Normalized()
+		return math.Abs(n.Length()-1) < epsilon &&
+			vectorsEqual(n.Scaled(v.Length()), v, 0.0001)
+	}
+
+	check(t, f)
+}
+
+func TestDot(t *testing.T) {
+	assert.Equal(t, 32.0, Vector3{1, 2, 3}.Dot(Vector3{4, 5, 6}))
+	assert.Equal(t, 0.0, Vector3{1, 0, 0}.Dot(Vector3{0, 0, 1}))
+	assert.Equal(t, 0.0, ZeroVector3.Dot(Vector3{4, 5, 6}))
+	assert.Equal(t, -1.0, Vector3{0, 1, 0}.Dot(Vector3{0, -1, 0}))
+}
+
+func TestCross(t *testing.T) {
+	assert.Equal(t, Vector3{Z: 1}, Vector3{X: 1}.Cross(Vector3{Y: 1}))
+	assert.Equal(t, Vector3{Z: -1}, Vector3{Y: 1}.Cross(Vector3{X: 1}))
+	assert.Equal(t, ZeroVector3, Vector3{1, 2, 3}.Cross(Vector3{2, 4, 6}))
+	assert.Equal(t, ZeroVector3, ZeroVector3.Cross(Vector3{1, 2, 3}))
+
+	// Perpendicular to both inputs.
+	f := func(a, b Vector3) bool {
+		c := a.Cross(b)
+		return math.Abs(c.Dot(a)) < 0.01 && math.Abs(c.Dot(b)) < 0.01
+	}
+
+	check(t, f)
+}
+
+func TestDistanceTo(t *testing.T) {
+	a := Vector3{1, 2, 3}
+	assert.Equal(t, 0.0, a.DistanceTo(a))
+	assert.Equal(t, 5.0, a.DistanceTo(Vector3{4, 6, 3}))
+	assert.Equal(t, a.Length(), ZeroVector3.DistanceTo(a))
+}
+
+func TestScaled(t *testing.T) {
+	v := Vector3{X: 1, Y: 2, Z: 3}
+	assert.Equal(t, Vector3{X: 0.5, Y: 1, Z: 1.5}, v.Scaled(0.5))
+	assert.Equal(t, Vector3{X: -2, Y: -4, Z: -6}, v.Scaled(-2))
+	assert.Equal(t, ZeroVector3, v.Scaled(0))
+}
+
+func TestClampLength(t *testing.T) {
+	type eg struct {
+		in  Vector3
+		max float64
+		out Vector3
+	}
+
+	examples := []eg{
+		{Vector3{X: 3, Z: 4}, 10, Vector3{X: 3, Z: 4}},
+		{Vector3{X: 3, Z: 4}, 5, Vector3{X: 3, Z: 4}},
+		{Vector3{X: 30, Z: 40}, 5, Vector3{X: 3, Z: 4}},
+		{Vector3{X: 30, Z: 40}, 0, ZeroVector3},
+		{ZeroVector3, 5, ZeroVector3},
+		{ZeroVector3, 0, ZeroVector3},
+	}
+
+	for _, x := range examples {
+		assert.Equal(t, x.out, x.in.ClampLength(x.max))
+	}
+}
+
+func TestVectorJSON(t *testing.T) {
+	b, err := json.Marshal(Vector3{X: 1.5, Y: -2, Z: 0})
+	assert.NoError(t, err)
+	assert.Equal(t, "[1.5,-2,0]", string(b))
+
+	_, err = json.Marshal(Vector3{X: math.NaN()})
+	assert.Error(t, err)
+
+	var v Vector3
+	assert.NoError(t, json.Unmarshal([]byte("[1.5,-2,0]"), &v))
+	assert.Equal(t, Vector3{X: 1.5, Y: -2, Z: 0}, v)
+
+	// The old object format is still accepted.
+	assert.NoError(t, json.Unmarshal([]byte(`{"x":1,"y":2,"z":3}`), &v))
+	assert.Equal(t, Vector3{X: 1, Y: 2, Z: 3}, v)
+
+	assert.Error(t, json.Unmarshal([]byte("[1,2]"), &v))
+	assert.Error(t, json.Unmarshal([]byte(`"nope"`), &v))
+
+	f := func(v Vector3) bool {
+		var v2 Vector3
+		b, err := json.Marshal(v)
+		return err == nil && json.Unmarshal(b, &v2) == nil && v == v2
+	}
+
+	check(t, f)
+}
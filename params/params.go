@@ -0,0 +1,251 @@
+package params
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+)
+
+type Type string
+
+const (
+	Float Type = "float"
+	Int   Type = "int"
+	Bool  Type = "bool"
+)
+
+// Param is a named value which can be adjusted at runtime, e.g. via the API.
+// Components register their params during Boot.
+//
+// Get and Set are only ever called from the main loop (between ticks), so they
+// don't need to worry about synchronization. All values are float64s, to keep
+// things simple; the Type is only used for validation and display. Bools are
+// zero (false) or one (true).
+type Param struct {
+	Name string
+	Type Type
+	Min  float64
+	Max  float64
+
+	// How far to nudge the value at a time, e.g. from the controller's tuning
+	// mode. If zero, it's one for ints and bools, and a hundredth of the range
+	// for floats.
+	Step float64
+
+	Get func() float64
+	Set func(float64)
+}
+
+// Value is a read-only copy of a Param and its value, as of the last time the
+// pending writes were applied. It's safe to pass between goroutines.
+type Value struct {
+	Name  string  `json:"name"`
+	Type  Type    `json:"type"`
+	Min   float64 `json:"min"`
+	Max   float64 `json:"max"`
+	Step  float64 `json:"step"`
+	Value float64 `json:"value"`
+}
+
+// Registry is a set of params. Writes are validated immediately, but are only
+// applied when Apply is called (by the main loop), so params can be written
+// from any goroutine without racing the components which own them.
+type Registry struct {
+	sync.Mutex
+	params  map[string]*Param
+	cache   map[string]float64
+	pending []write
+}
+
+type write struct {
+	name string
+	val  float64
+}
+
+func New() *Registry {
+	return &Registry{
+		params: map[string]*Param{},
+		cache:  map[string]float64{},
+	}
+}
+
+// Default is the registry used by the package-level functions, which is what
+// components should register with.
+var Default = New()
+
+// Register adds a param to the default registry.
+func Register(p Param) error {
+	return Default.Register(p)
+}
+
+// Register adds a param to the registry. Names must be unique.
+func (r *Registry) Register(p Param) error {
+	if p.Name == "" || p.Get == nil || p.Set == nil {
+		return fmt.Errorf("invalid param: %#v", p)
+	}
+
+	switch p.Type {
+	case Float, Int:
+	case Bool:
+		p.Min = 0
+		p.Max = 1
+	default:
+		return fmt.Errorf("param %s has invalid type: %q", p.Name, p.Type)
+	}
+
+	if p.Min > p.Max {
+		return fmt.Errorf("param %s has min (%v) > max (%v)", p.Name, p.Min, p.Max)
+	}
+
+	switch {
+	case p.Step < 0 || math.IsNaN(p.Step):
+		return fmt.Errorf("param %s has invalid step: %v", p.Name, p.Step)
+	case p.Step == 0 && p.Type == Float:
+		p.Step = (p.Max - p.Min) / 100
+	case p.Step == 0:
+		p.Step = 1
+	}
+
+	r.Lock()
+	defer r.Unlock()
+
+	if _, ok := r.params[p.Name]; ok {
+		return fmt.Errorf("param already registered: %s", p.Name)
+	}
+
+	r.params[p.Name] = &p
+	r.cache[p.Name] = p.Get()
+	return nil
+}
+
+// Validate returns an error if the given value can't be written to the named
+// param, because it doesn't exist, is out of range, or is the wrong type.
+func (r *Registry) Validate(name string, val float64) error {
+	r.Lock()
+	defer r.Unlock()
+	return r.validate(name, val)
+}
+
+func (r *Registry) validate(name string, val float64) error {
+	p, ok := r.params[name]
+	if !ok {
+		return fmt.Errorf("no such param: %s", name)
+	}
+
+	if math.IsNaN(val) || math.IsInf(val, 0) {
+		return fmt.Errorf("invalid value for %s: %v", name, val)
+	}
+
+	if (p.Type == Int || p.Type == Bool) && val != math.Trunc(val) {
+		return fmt.Errorf("%s must be an integer, got %v", name, val)
+	}
+
+	if val < p.Min || val > p.Max {
+		return fmt.Errorf("%s must be between %v and %v, got %v", name, p.Min, p.Max, val)
+	}
+
+	return nil
+}
+
+// Set validates and queues writes to the given params, to be applied by the
+// next call to Apply. If any of the writes are invalid, none are queued.
+func (r *Registry) Set(vals map[string]float64) error {
+	r.Lock()
+	defer r.Unlock()
+
+	// Sort the names, so that errors are deterministic.
+	names := make([]string, 0, len(vals))
+	for n := range vals {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	for _, n := range names {
+		err := r.validate(n, vals[n])
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, n := range names {
+		r.pending = append(r.pending, write{n, vals[n]})
+	}
+
+	return nil
+}
+
+// Apply writes any pending values to their params, and refreshes the cached
+// values returned by Values. This must only be called from the main loop.
+// Returns the names of the params which were written.
+func (r *Registry) Apply() []string {
+	r.Lock()
+	defer r.Unlock()
+
+	var written []string
+	for _, w := range r.pending {
+		r.params[w.name].Set(w.val)
+		written = append(written, w.name)
+	}
+	r.pending = r.pending[:0]
+
+	// Refresh the cache, since components might have changed their own params
+	// during the last tick (e.g. clearance via the controller).
+	for n, p := range r.params {
+		r.cache[n] = p.Get()
+	}
+
+	return written
+}
+
+// Values returns the current value of every param, sorted by name.
+func (r *Registry) Values() []Value {
+	r.Lock()
+	defer r.Unlock()
+
+	out := make([]Value, 0, len(r.params))
+	for n := range r.params {
+		out = append(out, r.value(n))
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Name < out[j].Name
+	})
+
+	return out
+}
+
+// Lookup returns the named param and its value, as of the last time the
+// pending writes were applied, and whether it exists.
+func (r *Registry) Lookup(name string) (Value, bool) {
+	r.Lock()
+	defer r.Unlock()
+
+	if _, ok := r.params[name]; !ok {
+		return Value{}, false
+	}
+
+	return r.value(name), true
+}
+
+func (r *Registry) value(name string) Value {
+	p := r.params[name]
+	return Value{
+		Name:  name,
+		Type:  p.Type,
+		Min:   p.Min,
+		Max:   p.Max,
+		Step:  p.Step,
+		Value: r.cache[name],
+	}
+}
+
+// Get returns the value of the named param, as of the last time the pending
+// writes were applied, and whether it exists.
+func (r *Registry) Get(name string) (float64, bool) {
+	r.Lock()
+	defer r.Unlock()
+
+	v, ok := r.cache[name]
+	return v, ok
+}
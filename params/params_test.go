@@ -0,0 +1,95 @@
+package params
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegisterAndApply(t *testing.T) {
+	r := New()
+
+	var f float64 = 1
+	var i float64 = 2
+	assert.NoError(t, r.Register(Param{"a.float", Float, 0, 10, 0, func() float64 { return f }, func(v float64) { f = v }}))
+	assert.NoError(t, r.Register(Param{"b.int", Int, 0, 10, 0, func() float64 { return i }, func(v float64) { i = v }}))
+
+	// Duplicate names are rejected.
+	assert.Error(t, r.Register(Param{"a.float", Float, 0, 10, 0, func() float64 { return f }, func(v float64) { f = v }}))
+
+	assert.NoError(t, r.Set(map[string]float64{"a.float": 2.5, "b.int": 3}))
+	assert.Equal(t, 1.0, f)
+
+	assert.Equal(t, []string{"a.float", "b.int"}, r.Apply())
+	assert.Equal(t, 2.5, f)
+	assert.Equal(t, 3.0, i)
+
+	// Values changed by their owner are picked up at the next Apply.
+	f = 4
+	assert.Nil(t, r.Apply())
+
+	vals := r.Values()
+	assert.Equal(t, "a.float", vals[0].Name)
+	assert.Equal(t, 4.0, vals[0].Value)
+	assert.Equal(t, 3.0, vals[1].Value)
+
+	v, ok := r.Get("a.float")
+	assert.True(t, ok)
+	assert.Equal(t, 4.0, v)
+	_, ok = r.Get("c.nope")
+	assert.False(t, ok)
+}
+
+func TestValidation(t *testing.T) {
+	r := New()
+	var v float64
+	get := func() float64 { return v }
+	set := func(x float64) { v = x }
+	assert.NoError(t, r.Register(Param{"f", Float, -1, 1, 0, get, set}))
+	assert.NoError(t, r.Register(Param{"i", Int, 0, 5, 0, get, set}))
+	assert.NoError(t, r.Register(Param{"b", Bool, 0, 0, 0, get, set}))
+
+	assert.NoError(t, r.Validate("f", -1))
+	assert.NoError(t, r.Validate("f", 0.5))
+	assert.Error(t, r.Validate("f", 1.01))
+	assert.NoError(t, r.Validate("i", 5))
+	assert.Error(t, r.Validate("i", 2.5))
+	assert.NoError(t, r.Validate("b", 1))
+	assert.Error(t, r.Validate("b", 2))
+	assert.Error(t, r.Validate("nope", 0))
+
+	// If any write is invalid, none are queued.
+	assert.Error(t, r.Set(map[string]float64{"f": 0.5, "i": 9}))
+	assert.Nil(t, r.Apply())
+	assert.Equal(t, 0.0, v)
+
+	// Bad registrations.
+	assert.Error(t, r.Register(Param{"bad", Float, 1, 0, 0, get, set}))
+	assert.Error(t, r.Register(Param{"bad", Type("string"), 0, 1, 0, get, set}))
+	assert.Error(t, r.Register(Param{Name: "bad", Type: Float}))
+	assert.Error(t, r.Register(Param{"bad", Float, 0, 1, -0.1, get, set}))
+}
+
+func TestLookup(t *testing.T) {
+	r := New()
+	var v float64 = 3
+	get := func() float64 { return v }
+	set := func(x float64) { v = x }
+	assert.NoError(t, r.Register(Param{"f", Float, -1, 3, 0, get, set}))
+	assert.NoError(t, r.Register(Param{"g", Float, 0, 80, 5, get, set}))
+	assert.NoError(t, r.Register(Param{"i", Int, 0, 50, 0, get, set}))
+
+	// The step defaults to a hundredth of the range, or one.
+	p, ok := r.Lookup("f")
+	assert.True(t, ok)
+	assert.Equal(t, Value{Name: "f", Type: Float, Min: -1, Max: 3, Step: 0.04, Value: 3}, p)
+
+	p, _ = r.Lookup("g")
+	assert.Equal(t, 5.0, p.Step)
+
+	p, _ = r.Lookup("i")
+	assert.Equal(t, 1.0, p.Step)
+
+	_, ok = r.Lookup("nope")
+	assert.False(t, ok)
+}
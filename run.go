@@ -0,0 +1,165 @@
+package hexapod
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"time"
+
+	"github.com/adammck/dynamixel/network"
+	"github.com/adammck/hexapod/config"
+	"github.com/adammck/hexapod/servos"
+)
+
+const (
+
+	// The default for Hexapod.TargetFPS.
+	DefaultFPS = 60
+
+	// The default for Hexapod.ShutdownGrace.
+	DefaultShutdownGrace = 2 * time.Second
+)
+
+// Shutdowner is an optional interface for components which need to clean up
+// (e.g. flush a file, or close a device) once the loop has stopped. Run calls
+// Shutdown on each of them, in the order they were registered, after the last
+// tick. A component whose Tick can block should also see Restartable.
+type Shutdowner interface {
+	Shutdown() error
+}
+
+// New creates a hexapod on the given Dynamixel network, with the safety limits
+// from the given config. The rest of the config is for the components, which
+// the caller creates and registers before calling Run.
+//
+// This is the entry point for programs which embed the hexapod, e.g. to add a
+// component of their own:
+//
+//	h := hexapod.New(network, cfg)
+//	h.Register(legs.New(network, cfg.Legs, cfg.Gait), &MyComponent{})
+//	err := h.Run(ctx)
+func New(network *network.Network, cfg config.Config) *Hexapod {
+	h := NewHexapod(network, DefaultFPS)
+	h.HealthWindow = cfg.Safety.HealthWindow.Duration
+	h.MaxRestarts = cfg.Safety.Restarts
+	h.ShutdownGrace = cfg.Safety.ShutdownGrace.Duration
+	h.Countdown = cfg.Safety.Countdown.Duration
+	h.Countdowns = map[string]time.Duration{}
+	for name, d := range cfg.Safety.Countdowns {
+		h.Countdowns[name] = d.Duration
+	}
+	h.Journal = NewJournal(DefaultJournalLength, h.TargetFPS)
+	h.State.Identity = NewIdentity(cfg.Identity)
+	h.State.Identity.export()
+	return h
+}
+
+// Register adds components to receive ticks every frame, in the order given,
+// after any which were registered before. Components can't be registered once
+// Run has been called.
+func (h *Hexapod) Register(cs ...Component) {
+	for _, c := range cs {
+		h.Add(c)
+	}
+}
+
+// Run boots the components, and ticks them at TargetFPS until a shutdown is
+// requested (by a component setting State.Shutdown, or by the context being
+// done), and then for ShutdownGrace, so they have a chance to sit down. Then
+// each Shutdowner is shut down, and the servos are powered off.
+//
+// It returns nil if the loop was stopped on purpose, or the error which
+// stopped it, including a panic which the loop couldn't recover from. The
+// components are shut down either way.
+func (h *Hexapod) Run(ctx context.Context) (err error) {
+	log.Info("booting components")
+	err = h.Boot()
+	if err != nil {
+		return fmt.Errorf("error while booting: %s", err)
+	}
+
+	// Whatever stops the loop, power down the servos before returning.
+	defer func() {
+		if r := recover(); r != nil {
+			log.Errorf("recovered from panic: %v\n%s", r, debug.Stack())
+			err = fmt.Errorf("main loop panicked: %v", r)
+		}
+
+		h.shutdown()
+	}()
+
+	log.Infof("starting loop at %dfps", h.TargetFPS)
+	ticker := time.NewTicker(time.Second / time.Duration(h.TargetFPS))
+	defer ticker.Stop()
+
+	// This is set as soon as State.Shutdown becomes true.
+	var pending time.Time
+
+	// Set to nil once the context is done, so it's only handled once.
+	done := ctx.Done()
+
+	for {
+		select {
+		case <-done:
+			done = nil
+			if !h.State.Shutdown {
+				log.Warnf("%s, requesting shutdown...", ctx.Err())
+				h.State.Shutdown = true
+			}
+
+		case now := <-ticker.C:
+			err = h.Tick(now)
+			if err != nil {
+				return err
+			}
+
+			// Continue looping if shutdown wasn't requested.
+			if !h.State.Shutdown {
+				continue
+			}
+
+			// On the first loop after shutdown being set, note the time, so we
+			// can continue looping for the grace period.
+			if pending.IsZero() {
+				log.Warnf("shutdown requested, waiting %s...", h.ShutdownGrace)
+				pending = time.Now()
+				continue
+			}
+
+			if time.Since(pending) > h.ShutdownGrace {
+				log.Warn("done waiting, shutting down")
+				return nil
+			}
+		}
+	}
+}
+
+// shutdown calls Shutdown on each Shutdowner (including those which failed,
+// since they might have something to clean up), and then powers off the servos.
+func (h *Hexapod) shutdown() {
+	for _, c := range h.Components {
+		s, ok := c.(Shutdowner)
+		if !ok {
+			continue
+		}
+
+		err := h.shutdownComponent(s)
+		if err != nil {
+			log.Warnf("%s (while shutting down %T)", err, c)
+		}
+	}
+
+	servos.Shutdown()
+}
+
+// shutdownComponent calls Shutdown on a single component, recovering from any
+// panic, so the rest are still shut down.
+func (h *Hexapod) shutdownComponent(s Shutdowner) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panicked: %v", r)
+		}
+	}()
+
+	return s.Shutdown()
+}
@@ -0,0 +1,79 @@
+package hexapod
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/adammck/dynamixel/network"
+	"github.com/adammck/hexapod/config"
+	fake_serial "github.com/adammck/hexapod/fake/serial"
+	"github.com/adammck/hexapod/params"
+	"github.com/stretchr/testify/assert"
+)
+
+// shutdownComponent records when it was shut down, in a shared list.
+type shutdownComponent struct {
+	fakeComponent
+	name  string
+	order *[]string
+	err   error
+}
+
+func (c *shutdownComponent) Shutdown() error {
+	*c.order = append(*c.order, c.name)
+	return c.err
+}
+
+func newTestRun() *Hexapod {
+	cfg := config.Default()
+	cfg.Safety.ShutdownGrace = config.Duration{Duration: 50 * time.Millisecond}
+
+	h := New(network.New(&fake_serial.FakeSerial{}), cfg)
+	h.Params = params.New()
+	h.TargetFPS = 200
+	return h
+}
+
+func TestRunStopsWhenContextIsDone(t *testing.T) {
+	h := newTestRun()
+
+	var order []string
+	a := &shutdownComponent{name: "a", order: &order, err: errors.New("oops")}
+	b := &fakeComponent{}
+	c := &shutdownComponent{name: "c", order: &order}
+	h.Register(a, b, c)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	start := time.Now()
+	assert.NoError(t, h.Run(ctx))
+
+	// The loop carries on for the grace period, so the components can sit
+	// down. A Shutdowner returning an error doesn't stop the rest.
+	assert.True(t, h.State.Shutdown)
+	assert.GreaterOrEqual(t, time.Since(start), 100*time.Millisecond)
+	assert.Greater(t, b.ticks, 0)
+	assert.Equal(t, []string{"a", "c"}, order)
+}
+
+func TestRunShutsDownAfterError(t *testing.T) {
+	h := newTestRun()
+
+	var order []string
+	a := &shutdownComponent{name: "a", order: &order}
+	a.panicOn = 2
+	c := &shutdownComponent{name: "c", order: &order}
+	h.Register(a, c)
+
+	// The essential component panics every tick, so the loop gives up, but
+	// still shuts everything down. Even the one which was failing.
+	e := &essentialComponent{fakeComponent{panicOn: 1, sticky: true}}
+	h.Register(e)
+
+	err := h.Run(context.Background())
+	assert.Error(t, err)
+	assert.Equal(t, []string{"a", "c"}, order)
+}
@@ -0,0 +1,245 @@
+package servos
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+const (
+
+	// The AX-12 present position register, which is read back from the servos
+	// (e.g. by servo.Angle).
+	presentPositionAddr = 0x24
+
+	// The offset of the first parameter in an instruction or status packet,
+	// after the header (0xFF 0xFF), ID, length, and instruction (or error).
+	paramsOffset = 5
+)
+
+// captureMagic is written at the start of every servo capture, like the flight
+// recorder's dumps, so garbage is refused.
+var captureMagic = [4]byte{'H', 'X', 'S', '1'}
+
+// The kinds of Sample.
+const (
+	SampleGoal    uint8 = 1
+	SamplePresent uint8 = 2
+)
+
+// Sample is a single position of a servo which went over the bus: either a goal
+// which was written to it, or its present position, read back from it. It's a
+// fixed size, so the capture can be preallocated, like the flight recorder.
+type Sample struct {
+	Time     int64 // unix nanoseconds
+	ID       uint8
+	Kind     uint8
+	Position uint16
+}
+
+// Degrees returns the position as an angle, from the end of the servo's range
+// rather than the zero angle of the joint, so it's only good for comparing with
+// other samples of the same servo.
+func (s Sample) Degrees() float64 {
+	return float64(s.Position) / angleToPosition
+}
+
+// read is a read of the present position which is waiting for its reply: the
+// servo, and where the position is in the registers which were read.
+type read struct {
+	id     byte
+	offset int
+}
+
+// Capture records the position of every servo as it goes over the bus: the goal
+// positions which are written (singly or by sync write), and the present
+// positions which are read back. It keeps the most recent samples in a ring, so
+// the capture can be dumped alongside the flight recorder's records, to see
+// what the servos actually did. See Port.Capture.
+//
+// The present positions are only captured when something reads them, which the
+// legs don't while walking, unless config.Legs.Feedback is set.
+type Capture struct {
+
+	// The clock which the samples are timed by. This is time.Now unless
+	// changed, e.g. to capture a simulation at its own time.
+	Now func() time.Time
+
+	mu   sync.Mutex
+	ring []Sample
+	next int
+	n    int
+
+	// The read which is waiting for its reply, and how much of the reply has
+	// arrived.
+	pending *read
+	reply   []byte
+}
+
+// NewCapture returns a capture which keeps the given number of samples.
+func NewCapture(size int) *Capture {
+	if size < 1 {
+		size = 1
+	}
+
+	return &Capture{
+		Now:  time.Now,
+		ring: make([]Sample, size),
+	}
+}
+
+// add records a sample, overwriting the oldest if the ring is full.
+func (c *Capture) add(s Sample) {
+	c.ring[c.next] = s
+	c.next = (c.next + 1) % len(c.ring)
+	if c.n < len(c.ring) {
+		c.n += 1
+	}
+}
+
+// Samples returns a copy of the samples in the ring, oldest first.
+func (c *Capture) Samples() []Sample {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	out := make([]Sample, 0, c.n)
+	start := (c.next - c.n + len(c.ring)) % len(c.ring)
+	for i := 0; i < c.n; i++ {
+		out = append(out, c.ring[(start+i)%len(c.ring)])
+	}
+
+	return out
+}
+
+// covers returns the offset of the two byte register at addr within the given
+// number of registers from start, or -1 if they don't cover all of it.
+func covers(start, n, addr int) int {
+	if addr < start || addr+2 > start+n {
+		return -1
+	}
+
+	return addr - start
+}
+
+// wrote records the goal positions in a packet which has just been written, and
+// whether it's a read of the present position, whose reply is recorded once it
+// arrives (see read).
+func (c *Capture) wrote(pkt []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// The servos only reply to one thing at a time, so any read which is still
+	// waiting for its reply won't get one.
+	c.pending = nil
+	c.reply = c.reply[:0]
+
+	// Everything of interest has at least two params, before the checksum.
+	if len(pkt) < paramsOffset+3 {
+		return
+	}
+
+	now := c.Now().UnixNano()
+	id, inst, params := pkt[2], pkt[4], pkt[paramsOffset:len(pkt)-1]
+
+	switch inst {
+	case writeDataInstruction:
+		if i := covers(int(params[0]), len(params)-1, goalPositionAddr); i >= 0 && id != broadcastID {
+			c.add(Sample{Time: now, ID: id, Kind: SampleGoal, Position: position(params[1+i:])})
+		}
+
+	case syncWriteInstruction:
+		n := int(params[1])
+		i := covers(int(params[0]), n, goalPositionAddr)
+		if i < 0 {
+			return
+		}
+
+		for data := params[2:]; len(data) >= n+1; data = data[n+1:] {
+			c.add(Sample{Time: now, ID: data[0], Kind: SampleGoal, Position: position(data[1+i:])})
+		}
+
+	case readInstruction:
+		if i := covers(int(params[0]), int(params[1]), presentPositionAddr); i >= 0 && id != broadcastID {
+			c.pending = &read{id: id, offset: i}
+		}
+	}
+}
+
+// read records the present position from the bytes which were just read, once
+// the whole reply to the pending read has arrived. Replies with an error are
+// ignored, since the position might be garbage.
+func (c *Capture) read(b []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.pending == nil || len(b) == 0 {
+		return
+	}
+
+	c.reply = append(c.reply, b...)
+	if len(c.reply) < 4 || len(c.reply) < 4+int(c.reply[3]) {
+		return
+	}
+
+	r := c.pending
+	c.pending = nil
+
+	params := c.reply[paramsOffset:]
+	if c.reply[2] != r.id || c.reply[4] != 0 || len(params) < r.offset+2 {
+		return
+	}
+
+	c.add(Sample{Time: c.Now().UnixNano(), ID: r.id, Kind: SamplePresent, Position: position(params[r.offset:])})
+}
+
+// position returns the (little endian) two byte register at the start of b.
+func position(b []byte) uint16 {
+	return uint16(b[0]) | uint16(b[1])<<8
+}
+
+// EncodeCapture writes the given samples (oldest first) to w.
+func EncodeCapture(w io.Writer, samples []Sample) error {
+	_, err := w.Write(captureMagic[:])
+	if err != nil {
+		return err
+	}
+
+	return binary.Write(w, binary.LittleEndian, samples)
+}
+
+// DecodeCapture reads all of the samples from a capture written by
+// EncodeCapture.
+func DecodeCapture(r io.Reader) ([]Sample, error) {
+	var m [4]byte
+	_, err := io.ReadFull(r, m[:])
+	if err != nil {
+		return nil, fmt.Errorf("%s (while reading header)", err)
+	}
+
+	if m != captureMagic {
+		return nil, fmt.Errorf("not a servo capture: %q", m[:])
+	}
+
+	buf := make([]byte, binary.Size(Sample{}))
+	out := []Sample{}
+	for {
+		_, err := io.ReadFull(r, buf)
+		if err == io.EOF {
+			return out, nil
+		}
+		if err != nil {
+			return out, fmt.Errorf("%s (while reading sample %d)", err, len(out))
+		}
+
+		var s Sample
+		err = binary.Read(bytes.NewReader(buf), binary.LittleEndian, &s)
+		if err != nil {
+			return out, fmt.Errorf("%s (while reading sample %d)", err, len(out))
+		}
+
+		out = append(out, s)
+	}
+}
@@ -0,0 +1,164 @@
+package servos
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// posSerial is a serial port which replies to reads of the present position
+// with the given position of each servo, a byte at a time, like a slow port.
+type posSerial struct {
+	pos map[byte]uint16
+	buf []byte
+}
+
+func (s *posSerial) Read(b []byte) (int, error) {
+	if len(s.buf) == 0 || len(b) == 0 {
+		return 0, nil
+	}
+
+	b[0] = s.buf[0]
+	s.buf = s.buf[1:]
+	return 1, nil
+}
+
+func (s *posSerial) Write(b []byte) (int, error) {
+	if b[4] == readInstruction {
+		id, p := b[2], s.pos[b[2]]
+		s.buf = append(s.buf, 0xFF, 0xFF, id, 0x04, 0x00, byte(p), byte(p>>8), 0x00)
+	}
+
+	return len(b), nil
+}
+
+func (s *posSerial) Close() error {
+	return nil
+}
+
+// readPacket returns a READ DATA packet, which reads the given number of
+// registers of the given servo, starting at the given address.
+func readPacket(id byte, addr byte, n byte) []byte {
+	return []byte{0xFF, 0xFF, id, 0x04, readInstruction, addr, n, 0x00}
+}
+
+// drain reads until nothing arrives.
+func drain(p *Port) {
+	buf := make([]byte, 8)
+	for {
+		n, _ := p.Read(buf)
+		if n == 0 {
+			return
+		}
+	}
+}
+
+func TestCapture(t *testing.T) {
+	now := time.Unix(1000, 0)
+	s := &posSerial{pos: map[byte]uint16{7: 0x210}}
+	p := NewPort(s)
+	p.Capture = NewCapture(100)
+	p.Capture.Now = func() time.Time { return now }
+
+	// The goal positions written by sync write, and by write data, even if
+	// other registers are written at the same time.
+	w := NewGoalPositions()
+	w.Set(11, 0x123)
+	w.Set(12, 0x321)
+	p.Write(w.Bytes())
+	now = now.Add(time.Millisecond)
+	p.Write(writeData(13, 0x1c, 0x01, 0x01, 0x00, 0x02))
+
+	// But not writes to other registers, or to the broadcast ID.
+	p.Write(writeData(13, torqueLimitAddr, 0x00, 0x02))
+	p.Write(writeData(broadcastID, goalPositionAddr, 0x00, 0x02))
+	p.Write(NewSyncWrite(torqueLimitAddr).Bytes())
+
+	// The present position is recorded once the reply has arrived, which is a
+	// byte at a time.
+	now = now.Add(time.Millisecond)
+	p.Write(readPacket(7, presentPositionAddr, 2))
+	drain(p)
+
+	// Or read along with other registers.
+	p.Write(readPacket(7, 0x22, 6))
+	s.buf = []byte{0xFF, 0xFF, 0x07, 0x08, 0x00, 0xAA, 0xAA, 0x10, 0x02, 0xBB, 0xBB, 0x00}
+	drain(p)
+
+	// Reads of anything else aren't, and nor are replies with an error.
+	p.Write(readPacket(7, torqueLimitAddr, 2))
+	drain(p)
+	p.Write(readPacket(7, presentPositionAddr, 2))
+	s.buf = []byte{0xFF, 0xFF, 0x07, 0x04, 0x20, 0x10, 0x02, 0x00}
+	drain(p)
+
+	t0 := time.Unix(1000, 0).UnixNano()
+	ms := int64(time.Millisecond)
+	assert.Equal(t, []Sample{
+		{Time: t0, ID: 11, Kind: SampleGoal, Position: 0x123},
+		{Time: t0, ID: 12, Kind: SampleGoal, Position: 0x321},
+		{Time: t0 + ms, ID: 13, Kind: SampleGoal, Position: 0x200},
+		{Time: t0 + 2*ms, ID: 7, Kind: SamplePresent, Position: 0x210},
+		{Time: t0 + 2*ms, ID: 7, Kind: SamplePresent, Position: 0x210},
+	}, p.Capture.Samples())
+}
+
+func TestCaptureIncomplete(t *testing.T) {
+	s := &posSerial{}
+	p := NewPort(s)
+	p.Capture = NewCapture(100)
+
+	// A reply which doesn't finish before the next write is dropped, without
+	// mistaking the next reply for it.
+	p.Write(readPacket(7, presentPositionAddr, 2))
+	s.buf = []byte{0xFF, 0xFF, 0x07}
+	drain(p)
+	p.Write(readPacket(8, torqueLimitAddr, 2))
+	s.buf = []byte{0x04, 0x00, 0x10, 0x02, 0x00}
+	drain(p)
+
+	assert.Empty(t, p.Capture.Samples())
+}
+
+func TestCaptureRing(t *testing.T) {
+	c := NewCapture(3)
+	for i := 0; i < 5; i++ {
+		c.wrote(writeData(byte(i), goalPositionAddr, byte(i), 0x00))
+	}
+
+	var ids []uint8
+	for _, s := range c.Samples() {
+		ids = append(ids, s.ID)
+	}
+	assert.Equal(t, []uint8{2, 3, 4}, ids)
+}
+
+func TestCaptureRoundTrip(t *testing.T) {
+	in := []Sample{
+		{Time: 1, ID: 11, Kind: SampleGoal, Position: 512},
+		{Time: 2, ID: 11, Kind: SamplePresent, Position: 500},
+	}
+
+	var buf bytes.Buffer
+	assert.NoError(t, EncodeCapture(&buf, in))
+	out, err := DecodeCapture(&buf)
+	assert.NoError(t, err)
+	assert.Equal(t, in, out)
+
+	_, err = DecodeCapture(bytes.NewReader([]byte("HXR2....")))
+	assert.EqualError(t, err, `not a servo capture: "HXR2"`)
+
+	// A truncated sample is an error, after the ones which were complete.
+	buf.Reset()
+	assert.NoError(t, EncodeCapture(&buf, in))
+	out, err = DecodeCapture(bytes.NewReader(buf.Bytes()[:buf.Len()-3]))
+	assert.Error(t, err)
+	assert.Equal(t, in[:1], out)
+}
+
+func TestSampleDegrees(t *testing.T) {
+	assert.InDelta(t, 150.0, Sample{Position: 1023}.Degrees()/2, 0.001)
+	assert.InDelta(t, 0.293, Sample{Position: 1}.Degrees(), 0.001)
+}
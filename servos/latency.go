@@ -0,0 +1,287 @@
+package servos
+
+import (
+	"expvar"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/adammck/hexapod/config"
+)
+
+const (
+
+	// The instructions (protocol v1) which the servos reply to, even at return
+	// level one, which is what every servo is set to (see New). The others are
+	// only replied to at return level two.
+	pingInstruction = 0x01
+	readInstruction = 0x02
+
+	// The slowest transactions are kept for each of the last few windows, so
+	// the worst of the last minute can be found without keeping every one.
+	// That's the current window, and enough before it to cover a minute.
+	latencyWindow  = 10 * time.Second
+	latencyWindows = 7
+)
+
+// The upper bounds of the histogram's buckets. Anything slower goes in the last
+// one, which has no bound.
+var latencyBuckets = []time.Duration{
+	time.Millisecond,
+	2 * time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	20 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+}
+
+// Counters exported via expvar, for the diagnostics server. These are global
+// (rather than per-Latency) because expvar is.
+var (
+	expTransactions = expvar.NewInt("servos.transactions")
+	expSlow         = expvar.NewInt("servos.slow_transactions")
+)
+
+// Transaction is the timing of a single packet written to the bus, and of its
+// reply, if one was expected.
+type Transaction struct {
+
+	// The servo which it was sent to (or the broadcast ID), and the
+	// instruction.
+	ID          byte
+	Instruction byte
+
+	// When it was queued, i.e. Write was called.
+	At time.Time
+
+	// How long it waited for the previous write to finish, how long the write
+	// itself took, and how long the reply took to arrive after that. The last
+	// is zero if no reply was expected.
+	Queue    time.Duration
+	Write    time.Duration
+	Response time.Duration
+
+	// The number of reads which returned nothing while waiting for the reply.
+	// The network backs off (and tries again) after each one, so a reply which
+	// arrives just after one is late by the backoff.
+	Retries int
+
+	// Whether a reply was expected, but didn't arrive (in full) before the next
+	// packet was written.
+	Incomplete bool
+}
+
+// Total returns how long the transaction took, from being queued to finishing.
+func (t Transaction) Total() time.Duration {
+	return t.Queue + t.Write + t.Response
+}
+
+func (t Transaction) String() string {
+	s := fmt.Sprintf("id=%d instruction=0x%02X total=%s (queue=%s write=%s response=%s) retries=%d",
+		t.ID, t.Instruction, us(t.Total()), us(t.Queue), us(t.Write), us(t.Response), t.Retries)
+	if t.Incomplete {
+		s += " incomplete"
+	}
+
+	return s
+}
+
+// us rounds a duration to the microsecond, for the logs.
+func us(d time.Duration) time.Duration {
+	return d.Round(time.Microsecond)
+}
+
+// histogram counts transactions by their total duration. See latencyBuckets.
+type histogram [8]int64
+
+func (h *histogram) add(d time.Duration) {
+	i := sort.Search(len(latencyBuckets), func(i int) bool { return d <= latencyBuckets[i] })
+	h[i] += 1
+}
+
+// worst is the slowest transactions which were recorded during a window, with
+// the slowest first.
+type worst struct {
+	start time.Time
+	txs   []Transaction
+}
+
+// add inserts the transaction, if it's slower than any of them (or there are
+// fewer than n), and drops the fastest if there are too many.
+func (w *worst) add(t Transaction, n int) {
+	i := sort.Search(len(w.txs), func(i int) bool { return w.txs[i].Total() < t.Total() })
+	if i >= n {
+		return
+	}
+
+	w.txs = append(w.txs, Transaction{})
+	copy(w.txs[i+1:], w.txs[i:])
+	w.txs[i] = t
+	if len(w.txs) > n {
+		w.txs = w.txs[:n]
+	}
+}
+
+// Latency records the timing of every transaction on the bus: how long it was
+// queued behind the one before, how long the write took, and how long the reply
+// took to arrive. It keeps a histogram, and the slowest of the last minute, and
+// logs a summary periodically. Slow transactions are warned about straight
+// away. See config.Bus.
+//
+// It's written by the port, but can be read from any goroutine.
+type Latency struct {
+	cfg config.Bus
+
+	mu          sync.Mutex
+	total       int64
+	slow        int64
+	hist        histogram
+	windows     [latencyWindows]worst
+	summary     time.Time
+	summaryN    int64
+	summaryHist histogram
+	summarySlow Transaction
+}
+
+// NewLatency returns a new Latency with the given config.
+func NewLatency(cfg config.Bus) *Latency {
+	return &Latency{cfg: cfg}
+}
+
+// record adds a finished transaction.
+func (l *Latency) record(t Transaction) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	d := t.Total()
+	l.total += 1
+	l.hist.add(d)
+	expTransactions.Add(1)
+
+	// Each window is reused once it's older than the rest.
+	start := t.At.Truncate(latencyWindow)
+	w := &l.windows[start.UnixNano()/int64(latencyWindow)%latencyWindows]
+	if !w.start.Equal(start) {
+		*w = worst{start: start}
+	}
+	w.add(t, l.cfg.Worst)
+
+	if s := l.cfg.SlowTransaction.Duration; s > 0 && d > s {
+		l.slow += 1
+		expSlow.Add(1)
+		log.Warnf("slow bus transaction: %s", t)
+	}
+
+	if l.summary.IsZero() {
+		l.summary = t.At
+	}
+	l.summaryN += 1
+	l.summaryHist.add(d)
+	if d > l.summarySlow.Total() {
+		l.summarySlow = t
+	}
+
+	if i := l.cfg.SummaryInterval.Duration; i > 0 && t.At.Sub(l.summary) >= i {
+		log.Infof("bus transactions in the last %s: n=%d %s, slowest: %s", us(t.At.Sub(l.summary)), l.summaryN, l.summaryHist, l.summarySlow)
+		l.summary = t.At
+		l.summaryN = 0
+		l.summaryHist = histogram{}
+		l.summarySlow = Transaction{}
+	}
+}
+
+func (h histogram) String() string {
+	var parts []string
+	for i, n := range h {
+		if n == 0 {
+			continue
+		}
+
+		if i < len(latencyBuckets) {
+			parts = append(parts, fmt.Sprintf("<=%s:%d", latencyBuckets[i], n))
+		} else {
+			parts = append(parts, fmt.Sprintf(">%s:%d", latencyBuckets[i-1], n))
+		}
+	}
+
+	return strings.Join(parts, " ")
+}
+
+// Bucket is a bucket of the histogram: the number of transactions which took
+// no longer than its bound (and longer than the one before's), in microseconds.
+// The last has no bound, which is zero.
+type Bucket struct {
+	LeUs  int64 `json:"le_us"`
+	Count int64 `json:"count"`
+}
+
+// TransactionStats is a Transaction, with the durations in microseconds.
+type TransactionStats struct {
+	ID          int       `json:"id"`
+	Instruction int       `json:"instruction"`
+	At          time.Time `json:"at"`
+	QueueUs     int64     `json:"queue_us"`
+	WriteUs     int64     `json:"write_us"`
+	ResponseUs  int64     `json:"response_us"`
+	TotalUs     int64     `json:"total_us"`
+	Retries     int       `json:"retries"`
+	Incomplete  bool      `json:"incomplete"`
+}
+
+// LatencyStats summarizes the transactions since boot, and the slowest of the
+// last minute, slowest first.
+type LatencyStats struct {
+	Transactions int64              `json:"transactions"`
+	Slow         int64              `json:"slow"`
+	Histogram    []Bucket           `json:"histogram"`
+	Worst        []TransactionStats `json:"worst"`
+}
+
+// Stats returns the stats as of the given time.
+func (l *Latency) Stats(now time.Time) LatencyStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	out := LatencyStats{
+		Transactions: l.total,
+		Slow:         l.slow,
+		Histogram:    make([]Bucket, len(l.hist)),
+		Worst:        []TransactionStats{},
+	}
+
+	for i, n := range l.hist {
+		out.Histogram[i].Count = n
+		if i < len(latencyBuckets) {
+			out.Histogram[i].LeUs = int64(latencyBuckets[i] / time.Microsecond)
+		}
+	}
+
+	all := worst{}
+	for _, w := range l.windows {
+		for _, t := range w.txs {
+			if now.Sub(t.At) < time.Minute {
+				all.add(t, l.cfg.Worst)
+			}
+		}
+	}
+
+	for _, t := range all.txs {
+		out.Worst = append(out.Worst, TransactionStats{
+			ID:          int(t.ID),
+			Instruction: int(t.Instruction),
+			At:          t.At,
+			QueueUs:     int64(t.Queue / time.Microsecond),
+			WriteUs:     int64(t.Write / time.Microsecond),
+			ResponseUs:  int64(t.Response / time.Microsecond),
+			TotalUs:     int64(t.Total() / time.Microsecond),
+			Retries:     t.Retries,
+			Incomplete:  t.Incomplete,
+		})
+	}
+
+	return out
+}
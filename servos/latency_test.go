@@ -0,0 +1,224 @@
+package servos
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/adammck/hexapod/config"
+	"github.com/stretchr/testify/assert"
+)
+
+// slowSerial is a serial port which takes the given time to write each packet,
+// and replies to pings after the given delay, like a servo which is slow to
+// respond. Reads return nothing until then.
+type slowSerial struct {
+	write time.Duration
+	reply time.Duration
+
+	mu    sync.Mutex
+	buf   []byte
+	ready time.Time
+}
+
+func (s *slowSerial) Read(b []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if time.Now().Before(s.ready) {
+		return 0, nil
+	}
+
+	n := copy(b, s.buf)
+	s.buf = s.buf[n:]
+	return n, nil
+}
+
+func (s *slowSerial) Write(b []byte) (int, error) {
+	time.Sleep(s.write)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if b[4] == pingInstruction {
+		s.buf = append(s.buf, 0xFF, 0xFF, b[2], 0x02, 0x00, ^(b[2] + 2))
+		s.ready = time.Now().Add(s.reply)
+	}
+
+	return len(b), nil
+}
+
+func (s *slowSerial) Close() error {
+	return nil
+}
+
+// ping returns a PING packet for the given servo.
+func ping(id byte) []byte {
+	return []byte{0xFF, 0xFF, id, 0x02, pingInstruction, ^(id + 2)}
+}
+
+// readReply reads a whole status packet, trying again (like the network does)
+// until it's arrived.
+func readReply(t *testing.T, p *Port) {
+	buf := make([]byte, 6)
+	n := 0
+	for start := time.Now(); n < len(buf) && time.Since(start) < time.Second; {
+		m, err := p.Read(buf[n:])
+		assert.NoError(t, err)
+		n += m
+		if m == 0 {
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	assert.Equal(t, len(buf), n)
+}
+
+func TestLatencyBreakdown(t *testing.T) {
+	cfg := config.Default().Bus
+	cfg.SlowTransaction = config.Duration{Duration: 15 * time.Millisecond}
+	s := &slowSerial{write: 2 * time.Millisecond, reply: 20 * time.Millisecond}
+	p := NewPort(s)
+	p.Latency = NewLatency(cfg)
+
+	// A write which expects no reply is recorded straight away.
+	_, err := p.Write(writeData(3, torqueEnableAddr, 1))
+	assert.NoError(t, err)
+	stats := p.Latency.Stats(time.Now())
+	assert.Equal(t, int64(1), stats.Transactions)
+	assert.Equal(t, int64(0), stats.Slow)
+
+	// A ping isn't recorded until the reply has arrived.
+	_, err = p.Write(ping(7))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), p.Latency.Stats(time.Now()).Transactions)
+	readReply(t, p)
+
+	stats = p.Latency.Stats(time.Now())
+	assert.Equal(t, int64(2), stats.Transactions)
+	assert.Equal(t, int64(1), stats.Slow)
+
+	// It's the slowest, and the time is in the response, after some retries.
+	if assert.Len(t, stats.Worst, 2) {
+		w := stats.Worst[0]
+		assert.Equal(t, 7, w.ID)
+		assert.Equal(t, pingInstruction, w.Instruction)
+		assert.True(t, w.WriteUs >= 2000, "write=%dus", w.WriteUs)
+		assert.True(t, w.ResponseUs >= 20000, "response=%dus", w.ResponseUs)
+		assert.InDelta(t, w.QueueUs+w.WriteUs+w.ResponseUs, w.TotalUs, 2)
+		assert.Greater(t, w.Retries, 0)
+		assert.False(t, w.Incomplete)
+
+		assert.Equal(t, 3, stats.Worst[1].ID)
+		assert.Equal(t, int64(0), stats.Worst[1].ResponseUs)
+	}
+
+	// The ping is in the 20-50ms bucket, and the write in the 2-5ms one.
+	var counts []int64
+	for _, b := range stats.Histogram {
+		counts = append(counts, b.Count)
+	}
+	assert.Equal(t, []int64{0, 0, 1, 0, 0, 1, 0, 0}, counts)
+	assert.Equal(t, int64(50000), stats.Histogram[5].LeUs)
+	assert.Equal(t, int64(0), stats.Histogram[7].LeUs)
+}
+
+func TestLatencyIncomplete(t *testing.T) {
+	p := NewPort(&slowSerial{reply: time.Hour})
+	p.Latency = NewLatency(config.Default().Bus)
+
+	// The reply never arrives, so the ping is recorded when the next packet is
+	// written, without a response time.
+	p.Write(ping(4))
+	p.Read(make([]byte, 6))
+	p.Write(writeData(4, torqueEnableAddr, 1))
+
+	stats := p.Latency.Stats(time.Now())
+	assert.Equal(t, int64(2), stats.Transactions)
+	var ping TransactionStats
+	for _, w := range stats.Worst {
+		if w.Instruction == pingInstruction {
+			ping = w
+		}
+	}
+	assert.True(t, ping.Incomplete)
+	assert.Equal(t, 1, ping.Retries)
+	assert.Equal(t, int64(0), ping.ResponseUs)
+}
+
+func TestLatencyQueue(t *testing.T) {
+	s := &serial{gate: make(chan struct{})}
+	p := NewPort(s)
+	p.Latency = NewLatency(config.Default().Bus)
+
+	go p.Write([]byte{0xFF, 0xFF, 0x01, 0x04, 0x03, 0x18, 0x01, 0xDF})
+	for len(p.sem) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// The second write waits for the first, which is stuck.
+	done := make(chan struct{})
+	go func() {
+		p.Write([]byte{0xFF, 0xFF, 0x02, 0x04, 0x03, 0x18, 0x01, 0xDE})
+		close(done)
+	}()
+	time.Sleep(20 * time.Millisecond)
+	close(s.gate)
+	<-done
+
+	stats := p.Latency.Stats(time.Now())
+	if assert.Len(t, stats.Worst, 2) {
+		for _, w := range stats.Worst {
+			if w.ID == 2 {
+				assert.True(t, w.QueueUs >= 15000, "queue=%dus", w.QueueUs)
+			} else {
+				assert.True(t, w.WriteUs >= 15000, "write=%dus", w.WriteUs)
+			}
+		}
+	}
+}
+
+func TestLatencyWorst(t *testing.T) {
+	cfg := config.Default().Bus
+	cfg.Worst = 3
+	l := NewLatency(cfg)
+
+	// Records a write to the given servo, at the given second, which took the
+	// given number of milliseconds.
+	t0 := time.Unix(1000, 0)
+	add := func(id byte, sec int, ms int) {
+		l.record(Transaction{ID: id, At: t0.Add(time.Duration(sec) * time.Second), Write: time.Duration(ms) * time.Millisecond})
+	}
+
+	ids := func(now int) []int {
+		var out []int
+		for _, w := range l.Stats(t0.Add(time.Duration(now) * time.Second)).Worst {
+			out = append(out, w.ID)
+		}
+		return out
+	}
+
+	add(1, 0, 30)
+	add(2, 5, 3)
+	add(3, 15, 10)
+	add(4, 25, 4)
+	add(5, 35, 1)
+	add(6, 45, 20)
+
+	// The slowest three, slowest first.
+	assert.Equal(t, []int{1, 6, 3}, ids(50))
+
+	// A minute later, the first has expired, and so on.
+	assert.Equal(t, []int{6, 3, 4}, ids(61))
+	assert.Equal(t, []int{6, 4, 5}, ids(76))
+	assert.Equal(t, []int{6}, ids(100))
+	assert.Empty(t, ids(200))
+
+	// The windows are reused as time goes on, without losing the recent ones.
+	add(7, 70, 2)
+	add(8, 125, 5)
+	assert.Equal(t, []int{8, 7}, ids(126))
+
+	// The total counts everything since the start.
+	assert.Equal(t, int64(8), l.Stats(t0).Transactions)
+}
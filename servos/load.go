@@ -0,0 +1,21 @@
+package servos
+
+// The AX-12 present load register is a ten bit magnitude (out of 1023, the max
+// torque), with the direction in the bit above it.
+const (
+	maxLoad       = 1023
+	loadDirection = 1 << 10
+)
+
+// Load converts a present load reading (as returned by servo.PresentLoad) to a
+// fraction of the max torque, from -1 (clockwise) to +1 (counter-clockwise).
+// It's only a rough measure of the current drawn by the motor, and not a
+// measure of torque at all, so isn't good for much else.
+func Load(v int) float64 {
+	f := float64(v&maxLoad) / maxLoad
+	if v&loadDirection != 0 {
+		return -f
+	}
+
+	return f
+}
@@ -0,0 +1,23 @@
+package servos
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoad(t *testing.T) {
+	for _, tc := range []struct {
+		in  int
+		out float64
+	}{
+		{0, 0},
+		{1023, 1},
+		{512, 512.0 / 1023},
+		{1024, 0},
+		{1024 + 1023, -1},
+		{1024 + 256, -256.0 / 1023},
+	} {
+		assert.Equal(t, tc.out, Load(tc.in), "Load(%d)", tc.in)
+	}
+}
@@ -0,0 +1,216 @@
+package servos
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+const (
+
+	// The WRITE DATA instruction (protocol v1), and the registers which the
+	// emergency stop writes to.
+	writeDataInstruction = 0x03
+	torqueEnableAddr     = 0x18
+	torqueLimitAddr      = 0x22
+
+	// How long the bus has to have been quiet (since the last write) before
+	// the emergency stop is sent, so it doesn't collide with a status packet
+	// on the way back. They're a few bytes at 1Mbps, so this is plenty.
+	quiet = 10 * time.Millisecond
+)
+
+// ErrBusy is returned by Port.Emergency when a write is in progress, and
+// doesn't finish in time.
+var ErrBusy = errors.New("serial port is busy")
+
+// Port wraps the serial port which the network is on, so the emergency stop
+// (see Emergency) can write to it without taking the network lock, which might
+// be held forever if the main loop has stalled.
+//
+// The difficulty is not corrupting a packet which is being written (or
+// replied to) at the time, which the servos would misread. Every packet is
+// written with a single call to Write (see SyncWrite, and the dynamixel
+// protocol), so Port serializes writes, and never splits a packet. It doesn't
+// serialize reads, which don't touch the bus.
+type Port struct {
+	io.ReadWriteCloser
+
+	// Held during each write. It's a channel rather than a mutex so the
+	// emergency stop can give up waiting for it.
+	sem chan struct{}
+
+	// When the last write finished. Protected by sem.
+	last time.Time
+
+	// Where the timing of each transaction is recorded, or nil to not bother.
+	// This must be set before anything is written.
+	Latency *Latency
+
+	// Where the position of each servo is recorded, or nil to not bother. Like
+	// the latency, this must be set before anything is written.
+	Capture *Capture
+
+	// The transaction which is waiting for its reply, if any, and how much of
+	// the reply has arrived. Protected by mu, since reads aren't serialized.
+	mu      sync.Mutex
+	pending *Transaction
+	written time.Time
+	reply   []byte
+}
+
+// NewPort wraps the given serial port.
+func NewPort(rwc io.ReadWriteCloser) *Port {
+	return &Port{
+		ReadWriteCloser: rwc,
+		sem:             make(chan struct{}, 1),
+	}
+}
+
+func (p *Port) Write(b []byte) (int, error) {
+	queued := time.Now()
+	p.sem <- struct{}{}
+	defer func() { <-p.sem }()
+
+	start := time.Now()
+	n, err := p.ReadWriteCloser.Write(b)
+	p.last = time.Now()
+
+	if p.Latency != nil {
+		p.wrote(b, queued, start, p.last)
+	}
+
+	if p.Capture != nil {
+		p.Capture.wrote(b)
+	}
+
+	return n, err
+}
+
+// Read reads from the serial port, and (if a reply is expected) records how
+// long it took to arrive, and what was in it.
+func (p *Port) Read(b []byte) (int, error) {
+	n, err := p.ReadWriteCloser.Read(b)
+	if p.Latency != nil {
+		p.read(b[:n], time.Now())
+	}
+
+	if p.Capture != nil {
+		p.Capture.read(b[:n])
+	}
+
+	return n, err
+}
+
+// wrote records a packet which has just been written. If it expects a reply,
+// it's recorded when that arrives (see read) instead. Any transaction which is
+// still waiting for its reply is recorded as incomplete, since the servos only
+// reply to one thing at a time.
+func (p *Port) wrote(pkt []byte, queued, start, end time.Time) {
+	t := Transaction{
+		At:    queued,
+		Queue: start.Sub(queued),
+		Write: end.Sub(start),
+	}
+
+	if len(pkt) > 4 {
+		t.ID, t.Instruction = pkt[2], pkt[4]
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.pending != nil {
+		p.pending.Incomplete = true
+		p.Latency.record(*p.pending)
+		p.pending = nil
+	}
+
+	if t.ID == broadcastID || (t.Instruction != pingInstruction && t.Instruction != readInstruction) {
+		p.Latency.record(t)
+		return
+	}
+
+	p.pending = &t
+	p.written = end
+	p.reply = p.reply[:0]
+}
+
+// read records the bytes which were just read, which are (part of) the reply
+// to the pending transaction, if there is one. A status packet starts with the
+// header (0xFF 0xFF), the ID, and the length of the rest.
+func (p *Port) read(b []byte, now time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.pending == nil {
+		return
+	}
+
+	if len(b) == 0 {
+		p.pending.Retries += 1
+		return
+	}
+
+	p.reply = append(p.reply, b...)
+	if len(p.reply) < 4 || len(p.reply) < 4+int(p.reply[3]) {
+		return
+	}
+
+	p.pending.Response = now.Sub(p.written)
+	p.Latency.record(*p.pending)
+	p.pending = nil
+}
+
+// Emergency drops the torque limit of every servo to zero, and then disables
+// their torque, by broadcasting to them. It waits (up to the given timeout)
+// for any write in progress to finish first, and then for the bus to be quiet,
+// in case a servo is replying to it.
+//
+// The port is never released afterwards, so nothing else can be written (like
+// another goal position) after the servos have been stopped. This is the last
+// thing that happens before exiting.
+func (p *Port) Emergency(timeout time.Duration) error {
+	select {
+	case p.sem <- struct{}{}:
+	case <-time.After(timeout):
+		return ErrBusy
+	}
+
+	if d := quiet - time.Since(p.last); d > 0 {
+		time.Sleep(d)
+	}
+
+	// The servos don't acknowledge broadcasts, so send everything twice, in
+	// case the first is lost to noise. The torque limit goes first, since it
+	// makes them go limp even if the second packet is lost.
+	var err error
+	for i := 0; i < 2; i++ {
+		for _, pkt := range [][]byte{
+			writeData(broadcastID, torqueLimitAddr, 0, 0),
+			writeData(broadcastID, torqueEnableAddr, 0),
+		} {
+			_, werr := p.ReadWriteCloser.Write(pkt)
+			if werr != nil {
+				err = werr
+			}
+		}
+	}
+
+	return err
+}
+
+// writeData returns a WRITE DATA packet, which writes the given bytes to the
+// registers of the given servo, starting at the given address.
+func writeData(id byte, addr byte, data ...byte) []byte {
+	pkt := []byte{0xFF, 0xFF, id, byte(len(data) + 3), writeDataInstruction, addr}
+	pkt = append(pkt, data...)
+
+	var sum byte
+	for _, c := range pkt[2:] {
+		sum += c
+	}
+
+	return append(pkt, ^sum)
+}
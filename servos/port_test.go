@@ -0,0 +1,125 @@
+package servos
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// serial is a serial port which records every write, and (if the gate isn't
+// nil) blocks each one until the gate is closed, like a write stuck in the
+// driver.
+type serial struct {
+	mu     sync.Mutex
+	writes [][]byte
+	gate   chan struct{}
+}
+
+func (s *serial) Read(b []byte) (int, error) {
+	return 0, nil
+}
+
+func (s *serial) Write(b []byte) (int, error) {
+	if s.gate != nil {
+		<-s.gate
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.writes = append(s.writes, append([]byte{}, b...))
+	return len(b), nil
+}
+
+func (s *serial) Close() error {
+	return nil
+}
+
+func (s *serial) Writes() [][]byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([][]byte{}, s.writes...)
+}
+
+// The packets which the emergency stop sends: zero torque limit, and then
+// torque disabled, twice.
+var emergency = [][]byte{
+	{0xFF, 0xFF, 0xFE, 0x05, 0x03, 0x22, 0x00, 0x00, 0xD7},
+	{0xFF, 0xFF, 0xFE, 0x04, 0x03, 0x18, 0x00, 0xE2},
+	{0xFF, 0xFF, 0xFE, 0x05, 0x03, 0x22, 0x00, 0x00, 0xD7},
+	{0xFF, 0xFF, 0xFE, 0x04, 0x03, 0x18, 0x00, 0xE2},
+}
+
+func TestWriteData(t *testing.T) {
+
+	// The example from the AX-12 manual, which sets the ID of servo 1 to 1.
+	assert.Equal(t, []byte{0xFF, 0xFF, 0x01, 0x04, 0x03, 0x03, 0x01, 0xF3}, writeData(1, 0x03, 1))
+}
+
+func TestEmergency(t *testing.T) {
+	s := &serial{}
+	p := NewPort(s)
+
+	assert.NoError(t, p.Emergency(time.Second))
+	assert.Equal(t, emergency, s.Writes())
+}
+
+func TestEmergencyWaitsForWrite(t *testing.T) {
+	s := &serial{gate: make(chan struct{})}
+	p := NewPort(s)
+	pkt := []byte{0xFF, 0xFF, 0x01, 0x04, 0x03, 0x03, 0x01, 0xF3}
+
+	go p.Write(pkt)
+	for len(p.sem) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	done := make(chan error)
+	go func() {
+		done <- p.Emergency(time.Second)
+	}()
+
+	// Nothing is sent while the packet is still being written.
+	time.Sleep(20 * time.Millisecond)
+	assert.Empty(t, s.Writes())
+
+	// Once it has been, the whole thing comes before the emergency stop, which
+	// waits for the bus to be quiet.
+	close(s.gate)
+	start := time.Now()
+	assert.NoError(t, <-done)
+	assert.True(t, time.Since(start) >= quiet)
+	assert.Equal(t, append([][]byte{pkt}, emergency...), s.Writes())
+
+	// Nothing can be written afterwards.
+	wrote := make(chan struct{})
+	go func() {
+		p.Write(pkt)
+		close(wrote)
+	}()
+
+	select {
+	case <-wrote:
+		t.Error("wrote after the emergency stop")
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+func TestEmergencyGivesUp(t *testing.T) {
+	s := &serial{gate: make(chan struct{})}
+	p := NewPort(s)
+	defer close(s.gate)
+
+	go p.Write([]byte{0xFF})
+	for len(p.sem) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// A write which never finishes means the emergency stop can't be sent
+	// without splitting it.
+	assert.Equal(t, ErrBusy, p.Emergency(20*time.Millisecond))
+	assert.Empty(t, s.Writes())
+}
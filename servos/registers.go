@@ -0,0 +1,80 @@
+package servos
+
+import (
+	"sort"
+
+	"github.com/adammck/dynamixel/network"
+)
+
+// Registers caches the value of a (two byte) register of many servos, so only
+// the values which have changed since they were last written are sent. They're
+// sent in a single sync write, like the goal positions. Nothing is remembered
+// until it has been written, so a write which fails is tried again next time.
+type Registers struct {
+	w       *SyncWrite
+	written map[int]int
+	pending map[int]int
+}
+
+// NewRegisters returns a cache of the (two byte) register at the given address,
+// which starts out not knowing what any servo's register holds.
+func NewRegisters(addr byte) *Registers {
+	return &Registers{
+		w:       NewSyncWrite(addr),
+		written: map[int]int{},
+		pending: map[int]int{},
+	}
+}
+
+// Set queues the value for the servo with the given ID, unless it's what was
+// last written to it.
+func (r *Registers) Set(id int, v int) {
+	if w, ok := r.written[id]; ok && w == v {
+		delete(r.pending, id)
+		return
+	}
+
+	r.pending[id] = v
+}
+
+// Pending returns a copy of the values which are queued to be written, by ID.
+func (r *Registers) Pending() map[int]int {
+	out := make(map[int]int, len(r.pending))
+	for id, v := range r.pending {
+		out[id] = v
+	}
+
+	return out
+}
+
+// WriteTo sends the queued values to the network in a single packet, unless
+// there aren't any, and remembers them once they've been sent. The network
+// must already be locked.
+func (r *Registers) WriteTo(n *network.Network) error {
+	if len(r.pending) == 0 {
+		return nil
+	}
+
+	// In order of ID, so the packet is the same every time.
+	ids := make([]int, 0, len(r.pending))
+	for id := range r.pending {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	r.w.Reset()
+	for _, id := range ids {
+		r.w.Set(id, r.pending[id])
+	}
+
+	err := r.w.WriteTo(n)
+	if err != nil {
+		return err
+	}
+
+	for id, v := range r.pending {
+		r.written[id] = v
+	}
+	r.pending = map[int]int{}
+	return nil
+}
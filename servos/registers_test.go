@@ -0,0 +1,41 @@
+package servos
+
+import (
+	"testing"
+
+	"github.com/adammck/dynamixel/network"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegisters(t *testing.T) {
+	s := &serial{}
+	n := network.New(s)
+	r := NewRegisters(torqueLimitAddr)
+
+	// Nothing is written until something is set.
+	assert.NoError(t, r.WriteTo(n))
+	assert.Empty(t, s.Writes())
+
+	// Everything is written the first time, in order of ID.
+	r.Set(12, 0x200)
+	r.Set(11, 0x100)
+	assert.Equal(t, map[int]int{11: 0x100, 12: 0x200}, r.Pending())
+	assert.NoError(t, r.WriteTo(n))
+
+	w := NewSyncWrite(torqueLimitAddr)
+	w.Set(11, 0x100)
+	w.Set(12, 0x200)
+	assert.Equal(t, [][]byte{w.Bytes()}, s.Writes())
+	assert.Empty(t, r.Pending())
+
+	// After that, only the values which changed.
+	r.Set(11, 0x100)
+	r.Set(12, 0x300)
+	assert.Equal(t, map[int]int{12: 0x300}, r.Pending())
+
+	// And not those which were changed back before being written.
+	r.Set(12, 0x200)
+	assert.Empty(t, r.Pending())
+	assert.NoError(t, r.WriteTo(n))
+	assert.Len(t, s.Writes(), 1)
+}
@@ -0,0 +1,119 @@
+package servos
+
+import (
+	"fmt"
+
+	"github.com/adammck/dynamixel/network"
+)
+
+const (
+
+	// The SYNC WRITE instruction (protocol v1), which writes to the same
+	// registers of many servos at once. Nothing is returned, since it's sent to
+	// the broadcast ID.
+	syncWriteInstruction = 0x83
+	broadcastID          = 0xFE
+
+	// The header (0xFF 0xFF), ID, length, instruction, address, and data length
+	// which start every sync write.
+	syncWriteHeader = 7
+
+	// The AX-12 goal position register, and its range.
+	goalPositionAddr = 0x1e
+	maxPosition      = 1023
+
+	// The range of an AX-12, in degrees, over the range of positions. This is
+	// the same conversion as the servo package makes.
+	maxAngle        float64 = 300
+	angleToPosition float64 = 1 / (maxAngle / maxPosition)
+)
+
+// SyncWrite builds a SYNC WRITE packet, which sets the same register of many
+// servos in a single packet, rather than one (and a response) per servo. The
+// buffer is kept between packets, so building one doesn't allocate once it has
+// grown to size. Values are two bytes, which covers the goal position.
+type SyncWrite struct {
+	addr byte
+	buf  []byte
+}
+
+// NewSyncWrite returns an empty sync write to the (two byte) register at the
+// given address.
+func NewSyncWrite(addr byte) *SyncWrite {
+	w := &SyncWrite{addr: addr}
+	w.Reset()
+	return w
+}
+
+// NewGoalPositions returns an empty sync write to the goal position.
+func NewGoalPositions() *SyncWrite {
+	return NewSyncWrite(goalPositionAddr)
+}
+
+// Reset removes every servo from the packet, so it can be built again.
+func (w *SyncWrite) Reset() {
+	w.buf = append(w.buf[:0], 0xFF, 0xFF, broadcastID, 0, syncWriteInstruction, w.addr, 2)
+}
+
+// Set adds the value for the servo with the given ID to the packet.
+func (w *SyncWrite) Set(id int, v int) {
+	w.buf = append(w.buf, byte(id), byte(v&0xFF), byte((v>>8)&0xFF))
+}
+
+// Len returns the number of servos in the packet.
+func (w *SyncWrite) Len() int {
+	return (len(w.buf) - syncWriteHeader) / 3
+}
+
+// Bytes returns the finished packet, with its length and checksum. It's only
+// valid until the next call to Reset or Set.
+func (w *SyncWrite) Bytes() []byte {
+
+	// The length counts the instruction, address, data length, and checksum,
+	// as well as the data itself.
+	w.buf[3] = byte(len(w.buf) - 4 + 1)
+
+	var sum byte
+	for _, c := range w.buf[2:] {
+		sum += c
+	}
+
+	return append(w.buf, ^sum)
+}
+
+// WriteTo sends the packet to the network, unless it's empty. The network must
+// already be locked.
+func (w *SyncWrite) WriteTo(n *network.Network) error {
+	if w.Len() == 0 {
+		return nil
+	}
+
+	// Network.Write boxes the packet for its logger, even if there isn't one,
+	// which allocates every time. Skip it unless it's logging.
+	if n.Logger == nil {
+		_, err := n.Serial.Write(w.Bytes())
+		return err
+	}
+
+	_, err := n.Write(w.Bytes())
+	return err
+}
+
+// Position returns the goal position which moves a servo with the given zero
+// angle to the given angle, which is wrapped into +/- 180 first. This is the
+// same as servo.MoveTo, for building sync writes.
+func Position(zero, angle float64) (int, error) {
+	for angle > 180 {
+		angle -= 360
+	}
+	for angle < -180 {
+		angle += 360
+	}
+
+	p := int((zero + angle) * angleToPosition)
+	if p < 0 || p > maxPosition {
+		return 0, fmt.Errorf("goal position out of range: %d (angle=%0.2f)", p, angle)
+	}
+
+	return p, nil
+}
@@ -0,0 +1,58 @@
+package servos
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSyncWrite(t *testing.T) {
+	w := NewGoalPositions()
+	assert.Equal(t, 0, w.Len())
+
+	// The example from the AX-12 manual, but for the goal position only.
+	w.Set(0, 0x010)
+	w.Set(1, 0x220)
+	assert.Equal(t, 2, w.Len())
+	assert.Equal(t, []byte{0xFF, 0xFF, 0xFE, 0x0A, 0x83, 0x1E, 0x02, 0x00, 0x10, 0x00, 0x01, 0x20, 0x02, 0x21}, w.Bytes())
+
+	// Reset keeps the header, and the checksum is worked out again.
+	w.Reset()
+	w.Set(1, 0x220)
+	assert.Equal(t, []byte{0xFF, 0xFF, 0xFE, 0x07, 0x83, 0x1E, 0x02, 0x01, 0x20, 0x02, 0x34}, w.Bytes())
+}
+
+func TestPosition(t *testing.T) {
+	p, err := Position(150, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 511, p)
+
+	p, err = Position(150, -150)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, p)
+
+	// Angles are wrapped before converting.
+	p, err = Position(150, 350)
+	assert.NoError(t, err)
+	assert.Equal(t, 477, p)
+
+	_, err = Position(150, 160)
+	assert.Error(t, err)
+}
+
+// BenchmarkSyncWrite builds a packet for the goal positions of every servo in
+// the legs.
+func BenchmarkSyncWrite(b *testing.B) {
+	w := NewGoalPositions()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		w.Reset()
+		for id := 0; id < 6*4; id++ {
+			w.Set(id+11, 512+i%100)
+		}
+		w.Bytes()
+	}
+}
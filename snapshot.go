@@ -0,0 +1,189 @@
+package hexapod
+
+import (
+	"time"
+
+	"github.com/adammck/hexapod/math3d"
+	"github.com/adammck/hexapod/units"
+)
+
+// The version of the JSON representation of Snapshot, which every consumer
+// (telemetry, the API, session summaries, etc) shares. Clients should check the
+// major version before trusting any other field.
+//
+// The major version is incremented when a field is renamed, removed, or changes
+// type, which might break a client. The minor version is incremented when a
+// field is added, which shouldn't. See snapshot_test.go, which enforces this.
+//
+// Version 2 encodes vectors (offset and look_at) as [x,y,z] arrays.
+// Version 2.1 adds the minor version itself.
+// Version 2.2 adds the units, which lengths and angles are converted to.
+// Version 2.3 adds the turbo.
+// Version 2.4 adds safe mode, and whether the hex has been armed in it.
+// Version 2.5 adds the stiffness preset of the legs.
+// Version 2.6 adds the derate, i.e. the factor which the speed is limited to.
+const (
+	SnapshotVersion = 2
+	SnapshotMinor   = 6
+)
+
+// SnapshotPose is the JSON representation of a math3d.Pose. Angles and positions
+// are in the units of the snapshot which it's part of.
+type SnapshotPose struct {
+	X       float64 `json:"x"`
+	Y       float64 `json:"y"`
+	Z       float64 `json:"z"`
+	Heading float64 `json:"heading"`
+	Pitch   float64 `json:"pitch"`
+	Bank    float64 `json:"bank"`
+}
+
+// SnapshotTurbo is the JSON representation of a Turbo, with the durations in
+// seconds.
+type SnapshotTurbo struct {
+	Active    bool    `json:"active"`
+	Factor    float64 `json:"factor"`
+	Remaining float64 `json:"remaining"`
+	Cooldown  float64 `json:"cooldown"`
+}
+
+// Snapshot is a copy of the interesting parts of the State at a single point in
+// time. It's a separate type (rather than just marshalling the State) so that
+// the wire format doesn't change every time the State does.
+//
+// Every length (positions, the clearance, and the feet) and angle (the heading,
+// pitch, and bank of the poses) is in the units which are recorded in it.
+type Snapshot struct {
+	Version   int             `json:"version"`
+	Minor     int             `json:"minor"`
+	Units     units.System    `json:"units"`
+	Robot     string          `json:"robot"`
+	Name      string          `json:"name"`
+	Time      time.Time       `json:"time"`
+	FPS       int             `json:"fps"`
+	Shutdown  bool            `json:"shutdown"`
+	Pose      SnapshotPose    `json:"pose"`
+	Target    SnapshotPose    `json:"target"`
+	Offset    math3d.Vector3  `json:"offset"`
+	LookAt    *math3d.Vector3 `json:"look_at"`
+	Clearance float64         `json:"clearance"`
+	Speed     int             `json:"speed"`
+	Gait      string          `json:"gait"`
+	GaitIndex int             `json:"gait_index"`
+	Voltage   float64         `json:"voltage"`
+	Current   float64         `json:"current"`
+	Charge    float64         `json:"charge"`
+	Resting   bool            `json:"resting"`
+	Turbo     SnapshotTurbo   `json:"turbo"`
+	SafeMode  bool            `json:"safe_mode"`
+	Armed     bool            `json:"armed"`
+	Stiffness string          `json:"stiffness"`
+	Derate    float64         `json:"derate"`
+
+	// The goal position of each foot, in the chassis space. See State.Feet.
+	Feet [6]math3d.Vector3 `json:"feet"`
+}
+
+// Snapshot copies the state into a new Snapshot, in the internal units (mm and
+// degrees); see Snapshot.In to convert it. This must be called from the main
+// loop (i.e. from a component's Tick), since that's the only time that the
+// state is guaranteed not to be changing underneath us. The snapshot shares
+// nothing with the state, so can be handed to other goroutines.
+func (s *State) Snapshot(now time.Time) Snapshot {
+	out := Snapshot{
+		Version:   SnapshotVersion,
+		Minor:     SnapshotMinor,
+		Units:     units.Internal,
+		Robot:     s.Identity.ID,
+		Name:      s.Identity.Name,
+		Time:      now,
+		FPS:       s.FPS,
+		Shutdown:  s.Shutdown,
+		Pose:      makeSnapshotPose(s.Pose),
+		Target:    makeSnapshotPose(s.Target),
+		Offset:    s.Offset,
+		Clearance: s.Target.Position.Y,
+		Speed:     s.Speed,
+		GaitIndex: s.GaitIndex,
+		Voltage:   s.Voltage,
+		Current:   s.Power.Current,
+		Charge:    s.Power.Charge,
+		Resting:   s.Resting,
+		Feet:      s.Feet,
+		SafeMode:  s.SafeMode,
+		Armed:     s.Armed,
+		Stiffness: s.Stiffness,
+		Derate:    s.Derate.Scale(),
+		Turbo: SnapshotTurbo{
+			Active:    s.Turbo.Active,
+			Factor:    s.Turbo.Factor,
+			Remaining: s.Turbo.Remaining.Seconds(),
+			Cooldown:  s.Turbo.Cooldown.Seconds(),
+		},
+	}
+
+	if g, ok := s.ActiveGait(); ok {
+		out.Gait = g.Name
+	}
+
+	// Copy the value, not the pointer, since the controller reuses it.
+	if s.LookAt != nil {
+		v := *s.LookAt
+		out.LookAt = &v
+	}
+
+	return out
+}
+
+// In returns a copy of the snapshot with its lengths and angles converted to
+// the given units. They're converted from the units which it's already in, so
+// converting it more than once (e.g. by two layers which each think they're
+// responsible for it) is harmless.
+func (s Snapshot) In(to units.System) Snapshot {
+	from := s.Units
+	out := s
+	out.Units = to
+
+	length := func(v float64) float64 { return from.ConvertLength(v, to) }
+	vector := func(v math3d.Vector3) math3d.Vector3 {
+		return math3d.Vector3{X: length(v.X), Y: length(v.Y), Z: length(v.Z)}
+	}
+	pose := func(p SnapshotPose) SnapshotPose {
+		return SnapshotPose{
+			X:       length(p.X),
+			Y:       length(p.Y),
+			Z:       length(p.Z),
+			Heading: from.ConvertAngle(p.Heading, to),
+			Pitch:   from.ConvertAngle(p.Pitch, to),
+			Bank:    from.ConvertAngle(p.Bank, to),
+		}
+	}
+
+	out.Pose = pose(s.Pose)
+	out.Target = pose(s.Target)
+	out.Offset = vector(s.Offset)
+	out.Clearance = length(s.Clearance)
+
+	// Don't convert the vector in place, since it's shared with the original.
+	if s.LookAt != nil {
+		v := vector(*s.LookAt)
+		out.LookAt = &v
+	}
+
+	for i, f := range s.Feet {
+		out.Feet[i] = vector(f)
+	}
+
+	return out
+}
+
+func makeSnapshotPose(p math3d.Pose) SnapshotPose {
+	return SnapshotPose{
+		X:       p.Position.X,
+		Y:       p.Position.Y,
+		Z:       p.Position.Z,
+		Heading: p.Heading,
+		Pitch:   p.Pitch,
+		Bank:    p.Bank,
+	}
+}
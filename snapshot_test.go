@@ -0,0 +1,279 @@
+package hexapod
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/adammck/hexapod/config"
+	"github.com/adammck/hexapod/math3d"
+	"github.com/adammck/hexapod/units"
+	"github.com/stretchr/testify/assert"
+)
+
+// Run with -update to write the golden file for the current SnapshotVersion and
+// SnapshotMinor, after an intentional change to the Snapshot. It refuses to
+// overwrite one which already exists: clients might depend on every version
+// which has been released, so each golden file is kept (and checked) forever.
+//
+// The policy is:
+//
+//   - Adding a field bumps SnapshotMinor. Every golden file with the same
+//     major version must still be a subset of the current snapshot.
+//   - Renaming or removing a field, or changing its type, bumps
+//     SnapshotVersion (and resets SnapshotMinor to zero). The golden files for
+//     older major versions are then ignored.
+var update = flag.Bool("update", false, "write the golden snapshot file for the current version")
+
+func goldenPath(major, minor int) string {
+	return filepath.Join("testdata", fmt.Sprintf("snapshot-%d.%d.json", major, minor))
+}
+
+// fullSnapshot returns a snapshot with every field set to something other than
+// its zero value, so none of them are omitted or ambiguous in the golden file.
+func fullSnapshot() Snapshot {
+	lookAt := math3d.Vector3{X: 10, Y: 20, Z: 300}
+	state := &State{
+		Identity:  Identity{Name: "Hex Two", ID: "hex-two"},
+		FPS:       60,
+		Shutdown:  true,
+		SafeMode:  true,
+		Armed:     true,
+		Stiffness: "soft",
+		Commands: Commands{
+			Target:    math3d.Pose{Position: math3d.Vector3{X: 1, Y: 40, Z: 3}, Heading: 45, Pitch: 1.5, Bank: -2},
+			Offset:    math3d.Vector3{X: 4, Y: 5, Z: 6},
+			LookAt:    &lookAt,
+			Speed:     2,
+			GaitIndex: 1,
+			Turbo:     Turbo{Active: true, Factor: 1.5, Remaining: 2500 * time.Millisecond, Cooldown: 12500 * time.Millisecond},
+			Derate:    Derate{Active: true, Factor: 0.8, Battery: 0.8, Thermal: 1},
+		},
+		Measurements: Measurements{Voltage: 11.1},
+		Estimates: Estimates{
+			Pose:    math3d.Pose{Position: math3d.Vector3{X: 1, Y: 38, Z: 2}, Heading: 44.5, Pitch: 1, Bank: -1.5},
+			Power:   Power{Current: 1.5, Charge: 250},
+			Resting: true,
+		},
+	}
+
+	for i := range state.Feet {
+		state.Feet[i] = math3d.Vector3{X: float64(i * 100), Y: -40, Z: float64(50 - i)}
+	}
+
+	s := state.Snapshot(time.Date(2017, 6, 1, 12, 30, 0, 500, time.UTC))
+
+	// There are no gaits in the state, so the name has to be set by hand.
+	s.Gait = "tripod"
+	return s
+}
+
+func TestSnapshotGolden(t *testing.T) {
+	got, err := json.MarshalIndent(fullSnapshot(), "", "  ")
+	assert.NoError(t, err)
+	got = append(got, '\n')
+
+	path := goldenPath(SnapshotVersion, SnapshotMinor)
+
+	if *update {
+		if _, err := os.Stat(path); err == nil {
+			t.Fatalf("%s already exists; bump SnapshotMinor (or SnapshotVersion) rather than changing it", path)
+		}
+		assert.NoError(t, os.MkdirAll("testdata", 0755))
+		assert.NoError(t, ioutil.WriteFile(path, got, 0644))
+		return
+	}
+
+	want, err := ioutil.ReadFile(path)
+	if !assert.NoError(t, err, "bump SnapshotMinor (or SnapshotVersion) and run with -update to create it") {
+		return
+	}
+
+	assert.Equal(t, string(want), string(got), "snapshot differs from %s; bump SnapshotMinor (or SnapshotVersion) and run with -update", path)
+}
+
+func TestSnapshotCompatibility(t *testing.T) {
+	b, err := json.Marshal(fullSnapshot())
+	assert.NoError(t, err)
+
+	var cur interface{}
+	assert.NoError(t, json.Unmarshal(b, &cur))
+
+	paths, err := filepath.Glob(filepath.Join("testdata", "snapshot-*.json"))
+	assert.NoError(t, err)
+	assert.NotEmpty(t, paths)
+
+	for _, path := range paths {
+		var major, minor int
+		_, err := fmt.Sscanf(filepath.Base(path), "snapshot-%d.%d.json", &major, &minor)
+		if !assert.NoError(t, err, path) {
+			continue
+		}
+
+		// Older major versions are allowed to be incompatible, and newer ones
+		// must not exist yet.
+		if major < SnapshotVersion {
+			continue
+		}
+		assert.False(t, major > SnapshotVersion || minor > SnapshotMinor, "%s is newer than the current version (%d.%d)", path, SnapshotVersion, SnapshotMinor)
+
+		b, err := ioutil.ReadFile(path)
+		assert.NoError(t, err)
+
+		var old interface{}
+		assert.NoError(t, json.Unmarshal(b, &old))
+
+		for _, msg := range incompatible("", old, cur) {
+			t.Errorf("%s: %s; that needs a new SnapshotVersion", path, msg)
+		}
+	}
+}
+
+// incompatible returns the fields in the old JSON value which are missing from
+// (or a different type in) the new one. Fields which were added are fine.
+func incompatible(prefix string, old, new interface{}) []string {
+	if reflect.TypeOf(old) != reflect.TypeOf(new) {
+		return []string{fmt.Sprintf("%s changed from %T to %T", prefix, old, new)}
+	}
+
+	var out []string
+	switch o := old.(type) {
+	case map[string]interface{}:
+		n := new.(map[string]interface{})
+		for k, v := range o {
+			nv, ok := n[k]
+			if !ok {
+				out = append(out, fmt.Sprintf("%s.%s was removed", prefix, k))
+				continue
+			}
+			out = append(out, incompatible(prefix+"."+k, v, nv)...)
+		}
+
+	case []interface{}:
+		n := new.([]interface{})
+		if len(o) != len(n) {
+			return []string{fmt.Sprintf("%s changed length from %d to %d", prefix, len(o), len(n))}
+		}
+		for i := range o {
+			out = append(out, incompatible(fmt.Sprintf("%s[%d]", prefix, i), o[i], n[i])...)
+		}
+	}
+
+	return out
+}
+
+func TestIncompatible(t *testing.T) {
+	old := map[string]interface{}{"a": 1.0, "b": []interface{}{1.0, 2.0}, "c": map[string]interface{}{"d": "x"}}
+
+	// Adding fields, and changing values, is fine.
+	assert.Empty(t, incompatible("", old, map[string]interface{}{
+		"a": 2.0, "b": []interface{}{3.0, 4.0}, "c": map[string]interface{}{"d": "y", "e": true}, "f": nil,
+	}))
+
+	// Removing, retyping, or resizing isn't.
+	assert.Equal(t, []string{".c.d was removed"}, incompatible("", old, map[string]interface{}{
+		"a": 1.0, "b": []interface{}{1.0, 2.0}, "c": map[string]interface{}{},
+	}))
+	assert.Equal(t, []string{".a changed from float64 to string"}, incompatible("", old, map[string]interface{}{
+		"a": "1", "b": []interface{}{1.0, 2.0}, "c": map[string]interface{}{"d": "x"},
+	}))
+	assert.Equal(t, []string{".b changed length from 2 to 3"}, incompatible("", old, map[string]interface{}{
+		"a": 1.0, "b": []interface{}{1.0, 2.0, 3.0}, "c": map[string]interface{}{"d": "x"},
+	}))
+}
+
+func TestSnapshotJSON(t *testing.T) {
+	lookAt := math3d.Vector3{X: 1, Y: 2, Z: 3}
+	state := &State{
+		FPS: 60,
+		Commands: Commands{
+			LookAt:    &lookAt,
+			Speed:     2,
+			GaitIndex: 1,
+		},
+		Measurements: Measurements{Voltage: 11.1},
+		Estimates: Estimates{
+			Pose:  math3d.Pose{Position: math3d.Vector3{X: 1, Y: 2, Z: 3}, Heading: 90},
+			Power: Power{Current: 1.5, Charge: 250},
+		},
+	}
+	state.Feet[1] = math3d.Vector3{X: 100, Y: -40, Z: 50}
+
+	b, err := json.Marshal(state.Snapshot(time.Time{}))
+	assert.NoError(t, err)
+
+	var m map[string]interface{}
+	assert.NoError(t, json.Unmarshal(b, &m))
+	assert.Equal(t, float64(SnapshotVersion), m["version"])
+	assert.Equal(t, float64(SnapshotMinor), m["minor"])
+	assert.Equal(t, 90.0, m["pose"].(map[string]interface{})["heading"])
+	assert.Equal(t, []interface{}{1.0, 2.0, 3.0}, m["look_at"])
+	assert.Equal(t, []interface{}{0.0, 0.0, 0.0}, m["offset"])
+	assert.Equal(t, 11.1, m["voltage"])
+	assert.Equal(t, 1.5, m["current"])
+	assert.Equal(t, 250.0, m["charge"])
+	assert.Equal(t, map[string]interface{}{"active": false, "factor": 0.0, "remaining": 0.0, "cooldown": 0.0}, m["turbo"])
+	if feet, ok := m["feet"].([]interface{}); assert.True(t, ok) && assert.Len(t, feet, 6) {
+		assert.Equal(t, []interface{}{100.0, -40.0, 50.0}, feet[1])
+	}
+
+	// The snapshot must not alias the state.
+	lookAt.X = 99
+	s := state.Snapshot(time.Time{})
+	state.LookAt.X = 100
+	assert.Equal(t, 99.0, s.LookAt.X)
+}
+
+func TestSnapshotUnits(t *testing.T) {
+	metric := units.System{Length: units.Meters, Angle: units.Radians}
+	s := fullSnapshot()
+	assert.Equal(t, units.Internal, s.Units)
+
+	m := s.In(metric)
+	assert.Equal(t, metric, m.Units)
+	assert.InDelta(t, 0.001, m.Pose.X, 1e-9)
+	assert.InDelta(t, 0.038, m.Pose.Y, 1e-9)
+	assert.InDelta(t, 44.5*math.Pi/180, m.Pose.Heading, 1e-9)
+	assert.InDelta(t, -2*math.Pi/180, m.Target.Bank, 1e-9)
+	assert.InDelta(t, 0.006, m.Offset.Z, 1e-9)
+	assert.InDelta(t, 0.3, m.LookAt.Z, 1e-9)
+	assert.InDelta(t, 0.04, m.Clearance, 1e-9)
+	assert.InDelta(t, 0.5, m.Feet[5].X, 1e-9)
+
+	// Everything else is left alone.
+	assert.Equal(t, s.Voltage, m.Voltage)
+	assert.Equal(t, s.Speed, m.Speed)
+
+	// The original isn't touched, including via the look at pointer.
+	assert.Equal(t, 300.0, s.LookAt.Z)
+	assert.Equal(t, 1.0, s.Pose.X)
+
+	// Converting twice is the same as converting once, and converting back is
+	// the same as (near enough) not converting.
+	assert.Equal(t, m, m.In(metric))
+	back := m.In(units.Internal)
+	assert.InDelta(t, s.Pose.Heading, back.Pose.Heading, 1e-9)
+	assert.InDelta(t, s.Feet[3].X, back.Feet[3].X, 1e-9)
+	assert.Equal(t, units.Internal, back.Units)
+}
+
+func TestSnapshotIdentity(t *testing.T) {
+	cfg := config.Default()
+	cfg.Identity = config.Identity{Name: "Hex Two", Description: "the spare"}
+	h := New(nil, cfg)
+
+	b, err := json.Marshal(h.State.Snapshot(time.Time{}))
+	assert.NoError(t, err)
+
+	var m map[string]interface{}
+	assert.NoError(t, json.Unmarshal(b, &m))
+	assert.Equal(t, "hex-two", m["robot"])
+	assert.Equal(t, "Hex Two", m["name"])
+}
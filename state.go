@@ -0,0 +1,659 @@
+package hexapod
+
+import (
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/adammck/hexapod/math3d"
+)
+
+// State is passed to every component on every tick. It's split into sections
+// by who writes them, so that it's clear which component is responsible for
+// what: Commands are what the hex has been told to do, Measurements are what
+// the sensors say, and Estimates are what the hex believes about itself. The
+// sections are embedded, so their fields can be used as if they were the
+// state's own. The fields directly on the state are requests which any
+// component can make, and the loop's own bookkeeping, except for the identity,
+// which is read-only.
+//
+// Components declare which sections they write by implementing StateWriter.
+// That isn't enforced unless Hexapod.Strict is set, which is meant for tests.
+type State struct {
+
+	// Which robot this is. It's set when the hexapod is created, and must not
+	// be written by any component.
+	Identity Identity
+
+	// The approximate number of frames per second which the main loop is
+	// currently running at. This can vary quite a bit depending on the load.
+	FPS int
+
+	// Components can set this to true to indicate that the hex should shut
+	// down. Components which need to clean up before being terminated (e.g.
+	// powering off servos) should check this value frequently.
+	Shutdown bool
+
+	// The gaits which Commands.Gait can be chosen from.
+	Gaits *GaitRegistry
+
+	// Components can set this to true to request that the flight recorder dump
+	// its buffer to disk. The recorder resets it once the dump is written.
+	Dump bool
+
+	// The name of the active profile (see config.Profile), or empty if none is
+	// active. This is set by the profiles component.
+	Profile string
+
+	// Components can set this to true to request that the next profile be
+	// activated. The profiles component resets it once it has done so.
+	NextProfile bool
+
+	// The name of the stiffness preset (see config.Stiffness) which the leg
+	// servos are set to, or empty until the legs have set the first. This is
+	// set by the legs.
+	Stiffness string
+
+	// Components can set this to the name of a stiffness preset to ask the
+	// legs to switch to it. The legs reset it once they've seen it, but only
+	// switch while every foot is on the ground, so it can take a step.
+	SetStiffness string
+
+	// Components can set this to pause or step through the gait, for debugging
+	// foot placement. The legs reset it once they've seen it, and ignore it
+	// unless config.Gait.Debug is set.
+	GaitStep GaitStepRequest
+
+	// Where the legs are in the step cycle, which they set every tick while
+	// config.Gait.Debug is set. It's zero while that isn't.
+	GaitDebug GaitDebug
+
+	// Set by the calibration component while the wizard is running. Walking
+	// input should be ignored, and the legs leave the servos alone, so they
+	// can be posed by hand.
+	Calibrating bool
+
+	// Components can set this to true to ask for the self-test to be run. It's
+	// ignored unless the hex is parked. The selftest component resets it.
+	StartSelfTest bool
+
+	// Set by the selftest component while the self-test is running. Like while
+	// calibrating, walking input should be ignored, and the legs leave the
+	// servos alone, since they're being moved one leg at a time.
+	SelfTesting bool
+
+	// The results of the most recent self-test, or the one in progress.
+	SelfTest SelfTest
+
+	// Set by the righting component while the IMU says that the hex is lying
+	// still on its back. The legs leave the servos alone meanwhile, and stand
+	// up again once it's the right way up.
+	Fallen bool
+
+	// Components can set this to true to ask for the hex to be rolled back
+	// over. It's ignored unless it has fallen. The righting component resets
+	// it.
+	StartRighting bool
+
+	// Set by the righting component while it's rolling the hex over. Like
+	// while self-testing, the legs leave the servos alone.
+	Righting bool
+
+	// Set by the startup component, which checks which way up the hex is
+	// before the legs stand it up for the first time: Startup is what it
+	// found, and StartupHold is set until that's on its feet. The legs leave
+	// the servos alone meanwhile, and stand up once it's reset.
+	Startup     Situation
+	StartupHold bool
+
+	// Components can set this to true to ask for the hex to stand up anyway,
+	// whatever the startup check says. It's ignored once it has stood up. The
+	// startup component resets it.
+	StandAnyway bool
+
+	// Components can set this to true to ask for the legs to be folded up
+	// against the chassis, and the torque turned off, so the hex can be
+	// carried. The transport component resets it.
+	StartTransport bool
+
+	// Set by the transport component while it's folding the legs up, or they
+	// are, until it has been woken up and unfolded them again. The hex is
+	// halted meanwhile, and the legs leave the servos alone once it's started
+	// folding (see Transport.OwnsLegs), and stand up again afterwards like
+	// they do after booting.
+	Transport Transport
+
+	// Set by the endurance component while it has parked the hex for a rest,
+	// to let the servos cool down on a long run. See config.Endurance.
+	Cooling bool
+
+	// Set by the safemode component while the hex is in safe mode (see
+	// config.Config.Safe), so the LEDs and telemetry can show it. Armed is set
+	// once the arming sequence has been done; until then the hex is halted.
+	SafeMode bool
+	Armed    bool
+
+	// Components can set this to ask the calibration wizard to do something.
+	// The calibration component resets it once it has been handled.
+	Calibration CalibrationRequest
+
+	// Components can set this to true to stop the legs from resting (e.g. while
+	// playing a sequence, which looks idle). It must be set on every tick,
+	// since the legs reset it once they've seen it.
+	KeepAwake bool
+
+	// Components can set this to true to ask the legs to step the feet back to
+	// their home positions under the body, one at a time, and then fold the
+	// offset into the pose. It's ignored unless the hex is parked, and nothing
+	// is keeping it awake. The legs reset it. See config.Legs.RecentreDistance.
+	Recentre bool
+
+	// Components can set this to the name of one of the odometer's lifetime
+	// counters (see components/odometer), to reset it, e.g. after replacing a
+	// worn servo. The odometer resets it once it has been handled.
+	ResetCounter string
+
+	// Components can set this to true to ask the navigator to walk its canned
+	// route (see config.Navigator). The navigator resets it once it has queued
+	// the route.
+	StartRoute bool
+
+	// Components can set this to ask the navigator to set or return to the
+	// home pose. The navigator resets it once it has been handled.
+	Home HomeRequest
+
+	// Components can set this to make the head gesture, after any gestures
+	// which it's already doing. The head resets it once it has been queued.
+	Gesture Gesture
+
+	// Set by the core while an autonomous component is waiting for its
+	// countdown to finish before it can move the hex, so the buzzer and LEDs
+	// can warn anyone nearby. See Autonomous.
+	Countdown Countdown
+
+	// Events published during the current component's tick. See Publish.
+	events []Event
+
+	Commands
+	Measurements
+	Estimates
+}
+
+// Commands are what the hex has been told to do. They're written by the
+// components which take input (the controller, API, etc), and read by the legs
+// and head.
+type Commands struct {
+
+	// Components can set this to true to indicate that the hex should stop
+	// moving (but not shut down) as soon as possible, e.g. via the API.
+	// Components which control the target should hold it at the current pose
+	// (but keep the clearance) until it's reset.
+	Halt bool
+
+	// The offset from the actual home position which the feet should be
+	// positioned at.
+	Offset math3d.Vector3
+
+	// The rotation (in degrees) about the Y axis which the chassis is turned
+	// by, on top of the offset. Like the offset, this moves the chassis on its
+	// feet, rather than turning them.
+	OffsetHeading float64
+
+	// The target pose of the origin, in the world space. This can be set to
+	// instruct the legs to walk towards an arbitrary point, and the chassis to
+	// orient itself strangely.
+	Target math3d.Pose
+
+	// The point to aim the head (camera) at, in the world space. This is a
+	// pointer so it can be set to nil if there is no target.
+	LookAt *math3d.Vector3
+
+	// The gait which should be used. If it's zero, the gait at GaitIndex (mod
+	// however many gaits are registered) is used instead; SetGait keeps the two
+	// in sync. See ActiveGait.
+	Gait      Gait
+	GaitIndex int
+
+	// The increase (or decrease, if negative) from the default speed at which
+	// we should walk. There is no unit; more is just faster. See MinSpeed.
+	Speed int
+
+	// The navigator's progress through its queue of waypoints. This is zero
+	// unless it has somewhere to go.
+	Navigation Navigation
+
+	// The follower's progress behind the leader. This is zero unless it's
+	// following another hex. See config.Follow.
+	Follow Follow
+
+	// The controller's speed boost, which multiplies its move speed for a few
+	// seconds. See config.Controller.TurboFactor.
+	Turbo Turbo
+
+	// The controller's auto gait mode, which picks the gait from how fast the
+	// hex is being told to walk. See config.Controller.AutoGait.
+	AutoGait AutoGait
+
+	// The limit on how fast the hex should walk, from the battery voltage and
+	// servo temperature. See config.Derate.
+	Derate Derate
+
+	// A copy of the raw controller input for the current tick. Nothing should
+	// be controlled by this; it's only here for the flight recorder.
+	Input Input
+}
+
+// Navigation is the progress of the navigator, which walks to a queue of
+// waypoints by setting the target.
+type Navigation struct {
+
+	// Set while there's a waypoint to walk to, even if navigation is paused.
+	Active bool `json:"active"`
+
+	// Set while something else is in control of the target, e.g. because the
+	// sticks are being used, or the hex has been halted. Navigation resumes
+	// once it's released, towards the same goal.
+	Paused bool `json:"paused"`
+
+	// The pose to reach the current waypoint, in the world space, and how far
+	// (in mm, and degrees) the pose is from it.
+	Goal     math3d.Pose `json:"goal"`
+	Distance float64     `json:"distance"`
+	Angle    float64     `json:"angle"`
+
+	// The number of waypoints reached since the navigator became active, and
+	// the number left to go, including the current one.
+	Reached   int `json:"reached"`
+	Remaining int `json:"remaining"`
+
+	// Set while walking back to the home pose, which (unlike the rest) is kept
+	// while the navigator is idle, until it's invalidated by drift.
+	Homing  bool        `json:"homing"`
+	HasHome bool        `json:"has_home"`
+	Home    math3d.Pose `json:"home"`
+}
+
+// Follow is the progress of the follower, which walks to a slot behind another
+// hex by setting the target.
+type Follow struct {
+
+	// Set once the leader has been heard from.
+	Active bool `json:"active"`
+
+	// Set while something else is in control of the target, like Navigation,
+	// until it's released.
+	Paused bool `json:"paused"`
+
+	// Set while the leader hasn't been heard from for too long, which halts
+	// the follower until it is again.
+	Lost bool `json:"lost"`
+
+	// The name of the leader, as it says, and how far (in mm, ignoring the
+	// clearance) the pose is from the slot behind it.
+	Leader   string  `json:"leader"`
+	Distance float64 `json:"distance"`
+}
+
+// Turbo is the state of the controller's speed boost, e.g. for the LEDs.
+type Turbo struct {
+
+	// Set while the boost is engaged (including while it decays).
+	Active bool
+
+	// The multiplier of the move speed while Active, or zero.
+	Factor float64
+
+	// How long the boost will last, including the decay, and how long until it
+	// can be engaged again. Both are zero while it's ready.
+	Remaining time.Duration
+	Cooldown  time.Duration
+}
+
+// AutoGait is the state of the controller's auto gait mode, e.g. for the
+// console.
+type AutoGait struct {
+
+	// Set while the mode is enabled. The rest is zero while it isn't.
+	Enabled bool
+
+	// How fast (in mm per step cycle) the hex was told to walk during the
+	// last tick, and the name of the gait which that picked, which is the
+	// active gait unless it can't be used at the current clearance.
+	Speed float64
+	Gait  string
+}
+
+// Derate is the limit on the speed of the hex, as a factor of its full speed,
+// which the controller and navigator scale their moves (and the turbo) by.
+type Derate struct {
+
+	// Set while the speed is limited at all, i.e. the factor is below one.
+	Active bool
+
+	// The lowest of the factors below (from zero to one) while Active, which
+	// is the one in effect, or zero.
+	Factor float64
+
+	// The factor from each policy, from the battery voltage and from the
+	// servo temperature, while Active, or zero.
+	Battery float64
+	Thermal float64
+}
+
+// Scale returns the factor to scale the speed by, which is one if it isn't
+// limited.
+func (d Derate) Scale() float64 {
+	if !d.Active {
+		return 1
+	}
+
+	return d.Factor
+}
+
+// Measurements are what the sensors have read. They're written by the sensor
+// components, and never by anything which is only guessing.
+type Measurements struct {
+
+	// The battery voltage, averaged over the readings within the window (see
+	// config.Voltage), and the lowest and highest of those readings. These are
+	// only updated every few seconds (by the voltage component), and are zero
+	// until the first check.
+	Voltage    float64
+	VoltageMin float64
+	VoltageMax float64
+
+	// Set by the voltage component while the battery is low (below
+	// safety.min_voltage), or critical (below safety.critical_voltage, which
+	// is low too). Each is only cleared once the voltage has risen back above
+	// its threshold by voltage.hysteresis, so they don't flap.
+	BatteryLow      bool
+	BatteryCritical bool
+
+	// The temperature (in degrees C) of the servo which the voltage is read
+	// from, at the same time, or zero if it can't be read. The others are
+	// probably about as hot, give or take how hard their legs are working.
+	ServoTemperature float64
+
+	// The distance (in mm) to whatever the rangefinder sees ahead, at about
+	// the height of the body, or zero if nothing is in range (or there's no
+	// rangefinder).
+	Range float64
+
+	// The health of the computer which the hex runs on. This is updated every
+	// second by the sysmon component.
+	System System
+}
+
+// System is the health of the computer, and of the main loop running on it.
+type System struct {
+
+	// The fraction of the CPU (from zero to one) which was busy since the last
+	// sample, across all cores.
+	CPU float64
+
+	// The temperature (in degrees C) of the CPU, or zero if it can't be read.
+	Temperature float64
+
+	// The memory (in bytes) which is in use, and installed.
+	MemUsed  uint64
+	MemTotal uint64
+
+	// The fraction of recent ticks which took longer than the tick period, and
+	// the number of steps of load (see config.Sysmon) which have been shed
+	// because of it.
+	Overrun float64
+	Shed    int
+}
+
+// Estimates are what the hex believes about itself, based on the commands and
+// measurements. They're written by the legs (and the simulator, which knows
+// better), the head, and the power estimator.
+type Estimates struct {
+
+	// The actual pose at the origin, in the world coordinate space. This should
+	// be updated as accurately as possible as the hex walks around.
+	Pose math3d.Pose
+
+	// Set by the legs component for each leg whose goal was outside of its reach
+	// during the current tick, in the same order as legs.Legs.
+	Saturated [6]bool
+
+	// The goal position of each foot, in the chassis coordinate space, as most
+	// recently sent to the servos by the legs component. Same order as above.
+	Feet [6]math3d.Vector3
+
+	// How close the hex is to tipping over, going by the feet which are on
+	// the ground. It's set by the legs every tick, once they're ready.
+	Stability Stability
+
+	// The plane which the feet on the ground are standing on, fitted to them
+	// by the legs every tick, once they're ready. Until then, it's Y=0.
+	Ground Ground
+
+	// How far (in mm) the pose may have drifted from the truth since boot,
+	// which only ever increases. Dead reckoning gets worse the further the hex
+	// walks, and much worse when a foot can't reach its goal, since it probably
+	// slipped. Compare two readings to tell how far to trust the pose between
+	// them.
+	Drift float64
+
+	// Set by the legs component while it's resting, after standing still for a
+	// while. The body is lowered a little, and the torque may be reduced.
+	Resting bool
+
+	// The gait params which the legs started the current step cycle with.
+	// Changes to the params only take effect from the start of the next, so
+	// this can be a cycle behind them.
+	GaitParams GaitParams
+
+	// Where the legs are in the step cycle, for the components which follow
+	// the gait. It's set by the legs every tick, which are registered before
+	// any of those (see components/builtin), so it's always this tick's by the
+	// time they see it.
+	GaitStatus GaitStatus
+
+	// How much the legs have been used since boot, for the lifetime counters.
+	// It's set by the legs every tick.
+	Usage Usage
+
+	// Where the head is pointing, as most recently sent to its servos.
+	Head Head
+
+	// How much current the hex is drawing from the battery, going by the load
+	// on its servos.
+	Power Power
+
+	// How far the legs have reduced things to keep the current within the
+	// torque budget (see config.Budget).
+	Budget Budget
+}
+
+// GaitParams are the tunable params of the gait: the ticks per step at speed
+// zero, the fraction of the cycle which each foot spends on the ground (or zero
+// for however long the gait leaves it there), and the height (in mm) which the
+// feet are lifted to and their distance from the center of the body.
+type GaitParams struct {
+	TicksPerStep int
+	DutyFactor   float64
+	StepHeight   float64
+	StepRadius   float64
+}
+
+// GaitDebug is where the legs are in the step cycle, for stepping through it.
+// Tick is the number of ticks of the current cycle which have been played (so
+// zero between cycles) out of Length, and Phase is the step which the next tick
+// is part of. Cycles is the number of step cycles walked since boot.
+type GaitDebug struct {
+	Enabled bool
+	Paused  bool
+	Tick    int
+	Length  int
+	Phase   int
+	Cycles  int
+}
+
+// GaitStatus is where the legs are in the current step cycle. Phase is the
+// fraction of the cycle which had been played before this tick's frame, from
+// zero to just under one, so it goes up by a frame per tick and wraps around
+// at the start of the next. Swing is whether each foot is in the air, and
+// Landing is where each will be at the end of the cycle, in the world space
+// (on the ground, so Y is zero), in the same order as Feet. NextPhase is how
+// long until the end of the current step, i.e. the next phase boundary (see
+// GaitDebug), in ticks and at the current FPS.
+//
+// Walking is false (and everything else is zero) between cycles, e.g. while
+// parked. While the gait is paused, it stays as it was when it was paused.
+type GaitStatus struct {
+	Walking        bool
+	Phase          float64
+	Swing          [6]bool
+	Landing        [6]math3d.Vector3
+	NextPhaseTicks int
+	NextPhase      time.Duration
+}
+
+// Stability is how statically stable the hex is. Margin is how far (in mm) the
+// center of mass (see config.Chassis.CenterOfMass), projected straight down,
+// is inside the support polygon, i.e. the convex hull of the feet which are on
+// the ground, from its nearest edge. It's negative outside, e.g. after leaning
+// too far, by how far outside. Planted is the number of feet on the ground.
+//
+// With fewer than three, there's no polygon to be inside, so Margin is the
+// distance to the foot (or the line between the two), negated, which is never
+// positive. With none, it's zero.
+type Stability struct {
+	Margin  float64
+	Planted int
+}
+
+// Ground is a plane in the world space: the points p for which
+// Normal.Dot(p) == Offset. Normal is a unit vector, pointing up. Feet is the
+// number of feet whose measured positions it was fitted to, which is fewer than
+// are on the ground if any were too far out of line with the rest (e.g.
+// standing on a block) and were left out, or haven't been measured yet.
+//
+// The zero value is treated as the Y=0 plane, which the feet were assumed to be
+// standing on before there was an estimate. There never is without the legs'
+// feedback (see config.Legs.Feedback), which the measurements come from.
+type Ground struct {
+	Normal math3d.Vector3
+	Offset float64
+	Feet   int
+}
+
+// Height returns the height (Y) of the ground at the given X/Z.
+func (g Ground) Height(x, z float64) float64 {
+	if g.Normal.Y == 0 {
+		return 0
+	}
+
+	return (g.Offset - g.Normal.X*x - g.Normal.Z*z) / g.Normal.Y
+}
+
+// Usage is how much the legs have been used since boot. Steps is the number of
+// times which each foot has been lifted, in the same order as Feet, and Torque
+// is how long the servos have been holding the legs up (i.e. not while they're
+// being calibrated or tested, or after sitting down).
+type Usage struct {
+	Steps  [6]int
+	Torque time.Duration
+}
+
+// Power is the current (in amps) which the power estimator thinks is being
+// drawn from the battery, smoothed, and the charge (in mAh) drawn since boot.
+// These are only estimates from the servo load, and could easily be out by a
+// quarter or more until they're calibrated.
+type Power struct {
+	Current float64
+	Charge  float64
+}
+
+// Budget is how far each stage of the torque budget has been reduced, from zero
+// (not at all) to one (as far as it goes), and the total number of steps which
+// that adds up to. HeadTorque is the torque limit which the head should use, or
+// zero for its own.
+type Budget struct {
+	Level      int
+	Swing      float64
+	Head       float64
+	Gait       float64
+	HeadTorque int
+}
+
+// Head is the angle (in degrees) of the head, to the right and up from looking
+// straight ahead. Clamped is true if it's pointing at the edge of its range,
+// because State.LookAt is outside of it. Gesture is the gesture in progress, if
+// any, including blending back to State.LookAt afterwards. Path is set while a
+// look path is being played, in place of State.LookAt (see head.LookPath).
+type Head struct {
+	Pan     float64
+	Tilt    float64
+	Clamped bool
+	Gesture Gesture
+	Path    bool
+}
+
+// Copy returns a copy of the state which doesn't share anything that the
+// original's components might change, so it can be kept (e.g. for telemetry)
+// while they carry on. The gait registry is shared, since it's read-only.
+// Unpublished events aren't copied.
+func (s *State) Copy() State {
+	c := *s
+	c.events = nil
+	if s.LookAt != nil {
+		v := *s.LookAt
+		c.LookAt = &v
+	}
+
+	return c
+}
+
+// Role is a set of the sections of the state which a component writes.
+type Role int
+
+const (
+	Commander Role = 1 << iota
+	Sensor
+	Estimator
+)
+
+// StateWriter is an optional interface for components which write to any of
+// the sections of the state. Components which don't implement it may only
+// write to the fields directly on the state.
+type StateWriter interface {
+	Writes() Role
+}
+
+func writesOf(c Component) Role {
+	if w, ok := c.(StateWriter); ok {
+		return w.Writes()
+	}
+
+	return 0
+}
+
+// checkWrites panics if the component changed any section of the state which
+// it didn't declare that it writes, compared to the given copy from before its
+// tick.
+func (h *Hexapod) checkWrites(c Component, before *State) {
+	r := writesOf(c)
+
+	if before.Identity != h.State.Identity {
+		panic(fmt.Sprintf("%T wrote to State.Identity, which is read-only", c))
+	}
+
+	for _, s := range []struct {
+		name   string
+		role   Role
+		before interface{}
+		after  interface{}
+	}{
+		{"Commands", Commander, before.Commands, h.State.Commands},
+		{"Measurements", Sensor, before.Measurements, h.State.Measurements},
+		{"Estimates", Estimator, before.Estimates, h.State.Estimates},
+	} {
+		if r&s.role == 0 && !reflect.DeepEqual(s.before, s.after) {
+			panic(fmt.Sprintf("%T wrote to State.%s, but doesn't declare it (see StateWriter)", c, s.name))
+		}
+	}
+}
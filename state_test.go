@@ -0,0 +1,96 @@
+package hexapod
+
+import (
+	"testing"
+	"time"
+
+	"github.com/adammck/hexapod/math3d"
+	"github.com/stretchr/testify/assert"
+)
+
+// writingComponent changes the state on every tick, and declares that it
+// writes the given sections.
+type writingComponent struct {
+	role  Role
+	write func(s *State)
+}
+
+func (c *writingComponent) Boot() error {
+	return nil
+}
+
+func (c *writingComponent) Tick(now time.Time, state *State) error {
+	c.write(state)
+	return nil
+}
+
+func (c *writingComponent) Writes() Role {
+	return c.role
+}
+
+func TestStrictWrites(t *testing.T) {
+	target := func(s *State) { s.Target.Position.Y += 1 }
+	voltage := func(s *State) { s.Voltage = 12 }
+	pose := func(s *State) { s.Pose.Heading += 1 }
+	shutdown := func(s *State) { s.Shutdown = true }
+
+	examples := []struct {
+		role  Role
+		write func(s *State)
+		ok    bool
+	}{
+		{Commander, target, true},
+		{Sensor, voltage, true},
+		{Estimator, pose, true},
+		{Commander | Estimator, pose, true},
+		{0, shutdown, true},
+
+		{0, target, false},
+		{Sensor, target, false},
+		{Commander, voltage, false},
+		{Commander | Sensor, pose, false},
+	}
+
+	for i, x := range examples {
+		h := newTestHexapod()
+		h.Strict = true
+		h.Add(&writingComponent{x.role, x.write})
+
+		f := func() { h.Tick(time.Now()) }
+		if x.ok {
+			assert.NotPanics(t, f, "example %d", i)
+		} else {
+			assert.Panics(t, f, "example %d", i)
+		}
+	}
+}
+
+func TestStrictWritesLookAt(t *testing.T) {
+	h := newTestHexapod()
+	h.Strict = true
+
+	// Changing the vector in place is still a write.
+	v := math3d.Vector3{}
+	h.State.LookAt = &v
+	h.Add(&writingComponent{Sensor, func(s *State) { s.LookAt.X += 1 }})
+	assert.Panics(t, func() { h.Tick(time.Now()) })
+}
+
+func TestNotStrict(t *testing.T) {
+	h := newTestHexapod()
+	h.Add(&writingComponent{0, func(s *State) { s.Target.Position.Y += 1 }})
+	assert.NoError(t, tickN(t, h, 2))
+	assert.Equal(t, 2.0, h.State.Target.Position.Y)
+}
+
+func TestStateCopy(t *testing.T) {
+	v := math3d.Vector3{X: 1}
+	s := &State{Commands: Commands{LookAt: &v, Speed: 2}}
+	c := s.Copy()
+
+	v.X = 2
+	s.Speed = 3
+	assert.Equal(t, 1.0, c.LookAt.X)
+	assert.Equal(t, 2, c.Speed)
+	assert.Nil(t, (&State{}).Copy().LookAt)
+}
@@ -0,0 +1,251 @@
+package hexapod
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+)
+
+const (
+
+	// The smallest change to a float field which StateDiff logs, unless it's
+	// given another for that field. This is well below anything which matters
+	// (a thousandth of a mm or degree), but above the noise from tweening.
+	DefaultDiffEpsilon = 0.001
+
+	// The default for StateDiff.MaxPerSecond.
+	DefaultDiffsPerSecond = 20
+)
+
+var diffLog = NewLog("diff")
+
+// StateDiff logs which fields of the state each component changes during its
+// tick, to find out who set some bad value. It's slow-ish, so is only enabled
+// when debugging; see Hexapod.StateDiff.
+//
+// The fields to compare are found (via reflection) once, when the StateDiff is
+// created, rather than by walking the state every tick.
+type StateDiff struct {
+
+	// The maximum number of lines to log per second (of tick time). Any more
+	// are dropped, and counted, so it's usable while walking around.
+	MaxPerSecond int
+
+	plan []diffField
+
+	// The start of the current second, and the number of lines logged and
+	// dropped during it.
+	window  time.Time
+	lines   int
+	dropped int
+
+	// Where to log lines. This is diffLog.Infof, except in tests.
+	logf func(format string, args ...interface{})
+}
+
+// diffField is a single (non-struct) field of the state, and how to reach it.
+type diffField struct {
+	path  string
+	steps []diffStep
+	eps   float64
+}
+
+// diffStep is a single step from a value to one of its parts: a field of a
+// struct, an element of an array, or whatever a pointer points to.
+type diffStep struct {
+	kind reflect.Kind
+	i    int
+}
+
+// NewStateDiff returns a StateDiff which ignores changes to float fields which
+// are smaller than the epsilon for their path (e.g. "Target.Position.Y"), or
+// the longest prefix of it (e.g. "Target"), or DefaultDiffEpsilon. Paths are
+// as the fields are used, without the section which they're embedded in.
+func NewStateDiff(epsilons map[string]float64) *StateDiff {
+	d := &StateDiff{
+		MaxPerSecond: DefaultDiffsPerSecond,
+		logf:         diffLog.Infof,
+	}
+
+	d.plan = planDiff(reflect.TypeOf(State{}), "", nil, nil)
+	for i := range d.plan {
+		d.plan[i].eps = epsilonFor(d.plan[i].path, epsilons)
+	}
+
+	return d
+}
+
+// planDiff returns the fields of the given type, which is reached from the
+// state via the given path and steps.
+func planDiff(t reflect.Type, path string, steps []diffStep, seen []reflect.Type) []diffField {
+	join := func(name string) string {
+		if path == "" {
+			return name
+		}
+		return path + "." + name
+	}
+
+	with := func(kind reflect.Kind, i int) []diffStep {
+		return append(append([]diffStep{}, steps...), diffStep{kind, i})
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		var out []diffField
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.PkgPath != "" {
+				continue
+			}
+
+			p := join(f.Name)
+			if f.Anonymous {
+				p = path
+			}
+
+			out = append(out, planDiff(f.Type, p, with(reflect.Struct, i), seen)...)
+		}
+		return out
+
+	case reflect.Array:
+		var out []diffField
+		for i := 0; i < t.Len(); i++ {
+			out = append(out, planDiff(t.Elem(), fmt.Sprintf("%s[%d]", path, i), with(reflect.Array, i), seen)...)
+		}
+		return out
+
+	case reflect.Ptr:
+		for _, s := range seen {
+			if s == t {
+				return nil
+			}
+		}
+		return planDiff(t.Elem(), path, with(reflect.Ptr, 0), append(seen, t))
+
+	case reflect.Bool, reflect.String, reflect.Float32, reflect.Float64,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return []diffField{{path: path, steps: steps}}
+	}
+
+	// Anything else (slices, maps, funcs) isn't in the state, and would need
+	// deeper comparison than this does.
+	return nil
+}
+
+func epsilonFor(path string, epsilons map[string]float64) float64 {
+	best, eps := -1, DefaultDiffEpsilon
+	for p, e := range epsilons {
+		if (path == p || strings.HasPrefix(path, p+".") || strings.HasPrefix(path, p+"[")) && len(p) > best {
+			best, eps = len(p), e
+		}
+	}
+
+	return eps
+}
+
+// get returns the field of the given state, or false if it's behind a nil
+// pointer.
+func (f *diffField) get(v reflect.Value) (reflect.Value, bool) {
+	for _, s := range f.steps {
+		switch s.kind {
+		case reflect.Struct:
+			v = v.Field(s.i)
+		case reflect.Array:
+			v = v.Index(s.i)
+		case reflect.Ptr:
+			if v.IsNil() {
+				return v, false
+			}
+			v = v.Elem()
+		}
+	}
+
+	return v, true
+}
+
+// changes returns the fields which differ (by more than their epsilon) between
+// the two states, formatted as "path old→new".
+func (d *StateDiff) changes(before, after *State) []string {
+	a, b := reflect.ValueOf(before).Elem(), reflect.ValueOf(after).Elem()
+
+	var out []string
+	for i := range d.plan {
+		f := &d.plan[i]
+		va, oka := f.get(a)
+		vb, okb := f.get(b)
+
+		if !oka || !okb {
+			if oka != okb {
+				out = append(out, fmt.Sprintf("%s %s→%s", f.path, show(va, oka), show(vb, okb)))
+			}
+			continue
+		}
+
+		if changed(va, vb, f.eps) {
+			out = append(out, fmt.Sprintf("%s %s→%s", f.path, show(va, true), show(vb, true)))
+		}
+	}
+
+	return out
+}
+
+func changed(a, b reflect.Value, eps float64) bool {
+	switch a.Kind() {
+	case reflect.Float32, reflect.Float64:
+		x, y := a.Float(), b.Float()
+
+		// NaN is always worth knowing about.
+		if x != x || y != y {
+			return (x != x) != (y != y)
+		}
+
+		d := x - y
+		return d >= eps || -d >= eps
+	}
+
+	return a.Interface() != b.Interface()
+}
+
+func show(v reflect.Value, ok bool) string {
+	if !ok {
+		return "nil"
+	}
+
+	switch v.Kind() {
+	case reflect.Float32, reflect.Float64:
+		return fmt.Sprintf("%.3f", v.Float())
+	case reflect.String:
+		return fmt.Sprintf("%q", v.String())
+	}
+
+	return fmt.Sprintf("%v", v.Interface())
+}
+
+// log logs what the given component changed between the two states, if
+// anything, unless too many lines have been logged in the last second.
+func (d *StateDiff) log(now time.Time, c Component, before, after *State) {
+	ch := d.changes(before, after)
+	if len(ch) == 0 {
+		return
+	}
+
+	if now.Sub(d.window) >= time.Second {
+		if d.dropped > 0 {
+			d.logf("dropped %d diffs", d.dropped)
+		}
+
+		d.window = now
+		d.lines = 0
+		d.dropped = 0
+	}
+
+	if d.MaxPerSecond > 0 && d.lines >= d.MaxPerSecond {
+		d.dropped += 1
+		return
+	}
+
+	d.lines += 1
+	d.logf("%T: %s", c, strings.Join(ch, ", "))
+}
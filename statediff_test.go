@@ -0,0 +1,117 @@
+package hexapod
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/adammck/hexapod/math3d"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiffPlan(t *testing.T) {
+	d := NewStateDiff(nil)
+
+	paths := map[string]bool{}
+	for _, f := range d.plan {
+		paths[f.path] = true
+	}
+
+	// Embedded sections are flattened, arrays are expanded, and pointers are
+	// followed.
+	for _, p := range []string{"FPS", "Shutdown", "Target.Position.Y", "Pose.Heading", "LookAt.X", "Feet[5].Z", "Saturated[0]", "Input.Buttons", "Gait.Name", "Voltage"} {
+		assert.True(t, paths[p], p)
+	}
+
+	// The registry has nothing to compare.
+	for p := range paths {
+		assert.NotContains(t, p, "Gaits")
+	}
+}
+
+func TestDiffChanges(t *testing.T) {
+	d := NewStateDiff(nil)
+
+	before := State{}
+	before.Target.Position.Y = 40
+	before.Speed = 1
+
+	after := before.Copy()
+	after.Target.Position.Y = 42.5
+	after.Speed = 2
+	after.Feet[2].Z = -1
+	after.Saturated[3] = true
+	after.Profile = "indoor"
+	after.LookAt = &math3d.Vector3{X: 1}
+
+	assert.Equal(t, []string{
+		`Profile ""→"indoor"`,
+		"Target.Position.Y 40.000→42.500",
+		"LookAt.X nil→1.000",
+		"LookAt.Y nil→0.000",
+		"LookAt.Z nil→0.000",
+		"Speed 1→2",
+		"Saturated[3] false→true",
+		"Feet[2].Z 0.000→-1.000",
+	}, d.changes(&before, &after))
+
+	// Changing a pointed-to value in place is a change.
+	before = after.Copy()
+	after.LookAt.X = 2
+	assert.Equal(t, []string{"LookAt.X 1.000→2.000"}, d.changes(&before, &after))
+
+	assert.Empty(t, d.changes(&after, &after))
+}
+
+func TestDiffEpsilon(t *testing.T) {
+	d := NewStateDiff(map[string]float64{
+		"Pose":              0.5,
+		"Pose.Position.Y":   0.01,
+		"Target.Position.Y": 2,
+	})
+
+	before := State{}
+	after := State{}
+	after.Pose.Position.X = 0.4   // Pose
+	after.Pose.Heading = 0.6      // Pose
+	after.Pose.Position.Y = 0.02  // Pose.Position.Y
+	after.Target.Position.Y = 1.5 // Target.Position.Y
+	after.Target.Position.X = 0.0005
+	after.Offset.X = 0.002
+
+	assert.Equal(t, []string{
+		"Offset.X 0.000→0.002",
+		"Pose.Position.Y 0.000→0.020",
+		"Pose.Heading 0.000→0.600",
+	}, d.changes(&before, &after))
+}
+
+func TestDiffLoop(t *testing.T) {
+	var lines []string
+	d := NewStateDiff(nil)
+	d.MaxPerSecond = 3
+	d.logf = func(format string, args ...interface{}) {
+		lines = append(lines, fmt.Sprintf(format, args...))
+	}
+
+	h := newTestHexapod()
+	h.StateDiff = d
+	h.Add(&writingComponent{Commander, func(s *State) { s.Speed += 1 }})
+	h.Add(&fakeComponent{})
+
+	// Five ticks in the first second, only three of which are logged. The
+	// component which doesn't change anything isn't logged at all.
+	start := time.Unix(0, 0)
+	for i := 0; i < 5; i++ {
+		assert.NoError(t, h.Tick(start.Add(time.Duration(i)*100*time.Millisecond)))
+	}
+	assert.NoError(t, h.Tick(start.Add(time.Second)))
+
+	assert.Equal(t, []string{
+		"*hexapod.writingComponent: Speed 0→1",
+		"*hexapod.writingComponent: Speed 1→2",
+		"*hexapod.writingComponent: Speed 2→3",
+		"dropped 2 diffs",
+		"*hexapod.writingComponent: Speed 5→6",
+	}, lines)
+}
@@ -0,0 +1,83 @@
+// Package units labels and converts the units in which the hexapod presents
+// things to people: log lines and telemetry. Everything internal stays in
+// millimeters and degrees; conversion only happens at the edge, once.
+//
+// Values which are logged should be wrapped in MM or Deg (rather than formatted
+// as bare numbers), so that "clearance=40mm" can't be mistaken for anything
+// else.
+package units
+
+import (
+	"fmt"
+	"math"
+)
+
+// The units which a System can use for lengths and angles.
+const (
+	Millimeters = "mm"
+	Meters      = "m"
+	Degrees     = "deg"
+	Radians     = "rad"
+)
+
+// How many of each unit there are per internal unit (mm, or degrees).
+var (
+	perMillimeter = map[string]float64{Millimeters: 1, Meters: 0.001}
+	perDegree     = map[string]float64{Degrees: 1, Radians: math.Pi / 180}
+)
+
+// System is a choice of units for lengths and angles. It's recorded alongside
+// whatever was converted to it (e.g. in a hexapod.Snapshot), so that a reader
+// knows which units the numbers are in, and so converting something twice is
+// harmless: it's converted from the units which it's already in.
+type System struct {
+	Length string `json:"length"`
+	Angle  string `json:"angle"`
+}
+
+// Internal is the system which everything is in until it's presented.
+var Internal = System{Length: Millimeters, Angle: Degrees}
+
+// IsLength returns true if the given unit is one which lengths can be in.
+func IsLength(unit string) bool {
+	_, ok := perMillimeter[unit]
+	return ok
+}
+
+// IsAngle returns true if the given unit is one which angles can be in.
+func IsAngle(unit string) bool {
+	_, ok := perDegree[unit]
+	return ok
+}
+
+// ConvertLength converts a length in the units of this system to those of the other.
+func (s System) ConvertLength(v float64, to System) float64 {
+	return convert(v, perMillimeter, s.Length, to.Length)
+}
+
+// ConvertAngle converts an angle in the units of this system to those of the other.
+func (s System) ConvertAngle(v float64, to System) float64 {
+	return convert(v, perDegree, s.Angle, to.Angle)
+}
+
+func convert(v float64, per map[string]float64, from, to string) float64 {
+	if from == to {
+		return v
+	}
+
+	return v / per[from] * per[to]
+}
+
+// MM is a length in millimeters, which is formatted with its unit.
+type MM float64
+
+func (v MM) String() string {
+	return fmt.Sprintf("%.1fmm", float64(v))
+}
+
+// Deg is an angle in degrees, which is formatted with its unit.
+type Deg float64
+
+func (v Deg) String() string {
+	return fmt.Sprintf("%.1fdeg", float64(v))
+}
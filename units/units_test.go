@@ -0,0 +1,46 @@
+package units
+
+import (
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var metric = System{Length: Meters, Angle: Radians}
+
+func TestConvert(t *testing.T) {
+	assert.InDelta(t, 0.04, Internal.ConvertLength(40, metric), 1e-9)
+	assert.InDelta(t, math.Pi/2, Internal.ConvertAngle(90, metric), 1e-9)
+	assert.InDelta(t, 40, metric.ConvertLength(0.04, Internal), 1e-9)
+	assert.InDelta(t, 90, metric.ConvertAngle(math.Pi/2, Internal), 1e-9)
+
+	// Converting to the same units does nothing, so converting something
+	// which has already been converted (from the units which it's in) can't
+	// make it smaller again.
+	assert.Equal(t, 40.0, Internal.ConvertLength(40, Internal))
+	assert.Equal(t, 0.04, metric.ConvertLength(0.04, metric))
+	assert.Equal(t, 1.5, metric.ConvertAngle(1.5, metric))
+
+	// Lengths and angles are independent.
+	mixed := System{Length: Meters, Angle: Degrees}
+	assert.InDelta(t, 0.04, Internal.ConvertLength(40, mixed), 1e-9)
+	assert.Equal(t, 90.0, Internal.ConvertAngle(90, mixed))
+}
+
+func TestIsUnit(t *testing.T) {
+	assert.True(t, IsLength("mm"))
+	assert.True(t, IsLength("m"))
+	assert.False(t, IsLength("cm"))
+	assert.False(t, IsLength("deg"))
+	assert.True(t, IsAngle("deg"))
+	assert.True(t, IsAngle("rad"))
+	assert.False(t, IsAngle("mm"))
+	assert.False(t, IsAngle(""))
+}
+
+func TestFormat(t *testing.T) {
+	assert.Equal(t, "clearance=40.0mm", fmt.Sprintf("clearance=%s", MM(40)))
+	assert.Equal(t, "pan=-12.5deg", fmt.Sprintf("pan=%v", Deg(-12.5)))
+}
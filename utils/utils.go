@@ -6,10 +6,12 @@ import (
 	"time"
 )
 
+// Deg converts an angle from radians to degrees.
 func Deg(rads float64) float64 {
 	return rads / (math.Pi / 180)
 }
 
+// Rad converts an angle from degrees to radians.
 func Rad(degrees float64) float64 {
 	return (math.Pi / 180) * degrees
 }
@@ -0,0 +1,6 @@
+package hexapod
+
+// Version identifies the build. It's "dev" unless set at build time, e.g.:
+//
+//	go build -ldflags "-X github.com/adammck/hexapod.Version=1.2.3"
+var Version = "dev"